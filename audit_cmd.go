@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/integrity"
+	"github.com/johnwmail/nclip/internal/keyring"
+	"github.com/johnwmail/nclip/storage"
+)
+
+const auditUsage = `Usage: nclip audit [--repair] [--verify=false] [flags]
+
+Check that every paste's metadata and content agree, as after a partial
+restore from backup: content exists for the metadata and the reverse,
+sizes match the stored content, encrypted content decrypts (with the
+keys in NCLIP_ENCRYPTION_KEYS) and creation and expiry times are sane.
+The report is printed as JSON; the exit status is 1 when issues are left
+unresolved.
+
+With --repair, sizes are recomputed from the content, orphans older than
+NCLIP_ORPHAN_MIN_AGE are deleted, missing versions are dropped from the
+metadata and pastes whose content fails to decrypt are marked corrupt, so
+they are no longer served. The store is chosen as nclip chooses it; it
+is only read without --repair.
+
+`
+
+// runAuditCommand implements the "nclip audit" subcommand and returns the
+// process exit code.
+func runAuditCommand(args []string, stdout, stderr io.Writer, getenv func(string) string) int {
+	fs := flag.NewFlagSet("nclip audit", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprint(stderr, auditUsage)
+		fs.PrintDefaults()
+	}
+	repair := fs.Bool("repair", false, "Apply the repair actions instead of only reporting them")
+	verify := fs.Bool("verify", true, "Read encrypted content in full to check that it decrypts")
+	cfg, _, err := config.Load(fs, args, getenv)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+		return 1
+	}
+
+	backend, name, err := openBackendStore(cfg)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+		return 1
+	}
+	defer func() { _ = backend.Close() }()
+	if ro, ok := backend.(storage.ReadOnlySetter); ok && !*repair {
+		ro.SetReadOnly(true)
+	}
+	// Sizes and checksums are those of the decrypted content, which is
+	// encrypted for its slug rather than its tenant's key.
+	var store storage.PasteStore = storage.NewTenantStore(backend)
+	if cfg.EncryptionKeys != "" {
+		keys, err := keyring.Parse(cfg.EncryptionKeys)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+			return 1
+		}
+		store = storage.NewEncryptedStore(store, keys)
+	}
+
+	report, err := integrity.Run(store, integrity.Options{
+		Repair:      *repair,
+		Verify:      *verify,
+		MinAge:      cfg.OrphanMinAge,
+		MaxLifetime: max(config.MaxTTL, cfg.MinRetention),
+	}, time.Now())
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %s: %v\n", name, err)
+		return 1
+	}
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+		return 1
+	}
+	_, _ = fmt.Fprintf(stderr, "%s: %d pastes checked, %d issues, %d repaired, %d failed\n",
+		name, report.Pastes, report.Unresolved()+report.Repaired, report.Repaired, report.Failed)
+	if report.Unresolved() > 0 {
+		return 1
+	}
+	return 0
+}
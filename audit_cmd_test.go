@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/johnwmail/nclip/internal/integrity"
+)
+
+func TestAuditCommand(t *testing.T) {
+	dir := t.TempDir()
+	meta := `{"id":"SZPST","created_at":"2099-06-01T09:00:00Z","size":99,"content_type":"text/plain"}`
+	if err := os.WriteFile(filepath.Join(dir, "SZPST.json"), []byte(meta), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "SZPST"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	getenv := func(key string) string {
+		if key == "NCLIP_DATA_DIR" {
+			return dir
+		}
+		return ""
+	}
+	run := func(args ...string) (int, *integrity.Report, string) {
+		var stdout, stderr bytes.Buffer
+		code := runAuditCommand(args, &stdout, &stderr, getenv)
+		var report integrity.Report
+		_ = json.Unmarshal(stdout.Bytes(), &report)
+		return code, &report, stderr.String()
+	}
+
+	code, report, out := run()
+	if code != 1 || report.IssueCounts[integrity.KindSizeMismatch] != 1 || report.IssueCounts[integrity.KindBadExpiry] != 1 {
+		t.Fatalf("audit exited %d with %+v: %s", code, report, out)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "SZPST.json")); string(data) != meta {
+		t.Fatalf("audit without --repair rewrote the metadata: %s", data)
+	}
+
+	// The creation time in the future cannot be repaired.
+	code, report, out = run("--repair")
+	if code != 1 || report.Repaired != 1 || !strings.Contains(out, "filesystem: 1 pastes checked, 2 issues, 1 repaired, 0 failed") {
+		t.Fatalf("repair exited %d with %+v: %s", code, report, out)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "SZPST.json")); !strings.Contains(string(data), `"size": 5`) {
+		t.Errorf("repaired metadata = %s", data)
+	}
+	if code, _, out := run("--bogus"); code == 0 {
+		t.Errorf("unknown flag exited 0: %s", out)
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const checkUsage = `Usage: nclip check [--url URL] [--api-key KEY] [--json]
+
+Run an end-to-end test against a running nclip server and print a
+pass/fail matrix with the latency of each step:
+
+  create        upload a paste
+  meta          read its metadata and compare the size
+  raw           read its content back
+  burn-create   upload a burn-after-read paste
+  burn-consume  read it, then check that it is gone
+  delete        delete the paste and check that it is gone
+  oversize      check that an upload over the size limit is refused
+
+Steps that depend on a failed one are skipped. Test pastes expire after
+an hour should they be left behind. Deleting needs an admin key when the
+server requires API keys. The exit status is 1 when any step failed, so
+the command suits post-deploy checks and synthetic monitoring.
+
+`
+
+// runCheckCommand implements the "nclip check" subcommand and returns the
+// process exit code.
+func runCheckCommand(args []string, stdout, stderr io.Writer, getenv func(string) string) int {
+	fs := flag.NewFlagSet("nclip check", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprint(stderr, checkUsage)
+		fs.PrintDefaults()
+	}
+	server := fs.String("url", envOr(getenv, "NCLIP_URL", "http://localhost:8080"), "Server URL, including any route prefix (env NCLIP_URL)")
+	apiKey := fs.String("api-key", getenv("NCLIP_API_KEY"), "API key to upload and delete with (env NCLIP_API_KEY)")
+	fs.StringVar(apiKey, "key", *apiKey, "Alias of --api-key")
+	timeout := fs.Duration("timeout", 10*time.Second, "Timeout of each request")
+	maxSize := fs.Int64("max-size", 0, "Size limit to test, in bytes (default: the limit the server announces)")
+	asJSON := fs.Bool("json", false, "Print the results as JSON")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	k := &checker{
+		server:  strings.TrimRight(*server, "/"),
+		apiKey:  *apiKey,
+		client:  &http.Client{Timeout: *timeout},
+		maxSize: *maxSize,
+	}
+	results := k.run()
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(struct {
+			URL     string        `json:"url"`
+			Passed  bool          `json:"passed"`
+			Results []checkResult `json:"results"`
+		}{k.server, checkPassed(results), results})
+	} else {
+		tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintf(tw, "STEP\tRESULT\tLATENCY\tDETAIL\n")
+		for _, r := range results {
+			latency := "-"
+			if r.Status != checkSkip {
+				latency = strconv.FormatInt(r.LatencyMS, 10) + "ms"
+			}
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Step, r.Status, latency, r.Detail)
+		}
+		_ = tw.Flush()
+	}
+	if !checkPassed(results) {
+		return 1
+	}
+	return 0
+}
+
+// Outcomes of a check step.
+const (
+	checkPass = "pass"
+	checkFail = "fail"
+	checkSkip = "skip"
+)
+
+// checkResult is the outcome of one step.
+type checkResult struct {
+	Step      string `json:"step"`
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latency_ms"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// checkPassed reports whether no step failed.
+func checkPassed(results []checkResult) bool {
+	for _, r := range results {
+		if r.Status == checkFail {
+			return false
+		}
+	}
+	return true
+}
+
+// checker runs the steps against one server.
+type checker struct {
+	server  string
+	apiKey  string
+	client  *http.Client
+	maxSize int64
+	results []checkResult
+}
+
+// run runs every step and returns their results in order.
+func (k *checker) run() []checkResult {
+	content := []byte("nclip check " + checkNonce() + "\n")
+	var slug, burnSlug string
+	var burnContent []byte
+
+	created := k.step("create", func() (string, error) {
+		var err error
+		slug, err = k.create("/", content)
+		return slug, err
+	})
+	k.stepAfter(created, "meta", func() (string, error) {
+		var meta struct {
+			Size int64 `json:"size"`
+		}
+		if err := k.getJSON("/api/v1/meta/"+url.PathEscape(slug), &meta); err != nil {
+			return "", err
+		}
+		if meta.Size != int64(len(content)) {
+			return "", fmt.Errorf("size is %d, want %d", meta.Size, len(content))
+		}
+		return "", nil
+	})
+	k.stepAfter(created, "raw", func() (string, error) {
+		body, err := k.fetch("/raw/" + url.PathEscape(slug))
+		if err != nil {
+			return "", err
+		}
+		if !bytes.Equal(body, content) {
+			return "", errors.New("content differs from the upload")
+		}
+		return "", nil
+	})
+	burnCreated := k.step("burn-create", func() (string, error) {
+		burnContent = []byte("nclip check burn " + checkNonce() + "\n")
+		var err error
+		burnSlug, err = k.create("/burn/", burnContent)
+		return burnSlug, err
+	})
+	k.stepAfter(burnCreated, "burn-consume", func() (string, error) {
+		body, err := k.fetch("/raw/" + url.PathEscape(burnSlug))
+		if err != nil {
+			return "", err
+		}
+		if !bytes.Equal(body, burnContent) {
+			return "", errors.New("content differs from the upload")
+		}
+		return "", k.gone("/raw/" + url.PathEscape(burnSlug))
+	})
+	k.stepAfter(created, "delete", func() (string, error) {
+		resp, body, err := k.do(http.MethodDelete, "/"+url.PathEscape(slug), nil, nil)
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return "", checkStatusError(resp, body)
+		}
+		return "", k.gone("/raw/" + url.PathEscape(slug))
+	})
+	k.step("oversize", k.oversize)
+	return k.results
+}
+
+// step runs fn as the step name and records its outcome, with fn's
+// detail on success. It reports whether the step passed.
+func (k *checker) step(name string, fn func() (string, error)) bool {
+	start := time.Now()
+	detail, err := fn()
+	r := checkResult{Step: name, Status: checkPass, LatencyMS: time.Since(start).Milliseconds(), Detail: detail}
+	if err != nil {
+		r.Status = checkFail
+		r.Detail = err.Error()
+	}
+	k.results = append(k.results, r)
+	return err == nil
+}
+
+// stepAfter runs the step unless the step it depends on failed.
+func (k *checker) stepAfter(ok bool, name string, fn func() (string, error)) {
+	if !ok {
+		k.results = append(k.results, checkResult{Step: name, Status: checkSkip, Detail: "an earlier step failed"})
+		return
+	}
+	k.step(name, fn)
+}
+
+// create uploads content to path and returns the slug.
+func (k *checker) create(path string, content []byte) (string, error) {
+	resp, body, err := k.do(http.MethodPost, path, bytes.NewReader(content), http.Header{"X-Ttl": {"1h"}})
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", checkStatusError(resp, body)
+	}
+	var created struct {
+		Slug string `json:"slug"`
+	}
+	if err := json.Unmarshal(body, &created); err != nil || created.Slug == "" {
+		return "", errors.New("the upload response has no slug")
+	}
+	return created.Slug, nil
+}
+
+// fetch returns the body of a GET of path, which must succeed.
+func (k *checker) fetch(path string) ([]byte, error) {
+	resp, body, err := k.do(http.MethodGet, path, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, checkStatusError(resp, body)
+	}
+	return body, nil
+}
+
+// getJSON decodes the body of a GET of path into out.
+func (k *checker) getJSON(path string, out any) error {
+	body, err := k.fetch(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, out)
+}
+
+// gone fails unless a GET of path answers 404.
+func (k *checker) gone(path string) error {
+	resp, _, err := k.do(http.MethodGet, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("still served after it should be gone (%s)", resp.Status)
+	}
+	return nil
+}
+
+// oversize uploads one byte more than the size limit and expects a 413.
+// The body is only sent should the server ask for it, since the limit is
+// checked against Content-Length first.
+func (k *checker) oversize() (string, error) {
+	limit := k.maxSize
+	if limit <= 0 {
+		var discovery struct {
+			Limits struct {
+				MaxSize int64 `json:"max_size"`
+			} `json:"limits"`
+		}
+		if err := k.getJSON("/.well-known/nclip.json", &discovery); err != nil {
+			return "", fmt.Errorf("size limit unknown, set --max-size: %w", err)
+		}
+		if limit = discovery.Limits.MaxSize; limit <= 0 {
+			return "", errors.New("the server announces no size limit, set --max-size")
+		}
+	}
+	req, err := k.request(http.MethodPost, "/", io.LimitReader(checkFiller{}, limit+1), http.Header{"X-Ttl": {"1h"}, "Expect": {"100-continue"}})
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = limit + 1
+	resp, respBody, err := k.send(req)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		return "", fmt.Errorf("%d bytes were not refused: %w", limit+1, checkStatusError(resp, respBody))
+	}
+	return fmt.Sprintf("%d bytes refused", limit+1), nil
+}
+
+// do sends a request to path and returns the response with up to 1 MiB
+// of its body.
+func (k *checker) do(method, path string, body io.Reader, header http.Header) (*http.Response, []byte, error) {
+	req, err := k.request(method, path, body, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	return k.send(req)
+}
+
+// request returns a request to path with header, asking for JSON.
+func (k *checker) request(method, path string, body io.Reader, header http.Header) (*http.Request, error) {
+	req, err := http.NewRequest(method, k.server+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "nclip-check")
+	if k.apiKey != "" {
+		req.Header.Set("X-Api-Key", k.apiKey)
+	}
+	return req, nil
+}
+
+// send sends req and returns the response with up to 1 MiB of its body.
+func (k *checker) send(req *http.Request) (*http.Response, []byte, error) {
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, data, nil
+}
+
+// checkStatusError describes an unexpected response with the server's
+// message, if any.
+func checkStatusError(resp *http.Response, body []byte) error {
+	var apiErr struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+		return fmt.Errorf("%s: %s", resp.Status, apiErr.Error)
+	}
+	return errors.New(resp.Status)
+}
+
+// checkNonce returns a random string that makes test content unique.
+func checkNonce() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// checkFiller is an endless reader of text.
+type checkFiller struct{}
+
+func (checkFiller) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	return len(p), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+)
+
+func TestCheckCommand(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{
+		SlugLength:    5,
+		BufferSize:    1024,
+		DefaultTTL:    24 * time.Hour,
+		MaxRenderSize: 1024,
+	}
+	srv := httptest.NewServer(setupRouter(newTestStore(), cfg, nil))
+	defer srv.Close()
+	getenv := func(string) string { return "" }
+
+	var stdout, stderr bytes.Buffer
+	if code := runCheckCommand([]string{"--url", srv.URL}, &stdout, &stderr, getenv); code != 0 {
+		t.Fatalf("check exited %d: %s%s", code, stdout.String(), stderr.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 8 || !strings.HasPrefix(lines[0], "STEP") || !strings.Contains(lines[7], "1025 bytes refused") {
+		t.Fatalf("unexpected matrix:\n%s", stdout.String())
+	}
+
+	// A server that accepts oversized uploads fails the check, and the
+	// JSON output says which step failed.
+	stdout.Reset()
+	code := runCheckCommand([]string{"--url", srv.URL, "--max-size", "100", "--json"}, &stdout, &stderr, getenv)
+	var report struct {
+		Passed  bool          `json:"passed"`
+		Results []checkResult `json:"results"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	last := report.Results[len(report.Results)-1]
+	if code != 1 || report.Passed || last.Step != "oversize" || last.Status != checkFail {
+		t.Fatalf("check exited %d with %+v", code, report)
+	}
+
+	// Steps depending on the upload are skipped when it fails.
+	down := httptest.NewServer(http.NotFoundHandler())
+	defer down.Close()
+	stdout.Reset()
+	if code := runCheckCommand([]string{"--url", down.URL, "--json"}, &stdout, &stderr, getenv); code != 1 {
+		t.Fatalf("check of a broken server exited %d", code)
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Results[0].Status != checkFail || report.Results[1].Status != checkSkip {
+		t.Errorf("unexpected results %+v", report.Results)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/johnwmail/nclip/internal/signing"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// maxBody is the largest paste served at the edge. Generated Lambda@Edge
+// responses are limited to 1 MB and binary bodies are base64 encoded, so
+// anything larger goes to the origin.
+const maxBody = 700 * 1024
+
+// request is the part of an incoming request the edge path looks at.
+type request struct {
+	Method string
+	Path   string
+	// Query is the raw query string, without the "?".
+	Query     string
+	UserAgent string
+	Accept    string
+	Range     bool
+}
+
+// response is a complete response generated at the edge.
+type response struct {
+	Status  int
+	Headers map[string]string
+	Body    []byte
+}
+
+// edge serves the read-only fast path from the paste store.
+type edge struct {
+	store storage.PasteStore
+	// prefix is the origin's route prefix, such as "/paste"; requests
+	// outside it go to the origin.
+	prefix string
+	// signer signs /raw responses like the origin's NCLIP_SIGNING_KEY;
+	// nil sends them unsigned.
+	signer *signing.Signer
+}
+
+// serve answers r from the store, or returns nil when the request must go
+// to the origin: anything other than a plain GET/HEAD of an existing,
+// small, non-burn, non-private paste by a client that wants the raw
+// content, without a query string. Misses go to the origin too, so error
+// pages and expiry cleanup stay in one place.
+func (e *edge) serve(r request) *response {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return nil
+	}
+	if r.Query != "" {
+		// Queries change the response (?version=, ?head=, ?grep=,
+		// ?encoding=, share tokens...), and only the origin knows them all.
+		return nil
+	}
+	path, ok := strings.CutPrefix(r.Path, e.prefix)
+	if !ok {
+		return nil
+	}
+	slug, raw := strings.CutPrefix(path, "/raw/")
+	if !raw {
+		slug, raw = strings.CutPrefix(path, "/r/")
+	}
+	if !raw {
+		// The HTML view is rendered by the origin; only CLI clients get
+		// the raw content from GET /:slug.
+		slug = strings.TrimPrefix(path, "/")
+		if !isCLI(r.UserAgent, r.Accept) {
+			return nil
+		}
+	}
+	if !utils.IsValidSlug(slug) || r.Range {
+		return nil
+	}
+
+	paste, err := e.store.Get(slug)
+	if err != nil || paste == nil || paste.BurnAfterRead || paste.IsPrivate() || paste.Quarantined || paste.Corrupt || paste.Size > maxBody {
+		// Burning is a write; the edge never modifies the store. Private
+		// and quarantined pastes are checked against API keys and share
+		// links by the origin, which also answers for corrupt pastes.
+		return nil
+	}
+	// Encrypted content (NCLIP_ENCRYPTION_KEYS) never matches paste.Size,
+	// so it is handed to the origin, which holds the keys.
+	content, err := e.store.GetContent(slug)
+	if err != nil || int64(len(content)) != paste.Size {
+		return nil
+	}
+
+	headers := map[string]string{
+		"Content-Type":   paste.ContentType,
+		"Content-Length": strconv.FormatInt(paste.Size, 10),
+	}
+	if raw {
+		// The headers the origin's /raw sends, from the same helpers.
+		headers["Accept-Ranges"] = "bytes"
+		headers["Content-Disposition"] = utils.ContentDisposition(defaultFilename(paste), !utils.IsTextContent(paste.ContentType))
+		if mtime := paste.ModTime(); !mtime.IsZero() {
+			headers["Last-Modified"] = mtime.UTC().Format(http.TimeFormat)
+		}
+		if e.signer != nil {
+			for name, value := range e.signer.Headers(slug, content) {
+				headers[name] = value
+			}
+		}
+	}
+	if r.Method == http.MethodHead {
+		content = nil
+	}
+	return &response{Status: http.StatusOK, Headers: headers, Body: content}
+}
+
+// isCLI mirrors the retrieval handler's client detection.
+func isCLI(userAgent, accept string) bool {
+	if strings.Contains(accept, "text/html") {
+		return false
+	}
+	ua := strings.ToLower(userAgent)
+	for _, tool := range []string{"curl", "wget", "powershell"} {
+		if strings.Contains(ua, tool) {
+			return true
+		}
+	}
+	return false
+}
+
+func defaultFilename(paste *models.Paste) string {
+	if paste.Filename != "" {
+		return paste.Filename
+	}
+	return paste.ID + utils.ExtensionByMime(paste.ContentType)
+}
+
+// encodeBody returns body as a string for an event response and whether it
+// is base64 encoded. Text is sent as is so it stays readable in logs.
+func encodeBody(contentType string, body []byte) (string, bool) {
+	if utils.IsTextContent(contentType) && utf8.Valid(body) {
+		return string(body), false
+	}
+	return base64.StdEncoding.EncodeToString(body), true
+}
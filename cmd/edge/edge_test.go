@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/handlers/retrieval"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/internal/signing"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func newTestEdge(t *testing.T) *edge {
+	t.Helper()
+	backend, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystemStore: %v", err)
+	}
+	store := storage.NewTenantStore(backend)
+	expires := time.Now().Add(time.Hour)
+	put := func(id, contentType string, content []byte, burn bool) {
+		if err := store.StoreContent(id, content); err != nil {
+			t.Fatal(err)
+		}
+		p := &models.Paste{ID: id, CreatedAt: time.Now(), ExpiresAt: &expires, Size: int64(len(content)), ContentType: contentType, BurnAfterRead: burn}
+		if err := store.Store(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("TEXT2", "text/plain; charset=utf-8", []byte("hello edge\n"), false)
+	put("BURN2", "text/plain; charset=utf-8", []byte("secret"), true)
+	put("BNRY2", "application/octet-stream", []byte{0, 1, 2, 0xff}, false)
+	put("LRGE2", "text/plain; charset=utf-8", make([]byte, maxBody+1), false)
+	put("QRNT2", "text/plain; charset=utf-8", []byte("flagged"), false)
+	put("CRPT2", "text/plain; charset=utf-8", []byte("garbled"), false)
+	if err := store.AssignTenant("TNNT2", "eng"); err != nil {
+		t.Fatal(err)
+	}
+	put("TNNT2", "text/plain; charset=utf-8", []byte("hello tenant\n"), false)
+	for id, mark := range map[string]func(*models.Paste){
+		"QRNT2": func(p *models.Paste) { p.Quarantined = true },
+		"CRPT2": func(p *models.Paste) { p.Corrupt = true },
+	} {
+		p, err := store.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mark(p)
+		if err := store.Store(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The edge has a cache of its own.
+	return &edge{store: storage.NewTenantStore(backend)}
+}
+
+func TestServe(t *testing.T) {
+	e := newTestEdge(t)
+	cases := []struct {
+		name string
+		req  request
+		want int // 0: handed to the origin
+	}{
+		{"raw", request{Method: "GET", Path: "/raw/TEXT2"}, http.StatusOK},
+		{"head", request{Method: "HEAD", Path: "/raw/TEXT2"}, http.StatusOK},
+		{"tenant", request{Method: "GET", Path: "/raw/TNNT2"}, http.StatusOK},
+		{"cli view", request{Method: "GET", Path: "/TEXT2", UserAgent: "curl/8.0"}, http.StatusOK},
+		{"browser view", request{Method: "GET", Path: "/TEXT2", UserAgent: "Mozilla/5.0", Accept: "text/html"}, 0},
+		{"burn", request{Method: "GET", Path: "/raw/BURN2"}, 0},
+		{"quarantined", request{Method: "GET", Path: "/raw/QRNT2"}, 0},
+		{"quarantined cli view", request{Method: "GET", Path: "/QRNT2", UserAgent: "curl/8.0"}, 0},
+		{"corrupt", request{Method: "GET", Path: "/raw/CRPT2"}, 0},
+		{"corrupt cli view", request{Method: "GET", Path: "/CRPT2", UserAgent: "curl/8.0"}, 0},
+		{"too large", request{Method: "GET", Path: "/raw/LRGE2"}, 0},
+		{"range", request{Method: "GET", Path: "/raw/TEXT2", Range: true}, 0},
+		{"missing", request{Method: "GET", Path: "/raw/NXSTS"}, 0},
+		{"invalid slug", request{Method: "GET", Path: "/raw/../x"}, 0},
+		{"upload", request{Method: "POST", Path: "/"}, 0},
+		{"other route", request{Method: "GET", Path: "/api/v1/meta/TEXT2"}, 0},
+		{"raw alias", request{Method: "GET", Path: "/r/TEXT2"}, http.StatusOK},
+		{"query", request{Method: "GET", Path: "/raw/TEXT2", Query: "head=1"}, 0},
+		{"share token", request{Method: "GET", Path: "/TEXT2", Query: "share=abc", UserAgent: "curl/8.0"}, 0},
+	}
+	for _, tc := range cases {
+		resp := e.serve(tc.req)
+		switch {
+		case tc.want == 0 && resp != nil:
+			t.Errorf("%s: expected hand-off to origin, got %d", tc.name, resp.Status)
+		case tc.want != 0 && (resp == nil || resp.Status != tc.want):
+			t.Errorf("%s: expected %d, got %+v", tc.name, tc.want, resp)
+		}
+	}
+
+	resp := e.serve(request{Method: "GET", Path: "/raw/TEXT2"})
+	if string(resp.Body) != "hello edge\n" || resp.Headers["Content-Disposition"] == "" {
+		t.Errorf("unexpected raw response: %+v", resp)
+	}
+	if resp := e.serve(request{Method: "HEAD", Path: "/raw/TEXT2"}); len(resp.Body) != 0 || resp.Headers["Content-Length"] != "11" {
+		t.Errorf("unexpected HEAD response: %+v", resp)
+	}
+}
+
+// The edge answers with the headers the origin sends for the same
+// request.
+func TestServe_MatchesOrigin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	e := newTestEdge(t)
+	signer, err := signing.Parse(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{5}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.signer = signer
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	p, err := e.store.Get("TEXT2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.FileMtime = &mtime
+	if err := e.store.Store(p); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{MaxRenderSize: 1024}
+	rh := retrieval.NewHandler(services.NewPasteService(e.store, cfg), e.store, cfg)
+	rh.SetSigner(signer)
+	router := gin.New()
+	router.GET("/raw/:slug", rh.Raw)
+	router.GET("/:slug", rh.View)
+
+	for _, path := range []string{"/raw/TEXT2", "/raw/BNRY2", "/TEXT2"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("User-Agent", "curl/8.0")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		resp := e.serve(request{Method: http.MethodGet, Path: path, UserAgent: "curl/8.0"})
+		if w.Code != http.StatusOK || resp == nil {
+			t.Fatalf("%s: origin answered %d, edge %+v", path, w.Code, resp)
+		}
+		for _, name := range []string{"Content-Type", "Content-Length", "Content-Disposition", "Accept-Ranges", "Last-Modified", signing.Header, signing.HashHeader} {
+			if got, want := resp.Headers[name], w.Header().Get(name); got != want {
+				t.Errorf("%s: %s is %q at the edge, %q at the origin", path, name, got, want)
+			}
+		}
+		if !bytes.Equal(resp.Body, w.Body.Bytes()) {
+			t.Errorf("%s: edge body differs from the origin's", path)
+		}
+	}
+}
+
+func TestServe_RoutePrefix(t *testing.T) {
+	e := newTestEdge(t)
+	e.prefix = "/paste"
+	if resp := e.serve(request{Method: "GET", Path: "/paste/raw/TEXT2"}); resp == nil || resp.Status != http.StatusOK {
+		t.Errorf("raw under the prefix: expected 200, got %+v", resp)
+	}
+	if resp := e.serve(request{Method: "GET", Path: "/paste/TEXT2", UserAgent: "curl/8.0"}); resp == nil || resp.Status != http.StatusOK {
+		t.Errorf("cli view under the prefix: expected 200, got %+v", resp)
+	}
+	if resp := e.serve(request{Method: "GET", Path: "/raw/TEXT2"}); resp != nil {
+		t.Errorf("raw outside the prefix: expected hand-off to origin, got %d", resp.Status)
+	}
+}
+
+func TestHandle_CloudFront(t *testing.T) {
+	e := newTestEdge(t)
+	event := func(uri, query string) json.RawMessage {
+		return json.RawMessage(`{"Records":[{"cf":{"config":{"eventType":"origin-request"},"request":{"clientIp":"203.0.113.7","method":"GET","uri":"` + uri + `","querystring":"` + query + `","headers":{"user-agent":[{"key":"User-Agent","value":"curl/8.0"}]},"origin":{"s3":{"domainName":"example"}}}}}]}`)
+	}
+
+	out, err := e.handle(event("/raw/BNRY2", ""), "")
+	if err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	resp, ok := out.(*cloudFrontResponse)
+	if !ok || resp.Status != "200" || resp.BodyEncoding != "base64" {
+		t.Fatalf("expected base64 response, got %#v", out)
+	}
+	if body, _ := base64.StdEncoding.DecodeString(resp.Body); string(body) != "\x00\x01\x02\xff" {
+		t.Errorf("unexpected body %q", body)
+	}
+	if _, ok := resp.Headers["content-length"]; ok {
+		t.Error("Content-Length must not be set on Lambda@Edge responses")
+	}
+
+	// Hand-off returns the request unchanged, including fields not modeled.
+	for _, in := range []json.RawMessage{event("/raw/BURN2", ""), event("/raw/TEXT2", "version=1")} {
+		out, err = e.handle(in, "")
+		if err != nil {
+			t.Fatalf("handle: %v", err)
+		}
+		var want struct {
+			Records []struct {
+				CF struct {
+					Request json.RawMessage `json:"request"`
+				} `json:"cf"`
+			} `json:"Records"`
+		}
+		_ = json.Unmarshal(in, &want)
+		if got, ok := out.(json.RawMessage); !ok || string(got) != string(want.Records[0].CF.Request) {
+			t.Errorf("expected the original request back, got %s", out)
+		}
+	}
+}
+
+func TestHandle_FunctionURL(t *testing.T) {
+	e := newTestEdge(t)
+	event := func(path, query string) json.RawMessage {
+		var req events.LambdaFunctionURLRequest
+		req.Version = "2.0"
+		req.RawPath = path
+		req.RawQueryString = query
+		req.Headers = map[string]string{"user-agent": "curl/8.0"}
+		req.RequestContext.HTTP.Method = "GET"
+		data, _ := json.Marshal(req)
+		return data
+	}
+
+	out, _ := e.handle(event("/TEXT2", ""), "https://paste.example.com")
+	resp := out.(*events.LambdaFunctionURLResponse)
+	if resp.StatusCode != http.StatusOK || resp.Body != "hello edge\n" || resp.IsBase64Encoded {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	out, _ = e.handle(event("/raw/BURN2", "a=1"), "https://paste.example.com")
+	resp = out.(*events.LambdaFunctionURLResponse)
+	if resp.StatusCode != http.StatusTemporaryRedirect || resp.Headers["Location"] != "https://paste.example.com/raw/BURN2?a=1" {
+		t.Errorf("expected redirect to origin, got %+v", resp)
+	}
+
+	out, _ = e.handle(event("/TEXT2", "share=abc"), "https://paste.example.com")
+	resp = out.(*events.LambdaFunctionURLResponse)
+	if resp.StatusCode != http.StatusTemporaryRedirect || resp.Headers["Location"] != "https://paste.example.com/TEXT2?share=abc" {
+		t.Errorf("expected a request with a query redirected to origin, got %+v", resp)
+	}
+}
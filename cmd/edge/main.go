@@ -0,0 +1,195 @@
+// Command edge is a minimal, read-only nclip handler for running close to
+// clients in front of the main deployment. It serves GET /raw/:slug (or
+// its /r/:slug alias), and
+// GET /:slug for CLI clients, straight from the S3 bucket the main binary
+// writes to, without Gin or templates. Everything else (uploads, the HTML
+// view, burn-after-read, misses, large or ranged reads) is handed to the
+// origin.
+//
+// It accepts CloudFront origin-request events, where handing over means
+// returning the request unchanged, and Lambda Function URL events, where it
+// means a redirect to the origin URL.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/johnwmail/nclip/internal/signing"
+	"github.com/johnwmail/nclip/storage"
+)
+
+// Settings baked in with -ldflags "-X main.S3Bucket=...", because
+// Lambda@Edge functions cannot have environment variables. When set, the
+// NCLIP_S3_BUCKET, NCLIP_S3_PREFIX, NCLIP_S3_REGION, NCLIP_ORIGIN_URL,
+// NCLIP_ROUTE_PREFIX and NCLIP_SIGNING_KEY environment variables take
+// precedence.
+var (
+	Version     = "dev"
+	S3Bucket    = ""
+	S3Prefix    = ""
+	S3Region    = ""
+	OriginURL   = ""
+	RoutePrefix = ""
+	SigningKey  = ""
+)
+
+func main() {
+	bucket := envOr("NCLIP_S3_BUCKET", S3Bucket)
+	store, err := storage.NewS3StoreInRegion(bucket, envOr("NCLIP_S3_PREFIX", S3Prefix), envOr("NCLIP_S3_REGION", S3Region))
+	if err != nil {
+		log.Fatalf("Failed to initialize S3 storage: %v", err)
+	}
+	store.SetReadOnly(true)
+	log.Printf("nclip edge %s serving from bucket %s", Version, bucket)
+
+	e := &edge{store: storage.NewTenantStore(store)}
+	if key := envOr("NCLIP_SIGNING_KEY", SigningKey); key != "" {
+		if e.signer, err = signing.Parse(key); err != nil {
+			log.Fatalf("Invalid signing key: %v", err)
+		}
+	}
+	if prefix := strings.Trim(envOr("NCLIP_ROUTE_PREFIX", RoutePrefix), "/"); prefix != "" {
+		e.prefix = "/" + prefix
+	}
+	origin := strings.TrimSuffix(envOr("NCLIP_ORIGIN_URL", OriginURL), "/")
+	lambda.Start(func(ctx context.Context, event json.RawMessage) (interface{}, error) {
+		return e.handle(event, origin)
+	})
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// handle dispatches a raw Lambda event to the matching adapter.
+func (e *edge) handle(event json.RawMessage, origin string) (interface{}, error) {
+	var cf cloudFrontEvent
+	if err := json.Unmarshal(event, &cf); err == nil && len(cf.Records) > 0 {
+		return e.handleCloudFront(cf.Records[0].CF.Request)
+	}
+	var req events.LambdaFunctionURLRequest
+	if err := json.Unmarshal(event, &req); err != nil {
+		return nil, fmt.Errorf("unsupported event: %w", err)
+	}
+	return e.handleFunctionURL(req, origin), nil
+}
+
+// cloudFrontEvent is the subset of a CloudFront (Lambda@Edge) event used
+// here. The request is kept raw so it can be returned to CloudFront
+// unchanged when the origin should answer.
+type cloudFrontEvent struct {
+	Records []struct {
+		CF struct {
+			Request json.RawMessage `json:"request"`
+		} `json:"cf"`
+	} `json:"Records"`
+}
+
+type cloudFrontHeader struct {
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value"`
+}
+
+type cloudFrontRequest struct {
+	Method      string                        `json:"method"`
+	URI         string                        `json:"uri"`
+	QueryString string                        `json:"querystring"`
+	Headers     map[string][]cloudFrontHeader `json:"headers"`
+}
+
+type cloudFrontResponse struct {
+	Status       string                        `json:"status"`
+	Headers      map[string][]cloudFrontHeader `json:"headers"`
+	Body         string                        `json:"body,omitempty"`
+	BodyEncoding string                        `json:"bodyEncoding,omitempty"`
+}
+
+func (e *edge) handleCloudFront(raw json.RawMessage) (interface{}, error) {
+	var req cloudFrontRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, fmt.Errorf("invalid CloudFront request: %w", err)
+	}
+	header := func(name string) string {
+		if h := req.Headers[name]; len(h) > 0 {
+			return h[0].Value
+		}
+		return ""
+	}
+	resp := e.serve(request{
+		Method:    req.Method,
+		Path:      req.URI,
+		Query:     req.QueryString,
+		UserAgent: header("user-agent"),
+		Accept:    header("accept"),
+		Range:     header("range") != "",
+	})
+	if resp == nil {
+		return raw, nil
+	}
+
+	out := &cloudFrontResponse{
+		Status:  fmt.Sprint(resp.Status),
+		Headers: make(map[string][]cloudFrontHeader, len(resp.Headers)),
+	}
+	for k, v := range resp.Headers {
+		// Lambda@Edge sets Content-Length itself and rejects it here.
+		if k == "Content-Length" {
+			continue
+		}
+		out.Headers[strings.ToLower(k)] = []cloudFrontHeader{{Key: k, Value: v}}
+	}
+	if len(resp.Body) > 0 {
+		body, b64 := encodeBody(resp.Headers["Content-Type"], resp.Body)
+		out.Body = body
+		out.BodyEncoding = "text"
+		if b64 {
+			out.BodyEncoding = "base64"
+		}
+	}
+	return out, nil
+}
+
+func (e *edge) handleFunctionURL(req events.LambdaFunctionURLRequest, origin string) *events.LambdaFunctionURLResponse {
+	header := func(name string) string {
+		return req.Headers[strings.ToLower(name)]
+	}
+	resp := e.serve(request{
+		Method:    req.RequestContext.HTTP.Method,
+		Path:      req.RawPath,
+		Query:     req.RawQueryString,
+		UserAgent: header("User-Agent"),
+		Accept:    header("Accept"),
+		Range:     header("Range") != "",
+	})
+	if resp == nil {
+		if origin == "" {
+			return &events.LambdaFunctionURLResponse{StatusCode: http.StatusBadGateway, Body: "origin not configured\n"}
+		}
+		location := origin + req.RawPath
+		if req.RawQueryString != "" {
+			location += "?" + req.RawQueryString
+		}
+		return &events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusTemporaryRedirect,
+			Headers:    map[string]string{"Location": location},
+		}
+	}
+	body, b64 := encodeBody(resp.Headers["Content-Type"], resp.Body)
+	return &events.LambdaFunctionURLResponse{
+		StatusCode:      resp.Status,
+		Headers:         resp.Headers,
+		Body:            body,
+		IsBase64Encoded: b64,
+	}
+}
@@ -1,11 +1,62 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"net/mail"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/internal/cdnpurge"
+	"github.com/johnwmail/nclip/internal/certs"
+	"github.com/johnwmail/nclip/internal/egress"
+	"github.com/johnwmail/nclip/internal/emailin"
+	"github.com/johnwmail/nclip/internal/forwarded"
+	"github.com/johnwmail/nclip/internal/keyring"
+	"github.com/johnwmail/nclip/internal/push"
+	"github.com/johnwmail/nclip/internal/respheaders"
+	"github.com/johnwmail/nclip/internal/signing"
+	"github.com/johnwmail/nclip/internal/slashcmd"
+	"github.com/johnwmail/nclip/internal/slugmask"
+	"github.com/johnwmail/nclip/storage"
+)
+
+// MinTTL and MaxTTL bound the per-paste expiration accepted via X-TTL.
+const (
+	MinTTL = time.Hour
+	MaxTTL = 7 * 24 * time.Hour
+)
+
+// routePrefixPattern matches a valid RoutePrefix, such as "/paste" or
+// "/tools/paste".
+var routePrefixPattern = regexp.MustCompile(`^(/[A-Za-z0-9._~-]+)+$`)
+
+// cloudFrontIDPattern matches a CloudFront distribution ID.
+var cloudFrontIDPattern = regexp.MustCompile(`^[A-Z0-9]{1,32}$`)
+
+// Deployment roles. A replica shares the writer's storage but never
+// modifies it; a mirror keeps its own storage, copying the writer's
+// pastes through the sync feed.
+const (
+	RoleWriter  = "writer"
+	RoleReplica = "replica"
+	RoleMirror  = "mirror"
+)
+
+// Storage types. Auto picks MongoDB when MongoURI is set, S3 in Lambda
+// mode and the filesystem otherwise; memory keeps pastes in the process
+// until it exits.
+const (
+	StorageAuto   = "auto"
+	StorageMemory = "memory"
 )
 
 // Config holds all configuration for the nclip service
@@ -17,94 +68,677 @@ type Config struct {
 	DefaultTTL time.Duration `json:"default_ttl"`
 	S3Bucket   string        `json:"s3_bucket"`
 	S3Prefix   string        `json:"s3_prefix"`
+	// RoutePrefix mounts every route under a path such as "/paste", for
+	// deployments behind an existing site. It is empty to serve from the
+	// root, and never ends in a slash.
+	RoutePrefix string `json:"route_prefix"`
+	// TrustedProxies lists the IP addresses and CIDR ranges of reverse
+	// proxies whose X-Forwarded-For, X-Forwarded-Prefix and Forwarded
+	// headers are believed (see forwarded.ParseProxies). Empty keeps gin's
+	// default for client IPs and ignores forwarded prefixes.
+	TrustedProxies string `json:"trusted_proxies"`
+	// S3ReadCounting selects how reads update the S3 metadata object:
+	// "rewrite", "conditional" or "buffered" (see storage.ReadCountMode).
+	// S3ReadFlushInterval is how often buffered reads of a paste are
+	// written.
+	S3ReadCounting      string        `json:"s3_read_counting"`
+	S3ReadFlushInterval time.Duration `json:"s3_read_flush_interval"`
+	// S3SlugIndex keeps an index of taken slugs so most generated slug
+	// candidates are accepted without a HeadObject request (see
+	// storage.S3Store.EnableSlugIndex).
+	S3SlugIndex bool `json:"s3_slug_index"`
+	// S3KMSKeyID, S3StorageClass and S3ACL are applied to every object
+	// written to S3 (see storage.S3PutOptions); empty values keep the
+	// bucket's defaults.
+	S3KMSKeyID     string `json:"s3_kms_key_id"`
+	S3StorageClass string `json:"s3_storage_class"`
+	S3ACL          string `json:"s3_acl"`
+	// S3RetryMode (standard or adaptive) and S3MaxAttempts set how failed
+	// and throttled S3 requests are retried.
+	S3RetryMode   string `json:"s3_retry_mode"`
+	S3MaxAttempts int    `json:"s3_max_attempts"`
+	// S3HeadTimeout bounds S3 existence and size checks, S3Timeout the
+	// other small requests and S3ContentTimeout transfers of paste content,
+	// retries included.
+	S3HeadTimeout    time.Duration `json:"s3_head_timeout"`
+	S3Timeout        time.Duration `json:"s3_timeout"`
+	S3ContentTimeout time.Duration `json:"s3_content_timeout"`
+	// S3MaxIdleConns and S3IdleConnTimeout bound the idle connections kept
+	// to S3 between requests. S3PrewarmConns connections are opened at
+	// startup in Lambda mode, so the first requests skip the handshakes.
+	S3MaxIdleConns    int           `json:"s3_max_idle_conns"`
+	S3IdleConnTimeout time.Duration `json:"s3_idle_conn_timeout"`
+	S3PrewarmConns    int           `json:"s3_prewarm_conns"`
+	// PresignMaxSize enables direct uploads to S3 through presigned URLs
+	// for content up to this many bytes; 0 disables them.
+	PresignMaxSize int64 `json:"presign_max_size"`
+	// StorageType is StorageAuto (default) or StorageMemory.
+	StorageType string `json:"storage_type"`
+	// MongoURI selects the MongoDB backend in both server and Lambda mode
+	// when set, storing pastes in the MongoDatabase database.
+	MongoURI      string `json:"mongo_uri"`
+	MongoDatabase string `json:"mongo_database"`
+	// Instances is how many instances serve the same pastes behind a load
+	// balancer. Above 1, startup warns about features that keep their
+	// state per instance, and DiagnoseScaling makes the findings that
+	// break such a deployment fatal. RedisURL moves rate limit windows and
+	// used proof-of-work nonces to a Redis server shared by all instances.
+	Instances       int    `json:"instances"`
+	DiagnoseScaling bool   `json:"diagnose_scaling"`
+	RedisURL        string `json:"-"`
 	// DataDir is the filesystem directory used by the server mode to store
 	// paste content and metadata. It defaults to ./data and can be overridden
 	// via the NCLIP_DATA_DIR environment variable or CLI flag.
 	DataDir string `json:"data_dir"`
+	// Fsync makes the filesystem backend also sync the directory entries
+	// of content and metadata files to disk before an upload or update is
+	// acknowledged; the files themselves are always synced.
+	Fsync bool `json:"fsync"`
 	// UploadAuth enables API key authentication on upload endpoints
 	UploadAuth bool `json:"upload_auth"`
 	// APIKeys is a comma-separated list of valid API keys
-	APIKeys       string `json:"api_keys"`
+	APIKeys string `json:"api_keys"`
+	// APIKeysFile names a file of "KEY SCOPE[,SCOPE]" lines granting keys
+	// narrower scopes (read, write, burn, admin). Keys in APIKeys keep
+	// every scope.
+	APIKeysFile   string `json:"api_keys_file"`
 	Version       string `json:"version"`
 	BuildTime     string `json:"build_time"`
 	CommitHash    string `json:"commit_hash"`
 	MaxRenderSize int64  `json:"max_render_size"`
+	// TCPPort enables the plain-TCP "type and go" retrieval listener when
+	// non-zero. Clients send "<slug>\n" and receive the raw content.
+	TCPPort int `json:"tcp_port"`
+	// GopherPort enables a read-only gopher listener when non-zero.
+	GopherPort int `json:"gopher_port"`
+	// TCPRateLimit is the maximum number of requests per minute accepted
+	// from a single client IP on the TCP and gopher listeners (0 disables).
+	TCPRateLimit int `json:"tcp_rate_limit"`
+	// TCPRateLimitIPv6 is the per-minute limit for each IPv6 aggregate; 0
+	// uses TCPRateLimit.
+	TCPRateLimitIPv6 int `json:"tcp_rate_limit_ipv6"`
+	// TCPRateLimitIPv4Prefix and TCPRateLimitIPv6Prefix are the prefix
+	// lengths clients are aggregated by for rate limiting, so rotating
+	// addresses within one subscriber's subnet does not evade the limit.
+	TCPRateLimitIPv4Prefix int `json:"tcp_rate_limit_ipv4_prefix"`
+	TCPRateLimitIPv6Prefix int `json:"tcp_rate_limit_ipv6_prefix"`
+	// TCPMaxSize is the largest paste (bytes) served over TCP or gopher.
+	TCPMaxSize int64 `json:"tcp_max_size"`
+	// LambdaStreaming serves /raw downloads through Lambda response
+	// streaming when the request arrives via a Function URL configured
+	// with InvokeMode RESPONSE_STREAM. API Gateway requests stay buffered.
+	LambdaStreaming bool `json:"lambda_streaming"`
+	// SessionSecret signs web UI session cookies and CSRF tokens. When
+	// empty a random key is generated at startup, which does not survive
+	// restarts or work across multiple instances.
+	SessionSecret string `json:"-"`
+	// SessionSecretPrevious lists comma-separated secrets that signed
+	// before SessionSecret. Their sessions, tokens and links are still
+	// accepted, so the secret can be rotated without breaking those
+	// already handed out; new ones are signed with SessionSecret.
+	SessionSecretPrevious string `json:"-"`
+	// SessionTTL is the lifetime of a web UI session cookie.
+	SessionTTL time.Duration `json:"session_ttl"`
+	// SessionUploads lets requests with a valid session and CSRF token
+	// upload without an API key when UploadAuth is enabled, as long as they
+	// carry the proof of work PoWDifficulty asks for, which it requires.
+	// Any client gets a session by loading the web UI, so the proof of work
+	// is what each of these uploads costs.
+	SessionUploads bool `json:"session_uploads"`
+	// PoWDifficulty requires uploads without a valid API key to carry a
+	// proof of work with this many leading zero bits in X-PoW (0 disables).
+	// Each extra bit doubles the client's expected work.
+	PoWDifficulty int `json:"pow_difficulty"`
+	// Role is RoleWriter (default), RoleReplica or RoleMirror. Replicas
+	// serve reads from the shared backend and reject all mutating requests.
+	// Mirrors reject them too, but copy the writer's pastes into their own
+	// storage.
+	Role string `json:"role"`
+	// WriterURL is the writer's base URL that replicas redirect writes
+	// and burn-after-read reads to, and that mirrors copy pastes from.
+	WriterURL string `json:"writer_url"`
+	// SyncJournal enables the sync feed mirrors poll: the path of the
+	// journal of stored and deleted pastes (server mode only; empty
+	// disables).
+	SyncJournal string `json:"sync_journal"`
+	// MirrorAPIKey is the writer's API key a mirror reads the sync feed
+	// with; it needs the admin scope. MirrorInterval is how often the
+	// mirror polls.
+	MirrorAPIKey   string        `json:"-"`
+	MirrorInterval time.Duration `json:"mirror_interval"`
+	// AuditLog enables the JSON Lines audit log of mutating operations. It
+	// is a file path or an s3://bucket/prefix URL; empty disables auditing.
+	AuditLog string `json:"audit_log"`
+	// AuditMaxSize is the size (bytes) at which the audit file is rotated.
+	AuditMaxSize int64 `json:"audit_max_size"`
+	// AuditMaxBackups is the number of rotated audit files kept.
+	AuditMaxBackups int `json:"audit_max_backups"`
+	// SpoolDir enables the write-behind upload spool (container mode only):
+	// uploads the storage backend rejects are kept here and retried.
+	SpoolDir string `json:"spool_dir"`
+	// SpoolMaxSize bounds the spool (bytes); uploads fail once it is full.
+	SpoolMaxSize int64 `json:"spool_max_size"`
+	// BinaryConfirmSize is the size (bytes) above which uploads that are
+	// not text need X-Allow-Binary: true; 0 disables the check.
+	BinaryConfirmSize int64 `json:"binary_confirm_size"`
+	// MultipartSpoolDir holds large multipart file parts while they are
+	// read (container mode only); empty leaves them to os.TempDir.
+	MultipartSpoolDir string `json:"multipart_spool_dir"`
+	// MultipartSpoolMaxSize bounds the multipart spool (bytes); uploads
+	// get 503 while it is full.
+	MultipartSpoolMaxSize int64 `json:"multipart_spool_max_size"`
+	// ReservedSlugs is a comma-separated list of extra words that cannot be
+	// used as custom slugs (in addition to the built-in list and routes).
+	ReservedSlugs string `json:"reserved_slugs"`
+	// TLSCert and TLSKey are PEM files that make the server mode listen
+	// with TLS (and HTTP/2 via ALPN) instead of plaintext HTTP/1.1.
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+	// CABundle is a PEM file of CA certificates that outbound TLS
+	// connections trust in addition to the system roots, such as the CA
+	// of a TLS-intercepting egress proxy.
+	CABundle string `json:"ca_bundle"`
+	// H2C accepts cleartext HTTP/2 on the plaintext listener. Only enable
+	// it behind a trusted proxy that terminates TLS.
+	H2C bool `json:"h2c"`
+	// HTTP3 additionally serves HTTP/3 over QUIC on the same port (UDP)
+	// and advertises it with Alt-Svc. Requires TLSCert and TLSKey.
+	HTTP3 bool `json:"http3"`
+	// ACMEDomains obtains and renews the server certificate from an ACME CA
+	// instead of TLSCert and TLSKey: a comma-separated list of names, which
+	// may include wildcards (*.example.com). Challenges are answered with
+	// DNS-01 records through ACMEDNSProvider, and the certificate is kept
+	// in the storage backend so instances sharing it share the certificate.
+	ACMEDomains string `json:"acme_domains"`
+	// ACMEEmail is the contact address registered with the CA.
+	ACMEEmail string `json:"acme_email"`
+	// ACMEDirectory is the CA's ACME directory URL.
+	ACMEDirectory string `json:"acme_directory"`
+	// ACMEDNSProvider is "route53" (default AWS credentials) or
+	// "cloudflare" (CloudflareAPIToken).
+	ACMEDNSProvider string `json:"acme_dns_provider"`
+	// ACMEPropagation is how long to wait after publishing a challenge
+	// record before the CA checks it.
+	ACMEPropagation time.Duration `json:"acme_propagation"`
+	// CloudflareAPIToken is a Cloudflare API token with Zone.DNS edit
+	// permission, for ACMEDNSProvider "cloudflare".
+	CloudflareAPIToken string `json:"-"`
+	// MetricsPort serves Prometheus metrics at /metrics on a separate
+	// listener when non-zero (server mode only), keeping them off the
+	// public port.
+	MetricsPort int `json:"metrics_port"`
+	// ReadRetryAttempts is how many times a lookup of a paste created by
+	// this instance in the last few seconds is retried when the backend
+	// does not return it yet (0 disables). ReadRetryBackoff is the delay
+	// before the first retry; it doubles after each attempt.
+	ReadRetryAttempts int           `json:"read_retry_attempts"`
+	ReadRetryBackoff  time.Duration `json:"read_retry_backoff"`
+	// MinRetention is the shortest lifetime a paste can have: shorter
+	// TTLs, including the default, are raised to it (0 disables). Unlike
+	// X-TTL it may exceed MaxTTL, for regulated deployments.
+	MinRetention time.Duration `json:"min_retention"`
+	// SlackWorkspaces enables the Slack/Mattermost slash command endpoint
+	// for a comma-separated list of TEAM_ID:SECRET[:API_KEY] entries. The
+	// secret is the Slack signing secret or the Mattermost command token.
+	SlackWorkspaces string `json:"-"`
+	// EmailSNSTopic enables the email-in gateway for SES mail published to
+	// this SNS topic ARN. EmailSenders is the comma-separated allowlist of
+	// SENDER[=OWNER] entries, where SENDER is an address or @domain.
+	// EmailReplyFrom, when set, is the SES identity replies are sent from.
+	EmailSNSTopic  string `json:"email_sns_topic"`
+	EmailSenders   string `json:"email_senders"`
+	EmailReplyFrom string `json:"email_reply_from"`
+	// BurnNotifyFrom is the SES identity burn notification emails are sent
+	// from. Without it, X-Notify-On-Burn only accepts webhook URLs.
+	BurnNotifyFrom string `json:"burn_notify_from"`
+	// EncryptionKeys enables at-rest encryption of paste content: a
+	// comma-separated list of ID:BASE64KEY entries whose first entry is
+	// the current key. Older keys stay listed until re-encryption is done.
+	EncryptionKeys string `json:"-"`
+	// SigningKey, a base64 Ed25519 seed, makes /raw and /download
+	// responses carry an X-Nclip-Signature header over the content hash
+	// and slug. The public key is served at /api/v1/public-key.
+	SigningKey string `json:"-"`
+	// VAPIDPrivateKey, a base64url P-256 private key, enables Web Push:
+	// uploaders can subscribe on the manage page to be notified when their
+	// burn-after-read paste is read or their paste is about to expire.
+	// VAPIDSubject is the mailto: or https: contact push services see, and
+	// PushExpiryNotice how long before expiry the uploader is warned.
+	VAPIDPrivateKey  string        `json:"-"`
+	VAPIDSubject     string        `json:"vapid_subject"`
+	PushExpiryNotice time.Duration `json:"push_expiry_notice"`
+	// ReencryptRate is the default number of pastes per second the
+	// re-encryption job processes.
+	ReencryptRate int `json:"reencrypt_rate"`
+	// OrphanSweepInterval is how often content without metadata (and the
+	// reverse), left behind by crashes, is removed in the background (0
+	// disables; the admin API can still sweep). OrphanMinAge is how old
+	// such objects must be, so uploads in flight are never touched.
+	OrphanSweepInterval time.Duration `json:"orphan_sweep_interval"`
+	OrphanMinAge        time.Duration `json:"orphan_min_age"`
+	// CDNPurgeCloudFront, a CloudFront distribution ID, and CDNPurgeWebhook,
+	// an http(s) URL that may carry a token, have the CDN's cached copies
+	// of deleted and expired pastes invalidated. CDNPurgePaths lists the
+	// paths invalidated for a paste, with {slug} standing for its slug, and
+	// CDNPurgeInterval is how often a batch is sent to each target.
+	CDNPurgeCloudFront string        `json:"cdn_purge_cloudfront"`
+	CDNPurgeWebhook    string        `json:"-"`
+	CDNPurgePaths      string        `json:"cdn_purge_paths"`
+	CDNPurgeInterval   time.Duration `json:"cdn_purge_interval"`
+	// ShedErrorPercent and ShedLatency make uploads fail fast with 503
+	// while the storage backend is degraded: when more than this percentage
+	// of its operations failed, or they took longer than this on average,
+	// over the last ShedWindow (0 disables either check). Reads are still
+	// served.
+	ShedErrorPercent int           `json:"shed_error_percent"`
+	ShedLatency      time.Duration `json:"shed_latency"`
+	ShedWindow       time.Duration `json:"shed_window"`
+	// MaxConcurrentUploads caps the uploads in flight on this instance, and
+	// MaxConcurrentUploadsPerKey those of one API key, or of one client
+	// address for uploads without a key (0 disables either cap). Uploads
+	// beyond them get 429 at once instead of waiting.
+	MaxConcurrentUploads       int `json:"max_concurrent_uploads"`
+	MaxConcurrentUploadsPerKey int `json:"max_concurrent_uploads_per_key"`
+	// CacheControlStatic, CacheControlPages, CacheControlContent and
+	// CacheControlAPI are the Cache-Control of GET responses from static
+	// assets, HTML pages, paste content and the API whose handler sets
+	// none (empty sends none).
+	CacheControlStatic  string `json:"cache_control_static"`
+	CacheControlPages   string `json:"cache_control_pages"`
+	CacheControlContent string `json:"cache_control_content"`
+	CacheControlAPI     string `json:"cache_control_api"`
+	// MaxVersions is how many earlier contents of a paste are kept when
+	// PUT replaces it (0 keeps none).
+	MaxVersions int `json:"max_versions"`
+	// HotSlugs is how many slugs the hot slug tracker counts per 10s of
+	// reads, bounding its memory; the most-read ones are served by
+	// /api/v1/stats/hot and exported as metrics (0 disables).
+	HotSlugs int `json:"hot_slugs"`
+	// EmbedFrameAncestors is the CSP frame-ancestors source list of
+	// /embed pages, naming the sites that may frame them, e.g.
+	// "https://blog.example.com". "*" allows any site; empty disables
+	// embedding.
+	EmbedFrameAncestors string `json:"embed_frame_ancestors"`
+	// OverrideDir holds files that replace the built-in templates and
+	// static assets of the same name. Templates are re-parsed when they
+	// change there.
+	OverrideDir string `json:"override_dir"`
+	// SiteName, LogoURL, FooterHTML and ImprintURL brand every page: the
+	// name replaces "NCLIP" in headers and titles, the logo its icon, and
+	// the footer HTML and imprint link are added to the footer.
+	SiteName   string `json:"site_name"`
+	LogoURL    string `json:"logo_url"`
+	FooterHTML string `json:"footer_html"`
+	ImprintURL string `json:"imprint_url"`
+	// PublicPages serves the /about and /stats pages, which describe the
+	// instance's limits, retention and abuse policy to visitors.
+	PublicPages bool `json:"public_pages"`
+	// AbuseContact is the email address or URL that abuse reports go to,
+	// shown on the public pages and in /.well-known/nclip.json.
+	AbuseContact string `json:"abuse_contact"`
+	// CustomSlugMode is "plain", storing pastes under the custom slugs
+	// clients choose, or "obfuscate", storing them under an HMAC of the
+	// custom slug keyed with SlugSecret, so sequential custom slugs cannot
+	// be enumerated.
+	CustomSlugMode string `json:"custom_slug_mode"`
+	// SlugSecret keys the obfuscation of custom slugs. Changing it loses
+	// the way from custom slugs to the pastes stored under the old one.
+	SlugSecret string `json:"-"`
+	// ChecksumURLs records the SHA-256 of every paste's content and serves
+	// the content under /sha256/{hash} as well, so it can be fetched and
+	// verified by what it is rather than by its slug.
+	ChecksumURLs bool `json:"checksum_urls"`
+}
+
+// CacheControl returns the default Cache-Control of each route class.
+func (c *Config) CacheControl() map[respheaders.Class]string {
+	return map[respheaders.Class]string{
+		respheaders.ClassStatic:  c.CacheControlStatic,
+		respheaders.ClassPage:    c.CacheControlPages,
+		respheaders.ClassContent: c.CacheControlContent,
+		respheaders.ClassAPI:     c.CacheControlAPI,
+	}
+}
+
+// S3PutOptions returns the options applied to every object written to S3.
+func (c *Config) S3PutOptions() storage.S3PutOptions {
+	return storage.S3PutOptions{KMSKeyID: c.S3KMSKeyID, StorageClass: c.S3StorageClass, ACL: c.S3ACL}
+}
+
+// S3ClientOptions returns the retry and timeout settings of the S3 client.
+func (c *Config) S3ClientOptions() storage.S3ClientOptions {
+	return storage.S3ClientOptions{
+		RetryMode:       c.S3RetryMode,
+		MaxAttempts:     c.S3MaxAttempts,
+		HeadTimeout:     c.S3HeadTimeout,
+		RequestTimeout:  c.S3Timeout,
+		ContentTimeout:  c.S3ContentTimeout,
+		MaxIdleConns:    c.S3MaxIdleConns,
+		IdleConnTimeout: c.S3IdleConnTimeout,
+	}
+}
+
+// IsReplica reports whether this instance runs as a read-only replica.
+func (c *Config) IsReplica() bool {
+	return c.Role == RoleReplica
+}
+
+// IsMemory reports whether pastes are kept in process memory.
+func (c *Config) IsMemory() bool {
+	return c.StorageType == StorageMemory
+}
+
+// IsMirror reports whether this instance mirrors a writer through its
+// sync feed.
+func (c *Config) IsMirror() bool {
+	return c.Role == RoleMirror
+}
+
+// Path returns the route path p, which starts with a slash, under
+// RoutePrefix.
+func (c *Config) Path(p string) string {
+	return c.RoutePrefix + p
+}
+
+// TLSEnabled reports whether the server mode listens with TLS, from
+// certificate files or ACME.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCert != "" && c.TLSKey != "" || c.ACMEEnabled()
+}
+
+// ACMEEnabled reports whether the server certificate is obtained by ACME.
+func (c *Config) ACMEEnabled() bool {
+	return c.ACMEDomains != ""
+}
+
+// Source names reported by Sources and used in error messages.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// defaultConfigFile is loaded when it exists and neither --config nor
+// NCLIP_CONFIG names a file.
+const defaultConfigFile = "nclip.yaml"
+
+// option describes one setting. Every setting can be given as a CLI flag
+// (--name), an environment variable (env) and a config file key (name with
+// dashes replaced by underscores), so the three sources never diverge.
+type option struct {
+	name   string
+	env    string
+	usage  string
+	secret bool
+	ptr    interface{} // *int, *int64, *bool, *string or *time.Duration
+}
+
+// fileKey returns the config file key for o.
+func (o option) fileKey() string {
+	return strings.ReplaceAll(o.name, "-", "_")
+}
+
+// options lists every configurable setting of c, in documentation order.
+func (c *Config) options() []option {
+	return []option{
+		{name: "port", env: "NCLIP_PORT", usage: "Port to listen on", ptr: &c.Port},
+		{name: "url", env: "NCLIP_URL", usage: "Base URL for paste links", ptr: &c.URL},
+		{name: "route-prefix", env: "NCLIP_ROUTE_PREFIX", usage: "Path prefix to serve every route under, e.g. /paste", ptr: &c.RoutePrefix},
+		{name: "trusted-proxies", env: "NCLIP_TRUSTED_PROXIES", usage: "Comma-separated IPs and CIDR ranges of reverse proxies whose forwarded client IP and path prefix headers are trusted", ptr: &c.TrustedProxies},
+		{name: "slug-length", env: "NCLIP_SLUG_LENGTH", usage: "Length of generated slugs", ptr: &c.SlugLength},
+		{name: "buffer-size", env: "NCLIP_BUFFER_SIZE", usage: "Maximum upload size in bytes", ptr: &c.BufferSize},
+		{name: "max-render-size", env: "NCLIP_MAX_RENDER_SIZE", usage: "Maximum size (bytes) to render inline in the HTML view", ptr: &c.MaxRenderSize},
+		{name: "ttl", env: "NCLIP_TTL", usage: "Default paste expiration time", ptr: &c.DefaultTTL},
+		{name: "s3-bucket", env: "NCLIP_S3_BUCKET", usage: "S3 bucket for Lambda mode", ptr: &c.S3Bucket},
+		{name: "s3-prefix", env: "NCLIP_S3_PREFIX", usage: "S3 key prefix for Lambda mode", ptr: &c.S3Prefix},
+		{name: "s3-read-counting", env: "NCLIP_S3_READ_COUNTING", usage: "How reads update S3 metadata: rewrite, conditional or buffered", ptr: &c.S3ReadCounting},
+		{name: "s3-slug-index", env: "NCLIP_S3_SLUG_INDEX", usage: "Keep an index of taken slugs to skip most S3 existence checks", ptr: &c.S3SlugIndex},
+		{name: "s3-kms-key-id", env: "NCLIP_S3_KMS_KEY_ID", usage: "KMS key (ID, ARN or alias) to encrypt S3 objects with SSE-KMS; empty uses the bucket default", ptr: &c.S3KMSKeyID},
+		{name: "s3-storage-class", env: "NCLIP_S3_STORAGE_CLASS", usage: "Storage class of S3 objects, e.g. INTELLIGENT_TIERING; empty uses STANDARD", ptr: &c.S3StorageClass},
+		{name: "s3-acl", env: "NCLIP_S3_ACL", usage: "Canned ACL of S3 objects; leave empty for buckets that enforce bucket owner ownership", ptr: &c.S3ACL},
+		{name: "s3-retry-mode", env: "NCLIP_S3_RETRY_MODE", usage: "How failed S3 requests are retried: standard, or adaptive to also slow down while S3 throttles", ptr: &c.S3RetryMode},
+		{name: "s3-max-attempts", env: "NCLIP_S3_MAX_ATTEMPTS", usage: "Attempts of one S3 request, the first included", ptr: &c.S3MaxAttempts},
+		{name: "s3-head-timeout", env: "NCLIP_S3_HEAD_TIMEOUT", usage: "Timeout of S3 existence and size checks, retries included", ptr: &c.S3HeadTimeout},
+		{name: "s3-timeout", env: "NCLIP_S3_TIMEOUT", usage: "Timeout of S3 metadata and other small requests, retries included", ptr: &c.S3Timeout},
+		{name: "s3-content-timeout", env: "NCLIP_S3_CONTENT_TIMEOUT", usage: "Timeout of S3 paste content transfers, retries included", ptr: &c.S3ContentTimeout},
+		{name: "s3-max-idle-conns", env: "NCLIP_S3_MAX_IDLE_CONNS", usage: "Idle connections kept open to S3 for later requests", ptr: &c.S3MaxIdleConns},
+		{name: "s3-idle-conn-timeout", env: "NCLIP_S3_IDLE_CONN_TIMEOUT", usage: "How long an idle connection to S3 is kept open", ptr: &c.S3IdleConnTimeout},
+		{name: "s3-prewarm-conns", env: "NCLIP_S3_PREWARM_CONNS", usage: "Connections to S3 opened at startup in Lambda mode (0 disables)", ptr: &c.S3PrewarmConns},
+		{name: "presign-max-size", env: "NCLIP_PRESIGN_MAX_SIZE", usage: "Largest upload in bytes clients may store directly in S3 through a presigned URL (0 disables)", ptr: &c.PresignMaxSize},
+		{name: "storage-type", env: "NCLIP_STORAGE_TYPE", usage: "Storage backend: auto (MongoDB, S3 in Lambda, else the filesystem) or memory (lost on exit; for demos and CI)", ptr: &c.StorageType},
+		{name: "mongo-uri", env: "NCLIP_MONGO_URI", usage: "MongoDB connection URI; stores pastes in MongoDB instead of the filesystem or S3", ptr: &c.MongoURI},
+		{name: "mongo-database", env: "NCLIP_MONGO_DATABASE", usage: "MongoDB database name", ptr: &c.MongoDatabase},
+		{name: "instances", env: "NCLIP_INSTANCES", usage: "Number of instances serving the same pastes behind a load balancer", ptr: &c.Instances},
+		{name: "diagnose-scaling", env: "NCLIP_DIAGNOSE_SCALING", usage: "Refuse to start when enabled features keep state an instance of several cannot share", ptr: &c.DiagnoseScaling},
+		{name: "redis-url", env: "NCLIP_REDIS_URL", usage: "Redis URL for rate limits and proof-of-work nonces shared between instances (empty keeps them in memory)", secret: true, ptr: &c.RedisURL},
+		{name: "s3-read-flush-interval", env: "NCLIP_S3_READ_FLUSH_INTERVAL", usage: "How often buffered S3 read counts of a paste are written", ptr: &c.S3ReadFlushInterval},
+		{name: "data-dir", env: "NCLIP_DATA_DIR", usage: "Filesystem data directory for server mode", ptr: &c.DataDir},
+		{name: "fsync", env: "NCLIP_FSYNC", usage: "Sync filesystem writes to disk before acknowledging them", ptr: &c.Fsync},
+		{name: "upload-auth", env: "NCLIP_UPLOAD_AUTH", usage: "Require API key for upload endpoints", ptr: &c.UploadAuth},
+		{name: "api-keys", env: "NCLIP_API_KEYS", usage: "Comma-separated API keys for upload authentication", secret: true, ptr: &c.APIKeys},
+		{name: "api-keys-file", env: "NCLIP_API_KEYS_FILE", usage: "File of API keys with scopes, one \"KEY SCOPE[,SCOPE]\" per line", ptr: &c.APIKeysFile},
+		{name: "tcp-port", env: "NCLIP_TCP_PORT", usage: "Port for the plain-TCP retrieval listener (0 disables)", ptr: &c.TCPPort},
+		{name: "gopher-port", env: "NCLIP_GOPHER_PORT", usage: "Port for the gopher retrieval listener (0 disables)", ptr: &c.GopherPort},
+		{name: "tcp-rate-limit", env: "NCLIP_TCP_RATE_LIMIT", usage: "Maximum TCP/gopher requests per minute per client IP (0 disables)", ptr: &c.TCPRateLimit},
+		{name: "tcp-rate-limit-ipv6", env: "NCLIP_TCP_RATE_LIMIT_IPV6", usage: "Maximum TCP/gopher requests per minute per IPv6 prefix (0 uses tcp-rate-limit)", ptr: &c.TCPRateLimitIPv6},
+		{name: "tcp-rate-limit-ipv4-prefix", env: "NCLIP_TCP_RATE_LIMIT_IPV4_PREFIX", usage: "Prefix length IPv4 clients are grouped by for rate limiting", ptr: &c.TCPRateLimitIPv4Prefix},
+		{name: "tcp-rate-limit-ipv6-prefix", env: "NCLIP_TCP_RATE_LIMIT_IPV6_PREFIX", usage: "Prefix length IPv6 clients are grouped by for rate limiting", ptr: &c.TCPRateLimitIPv6Prefix},
+		{name: "tcp-max-size", env: "NCLIP_TCP_MAX_SIZE", usage: "Maximum paste size (bytes) served over TCP/gopher", ptr: &c.TCPMaxSize},
+		{name: "lambda-streaming", env: "NCLIP_LAMBDA_STREAMING", usage: "Stream /raw responses for Lambda Function URL requests", ptr: &c.LambdaStreaming},
+		{name: "session-secret", env: "NCLIP_SESSION_SECRET", usage: "Secret used to sign web UI session cookies", secret: true, ptr: &c.SessionSecret},
+		{name: "session-secret-previous", env: "NCLIP_SESSION_SECRET_PREVIOUS", usage: "Comma-separated former session secrets whose signed links and sessions are still accepted", secret: true, ptr: &c.SessionSecretPrevious},
+		{name: "session-ttl", env: "NCLIP_SESSION_TTL", usage: "Lifetime of web UI session cookies", ptr: &c.SessionTTL},
+		{name: "session-uploads", env: "NCLIP_SESSION_UPLOADS", usage: "Allow session-authenticated browser uploads when upload auth is enabled", ptr: &c.SessionUploads},
+		{name: "pow-difficulty", env: "NCLIP_POW_DIFFICULTY", usage: "Proof-of-work bits required of uploads without an API key (0 disables)", ptr: &c.PoWDifficulty},
+		{name: "role", env: "NCLIP_ROLE", usage: "Deployment role: writer, replica or mirror", ptr: &c.Role},
+		{name: "writer-url", env: "NCLIP_WRITER_URL", usage: "Writer base URL that replicas redirect writes to and mirrors copy from", ptr: &c.WriterURL},
+		{name: "sync-journal", env: "NCLIP_SYNC_JOURNAL", usage: "Change journal file enabling the sync feed for mirrors (empty disables)", ptr: &c.SyncJournal},
+		{name: "mirror-api-key", env: "NCLIP_MIRROR_API_KEY", usage: "Writer API key (admin scope) a mirror reads the sync feed with", secret: true, ptr: &c.MirrorAPIKey},
+		{name: "mirror-interval", env: "NCLIP_MIRROR_INTERVAL", usage: "How often a mirror polls the writer's sync feed", ptr: &c.MirrorInterval},
+		{name: "audit-log", env: "NCLIP_AUDIT_LOG", usage: "Audit log destination: file path or s3://bucket/prefix (empty disables)", ptr: &c.AuditLog},
+		{name: "audit-max-size", env: "NCLIP_AUDIT_MAX_SIZE", usage: "Audit log file size (bytes) that triggers rotation", ptr: &c.AuditMaxSize},
+		{name: "audit-max-backups", env: "NCLIP_AUDIT_MAX_BACKUPS", usage: "Number of rotated audit log files to keep", ptr: &c.AuditMaxBackups},
+		{name: "spool-dir", env: "NCLIP_SPOOL_DIR", usage: "Directory for spooling uploads during storage outages (empty disables)", ptr: &c.SpoolDir},
+		{name: "spool-max-size", env: "NCLIP_SPOOL_MAX_SIZE", usage: "Maximum spool size in bytes", ptr: &c.SpoolMaxSize},
+		{name: "binary-confirm-size", env: "NCLIP_BINARY_CONFIRM_SIZE", usage: "Size in bytes above which binary uploads need X-Allow-Binary (0 disables)", ptr: &c.BinaryConfirmSize},
+		{name: "multipart-spool-dir", env: "NCLIP_MULTIPART_SPOOL_DIR", usage: "Directory for large multipart upload parts (empty uses the system temp directory)", ptr: &c.MultipartSpoolDir},
+		{name: "multipart-spool-max-size", env: "NCLIP_MULTIPART_SPOOL_MAX_SIZE", usage: "Maximum multipart spool size in bytes", ptr: &c.MultipartSpoolMaxSize},
+		{name: "reserved-slugs", env: "NCLIP_RESERVED_SLUGS", usage: "Comma-separated extra words that cannot be used as custom slugs", ptr: &c.ReservedSlugs},
+		{name: "tls-cert", env: "NCLIP_TLS_CERT", usage: "TLS certificate file (PEM); enables HTTPS with HTTP/2", ptr: &c.TLSCert},
+		{name: "tls-key", env: "NCLIP_TLS_KEY", usage: "TLS private key file (PEM)", ptr: &c.TLSKey},
+		{name: "ca-bundle", env: "NCLIP_CA_BUNDLE", usage: "PEM file of CA certificates trusted for outbound TLS (S3, MongoDB, Redis, webhooks, ...) in addition to the system roots", ptr: &c.CABundle},
+		{name: "h2c", env: "NCLIP_H2C", usage: "Accept cleartext HTTP/2 (h2c) on the plaintext listener", ptr: &c.H2C},
+		{name: "http3", env: "NCLIP_HTTP3", usage: "Also serve HTTP/3 over QUIC on the same UDP port (requires TLS)", ptr: &c.HTTP3},
+		{name: "acme-domains", env: "NCLIP_ACME_DOMAINS", usage: "Comma-separated names (wildcards allowed) to obtain an ACME certificate for (empty disables)", ptr: &c.ACMEDomains},
+		{name: "acme-email", env: "NCLIP_ACME_EMAIL", usage: "Contact email registered with the ACME CA", ptr: &c.ACMEEmail},
+		{name: "acme-directory", env: "NCLIP_ACME_DIRECTORY", usage: "ACME directory URL of the CA", ptr: &c.ACMEDirectory},
+		{name: "acme-dns-provider", env: "NCLIP_ACME_DNS_PROVIDER", usage: "DNS provider answering DNS-01 challenges: route53 or cloudflare", ptr: &c.ACMEDNSProvider},
+		{name: "acme-propagation", env: "NCLIP_ACME_PROPAGATION", usage: "Wait after publishing a DNS-01 record before the CA checks it", ptr: &c.ACMEPropagation},
+		{name: "cloudflare-api-token", env: "NCLIP_CLOUDFLARE_API_TOKEN", usage: "Cloudflare API token with Zone.DNS edit permission", secret: true, ptr: &c.CloudflareAPIToken},
+		{name: "metrics-port", env: "NCLIP_METRICS_PORT", usage: "Port for the Prometheus /metrics listener (0 disables)", ptr: &c.MetricsPort},
+		{name: "read-retry-attempts", env: "NCLIP_READ_RETRY_ATTEMPTS", usage: "Retries when a just-created paste is not found yet (0 disables)", ptr: &c.ReadRetryAttempts},
+		{name: "read-retry-backoff", env: "NCLIP_READ_RETRY_BACKOFF", usage: "Delay before the first read retry; doubles per attempt", ptr: &c.ReadRetryBackoff},
+		{name: "slack-workspaces", env: "NCLIP_SLACK_WORKSPACES", usage: "Slash command workspaces as TEAM_ID:SECRET[:API_KEY], comma-separated", secret: true, ptr: &c.SlackWorkspaces},
+		{name: "email-sns-topic", env: "NCLIP_EMAIL_SNS_TOPIC", usage: "SNS topic ARN of inbound SES mail; enables the email-in gateway", ptr: &c.EmailSNSTopic},
+		{name: "email-senders", env: "NCLIP_EMAIL_SENDERS", usage: "Allowed email senders as SENDER[=OWNER], comma-separated", ptr: &c.EmailSenders},
+		{name: "email-reply-from", env: "NCLIP_EMAIL_REPLY_FROM", usage: "SES identity to reply to senders from (empty disables replies)", ptr: &c.EmailReplyFrom},
+		{name: "burn-notify-from", env: "NCLIP_BURN_NOTIFY_FROM", usage: "SES identity burn notification emails are sent from (empty allows webhooks only)", ptr: &c.BurnNotifyFrom},
+		{name: "encryption-keys", env: "NCLIP_ENCRYPTION_KEYS", usage: "Content encryption keys as ID:BASE64KEY, comma-separated, current key first (empty disables)", secret: true, ptr: &c.EncryptionKeys},
+		{name: "signing-key", env: "NCLIP_SIGNING_KEY", usage: "Ed25519 seed (32 bytes, base64) used to sign raw downloads (empty disables)", secret: true, ptr: &c.SigningKey},
+		{name: "vapid-private-key", env: "NCLIP_VAPID_PRIVATE_KEY", usage: "VAPID P-256 private key (base64url) enabling Web Push notifications (empty disables)", secret: true, ptr: &c.VAPIDPrivateKey},
+		{name: "vapid-subject", env: "NCLIP_VAPID_SUBJECT", usage: "mailto: or https: contact URL sent to push services", ptr: &c.VAPIDSubject},
+		{name: "push-expiry-notice", env: "NCLIP_PUSH_EXPIRY_NOTICE", usage: "How long before a paste expires its subscribed uploader is notified", ptr: &c.PushExpiryNotice},
+		{name: "reencrypt-rate", env: "NCLIP_REENCRYPT_RATE", usage: "Pastes per second processed by the re-encryption job", ptr: &c.ReencryptRate},
+		{name: "orphan-sweep-interval", env: "NCLIP_ORPHAN_SWEEP_INTERVAL", usage: "How often orphaned content and metadata are removed (0 disables)", ptr: &c.OrphanSweepInterval},
+		{name: "orphan-min-age", env: "NCLIP_ORPHAN_MIN_AGE", usage: "Minimum age of orphaned content or metadata before it is removed", ptr: &c.OrphanMinAge},
+		{name: "cdn-purge-cloudfront", env: "NCLIP_CDN_PURGE_CLOUDFRONT", usage: "CloudFront distribution ID to invalidate deleted and expired pastes in (empty disables)", ptr: &c.CDNPurgeCloudFront},
+		{name: "cdn-purge-webhook", env: "NCLIP_CDN_PURGE_WEBHOOK", usage: "URL posted the paths of deleted and expired pastes to purge from a CDN (empty disables)", secret: true, ptr: &c.CDNPurgeWebhook},
+		{name: "cdn-purge-paths", env: "NCLIP_CDN_PURGE_PATHS", usage: "Comma-separated paths purged for a paste, {slug} standing for its slug", ptr: &c.CDNPurgePaths},
+		{name: "cdn-purge-interval", env: "NCLIP_CDN_PURGE_INTERVAL", usage: "How often a batch of paths is sent to each CDN purge target", ptr: &c.CDNPurgeInterval},
+		{name: "shed-error-percent", env: "NCLIP_SHED_ERROR_PERCENT", usage: "Reject uploads while more than this percentage of storage operations fail (0 disables)", ptr: &c.ShedErrorPercent},
+		{name: "shed-latency", env: "NCLIP_SHED_LATENCY", usage: "Reject uploads while storage operations take longer than this on average (0 disables)", ptr: &c.ShedLatency},
+		{name: "shed-window", env: "NCLIP_SHED_WINDOW", usage: "Window over which storage errors and latency are measured for load shedding", ptr: &c.ShedWindow},
+		{name: "max-concurrent-uploads", env: "NCLIP_MAX_CONCURRENT_UPLOADS", usage: "Uploads in flight at once on this instance; more are rejected with 429 (0 disables)", ptr: &c.MaxConcurrentUploads},
+		{name: "max-concurrent-uploads-per-key", env: "NCLIP_MAX_CONCURRENT_UPLOADS_PER_KEY", usage: "Uploads in flight at once per API key, or per client address without one (0 disables)", ptr: &c.MaxConcurrentUploadsPerKey},
+		{name: "cache-control-static", env: "NCLIP_CACHE_CONTROL_STATIC", usage: "Default Cache-Control of static assets (empty sends none)", ptr: &c.CacheControlStatic},
+		{name: "cache-control-pages", env: "NCLIP_CACHE_CONTROL_PAGES", usage: "Default Cache-Control of HTML pages (empty sends none)", ptr: &c.CacheControlPages},
+		{name: "cache-control-content", env: "NCLIP_CACHE_CONTROL_CONTENT", usage: "Default Cache-Control of raw paste content (empty sends none)", ptr: &c.CacheControlContent},
+		{name: "cache-control-api", env: "NCLIP_CACHE_CONTROL_API", usage: "Default Cache-Control of API responses (empty sends none)", ptr: &c.CacheControlAPI},
+		{name: "max-versions", env: "NCLIP_MAX_VERSIONS", usage: "Earlier contents kept per paste when its content is replaced (0 keeps none)", ptr: &c.MaxVersions},
+		{name: "min-retention", env: "NCLIP_MIN_RETENTION", usage: "Minimum paste lifetime; shorter TTLs are raised to it (0 disables)", ptr: &c.MinRetention},
+		{name: "hot-slugs", env: "NCLIP_HOT_SLUGS", usage: "Slugs counted per 10s by the most-read slug tracker (0 disables)", ptr: &c.HotSlugs},
+		{name: "embed-frame-ancestors", env: "NCLIP_EMBED_FRAME_ANCESTORS", usage: "Space-separated sites allowed to frame /embed pages, \"*\" for any (empty disables embedding)", ptr: &c.EmbedFrameAncestors},
+		{name: "override-dir", env: "NCLIP_OVERRIDE_DIR", usage: "Directory of templates and static assets replacing the built-in ones (empty disables)", ptr: &c.OverrideDir},
+		{name: "site-name", env: "NCLIP_SITE_NAME", usage: "Site name shown in page headers and titles", ptr: &c.SiteName},
+		{name: "logo-url", env: "NCLIP_LOGO_URL", usage: "Logo image shown in page headers (empty keeps the built-in icon)", ptr: &c.LogoURL},
+		{name: "footer-html", env: "NCLIP_FOOTER_HTML", usage: "HTML added to every page footer", ptr: &c.FooterHTML},
+		{name: "imprint-url", env: "NCLIP_IMPRINT_URL", usage: "Imprint or legal notice page linked from every footer", ptr: &c.ImprintURL},
+		{name: "public-pages", env: "NCLIP_PUBLIC_PAGES", usage: "Serve the /about and /stats pages with the instance's limits, retention and paste count", ptr: &c.PublicPages},
+		{name: "abuse-contact", env: "NCLIP_ABUSE_CONTACT", usage: "Email address or URL for abuse reports, shown on the public pages", ptr: &c.AbuseContact},
+		{name: "custom-slug-mode", env: "NCLIP_CUSTOM_SLUG_MODE", usage: "How custom slugs are stored: plain, or obfuscate to store them under an HMAC keyed with the slug secret", ptr: &c.CustomSlugMode},
+		{name: "slug-secret", env: "NCLIP_SLUG_SECRET", usage: "Secret (16+ characters) that obfuscates custom slugs", secret: true, ptr: &c.SlugSecret},
+		{name: "checksum-urls", env: "NCLIP_CHECKSUM_URLS", usage: "Record the SHA-256 of paste content and serve it under /sha256/{hash}", ptr: &c.ChecksumURLs},
+	}
+}
+
+// Default returns the configuration used when nothing is overridden.
+func Default() *Config {
+	return &Config{
+		Port:                   8080,
+		URL:                    "",
+		SlugLength:             5,
+		BufferSize:             5 * 1024 * 1024, // 5MB
+		DefaultTTL:             24 * time.Hour,
+		S3Bucket:               "",
+		S3Prefix:               "",
+		RoutePrefix:            "",
+		S3ReadCounting:         string(storage.ReadCountConditional),
+		S3ReadFlushInterval:    30 * time.Second,
+		S3RetryMode:            storage.S3RetryStandard,
+		S3MaxAttempts:          storage.DefaultS3MaxAttempts,
+		S3HeadTimeout:          storage.DefaultS3HeadTimeout,
+		S3Timeout:              storage.DefaultS3RequestTimeout,
+		S3ContentTimeout:       storage.DefaultS3ContentTimeout,
+		S3MaxIdleConns:         storage.DefaultS3MaxIdleConns,
+		S3IdleConnTimeout:      storage.DefaultS3IdleConnTimeout,
+		S3PrewarmConns:         0,
+		S3SlugIndex:            false,
+		PresignMaxSize:         0,
+		StorageType:            StorageAuto,
+		MongoURI:               "",
+		MongoDatabase:          "nclip",
+		Instances:              1,
+		DataDir:                "./data",
+		Fsync:                  false,
+		MaxRenderSize:          262144, // 256 KiB
+		TCPRateLimit:           60,
+		TCPRateLimitIPv4Prefix: 32,
+		TCPRateLimitIPv6Prefix: 64,
+		TCPMaxSize:             1024 * 1024, // 1 MiB
+		SessionTTL:             24 * time.Hour,
+		Role:                   RoleWriter,
+		AuditMaxSize:           10 * 1024 * 1024, // 10 MiB
+		AuditMaxBackups:        5,
+		SpoolMaxSize:           100 * 1024 * 1024, // 100 MiB
+		MultipartSpoolMaxSize:  256 * 1024 * 1024, // 256 MiB
+		ReadRetryAttempts:      3,
+		ReadRetryBackoff:       100 * time.Millisecond,
+		ReencryptRate:          10,
+		OrphanMinAge:           24 * time.Hour,
+		CDNPurgePaths:          "/{slug},/raw/{slug},/download/{slug},/r/{slug},/d/{slug},/embed/{slug},/preview/{slug}.png",
+		CDNPurgeInterval:       30 * time.Second,
+		ShedWindow:             time.Minute,
+		CacheControlStatic:     "public, max-age=3600",
+		CacheControlPages:      "no-cache",
+		CacheControlContent:    "no-cache",
+		CacheControlAPI:        "no-store",
+		MaxVersions:            5,
+		HotSlugs:               100,
+		EmbedFrameAncestors:    "*",
+		MirrorInterval:         30 * time.Second,
+		PushExpiryNotice:       time.Hour,
+		ACMEDirectory:          certs.LetsEncrypt,
+		ACMEPropagation:        30 * time.Second,
+		CustomSlugMode:         slugmask.ModePlain,
+	}
 }
 
-// LoadConfig loads configuration from environment variables and CLI flags
+// LoadConfig loads configuration from the process's CLI flags, environment
+// and config file. Invalid configuration is reported on stderr and the
+// process exits, since nclip cannot start with it.
 func LoadConfig() *Config {
-	config := &Config{
-		Port:          8080,
-		URL:           "",
-		SlugLength:    5,
-		BufferSize:    5 * 1024 * 1024, // 5MB
-		DefaultTTL:    24 * time.Hour,
-		S3Bucket:      "",
-		S3Prefix:      "",
-		DataDir:       "./data",
-		MaxRenderSize: 262144, // 256 KiB
-	}
-
-	// Parse CLI flags
-	flag.IntVar(&config.Port, "port", config.Port, "Port to listen on")
-	flag.StringVar(&config.URL, "url", config.URL, "Base URL for paste links")
-	flag.IntVar(&config.SlugLength, "slug-length", config.SlugLength, "Length of generated slugs")
-	flag.Int64Var(&config.BufferSize, "buffer-size", config.BufferSize, "Maximum upload size in bytes")
-	flag.Int64Var(&config.MaxRenderSize, "max-render-size", config.MaxRenderSize, "Maximum size (bytes) to render inline in the HTML view")
-	flag.DurationVar(&config.DefaultTTL, "ttl", config.DefaultTTL, "Default paste expiration time")
-	flag.StringVar(&config.S3Bucket, "s3-bucket", config.S3Bucket, "S3 bucket for Lambda mode")
-	flag.StringVar(&config.S3Prefix, "s3-prefix", config.S3Prefix, "S3 key prefix for Lambda mode")
-	flag.StringVar(&config.DataDir, "data-dir", config.DataDir, "Filesystem data directory for server mode")
-	flag.BoolVar(&config.UploadAuth, "upload-auth", config.UploadAuth, "Require API key for upload endpoints")
-	flag.StringVar(&config.APIKeys, "api-keys", config.APIKeys, "Comma-separated API keys for upload authentication")
-	flag.Parse()
-
-	// Override with environment variables if present
-	setIntEnv := func(env string, dest *int) {
-		if val := os.Getenv(env); val != "" {
-			if v, err := strconv.Atoi(val); err == nil {
-				*dest = v
-			}
-		}
-	}
-	setInt64Env := func(env string, dest *int64) {
-		if val := os.Getenv(env); val != "" {
-			if v, err := strconv.ParseInt(val, 10, 64); err == nil {
-				*dest = v
-			}
-		}
-	}
-	setBoolEnv := func(env string, dest *bool) {
-		if val := os.Getenv(env); val != "" {
-			if v, err := strconv.ParseBool(val); err == nil {
-				*dest = v
-			}
-		}
-	}
-	setStringEnv := func(env string, dest *string) {
-		if val := os.Getenv(env); val != "" {
-			*dest = val
-		}
-	}
-
-	setIntEnv("NCLIP_PORT", &config.Port)
-	setStringEnv("NCLIP_URL", &config.URL)
-	setIntEnv("NCLIP_SLUG_LENGTH", &config.SlugLength)
-	setInt64Env("NCLIP_BUFFER_SIZE", &config.BufferSize)
-	if val := os.Getenv("NCLIP_TTL"); val != "" {
-		if ttl, err := time.ParseDuration(val); err == nil {
-			config.DefaultTTL = ttl
-		}
-	}
-	setStringEnv("NCLIP_S3_BUCKET", &config.S3Bucket)
-	setStringEnv("NCLIP_S3_PREFIX", &config.S3Prefix)
-	setBoolEnv("NCLIP_UPLOAD_AUTH", &config.UploadAuth)
-	setStringEnv("NCLIP_API_KEYS", &config.APIKeys)
-	// NCLIP_DATA_DIR configures the local filesystem data directory used in
-	// server mode. Keep backward compatibility with the environment var.
-	setStringEnv("NCLIP_DATA_DIR", &config.DataDir)
-	// NCLIP_MAX_RENDER_SIZE configures MaxRenderSize; preview length equals MaxRenderSize.
-	setInt64Env("NCLIP_MAX_RENDER_SIZE", &config.MaxRenderSize)
+	config, _, err := Load(flag.CommandLine, os.Args[1:], os.Getenv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nclip: %v\n", err)
+		os.Exit(2)
+	}
+	return config
+}
+
+// Load builds the configuration with precedence flags > env > file >
+// defaults. Flags are registered on fs and parsed from args; the config
+// file is named by --config or NCLIP_CONFIG, or is ./nclip.yaml when that
+// exists. It also returns the source each setting came from, keyed by
+// file key.
+func Load(fs *flag.FlagSet, args []string, getenv func(string) string) (*Config, map[string]string, error) {
+	config := Default()
+	opts := config.options()
+	sources := make(map[string]string, len(opts))
+	for _, o := range opts {
+		sources[o.fileKey()] = SourceDefault
+		registerFlag(fs, o)
+	}
+	var configPath string
+	fs.StringVar(&configPath, "config", "", "Path to a YAML or TOML config file (env: NCLIP_CONFIG)")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	fromFlag := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { fromFlag[f.Name] = true })
+
+	// Config file: lowest precedence after the defaults.
+	if configPath == "" {
+		configPath = getenv("NCLIP_CONFIG")
+	}
+	required := configPath != ""
+	if !required {
+		configPath = defaultConfigFile
+	}
+	values, err := readConfigFile(configPath, required)
+	if err != nil {
+		return nil, nil, err
+	}
+	if values != nil {
+		if err := applyFile(opts, values, fromFlag, sources, configPath); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Environment overrides the file but not explicit flags.
+	var errs []error
+	for _, o := range opts {
+		if fromFlag[o.name] {
+			sources[o.fileKey()] = SourceFlag
+			continue
+		}
+		val := getenv(o.env)
+		if val == "" {
+			continue
+		}
+		if err := setFromString(o, val); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", o.env, err))
+			continue
+		}
+		sources[o.fileKey()] = SourceEnv
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+
+	if config.Role == "" {
+		config.Role = RoleWriter
+	}
 
 	// Ensure DataDir is never empty. If a user passed an empty value via
 	// CLI flags (for example `--data-dir ""`) we treat that as unspecified
@@ -114,14 +748,256 @@ func LoadConfig() *Config {
 	}
 
 	// Normalize DataDir to absolute path where possible
-	if abs, err := filepath.Abs(config.DataDir); err == nil {
-		config.DataDir = abs
+	abs, err := filepath.Abs(config.DataDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve absolute path for data directory: %w", err)
+	}
+	config.DataDir = abs
+
+	// "paste", "/paste/" and "/paste" all mount nclip under /paste.
+	if config.RoutePrefix = strings.Trim(config.RoutePrefix, "/"); config.RoutePrefix != "" {
+		config.RoutePrefix = "/" + config.RoutePrefix
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return config, sources, nil
+}
+
+// Validate checks that settings are within their allowed ranges, reporting
+// every problem at once under the setting's config file key.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: "+format, append([]interface{}{key}, args...)...))
+		}
+	}
+	check(c.Port > 0 && c.Port <= 65535, "port", "must be between 1 and 65535, got %d", c.Port)
+	check(c.TCPPort >= 0 && c.TCPPort <= 65535, "tcp_port", "must be between 0 and 65535, got %d", c.TCPPort)
+	check(c.GopherPort >= 0 && c.GopherPort <= 65535, "gopher_port", "must be between 0 and 65535, got %d", c.GopherPort)
+	check(c.MetricsPort >= 0 && c.MetricsPort <= 65535, "metrics_port", "must be between 0 and 65535, got %d", c.MetricsPort)
+	check(c.MetricsPort == 0 || c.MetricsPort != c.Port, "metrics_port", "must differ from port %d", c.Port)
+	check(c.SlugLength >= 3 && c.SlugLength <= 32, "slug_length", "must be between 3 and 32, got %d", c.SlugLength)
+	check(c.BufferSize > 0, "buffer_size", "must be positive, got %d", c.BufferSize)
+	check(c.MaxRenderSize >= 0, "max_render_size", "must not be negative, got %d", c.MaxRenderSize)
+	check(c.DefaultTTL > 0, "ttl", "must be positive, got %s", c.DefaultTTL)
+	check(c.SessionTTL > 0, "session_ttl", "must be positive, got %s", c.SessionTTL)
+	check(c.SessionSecretPrevious == "" || c.SessionSecret != "", "session_secret_previous", "needs session_secret, the secret that replaced them")
+	check(c.TCPRateLimit >= 0, "tcp_rate_limit", "must not be negative, got %d", c.TCPRateLimit)
+	check(c.TCPRateLimitIPv6 >= 0, "tcp_rate_limit_ipv6", "must not be negative, got %d", c.TCPRateLimitIPv6)
+	check(c.TCPRateLimitIPv4Prefix >= 1 && c.TCPRateLimitIPv4Prefix <= 32, "tcp_rate_limit_ipv4_prefix", "must be between 1 and 32, got %d", c.TCPRateLimitIPv4Prefix)
+	check(c.TCPRateLimitIPv6Prefix >= 1 && c.TCPRateLimitIPv6Prefix <= 128, "tcp_rate_limit_ipv6_prefix", "must be between 1 and 128, got %d", c.TCPRateLimitIPv6Prefix)
+	check(c.TCPMaxSize >= 0, "tcp_max_size", "must not be negative, got %d", c.TCPMaxSize)
+	check(c.AuditMaxSize >= 0, "audit_max_size", "must not be negative, got %d", c.AuditMaxSize)
+	check(c.AuditMaxBackups >= 0, "audit_max_backups", "must not be negative, got %d", c.AuditMaxBackups)
+	check(c.PoWDifficulty >= 0 && c.PoWDifficulty <= 32, "pow_difficulty", "must be between 0 and 32, got %d", c.PoWDifficulty)
+	check(!c.SessionUploads || !c.UploadAuth || c.PoWDifficulty > 0, "session_uploads", "requires pow_difficulty, since anyone loading the web UI gets a session")
+	check(c.ReadRetryAttempts >= 0 && c.ReadRetryAttempts <= 10, "read_retry_attempts", "must be between 0 and 10, got %d", c.ReadRetryAttempts)
+	check(c.ReadRetryBackoff >= 0 && c.ReadRetryBackoff <= 5*time.Second, "read_retry_backoff", "must be between 0 and 5s, got %s", c.ReadRetryBackoff)
+	keys, err := apikeys.Load(c.APIKeys, c.APIKeysFile)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("api_keys_file: %w", err))
+	}
+	if workspaces, err := slashcmd.ParseWorkspaces(c.SlackWorkspaces); err != nil {
+		errs = append(errs, fmt.Errorf("slack_workspaces: %w", err))
+	} else {
+		for _, ws := range workspaces {
+			_, listed := keys.Lookup(ws.APIKey)
+			check(ws.APIKey == "" || listed, "slack_workspaces", "workspace %s maps to an API key not listed in api_keys", ws.TeamID)
+		}
+	}
+	if senders, err := emailin.ParseSenders(c.EmailSenders); err != nil {
+		errs = append(errs, fmt.Errorf("email_senders: %w", err))
+	} else {
+		check(c.EmailSNSTopic == "" || len(senders) > 0, "email_senders", "required when email_sns_topic is set")
+	}
+	if c.BurnNotifyFrom != "" {
+		_, err := mail.ParseAddress(c.BurnNotifyFrom)
+		check(err == nil, "burn_notify_from", "must be an email address, got %q", c.BurnNotifyFrom)
+	}
+	if c.EncryptionKeys != "" {
+		if _, err := keyring.Parse(c.EncryptionKeys); err != nil {
+			errs = append(errs, fmt.Errorf("encryption_keys: %w", err))
+		}
+	}
+	if c.SigningKey != "" {
+		if _, err := signing.Parse(c.SigningKey); err != nil {
+			errs = append(errs, fmt.Errorf("signing_key: %w", err))
+		}
+	}
+	if c.VAPIDPrivateKey != "" {
+		if _, err := push.ParseKey(c.VAPIDPrivateKey); err != nil {
+			errs = append(errs, fmt.Errorf("vapid_private_key: %w", err))
+		}
+	}
+	check(c.VAPIDPrivateKey == "" || push.ValidSubject(c.VAPIDSubject), "vapid_subject", "must be a mailto: or https:// URL when vapid_private_key is set, got %q", c.VAPIDSubject)
+	check(c.PushExpiryNotice >= time.Minute && c.PushExpiryNotice <= 24*time.Hour, "push_expiry_notice", "must be between 1m and 24h, got %s", c.PushExpiryNotice)
+	if _, err := storage.ParseReadCountMode(c.S3ReadCounting); err != nil {
+		errs = append(errs, fmt.Errorf("s3_read_counting: %w", err))
+	}
+	if err := (storage.S3PutOptions{StorageClass: c.S3StorageClass}).Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("s3_storage_class: %w", err))
+	}
+	if err := (storage.S3PutOptions{ACL: c.S3ACL}).Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("s3_acl: %w", err))
+	}
+	if err := (storage.S3ClientOptions{RetryMode: c.S3RetryMode}).Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("s3_retry_mode: %w", err))
+	}
+	check(c.S3MaxAttempts >= 1 && c.S3MaxAttempts <= 10, "s3_max_attempts", "must be between 1 and 10, got %d", c.S3MaxAttempts)
+	check(c.S3HeadTimeout >= 100*time.Millisecond && c.S3HeadTimeout <= time.Minute, "s3_head_timeout", "must be between 100ms and 1m, got %s", c.S3HeadTimeout)
+	check(c.S3Timeout >= time.Second && c.S3Timeout <= 5*time.Minute, "s3_timeout", "must be between 1s and 5m, got %s", c.S3Timeout)
+	check(c.S3ContentTimeout >= time.Second && c.S3ContentTimeout <= 15*time.Minute, "s3_content_timeout", "must be between 1s and 15m, got %s", c.S3ContentTimeout)
+	check(c.S3MaxIdleConns >= 1 && c.S3MaxIdleConns <= 1000, "s3_max_idle_conns", "must be between 1 and 1000, got %d", c.S3MaxIdleConns)
+	check(c.S3IdleConnTimeout >= time.Second && c.S3IdleConnTimeout <= time.Hour, "s3_idle_conn_timeout", "must be between 1s and 1h, got %s", c.S3IdleConnTimeout)
+	check(c.S3PrewarmConns >= 0 && c.S3PrewarmConns <= c.S3MaxIdleConns, "s3_prewarm_conns", "must be between 0 and s3_max_idle_conns (%d), got %d", c.S3MaxIdleConns, c.S3PrewarmConns)
+	check(c.StorageType == StorageAuto || c.StorageType == StorageMemory, "storage_type", "must be %q or %q, got %q", StorageAuto, StorageMemory, c.StorageType)
+	check(!c.IsMemory() || c.MongoURI == "", "storage_type", "memory cannot be combined with mongo_uri")
+	check(c.MongoURI == "" || strings.HasPrefix(c.MongoURI, "mongodb://") || strings.HasPrefix(c.MongoURI, "mongodb+srv://"),
+		"mongo_uri", "must start with mongodb:// or mongodb+srv://")
+	check(c.MongoURI == "" || (c.MongoDatabase != "" && !strings.ContainsAny(c.MongoDatabase, "/\\. \"$")),
+		"mongo_database", "must be a non-empty name without /\\. \"$, got %q", c.MongoDatabase)
+	check(c.Instances >= 1 && c.Instances <= 1000, "instances", "must be between 1 and 1000, got %d", c.Instances)
+	check(c.RedisURL == "" || strings.HasPrefix(c.RedisURL, "redis://") || strings.HasPrefix(c.RedisURL, "rediss://"),
+		"redis_url", "must start with redis:// or rediss://")
+	check(c.S3ReadFlushInterval >= time.Second && c.S3ReadFlushInterval <= time.Hour, "s3_read_flush_interval", "must be between 1s and 1h, got %s", c.S3ReadFlushInterval)
+	check(c.ReencryptRate >= 1 && c.ReencryptRate <= 1000, "reencrypt_rate", "must be between 1 and 1000, got %d", c.ReencryptRate)
+	check(c.OrphanSweepInterval == 0 || c.OrphanSweepInterval >= time.Minute, "orphan_sweep_interval", "must be 0 or at least 1m, got %s", c.OrphanSweepInterval)
+	check(c.OrphanMinAge >= time.Hour, "orphan_min_age", "must be at least 1h, got %s", c.OrphanMinAge)
+	check(c.CDNPurgeCloudFront == "" || cloudFrontIDPattern.MatchString(c.CDNPurgeCloudFront), "cdn_purge_cloudfront", "must be a distribution ID such as E2QWRUHAPOMQZL, got %q", c.CDNPurgeCloudFront)
+	check(c.CDNPurgeWebhook == "" || (isLinkURL(c.CDNPurgeWebhook) && !strings.HasPrefix(c.CDNPurgeWebhook, "/")), "cdn_purge_webhook", "must be an http:// or https:// URL")
+	check(cdnpurge.ParseTemplates(c.CDNPurgePaths, "") != nil, "cdn_purge_paths", "must list paths starting with / and containing {slug}, got %q", c.CDNPurgePaths)
+	check(c.CDNPurgeInterval >= time.Second && c.CDNPurgeInterval <= time.Hour, "cdn_purge_interval", "must be between 1s and 1h, got %s", c.CDNPurgeInterval)
+	check(c.ShedErrorPercent >= 0 && c.ShedErrorPercent <= 100, "shed_error_percent", "must be between 0 and 100, got %d", c.ShedErrorPercent)
+	check(c.ShedLatency >= 0, "shed_latency", "must not be negative, got %s", c.ShedLatency)
+	check(c.MaxConcurrentUploads >= 0, "max_concurrent_uploads", "must not be negative, got %d", c.MaxConcurrentUploads)
+	check(c.MaxConcurrentUploadsPerKey >= 0, "max_concurrent_uploads_per_key", "must not be negative, got %d", c.MaxConcurrentUploadsPerKey)
+	for _, cc := range []struct{ key, value string }{
+		{"cache_control_static", c.CacheControlStatic},
+		{"cache_control_pages", c.CacheControlPages},
+		{"cache_control_content", c.CacheControlContent},
+		{"cache_control_api", c.CacheControlAPI},
+	} {
+		if err := respheaders.CheckCacheControl(cc.value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", cc.key, err))
+		}
+	}
+	check(c.ShedWindow >= 10*time.Second && c.ShedWindow <= 10*time.Minute, "shed_window", "must be between 10s and 10m, got %s", c.ShedWindow)
+	check(c.MaxVersions >= 0 && c.MaxVersions <= 100, "max_versions", "must be between 0 and 100, got %d", c.MaxVersions)
+	check(c.HotSlugs >= 0 && c.HotSlugs <= 1000, "hot_slugs", "must be between 0 and 1000, got %d", c.HotSlugs)
+	check(c.MinRetention >= 0, "min_retention", "must not be negative, got %s", c.MinRetention)
+	check(c.SpoolMaxSize >= 0, "spool_max_size", "must not be negative, got %d", c.SpoolMaxSize)
+	check(c.BinaryConfirmSize >= 0, "binary_confirm_size", "must not be negative, got %d", c.BinaryConfirmSize)
+	check(c.PresignMaxSize >= 0 && c.PresignMaxSize <= 5<<30, "presign_max_size", "must be between 0 and 5 GiB (the largest single S3 PUT), got %d", c.PresignMaxSize)
+	check(c.MultipartSpoolMaxSize > 0 || c.MultipartSpoolDir == "", "multipart_spool_max_size", "must be positive when multipart_spool_dir is set, got %d", c.MultipartSpoolMaxSize)
+	check(c.Role == RoleWriter || c.Role == RoleReplica || c.Role == RoleMirror, "role", "must be %q, %q or %q, got %q", RoleWriter, RoleReplica, RoleMirror, c.Role)
+	if c.IsMirror() {
+		check(c.WriterURL != "", "writer_url", "required when role is %q", RoleMirror)
+		check(c.MirrorAPIKey != "", "mirror_api_key", "required when role is %q", RoleMirror)
+	}
+	check(c.MirrorInterval >= time.Second, "mirror_interval", "must be at least 1s, got %s", c.MirrorInterval)
+	check((c.TLSCert == "") == (c.TLSKey == ""), "tls_cert", "tls_cert and tls_key must be set together")
+	check(!c.H2C || !c.TLSEnabled(), "h2c", "cannot be combined with TLS, which negotiates HTTP/2 itself")
+	check(!c.HTTP3 || c.TLSEnabled(), "http3", "requires tls_cert and tls_key or acme_domains")
+	if c.ACMEEnabled() {
+		if _, err := certs.ParseDomains(c.ACMEDomains); err != nil {
+			errs = append(errs, fmt.Errorf("acme_domains: %w", err))
+		}
+		check(c.TLSCert == "" && c.TLSKey == "", "acme_domains", "cannot be combined with tls_cert and tls_key")
+		check(c.ACMEDirectory != "", "acme_directory", "required when acme_domains is set")
+		check(c.ACMEDNSProvider == "route53" || c.ACMEDNSProvider == "cloudflare", "acme_dns_provider", "must be \"route53\" or \"cloudflare\", got %q", c.ACMEDNSProvider)
+		check(c.ACMEDNSProvider != "cloudflare" || c.CloudflareAPIToken != "", "cloudflare_api_token", "required when acme_dns_provider is \"cloudflare\"")
+	}
+	if _, err := forwarded.ParseProxies(c.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("trusted_proxies: %w", err))
+	}
+	check(c.RoutePrefix == "" || routePrefixPattern.MatchString(c.RoutePrefix) && path.Clean(c.RoutePrefix) == c.RoutePrefix, "route_prefix", "must be a clean path of letters, digits, '-', '_', '.' and '~', got %q", c.RoutePrefix)
+	check(!strings.ContainsAny(c.EmbedFrameAncestors, ";,\r\n"), "embed_frame_ancestors", "must be a space-separated source list without ';' or ',', got %q", c.EmbedFrameAncestors)
+	if c.CABundle != "" {
+		if _, _, err := egress.LoadCABundle(c.CABundle); err != nil {
+			errs = append(errs, fmt.Errorf("ca_bundle: %w", err))
+		}
+	}
+	if c.OverrideDir != "" {
+		info, err := os.Stat(c.OverrideDir)
+		check(err == nil && info.IsDir(), "override_dir", "must be an existing directory, got %q", c.OverrideDir)
+	}
+	check(isLinkURL(c.LogoURL), "logo_url", "must be an http(s) URL or an absolute path, got %q", c.LogoURL)
+	check(isLinkURL(c.ImprintURL), "imprint_url", "must be an http(s) URL or an absolute path, got %q", c.ImprintURL)
+	if c.AbuseContact != "" && !strings.Contains(c.AbuseContact, "/") {
+		addr, err := mail.ParseAddress(c.AbuseContact)
+		check(err == nil && addr.Address == c.AbuseContact, "abuse_contact", "must be an email address or an http(s) URL, got %q", c.AbuseContact)
 	} else {
-		// If we can't get an absolute path, return error and exit
-		// (this should be rare, only if the current working directory
-		// is somehow invalid)
-		panic("Failed to resolve absolute path for data directory: " + err.Error())
+		check(isLinkURL(c.AbuseContact), "abuse_contact", "must be an email address or an http(s) URL, got %q", c.AbuseContact)
+	}
+	check(c.CustomSlugMode == slugmask.ModePlain || c.CustomSlugMode == slugmask.ModeObfuscate, "custom_slug_mode", "must be \"plain\" or \"obfuscate\", got %q", c.CustomSlugMode)
+	check(c.CustomSlugMode != slugmask.ModeObfuscate || len(c.SlugSecret) >= slugmask.MinSecretLength, "slug_secret", "must be at least %d characters when custom_slug_mode is \"obfuscate\"", slugmask.MinSecretLength)
+	check(c.ACMEPropagation >= 0 && c.ACMEPropagation <= 10*time.Minute, "acme_propagation", "must be between 0 and 10m, got %s", c.ACMEPropagation)
+	return errors.Join(errs...)
+}
+
+// isLinkURL reports whether s is empty, an http(s) URL or an absolute
+// path, the links pages may point to.
+func isLinkURL(s string) bool {
+	if s == "" {
+		return true
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "" {
+		return u.Host == "" && strings.HasPrefix(u.Path, "/")
 	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
 
-	return config
+func registerFlag(fs *flag.FlagSet, o option) {
+	switch p := o.ptr.(type) {
+	case *int:
+		fs.IntVar(p, o.name, *p, o.usage)
+	case *int64:
+		fs.Int64Var(p, o.name, *p, o.usage)
+	case *bool:
+		fs.BoolVar(p, o.name, *p, o.usage)
+	case *string:
+		fs.StringVar(p, o.name, *p, o.usage)
+	case *time.Duration:
+		fs.DurationVar(p, o.name, *p, o.usage)
+	}
+}
+
+// setFromString parses an environment value into o.
+func setFromString(o option, val string) error {
+	switch p := o.ptr.(type) {
+	case *int:
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", val)
+		}
+		*p = v
+	case *int64:
+		v, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", val)
+		}
+		*p = v
+	case *bool:
+		v, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q (use true or false)", val)
+		}
+		*p = v
+	case *string:
+		*p = val
+	case *time.Duration:
+		v, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q (use e.g. 90m or 24h)", val)
+		}
+		*p = v
+	}
+	return nil
 }
@@ -1,8 +1,12 @@
 package config
 
 import (
+	"bytes"
+	"flag"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -77,3 +81,229 @@ func TestMaxRenderSize_InvalidEnv(t *testing.T) {
 		t.Errorf("expected default MaxRenderSize 262144 when env is invalid, got %d", maxRenderSize)
 	}
 }
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	return path
+}
+
+func loadWith(t *testing.T, args []string, env map[string]string) (*Config, map[string]string, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(&bytes.Buffer{})
+	return Load(fs, args, func(k string) string { return env[k] })
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeConfigFile(t, "nclip.yaml", "port: 7000\nslug_length: 6\nttl: 2h\nupload_auth: true\napi_keys: filekey\n")
+
+	cfg, sources, err := loadWith(t, []string{"--port", "9000"}, map[string]string{
+		"NCLIP_CONFIG":      path,
+		"NCLIP_PORT":        "8000",
+		"NCLIP_SLUG_LENGTH": "7",
+	})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Port != 9000 || sources["port"] != SourceFlag {
+		t.Errorf("expected flag to win for port, got %d from %s", cfg.Port, sources["port"])
+	}
+	if cfg.SlugLength != 7 || sources["slug_length"] != SourceEnv {
+		t.Errorf("expected env to beat file for slug_length, got %d from %s", cfg.SlugLength, sources["slug_length"])
+	}
+	if cfg.DefaultTTL != 2*time.Hour || !cfg.UploadAuth || cfg.APIKeys != "filekey" || sources["ttl"] != SourceFile {
+		t.Errorf("expected file values for ttl/upload_auth/api_keys, got %+v", cfg)
+	}
+	if cfg.BufferSize != 5*1024*1024 || sources["buffer_size"] != SourceDefault {
+		t.Errorf("expected default buffer size, got %d from %s", cfg.BufferSize, sources["buffer_size"])
+	}
+}
+
+func TestLoad_RoutePrefix(t *testing.T) {
+	for _, prefix := range []string{"paste", "/paste/", "/paste"} {
+		cfg, _, err := loadWith(t, nil, map[string]string{"NCLIP_ROUTE_PREFIX": prefix})
+		if err != nil {
+			t.Fatalf("Load(%q): %v", prefix, err)
+		}
+		if cfg.RoutePrefix != "/paste" || cfg.Path("/raw/ABCDE") != "/paste/raw/ABCDE" {
+			t.Errorf("route prefix %q loaded as %q", prefix, cfg.RoutePrefix)
+		}
+	}
+	cfg, _, err := loadWith(t, nil, map[string]string{"NCLIP_ROUTE_PREFIX": "/"})
+	if err != nil {
+		t.Fatalf("Load(\"/\"): %v", err)
+	}
+	if cfg.RoutePrefix != "" {
+		t.Errorf("route prefix \"/\" loaded as %q, want none", cfg.RoutePrefix)
+	}
+}
+
+func TestLoad_TOML(t *testing.T) {
+	path := writeConfigFile(t, "nclip.toml", "port = 8081\nrole = \"replica\"\nsession_ttl = \"1h\"\n")
+	cfg, _, err := loadWith(t, []string{"--config", path}, nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Port != 8081 || !cfg.IsReplica() || cfg.SessionTTL != time.Hour {
+		t.Errorf("unexpected config from TOML: %+v", cfg)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	cases := []struct {
+		name string
+		file string
+		env  map[string]string
+		want []string
+	}{
+		{"unknown key", "slug-length: 6\nroles: writer\n", nil,
+			[]string{`unknown key "slug-length" (did you mean "slug_length"?)`, `unknown key "roles" (did you mean "role"?)`}},
+		{"wrong type", "port: \"eighty\"\nttl: 5\n", nil,
+			[]string{"port: expected an integer", `ttl: expected a duration string`}},
+		{"invalid env", "", map[string]string{"NCLIP_UPLOAD_AUTH": "yes please"},
+			[]string{`NCLIP_UPLOAD_AUTH: invalid boolean "yes please"`}},
+		{"out of range", "port: 70000\nslug_length: 2\nrole: primary\n", nil,
+			[]string{"port: must be between 1 and 65535", "slug_length: must be between 3 and 32", `role: must be "writer", "replica" or "mirror"`}},
+		{"mirror", "role: mirror\nmirror_interval: 100ms\n", nil,
+			[]string{"writer_url: required when role is \"mirror\"", "mirror_api_key: required when role is \"mirror\"", "mirror_interval: must be at least 1s, got 100ms"}},
+		{"http versions", "tls_key: key.pem\nhttp3: true\n", nil,
+			[]string{"tls_cert: tls_cert and tls_key must be set together", "http3: requires tls_cert and tls_key"}},
+		{"acme", "acme_domains: example.com,*.*.example.com\ntls_cert: cert.pem\ntls_key: key.pem\nacme_dns_provider: gandi\nacme_propagation: 1h\n", nil,
+			[]string{`acme_domains: invalid domain "*.*.example.com"`, "acme_domains: cannot be combined with tls_cert and tls_key",
+				`acme_dns_provider: must be "route53" or "cloudflare", got "gandi"`, "acme_propagation: must be between 0 and 10m, got 1h0m0s"}},
+		{"acme cloudflare", "acme_domains: example.com\nacme_dns_provider: cloudflare\n", nil,
+			[]string{`cloudflare_api_token: required when acme_dns_provider is "cloudflare"`}},
+		{"h2c with tls", "tls_cert: cert.pem\ntls_key: key.pem\nh2c: true\n", nil,
+			[]string{"h2c: cannot be combined with TLS"}},
+		{"slack workspaces", "", map[string]string{"NCLIP_SLACK_WORKSPACES": "T1", "NCLIP_API_KEYS": "k1"},
+			[]string{"slack_workspaces: invalid workspace"}},
+		{"slack workspace key", "", map[string]string{"NCLIP_SLACK_WORKSPACES": "T1:secret:k2", "NCLIP_API_KEYS": "k1"},
+			[]string{"slack_workspaces: workspace T1 maps to an API key not listed in api_keys"}},
+		{"api keys file", "", map[string]string{"NCLIP_API_KEYS_FILE": "/nonexistent/nclip-keys"},
+			[]string{"api_keys_file: open /nonexistent/nclip-keys"}},
+		{"encryption keys", "", map[string]string{"NCLIP_ENCRYPTION_KEYS": "k1:c2hvcnQ="},
+			[]string{"encryption_keys: key \"k1\" must be 32 bytes in base64"}},
+		{"mongo", "mongo_uri: localhost:27017\n", nil,
+			[]string{"mongo_uri: must start with mongodb:// or mongodb+srv://"}},
+		{"storage type", "", map[string]string{"NCLIP_STORAGE_TYPE": "redis"},
+			[]string{`storage_type: must be "auto" or "memory", got "redis"`}},
+		{"memory with mongo", "storage_type: memory\nmongo_uri: mongodb://localhost\n", nil,
+			[]string{"storage_type: memory cannot be combined with mongo_uri"}},
+		{"ca bundle", "", map[string]string{"NCLIP_CA_BUNDLE": "/nonexistent/ca.pem"},
+			[]string{"ca_bundle: failed to read CA bundle: open /nonexistent/ca.pem: no such file or directory"}},
+		{"mongo database", "mongo_uri: mongodb://localhost\nmongo_database: my.db\n", nil,
+			[]string{`mongo_database: must be a non-empty name without /\. "$, got "my.db"`}},
+		{"scaling", "instances: 0\n", map[string]string{"NCLIP_REDIS_URL": "localhost:6379"},
+			[]string{"instances: must be between 1 and 1000, got 0", "redis_url: must start with redis:// or rediss://"}},
+		{"multipart spool", "multipart_spool_dir: /var/tmp/nclip\nmultipart_spool_max_size: 0\nbinary_confirm_size: -1\n", nil,
+			[]string{"binary_confirm_size: must not be negative, got -1", "multipart_spool_max_size: must be positive when multipart_spool_dir is set, got 0"}},
+		{"s3 read counting", "s3_read_counting: sometimes\ns3_read_flush_interval: 0s\npresign_max_size: -1\n", nil,
+			[]string{`s3_read_counting: must be "rewrite", "conditional" or "buffered", got "sometimes"`, "s3_read_flush_interval: must be between 1s and 1h, got 0s",
+				"presign_max_size: must be between 0 and 5 GiB (the largest single S3 PUT), got -1"}},
+		{"s3 put options", "", map[string]string{"NCLIP_S3_STORAGE_CLASS": "COLD", "NCLIP_S3_ACL": "everyone"},
+			[]string{`s3_storage_class: unknown S3 storage class "COLD"`, `s3_acl: unknown S3 canned ACL "everyone"`}},
+		{"s3 client options", "", map[string]string{"NCLIP_S3_RETRY_MODE": "eager", "NCLIP_S3_MAX_ATTEMPTS": "0", "NCLIP_S3_HEAD_TIMEOUT": "10ms", "NCLIP_S3_CONTENT_TIMEOUT": "1h"},
+			[]string{`s3_retry_mode: unknown S3 retry mode "eager"`, "s3_max_attempts: must be between 1 and 10, got 0", "s3_head_timeout: must be between 100ms and 1m, got 10ms", "s3_content_timeout: must be between 1s and 15m, got 1h0m0s"}},
+		{"s3 connections", "", map[string]string{"NCLIP_S3_MAX_IDLE_CONNS": "8", "NCLIP_S3_IDLE_CONN_TIMEOUT": "2h", "NCLIP_S3_PREWARM_CONNS": "16"},
+			[]string{"s3_idle_conn_timeout: must be between 1s and 1h, got 2h0m0s", "s3_prewarm_conns: must be between 0 and s3_max_idle_conns (8), got 16"}},
+		{"signing key", "", map[string]string{"NCLIP_SIGNING_KEY": "c2hvcnQ="},
+			[]string{"signing_key: signing key must be 32 bytes, got 5"}},
+		{"web push", "push_expiry_notice: 10s\n", map[string]string{"NCLIP_VAPID_PRIVATE_KEY": "c2hvcnQ"},
+			[]string{"vapid_private_key: VAPID private key must be 32 bytes, got 5",
+				`vapid_subject: must be a mailto: or https:// URL when vapid_private_key is set, got ""`,
+				"push_expiry_notice: must be between 1m and 24h, got 10s"}},
+		{"pow difficulty", "pow_difficulty: 40\n", nil,
+			[]string{"pow_difficulty: must be between 0 and 32, got 40"}},
+		{"session uploads", "upload_auth: true\nsession_uploads: true\n", map[string]string{"NCLIP_API_KEYS": "k1"},
+			[]string{"session_uploads: requires pow_difficulty"}},
+		{"orphan sweep", "orphan_sweep_interval: 10s\norphan_min_age: 5m\n", nil,
+			[]string{"orphan_sweep_interval: must be 0 or at least 1m, got 10s", "orphan_min_age: must be at least 1h, got 5m0s"}},
+		{"cdn purge", "cdn_purge_cloudfront: d111111abcdef8\ncdn_purge_paths: /raw/,{slug}\ncdn_purge_interval: 0s\n", map[string]string{"NCLIP_CDN_PURGE_WEBHOOK": "ftp://purge.example.com"},
+			[]string{`cdn_purge_cloudfront: must be a distribution ID such as E2QWRUHAPOMQZL, got "d111111abcdef8"`, "cdn_purge_webhook: must be an http:// or https:// URL",
+				`cdn_purge_paths: must list paths starting with / and containing {slug}, got "/raw/,{slug}"`, "cdn_purge_interval: must be between 1s and 1h, got 0s"}},
+		{"load shedding", "shed_error_percent: 150\nshed_latency: -1s\nshed_window: 1s\n", nil,
+			[]string{"shed_error_percent: must be between 0 and 100, got 150", "shed_latency: must not be negative, got -1s", "shed_window: must be between 10s and 10m, got 1s"}},
+		{"concurrent uploads", "max_concurrent_uploads: -1\n", map[string]string{"NCLIP_MAX_CONCURRENT_UPLOADS_PER_KEY": "-2"},
+			[]string{"max_concurrent_uploads: must not be negative, got -1", "max_concurrent_uploads_per_key: must not be negative, got -2"}},
+		{"cache control", "cache_control_static: \"public, max age\"\n", map[string]string{"NCLIP_CACHE_CONTROL_API": "no-store\r\nX-Injected: 1"},
+			[]string{`cache_control_static: invalid Cache-Control directive "max age"`, `cache_control_api: invalid Cache-Control directive "no-store\r\nX-Injected: 1"`}},
+		{"max versions", "max_versions: 101\n", nil,
+			[]string{"max_versions: must be between 0 and 100, got 101"}},
+		{"hot slugs", "hot_slugs: -1\n", nil,
+			[]string{"hot_slugs: must be between 0 and 1000, got -1"}},
+		{"route prefix", "", map[string]string{"NCLIP_ROUTE_PREFIX": "/paste/../admin"},
+			[]string{`route_prefix: must be a clean path of letters, digits, '-', '_', '.' and '~', got "/paste/../admin"`}},
+		{"trusted proxies", "", map[string]string{"NCLIP_TRUSTED_PROXIES": "10.0.0.0/8, proxy.internal"},
+			[]string{`trusted_proxies: invalid proxy "proxy.internal": want an IP address or CIDR range`}},
+		{"branding", "override_dir: /nonexistent/nclip-theme\nlogo_url: javascript:alert(1)\nimprint_url: legal.html\n", nil,
+			[]string{`override_dir: must be an existing directory, got "/nonexistent/nclip-theme"`,
+				`logo_url: must be an http(s) URL or an absolute path, got "javascript:alert(1)"`,
+				`imprint_url: must be an http(s) URL or an absolute path, got "legal.html"`}},
+		{"abuse contact", "", map[string]string{"NCLIP_ABUSE_CONTACT": "Abuse Desk <abuse@example.com>"},
+			[]string{`abuse_contact: must be an email address or an http(s) URL, got "Abuse Desk <abuse@example.com>"`}},
+		{"previous session secrets", "", map[string]string{"NCLIP_SESSION_SECRET_PREVIOUS": "old-secret"},
+			[]string{`session_secret_previous: needs session_secret, the secret that replaced them`}},
+		{"custom slug mode", "", map[string]string{"NCLIP_CUSTOM_SLUG_MODE": "obfuscate", "NCLIP_SLUG_SECRET": "short"},
+			[]string{`slug_secret: must be at least 16 characters when custom_slug_mode is "obfuscate"`}},
+		{"embed frame ancestors", "", map[string]string{"NCLIP_EMBED_FRAME_ANCESTORS": "https://a.example; script-src *"},
+			[]string{`embed_frame_ancestors: must be a space-separated source list without ';' or ',', got "https://a.example; script-src *"`}},
+		{"reencrypt rate", "", map[string]string{"NCLIP_REENCRYPT_RATE": "0"},
+			[]string{"reencrypt_rate: must be between 1 and 1000, got 0"}},
+		{"email senders", "", map[string]string{"NCLIP_EMAIL_SENDERS": "example.com"},
+			[]string{"email_senders: invalid sender"}},
+		{"email topic without senders", "", map[string]string{"NCLIP_EMAIL_SNS_TOPIC": "arn:aws:sns:us-east-1:123456789012:mail"},
+			[]string{"email_senders: required when email_sns_topic is set"}},
+		{"burn notify from", "", map[string]string{"NCLIP_BURN_NOTIFY_FROM": "nclip at example"},
+			[]string{`burn_notify_from: must be an email address, got "nclip at example"`}},
+	}
+	for _, tc := range cases {
+		env := map[string]string{"NCLIP_CONFIG": writeConfigFile(t, "nclip.yaml", tc.file)}
+		for k, v := range tc.env {
+			env[k] = v
+		}
+		_, _, err := loadWith(t, nil, env)
+		if err == nil {
+			t.Errorf("%s: expected error", tc.name)
+			continue
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: expected error to contain %q, got:\n%v", tc.name, want, err)
+			}
+		}
+	}
+
+	if _, _, err := loadWith(t, nil, map[string]string{"NCLIP_CONFIG": "/nonexistent/nclip.yaml"}); err == nil {
+		t.Error("expected error for a missing explicit config file")
+	}
+}
+
+func TestPrint(t *testing.T) {
+	cfg := Default()
+	cfg.APIKeys = "k3y-value"
+	var buf bytes.Buffer
+	if err := cfg.Print(&buf, map[string]string{"port": SourceFlag}); err != nil {
+		t.Fatalf("Print failed: %v", err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "k3y-value") || !strings.Contains(out, `api_keys: '********'`) {
+		t.Errorf("expected api_keys to be masked, got:\n%s", out)
+	}
+	if !strings.Contains(out, "port: 8080 # flag\n") || !strings.Contains(out, "ttl: 24h0m0s\n") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+
+	// The printed configuration is itself a valid config file.
+	path := writeConfigFile(t, "nclip.yaml", strings.ReplaceAll(out, "'********'", "k3y-value"))
+	reloaded, _, err := loadWith(t, []string{"--config", path}, nil)
+	if err != nil {
+		t.Fatalf("failed to reload printed config: %v", err)
+	}
+	if reloaded.APIKeys != "k3y-value" || reloaded.Port != cfg.Port {
+		t.Errorf("round trip mismatch: %+v", reloaded)
+	}
+}
@@ -0,0 +1,221 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// readConfigFile parses the YAML or TOML file at path (chosen by extension,
+// YAML by default) into a map of top-level keys. A missing file is only an
+// error when required; otherwise nil is returned.
+func readConfigFile(path string, required bool) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- operator-supplied config path
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+	values := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		if err = dec.Decode(&values); err == io.EOF {
+			err = nil // empty file
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// applyFile copies file values into opts, skipping settings given as flags.
+// Unknown keys and type mismatches are all reported together.
+func applyFile(opts []option, values map[string]interface{}, fromFlag map[string]bool, sources map[string]string, path string) error {
+	byKey := make(map[string]option, len(opts))
+	for _, o := range opts {
+		byKey[o.fileKey()] = o
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var errs []string
+	for _, key := range keys {
+		o, ok := byKey[key]
+		if !ok {
+			msg := fmt.Sprintf("unknown key %q", key)
+			if s := suggestKey(key, byKey); s != "" {
+				msg += fmt.Sprintf(" (did you mean %q?)", s)
+			}
+			errs = append(errs, msg)
+			continue
+		}
+		if fromFlag[o.name] {
+			continue
+		}
+		if err := setFromValue(o, values[key]); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", key, err))
+			continue
+		}
+		sources[key] = SourceFile
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("config file %s:\n  %s", path, strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// setFromValue stores a decoded YAML/TOML value into o.
+func setFromValue(o option, v interface{}) error {
+	switch p := o.ptr.(type) {
+	case *int:
+		n, ok := toInt64(v)
+		if !ok || n < math.MinInt32 || n > math.MaxInt32 {
+			return fmt.Errorf("expected an integer, got %s", describe(v))
+		}
+		*p = int(n)
+	case *int64:
+		n, ok := toInt64(v)
+		if !ok {
+			return fmt.Errorf("expected an integer, got %s", describe(v))
+		}
+		*p = n
+	case *bool:
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("expected true or false, got %s", describe(v))
+		}
+		*p = b
+	case *string:
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("expected a string, got %s", describe(v))
+		}
+		*p = s
+	case *time.Duration:
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("expected a duration string such as \"24h\", got %s", describe(v))
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q (use e.g. 90m or 24h)", s)
+		}
+		*p = d
+	}
+	return nil
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint64:
+		return int64(n), n <= math.MaxInt64
+	case float64:
+		return int64(n), n == math.Trunc(n)
+	}
+	return 0, false
+}
+
+func describe(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "nothing"
+	case map[string]interface{}:
+		return "a table"
+	case []interface{}:
+		return "a list"
+	}
+	return fmt.Sprintf("%T %v", v, v)
+}
+
+// suggestKey returns the known key closest to key when it is a plausible
+// typo (for example "slug-length" or "sluglength" for "slug_length").
+func suggestKey(key string, known map[string]option) string {
+	normalized := strings.ReplaceAll(strings.ToLower(key), "-", "_")
+	if _, ok := known[normalized]; ok {
+		return normalized
+	}
+	best, bestDist := "", 3
+	for k := range known {
+		if d := editDistance(normalized, k); d < bestDist || (d == bestDist && k < best) {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// Print writes the effective configuration as YAML that can be used as a
+// config file. Secrets are masked, and each line is annotated with the
+// source of its value when sources is non-nil.
+func (c *Config) Print(w io.Writer, sources map[string]string) error {
+	for _, o := range c.options() {
+		var v interface{}
+		switch p := o.ptr.(type) {
+		case *int:
+			v = *p
+		case *int64:
+			v = *p
+		case *bool:
+			v = *p
+		case *string:
+			v = *p
+			if o.secret && *p != "" {
+				v = "********"
+			}
+		case *time.Duration:
+			v = p.String()
+		}
+		out, err := yaml.Marshal(map[string]interface{}{o.fileKey(): v})
+		if err != nil {
+			return err
+		}
+		line := strings.TrimRight(string(out), "\n")
+		if src := sources[o.fileKey()]; src != "" {
+			line += " # " + src
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/johnwmail/nclip/config"
+)
+
+const configUsage = `Usage: nclip config print [flags]
+
+Print the effective configuration as YAML, after applying defaults, the
+config file, environment variables and flags (in increasing precedence).
+Each line is annotated with the source of its value; secrets are masked.
+`
+
+// runConfigCommand implements the "nclip config" subcommand and returns the
+// process exit code.
+func runConfigCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "print" {
+		_, _ = fmt.Fprint(stderr, configUsage)
+		return 2
+	}
+	fs := flag.NewFlagSet("nclip config print", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	cfg, sources, err := config.Load(fs, args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+		return 1
+	}
+	if err := cfg.Print(stdout, sources); err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/utils"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// AuditHandler serves the admin audit log query API
+type AuditHandler struct {
+	log *audit.Logger
+}
+
+// NewAuditHandler creates a new audit handler
+func NewAuditHandler(log *audit.Logger) *AuditHandler {
+	return &AuditHandler{
+		log: log,
+	}
+}
+
+// Recent handles GET /api/v1/audit?limit=&action=&slug=, returning the most
+// recent matching entries, newest first.
+func (h *AuditHandler) Recent(c *gin.Context) {
+	filter := audit.Filter{Action: c.Query("action"), Slug: c.Query("slug")}
+	if filter.Slug != "" && !utils.IsValidSlug(filter.Slug) {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidSlug, "Invalid slug format")
+		return
+	}
+	limit := defaultAuditLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest,
+				"limit must be between 1 and "+strconv.Itoa(maxAuditLimit))
+			return
+		}
+		limit = n
+	}
+
+	entries, err := h.log.Recent(limit, filter)
+	if err != nil {
+		log.Printf("[ERROR] Audit query failed: %v", err)
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read audit log")
+		return
+	}
+	if entries == nil {
+		entries = []audit.Entry{}
+	}
+	audit.Record(c, audit.ActionAdminAudit, filter.Slug, audit.ResultSuccess, "action="+filter.Action)
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/audit"
+)
+
+func TestAuditHandler_Recent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l, err := audit.Open(filepath.Join(t.TempDir(), "audit.log"), 0, 0)
+	if err != nil {
+		t.Fatalf("audit.Open failed: %v", err)
+	}
+	defer func() { _ = l.Close() }()
+	l.Log(audit.Entry{Action: audit.ActionCreate, Slug: "AAAAA", Result: audit.ResultSuccess})
+	l.Log(audit.Entry{Action: audit.ActionBurn, Slug: "BBBBB", Result: audit.ResultSuccess})
+
+	router := gin.New()
+	router.Use(l.Middleware())
+	router.GET("/api/v1/audit", NewAuditHandler(l).Recent)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/audit?action=burn", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Entries []audit.Entry `json:"entries"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(resp.Entries) != 1 || resp.Entries[0].Slug != "BBBBB" {
+		t.Errorf("expected only the burn entry, got %+v", resp.Entries)
+	}
+
+	// The query itself is audited.
+	queries, _ := l.Recent(10, audit.Filter{Action: audit.ActionAdminAudit})
+	if len(queries) != 1 {
+		t.Errorf("expected the audit query to be recorded, got %d entries", len(queries))
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/audit?limit=0", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid limit, got %d", w.Code)
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/pow"
+)
+
+// ChallengeHandler issues the proof-of-work challenges required of uploads
+// without an API key.
+type ChallengeHandler struct {
+	verifier *pow.Verifier
+}
+
+// NewChallengeHandler creates a new challenge handler
+func NewChallengeHandler(verifier *pow.Verifier) *ChallengeHandler {
+	return &ChallengeHandler{verifier: verifier}
+}
+
+// Challenge handles GET /api/v1/challenge. The client solves the returned
+// challenge and sends the solution in the X-PoW header of its upload.
+func (h *ChallengeHandler) Challenge(c *gin.Context) {
+	ch, err := h.verifier.Challenge()
+	if err != nil {
+		log.Printf("[ERROR] Challenge: failed to issue challenge: %v", err)
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to issue challenge")
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, ch)
+}
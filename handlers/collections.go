@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/forwarded"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// CollectionHandler serves collections of pastes: the JSON API to create
+// and change them, and the HTML index page at /c/:id.
+type CollectionHandler struct {
+	service *services.CollectionService
+	access  *access.Checker
+	config  *config.Config
+	// ui provides the request scheme detection shared with the web UI.
+	ui *WebUIHandler
+}
+
+// NewCollectionHandler creates a new collection handler
+func NewCollectionHandler(service *services.CollectionService, checker *access.Checker, config *config.Config) *CollectionHandler {
+	return &CollectionHandler{
+		service: service,
+		access:  checker,
+		config:  config,
+		ui:      NewWebUIHandler(config),
+	}
+}
+
+// createCollectionRequest is the JSON body of POST /api/v1/collections.
+type createCollectionRequest struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Pastes      []string `json:"pastes"`
+}
+
+// attachRequest is the JSON body of POST /api/v1/collections/:id/pastes.
+type attachRequest struct {
+	Slugs []string `json:"slugs"`
+}
+
+// actor returns who is making the request. Admin keys may change any
+// collection, or superadmin keys once keys are in tenants, since
+// collections belong to no tenant; without upload auth there are no scopes
+// and only the owner check applies.
+func (h *CollectionHandler) actor(c *gin.Context) services.Actor {
+	a := services.Actor{Owner: h.access.Owner(c)}
+	if _, ok := access.Scopes(c); ok {
+		a.Admin = h.access.SuperAdmin(c)
+	}
+	return a
+}
+
+// Create handles POST /api/v1/collections, creating a collection owned by
+// the caller's API key, optionally with its first pastes.
+func (h *CollectionHandler) Create(c *gin.Context) {
+	var req createCollectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "Invalid JSON body")
+		return
+	}
+	if !validSlugs(c, req.Pastes) {
+		return
+	}
+	actor := h.actor(c)
+	col, err := h.service.CreateCollection(req.Title, req.Description, actor.Owner)
+	if err != nil {
+		h.fail(c, audit.ActionCollectionCreate, "", err)
+		return
+	}
+	if len(req.Pastes) > 0 {
+		attached, err := h.service.Attach(col.ID, actor, req.Pastes...)
+		if err != nil {
+			if _, derr := h.service.DeleteCollection(col.ID, actor, false); derr != nil {
+				log.Printf("[ERROR] Collections: failed to remove %s after a failed create: %v", col.ID, derr)
+			}
+			h.fail(c, audit.ActionCollectionCreate, "", err)
+			return
+		}
+		col = attached
+	}
+	audit.Record(c, audit.ActionCollectionCreate, col.ID, audit.ResultSuccess, "")
+	c.JSON(http.StatusCreated, h.response(c, col))
+}
+
+// Get handles GET /api/v1/collections/:id, returning the collection with
+// the metadata of the member pastes the caller may read.
+func (h *CollectionHandler) Get(c *gin.Context) {
+	col, err := h.service.GetCollection(c.Param("id"))
+	if err != nil {
+		h.fail(c, "", c.Param("id"), err)
+		return
+	}
+	c.JSON(http.StatusOK, h.response(c, col))
+}
+
+// Attach handles POST /api/v1/collections/:id/pastes with body
+// {"slugs": [...]}, adding existing pastes to the collection.
+func (h *CollectionHandler) Attach(c *gin.Context) {
+	id := c.Param("id")
+	var req attachRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Slugs) == 0 {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, `Body must be {"slugs": [...]} with at least one slug`)
+		return
+	}
+	if !validSlugs(c, req.Slugs) {
+		return
+	}
+	col, err := h.service.Attach(id, h.actor(c), req.Slugs...)
+	if err != nil {
+		h.fail(c, audit.ActionCollectionUpdate, id, err)
+		return
+	}
+	audit.Record(c, audit.ActionCollectionUpdate, id, audit.ResultSuccess, fmt.Sprintf("attached=%d", len(req.Slugs)))
+	c.JSON(http.StatusOK, h.response(c, col))
+}
+
+// Detach handles DELETE /api/v1/collections/:id/pastes/:slug, removing a
+// paste from the collection without deleting it.
+func (h *CollectionHandler) Detach(c *gin.Context) {
+	id, slug := c.Param("id"), c.Param("slug")
+	if !validSlugs(c, []string{slug}) {
+		return
+	}
+	col, err := h.service.Detach(id, h.actor(c), slug)
+	if err != nil {
+		h.fail(c, audit.ActionCollectionUpdate, id, err)
+		return
+	}
+	audit.Record(c, audit.ActionCollectionUpdate, id, audit.ResultSuccess, "detached="+slug)
+	c.JSON(http.StatusOK, h.response(c, col))
+}
+
+// Delete handles DELETE /api/v1/collections/:id?cascade=true. Without
+// cascade the member pastes are kept; with it they are deleted too, as far
+// as the caller could delete them one by one.
+func (h *CollectionHandler) Delete(c *gin.Context) {
+	id := c.Param("id")
+	cascade, _ := strconv.ParseBool(c.DefaultQuery("cascade", "false"))
+	result, err := h.service.DeleteCollection(id, h.actor(c), cascade)
+	if err != nil {
+		h.fail(c, audit.ActionCollectionDelete, id, err)
+		return
+	}
+	for _, slug := range result.Deleted {
+		audit.Record(c, audit.ActionDelete, slug, audit.ResultSuccess, "collection="+id)
+	}
+	audit.Record(c, audit.ActionCollectionDelete, id, audit.ResultSuccess,
+		fmt.Sprintf("cascade=%t deleted=%d kept=%d", cascade, len(result.Deleted), len(result.Kept)))
+	c.JSON(http.StatusOK, gin.H{"id": id, "deleted": result.Deleted, "kept": result.Kept})
+}
+
+// Show handles GET /c/:id, the HTML index of a collection's pastes.
+func (h *CollectionHandler) Show(c *gin.Context) {
+	data := h.pageData(c)
+	col, err := h.service.GetCollection(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, services.ErrCollectionNotFound) {
+			apierror.HTML(c, http.StatusNotFound, apierror.CodeNotFound, "Collection not found", data)
+			return
+		}
+		log.Printf("[ERROR] Collections: failed to load %s: %v", c.Param("id"), err)
+		apierror.HTML(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to load collection", data)
+		return
+	}
+	data["Title"] = "NCLIP - " + col.Title
+	data["Collection"] = col
+	data["Pastes"] = h.readable(c, col)
+	c.HTML(http.StatusOK, "collection.html", data)
+}
+
+// readable returns the live members of col the caller may read. Private
+// pastes of other keys are left out, as if they did not exist.
+func (h *CollectionHandler) readable(c *gin.Context, col *models.Collection) []*models.Paste {
+	var pastes []*models.Paste
+	for _, p := range h.service.Members(col) {
+		if h.access.CanRead(c, p) {
+			pastes = append(pastes, p)
+		}
+	}
+	return pastes
+}
+
+// response builds the JSON representation of a collection.
+func (h *CollectionHandler) response(c *gin.Context, col *models.Collection) gin.H {
+	pastes := []gin.H{}
+	for _, p := range h.readable(c, col) {
+		pastes = append(pastes, metadataResponse(p))
+	}
+	return gin.H{
+		"id":          col.ID,
+		"url":         h.baseURL(c) + "/c/" + col.ID,
+		"title":       col.Title,
+		"description": col.Description,
+		"created_at":  col.CreatedAt,
+		"pastes":      pastes,
+	}
+}
+
+// fail writes the error response for err, auditing failed changes.
+func (h *CollectionHandler) fail(c *gin.Context, action, id string, err error) {
+	status, code, msg := http.StatusInternalServerError, apierror.CodeInternal, "Failed to update collection"
+	switch {
+	case errors.Is(err, services.ErrInvalidCollection):
+		status, code, msg = http.StatusBadRequest, apierror.CodeBadRequest, err.Error()
+	case errors.Is(err, services.ErrCollectionNotFound):
+		status, code, msg = http.StatusNotFound, apierror.CodeNotFound, "Collection not found"
+	case errors.Is(err, storage.ErrNotFound):
+		status, code, msg = http.StatusNotFound, apierror.CodeNotFound, err.Error()
+	case errors.Is(err, services.ErrCollectionForbidden):
+		status, code, msg = http.StatusForbidden, apierror.CodeCollectionForbidden, err.Error()
+	case errors.Is(err, services.ErrCollectionFull):
+		status, code, msg = http.StatusConflict, apierror.CodeCollectionFull, err.Error()
+	case errors.Is(err, services.ErrCollectionsDisabled):
+		status, code, msg = http.StatusNotImplemented, apierror.CodeUnsupported, err.Error()
+	default:
+		log.Printf("[ERROR] Collections: %s %s: %v", action, id, err)
+	}
+	if action != "" {
+		audit.Record(c, action, id, audit.ResultFailure, err.Error())
+	}
+	apierror.JSON(c, status, code, msg)
+}
+
+// baseURL returns the scheme and host the request was made to, followed
+// by the forwarded path prefix and the route prefix.
+func (h *CollectionHandler) baseURL(c *gin.Context) string {
+	scheme := "http"
+	if h.ui.isHTTPS(c) {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s%s", scheme, c.Request.Host, forwarded.PathPrefix(c), h.config.RoutePrefix)
+}
+
+// pageData returns the template values shared by every page.
+func (h *CollectionHandler) pageData(c *gin.Context) gin.H {
+	return gin.H{
+		"Version":    h.config.Version,
+		"BuildTime":  h.config.BuildTime,
+		"CommitHash": h.config.CommitHash,
+		"BaseURL":    h.baseURL(c),
+	}
+}
+
+// validSlugs rejects the request with 400 unless every slug is well formed.
+func validSlugs(c *gin.Context, slugs []string) bool {
+	if len(slugs) > models.MaxCollectionPastes {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest,
+			fmt.Sprintf("At most %d slugs per request", models.MaxCollectionPastes))
+		return false
+	}
+	for _, slug := range slugs {
+		if !utils.IsValidSlug(slug) {
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidSlug, "Invalid slug format: "+slug)
+			return false
+		}
+	}
+	return true
+}
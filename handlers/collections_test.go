@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/hmackeys"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func TestCollectionHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	alice := audit.KeyID("alice")
+	for _, p := range []*models.Paste{
+		{ID: "NTS", Owner: alice},
+		{ID: "PRVT", Owner: alice, Visibility: models.VisibilityPrivate},
+		{ID: "BBS", Owner: audit.KeyID("bob")},
+	} {
+		if err := store.StoreContent(p.ID, []byte(p.ID)); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Store(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pastes := services.NewPasteService(store, cfg)
+	h := NewCollectionHandler(services.NewCollectionService(store, pastes),
+		access.NewChecker(apikeys.Parse("alice,bob"), hmackeys.New("secret", nil)), cfg)
+	router := gin.New()
+	router.POST("/api/v1/collections", h.Create)
+	router.GET("/api/v1/collections/:id", h.Get)
+	router.POST("/api/v1/collections/:id/pastes", h.Attach)
+	router.DELETE("/api/v1/collections/:id/pastes/:slug", h.Detach)
+	router.DELETE("/api/v1/collections/:id", h.Delete)
+	do := func(method, url, key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("X-Api-Key", key)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder) (resp struct {
+		ID     string `json:"id"`
+		URL    string `json:"url"`
+		Pastes []struct {
+			ID string `json:"id"`
+		} `json:"pastes"`
+	}) {
+		t.Helper()
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	w := do("POST", "/api/v1/collections", "alice", `{"title":"Notes","pastes":["NTS","PRVT"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	created := decode(w)
+	if created.ID == "" || !strings.HasSuffix(created.URL, "/c/"+created.ID) || len(created.Pastes) != 2 {
+		t.Fatalf("unexpected collection %+v", created)
+	}
+
+	// Private members are hidden from callers who cannot read them.
+	if got := decode(do("GET", "/api/v1/collections/"+created.ID, "", "")); len(got.Pastes) != 1 || got.Pastes[0].ID != "NTS" {
+		t.Errorf("expected only the public paste, got %+v", got.Pastes)
+	}
+
+	if w := do("POST", "/api/v1/collections/"+created.ID+"/pastes", "bob", `{"slugs":["BBS"]}`); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for another key's collection, got %d", w.Code)
+	}
+	if w := do("POST", "/api/v1/collections/"+created.ID+"/pastes", "alice", `{"slugs":["ABSENT"]}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing paste, got %d", w.Code)
+	}
+	if w := do("DELETE", "/api/v1/collections/"+created.ID+"/pastes/PRVT", "alice", ""); w.Code != http.StatusOK || len(decode(w).Pastes) != 1 {
+		t.Errorf("expected the paste detached, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("GET", "/api/v1/collections/NPQRSTUV", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown collection, got %d", w.Code)
+	}
+
+	w = do("DELETE", "/api/v1/collections/"+created.ID+"?cascade=true", "alice", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if exists, _ := store.Exists("NTS"); exists {
+		t.Error("expected the member paste deleted by the cascade")
+	}
+	if exists, _ := store.Exists("PRVT"); !exists {
+		t.Error("expected the detached paste kept")
+	}
+}
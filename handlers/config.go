@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/settings"
+)
+
+// ConfigHandler exposes the public, client-relevant server limits
+type ConfigHandler struct {
+	config *config.Config
+	// directUploads reports whether presigned uploads are available, which
+	// takes a suitable backend as well as the setting.
+	directUploads bool
+	// settings, when set, are reported instead of the configured values.
+	settings *settings.Runtime
+}
+
+// NewConfigHandler creates a new config handler
+func NewConfigHandler(config *config.Config) *ConfigHandler {
+	return &ConfigHandler{
+		config: config,
+	}
+}
+
+// SetDirectUploads reports presigned uploads as available.
+func (h *ConfigHandler) SetDirectUploads(enabled bool) {
+	h.directUploads = enabled
+}
+
+// SetSettings reports the default TTL and render size limit of rt, which
+// can change while the server runs.
+func (h *ConfigHandler) SetSettings(rt *settings.Runtime) {
+	h.settings = rt
+}
+
+// GetConfig handles GET /api/v1/config. It returns only values clients need
+// to validate uploads before sending them; secrets are never included.
+func (h *ConfigHandler) GetConfig(c *gin.Context) {
+	presignMaxSize := int64(0)
+	if h.directUploads {
+		presignMaxSize = h.config.PresignMaxSize
+	}
+	current := settings.FromConfig(h.config)
+	if h.settings != nil {
+		current = h.settings.Current()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"buffer_size":         h.config.BufferSize,
+		"max_render_size":     current.MaxRenderSize,
+		"default_ttl":         current.DefaultTTL.String(),
+		"min_ttl":             config.MinTTL.String(),
+		"max_ttl":             config.MaxTTL.String(),
+		"min_retention":       h.config.MinRetention.String(),
+		"upload_auth":         h.config.UploadAuth,
+		"pow_difficulty":      h.config.PoWDifficulty,
+		"binary_confirm_size": h.config.BinaryConfirmSize,
+		"presign_max_size":    presignMaxSize,
+		"range_requests":      true,
+		"read_only":           current.ReadOnly,
+		"version":             h.config.Version,
+	})
+}
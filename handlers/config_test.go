@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+)
+
+func TestConfigHandler_GetConfig(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{BufferSize: 1024, MaxRenderSize: 512, DefaultTTL: 2 * time.Hour, UploadAuth: true, APIKeys: "secret"}
+	handler := NewConfigHandler(cfg)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	handler.GetConfig(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response["buffer_size"] != float64(1024) {
+		t.Errorf("Expected buffer_size 1024, got %v", response["buffer_size"])
+	}
+	if response["default_ttl"] != "2h0m0s" {
+		t.Errorf("Expected default_ttl 2h0m0s, got %v", response["default_ttl"])
+	}
+	if response["upload_auth"] != true {
+		t.Errorf("Expected upload_auth true, got %v", response["upload_auth"])
+	}
+	if _, ok := response["api_keys"]; ok {
+		t.Errorf("API keys must not be exposed")
+	}
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/forwarded"
+)
+
+// redactedHeaders carry credentials, so the request echo reports that they
+// were sent but not their values.
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Cookie":              true,
+	"Proxy-Authorization": true,
+	"X-Api-Key":           true,
+}
+
+// DebugHandler serves the admin request echo used to check proxy and CDN
+// configuration.
+type DebugHandler struct {
+	config *config.Config
+	// ui provides the request scheme detection shared with the web UI.
+	ui *WebUIHandler
+}
+
+// NewDebugHandler creates a new debug handler
+func NewDebugHandler(config *config.Config) *DebugHandler {
+	return &DebugHandler{config: config, ui: NewWebUIHandler(config)}
+}
+
+// Request handles GET /api/v1/debug/request, echoing the request headers as
+// received together with what the server derives from them: the scheme,
+// host and client IP, and the base URL it builds links from. Credential
+// headers are redacted.
+func (h *DebugHandler) Request(c *gin.Context) {
+	scheme := "http"
+	if h.ui.isHTTPS(c) {
+		scheme = "https"
+	}
+	headers := make(map[string][]string, len(c.Request.Header))
+	for name, values := range c.Request.Header {
+		if redactedHeaders[name] {
+			values = []string{"[redacted]"}
+		}
+		headers[name] = values
+	}
+	if c.Request.Host != "" {
+		headers["Host"] = []string{c.Request.Host}
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"method":       c.Request.Method,
+		"uri":          c.Request.RequestURI,
+		"proto":        c.Request.Proto,
+		"tls":          c.Request.TLS != nil,
+		"remote_addr":  c.Request.RemoteAddr,
+		"client_ip":    c.ClientIP(),
+		"scheme":       scheme,
+		"host":         c.Request.Host,
+		"route_prefix": h.config.RoutePrefix,
+		"path_prefix":  forwarded.PathPrefix(c),
+		"base_url":     fmt.Sprintf("%s://%s%s%s", scheme, c.Request.Host, forwarded.PathPrefix(c), h.config.RoutePrefix),
+		"headers":      headers,
+		"forwarded":    forwardedHeaders(c.Request.Header),
+	})
+}
+
+// forwardedHeaders returns the proxy headers that affect the derived scheme,
+// host and client IP, keyed by lower-case name, so a missing one is easy to
+// spot.
+func forwardedHeaders(header http.Header) map[string]string {
+	out := map[string]string{}
+	for _, name := range []string{
+		"Forwarded",
+		"X-Forwarded-For",
+		"X-Forwarded-Host",
+		"X-Forwarded-Prefix",
+		"X-Forwarded-Proto",
+		"X-Forwarded-Protocol",
+		"X-Forwarded-Scheme",
+		"X-Forwarded-Ssl",
+		"X-Real-Ip",
+		"CloudFront-Forwarded-Proto",
+		"CloudFront-Viewer-Address",
+	} {
+		out[strings.ToLower(name)] = header.Get(name)
+	}
+	return out
+}
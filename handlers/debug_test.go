@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+)
+
+func TestDebugHandler_Request(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/v1/debug/request", NewDebugHandler(&config.Config{RoutePrefix: "/paste"}).Request)
+
+	req := httptest.NewRequest("GET", "/api/v1/debug/request", nil)
+	req.Host = "paste.example.com"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Api-Key", "secret")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Scheme    string              `json:"scheme"`
+		Host      string              `json:"host"`
+		BaseURL   string              `json:"base_url"`
+		Headers   map[string][]string `json:"headers"`
+		Forwarded map[string]string   `json:"forwarded"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if resp.Scheme != "https" || resp.Host != "paste.example.com" || resp.BaseURL != "https://paste.example.com/paste" {
+		t.Errorf("unexpected derived values: %+v", resp)
+	}
+	if got := resp.Headers["X-Api-Key"]; len(got) != 1 || got[0] != "[redacted]" {
+		t.Errorf("X-Api-Key not redacted: %v", got)
+	}
+	if resp.Forwarded["x-forwarded-proto"] != "https" {
+		t.Errorf("forwarded headers missing: %v", resp.Forwarded)
+	}
+}
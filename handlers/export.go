@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// ExportHandler serves zip archives of the caller's pastes
+type ExportHandler struct {
+	store  storage.PasteStore
+	access *access.Checker
+	now    func() time.Time
+}
+
+// NewExportHandler creates a new export handler
+func NewExportHandler(store storage.PasteStore, checker *access.Checker) *ExportHandler {
+	return &ExportHandler{store: store, access: checker, now: time.Now}
+}
+
+// exportEntry describes one paste in the archive manifest.
+type exportEntry struct {
+	ID       string `json:"id"`
+	Content  string `json:"content"`
+	Metadata string `json:"metadata"`
+	Size     int64  `json:"size"`
+}
+
+// exportManifest is manifest.json, written last so it can list every
+// paste without holding the archive in memory.
+type exportManifest struct {
+	ExportedAt time.Time     `json:"exported_at"`
+	Owner      string        `json:"owner"`
+	Pastes     []exportEntry `json:"pastes"`
+	// Skipped lists burn-after-read pastes, whose content is not exported
+	// since reading it would not burn it, quarantined pastes, which only
+	// admins may read, and pastes marked corrupt.
+	Skipped []string `json:"skipped"`
+	// Failed lists pastes whose content could not be read. A read that
+	// failed partway leaves a truncated content entry in the archive.
+	Failed []string `json:"failed"`
+}
+
+// Export handles GET /api/v1/pastes/export, streaming a zip of every
+// unexpired paste owned by the caller's API key. Each paste is stored as
+// <slug>/<filename> (or <slug>/<slug><ext> when it was uploaded without
+// a filename) with its metadata in <slug>/metadata.json, and
+// manifest.json lists them all. Pastes are read and written one at a time,
+// so memory use is bounded by the largest paste, not the archive.
+func (h *ExportHandler) Export(c *gin.Context) {
+	lister, ok := h.store.(storage.Lister)
+	if !ok {
+		apierror.JSON(c, http.StatusNotImplemented, apierror.CodeUnsupported, "Listing is not supported by this storage backend")
+		return
+	}
+	owner := h.access.Owner(c)
+	if owner == "" {
+		apierror.JSON(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized")
+		return
+	}
+
+	// Fetch the first page before committing to a 200 response, so an
+	// unavailable backend still gets a JSON error.
+	opts := storage.ListOptions{Limit: maxListLimit}
+	page, err := lister.List(opts)
+	if err != nil {
+		log.Printf("[ERROR] Export: %v", err)
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to list pastes")
+		return
+	}
+
+	now := h.now().UTC()
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", utils.ContentDisposition("nclip-export-"+now.Format("20060102-150405")+".zip", true))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	zw := zip.NewWriter(c.Writer)
+	manifest := exportManifest{ExportedAt: now, Owner: owner, Pastes: []exportEntry{}, Skipped: []string{}, Failed: []string{}}
+	for {
+		results := storage.GetBatch(h.store, page.IDs)
+		for _, id := range page.IDs {
+			paste := results[id].Paste
+			if paste == nil || paste.Owner != owner || paste.IsExpired() {
+				continue
+			}
+			if paste.BurnAfterRead || paste.Quarantined || paste.Corrupt {
+				manifest.Skipped = append(manifest.Skipped, id)
+				continue
+			}
+			entry, err := h.writePaste(zw, paste)
+			if err != nil {
+				log.Printf("[ERROR] Export: %s: %v", id, err)
+				manifest.Failed = append(manifest.Failed, id)
+				continue
+			}
+			manifest.Pastes = append(manifest.Pastes, entry)
+			// Push each paste to the client instead of letting the
+			// response build up in buffers.
+			if err := zw.Flush(); err != nil {
+				h.abort(c, manifest, err)
+				return
+			}
+			c.Writer.Flush()
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+		if page, err = lister.List(opts); err != nil {
+			h.abort(c, manifest, err)
+			return
+		}
+	}
+
+	if err := writeJSONEntry(zw, "manifest.json", manifest); err != nil {
+		h.abort(c, manifest, err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		h.abort(c, manifest, err)
+		return
+	}
+	audit.Record(c, audit.ActionExport, "", audit.ResultSuccess,
+		fmt.Sprintf("pastes=%d skipped=%d failed=%d", len(manifest.Pastes), len(manifest.Skipped), len(manifest.Failed)))
+}
+
+// writePaste adds the content and metadata of paste to the archive. The
+// content is streamed from the store, so large pastes are never held in
+// memory.
+func (h *ExportHandler) writePaste(zw *zip.Writer, paste *models.Paste) (exportEntry, error) {
+	content, err := storage.OpenContent(h.store, paste.ID)
+	if err != nil {
+		return exportEntry{}, err
+	}
+	defer func() { _ = content.Close() }()
+	entry := exportEntry{
+		ID:       paste.ID,
+		Content:  paste.ID + "/" + exportFilename(paste),
+		Metadata: paste.ID + "/metadata.json",
+	}
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: entry.Content, Method: zip.Deflate, Modified: paste.CreatedAt})
+	if err != nil {
+		return entry, err
+	}
+	if entry.Size, err = io.Copy(w, content); err != nil {
+		return entry, err
+	}
+	return entry, writeJSONEntry(zw, entry.Metadata, metadataResponse(paste))
+}
+
+// exportFilename names a paste's content in the archive after the
+// uploader's filename, unless it would clash with the metadata file.
+func exportFilename(paste *models.Paste) string {
+	if name := utils.SanitizeFilename(paste.Filename); name != "" && name != "metadata.json" {
+		return name
+	}
+	return paste.ID + utils.ExtensionByMime(paste.ContentType)
+}
+
+// abort ends an archive that failed after the response started. The
+// status can no longer change, so the truncated zip is all the client
+// sees; the failure is logged and audited.
+func (h *ExportHandler) abort(c *gin.Context, manifest exportManifest, err error) {
+	log.Printf("[ERROR] Export: aborted after %d pastes: %v", len(manifest.Pastes), err)
+	audit.Record(c, audit.ActionExport, "", audit.ResultFailure,
+		fmt.Sprintf("pastes=%d: %v", len(manifest.Pastes), err))
+	c.Abort()
+}
+
+// writeJSONEntry adds v to the archive as an indented JSON file.
+func writeJSONEntry(zw *zip.Writer, name string, v interface{}) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
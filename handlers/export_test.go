@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/hmackeys"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func TestExportHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := audit.KeyID("alice")
+	past := time.Now().Add(-time.Hour)
+	put := func(p *models.Paste, content string) {
+		t.Helper()
+		if err := store.StoreContent(p.ID, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Store(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(&models.Paste{ID: "NTS", Owner: alice, ContentType: "text/plain", Tags: []string{"work"}}, "notes")
+	put(&models.Paste{ID: "PRVT", Owner: alice, Visibility: models.VisibilityPrivate, ContentType: "application/json", Filename: "config.json"}, "{}")
+	put(&models.Paste{ID: "BRN", Owner: alice, BurnAfterRead: true}, "once")
+	put(&models.Paste{ID: "QRNT", Owner: alice, Quarantined: true}, "flagged")
+	put(&models.Paste{ID: "XPRD", Owner: alice, ExpiresAt: &past}, "gone")
+	put(&models.Paste{ID: "BBS", Owner: audit.KeyID("bob")}, "bob's")
+	put(&models.Paste{ID: "NWNR"}, "anonymous")
+
+	h := NewExportHandler(store, access.NewChecker(apikeys.Parse("alice,bob"), hmackeys.New("secret", nil)))
+	router := gin.New()
+	router.GET("/api/v1/pastes/export", h.Export)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/v1/pastes/export", nil)
+	req.Header.Set("X-Api-Key", "alice")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("expected a zip, got %d %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	if !strings.Contains(w.Header().Get("Content-Disposition"), "attachment") {
+		t.Errorf("expected an attachment, got %q", w.Header().Get("Content-Disposition"))
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	files := map[string]string{}
+	var names []string
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(rc)
+		_ = rc.Close()
+		files[f.Name] = string(b)
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	want := []string{"NTS/NTS.txt", "NTS/metadata.json", "PRVT/config.json", "PRVT/metadata.json", "manifest.json"}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Fatalf("expected entries %v, got %v", want, names)
+	}
+	if files["NTS/NTS.txt"] != "notes" || files["PRVT/config.json"] != "{}" {
+		t.Errorf("unexpected content %q %q", files["NTS/NTS.txt"], files["PRVT/config.json"])
+	}
+	var meta map[string]interface{}
+	if err := json.Unmarshal([]byte(files["NTS/metadata.json"]), &meta); err != nil || meta["id"] != "NTS" {
+		t.Errorf("unexpected metadata %s (%v)", files["NTS/metadata.json"], err)
+	}
+	var manifest exportManifest
+	if err := json.Unmarshal([]byte(files["manifest.json"]), &manifest); err != nil {
+		t.Fatalf("invalid manifest: %v", err)
+	}
+	if manifest.Owner != alice || len(manifest.Pastes) != 2 || strings.Join(manifest.Skipped, " ") != "BRN QRNT" {
+		t.Errorf("unexpected manifest %+v", manifest)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/pastes/export", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a key, got %d", w.Code)
+	}
+}
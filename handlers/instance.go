@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/forwarded"
+	"github.com/johnwmail/nclip/internal/settings"
+	"github.com/johnwmail/nclip/internal/tenancy"
+	"github.com/johnwmail/nclip/internal/theme"
+)
+
+// InstanceHandler describes the instance to visitors and clients: the
+// /about and /stats pages and /.well-known/nclip.json. Everything it shows
+// is public already, through /api/v1/config or the upload limits.
+type InstanceHandler struct {
+	config *config.Config
+	ui     *WebUIHandler
+	// usage, when set, counts the pastes shown on /stats.
+	usage *tenancy.Tracker
+	// settings, when set, are reported instead of the configured values.
+	settings *settings.Runtime
+	features map[string]bool
+	started  time.Time
+}
+
+// NewInstanceHandler creates a new instance handler. usage may be nil for
+// stores that cannot count their pastes.
+func NewInstanceHandler(config *config.Config, usage *tenancy.Tracker) *InstanceHandler {
+	return &InstanceHandler{
+		config: config,
+		ui:     NewWebUIHandler(config),
+		usage:  usage,
+		features: map[string]bool{
+			"burn_after_read": true,
+			"collections":     true,
+			"range_requests":  true,
+			"upload_links":    config.UploadAuth,
+			"proof_of_work":   config.PoWDifficulty > 0,
+			"embed":           config.EmbedFrameAncestors != "",
+		},
+		started: time.Now(),
+	}
+}
+
+// SetSettings reports the default TTL of rt, which can change while the
+// server runs.
+func (h *InstanceHandler) SetSettings(rt *settings.Runtime) {
+	h.settings = rt
+}
+
+// SetFeature lists the optional feature name as enabled or not in
+// /.well-known/nclip.json, for features that depend on more than the
+// configuration, such as direct uploads.
+func (h *InstanceHandler) SetFeature(name string, enabled bool) {
+	h.features[name] = enabled
+}
+
+// current returns the settings in effect.
+func (h *InstanceHandler) current() settings.Values {
+	if h.settings != nil {
+		return h.settings.Current()
+	}
+	return settings.FromConfig(h.config)
+}
+
+// baseURL returns the configured URL, or the scheme and host the request
+// was made to followed by the forwarded path prefix and the route prefix.
+func (h *InstanceHandler) baseURL(c *gin.Context) string {
+	if h.config.URL != "" {
+		return strings.TrimSuffix(h.config.URL, "/")
+	}
+	scheme := "http"
+	if h.ui.isHTTPS(c) {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s%s", scheme, c.Request.Host, forwarded.PathPrefix(c), h.config.RoutePrefix)
+}
+
+// pageData returns the template values shared by the public pages.
+func (h *InstanceHandler) pageData(c *gin.Context, title string) gin.H {
+	current := h.current()
+	data := gin.H{
+		"Title":        title,
+		"Version":      h.config.Version,
+		"BuildTime":    h.config.BuildTime,
+		"CommitHash":   h.config.CommitHash,
+		"BaseURL":      h.baseURL(c),
+		"MaxSize":      formatSize(h.config.BufferSize),
+		"DefaultTTL":   formatDuration(current.DefaultTTL),
+		"MaxTTL":       formatDuration(config.MaxTTL),
+		"UploadAuth":   h.config.UploadAuth,
+		"ReadOnly":     current.ReadOnly,
+		"AbuseContact": h.config.AbuseContact,
+	}
+	if h.config.MinRetention > 0 {
+		data["MinRetention"] = formatDuration(h.config.MinRetention)
+	}
+	// The configuration only takes email addresses and URLs, and only
+	// URLs contain a slash.
+	if contact := h.config.AbuseContact; contact != "" && !strings.Contains(contact, "/") {
+		data["AbuseLink"] = "mailto:" + contact
+	} else {
+		data["AbuseLink"] = contact
+	}
+	return data
+}
+
+// About handles GET /about, the instance's limits, retention and abuse
+// policy.
+func (h *InstanceHandler) About(c *gin.Context) {
+	c.HTML(http.StatusOK, "about.html", h.pageData(c, "NCLIP - About"))
+}
+
+// Stats handles GET /stats, adding the uptime and the number and size of
+// the stored pastes to what /about shows. The counts come from a scan of
+// the store at most tenancy.MaxAge old.
+func (h *InstanceHandler) Stats(c *gin.Context) {
+	data := h.pageData(c, "NCLIP - Stats")
+	data["Uptime"] = formatDuration(time.Since(h.started))
+	if h.usage != nil {
+		total, scannedAt, err := h.usage.Total()
+		if err != nil {
+			log.Printf("[ERROR] Instance stats: %v", err)
+		} else {
+			data["Pastes"] = total.Pastes
+			data["Stored"] = formatSize(total.Bytes)
+			data["ScannedAt"] = scannedAt.UTC().Format("2006-01-02 15:04 MST")
+		}
+	}
+	c.HTML(http.StatusOK, "stats.html", data)
+}
+
+// Discovery handles GET /.well-known/nclip.json, which lets clients find
+// the instance's API, limits and optional features from its address
+// alone.
+func (h *InstanceHandler) Discovery(c *gin.Context) {
+	base := h.baseURL(c)
+	current := h.current()
+	resp := gin.H{
+		"software": "nclip",
+		"version":  h.config.Version,
+		"name":     theme.Branding{SiteName: h.config.SiteName}.Name(),
+		"url":      base,
+		"api": gin.H{
+			"upload":   base + "/",
+			"burn":     base + "/burn/",
+			"config":   base + "/api/v1/config",
+			"metadata": base + "/api/v1/meta/{slug}",
+			"raw":      base + "/raw/{slug}",
+		},
+		"limits": gin.H{
+			"max_size":    h.config.BufferSize,
+			"default_ttl": current.DefaultTTL.String(),
+			"min_ttl":     config.MinTTL.String(),
+			"max_ttl":     config.MaxTTL.String(),
+		},
+		"upload_auth": h.config.UploadAuth,
+		"read_only":   current.ReadOnly,
+		"features":    h.features,
+	}
+	if h.config.AbuseContact != "" {
+		resp["abuse_contact"] = h.config.AbuseContact
+	}
+	if h.config.PublicPages {
+		resp["about"] = base + "/about"
+		resp["stats"] = base + "/stats"
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// formatSize formats n bytes with a binary unit.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatDuration formats d in days, hours and minutes, leaving out the
+// units that are zero, e.g. "1d 6h" or "15m".
+func formatDuration(d time.Duration) string {
+	if d < time.Minute {
+		return "less than a minute"
+	}
+	days, d := d/(24*time.Hour), d%(24*time.Hour)
+	hours, d := d/time.Hour, d%time.Hour
+	minutes := d / time.Minute
+	var parts []string
+	for _, p := range []struct {
+		n    time.Duration
+		unit string
+	}{{days, "d"}, {hours, "h"}, {minutes, "m"}} {
+		if p.n > 0 {
+			parts = append(parts, fmt.Sprintf("%d%s", p.n, p.unit))
+		}
+	}
+	return strings.Join(parts, " ")
+}
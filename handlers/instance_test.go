@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+)
+
+func TestInstanceHandler_Discovery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{URL: "https://paste.example.com/", BufferSize: 1024, DefaultTTL: 2 * time.Hour,
+		UploadAuth: true, APIKeys: "secret", PublicPages: true, AbuseContact: "abuse@example.com", SiteName: "Example Paste"}
+	handler := NewInstanceHandler(cfg, nil)
+	handler.SetFeature("direct_uploads", true)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/.well-known/nclip.json", nil)
+	handler.Discovery(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var response struct {
+		Name         string            `json:"name"`
+		URL          string            `json:"url"`
+		API          map[string]string `json:"api"`
+		Limits       map[string]any    `json:"limits"`
+		UploadAuth   bool              `json:"upload_auth"`
+		Features     map[string]bool   `json:"features"`
+		AbuseContact string            `json:"abuse_contact"`
+		About        string            `json:"about"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response.Name != "Example Paste" || response.URL != "https://paste.example.com" || response.About != "https://paste.example.com/about" {
+		t.Errorf("unexpected identity: %+v", response)
+	}
+	if response.API["upload"] != "https://paste.example.com/" || response.Limits["max_size"] != float64(1024) || response.Limits["default_ttl"] != "2h0m0s" {
+		t.Errorf("unexpected API or limits: %+v", response)
+	}
+	if !response.UploadAuth || !response.Features["direct_uploads"] || !response.Features["upload_links"] || response.Features["embed"] {
+		t.Errorf("unexpected features: %+v", response)
+	}
+	if response.AbuseContact != "abuse@example.com" {
+		t.Errorf("Expected the abuse contact, got %q", response.AbuseContact)
+	}
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("API keys must not be exposed")
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		30 * time.Second:                "less than a minute",
+		15 * time.Minute:                "15m",
+		24 * time.Hour:                  "1d",
+		30*time.Hour + 5*time.Minute:    "1d 6h 5m",
+		7*24*time.Hour + 59*time.Second: "7d",
+	} {
+		if got := formatDuration(d); got != want {
+			t.Errorf("formatDuration(%s) = %q, want %q", d, got, want)
+		}
+	}
+}
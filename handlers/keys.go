@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/internal/audit"
+)
+
+// KeysHandler serves the admin API of the API keys. Keys are named by
+// their audit ID; the keys themselves are only returned once, when
+// created.
+type KeysHandler struct {
+	registry *apikeys.Registry
+	access   *access.Checker
+}
+
+// NewKeysHandler creates a new keys handler
+func NewKeysHandler(registry *apikeys.Registry, checker *access.Checker) *KeysHandler {
+	return &KeysHandler{registry: registry, access: checker}
+}
+
+// keyRequest is the body of a key creation or change.
+type keyRequest struct {
+	Scopes  []string `json:"scopes"`
+	MaxSize int64    `json:"max_size"`
+	Tenant  string   `json:"tenant"`
+}
+
+// Me handles GET /api/v1/admin/me, describing the key the request is made
+// with, so that the admin console can show what the key may do.
+func (h *KeysHandler) Me(c *gin.Context) {
+	key := access.APIKey(c)
+	scopes, _ := h.registry.Lookup(key)
+	c.JSON(http.StatusOK, gin.H{
+		"id":         audit.KeyID(key),
+		"scopes":     scopes,
+		"tenant":     h.access.Tenant(c),
+		"superadmin": h.access.SuperAdmin(c),
+		"keys_file":  h.registry.Editable(),
+	})
+}
+
+// List handles GET /api/v1/admin/keys, returning every key with the last
+// four characters of the key as a hint, and the quota of every tenant.
+func (h *KeysHandler) List(c *gin.Context) {
+	entries := h.registry.Entries()
+	keys := make([]gin.H, 0, len(entries))
+	for _, e := range entries {
+		keys = append(keys, keyResponse(e))
+	}
+	tenancy := h.registry.Tenancy()
+	quotas := gin.H{}
+	for _, tenant := range tenancy.Names() {
+		if quota, ok := tenancy.Quota(tenant); ok {
+			quotas[tenant] = quota
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys, "quotas": quotas, "keys_file": h.registry.Editable()})
+}
+
+// Create handles POST /api/v1/admin/keys, adding a random key to the keys
+// file. The response is the only place the key is ever shown.
+func (h *KeysHandler) Create(c *gin.Context) {
+	e, ok := h.bind(c)
+	if !ok {
+		return
+	}
+	key, err := h.registry.Create(e.Scopes, e.MaxSize, e.Tenant)
+	if err != nil {
+		h.fail(c, audit.ActionKeyCreate, err)
+		return
+	}
+	e.Key, e.InFile = key, true
+	audit.Record(c, audit.ActionKeyCreate, "", audit.ResultSuccess, keyDetail(e))
+	resp := keyResponse(e)
+	resp["key"] = key
+	c.JSON(http.StatusCreated, resp)
+}
+
+// Update handles PATCH /api/v1/admin/keys/:id, replacing the scopes, size
+// limit and tenant of a key of the keys file.
+func (h *KeysHandler) Update(c *gin.Context) {
+	key, ok := h.find(c)
+	if !ok {
+		return
+	}
+	e, ok := h.bind(c)
+	if !ok {
+		return
+	}
+	e.Key, e.InFile = key, true
+	if err := h.registry.Update(e); err != nil {
+		h.fail(c, audit.ActionKeyUpdate, err)
+		return
+	}
+	audit.Record(c, audit.ActionKeyUpdate, "", audit.ResultSuccess, keyDetail(e))
+	c.JSON(http.StatusOK, keyResponse(e))
+}
+
+// Revoke handles DELETE /api/v1/admin/keys/:id, removing a key from the
+// keys file. Requests made with it are refused from then on.
+func (h *KeysHandler) Revoke(c *gin.Context) {
+	key, ok := h.find(c)
+	if !ok {
+		return
+	}
+	if err := h.registry.Revoke(key); err != nil {
+		h.fail(c, audit.ActionKeyRevoke, err)
+		return
+	}
+	audit.Record(c, audit.ActionKeyRevoke, "", audit.ResultSuccess, audit.KeyID(key))
+	c.JSON(http.StatusOK, gin.H{"id": audit.KeyID(key), "revoked": true})
+}
+
+// SetQuota handles PUT /api/v1/admin/quotas/:tenant with a body of
+// {"quota": BYTES}, setting the storage quota of a tenant. A quota of 0
+// removes it.
+func (h *KeysHandler) SetQuota(c *gin.Context) {
+	tenant := c.Param("tenant")
+	var body struct {
+		Quota *int64 `json:"quota"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Quota == nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, `a body of {"quota": BYTES} is required`)
+		return
+	}
+	if err := h.registry.SetQuota(tenant, *body.Quota); err != nil {
+		h.fail(c, audit.ActionQuota, err)
+		return
+	}
+	audit.Record(c, audit.ActionQuota, "", audit.ResultSuccess, "tenant="+tenant+" quota="+strconv.FormatInt(*body.Quota, 10))
+	c.JSON(http.StatusOK, gin.H{"tenant": tenant, "quota": *body.Quota})
+}
+
+// find returns the key of the keys file named by the :id parameter,
+// refusing the key the request is made with, which would lock the admin
+// out mid-session.
+func (h *KeysHandler) find(c *gin.Context) (string, bool) {
+	if !h.registry.Editable() {
+		apierror.JSON(c, http.StatusNotImplemented, apierror.CodeUnsupported, "keys can only be managed with a keys file")
+		return "", false
+	}
+	id := c.Param("id")
+	for _, e := range h.registry.Entries() {
+		if audit.KeyID(e.Key) != id {
+			continue
+		}
+		if !e.InFile {
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "keys of NCLIP_API_KEYS cannot be changed")
+			return "", false
+		}
+		if e.Key == access.APIKey(c) {
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "the key making the request cannot be changed")
+			return "", false
+		}
+		return e.Key, true
+	}
+	apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "key not found")
+	return "", false
+}
+
+// bind parses the body of a key creation or change.
+func (h *KeysHandler) bind(c *gin.Context) (apikeys.Entry, bool) {
+	if !h.registry.Editable() {
+		apierror.JSON(c, http.StatusNotImplemented, apierror.CodeUnsupported, "keys can only be managed with a keys file")
+		return apikeys.Entry{}, false
+	}
+	var body keyRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "invalid JSON body")
+		return apikeys.Entry{}, false
+	}
+	if len(body.Scopes) == 0 {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "at least one scope is required")
+		return apikeys.Entry{}, false
+	}
+	scopes, err := apikeys.ParseScopes(strings.Join(body.Scopes, ","))
+	if err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, err.Error())
+		return apikeys.Entry{}, false
+	}
+	return apikeys.Entry{Scopes: scopes, MaxSize: body.MaxSize, Tenant: body.Tenant}, true
+}
+
+// fail records and answers a failed change of the keys file.
+func (h *KeysHandler) fail(c *gin.Context, action string, err error) {
+	audit.Record(c, action, "", audit.ResultFailure, err.Error())
+	switch {
+	case errors.Is(err, apikeys.ErrNoKeysFile):
+		apierror.JSON(c, http.StatusNotImplemented, apierror.CodeUnsupported, "keys can only be managed with a keys file")
+	case errors.Is(err, apikeys.ErrKeyNotFound):
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "key not found")
+	case errors.Is(err, apikeys.ErrInvalidEntry):
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, err.Error())
+	default:
+		log.Printf("[ERROR] Failed to update the API keys file: %v", err)
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to update the keys file")
+	}
+}
+
+// keyResponse describes e without the key itself.
+func keyResponse(e apikeys.Entry) gin.H {
+	resp := gin.H{
+		"id":      audit.KeyID(e.Key),
+		"hint":    keyHint(e.Key),
+		"scopes":  e.Scopes,
+		"in_file": e.InFile,
+	}
+	if e.MaxSize > 0 {
+		resp["max_size"] = e.MaxSize
+	}
+	if e.Tenant != "" {
+		resp["tenant"] = e.Tenant
+	}
+	return resp
+}
+
+// keyHint returns the last four characters of key, enough to tell keys
+// apart without disclosing them.
+func keyHint(key string) string {
+	if len(key) <= 8 {
+		return "…"
+	}
+	return "…" + key[len(key)-4:]
+}
+
+// keyDetail describes e for the audit log.
+func keyDetail(e apikeys.Entry) string {
+	detail := audit.KeyID(e.Key) + " scopes=" + e.Scopes.String()
+	if e.MaxSize > 0 {
+		detail += " max_size=" + strconv.FormatInt(e.MaxSize, 10)
+	}
+	if e.Tenant != "" {
+		detail += " tenant=" + e.Tenant
+	}
+	return detail
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/hmackeys"
+)
+
+func setupKeysRouter(t *testing.T, path string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	registry, err := apikeys.NewRegistry("root", path)
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	h := NewKeysHandler(registry, access.NewChecker(registry, hmackeys.New("secret", nil)))
+	router := gin.New()
+	router.GET("/api/v1/admin/keys", h.List)
+	router.POST("/api/v1/admin/keys", h.Create)
+	router.PATCH("/api/v1/admin/keys/:id", h.Update)
+	router.DELETE("/api/v1/admin/keys/:id", h.Revoke)
+	router.PUT("/api/v1/admin/quotas/:tenant", h.SetQuota)
+	return router
+}
+
+func TestKeysHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("ops-secret-key admin\nci-secret-key write\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	router := setupKeysRouter(t, path)
+
+	do := func(method, url, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("X-Api-Key", key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("GET", "/api/v1/admin/keys", "ops-secret-key", "")
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "secret-key") || !strings.Contains(w.Body.String(), audit.KeyID("ci-secret-key")) {
+		t.Errorf("list: expected key IDs without the keys, got %d %s", w.Code, w.Body.String())
+	}
+
+	cases := []struct {
+		name, method, url, body string
+		want                    int
+	}{
+		{"unknown scope", "POST", "/api/v1/admin/keys", `{"scopes":["root"]}`, http.StatusBadRequest},
+		{"no scopes", "POST", "/api/v1/admin/keys", `{}`, http.StatusBadRequest},
+		{"bad tenant", "POST", "/api/v1/admin/keys", `{"scopes":["read"],"tenant":"No Way"}`, http.StatusBadRequest},
+		{"unknown key", "DELETE", "/api/v1/admin/keys/key:000000000000", "", http.StatusNotFound},
+		{"listed key", "DELETE", "/api/v1/admin/keys/" + audit.KeyID("root"), "", http.StatusBadRequest},
+		{"own key", "DELETE", "/api/v1/admin/keys/" + audit.KeyID("ops-secret-key"), "", http.StatusBadRequest},
+		{"update", "PATCH", "/api/v1/admin/keys/" + audit.KeyID("ci-secret-key"), `{"scopes":["read"],"max_size":1024}`, http.StatusOK},
+		{"quota", "PUT", "/api/v1/admin/quotas/eng", `{"quota":1048576}`, http.StatusOK},
+		{"quota without a body", "PUT", "/api/v1/admin/quotas/eng", `{}`, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		if w := do(tc.method, tc.url, "ops-secret-key", tc.body); w.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.want, w.Code, w.Body.String())
+		}
+	}
+	if data, _ := os.ReadFile(path); string(data) != "ops-secret-key admin\nci-secret-key read max_size=1024\n@eng quota=1048576\n" {
+		t.Errorf("unexpected keys file %q", data)
+	}
+
+	// Without a keys file there is nothing to change.
+	router = setupKeysRouter(t, "")
+	if w := do("POST", "/api/v1/admin/keys", "root", `{"scopes":["read"]}`); w.Code != http.StatusNotImplemented {
+		t.Errorf("create without a keys file: expected 501, got %d", w.Code)
+	}
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// maxListLimit caps the page size accepted by the list API.
+const maxListLimit = 200
+
+// ListHandler handles the paste listing and bulk-delete admin API
+type ListHandler struct {
+	store  storage.PasteStore
+	access *access.Checker
+}
+
+// NewListHandler creates a new list handler
+func NewListHandler(store storage.PasteStore) *ListHandler {
+	return &ListHandler{
+		store: store,
+	}
+}
+
+// SetAccess sets the checker for private pastes. Without one, private
+// pastes are never listed.
+func (h *ListHandler) SetAccess(checker *access.Checker) {
+	h.access = checker
+}
+
+// List handles GET /api/v1/pastes?tag=&visibility=&source_repo=&quarantined=&cursor=&limit=.
+// It returns one page of paste metadata in slug order, plus the cursor for
+// the next page. Private pastes are only listed for the key that owns them,
+// quarantined ones for admin keys, and only pastes of the key's tenant are
+// listed.
+func (h *ListHandler) List(c *gin.Context) {
+	lister, ok := h.store.(storage.Lister)
+	if !ok {
+		apierror.JSON(c, http.StatusNotImplemented, apierror.CodeUnsupported, "Listing is not supported by this storage backend")
+		return
+	}
+	opts, ok := listOptions(c)
+	if !ok {
+		return
+	}
+	var visibility models.Visibility
+	if v := c.Query("visibility"); v != "" {
+		var err error
+		if visibility, err = models.ParseVisibility(v); err != nil {
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidVisibility, err.Error())
+			return
+		}
+	}
+
+	sourceRepo := c.Query("source_repo")
+	quarantined := c.Query("quarantined") == "true"
+
+	page, err := lister.List(opts)
+	if err != nil {
+		log.Printf("[ERROR] List: %v", err)
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to list pastes")
+		return
+	}
+
+	// Tag indexes may briefly reference expired or re-tagged pastes, so
+	// re-check metadata before returning an entry.
+	results := storage.GetBatch(h.store, page.IDs)
+	pastes := make([]gin.H, 0, len(page.IDs))
+	for _, id := range page.IDs {
+		paste := results[id].Paste
+		if paste == nil || (opts.Tag != "" && !paste.HasTag(opts.Tag)) {
+			continue
+		}
+		if (visibility != "" && paste.VisibilityLevel() != visibility) || !h.access.CanRead(c, paste) || !h.access.InTenant(c, paste) {
+			continue
+		}
+		if (sourceRepo != "" && paste.Source[models.SourceRepo] != sourceRepo) || (quarantined && !paste.Quarantined) {
+			continue
+		}
+		resp := metadataResponse(paste)
+		addAtRest(resp, h.store, id)
+		if paste.Tenant != "" {
+			resp["tenant"] = paste.Tenant
+		}
+		if paste.Owner != "" {
+			resp["owner"] = paste.Owner
+		}
+		pastes = append(pastes, resp)
+	}
+	detail := "tag=" + opts.Tag
+	if visibility != "" {
+		detail += " visibility=" + string(visibility)
+	}
+	if sourceRepo != "" {
+		detail += " source_repo=" + sourceRepo
+	}
+	if quarantined {
+		detail += " quarantined=true"
+	}
+	audit.Record(c, audit.ActionAdminList, "", audit.ResultSuccess, detail)
+	c.JSON(http.StatusOK, gin.H{"pastes": pastes, "next_cursor": page.NextCursor})
+}
+
+// DeleteByTag handles DELETE /api/v1/pastes?tag=<tag>, deleting every paste
+// carrying the tag except those under legal hold. Pastes of other tenants
+// are left alone.
+func (h *ListHandler) DeleteByTag(c *gin.Context) {
+	lister, ok := h.store.(storage.Lister)
+	if !ok {
+		apierror.JSON(c, http.StatusNotImplemented, apierror.CodeUnsupported, "Listing is not supported by this storage backend")
+		return
+	}
+	tag := c.Query("tag")
+	if !utils.IsValidTag(tag) {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidTags, "A valid tag query parameter is required")
+		return
+	}
+
+	// List every matching paste first, then delete them in one batch.
+	var ids []string
+	held := 0
+	opts := storage.ListOptions{Tag: tag, Limit: maxListLimit}
+	for {
+		page, err := lister.List(opts)
+		if err != nil {
+			log.Printf("[ERROR] DeleteByTag: failed to list tag %q: %v", tag, err)
+			audit.Record(c, audit.ActionAdminDeleteTag, "", audit.ResultFailure, fmt.Sprintf("tag=%s: %v", tag, err))
+			apierror.JSONDetail(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to list pastes",
+				"no pastes were deleted")
+			return
+		}
+		results := storage.GetBatch(h.store, page.IDs)
+		for _, id := range page.IDs {
+			paste := results[id].Paste
+			if paste == nil || !paste.HasTag(tag) || !h.access.InTenant(c, paste) {
+				continue
+			}
+			if paste.LegalHold {
+				audit.Record(c, audit.ActionDelete, id, audit.ResultFailure, "legal hold")
+				held++
+				continue
+			}
+			ids = append(ids, id)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+
+	deleted := 0
+	errs := storage.DeleteBatch(h.store, ids, storage.LogDeleteProgress("DeleteByTag "+tag))
+	for _, id := range ids {
+		if err, failed := errs[id]; failed {
+			log.Printf("[ERROR] DeleteByTag: failed to delete %s: %v", id, err)
+			audit.Record(c, audit.ActionDelete, id, audit.ResultFailure, err.Error())
+			continue
+		}
+		audit.Record(c, audit.ActionDelete, id, audit.ResultSuccess, "tag="+tag)
+		deleted++
+	}
+	audit.Record(c, audit.ActionAdminDeleteTag, "", audit.ResultSuccess, fmt.Sprintf("tag=%s deleted=%d held=%d", tag, deleted, held))
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted, "held": held, "tag": tag})
+}
+
+// listOptions parses and validates the list query parameters, writing an
+// error response and returning false when they are invalid.
+func listOptions(c *gin.Context) (storage.ListOptions, bool) {
+	opts := storage.ListOptions{Tag: c.Query("tag"), Cursor: c.Query("cursor"), Limit: storage.DefaultListLimit}
+	if opts.Tag != "" && !utils.IsValidTag(opts.Tag) {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidTags, "Invalid tag")
+		return opts, false
+	}
+	if opts.Cursor != "" && !utils.IsValidSlug(opts.Cursor) {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "Invalid cursor")
+		return opts, false
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxListLimit {
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest,
+				"limit must be between 1 and "+strconv.Itoa(maxListLimit))
+			return opts, false
+		}
+		opts.Limit = n
+	}
+	return opts, true
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/hmackeys"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func setupListRouter(t *testing.T) (*gin.Engine, storage.PasteStore) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystemStore failed: %v", err)
+	}
+	h := NewListHandler(store)
+	h.SetAccess(access.NewChecker(apikeys.Parse("alice,bob"), hmackeys.New("secret", nil)))
+	router := gin.New()
+	router.GET("/api/v1/pastes", h.List)
+	router.DELETE("/api/v1/pastes", h.DeleteByTag)
+	return router, store
+}
+
+func TestListHandler_ListByTag(t *testing.T) {
+	router, store := setupListRouter(t)
+	_ = store.Store(&models.Paste{ID: "AAAAA", Tags: []string{"work"}})
+	_ = store.Store(&models.Paste{ID: "BBBBB", Tags: []string{"work"}})
+	_ = store.Store(&models.Paste{ID: "CCCCC", Tags: []string{"home"}})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/pastes?tag=work&limit=1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Pastes     []map[string]interface{} `json:"pastes"`
+		NextCursor string                   `json:"next_cursor"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(resp.Pastes) != 1 || resp.Pastes[0]["id"] != "AAAAA" || resp.NextCursor != "AAAAA" {
+		t.Fatalf("unexpected first page: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/pastes?tag=work&cursor=AAAAA", nil))
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(resp.Pastes) != 1 || resp.Pastes[0]["id"] != "BBBBB" || resp.NextCursor != "" {
+		t.Fatalf("unexpected second page: %s", w.Body.String())
+	}
+}
+
+func TestListHandler_Visibility(t *testing.T) {
+	router, store := setupListRouter(t)
+	_ = store.Store(&models.Paste{ID: "AAAAA", Visibility: models.VisibilityPublic})
+	_ = store.Store(&models.Paste{ID: "BBBBB"})
+	_ = store.Store(&models.Paste{ID: "CCCCC", Visibility: models.VisibilityPrivate, Owner: audit.KeyID("alice")})
+
+	list := func(query, key string) []string {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/v1/pastes"+query, nil)
+		req.Header.Set("X-Api-Key", key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected 200, got %d: %s", query, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Pastes []map[string]interface{} `json:"pastes"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		var ids []string
+		for _, p := range resp.Pastes {
+			ids = append(ids, p["id"].(string)+":"+p["visibility"].(string))
+		}
+		return ids
+	}
+
+	if got := fmt.Sprint(list("", "bob")); got != "[AAAAA:public BBBBB:unlisted]" {
+		t.Errorf("expected another key not to see the private paste, got %s", got)
+	}
+	if got := fmt.Sprint(list("", "alice")); got != "[AAAAA:public BBBBB:unlisted CCCCC:private]" {
+		t.Errorf("expected the owner to see the private paste, got %s", got)
+	}
+	if got := fmt.Sprint(list("?visibility=public", "alice")); got != "[AAAAA:public]" {
+		t.Errorf("expected only public pastes, got %s", got)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/pastes?visibility=secret", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid visibility, got %d", w.Code)
+	}
+}
+
+func TestListHandler_SourceRepo(t *testing.T) {
+	router, store := setupListRouter(t)
+	_ = store.Store(&models.Paste{ID: "AAAAA", Source: map[string]string{models.SourceRepo: "org/app"}})
+	_ = store.Store(&models.Paste{ID: "BBBBB", Source: map[string]string{models.SourceRepo: "org/other"}})
+	_ = store.Store(&models.Paste{ID: "CCCCC"})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/pastes?source_repo=org/app", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Pastes []map[string]interface{} `json:"pastes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(resp.Pastes) != 1 || resp.Pastes[0]["id"] != "AAAAA" {
+		t.Fatalf("expected only AAAAA, got %s", w.Body.String())
+	}
+}
+
+func TestListHandler_Quarantined(t *testing.T) {
+	router, store := setupListRouter(t)
+	h := NewListHandler(store)
+	h.SetAccess(access.NewChecker(apikeys.Keys{"ops": {apikeys.ScopeAdmin}, "dev": {apikeys.ScopeRead}}, hmackeys.New("secret", nil)))
+	router = gin.New()
+	router.GET("/api/v1/pastes", h.List)
+	_ = store.Store(&models.Paste{ID: "AAAAA", Quarantined: true})
+	_ = store.Store(&models.Paste{ID: "BBBBB"})
+
+	list := func(query, key string) string {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/v1/pastes"+query, nil)
+		req.Header.Set("X-Api-Key", key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp struct {
+			Pastes []map[string]interface{} `json:"pastes"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		var ids []string
+		for _, p := range resp.Pastes {
+			ids = append(ids, p["id"].(string))
+		}
+		return fmt.Sprint(ids)
+	}
+
+	if got := list("", "dev"); got != "[BBBBB]" {
+		t.Errorf("expected a read key not to see the quarantined paste, got %s", got)
+	}
+	if got := list("", "ops"); got != "[AAAAA BBBBB]" {
+		t.Errorf("expected an admin key to see every paste, got %s", got)
+	}
+	if got := list("?quarantined=true", "ops"); got != "[AAAAA]" {
+		t.Errorf("expected only the quarantined paste, got %s", got)
+	}
+}
+
+func TestListHandler_InvalidParams(t *testing.T) {
+	router, _ := setupListRouter(t)
+	for _, url := range []string{
+		"/api/v1/pastes?tag=Bad%20Tag",
+		"/api/v1/pastes?limit=0",
+		"/api/v1/pastes?limit=1000",
+		"/api/v1/pastes?cursor=../x",
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", url, w.Code)
+		}
+	}
+}
+
+func TestListHandler_Unsupported(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewListHandler(NewMockPasteStore())
+	router := gin.New()
+	router.GET("/api/v1/pastes", h.List)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/pastes", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("expected 501, got %d", w.Code)
+	}
+}
+
+func TestListHandler_DeleteByTag(t *testing.T) {
+	router, store := setupListRouter(t)
+	_ = store.Store(&models.Paste{ID: "AAAAA", Tags: []string{"tmp"}})
+	_ = store.Store(&models.Paste{ID: "BBBBB", Tags: []string{"tmp", "keep"}})
+	_ = store.Store(&models.Paste{ID: "CCCCC", Tags: []string{"keep"}})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v1/pastes?tag=tmp", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp["deleted"] != float64(2) {
+		t.Errorf("expected 2 deleted, got %v", resp["deleted"])
+	}
+	if exists, _ := store.Exists("CCCCC"); !exists {
+		t.Error("expected untagged paste to survive")
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v1/pastes", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without tag, got %d", w.Code)
+	}
+}
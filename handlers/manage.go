@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/forwarded"
+	"github.com/johnwmail/nclip/internal/push"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/internal/session"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// ManageHandler changes and deletes pastes, either through the admin API or
+// from the self-service page the uploader reaches with the manage URL
+// returned at creation.
+type ManageHandler struct {
+	service *services.PasteService
+	access  *access.Checker
+	config  *config.Config
+	// ui provides the request scheme detection shared with the web UI.
+	ui *WebUIHandler
+	// push holds browser subscriptions to the paste; nil disables them.
+	push *push.Notifier
+}
+
+// NewManageHandler creates a new manage handler
+func NewManageHandler(service *services.PasteService, checker *access.Checker, config *config.Config) *ManageHandler {
+	return &ManageHandler{
+		service: service,
+		access:  checker,
+		config:  config,
+		ui:      NewWebUIHandler(config),
+	}
+}
+
+// updateRequest is the JSON body of PATCH /api/v1/pastes/:slug and
+// POST /manage/:slug. Omitted fields are left unchanged.
+type updateRequest struct {
+	// TTL sets the expiry to now plus TTL (1h to 7d).
+	TTL           string  `json:"ttl"`
+	BurnAfterRead *bool   `json:"burn_after_read"`
+	Visibility    *string `json:"visibility"`
+}
+
+// parse converts r into a service request.
+func (r updateRequest) parse() (services.UpdatePasteRequest, apierror.Code, error) {
+	var req services.UpdatePasteRequest
+	if r.TTL != "" {
+		d, err := time.ParseDuration(r.TTL)
+		if err != nil || d < config.MinTTL || d > config.MaxTTL {
+			return req, apierror.CodeInvalidTTL, fmt.Errorf("ttl must be between 1h and 7d")
+		}
+		req.TTL = &d
+	}
+	req.BurnAfterRead = r.BurnAfterRead
+	if r.Visibility != nil {
+		v, err := models.ParseVisibility(*r.Visibility)
+		if err != nil {
+			return req, apierror.CodeInvalidVisibility, err
+		}
+		req.Visibility = &v
+	}
+	return req, "", nil
+}
+
+// Update handles PATCH /api/v1/pastes/:slug, changing a paste's expiry,
+// burn-after-read flag or visibility.
+func (h *ManageHandler) Update(c *gin.Context) {
+	h.update(c, c.Param("slug"), true)
+}
+
+// Page handles GET /manage/:slug?token=, the page where the uploader can
+// extend, reconfigure or delete a paste without an API key.
+func (h *ManageHandler) Page(c *gin.Context) {
+	slug := c.Param("slug")
+	paste, ok := h.authorize(c, slug)
+	if !ok {
+		apierror.HTML(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found or manage link invalid", h.pageData(c))
+		return
+	}
+	data := h.pageData(c)
+	data["Title"] = "NCLIP - Manage " + slug
+	data["Paste"] = paste
+	data["Token"] = c.Query("token")
+	data["CSRFToken"] = session.CSRFToken(c)
+	data["CanBePrivate"] = paste.Owner != ""
+	data["Push"] = h.push != nil
+	c.HTML(http.StatusOK, "manage.html", data)
+}
+
+// ManageUpdate handles POST /manage/:slug?token=, the page's save action.
+// The body is the same as for Update.
+func (h *ManageHandler) ManageUpdate(c *gin.Context) {
+	if !h.authorizeAction(c) {
+		return
+	}
+	h.update(c, c.Param("slug"), false)
+}
+
+// ManageDelete handles DELETE /manage/:slug?token=, the page's delete
+// action. Pastes under legal hold are kept.
+func (h *ManageHandler) ManageDelete(c *gin.Context) {
+	slug := c.Param("slug")
+	if !h.authorizeAction(c) {
+		return
+	}
+	if err := h.service.RemovePaste(slug); err != nil {
+		if errors.Is(err, services.ErrLegalHold) {
+			audit.Record(c, audit.ActionDelete, slug, audit.ResultFailure, "legal hold")
+			apierror.JSON(c, http.StatusConflict, apierror.CodeLegalHold, "Paste is under legal hold")
+			return
+		}
+		log.Printf("[ERROR] ManageDelete: failed to delete %s: %v", slug, err)
+		audit.Record(c, audit.ActionDelete, slug, audit.ResultFailure, err.Error())
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to delete paste")
+		return
+	}
+	audit.Record(c, audit.ActionDelete, slug, audit.ResultSuccess, "manage")
+	c.JSON(http.StatusOK, gin.H{"deleted": true, "slug": slug})
+}
+
+// update applies the JSON body to slug and responds with its metadata.
+// admin reports whether the request was authorized with an admin key,
+// which may only change pastes of its tenant.
+func (h *ManageHandler) update(c *gin.Context, slug string, admin bool) {
+	if !utils.IsValidSlug(slug) {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidSlug, "Invalid slug format")
+		return
+	}
+	var body updateRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "invalid JSON body")
+		return
+	}
+	req, code, err := body.parse()
+	if err != nil {
+		apierror.JSON(c, http.StatusBadRequest, code, err.Error())
+		return
+	}
+
+	before, err := h.service.GetPaste(slug)
+	if err != nil || admin && !h.access.InTenant(c, before) {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found")
+		return
+	}
+	paste, err := h.service.UpdatePaste(slug, req)
+	switch {
+	case errors.Is(err, services.ErrNoOwner):
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidVisibility, err.Error())
+		return
+	case errors.Is(err, services.ErrBurnAppendable):
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, err.Error())
+		return
+	case errors.Is(err, services.ErrLegalHold):
+		audit.Record(c, audit.ActionUpdate, slug, audit.ResultFailure, "legal hold")
+		apierror.JSON(c, http.StatusConflict, apierror.CodeLegalHold, "Paste is under legal hold")
+		return
+	case err != nil:
+		log.Printf("[ERROR] Update: failed to update %s: %v", slug, err)
+		audit.Record(c, audit.ActionUpdate, slug, audit.ResultFailure, err.Error())
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to update paste")
+		return
+	}
+	audit.Record(c, audit.ActionUpdate, slug, audit.ResultSuccess, body.detail())
+	resp := metadataResponse(paste)
+	if paste.BurnAfterRead && !before.BurnAfterRead {
+		// The new burn link is only handed out here, as at creation.
+		resp["burn_url"] = h.baseURL(c) + h.config.Path("/b/"+slug) + "#k=" + paste.BurnToken
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// detail summarizes the changed settings for the audit log.
+func (r updateRequest) detail() string {
+	detail := ""
+	if r.TTL != "" {
+		detail += " ttl=" + r.TTL
+	}
+	if r.BurnAfterRead != nil {
+		detail += fmt.Sprintf(" burn_after_read=%t", *r.BurnAfterRead)
+	}
+	if r.Visibility != nil {
+		detail += " visibility=" + *r.Visibility
+	}
+	if detail == "" {
+		return ""
+	}
+	return detail[1:]
+}
+
+// authorize loads slug and checks the manage token in the query.
+func (h *ManageHandler) authorize(c *gin.Context, slug string) (*models.Paste, bool) {
+	if !utils.IsValidSlug(slug) {
+		return nil, false
+	}
+	paste, err := h.service.GetPaste(slug)
+	if err != nil || !h.access.VerifyManage(paste, c.Query("token")) {
+		return nil, false
+	}
+	c.Header("Cache-Control", "no-store")
+	// The token is in the page URL; keep it out of Referer headers.
+	c.Header("Referrer-Policy", "no-referrer")
+	return paste, true
+}
+
+// authorizeAction checks the manage token and that the request came from
+// the manage page: it must carry the session cookie and CSRF token, so
+// another site cannot replay a leaked manage URL through the user's
+// browser. It writes the error response and returns false on failure.
+func (h *ManageHandler) authorizeAction(c *gin.Context) bool {
+	if !session.Verified(c) {
+		apierror.JSON(c, http.StatusForbidden, apierror.CodeCSRFInvalid, "invalid or missing CSRF token")
+		return false
+	}
+	if _, ok := h.authorize(c, c.Param("slug")); !ok {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found or manage link invalid")
+		return false
+	}
+	audit.SetActor(c, "manage")
+	return true
+}
+
+// baseURL returns the scheme, host and proxy path prefix of the request.
+func (h *ManageHandler) baseURL(c *gin.Context) string {
+	scheme := "http"
+	if h.ui.isHTTPS(c) {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, c.Request.Host, forwarded.PathPrefix(c))
+}
+
+// pageData returns the template fields shared by every page.
+func (h *ManageHandler) pageData(c *gin.Context) gin.H {
+	return gin.H{
+		"Version":    h.config.Version,
+		"BuildTime":  h.config.BuildTime,
+		"CommitHash": h.config.CommitHash,
+		"BaseURL":    h.baseURL(c) + h.config.RoutePrefix,
+		"UploadAuth": h.config.UploadAuth,
+	}
+}
@@ -3,47 +3,146 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"sync"
 
 	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/models"
 	"github.com/johnwmail/nclip/storage"
 	"github.com/johnwmail/nclip/utils"
 )
 
 // MetaHandler handles metadata operations
 type MetaHandler struct {
-	store storage.PasteStore
+	store  storage.PasteStore
+	access *access.Checker
+	// lock is held while a flag is changed; see SetContentLock.
+	lock sync.Locker
 }
 
 // NewMetaHandler creates a new metadata handler
 func NewMetaHandler(store storage.PasteStore) *MetaHandler {
 	return &MetaHandler{
 		store: store,
+		lock:  &sync.Mutex{},
 	}
 }
 
+// SetContentLock makes the handler hold l while it changes a paste's
+// flags, so it does not write back metadata read before a content
+// replacement or append that holds l.
+func (h *MetaHandler) SetContentLock(l sync.Locker) {
+	h.lock = l
+}
+
+// SetAccess sets the checker for private pastes. Without one, private
+// pastes are reported as not found.
+func (h *MetaHandler) SetAccess(checker *access.Checker) {
+	h.access = checker
+}
+
 // GetMetadata handles metadata retrieval via GET /api/v1/meta/:slug and GET /json/:slug
 func (h *MetaHandler) GetMetadata(c *gin.Context) {
 	slug := c.Param("slug")
 
 	if !utils.IsValidSlug(slug) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid slug format"})
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidSlug, "Invalid slug format")
 		return
 	}
 
 	paste, err := h.store.Get(slug)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve paste"})
+		if errors.Is(err, storage.ErrNotFound) {
+			apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found")
+			return
+		}
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to retrieve paste")
 		return
 	}
 
-	if paste == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Paste not found"})
+	if paste == nil || !h.access.CanRead(c, paste) {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found")
 		return
 	}
 
 	// Return metadata without content, pretty-printed JSON
-	response := gin.H{
+	response := metadataResponse(paste)
+	addAtRest(response, h.store, paste.ID)
+
+	jsonBytes, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to marshal JSON")
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", jsonBytes)
+}
+
+// maxBatchSlugs is the largest number of slugs accepted by GetMetadataBatch.
+const maxBatchSlugs = 100
+
+// batchRequest is the JSON body accepted by POST /api/v1/meta/batch.
+type batchRequest struct {
+	Slugs []string `json:"slugs"`
+}
+
+// GetMetadataBatch handles bulk metadata retrieval via POST /api/v1/meta/batch.
+// The response maps each requested slug to its metadata, or to an object
+// with an "error" code ("invalid_slug", "not_found" or "internal_error").
+func (h *MetaHandler) GetMetadataBatch(c *gin.Context) {
+	var req batchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "Invalid JSON body")
+		return
+	}
+	if len(req.Slugs) == 0 {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "No slugs provided")
+		return
+	}
+	if len(req.Slugs) > maxBatchSlugs {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, fmt.Sprintf("Too many slugs: maximum is %d", maxBatchSlugs))
+		return
+	}
+
+	pastes := make(map[string]gin.H, len(req.Slugs))
+	var valid []string
+	for _, slug := range req.Slugs {
+		if _, seen := pastes[slug]; seen {
+			continue
+		}
+		if !utils.IsValidSlug(slug) {
+			pastes[slug] = gin.H{"error": apierror.CodeInvalidSlug}
+			continue
+		}
+		pastes[slug] = nil
+		valid = append(valid, slug)
+	}
+
+	for slug, r := range storage.GetBatch(h.store, valid) {
+		switch {
+		case r.Err == nil && h.access.CanRead(c, r.Paste):
+			pastes[slug] = metadataResponse(r.Paste)
+		case r.Err == nil, errors.Is(r.Err, storage.ErrNotFound):
+			pastes[slug] = gin.H{"error": apierror.CodeNotFound}
+		default:
+			pastes[slug] = gin.H{"error": apierror.CodeInternal}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"pastes": pastes})
+}
+
+// metadataResponse builds the public metadata representation of a paste.
+func metadataResponse(paste *models.Paste) gin.H {
+	tags := paste.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	resp := gin.H{
 		"id":              paste.ID,
 		"created_at":      paste.CreatedAt,
 		"expires_at":      paste.ExpiresAt,
@@ -51,14 +150,120 @@ func (h *MetaHandler) GetMetadata(c *gin.Context) {
 		"content_type":    paste.ContentType,
 		"burn_after_read": paste.BurnAfterRead,
 		"read_count":      paste.ReadCount,
+		"views":           paste.Views,
+		"raw_reads":       paste.RawReads,
+		"downloads":       paste.Downloads,
+		"tags":            tags,
+		"pinned":          paste.Pinned,
+		"legal_hold":      paste.LegalHold,
+		"visibility":      paste.VisibilityLevel(),
+		"filename":        paste.Filename,
+		"encoding":        paste.Encoding,
+		"version":         paste.CurrentVersion(),
+		"appendable":      paste.Appendable,
+	}
+	if paste.SHA256 != "" {
+		resp["sha256"] = paste.SHA256
+	}
+	if paste.Origin != nil {
+		resp["origin"] = paste.Origin
+	}
+	if len(paste.Source) > 0 {
+		resp["source"] = paste.Source
+	}
+	if paste.FileMtime != nil {
+		resp["file_mtime"] = paste.FileMtime
+	}
+	if paste.Quarantined {
+		resp["quarantined"] = true
 	}
+	return resp
+}
 
-	jsonBytes, err := json.MarshalIndent(response, "", "  ")
+// addAtRest adds how the store keeps the paste's content to a metadata
+// response. It is left out when the store cannot tell, e.g. because the
+// content is missing.
+func addAtRest(resp gin.H, store storage.PasteStore, id string) {
+	rest, err := storage.ContentAtRest(store, id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to marshal JSON"})
+		log.Printf("[WARN] Failed to read at-rest details of %s: %v", id, err)
 		return
 	}
-	c.Data(http.StatusOK, "application/json; charset=utf-8", jsonBytes)
+	resp["at_rest"] = rest
+}
+
+// Pin handles POST /api/v1/pastes/:slug/pin, exempting a paste from
+// expiry until it is unpinned.
+func (h *MetaHandler) Pin(c *gin.Context) {
+	h.setFlag(c, audit.ActionPin, func(p *models.Paste) *bool { return &p.Pinned }, true)
+}
+
+// Unpin handles DELETE /api/v1/pastes/:slug/pin. The paste's original
+// expiry applies again, so a paste pinned past it expires immediately.
+func (h *MetaHandler) Unpin(c *gin.Context) {
+	h.setFlag(c, audit.ActionUnpin, func(p *models.Paste) *bool { return &p.Pinned }, false)
+}
+
+// Hold handles POST /api/v1/pastes/:slug/hold, placing a paste under legal
+// hold: it cannot expire, burn or be deleted until the hold is released.
+func (h *MetaHandler) Hold(c *gin.Context) {
+	h.setFlag(c, audit.ActionHold, func(p *models.Paste) *bool { return &p.LegalHold }, true)
+}
+
+// Release handles DELETE /api/v1/pastes/:slug/hold. As with Unpin, the
+// original expiry applies again.
+func (h *MetaHandler) Release(c *gin.Context) {
+	h.setFlag(c, audit.ActionRelease, func(p *models.Paste) *bool { return &p.LegalHold }, false)
+}
+
+// Quarantine handles POST /api/v1/pastes/:slug/quarantine, hiding a paste
+// from everyone but admin keys until it is released or deleted.
+func (h *MetaHandler) Quarantine(c *gin.Context) {
+	h.setFlag(c, audit.ActionQuarantine, func(p *models.Paste) *bool { return &p.Quarantined }, true)
+}
+
+// Unquarantine handles DELETE /api/v1/pastes/:slug/quarantine.
+func (h *MetaHandler) Unquarantine(c *gin.Context) {
+	h.setFlag(c, audit.ActionUnquarantine, func(p *models.Paste) *bool { return &p.Quarantined }, false)
+}
+
+// setFlag sets the boolean field of the paste returned by field to value,
+// records action in the audit log and responds with the paste's metadata.
+func (h *MetaHandler) setFlag(c *gin.Context, action string, field func(*models.Paste) *bool, value bool) {
+	slug := c.Param("slug")
+
+	if !utils.IsValidSlug(slug) {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidSlug, "Invalid slug format")
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	paste, err := h.store.Get(slug)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found")
+			return
+		}
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to retrieve paste")
+		return
+	}
+	if paste == nil || !h.access.InTenant(c, paste) {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found")
+		return
+	}
+
+	if flag := field(paste); *flag != value {
+		*flag = value
+		if err := h.store.Store(paste); err != nil {
+			audit.Record(c, action, slug, audit.ResultFailure, err.Error())
+			apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to update paste")
+			return
+		}
+	}
+	audit.Record(c, action, slug, audit.ResultSuccess, "")
+
+	c.JSON(http.StatusOK, metadataResponse(paste))
 }
 
 // DeletePaste handles paste deletion via DELETE /:slug
@@ -66,29 +271,36 @@ func (h *MetaHandler) DeletePaste(c *gin.Context) {
 	slug := c.Param("slug")
 
 	if !utils.IsValidSlug(slug) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid slug format"})
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidSlug, "Invalid slug format")
 		return
 	}
 
 	paste, err := h.store.Get(slug)
 	if err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Paste not found"})
+			apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve paste"})
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to retrieve paste")
 		return
 	}
 
-	if paste == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Paste not found"})
+	if paste == nil || !h.access.InTenant(c, paste) {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found")
+		return
+	}
+	if paste.LegalHold {
+		audit.Record(c, audit.ActionDelete, slug, audit.ResultFailure, "legal hold")
+		apierror.JSON(c, http.StatusConflict, apierror.CodeLegalHold, "Paste is under legal hold")
 		return
 	}
 
 	if err := h.store.Delete(slug); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete paste"})
+		audit.Record(c, audit.ActionDelete, slug, audit.ResultFailure, err.Error())
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to delete paste")
 		return
 	}
+	audit.Record(c, audit.ActionDelete, slug, audit.ResultSuccess, "")
 
 	c.JSON(http.StatusOK, gin.H{"deleted": true, "slug": slug})
 }
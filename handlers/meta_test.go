@@ -5,92 +5,35 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/internal/hmackeys"
 	"github.com/johnwmail/nclip/models"
 	"github.com/johnwmail/nclip/storage"
 )
 
-// MockPasteStore implements storage.PasteStore for testing
+// MockPasteStore is an in-memory storage.PasteStore whose Get can be made
+// to fail. Only the PasteStore methods are exposed, so it cannot list.
 type MockPasteStore struct {
-	pastes  map[string]*models.Paste
-	getErr  error
-	content map[string][]byte
+	storage.PasteStore
+	getErr error
 }
 
 func NewMockPasteStore() *MockPasteStore {
-	return &MockPasteStore{
-		pastes:  make(map[string]*models.Paste),
-		content: make(map[string][]byte),
-	}
-}
-
-// StoreContent saves the raw content for a paste
-func (m *MockPasteStore) StoreContent(id string, content []byte) error {
-	m.content[id] = content
-	return nil
-}
-
-// GetContent retrieves the raw content for a paste
-func (m *MockPasteStore) GetContent(id string) ([]byte, error) {
-	c, ok := m.content[id]
-	if !ok {
-		return nil, nil
-	}
-	return c, nil
-}
-
-func (m *MockPasteStore) Store(paste *models.Paste) error {
-	m.pastes[paste.ID] = paste
-	return nil
+	return &MockPasteStore{PasteStore: storage.NewMemoryStore()}
 }
 
 func (m *MockPasteStore) Get(id string) (*models.Paste, error) {
 	if m.getErr != nil {
 		return nil, m.getErr
 	}
-	return m.pastes[id], nil
-}
-
-func (m *MockPasteStore) Exists(id string) (bool, error) {
-	_, exists := m.pastes[id]
-	return exists, nil
-}
-
-func (m *MockPasteStore) Delete(id string) error {
-	delete(m.pastes, id)
-	return nil
-}
-
-func (m *MockPasteStore) IncrementReadCount(id string) error {
-	if paste := m.pastes[id]; paste != nil {
-		paste.ReadCount++
-	}
-	return nil
-}
-
-func (m *MockPasteStore) Close() error {
-	return nil
-}
-
-func (m *MockPasteStore) GetContentPrefix(id string, n int64) ([]byte, error) {
-	c, ok := m.content[id]
-	if !ok {
-		return nil, nil
-	}
-	if int64(len(c)) <= n {
-		return c, nil
-	}
-	return c[:n], nil
-}
-
-func (m *MockPasteStore) StatContent(id string) (bool, int64, error) {
-	if c, ok := m.content[id]; ok {
-		return true, int64(len(c)), nil
-	}
-	return false, 0, nil
+	return m.PasteStore.Get(id)
 }
 
 func (m *MockPasteStore) SetGetError(err error) {
@@ -339,3 +282,252 @@ func TestMetaHandler_DeletePaste(t *testing.T) {
 		})
 	}
 }
+
+func TestMetaHandler_GetMetadataBatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store := NewMockPasteStore()
+	if err := store.Store(&models.Paste{ID: "ABC23", CreatedAt: time.Now(), Size: 3, ContentType: "text/plain"}); err != nil {
+		t.Fatalf("failed to seed store: %v", err)
+	}
+	if err := store.Store(&models.Paste{ID: "PRV23", CreatedAt: time.Now(), Visibility: models.VisibilityPrivate, Owner: "key:abcdef"}); err != nil {
+		t.Fatalf("failed to seed store: %v", err)
+	}
+	handler := NewMetaHandler(store)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/meta/batch",
+		strings.NewReader(`{"slugs":["ABC23","XYZ89","PRV23","bad!"]}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	handler.GetMetadataBatch(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var response struct {
+		Pastes map[string]map[string]interface{} `json:"pastes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response.Pastes["ABC23"]["id"] != "ABC23" {
+		t.Errorf("Expected metadata for ABC23, got %v", response.Pastes["ABC23"])
+	}
+	if response.Pastes["XYZ89"]["error"] != "not_found" {
+		t.Errorf("Expected not_found for XYZ89, got %v", response.Pastes["XYZ89"])
+	}
+	if response.Pastes["PRV23"]["error"] != "not_found" {
+		t.Errorf("Expected private PRV23 to be reported as not_found, got %v", response.Pastes["PRV23"])
+	}
+	if response.Pastes["bad!"]["error"] != "invalid_slug" {
+		t.Errorf("Expected invalid_slug for bad!, got %v", response.Pastes["bad!"])
+	}
+}
+
+func TestMetaHandler_GetMetadataBatch_TooMany(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	slugs := make([]string, maxBatchSlugs+1)
+	for i := range slugs {
+		slugs[i] = "ABC23"
+	}
+	body, _ := json.Marshal(map[string][]string{"slugs": slugs})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/meta/batch", strings.NewReader(string(body)))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	NewMetaHandler(NewMockPasteStore()).GetMetadataBatch(c)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestMetaHandler_Pin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystemStore: %v", err)
+	}
+	handler := NewMetaHandler(store)
+	router := gin.New()
+	router.POST("/api/v1/pastes/:slug/pin", handler.Pin)
+	router.DELETE("/api/v1/pastes/:slug/pin", handler.Unpin)
+	router.GET("/api/v1/meta/:slug", handler.GetMetadata)
+
+	expires := time.Now().Add(time.Hour)
+	if err := store.Store(&models.Paste{ID: "PNME2", CreatedAt: time.Now(), ExpiresAt: &expires}); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	do := func(method, path string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		var body map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	if code, _ := do(http.MethodPost, "/api/v1/pastes/NXSTS/pin"); code != http.StatusNotFound {
+		t.Errorf("pin missing paste: expected 404, got %d", code)
+	}
+	if code, body := do(http.MethodPost, "/api/v1/pastes/PNME2/pin"); code != http.StatusOK || body["pinned"] != true {
+		t.Fatalf("pin: expected 200 with pinned=true, got %d %v", code, body)
+	}
+	if _, body := do(http.MethodGet, "/api/v1/meta/PNME2"); body["pinned"] != true {
+		t.Errorf("meta: expected pinned=true, got %v", body)
+	}
+
+	// A pinned paste outlives its expiry.
+	paste, _ := store.Get("PNME2")
+	past := time.Now().Add(-time.Minute)
+	paste.ExpiresAt = &past
+	if err := store.Store(paste); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if _, err := store.Get("PNME2"); err != nil {
+		t.Fatalf("expected pinned paste to survive expiry, got %v", err)
+	}
+
+	if code, body := do(http.MethodDelete, "/api/v1/pastes/PNME2/pin"); code != http.StatusOK || body["pinned"] != false {
+		t.Fatalf("unpin: expected 200 with pinned=false, got %d %v", code, body)
+	}
+	if _, err := store.Get("PNME2"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected unpinned paste past its expiry to be gone, got %v", err)
+	}
+}
+
+func TestMetaHandler_Quarantine(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystemStore: %v", err)
+	}
+	handler := NewMetaHandler(store)
+	handler.SetAccess(access.NewChecker(apikeys.Keys{"ops": {apikeys.ScopeAdmin}, "dev": {apikeys.ScopeRead}}, hmackeys.New("secret", nil)))
+	router := gin.New()
+	router.POST("/api/v1/pastes/:slug/quarantine", handler.Quarantine)
+	router.DELETE("/api/v1/pastes/:slug/quarantine", handler.Unquarantine)
+	router.GET("/api/v1/meta/:slug", handler.GetMetadata)
+
+	if err := store.Store(&models.Paste{ID: "QRNT2", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	do := func(method, path, key string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-Api-Key", key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var body map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	if code, body := do(http.MethodPost, "/api/v1/pastes/QRNT2/quarantine", "ops"); code != http.StatusOK || body["quarantined"] != true {
+		t.Fatalf("quarantine: expected 200 with quarantined=true, got %d %v", code, body)
+	}
+	// Only admin keys see a quarantined paste.
+	for _, key := range []string{"", "dev"} {
+		if code, _ := do(http.MethodGet, "/api/v1/meta/QRNT2", key); code != http.StatusNotFound {
+			t.Errorf("meta with key %q: expected 404, got %d", key, code)
+		}
+	}
+	if code, body := do(http.MethodGet, "/api/v1/meta/QRNT2", "ops"); code != http.StatusOK || body["quarantined"] != true {
+		t.Errorf("meta with admin key: expected 200 with quarantined=true, got %d %v", code, body)
+	}
+
+	if code, body := do(http.MethodDelete, "/api/v1/pastes/QRNT2/quarantine", "ops"); code != http.StatusOK || body["quarantined"] != nil {
+		t.Fatalf("release: expected 200 without quarantined, got %d %v", code, body)
+	}
+	if code, _ := do(http.MethodGet, "/api/v1/meta/QRNT2", ""); code != http.StatusOK {
+		t.Errorf("meta after release: expected 200, got %d", code)
+	}
+}
+
+func TestMetaHandler_LegalHold(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystemStore: %v", err)
+	}
+	handler := NewMetaHandler(store)
+	router := gin.New()
+	router.POST("/api/v1/pastes/:slug/hold", handler.Hold)
+	router.DELETE("/api/v1/pastes/:slug/hold", handler.Release)
+	router.DELETE("/:slug", handler.DeletePaste)
+
+	past := time.Now().Add(-time.Minute)
+	if err := store.Store(&models.Paste{ID: "HLD22", CreatedAt: time.Now(), ExpiresAt: &past, LegalHold: true}); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	do := func(method, path string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		var body map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	// A held paste outlives its expiry and cannot be deleted.
+	if _, err := store.Get("HLD22"); err != nil {
+		t.Fatalf("expected held paste to survive expiry, got %v", err)
+	}
+	if code, body := do(http.MethodDelete, "/HLD22"); code != http.StatusConflict || body["code"] != "legal_hold" {
+		t.Fatalf("delete: expected 409 legal_hold, got %d %v", code, body)
+	}
+	if code, body := do(http.MethodPost, "/api/v1/pastes/HLD22/hold"); code != http.StatusOK || body["legal_hold"] != true {
+		t.Fatalf("hold: expected 200 with legal_hold=true, got %d %v", code, body)
+	}
+
+	// Releasing restores the original expiry.
+	if code, body := do(http.MethodDelete, "/api/v1/pastes/HLD22/hold"); code != http.StatusOK || body["legal_hold"] != false {
+		t.Fatalf("release: expected 200 with legal_hold=false, got %d %v", code, body)
+	}
+	if _, err := store.Get("HLD22"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected released paste past its expiry to be gone, got %v", err)
+	}
+}
+
+func TestMetaHandler_HoldsContentLock(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystemStore: %v", err)
+	}
+	var mu sync.Mutex
+	handler := NewMetaHandler(store)
+	handler.SetContentLock(&mu)
+	router := gin.New()
+	router.POST("/api/v1/pastes/:slug/pin", handler.Pin)
+
+	expires := time.Now().Add(time.Hour)
+	if err := store.Store(&models.Paste{ID: "PNLK2", CreatedAt: time.Now(), ExpiresAt: &expires}); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	// A content replacement is running.
+	mu.Lock()
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/pastes/PNLK2/pin", nil))
+		done <- w.Code
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if paste, _ := store.Get("PNLK2"); paste.Pinned {
+		t.Fatal("expected pin to wait for the content lock")
+	}
+	mu.Unlock()
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("pin: expected 200, got %d", code)
+	}
+	if paste, _ := store.Get("PNLK2"); !paste.Pinned {
+		t.Error("expected the paste to be pinned once the lock was released")
+	}
+}
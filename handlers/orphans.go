@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/janitor"
+)
+
+// OrphansHandler serves the admin API of the orphan sweep
+type OrphansHandler struct {
+	janitor *janitor.Janitor
+}
+
+// NewOrphansHandler creates a new orphans handler
+func NewOrphansHandler(j *janitor.Janitor) *OrphansHandler {
+	return &OrphansHandler{janitor: j}
+}
+
+// List handles GET /api/v1/orphans, a dry run reporting the orphans a
+// sweep would remove.
+func (h *OrphansHandler) List(c *gin.Context) {
+	h.sweep(c, true)
+}
+
+// Sweep handles POST /api/v1/orphans, removing orphans now.
+func (h *OrphansHandler) Sweep(c *gin.Context) {
+	h.sweep(c, false)
+}
+
+func (h *OrphansHandler) sweep(c *gin.Context, dryRun bool) {
+	report, err := h.janitor.Sweep(dryRun)
+	if err != nil {
+		if !dryRun {
+			audit.Record(c, audit.ActionOrphanSweep, "", audit.ResultFailure, err.Error())
+		}
+		if errors.Is(err, janitor.ErrRunning) {
+			apierror.JSON(c, http.StatusConflict, apierror.CodeConflict, err.Error())
+			return
+		}
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to list storage objects")
+		return
+	}
+	if !dryRun {
+		audit.Record(c, audit.ActionOrphanSweep, "", audit.ResultSuccess,
+			fmt.Sprintf("removed=%d failed=%d bytes=%d", report.Removed, report.Failed, report.Bytes))
+	}
+	c.JSON(http.StatusOK, report)
+}
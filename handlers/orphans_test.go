@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/janitor"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func TestOrphansHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	store, err := storage.NewFilesystemStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Content left behind by an upload that crashed before its metadata.
+	orphan := filepath.Join(dir, "RPHAN")
+	if err := os.WriteFile(orphan, []byte("lost"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(orphan, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewOrphansHandler(janitor.New(store, 24*time.Hour))
+	router := gin.New()
+	router.GET("/api/v1/orphans", h.List)
+	router.POST("/api/v1/orphans", h.Sweep)
+	do := func(method string) (int, janitor.Report) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, "/api/v1/orphans", nil))
+		var r janitor.Report
+		_ = json.Unmarshal(w.Body.Bytes(), &r)
+		return w.Code, r
+	}
+
+	code, r := do(http.MethodGet)
+	if code != http.StatusOK || !r.DryRun || r.Found != 1 || r.Bytes != 4 || len(r.Orphans) != 1 || r.Orphans[0].Slug != "RPHAN" {
+		t.Fatalf("dry run: %d %+v", code, r)
+	}
+	if _, err := os.Stat(orphan); err != nil {
+		t.Fatalf("dry run removed the orphan: %v", err)
+	}
+	code, r = do(http.MethodPost)
+	if code != http.StatusOK || r.DryRun || r.Removed != 1 {
+		t.Fatalf("sweep: %d %+v", code, r)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("expected the orphan to be removed, got %v", err)
+	}
+}
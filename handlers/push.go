@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/push"
+)
+
+// SetPush enables push subscriptions on the manage page, delivered by n.
+func (h *ManageHandler) SetPush(n *push.Notifier) {
+	h.push = n
+}
+
+// PushPublicKey handles GET /api/v1/push/public-key, returning the VAPID
+// key the web UI subscribes with.
+func (h *ManageHandler) PushPublicKey(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, gin.H{"public_key": h.push.PublicKey()})
+}
+
+// PushSubscribe handles POST /manage/:slug/push?token=, registering the
+// browser's PushSubscription (the JSON of its toJSON method) to be
+// notified when the paste is burned or about to expire.
+func (h *ManageHandler) PushSubscribe(c *gin.Context) {
+	if !h.authorizeAction(c) {
+		return
+	}
+	slug := c.Param("slug")
+	var sub push.Subscription
+	if err := json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, 4096)).Decode(&sub); err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "invalid JSON body")
+		return
+	}
+	if err := sub.Validate(); err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, err.Error())
+		return
+	}
+	paste, err := h.service.GetPaste(slug)
+	if err != nil {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found")
+		return
+	}
+	manageURL := h.pageData(c)["BaseURL"].(string) + "/manage/" + slug + "?token=" + url.QueryEscape(c.Query("token"))
+	if err := h.push.Subscribe(paste, sub, manageURL); err != nil {
+		if errors.Is(err, push.ErrTooMany) {
+			apierror.JSON(c, http.StatusConflict, apierror.CodeSubscriptionLimit, err.Error())
+			return
+		}
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"subscribed": true, "slug": slug, "burn_after_read": paste.BurnAfterRead, "expires_at": paste.ExpiresAt})
+}
+
+// PushUnsubscribe handles DELETE /manage/:slug/push?token=, removing the
+// subscription whose endpoint is in the JSON body.
+func (h *ManageHandler) PushUnsubscribe(c *gin.Context) {
+	if !h.authorizeAction(c) {
+		return
+	}
+	var body struct {
+		Endpoint string `json:"endpoint"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, 4096)).Decode(&body); err != nil || body.Endpoint == "" {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "endpoint is required")
+		return
+	}
+	if !h.push.Unsubscribe(c.Param("slug"), body.Endpoint) {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Subscription not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"unsubscribed": true, "slug": c.Param("slug")})
+}
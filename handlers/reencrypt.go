@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/reencrypt"
+)
+
+// maxReencryptRate bounds the rate an admin may ask for, in pastes per
+// second.
+const maxReencryptRate = 1000
+
+// ReencryptHandler serves the admin API of the re-encryption job
+type ReencryptHandler struct {
+	job  *reencrypt.Job
+	rate int
+}
+
+// NewReencryptHandler creates a new re-encryption handler. rate is used
+// when a start request does not give one.
+func NewReencryptHandler(job *reencrypt.Job, rate int) *ReencryptHandler {
+	return &ReencryptHandler{
+		job:  job,
+		rate: rate,
+	}
+}
+
+// Status handles GET /api/v1/reencrypt, reporting the job's progress.
+func (h *ReencryptHandler) Status(c *gin.Context) {
+	c.JSON(http.StatusOK, h.job.Status())
+}
+
+// Start handles POST /api/v1/reencrypt?rate=, starting the job or resuming
+// a stopped one.
+func (h *ReencryptHandler) Start(c *gin.Context) {
+	rate := h.rate
+	if v := c.Query("rate"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxReencryptRate {
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest,
+				"rate must be between 1 and "+strconv.Itoa(maxReencryptRate))
+			return
+		}
+		rate = n
+	}
+	if err := h.job.Start(rate); err != nil {
+		audit.Record(c, audit.ActionReencrypt, "", audit.ResultFailure, err.Error())
+		if errors.Is(err, reencrypt.ErrRunning) {
+			apierror.JSON(c, http.StatusConflict, apierror.CodeConflict, err.Error())
+			return
+		}
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, err.Error())
+		return
+	}
+	audit.Record(c, audit.ActionReencrypt, "", audit.ResultSuccess, "start rate="+strconv.Itoa(rate))
+	c.JSON(http.StatusAccepted, h.job.Status())
+}
+
+// Stop handles DELETE /api/v1/reencrypt, stopping the job. Its progress
+// is kept, so a later start resumes it.
+func (h *ReencryptHandler) Stop(c *gin.Context) {
+	if err := h.job.Stop(); err != nil {
+		apierror.JSON(c, http.StatusConflict, apierror.CodeConflict, err.Error())
+		return
+	}
+	audit.Record(c, audit.ActionReencrypt, "", audit.ResultSuccess, "stop")
+	c.JSON(http.StatusOK, h.job.Status())
+}
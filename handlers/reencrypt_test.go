@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/keyring"
+	"github.com/johnwmail/nclip/internal/reencrypt"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func TestReencryptHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	backend, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := keyring.Parse("k1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, keyring.KeySize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := reencrypt.New(storage.NewEncryptedStore(backend, keys), filepath.Join(t.TempDir(), "state.json"))
+	h := NewReencryptHandler(job, 10)
+	router := gin.New()
+	router.GET("/api/v1/reencrypt", h.Status)
+	router.POST("/api/v1/reencrypt", h.Start)
+	router.DELETE("/api/v1/reencrypt", h.Stop)
+
+	do := func(method, target string) (int, reencrypt.Status) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		var s reencrypt.Status
+		_ = json.Unmarshal(w.Body.Bytes(), &s)
+		return w.Code, s
+	}
+
+	if code, s := do(http.MethodGet, "/api/v1/reencrypt"); code != http.StatusOK || s.State != reencrypt.StateIdle {
+		t.Errorf("initial status: %d %+v", code, s)
+	}
+	if code, _ := do(http.MethodPost, "/api/v1/reencrypt?rate=0"); code != http.StatusBadRequest {
+		t.Errorf("rate=0: expected 400, got %d", code)
+	}
+	if code, _ := do(http.MethodDelete, "/api/v1/reencrypt"); code != http.StatusConflict {
+		t.Errorf("stop while idle: expected 409, got %d", code)
+	}
+	code, s := do(http.MethodPost, "/api/v1/reencrypt?rate=500")
+	if code != http.StatusAccepted || s.KeyID != "k1" || s.Rate != 500 {
+		t.Errorf("start: %d %+v", code, s)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Status().State != reencrypt.StateDone && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if code, s := do(http.MethodGet, "/api/v1/reencrypt"); code != http.StatusOK || s.State != reencrypt.StateDone {
+		t.Errorf("final status: %d %+v", code, s)
+	}
+}
@@ -0,0 +1,84 @@
+package retrieval
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/respheaders"
+	"github.com/johnwmail/nclip/internal/session"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// burnRequest is the JSON body of POST /b/:slug.
+type burnRequest struct {
+	Token string `json:"token"`
+}
+
+// BurnPage handles GET /b/:slug, the landing page of a burn-after-read
+// link. It never reads the paste: the page's script sends the token from
+// the URL fragment, which link preview bots never see, to BurnReveal once
+// the reader asks for the content. Replicas send readers to the writer,
+// the only instance that may burn the paste.
+func (h *Handler) BurnPage(c *gin.Context) {
+	slug := c.Param("slug")
+	if !utils.IsValidSlug(slug) {
+		h.renderError(c, http.StatusBadRequest, apierror.CodeInvalidSlug, "Invalid slug format")
+		return
+	}
+	if h.config.IsReplica() {
+		h.redirectToWriter(c)
+		return
+	}
+	respheaders.SetNoStore(c.Writer.Header())
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("X-Robots-Tag", "noindex")
+	c.HTML(http.StatusOK, "burn.html", gin.H{"Title": "NCLIP - Burn after reading", "Slug": slug, "Version": h.config.Version, "BuildTime": h.config.BuildTime, "CommitHash": h.config.CommitHash, "CSRFToken": session.CSRFToken(c)})
+}
+
+// BurnReveal handles POST /b/:slug. Given the paste's burn token it
+// returns the content and burns the paste. Like a share link the token
+// grants access whatever the paste's visibility. A wrong token gets the
+// same 404 as a missing paste and leaves the paste in place.
+func (h *Handler) BurnReveal(c *gin.Context) {
+	slug := c.Param("slug")
+	if !utils.IsValidSlug(slug) {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidSlug, "Invalid slug format")
+		return
+	}
+	var req burnRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "burn token required")
+		return
+	}
+	paste, err := h.service.GetPaste(slug)
+	if err != nil || !paste.BurnAfterRead || paste.BurnToken == "" || paste.Quarantined ||
+		subtle.ConstantTimeCompare([]byte(req.Token), []byte(paste.BurnToken)) != 1 {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found or already burned")
+		return
+	}
+	if paste.Corrupt {
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeContentCorrupt, "Paste content is corrupt")
+		return
+	}
+	content, err := h.service.GetPasteContent(slug)
+	if err != nil {
+		log.Printf("[ERROR] BurnReveal: content not found or deleted for slug %s: %v", slug, err)
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found or already burned")
+		return
+	}
+	if err := h.service.IncrementReadCount(slug, models.ReadView); err != nil {
+		log.Printf("[WARN] BurnReveal: failed to increment read count for %s: %v", slug, err)
+	}
+	if !h.claimBurn(c, paste) {
+		return
+	}
+	respheaders.SetNoStore(c.Writer.Header())
+	c.Header("X-Content-Type-Options", "nosniff")
+	setContentDisposition(c, defaultFilename(slug, paste), !utils.IsTextContent(paste.ContentType))
+	c.Data(http.StatusOK, paste.ContentType, content)
+	h.completeBurn(c, paste, nil)
+}
@@ -0,0 +1,147 @@
+package retrieval
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/hmackeys"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+// Test that burn-after-read and private pastes are sent with headers that
+// keep every cache, shared or not, from storing them, on every path that
+// serves their content.
+func TestNoStoreHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dataDir := t.TempDir()
+	cfg := &config.Config{MaxRenderSize: 1024, DataDir: dataDir}
+	store, err := storage.NewFilesystemStore(dataDir)
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	rh := NewHandler(services.NewPasteService(store, cfg), store, cfg)
+	rh.SetAccess(access.NewChecker(apikeys.Parse("alice"), hmackeys.New("secret", nil)))
+
+	router := gin.New()
+	router.SetHTMLTemplate(template.Must(template.New("view.html").Parse("{{.Content}}")))
+	router.GET("/:slug", rh.View)
+	router.GET("/raw/:slug", rh.Raw)
+	router.GET("/download/:slug", rh.Download)
+
+	content := []byte("secret")
+	put := func(paste *models.Paste) {
+		t.Helper()
+		paste.CreatedAt, paste.Size, paste.ContentType = time.Now(), int64(len(content)), "text/plain"
+		if err := store.StoreContent(paste.ID, content); err != nil {
+			t.Fatalf("failed to store content: %v", err)
+		}
+		if err := store.Store(paste); err != nil {
+			t.Fatalf("failed to store paste metadata: %v", err)
+		}
+	}
+	get := func(path, userAgent string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("User-Agent", userAgent)
+		req.Header.Set("X-Api-Key", "alice")
+		if userAgent != "curl/8.0" {
+			req.Header.Set("Accept", "text/html")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected 200, got %d: %s", path, w.Code, w.Body.String())
+		}
+		return w
+	}
+	paths := []struct{ name, prefix, userAgent string }{
+		{"view", "/", "Mozilla/5.0"},
+		{"CLI", "/", "curl/8.0"},
+		{"raw", "/raw/", "curl/8.0"},
+		{"download", "/download/", "curl/8.0"},
+	}
+
+	for _, p := range paths {
+		for _, paste := range []*models.Paste{
+			{ID: "BRNCC", BurnAfterRead: true},
+			{ID: "PRVCC", Visibility: models.VisibilityPrivate, Owner: audit.KeyID("alice")},
+		} {
+			put(paste)
+			w := get(p.prefix+paste.ID, p.userAgent)
+			h := w.Header()
+			if h.Get("Cache-Control") != "no-store, private" || h.Get("Pragma") != "no-cache" || h.Get("Surrogate-Control") != "no-store" {
+				t.Errorf("%s of %s: expected no-store headers, got Cache-Control=%q Pragma=%q Surrogate-Control=%q",
+					p.name, paste.ID, h.Get("Cache-Control"), h.Get("Pragma"), h.Get("Surrogate-Control"))
+			}
+		}
+
+		put(&models.Paste{ID: "PBLCC"})
+		if h := get(p.prefix+"PBLCC", p.userAgent).Header(); h.Get("Pragma") != "" || h.Get("Surrogate-Control") != "" {
+			t.Errorf("%s of a public paste: expected it to stay cacheable, got %v", p.name, h)
+		}
+	}
+}
+
+// Test that /raw and /download send the uploaded file's mtime as
+// Last-Modified, so curl -R and wget keep it, and honour
+// If-Modified-Since against it.
+func TestFileMtimeLastModified(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dataDir := t.TempDir()
+	cfg := &config.Config{MaxRenderSize: 1024, DataDir: dataDir}
+	store, err := storage.NewFilesystemStore(dataDir)
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	rh := NewHandler(services.NewPasteService(store, cfg), store, cfg)
+
+	router := gin.New()
+	router.GET("/raw/:slug", rh.Raw)
+	router.GET("/download/:slug", rh.Download)
+
+	mtime := time.Date(2026, 9, 30, 8, 15, 0, 0, time.UTC)
+	want := "Wed, 30 Sep 2026 08:15:00 GMT"
+	content := []byte("artifact")
+	for _, paste := range []*models.Paste{
+		{ID: "MTRAW", FileMtime: &mtime},
+		{ID: "MTDLD", FileMtime: &mtime},
+		{ID: "MTBRN", FileMtime: &mtime, BurnAfterRead: true},
+	} {
+		paste.CreatedAt, paste.Size, paste.ContentType = time.Now(), int64(len(content)), "text/plain"
+		if err := store.StoreContent(paste.ID, content); err != nil {
+			t.Fatalf("failed to store content: %v", err)
+		}
+		if err := store.Store(paste); err != nil {
+			t.Fatalf("failed to store paste metadata: %v", err)
+		}
+	}
+
+	for _, path := range []string{"/raw/MTRAW", "/download/MTDLD", "/raw/MTBRN"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("User-Agent", "curl/8.0")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Header().Get("Last-Modified") != want {
+			t.Errorf("GET %s: expected 200 with Last-Modified %q, got %d %q", path, want, w.Code, w.Header().Get("Last-Modified"))
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/raw/MTRAW", nil)
+	req.Header.Set("User-Agent", "curl/8.0")
+	req.Header.Set("If-Modified-Since", want)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since the mtime: expected 304, got %d", w.Code)
+	}
+}
@@ -0,0 +1,56 @@
+package retrieval
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// Checksum handles GET /sha256/:hash, which serves the same bytes as
+// /raw/{slug} for a paste whose content has that hex SHA-256. Only pastes
+// stored while NCLIP_CHECKSUM_URLS was set can be found. Burn-after-read,
+// private, appendable and corrupt pastes are never served here. The
+// content behind a hash cannot change, so responses may be cached for
+// long; the hash doubles as the ETag.
+func (h *Handler) Checksum(c *gin.Context) {
+	sum := c.Param("hash")
+	if !utils.IsValidChecksum(sum) {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "The hash must be 64 lowercase hex characters")
+		return
+	}
+	paste, content, err := h.service.FindByChecksum(sum)
+	if err != nil {
+		if !errors.Is(err, services.ErrChecksumNotFound) {
+			log.Printf("[ERROR] Checksum: %v", err)
+		}
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found or deleted")
+		return
+	}
+	if !h.authorize(c, paste, apierror.JSON) {
+		return
+	}
+	if c.GetHeader("Range") == "" {
+		if err := h.service.IncrementReadCount(paste.ID, models.ReadRaw); err != nil {
+			log.Printf("[WARN] Checksum: failed to increment read count for %s: %v", paste.ID, err)
+		}
+	}
+	filename := defaultFilename(paste.ID, paste)
+	c.Header("Content-Type", paste.ContentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	// authorize has forbidden caching the pastes it must not be.
+	if c.Writer.Header().Get("Cache-Control") == "" {
+		c.Header("Cache-Control", "public, max-age=86400, immutable")
+	}
+	c.Header("ETag", `"`+sum+`"`)
+	c.Header("Accept-Ranges", "bytes")
+	h.sign(c, paste.ID, content)
+	setContentDisposition(c, filename, !utils.IsTextContent(paste.ContentType))
+	http.ServeContent(c.Writer, c.Request, filename, paste.ModTime(), bytes.NewReader(content))
+}
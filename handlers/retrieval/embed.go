@@ -0,0 +1,73 @@
+package retrieval
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/preview"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// embedLine is one highlighted line of an embedded paste.
+type embedLine struct {
+	Number int
+	Tokens []preview.Token
+}
+
+// Embed handles GET /embed/:slug, a minimal page showing a text paste with
+// basic highlighting, for other sites to frame (see /embed.js). Only the
+// sites in NCLIP_EMBED_FRAME_ANCESTORS may frame it. Private pastes get the
+// same 404 as missing ones and burn-after-read pastes are refused, since
+// a page load would burn them. Content beyond MaxRenderSize is cut off.
+func (h *Handler) Embed(c *gin.Context) {
+	slug := c.Param("slug")
+	if !utils.IsValidSlug(slug) {
+		h.renderError(c, http.StatusBadRequest, apierror.CodeInvalidSlug, "Invalid slug format")
+		return
+	}
+	paste, err := h.service.GetPaste(slug)
+	if err != nil || paste.IsPrivate() || paste.Quarantined {
+		h.renderNotFound(c, "Paste not found or deleted")
+		return
+	}
+	if !h.authorize(c, paste, h.renderError) {
+		return
+	}
+	if paste.BurnAfterRead {
+		h.renderError(c, http.StatusForbidden, apierror.CodeEmbedForbidden, "Burn-after-read pastes cannot be embedded")
+		return
+	}
+	if !utils.IsTextContent(paste.ContentType) {
+		h.renderError(c, http.StatusForbidden, apierror.CodeEmbedForbidden, "Only text pastes can be embedded")
+		return
+	}
+	content, err := h.store.GetContentPrefix(slug, h.maxRenderSize())
+	if err != nil {
+		log.Printf("[ERROR] Embed: failed to read content for %s: %v", slug, err)
+		h.renderNotFound(c, "Paste not available or deleted")
+		return
+	}
+	if err := h.service.IncrementReadCount(slug, models.ReadView); err != nil {
+		log.Printf("[WARN] Embed: failed to increment read count for %s: %v", slug, err)
+	}
+
+	text := strings.TrimSuffix(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+	var lines []embedLine
+	for i, line := range strings.Split(text, "\n") {
+		lines = append(lines, embedLine{Number: i + 1, Tokens: preview.Tokenize(line)})
+	}
+	baseURL := h.getBaseURL(c)
+	c.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors "+h.config.EmbedFrameAncestors)
+	c.HTML(http.StatusOK, "embed.html", gin.H{
+		"Title":     "NCLIP - Paste " + paste.ID,
+		"Paste":     paste,
+		"Lines":     lines,
+		"Truncated": int64(len(content)) < paste.Size,
+		"PasteURL":  baseURL + "/" + paste.ID,
+		"RawURL":    baseURL + "/raw/" + paste.ID,
+	})
+}
@@ -0,0 +1,76 @@
+package retrieval
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func TestRaw_OriginalEncoding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dataDir := t.TempDir()
+	cfg := &config.Config{MaxRenderSize: 1024, DataDir: dataDir, MaxVersions: 1}
+	store, err := storage.NewFilesystemStore(dataDir)
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	service := services.NewPasteService(store, cfg)
+	rh := NewHandler(service, store, cfg)
+
+	utf16 := []byte{0xFF, 0xFE, 'h', 0, 0xE9, 0, 'y', 0, '\r', 0, '\n', 0}
+	latin1 := []byte("caf\xe9\n")
+	create := func(content []byte, contentType string, burn bool) string {
+		resp, err := service.CreatePaste(services.CreatePasteRequest{Content: content, ContentType: contentType, BurnAfterRead: burn, TTL: time.Hour})
+		if err != nil {
+			t.Fatalf("CreatePaste: %v", err)
+		}
+		return resp.Slug
+	}
+	wide := create(utf16, "text/plain; charset=utf-16le", false)
+	burn := create(latin1, "text/plain", true)
+	replaced := create(latin1, "text/plain", false)
+	if _, err := service.ReplaceContent(replaced, []byte("new\n"), "text/plain"); err != nil {
+		t.Fatalf("ReplaceContent: %v", err)
+	}
+
+	router := gin.New()
+	router.GET("/raw/:slug", rh.Raw)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	tests := []struct {
+		path, body, contentType string
+	}{
+		{"/raw/" + wide, "héy\r\n", "text/plain; charset=utf-8"},
+		{"/raw/" + wide + "?encoding=utf-8", "héy\r\n", "text/plain; charset=utf-8"},
+		{"/raw/" + wide + "?encoding=original", string(utf16), "text/plain; charset=utf-16le"},
+		{"/raw/" + replaced + "?encoding=original", "new\n", "text/plain"},
+		{"/raw/" + replaced + "?version=1&encoding=original", string(latin1), "text/plain; charset=iso-8859-1"},
+		{"/raw/" + replaced + "?version=1", "café\n", "text/plain; charset=utf-8"},
+		{"/raw/" + burn + "?encoding=original", string(latin1), "text/plain; charset=iso-8859-1"},
+	}
+	for _, tt := range tests {
+		w := get(tt.path)
+		if w.Code != http.StatusOK || w.Body.String() != tt.body || w.Header().Get("Content-Type") != tt.contentType {
+			t.Errorf("GET %s: got %d %q (%s), want %q (%s)", tt.path, w.Code, w.Body.String(), w.Header().Get("Content-Type"), tt.body, tt.contentType)
+		}
+	}
+	if p, _ := store.Get(burn); p != nil {
+		t.Error("burn-after-read paste was not burned by its original read")
+	}
+
+	for _, path := range []string{"/raw/" + wide + "?encoding=latin9", "/raw/" + wide + "?encoding=original&head=1"} {
+		if w := get(path); w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: got %d, want 400", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package retrieval
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limits of the /raw line filters.
+const (
+	// maxFilterLines bounds ?head= and ?tail=.
+	maxFilterLines = 100000
+	// maxGrepMatches bounds the lines ?grep= returns.
+	maxGrepMatches = 1000
+	// maxGrepPattern bounds the length of a ?grep= pattern.
+	maxGrepPattern = 256
+	// maxFilterLineLength is the longest line the filters keep; longer
+	// lines are cut.
+	maxFilterLineLength = 64 * 1024
+)
+
+// lineFilter selects lines of a text paste for GET /raw/:slug?head=N,
+// ?tail=N and ?grep=PATTERN. With grep, head and tail apply to the
+// matching lines.
+type lineFilter struct {
+	head int
+	tail int
+	grep *regexp.Regexp
+}
+
+// parseLineFilter reads the filter query parameters, returning nil when
+// the request has none.
+func parseLineFilter(c *gin.Context) (*lineFilter, error) {
+	var f lineFilter
+	var err error
+	head, hasHead := c.GetQuery("head")
+	tail, hasTail := c.GetQuery("tail")
+	pattern, hasGrep := c.GetQuery("grep")
+	if !hasHead && !hasTail && !hasGrep {
+		return nil, nil
+	}
+	if hasHead && hasTail {
+		return nil, errors.New("head and tail cannot be combined")
+	}
+	if hasHead {
+		if f.head, err = filterCount("head", head); err != nil {
+			return nil, err
+		}
+	}
+	if hasTail {
+		if f.tail, err = filterCount("tail", tail); err != nil {
+			return nil, err
+		}
+	}
+	if hasGrep {
+		if pattern == "" || len(pattern) > maxGrepPattern {
+			return nil, fmt.Errorf("grep must be a pattern of 1 to %d bytes", maxGrepPattern)
+		}
+		if f.grep, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid grep pattern: %w", err)
+		}
+	}
+	return &f, nil
+}
+
+// filterCount parses the line count of ?head= or ?tail=.
+func filterCount(name, v string) (int, error) {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxFilterLines {
+		return 0, fmt.Errorf("%s must be a number of lines between 1 and %d", name, maxFilterLines)
+	}
+	return n, nil
+}
+
+// selects reports whether line passes the grep pattern.
+func (f *lineFilter) selects(line []byte) bool {
+	return f.grep == nil || f.grep.Match(line)
+}
+
+// write writes the selected lines to w, each ending in a newline. Content
+// is streamed from open, so only one line is held in memory at a time;
+// tail reads it twice, first to count the lines.
+func (f *lineFilter) write(w io.Writer, open func() (io.ReadCloser, error)) error {
+	limit := f.head
+	if f.tail > 0 {
+		limit = f.tail
+	}
+	if f.grep != nil && (limit == 0 || limit > maxGrepMatches) {
+		limit = maxGrepMatches
+	}
+
+	skip := 0
+	if f.tail > 0 {
+		total := 0
+		if err := scanContent(open, func(line []byte) (bool, error) {
+			if f.selects(line) {
+				total++
+			}
+			return true, nil
+		}); err != nil {
+			return err
+		}
+		skip = max(total-limit, 0)
+	}
+
+	seen, written := 0, 0
+	return scanContent(open, func(line []byte) (bool, error) {
+		if limit > 0 && written == limit {
+			return false, nil
+		}
+		if !f.selects(line) {
+			return true, nil
+		}
+		seen++
+		if seen <= skip {
+			return true, nil
+		}
+		written++
+		if _, err := w.Write(line); err != nil {
+			return false, err
+		}
+		_, err := w.Write([]byte{'\n'})
+		return err == nil, err
+	})
+}
+
+// scanContent opens the content and calls fn with each line, without its
+// newline, until fn returns false or an error.
+func scanContent(open func() (io.ReadCloser, error), fn func(line []byte) (bool, error)) error {
+	r, err := open()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	return scanLines(r, fn)
+}
+
+// scanLines calls fn with each line of r. Lines longer than
+// maxFilterLineLength are cut to that length.
+func scanLines(r io.Reader, fn func(line []byte) (bool, error)) error {
+	br := bufio.NewReaderSize(r, maxFilterLineLength)
+	for {
+		line, err := br.ReadSlice('\n')
+		truncated := errors.Is(err, bufio.ErrBufferFull)
+		if len(line) > 0 && (err == nil || truncated || err == io.EOF) {
+			if line[len(line)-1] == '\n' {
+				line = line[:len(line)-1]
+			}
+			more, ferr := fn(line)
+			if ferr != nil || !more {
+				return ferr
+			}
+		}
+		for truncated {
+			// Discard the rest of the cut line.
+			_, err = br.ReadSlice('\n')
+			truncated = errors.Is(err, bufio.ErrBufferFull)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
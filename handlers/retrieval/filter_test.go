@@ -0,0 +1,104 @@
+package retrieval
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func TestRawLineFilters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dataDir := t.TempDir()
+	cfg := &config.Config{MaxRenderSize: 1024, DataDir: dataDir}
+	store, err := storage.NewFilesystemStore(dataDir)
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	rh := NewHandler(services.NewPasteService(store, cfg), store, cfg)
+
+	put := func(slug string, content []byte, contentType string, burn bool) {
+		t.Helper()
+		if err := store.StoreContent(slug, content); err != nil {
+			t.Fatalf("failed to store content: %v", err)
+		}
+		p := &models.Paste{ID: slug, CreatedAt: time.Now(), Size: int64(len(content)), ContentType: contentType, BurnAfterRead: burn}
+		if err := store.Store(p); err != nil {
+			t.Fatalf("failed to store paste metadata: %v", err)
+		}
+	}
+	put("LGS22", []byte("start\nerror one\nok\nerror two\nok\nerror three\nend"), "text/plain", false)
+	put("BRN22", []byte("a\nb\n"), "text/plain", true)
+	put("BIN22", []byte{0x89, 'P', 'N', 'G'}, "image/png", false)
+
+	router := gin.New()
+	router.GET("/raw/:slug", rh.Raw)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	ok := []struct {
+		query string
+		want  string
+	}{
+		{"head=2", "start\nerror one\n"},
+		{"tail=2", "error three\nend\n"},
+		{"tail=100", "start\nerror one\nok\nerror two\nok\nerror three\nend\n"},
+		{"grep=" + url.QueryEscape("^error"), "error one\nerror two\nerror three\n"},
+		{"grep=error&tail=1", "error three\n"},
+		{"grep=error&head=2", "error one\nerror two\n"},
+		{"grep=nomatch", ""},
+	}
+	for _, tc := range ok {
+		w := get("/raw/LGS22?" + tc.query)
+		if w.Code != http.StatusOK || w.Body.String() != tc.want {
+			t.Errorf("?%s: got %d %q; want %q", tc.query, w.Code, w.Body.String(), tc.want)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+			t.Errorf("?%s: unexpected Content-Type %q", tc.query, ct)
+		}
+	}
+
+	for _, path := range []string{
+		"/raw/LGS22?head=0",
+		"/raw/LGS22?tail=x",
+		"/raw/LGS22?head=1&tail=1",
+		"/raw/LGS22?grep=" + url.QueryEscape("(unclosed"),
+		"/raw/LGS22?grep=",
+		"/raw/BIN22?head=1",
+		"/raw/BRN22?tail=1",
+	} {
+		if w := get(path); w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: expected 400, got %d: %s", path, w.Code, w.Body.String())
+		}
+	}
+	// A rejected filter must not burn the paste.
+	if w := get("/raw/BRN22"); w.Code != http.StatusOK || w.Body.String() != "a\nb\n" {
+		t.Errorf("burn paste after a rejected filter: got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestScanLines_TruncatesLongLines(t *testing.T) {
+	long := strings.Repeat("x", maxFilterLineLength+10)
+	var lines []int
+	err := scanLines(strings.NewReader(long+"\nshort\n"), func(line []byte) (bool, error) {
+		lines = append(lines, len(line))
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[0] != maxFilterLineLength || lines[1] != 5 {
+		t.Errorf("unexpected line lengths %v", lines)
+	}
+}
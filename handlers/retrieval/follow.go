@@ -0,0 +1,103 @@
+package retrieval
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/models"
+)
+
+// followPollInterval is how often a follow read checks the paste for
+// appended content. Polling the metadata works across instances, whichever
+// one took the append.
+const followPollInterval = time.Second
+
+// followMaxDuration ends follow reads that outlast it; clients reconnect
+// to keep following.
+const followMaxDuration = time.Hour
+
+// parseFollow reads ?follow=.
+func parseFollow(c *gin.Context) (bool, error) {
+	v := c.Query("follow")
+	if v == "" {
+		return false, nil
+	}
+	follow, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("follow must be true or false, got %q", v)
+	}
+	return follow, nil
+}
+
+// serveFollow handles GET /raw/:slug?follow=true. It sends the content of
+// the paste, then streams what is appended to it as a chunked response
+// until the final append, until the paste is deleted or expires, or until
+// followMaxDuration. Pastes that are not appendable are sent in full and
+// the response ends. It counts as one raw read.
+func (h *Handler) serveFollow(c *gin.Context) {
+	slug := c.Param("slug")
+	paste, err := h.service.GetPaste(slug)
+	if err != nil {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found or deleted")
+		return
+	}
+	if !h.authorize(c, paste, apierror.JSON) {
+		return
+	}
+	if paste.BurnAfterRead {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "follow is not available for burn-after-read pastes")
+		return
+	}
+	if err := h.service.IncrementReadCount(slug, models.ReadRaw); err != nil {
+		log.Printf("[WARN] Raw: failed to increment read count for %s: %v", slug, err)
+	}
+
+	c.Header("Content-Type", paste.ContentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "no-store")
+	// Reverse proxies such as nginx would otherwise hold the stream back.
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.WriteHeaderNow()
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(followPollInterval)
+	defer ticker.Stop()
+	deadline := time.NewTimer(followMaxDuration)
+	defer deadline.Stop()
+	var sent int64
+	for {
+		if paste.Size > sent {
+			content, err := h.service.GetPasteContent(slug)
+			if err != nil {
+				log.Printf("[ERROR] Raw: follow read of %s failed: %v", slug, err)
+				return
+			}
+			if int64(len(content)) > sent {
+				if _, err := c.Writer.Write(content[sent:]); err != nil {
+					return
+				}
+				c.Writer.Flush()
+				sent = int64(len(content))
+			}
+		}
+		if !paste.Appendable && sent >= paste.Size {
+			return
+		}
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-deadline.C:
+			return
+		case <-ticker.C:
+		}
+		if paste, err = h.service.GetPaste(slug); err != nil {
+			return
+		}
+	}
+}
@@ -1,15 +1,31 @@
 package retrieval
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"net/url"
+	"os"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/burnnotify"
+	"github.com/johnwmail/nclip/internal/forwarded"
+	"github.com/johnwmail/nclip/internal/preview"
+	"github.com/johnwmail/nclip/internal/respheaders"
 	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/internal/session"
+	"github.com/johnwmail/nclip/internal/settings"
+	"github.com/johnwmail/nclip/internal/signing"
 	"github.com/johnwmail/nclip/models"
 	"github.com/johnwmail/nclip/storage"
 	"github.com/johnwmail/nclip/utils"
@@ -20,6 +36,11 @@ type Handler struct {
 	service *services.PasteService
 	store   storage.PasteStore
 	config  *config.Config
+	access  *access.Checker
+	signer  *signing.Signer
+	tokens  *services.TokenService
+	// settings, when set, replace the configured render size limit.
+	settings *settings.Runtime
 }
 
 // NewHandler creates a new retrieval handler
@@ -31,6 +52,43 @@ func NewHandler(service *services.PasteService, store storage.PasteStore, config
 	}
 }
 
+// SetAccess sets the checker for private pastes. Without one, private
+// pastes are never served.
+func (h *Handler) SetAccess(checker *access.Checker) {
+	h.access = checker
+}
+
+// SetSettings makes the HTML views use the render size limit of rt, which
+// can change while the server runs, instead of the configured one.
+func (h *Handler) SetSettings(rt *settings.Runtime) {
+	h.settings = rt
+}
+
+// maxRenderSize returns the largest paste rendered in full in the HTML
+// views.
+func (h *Handler) maxRenderSize() int64 {
+	if h.settings != nil {
+		return h.settings.Current().MaxRenderSize
+	}
+	return h.config.MaxRenderSize
+}
+
+// SetSigner makes Raw and Download sign the content they serve. Filtered
+// responses are not signed.
+func (h *Handler) SetSigner(signer *signing.Signer) {
+	h.signer = signer
+}
+
+// sign sets the signature headers for content, when a signer is set.
+func (h *Handler) sign(c *gin.Context, slug string, content []byte) {
+	if h.signer == nil {
+		return
+	}
+	for name, value := range h.signer.Headers(slug, content) {
+		c.Header(name, value)
+	}
+}
+
 // dataDir returns the configured data directory. LoadConfig should populate
 // Config.DataDir (from flags or environment). We avoid reading the env here
 // so that all resolution is centralized in config.LoadConfig.
@@ -85,25 +143,18 @@ func (h *Handler) isCli(c *gin.Context) bool {
 // Note: filesystem-specific helper removed; handlers use the same stream-then-delete
 // logic for all stores.
 
-// View handles paste viewing via GET /:slug
+// View handles paste viewing via GET /:slug. A .txt, .md or .html
+// extension selects a snippet format; see snippet.
 func (h *Handler) View(c *gin.Context) {
 	slug := c.Param("slug")
+	if slug, format, ok := splitSnippet(slug); ok {
+		h.snippet(c, slug, format)
+		return
+	}
 
 	if !utils.IsValidSlug(slug) {
 		// Prefer HTML for non-CLI (browser) clients; return JSON for CLI/API clients.
-		if !h.isCli(c) {
-			c.HTML(http.StatusBadRequest, "view.html", gin.H{
-				"Title":      "NCLIP - Error",
-				"Error":      "Invalid slug format",
-				"Version":    h.config.Version,
-				"BuildTime":  h.config.BuildTime,
-				"CommitHash": h.config.CommitHash,
-				"BaseURL":    h.getBaseURL(c),
-				"UploadAuth": h.config.UploadAuth,
-			})
-		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid slug format"})
-		}
+		h.renderError(c, http.StatusBadRequest, apierror.CodeInvalidSlug, "Invalid slug format")
 		return
 	}
 
@@ -113,33 +164,38 @@ func (h *Handler) View(c *gin.Context) {
 		h.renderNotFound(c, "Paste not found or deleted")
 		return
 	}
+	if !h.authorize(c, paste, h.renderError) {
+		return
+	}
+	version, versioned, err := parseVersion(c)
+	if err != nil {
+		h.renderError(c, http.StatusBadRequest, apierror.CodeBadRequest, err.Error())
+		return
+	}
+	if versioned {
+		h.viewVersion(c, paste, version)
+		return
+	}
+	if paste.BurnAfterRead && h.config.IsReplica() {
+		h.redirectToWriter(c)
+		return
+	}
 
 	// Early strict size check: ask the store for the existence and size of
 	// the content. This uses a store-specific stat (filesystem: os.Stat,
 	// S3: HeadObject) so it works for either backend without requiring a
-	// local dataDir.
-	if exists, actualSize, serr := h.store.StatContent(slug); serr == nil && exists {
+	// local dataDir. Appendable pastes are skipped: an append writes their
+	// content before their metadata.
+	if exists, actualSize, serr := h.store.StatContent(slug); serr == nil && exists && !paste.Appendable {
 		if actualSize != paste.Size {
 			log.Printf("[ERROR] View: early size mismatch for slug %s: metadata=%d actual=%d", slug, paste.Size, actualSize)
-			if h.isCli(c) {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "size_mismatch"})
-			} else {
-				c.HTML(http.StatusInternalServerError, "view.html", gin.H{
-					"Title":      "NCLIP - Error",
-					"Error":      "Size mismatch",
-					"Version":    h.config.Version,
-					"BuildTime":  h.config.BuildTime,
-					"CommitHash": h.config.CommitHash,
-					"BaseURL":    h.getBaseURL(c),
-					"UploadAuth": h.config.UploadAuth,
-				})
-			}
+			h.renderError(c, http.StatusInternalServerError, apierror.CodeSizeMismatch, "Size mismatch")
 			return
 		}
 	}
 
 	// Increment read count
-	if err := h.service.IncrementReadCount(slug); err != nil {
+	if err := h.service.IncrementReadCount(slug, models.ReadView); err != nil {
 		// Log error but don't fail the request
 		fmt.Printf("Failed to increment read count for %s: %v\n", slug, err)
 	}
@@ -171,7 +227,7 @@ func (h *Handler) View(c *gin.Context) {
 // NOTE: Size verification is performed in View() before calling this function.
 func (h *Handler) viewBrowserBurn(c *gin.Context, slug string, paste *models.Paste) {
 	// For small content, render full
-	if paste.Size <= h.config.MaxRenderSize {
+	if paste.Size <= h.maxRenderSize() {
 		// Read full content, delete paste, render
 		full, err := h.service.GetPasteContent(slug)
 		if err != nil {
@@ -179,12 +235,11 @@ func (h *Handler) viewBrowserBurn(c *gin.Context, slug string, paste *models.Pas
 			return
 		}
 		// No size check needed - already verified in View()
-		if err := h.service.DeletePaste(slug); err != nil {
-			log.Printf("[ERROR] viewBrowserBurn: failed to delete burn paste %s: %v", slug, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete burn-after-read paste"})
+		if !h.claimBurn(c, paste) {
 			return
 		}
-		c.HTML(http.StatusOK, "view.html", gin.H{"Title": fmt.Sprintf("NCLIP - Paste %s", paste.ID), "Paste": paste, "IsText": utils.IsTextContent(paste.ContentType), "IsPreview": false, "Content": string(full), "Version": h.config.Version, "BuildTime": h.config.BuildTime, "CommitHash": h.config.CommitHash, "BaseURL": h.getBaseURL(c), "UploadAuth": h.config.UploadAuth})
+		c.HTML(http.StatusOK, "view.html", gin.H{"Title": fmt.Sprintf("NCLIP - Paste %s", paste.ID), "Paste": paste, "IsText": utils.IsTextContent(paste.ContentType), "IsPreview": false, "Content": string(full), "Version": h.config.Version, "BuildTime": h.config.BuildTime, "CommitHash": h.config.CommitHash, "BaseURL": h.getBaseURL(c), "UploadAuth": h.config.UploadAuth, "CSRFToken": session.CSRFToken(c)})
+		h.completeBurn(c, paste, nil)
 		return
 	}
 
@@ -194,7 +249,8 @@ func (h *Handler) viewBrowserBurn(c *gin.Context, slug string, paste *models.Pas
 	if err != nil {
 		return
 	}
-	c.HTML(http.StatusOK, "view.html", gin.H{"Title": fmt.Sprintf("NCLIP - Paste %s", paste.ID), "Paste": paste, "IsText": utils.IsTextContent(paste.ContentType), "IsPreview": true, "Content": string(preview), "Version": h.config.Version, "BuildTime": h.config.BuildTime, "CommitHash": h.config.CommitHash, "BaseURL": h.getBaseURL(c), "UploadAuth": h.config.UploadAuth})
+	c.HTML(http.StatusOK, "view.html", gin.H{"Title": fmt.Sprintf("NCLIP - Paste %s", paste.ID), "Paste": paste, "IsText": utils.IsTextContent(paste.ContentType), "IsPreview": true, "Content": string(preview), "Version": h.config.Version, "BuildTime": h.config.BuildTime, "CommitHash": h.config.CommitHash, "BaseURL": h.getBaseURL(c), "UploadAuth": h.config.UploadAuth, "CSRFToken": session.CSRFToken(c)})
+	h.completeBurn(c, paste, nil)
 }
 
 // viewCLI handles CLI (curl/wget/powershell) clients; streams full content or temp file for burn-after-read
@@ -208,15 +264,15 @@ func (h *Handler) viewCLI(c *gin.Context, slug string, paste *models.Paste) {
 	}
 
 	if paste.BurnAfterRead {
-		// Delete paste before streaming so subsequent reads return 404
-		if err := h.service.DeletePaste(slug); err != nil {
-			log.Printf("[ERROR] View CLI: failed to delete burn-after-read paste %s before streaming: %v", slug, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete burn-after-read paste"})
+		// Claim the paste before streaming so other readers get 404; it is
+		// deleted once the transfer completes.
+		if !h.claimBurn(c, paste) {
 			return
 		}
 		c.Header("Content-Type", paste.ContentType)
 		c.Header("Content-Length", fmt.Sprintf("%d", paste.Size))
-		_, _ = c.Writer.Write(content)
+		_, werr := c.Writer.Write(content)
+		h.completeBurn(c, paste, werr)
 		return
 	}
 
@@ -232,7 +288,7 @@ func (h *Handler) viewBrowser(c *gin.Context, slug string, paste *models.Paste)
 	isPreview := false
 
 	// If size <= MaxRenderSize render full, otherwise show preview
-	if paste.Size <= h.config.MaxRenderSize {
+	if paste.Size <= h.maxRenderSize() {
 		full, err := h.loadFullContent(slug, paste)
 		if err != nil {
 			// loadFullContent already logged and rendered appropriate response
@@ -260,34 +316,167 @@ func (h *Handler) viewBrowser(c *gin.Context, slug string, paste *models.Paste)
 		"CommitHash": h.config.CommitHash,
 		"BaseURL":    h.getBaseURL(c),
 		"UploadAuth": h.config.UploadAuth,
+		"OGImage":    preview.Eligible(paste),
+		"Share":      c.Query(access.ShareParam),
+		"CSRFToken":  session.CSRFToken(c),
 	})
 }
 
-// Raw handles raw content download via GET /raw/:slug
+// Raw handles raw content download via GET /raw/:slug. ?head=N, ?tail=N
+// and ?grep=PATTERN select lines of text pastes; see lineFilter.
+// ?encoding=original serves text that was transcoded to UTF-8 at upload
+// in the charset it was uploaded in.
 func (h *Handler) Raw(c *gin.Context) {
+	filter, err := parseLineFilter(c)
+	if err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, err.Error())
+		return
+	}
+	original, err := parseEncoding(c)
+	if err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, err.Error())
+		return
+	}
+	if original && filter != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "Line filters cannot be combined with encoding=original")
+		return
+	}
+	if follow, err := parseFollow(c); err != nil || follow {
+		switch {
+		case err != nil:
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, err.Error())
+		case original || filter != nil || c.Query("version") != "":
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "follow cannot be combined with line filters, encoding or version")
+		default:
+			h.serveFollow(c)
+		}
+		return
+	}
+	h.serveRaw(c, "", false, original, filter)
+}
+
+// parseEncoding reads ?encoding=, reporting whether it asks for the
+// original bytes rather than the stored UTF-8.
+func parseEncoding(c *gin.Context) (bool, error) {
+	switch v := c.Query("encoding"); strings.ToLower(v) {
+	case "", "utf-8", "utf8":
+		return false, nil
+	case "original":
+		return true, nil
+	default:
+		return false, fmt.Errorf("encoding must be original or utf-8, got %q", v)
+	}
+}
+
+// originalContent converts content stored as UTF-8 back to encoding, the
+// charset it was uploaded in, and returns it with a matching content type.
+// Content stored as sent is returned unchanged.
+func originalContent(content []byte, contentType, encoding string) ([]byte, string, error) {
+	if encoding == "" {
+		return content, contentType, nil
+	}
+	original, err := utils.FromUTF8(content, encoding)
+	if err != nil {
+		return nil, "", err
+	}
+	return original, utils.WithCharset(contentType, encoding), nil
+}
+
+// Download handles GET /download/:slug. It serves the same bytes as Raw,
+// with the same read-count and burn-after-read semantics, but always as an
+// attachment so browsers never render the content. ?filename= overrides
+// the suggested name after sanitization.
+func (h *Handler) Download(c *gin.Context) {
+	filename := ""
+	if v, ok := c.GetQuery("filename"); ok {
+		filename = utils.SanitizeFilename(v)
+		if filename == "" {
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "Invalid filename")
+			return
+		}
+	}
+	c.Header("X-Content-Type-Options", "nosniff")
+	h.serveRaw(c, filename, true, false, nil)
+}
+
+// serveRaw implements Raw and Download. An empty filename defaults to the
+// slug plus an extension derived from the content type; attachment forces
+// Content-Disposition: attachment even for text; original converts
+// transcoded text back to its uploaded charset. A non-nil filter serves
+// only the selected lines.
+func (h *Handler) serveRaw(c *gin.Context, filename string, attachment, original bool, filter *lineFilter) {
 	slug := c.Param("slug")
 
 	paste, err := h.service.GetPaste(slug)
 	if err != nil {
 		log.Printf("[ERROR] Raw: %v", err)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Paste not found or deleted"})
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found or deleted")
+		return
+	}
+	if !h.authorize(c, paste, apierror.JSON) {
+		return
+	}
+	version, versioned, err := parseVersion(c)
+	if err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, err.Error())
+		return
+	}
+	if versioned {
+		if filter != nil {
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "Line filters cannot be combined with version")
+			return
+		}
+		if v, content, ok := h.loadVersion(c, paste, version); ok {
+			if original {
+				converted := *v
+				if content, converted.ContentType, err = originalContent(content, v.ContentType, v.Encoding); err != nil {
+					log.Printf("[ERROR] Raw: failed to convert version %d of %s to %s: %v", version, slug, v.Encoding, err)
+					apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to convert paste content")
+					return
+				}
+				v = &converted
+			}
+			h.sendVersion(c, paste, v, content, filename, attachment)
+		}
+		return
+	}
+	if filter != nil {
+		// A filtered read would burn the paste without returning all of it.
+		if paste.BurnAfterRead {
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "Line filters are not available for burn-after-read pastes")
+			return
+		}
+		if !utils.IsTextContent(paste.ContentType) {
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "Line filters are only available for text pastes")
+			return
+		}
+	}
+	if paste.BurnAfterRead && h.config.IsReplica() {
+		h.redirectToWriter(c)
 		return
 	}
 
 	// Early strict size check for Raw: enforce same size_mismatch behavior as View
-	if exists, actualSize, serr := h.store.StatContent(slug); serr == nil && exists {
+	if exists, actualSize, serr := h.store.StatContent(slug); serr == nil && exists && !paste.Appendable {
 		paste, _ := h.service.GetPaste(slug)
 		if paste != nil && actualSize != paste.Size {
 			log.Printf("[ERROR] Raw: early size mismatch for slug %s: metadata=%d actual=%d", slug, paste.Size, actualSize)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "size_mismatch"})
+			apierror.JSON(c, http.StatusInternalServerError, apierror.CodeSizeMismatch, "Size mismatch")
 			return
 		}
 	}
 
-	// Increment read count
-	if err := h.service.IncrementReadCount(slug); err != nil {
-		// Log error but don't fail the request
-		fmt.Printf("Failed to increment read count for %s: %v\n", slug, err)
+	// Increment read count. Range requests resume an earlier download, so
+	// only full requests count as a read.
+	if c.GetHeader("Range") == "" || paste.BurnAfterRead || filter != nil {
+		kind := models.ReadRaw
+		if attachment {
+			kind = models.ReadDownload
+		}
+		if err := h.service.IncrementReadCount(slug, kind); err != nil {
+			// Log error but don't fail the request
+			fmt.Printf("Failed to increment read count for %s: %v\n", slug, err)
+		}
 	}
 
 	// Defer content load until after burn-after-read branch to avoid
@@ -295,42 +484,145 @@ func (h *Handler) Raw(c *gin.Context) {
 
 	// If burn-after-read, delete the paste so subsequent accesses return 404.
 	// Serve the content for this request (first read) then delete the stored data.
+	if filename == "" {
+		filename = defaultFilename(slug, paste)
+	}
 	if paste.BurnAfterRead {
-		if ok := h.handleRawBurn(c, slug, paste); !ok {
-			return
-		}
+		h.handleRawBurn(c, slug, paste, filename, attachment, original)
+		return
+	}
+	if filter != nil {
+		h.serveFiltered(c, slug, filter)
+		return
 	}
 	// Non-burn path: load content now and validate size before serving
 	content, cerr := h.service.GetPasteContent(slug)
 	if cerr != nil {
 		log.Printf("[ERROR] Raw: content not found or deleted for slug %s: %v", slug, cerr)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Paste content not found or deleted"})
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste content not found or deleted")
 		return
 	}
 	// NOTE: early size verification is performed in View(); do not do late checks here.
-	c.Header("Content-Type", paste.ContentType)
-	c.Header("Content-Length", fmt.Sprintf("%d", paste.Size))
-	ext := utils.ExtensionByMime(paste.ContentType)
-	filename := slug
-	if ext != "" {
-		filename = slug + ext
-	}
-	escaped := url.PathEscape(filename)
-	if utils.IsTextContent(paste.ContentType) {
-		c.Header("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"; filename*=UTF-8''%s", filename, escaped))
-	} else {
-		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"; filename*=UTF-8''%s", filename, escaped))
+	contentType := paste.ContentType
+	if original {
+		if content, contentType, cerr = originalContent(content, contentType, paste.Encoding); cerr != nil {
+			log.Printf("[ERROR] Raw: failed to convert %s to %s: %v", slug, paste.Encoding, cerr)
+			apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to convert paste content")
+			return
+		}
 	}
-	c.Data(http.StatusOK, paste.ContentType, content)
+	c.Header("Content-Type", contentType)
+	c.Header("Accept-Ranges", "bytes")
+	// The signature covers the full content, also for Range requests.
+	h.sign(c, slug, content)
+	setContentDisposition(c, filename, attachment || !utils.IsTextContent(contentType))
+	// ServeContent handles Range/If-Range so the web UI can pause and resume
+	// large downloads; it also sets Content-Length. Last-Modified is the
+	// uploaded file's mtime when one was sent, for curl -R and wget.
+	http.ServeContent(c.Writer, c.Request, filename, paste.ModTime(), bytes.NewReader(content))
 }
 
-// getBaseURL returns the base URL for the application
+// serveFiltered streams the lines of slug selected by filter. The output
+// length is unknown up front, so Range is ignored and the response is
+// chunked.
+func (h *Handler) serveFiltered(c *gin.Context, slug string, filter *lineFilter) {
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Header("X-Content-Type-Options", "nosniff")
+	w := bufio.NewWriter(c.Writer)
+	err := filter.write(w, func() (io.ReadCloser, error) {
+		return storage.OpenContent(h.store, slug)
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		if !c.Writer.Written() {
+			c.Status(http.StatusOK)
+			c.Writer.WriteHeaderNow()
+		}
+		return
+	}
+	if c.Writer.Written() {
+		log.Printf("[ERROR] Raw: filtered read of %s failed: %v", slug, err)
+		return
+	}
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste content not found or deleted")
+		return
+	}
+	log.Printf("[ERROR] Raw: filtered read of %s failed: %v", slug, err)
+	apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read paste content")
+}
+
+// Preview handles GET /preview/:slug.png, serving a PNG snapshot of the
+// first lines of a text paste for OpenGraph link previews. The image is
+// rendered on first request and cached in the store under <slug>.png.
+// Fetching a preview neither counts as a read nor burns the paste.
+func (h *Handler) Preview(c *gin.Context) {
+	slug, ok := strings.CutSuffix(c.Param("file"), ".png")
+	if !ok || !utils.IsValidSlug(slug) {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Preview not found")
+		return
+	}
+	paste, err := h.service.GetPaste(slug)
+	if err != nil || !preview.Eligible(paste) {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Preview not found")
+		return
+	}
+
+	img, err := h.store.GetContent(preview.CacheID(slug))
+	if err != nil || len(img) == 0 {
+		content, err := h.store.GetContentPrefix(slug, preview.MaxInput)
+		if err != nil {
+			log.Printf("[ERROR] Preview: failed to read content for %s: %v", slug, err)
+			apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Preview not found")
+			return
+		}
+		img, err = preview.Render(content)
+		if err != nil {
+			log.Printf("[ERROR] Preview: failed to render %s: %v", slug, err)
+			apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to render preview")
+			return
+		}
+		if err := h.store.StoreContent(preview.CacheID(slug), img); err != nil && utils.IsDebugEnabled() {
+			log.Printf("[DEBUG] Preview: not caching preview for %s: %v", slug, err)
+		}
+	}
+
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.Data(http.StatusOK, preview.ContentType, img)
+}
+
+// authorize reports whether the request may read paste, responding with
+// respond, apierror.JSON or renderError, when it may not. Private pastes are hidden behind a 404 so their
+// existence is not revealed. Pastes marked corrupt get a 500
+// content_corrupt instead of their content; only their earlier versions
+// are still served. No cache may store the responses of private and
+// quarantined pastes, which others must not read, nor of burn-after-read
+// pastes, which must be gone after this read.
+func (h *Handler) authorize(c *gin.Context, paste *models.Paste, respond func(*gin.Context, int, apierror.Code, string)) bool {
+	if !h.access.CanRead(c, paste) {
+		respond(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found or deleted")
+		return false
+	}
+	if _, versioned, _ := parseVersion(c); paste.Corrupt && !versioned {
+		respond(c, http.StatusInternalServerError, apierror.CodeContentCorrupt, "Paste content is corrupt")
+		return false
+	}
+	if paste.IsPrivate() || paste.Quarantined || paste.BurnAfterRead {
+		respheaders.SetNoStore(c.Writer.Header())
+	}
+	return true
+}
+
+// getBaseURL returns the base URL for the application, including the
+// forwarded path prefix and the route prefix.
 func (h *Handler) getBaseURL(c *gin.Context) string {
 	scheme := "http"
 	if h.isHTTPS(c) {
 		scheme = "https"
 	}
-	return fmt.Sprintf("%s://%s", scheme, c.Request.Host)
+	return fmt.Sprintf("%s://%s%s%s", scheme, c.Request.Host, forwarded.PathPrefix(c), h.config.RoutePrefix)
 }
 
 // loadFullContent loads the entire content for small pastes and performs
@@ -357,23 +649,21 @@ func (h *Handler) loadPreviewContent(c *gin.Context, slug string, paste *models.
 	if paste.BurnAfterRead {
 		// Read up to MaxRenderSize, verify full size via StatContent when possible,
 		// delete the paste, and return the prefix for preview rendering.
-		prefix, err := h.store.GetContentPrefix(slug, h.config.MaxRenderSize)
+		prefix, err := h.store.GetContentPrefix(slug, h.maxRenderSize())
 		if err != nil {
 			log.Printf("[ERROR] View Browser: failed to read preview from store for %s: %v", slug, err)
 			h.renderNotFound(c, "Paste not available or deleted")
 			return nil, err
 		}
-		// Verify size matches metadata before deleting, if we can stat the content.
-		// No late verification of size here; delete paste and return preview.
-		if err := h.service.DeletePaste(slug); err != nil {
-			log.Printf("[ERROR] View Browser: failed to delete burn-after-read paste %s during preview: %v", slug, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete burn-after-read paste"})
-			return nil, err
+		// Claim the paste; the caller completes the burn once the preview
+		// is rendered.
+		if !h.claimBurn(c, paste) {
+			return nil, services.ErrBurnClaimed
 		}
 		return prefix, nil
 	}
 
-	prefix, err := h.store.GetContentPrefix(slug, h.config.MaxRenderSize)
+	prefix, err := h.store.GetContentPrefix(slug, h.maxRenderSize())
 	if err != nil {
 		log.Printf("[ERROR] View Browser: failed to read preview from store for %s: %v", slug, err)
 		h.renderNotFound(c, "Paste not available or deleted")
@@ -382,57 +672,146 @@ func (h *Handler) loadPreviewContent(c *gin.Context, slug string, paste *models.
 	return prefix, nil
 }
 
-// handleRawBurn performs the burn-after-read flow for Raw: it moves files to
-// temporary burn paths, validates size, deletes metadata, streams the file
-// to the response, and cleans up. It returns true on success (streamed and
-// returned) or false if the caller should stop processing.
-func (h *Handler) handleRawBurn(c *gin.Context, slug string, paste *models.Paste) bool {
+// handleRawBurn performs the burn-after-read flow for Raw: it reads the
+// content, deletes the paste and streams the bytes. Range headers are
+// ignored because a burn paste can only be read once. It writes the full
+// response, including errors.
+func (h *Handler) handleRawBurn(c *gin.Context, slug string, paste *models.Paste, filename string, attachment, original bool) {
 	// Unified handler-level burn: read full content, verify size, delete paste, then stream the bytes.
 	// Read full content, verify size, delete the paste, then stream the bytes.
 	content, err := h.service.GetPasteContent(slug)
 	if err != nil {
 		log.Printf("[ERROR] Raw: content not found or deleted for slug %s: %v", slug, err)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Paste not found or deleted"})
-		return false
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found or deleted")
+		return
+	}
+	contentType := paste.ContentType
+	if original {
+		if content, contentType, err = originalContent(content, contentType, paste.Encoding); err != nil {
+			log.Printf("[ERROR] Raw: failed to convert %s to %s: %v", slug, paste.Encoding, err)
+			apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to convert paste content")
+			return
+		}
+	}
+	// No late size mismatch checks; claim the paste, stream, then burn it.
+	if !h.claimBurn(c, paste) {
+		return
 	}
-	// No late size mismatch checks; proceed to delete and stream.
-	if err := h.service.DeletePaste(slug); err != nil {
-		log.Printf("[ERROR] Raw: failed to delete burn-after-read paste %s before streaming: %v", slug, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete burn-after-read paste"})
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Length", fmt.Sprintf("%d", len(content)))
+	c.Header("Last-Modified", paste.ModTime().UTC().Format(http.TimeFormat))
+	// The content is gone after this response; no cache may keep it.
+	respheaders.SetNoStore(c.Writer.Header())
+	setContentDisposition(c, filename, attachment || !utils.IsTextContent(contentType))
+	h.sign(c, slug, content)
+	_, werr := c.Writer.Write(content)
+	h.completeBurn(c, paste, werr)
+}
+
+// defaultFilename names a download after the uploader's filename, or
+// else its slug with an extension derived from the content type.
+func defaultFilename(slug string, paste *models.Paste) string {
+	if paste.Filename != "" {
+		return paste.Filename
+	}
+	return slug + utils.ExtensionByMime(paste.ContentType)
+}
+
+// setContentDisposition sets an inline or attachment Content-Disposition
+// with both the plain and RFC 5987 encoded filename.
+func setContentDisposition(c *gin.Context, filename string, attachment bool) {
+	c.Header("Content-Disposition", utils.ContentDisposition(filename, attachment))
+}
+
+// claimBurn claims a burn-after-read paste before its content is sent, so
+// other readers get 404 while the transfer runs. Pastes under legal hold
+// are served but never claimed or burned. It reports whether to send the
+// content; otherwise it has written the error response.
+func (h *Handler) claimBurn(c *gin.Context, paste *models.Paste) bool {
+	if paste.LegalHold {
+		audit.Record(c, audit.ActionBurn, paste.ID, audit.ResultFailure, "legal hold")
+		return true
+	}
+	err := h.service.ClaimBurn(paste.ID, burnClaimant(c))
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, services.ErrBurnClaimed) {
+		h.renderNotFound(c, "Paste not available or deleted")
 		return false
 	}
-	c.Header("Content-Type", paste.ContentType)
-	c.Header("Content-Length", fmt.Sprintf("%d", paste.Size))
-	ext := utils.ExtensionByMime(paste.ContentType)
-	filename := slug
-	if ext != "" {
-		filename = slug + ext
-	}
-	escaped := url.PathEscape(filename)
-	if utils.IsTextContent(paste.ContentType) {
-		c.Header("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"; filename*=UTF-8''%s", filename, escaped))
-	} else {
-		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"; filename*=UTF-8''%s", filename, escaped))
+	log.Printf("[ERROR] Failed to claim burn-after-read paste %s: %v", paste.ID, err)
+	audit.Record(c, audit.ActionBurn, paste.ID, audit.ResultFailure, err.Error())
+	h.renderError(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to claim burn-after-read paste")
+	return false
+}
+
+// completeBurn deletes a claimed paste once its content was sent and
+// records the burn in the audit log. When the transfer failed (werr, or
+// the client went away) the claim is kept, so the same client can retry
+// until it times out.
+func (h *Handler) completeBurn(c *gin.Context, paste *models.Paste, werr error) {
+	if paste.LegalHold {
+		return
 	}
-	_, _ = c.Writer.Write(content)
-	return true
+	if werr == nil {
+		werr = c.Request.Context().Err()
+	}
+	if werr != nil {
+		log.Printf("[WARN] Transfer of burn-after-read paste %s did not complete, keeping it claimed for a retry: %v", paste.ID, werr)
+		audit.Record(c, audit.ActionBurn, paste.ID, audit.ResultFailure, "transfer interrupted")
+		return
+	}
+	err := h.service.CompleteBurn(paste, burnnotify.NewReader("http", c.ClientIP(), c.GetHeader("User-Agent")))
+	if errors.Is(err, services.ErrLegalHold) {
+		audit.Record(c, audit.ActionBurn, paste.ID, audit.ResultFailure, "legal hold")
+		return
+	}
+	if err != nil {
+		// The claim times out and the paste is deleted on its next lookup.
+		log.Printf("[ERROR] Failed to delete burn-after-read paste %s: %v", paste.ID, err)
+		audit.Record(c, audit.ActionBurn, paste.ID, audit.ResultFailure, err.Error())
+		return
+	}
+	audit.Record(c, audit.ActionBurn, paste.ID, audit.ResultSuccess, "")
+}
+
+// burnClaimant identifies the client reading a burn-after-read paste, so
+// only it may retry an interrupted transfer.
+func burnClaimant(c *gin.Context) string {
+	sum := sha256.Sum256([]byte(c.ClientIP() + "\x00" + c.GetHeader("User-Agent") + "\x00" + access.APIKey(c)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// redirectToWriter sends a read that must modify the backend (burn-after-read)
+// from a replica to the writer, the only instance allowed to delete pastes.
+func (h *Handler) redirectToWriter(c *gin.Context) {
+	if h.config.WriterURL == "" {
+		h.renderError(c, http.StatusForbidden, apierror.CodeReadOnlyReplica, services.ErrWriterOnly.Error())
+		return
+	}
+	c.Redirect(http.StatusTemporaryRedirect, strings.TrimRight(h.config.WriterURL, "/")+c.Request.URL.RequestURI())
 }
 
 // renderNotFound sends a consistent 404 response. CLI/API clients receive JSON,
-// while browser clients receive the HTML view with a friendly message.
+// while browser clients receive the HTML error page with a friendly message.
 func (h *Handler) renderNotFound(c *gin.Context, message string) {
+	h.renderError(c, http.StatusNotFound, apierror.CodeNotFound, message)
+}
+
+// renderError sends a typed error response: JSON for CLI/API clients and the
+// themable error page for browsers.
+func (h *Handler) renderError(c *gin.Context, status int, code apierror.Code, message string) {
 	if h.isCli(c) {
-		c.JSON(http.StatusNotFound, gin.H{"error": message})
-	} else {
-		c.Header("Content-Type", "text/html; charset=utf-8")
-		c.HTML(http.StatusNotFound, "view.html", gin.H{
-			"Title":      "NCLIP - Not Found",
-			"Error":      message,
-			"Version":    h.config.Version,
-			"BuildTime":  h.config.BuildTime,
-			"CommitHash": h.config.CommitHash,
-			"BaseURL":    h.getBaseURL(c),
-			"UploadAuth": h.config.UploadAuth,
-		})
+		apierror.JSON(c, status, code, message)
+		return
 	}
+	apierror.HTML(c, status, code, message, gin.H{
+		"Version":    h.config.Version,
+		"BuildTime":  h.config.BuildTime,
+		"CommitHash": h.config.CommitHash,
+		"BaseURL":    h.getBaseURL(c),
+		"UploadAuth": h.config.UploadAuth,
+		"CSRFToken":  session.CSRFToken(c),
+	})
 }
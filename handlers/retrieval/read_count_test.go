@@ -0,0 +1,63 @@
+package retrieval
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+// Test that views, raw fetches and downloads are counted separately.
+func TestReadCounters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dataDir := t.TempDir()
+	cfg := &config.Config{MaxRenderSize: 1024, DataDir: dataDir}
+	store, err := storage.NewFilesystemStore(dataDir)
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	rh := NewHandler(services.NewPasteService(store, cfg), store, cfg)
+
+	content := []byte("counted")
+	if err := store.StoreContent("CNT22", content); err != nil {
+		t.Fatalf("failed to store content: %v", err)
+	}
+	if err := store.Store(&models.Paste{ID: "CNT22", CreatedAt: time.Now(), Size: int64(len(content)), ContentType: "text/plain"}); err != nil {
+		t.Fatalf("failed to store paste metadata: %v", err)
+	}
+
+	router := gin.New()
+	router.GET("/:slug", rh.View)
+	router.GET("/raw/:slug", rh.Raw)
+	router.GET("/download/:slug", rh.Download)
+	get := func(path string, header http.Header) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header = header
+		req.Header.Set("User-Agent", "curl/8.0")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK && w.Code != http.StatusPartialContent {
+			t.Fatalf("GET %s: expected success, got %d: %s", path, w.Code, w.Body.String())
+		}
+	}
+	get("/CNT22", http.Header{})
+	get("/raw/CNT22", http.Header{})
+	get("/raw/CNT22", http.Header{})
+	get("/download/CNT22", http.Header{})
+	// Ranged requests resume a download and are not counted.
+	get("/download/CNT22", http.Header{"Range": []string{"bytes=0-2"}})
+
+	p, err := store.Get("CNT22")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if p.ReadCount != 4 || p.Views != 1 || p.RawReads != 2 || p.Downloads != 1 {
+		t.Errorf("unexpected counters: read_count=%d views=%d raw_reads=%d downloads=%d", p.ReadCount, p.Views, p.RawReads, p.Downloads)
+	}
+}
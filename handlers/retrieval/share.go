@@ -0,0 +1,65 @@
+package retrieval
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// shareRequest is the optional JSON body of POST /api/v1/pastes/:slug/share.
+type shareRequest struct {
+	// ExpiresIn is how long the link stays valid (default 24h, max 30d).
+	ExpiresIn string `json:"expires_in"`
+}
+
+// Share handles POST /api/v1/pastes/:slug/share, signing a link that lets
+// anyone read a private paste until it expires. Only the API key that
+// uploaded the paste may share it; other callers get 404.
+func (h *Handler) Share(c *gin.Context) {
+	slug := c.Param("slug")
+	if !utils.IsValidSlug(slug) {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidSlug, "Invalid slug format")
+		return
+	}
+	var req shareRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "invalid JSON body")
+		return
+	}
+	validity := access.DefaultShareValidity
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 || d > access.MaxShareValidity {
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest,
+				fmt.Sprintf("expires_in must be a duration between 1s and %s", access.MaxShareValidity))
+			return
+		}
+		validity = d
+	}
+
+	owner := h.access.Owner(c)
+	paste, err := h.service.GetPaste(slug)
+	if err != nil || !paste.IsPrivate() || owner == "" || owner != paste.Owner {
+		audit.Record(c, audit.ActionShare, slug, audit.ResultFailure, "not found or not owner")
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Private paste not found")
+		return
+	}
+
+	expires := time.Now().Add(validity)
+	token := h.access.ShareToken(slug, expires)
+	audit.Record(c, audit.ActionShare, slug, audit.ResultSuccess, "expires="+expires.UTC().Format(time.RFC3339))
+	c.JSON(http.StatusOK, gin.H{
+		"url":        h.getBaseURL(c) + "/" + slug + "?" + access.ShareParam + "=" + url.QueryEscape(token),
+		"expires_at": expires.UTC().Truncate(time.Second),
+	})
+}
@@ -0,0 +1,80 @@
+package retrieval
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/internal/signing"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func TestRaw_Signature(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dataDir := t.TempDir()
+	cfg := &config.Config{MaxRenderSize: 1024, DataDir: dataDir}
+	store, err := storage.NewFilesystemStore(dataDir)
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	signer, err := signing.Parse(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh := NewHandler(services.NewPasteService(store, cfg), store, cfg)
+	rh.SetSigner(signer)
+
+	content := []byte("release artifact\nline two\n")
+	for _, p := range []*models.Paste{
+		{ID: "SGN22", CreatedAt: time.Now(), Size: int64(len(content)), ContentType: "text/plain"},
+		{ID: "SGB22", CreatedAt: time.Now(), Size: int64(len(content)), ContentType: "text/plain", BurnAfterRead: true},
+	} {
+		if err := store.StoreContent(p.ID, content); err != nil {
+			t.Fatalf("failed to store content: %v", err)
+		}
+		if err := store.Store(p); err != nil {
+			t.Fatalf("failed to store paste metadata: %v", err)
+		}
+	}
+
+	router := gin.New()
+	router.GET("/raw/:slug", rh.Raw)
+	router.GET("/download/:slug", rh.Download)
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, path := range []string{"/raw/SGN22", "/download/SGN22", "/raw/SGB22"} {
+		w := get(path, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: got %d", path, w.Code)
+		}
+		slug := path[len(path)-5:]
+		if !signing.Verify(signer.PublicKey(), slug, w.Body.Bytes(), w.Header().Get(signing.Header)) {
+			t.Errorf("GET %s: signature %q does not verify", path, w.Header().Get(signing.Header))
+		}
+	}
+
+	// Range responses carry the signature of the full content.
+	w := get("/raw/SGN22", http.Header{"Range": []string{"bytes=0-6"}})
+	if w.Code != http.StatusPartialContent || !signing.Verify(signer.PublicKey(), "SGN22", content, w.Header().Get(signing.Header)) {
+		t.Errorf("range request: got %d with signature %q", w.Code, w.Header().Get(signing.Header))
+	}
+	// Filtered output is not the signed artifact.
+	if w := get("/raw/SGN22?head=1", nil); w.Header().Get(signing.Header) != "" {
+		t.Error("filtered response was signed")
+	}
+}
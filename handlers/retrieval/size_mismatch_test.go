@@ -100,3 +100,33 @@ func TestRaw_SizeMismatch_NonBurn(t *testing.T) {
 		t.Fatalf("expected size_mismatch in raw response body, got: %s", w.Body.String())
 	}
 }
+
+// Pastes marked corrupt by "nclip audit --repair" are not served.
+func TestRaw_Corrupt(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dataDir := t.TempDir()
+	cfg := &config.Config{MaxRenderSize: 1024, DataDir: dataDir}
+	store, err := storage.NewFilesystemStore(dataDir)
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	rh := NewHandler(services.NewPasteService(store, cfg), store, cfg)
+
+	paste := &models.Paste{ID: "PQR45", CreatedAt: time.Now(), Size: 3, ContentType: "text/plain", Corrupt: true}
+	if err := store.StoreContent(paste.ID, []byte("abc")); err != nil {
+		t.Fatalf("failed to store content: %v", err)
+	}
+	if err := store.Store(paste); err != nil {
+		t.Fatalf("failed to store paste metadata: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/raw/PQR45", nil)
+	c.Params = gin.Params{{Key: "slug", Value: paste.ID}}
+	rh.Raw(c)
+
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "content_corrupt") {
+		t.Fatalf("expected 500 content_corrupt, got %d: %s", w.Code, w.Body.String())
+	}
+}
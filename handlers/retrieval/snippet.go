@@ -0,0 +1,186 @@
+package retrieval
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// Snippet formats, selected by the extension of GET /:slug.ext.
+const (
+	snippetText     = "txt"
+	snippetMarkdown = "md"
+	snippetHTML     = "html"
+)
+
+// splitSnippet splits the :slug parameter of GET /:slug into the slug and
+// the snippet format its extension selects. ok is false when the
+// parameter has no snippet extension.
+func splitSnippet(param string) (slug, format string, ok bool) {
+	slug, ext, found := strings.Cut(param, ".")
+	if !found {
+		return param, "", false
+	}
+	switch ext {
+	case snippetText, snippetMarkdown, snippetHTML:
+		return slug, ext, true
+	}
+	return param, "", false
+}
+
+// snippet handles GET /:slug.txt, /:slug.md and /:slug.html, which serve a
+// text paste in one representation whatever the Accept header says: the
+// raw text, the text in a fenced Markdown code block under a metadata
+// header, or a standalone page that prints well. They suit places that
+// append an extension to links or need a given type. Burn-after-read
+// pastes are refused, since a link preview would burn them, and the
+// Markdown and HTML forms are cut off after MaxRenderSize.
+func (h *Handler) snippet(c *gin.Context, slug, format string) {
+	if !utils.IsValidSlug(slug) {
+		h.renderError(c, http.StatusBadRequest, apierror.CodeInvalidSlug, "Invalid slug format")
+		return
+	}
+	paste, err := h.service.GetPaste(slug)
+	if err != nil {
+		h.renderNotFound(c, "Paste not found or deleted")
+		return
+	}
+	if !h.authorize(c, paste, h.renderError) {
+		return
+	}
+	if paste.BurnAfterRead {
+		h.renderError(c, http.StatusForbidden, apierror.CodeSnippetForbidden, "Burn-after-read pastes are not available as ."+format)
+		return
+	}
+	if !utils.IsTextContent(paste.ContentType) {
+		h.renderError(c, http.StatusForbidden, apierror.CodeSnippetForbidden, "Only text pastes are available as ."+format)
+		return
+	}
+
+	kind := models.ReadView
+	var content []byte
+	if format == snippetText {
+		kind = models.ReadRaw
+		content, err = h.service.GetPasteContent(slug)
+	} else {
+		content, err = h.store.GetContentPrefix(slug, h.maxRenderSize())
+	}
+	if err != nil {
+		log.Printf("[ERROR] Snippet: failed to read content for %s: %v", slug, err)
+		h.renderNotFound(c, "Paste not available or deleted")
+		return
+	}
+	if err := h.service.IncrementReadCount(slug, kind); err != nil {
+		log.Printf("[WARN] Snippet: failed to increment read count for %s: %v", slug, err)
+	}
+	truncated := int64(len(content)) < paste.Size
+	baseURL := h.getBaseURL(c)
+
+	switch format {
+	case snippetText:
+		c.Header("Content-Disposition", "inline")
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", content)
+	case snippetMarkdown:
+		c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(markdownSnippet(paste, string(content), truncated, baseURL)))
+	case snippetHTML:
+		c.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+		c.HTML(http.StatusOK, "snippet.html", gin.H{
+			"Title":     "NCLIP - Paste " + paste.ID,
+			"Paste":     paste,
+			"Content":   string(content),
+			"Truncated": truncated,
+			"Expires":   snippetExpiry(paste),
+			"PasteURL":  baseURL + "/" + paste.ID,
+			"RawURL":    baseURL + "/raw/" + paste.ID,
+		})
+	}
+}
+
+// markdownSnippet renders paste as a Markdown document: a heading, a list
+// of its metadata and its content in a fenced code block.
+func markdownSnippet(paste *models.Paste, content string, truncated bool, baseURL string) string {
+	var b strings.Builder
+	title := paste.Filename
+	if title == "" {
+		title = "Paste " + paste.ID
+	}
+	fmt.Fprintf(&b, "# %s\n\n", title)
+	fmt.Fprintf(&b, "- Source: %s/%s\n", baseURL, paste.ID)
+	fmt.Fprintf(&b, "- Created: %s\n", paste.CreatedAt.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "- Expires: %s\n", snippetExpiry(paste))
+	fmt.Fprintf(&b, "- Type: %s\n", paste.ContentType)
+	fmt.Fprintf(&b, "- Size: %d bytes\n\n", paste.Size)
+
+	// The fence is longer than any run of backticks in the content, so
+	// the content cannot close it.
+	fence := strings.Repeat("`", max(3, longestRun(content, '`')+1))
+	content = strings.TrimSuffix(content, "\n")
+	fmt.Fprintf(&b, "%s%s\n%s\n%s\n", fence, fenceLanguage(paste), content, fence)
+	if truncated {
+		fmt.Fprintf(&b, "\nOnly the beginning of this paste is shown. The full text is at %s/raw/%s\n", baseURL, paste.ID)
+	}
+	return b.String()
+}
+
+// snippetExpiry describes when paste expires.
+func snippetExpiry(paste *models.Paste) string {
+	if paste.ExpiresAt == nil || paste.Pinned || paste.LegalHold {
+		return "never"
+	}
+	return paste.ExpiresAt.UTC().Format(time.RFC3339)
+}
+
+// fenceLanguage returns the info string of paste's code block: its
+// filename's extension, the language reported by the service it was
+// imported from, or a name for a few structured content types.
+func fenceLanguage(paste *models.Paste) string {
+	if ext := strings.TrimPrefix(path.Ext(paste.Filename), "."); ext != "" {
+		return fenceWord(ext)
+	}
+	if paste.Origin != nil && paste.Origin.Language != "" {
+		return fenceWord(paste.Origin.Language)
+	}
+	base, _, _ := mime.ParseMediaType(paste.ContentType)
+	switch base {
+	case "application/json", "application/geo+json":
+		return "json"
+	case "text/csv":
+		return "csv"
+	case "text/markdown":
+		return "markdown"
+	}
+	return ""
+}
+
+// fenceWord returns s lowercased if it is alphanumeric, and "" otherwise.
+func fenceWord(s string) string {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return ""
+		}
+	}
+	return strings.ToLower(s)
+}
+
+// longestRun returns the length of the longest run of ch in s.
+func longestRun(s string, ch byte) int {
+	longest, run := 0, 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == ch {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return longest
+}
@@ -0,0 +1,213 @@
+package retrieval
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/respheaders"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// tokenRequest is the optional JSON body of POST
+// /api/v1/pastes/:slug/tokens.
+type tokenRequest struct {
+	// MaxUses is how many reads the token allows (default 0, unlimited).
+	MaxUses int `json:"max_uses"`
+	// ExpiresIn is how long the token stays valid (default: as long as
+	// the paste, max 30d).
+	ExpiresIn string `json:"expires_in"`
+}
+
+// tokenResponse describes a share token to its owner.
+type tokenResponse struct {
+	Token     string     `json:"token"`
+	URL       string     `json:"url"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// SetTokens enables share tokens.
+func (h *Handler) SetTokens(tokens *services.TokenService) {
+	h.tokens = tokens
+}
+
+func (h *Handler) tokenResponse(c *gin.Context, t *models.ShareToken) tokenResponse {
+	return tokenResponse{
+		Token:     t.Token,
+		URL:       h.getBaseURL(c) + "/t/" + t.Token,
+		MaxUses:   t.MaxUses,
+		Uses:      t.Uses,
+		CreatedAt: t.CreatedAt,
+		ExpiresAt: t.ExpiresAt,
+	}
+}
+
+// ownedPaste returns the paste named by the slug parameter when it was
+// uploaded with the caller's API key. Otherwise it writes the error
+// response, a 404 for other callers' pastes, audits it when action is
+// set, and returns nil.
+func (h *Handler) ownedPaste(c *gin.Context, action string) *models.Paste {
+	slug := c.Param("slug")
+	if !utils.IsValidSlug(slug) {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidSlug, "Invalid slug format")
+		return nil
+	}
+	owner := h.access.Owner(c)
+	paste, err := h.service.GetPaste(slug)
+	if err != nil || owner == "" || owner != paste.Owner {
+		if action != "" {
+			audit.Record(c, action, slug, audit.ResultFailure, "not found or not owner")
+		}
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found")
+		return nil
+	}
+	return paste
+}
+
+// CreateToken handles POST /api/v1/pastes/:slug/tokens, issuing a share
+// token that reads the paste at /t/<token> without revealing its slug.
+// Only the API key that uploaded the paste may issue tokens.
+func (h *Handler) CreateToken(c *gin.Context) {
+	var req tokenRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "invalid JSON body")
+		return
+	}
+	if req.MaxUses < 0 || req.MaxUses > models.MaxTokenUses {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest,
+			fmt.Sprintf("max_uses must be between 0 (unlimited) and %d", models.MaxTokenUses))
+		return
+	}
+	var ttl time.Duration
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 || d > services.MaxTokenTTL {
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest,
+				fmt.Sprintf("expires_in must be a duration between 1s and %s", services.MaxTokenTTL))
+			return
+		}
+		ttl = d
+	}
+	paste := h.ownedPaste(c, audit.ActionTokenCreate)
+	if paste == nil {
+		return
+	}
+	t, err := h.tokens.CreateToken(paste, h.access.Owner(c), req.MaxUses, ttl)
+	if err != nil {
+		h.tokenError(c, audit.ActionTokenCreate, paste.ID, err)
+		return
+	}
+	audit.Record(c, audit.ActionTokenCreate, paste.ID, audit.ResultSuccess, "max_uses="+strconv.Itoa(t.MaxUses))
+	c.JSON(http.StatusCreated, h.tokenResponse(c, t))
+}
+
+// ListTokens handles GET /api/v1/pastes/:slug/tokens, listing the live
+// share tokens of a paste to its uploader.
+func (h *Handler) ListTokens(c *gin.Context) {
+	paste := h.ownedPaste(c, "")
+	if paste == nil {
+		return
+	}
+	tokens, err := h.tokens.ListTokens(paste.ID)
+	if err != nil {
+		h.tokenError(c, "", paste.ID, err)
+		return
+	}
+	resp := make([]tokenResponse, 0, len(tokens))
+	for _, t := range tokens {
+		resp = append(resp, h.tokenResponse(c, t))
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": resp})
+}
+
+// RevokeToken handles DELETE /api/v1/pastes/:slug/tokens/:token. Other
+// tokens of the paste keep working.
+func (h *Handler) RevokeToken(c *gin.Context) {
+	paste := h.ownedPaste(c, audit.ActionTokenRevoke)
+	if paste == nil {
+		return
+	}
+	if err := h.tokens.RevokeToken(paste.ID, c.Param("token")); err != nil {
+		h.tokenError(c, audit.ActionTokenRevoke, paste.ID, err)
+		return
+	}
+	audit.Record(c, audit.ActionTokenRevoke, paste.ID, audit.ResultSuccess, "")
+	c.JSON(http.StatusOK, gin.H{"revoked": true})
+}
+
+// tokenError writes the response for a TokenService error, auditing it
+// when action is set.
+func (h *Handler) tokenError(c *gin.Context, action, slug string, err error) {
+	status, code, msg := http.StatusInternalServerError, apierror.CodeInternal, "Share token operation failed"
+	switch {
+	case errors.Is(err, services.ErrTokenNotFound):
+		status, code, msg = http.StatusNotFound, apierror.CodeNotFound, "Share token not found"
+	case errors.Is(err, services.ErrTokenBurn):
+		status, code, msg = http.StatusBadRequest, apierror.CodeBadRequest, err.Error()
+	case errors.Is(err, services.ErrTokenLimit):
+		status, code, msg = http.StatusConflict, apierror.CodeTokenLimit, err.Error()
+	case errors.Is(err, services.ErrTokensDisabled):
+		status, code, msg = http.StatusNotImplemented, apierror.CodeUnsupported, err.Error()
+	default:
+		log.Printf("[ERROR] Tokens: %s: %v", slug, err)
+	}
+	if action != "" {
+		audit.Record(c, action, slug, audit.ResultFailure, msg)
+	}
+	apierror.JSON(c, status, code, msg)
+}
+
+// Token handles GET /t/:token, serving the content of the paste a share
+// token reads and spending one of its uses. The response never reveals
+// the slug: the download is named after the uploader's filename or
+// "paste". Token reads count as raw reads. Since uses are recorded in
+// storage, replicas send token reads to the writer.
+func (h *Handler) Token(c *gin.Context) {
+	if h.config.IsReplica() {
+		h.redirectToWriter(c)
+		return
+	}
+	_, paste, err := h.tokens.UseToken(c.Param("token"))
+	if errors.Is(err, services.ErrContentCorrupt) {
+		h.renderError(c, http.StatusInternalServerError, apierror.CodeContentCorrupt, "Paste content is corrupt")
+		return
+	}
+	if err != nil {
+		if !errors.Is(err, services.ErrTokenNotFound) {
+			log.Printf("[ERROR] Token: %v", err)
+		}
+		h.renderNotFound(c, "Share link not found, expired or used up")
+		return
+	}
+	content, err := h.service.GetPasteContent(paste.ID)
+	if err != nil {
+		log.Printf("[ERROR] Token: content of %s not found or deleted: %v", paste.ID, err)
+		h.renderNotFound(c, "Share link not found, expired or used up")
+		return
+	}
+	if err := h.service.IncrementReadCount(paste.ID, models.ReadRaw); err != nil {
+		log.Printf("[WARN] Token: failed to increment read count for %s: %v", paste.ID, err)
+	}
+	filename := paste.Filename
+	if filename == "" {
+		filename = "paste" + utils.ExtensionByMime(paste.ContentType)
+	}
+	respheaders.SetNoStore(c.Writer.Header())
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("X-Content-Type-Options", "nosniff")
+	setContentDisposition(c, filename, !utils.IsTextContent(paste.ContentType))
+	c.Data(http.StatusOK, paste.ContentType, content)
+}
@@ -0,0 +1,171 @@
+package retrieval
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/hmackeys"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+// Test that share tokens read a paste without its slug, honour their own
+// use limits and can be revoked one by one.
+func TestShareTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dataDir := t.TempDir()
+	cfg := &config.Config{MaxRenderSize: 1024, DataDir: dataDir}
+	store, err := storage.NewFilesystemStore(dataDir)
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	pastes := services.NewPasteService(store, cfg)
+	rh := NewHandler(pastes, store, cfg)
+	rh.SetAccess(access.NewChecker(apikeys.Parse("alice,bob"), hmackeys.New("secret", nil)))
+	rh.SetTokens(services.NewTokenService(store, pastes))
+
+	content := []byte("release notes")
+	for _, p := range []*models.Paste{
+		{ID: "TKNS2", CreatedAt: time.Now(), Size: int64(len(content)), ContentType: "text/plain",
+			Visibility: models.VisibilityPrivate, Owner: audit.KeyID("alice")},
+		{ID: "TKNB2", CreatedAt: time.Now(), Size: int64(len(content)), ContentType: "text/plain",
+			BurnAfterRead: true, Owner: audit.KeyID("alice")},
+	} {
+		if err := store.StoreContent(p.ID, content); err != nil {
+			t.Fatalf("failed to store content: %v", err)
+		}
+		if err := store.Store(p); err != nil {
+			t.Fatalf("failed to store paste metadata: %v", err)
+		}
+	}
+
+	router := gin.New()
+	router.GET("/t/:token", rh.Token)
+	router.POST("/api/v1/pastes/:slug/tokens", rh.CreateToken)
+	router.GET("/api/v1/pastes/:slug/tokens", rh.ListTokens)
+	router.DELETE("/api/v1/pastes/:slug/tokens/:token", rh.RevokeToken)
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("User-Agent", "curl/8.0")
+		if key != "" {
+			req.Header.Set("X-Api-Key", key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	create := func(body string) tokenResponse {
+		t.Helper()
+		w := do(http.MethodPost, "/api/v1/pastes/TKNS2/tokens", "alice", body)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create token: expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp tokenResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if w := do(http.MethodPost, "/api/v1/pastes/TKNS2/tokens", "bob", ""); w.Code != http.StatusNotFound {
+		t.Errorf("create by another key: expected 404, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/api/v1/pastes/TKNB2/tokens", "alice", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("token for a burn paste: expected 400, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/api/v1/pastes/TKNS2/tokens", "alice", `{"max_uses":-1}`); w.Code != http.StatusBadRequest {
+		t.Errorf("negative max_uses: expected 400, got %d", w.Code)
+	}
+
+	twice := create(`{"max_uses":2}`)
+	unlimited := create(`{"expires_in":"1h"}`)
+	if strings.Contains(twice.URL, "TKNS2") || !strings.HasSuffix(twice.URL, "/t/"+twice.Token) {
+		t.Errorf("token URL %q reveals the slug or is malformed", twice.URL)
+	}
+	if unlimited.ExpiresAt == nil {
+		t.Error("expires_in did not set an expiry")
+	}
+
+	for i := 0; i < 2; i++ {
+		w := do(http.MethodGet, "/t/"+twice.Token, "", "")
+		if w.Code != http.StatusOK || w.Body.String() != string(content) {
+			t.Fatalf("use %d: expected content, got %d: %s", i+1, w.Code, w.Body.String())
+		}
+		if strings.Contains(w.Header().Get("Content-Disposition"), "TKNS2") {
+			t.Errorf("Content-Disposition reveals the slug: %q", w.Header().Get("Content-Disposition"))
+		}
+	}
+	if w := do(http.MethodGet, "/t/"+twice.Token, "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("third use of a two-use token: expected 404, got %d", w.Code)
+	}
+
+	// Revoking one token leaves the others working.
+	other := create("")
+	if w := do(http.MethodDelete, "/api/v1/pastes/TKNS2/tokens/"+unlimited.Token, "bob", ""); w.Code != http.StatusNotFound {
+		t.Errorf("revoke by another key: expected 404, got %d", w.Code)
+	}
+	if w := do(http.MethodDelete, "/api/v1/pastes/TKNS2/tokens/"+unlimited.Token, "alice", ""); w.Code != http.StatusOK {
+		t.Fatalf("revoke: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodGet, "/t/"+unlimited.Token, "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("revoked token: expected 404, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/t/"+other.Token, "", ""); w.Code != http.StatusOK {
+		t.Errorf("other token after a revoke: expected 200, got %d", w.Code)
+	}
+
+	// Reads of a quarantined paste, or of one made burn-after-read since,
+	// are refused without spending a use.
+	for _, change := range []func(*models.Paste){
+		func(p *models.Paste) { p.Quarantined = true },
+		func(p *models.Paste) { p.BurnAfterRead = true },
+	} {
+		paste, err := store.Get("TKNS2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved := *paste
+		change(paste)
+		if err := store.Store(paste); err != nil {
+			t.Fatal(err)
+		}
+		if w := do(http.MethodGet, "/t/"+other.Token, "", ""); w.Code != http.StatusNotFound {
+			t.Errorf("token of a quarantined or burn paste: expected 404, got %d", w.Code)
+		}
+		if err := store.Store(&saved); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := do(http.MethodGet, "/api/v1/pastes/TKNS2/tokens", "alice", "")
+	var list struct {
+		Tokens []tokenResponse `json:"tokens"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("list: %d %s", w.Code, w.Body.String())
+	}
+	if len(list.Tokens) != 1 || list.Tokens[0].Token != other.Token || list.Tokens[0].Uses != 1 {
+		t.Errorf("unexpected tokens %+v", list.Tokens)
+	}
+
+	// A token does not outlive its paste.
+	if err := pastes.DeletePaste("TKNS2"); err != nil {
+		t.Fatal(err)
+	}
+	if w := do(http.MethodGet, "/t/"+other.Token, "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("token of a deleted paste: expected 404, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/t/not-a-token", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("malformed token: expected 404, got %d", w.Code)
+	}
+}
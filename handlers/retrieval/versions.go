@@ -0,0 +1,149 @@
+package retrieval
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/internal/session"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// versionResponse describes one version in the Versions listing.
+type versionResponse struct {
+	models.PasteVersion
+	Current bool `json:"current"`
+}
+
+// parseVersion returns the version number in the ?version= query
+// parameter, reporting false when there is none.
+func parseVersion(c *gin.Context) (int, bool, error) {
+	v, ok := c.GetQuery("version")
+	if !ok {
+		return 0, false, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, true, fmt.Errorf("version must be a positive integer")
+	}
+	return n, true, nil
+}
+
+// loadVersion loads version n of paste, writing a 404 when it is not kept.
+// Burn-after-read pastes have no versions: reading one that way would not
+// burn it.
+func (h *Handler) loadVersion(c *gin.Context, paste *models.Paste, n int) (*models.PasteVersion, []byte, bool) {
+	if paste.BurnAfterRead {
+		h.renderError(c, http.StatusBadRequest, apierror.CodeBadRequest, "Burn-after-read pastes have no versions")
+		return nil, nil, false
+	}
+	v, content, err := h.service.GetVersion(paste, n)
+	if err != nil {
+		if !errors.Is(err, services.ErrVersionNotFound) {
+			log.Printf("[ERROR] Version %d of %s: %v", n, paste.ID, err)
+		}
+		h.renderNotFound(c, "Version not found")
+		return nil, nil, false
+	}
+	return v, content, true
+}
+
+// viewVersion handles GET /:slug?version=N. Browsers get the rendered page
+// for text versions up to MaxRenderSize; everything else is sent raw, as
+// to CLI clients.
+func (h *Handler) viewVersion(c *gin.Context, paste *models.Paste, n int) {
+	v, content, ok := h.loadVersion(c, paste, n)
+	if !ok {
+		return
+	}
+	if h.isCli(c) || !utils.IsTextContent(v.ContentType) || v.Size > h.maxRenderSize() {
+		h.sendVersion(c, paste, v, content, "", false)
+		return
+	}
+	if err := h.service.IncrementReadCount(paste.ID, models.ReadView); err != nil {
+		log.Printf("[WARN] View: failed to increment read count for %s: %v", paste.ID, err)
+	}
+	// Show the version's size and type in place of the current ones.
+	shown := *paste
+	shown.Size, shown.ContentType = v.Size, v.ContentType
+	c.Header("X-Paste-Version", strconv.Itoa(v.Number))
+	c.HTML(http.StatusOK, "view.html", gin.H{
+		"Title":        fmt.Sprintf("NCLIP - Paste %s (version %d)", paste.ID, v.Number),
+		"Paste":        &shown,
+		"PasteVersion": v.Number,
+		"IsText":       true,
+		"Content":      string(content),
+		"Version":      h.config.Version,
+		"BuildTime":    h.config.BuildTime,
+		"CommitHash":   h.config.CommitHash,
+		"BaseURL":      h.getBaseURL(c),
+		"UploadAuth":   h.config.UploadAuth,
+		"Share":        c.Query(access.ShareParam),
+		"CSRFToken":    session.CSRFToken(c),
+	})
+}
+
+// sendVersion sends the content of version v of paste, counting the read
+// like Raw or Download. Range requests are served as for the current
+// content.
+func (h *Handler) sendVersion(c *gin.Context, paste *models.Paste, v *models.PasteVersion, content []byte, filename string, attachment bool) {
+	if c.GetHeader("Range") == "" {
+		kind := models.ReadRaw
+		if attachment {
+			kind = models.ReadDownload
+		}
+		if err := h.service.IncrementReadCount(paste.ID, kind); err != nil {
+			log.Printf("[WARN] Raw: failed to increment read count for %s: %v", paste.ID, err)
+		}
+	}
+	if filename == "" {
+		filename = defaultFilename(paste.ID, &models.Paste{Filename: paste.Filename, ContentType: v.ContentType})
+	}
+	c.Header("Content-Type", v.ContentType)
+	c.Header("Accept-Ranges", "bytes")
+	c.Header("X-Paste-Version", strconv.Itoa(v.Number))
+	setContentDisposition(c, filename, attachment || !utils.IsTextContent(v.ContentType))
+	http.ServeContent(c.Writer, c.Request, filename, v.CreatedAt, bytes.NewReader(content))
+}
+
+// Versions handles GET /api/v1/pastes/:slug/versions, listing the kept
+// versions of a paste oldest first, followed by the current one. Anyone
+// who may read the paste may list them.
+func (h *Handler) Versions(c *gin.Context) {
+	slug := c.Param("slug")
+	if !utils.IsValidSlug(slug) {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidSlug, "Invalid slug format")
+		return
+	}
+	paste, err := h.service.GetPaste(slug)
+	if err != nil {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found")
+		return
+	}
+	if !h.authorize(c, paste, apierror.JSON) {
+		return
+	}
+	versions := make([]versionResponse, 0, len(paste.Versions)+1)
+	for _, v := range paste.Versions {
+		versions = append(versions, versionResponse{PasteVersion: v})
+	}
+	versions = append(versions, versionResponse{
+		PasteVersion: models.PasteVersion{
+			Number:      paste.CurrentVersion(),
+			Size:        paste.Size,
+			ContentType: paste.ContentType,
+			Encoding:    paste.Encoding,
+			CreatedAt:   paste.ContentCreatedAt(),
+		},
+		Current: true,
+	})
+	c.JSON(http.StatusOK, gin.H{"slug": slug, "current": paste.CurrentVersion(), "versions": versions})
+}
@@ -0,0 +1,96 @@
+package retrieval
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/hmackeys"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+// Test that private pastes are only served with the owner's API key or a
+// share link minted by the owner.
+func TestPrivatePasteAccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dataDir := t.TempDir()
+	cfg := &config.Config{MaxRenderSize: 1024, DataDir: dataDir}
+	store, err := storage.NewFilesystemStore(dataDir)
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	rh := NewHandler(services.NewPasteService(store, cfg), store, cfg)
+	rh.SetAccess(access.NewChecker(apikeys.Parse("alice,bob"), hmackeys.New("secret", nil)))
+
+	content := []byte("private notes")
+	if err := store.StoreContent("PRVTE", content); err != nil {
+		t.Fatalf("failed to store content: %v", err)
+	}
+	if err := store.Store(&models.Paste{ID: "PRVTE", CreatedAt: time.Now(), Size: int64(len(content)), ContentType: "text/plain",
+		Visibility: models.VisibilityPrivate, Owner: audit.KeyID("alice")}); err != nil {
+		t.Fatalf("failed to store paste metadata: %v", err)
+	}
+
+	router := gin.New()
+	router.GET("/:slug", rh.View)
+	router.GET("/raw/:slug", rh.Raw)
+	router.POST("/api/v1/pastes/:slug/share", rh.Share)
+	do := func(method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("User-Agent", "curl/8.0")
+		if key != "" {
+			req.Header.Set("X-Api-Key", key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, path := range []string{"/PRVTE", "/raw/PRVTE"} {
+		if w := do(http.MethodGet, path, ""); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s without a key: expected 404, got %d", path, w.Code)
+		}
+		if w := do(http.MethodGet, path, "bob"); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s with another key: expected 404, got %d", path, w.Code)
+		}
+		w := do(http.MethodGet, path, "alice")
+		if w.Code != http.StatusOK || w.Body.String() != string(content) {
+			t.Errorf("GET %s with the owner's key: expected content, got %d: %s", path, w.Code, w.Body.String())
+		}
+		if cc := w.Header().Get("Cache-Control"); !strings.Contains(cc, "no-store") {
+			t.Errorf("GET %s: expected Cache-Control no-store, got %q", path, cc)
+		}
+	}
+
+	if w := do(http.MethodPost, "/api/v1/pastes/PRVTE/share", "bob"); w.Code != http.StatusNotFound {
+		t.Errorf("share by another key: expected 404, got %d", w.Code)
+	}
+	w := do(http.MethodPost, "/api/v1/pastes/PRVTE/share", "alice")
+	if w.Code != http.StatusOK {
+		t.Fatalf("share by owner: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode share response: %v", err)
+	}
+	u, err := url.Parse(resp.URL)
+	if err != nil || u.Path != "/PRVTE" {
+		t.Fatalf("unexpected share URL %q", resp.URL)
+	}
+	if w := do(http.MethodGet, "/raw/PRVTE?"+u.RawQuery, ""); w.Code != http.StatusOK || w.Body.String() != string(content) {
+		t.Errorf("GET with share link: expected content, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/settings"
+)
+
+// maxSettingsBody bounds the body of a settings change.
+const maxSettingsBody = 64 << 10
+
+// SettingsHandler serves the admin API of the runtime settings
+type SettingsHandler struct {
+	runtime *settings.Runtime
+}
+
+// NewSettingsHandler creates a new settings handler
+func NewSettingsHandler(rt *settings.Runtime) *SettingsHandler {
+	return &SettingsHandler{runtime: rt}
+}
+
+// Get handles GET /api/v1/admin/settings, returning the settings in effect
+// on this instance and the overrides they were last loaded with.
+func (h *SettingsHandler) Get(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"settings":  h.runtime.Current(),
+		"overrides": h.runtime.Overrides(),
+	})
+}
+
+// Update handles PATCH /api/v1/admin/settings. The body is a JSON merge
+// patch of the overrides: a setting set to null goes back to the value
+// the instances are configured with. The change applies here at once and
+// on the other instances within settings.PollInterval.
+func (h *SettingsHandler) Update(c *gin.Context) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxSettingsBody))
+	if err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "failed to read request body")
+		return
+	}
+	overrides, err := h.runtime.Update(body, audit.Actor(c))
+	if err != nil {
+		audit.Record(c, audit.ActionSettings, "", audit.ResultFailure, err.Error())
+		if errors.Is(err, settings.ErrInvalid) {
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, err.Error())
+			return
+		}
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to save settings")
+		return
+	}
+	audit.Record(c, audit.ActionSettings, "", audit.ResultSuccess, string(body))
+	c.JSON(http.StatusOK, gin.H{
+		"settings":  h.runtime.Current(),
+		"overrides": overrides,
+	})
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/signing"
+)
+
+// SigningHandler publishes the key raw downloads are signed with.
+type SigningHandler struct {
+	signer *signing.Signer
+}
+
+// NewSigningHandler creates a new signing handler
+func NewSigningHandler(signer *signing.Signer) *SigningHandler {
+	return &SigningHandler{signer: signer}
+}
+
+// PublicKey handles GET /api/v1/public-key, returning the Ed25519 public
+// key that verifies the X-Nclip-Signature header of /raw and /download
+// responses, and the format of the signed message.
+func (h *SigningHandler) PublicKey(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, gin.H{
+		"algorithm":   signing.Algorithm,
+		"key_id":      h.signer.KeyID(),
+		"public_key":  base64.StdEncoding.EncodeToString(h.signer.PublicKey()),
+		"header":      signing.Header,
+		"hash_header": signing.HashHeader,
+		"message":     signing.Prefix + "{slug}\n{hex sha256 of content}",
+	})
+}
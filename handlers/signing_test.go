@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/signing"
+)
+
+func TestSigningHandler_PublicKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	signer, err := signing.Parse(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	NewSigningHandler(signer).PublicKey(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var resp map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := base64.StdEncoding.DecodeString(resp["public_key"])
+	if err != nil || !bytes.Equal(pub, signer.PublicKey()) {
+		t.Errorf("unexpected public key %q", resp["public_key"])
+	}
+	if resp["algorithm"] != "ed25519" || resp["key_id"] != signer.KeyID() {
+		t.Errorf("unexpected response %v", resp)
+	}
+}
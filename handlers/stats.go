@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/hotkeys"
+)
+
+const (
+	defaultHotWindow = 5 * time.Minute
+	defaultHotLimit  = 20
+)
+
+// StatsHandler serves the admin API of the hot slug tracker
+type StatsHandler struct {
+	hot *hotkeys.Tracker
+}
+
+// NewStatsHandler creates a new stats handler
+func NewStatsHandler(hot *hotkeys.Tracker) *StatsHandler {
+	return &StatsHandler{hot: hot}
+}
+
+// Hot handles GET /api/v1/stats/hot?window=&limit=, returning the most-read
+// slugs over the window (1m, 5m, 15m or 1h), most-read first. Counts are
+// estimates: reads is never below the true count, and reads - error never
+// above it.
+func (h *StatsHandler) Hot(c *gin.Context) {
+	window := defaultHotWindow
+	if v := c.Query("window"); v != "" {
+		w, err := hotkeys.ParseWindow(v)
+		if err != nil {
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, err.Error())
+			return
+		}
+		window = w
+	}
+	limit := min(defaultHotLimit, h.hot.Capacity())
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > h.hot.Capacity() {
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest,
+				"limit must be between 1 and "+strconv.Itoa(h.hot.Capacity()))
+			return
+		}
+		limit = n
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"window": hotkeys.WindowName(window),
+		"slugs":  h.hot.Top(window, limit),
+	})
+}
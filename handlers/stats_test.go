@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/hotkeys"
+)
+
+func TestStatsHandler_Hot(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	hot := hotkeys.New(10)
+	for i := 0; i < 3; i++ {
+		hot.Observe("HOTAB", "raw")
+	}
+	hot.Observe("WARMC", "view")
+
+	router := gin.New()
+	router.GET("/api/v1/stats/hot", NewStatsHandler(hot).Hot)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/stats/hot?window=1m&limit=1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Window string          `json:"window"`
+		Slugs  []hotkeys.Entry `json:"slugs"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if resp.Window != "1m" || len(resp.Slugs) != 1 || resp.Slugs[0] != (hotkeys.Entry{Slug: "HOTAB", Reads: 3}) {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	for _, query := range []string{"window=2m", "limit=0", "limit=11"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/stats/hot?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/changes"
+	"github.com/johnwmail/nclip/storage"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// Bounds for the page size of the sync feed.
+const (
+	defaultSyncLimit = 500
+	maxSyncLimit     = 1000
+)
+
+// SyncHandler serves the change feed that mirrors poll
+type SyncHandler struct {
+	journal *changes.Journal
+	store   storage.PasteStore
+}
+
+// NewSyncHandler creates a new sync handler
+func NewSyncHandler(journal *changes.Journal, store storage.PasteStore) *SyncHandler {
+	return &SyncHandler{journal: journal, store: store}
+}
+
+// Changes handles GET /api/v1/sync/changes?since=&limit=, returning the
+// pastes stored or deleted after the cursor since (0 for the start of the
+// journal). Puts carry the paste's current metadata; a put of a paste
+// that is gone or expired by now is reported as a delete. A cursor the
+// journal no longer covers gets 410 with the oldest usable cursor in
+// detail.
+func (h *SyncHandler) Changes(c *gin.Context) {
+	since, err := strconv.ParseInt(c.DefaultQuery("since", "0"), 10, 64)
+	if err != nil || since < 0 {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "since must be a non-negative cursor")
+		return
+	}
+	limit := defaultSyncLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSyncLimit {
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest,
+				"limit must be between 1 and "+strconv.Itoa(maxSyncLimit))
+			return
+		}
+		limit = n
+	}
+
+	page, err := h.journal.Since(since, limit)
+	if errors.Is(err, changes.ErrCursorExpired) {
+		apierror.JSONDetail(c, http.StatusGone, apierror.CodeCursorExpired, err.Error(),
+			strconv.FormatInt(h.journal.Oldest(), 10))
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] Sync changes: %v", err)
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read the change journal")
+		return
+	}
+
+	var puts []string
+	for _, ch := range page.Changes {
+		if ch.Op == changes.OpPut {
+			puts = append(puts, ch.Slug)
+		}
+	}
+	results := storage.GetBatch(h.store, puts)
+	for i, ch := range page.Changes {
+		if ch.Op != changes.OpPut {
+			continue
+		}
+		if paste := results[ch.Slug].Paste; paste != nil && !paste.IsExpired() {
+			page.Changes[i].Paste = paste
+		} else {
+			page.Changes[i].Op = changes.OpDelete
+		}
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, page)
+}
+
+// Content handles GET /api/v1/sync/content/:slug, serving a paste's raw
+// content for mirrors. Unlike /raw it never counts a read or burns the
+// paste.
+func (h *SyncHandler) Content(c *gin.Context) {
+	slug := c.Param("slug")
+	if !utils.IsValidSlug(slug) {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidSlug, "Invalid slug format")
+		return
+	}
+	exists, _, err := h.store.StatContent(slug)
+	if err == nil && !exists {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found or deleted")
+		return
+	}
+	var content []byte
+	if err == nil {
+		content, err = h.store.GetContent(slug)
+	}
+	if err != nil {
+		log.Printf("[ERROR] Sync content %s: %v", slug, err)
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read content")
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "application/octet-stream", content)
+}
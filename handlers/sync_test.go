@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/changes"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func TestSyncHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	backend, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal, err := changes.Open(filepath.Join(t.TempDir(), "changes.jsonl"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = journal.Close() }()
+	store := storage.NewJournaledStore(backend, journal)
+	past := time.Now().Add(-time.Hour)
+	for _, p := range []*models.Paste{{ID: "KEEP", ContentType: "text/plain"}, {ID: "XPRD", ExpiresAt: &past}} {
+		if err := store.StoreContent(p.ID, []byte("content of "+p.ID)); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Store(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := NewSyncHandler(journal, store)
+	router := gin.New()
+	router.GET("/api/v1/sync/changes", h.Changes)
+	router.GET("/api/v1/sync/content/:slug", h.Content)
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	w := get("/api/v1/sync/changes?since=0")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var page changes.Page
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Changes) != 2 || page.Cursor != 2 || page.More {
+		t.Fatalf("unexpected page %+v", page)
+	}
+	if ch := page.Changes[0]; ch.Op != changes.OpPut || ch.Paste == nil || ch.Paste.ContentType != "text/plain" {
+		t.Errorf("expected a put with metadata, got %+v", ch)
+	}
+	if ch := page.Changes[1]; ch.Op != changes.OpDelete || ch.Paste != nil {
+		t.Errorf("expected the expired paste as a delete, got %+v", ch)
+	}
+
+	for _, url := range []string{"/api/v1/sync/changes?since=-1", "/api/v1/sync/changes?limit=5000"} {
+		if w := get(url); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", url, w.Code)
+		}
+	}
+
+	// Once the journal is compacted past a cursor it gets 410 with the
+	// oldest cursor left.
+	for i := 0; i < 3; i++ {
+		journal.Record(changes.OpDelete, "GONE")
+	}
+	w = get("/api/v1/sync/changes?since=0")
+	if w.Code != http.StatusGone {
+		t.Fatalf("expected 410, got %d: %s", w.Code, w.Body.String())
+	}
+	var body apierror.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != apierror.CodeCursorExpired || body.Detail == "" {
+		t.Errorf("expected %s with a cursor, got %+v", apierror.CodeCursorExpired, body)
+	}
+
+	w = get("/api/v1/sync/content/KEEP")
+	if w.Code != http.StatusOK || w.Body.String() != "content of KEEP" {
+		t.Fatalf("unexpected content %d %q", w.Code, w.Body.String())
+	}
+	if paste, err := store.Get("KEEP"); err != nil || paste.ReadCount != 0 {
+		t.Errorf("expected content reads not to be counted, got %+v (%v)", paste, err)
+	}
+	if w := get("/api/v1/sync/content/NPQR"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for missing content, got %d", w.Code)
+	}
+}
@@ -4,20 +4,76 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/mirror"
+	"github.com/johnwmail/nclip/storage"
 )
 
 // SystemHandler handles system endpoints
-type SystemHandler struct{}
+type SystemHandler struct {
+	config *config.Config
+	store  storage.PasteStore
+	mirror *mirror.Mirror
+	health *storage.HealthTracker
+}
 
 // NewSystemHandler creates a new system handler
-func NewSystemHandler() *SystemHandler {
-	return &SystemHandler{}
+func NewSystemHandler(config *config.Config, store storage.PasteStore) *SystemHandler {
+	return &SystemHandler{
+		config: config,
+		store:  store,
+	}
+}
+
+// SetMirror sets the mirror whose progress /health reports.
+func (h *SystemHandler) SetMirror(m *mirror.Mirror) {
+	h.mirror = m
+}
+
+// SetHealth sets the storage health tracker whose load shedding state
+// /health reports.
+func (h *SystemHandler) SetHealth(t *storage.HealthTracker) {
+	h.health = t
 }
 
-// Health handles health check via GET /health
+// Health handles health check via GET /health. The role lets load balancers
+// and monitoring tell the writer apart from read-only replicas and mirrors.
+// When the upload spool is enabled its depth is reported so a backlog of
+// uploads waiting for the storage backend is visible, and a mirror reports
+// how far it has copied. With load shedding enabled it reports whether
+// uploads are being shed; the status stays 200 since reads are still
+// served. On S3 it counts the retried and throttled requests and the
+// connections opened and reused.
 func (h *SystemHandler) Health(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
+	resp := gin.H{
 		"status":  "ok",
 		"service": "nclip",
-	})
+		"role":    config.RoleWriter,
+	}
+	if h.config.IsReplica() || h.config.IsMirror() {
+		resp["role"] = h.config.Role
+		resp["writer_url"] = h.config.WriterURL
+	}
+	if h.mirror != nil {
+		resp["mirror"] = h.mirror.Status()
+	}
+	if h.store != nil {
+		resp["storage_capabilities"] = storage.StoreCapabilities(h.store)
+	}
+	if spool, ok := storage.Find[*storage.SpoolStore](h.store); ok {
+		resp["spool"] = spool.SpoolStats()
+	}
+	if s3, ok := storage.Find[*storage.S3Store](h.store); ok {
+		resp["s3_retries"] = s3.RetryStats()
+		resp["s3_connections"] = s3.ConnStats()
+	}
+	if h.health != nil {
+		st := h.health.Status()
+		if st.Degraded {
+			resp["status"] = "degraded"
+		}
+		resp["shedding"] = st.Degraded
+		resp["storage_health"] = st
+	}
+	c.JSON(http.StatusOK, resp)
 }
@@ -4,16 +4,19 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/storage"
 )
 
 func TestSystemHandler_Health(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
 	// Create handler
-	handler := NewSystemHandler()
+	handler := NewSystemHandler(&config.Config{Role: config.RoleWriter}, nil)
 
 	// Setup request
 	w := httptest.NewRecorder()
@@ -49,3 +52,56 @@ func TestSystemHandler_Health(t *testing.T) {
 		t.Errorf("Expected service '%s', got '%v'", expectedService, service)
 	}
 }
+
+func TestSystemHandler_HealthStorageCapabilities(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewSystemHandler(&config.Config{Role: config.RoleWriter}, store)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	handler.Health(c)
+
+	var response struct {
+		Capabilities map[string]bool `json:"storage_capabilities"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response.Capabilities == nil {
+		t.Fatalf("Expected storage_capabilities in %s", w.Body.String())
+	}
+	if response.Capabilities["encryption"] || response.Capabilities["chunking"] {
+		t.Errorf("Unexpected capabilities for a plain filesystem store: %v", response.Capabilities)
+	}
+}
+
+func TestSystemHandler_HealthSpool(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	backend, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	spool, err := storage.NewSpoolStore(backend, filepath.Join(t.TempDir(), "spool"), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The spool is found under the wrappers main puts around it.
+	store := storage.NewInstrumentedStore(spool, "filesystem", nil)
+	handler := NewSystemHandler(&config.Config{Role: config.RoleWriter}, store)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	handler.Health(c)
+
+	var response map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if _, ok := response["spool"]; !ok {
+		t.Errorf("Expected spool stats in %s", w.Body.String())
+	}
+}
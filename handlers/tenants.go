@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/tenancy"
+)
+
+// TenantsHandler serves the storage each tenant uses
+type TenantsHandler struct {
+	usage  *tenancy.Tracker
+	access *access.Checker
+}
+
+// NewTenantsHandler creates a new tenants handler
+func NewTenantsHandler(usage *tenancy.Tracker, checker *access.Checker) *TenantsHandler {
+	return &TenantsHandler{usage: usage, access: checker}
+}
+
+// Usage handles GET /api/v1/stats/tenants, returning the pastes, bytes,
+// reads and quota of every tenant to superadmin keys, and of their own
+// tenant to other admin keys. The figures are as of scanned_at, plus the
+// uploads made since.
+func (h *TenantsHandler) Usage(c *gin.Context) {
+	var tenants []tenancy.Usage
+	var scannedAt time.Time
+	var err error
+	if h.access.SuperAdmin(c) {
+		tenants, scannedAt, err = h.usage.Usage()
+	} else {
+		var u tenancy.Usage
+		u, scannedAt, err = h.usage.UsageOf(h.access.Tenant(c))
+		tenants = []tenancy.Usage{u}
+	}
+	if err != nil {
+		log.Printf("[ERROR] Tenant usage: %v", err)
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to compute tenant usage")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tenants": tenants, "scanned_at": scannedAt})
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/internal/hmackeys"
+	"github.com/johnwmail/nclip/internal/tenancy"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func TestTenantsHandler_Usage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	expires := time.Now().Add(time.Hour)
+	for _, p := range []*models.Paste{
+		{ID: "ENGAB", Tenant: "eng", Size: 5, ExpiresAt: &expires},
+		{ID: "MKTAB", Tenant: "marketing", Size: 3, ExpiresAt: &expires},
+	} {
+		if err := store.StoreContent(p.ID, make([]byte, p.Size)); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Store(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := []byte("eng-ops admin tenant=eng\nmkt-ops admin tenant=marketing\nroot superadmin\n@eng quota=1MB\n")
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := apikeys.ParseFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenants, err := apikeys.LoadTenancy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checker := access.NewChecker(keys, hmackeys.New("secret", nil))
+	checker.SetTenancy(tenants)
+	usage, err := tenancy.New(store, tenants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	router.GET("/api/v1/stats/tenants", NewTenantsHandler(usage, checker).Usage)
+
+	get := func(key string) []tenancy.Usage {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/api/v1/stats/tenants", nil)
+		req.Header.Set("X-Api-Key", key)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", key, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Tenants []tenancy.Usage `json:"tenants"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		return resp.Tenants
+	}
+	if got := get("root"); len(got) != 3 || got[1] != (tenancy.Usage{Tenant: "eng", Pastes: 1, Bytes: 5, Quota: 1 << 20}) {
+		t.Errorf("superadmin: unexpected tenants %+v", got)
+	}
+	if got := get("mkt-ops"); len(got) != 1 || got[0] != (tenancy.Usage{Tenant: "marketing", Pastes: 1, Bytes: 3}) {
+		t.Errorf("tenant admin: expected only its own tenant, got %+v", got)
+	}
+}
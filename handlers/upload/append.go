@@ -0,0 +1,78 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// Append handles POST /api/v1/pastes/:slug/append, adding the raw request
+// body to the end of a paste created with X-Appendable. ?final=true marks
+// the last chunk; the body may then be empty. Like Replace, it takes the
+// owner's API key, an admin key or the paste's manage token, and the
+// paste as a whole stays within the caller's upload limit.
+func (h *Handler) Append(c *gin.Context) {
+	slug := c.Param("slug")
+	if !utils.IsValidSlug(slug) {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidSlug, "Invalid slug format")
+		return
+	}
+	final, err := strconv.ParseBool(c.DefaultQuery("final", "false"))
+	if err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "final must be true or false")
+		return
+	}
+	paste, err := h.service.GetPaste(slug)
+	if err != nil || !h.access.CanEdit(c, paste) {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found or not editable")
+		return
+	}
+	limit, _ := h.uploadLimit(c)
+	chunk, exceeded, err := h.readLimitedContent(c.Request.Body, limit)
+	if err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "failed to read request body")
+		return
+	}
+	if exceeded {
+		h.uploadError(c, fmt.Errorf("content too large: exceeds limit of %d bytes", limit))
+		return
+	}
+
+	paste, err = h.service.AppendContent(slug, chunk, limit, final)
+	switch {
+	case errors.Is(err, services.ErrAppendTooLarge):
+		h.uploadError(c, err)
+		return
+	case errors.Is(err, services.ErrNotAppendable):
+		apierror.JSON(c, http.StatusConflict, apierror.CodeConflict, "Paste is not appendable")
+		return
+	case errors.Is(err, services.ErrLegalHold):
+		audit.Record(c, audit.ActionUpdate, slug, audit.ResultFailure, "legal hold")
+		apierror.JSON(c, http.StatusConflict, apierror.CodeLegalHold, "Paste is under legal hold")
+		return
+	case err != nil:
+		log.Printf("[ERROR] Append: failed to append to %s: %v", slug, err)
+		audit.Record(c, audit.ActionUpdate, slug, audit.ResultFailure, err.Error())
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to append to paste")
+		return
+	}
+	detail := fmt.Sprintf("append %d bytes", len(chunk))
+	if final {
+		detail += ", final"
+	}
+	audit.Record(c, audit.ActionUpdate, slug, audit.ResultSuccess, detail)
+
+	c.JSON(http.StatusOK, gin.H{
+		"slug":       slug,
+		"size":       paste.Size,
+		"appendable": paste.Appendable,
+	})
+}
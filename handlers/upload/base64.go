@@ -0,0 +1,101 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	errBase64Alphabet = errors.New("mixes the standard and URL-safe alphabets")
+	errBase64Padding  = errors.New("data after padding")
+)
+
+// base64Reader passes on the base64 read from r in the standard alphabet
+// without padding, whether it was sent in the standard or the URL-safe
+// alphabet and with or without padding, so one streaming decoder handles
+// every variant. The first character only one alphabet has fixes the
+// alphabet. Line breaks are passed on for the decoder to skip.
+type base64Reader struct {
+	r       io.Reader
+	std     bool
+	urlSafe bool
+	padded  bool
+}
+
+// base64Class sorts the bytes base64Reader treats specially; the rest
+// are passed on as they are.
+var base64Class = func() (class [256]byte) {
+	class['+'], class['/'] = classStd, classStd
+	class['-'], class['_'] = classURLSafe, classURLSafe
+	class['='] = classPadding
+	class['\r'], class['\n'] = classNewline, classNewline
+	return class
+}()
+
+const (
+	classOther = iota
+	classStd
+	classURLSafe
+	classPadding
+	classNewline
+)
+
+func (b *base64Reader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	out := 0
+	for _, ch := range p[:n] {
+		switch base64Class[ch] {
+		case classPadding:
+			b.padded = true
+			continue
+		case classNewline:
+			p[out] = ch
+			out++
+			continue
+		case classStd:
+			b.std = true
+		case classURLSafe:
+			b.urlSafe = true
+			if ch == '-' {
+				ch = '+'
+			} else {
+				ch = '/'
+			}
+		}
+		if b.padded {
+			return out, errBase64Padding
+		}
+		if b.std && b.urlSafe {
+			return out, errBase64Alphabet
+		}
+		p[out] = ch
+		out++
+	}
+	return out, err
+}
+
+// decodeBase64 decodes the base64 read from r, reporting whether the
+// decoded content exceeds limit instead of returning it. Only the decoded
+// content is held in memory; sizeHint, the encoded size if known, sizes
+// its buffer up front.
+func decodeBase64(r io.Reader, sizeHint, limit int64) ([]byte, bool, error) {
+	var buf bytes.Buffer
+	if sizeHint > 0 {
+		buf.Grow(int(min(sizeHint/4*3+3, limit+1)) + bytes.MinRead)
+	}
+	decoder := base64.NewDecoder(base64.RawStdEncoding, &base64Reader{r: r})
+	n, err := buf.ReadFrom(io.LimitReader(decoder, limit+1))
+	var corrupt base64.CorruptInputError
+	switch {
+	case errors.As(err, &corrupt) || errors.Is(err, errBase64Alphabet) || errors.Is(err, errBase64Padding):
+		return nil, false, fmt.Errorf("invalid base64 encoding: %v", err)
+	case err != nil:
+		return nil, false, err
+	case n > limit:
+		return nil, true, nil
+	}
+	return buf.Bytes(), false, nil
+}
@@ -0,0 +1,109 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecodeBase64(t *testing.T) {
+	data := []byte("nclip \xfb\xff\xfe test")
+	tests := []struct {
+		name    string
+		encoded string
+		limit   int64
+		want    []byte
+		wantErr string
+		exceeds bool
+	}{
+		{name: "standard", encoded: base64.StdEncoding.EncodeToString(data), limit: 100, want: data},
+		{name: "URL-safe", encoded: base64.URLEncoding.EncodeToString(data), limit: 100, want: data},
+		{name: "raw standard", encoded: base64.RawStdEncoding.EncodeToString(data), limit: 100, want: data},
+		{name: "raw URL-safe", encoded: base64.RawURLEncoding.EncodeToString(data), limit: 100, want: data},
+		{name: "line breaks", encoded: "SGVs\r\nbG8s\nIHdv\ncmxk\n", limit: 100, want: []byte("Hello, world")},
+		{name: "padding then newline", encoded: "SGk=\n", limit: 100, want: []byte("Hi")},
+		{name: "at limit", encoded: "SGVsbG8=", limit: 5, want: []byte("Hello")},
+		{name: "over limit", encoded: "SGVsbG8=", limit: 4, exceeds: true},
+		{name: "mixed alphabets", encoded: "+/-_", limit: 100, wantErr: "invalid base64 encoding"},
+		{name: "data after padding", encoded: "SGk=SGk=", limit: 100, wantErr: "invalid base64 encoding"},
+		{name: "invalid character", encoded: "SGV*bG8=", limit: 100, wantErr: "invalid base64 encoding"},
+		{name: "truncated", encoded: "SGVsb", limit: 100, wantErr: "invalid base64 encoding"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Reading a byte at a time checks state carries across reads.
+			r := iotest.OneByteReader(strings.NewReader(tt.encoded))
+			got, exceeded, err := decodeBase64(r, int64(len(tt.encoded)), tt.limit)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if exceeded != tt.exceeds {
+				t.Fatalf("exceeded = %v, want %v", exceeded, tt.exceeds)
+			}
+			if !tt.exceeds && !bytes.Equal(got, tt.want) {
+				t.Errorf("decoded %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// benchmarkContent returns 50MB of random content and its base64 encoding
+// with 76-character lines.
+func benchmarkContent(b *testing.B) ([]byte, []byte) {
+	content := make([]byte, 50<<20)
+	if _, err := rand.Read(content); err != nil {
+		b.Fatal(err)
+	}
+	var encoded bytes.Buffer
+	line := make([]byte, 57)
+	for r := bytes.NewReader(content); ; {
+		n, _ := r.Read(line)
+		if n == 0 {
+			break
+		}
+		encoded.WriteString(base64.StdEncoding.EncodeToString(line[:n]))
+		encoded.WriteByte('\n')
+	}
+	return content, encoded.Bytes()
+}
+
+func BenchmarkDecodeBase64_50MB(b *testing.B) {
+	content, encoded := benchmarkContent(b)
+	b.SetBytes(int64(len(encoded)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		decoded, exceeded, err := decodeBase64(bytes.NewReader(encoded), int64(len(encoded)), int64(len(content)))
+		if err != nil || exceeded || len(decoded) != len(content) {
+			b.Fatalf("decodeBase64: %d bytes, %v, %v", len(decoded), exceeded, err)
+		}
+	}
+}
+
+// BenchmarkDecodeBase64_50MB_FullBody is the decode of a body read in full
+// first, for comparison.
+func BenchmarkDecodeBase64_50MB_FullBody(b *testing.B) {
+	content, encoded := benchmarkContent(b)
+	b.SetBytes(int64(len(encoded)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var body bytes.Buffer
+		if _, err := body.ReadFrom(bytes.NewReader(encoded)); err != nil {
+			b.Fatal(err)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(body.String())
+		if err != nil || len(decoded) != len(content) {
+			b.Fatalf("DecodeString: %d bytes, %v", len(decoded), err)
+		}
+	}
+}
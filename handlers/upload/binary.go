@@ -0,0 +1,37 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/utils"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var binaryRefused = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "upload_binary_refused_total",
+	Help: "Large binary uploads refused because they did not send X-Allow-Binary.",
+})
+
+// Collector returns the upload metrics for registration.
+func Collector() prometheus.Collector {
+	return binaryRefused
+}
+
+// errBinaryUnconfirmed is returned by checkBinary for binary uploads that
+// need X-Allow-Binary.
+var errBinaryUnconfirmed = errors.New("binary upload not confirmed")
+
+// checkBinary refuses content that is not text and larger than
+// BinaryConfirmSize unless the upload confirms it with X-Allow-Binary, so
+// a stray curl --data-binary @disk.img is not stored by accident.
+func (h *Handler) checkBinary(c *gin.Context, content []byte, contentType string) error {
+	threshold := h.config.BinaryConfirmSize
+	if threshold <= 0 || int64(len(content)) <= threshold || utils.IsTextContent(contentType) || headerEnabled(c, "X-Allow-Binary") {
+		return nil
+	}
+	binaryRefused.Inc()
+	return fmt.Errorf("%w: the upload looks binary (%s, %d bytes, more than %d); if you meant to upload it, send it again with the header X-Allow-Binary: true",
+		errBinaryUnconfirmed, contentType, len(content), threshold)
+}
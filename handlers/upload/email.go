@@ -0,0 +1,176 @@
+package upload
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/emailin"
+	"github.com/johnwmail/nclip/internal/services"
+)
+
+// maxSNSMessage is the largest SNS delivery (256 KB) plus JSON overhead.
+const maxSNSMessage = 512 * 1024
+
+// emailGateway holds the email-in settings.
+type emailGateway struct {
+	verifier *emailin.Verifier
+	senders  emailin.Senders
+	replier  emailin.Replier
+}
+
+// SetEmailIn enables POST /integrations/email for SES mail published to
+// the topic verifier accepts. replier may be nil to skip replies.
+func (h *Handler) SetEmailIn(verifier *emailin.Verifier, senders emailin.Senders, replier emailin.Replier) {
+	h.email = &emailGateway{verifier: verifier, senders: senders, replier: replier}
+}
+
+// EmailIn handles POST /integrations/email, the HTTPS subscription of the
+// SNS topic an SES receipt rule publishes inbound mail to. The text body
+// and each attachment of mail from an allowed, authenticated sender become
+// pastes, and the sender gets a reply listing their URLs. Rejected mail is
+// acknowledged too, so SNS does not retry it, and never answered; a
+// redelivered message is acknowledged without creating pastes again.
+func (h *Handler) EmailIn(c *gin.Context) {
+	if h.email == nil {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Resource not found")
+		return
+	}
+	body, truncated, err := h.readLimitedContent(c.Request.Body, maxSNSMessage)
+	if err != nil || truncated {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "Invalid SNS message")
+		return
+	}
+	var msg emailin.Message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "Invalid SNS message")
+		return
+	}
+	if err := h.email.verifier.Verify(&msg); err != nil {
+		log.Printf("[WARN] EmailIn: rejected SNS message %s: %v", msg.MessageID, err)
+		apierror.JSON(c, http.StatusUnauthorized, apierror.CodeUnauthorized, err.Error())
+		return
+	}
+
+	switch msg.Type {
+	case emailin.TypeSubscriptionConfirmation:
+		if err := h.email.verifier.Confirm(&msg); err != nil {
+			log.Printf("[ERROR] EmailIn: failed to confirm subscription to %s: %v", msg.TopicArn, err)
+			apierror.JSON(c, http.StatusBadGateway, apierror.CodeInternal, "Failed to confirm subscription")
+			return
+		}
+		log.Printf("[INFO] EmailIn: confirmed subscription to %s", msg.TopicArn)
+		c.Status(http.StatusOK)
+		return
+	case emailin.TypeNotification:
+	default:
+		c.Status(http.StatusOK)
+		return
+	}
+
+	var n emailin.Notification
+	if err := json.Unmarshal([]byte(msg.Message), &n); err != nil || n.NotificationType != "Received" {
+		c.Status(http.StatusOK)
+		return
+	}
+	id := n.Mail.MessageID
+	if id == "" {
+		id = msg.MessageID
+	}
+	if first, err := h.email.verifier.First(id); err != nil {
+		// Ask SNS to deliver it again rather than risk a duplicate.
+		log.Printf("[ERROR] EmailIn: failed to check message %s for redelivery: %v", id, err)
+		apierror.JSON(c, http.StatusServiceUnavailable, apierror.CodeInternal, "Cannot check the message for redelivery")
+		return
+	} else if !first {
+		log.Printf("[INFO] EmailIn: ignored redelivered message %s", id)
+		c.Status(http.StatusOK)
+		return
+	}
+	email, err := h.parseInboundEmail(&n)
+	if err != nil {
+		log.Printf("[WARN] EmailIn: rejected message %s from %s: %v", n.Mail.MessageID, n.Mail.Source, err)
+		audit.SetActor(c, "email:"+strings.ToLower(n.Mail.Source))
+		audit.Record(c, audit.ActionCreate, "", audit.ResultFailure, "email: "+err.Error())
+		c.Status(http.StatusOK)
+		return
+	}
+	owner, _ := h.email.senders.Owner(email.From)
+	audit.SetActor(c, "email:"+owner)
+
+	parts := email.Attachments
+	if len(email.Body) > 0 {
+		parts = append([]emailin.Part{{Content: email.Body}}, parts...)
+	}
+	var urls []string
+	for _, part := range parts {
+		resp, err := h.service.CreatePaste(services.CreatePasteRequest{
+			Content:  part.Content,
+			Filename: part.Filename,
+			TTL:      h.defaultTTL(),
+		})
+		if err != nil {
+			log.Printf("[ERROR] EmailIn: failed to create paste for %s: %v", owner, err)
+			audit.Record(c, audit.ActionCreate, "", audit.ResultFailure, err.Error())
+			email.Skipped = append(email.Skipped, part.Filename)
+			continue
+		}
+		audit.Record(c, audit.ActionCreate, resp.Slug, audit.ResultSuccess, "email")
+		url := h.generatePasteURL(c, resp.Slug)
+		if part.Filename != "" {
+			url += " (" + part.Filename + ")"
+		}
+		urls = append(urls, url)
+	}
+
+	if h.email.replier != nil {
+		if err := h.email.replier.Reply(email.From, "Re: "+email.Subject, emailReply(urls, email.Skipped)); err != nil {
+			log.Printf("[WARN] EmailIn: failed to reply to %s: %v", email.From, err)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"pastes": len(urls)})
+}
+
+// parseInboundEmail authenticates and parses an SES notification and
+// checks the sender against the allowlist.
+func (h *Handler) parseInboundEmail(n *emailin.Notification) (*emailin.Email, error) {
+	if err := n.Authenticate(); err != nil {
+		return nil, err
+	}
+	raw, err := n.Raw()
+	if err != nil {
+		return nil, err
+	}
+	email, err := emailin.Parse(raw, h.config.BufferSize)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.AuthenticateFrom(email.From); err != nil {
+		return nil, err
+	}
+	if _, ok := h.email.senders.Owner(email.From); !ok {
+		return nil, fmt.Errorf("sender %s is not allowed", email.From)
+	}
+	return email, nil
+}
+
+func emailReply(urls, skipped []string) string {
+	var b strings.Builder
+	if len(urls) == 0 {
+		b.WriteString("No pastes were created: the message had no text body or attachments.\n")
+	} else {
+		b.WriteString("Your pastes:\n\n")
+		for _, u := range urls {
+			b.WriteString(u + "\n")
+		}
+	}
+	if len(skipped) > 0 {
+		b.WriteString("\nSkipped (too large or failed): " + strings.Join(skipped, ", ") + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,66 @@
+package upload
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/emailin"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func TestEmailIn_Rejects(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	cfg := &config.Config{BufferSize: 1024, DefaultTTL: 24 * time.Hour}
+	handler := NewHandler(services.NewPasteService(store, cfg), cfg)
+	router := gin.New()
+	router.POST("/integrations/email", handler.EmailIn)
+
+	post := func(body string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/integrations/email", strings.NewReader(body)))
+		return w.Code
+	}
+
+	if code := post(`{}`); code != http.StatusNotFound {
+		t.Errorf("disabled: expected 404, got %d", code)
+	}
+
+	senders, _ := emailin.ParseSenders("@example.com")
+	handler.SetEmailIn(emailin.NewVerifier("arn:aws:sns:us-east-1:123456789012:nclip-mail"), senders, nil)
+	if code := post(`not json`); code != http.StatusBadRequest {
+		t.Errorf("invalid body: expected 400, got %d", code)
+	}
+	if code := post(`{"Type":"Notification","TopicArn":"arn:aws:sns:us-east-1:123456789012:other"}`); code != http.StatusUnauthorized {
+		t.Errorf("wrong topic: expected 401, got %d", code)
+	}
+	if code := post(`{"Type":"Notification","TopicArn":"arn:aws:sns:us-east-1:123456789012:nclip-mail",` +
+		`"Timestamp":"` + time.Now().UTC().Format(time.RFC3339) + `","SignatureVersion":"2","Signature":"AAAA",` +
+		`"SigningCertURL":"https://evil.example/cert.pem"}`); code != http.StatusUnauthorized {
+		t.Errorf("untrusted certificate URL: expected 401, got %d", code)
+	}
+	if page, _ := store.List(storage.ListOptions{}); len(page.IDs) != 0 {
+		t.Errorf("expected no pastes, got %d", len(page.IDs))
+	}
+}
+
+func TestEmailReply(t *testing.T) {
+	reply := emailReply([]string{"http://example.com/ABCDE", "http://example.com/FGHJK (app.log)"}, []string{"huge.bin"})
+	for _, want := range []string{"http://example.com/ABCDE\n", "(app.log)", "Skipped (too large or failed): huge.bin"} {
+		if !strings.Contains(reply, want) {
+			t.Errorf("reply missing %q:\n%s", want, reply)
+		}
+	}
+	if reply := emailReply(nil, nil); !strings.HasPrefix(reply, "No pastes were created") {
+		t.Errorf("unexpected empty reply %q", reply)
+	}
+}
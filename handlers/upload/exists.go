@@ -0,0 +1,34 @@
+package upload
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// Exists handles GET and HEAD /api/v1/exists/:slug for clients checking a
+// custom slug before uploading: 204 when the slug is taken (or reserved),
+// 404 when it is free. It only reads metadata, so it never counts a read
+// or burns a paste.
+func (h *Handler) Exists(c *gin.Context) {
+	slug := c.Param("slug")
+	c.Header("Cache-Control", "no-store")
+	if !utils.IsValidSlug(slug) {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidSlug, "Invalid slug format")
+		return
+	}
+	taken, err := h.service.SlugTaken(h.storedSlug(slug))
+	if err != nil {
+		log.Printf("[ERROR] Exists: failed to check %s: %v", slug, err)
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to check slug")
+		return
+	}
+	if !taken {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Slug is available")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,68 @@
+package upload
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+	"github.com/johnwmail/nclip/utils"
+)
+
+func TestExists(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	cfg := &config.Config{BufferSize: 1024, DefaultTTL: 24 * time.Hour}
+	service := services.NewPasteService(store, cfg)
+	service.SetReservedSlugs(utils.NewReservedSlugs(utils.DefaultReservedSlugs...))
+	handler := NewHandler(service, cfg)
+	router := gin.New()
+	router.GET("/api/v1/exists/:slug", handler.Exists)
+	router.HEAD("/api/v1/exists/:slug", handler.Exists)
+
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	for _, p := range []*models.Paste{
+		{ID: "BURNME", CreatedAt: now, ExpiresAt: &future, Size: 2, ContentType: "text/plain", BurnAfterRead: true},
+		{ID: "EXPRD", CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: &past, Size: 2, ContentType: "text/plain"},
+	} {
+		if err := store.StoreContent(p.ID, []byte("hi")); err != nil {
+			t.Fatalf("StoreContent: %v", err)
+		}
+		if err := store.Store(p); err != nil {
+			t.Fatalf("Store: %v", err)
+		}
+	}
+
+	for _, tt := range []struct {
+		method, slug string
+		want         int
+	}{
+		{http.MethodGet, "BURNME", http.StatusNoContent},
+		{http.MethodHead, "BURNME", http.StatusNoContent},
+		{http.MethodHead, "EXPRD", http.StatusNotFound},
+		{http.MethodGet, "ABSENT", http.StatusNotFound},
+		{http.MethodGet, "HEALTH", http.StatusNoContent},
+		{http.MethodGet, "a", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(tt.method, "/api/v1/exists/"+tt.slug, nil))
+		if w.Code != tt.want {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.slug, tt.want, w.Code)
+		}
+	}
+
+	// Checking neither burns nor counts as a read.
+	paste, err := store.Get("BURNME")
+	if err != nil || paste.ReadCount != 0 {
+		t.Errorf("BURNME after checks: %+v, %v", paste, err)
+	}
+}
@@ -1,18 +1,35 @@
 package upload
 
 import (
-	"encoding/base64"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mime"
 	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/burnnotify"
+	"github.com/johnwmail/nclip/internal/forwarded"
+	"github.com/johnwmail/nclip/internal/multipartspool"
 	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/internal/settings"
+	"github.com/johnwmail/nclip/internal/slashcmd"
+	"github.com/johnwmail/nclip/internal/slugmask"
+	"github.com/johnwmail/nclip/internal/tenancy"
+	"github.com/johnwmail/nclip/internal/uploadlink"
+	"github.com/johnwmail/nclip/models"
 	"github.com/johnwmail/nclip/utils"
 )
 
@@ -20,16 +37,110 @@ import (
 type Handler struct {
 	service *services.PasteService
 	config  *config.Config
+	links   *uploadlink.Signer
+	access  *access.Checker
+	// workspaces are the Slack/Mattermost teams allowed to use
+	// SlashCommand, keyed by team ID.
+	workspaces map[string]slashcmd.Workspace
+	email      *emailGateway
+	// sizeLimits are the per-key upload size limits of the keys file.
+	sizeLimits apikeys.Limiter
+	// multipartSpool, when set, holds large multipart file parts instead
+	// of os.TempDir.
+	multipartSpool *multipartspool.Spool
+	// direct enables presigned uploads; nil disables them.
+	direct *directUploads
+	// settings, when set, replace the configured default TTL.
+	settings *settings.Runtime
+	// burnNotify, when set, accepts X-Notify-On-Burn.
+	burnNotify *burnnotify.Notifier
+	// slugs, when set, maps custom slugs to the slugs pastes are stored
+	// under.
+	slugs slugmask.Transformer
 }
 
 // NewHandler creates a new upload handler
 func NewHandler(service *services.PasteService, config *config.Config) *Handler {
 	return &Handler{
-		service: service,
-		config:  config,
+		service:    service,
+		config:     config,
+		sizeLimits: apikeys.SizeLimits(nil),
 	}
 }
 
+// SetUploadLinks enables one-time upload links signed by signer.
+func (h *Handler) SetUploadLinks(signer *uploadlink.Signer) {
+	h.links = signer
+}
+
+// SetAccess sets the checker that identifies the owner of private pastes.
+// Without one, X-Visibility: private is rejected.
+func (h *Handler) SetAccess(checker *access.Checker) {
+	h.access = checker
+}
+
+// SetSizeLimits sets per-key upload size limits that replace BufferSize
+// for the keys they list, and for uploads without a valid API key when
+// they list apikeys.Anonymous.
+func (h *Handler) SetSizeLimits(limits apikeys.Limiter) {
+	h.sizeLimits = limits
+}
+
+// SetSettings makes uploads use the default TTL of rt, which can change
+// while the server runs, instead of the configured one.
+func (h *Handler) SetSettings(rt *settings.Runtime) {
+	h.settings = rt
+}
+
+// SetBurnNotifier lets burn-after-read uploads name a webhook or email
+// address to notify when they are read, with X-Notify-On-Burn.
+func (h *Handler) SetBurnNotifier(n *burnnotify.Notifier) {
+	h.burnNotify = n
+}
+
+// defaultTTL returns the lifetime of uploads that do not ask for one.
+func (h *Handler) defaultTTL() time.Duration {
+	if h.settings != nil {
+		return h.settings.Current().DefaultTTL
+	}
+	return h.config.DefaultTTL
+}
+
+// SetMultipartSpool makes multipart uploads spool large file parts to
+// spool, which bounds their total size, instead of os.TempDir.
+func (h *Handler) SetMultipartSpool(spool *multipartspool.Spool) {
+	h.multipartSpool = spool
+}
+
+// SetSlugTransformer stores pastes uploaded with a custom slug under the
+// slug t maps it to.
+func (h *Handler) SetSlugTransformer(t slugmask.Transformer) {
+	h.slugs = t
+}
+
+// storedSlug returns the slug a paste uploaded with the custom slug name
+// is stored under.
+func (h *Handler) storedSlug(name string) string {
+	if h.slugs == nil {
+		return name
+	}
+	return h.slugs.Transform(name)
+}
+
+// uploadLimit returns the upload size limit of the request's API key, of
+// uploads without one when the request carries no valid key, or else
+// BufferSize. It also describes whose limit it is for error responses.
+func (h *Handler) uploadLimit(c *gin.Context) (int64, string) {
+	key, who := "", "uploads without an API key"
+	if h.access.Owner(c) != "" {
+		key, who = access.APIKey(c), "this API key"
+	}
+	if size, ok := h.sizeLimits.Limit(key); ok {
+		return size, who
+	}
+	return h.config.BufferSize, "this server"
+}
+
 // headerEnabled returns true if the given header key is present and not
 // explicitly disabled. Presence with an empty value counts as enabled.
 // Explicit disabling values (case-insensitive): "0", "false", "no".
@@ -63,23 +174,142 @@ func (h *Handler) parseTTL(c *gin.Context) (time.Time, error) {
 	ttlStr := c.GetHeader("X-TTL")
 	if ttlStr != "" {
 		d, err := time.ParseDuration(ttlStr)
-		minTTL := time.Hour
-		maxTTL := 7 * 24 * time.Hour
 		if utils.IsDebugEnabled() {
 			log.Printf("[DEBUG] Parsed X-TTL duration: %v (raw: %s)", d, ttlStr)
 		}
-		if err != nil || d < minTTL || d > maxTTL {
+		if err != nil || d < config.MinTTL || d > config.MaxTTL {
 			return time.Time{}, fmt.Errorf("X-TTL must be between 1h and 7d")
 		}
 		return time.Now().Add(d), nil
 	}
-	return time.Now().Add(h.config.DefaultTTL), nil
+	return time.Now().Add(h.defaultTTL()), nil
+}
+
+// parseVisibility applies the X-Visibility header to req. Pastes are
+// owned by the API key sent with the upload, if any, and belong to its
+// tenant; private pastes require one.
+func (h *Handler) parseVisibility(c *gin.Context, req *services.CreatePasteRequest) error {
+	v, err := models.ParseVisibility(c.GetHeader("X-Visibility"))
+	if err != nil {
+		return err
+	}
+	req.Visibility = v
+	req.Owner = h.access.Owner(c)
+	req.Tenant = h.access.Tenant(c)
+	if v == models.VisibilityPrivate && req.Owner == "" {
+		return services.ErrNoOwner
+	}
+	return nil
+}
+
+// parseCollection applies the X-Collection header to req. Admin keys may
+// add to any collection (superadmin keys once keys are in tenants), other
+// callers only to their own.
+func (h *Handler) parseCollection(c *gin.Context, req *services.CreatePasteRequest) error {
+	id := strings.TrimSpace(c.GetHeader("X-Collection"))
+	if id == "" {
+		return nil
+	}
+	if !utils.IsValidSlug(id) {
+		return services.ErrCollectionNotFound
+	}
+	req.Collection = id
+	if _, ok := access.Scopes(c); ok {
+		req.Admin = h.access.SuperAdmin(c)
+	}
+	return nil
+}
+
+// parseNotifyOnBurn applies the X-Notify-On-Burn header to req, which
+// must be burn-after-read.
+func (h *Handler) parseNotifyOnBurn(c *gin.Context, req *services.CreatePasteRequest) error {
+	v := c.GetHeader("X-Notify-On-Burn")
+	if v == "" {
+		return nil
+	}
+	if !req.BurnAfterRead {
+		return errors.New("X-Notify-On-Burn needs a burn-after-read paste (set X-Burn or use /burn/)")
+	}
+	if h.burnNotify == nil {
+		return errors.New("burn notifications are not enabled on this server")
+	}
+	target, err := burnnotify.ParseTarget(v, h.burnNotify.Email())
+	if err != nil {
+		return err
+	}
+	req.NotifyOnBurn = target
+	return nil
+}
+
+// partMtimeKey is the gin context key under which the multipart readers
+// record the file part's modification time, for parseFileMtime.
+const partMtimeKey = "nclip.upload.part_mtime"
+
+// partMtime returns the modification time a multipart file part carries,
+// in an X-File-Mtime part header or the modification-date parameter of
+// its Content-Disposition, or "" when it has none.
+func partMtime(header textproto.MIMEHeader) string {
+	if v := header.Get("X-File-Mtime"); v != "" {
+		return v
+	}
+	_, params, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	return params["modification-date"]
+}
+
+// parseFileMtime applies the X-File-Mtime header to req, falling back to
+// the modification time of a multipart file part.
+func (h *Handler) parseFileMtime(c *gin.Context, req *services.CreatePasteRequest) error {
+	v := c.GetHeader("X-File-Mtime")
+	if v == "" {
+		v = c.GetString(partMtimeKey)
+	}
+	if v == "" {
+		return nil
+	}
+	mtime, err := models.ParseFileMtime(v, time.Now())
+	if err != nil {
+		return err
+	}
+	req.FileMtime = &mtime
+	return nil
 }
 
 // readUploadContent extracts content, filename, and content-type from request
-// Supports X-Base64 header for base64 encoded content
+// Supports X-Base64 header for base64 encoded content. The size limit is
+// the caller's, see uploadLimit.
 func (h *Handler) readUploadContent(c *gin.Context) ([]byte, string, string, error) {
-	limit := h.config.BufferSize
+	limit, _ := h.uploadLimit(c)
+	content, filename, contentType, err := h.readUploadContentLimit(c, limit)
+	if err == nil {
+		err = h.checkBinary(c, content, contentType)
+	}
+	if err != nil {
+		return nil, filename, contentType, err
+	}
+	return content, filename, contentType, nil
+}
+
+// uploadError writes the response for an error from readUploadContent. A
+// 413 names whose limit was exceeded in its detail.
+func (h *Handler) uploadError(c *gin.Context, err error) {
+	log.Printf("[ERROR] %v", err)
+	status, code := readErrorStatus(err)
+	if status == http.StatusServiceUnavailable {
+		c.Header("Retry-After", spoolRetryAfter)
+	}
+	if status != http.StatusRequestEntityTooLarge {
+		apierror.JSON(c, status, code, err.Error())
+		return
+	}
+	limit, who := h.uploadLimit(c)
+	apierror.JSONDetail(c, status, code, err.Error(), fmt.Sprintf("the upload limit for %s is %d bytes", who, limit))
+}
+
+// readUploadContentLimit is readUploadContent with an explicit size limit.
+func (h *Handler) readUploadContentLimit(c *gin.Context, limit int64) ([]byte, string, string, error) {
 	contentTypeHeader := c.Request.Header.Get("Content-Type")
 
 	var content []byte
@@ -87,114 +317,199 @@ func (h *Handler) readUploadContent(c *gin.Context) ([]byte, string, string, err
 	var contentType string
 	var err error
 
+	// Check if content is base64 encoded
+	// Semantics: header presence enables base64 unless explicitly set to 0/false/no
+	encoded := headerEnabled(c, "X-Base64")
+	direct := false
 	if contentTypeHeader != "" && strings.HasPrefix(contentTypeHeader, "multipart/form-data") {
 		content, filename, contentType, err = h.readMultipartUpload(c, limit)
+	} else if isFormUpload(c) {
+		content, filename, contentType, err = h.readFormUpload(c, limit)
 	} else {
+		// Direct uploads are decoded while they are read.
 		content, filename, contentType, err = h.readDirectUpload(c, limit)
+		direct = true
 	}
 
 	if err != nil {
 		return nil, filename, contentType, err
 	}
+	if !encoded {
+		return content, filename, contentType, nil
+	}
 
-	// Check if content is base64 encoded
-	// Semantics: header presence enables base64 unless explicitly set to 0/false/no
-	if headerEnabled(c, "X-Base64") {
-		decoded, decodeErr := h.decodeBase64Content(content)
-		if decodeErr != nil {
-			return nil, filename, contentType, decodeErr
-		}
-
-		// Validate decoded content size
-		if int64(len(decoded)) > limit {
-			return nil, filename, contentType, fmt.Errorf("decoded content too large: %d bytes exceeds limit of %d bytes", len(decoded), limit)
+	if !direct {
+		decoded, exceeded, err := decodeBase64(bytes.NewReader(content), int64(len(content)), limit)
+		if err != nil {
+			return nil, filename, contentType, err
 		}
-
-		// Validate decoded content is not empty
-		if len(decoded) == 0 {
-			return nil, filename, contentType, fmt.Errorf("decoded content is empty")
+		if exceeded {
+			return nil, filename, contentType, fmt.Errorf("decoded content too large: exceeds limit of %d bytes", limit)
 		}
-
-		// Re-detect content type based on decoded content
-		contentType = utils.DetectContentType(filename, decoded)
-
 		if utils.IsDebugEnabled() {
 			log.Printf("[DEBUG] Base64 decoded: %d bytes → %d bytes", len(content), len(decoded))
 		}
+		content = decoded
+	}
 
-		return decoded, filename, contentType, nil
+	// Validate decoded content is not empty
+	if len(content) == 0 {
+		return nil, filename, contentType, fmt.Errorf("decoded content is empty")
 	}
 
+	// Re-detect content type based on decoded content
+	contentType = utils.DetectContentType(filename, content)
 	return content, filename, contentType, nil
 }
 
-// decodeBase64Content decodes base64 encoded content
-func (h *Handler) decodeBase64Content(encoded []byte) ([]byte, error) {
-	// Try standard base64 decoding first
-	decoded, err := base64.StdEncoding.DecodeString(string(encoded))
-	if err == nil {
-		return decoded, nil
-	}
-
-	// Try URL-safe base64 as fallback
-	decoded, err = base64.URLEncoding.DecodeString(string(encoded))
-	if err == nil {
-		return decoded, nil
+func (h *Handler) readMultipartUpload(c *gin.Context, limit int64) ([]byte, string, string, error) {
+	// If content is base64 encoded, adjust limit
+	effectiveLimit := limit
+	if headerEnabled(c, "X-Base64") {
+		effectiveLimit = int64(float64(limit) * 1.34)
 	}
 
-	// Try raw standard base64 (no padding)
-	decoded, err = base64.RawStdEncoding.DecodeString(string(encoded))
-	if err == nil {
-		return decoded, nil
+	var content []byte
+	var filename string
+	var err error
+	if h.multipartSpool != nil {
+		content, filename, err = h.readSpooledFilePart(c, effectiveLimit)
+	} else {
+		content, filename, err = h.readFormFile(c, effectiveLimit)
 	}
-
-	// Try raw URL-safe base64 (no padding)
-	decoded, err = base64.RawURLEncoding.DecodeString(string(encoded))
 	if err != nil {
-		return nil, fmt.Errorf("invalid base64 encoding: %v", err)
+		return nil, filename, "", err
 	}
 
-	return decoded, nil
+	contentType := utils.DetectContentType(filename, content)
+	if len(content) == 0 {
+		return nil, filename, contentType, fmt.Errorf("empty content")
+	}
+	return content, filename, contentType, nil
 }
 
-func (h *Handler) readMultipartUpload(c *gin.Context, limit int64) ([]byte, string, string, error) {
+// multipartMemory is how much of a multipart file part is buffered in
+// memory before it spills to the multipart spool.
+const multipartMemory = 1 << 20
+
+// spoolRetryAfter is the Retry-After (seconds) sent when the multipart
+// spool is full.
+const spoolRetryAfter = "5"
+
+// readFormFile reads the "file" part through net/http's form parsing,
+// which spools large parts to os.TempDir.
+func (h *Handler) readFormFile(c *gin.Context, limit int64) ([]byte, string, error) {
 	file, header, err := c.Request.FormFile("file")
 	if err != nil {
-		return nil, "", "", fmt.Errorf("no file provided")
+		return nil, "", fmt.Errorf("no file provided")
 	}
 	defer func() { _ = file.Close() }()
 
 	filename := header.Filename
+	c.Set(partMtimeKey, partMtime(header.Header))
+	if header.Size > 0 && header.Size > limit {
+		return nil, filename, fmt.Errorf("content too large: %d bytes exceeds limit of %d bytes", header.Size, limit)
+	}
 
-	// If content is base64 encoded, adjust limit
-	effectiveLimit := limit
-	if headerEnabled(c, "X-Base64") {
-		effectiveLimit = int64(float64(limit) * 1.34)
+	content, exceeded, err := h.readLimitedContent(file, limit)
+	if err != nil {
+		return nil, filename, fmt.Errorf("failed to read file")
 	}
+	if exceeded {
+		return nil, filename, fmt.Errorf("content too large: exceeds limit of %d bytes", limit)
+	}
+	return content, filename, nil
+}
 
-	if header.Size > 0 && header.Size > effectiveLimit {
-		return nil, filename, "", fmt.Errorf("content too large: %d bytes exceeds limit of %d bytes", header.Size, effectiveLimit)
+// readSpooledFilePart streams the multipart body and reads the "file"
+// part, spilling it to the multipart spool once it outgrows
+// multipartMemory. Other parts are skipped.
+func (h *Handler) readSpooledFilePart(c *gin.Context, limit int64) ([]byte, string, error) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, "", fmt.Errorf("no file provided")
 	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, "", fmt.Errorf("no file provided")
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read file")
+		}
+		if part.FormName() != "file" || part.FileName() == "" {
+			skipped, err := io.Copy(io.Discard, io.LimitReader(part, limit+1))
+			_ = part.Close()
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to read file")
+			}
+			if skipped > limit {
+				return nil, "", fmt.Errorf("content too large: form field exceeds limit of %d bytes", limit)
+			}
+			continue
+		}
+		filename := part.FileName()
+		c.Set(partMtimeKey, partMtime(part.Header))
+		content, err := h.spoolPart(part, limit, c.Request.ContentLength)
+		_ = part.Close()
+		return content, filename, err
+	}
+}
 
-	content, exceeded, err := h.readLimitedContent(file, effectiveLimit)
+// spoolPart reads r up to limit bytes. Content beyond multipartMemory is
+// written to a spool file sized for the worst case, the smaller of limit
+// and the request's Content-Length, before it is read back.
+func (h *Handler) spoolPart(r io.Reader, limit, contentLength int64) ([]byte, error) {
+	head, err := io.ReadAll(io.LimitReader(r, min(limit, multipartMemory)+1))
 	if err != nil {
-		return nil, filename, "", fmt.Errorf("failed to read file")
+		return nil, fmt.Errorf("failed to read file")
 	}
-	if exceeded {
-		return nil, filename, "", fmt.Errorf("content too large: exceeds limit of %d bytes", effectiveLimit)
+	if int64(len(head)) <= min(limit, multipartMemory) {
+		return head, nil
+	}
+	if int64(len(head)) > limit {
+		return nil, fmt.Errorf("content too large: exceeds limit of %d bytes", limit)
 	}
 
-	contentType := utils.DetectContentType(filename, content)
-	if len(content) == 0 {
-		return nil, filename, contentType, fmt.Errorf("empty content")
+	reserve := limit + 1
+	if contentLength > 0 && contentLength < reserve {
+		reserve = contentLength
 	}
-	return content, filename, contentType, nil
+	f, err := h.multipartSpool.Create(reserve)
+	switch {
+	case errors.Is(err, multipartspool.ErrTooLarge):
+		return nil, fmt.Errorf("content too large: exceeds the multipart spool size of %d bytes", h.multipartSpool.Max())
+	case errors.Is(err, multipartspool.ErrFull):
+		return nil, fmt.Errorf("%w, please retry later", err)
+	case err != nil:
+		log.Printf("[ERROR] %v", err)
+		return nil, fmt.Errorf("failed to read file")
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err := io.Copy(f, io.MultiReader(bytes.NewReader(head), r)); err != nil {
+		if errors.Is(err, multipartspool.ErrTooLarge) {
+			return nil, fmt.Errorf("content too large: exceeds limit of %d bytes", limit)
+		}
+		return nil, fmt.Errorf("failed to read file")
+	}
+	if f.Size() > limit {
+		return nil, fmt.Errorf("content too large: exceeds limit of %d bytes", limit)
+	}
+	content, err := io.ReadAll(f.Reader())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file")
+	}
+	return content, nil
 }
 
+// readDirectUpload reads a raw body. Base64 bodies (X-Base64) are decoded
+// as they are read, so the encoded body is never held in memory.
 func (h *Handler) readDirectUpload(c *gin.Context, limit int64) ([]byte, string, string, error) {
 	// Adjust limit for base64 overhead if needed
+	encoded := headerEnabled(c, "X-Base64")
 	effectiveLimit := limit
-	if headerEnabled(c, "X-Base64") {
+	if encoded {
 		// Base64 increases size by ~33%, plus potential padding
 		// Use 1.34x multiplier to account for overhead
 		effectiveLimit = int64(float64(limit) * 1.34)
@@ -204,14 +519,35 @@ func (h *Handler) readDirectUpload(c *gin.Context, limit int64) ([]byte, string,
 		return nil, "", "", fmt.Errorf("content too large: %d bytes exceeds limit of %d bytes", contentLength, effectiveLimit)
 	}
 
-	content, exceeded, err := h.readLimitedContent(c.Request.Body, effectiveLimit)
+	var content []byte
+	var exceeded bool
+	var err error
+	if encoded {
+		content, exceeded, err = decodeBase64(c.Request.Body, c.Request.ContentLength, limit)
+		if err != nil && strings.Contains(err.Error(), "invalid base64") {
+			return nil, "", "", err
+		}
+		if exceeded {
+			return nil, "", "", fmt.Errorf("decoded content too large: exceeds limit of %d bytes", limit)
+		}
+		if err == nil && utils.IsDebugEnabled() {
+			log.Printf("[DEBUG] Base64 decoded: %d bytes → %d bytes", c.Request.ContentLength, len(content))
+		}
+	} else {
+		content, exceeded, err = h.readLimitedContent(c.Request.Body, effectiveLimit)
+		if exceeded {
+			return nil, "", "", fmt.Errorf("content too large: exceeds limit of %d bytes", effectiveLimit)
+		}
+	}
 	if err != nil {
 		return nil, "", "", fmt.Errorf("failed to read content")
 	}
-	if exceeded {
-		return nil, "", "", fmt.Errorf("content too large: exceeds limit of %d bytes", effectiveLimit)
-	}
 
+	// Header values are ASCII, so X-Filename may percent-encode a UTF-8 name.
+	filename := c.GetHeader("X-Filename")
+	if decoded, err := url.PathUnescape(filename); err == nil {
+		filename = decoded
+	}
 	contentType := ""
 	if ct := c.ContentType(); ct != "" {
 		if parsedType, _, err := mime.ParseMediaType(ct); err == nil {
@@ -221,14 +557,83 @@ func (h *Handler) readDirectUpload(c *gin.Context, limit int64) ([]byte, string,
 		}
 	}
 	if contentType == "" {
-		contentType = utils.DetectContentType("", content)
+		contentType = utils.DetectContentType(filename, content)
+	}
+	if len(content) == 0 {
+		return nil, filename, contentType, fmt.Errorf("empty content")
+	}
+	return content, filename, contentType, nil
+}
+
+// formFieldHeaders maps the optional fields of a form-encoded upload to the
+// headers they stand in for.
+var formFieldHeaders = map[string]string{"ttl": "X-TTL", "burn": "X-Burn", "slug": "X-Slug"}
+
+// isFormUpload reports whether the request body is form-encoded, as sent
+// by HTML forms and by curl -d.
+func isFormUpload(c *gin.Context) bool {
+	mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	return err == nil && mediaType == "application/x-www-form-urlencoded"
+}
+
+// readFormUpload reads a form-encoded body. A form with a content field,
+// and no fields other than ttl, burn and slug, is decoded: the paste is
+// the content field, and the other fields stand in for the X-TTL, X-Burn
+// and X-Slug headers, which win when both are sent. Any other body, such
+// as curl -d @file, is kept as sent, like a raw upload, with its content
+// type detected rather than taken from the header.
+func (h *Handler) readFormUpload(c *gin.Context, limit int64) ([]byte, string, string, error) {
+	effectiveLimit := limit
+	if headerEnabled(c, "X-Base64") {
+		effectiveLimit = int64(float64(limit) * 1.34)
+	}
+	// Percent-encoding triples the size of a form at most.
+	body, exceeded, err := h.readLimitedContent(c.Request.Body, 3*effectiveLimit)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to read content")
+	}
+	if exceeded {
+		return nil, "", "", fmt.Errorf("content too large: exceeds limit of %d bytes", effectiveLimit)
+	}
+
+	content := body
+	if form, ok := parseUploadForm(body); ok {
+		content = []byte(form.Get("content"))
+		for field, header := range formFieldHeaders {
+			if v, ok := form[field]; ok && c.GetHeader(header) == "" {
+				c.Request.Header.Set(header, v[0])
+			}
+		}
+	}
+	if int64(len(content)) > effectiveLimit {
+		return nil, "", "", fmt.Errorf("content too large: %d bytes exceeds limit of %d bytes", len(content), effectiveLimit)
 	}
+	contentType := utils.DetectContentType("", content)
 	if len(content) == 0 {
 		return nil, "", contentType, fmt.Errorf("empty content")
 	}
 	return content, "", contentType, nil
 }
 
+// parseUploadForm parses body as an upload form, reporting false when it
+// is not one. Browsers and curl --data-urlencode encode whitespace, so a
+// body containing any is raw text.
+func parseUploadForm(body []byte) (url.Values, bool) {
+	if bytes.ContainsAny(body, " \t\r\n") {
+		return nil, false
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil || len(form["content"]) == 0 {
+		return nil, false
+	}
+	for field := range form {
+		if _, ok := formFieldHeaders[field]; !ok && field != "content" {
+			return nil, false
+		}
+	}
+	return form, true
+}
+
 func (h *Handler) readLimitedContent(r io.Reader, limit int64) ([]byte, bool, error) {
 	if r == nil {
 		return nil, false, fmt.Errorf("nil reader")
@@ -257,40 +662,156 @@ func (h *Handler) readLimitedContent(r io.Reader, limit int64) ([]byte, bool, er
 	return buf, false, nil
 }
 
-// storePasteAndRespond stores paste and responds to client
-func (h *Handler) storePasteAndRespond(c *gin.Context, req services.CreatePasteRequest) {
+// readErrorStatus maps an error from readUploadContent to an HTTP status
+// and error code.
+func readErrorStatus(err error) (int, apierror.Code) {
+	msg := err.Error()
+	switch {
+	case errors.Is(err, multipartspool.ErrFull):
+		return http.StatusServiceUnavailable, apierror.CodeOverloaded
+	case errors.Is(err, errBinaryUnconfirmed):
+		return http.StatusUnprocessableEntity, apierror.CodeBinaryUnconfirmed
+	case strings.Contains(msg, "content too large"):
+		return http.StatusRequestEntityTooLarge, apierror.CodePayloadTooLarge
+	case strings.Contains(msg, "invalid base64"):
+		return http.StatusBadRequest, apierror.CodeInvalidBase64
+	case strings.Contains(msg, "empty"):
+		return http.StatusBadRequest, apierror.CodeEmptyContent
+	}
+	return http.StatusBadRequest, apierror.CodeBadRequest
+}
+
+// storePasteAndRespond stores paste and responds to client. It reports
+// whether the paste was created. X-Verify has the paste read back before
+// it is reported created, on every upload route.
+func (h *Handler) storePasteAndRespond(c *gin.Context, req services.CreatePasteRequest) bool {
+	req.Verify = headerEnabled(c, "X-Verify")
 	resp, err := h.service.CreatePaste(req)
 	if err != nil {
 		// Check if this is a validation error (should return 400) or server error (500)
 		errMsg := err.Error()
-		if strings.Contains(errMsg, "slug already exists") ||
-			strings.Contains(errMsg, "invalid slug format") ||
-			strings.Contains(errMsg, "X-TTL must be between") {
-			c.Header("Content-Type", "application/json; charset=utf-8")
-			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
-			return
+		switch {
+		case errors.Is(err, services.ErrCollectionNotFound):
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidCollection, errMsg)
+			return false
+		case errors.Is(err, services.ErrCollectionForbidden):
+			apierror.JSON(c, http.StatusForbidden, apierror.CodeCollectionForbidden, errMsg)
+			return false
+		case errors.Is(err, services.ErrCollectionFull):
+			apierror.JSON(c, http.StatusConflict, apierror.CodeCollectionFull, errMsg)
+			return false
+		case errors.Is(err, services.ErrCollectionsDisabled):
+			apierror.JSON(c, http.StatusNotImplemented, apierror.CodeUnsupported, errMsg)
+			return false
+		case errors.Is(err, services.ErrBurnAppendable):
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, errMsg)
+			return false
+		case errors.Is(err, services.ErrUploadMissing):
+			apierror.JSON(c, http.StatusConflict, apierror.CodeUploadIncomplete, errMsg)
+			return false
+		case errors.Is(err, services.ErrUploadMismatch):
+			apierror.JSON(c, http.StatusUnprocessableEntity, apierror.CodeUploadMismatch, errMsg)
+			return false
+		case errors.Is(err, services.ErrVerifyFailed):
+			audit.Record(c, audit.ActionCreate, req.CustomSlug, audit.ResultFailure, errMsg)
+			apierror.JSON(c, http.StatusInternalServerError, apierror.CodeVerifyFailed, errMsg)
+			return false
+		case errors.Is(err, tenancy.ErrQuotaExceeded):
+			audit.Record(c, audit.ActionCreate, req.CustomSlug, audit.ResultFailure, errMsg)
+			apierror.JSON(c, http.StatusInsufficientStorage, apierror.CodeTenantQuota, errMsg)
+			return false
+		case strings.Contains(errMsg, "slug already exists"):
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeSlugExists, errMsg)
+			return false
+		case strings.Contains(errMsg, "slug is reserved"):
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeSlugReserved, errMsg)
+			return false
+		case strings.Contains(errMsg, "invalid slug format"):
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidSlug, errMsg)
+			return false
+		case strings.Contains(errMsg, "X-TTL must be between"):
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidTTL, errMsg)
+			return false
 		}
 		// For other errors, return 500
 		log.Printf("[ERROR] Failed to create paste: %v", err)
-		c.Header("Content-Type", "application/json; charset=utf-8")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create paste"})
-		return
+		audit.Record(c, audit.ActionCreate, req.CustomSlug, audit.ResultFailure, err.Error())
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to create paste")
+		return false
+	}
+
+	detail := ""
+	if req.BurnAfterRead {
+		detail = "burn_after_read"
 	}
+	audit.Record(c, audit.ActionCreate, resp.Slug, audit.ResultSuccess, detail)
 
 	pasteURL := h.generatePasteURL(c, resp.Slug)
 	resp.URL = pasteURL
 
+	// The manage URL lets the uploader change or delete the paste from a
+	// browser without an API key. CLI clients get it in a header.
+	manageURL := ""
+	if h.access != nil {
+		manageURL = h.generatePasteURL(c, "manage/"+resp.Slug) + "?token=" + h.access.ManageToken(resp.Slug, resp.CreatedAt)
+		c.Header("X-Manage-URL", manageURL)
+	}
+
+	// Burn-after-read pastes also get a /b/ link carrying their token in
+	// the fragment, which link preview bots never send, so unfurling the
+	// link cannot burn the paste.
+	burnURL := ""
+	if resp.BurnToken != "" {
+		burnURL = h.generatePasteURL(c, "b/"+resp.Slug) + "#k=" + resp.BurnToken
+		c.Header("X-Burn-URL", burnURL)
+	}
+
+	// Appendable pastes get the URL that appends to them, authorized by
+	// the manage token.
+	appendURL := ""
+	if req.Appendable && h.access != nil {
+		appendURL = h.generatePasteURL(c, "api/v1/pastes/"+resp.Slug+"/append") + "?token=" + h.access.ManageToken(resp.Slug, resp.CreatedAt)
+		c.Header("X-Append-URL", appendURL)
+	}
+
 	// Always return JSON for web UI (browser)
 	if h.isCli(c) || c.Request.Header.Get("Accept") == "text/plain" {
+		if resp.ExpiresAt != nil {
+			c.Header("X-Nclip-Expires-At", resp.ExpiresAt.Format(time.RFC3339))
+		}
+		c.Header("X-Nclip-Burn", strconv.FormatBool(resp.BurnAfterRead))
+		if resp.Verification != "" {
+			c.Header("X-Nclip-Verification", resp.Verification)
+		}
 		c.String(http.StatusOK, pasteURL+"\n")
-		return
+		return true
 	}
-	c.Header("Content-Type", "application/json; charset=utf-8")
-	c.JSON(http.StatusOK, gin.H{
+	body := gin.H{
 		"url":             resp.URL,
 		"slug":            resp.Slug,
-		"burn_after_read": req.BurnAfterRead,
-	})
+		"burn_after_read": resp.BurnAfterRead,
+		"expires_at":      resp.ExpiresAt,
+		"size":            resp.Size,
+		"content_type":    resp.ContentType,
+	}
+	if req.Visibility != "" && req.Visibility != models.VisibilityUnlisted {
+		body["visibility"] = req.Visibility
+	}
+	if resp.Verification != "" {
+		body["verification"] = resp.Verification
+	}
+	if manageURL != "" {
+		body["manage_url"] = manageURL
+	}
+	if burnURL != "" {
+		body["burn_url"] = burnURL
+	}
+	if appendURL != "" {
+		body["append_url"] = appendURL
+	}
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.JSON(http.StatusOK, body)
+	return true
 }
 
 // generatePasteURL generates the full URL for a paste
@@ -299,7 +820,7 @@ func (h *Handler) generatePasteURL(c *gin.Context, slug string) string {
 	if h.isHTTPS(c) {
 		scheme = "https"
 	}
-	return fmt.Sprintf("%s://%s/%s", scheme, c.Request.Host, slug)
+	return fmt.Sprintf("%s://%s%s%s", scheme, c.Request.Host, forwarded.PathPrefix(c), h.config.Path("/"+slug))
 }
 
 // isHTTPS detects if the request is over HTTPS
@@ -333,28 +854,44 @@ func (h *Handler) isCli(c *gin.Context) bool {
 	return false
 }
 
+// burnRequested reports whether an upload asks for burn-after-read.
+func burnRequested(c *gin.Context) bool {
+	// Support header-presence semantics: X-Burn enables burn unless explicitly disabled
+	if headerEnabled(c, "X-Burn") {
+		return true
+	}
+	// Fall back to route-based detection for backward compatibility
+	return strings.HasSuffix(c.FullPath(), "/burn/")
+}
+
+// checkBurnScope rejects an upload that is not burn-after-read when the
+// API key has only the burn scope, reporting whether it may go ahead.
+func checkBurnScope(c *gin.Context, burnAfterRead bool) bool {
+	if scopes, ok := access.Scopes(c); ok && !burnAfterRead && !scopes.Has(apikeys.ScopeWrite) {
+		apierror.JSON(c, http.StatusForbidden, apierror.CodeInsufficientScope, "api key may only create burn-after-read pastes (set X-Burn or use /burn/)")
+		return false
+	}
+	return true
+}
+
 // Upload handles paste upload via POST /
 func (h *Handler) Upload(c *gin.Context) {
+	// Keys with only the burn scope may create burn-after-read pastes only;
+	// reject others before reading the body. A form may ask for burn in a
+	// field, so its scope is checked once the body is read.
+	form := isFormUpload(c)
+	if !form && !checkBurnScope(c, burnRequested(c)) {
+		return
+	}
+
 	content, filename, contentType, err := h.readUploadContent(c)
 	if err != nil {
-		log.Printf("[ERROR] %v", err)
-		c.Header("Content-Type", "application/json; charset=utf-8")
-		status := http.StatusBadRequest
-		if strings.Contains(err.Error(), "content too large") {
-			status = http.StatusRequestEntityTooLarge
-		}
-		c.JSON(status, gin.H{"error": err.Error()})
+		h.uploadError(c, err)
 		return
 	}
-
-	// Determine if burn-after-read: check X-Burn header first, then fall back to route path
-	burnAfterRead := false
-	// Support header-presence semantics: X-Burn enables burn unless explicitly disabled
-	if headerEnabled(c, "X-Burn") {
-		burnAfterRead = true
-	} else {
-		// Fall back to route-based detection for backward compatibility
-		burnAfterRead = strings.HasSuffix(c.FullPath(), "/burn/")
+	burnAfterRead := burnRequested(c)
+	if form && !checkBurnScope(c, burnAfterRead) {
+		return
 	}
 
 	req := services.CreatePasteRequest{
@@ -362,6 +899,7 @@ func (h *Handler) Upload(c *gin.Context) {
 		Filename:      filename,
 		ContentType:   contentType,
 		BurnAfterRead: burnAfterRead,
+		Appendable:    headerEnabled(c, "X-Appendable"),
 	}
 
 	// Check for custom slug header
@@ -369,22 +907,45 @@ func (h *Handler) Upload(c *gin.Context) {
 	if customSlug != "" {
 		// Validate slug format
 		if !utils.IsValidSlug(customSlug) {
-			c.Header("Content-Type", "application/json; charset=utf-8")
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid slug format"})
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidSlug, "Invalid slug format")
 			return
 		}
-		req.CustomSlug = customSlug
+		req.CustomSlug = h.storedSlug(customSlug)
 	}
 
 	// Parse TTL
 	ttl, err := h.parseTTL(c)
 	if err != nil {
-		c.Header("Content-Type", "application/json; charset=utf-8")
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidTTL, err.Error())
 		return
 	}
 	req.TTL = time.Until(ttl)
 
+	if req.Tags, err = utils.ParseTags(c.GetHeader("X-Tags")); err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidTags, err.Error())
+		return
+	}
+	if err := h.parseVisibility(c, &req); err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidVisibility, err.Error())
+		return
+	}
+	if err := h.parseCollection(c, &req); err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidCollection, err.Error())
+		return
+	}
+	if err := h.parseNotifyOnBurn(c, &req); err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidNotify, err.Error())
+		return
+	}
+	if req.Source, err = models.ParseSource(c.GetHeader); err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidSource, err.Error())
+		return
+	}
+	if err := h.parseFileMtime(c, &req); err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidMtime, err.Error())
+		return
+	}
+
 	h.storePasteAndRespond(c, req)
 }
 
@@ -392,9 +953,7 @@ func (h *Handler) Upload(c *gin.Context) {
 func (h *Handler) UploadBurn(c *gin.Context) {
 	content, filename, contentType, err := h.readUploadContent(c)
 	if err != nil {
-		log.Printf("[ERROR] %v", err)
-		c.Header("Content-Type", "application/json; charset=utf-8")
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		h.uploadError(c, err)
 		return
 	}
 
@@ -407,11 +966,35 @@ func (h *Handler) UploadBurn(c *gin.Context) {
 
 	expiresAt, err := h.parseTTL(c)
 	if err != nil {
-		c.Header("Content-Type", "application/json; charset=utf-8")
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidTTL, err.Error())
 		return
 	}
 	req.TTL = time.Until(expiresAt)
 
+	if req.Tags, err = utils.ParseTags(c.GetHeader("X-Tags")); err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidTags, err.Error())
+		return
+	}
+	if err := h.parseVisibility(c, &req); err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidVisibility, err.Error())
+		return
+	}
+	if err := h.parseCollection(c, &req); err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidCollection, err.Error())
+		return
+	}
+	if err := h.parseNotifyOnBurn(c, &req); err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidNotify, err.Error())
+		return
+	}
+	if req.Source, err = models.ParseSource(c.GetHeader); err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidSource, err.Error())
+		return
+	}
+	if err := h.parseFileMtime(c, &req); err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidMtime, err.Error())
+		return
+	}
+
 	h.storePasteAndRespond(c, req)
 }
@@ -3,15 +3,19 @@ package upload
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"io"
 	"mime/multipart"
+	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/multipartspool"
 	"github.com/johnwmail/nclip/internal/services"
 	"github.com/johnwmail/nclip/storage"
 )
@@ -525,3 +529,319 @@ func TestXBurnHeader(t *testing.T) {
 		})
 	}
 }
+
+func TestFormUpload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	cfg := &config.Config{BufferSize: 1024 * 1024, DefaultTTL: 24 * time.Hour}
+	handler := NewHandler(services.NewPasteService(store, cfg), cfg)
+	router := gin.New()
+	router.POST("/", handler.Upload)
+
+	tests := []struct {
+		name        string
+		body        string
+		header      map[string]string
+		wantStatus  int
+		wantSlug    string
+		wantContent string
+		wantBurn    bool
+		wantTTL     time.Duration
+	}{
+		{
+			name:        "content field",
+			body:        url.Values{"content": {"hello world\nline 2 & more"}}.Encode(),
+			wantStatus:  200,
+			wantContent: "hello world\nline 2 & more",
+			wantTTL:     24 * time.Hour,
+		},
+		{
+			name:        "optional fields",
+			body:        "content=secret&ttl=2h&burn=on&slug=FRMSLUG",
+			wantStatus:  200,
+			wantSlug:    "FRMSLUG",
+			wantContent: "secret",
+			wantBurn:    true,
+			wantTTL:     2 * time.Hour,
+		},
+		{
+			name:        "headers win over fields",
+			body:        "content=secret&ttl=2h&burn=on",
+			header:      map[string]string{"X-TTL": "3h", "X-Burn": "0"},
+			wantStatus:  200,
+			wantContent: "secret",
+			wantTTL:     3 * time.Hour,
+		},
+		{
+			name:        "curl -d without a content field",
+			body:        "foo=bar",
+			wantStatus:  200,
+			wantContent: "foo=bar",
+			wantTTL:     24 * time.Hour,
+		},
+		{
+			name:        "curl -d @file",
+			body:        "content=not a form\nsecond line",
+			wantStatus:  200,
+			wantContent: "content=not a form\nsecond line",
+			wantTTL:     24 * time.Hour,
+		},
+		{
+			name:        "unknown field",
+			body:        "content=x&user=me",
+			wantStatus:  200,
+			wantContent: "content=x&user=me",
+			wantTTL:     24 * time.Hour,
+		},
+		{
+			name:       "empty content field",
+			body:       "content=",
+			wantStatus: 400,
+		},
+		{
+			name:       "invalid ttl field",
+			body:       "content=x&ttl=forever",
+			wantStatus: 400,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != 200 {
+				return
+			}
+			var resp struct {
+				Slug string `json:"slug"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("bad response %q: %v", w.Body.String(), err)
+			}
+			if tt.wantSlug != "" && resp.Slug != tt.wantSlug {
+				t.Errorf("slug = %q, want %q", resp.Slug, tt.wantSlug)
+			}
+			paste, err := store.Get(resp.Slug)
+			if err != nil {
+				t.Fatalf("Get(%s): %v", resp.Slug, err)
+			}
+			content, err := store.GetContent(resp.Slug)
+			if err != nil {
+				t.Fatalf("GetContent(%s): %v", resp.Slug, err)
+			}
+			if string(content) != tt.wantContent {
+				t.Errorf("content = %q, want %q", content, tt.wantContent)
+			}
+			if strings.Contains(paste.ContentType, "form") {
+				t.Errorf("content type = %q, want it detected", paste.ContentType)
+			}
+			if paste.BurnAfterRead != tt.wantBurn {
+				t.Errorf("burn_after_read = %v, want %v", paste.BurnAfterRead, tt.wantBurn)
+			}
+			if ttl := time.Until(*paste.ExpiresAt); ttl > tt.wantTTL || ttl < tt.wantTTL-time.Minute {
+				t.Errorf("ttl = %v, want %v", ttl, tt.wantTTL)
+			}
+		})
+	}
+}
+
+func TestMultipartUpload_Spool(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	cfg := &config.Config{BufferSize: 4 << 20, DefaultTTL: 24 * time.Hour}
+	service := services.NewPasteService(store, cfg)
+
+	large := bytes.Repeat([]byte("spool me "), (2<<20)/9)
+	newRequest := func(content []byte) *http.Request {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		_ = writer.WriteField("note", "skipped")
+		part, err := writer.CreateFormFile("file", "big.txt")
+		if err != nil {
+			t.Fatalf("Failed to create form file: %v", err)
+		}
+		_, _ = part.Write(content)
+		_ = writer.Close()
+		req := httptest.NewRequest("POST", "/", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req
+	}
+
+	cases := []struct {
+		name     string
+		max      int64
+		held     int64
+		content  []byte
+		want     int
+		wantCode string
+	}{
+		{"spooled", 8 << 20, 0, large, http.StatusOK, ""},
+		{"in memory while full", 8 << 20, 8 << 20, []byte("small"), http.StatusOK, ""},
+		{"full", 8 << 20, 7 << 20, large, http.StatusServiceUnavailable, "overloaded"},
+		{"never fits", 1 << 20, 0, large, http.StatusRequestEntityTooLarge, "payload_too_large"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			spool, err := multipartspool.New(t.TempDir(), tc.max)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.held > 0 {
+				held, err := spool.Create(tc.held)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer func() { _ = held.Close() }()
+			}
+			handler := NewHandler(service, cfg)
+			handler.SetMultipartSpool(spool)
+			router := gin.New()
+			router.POST("/", handler.Upload)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, newRequest(tc.content))
+			if w.Code != tc.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tc.want, w.Body.String())
+			}
+			if tc.wantCode != "" && !strings.Contains(w.Body.String(), tc.wantCode) {
+				t.Errorf("body %s does not contain code %q", w.Body.String(), tc.wantCode)
+			}
+			if tc.want == http.StatusServiceUnavailable && w.Header().Get("Retry-After") == "" {
+				t.Error("missing Retry-After header")
+			}
+			if got := spool.Used(); got != tc.held {
+				t.Errorf("spool reservations after request = %d, want %d", got, tc.held)
+			}
+			if tc.want != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Slug string `json:"slug"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("bad response %q: %v", w.Body.String(), err)
+			}
+			got, err := store.GetContent(resp.Slug)
+			if err != nil {
+				t.Fatalf("GetContent(%s): %v", resp.Slug, err)
+			}
+			if !bytes.Equal(got, tc.content) {
+				t.Errorf("stored %d bytes, want %d", len(got), len(tc.content))
+			}
+		})
+	}
+}
+
+func TestUploadResponse_ReportsStoredPaste(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	cfg := &config.Config{BufferSize: 1024, DefaultTTL: 24 * time.Hour, MinRetention: 2 * time.Hour}
+	handler := NewHandler(services.NewPasteService(store, cfg), cfg)
+	router := gin.New()
+	router.POST("/", handler.Upload)
+
+	// X-TTL is raised to MinRetention; the response reports the result.
+	req := httptest.NewRequest("POST", "/", strings.NewReader("hello"))
+	req.Header.Set("X-TTL", "1h")
+	req.Header.Set("X-Burn", "1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Slug          string    `json:"slug"`
+		ExpiresAt     time.Time `json:"expires_at"`
+		BurnAfterRead bool      `json:"burn_after_read"`
+		Size          int64     `json:"size"`
+		ContentType   string    `json:"content_type"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("bad response %q: %v", w.Body.String(), err)
+	}
+	if ttl := time.Until(resp.ExpiresAt); ttl < time.Hour+59*time.Minute || ttl > 2*time.Hour {
+		t.Errorf("expires_at is %s away, want about 2h", ttl)
+	}
+	if resp.ExpiresAt.Location() != time.UTC {
+		t.Errorf("expires_at %s is not UTC", resp.ExpiresAt)
+	}
+	if !resp.BurnAfterRead || resp.Size != 5 || !strings.HasPrefix(resp.ContentType, "text/plain") {
+		t.Errorf("response = %+v, want burn_after_read, size 5 and text/plain", resp)
+	}
+
+	req = httptest.NewRequest("POST", "/", strings.NewReader("hello"))
+	req.Header.Set("User-Agent", "curl/8.0")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	expires, err := time.Parse(time.RFC3339, w.Header().Get("X-Nclip-Expires-At"))
+	if err != nil {
+		t.Fatalf("X-Nclip-Expires-At: %v", err)
+	}
+	if ttl := time.Until(expires); ttl < 23*time.Hour || ttl > 24*time.Hour {
+		t.Errorf("X-Nclip-Expires-At is %s away, want about 24h", ttl)
+	}
+	if got := w.Header().Get("X-Nclip-Burn"); got != "false" {
+		t.Errorf("X-Nclip-Burn = %q, want false", got)
+	}
+}
+
+func TestUpload_BinaryGuard(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	cfg := &config.Config{BufferSize: 1 << 20, DefaultTTL: 24 * time.Hour, BinaryConfirmSize: 1024}
+	handler := NewHandler(services.NewPasteService(store, cfg), cfg)
+	router := gin.New()
+	router.POST("/", handler.Upload)
+
+	binary := bytes.Repeat([]byte{0x00, 0xff, 0x10, 0x80}, 512)
+	cases := []struct {
+		name    string
+		content []byte
+		allow   string
+		want    int
+	}{
+		{"large binary", binary, "", http.StatusUnprocessableEntity},
+		{"confirmed binary", binary, "true", http.StatusOK},
+		{"explicitly unconfirmed", binary, "false", http.StatusUnprocessableEntity},
+		{"small binary", binary[:1024], "", http.StatusOK},
+		{"large text", bytes.Repeat([]byte("text "), 1024), "", http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", bytes.NewReader(tc.content))
+			if tc.allow != "" {
+				req.Header.Set("X-Allow-Binary", tc.allow)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tc.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tc.want, w.Body.String())
+			}
+			if tc.want == http.StatusUnprocessableEntity && !strings.Contains(w.Body.String(), `"binary_unconfirmed"`) {
+				t.Errorf("body = %s, want code binary_unconfirmed", w.Body.String())
+			}
+		})
+	}
+}
@@ -1,14 +1,24 @@
 package upload
 
 import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
 	"net/http/httptest"
+	"net/textproto"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/hmackeys"
+	"github.com/johnwmail/nclip/internal/multipartspool"
 	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/models"
 	"github.com/johnwmail/nclip/storage"
 )
 
@@ -181,3 +191,313 @@ func TestCustomSlugHeaderValidation(t *testing.T) {
 		t.Fatalf("expected 400 for invalid slug, got %d: %s", w.Code, w.Body.String())
 	}
 }
+
+func TestTagsHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	cfg := &config.Config{
+		BufferSize: 1024 * 1024,
+		DefaultTTL: 24 * time.Hour,
+	}
+	h := NewHandler(services.NewPasteService(store, cfg), cfg)
+
+	router := gin.New()
+	router.POST("/", h.Upload)
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader("hello"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Slug", "TAGGD")
+	req.Header.Set("X-Tags", "Work, logs")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	paste, err := store.Get("TAGGD")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if len(paste.Tags) != 2 || paste.Tags[0] != "work" || paste.Tags[1] != "logs" {
+		t.Errorf("expected normalized tags [work logs], got %v", paste.Tags)
+	}
+
+	req = httptest.NewRequest("POST", "/", strings.NewReader("hello"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Tags", "bad tag")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "invalid_tags") {
+		t.Fatalf("expected 400 invalid_tags, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSourceHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	cfg := &config.Config{
+		BufferSize: 1024 * 1024,
+		DefaultTTL: 24 * time.Hour,
+	}
+	h := NewHandler(services.NewPasteService(store, cfg), cfg)
+
+	router := gin.New()
+	router.POST("/", h.Upload)
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader("build log"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Slug", "CXBLD")
+	req.Header.Set("X-Source-Repo", " org/app ")
+	req.Header.Set("X-Source-Pipeline", "release")
+	req.Header.Set("X-Source-Job-URL", "https://ci.example.com/jobs/42")
+	req.Header.Set("X-Source-Commit", "ABCDEF1234")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	paste, err := store.Get("CXBLD")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	want := map[string]string{"repo": "org/app", "pipeline": "release", "job_url": "https://ci.example.com/jobs/42", "commit": "abcdef1234"}
+	if len(paste.Source) != len(want) {
+		t.Fatalf("expected source %v, got %v", want, paste.Source)
+	}
+	for k, v := range want {
+		if paste.Source[k] != v {
+			t.Errorf("source[%s] = %q, want %q", k, paste.Source[k], v)
+		}
+	}
+
+	for name, value := range map[string]string{
+		"X-Source-Commit":  "not-a-sha",
+		"X-Source-Job-URL": "javascript:alert(1)",
+		"X-Source-Repo":    strings.Repeat("a", 257),
+	} {
+		req = httptest.NewRequest("POST", "/", strings.NewReader("hello"))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set(name, value)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != 400 || !strings.Contains(w.Body.String(), "invalid_source") {
+			t.Errorf("%s: expected 400 invalid_source, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestFileMtimeHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	cfg := &config.Config{
+		BufferSize: 1024 * 1024,
+		DefaultTTL: 24 * time.Hour,
+	}
+	h := NewHandler(services.NewPasteService(store, cfg), cfg)
+
+	router := gin.New()
+	router.POST("/", h.Upload)
+	want := time.Date(2026, 9, 30, 8, 15, 0, 0, time.UTC)
+	check := func(name, slug string, req *http.Request) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != 200 {
+			t.Fatalf("%s: expected 200, got %d: %s", name, w.Code, w.Body.String())
+		}
+		paste, err := store.Get(slug)
+		if err != nil {
+			t.Fatalf("%s: Get failed: %v", name, err)
+		}
+		if paste.FileMtime == nil || !paste.FileMtime.Equal(want) {
+			t.Errorf("%s: expected file mtime %v, got %v", name, want, paste.FileMtime)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader("artifact"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Slug", "MTHDR")
+	req.Header.Set("X-File-Mtime", "1790756100")
+	check("header", "MTHDR", req)
+
+	// A multipart part carries it in its Content-Disposition, read by both
+	// the form parser and the spooling reader.
+	multipartReq := func(slug string) *http.Request {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Disposition": {`form-data; name="file"; filename="build.log"; modification-date="Wed, 30 Sep 2026 08:15:00 GMT"`},
+		})
+		if err != nil {
+			t.Fatalf("CreatePart: %v", err)
+		}
+		_, _ = part.Write([]byte("artifact"))
+		if err := writer.Close(); err != nil {
+			t.Fatalf("failed to close multipart writer: %v", err)
+		}
+		req := httptest.NewRequest("POST", "/", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("X-Slug", slug)
+		return req
+	}
+	check("multipart", "MTFRM", multipartReq("MTFRM"))
+	spool, err := multipartspool.New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("multipartspool.New: %v", err)
+	}
+	h.SetMultipartSpool(spool)
+	check("spooled multipart", "MTSPL", multipartReq("MTSPL"))
+
+	for _, v := range []string{"last tuesday", "4102444800"} {
+		req = httptest.NewRequest("POST", "/", strings.NewReader("hello"))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("X-File-Mtime", v)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != 400 || !strings.Contains(w.Body.String(), "invalid_mtime") {
+			t.Errorf("%q: expected 400 invalid_mtime, got %d: %s", v, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestVisibilityHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	cfg := &config.Config{
+		BufferSize: 1024 * 1024,
+		DefaultTTL: 24 * time.Hour,
+	}
+	h := NewHandler(services.NewPasteService(store, cfg), cfg)
+	h.SetAccess(access.NewChecker(apikeys.Parse("alice"), hmackeys.New("secret", nil)))
+
+	router := gin.New()
+	router.POST("/", h.Upload)
+	upload := func(slug, visibility, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("hello"))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("X-Slug", slug)
+		req.Header.Set("X-Visibility", visibility)
+		if key != "" {
+			req.Header.Set("X-Api-Key", key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := upload("PUBLC", "Public", ""); w.Code != 200 || !strings.Contains(w.Body.String(), `"visibility":"public"`) {
+		t.Fatalf("expected 200 with public visibility, got %d: %s", w.Code, w.Body.String())
+	}
+	if p, err := store.Get("PUBLC"); err != nil || p.Visibility != models.VisibilityPublic {
+		t.Errorf("expected stored public visibility, got %+v (%v)", p, err)
+	}
+
+	for name, key := range map[string]string{"no key": "", "unknown key": "mallory"} {
+		if w := upload("PRVTA", "private", key); w.Code != 400 || !strings.Contains(w.Body.String(), "invalid_visibility") {
+			t.Errorf("%s: expected 400 invalid_visibility, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+	if w := upload("PRVTB", "private", "alice"); w.Code != 200 {
+		t.Fatalf("expected 200 for private upload with a key, got %d: %s", w.Code, w.Body.String())
+	}
+	p, err := store.Get("PRVTB")
+	if err != nil || !p.IsPrivate() || p.Owner != audit.KeyID("alice") {
+		t.Errorf("expected private paste owned by alice, got %+v (%v)", p, err)
+	}
+
+	if w := upload("SECRT", "secret", ""); w.Code != 400 || !strings.Contains(w.Body.String(), "invalid_visibility") {
+		t.Errorf("expected 400 invalid_visibility, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestFilenameHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	cfg := &config.Config{
+		BufferSize: 1024 * 1024,
+		DefaultTTL: 24 * time.Hour,
+	}
+	h := NewHandler(services.NewPasteService(store, cfg), cfg)
+
+	router := gin.New()
+	router.POST("/", h.Upload)
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"a":1}`))
+	req.Header.Set("X-Slug", "NAMED")
+	req.Header.Set("X-Filename", "../r%C3%A9sum%C3%A9%0A.json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	paste, err := store.Get("NAMED")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if paste.Filename != "résumé.json" || paste.ContentType != "application/json" {
+		t.Errorf("expected a sanitized filename and a type detected from it, got %q %q", paste.Filename, paste.ContentType)
+	}
+}
+
+func TestVerifyHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	cfg := &config.Config{
+		BufferSize: 1024 * 1024,
+		DefaultTTL: 24 * time.Hour,
+	}
+	h := NewHandler(services.NewPasteService(store, cfg), cfg)
+
+	router := gin.New()
+	router.POST("/", h.Upload)
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader("hello"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Verify", "true")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"verification":"verified"`) {
+		t.Fatalf("expected a verified upload, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// CLI clients get the outcome in a header.
+	req = httptest.NewRequest("POST", "/", strings.NewReader("hello"))
+	req.Header.Set("User-Agent", "curl/8.0")
+	req.Header.Set("X-Verify", "1")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if got := w.Header().Get("X-Nclip-Verification"); got != "verified" {
+		t.Errorf("X-Nclip-Verification = %q, want verified", got)
+	}
+
+	req = httptest.NewRequest("POST", "/", strings.NewReader("hello"))
+	req.Header.Set("Content-Type", "text/plain")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if strings.Contains(w.Body.String(), "verification") {
+		t.Errorf("expected no verification without X-Verify, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,142 @@
+package upload
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/internal/uploadlink"
+)
+
+// createLinkRequest is the optional JSON body of POST /api/v1/upload-links.
+type createLinkRequest struct {
+	// ExpiresIn is how long the link stays usable (default 24h, max 7d).
+	ExpiresIn string `json:"expires_in"`
+	// MaxSize is the largest accepted upload in bytes (default and max:
+	// the server's buffer size).
+	MaxSize int64 `json:"max_size"`
+	// TTL is the lifetime of the created paste (default: server default).
+	TTL           string `json:"ttl"`
+	BurnAfterRead bool   `json:"burn_after_read"`
+}
+
+// parse validates r against the server limits and returns the link's
+// validity, size limit and paste TTL, defaultTTL unless r sets one.
+func (r createLinkRequest) parse(cfg *config.Config, defaultTTL time.Duration) (time.Duration, int64, time.Duration, error) {
+	validity := uploadlink.DefaultValidity
+	if r.ExpiresIn != "" {
+		d, err := time.ParseDuration(r.ExpiresIn)
+		if err != nil || d <= 0 || d > uploadlink.MaxValidity {
+			return 0, 0, 0, fmt.Errorf("expires_in must be a duration between 1s and %s", uploadlink.MaxValidity)
+		}
+		validity = d
+	}
+	maxSize := cfg.BufferSize
+	if r.MaxSize < 0 || r.MaxSize > cfg.BufferSize {
+		return 0, 0, 0, fmt.Errorf("max_size must be between 1 and %d bytes", cfg.BufferSize)
+	}
+	if r.MaxSize > 0 {
+		maxSize = r.MaxSize
+	}
+	ttl := defaultTTL
+	if r.TTL != "" {
+		d, err := time.ParseDuration(r.TTL)
+		if err != nil || d < config.MinTTL || d > config.MaxTTL {
+			return 0, 0, 0, fmt.Errorf("ttl must be between 1h and 7d")
+		}
+		ttl = d
+	}
+	return validity, maxSize, ttl, nil
+}
+
+// CreateLink handles POST /api/v1/upload-links, issuing a signed URL that
+// lets someone without an API key create exactly one paste.
+func (h *Handler) CreateLink(c *gin.Context) {
+	if h.links == nil {
+		apierror.JSON(c, http.StatusNotImplemented, apierror.CodeUnsupported, "upload links are not enabled")
+		return
+	}
+	var req createLinkRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "invalid JSON body")
+		return
+	}
+	validity, maxSize, ttl, err := req.parse(h.config, h.defaultTTL())
+	if err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, err.Error())
+		return
+	}
+
+	link, err := h.links.New(validity, maxSize, ttl, req.BurnAfterRead)
+	if err != nil {
+		log.Printf("[ERROR] Failed to create upload link: %v", err)
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to create upload link")
+		return
+	}
+	audit.Record(c, audit.ActionUploadLink, "", audit.ResultSuccess, "link:"+link.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"url":             h.generatePasteURL(c, "u/"+h.links.Encode(link)),
+		"id":              link.ID,
+		"expires_at":      link.ExpiresAt().UTC(),
+		"max_size":        link.MaxSize,
+		"ttl":             link.PasteTTL().String(),
+		"burn_after_read": link.Burn,
+	})
+}
+
+// UploadWithLink handles POST /u/:token: an upload authorized by a link
+// from CreateLink instead of an API key. The link's constraints apply and
+// it cannot be used again, even after the paste is gone.
+func (h *Handler) UploadWithLink(c *gin.Context) {
+	if h.links == nil {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Resource not found")
+		return
+	}
+	link, err := h.links.Decode(c.Param("token"))
+	switch {
+	case errors.Is(err, uploadlink.ErrExpired):
+		apierror.JSON(c, http.StatusGone, apierror.CodeLinkExpired, err.Error())
+		return
+	case err != nil:
+		apierror.JSON(c, http.StatusForbidden, apierror.CodeLinkInvalid, err.Error())
+		return
+	}
+	audit.SetActor(c, "link:"+link.ID)
+
+	content, filename, contentType, err := h.readUploadContentLimit(c, link.MaxSize)
+	if err != nil {
+		status, code := readErrorStatus(err)
+		apierror.JSON(c, status, code, err.Error())
+		return
+	}
+
+	if err := h.service.ClaimUploadLink(link.ID); err != nil {
+		if errors.Is(err, services.ErrUploadLinkUsed) {
+			apierror.JSON(c, http.StatusGone, apierror.CodeLinkUsed, err.Error())
+			return
+		}
+		log.Printf("[ERROR] %v", err)
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to create paste")
+		return
+	}
+	ok := h.storePasteAndRespond(c, services.CreatePasteRequest{
+		Content:       content,
+		Filename:      filename,
+		ContentType:   contentType,
+		BurnAfterRead: link.Burn,
+		TTL:           link.PasteTTL(),
+	})
+	if !ok {
+		h.service.ReleaseUploadLink(link.ID)
+	}
+}
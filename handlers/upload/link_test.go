@@ -0,0 +1,91 @@
+package upload
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/hmackeys"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/internal/uploadlink"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func TestUploadLinks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	cfg := &config.Config{BufferSize: 1024, DefaultTTL: 24 * time.Hour}
+	handler := NewHandler(services.NewPasteService(store, cfg), cfg)
+	handler.SetUploadLinks(uploadlink.NewSigner(hmackeys.New("secret", nil)))
+	router := gin.New()
+	router.POST("/api/v1/upload-links", handler.CreateLink)
+	router.POST("/u/:token", handler.UploadWithLink)
+
+	do := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Accept", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Invalid constraints are rejected.
+	for _, body := range []string{`{"max_size": 4096}`, `{"ttl": "1m"}`, `{"expires_in": "30d"}`, `{`} {
+		if w := do("/api/v1/upload-links", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+
+	w := do("/api/v1/upload-links", `{"max_size": 8, "ttl": "2h", "burn_after_read": true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create link: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var link struct {
+		URL           string `json:"url"`
+		MaxSize       int64  `json:"max_size"`
+		BurnAfterRead bool   `json:"burn_after_read"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &link); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	_, path, ok := strings.Cut(link.URL, "example.com")
+	if !ok || !strings.HasPrefix(path, "/u/") || link.MaxSize != 8 || !link.BurnAfterRead {
+		t.Fatalf("unexpected link response: %s", w.Body.String())
+	}
+
+	if w := do(path, "too large!"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized upload: expected 413, got %d", w.Code)
+	}
+	if w := do(path+"x", "hello"); w.Code != http.StatusForbidden {
+		t.Errorf("tampered token: expected 403, got %d", w.Code)
+	}
+
+	w = do(path, "hello")
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Slug string `json:"slug"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &created)
+	paste, err := store.Get(created.Slug)
+	if err != nil || !paste.BurnAfterRead || time.Until(*paste.ExpiresAt) > 2*time.Hour {
+		t.Errorf("link constraints not applied to paste: %+v (%v)", paste, err)
+	}
+
+	// Single use, even once the paste is gone.
+	if err := store.Delete(created.Slug); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if w := do(path, "again"); w.Code != http.StatusGone {
+		t.Errorf("reuse: expected 410, got %d", w.Code)
+	}
+}
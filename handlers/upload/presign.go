@@ -0,0 +1,198 @@
+package upload
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/presignupload"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/storage"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// directUploads are the dependencies of presigned uploads.
+type directUploads struct {
+	presigner storage.ContentPresigner
+	tickets   *presignupload.Signer
+}
+
+// SetDirectUploads enables presigned uploads: clients store content
+// through URLs from presigner and finalize the paste with tickets signed
+// by tickets.
+func (h *Handler) SetDirectUploads(presigner storage.ContentPresigner, tickets *presignupload.Signer) {
+	h.direct = &directUploads{presigner: presigner, tickets: tickets}
+}
+
+// presignRequest is the JSON body of POST /api/v1/presign-upload.
+type presignRequest struct {
+	// Size is the exact content size in bytes.
+	Size int64 `json:"size"`
+	// ContentType is enforced on the upload; empty detects it from the
+	// content when the paste is finalized.
+	ContentType string `json:"content_type"`
+	Filename    string `json:"filename"`
+	// TTL is the lifetime of the paste (default: server default).
+	TTL           string `json:"ttl"`
+	BurnAfterRead bool   `json:"burn_after_read"`
+	// AllowBinary confirms a large binary upload, like X-Allow-Binary.
+	AllowBinary bool `json:"allow_binary"`
+}
+
+// finalizeRequest is the JSON body of POST /api/v1/finalize/:slug.
+type finalizeRequest struct {
+	Token string `json:"token"`
+}
+
+// directUploadLimit returns the largest presigned upload the request may
+// start: NCLIP_PRESIGN_MAX_SIZE, or the API key's size limit if smaller.
+func (h *Handler) directUploadLimit(c *gin.Context) int64 {
+	limit := h.config.PresignMaxSize
+	key := ""
+	if h.access.Owner(c) != "" {
+		key = access.APIKey(c)
+	}
+	if size, ok := h.sizeLimits.Limit(key); ok {
+		limit = min(limit, size)
+	}
+	return limit
+}
+
+// PresignUpload handles POST /api/v1/presign-upload. It reserves a slug
+// and returns a presigned URL the client PUTs the content to, bypassing
+// the server, and a ticket to finalize the paste with afterwards.
+func (h *Handler) PresignUpload(c *gin.Context) {
+	if h.direct == nil {
+		apierror.JSON(c, http.StatusNotImplemented, apierror.CodeUnsupported, "direct uploads are not enabled")
+		return
+	}
+	var req presignRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Size <= 0 {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeEmptyContent, "size must be positive")
+		return
+	}
+	if limit := h.directUploadLimit(c); req.Size > limit {
+		apierror.JSONDetail(c, http.StatusRequestEntityTooLarge, apierror.CodePayloadTooLarge,
+			"content too large", fmt.Sprintf("the limit for direct uploads is %d bytes", limit))
+		return
+	}
+	if req.ContentType != "" {
+		if _, _, err := mime.ParseMediaType(req.ContentType); err != nil {
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "invalid content_type")
+			return
+		}
+	}
+	ttl := h.defaultTTL()
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d < config.MinTTL || d > config.MaxTTL {
+			apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidTTL, "ttl must be between 1h and 7d")
+			return
+		}
+		ttl = d
+	}
+	// Finalizing checks that content declared as text is text, so the
+	// declared type is enough to apply the binary guard here.
+	if threshold := h.config.BinaryConfirmSize; threshold > 0 && req.Size > threshold && !utils.IsTextContent(req.ContentType) && !req.AllowBinary {
+		binaryRefused.Inc()
+		apierror.JSON(c, http.StatusUnprocessableEntity, apierror.CodeBinaryUnconfirmed,
+			fmt.Sprintf("%v: the upload looks binary (%q, %d bytes, more than %d); set allow_binary if you meant to upload it",
+				errBinaryUnconfirmed, req.ContentType, req.Size, threshold))
+		return
+	}
+
+	slug, err := h.service.GenerateSlug()
+	if err != nil {
+		log.Printf("[ERROR] Presign upload: %v", err)
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to reserve a slug")
+		return
+	}
+	if err := h.service.AssignTenant(slug, h.access.Tenant(c)); err != nil {
+		log.Printf("[ERROR] Presign upload: %v", err)
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to reserve a slug")
+		return
+	}
+	url, header, err := h.direct.presigner.PresignContentPut(slug, req.Size, req.ContentType, presignupload.URLValidity)
+	if err != nil {
+		log.Printf("[ERROR] Presign upload: failed to presign %s: %v", slug, err)
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to presign the upload")
+		return
+	}
+	ticket := h.direct.tickets.New(presignupload.Ticket{
+		Slug:        slug,
+		Size:        req.Size,
+		ContentType: req.ContentType,
+		Filename:    req.Filename,
+		TTL:         int64(ttl / time.Second),
+		Burn:        req.BurnAfterRead,
+		Owner:       h.access.Owner(c),
+		Tenant:      h.access.Tenant(c),
+	})
+	headers := make(map[string]string, len(header))
+	for name := range header {
+		headers[name] = header.Get(name)
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"slug":         slug,
+		"upload_url":   url,
+		"method":       http.MethodPut,
+		"headers":      headers,
+		"expires_at":   time.Now().Add(presignupload.URLValidity).UTC(),
+		"finalize_url": h.generatePasteURL(c, "api/v1/finalize/"+slug),
+		"token":        h.direct.tickets.Encode(ticket),
+	})
+}
+
+// Finalize handles POST /api/v1/finalize/:slug: once the content of a
+// presigned upload is stored, it checks its size and type against the
+// ticket and writes the paste's metadata. The response is that of a
+// regular upload.
+func (h *Handler) Finalize(c *gin.Context) {
+	if h.direct == nil {
+		apierror.JSON(c, http.StatusNotImplemented, apierror.CodeUnsupported, "direct uploads are not enabled")
+		return
+	}
+	var req finalizeRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "invalid JSON body")
+		return
+	}
+	ticket, err := h.direct.tickets.Decode(req.Token)
+	switch {
+	case errors.Is(err, presignupload.ErrExpired):
+		apierror.JSON(c, http.StatusGone, apierror.CodeTicketExpired, err.Error())
+		return
+	case err != nil:
+		apierror.JSON(c, http.StatusForbidden, apierror.CodeTicketInvalid, err.Error())
+		return
+	case ticket.Slug != c.Param("slug"):
+		apierror.JSON(c, http.StatusForbidden, apierror.CodeTicketInvalid, "upload ticket is for another slug")
+		return
+	}
+	audit.SetActor(c, "presign")
+	h.storePasteAndRespond(c, services.CreatePasteRequest{
+		CustomSlug:    ticket.Slug,
+		Uploaded:      true,
+		UploadedSize:  ticket.Size,
+		ContentType:   ticket.ContentType,
+		Filename:      ticket.Filename,
+		BurnAfterRead: ticket.Burn,
+		TTL:           ticket.PasteTTL(),
+		Owner:         ticket.Owner,
+		Tenant:        ticket.Tenant,
+	})
+}
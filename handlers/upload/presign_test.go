@@ -0,0 +1,134 @@
+package upload
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/hmackeys"
+	"github.com/johnwmail/nclip/internal/presignupload"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/storage"
+)
+
+// fakePresigner hands out URLs without signing anything; tests store the
+// content themselves.
+type fakePresigner struct{}
+
+func (fakePresigner) PresignContentPut(id string, size int64, contentType string, validity time.Duration) (string, http.Header, error) {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	return "https://bucket.example.com/" + id, header, nil
+}
+
+func TestDirectUploads(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	cfg := &config.Config{BufferSize: 16, PresignMaxSize: 1024, DefaultTTL: 24 * time.Hour}
+	handler := NewHandler(services.NewPasteService(store, cfg), cfg)
+	router := gin.New()
+	router.POST("/api/v1/presign-upload", handler.PresignUpload)
+	router.POST("/api/v1/finalize/:slug", handler.Finalize)
+
+	do := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Accept", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+	type presigned struct {
+		Slug      string            `json:"slug"`
+		UploadURL string            `json:"upload_url"`
+		Headers   map[string]string `json:"headers"`
+		Token     string            `json:"token"`
+	}
+	presign := func(body string) presigned {
+		t.Helper()
+		w := do("/api/v1/presign-upload", body)
+		if w.Code != http.StatusOK {
+			t.Fatalf("presign: expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var p presigned
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		return p
+	}
+
+	if w := do("/api/v1/presign-upload", `{"size": 10}`); w.Code != http.StatusNotImplemented {
+		t.Errorf("disabled: expected 501, got %d", w.Code)
+	}
+	handler.SetDirectUploads(fakePresigner{}, presignupload.NewSigner(hmackeys.New("secret", nil)))
+
+	for body, want := range map[string]int{
+		`{"size": 0}`:                       http.StatusBadRequest,
+		`{"size": 2048}`:                    http.StatusRequestEntityTooLarge,
+		`{"size": 10, "ttl": "1m"}`:         http.StatusBadRequest,
+		`{"size": 10, "content_type": ";"}`: http.StatusBadRequest,
+		`{`:                                 http.StatusBadRequest,
+	} {
+		if w := do("/api/v1/presign-upload", body); w.Code != want {
+			t.Errorf("%s: expected %d, got %d", body, want, w.Code)
+		}
+	}
+
+	// Content larger than the buffer size is accepted once stored.
+	content := "direct upload larger than the buffer\n"
+	p := presign(`{"size": ` + strconv.Itoa(len(content)) + `, "content_type": "text/plain", "filename": "notes.txt", "ttl": "2h"}`)
+	if p.Slug == "" || !strings.HasSuffix(p.UploadURL, "/"+p.Slug) || p.Headers["Content-Type"] != "text/plain" {
+		t.Fatalf("unexpected presign response: %+v", p)
+	}
+	finalize := "/api/v1/finalize/" + p.Slug
+	if w := do(finalize, `{"token": "`+p.Token+`"}`); w.Code != http.StatusConflict {
+		t.Errorf("finalize before upload: expected 409, got %d", w.Code)
+	}
+	if w := do("/api/v1/finalize/OTHER", `{"token": "`+p.Token+`"}`); w.Code != http.StatusForbidden {
+		t.Errorf("finalize for another slug: expected 403, got %d", w.Code)
+	}
+	if w := do(finalize, `{"token": "`+p.Token+`x"}`); w.Code != http.StatusForbidden {
+		t.Errorf("tampered token: expected 403, got %d", w.Code)
+	}
+	if err := store.StoreContent(p.Slug, []byte(content)); err != nil {
+		t.Fatalf("StoreContent: %v", err)
+	}
+	w := do(finalize, `{"token": "`+p.Token+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("finalize: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	paste, err := store.Get(p.Slug)
+	if err != nil || paste == nil || paste.Size != int64(len(content)) || paste.Filename != "notes.txt" ||
+		paste.ContentType != "text/plain" || time.Until(*paste.ExpiresAt) > 2*time.Hour {
+		t.Errorf("unexpected paste: %+v (%v)", paste, err)
+	}
+	if w := do(finalize, `{"token": "`+p.Token+`"}`); w.Code == http.StatusOK {
+		t.Error("finalizing twice: expected an error")
+	}
+
+	// Stored content that does not match the ticket is refused.
+	p = presign(`{"size": 4, "content_type": "text/plain"}`)
+	if err := store.StoreContent(p.Slug, []byte{0, 1, 2, 3}); err != nil {
+		t.Fatalf("StoreContent: %v", err)
+	}
+	if w := do("/api/v1/finalize/"+p.Slug, `{"token": "`+p.Token+`"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("binary content declared as text: expected 422, got %d", w.Code)
+	}
+	p = presign(`{"size": 4}`)
+	if err := store.StoreContent(p.Slug, []byte("hi")); err != nil {
+		t.Fatalf("StoreContent: %v", err)
+	}
+	if w := do("/api/v1/finalize/"+p.Slug, `{"token": "`+p.Token+`"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("size mismatch: expected 422, got %d", w.Code)
+	}
+}
@@ -0,0 +1,68 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// Replace handles PUT /:slug, replacing a paste's content with the request
+// body, read like an upload to POST /. The previous content is kept as a
+// version, up to NCLIP_MAX_VERSIONS of them. It takes the owner's API key,
+// an admin key or the paste's manage token; anyone else gets the same 404
+// as for a missing paste.
+func (h *Handler) Replace(c *gin.Context) {
+	slug := c.Param("slug")
+	if !utils.IsValidSlug(slug) {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidSlug, "Invalid slug format")
+		return
+	}
+	paste, err := h.service.GetPaste(slug)
+	if err != nil || !h.access.CanEdit(c, paste) {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found or not editable")
+		return
+	}
+	content, _, contentType, err := h.readUploadContent(c)
+	if err != nil {
+		h.uploadError(c, err)
+		return
+	}
+
+	paste, err = h.service.ReplaceContent(slug, content, contentType)
+	switch {
+	case errors.Is(err, services.ErrLegalHold):
+		audit.Record(c, audit.ActionUpdate, slug, audit.ResultFailure, "legal hold")
+		apierror.JSON(c, http.StatusConflict, apierror.CodeLegalHold, "Paste is under legal hold")
+		return
+	case errors.Is(err, services.ErrBurnReplace):
+		apierror.JSON(c, http.StatusConflict, apierror.CodeConflict, err.Error())
+		return
+	case err != nil:
+		log.Printf("[ERROR] Replace: failed to replace content of %s: %v", slug, err)
+		audit.Record(c, audit.ActionUpdate, slug, audit.ResultFailure, err.Error())
+		apierror.JSON(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to replace paste content")
+		return
+	}
+	audit.Record(c, audit.ActionUpdate, slug, audit.ResultSuccess, fmt.Sprintf("content version=%d", paste.Version))
+
+	pasteURL := h.generatePasteURL(c, slug)
+	c.Header("X-Paste-Version", fmt.Sprintf("%d", paste.Version))
+	if h.isCli(c) || c.Request.Header.Get("Accept") == "text/plain" {
+		c.String(http.StatusOK, pasteURL+"\n")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"url":      pasteURL,
+		"slug":     slug,
+		"version":  paste.Version,
+		"versions": len(paste.Versions),
+		"size":     paste.Size,
+	})
+}
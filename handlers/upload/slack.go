@@ -0,0 +1,105 @@
+package upload
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/internal/slashcmd"
+)
+
+// maxSlashCommandForm bounds the form fields around the command text.
+const maxSlashCommandForm = 16 * 1024
+
+// SetSlashCommands enables POST /integrations/slack for workspaces.
+func (h *Handler) SetSlashCommands(workspaces map[string]slashcmd.Workspace) {
+	h.workspaces = workspaces
+}
+
+// slashResponse is the reply shown to the user who ran the command. Both
+// Slack and Mattermost accept this format.
+type slashResponse struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+func ephemeral(c *gin.Context, text string) {
+	c.JSON(http.StatusOK, slashResponse{ResponseType: "ephemeral", Text: text})
+}
+
+// SlashCommand handles POST /integrations/slack: a Slack or Mattermost
+// slash command that creates a paste from the command text and replies
+// with its URL, visible only to the user. Slack requests are verified by
+// their signature, Mattermost requests by their command token.
+func (h *Handler) SlashCommand(c *gin.Context) {
+	if len(h.workspaces) == 0 {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Resource not found")
+		return
+	}
+	body, truncated, err := h.readLimitedContent(c.Request.Body, h.config.BufferSize+maxSlashCommandForm)
+	if err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "Failed to read request")
+		return
+	}
+	if truncated {
+		apierror.JSON(c, http.StatusRequestEntityTooLarge, apierror.CodePayloadTooLarge, "Command too large")
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeBadRequest, "Invalid form body")
+		return
+	}
+
+	ws, ok := h.workspaces[form.Get("team_id")]
+	if !ok {
+		apierror.JSON(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unknown workspace")
+		return
+	}
+	if sig := c.GetHeader("X-Slack-Signature"); sig != "" {
+		err = slashcmd.VerifySlack(ws.Secret, c.GetHeader("X-Slack-Request-Timestamp"), sig, body, time.Now())
+	} else {
+		token := form.Get("token")
+		if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Token ") {
+			token = strings.TrimPrefix(auth, "Token ")
+		}
+		err = slashcmd.VerifyToken(ws.Secret, token)
+	}
+	if err != nil {
+		apierror.JSON(c, http.StatusUnauthorized, apierror.CodeUnauthorized, err.Error())
+		return
+	}
+	if ws.APIKey != "" {
+		audit.SetActor(c, audit.KeyID(ws.APIKey))
+	} else {
+		audit.SetActor(c, "slack:"+ws.TeamID)
+	}
+
+	content := slashcmd.Content(form.Get("text"))
+	if content == "" {
+		ephemeral(c, "Usage: "+form.Get("command")+" <text>, or a snippet wrapped in ```")
+		return
+	}
+	if int64(len(content)) > h.config.BufferSize {
+		ephemeral(c, "That paste is too large.")
+		return
+	}
+	resp, err := h.service.CreatePaste(services.CreatePasteRequest{
+		Content: []byte(content),
+		TTL:     h.defaultTTL(),
+	})
+	if err != nil {
+		log.Printf("[ERROR] SlashCommand: failed to create paste for team %s: %v", ws.TeamID, err)
+		audit.Record(c, audit.ActionCreate, "", audit.ResultFailure, err.Error())
+		ephemeral(c, "Failed to create paste, please try again.")
+		return
+	}
+	audit.Record(c, audit.ActionCreate, resp.Slug, audit.ResultSuccess, "slash_command team="+ws.TeamID)
+	ephemeral(c, "Paste created: "+h.generatePasteURL(c, resp.Slug))
+}
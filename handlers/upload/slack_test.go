@@ -0,0 +1,88 @@
+package upload
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/internal/slashcmd"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func TestSlashCommand(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	cfg := &config.Config{BufferSize: 1024, DefaultTTL: 24 * time.Hour}
+	handler := NewHandler(services.NewPasteService(store, cfg), cfg)
+	workspaces, _ := slashcmd.ParseWorkspaces("TSLACK:signing-secret,TMATTER:command-token")
+	handler.SetSlashCommands(workspaces)
+	router := gin.New()
+	router.POST("/integrations/slack", handler.SlashCommand)
+
+	do := func(form url.Values, header http.Header) (int, slashResponse) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/integrations/slack", strings.NewReader(form.Encode()))
+		req.Header = header
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		router.ServeHTTP(w, req)
+		var resp slashResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	slackHeader := func(form url.Values, secret string) http.Header {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte("v0:" + ts + ":" + form.Encode()))
+		return http.Header{
+			"X-Slack-Request-Timestamp": {ts},
+			"X-Slack-Signature":         {"v0=" + hex.EncodeToString(mac.Sum(nil))},
+		}
+	}
+
+	// Signed Slack command with a snippet.
+	form := url.Values{"team_id": {"TSLACK"}, "command": {"/paste"}, "text": {"```\necho hi\n```"}}
+	code, resp := do(form, slackHeader(form, "signing-secret"))
+	if code != http.StatusOK || resp.ResponseType != "ephemeral" || !strings.HasPrefix(resp.Text, "Paste created: http://example.com/") {
+		t.Fatalf("slack: unexpected response %d %+v", code, resp)
+	}
+	slug := strings.TrimPrefix(resp.Text, "Paste created: http://example.com/")
+	if content, err := store.GetContent(slug); err != nil || string(content) != "echo hi" {
+		t.Errorf("expected snippet content, got %q (%v)", content, err)
+	}
+
+	// Wrong signing secret, unknown team, wrong token.
+	if code, _ := do(form, slackHeader(form, "other")); code != http.StatusUnauthorized {
+		t.Errorf("bad signature: expected 401, got %d", code)
+	}
+	unknown := url.Values{"team_id": {"TOTHER"}, "text": {"hi"}}
+	if code, _ := do(unknown, slackHeader(unknown, "signing-secret")); code != http.StatusUnauthorized {
+		t.Errorf("unknown team: expected 401, got %d", code)
+	}
+	if code, _ := do(url.Values{"team_id": {"TMATTER"}, "token": {"nope"}, "text": {"hi"}}, http.Header{}); code != http.StatusUnauthorized {
+		t.Errorf("bad token: expected 401, got %d", code)
+	}
+
+	// Mattermost command token, in the form or the Authorization header.
+	code, resp = do(url.Values{"team_id": {"TMATTER"}, "token": {"command-token"}, "text": {"from mattermost"}}, http.Header{})
+	if code != http.StatusOK || !strings.HasPrefix(resp.Text, "Paste created: ") {
+		t.Errorf("mattermost: unexpected response %d %+v", code, resp)
+	}
+	code, resp = do(url.Values{"team_id": {"TMATTER"}, "command": {"/paste"}}, http.Header{"Authorization": {"Token command-token"}})
+	if code != http.StatusOK || !strings.HasPrefix(resp.Text, "Usage: /paste") {
+		t.Errorf("empty text: expected usage, got %d %+v", code, resp)
+	}
+}
@@ -0,0 +1,34 @@
+package upload
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// LookupSlug handles GET /api/v1/slugs/:name, telling the API key that
+// uploaded a paste with the custom slug name which slug it is stored
+// under. With NCLIP_CUSTOM_SLUG_MODE=obfuscate that slug cannot be worked
+// out from the name; other callers get a 404 whether or not the paste
+// exists, so the endpoint does not reveal which names are taken.
+func (h *Handler) LookupSlug(c *gin.Context) {
+	name := c.Param("name")
+	c.Header("Cache-Control", "no-store")
+	if !utils.IsValidSlug(name) {
+		apierror.JSON(c, http.StatusBadRequest, apierror.CodeInvalidSlug, "Invalid slug format")
+		return
+	}
+	slug := h.storedSlug(name)
+	paste, err := h.service.GetPaste(slug)
+	if err != nil || !h.access.CanEdit(c, paste) {
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Paste not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"name": name,
+		"slug": slug,
+		"url":  h.generatePasteURL(c, slug),
+	})
+}
@@ -0,0 +1,74 @@
+package upload
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/internal/hmackeys"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/internal/slugmask"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func TestObfuscatedCustomSlugs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	cfg := &config.Config{BufferSize: 1024 * 1024, DefaultTTL: 24 * time.Hour}
+	h := NewHandler(services.NewPasteService(store, cfg), cfg)
+	scopes := apikeys.Scopes{apikeys.ScopeRead, apikeys.ScopeWrite}
+	h.SetAccess(access.NewChecker(apikeys.Keys{"alice": scopes, "bob": scopes}, hmackeys.New("secret", nil)))
+	mask := slugmask.NewHMAC("0123456789abcdef")
+	h.SetSlugTransformer(mask)
+
+	router := gin.New()
+	router.POST("/", h.Upload)
+	router.GET("/api/v1/exists/:slug", h.Exists)
+	router.GET("/api/v1/slugs/:name", h.LookupSlug)
+	do := func(method, target, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader("hello"))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("X-Slug", "BATCH2345")
+		req.Header.Set("X-Api-Key", key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	slug := mask.Transform("BATCH2345")
+	if w := do("POST", "/", "alice"); w.Code != 200 || !strings.Contains(w.Body.String(), slug) {
+		t.Fatalf("expected the paste under %s, got %d: %s", slug, w.Code, w.Body.String())
+	}
+	if _, err := store.Get("BATCH2345"); err == nil {
+		t.Errorf("expected no paste under the custom slug itself")
+	}
+	if w := do("POST", "/", "bob"); w.Code == 200 {
+		t.Errorf("expected the custom slug to stay taken, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("GET", "/api/v1/exists/BATCH2345", ""); w.Code != 204 {
+		t.Errorf("expected the custom slug to exist, got %d", w.Code)
+	}
+
+	w := do("GET", "/api/v1/slugs/BATCH2345", "alice")
+	var got struct{ Name, Slug, URL string }
+	if err := json.Unmarshal(w.Body.Bytes(), &got); w.Code != 200 || err != nil || got.Slug != slug || !strings.HasSuffix(got.URL, "/"+slug) {
+		t.Fatalf("expected alice to find %s, got %d: %s", slug, w.Code, w.Body.String())
+	}
+	for _, target := range []string{"/api/v1/slugs/BATCH2345", "/api/v1/slugs/BATCH2346"} {
+		if w := do("GET", target, "bob"); w.Code != 404 {
+			t.Errorf("%s: expected 404 for another key, got %d", target, w.Code)
+		}
+	}
+	if w := do("GET", "/api/v1/slugs/a", "alice"); w.Code != 400 {
+		t.Errorf("expected 400 for an invalid name, got %d", w.Code)
+	}
+}
@@ -7,6 +7,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/forwarded"
+	"github.com/johnwmail/nclip/internal/session"
 )
 
 // WebUIHandler handles web interface
@@ -34,7 +36,7 @@ func (h *WebUIHandler) Index(c *gin.Context) {
 		if h.isHTTPS(c) {
 			scheme = "https"
 		}
-		baseURL = fmt.Sprintf("%s://%s", scheme, c.Request.Host)
+		baseURL = fmt.Sprintf("%s://%s%s%s", scheme, c.Request.Host, forwarded.PathPrefix(c), h.config.RoutePrefix)
 	}
 
 	// If request is from CLI tool, return plain text usage examples
@@ -43,21 +45,39 @@ func (h *WebUIHandler) Index(c *gin.Context) {
 		return
 	}
 
-	// Pass version info and upload-auth flag to template
+	// Pass version info, upload-auth flags and the session CSRF token to
+	// the template. Rendering the page issues the session cookie.
 	c.HTML(http.StatusOK, "index.html", struct {
-		Title      string
-		Config     struct{ URL string }
-		Version    string
-		BuildTime  string
-		CommitHash string
-		UploadAuth bool
+		Title          string
+		Config         struct{ URL string }
+		Version        string
+		BuildTime      string
+		CommitHash     string
+		UploadAuth     bool
+		SessionUploads bool
+		CSRFToken      string
 	}{
-		Title:      "NCLIP - HTTP Clipboard",
-		Config:     struct{ URL string }{URL: baseURL},
-		Version:    h.config.Version,
-		BuildTime:  h.config.BuildTime,
-		CommitHash: h.config.CommitHash,
-		UploadAuth: h.config.UploadAuth,
+		Title:          "NCLIP - HTTP Clipboard",
+		Config:         struct{ URL string }{URL: baseURL},
+		Version:        h.config.Version,
+		BuildTime:      h.config.BuildTime,
+		CommitHash:     h.config.CommitHash,
+		UploadAuth:     h.config.UploadAuth,
+		SessionUploads: h.config.SessionUploads,
+		CSRFToken:      session.CSRFToken(c),
+	})
+}
+
+// Admin handles GET /admin, the admin console. The page holds no data:
+// its script asks for an API key and calls the admin API with it, which
+// decides what the key may see and do.
+func (h *WebUIHandler) Admin(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.HTML(http.StatusOK, "admin.html", gin.H{
+		"Title":      "NCLIP - Admin",
+		"Version":    h.config.Version,
+		"BuildTime":  h.config.BuildTime,
+		"CommitHash": h.config.CommitHash,
 	})
 }
 
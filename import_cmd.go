@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/importer"
+	"github.com/johnwmail/nclip/internal/keyring"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+	"github.com/johnwmail/nclip/utils"
+)
+
+const importUsage = `Usage: nclip import --from gist|pastebin|hastebin [flags] [document...]
+
+Recreate a user's pastes from another pastebin service in this instance's
+store, keeping their filenames, languages and creation dates; the latter
+two are recorded in the paste's origin, shown by /api/v1/meta/{slug}.
+Visibility is kept where nclip has an equivalent: GitHub secret gists
+become unlisted, as do hastebin documents. Private pastes need --owner,
+the API key that may read them, and are skipped without it. Pastes that
+were imported before are skipped, so an import can be run again.
+
+  gist      the public gists of --user, or all gists of the --token's user
+  pastebin  the pastes of the user; --token is "devkey:userkey"
+  hastebin  the documents named as arguments, by key or URL
+
+Imported pastes expire after --ttl (NCLIP_TTL) unless pinned with --pin.
+The token can also be set in NCLIP_IMPORT_TOKEN. Each paste imported is
+printed with its URL under NCLIP_URL, or its slug when that is unset; the
+exit status is 1 when any failed.
+
+`
+
+// runImportCommand implements the "nclip import" subcommand and returns
+// the process exit code.
+func runImportCommand(args []string, stdout, stderr io.Writer, getenv func(string) string) int {
+	fs := flag.NewFlagSet("nclip import", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprint(stderr, importUsage)
+		fs.PrintDefaults()
+	}
+	from := fs.String("from", "", "Service to import from: "+strings.Join(importer.Services, ", "))
+	user := fs.String("user", "", "GitHub user whose public gists to import")
+	token := fs.String("token", getenv("NCLIP_IMPORT_TOKEN"), "Token for the service (NCLIP_IMPORT_TOKEN)")
+	apiURL := fs.String("api-url", "", "Base URL of the service, for self-hosted instances")
+	pin := fs.Bool("pin", false, "Pin the imported pastes, so they never expire")
+	owner := fs.String("owner", "", "API key owning the imported pastes; required for private pastes")
+	tags := fs.String("tags", "", "Comma-separated tags added to the imported pastes")
+	dryRun := fs.Bool("dry-run", false, "List the pastes that would be imported without importing them")
+	cfg, _, err := config.Load(fs, args, getenv)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+		return 1
+	}
+	tagList, err := utils.ParseTags(*tags)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: --tags: %v\n", err)
+		return 1
+	}
+	ownerID := ""
+	if *owner != "" {
+		keys, err := apikeys.Load(cfg.APIKeys, cfg.APIKeysFile)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+			return 1
+		}
+		if scopes, ok := keys.Lookup(*owner); !ok || !scopes.Has(apikeys.ScopeWrite) {
+			_, _ = fmt.Fprintln(stderr, "nclip: --owner is not an API key with the write scope")
+			return 1
+		}
+		ownerID = audit.KeyID(*owner)
+	}
+	source, err := importer.New(*from, importer.Options{BaseURL: *apiURL, Token: *token, User: *user, Keys: fs.Args()})
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+		return 1
+	}
+
+	backend, name, err := openBackendStore(cfg)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+		return 1
+	}
+	defer func() { _ = backend.Close() }()
+	// New slugs must be free in every tenant's prefix too.
+	var store storage.PasteStore = storage.NewTenantStore(backend)
+	if cfg.EncryptionKeys != "" {
+		keys, err := keyring.Parse(cfg.EncryptionKeys)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+			return 1
+		}
+		store = storage.NewEncryptedStore(store, keys)
+	}
+	imported, err := importedOrigins(store)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %s: %v\n", name, err)
+		return 1
+	}
+	items, err := source.List()
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+		return 1
+	}
+
+	service := services.NewPasteService(store, cfg)
+	var created, skipped, failed int
+	for _, item := range items {
+		what := item.Origin.Service + " " + item.Origin.ID
+		if imported[originKey(item.Origin)] {
+			skipped++
+			continue
+		}
+		if item.Visibility == models.VisibilityPrivate && ownerID == "" {
+			_, _ = fmt.Fprintf(stderr, "%s: skipped, private pastes need --owner\n", what)
+			skipped++
+			continue
+		}
+		if *dryRun {
+			_, _ = fmt.Fprintf(stdout, "%s\t%s\t%s\n", what, item.Visibility, item.Filename)
+			continue
+		}
+		content, err := source.Content(item, cfg.BufferSize)
+		if err == nil && len(content) == 0 {
+			err = errors.New("paste is empty")
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "%s: %v\n", what, err)
+			failed++
+			continue
+		}
+		origin := item.Origin
+		origin.ImportedAt = time.Now().UTC()
+		resp, err := service.CreatePaste(services.CreatePasteRequest{
+			Content:    content,
+			Filename:   utils.SanitizeFilename(item.Filename),
+			TTL:        cfg.DefaultTTL,
+			Tags:       tagList,
+			Visibility: item.Visibility,
+			Owner:      ownerID,
+			Origin:     &origin,
+		})
+		if err == nil && *pin {
+			err = pinPaste(store, resp.Slug)
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "%s: %v\n", what, err)
+			failed++
+			continue
+		}
+		imported[originKey(item.Origin)] = true
+		created++
+		link := resp.Slug
+		if cfg.URL != "" {
+			link = strings.TrimSuffix(cfg.URL, "/") + "/" + resp.Slug
+		}
+		_, _ = fmt.Fprintf(stdout, "%s\t%s\n", what, link)
+	}
+	_, _ = fmt.Fprintf(stderr, "%s: %d pastes found at %s, %d imported, %d skipped, %d failed\n",
+		name, len(items), source.Name(), created, skipped, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// originKey identifies an imported paste across imports.
+func originKey(o models.PasteOrigin) string {
+	return o.Service + "\x00" + o.ID
+}
+
+// importedOrigins returns the origins of the pastes in store that were
+// imported, by originKey. Stores that cannot list pastes are not checked.
+func importedOrigins(store storage.PasteStore) (map[string]bool, error) {
+	imported := map[string]bool{}
+	lister, ok := store.(storage.Lister)
+	if !ok {
+		log.Printf("[WARN] The store cannot list pastes; pastes imported before will be imported again")
+		return imported, nil
+	}
+	opts := storage.ListOptions{}
+	for {
+		page, err := lister.List(opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pastes: %w", err)
+		}
+		for id, res := range storage.GetBatch(store, page.IDs) {
+			if res.Err != nil && !errors.Is(res.Err, storage.ErrNotFound) {
+				return nil, fmt.Errorf("failed to read %s: %w", id, res.Err)
+			}
+			if res.Paste != nil && res.Paste.Origin != nil {
+				imported[originKey(*res.Paste.Origin)] = true
+			}
+		}
+		if page.NextCursor == "" {
+			return imported, nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// pinPaste pins the paste stored under slug.
+func pinPaste(store storage.PasteStore, slug string) error {
+	paste, err := store.Get(slug)
+	if err != nil {
+		return err
+	}
+	paste.Pinned = true
+	return store.Store(paste)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func TestImportCommand(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/documents/abc":
+			_, _ = fmt.Fprint(w, `{"key": "abc", "data": "print(1)\n"}`)
+		case "/documents/def":
+			_, _ = fmt.Fprint(w, `{"key": "def", "data": "hello"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	getenv := func(key string) string {
+		if key == "NCLIP_DATA_DIR" {
+			return dir
+		}
+		return ""
+	}
+	run := func(args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		args = append([]string{"--from", "hastebin", "--api-url", srv.URL}, args...)
+		code := runImportCommand(args, &stdout, &stderr, getenv)
+		return code, stdout.String(), stderr.String()
+	}
+
+	code, out, errOut := run("--pin", "--tags", "old", "abc.py", "def", "gone")
+	if code != 1 || !strings.Contains(errOut, "2 imported, 0 skipped, 1 failed") {
+		t.Fatalf("import exited %d: %s%s", code, out, errOut)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "hastebin abc\t") {
+		t.Fatalf("unexpected output %q", out)
+	}
+	slug := lines[0][strings.LastIndex(lines[0], "\t")+1:]
+
+	store, err := storage.NewFilesystemStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paste, err := store.Get(slug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paste.Origin == nil || paste.Origin.Language != "py" || paste.Origin.ImportedAt.IsZero() {
+		t.Errorf("origin = %+v", paste.Origin)
+	}
+	if !paste.Pinned || paste.Visibility != models.VisibilityUnlisted || len(paste.Tags) != 1 {
+		t.Errorf("paste = %+v, want pinned, unlisted and tagged", paste)
+	}
+
+	// Pastes imported before are skipped.
+	code, out, errOut = run("abc.py", "def")
+	if code != 0 || out != "" || !strings.Contains(errOut, "0 imported, 2 skipped") {
+		t.Fatalf("second import exited %d: %s%s", code, out, errOut)
+	}
+}
@@ -0,0 +1,235 @@
+// Package access decides who may read private pastes: the API key that
+// uploaded them, or anyone holding a share link signed for the paste. It
+// also issues the manage tokens that let an uploader change or delete a
+// paste without an API key. When the keys file puts keys in tenants, it
+// confines admin keys to the pastes of their own tenant.
+package access
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/hmackeys"
+	"github.com/johnwmail/nclip/models"
+)
+
+// Errors returned by VerifyShare.
+var (
+	ErrInvalidShare = errors.New("invalid share link")
+	ErrShareExpired = errors.New("share link has expired")
+)
+
+// Bounds for the validity period of a share link.
+const (
+	DefaultShareValidity = 24 * time.Hour
+	MaxShareValidity     = 30 * 24 * time.Hour
+)
+
+// ShareParam is the query parameter carrying a share token.
+const ShareParam = "share"
+
+// scopesKey is the Gin context key holding the authenticated key's scopes.
+const scopesKey = "nclip.scopes"
+
+// Checker checks API keys and share tokens against private pastes. A nil
+// Checker accepts neither, so private pastes are unreadable.
+type Checker struct {
+	keys    apikeys.Source
+	tenancy *apikeys.Tenancy
+	signing *hmackeys.Ring
+	now     func() time.Time
+}
+
+// NewChecker creates a Checker for keys, signing share links and manage
+// tokens with signing.
+func NewChecker(keys apikeys.Source, signing *hmackeys.Ring) *Checker {
+	return &Checker{keys: keys, signing: signing, now: time.Now}
+}
+
+// APIKey returns the key sent in "Authorization: Bearer <key>" or
+// X-Api-Key, or "" when there is none.
+func APIKey(c *gin.Context) string {
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(strings.ToLower(auth), "bearer ") {
+		if key := strings.TrimSpace(auth[7:]); key != "" {
+			return key
+		}
+	}
+	return strings.TrimSpace(c.GetHeader("X-Api-Key"))
+}
+
+// Owner returns the audit key ID of the valid API key sent with the
+// request, or "" when the request carries no valid key.
+func (a *Checker) Owner(c *gin.Context) string {
+	key := APIKey(c)
+	if a == nil || key == "" {
+		return ""
+	}
+	if _, ok := a.keys.Lookup(key); !ok {
+		return ""
+	}
+	return audit.KeyID(key)
+}
+
+// SetTenancy confines admin keys to the pastes of their tenant in
+// tenancy.
+func (a *Checker) SetTenancy(tenancy *apikeys.Tenancy) {
+	a.tenancy = tenancy
+}
+
+// Tenancy returns the tenants set with SetTenancy, or nil.
+func (a *Checker) Tenancy() *apikeys.Tenancy {
+	if a == nil {
+		return nil
+	}
+	return a.tenancy
+}
+
+// Tenant returns the tenant of the valid API key sent with the request,
+// or "" for the default tenant.
+func (a *Checker) Tenant(c *gin.Context) string {
+	if a == nil || a.Owner(c) == "" {
+		return ""
+	}
+	return a.tenancy.Tenant(APIKey(c))
+}
+
+// SuperAdmin reports whether the request carries a superadmin key, or an
+// admin key while no tenants are configured, so that the key may manage
+// the whole instance.
+func (a *Checker) SuperAdmin(c *gin.Context) bool {
+	if a == nil {
+		return false
+	}
+	scopes, ok := a.keys.Lookup(APIKey(c))
+	if !a.tenancy.Enabled() {
+		return ok && scopes.Has(apikeys.ScopeAdmin)
+	}
+	return ok && scopes.Has(apikeys.ScopeSuperAdmin)
+}
+
+// InTenant reports whether the request may manage paste as an admin: the
+// paste belongs to the tenant of the request's key, the key is a
+// superadmin, or no tenants are configured.
+func (a *Checker) InTenant(c *gin.Context, paste *models.Paste) bool {
+	if a == nil || !a.tenancy.Enabled() {
+		return true
+	}
+	return a.SuperAdmin(c) || a.Tenant(c) == paste.Tenant
+}
+
+// SetScopes records the scopes of the API key that authenticated the
+// request, for handlers that restrict what a key may do.
+func SetScopes(c *gin.Context, scopes apikeys.Scopes) {
+	c.Set(scopesKey, scopes)
+}
+
+// Scopes returns the scopes recorded by SetScopes, reporting false when
+// the request was not authenticated with an API key.
+func Scopes(c *gin.Context) (apikeys.Scopes, bool) {
+	v, ok := c.Get(scopesKey)
+	if !ok {
+		return nil, false
+	}
+	scopes, ok := v.(apikeys.Scopes)
+	return scopes, ok
+}
+
+// CanRead reports whether the request may read paste. Public and unlisted
+// pastes are readable by anyone; private ones need the owner's API key or
+// a valid share token in the ShareParam query parameter. Quarantined
+// pastes are only readable with an admin key of their tenant.
+func (a *Checker) CanRead(c *gin.Context, paste *models.Paste) bool {
+	if paste.Quarantined {
+		return a.Admin(c, paste)
+	}
+	if !paste.IsPrivate() {
+		return true
+	}
+	if a == nil {
+		return false
+	}
+	if owner := a.Owner(c); owner != "" && owner == paste.Owner {
+		return true
+	}
+	token := c.Query(ShareParam)
+	return token != "" && a.VerifyShare(paste.ID, token) == nil
+}
+
+// ManageParam is the query parameter carrying a manage token.
+const ManageParam = "token"
+
+// CanEdit reports whether the request may replace paste's content: it
+// carries the owner's API key, an admin key of the paste's tenant, or the
+// paste's manage token in the ManageParam query parameter.
+func (a *Checker) CanEdit(c *gin.Context, paste *models.Paste) bool {
+	if a == nil {
+		return false
+	}
+	if key := APIKey(c); key != "" {
+		scopes, ok := a.keys.Lookup(key)
+		admin := ok && scopes.Has(apikeys.ScopeAdmin) && a.InTenant(c, paste)
+		if admin || ok && paste.Owner != "" && audit.KeyID(key) == paste.Owner {
+			return true
+		}
+	}
+	return a.VerifyManage(paste, c.Query(ManageParam))
+}
+
+// Admin reports whether the request carries an admin key that may manage
+// paste.
+func (a *Checker) Admin(c *gin.Context, paste *models.Paste) bool {
+	if a == nil {
+		return false
+	}
+	scopes, ok := a.keys.Lookup(APIKey(c))
+	return ok && scopes.Has(apikeys.ScopeAdmin) && a.InTenant(c, paste)
+}
+
+// ShareToken returns a token granting read access to slug until expires.
+func (a *Checker) ShareToken(slug string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + a.signing.Sign("share:"+slug+":"+exp)
+}
+
+// VerifyShare checks a token issued by ShareToken for slug.
+func (a *Checker) VerifyShare(slug, token string) error {
+	exp, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrInvalidShare
+	}
+	if !a.signing.Verify("share:"+slug+":"+exp, sig) {
+		return ErrInvalidShare
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return ErrInvalidShare
+	}
+	if !a.now().Before(time.Unix(unix, 0)) {
+		return ErrShareExpired
+	}
+	return nil
+}
+
+// ManageToken returns the token that lets the uploader manage the paste
+// slug created at created. Binding it to the creation time keeps it from
+// carrying over to a later paste that reuses the slug.
+func (a *Checker) ManageToken(slug string, created time.Time) string {
+	return a.signing.Sign(manageMessage(slug, created))
+}
+
+// VerifyManage reports whether token is the manage token for paste.
+func (a *Checker) VerifyManage(paste *models.Paste, token string) bool {
+	return a != nil && token != "" && a.signing.Verify(manageMessage(paste.ID, paste.CreatedAt), token)
+}
+
+// manageMessage is the message a manage token signs. Messages start with
+// "share:" or "manage:", which keeps them apart from each other and from
+// the upload links and session cookies signed with the same keys.
+func manageMessage(slug string, created time.Time) string {
+	return "manage:" + slug + ":" + strconv.FormatInt(created.Unix(), 10)
+}
@@ -0,0 +1,208 @@
+package access
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/hmackeys"
+	"github.com/johnwmail/nclip/models"
+)
+
+func testContext(target string, headers map[string]string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", target, nil)
+	for k, v := range headers {
+		c.Request.Header.Set(k, v)
+	}
+	return c
+}
+
+func TestChecker_CanRead(t *testing.T) {
+	a := NewChecker(apikeys.Parse("alice, bob"), hmackeys.New("secret", nil))
+	private := &models.Paste{ID: "PRIVA", Visibility: models.VisibilityPrivate, Owner: audit.KeyID("alice")}
+	token := a.ShareToken("PRIVA", time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name    string
+		target  string
+		headers map[string]string
+		want    bool
+	}{
+		{name: "anonymous", target: "/PRIVA", want: false},
+		{name: "owner bearer", target: "/PRIVA", headers: map[string]string{"Authorization": "Bearer alice"}, want: true},
+		{name: "owner header", target: "/PRIVA", headers: map[string]string{"X-Api-Key": "alice"}, want: true},
+		{name: "other key", target: "/PRIVA", headers: map[string]string{"X-Api-Key": "bob"}, want: false},
+		{name: "unknown key", target: "/PRIVA", headers: map[string]string{"X-Api-Key": "mallory"}, want: false},
+		{name: "share link", target: "/PRIVA?share=" + token, want: true},
+		{name: "forged share link", target: "/PRIVA?share=" + token + "x", want: false},
+	}
+	for _, tt := range tests {
+		if got := a.CanRead(testContext(tt.target, tt.headers), private); got != tt.want {
+			t.Errorf("%s: CanRead = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	unlisted := &models.Paste{ID: "UNLST"}
+	if !a.CanRead(testContext("/UNLST", nil), unlisted) {
+		t.Error("expected unlisted paste to be readable anonymously")
+	}
+	var none *Checker
+	if none.CanRead(testContext("/PRIVA?share="+token, map[string]string{"X-Api-Key": "alice"}), private) {
+		t.Error("expected a nil Checker to deny private pastes")
+	}
+}
+
+func TestChecker_VerifyShare(t *testing.T) {
+	a := NewChecker(apikeys.Parse("alice"), hmackeys.New("secret", nil))
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	token := a.ShareToken("SHARE", now.Add(time.Hour))
+
+	if err := a.VerifyShare("SHARE", token); err != nil {
+		t.Fatalf("VerifyShare: %v", err)
+	}
+	if err := a.VerifyShare("OTHER", token); !errors.Is(err, ErrInvalidShare) {
+		t.Errorf("expected token for another slug to be rejected, got %v", err)
+	}
+	if err := NewChecker(apikeys.Parse("alice"), hmackeys.New("other", nil)).VerifyShare("SHARE", token); !errors.Is(err, ErrInvalidShare) {
+		t.Errorf("expected token signed with another secret to be rejected, got %v", err)
+	}
+	rotated := NewChecker(apikeys.Parse("alice"), hmackeys.New("new-secret", []string{"secret"}))
+	rotated.now = a.now
+	if err := rotated.VerifyShare("SHARE", token); err != nil {
+		t.Errorf("expected a token of the previous secret to verify after rotation, got %v", err)
+	}
+	if manage := a.ManageToken("SHARE", now); !rotated.VerifyManage(&models.Paste{ID: "SHARE", CreatedAt: now}, manage) {
+		t.Error("expected a manage token of the previous secret to verify after rotation")
+	}
+	if err := a.VerifyShare("SHARE", "no-signature"); !errors.Is(err, ErrInvalidShare) {
+		t.Errorf("expected malformed token to be rejected, got %v", err)
+	}
+
+	a.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if err := a.VerifyShare("SHARE", token); !errors.Is(err, ErrShareExpired) {
+		t.Errorf("expected ErrShareExpired, got %v", err)
+	}
+}
+
+func TestChecker_ManageToken(t *testing.T) {
+	a := NewChecker(apikeys.Parse(""), hmackeys.New("secret", nil))
+	created := time.Now()
+	paste := &models.Paste{ID: "MANGE", CreatedAt: created}
+	token := a.ManageToken("MANGE", created)
+
+	if !a.VerifyManage(paste, token) {
+		t.Fatal("expected manage token to verify")
+	}
+	if a.VerifyManage(&models.Paste{ID: "MANGE", CreatedAt: created.Add(time.Hour)}, token) {
+		t.Error("expected token not to carry over to a new paste with the same slug")
+	}
+	if a.VerifyManage(&models.Paste{ID: "OTHER", CreatedAt: created}, token) {
+		t.Error("expected token for another slug to be rejected")
+	}
+	if a.VerifyShare("MANGE", token) == nil {
+		t.Error("expected a manage token not to work as a share link")
+	}
+	var none *Checker
+	if none.VerifyManage(paste, token) {
+		t.Error("expected a nil Checker to reject manage tokens")
+	}
+}
+
+func TestChecker_CanEdit(t *testing.T) {
+	keys, err := apikeys.ParseFile([]byte("alice write\nbob write\nroot admin\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewChecker(keys, hmackeys.New("secret", nil))
+	created := time.Now()
+	paste := &models.Paste{ID: "EDITS", CreatedAt: created, Owner: audit.KeyID("alice")}
+	token := a.ManageToken("EDITS", created)
+
+	tests := []struct {
+		name    string
+		target  string
+		headers map[string]string
+		want    bool
+	}{
+		{name: "anonymous", target: "/EDITS", want: false},
+		{name: "owner", target: "/EDITS", headers: map[string]string{"X-Api-Key": "alice"}, want: true},
+		{name: "other key", target: "/EDITS", headers: map[string]string{"X-Api-Key": "bob"}, want: false},
+		{name: "admin key", target: "/EDITS", headers: map[string]string{"Authorization": "Bearer root"}, want: true},
+		{name: "manage token", target: "/EDITS?token=" + token, want: true},
+		{name: "forged manage token", target: "/EDITS?token=" + token + "x", want: false},
+	}
+	for _, tt := range tests {
+		if got := a.CanEdit(testContext(tt.target, tt.headers), paste); got != tt.want {
+			t.Errorf("%s: CanEdit = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	anonymous := &models.Paste{ID: "EDITS", CreatedAt: created}
+	if a.CanEdit(testContext("/EDITS", map[string]string{"X-Api-Key": "bob"}), anonymous) {
+		t.Error("expected a key not to edit a paste uploaded without one")
+	}
+}
+
+func TestChecker_Tenants(t *testing.T) {
+	data := []byte("eng-ops admin tenant=eng\neng-ci write tenant=eng\nmkt-ops admin tenant=marketing\nroot superadmin\nlocal admin\n")
+	keys, err := apikeys.ParseFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tenancy, err := apikeys.LoadTenancy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewChecker(keys, hmackeys.New("secret", nil))
+	eng := &models.Paste{ID: "ENGPS", Tenant: "eng"}
+	withKey := func(key string) *gin.Context { return testContext("/ENGPS", map[string]string{"X-Api-Key": key}) }
+
+	// Without tenants every admin key manages every paste.
+	if !a.InTenant(withKey("mkt-ops"), eng) || !a.SuperAdmin(withKey("local")) {
+		t.Error("expected admin keys to manage the instance without tenants")
+	}
+
+	a.SetTenancy(tenancy)
+	for key, want := range map[string]bool{"eng-ops": true, "eng-ci": true, "mkt-ops": false, "root": true, "local": false, "": false} {
+		if got := a.InTenant(withKey(key), eng); got != want {
+			t.Errorf("InTenant(%q) = %v, want %v", key, got, want)
+		}
+	}
+	for key, want := range map[string]bool{"eng-ops": true, "mkt-ops": false, "root": true} {
+		if got := a.CanEdit(withKey(key), eng); got != want {
+			t.Errorf("CanEdit(%q) = %v, want %v", key, got, want)
+		}
+	}
+	if a.SuperAdmin(withKey("eng-ops")) || !a.SuperAdmin(withKey("root")) {
+		t.Error("expected only superadmin keys to manage the instance with tenants")
+	}
+	if got := a.Tenant(withKey("eng-ci")); got != "eng" {
+		t.Errorf("Tenant(eng-ci) = %q", got)
+	}
+	if got := a.Tenant(withKey("unknown")); got != "" {
+		t.Errorf("expected an unknown key to have no tenant, got %q", got)
+	}
+}
+
+func TestScopes(t *testing.T) {
+	c := testContext("/", nil)
+	if _, ok := Scopes(c); ok {
+		t.Fatal("expected no scopes before authentication")
+	}
+	SetScopes(c, apikeys.Scopes{apikeys.ScopeBurn})
+	scopes, ok := Scopes(c)
+	if !ok || !scopes.Has(apikeys.ScopeBurn) || scopes.Has(apikeys.ScopeWrite) {
+		t.Errorf("expected burn-only scopes, got %v %v", scopes, ok)
+	}
+}
@@ -0,0 +1,188 @@
+package apierror
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Code is a stable, machine-readable error identifier. The full catalog is
+// documented in Documents/ERROR-CODES.md; codes are never renamed once
+// published so clients can switch on them safely.
+type Code string
+
+const (
+	CodeBadRequest          Code = "bad_request"
+	CodeInvalidSlug         Code = "invalid_slug"
+	CodeInvalidTTL          Code = "invalid_ttl"
+	CodeInvalidTags         Code = "invalid_tags"
+	CodeInvalidVisibility   Code = "invalid_visibility"
+	CodeInvalidCollection   Code = "invalid_collection"
+	CodeInvalidNotify       Code = "invalid_notify"
+	CodeInvalidSource       Code = "invalid_source"
+	CodeInvalidMtime        Code = "invalid_mtime"
+	CodeInvalidBase64       Code = "invalid_base64"
+	CodeEmptyContent        Code = "empty_content"
+	CodeBinaryUnconfirmed   Code = "binary_unconfirmed"
+	CodeUnauthorized        Code = "unauthorized"
+	CodeMissingAPIKey       Code = "missing_api_key"
+	CodeInsufficientScope   Code = "insufficient_scope"
+	CodeCollectionForbidden Code = "collection_forbidden"
+	CodeCSRFInvalid         Code = "csrf_invalid"
+	CodeEmbedForbidden      Code = "embed_forbidden"
+	CodeSnippetForbidden    Code = "snippet_forbidden"
+	CodePoWRequired         Code = "pow_required"
+	CodePoWInvalid          Code = "pow_invalid"
+	CodeNotFound            Code = "not_found"
+	CodeSlugExists          Code = "slug_exists"
+	CodeSlugReserved        Code = "slug_reserved"
+	CodePayloadTooLarge     Code = "payload_too_large"
+	CodeRateLimited         Code = "rate_limited"
+	CodeReadOnlyReplica     Code = "read_only_replica"
+	CodeSizeMismatch        Code = "size_mismatch"
+	CodeContentCorrupt      Code = "content_corrupt"
+	CodeUnsupported         Code = "unsupported"
+	CodeLinkInvalid         Code = "upload_link_invalid"
+	CodeLinkExpired         Code = "upload_link_expired"
+	CodeLinkUsed            Code = "upload_link_used"
+	CodeTicketInvalid       Code = "upload_ticket_invalid"
+	CodeTicketExpired       Code = "upload_ticket_expired"
+	CodeUploadIncomplete    Code = "upload_incomplete"
+	CodeUploadMismatch      Code = "upload_mismatch"
+	CodeVerifyFailed        Code = "verify_failed"
+	CodeCursorExpired       Code = "sync_cursor_expired"
+	CodeLegalHold           Code = "legal_hold"
+	CodeCollectionFull      Code = "collection_full"
+	CodeTokenLimit          Code = "token_limit"
+	CodeSubscriptionLimit   Code = "push_subscription_limit"
+	CodeConflict            Code = "conflict"
+	CodeOverloaded          Code = "overloaded"
+	CodeReadOnly            Code = "read_only"
+	CodeTenantQuota         Code = "tenant_quota_exceeded"
+	CodeInternal            Code = "internal_error"
+)
+
+// Response is the JSON body of every error response.
+type Response struct {
+	Code      Code   `json:"code"`
+	Error     string `json:"error"`
+	Detail    string `json:"detail,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// RequestIDHeader is the header used to propagate request IDs.
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the gin context key holding the current request ID.
+const requestIDKey = "nclip.request_id"
+
+// maxRequestIDLen bounds client-supplied request IDs we are willing to echo.
+const maxRequestIDLen = 128
+
+// RequestID returns a middleware that assigns every request an ID, reusing
+// a well-formed incoming X-Request-ID header (for example one set by a load
+// balancer) and echoing it on the response.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// GetRequestID returns the request ID assigned by the RequestID middleware,
+// or an empty string when the middleware is not installed.
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// New builds an error Response for the current request.
+func New(c *gin.Context, code Code, message string) Response {
+	return Response{Code: code, Error: message, RequestID: GetRequestID(c)}
+}
+
+// JSON writes a typed JSON error response.
+func JSON(c *gin.Context, status int, code Code, message string) {
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.JSON(status, New(c, code, message))
+}
+
+// JSONDetail writes a typed JSON error response with additional detail.
+func JSONDetail(c *gin.Context, status int, code Code, message, detail string) {
+	resp := New(c, code, message)
+	resp.Detail = detail
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.JSON(status, resp)
+}
+
+// Abort writes a typed JSON error response and aborts the handler chain.
+func Abort(c *gin.Context, status int, code Code, message string) {
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.AbortWithStatusJSON(status, New(c, code, message))
+}
+
+// HTML renders the themable error.html template for browser clients. Extra
+// template data (version info, base URL, ...) is merged into the defaults.
+func HTML(c *gin.Context, status int, code Code, message string, data gin.H) {
+	values := gin.H{
+		"Title":     "NCLIP - " + http.StatusText(status),
+		"Status":    status,
+		"Code":      code,
+		"Error":     message,
+		"RequestID": GetRequestID(c),
+	}
+	for k, v := range data {
+		values[k] = v
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.HTML(status, "error.html", values)
+}
+
+// CodeForStatus returns the default code for an HTTP status, used when an
+// error response was produced without an explicit code.
+func CodeForStatus(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return CodeUnauthorized
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeSlugExists
+	case http.StatusRequestEntityTooLarge:
+		return CodePayloadTooLarge
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	}
+	if status >= 500 {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,72 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestJSON_IncludesCodeAndRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestID())
+	router.GET("/x", func(c *gin.Context) {
+		JSON(c, http.StatusNotFound, CodeNotFound, "Paste not found")
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/x", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+	var resp Response
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	if resp.Code != CodeNotFound || resp.Error != "Paste not found" || resp.RequestID != "abc-123" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if got := w.Header().Get(RequestIDHeader); got != "abc-123" {
+		t.Errorf("expected request id header echoed, got %q", got)
+	}
+}
+
+func TestRequestID_GeneratesWhenInvalid(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestID())
+	router.GET("/x", func(c *gin.Context) { c.String(http.StatusOK, GetRequestID(c)) })
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/x", nil)
+	req.Header.Set(RequestIDHeader, "bad id\twith spaces")
+	router.ServeHTTP(w, req)
+
+	id := w.Body.String()
+	if len(id) != 32 || strings.ContainsAny(id, " \t") {
+		t.Errorf("expected generated 32-char hex id, got %q", id)
+	}
+}
+
+func TestCodeForStatus(t *testing.T) {
+	cases := map[int]Code{
+		http.StatusBadRequest:            CodeBadRequest,
+		http.StatusUnauthorized:          CodeUnauthorized,
+		http.StatusNotFound:              CodeNotFound,
+		http.StatusRequestEntityTooLarge: CodePayloadTooLarge,
+		http.StatusTooManyRequests:       CodeRateLimited,
+		http.StatusBadGateway:            CodeInternal,
+	}
+	for status, want := range cases {
+		if got := CodeForStatus(status); got != want {
+			t.Errorf("CodeForStatus(%d) = %s, want %s", status, got, want)
+		}
+	}
+}
@@ -0,0 +1,454 @@
+// Package apikeys parses the API keys clients authenticate with and the
+// scopes each key is granted.
+//
+// Keys listed in NCLIP_API_KEYS have every scope, as they always had. The
+// keys file grants narrower ones, one key per line:
+//
+//	# CI only creates pastes
+//	ci-3f9a...      write
+//	# the dashboard lists pastes
+//	dash-81c2...    read
+//	ops-77d0...     admin
+//
+// A line may end with max_size=SIZE to give the key its own upload size
+// limit, and the name * sets the limit of uploads without an API key:
+//
+//	ci-3f9a...      write   max_size=50MB
+//	*               max_size=1MB
+//
+// A line may also put the key in a tenant with tenant=NAME, for
+// deployments shared by several teams. A line naming @NAME sets the
+// tenant's storage quota:
+//
+//	eng-ci-51d0...  write   tenant=eng
+//	eng-ops-9e2b... admin   tenant=eng
+//	@eng            quota=10GB
+//
+// Keys without a tenant belong to the default tenant. Admin keys only
+// manage the pastes of their own tenant; the superadmin scope manages
+// every tenant.
+//
+// Blank lines and lines starting with # are ignored.
+package apikeys
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sync"
+)
+
+// Scope is a permission granted to an API key.
+type Scope string
+
+const (
+	// ScopeRead lists pastes through the admin API.
+	ScopeRead Scope = "read"
+	// ScopeWrite creates pastes of any kind.
+	ScopeWrite Scope = "write"
+	// ScopeBurn creates burn-after-read pastes only.
+	ScopeBurn Scope = "burn"
+	// ScopeAdmin grants every scope, including the admin routes, within
+	// the key's tenant.
+	ScopeAdmin Scope = "admin"
+	// ScopeSuperAdmin grants every scope across all tenants, and the admin
+	// routes that concern the whole instance.
+	ScopeSuperAdmin Scope = "superadmin"
+)
+
+var knownScopes = map[Scope]bool{ScopeRead: true, ScopeWrite: true, ScopeBurn: true, ScopeAdmin: true, ScopeSuperAdmin: true}
+
+// Scopes is the set of scopes granted to a key.
+type Scopes []Scope
+
+// Has reports whether s grants scope. Superadmin grants every scope,
+// admin every scope but superadmin, and write grants burn, since a key
+// that may create any paste may create a burn-after-read one.
+func (s Scopes) Has(scope Scope) bool {
+	for _, granted := range s {
+		if granted == scope || granted == ScopeSuperAdmin ||
+			(granted == ScopeAdmin && scope != ScopeSuperAdmin) ||
+			(granted == ScopeWrite && scope == ScopeBurn) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAny reports whether s grants at least one of scopes.
+func (s Scopes) HasAny(scopes ...Scope) bool {
+	for _, scope := range scopes {
+		if s.Has(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the scopes comma-separated, as written in the keys file.
+func (s Scopes) String() string {
+	names := make([]string, len(s))
+	for i, scope := range s {
+		names[i] = string(scope)
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseScopes parses a comma-separated list of scopes.
+func ParseScopes(s string) (Scopes, error) {
+	var scopes Scopes
+	for _, name := range strings.Split(s, ",") {
+		scope := Scope(strings.ToLower(strings.TrimSpace(name)))
+		if !knownScopes[scope] {
+			return nil, fmt.Errorf("unknown scope %q: want read, write, burn, admin or superadmin", name)
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+// Keys maps each API key to its scopes.
+type Keys map[string]Scopes
+
+// Parse parses a comma-separated list of keys, each granted every scope.
+// They belong to the default tenant but, being superadmins, manage every
+// tenant.
+func Parse(s string) Keys {
+	keys := Keys{}
+	for _, k := range strings.Split(s, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys[k] = Scopes{ScopeSuperAdmin}
+		}
+	}
+	return keys
+}
+
+// ParseFile parses the contents of a keys file.
+func ParseFile(data []byte) (Keys, error) {
+	f, err := parseFile(data)
+	return f.keys, err
+}
+
+// keysFile is the contents of a keys file.
+type keysFile struct {
+	keys    Keys
+	limits  SizeLimits
+	tenancy *Tenancy
+}
+
+// parseFile parses the keys, size limits and tenants of a keys file.
+func parseFile(data []byte) (keysFile, error) {
+	f := keysFile{keys: Keys{}, limits: SizeLimits{}, tenancy: &Tenancy{keys: map[string]string{}, quotas: map[string]int64{}}}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if fields[0] == Anonymous {
+			if len(fields) != 2 {
+				return keysFile{}, fmt.Errorf("line %d: want * max_size=SIZE", n)
+			}
+			if _, dup := f.limits[Anonymous]; dup {
+				return keysFile{}, fmt.Errorf("line %d: * listed twice", n)
+			}
+			size, err := parseMaxSize(fields[1])
+			if err != nil {
+				return keysFile{}, fmt.Errorf("line %d: %w", n, err)
+			}
+			f.limits[Anonymous] = size
+			continue
+		}
+		if tenant, ok := strings.CutPrefix(fields[0], "@"); ok {
+			if err := f.tenancy.parseQuota(tenant, fields[1:]); err != nil {
+				return keysFile{}, fmt.Errorf("line %d: %w", n, err)
+			}
+			continue
+		}
+		if len(fields) < 2 {
+			return keysFile{}, fmt.Errorf("line %d: want KEY SCOPE[,SCOPE...] [max_size=SIZE] [tenant=NAME]", n)
+		}
+		scopes, err := ParseScopes(fields[1])
+		if err != nil {
+			return keysFile{}, fmt.Errorf("line %d: %w", n, err)
+		}
+		if _, dup := f.keys[fields[0]]; dup {
+			return keysFile{}, fmt.Errorf("line %d: key listed twice", n)
+		}
+		f.keys[fields[0]] = scopes
+		for _, field := range fields[2:] {
+			name, value, _ := strings.Cut(field, "=")
+			switch {
+			case name == "max_size" && f.limits[fields[0]] == 0:
+				size, err := parseMaxSize(field)
+				if err != nil {
+					return keysFile{}, fmt.Errorf("line %d: %w", n, err)
+				}
+				f.limits[fields[0]] = size
+			case name == "tenant" && f.tenancy.keys[fields[0]] == "":
+				if !validTenant(value) {
+					return keysFile{}, fmt.Errorf("line %d: invalid tenant %q: want 1-32 lowercase letters, digits or dashes", n, value)
+				}
+				f.tenancy.keys[fields[0]] = value
+			default:
+				return keysFile{}, fmt.Errorf("line %d: unexpected field %q: want max_size=SIZE or tenant=NAME", n, field)
+			}
+		}
+	}
+	return f, scanner.Err()
+}
+
+// Load returns the keys of the comma-separated list and, when path is
+// set, the keys file. A key may only be listed once across both.
+func Load(list, path string) (Keys, error) {
+	keys := Parse(list)
+	if path == "" {
+		return keys, nil
+	}
+	data, err := os.ReadFile(path) // #nosec G304 -- path is operator configuration
+	if err != nil {
+		return nil, err
+	}
+	fileKeys, err := ParseFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for k, scopes := range fileKeys {
+		if _, dup := keys[k]; dup {
+			return nil, fmt.Errorf("%s: a key is also listed in api_keys", path)
+		}
+		keys[k] = scopes
+	}
+	return keys, nil
+}
+
+// Lookup returns the scopes of key, comparing in constant time so the
+// response time does not reveal how much of a key matched.
+func (k Keys) Lookup(key string) (Scopes, bool) {
+	var (
+		found  Scopes
+		exists bool
+	)
+	for candidate, scopes := range k {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) == 1 {
+			found, exists = scopes, true
+		}
+	}
+	return found, exists
+}
+
+// Anonymous is the name the keys file gives uploads without an API key.
+const Anonymous = "*"
+
+// SizeLimits maps API keys, and Anonymous, to their upload size limits in
+// bytes. Keys without an entry use the global limit.
+type SizeLimits map[string]int64
+
+// LoadSizeLimits returns the upload size limits of the keys file at path,
+// or none when path is empty.
+func LoadSizeLimits(path string) (SizeLimits, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path) // #nosec G304 -- path is operator configuration
+	if err != nil {
+		return nil, err
+	}
+	f, err := parseFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f.limits, nil
+}
+
+// Limit returns the upload size limit of key, or of uploads without an
+// API key when key is "". Like Lookup it compares in constant time.
+func (l SizeLimits) Limit(key string) (int64, bool) {
+	if key == "" {
+		size, ok := l[Anonymous]
+		return size, ok
+	}
+	var (
+		found  int64
+		exists bool
+	)
+	for candidate, size := range l {
+		if candidate != Anonymous && subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) == 1 {
+			found, exists = size, true
+		}
+	}
+	return found, exists
+}
+
+// sizeUnits are the suffixes max_size accepts, in powers of 1024.
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+	{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+// parseMaxSize parses a max_size=SIZE field, where SIZE is a number of
+// bytes with an optional K, M or G suffix (KB/KiB alike, 1024-based).
+func parseMaxSize(field string) (int64, error) {
+	value, ok := strings.CutPrefix(field, "max_size=")
+	if !ok {
+		return 0, fmt.Errorf("unexpected field %q: want max_size=SIZE", field)
+	}
+	n, err := parseSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid max_size %q: want a positive size such as 1048576, 512KB or 50MB", value)
+	}
+	return n, nil
+}
+
+// parseSize parses a number of bytes with an optional K, M or G suffix
+// (KB/KiB alike, 1024-based), up to 1 TiB.
+func parseSize(value string) (int64, error) {
+	num, mult := strings.ToUpper(value), int64(1)
+	for _, u := range sizeUnits {
+		if rest, ok := strings.CutSuffix(num, u.suffix); ok {
+			num, mult = rest, u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n <= 0 || n > (1<<40)/mult {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * mult, nil
+}
+
+// tenantPattern is the form of tenant names.
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// validTenant reports whether name is a valid tenant name.
+func validTenant(name string) bool {
+	return tenantPattern.MatchString(name)
+}
+
+// Tenancy maps API keys to the tenants of the keys file and tenants to
+// their storage quotas. The default tenant is "". It is safe for
+// concurrent use, as a Registry updates it in place.
+type Tenancy struct {
+	mu     sync.RWMutex
+	keys   map[string]string
+	quotas map[string]int64
+}
+
+// set replaces the tenants of t with those of other.
+func (t *Tenancy) set(other *Tenancy) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.keys, t.quotas = other.keys, other.quotas
+}
+
+// LoadTenancy returns the tenants of the keys file at path, or a Tenancy
+// without tenants when path is empty.
+func LoadTenancy(path string) (*Tenancy, error) {
+	if path == "" {
+		return &Tenancy{}, nil
+	}
+	data, err := os.ReadFile(path) // #nosec G304 -- path is operator configuration
+	if err != nil {
+		return nil, err
+	}
+	f, err := parseFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f.tenancy, nil
+}
+
+// parseQuota parses the fields of an @tenant line.
+func (t *Tenancy) parseQuota(tenant string, fields []string) error {
+	if !validTenant(tenant) {
+		return fmt.Errorf("invalid tenant %q: want 1-32 lowercase letters, digits or dashes", tenant)
+	}
+	if len(fields) != 1 || !strings.HasPrefix(fields[0], "quota=") {
+		return fmt.Errorf("want @TENANT quota=SIZE")
+	}
+	if _, dup := t.quotas[tenant]; dup {
+		return fmt.Errorf("@%s listed twice", tenant)
+	}
+	value := strings.TrimPrefix(fields[0], "quota=")
+	size, err := parseSize(value)
+	if err != nil {
+		return fmt.Errorf("invalid quota %q: want a positive size such as 500MB or 10GB", value)
+	}
+	t.quotas[tenant] = size
+	return nil
+}
+
+// Enabled reports whether any key belongs to a tenant other than the
+// default one. Without tenants, admin keys manage every paste as before.
+func (t *Tenancy) Enabled() bool {
+	if t == nil {
+		return false
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.keys) > 0
+}
+
+// Tenant returns the tenant of key, "" for the default tenant. Like Lookup
+// it compares in constant time.
+func (t *Tenancy) Tenant(key string) string {
+	if t == nil {
+		return ""
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var found string
+	for candidate, tenant := range t.keys {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) == 1 {
+			found = tenant
+		}
+	}
+	return found
+}
+
+// Quota returns the storage quota of tenant in bytes, reporting false
+// when it has none.
+func (t *Tenancy) Quota(tenant string) (int64, bool) {
+	if t == nil {
+		return 0, false
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	size, ok := t.quotas[tenant]
+	return size, ok
+}
+
+// Names returns the tenants that have keys or a quota, sorted, with the
+// default tenant "" first.
+func (t *Tenancy) Names() []string {
+	seen := map[string]bool{"": true}
+	if t != nil {
+		t.mu.RLock()
+		defer t.mu.RUnlock()
+		for _, tenant := range t.keys {
+			seen[tenant] = true
+		}
+		for tenant := range t.quotas {
+			seen[tenant] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
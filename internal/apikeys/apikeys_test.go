@@ -0,0 +1,180 @@
+package apikeys
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScopes_Has(t *testing.T) {
+	cases := []struct {
+		scopes Scopes
+		scope  Scope
+		want   bool
+	}{
+		{Scopes{ScopeAdmin}, ScopeRead, true},
+		{Scopes{ScopeAdmin}, ScopeBurn, true},
+		{Scopes{ScopeWrite}, ScopeBurn, true},
+		{Scopes{ScopeWrite}, ScopeRead, false},
+		{Scopes{ScopeBurn}, ScopeWrite, false},
+		{Scopes{ScopeRead}, ScopeAdmin, false},
+		{Scopes{ScopeAdmin}, ScopeSuperAdmin, false},
+		{Scopes{ScopeSuperAdmin}, ScopeAdmin, true},
+		{Scopes{ScopeRead, ScopeWrite}, ScopeRead, true},
+		{nil, ScopeRead, false},
+	}
+	for _, tc := range cases {
+		if got := tc.scopes.Has(tc.scope); got != tc.want {
+			t.Errorf("%v.Has(%s) = %v, want %v", tc.scopes, tc.scope, got, tc.want)
+		}
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	keys, err := ParseFile([]byte("# comment\n\nci write\ndash  read,burn\nops ADMIN\n"))
+	if err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	if len(keys) != 3 || keys["ci"].String() != "write" || keys["dash"].String() != "read,burn" || keys["ops"].String() != "admin" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+
+	for name, tc := range map[string]struct {
+		data string
+		want string
+	}{
+		"no scope":      {"ci\n", "line 1"},
+		"unknown scope": {"ci write\nops root\n", `line 2: unknown scope "root"`},
+		"duplicate":     {"ci write\nci read\n", "line 2: key listed twice"},
+		"extra field":   {"ci write read\n", "line 1"},
+	} {
+		if _, err := ParseFile([]byte(tc.data)); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected error containing %q, got %v", name, tc.want, err)
+		}
+	}
+}
+
+func TestLoadSizeLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	data := "*  max_size=1MB\nci write max_size=50mb\nbig admin max_size=2GiB\ndash read\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	limits, err := LoadSizeLimits(path)
+	if err != nil {
+		t.Fatalf("LoadSizeLimits: %v", err)
+	}
+	for key, want := range map[string]int64{"": 1 << 20, "ci": 50 << 20, "big": 2 << 30} {
+		if got, ok := limits.Limit(key); !ok || got != want {
+			t.Errorf("Limit(%q) = %d, %v; want %d", key, got, ok, want)
+		}
+	}
+	for _, key := range []string{"dash", "*", "missing"} {
+		if _, ok := limits.Limit(key); ok {
+			t.Errorf("Limit(%q): expected no limit", key)
+		}
+	}
+	if keys, err := ParseFile([]byte(data)); err != nil || len(keys) != 3 {
+		t.Errorf("ParseFile: the * line must not be a key, got %v %v", keys, err)
+	}
+	if limits, err := LoadSizeLimits(""); err != nil || limits != nil {
+		t.Errorf("LoadSizeLimits without a file: %v %v", limits, err)
+	}
+
+	for name, tc := range map[string]struct {
+		data string
+		want string
+	}{
+		"bad size":      {"ci write max_size=lots\n", `line 1: invalid max_size "lots"`},
+		"zero size":     {"ci write max_size=0\n", "line 1: invalid max_size"},
+		"star scopes":   {"* write max_size=1MB\n", "line 1: want * max_size=SIZE"},
+		"star twice":    {"* max_size=1MB\n* max_size=2MB\n", "line 2: * listed twice"},
+		"unknown field": {"ci write size=1MB\n", `line 1: unexpected field "size=1MB"`},
+	} {
+		if _, err := ParseFile([]byte(tc.data)); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected error containing %q, got %v", name, tc.want, err)
+		}
+	}
+}
+
+func TestLoadTenancy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	data := "ci write tenant=eng max_size=5MB\nops admin tenant=eng\nmkt write tenant=marketing\nroot superadmin\n@eng quota=10GB\n@legal quota=1MB\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tenancy, err := LoadTenancy(path)
+	if err != nil {
+		t.Fatalf("LoadTenancy: %v", err)
+	}
+	if !tenancy.Enabled() {
+		t.Error("expected tenancy to be enabled")
+	}
+	for key, want := range map[string]string{"ci": "eng", "ops": "eng", "mkt": "marketing", "root": "", "missing": ""} {
+		if got := tenancy.Tenant(key); got != want {
+			t.Errorf("Tenant(%q) = %q, want %q", key, got, want)
+		}
+	}
+	if quota, ok := tenancy.Quota("eng"); !ok || quota != 10<<30 {
+		t.Errorf("Quota(eng) = %d, %v", quota, ok)
+	}
+	if _, ok := tenancy.Quota("marketing"); ok {
+		t.Error("expected marketing to have no quota")
+	}
+	if got := strings.Join(tenancy.Names(), ","); got != ",eng,legal,marketing" {
+		t.Errorf("Names() = %q", got)
+	}
+	if limits, err := LoadSizeLimits(path); err != nil || limits["ci"] != 5<<20 {
+		t.Errorf("expected max_size alongside tenant=, got %v %v", limits, err)
+	}
+	if keys, err := ParseFile([]byte(data)); err != nil || len(keys) != 4 {
+		t.Errorf("ParseFile: @ lines must not be keys, got %v %v", keys, err)
+	}
+	if tenancy, err := LoadTenancy(""); err != nil || tenancy.Enabled() {
+		t.Errorf("LoadTenancy without a file: %v %v", tenancy, err)
+	}
+
+	for name, tc := range map[string]struct {
+		data string
+		want string
+	}{
+		"bad tenant":   {"ci write tenant=Eng\n", `line 1: invalid tenant "Eng"`},
+		"tenant twice": {"ci write tenant=a tenant=b\n", `line 1: unexpected field "tenant=b"`},
+		"bad quota":    {"@eng quota=lots\n", `line 1: invalid quota "lots"`},
+		"quota field":  {"@eng max_size=1MB\n", "line 1: want @TENANT quota=SIZE"},
+		"quota twice":  {"@eng quota=1MB\n@eng quota=2MB\n", "line 2: @eng listed twice"},
+		"quota tenant": {"@ quota=1MB\n", `line 1: invalid tenant ""`},
+	} {
+		if _, err := ParseFile([]byte(tc.data)); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected error containing %q, got %v", name, tc.want, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("ci write\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := Load("legacy, other", path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if scopes, ok := keys.Lookup("legacy"); !ok || !scopes.Has(ScopeAdmin) || !scopes.Has(ScopeSuperAdmin) {
+		t.Errorf("expected legacy key with every scope, got %v %v", scopes, ok)
+	}
+	if scopes, ok := keys.Lookup("ci"); !ok || scopes.Has(ScopeRead) || !scopes.Has(ScopeWrite) {
+		t.Errorf("expected ci key with write only, got %v %v", scopes, ok)
+	}
+	if _, ok := keys.Lookup("missing"); ok {
+		t.Error("expected unknown key to be rejected")
+	}
+
+	if _, err := Load("ci", path); err == nil {
+		t.Error("expected an error for a key listed in both sources")
+	}
+	if _, err := Load("", filepath.Join(t.TempDir(), "absent")); err == nil {
+		t.Error("expected an error for a missing keys file")
+	}
+}
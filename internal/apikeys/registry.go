@@ -0,0 +1,397 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source looks up the scopes of API keys. Keys and Registry implement it.
+type Source interface {
+	Lookup(key string) (Scopes, bool)
+}
+
+// Limiter looks up upload size limits. SizeLimits and Registry implement
+// it.
+type Limiter interface {
+	Limit(key string) (int64, bool)
+}
+
+// Errors returned by the Registry methods that change the keys file.
+var (
+	// ErrNoKeysFile is returned when no keys file is configured.
+	ErrNoKeysFile = errors.New("no keys file is configured")
+	// ErrKeyNotFound is returned for keys that are not in the keys file,
+	// including those of NCLIP_API_KEYS, which cannot be changed.
+	ErrKeyNotFound = errors.New("key not found in the keys file")
+	// ErrInvalidEntry is returned for scopes, sizes or tenants the keys
+	// file does not accept.
+	ErrInvalidEntry = errors.New("invalid key entry")
+)
+
+// KeyPrefix starts the keys created by Registry.Create.
+const KeyPrefix = "nclip_"
+
+// Entry describes a key of a Registry.
+type Entry struct {
+	Key    string
+	Scopes Scopes
+	// MaxSize is the key's upload size limit, 0 for the global one.
+	MaxSize int64
+	Tenant  string
+	// InFile reports whether the key is in the keys file, and so may be
+	// changed through the Registry.
+	InFile bool
+}
+
+// Registry holds the keys of NCLIP_API_KEYS and the keys file while the
+// server runs. Changes made through it are written to the keys file, and
+// Watch picks up the changes made to the file by others, so keys can be
+// created and revoked without a restart.
+//
+// The Tenancy it returns is updated in place, so holders of it see the
+// current tenants. Whether tenants are enabled at all is decided once at
+// startup by the routes, so putting the first key in a tenant takes
+// effect on restart.
+type Registry struct {
+	list string
+	path string
+
+	// editMu serializes reading and rewriting the keys file.
+	editMu sync.Mutex
+
+	mu      sync.RWMutex
+	keys    Keys
+	inFile  map[string]bool
+	limits  SizeLimits
+	tenancy *Tenancy
+	modTime time.Time
+}
+
+// NewRegistry loads the keys of the comma-separated list and, when path
+// is set, the keys file, as Load does.
+func NewRegistry(list, path string) (*Registry, error) {
+	r := &Registry{list: list, path: path, tenancy: &Tenancy{}}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the keys file again.
+func (r *Registry) Reload() error {
+	r.editMu.Lock()
+	defer r.editMu.Unlock()
+	if r.path == "" {
+		r.apply(keysFile{keys: Keys{}, limits: SizeLimits{}, tenancy: &Tenancy{}}, time.Time{})
+		return nil
+	}
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(r.path) // #nosec G304 -- path is operator configuration
+	if err != nil {
+		return err
+	}
+	f, err := r.parse(data)
+	if err != nil {
+		return err
+	}
+	r.apply(f, info.ModTime())
+	return nil
+}
+
+// parse parses the contents of the keys file, which may not list a key of
+// r.list again.
+func (r *Registry) parse(data []byte) (keysFile, error) {
+	f, err := parseFile(data)
+	if err != nil {
+		return keysFile{}, fmt.Errorf("%s: %w", r.path, err)
+	}
+	for k := range Parse(r.list) {
+		if _, dup := f.keys[k]; dup {
+			return keysFile{}, fmt.Errorf("%s: a key is also listed in api_keys", r.path)
+		}
+	}
+	return f, nil
+}
+
+// apply makes the keys of f, and those of r.list, current.
+func (r *Registry) apply(f keysFile, modTime time.Time) {
+	keys := Parse(r.list)
+	inFile := make(map[string]bool, len(f.keys))
+	for k, scopes := range f.keys {
+		keys[k] = scopes
+		inFile[k] = true
+	}
+	r.mu.Lock()
+	r.keys, r.inFile, r.limits, r.modTime = keys, inFile, f.limits, modTime
+	r.mu.Unlock()
+	r.tenancy.set(f.tenancy)
+}
+
+// Lookup returns the scopes of key, like Keys.Lookup.
+func (r *Registry) Lookup(key string) (Scopes, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.keys.Lookup(key)
+}
+
+// Limit returns the upload size limit of key, like SizeLimits.Limit.
+func (r *Registry) Limit(key string) (int64, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.limits.Limit(key)
+}
+
+// Tenancy returns the tenants of the keys file.
+func (r *Registry) Tenancy() *Tenancy {
+	return r.tenancy
+}
+
+// Editable reports whether the registry has a keys file to change.
+func (r *Registry) Editable() bool {
+	return r.path != ""
+}
+
+// Entries returns every key, sorted by tenant and key.
+func (r *Registry) Entries() []Entry {
+	r.mu.RLock()
+	entries := make([]Entry, 0, len(r.keys))
+	for k, scopes := range r.keys {
+		entries = append(entries, Entry{Key: k, Scopes: scopes, MaxSize: r.limits[k], InFile: r.inFile[k]})
+	}
+	r.mu.RUnlock()
+	for i := range entries {
+		entries[i].Tenant = r.tenancy.Tenant(entries[i].Key)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Tenant != entries[j].Tenant {
+			return entries[i].Tenant < entries[j].Tenant
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
+
+// Create adds a new random key to the keys file and returns it.
+func (r *Registry) Create(scopes Scopes, maxSize int64, tenant string) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	e := Entry{Key: KeyPrefix + hex.EncodeToString(b), Scopes: scopes, MaxSize: maxSize, Tenant: tenant}
+	line, err := e.line()
+	if err != nil {
+		return "", err
+	}
+	err = r.edit(func(lines []string) ([]string, error) {
+		return append(lines, line), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return e.Key, nil
+}
+
+// Update replaces the scopes, size limit and tenant of the key e.Key.
+func (r *Registry) Update(e Entry) error {
+	line, err := e.line()
+	if err != nil {
+		return err
+	}
+	return r.edit(func(lines []string) ([]string, error) {
+		i := keyLine(lines, e.Key)
+		if i < 0 {
+			return nil, ErrKeyNotFound
+		}
+		lines[i] = line
+		return lines, nil
+	})
+}
+
+// Revoke removes key from the keys file.
+func (r *Registry) Revoke(key string) error {
+	return r.edit(func(lines []string) ([]string, error) {
+		i := keyLine(lines, key)
+		if i < 0 {
+			return nil, ErrKeyNotFound
+		}
+		return append(lines[:i], lines[i+1:]...), nil
+	})
+}
+
+// SetQuota sets the storage quota of tenant, removing it when quota is 0.
+func (r *Registry) SetQuota(tenant string, quota int64) error {
+	if !validTenant(tenant) {
+		return fmt.Errorf("%w: tenant must be 1-32 lowercase letters, digits or dashes", ErrInvalidEntry)
+	}
+	if quota < 0 || quota > 1<<40 {
+		return fmt.Errorf("%w: quota must be between 1 byte and 1TB", ErrInvalidEntry)
+	}
+	return r.edit(func(lines []string) ([]string, error) {
+		i := keyLine(lines, "@"+tenant)
+		switch {
+		case quota == 0 && i >= 0:
+			return append(lines[:i], lines[i+1:]...), nil
+		case quota == 0:
+			return lines, nil
+		case i >= 0:
+			lines[i] = "@" + tenant + " quota=" + strconv.FormatInt(quota, 10)
+			return lines, nil
+		}
+		return append(lines, "@"+tenant+" quota="+strconv.FormatInt(quota, 10)), nil
+	})
+}
+
+// line returns the keys file line of e, checking its fields.
+func (e Entry) line() (string, error) {
+	if len(e.Scopes) == 0 {
+		return "", fmt.Errorf("%w: at least one scope is required", ErrInvalidEntry)
+	}
+	for _, scope := range e.Scopes {
+		if !knownScopes[scope] {
+			return "", fmt.Errorf("%w: unknown scope %q", ErrInvalidEntry, scope)
+		}
+	}
+	if e.MaxSize < 0 || e.MaxSize > 1<<40 {
+		return "", fmt.Errorf("%w: max_size must be between 1 byte and 1TB", ErrInvalidEntry)
+	}
+	if e.Tenant != "" && !validTenant(e.Tenant) {
+		return "", fmt.Errorf("%w: tenant must be 1-32 lowercase letters, digits or dashes", ErrInvalidEntry)
+	}
+	line := e.Key + " " + e.Scopes.String()
+	if e.MaxSize > 0 {
+		line += " max_size=" + strconv.FormatInt(e.MaxSize, 10)
+	}
+	if e.Tenant != "" {
+		line += " tenant=" + e.Tenant
+	}
+	return line, nil
+}
+
+// keyLine returns the index of the line of lines listing name, a key or
+// @tenant, or -1.
+func keyLine(lines []string, name string) int {
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && !strings.HasPrefix(fields[0], "#") && fields[0] == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// edit rewrites the keys file with the lines fn returns, keeping comments
+// and the order of the other lines, and makes the result current. The
+// file is replaced through a temporary file, so readers never see half of
+// it.
+func (r *Registry) edit(fn func(lines []string) ([]string, error)) error {
+	if r.path == "" {
+		return ErrNoKeysFile
+	}
+	r.editMu.Lock()
+	defer r.editMu.Unlock()
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(r.path) // #nosec G304 -- path is operator configuration
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(data) == 0 {
+		lines = nil
+	}
+	if lines, err = fn(lines); err != nil {
+		return err
+	}
+	data = []byte(strings.Join(lines, "\n") + "\n")
+	f, err := r.parse(data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEntry, err)
+	}
+	if err := writeFile(r.path, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+	modTime := time.Now()
+	if info, err := os.Stat(r.path); err == nil {
+		modTime = info.ModTime()
+	}
+	r.apply(f, modTime)
+	return nil
+}
+
+// writeFile replaces the file at path with data through a temporary file
+// in the same directory.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if err := tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Watch reloads the keys file whenever its modification time changes,
+// checking every interval until stop is closed. A file that fails to
+// parse keeps the previous keys.
+func (r *Registry) Watch(interval time.Duration, stop <-chan struct{}) {
+	if r.path == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(r.path)
+			if err != nil {
+				continue
+			}
+			r.mu.RLock()
+			changed := !info.ModTime().Equal(r.modTime)
+			r.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				log.Printf("[ERROR] API keys reload failed, keeping the previous keys: %v", err)
+				// Do not retry the same broken file on every tick.
+				r.mu.Lock()
+				r.modTime = info.ModTime()
+				r.mu.Unlock()
+				continue
+			}
+			log.Printf("API keys reloaded from %s", r.path)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package apikeys
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Edit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("# CI\nci write max_size=1MB\n\nops admin tenant=eng\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRegistry("root", path)
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	if scopes, ok := r.Lookup("root"); !ok || scopes.String() != "superadmin" {
+		t.Fatalf("expected the listed key, got %v %v", scopes, ok)
+	}
+
+	key, err := r.Create(Scopes{ScopeRead}, 0, "eng")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if !strings.HasPrefix(key, KeyPrefix) {
+		t.Errorf("expected a key starting with %s, got %s", KeyPrefix, key)
+	}
+	if scopes, ok := r.Lookup(key); !ok || scopes.String() != "read" || r.Tenancy().Tenant(key) != "eng" {
+		t.Errorf("expected the new key to be usable at once, got %v %v", scopes, ok)
+	}
+
+	if err := r.Update(Entry{Key: "ci", Scopes: Scopes{ScopeWrite, ScopeBurn}}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if _, ok := r.Limit("ci"); ok {
+		t.Error("expected the size limit to be gone after the update")
+	}
+	if err := r.SetQuota("eng", 1<<20); err != nil {
+		t.Fatalf("SetQuota: %v", err)
+	}
+	if quota, ok := r.Tenancy().Quota("eng"); !ok || quota != 1<<20 {
+		t.Errorf("expected the quota, got %d %v", quota, ok)
+	}
+	if err := r.Revoke(key); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, ok := r.Lookup(key); ok {
+		t.Error("expected the revoked key to be refused")
+	}
+
+	data, _ := os.ReadFile(path)
+	if want := "# CI\nci write,burn\n\nops admin tenant=eng\n@eng quota=1048576\n"; string(data) != want {
+		t.Errorf("expected the keys file %q, got %q", want, data)
+	}
+
+	entries := r.Entries()
+	if len(entries) != 3 || entries[0].Key != "ci" || entries[0].InFile != true || entries[1].Key != "root" || entries[1].InFile {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+}
+
+func TestRegistry_Errors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("ci write\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRegistry("root", path)
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	cases := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"unknown key", r.Revoke("nope"), ErrKeyNotFound},
+		{"listed key", r.Revoke("root"), ErrKeyNotFound},
+		{"no scopes", r.Update(Entry{Key: "ci"}), ErrInvalidEntry},
+		{"bad tenant", r.Update(Entry{Key: "ci", Scopes: Scopes{ScopeRead}, Tenant: "Bad Name"}), ErrInvalidEntry},
+		{"bad quota", r.SetQuota("eng", -1), ErrInvalidEntry},
+	}
+	for _, tc := range cases {
+		if !errors.Is(tc.err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, tc.err)
+		}
+	}
+
+	r, err = NewRegistry("root", "")
+	if err != nil {
+		t.Fatalf("NewRegistry without a file: %v", err)
+	}
+	if _, err := r.Create(Scopes{ScopeRead}, 0, ""); !errors.Is(err, ErrNoKeysFile) {
+		t.Errorf("expected ErrNoKeysFile, got %v", err)
+	}
+}
+
+func TestRegistry_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("ci write\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRegistry("", path)
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("ci read\nops admin\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if scopes, _ := r.Lookup("ci"); scopes.String() != "read" {
+		t.Errorf("expected the changed scopes, got %v", scopes)
+	}
+	if _, ok := r.Lookup("ops"); !ok {
+		t.Error("expected the added key")
+	}
+
+	// A broken file keeps the previous keys.
+	if err := os.WriteFile(path, []byte("ci bogus\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Error("expected an error for an unknown scope")
+	}
+	if _, ok := r.Lookup("ops"); !ok {
+		t.Error("expected the previous keys to be kept")
+	}
+}
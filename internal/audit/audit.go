@@ -0,0 +1,214 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionCreate           = "create"
+	ActionDelete           = "delete"
+	ActionUpdate           = "update"
+	ActionBurn             = "burn"
+	ActionShare            = "share"
+	ActionExport           = "export"
+	ActionTokenCreate      = "token.create"
+	ActionTokenRevoke      = "token.revoke"
+	ActionCollectionCreate = "collection.create"
+	ActionCollectionUpdate = "collection.update"
+	ActionCollectionDelete = "collection.delete"
+	ActionAdminList        = "admin.list"
+	ActionAdminDeleteTag   = "admin.delete_by_tag"
+	ActionAdminAudit       = "admin.audit_query"
+	ActionUploadLink       = "admin.upload_link"
+	ActionPin              = "admin.pin"
+	ActionUnpin            = "admin.unpin"
+	ActionHold             = "admin.hold"
+	ActionRelease          = "admin.release"
+	ActionQuarantine       = "admin.quarantine"
+	ActionUnquarantine     = "admin.unquarantine"
+	ActionKeyCreate        = "admin.key_create"
+	ActionKeyUpdate        = "admin.key_update"
+	ActionKeyRevoke        = "admin.key_revoke"
+	ActionQuota            = "admin.quota"
+	ActionReencrypt        = "admin.reencrypt"
+	ActionOrphanSweep      = "admin.orphan_sweep"
+	ActionSettings         = "admin.settings"
+)
+
+// Results recorded in the audit log.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Entry is a single audit record, written as one JSON line.
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	Slug       string    `json:"slug,omitempty"`
+	ActorKeyID string    `json:"actor_key_id,omitempty"`
+	ActorIP    string    `json:"actor_ip,omitempty"`
+	Result     string    `json:"result"`
+	Detail     string    `json:"detail,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+}
+
+// Filter selects entries returned by Recent. Empty fields match anything.
+type Filter struct {
+	Action string
+	Slug   string
+}
+
+// Match reports whether e satisfies the filter.
+func (f Filter) Match(e Entry) bool {
+	return (f.Action == "" || e.Action == f.Action) && (f.Slug == "" || e.Slug == f.Slug)
+}
+
+// Sink persists audit entries. Implementations must be safe for concurrent
+// use and must never modify or drop entries once written.
+type Sink interface {
+	Append(e Entry) error
+	// Recent returns up to limit matching entries, newest first.
+	Recent(limit int, f Filter) ([]Entry, error)
+	Close() error
+}
+
+// Logger records audit entries to a Sink. A nil *Logger is valid and
+// discards everything, so callers never need to check whether auditing is
+// enabled.
+type Logger struct {
+	sink Sink
+	now  func() time.Time
+}
+
+// New creates a Logger writing to sink.
+func New(sink Sink) *Logger {
+	return &Logger{sink: sink, now: time.Now}
+}
+
+// Open creates a Logger for dest, which is either a filesystem path or an
+// s3://bucket/prefix URL. An empty dest disables auditing and returns nil.
+func Open(dest string, maxSize int64, maxBackups int) (*Logger, error) {
+	switch {
+	case dest == "":
+		return nil, nil
+	case strings.HasPrefix(dest, "s3://"):
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(dest, "s3://"), "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("audit: invalid S3 destination %q", dest)
+		}
+		sink, err := NewS3Sink(bucket, prefix)
+		if err != nil {
+			return nil, err
+		}
+		return New(sink), nil
+	default:
+		sink, err := NewFileSink(dest, maxSize, maxBackups)
+		if err != nil {
+			return nil, err
+		}
+		return New(sink), nil
+	}
+}
+
+// Log records e, filling in the timestamp. Failures are logged, never
+// returned: auditing must not break the operation being audited.
+func (l *Logger) Log(e Entry) {
+	if l == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = l.now().UTC()
+	}
+	if err := l.sink.Append(e); err != nil {
+		log.Printf("[ERROR] audit: failed to record %s %s: %v", e.Action, e.Slug, err)
+	}
+}
+
+// Recent returns up to limit entries matching f, newest first.
+func (l *Logger) Recent(limit int, f Filter) ([]Entry, error) {
+	if l == nil {
+		return nil, nil
+	}
+	return l.sink.Recent(limit, f)
+}
+
+// Close closes the underlying sink.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.sink.Close()
+}
+
+// gin context keys.
+const (
+	loggerKey = "nclip.audit.logger"
+	actorKey  = "nclip.audit.actor"
+)
+
+// Middleware makes l available to handlers through Record.
+func (l *Logger) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l != nil {
+			c.Set(loggerKey, l)
+		}
+		c.Next()
+	}
+}
+
+// FromContext returns the Logger installed by Middleware, or nil.
+func FromContext(c *gin.Context) *Logger {
+	if v, ok := c.Get(loggerKey); ok {
+		if l, ok := v.(*Logger); ok {
+			return l
+		}
+	}
+	return nil
+}
+
+// SetActor records the authenticated identity for the current request. It
+// is called by the authentication middleware with a KeyID or "session".
+func SetActor(c *gin.Context, id string) {
+	c.Set(actorKey, id)
+}
+
+// Actor returns the identity SetActor recorded for the current request,
+// or "" for anonymous requests.
+func Actor(c *gin.Context) string {
+	return c.GetString(actorKey)
+}
+
+// KeyID derives a stable, non-reversible identifier for an API key so the
+// audit log can attribute actions without storing the key itself.
+func KeyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:6])
+}
+
+// Record logs an action for the current request, attributing it to the
+// authenticated actor and client IP.
+func Record(c *gin.Context, action, slug, result, detail string) {
+	l := FromContext(c)
+	if l == nil {
+		return
+	}
+	l.Log(Entry{
+		Action:     action,
+		Slug:       slug,
+		ActorKeyID: Actor(c),
+		ActorIP:    c.ClientIP(),
+		Result:     result,
+		Detail:     detail,
+		RequestID:  apierror.GetRequestID(c),
+	})
+}
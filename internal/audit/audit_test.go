@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNilLogger(t *testing.T) {
+	var l *Logger
+	l.Log(Entry{Action: ActionCreate})
+	if entries, err := l.Recent(10, Filter{}); err != nil || entries != nil {
+		t.Errorf("expected nil logger to return nothing, got %v, %v", entries, err)
+	}
+	if err := l.Close(); err != nil {
+		t.Errorf("expected nil logger Close to succeed, got %v", err)
+	}
+}
+
+func TestOpen_Disabled(t *testing.T) {
+	l, err := Open("", 0, 0)
+	if err != nil || l != nil {
+		t.Errorf("expected empty destination to disable auditing, got %v, %v", l, err)
+	}
+	if _, err := Open("s3://", 0, 0); err == nil {
+		t.Error("expected error for S3 destination without a bucket")
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	id := KeyID("secret")
+	if id == KeyID("other") || id != KeyID("secret") {
+		t.Error("expected KeyID to be stable and distinct per key")
+	}
+	if len(id) != len("key:")+12 {
+		t.Errorf("unexpected KeyID %q", id)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l, err := Open(filepath.Join(t.TempDir(), "audit.log"), 0, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer func() { _ = l.Close() }()
+
+	router := gin.New()
+	router.Use(l.Middleware())
+	router.DELETE("/:slug", func(c *gin.Context) {
+		SetActor(c, KeyID("secret"))
+		Record(c, ActionDelete, c.Param("slug"), ResultSuccess, "")
+		c.Status(http.StatusNoContent)
+	})
+	req := httptest.NewRequest("DELETE", "/ABCDE", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries, err := l.Recent(10, Filter{})
+	if err != nil {
+		t.Fatalf("Recent failed: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Action != ActionDelete || e.Slug != "ABCDE" || e.Result != ResultSuccess {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e.ActorKeyID != KeyID("secret") || e.ActorIP != "192.0.2.1" || e.Time.IsZero() {
+		t.Errorf("expected actor, IP and time to be recorded, got %+v", e)
+	}
+}
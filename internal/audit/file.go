@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileSink appends JSON lines to a file, rotating it to path.1 ... path.N
+// once it grows beyond maxSize bytes.
+type FileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// NewFileSink opens (or creates) the audit file at path. A maxSize of 0
+// disables rotation; maxBackups is the number of rotated files kept.
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, fmt.Errorf("audit: failed to create log directory: %w", err)
+	}
+	s := &FileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600) // #nosec G304 -- operator-configured path
+	if err != nil {
+		return fmt.Errorf("audit: failed to open %s: %w", s.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("audit: failed to stat %s: %w", s.path, err)
+	}
+	s.f, s.size = f, info.Size()
+	return nil
+}
+
+// Append implements Sink.
+func (s *FileSink) Append(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.f.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// rotate shifts path.N-1 -> path.N ... path -> path.1 and reopens path.
+// The oldest backup beyond maxBackups is removed. Callers must hold s.mu.
+func (s *FileSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	if s.maxBackups <= 0 {
+		_ = os.Remove(s.path)
+	} else {
+		_ = os.Remove(s.backup(s.maxBackups))
+		for i := s.maxBackups - 1; i >= 1; i-- {
+			_ = os.Rename(s.backup(i), s.backup(i+1))
+		}
+		if err := os.Rename(s.path, s.backup(1)); err != nil {
+			return fmt.Errorf("audit: failed to rotate %s: %w", s.path, err)
+		}
+	}
+	return s.open()
+}
+
+func (s *FileSink) backup(i int) string {
+	return fmt.Sprintf("%s.%d", s.path, i)
+}
+
+// Recent implements Sink by scanning the current file, then the backups
+// from newest to oldest.
+func (s *FileSink) Recent(limit int, f Filter) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Entry
+	for i := 0; i <= s.maxBackups && len(out) < limit; i++ {
+		path := s.path
+		if i > 0 {
+			path = s.backup(i)
+		}
+		data, err := os.ReadFile(path) // #nosec G304 -- operator-configured path
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var entries []Entry
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		for sc.Scan() {
+			var e Entry
+			if json.Unmarshal(sc.Bytes(), &e) == nil && f.Match(e) {
+				entries = append(entries, e)
+			}
+		}
+		for j := len(entries) - 1; j >= 0 && len(out) < limit; j-- {
+			out = append(out, entries[j])
+		}
+	}
+	return out, nil
+}
+
+// Close implements Sink.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
@@ -0,0 +1,82 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSink_AppendAndRecent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileSink(path, 0, 0)
+	if err != nil {
+		t.Fatalf("NewFileSink failed: %v", err)
+	}
+	defer func() { _ = sink.Close() }()
+
+	for _, e := range []Entry{
+		{Action: ActionCreate, Slug: "AAAAA", Result: ResultSuccess},
+		{Action: ActionDelete, Slug: "AAAAA", Result: ResultSuccess},
+		{Action: ActionCreate, Slug: "BBBBB", Result: ResultSuccess},
+	} {
+		if err := sink.Append(e); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	all, err := sink.Recent(10, Filter{})
+	if err != nil {
+		t.Fatalf("Recent failed: %v", err)
+	}
+	if len(all) != 3 || all[0].Slug != "BBBBB" || all[2].Action != ActionCreate {
+		t.Errorf("expected newest-first entries, got %+v", all)
+	}
+
+	creates, _ := sink.Recent(10, Filter{Action: ActionCreate})
+	if len(creates) != 2 {
+		t.Errorf("expected 2 create entries, got %d", len(creates))
+	}
+	slugA, _ := sink.Recent(1, Filter{Slug: "AAAAA"})
+	if len(slugA) != 1 || slugA[0].Action != ActionDelete {
+		t.Errorf("expected latest AAAAA entry to be delete, got %+v", slugA)
+	}
+}
+
+func TestFileSink_Rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	// Small enough that every entry triggers a rotation.
+	sink, err := NewFileSink(path, 10, 2)
+	if err != nil {
+		t.Fatalf("NewFileSink failed: %v", err)
+	}
+	defer func() { _ = sink.Close() }()
+
+	for _, slug := range []string{"AAAAA", "BBBBB", "CCCCC", "DDDDD"} {
+		if err := sink.Append(Entry{Action: ActionCreate, Slug: slug}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("expected %s to exist: %v", p, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected at most 2 backups, found %s.3", path)
+	}
+
+	entries, err := sink.Recent(10, Filter{})
+	if err != nil {
+		t.Fatalf("Recent failed: %v", err)
+	}
+	want := []string{"DDDDD", "CCCCC", "BBBBB"}
+	if len(entries) != len(want) {
+		t.Fatalf("expected %d entries across rotated files, got %+v", len(want), entries)
+	}
+	for i, slug := range want {
+		if entries[i].Slug != slug {
+			t.Errorf("entry %d: expected %s, got %s", i, slug, entries[i].Slug)
+		}
+	}
+}
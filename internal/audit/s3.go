@@ -0,0 +1,150 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// s3RecentDays bounds how far back Recent looks for entries.
+	s3RecentDays = 7
+	// s3RecentMaxScan bounds the number of objects Recent reads per query.
+	s3RecentMaxScan = 1000
+)
+
+// S3Sink stores each entry as its own object under
+// <prefix>/YYYY/MM/DD/<timestamp>-<random>.json. S3 has no append, so one
+// object per entry keeps the log append-only and safe across concurrent
+// Lambda instances; keys sort chronologically.
+type S3Sink struct {
+	bucket string
+	prefix string
+	client *s3.Client
+	now    func() time.Time
+}
+
+// NewS3Sink creates an S3Sink writing under prefix in bucket.
+func NewS3Sink(bucket, prefix string) (*S3Sink, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("audit: failed to load AWS config: %w", err)
+	}
+	return &S3Sink{
+		bucket: bucket,
+		prefix: strings.Trim(prefix, "/"),
+		client: s3.NewFromConfig(cfg),
+		now:    time.Now,
+	}, nil
+}
+
+func (s *S3Sink) dayPrefix(t time.Time) string {
+	day := t.UTC().Format("2006/01/02") + "/"
+	if s.prefix == "" {
+		return day
+	}
+	return s.prefix + "/" + day
+}
+
+// Append implements Sink.
+func (s *S3Sink) Append(e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	var suffix [4]byte
+	_, _ = rand.Read(suffix[:])
+	key := s.dayPrefix(e.Time) + e.Time.UTC().Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix[:]) + ".json"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// Recent implements Sink. It walks back day by day over the last
+// s3RecentDays days, reading newest objects first.
+func (s *S3Sink) Recent(limit int, f Filter) ([]Entry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var out []Entry
+	scanned := 0
+	now := s.now()
+	for d := 0; d < s3RecentDays && len(out) < limit && scanned < s3RecentMaxScan; d++ {
+		keys, err := s.listDay(ctx, s.dayPrefix(now.AddDate(0, 0, -d)))
+		if err != nil {
+			return nil, err
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+		for _, key := range keys {
+			if len(out) >= limit || scanned >= s3RecentMaxScan {
+				break
+			}
+			scanned++
+			e, err := s.get(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			if f.Match(e) {
+				out = append(out, e)
+			}
+		}
+	}
+	return out, nil
+}
+
+func (s *S3Sink) listDay(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	in := &s3.ListObjectsV2Input{Bucket: aws.String(s.bucket), Prefix: aws.String(prefix)}
+	for {
+		out, err := s.client.ListObjectsV2(ctx, in)
+		if err != nil {
+			return nil, fmt.Errorf("audit: failed to list %s: %w", prefix, err)
+		}
+		for _, obj := range out.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			return keys, nil
+		}
+		in.ContinuationToken = out.NextContinuationToken
+	}
+}
+
+func (s *S3Sink) get(ctx context.Context, key string) (Entry, error) {
+	obj, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	if err != nil {
+		return Entry{}, fmt.Errorf("audit: failed to read %s: %w", key, err)
+	}
+	defer func() { _ = obj.Body.Close() }()
+	data, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return Entry{}, err
+	}
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return Entry{}, fmt.Errorf("audit: malformed entry %s: %w", key, err)
+	}
+	return e, nil
+}
+
+// Close implements Sink.
+func (s *S3Sink) Close() error {
+	return nil
+}
@@ -0,0 +1,283 @@
+// Package burnnotify tells uploaders that their burn-after-read paste was
+// read. An upload names a target in the X-Notify-On-Burn header: an https
+// webhook URL, which is sent an Event as JSON, or an email address, which
+// is sent a short message through SES. The message carries the time of
+// the read and coarse information about the reader, never its full
+// address.
+package burnnotify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/egress"
+	"github.com/johnwmail/nclip/internal/emailin"
+	"github.com/johnwmail/nclip/models"
+)
+
+const (
+	// EventBurned is the event of a webhook notification.
+	EventBurned = "paste.burned"
+	// maxTarget bounds the length of a target.
+	maxTarget = 512
+	// queueSize bounds the notifications waiting to be sent; more are
+	// dropped rather than slowing down reads.
+	queueSize = 256
+	// sendTimeout bounds one delivery.
+	sendTimeout = 15 * time.Second
+)
+
+// ErrEmailDisabled is returned by ParseTarget for email addresses when
+// the server has no sender identity for notification emails.
+var ErrEmailDisabled = errors.New("email notifications are not enabled on this server; use an https webhook URL")
+
+// ParseTarget validates an X-Notify-On-Burn value and returns the target
+// to store with the paste: an https URL as given, or an email address as
+// a mailto: URL. email reports whether email targets are accepted.
+func ParseTarget(s string, email bool) (string, error) {
+	s = strings.TrimSpace(s)
+	if len(s) > maxTarget {
+		return "", fmt.Errorf("X-Notify-On-Burn must be at most %d characters", maxTarget)
+	}
+	if strings.HasPrefix(s, "https://") {
+		u, err := url.Parse(s)
+		if err != nil || u.Host == "" || u.User != nil {
+			return "", errors.New("X-Notify-On-Burn is not a valid https URL")
+		}
+		return u.String(), nil
+	}
+	addr, err := mail.ParseAddress(strings.TrimPrefix(s, "mailto:"))
+	if err != nil || addr.Name != "" {
+		return "", errors.New("X-Notify-On-Burn must be an https webhook URL or an email address")
+	}
+	if !email {
+		return "", ErrEmailDisabled
+	}
+	return "mailto:" + addr.Address, nil
+}
+
+// Reader describes who read a paste, coarsely enough that notifications
+// do not disclose the reader's address.
+type Reader struct {
+	// Via is the protocol the paste was read with: http, tcp or gopher.
+	// It is empty when an interrupted transfer timed out and the paste
+	// was burned without knowing whether the reader got it.
+	Via string `json:"via,omitempty"`
+	// Network is the reader's /24 (IPv4) or /48 (IPv6) network.
+	Network string `json:"network,omitempty"`
+	// Client names the reader's browser or tool, such as Firefox or curl.
+	Client string `json:"client,omitempty"`
+}
+
+// NewReader returns the Reader of a read via protocol from ip with
+// userAgent, either of which may be empty.
+func NewReader(via, ip, userAgent string) Reader {
+	return Reader{Via: via, Network: network(ip), Client: clientName(userAgent)}
+}
+
+// network returns the /24 or /48 network of ip, or "" if it is not an IP.
+func network(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// browsers maps User-Agent tokens to browser names, most specific first:
+// Edge and Opera also claim to be Chrome, and Chrome to be Safari.
+var browsers = []struct{ token, name string }{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Firefox/", "Firefox"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+}
+
+// clientName returns the browser or product name of userAgent. Link
+// preview bots matter most here, since they may be what burned a paste.
+func clientName(userAgent string) string {
+	ua := strings.TrimSpace(userAgent)
+	if _, rest, ok := strings.Cut(ua, "compatible; "); ok {
+		// Bots such as "Mozilla/5.0 (compatible; Discordbot/2.0; ...)".
+		ua = rest
+	} else {
+		for _, b := range browsers {
+			if strings.Contains(ua, b.token) {
+				return b.name
+			}
+		}
+	}
+	name, _, _ := strings.Cut(ua, "/")
+	name, _, _ = strings.Cut(name, " ")
+	name = strings.TrimRight(name, ";)")
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	return name
+}
+
+// Event is the JSON body posted to a webhook.
+type Event struct {
+	Event     string    `json:"event"`
+	Slug      string    `json:"slug"`
+	Filename  string    `json:"filename,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	BurnedAt  time.Time `json:"burned_at"`
+	Reader    Reader    `json:"reader"`
+}
+
+// delivery is one notification waiting to be sent.
+type delivery struct {
+	target string
+	event  Event
+}
+
+// Notifier sends burn notifications. It is safe for concurrent use.
+type Notifier struct {
+	client *http.Client
+	// mailer sends email notifications; nil disables them.
+	mailer  emailin.Replier
+	now     func() time.Time
+	queue   chan delivery
+	started atomic.Bool
+}
+
+// NewNotifier creates a Notifier that sends emails with mailer, which
+// may be nil to accept webhooks only.
+func NewNotifier(mailer emailin.Replier) *Notifier {
+	return &Notifier{
+		client: &http.Client{Transport: egress.PublicTransport("webhook"), Timeout: sendTimeout},
+		mailer: mailer,
+		now:    time.Now,
+		queue:  make(chan delivery, queueSize),
+	}
+}
+
+// Email reports whether email targets are accepted.
+func (n *Notifier) Email() bool {
+	return n.mailer != nil
+}
+
+// Start sends notifications in the background. Until it is called, as in
+// Lambda, which freezes between invocations, Burned sends them itself
+// before returning.
+func (n *Notifier) Start() {
+	n.started.Store(true)
+	go func() {
+		for d := range n.queue {
+			n.deliver(d)
+		}
+	}()
+}
+
+// Burned notifies the target paste was uploaded with, if any, that reader
+// read it and it was burned.
+func (n *Notifier) Burned(paste *models.Paste, reader Reader) {
+	if paste == nil || paste.NotifyOnBurn == "" {
+		return
+	}
+	d := delivery{target: paste.NotifyOnBurn, event: Event{
+		Event:     EventBurned,
+		Slug:      paste.ID,
+		Filename:  paste.Filename,
+		CreatedAt: paste.CreatedAt.UTC(),
+		BurnedAt:  n.now().UTC().Truncate(time.Second),
+		Reader:    reader,
+	}}
+	if !n.started.Load() {
+		n.deliver(d)
+		return
+	}
+	select {
+	case n.queue <- d:
+	default:
+		log.Printf("[WARN] Burn notify: queue full, dropped notification for %s", paste.ID)
+	}
+}
+
+// deliver sends d, logging failures.
+func (n *Notifier) deliver(d delivery) {
+	var err error
+	if to, ok := strings.CutPrefix(d.target, "mailto:"); ok {
+		err = n.sendEmail(to, d.event)
+	} else {
+		err = n.post(d.target, d.event)
+	}
+	if err != nil {
+		log.Printf("[WARN] Burn notify: failed to notify the uploader of %s: %v", d.event.Slug, err)
+	}
+}
+
+// post sends event to the webhook url.
+func (n *Notifier) post(url string, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "nclip-burn-notify")
+	req.Header.Set("X-Nclip-Event", event.Event)
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// sendEmail sends event to the address to.
+func (n *Notifier) sendEmail(to string, event Event) error {
+	if n.mailer == nil {
+		return errors.New("email notifications are not enabled")
+	}
+	return n.mailer.Reply(to, "Your paste "+event.Slug+" was read", emailBody(event))
+}
+
+// emailBody is the text of a notification email.
+func emailBody(event Event) string {
+	var b strings.Builder
+	name := event.Slug
+	if event.Filename != "" {
+		name += " (" + event.Filename + ")"
+	}
+	fmt.Fprintf(&b, "Your burn-after-read paste %s, created %s, was read and has been deleted.\n\n", name, event.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Read at: %s\n", event.BurnedAt.Format(time.RFC3339))
+	r := event.Reader
+	if r.Via == "" {
+		b.WriteString("Reader: unknown; the download was interrupted and the paste burned when its retry window closed\n")
+		return b.String()
+	}
+	fmt.Fprintf(&b, "Read via: %s\n", r.Via)
+	if r.Network != "" {
+		fmt.Fprintf(&b, "Reader network: %s\n", r.Network)
+	}
+	if r.Client != "" {
+		fmt.Fprintf(&b, "Reader client: %s\n", r.Client)
+	}
+	b.WriteString("\nIf you did not expect this read, treat the paste's content as disclosed.\n")
+	return b.String()
+}
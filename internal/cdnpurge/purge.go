@@ -0,0 +1,228 @@
+// Package cdnpurge invalidates the CDN's cached copies of deleted and
+// expired pastes, so they are not served after the paste is gone. A Purger
+// turns slugs into the paths of their URLs and sends them to one or more
+// targets, CloudFront or a webhook, in batches: at most one request per
+// target every interval, failed batches retried with backoff.
+package cdnpurge
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/johnwmail/nclip/utils"
+)
+
+const (
+	// SlugPlaceholder is replaced by the slug in path templates.
+	SlugPlaceholder = "{slug}"
+	// maxBatch bounds the paths sent to a target in one request; CloudFront
+	// allows 3000 paths in progress per distribution.
+	maxBatch = 1000
+	// maxPending bounds the paths waiting for a target; more are dropped
+	// rather than growing without bound while a target is down.
+	maxPending = 10000
+	// maxAttempts is how often a batch is sent before it is given up.
+	maxAttempts = 5
+	// maxBackoff bounds the wait before a failed batch is sent again.
+	maxBackoff = time.Hour
+	// sendTimeout bounds one request to a target.
+	sendTimeout = 30 * time.Second
+)
+
+// Target is a CDN, or anything else, that can be told to drop cached
+// copies of paths.
+type Target interface {
+	// Name identifies the target in logs.
+	Name() string
+	// Invalidate drops the cached copies of paths, which start with a
+	// slash.
+	Invalidate(ctx context.Context, paths []string) error
+}
+
+// queue holds the paths waiting for one target.
+type queue struct {
+	target  Target
+	pending []string
+	queued  map[string]bool
+	// batch is the batch being sent. After a failure it is kept and sent
+	// again at retryAt; attempts counts its failures.
+	batch    []string
+	attempts int
+	retryAt  time.Time
+}
+
+// Purger queues the paths of deleted and expired pastes and sends them to
+// its targets. It is safe for concurrent use.
+type Purger struct {
+	templates []string
+	interval  time.Duration
+	now       func() time.Time
+	started   atomic.Bool
+
+	mu     sync.Mutex
+	queues []*queue
+}
+
+// New creates a Purger that invalidates the paths templates give for a
+// slug, such as "/raw/{slug}", sending at most one batch to each target
+// every interval.
+func New(templates []string, interval time.Duration, targets ...Target) *Purger {
+	p := &Purger{templates: templates, interval: interval, now: time.Now}
+	for _, t := range targets {
+		p.queues = append(p.queues, &queue{target: t, queued: make(map[string]bool)})
+	}
+	return p
+}
+
+// ParseTemplates parses a comma-separated list of path templates, each
+// starting with a slash and containing {slug}, and puts prefix, the path
+// nclip is served under, before each. It returns nil for an invalid
+// template.
+func ParseTemplates(list, prefix string) []string {
+	var templates []string
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if !strings.HasPrefix(t, "/") || !strings.Contains(t, SlugPlaceholder) {
+			return nil
+		}
+		templates = append(templates, strings.TrimSuffix(prefix, "/")+t)
+	}
+	return templates
+}
+
+// Targets returns the names of the targets.
+func (p *Purger) Targets() []string {
+	names := make([]string, 0, len(p.queues))
+	for _, q := range p.queues {
+		names = append(names, q.target.Name())
+	}
+	return names
+}
+
+// Paths returns the paths of slug's URLs.
+func (p *Purger) Paths(slug string) []string {
+	paths := make([]string, 0, len(p.templates))
+	for _, t := range p.templates {
+		paths = append(paths, strings.ReplaceAll(t, SlugPlaceholder, slug))
+	}
+	return paths
+}
+
+// Start sends queued paths in the background every interval. Until it is
+// called, as in Lambda, which freezes between invocations, Purge sends
+// them itself before returning, without retries.
+func (p *Purger) Start() {
+	p.started.Store(true)
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			p.flush()
+		}
+	}()
+}
+
+// Purge invalidates the URLs of slugs. Ids that are not slugs, such as
+// those of previews and kept versions, are ignored.
+func (p *Purger) Purge(slugs ...string) {
+	var paths []string
+	for _, slug := range slugs {
+		if utils.IsValidSlug(slug) {
+			paths = append(paths, p.Paths(slug)...)
+		}
+	}
+	if len(paths) == 0 {
+		return
+	}
+	if !p.started.Load() {
+		for _, q := range p.queues {
+			for start := 0; start < len(paths); start += maxBatch {
+				batch := paths[start:min(start+maxBatch, len(paths))]
+				if err := invalidate(q.target, batch); err != nil {
+					log.Printf("[WARN] CDN purge: %s failed to invalidate %d path(s): %v", q.target.Name(), len(batch), err)
+				}
+			}
+		}
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, q := range p.queues {
+		dropped := 0
+		for _, path := range paths {
+			switch {
+			case q.queued[path]:
+			case len(q.pending) >= maxPending:
+				dropped++
+			default:
+				q.queued[path] = true
+				q.pending = append(q.pending, path)
+			}
+		}
+		if dropped > 0 {
+			log.Printf("[WARN] CDN purge: %s queue full, dropped %d path(s)", q.target.Name(), dropped)
+		}
+	}
+}
+
+// flush sends one batch to every target that has paths waiting and is not
+// backing off.
+func (p *Purger) flush() {
+	for _, q := range p.queues {
+		p.send(q)
+	}
+}
+
+// send sends q's failed batch if its retry is due, or else its next batch
+// of pending paths.
+func (p *Purger) send(q *queue) {
+	p.mu.Lock()
+	switch {
+	case q.batch == nil && len(q.pending) == 0:
+		p.mu.Unlock()
+		return
+	case q.batch == nil:
+		n := min(len(q.pending), maxBatch)
+		q.batch = q.pending[:n:n]
+		q.pending = q.pending[n:]
+		for _, path := range q.batch {
+			delete(q.queued, path)
+		}
+	case p.now().Before(q.retryAt):
+		p.mu.Unlock()
+		return
+	}
+	batch := q.batch
+	p.mu.Unlock()
+
+	err := invalidate(q.target, batch)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		q.batch, q.attempts = nil, 0
+		return
+	}
+	q.attempts++
+	if q.attempts >= maxAttempts {
+		log.Printf("[ERROR] CDN purge: %s failed to invalidate %d path(s) %d times, giving up: %v", q.target.Name(), len(batch), q.attempts, err)
+		q.batch, q.attempts = nil, 0
+		return
+	}
+	backoff := min(p.interval<<q.attempts, maxBackoff)
+	q.retryAt = p.now().Add(backoff)
+	log.Printf("[WARN] CDN purge: %s failed to invalidate %d path(s), retrying in %s: %v", q.target.Name(), len(batch), backoff, err)
+}
+
+// invalidate sends paths to t with a timeout.
+func invalidate(t Target, paths []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	return t.Invalidate(ctx, paths)
+}
@@ -0,0 +1,172 @@
+package cdnpurge
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// fakeTarget records the batches it is sent and fails while fail is set.
+type fakeTarget struct {
+	mu      sync.Mutex
+	batches [][]string
+	fail    bool
+}
+
+func (f *fakeTarget) Name() string { return "fake" }
+
+func (f *fakeTarget) Invalidate(_ context.Context, paths []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.batches = append(f.batches, append([]string(nil), paths...))
+	if f.fail {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+func TestParseTemplates(t *testing.T) {
+	got := ParseTemplates(" /{slug}, /raw/{slug} ,,", "/paste/")
+	if want := []string{"/paste/{slug}", "/paste/raw/{slug}"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, list := range []string{"", "/raw/", "raw/{slug}", "/{slug},/static"} {
+		if ParseTemplates(list, "") != nil {
+			t.Errorf("expected %q to be rejected", list)
+		}
+	}
+}
+
+func TestPurger_Batches(t *testing.T) {
+	target := &fakeTarget{}
+	p := New([]string{"/{slug}", "/raw/{slug}"}, time.Minute, target)
+	now := time.Unix(1700000000, 0)
+	p.now = func() time.Time { return now }
+	p.started.Store(true)
+
+	p.Purge("ABCDE", "not a slug", "ABCDE.png")
+	p.Purge("ABCDE", "FGHJK")
+	p.flush()
+	if want := [][]string{{"/ABCDE", "/raw/ABCDE", "/FGHJK", "/raw/FGHJK"}}; !reflect.DeepEqual(target.batches, want) {
+		t.Fatalf("expected one deduplicated batch, got %v", target.batches)
+	}
+
+	// A failed batch is kept and sent again once its backoff is over.
+	target.fail = true
+	p.Purge("MNPQR")
+	p.flush()
+	p.Purge("STUVW")
+	p.flush()
+	if len(target.batches) != 2 {
+		t.Fatalf("expected no request while backing off, got %v", target.batches)
+	}
+	now = now.Add(2 * time.Minute)
+	target.fail = false
+	p.flush()
+	p.flush()
+	want := [][]string{{"/MNPQR", "/raw/MNPQR"}, {"/MNPQR", "/raw/MNPQR"}, {"/STUVW", "/raw/STUVW"}}
+	if !reflect.DeepEqual(target.batches[1:], want) {
+		t.Fatalf("expected the failed batch retried before the next, got %v", target.batches[1:])
+	}
+
+	// After maxAttempts failures the batch is dropped.
+	target.fail = true
+	p.Purge("XYZ23")
+	for i := 0; i < maxAttempts; i++ {
+		now = now.Add(maxBackoff)
+		p.flush()
+	}
+	if p.queues[0].batch != nil || len(target.batches) != 4+maxAttempts {
+		t.Errorf("expected the batch given up after %d attempts, got %d requests", maxAttempts, len(target.batches))
+	}
+}
+
+func TestPurger_Unstarted(t *testing.T) {
+	target := &fakeTarget{}
+	p := New([]string{"/{slug}"}, time.Minute, target)
+	p.Purge("ABCDE")
+	if want := [][]string{{"/ABCDE"}}; !reflect.DeepEqual(target.batches, want) {
+		t.Errorf("expected Purge to send before Start, got %v", target.batches)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var got WebhookEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Nclip-Event") != EventPurge {
+			t.Errorf("unexpected event header %q", r.Header.Get("X-Nclip-Event"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		if strings.Contains(r.URL.RawQuery, "fail") {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	w := NewWebhook(srv.URL, "https://paste.example.com/")
+	if err := w.Invalidate(context.Background(), []string{"/ABCDE"}); err != nil {
+		t.Fatal(err)
+	}
+	want := WebhookEvent{Event: EventPurge, Paths: []string{"/ABCDE"}, URLs: []string{"https://paste.example.com/ABCDE"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if err := NewWebhook(srv.URL+"?fail", "").Invalidate(context.Background(), []string{"/ABCDE"}); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("expected the webhook's status as error, got %v", err)
+	}
+}
+
+func TestCloudFront(t *testing.T) {
+	var refs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); !strings.Contains(auth, "/us-east-1/cloudfront/") {
+			t.Errorf("request not signed for cloudfront: %q", auth)
+		}
+		if r.Method != http.MethodPost || r.URL.Path != "/distribution/E2QWRUHAPOMQZL/invalidation" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		var batch cloudFrontBatch
+		data, _ := io.ReadAll(r.Body)
+		if err := xml.Unmarshal(data, &batch); err != nil || batch.Quantity != 2 || len(batch.Paths) != 2 {
+			t.Errorf("invalid invalidation batch %s: %v", data, err)
+		}
+		refs = append(refs, batch.CallerReference)
+		if len(refs) > 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = io.WriteString(w, `<ErrorResponse><Error><Code>TooManyInvalidationsInProgress</Code><Message>slow down</Message></Error></ErrorResponse>`)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, `<Invalidation><Id>I1</Id><Status>InProgress</Status></Invalidation>`)
+	}))
+	defer srv.Close()
+
+	creds := aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+	})
+	c := newCloudFront(creds, srv.URL, "E2QWRUHAPOMQZL")
+	paths := []string{"/ABCDE", "/raw/ABCDE"}
+	if err := c.Invalidate(context.Background(), paths); err != nil {
+		t.Fatalf("Invalidate: %v", err)
+	}
+	var apiErr *cloudFrontError
+	if err := c.Invalidate(context.Background(), paths); !errors.As(err, &apiErr) || apiErr.Code != "TooManyInvalidationsInProgress" {
+		t.Errorf("expected the API error, got %v", err)
+	}
+	if len(refs) != 2 || refs[0] == refs[1] {
+		t.Errorf("expected a new caller reference per request, got %v", refs)
+	}
+}
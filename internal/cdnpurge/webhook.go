@@ -0,0 +1,75 @@
+package cdnpurge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/johnwmail/nclip/internal/egress"
+)
+
+// EventPurge is the event of a webhook request.
+const EventPurge = "paste.purge"
+
+// WebhookEvent is the JSON body posted to a webhook.
+type WebhookEvent struct {
+	Event string   `json:"event"`
+	Paths []string `json:"paths"`
+	// URLs are the paths on the configured base URL; they are left out
+	// when no base URL is configured.
+	URLs []string `json:"urls,omitempty"`
+}
+
+// Webhook is a Target that posts a WebhookEvent to a URL, for CDNs
+// without built-in support or a purge service of one's own. Any 2xx
+// response is success.
+type Webhook struct {
+	url    string
+	origin string
+	client *http.Client
+}
+
+// NewWebhook creates a Webhook target posting to url. origin, such as
+// "https://paste.example.com", is put before paths to form the event's
+// URLs; it may be empty.
+func NewWebhook(url, origin string) *Webhook {
+	return &Webhook{url: url, origin: strings.TrimSuffix(origin, "/"), client: egress.Client(sendTimeout)}
+}
+
+// Name implements Target.
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+// Invalidate implements Target.
+func (w *Webhook) Invalidate(ctx context.Context, paths []string) error {
+	event := WebhookEvent{Event: EventPurge, Paths: paths}
+	if w.origin != "" {
+		for _, p := range paths {
+			event.URLs = append(event.URLs, w.origin+p)
+		}
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "nclip-cdn-purge")
+	req.Header.Set("X-Nclip-Event", EventPurge)
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,456 @@
+// Package certs obtains and renews TLS certificates from an ACME CA (Let's
+// Encrypt by default), answering DNS-01 challenges through a DNSProvider.
+// DNS-01 is the only challenge that can prove control of wildcard names,
+// and it works for instances that are not reachable from the internet.
+//
+// The certificate and the ACME account key are kept in the storage
+// backend, so every instance sharing it serves the same certificate and
+// only one of them renews it. A lock object in the backend keeps the
+// others from ordering at the same time; it is best-effort, since the
+// backends have no compare-and-swap.
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"github.com/johnwmail/nclip/storage"
+)
+
+// LetsEncrypt is the production directory of Let's Encrypt.
+const LetsEncrypt = "https://acme-v02.api.letsencrypt.org/directory"
+
+// Intervals of the background loop: how often the stored certificate is
+// checked (and reloaded, in case another instance renewed it), and how
+// soon a failed attempt is retried.
+const (
+	checkInterval = time.Hour
+	retryInterval = 5 * time.Minute
+)
+
+// lockTTL is how long a renewal lock taken by another instance is honoured.
+// An order normally completes within a few minutes.
+const lockTTL = 15 * time.Minute
+
+// Names of the objects kept in the certificate store.
+const (
+	accountKeyName = "acme-account.key"
+	lockName       = "acme-renewal.lock"
+)
+
+// errNoCertificate is returned by GetCertificate until one is loaded.
+var errNoCertificate = errors.New("no TLS certificate available yet")
+
+// errLocked is returned by Ensure while another instance renews.
+var errLocked = errors.New("another instance is obtaining the certificate")
+
+// DNSProvider publishes and removes the TXT records of DNS-01 challenges.
+// fqdn has no trailing dot. Present returns once the provider has accepted
+// the record; the manager waits for it to propagate.
+type DNSProvider interface {
+	Present(ctx context.Context, fqdn, value string) error
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// ParseDomains parses a comma-separated list of DNS names, each optionally
+// a wildcard (*.example.com), into lower case.
+func ParseDomains(s string) ([]string, error) {
+	var domains []string
+	seen := map[string]bool{}
+	for _, d := range strings.Split(s, ",") {
+		d = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(d), "."))
+		if d == "" {
+			continue
+		}
+		if !validDomain(strings.TrimPrefix(d, "*.")) {
+			return nil, fmt.Errorf("invalid domain %q", d)
+		}
+		if !seen[d] {
+			seen[d] = true
+			domains = append(domains, d)
+		}
+	}
+	return domains, nil
+}
+
+// validDomain reports whether d is a DNS name of at least two labels.
+func validDomain(d string) bool {
+	labels := strings.Split(d, ".")
+	if len(d) > 253 || len(labels) < 2 {
+		return false
+	}
+	for _, l := range labels {
+		if l == "" || len(l) > 63 || l[0] == '-' || l[len(l)-1] == '-' {
+			return false
+		}
+		for _, r := range l {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Options configures a Manager.
+type Options struct {
+	// Domains are the names the certificate covers.
+	Domains []string
+	// Email is the contact address registered with the CA (optional).
+	Email string
+	// Directory is the CA's ACME directory URL; empty uses LetsEncrypt.
+	Directory string
+	// Provider answers the DNS-01 challenges.
+	Provider DNSProvider
+	// Propagation is how long to wait after publishing a challenge record
+	// before the CA is asked to check it.
+	Propagation time.Duration
+	// Store keeps the certificate and the account key.
+	Store storage.CertStore
+	// ReadOnly only loads certificates other instances obtain, as
+	// replicas do.
+	ReadOnly bool
+}
+
+// Manager serves a certificate for Options.Domains, obtaining it and
+// renewing it a third of its lifetime before it expires.
+type Manager struct {
+	opts Options
+	name string
+	// owner identifies this instance in the renewal lock.
+	owner string
+	now   func() time.Time
+
+	running sync.Mutex
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+}
+
+// New creates a Manager. It loads nothing until Ensure or Start is called.
+func New(opts Options) *Manager {
+	if opts.Directory == "" {
+		opts.Directory = LetsEncrypt
+	}
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return &Manager{
+		opts:  opts,
+		name:  certName(opts.Domains),
+		owner: fmt.Sprintf("%x", id),
+		now:   time.Now,
+	}
+}
+
+// certName returns the store name of the certificate for domains.
+func certName(domains []string) string {
+	if len(domains) == 0 {
+		return "certificate.pem"
+	}
+	return strings.ReplaceAll(domains[0], "*", "wildcard") + ".pem"
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cert == nil {
+		return nil, errNoCertificate
+	}
+	return m.cert, nil
+}
+
+// TLSConfig returns a server TLS configuration serving the managed
+// certificate.
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: m.GetCertificate,
+	}
+}
+
+// Start calls Ensure in the background, now and then periodically, until
+// ctx is done.
+func (m *Manager) Start(ctx context.Context) {
+	go func() {
+		for {
+			next := checkInterval
+			if err := m.Ensure(ctx); err != nil {
+				log.Printf("[WARN] ACME: %v; retrying in %s", err, retryInterval)
+				next = retryInterval
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(next):
+			}
+		}
+	}()
+}
+
+// Ensure loads the stored certificate and, unless the manager is read-only,
+// obtains a new one when it is missing, does not cover every domain or is
+// due for renewal.
+func (m *Manager) Ensure(ctx context.Context) error {
+	m.running.Lock()
+	defer m.running.Unlock()
+	cert, err := m.load()
+	if err != nil {
+		return err
+	}
+	if cert != nil {
+		m.set(cert)
+		if !m.needsRenewal(cert.Leaf) {
+			return nil
+		}
+	}
+	if m.opts.ReadOnly {
+		if cert == nil {
+			return errors.New("no certificate in storage yet; the writer obtains it")
+		}
+		return nil
+	}
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.unlock()
+	cert, err = m.obtain(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to obtain a certificate for %s: %w", strings.Join(m.opts.Domains, ", "), err)
+	}
+	m.set(cert)
+	log.Printf("[INFO] ACME: obtained a certificate for %s, valid until %s",
+		strings.Join(m.opts.Domains, ", "), cert.Leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+func (m *Manager) set(cert *tls.Certificate) {
+	m.mu.Lock()
+	m.cert = cert
+	m.mu.Unlock()
+}
+
+// load reads the stored certificate, returning nil when there is none.
+func (m *Manager) load() (*tls.Certificate, error) {
+	data, err := m.opts.Store.GetCert(m.name)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the stored certificate: %w", err)
+	}
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		log.Printf("[WARN] ACME: ignoring unreadable stored certificate %s: %v", m.name, err)
+		return nil, nil
+	}
+	return &cert, nil
+}
+
+// needsRenewal reports whether leaf is missing one of the domains or is
+// in the last third of its lifetime.
+func (m *Manager) needsRenewal(leaf *x509.Certificate) bool {
+	names := map[string]bool{}
+	for _, n := range leaf.DNSNames {
+		names[strings.ToLower(n)] = true
+	}
+	for _, d := range m.opts.Domains {
+		if !names[d] {
+			return true
+		}
+	}
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	return m.now().After(leaf.NotAfter.Add(-lifetime / 3))
+}
+
+// renewalLock is the content of the lock object.
+type renewalLock struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// lock takes the renewal lock unless another instance holds a live one.
+func (m *Manager) lock() error {
+	data, err := m.opts.Store.GetCert(lockName)
+	if err == nil {
+		var held renewalLock
+		if json.Unmarshal(data, &held) == nil && held.Owner != m.owner && m.now().Before(held.Expires) {
+			return errLocked
+		}
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("failed to read the renewal lock: %w", err)
+	}
+	data, err = json.Marshal(renewalLock{Owner: m.owner, Expires: m.now().Add(lockTTL)})
+	if err != nil {
+		return err
+	}
+	return m.opts.Store.PutCert(lockName, data)
+}
+
+func (m *Manager) unlock() {
+	if err := m.opts.Store.DeleteCert(lockName); err != nil {
+		log.Printf("[WARN] ACME: failed to release the renewal lock: %v", err)
+	}
+}
+
+// obtain orders a certificate, answers its challenges and stores the
+// result.
+func (m *Manager) obtain(ctx context.Context) (*tls.Certificate, error) {
+	client, err := m.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(m.opts.Domains...))
+	if err != nil {
+		return nil, err
+	}
+	// Authorizations are answered one at a time: a name and its wildcard
+	// share one record name, and some providers keep a single value there.
+	for _, u := range order.AuthzURLs {
+		if err := m.authorize(ctx, client, u); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: strings.TrimPrefix(m.opts.Domains[0], "*.")},
+		DNSNames: m.opts.Domains,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	bundle := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	for _, der := range chain {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	cert, err := tls.X509KeyPair(bundle, bundle)
+	if err != nil {
+		return nil, fmt.Errorf("CA returned an unusable certificate: %w", err)
+	}
+	if err := m.opts.Store.PutCert(m.name, bundle); err != nil {
+		return nil, fmt.Errorf("failed to store the certificate: %w", err)
+	}
+	return &cert, nil
+}
+
+// authorize answers the DNS-01 challenge of one authorization.
+func (m *Manager) authorize(ctx context.Context, client *acme.Client, url string) error {
+	authz, err := client.GetAuthorization(ctx, url)
+	if err != nil {
+		return err
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("CA offers no dns-01 challenge for %s", authz.Identifier.Value)
+	}
+	value, err := client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	fqdn := "_acme-challenge." + authz.Identifier.Value
+	if err := m.opts.Provider.Present(ctx, fqdn, value); err != nil {
+		return fmt.Errorf("failed to publish %s: %w", fqdn, err)
+	}
+	defer func() {
+		// The order's context may be done; removal gets its own.
+		cctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := m.opts.Provider.CleanUp(cctx, fqdn, value); err != nil {
+			log.Printf("[WARN] ACME: failed to remove %s: %v", fqdn, err)
+		}
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(m.opts.Propagation):
+	}
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return err
+	}
+	_, err = client.WaitAuthorization(ctx, authz.URI)
+	return err
+}
+
+// client returns an ACME client registered with the stored account key,
+// creating and storing the key on first use.
+func (m *Manager) client(ctx context.Context) (*acme.Client, error) {
+	key, err := m.accountKey()
+	if err != nil {
+		return nil, err
+	}
+	client := &acme.Client{Key: key, DirectoryURL: m.opts.Directory, UserAgent: "nclip"}
+	acct := &acme.Account{}
+	if m.opts.Email != "" {
+		acct.Contact = []string{"mailto:" + m.opts.Email}
+	}
+	if _, err := client.Register(ctx, acct, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("failed to register the ACME account: %w", err)
+	}
+	return client, nil
+}
+
+// accountKey loads the ACME account key, generating one if none is stored.
+func (m *Manager) accountKey() (*ecdsa.PrivateKey, error) {
+	data, err := m.opts.Store.GetCert(accountKeyName)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("stored ACME account key is not PEM")
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("failed to read the ACME account key: %w", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.opts.Store.PutCert(accountKeyName, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+		return nil, fmt.Errorf("failed to store the ACME account key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,163 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/storage"
+)
+
+// selfSigned returns a PEM bundle (key and certificate) for names, valid
+// from notBefore to notAfter.
+func selfSigned(t *testing.T, names []string, notBefore, notAfter time.Time) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}, &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: names[0]}}, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+}
+
+// newManager returns a Manager for example.com and its wildcard whose CA
+// must not be contacted.
+func newManager(t *testing.T, readOnly bool) (*Manager, *storage.FilesystemStore) {
+	t.Helper()
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to the CA: %s %s", r.Method, r.URL)
+		http.Error(w, "unexpected", http.StatusInternalServerError)
+	}))
+	t.Cleanup(ca.Close)
+	return New(Options{
+		Domains:   []string{"example.com", "*.example.com"},
+		Directory: ca.URL,
+		Store:     store,
+		ReadOnly:  readOnly,
+	}), store
+}
+
+func TestParseDomains(t *testing.T) {
+	got, err := ParseDomains(" Example.com, *.example.com ,example.com.,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"example.com", "*.example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseDomains = %v; want %v", got, want)
+	}
+	for _, bad := range []string{"localhost", "a.*.example.com", "exa_mple.com", "-a.example.com", "*"} {
+		if _, err := ParseDomains(bad); err == nil {
+			t.Errorf("ParseDomains(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestManager_LoadsStoredCertificate(t *testing.T) {
+	m, store := newManager(t, true)
+	if _, err := m.GetCertificate(nil); err == nil {
+		t.Fatal("expected no certificate before Ensure")
+	}
+	if err := m.Ensure(context.Background()); err == nil {
+		t.Error("expected an error on a replica while no certificate is stored")
+	}
+
+	now := time.Now()
+	if err := store.PutCert("wildcard.example.com.pem", selfSigned(t, []string{"*.example.com"}, now, now.Add(90*24*time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutCert(m.name, selfSigned(t, m.opts.Domains, now.Add(-time.Hour), now.Add(90*24*time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Ensure(context.Background()); err != nil {
+		t.Fatalf("Ensure: %v", err)
+	}
+	cert, err := m.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cert.Leaf.DNSNames, m.opts.Domains) {
+		t.Errorf("served a certificate for %v", cert.Leaf.DNSNames)
+	}
+}
+
+func TestManager_NeedsRenewal(t *testing.T) {
+	m, _ := newManager(t, true)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+	cases := []struct {
+		name      string
+		names     []string
+		notBefore time.Time
+		want      bool
+	}{
+		{"fresh", m.opts.Domains, now.Add(-time.Hour), false},
+		{"last third", m.opts.Domains, now.Add(-70 * 24 * time.Hour), true},
+		{"missing a domain", []string{"example.com"}, now.Add(-time.Hour), true},
+	}
+	for _, tc := range cases {
+		leaf := &x509.Certificate{DNSNames: tc.names, NotBefore: tc.notBefore, NotAfter: tc.notBefore.Add(90 * 24 * time.Hour)}
+		if got := m.needsRenewal(leaf); got != tc.want {
+			t.Errorf("%s: needsRenewal = %v; want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestManager_RenewalLock(t *testing.T) {
+	m, store := newManager(t, false)
+	data, err := json.Marshal(renewalLock{Owner: "other", Expires: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutCert(lockName, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Ensure(context.Background()); !errors.Is(err, errLocked) {
+		t.Fatalf("expected the other instance's lock to be honoured, got %v", err)
+	}
+
+	// An expired lock is taken over, and released after the attempt.
+	data, err = json.Marshal(renewalLock{Owner: "other", Expires: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutCert(lockName, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.lock(); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	m.unlock()
+	if _, err := store.GetCert(lockName); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected the lock released, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/egress"
+)
+
+// cloudflareAPI is the base URL of the Cloudflare v4 API.
+const cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// Cloudflare is a DNSProvider for zones hosted on Cloudflare. The API
+// token needs the Zone.DNS edit permission on the zones of the
+// certificate's names.
+type Cloudflare struct {
+	token   string
+	baseURL string
+	client  *http.Client
+}
+
+// NewCloudflare creates a Cloudflare provider authenticating with token.
+func NewCloudflare(token string) *Cloudflare {
+	return &Cloudflare{token: token, baseURL: cloudflareAPI, client: egress.Client(30 * time.Second)}
+}
+
+// cloudflareResponse is the envelope of every Cloudflare API response.
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+// cloudflareRecord is a DNS record as the API returns it.
+type cloudflareRecord struct {
+	ID string `json:"id"`
+}
+
+// Present implements DNSProvider.
+func (p *Cloudflare) Present(ctx context.Context, fqdn, value string) error {
+	zone, err := p.zoneID(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{"type": "TXT", "name": fqdn, "content": value, "ttl": 120})
+	if err != nil {
+		return err
+	}
+	return p.do(ctx, http.MethodPost, "/zones/"+url.PathEscape(zone)+"/dns_records", body, nil)
+}
+
+// CleanUp implements DNSProvider, removing every TXT record at fqdn with
+// value.
+func (p *Cloudflare) CleanUp(ctx context.Context, fqdn, value string) error {
+	zone, err := p.zoneID(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	query := url.Values{"type": {"TXT"}, "name": {fqdn}, "content": {value}}
+	var records []cloudflareRecord
+	if err := p.do(ctx, http.MethodGet, "/zones/"+url.PathEscape(zone)+"/dns_records?"+query.Encode(), nil, &records); err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err := p.do(ctx, http.MethodDelete, "/zones/"+url.PathEscape(zone)+"/dns_records/"+url.PathEscape(r.ID), nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zoneID finds the zone fqdn belongs to by trying its parent domains from
+// the longest down.
+func (p *Cloudflare) zoneID(ctx context.Context, fqdn string) (string, error) {
+	labels := strings.Split(fqdn, ".")
+	for i := 1; i < len(labels)-1; i++ {
+		var zones []cloudflareRecord
+		name := strings.Join(labels[i:], ".")
+		if err := p.do(ctx, http.MethodGet, "/zones?"+url.Values{"name": {name}}.Encode(), nil, &zones); err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			return zones[0].ID, nil
+		}
+	}
+	return "", fmt.Errorf("no Cloudflare zone found for %s", fqdn)
+}
+
+// do sends a request to the API and decodes its result into out.
+func (p *Cloudflare) do(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	var envelope cloudflareResponse
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("cloudflare returned %s", resp.Status)
+	}
+	if !envelope.Success {
+		var msgs []string
+		for _, e := range envelope.Errors {
+			msgs = append(msgs, fmt.Sprintf("%d %s", e.Code, e.Message))
+		}
+		if len(msgs) == 0 {
+			msgs = append(msgs, resp.Status)
+		}
+		return errors.New("cloudflare: " + strings.Join(msgs, "; "))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(envelope.Result, out)
+}
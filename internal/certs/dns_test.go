@@ -0,0 +1,120 @@
+package certs
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestCloudflare(t *testing.T) {
+	var records []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = io.WriteString(w, `{"success":false,"errors":[{"code":9109,"message":"Invalid access token"}]}`)
+			return
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/zones":
+			result := "[]"
+			if r.URL.Query().Get("name") == "example.com" {
+				result = `[{"id":"z1"}]`
+			}
+			_, _ = io.WriteString(w, `{"success":true,"result":`+result+`}`)
+		case r.Method == "POST" && r.URL.Path == "/zones/z1/dns_records":
+			var rec map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&rec)
+			records = append(records, rec["name"].(string)+"="+rec["content"].(string))
+			_, _ = io.WriteString(w, `{"success":true,"result":{"id":"r1"}}`)
+		case r.Method == "GET" && r.URL.Path == "/zones/z1/dns_records":
+			_, _ = io.WriteString(w, `{"success":true,"result":[{"id":"r1"}]}`)
+		case r.Method == "DELETE" && r.URL.Path == "/zones/z1/dns_records/r1":
+			records = records[:0]
+			_, _ = io.WriteString(w, `{"success":true,"result":{"id":"r1"}}`)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	p := NewCloudflare("tok")
+	p.baseURL = srv.URL
+	ctx := context.Background()
+	if err := p.Present(ctx, "_acme-challenge.www.example.com", "v1"); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if len(records) != 1 || records[0] != "_acme-challenge.www.example.com=v1" {
+		t.Fatalf("unexpected records %v", records)
+	}
+	if err := p.CleanUp(ctx, "_acme-challenge.www.example.com", "v1"); err != nil || len(records) != 0 {
+		t.Fatalf("CleanUp: %v, records %v", err, records)
+	}
+
+	p.token = "wrong"
+	if err := p.Present(ctx, "_acme-challenge.example.com", "v1"); err == nil || !strings.Contains(err.Error(), "Invalid access token") {
+		t.Errorf("expected the API error, got %v", err)
+	}
+}
+
+func TestRoute53(t *testing.T) {
+	var changes []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256") || !strings.Contains(auth, "/us-east-1/route53/") {
+			t.Errorf("request not signed for route53: %q", auth)
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/hostedzonesbyname":
+			// Like Route 53, answer with the next zone in order when there
+			// is no exact match.
+			_, _ = io.WriteString(w, `<ListHostedZonesByNameResponse><HostedZones><HostedZone><Id>/hostedzone/Z1</Id><Name>example.com.</Name><Config><PrivateZone>false</PrivateZone></Config></HostedZone></HostedZones></ListHostedZonesByNameResponse>`)
+		case r.Method == "POST" && r.URL.Path == "/hostedzone/Z1/rrset":
+			var req route53ChangeRequest
+			data, _ := io.ReadAll(r.Body)
+			if err := xml.Unmarshal(data, &req); err != nil || len(req.Changes) != 1 {
+				t.Errorf("invalid change request %s: %v", data, err)
+			}
+			c := req.Changes[0]
+			if c.Action == "DELETE" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = io.WriteString(w, `<ErrorResponse><Error><Code>InvalidChangeBatch</Code><Message>not found</Message></Error></ErrorResponse>`)
+				return
+			}
+			changes = append(changes, c.Action+" "+c.Set.Name+" "+c.Set.Values[0])
+			_, _ = io.WriteString(w, `<ChangeResourceRecordSetsResponse><ChangeInfo><Id>/change/C1</Id><Status>PENDING</Status></ChangeInfo></ChangeResourceRecordSetsResponse>`)
+		case r.Method == "GET" && r.URL.Path == "/change/C1":
+			_, _ = io.WriteString(w, `<GetChangeResponse><ChangeInfo><Id>/change/C1</Id><Status>INSYNC</Status></ChangeInfo></GetChangeResponse>`)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	creds := aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+	})
+	p := newRoute53(creds, srv.URL)
+	p.poll = time.Millisecond
+	ctx := context.Background()
+	if err := p.Present(ctx, "_acme-challenge.example.com", "v1"); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if len(changes) != 1 || changes[0] != `UPSERT _acme-challenge.example.com. "v1"` {
+		t.Errorf("unexpected changes %v", changes)
+	}
+	if err := p.CleanUp(ctx, "_acme-challenge.example.com", "v1"); err != nil {
+		t.Errorf("CleanUp of a removed record: %v", err)
+	}
+	if _, err := p.zoneID(ctx, "_acme-challenge.example.org"); err == nil {
+		t.Error("expected no zone for example.org")
+	}
+}
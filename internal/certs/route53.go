@@ -0,0 +1,221 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/johnwmail/nclip/internal/egress"
+)
+
+// route53API is the global Route 53 endpoint; its requests are signed for
+// us-east-1.
+const (
+	route53API    = "https://route53.amazonaws.com/2013-04-01"
+	route53Region = "us-east-1"
+	route53NS     = "https://route53.amazonaws.com/doc/2013-04-01/"
+)
+
+// Route53 is a DNSProvider for public hosted zones in Amazon Route 53,
+// using the default AWS credential chain. It needs the
+// route53:ListHostedZonesByName, route53:ChangeResourceRecordSets and
+// route53:GetChange permissions. Present waits until Route 53 reports the
+// change as in sync on its name servers.
+type Route53 struct {
+	credentials aws.CredentialsProvider
+	baseURL     string
+	client      *http.Client
+	signer      *v4.Signer
+	// poll is the interval GetChange is polled at.
+	poll time.Duration
+}
+
+// NewRoute53 creates a Route53 provider with the default AWS credentials.
+func NewRoute53(ctx context.Context) (*Route53, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return newRoute53(cfg.Credentials, route53API), nil
+}
+
+func newRoute53(credentials aws.CredentialsProvider, baseURL string) *Route53 {
+	return &Route53{
+		credentials: credentials,
+		baseURL:     baseURL,
+		client:      egress.Client(30 * time.Second),
+		signer:      v4.NewSigner(),
+		poll:        5 * time.Second,
+	}
+}
+
+// route53Zones is the ListHostedZonesByName response.
+type route53Zones struct {
+	HostedZones []struct {
+		ID     string `xml:"Id"`
+		Name   string `xml:"Name"`
+		Config struct {
+			PrivateZone bool `xml:"PrivateZone"`
+		} `xml:"Config"`
+	} `xml:"HostedZones>HostedZone"`
+}
+
+// route53ChangeInfo is the response of ChangeResourceRecordSets and
+// GetChange.
+type route53ChangeInfo struct {
+	ID     string `xml:"ChangeInfo>Id"`
+	Status string `xml:"ChangeInfo>Status"`
+}
+
+// route53Error is the body of a failed request.
+type route53Error struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+// route53ChangeRequest is the ChangeResourceRecordSets request body.
+type route53ChangeRequest struct {
+	XMLName xml.Name        `xml:"ChangeResourceRecordSetsRequest"`
+	Xmlns   string          `xml:"xmlns,attr"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+// route53Change is one change of a record set.
+type route53Change struct {
+	Action string           `xml:"Action"`
+	Set    route53RecordSet `xml:"ResourceRecordSet"`
+}
+
+// route53RecordSet is a resource record set.
+type route53RecordSet struct {
+	Name   string   `xml:"Name"`
+	Type   string   `xml:"Type"`
+	TTL    int      `xml:"TTL"`
+	Values []string `xml:"ResourceRecords>ResourceRecord>Value"`
+}
+
+// Present implements DNSProvider, replacing the TXT record set at fqdn.
+func (p *Route53) Present(ctx context.Context, fqdn, value string) error {
+	info, err := p.change(ctx, "UPSERT", fqdn, value)
+	if err != nil {
+		return err
+	}
+	for info.Status != "INSYNC" {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.poll):
+		}
+		if err := p.do(ctx, http.MethodGet, "/change/"+url.PathEscape(strings.TrimPrefix(info.ID, "/change/")), nil, &info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CleanUp implements DNSProvider. A record set that is already gone is
+// not an error.
+func (p *Route53) CleanUp(ctx context.Context, fqdn, value string) error {
+	_, err := p.change(ctx, "DELETE", fqdn, value)
+	var apiErr *route53Error
+	if errors.As(err, &apiErr) && apiErr.Code == "InvalidChangeBatch" {
+		return nil
+	}
+	return err
+}
+
+// change applies one change to the TXT record set at fqdn.
+func (p *Route53) change(ctx context.Context, action, fqdn, value string) (route53ChangeInfo, error) {
+	var info route53ChangeInfo
+	zone, err := p.zoneID(ctx, fqdn)
+	if err != nil {
+		return info, err
+	}
+	body, err := xml.Marshal(route53ChangeRequest{
+		Xmlns: route53NS,
+		Changes: []route53Change{{Action: action, Set: route53RecordSet{
+			Name:   fqdn + ".",
+			Type:   "TXT",
+			TTL:    60,
+			Values: []string{strconv.Quote(value)},
+		}}},
+	})
+	if err != nil {
+		return info, err
+	}
+	err = p.do(ctx, http.MethodPost, "/hostedzone/"+url.PathEscape(zone)+"/rrset", append([]byte(xml.Header), body...), &info)
+	return info, err
+}
+
+// zoneID finds the public hosted zone fqdn belongs to by trying its parent
+// domains from the longest down.
+func (p *Route53) zoneID(ctx context.Context, fqdn string) (string, error) {
+	labels := strings.Split(fqdn, ".")
+	for i := 1; i < len(labels)-1; i++ {
+		name := strings.Join(labels[i:], ".") + "."
+		var zones route53Zones
+		query := url.Values{"dnsname": {name}, "maxitems": {"1"}}
+		if err := p.do(ctx, http.MethodGet, "/hostedzonesbyname?"+query.Encode(), nil, &zones); err != nil {
+			return "", err
+		}
+		for _, z := range zones.HostedZones {
+			if strings.EqualFold(z.Name, name) && !z.Config.PrivateZone {
+				return strings.TrimPrefix(z.ID, "/hostedzone/"), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no Route 53 hosted zone found for %s", fqdn)
+}
+
+// do sends a signed request and decodes the XML response into out.
+func (p *Route53) do(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "text/xml")
+	}
+	creds, err := p.credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	if err := p.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), "route53", route53Region, time.Now()); err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &route53Error{}
+		if xml.Unmarshal(data, apiErr) != nil || apiErr.Code == "" {
+			return fmt.Errorf("route53 returned %s", resp.Status)
+		}
+		return apiErr
+	}
+	return xml.Unmarshal(data, out)
+}
+
+func (e *route53Error) Error() string {
+	return "route53: " + e.Code + ": " + e.Message
+}
@@ -0,0 +1,233 @@
+// Package changes keeps the journal behind the sync feed: an ordered log
+// of the slugs that were stored or deleted, which mirrors poll to copy a
+// primary's pastes without sharing its storage.
+//
+// The journal is a JSON Lines file. Each change has a sequence number that
+// serves as the feed cursor; only the most recent changes are retained, so
+// a mirror that falls further behind than that must be reseeded.
+package changes
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/johnwmail/nclip/models"
+)
+
+// Operations recorded in the journal.
+const (
+	OpPut    = "put"
+	OpDelete = "delete"
+)
+
+// DefaultRetain is the number of changes kept in the journal.
+const DefaultRetain = 100000
+
+// ErrCursorExpired is returned by Since for a cursor older than the oldest
+// retained change, or ahead of the latest one after the journal was reset.
+var ErrCursorExpired = errors.New("sync cursor is not covered by the change journal")
+
+// Change is one journal entry. Paste is only set in feed responses, for
+// puts of pastes that still exist.
+type Change struct {
+	Seq   int64         `json:"seq"`
+	Time  time.Time     `json:"time"`
+	Op    string        `json:"op"`
+	Slug  string        `json:"slug"`
+	Paste *models.Paste `json:"paste,omitempty"`
+}
+
+// Page is the body of GET /api/v1/sync/changes.
+type Page struct {
+	Changes []Change `json:"changes"`
+	// Cursor is the sequence number of the last change returned, or the
+	// requested cursor when there were none; pass it as since next time.
+	Cursor int64 `json:"cursor"`
+	// More is set when further changes are available right away.
+	More bool `json:"more"`
+}
+
+// Journal is an append-only log of changes. It is safe for concurrent use.
+type Journal struct {
+	path   string
+	retain int
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries []Change
+	file    *os.File
+}
+
+// Open opens or creates the journal at path, keeping the last retain
+// changes.
+func Open(path string, retain int) (*Journal, error) {
+	j := &Journal{path: path, retain: retain, now: time.Now}
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// load reads the retained changes from the journal file.
+func (j *Journal) load() error {
+	f, err := os.Open(j.path) // #nosec G304 -- path is operator configuration
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var c Change
+		// A line cut short by a crash is skipped.
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil || c.Seq == 0 {
+			continue
+		}
+		j.entries = append(j.entries, c)
+		if len(j.entries) > 2*j.retain {
+			j.entries = append([]Change(nil), j.entries[len(j.entries)-j.retain:]...)
+		}
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the journal file with the retained changes and reopens
+// it for appending. The caller holds mu, or has exclusive access.
+func (j *Journal) compact() error {
+	if len(j.entries) > j.retain {
+		j.entries = append([]Change(nil), j.entries[len(j.entries)-j.retain:]...)
+	}
+	if j.file != nil {
+		_ = j.file.Close()
+		j.file = nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), ".changes-*")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, c := range j.entries {
+		if err := enc.Encode(c); err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), j.path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	j.file, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0o600) // #nosec G304 -- path is operator configuration
+	return err
+}
+
+// Record appends a change. Failures are logged, never returned: the change
+// has already happened, and a mirror that misses it can be reseeded.
+func (j *Journal) Record(op, slug string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	c := Change{Seq: j.head() + 1, Time: j.now().UTC(), Op: op, Slug: slug}
+	j.entries = append(j.entries, c)
+	if len(j.entries) >= 2*j.retain {
+		if err := j.compact(); err != nil {
+			log.Printf("[ERROR] changes: failed to compact %s: %v", j.path, err)
+		}
+		return
+	}
+	if j.file == nil {
+		log.Printf("[ERROR] changes: failed to record %s %s: journal is not open", op, slug)
+		return
+	}
+	line, _ := json.Marshal(c)
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		log.Printf("[ERROR] changes: failed to record %s %s: %v", op, slug, err)
+	}
+}
+
+// Head returns the sequence number of the latest change, 0 when there are
+// none.
+func (j *Journal) Head() int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.head()
+}
+
+func (j *Journal) head() int64 {
+	if len(j.entries) == 0 {
+		return 0
+	}
+	return j.entries[len(j.entries)-1].Seq
+}
+
+// Oldest returns the oldest cursor the journal can serve changes after.
+func (j *Journal) Oldest() int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.oldest()
+}
+
+func (j *Journal) oldest() int64 {
+	if len(j.entries) == 0 {
+		return 0
+	}
+	return j.entries[0].Seq - 1
+}
+
+// Since returns up to limit changes after cursor, in order. It returns
+// ErrCursorExpired when changes after cursor are no longer retained; the
+// error message names the oldest cursor still available, as does Oldest.
+func (j *Journal) Since(cursor int64, limit int) (Page, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	page := Page{Changes: []Change{}, Cursor: cursor}
+	oldest := j.oldest()
+	if cursor < oldest || cursor > j.head() {
+		return page, fmt.Errorf("%w: oldest available cursor is %d", ErrCursorExpired, oldest)
+	}
+	start := int(cursor - oldest)
+	if start >= len(j.entries) {
+		return page, nil
+	}
+	end := start + limit
+	if end > len(j.entries) {
+		end = len(j.entries)
+	}
+	page.Changes = append(page.Changes, j.entries[start:end]...)
+	page.Cursor = page.Changes[len(page.Changes)-1].Seq
+	page.More = end < len(j.entries)
+	return page, nil
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
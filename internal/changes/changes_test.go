@@ -0,0 +1,90 @@
+package changes
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournal_Since(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "changes.jsonl")
+	j, err := Open(path, 10)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	page, err := j.Since(0, 10)
+	if err != nil || len(page.Changes) != 0 || page.Cursor != 0 || page.More {
+		t.Fatalf("expected an empty page, got %+v (%v)", page, err)
+	}
+
+	j.Record(OpPut, "AAAA")
+	j.Record(OpPut, "BBBB")
+	j.Record(OpDelete, "AAAA")
+	page, err = j.Since(0, 2)
+	if err != nil || len(page.Changes) != 2 || page.Cursor != 2 || !page.More {
+		t.Fatalf("unexpected first page %+v (%v)", page, err)
+	}
+	page, err = j.Since(page.Cursor, 2)
+	if err != nil || len(page.Changes) != 1 || page.Changes[0].Op != OpDelete || page.Cursor != 3 || page.More {
+		t.Fatalf("unexpected second page %+v (%v)", page, err)
+	}
+	page, err = j.Since(3, 2)
+	if err != nil || len(page.Changes) != 0 || page.Cursor != 3 {
+		t.Fatalf("expected no changes at the head, got %+v (%v)", page, err)
+	}
+	if _, err := j.Since(7, 2); !errors.Is(err, ErrCursorExpired) {
+		t.Errorf("expected ErrCursorExpired for a cursor ahead of the journal, got %v", err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening keeps the sequence.
+	j, err = Open(path, 10)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer func() { _ = j.Close() }()
+	j.Record(OpPut, "CCCC")
+	if head := j.Head(); head != 4 {
+		t.Errorf("expected head 4 after reopening, got %d", head)
+	}
+}
+
+func TestJournal_Retain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "changes.jsonl")
+	j, err := Open(path, 3)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { _ = j.Close() }()
+	for i := 0; i < 7; i++ {
+		j.Record(OpPut, "AAAA")
+	}
+	// Compaction at 6 entries kept the last 3 (seq 4-6), then seq 7 was
+	// appended.
+	if _, err := j.Since(2, 10); !errors.Is(err, ErrCursorExpired) {
+		t.Errorf("expected ErrCursorExpired for a dropped cursor, got %v", err)
+	}
+	page, err := j.Since(3, 10)
+	if err != nil || len(page.Changes) != 4 || page.Changes[0].Seq != 4 {
+		t.Fatalf("unexpected page %+v (%v)", page, err)
+	}
+
+	// A partial last line, as a crash leaves, is skipped on open.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"seq":8,"op":"pu`)
+	_ = f.Close()
+	reopened, err := Open(path, 3)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer func() { _ = reopened.Close() }()
+	if head := reopened.Head(); head != 7 {
+		t.Errorf("expected head 7, got %d", head)
+	}
+}
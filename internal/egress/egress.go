@@ -0,0 +1,222 @@
+// Package egress builds the clients nclip uses to reach other services:
+// S3, MongoDB, Redis, mirrors' primaries, DNS providers, webhooks and push
+// services. HTTP clients go through the proxy named by HTTPS_PROXY,
+// HTTP_PROXY and NO_PROXY, and every TLS client trusts the system roots
+// plus the certificates loaded with UseCABundle, so nclip works in
+// networks where all egress passes a proxy with a private CA.
+package egress
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// roots are the trusted roots; nil means the system roots.
+var roots atomic.Pointer[x509.CertPool]
+
+// UseCABundle makes the clients of this package trust the PEM
+// certificates in the file at path in addition to the system roots. An
+// empty path keeps the system roots. It returns the number of
+// certificates added.
+func UseCABundle(path string) (int, error) {
+	if path == "" {
+		return 0, nil
+	}
+	pool, n, err := LoadCABundle(path)
+	if err != nil {
+		return 0, err
+	}
+	roots.Store(pool)
+	return n, nil
+}
+
+// LoadCABundle returns the system roots plus the PEM certificates in the
+// file at path, and the number of certificates it added.
+func LoadCABundle(path string) (*x509.CertPool, int, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- operator-configured path
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	n := 0
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, 0, fmt.Errorf("CA bundle %s: certificate %d: %w", path, n+1, err)
+		}
+		pool.AddCert(cert)
+		n++
+	}
+	if n == 0 {
+		return nil, 0, fmt.Errorf("CA bundle %s holds no PEM certificates", path)
+	}
+	return pool, n, nil
+}
+
+// RootCAs returns the roots TLS clients trust, or nil for the system
+// roots.
+func RootCAs() *x509.CertPool {
+	return roots.Load()
+}
+
+// TLSConfig returns a TLS configuration trusting RootCAs.
+func TLSConfig() *tls.Config {
+	return &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: RootCAs()}
+}
+
+// Transport returns a transport that goes through the proxy of the
+// environment and trusts RootCAs.
+func Transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = http.ProxyFromEnvironment
+	t.TLSClientConfig = TLSConfig()
+	return t
+}
+
+// Client returns an HTTP client using Transport whose requests time out
+// after timeout.
+func Client(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: Transport(), Timeout: timeout}
+}
+
+// PublicTransport returns a transport for URLs that come from users, such
+// as webhooks. It refuses hosts that are not public, so nobody can make
+// the server send requests into its internal network; what names the
+// destination in its errors. Requests go through the proxy of the
+// environment like those of Transport. The proxy then connects to the
+// host, so its addresses are checked before the request is sent.
+func PublicTransport(what string) http.RoundTripper {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublic(net.ParseIP(host)) {
+				return fmt.Errorf("%s address %s is not public", what, host)
+			}
+			return nil
+		},
+	}
+	t := &publicTransport{
+		what:     what,
+		proxy:    http.ProxyFromEnvironment,
+		direct:   Transport(),
+		proxied:  Transport(),
+		resolver: net.DefaultResolver,
+	}
+	t.direct.Proxy = nil
+	t.direct.DialContext = dialer.DialContext
+	t.proxied.Proxy = func(req *http.Request) (*url.URL, error) { return t.proxy(req) }
+	return t
+}
+
+// publicTransport is the transport of PublicTransport.
+type publicTransport struct {
+	what string
+	// proxy picks the proxy of a request; tests replace it.
+	proxy    func(*http.Request) (*url.URL, error)
+	direct   *http.Transport
+	proxied  *http.Transport
+	resolver *net.Resolver
+}
+
+func (t *publicTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	proxy, err := t.proxy(req)
+	if err != nil {
+		return nil, err
+	}
+	if proxy == nil {
+		return t.direct.RoundTrip(req)
+	}
+	if err := t.checkHost(req.Context(), req.URL.Hostname()); err != nil {
+		return nil, err
+	}
+	return t.proxied.RoundTrip(req)
+}
+
+// checkHost fails unless every address of host is public.
+func (t *publicTransport) checkHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !isPublic(ip) {
+			return fmt.Errorf("%s address %s is not public", t.what, host)
+		}
+		return nil
+	}
+	addrs, err := t.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !isPublic(addr.IP) {
+			return fmt.Errorf("%s address %s of %s is not public", t.what, addr.IP, host)
+		}
+	}
+	return nil
+}
+
+// isPublic reports whether ip is a public unicast address.
+func isPublic(ip net.IP) bool {
+	return ip != nil && ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// Hint returns what to check when err is a failure to establish TLS with
+// a server or to reach it through a proxy, and "" for any other error.
+// Drivers that do not wrap their errors are recognised by the message.
+func Hint(err error) string {
+	if err == nil {
+		return ""
+	}
+	var (
+		authority x509.UnknownAuthorityError
+		hostname  x509.HostnameError
+		invalid   x509.CertificateInvalidError
+		header    tls.RecordHeaderError
+	)
+	msg := err.Error()
+	switch {
+	case errors.As(err, &authority) || strings.Contains(msg, "certificate signed by unknown authority"):
+		return "the server's certificate is not signed by a trusted CA; if a proxy intercepts TLS, set NCLIP_CA_BUNDLE to a PEM file with its CA certificate"
+	case errors.As(err, &hostname) || strings.Contains(msg, "certificate is valid for"):
+		return "the server's certificate does not name the host; check the endpoint, and NO_PROXY if a proxy answers for the host"
+	case errors.As(err, &invalid) || strings.Contains(msg, "certificate has expired or is not yet valid"):
+		return "the server's certificate is expired or not yet valid; check it and the system clock"
+	case errors.As(err, &header) || strings.Contains(msg, "first record does not look like a TLS handshake"):
+		return "the server did not answer with TLS; check the endpoint's scheme and port, and that HTTPS_PROXY names an http:// proxy unless the proxy speaks TLS"
+	case strings.Contains(msg, "proxyconnect"):
+		return "the proxy named by HTTPS_PROXY or HTTP_PROXY cannot be reached; check it, or list the host in NO_PROXY"
+	}
+	return ""
+}
+
+// Explain adds Hint to err, if it has one.
+func Explain(err error) error {
+	if hint := Hint(err); hint != "" {
+		return fmt.Errorf("%w; %s", err, hint)
+	}
+	return err
+}
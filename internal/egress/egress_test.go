@@ -0,0 +1,100 @@
+package egress
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCABundle(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	t.Cleanup(func() { roots.Store(nil) })
+
+	_, err := Client(5 * time.Second).Get(srv.URL)
+	if err == nil || !strings.Contains(Explain(err).Error(), "NCLIP_CA_BUNDLE") {
+		t.Fatalf("expected an untrusted certificate with a hint, got %v", Explain(err))
+	}
+
+	dir := t.TempDir()
+	bundle := filepath.Join(dir, "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(bundle, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := UseCABundle(bundle); n != 1 || err != nil {
+		t.Fatalf("UseCABundle = %d, %v", n, err)
+	}
+	resp, err := Client(5 * time.Second).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("expected the bundle to be trusted: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UseCABundle(empty); err == nil {
+		t.Error("expected a bundle without certificates to be rejected")
+	}
+	if n, err := UseCABundle(""); n != 0 || err != nil || RootCAs() == nil {
+		t.Errorf("an empty path should keep the loaded roots, got %d, %v", n, err)
+	}
+}
+
+func TestPublicTransport(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	tr := PublicTransport("webhook").(*publicTransport)
+	client := &http.Client{Transport: tr}
+
+	// Without a proxy the dialer refuses internal addresses.
+	tr.proxy = func(*http.Request) (*url.URL, error) { return nil, nil }
+	if _, err := client.Get(proxy.URL); err == nil || !strings.Contains(err.Error(), "webhook address 127.0.0.1 is not public") {
+		t.Errorf("expected a direct request to loopback to be refused, got %v", err)
+	}
+
+	// Through a proxy the host is checked before the request is sent.
+	tr.proxy = http.ProxyURL(proxyURL)
+	if _, err := client.Get("http://10.0.0.1/hook"); err == nil || !strings.Contains(err.Error(), "not public") {
+		t.Errorf("expected a proxied request to a private host to be refused, got %v", err)
+	}
+	resp, err := client.Get("http://203.0.113.7/hook")
+	if err != nil {
+		t.Fatalf("proxied request to a public host: %v", err)
+	}
+	_ = resp.Body.Close()
+	if len(proxied) != 1 || proxied[0] != "http://203.0.113.7/hook" {
+		t.Errorf("expected the request to go through the proxy, got %v", proxied)
+	}
+}
+
+func TestHint(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{errors.New("connection reset"), ""},
+		{fmt.Errorf("get: %w", errors.New("tls: failed to verify certificate: x509: certificate signed by unknown authority")), "NCLIP_CA_BUNDLE"},
+		{errors.New("proxyconnect tcp: dial tcp 10.0.0.8:3128: connect: connection refused"), "NO_PROXY"},
+	} {
+		if got := Hint(tc.err); tc.want == "" && got != "" || !strings.Contains(got, tc.want) {
+			t.Errorf("Hint(%v) = %q, want it to mention %q", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package emailin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+)
+
+// maxParts bounds how many MIME parts are looked at in one message.
+const maxParts = 50
+
+// Notification is an SES receipt notification published by an SNS action.
+type Notification struct {
+	NotificationType string `json:"notificationType"`
+	Mail             struct {
+		Source    string `json:"source"`
+		MessageID string `json:"messageId"`
+	} `json:"mail"`
+	Receipt struct {
+		SPFVerdict   Verdict `json:"spfVerdict"`
+		DKIMVerdict  Verdict `json:"dkimVerdict"`
+		DMARCVerdict Verdict `json:"dmarcVerdict"`
+		SpamVerdict  Verdict `json:"spamVerdict"`
+		VirusVerdict Verdict `json:"virusVerdict"`
+		Action       struct {
+			Type     string `json:"type"`
+			Encoding string `json:"encoding"`
+		} `json:"action"`
+	} `json:"receipt"`
+	// Content is the raw message. SNS actions only include messages up
+	// to 150 KB.
+	Content string `json:"content"`
+}
+
+// Verdict is an SES check result: PASS, FAIL, GRAY or PROCESSING_FAILED.
+type Verdict struct {
+	Status string `json:"status"`
+}
+
+// Authenticate checks the SES verdicts: SPF and DKIM must pass, DMARC must
+// not fail, and the message must not be spam or a virus. They authenticate
+// the envelope and signing domains only; AuthenticateFrom ties the From
+// address to them.
+func (n *Notification) Authenticate() error {
+	r := n.Receipt
+	switch {
+	case r.SPFVerdict.Status != "PASS":
+		return fmt.Errorf("SPF verdict %s", r.SPFVerdict.Status)
+	case r.DKIMVerdict.Status != "PASS":
+		return fmt.Errorf("DKIM verdict %s", r.DKIMVerdict.Status)
+	case r.DMARCVerdict.Status == "FAIL":
+		return errors.New("DMARC verdict FAIL")
+	case r.SpamVerdict.Status == "FAIL":
+		return errors.New("message is spam")
+	case r.VirusVerdict.Status == "FAIL":
+		return errors.New("message contains a virus")
+	}
+	return nil
+}
+
+// AuthenticateFrom checks that from, the message's From address, belongs
+// to an authenticated domain: DMARC must pass, or the From domain must be
+// aligned with the envelope sender's, which SPF verified, in that either
+// is the other or a subdomain of it. Otherwise a domain that passes SPF
+// and DKIM for itself could send as any address whose domain publishes no
+// DMARC policy.
+func (n *Notification) AuthenticateFrom(from string) error {
+	if n.Receipt.DMARCVerdict.Status == "PASS" {
+		return nil
+	}
+	fromDomain, envelopeDomain := domainOf(from), domainOf(n.Mail.Source)
+	if fromDomain == "" || envelopeDomain == "" ||
+		(fromDomain != envelopeDomain && !strings.HasSuffix(fromDomain, "."+envelopeDomain) && !strings.HasSuffix(envelopeDomain, "."+fromDomain)) {
+		return fmt.Errorf("From domain %q is not aligned with the envelope sender %q and DMARC did not pass", fromDomain, n.Mail.Source)
+	}
+	return nil
+}
+
+// domainOf returns the lower-cased domain of the address addr.
+func domainOf(addr string) string {
+	at := strings.LastIndex(addr, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(addr[at+1:]), ".")
+}
+
+// Raw returns the raw message, or an error when SES did not include it.
+func (n *Notification) Raw() ([]byte, error) {
+	if n.Content == "" {
+		return nil, errors.New("message content not included (larger than 150 KB?)")
+	}
+	if strings.EqualFold(n.Receipt.Action.Encoding, "BASE64") {
+		return base64.StdEncoding.DecodeString(n.Content)
+	}
+	return []byte(n.Content), nil
+}
+
+// Senders maps allowed sender addresses, or whole domains written as
+// "@example.com", to the owner identity their pastes are attributed to.
+type Senders map[string]string
+
+// ParseSenders parses a comma-separated list of SENDER or SENDER=OWNER
+// entries. Without an owner the sender address itself is used.
+func ParseSenders(s string) (Senders, error) {
+	senders := make(Senders)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		sender, owner, _ := strings.Cut(entry, "=")
+		sender = strings.ToLower(strings.TrimSpace(sender))
+		if !strings.Contains(sender, "@") || strings.HasSuffix(sender, "@") {
+			return nil, fmt.Errorf("invalid sender %q: want user@domain or @domain", sender)
+		}
+		senders[sender] = strings.TrimSpace(owner)
+	}
+	return senders, nil
+}
+
+// Owner returns the owner identity for addr and whether addr is allowed.
+// An exact address entry takes precedence over its domain's entry.
+func (s Senders) Owner(addr string) (string, bool) {
+	addr = strings.ToLower(addr)
+	owner, ok := s[addr]
+	if !ok {
+		if at := strings.LastIndex(addr, "@"); at >= 0 {
+			owner, ok = s[addr[at:]]
+		}
+	}
+	if !ok {
+		return "", false
+	}
+	if owner == "" {
+		owner = addr
+	}
+	return owner, true
+}
+
+// Part is a text body or attachment of a message.
+type Part struct {
+	Filename    string
+	ContentType string
+	Content     []byte
+}
+
+// Email is a parsed inbound message.
+type Email struct {
+	From    string
+	Subject string
+	// Body is the first text/plain part that is not an attachment.
+	Body []byte
+	// Attachments holds attachments up to the size limit; larger ones are
+	// listed by name in Skipped.
+	Attachments []Part
+	Skipped     []string
+}
+
+// Parse parses a raw message, keeping attachments of at most maxSize bytes.
+func Parse(raw []byte, maxSize int64) (*Email, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid From header: %w", err)
+	}
+	dec := new(mime.WordDecoder)
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+	e := &Email{From: from.Address, Subject: subject}
+	p := &parser{email: e, maxSize: maxSize}
+	if err := p.walk(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), "", msg.Body); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+type parser struct {
+	email   *Email
+	maxSize int64
+	parts   int
+}
+
+func (p *parser) walk(contentType, encoding, disposition string, body io.Reader) error {
+	if p.parts++; p.parts > maxParts {
+		return errors.New("too many MIME parts")
+	}
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "application/octet-stream", nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("invalid multipart body: %w", err)
+			}
+			if err := p.walk(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"),
+				part.Header.Get("Content-Disposition"), part); err != nil {
+				return err
+			}
+		}
+	}
+
+	filename := params["name"]
+	attachment := false
+	if disposition != "" {
+		if d, dparams, err := mime.ParseMediaType(disposition); err == nil {
+			attachment = d == "attachment"
+			if dparams["filename"] != "" {
+				filename = dparams["filename"]
+			}
+		}
+	}
+	if !attachment && filename == "" && mediaType == "text/plain" && p.email.Body == nil {
+		content, tooLarge, err := readPart(encoding, body, p.maxSize)
+		if err != nil {
+			return err
+		}
+		if tooLarge {
+			p.email.Skipped = append(p.email.Skipped, "message body")
+			content = []byte{}
+		}
+		p.email.Body = content
+		return nil
+	}
+	if !attachment && filename == "" {
+		// Alternative renderings (text/html) and inline images.
+		return nil
+	}
+
+	content, tooLarge, err := readPart(encoding, body, p.maxSize)
+	if err != nil {
+		return err
+	}
+	if tooLarge {
+		p.email.Skipped = append(p.email.Skipped, filename)
+		return nil
+	}
+	p.email.Attachments = append(p.email.Attachments, Part{Filename: filename, ContentType: mediaType, Content: content})
+	return nil
+}
+
+// readPart decodes a part body, reading at most maxSize decoded bytes. A
+// larger part is reported as tooLarge.
+func readPart(encoding string, body io.Reader, maxSize int64) (content []byte, tooLarge bool, err error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	content, err = io.ReadAll(io.LimitReader(body, maxSize+1))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decode MIME part: %w", err)
+	}
+	if int64(len(content)) > maxSize {
+		return nil, true, nil
+	}
+	return content, false, nil
+}
@@ -0,0 +1,145 @@
+package emailin
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+	pass := func() *Notification {
+		n := &Notification{}
+		n.Receipt.SPFVerdict.Status = "PASS"
+		n.Receipt.DKIMVerdict.Status = "PASS"
+		n.Receipt.DMARCVerdict.Status = "GRAY"
+		n.Receipt.SpamVerdict.Status = "PASS"
+		n.Receipt.VirusVerdict.Status = "PASS"
+		return n
+	}
+	if err := pass().Authenticate(); err != nil {
+		t.Fatalf("expected authenticated message, got %v", err)
+	}
+	for name, mutate := range map[string]func(*Notification){
+		"spf":   func(n *Notification) { n.Receipt.SPFVerdict.Status = "FAIL" },
+		"dkim":  func(n *Notification) { n.Receipt.DKIMVerdict.Status = "GRAY" },
+		"dmarc": func(n *Notification) { n.Receipt.DMARCVerdict.Status = "FAIL" },
+		"spam":  func(n *Notification) { n.Receipt.SpamVerdict.Status = "FAIL" },
+		"virus": func(n *Notification) { n.Receipt.VirusVerdict.Status = "FAIL" },
+	} {
+		n := pass()
+		mutate(n)
+		if err := n.Authenticate(); err == nil {
+			t.Errorf("%s: expected rejection", name)
+		}
+	}
+}
+
+func TestAuthenticateFrom(t *testing.T) {
+	for _, tc := range []struct {
+		dmarc, source, from string
+		ok                  bool
+	}{
+		{"GRAY", "bounce@example.com", "alice@example.com", true},
+		{"GRAY", "bounce@mail.example.com", "alice@Example.com", true},
+		{"GRAY", "bounce@example.com", "alice@eu.example.com", true},
+		{"GRAY", "bounce@attacker.org", "alice@example.com", false},
+		{"GRAY", "bounce@notexample.com", "alice@example.com", false},
+		{"GRAY", "", "alice@example.com", false},
+		{"PASS", "bounce@attacker.org", "alice@example.com", true},
+	} {
+		n := &Notification{}
+		n.Receipt.DMARCVerdict.Status = tc.dmarc
+		n.Mail.Source = tc.source
+		if err := n.AuthenticateFrom(tc.from); (err == nil) != tc.ok {
+			t.Errorf("DMARC %s, envelope %q, From %q: got %v", tc.dmarc, tc.source, tc.from, err)
+		}
+	}
+}
+
+func TestSenders(t *testing.T) {
+	senders, err := ParseSenders("Alice@Example.com=alice, @example.com, bob@other.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]string{
+		"alice@example.com": "alice",
+		"carol@example.com": "carol@example.com",
+		"BOB@other.org":     "bob@other.org",
+	} {
+		if owner, ok := senders.Owner(addr); !ok || owner != want {
+			t.Errorf("Owner(%q) = %q, %v; want %q", addr, owner, ok, want)
+		}
+	}
+	if _, ok := senders.Owner("mallory@other.org"); ok {
+		t.Error("unlisted sender allowed")
+	}
+	if _, err := ParseSenders("example.com"); err == nil {
+		t.Error("expected error for sender without @")
+	}
+}
+
+func TestParse(t *testing.T) {
+	big := strings.Repeat("x", 200)
+	raw := strings.Join([]string{
+		"From: Alice <alice@example.com>",
+		"Subject: =?utf-8?q?Logs_=E2=9C=93?=",
+		"MIME-Version: 1.0",
+		`Content-Type: multipart/mixed; boundary="outer"`,
+		"",
+		"--outer",
+		`Content-Type: multipart/alternative; boundary="inner"`,
+		"",
+		"--inner",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: quoted-printable",
+		"",
+		"see attached=",
+		" logs",
+		"--inner",
+		"Content-Type: text/html",
+		"",
+		"<p>see attached logs</p>",
+		"--inner--",
+		"--outer",
+		"Content-Type: application/octet-stream",
+		`Content-Disposition: attachment; filename="app.log"`,
+		"Content-Transfer-Encoding: base64",
+		"",
+		base64.StdEncoding.EncodeToString([]byte("line 1\nline 2\n")),
+		"--outer",
+		`Content-Type: text/plain; name="huge.txt"`,
+		"",
+		big,
+		"--outer--",
+		"",
+	}, "\r\n")
+
+	email, err := Parse([]byte(raw), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email.From != "alice@example.com" || email.Subject != "Logs ✓" {
+		t.Errorf("unexpected headers: from=%q subject=%q", email.From, email.Subject)
+	}
+	if string(email.Body) != "see attached logs" {
+		t.Errorf("unexpected body %q", email.Body)
+	}
+	if len(email.Attachments) != 1 || email.Attachments[0].Filename != "app.log" ||
+		string(email.Attachments[0].Content) != "line 1\nline 2\n" {
+		t.Errorf("unexpected attachments %+v", email.Attachments)
+	}
+	if len(email.Skipped) != 1 || email.Skipped[0] != "huge.txt" {
+		t.Errorf("expected huge.txt to be skipped, got %v", email.Skipped)
+	}
+}
+
+func TestRaw(t *testing.T) {
+	n := &Notification{Content: base64.StdEncoding.EncodeToString([]byte("From: a@b.c\r\n\r\nhi"))}
+	n.Receipt.Action.Encoding = "BASE64"
+	if raw, err := n.Raw(); err != nil || !strings.HasSuffix(string(raw), "hi") {
+		t.Errorf("Raw() = %q, %v", raw, err)
+	}
+	if _, err := (&Notification{}).Raw(); err == nil {
+		t.Error("expected error for missing content")
+	}
+}
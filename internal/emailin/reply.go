@@ -0,0 +1,49 @@
+package emailin
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+)
+
+// Replier sends the reply listing the created pastes.
+type Replier interface {
+	Reply(to, subject, body string) error
+}
+
+// SESReplier sends replies through Amazon SES.
+type SESReplier struct {
+	client *sesv2.Client
+	from   string
+}
+
+// NewSESReplier creates a replier sending from the verified SES identity
+// from, using the default AWS credential chain.
+func NewSESReplier(from string) (*SESReplier, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &SESReplier{client: sesv2.NewFromConfig(cfg), from: from}, nil
+}
+
+// Reply implements Replier.
+func (r *SESReplier) Reply(to, subject, body string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := r.client.SendEmail(ctx, &sesv2.SendEmailInput{
+		FromEmailAddress: aws.String(r.from),
+		Destination:      &types.Destination{ToAddresses: []string{to}},
+		Content: &types.EmailContent{
+			Simple: &types.Message{
+				Subject: &types.Content{Data: aws.String(subject)},
+				Body:    &types.Body{Text: &types.Content{Data: aws.String(body)}},
+			},
+		},
+	})
+	return err
+}
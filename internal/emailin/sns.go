@@ -0,0 +1,259 @@
+// Package emailin turns inbound email, delivered by Amazon SES through an
+// SNS topic, into pastes: it verifies the SNS delivery, checks the SES
+// SPF/DKIM/DMARC verdicts and the sender allowlist, and parses the MIME
+// message into a body and attachments.
+package emailin
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1" // #nosec G505 -- SNS SignatureVersion 1 is defined as SHA1withRSA
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/egress"
+)
+
+// SNS message types.
+const (
+	TypeNotification             = "Notification"
+	TypeSubscriptionConfirmation = "SubscriptionConfirmation"
+	TypeUnsubscribeConfirmation  = "UnsubscribeConfirmation"
+)
+
+// MaxMessageAge bounds how old a delivery may be, so a captured request
+// cannot be replayed later to create pastes again.
+const MaxMessageAge = time.Hour
+
+var (
+	// ErrInvalidSignature is returned for deliveries not signed by SNS.
+	ErrInvalidSignature = errors.New("invalid SNS message signature")
+	// ErrWrongTopic is returned for deliveries from another topic.
+	ErrWrongTopic = errors.New("SNS message is from an unexpected topic")
+	// ErrStale is returned for deliveries older than MaxMessageAge.
+	ErrStale = errors.New("SNS message is too old")
+)
+
+// snsHost matches the hosts SNS serves signing certificates and
+// subscription URLs from.
+var snsHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// Message is an SNS HTTP(S) delivery.
+type Message struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+	SubscribeURL     string `json:"SubscribeURL"`
+}
+
+// stringToSign builds the canonical form SNS signs for m's type.
+func (m *Message) stringToSign() string {
+	var b strings.Builder
+	add := func(key, value string) {
+		b.WriteString(key + "\n" + value + "\n")
+	}
+	add("Message", m.Message)
+	add("MessageId", m.MessageID)
+	if m.Type == TypeNotification {
+		if m.Subject != "" {
+			add("Subject", m.Subject)
+		}
+		add("Timestamp", m.Timestamp)
+		add("TopicArn", m.TopicArn)
+		add("Type", m.Type)
+		return b.String()
+	}
+	add("SubscribeURL", m.SubscribeURL)
+	add("Timestamp", m.Timestamp)
+	add("Token", m.Token)
+	add("TopicArn", m.TopicArn)
+	add("Type", m.Type)
+	return b.String()
+}
+
+// ReplayStore remembers handled messages for several instances, such as
+// a Redis server.
+type ReplayStore interface {
+	// UseMessage records id as handled until expires and reports whether
+	// it was not handled before.
+	UseMessage(id string, expires time.Time) (bool, error)
+}
+
+// Verifier checks SNS deliveries for one topic. Signing certificates are
+// fetched from SNS on first use and cached. It is safe for concurrent use.
+type Verifier struct {
+	topicARN string
+	client   *http.Client
+	// fetch downloads url; tests replace it.
+	fetch  func(url string) ([]byte, error)
+	now    func() time.Time
+	replay ReplayStore
+
+	mu    sync.Mutex
+	certs map[string]*x509.Certificate
+	// seen holds the ids of handled messages until they expire, unless a
+	// ReplayStore is set.
+	seen map[string]time.Time
+}
+
+// NewVerifier creates a Verifier accepting deliveries from topicARN.
+func NewVerifier(topicARN string) *Verifier {
+	v := &Verifier{
+		topicARN: topicARN,
+		client:   egress.Client(10 * time.Second),
+		now:      time.Now,
+		certs:    make(map[string]*x509.Certificate),
+		seen:     make(map[string]time.Time),
+	}
+	v.fetch = v.get
+	return v
+}
+
+// SetReplayStore remembers handled messages in r instead of in memory.
+func (v *Verifier) SetReplayStore(r ReplayStore) {
+	v.replay = r
+}
+
+// First records the message id as handled and reports whether it was not
+// handled before. SNS delivers at least once and redelivers when a
+// response is slow, so one email may arrive several times. Ids are kept
+// for MaxMessageAge, after which Verify rejects redeliveries as stale.
+func (v *Verifier) First(id string) (bool, error) {
+	now := v.now()
+	if v.replay != nil {
+		return v.replay.UseMessage(id, now.Add(MaxMessageAge))
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for seen, expires := range v.seen {
+		if now.After(expires) {
+			delete(v.seen, seen)
+		}
+	}
+	if _, ok := v.seen[id]; ok {
+		return false, nil
+	}
+	v.seen[id] = now.Add(MaxMessageAge)
+	return true, nil
+}
+
+// Verify checks that m was signed by SNS for the configured topic.
+func (v *Verifier) Verify(m *Message) error {
+	if m.TopicArn != v.topicARN {
+		return ErrWrongTopic
+	}
+	ts, err := time.Parse(time.RFC3339, m.Timestamp)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if v.now().Sub(ts) > MaxMessageAge {
+		return ErrStale
+	}
+	var hash crypto.Hash
+	switch m.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return ErrInvalidSignature
+	}
+	sig, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	cert, err := v.cert(m.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return ErrInvalidSignature
+	}
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum([]byte(m.stringToSign())) // #nosec G401 -- required by SignatureVersion 1
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(m.stringToSign()))
+		digest = sum[:]
+	}
+	if err := rsa.VerifyPKCS1v15(key, hash, digest, sig); err != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Confirm confirms a verified SubscriptionConfirmation by visiting its
+// SubscribeURL.
+func (v *Verifier) Confirm(m *Message) error {
+	if !isSNSURL(m.SubscribeURL) {
+		return fmt.Errorf("unexpected SubscribeURL %q", m.SubscribeURL)
+	}
+	_, err := v.fetch(m.SubscribeURL)
+	return err
+}
+
+func (v *Verifier) cert(certURL string) (*x509.Certificate, error) {
+	if !isSNSURL(certURL) || !strings.HasSuffix(certURL, ".pem") {
+		return nil, fmt.Errorf("%w: untrusted signing certificate URL %q", ErrInvalidSignature, certURL)
+	}
+	v.mu.Lock()
+	cert, ok := v.certs[certURL]
+	v.mu.Unlock()
+	if ok {
+		return cert, nil
+	}
+	data, err := v.fetch(certURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch SNS signing certificate: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: signing certificate is not PEM", ErrInvalidSignature)
+	}
+	cert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	v.mu.Lock()
+	v.certs[certURL] = cert
+	v.mu.Unlock()
+	return cert, nil
+}
+
+func (v *Verifier) get(u string) ([]byte, error) {
+	resp, err := v.client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+}
+
+// isSNSURL reports whether raw is an https URL on an SNS host.
+func isSNSURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme == "https" && snsHost.MatchString(u.Host)
+}
@@ -0,0 +1,175 @@
+package emailin
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+const (
+	testTopic   = "arn:aws:sns:us-east-1:123456789012:nclip-mail"
+	testCertURL = "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-test.pem"
+)
+
+// testVerifier returns a Verifier whose certificate fetches are served
+// from a freshly generated key, and a function that signs messages with it.
+func testVerifier(t *testing.T, now time.Time) (*Verifier, func(*Message)) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	v := NewVerifier(testTopic)
+	v.now = func() time.Time { return now }
+	fetches := 0
+	v.fetch = func(url string) ([]byte, error) {
+		if url != testCertURL {
+			return nil, errors.New("unexpected fetch of " + url)
+		}
+		fetches++
+		if fetches > 1 {
+			t.Errorf("certificate fetched %d times, want it cached", fetches)
+		}
+		return certPEM, nil
+	}
+	sign := func(m *Message) {
+		sum := sha256.Sum256([]byte(m.stringToSign()))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.SignatureVersion = "2"
+		m.SigningCertURL = testCertURL
+		m.Signature = base64.StdEncoding.EncodeToString(sig)
+	}
+	return v, sign
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	v, sign := testVerifier(t, now)
+	newMessage := func() *Message {
+		m := &Message{
+			Type:      TypeNotification,
+			MessageID: "msg-1",
+			TopicArn:  testTopic,
+			Message:   `{"notificationType":"Received"}`,
+			Timestamp: now.Add(-time.Minute).Format(time.RFC3339),
+		}
+		sign(m)
+		return m
+	}
+
+	if err := v.Verify(newMessage()); err != nil {
+		t.Fatalf("valid message rejected: %v", err)
+	}
+
+	tampered := newMessage()
+	tampered.Message = `{"notificationType":"Bounce"}`
+	if err := v.Verify(tampered); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("tampered message: expected ErrInvalidSignature, got %v", err)
+	}
+
+	wrongTopic := newMessage()
+	wrongTopic.TopicArn = "arn:aws:sns:us-east-1:123456789012:other"
+	if err := v.Verify(wrongTopic); !errors.Is(err, ErrWrongTopic) {
+		t.Errorf("wrong topic: expected ErrWrongTopic, got %v", err)
+	}
+
+	stale := newMessage()
+	stale.Timestamp = now.Add(-2 * MaxMessageAge).Format(time.RFC3339)
+	sign(stale)
+	if err := v.Verify(stale); !errors.Is(err, ErrStale) {
+		t.Errorf("stale message: expected ErrStale, got %v", err)
+	}
+
+	for _, certURL := range []string{
+		"http://sns.us-east-1.amazonaws.com/cert.pem",
+		"https://sns.us-east-1.amazonaws.com.evil.example/cert.pem",
+		"https://evil.example/sns.us-east-1.amazonaws.com.pem",
+	} {
+		m := newMessage()
+		m.SigningCertURL = certURL
+		if err := v.Verify(m); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("cert URL %s: expected ErrInvalidSignature, got %v", certURL, err)
+		}
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	v := NewVerifier(testTopic)
+	var visited string
+	v.fetch = func(url string) ([]byte, error) {
+		visited = url
+		return nil, nil
+	}
+	good := "https://sns.eu-west-1.amazonaws.com/?Action=ConfirmSubscription&Token=abc"
+	if err := v.Confirm(&Message{SubscribeURL: good}); err != nil || visited != good {
+		t.Errorf("Confirm: err=%v visited=%q", err, visited)
+	}
+	if err := v.Confirm(&Message{SubscribeURL: "https://example.com/confirm"}); err == nil {
+		t.Error("Confirm accepted a non-SNS SubscribeURL")
+	}
+}
+
+// fakeReplay is a ReplayStore that fails while err is set.
+type fakeReplay struct {
+	used map[string]bool
+	err  error
+}
+
+func (f *fakeReplay) UseMessage(id string, _ time.Time) (bool, error) {
+	if f.err != nil {
+		return false, f.err
+	}
+	first := !f.used[id]
+	f.used[id] = true
+	return first, nil
+}
+
+func TestFirst(t *testing.T) {
+	v := NewVerifier(testTopic)
+	now := time.Unix(1700000000, 0)
+	v.now = func() time.Time { return now }
+	if first, err := v.First("m1"); err != nil || !first {
+		t.Fatalf("expected the first delivery, got %v, %v", first, err)
+	}
+	if first, _ := v.First("m1"); first {
+		t.Error("expected the redelivery detected")
+	}
+	now = now.Add(MaxMessageAge + time.Second)
+	if first, _ := v.First("m2"); !first || len(v.seen) != 1 {
+		t.Errorf("expected expired ids forgotten, got %d", len(v.seen))
+	}
+
+	replay := &fakeReplay{used: map[string]bool{"m3": true}}
+	v.SetReplayStore(replay)
+	if first, _ := v.First("m3"); first {
+		t.Error("expected the replay store consulted")
+	}
+	replay.err = errors.New("unavailable")
+	if _, err := v.First("m4"); err == nil {
+		t.Error("expected the replay store's error")
+	}
+}
@@ -0,0 +1,179 @@
+// Package forwarded honors the path prefix a reverse proxy strips before
+// passing requests on, as when https://example.com/tools/nclip/raw/ABC
+// reaches nclip as /raw/ABC, so the URLs and links nclip generates still
+// go through the proxy. The prefix comes from X-Forwarded-Prefix or the
+// path parameter of Forwarded, and only from trusted proxies: anyone else
+// could otherwise make nclip hand out links to paths of their choosing.
+package forwarded
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxPrefixLength bounds the prefixes taken from headers.
+const maxPrefixLength = 256
+
+// contextKey is the gin context key of the prefix.
+const contextKey = "forwarded.prefix"
+
+// Proxies is a set of trusted proxy addresses. The zero value and nil
+// trust no one.
+type Proxies struct {
+	list     []string
+	prefixes []netip.Prefix
+}
+
+// ParseProxies parses a comma-separated list of IP addresses and CIDR
+// ranges, such as "10.0.0.0/8, 192.0.2.7".
+func ParseProxies(s string) (*Proxies, error) {
+	p := &Proxies{}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(field)
+			if aerr != nil {
+				return nil, fmt.Errorf("invalid proxy %q: want an IP address or CIDR range", field)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		p.list = append(p.list, field)
+		p.prefixes = append(p.prefixes, prefix.Masked())
+	}
+	return p, nil
+}
+
+// List returns the addresses and ranges as given, e.g. for
+// gin.Engine.SetTrustedProxies.
+func (p *Proxies) List() []string {
+	if p == nil {
+		return nil
+	}
+	return p.list
+}
+
+// Trusts reports whether remoteAddr, an address with or without a port,
+// belongs to a trusted proxy.
+func (p *Proxies) Trusts(remoteAddr string) bool {
+	if p == nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Prefix returns the path prefix header announces, without a trailing
+// slash, or "" when there is none or it is not a clean path. Proxies that
+// each strip a prefix list them outermost first, and they are joined.
+func Prefix(header http.Header) string {
+	var parts []string
+	if v := header.Get("X-Forwarded-Prefix"); v != "" {
+		parts = strings.Split(v, ",")
+	} else {
+		parts = forwardedPaths(header.Values("Forwarded"))
+	}
+	var b strings.Builder
+	for _, part := range parts {
+		part = strings.TrimRight(strings.TrimSpace(part), "/")
+		if part == "" {
+			continue
+		}
+		if !validPrefix(part) {
+			return ""
+		}
+		b.WriteString(part)
+	}
+	if b.Len() > maxPrefixLength {
+		return ""
+	}
+	return b.String()
+}
+
+// forwardedPaths returns the path parameters of the elements of Forwarded
+// header values.
+func forwardedPaths(values []string) []string {
+	var paths []string
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(name, "path") {
+					paths = append(paths, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	return paths
+}
+
+// validPrefix reports whether p is a clean absolute path of the
+// characters route prefixes may use.
+func validPrefix(p string) bool {
+	if !strings.HasPrefix(p, "/") || path.Clean(p) != p {
+		return false
+	}
+	for _, r := range p {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("/-_.~", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware records the prefix of requests from trusted proxies, for
+// PathPrefix and for the templates rendered to the response (see Writer).
+func Middleware(proxies *Proxies) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !proxies.Trusts(c.Request.RemoteAddr) {
+			return
+		}
+		if prefix := Prefix(c.Request.Header); prefix != "" {
+			c.Set(contextKey, prefix)
+			c.Writer = &Writer{ResponseWriter: c.Writer, prefix: prefix}
+		}
+	}
+}
+
+// PathPrefix returns the prefix the proxy stripped from the request, or
+// "". URLs for the client go below it, in front of the route prefix.
+func PathPrefix(c *gin.Context) string {
+	return c.GetString(contextKey)
+}
+
+// Writer is the response writer of requests with a prefix, letting
+// renderers that only see the writer find it.
+type Writer struct {
+	gin.ResponseWriter
+	prefix string
+}
+
+// PathPrefix returns the prefix the proxy stripped from the request.
+func (w *Writer) PathPrefix() string {
+	return w.prefix
+}
@@ -0,0 +1,83 @@
+package forwarded
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseProxies(t *testing.T) {
+	p, err := ParseProxies(" 10.0.0.0/8, 192.0.2.7 ,2001:db8::/32")
+	if err != nil {
+		t.Fatalf("ParseProxies: %v", err)
+	}
+	for addr, want := range map[string]bool{
+		"10.1.2.3:443":        true,
+		"192.0.2.7:80":        true,
+		"192.0.2.8:80":        false,
+		"[2001:db8::1]:8080":  true,
+		"[::ffff:10.0.0.1]:1": true,
+		"203.0.113.1":         false,
+		"not an address":      false,
+	} {
+		if got := p.Trusts(addr); got != want {
+			t.Errorf("Trusts(%q) = %v, want %v", addr, got, want)
+		}
+	}
+	if _, err := ParseProxies("10.0.0.0/8,proxy.internal"); err == nil {
+		t.Error("expected a host name to be rejected")
+	}
+	var none *Proxies
+	if none.Trusts("10.0.0.1:1") {
+		t.Error("expected a nil set to trust no one")
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	for _, tt := range []struct {
+		header http.Header
+		want   string
+	}{
+		{http.Header{"X-Forwarded-Prefix": {"/tools/nclip/"}}, "/tools/nclip"},
+		{http.Header{"X-Forwarded-Prefix": {"/tools, /nclip"}}, "/tools/nclip"},
+		{http.Header{"X-Forwarded-Prefix": {"/"}}, ""},
+		{http.Header{"X-Forwarded-Prefix": {"/a/../admin"}}, ""},
+		{http.Header{"X-Forwarded-Prefix": {"//evil.example"}}, ""},
+		{http.Header{"X-Forwarded-Prefix": {"/a\"><script>"}}, ""},
+		{http.Header{"X-Forwarded-Prefix": {"tools"}}, ""},
+		{http.Header{"Forwarded": {`for=192.0.2.60;proto=https;path="/tools/nclip"`}}, "/tools/nclip"},
+		{http.Header{"Forwarded": {"for=192.0.2.60;proto=https"}}, ""},
+		{http.Header{"X-Forwarded-Prefix": {"/x"}, "Forwarded": {"path=/y"}}, "/x"},
+	} {
+		if got := Prefix(tt.header); got != tt.want {
+			t.Errorf("Prefix(%v) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	proxies, _ := ParseProxies("192.0.2.0/24")
+	router := gin.New()
+	router.Use(Middleware(proxies))
+	router.GET("/", func(c *gin.Context) {
+		w, _ := c.Writer.(*Writer)
+		if got := PathPrefix(c); w == nil && got != "" || w != nil && w.PathPrefix() != got {
+			t.Errorf("writer and context disagree on the prefix %q", got)
+		}
+		c.String(http.StatusOK, PathPrefix(c))
+	})
+
+	for remote, want := range map[string]string{"192.0.2.10:5000": "/tools", "203.0.113.5:5000": ""} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-Prefix", "/tools")
+		router.ServeHTTP(w, req)
+		if w.Body.String() != want {
+			t.Errorf("request from %s: expected prefix %q, got %q", remote, want, w.Body.String())
+		}
+	}
+}
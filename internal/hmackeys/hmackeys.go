@@ -0,0 +1,149 @@
+// Package hmackeys holds the HMAC keys that sign share links, manage
+// tokens, upload links, direct upload tickets, session cookies, CSRF
+// tokens and proof-of-work challenges. New signatures use the current key
+// and name it by a key ID, "<id>~<mac>"; signatures made with a previous
+// key keep verifying until that key is dropped, so a secret can be
+// rotated without breaking links that are already out.
+package hmackeys
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"strings"
+)
+
+// idSeparator ends the key ID in a signature. It is outside the base64url
+// alphabet and needs no escaping in URLs or cookies.
+const idSeparator = "~"
+
+// idLength is the length of a key ID in characters.
+const idLength = 8
+
+type key struct {
+	id     string
+	secret []byte
+}
+
+// Ring signs with its current key and verifies with it and the previous
+// keys. Unlike keyring.Keyring, which encrypts content at rest, its key
+// IDs are derived from the secrets.
+type Ring struct {
+	keys      []key
+	ephemeral bool
+}
+
+// New creates a Ring signing with current and still accepting
+// signatures of the previous secrets. An empty current secret is replaced
+// by a random per-process key, see Ephemeral. Empty and repeated previous
+// secrets are skipped.
+func New(current string, previous []string) *Ring {
+	k := &Ring{}
+	if current == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Printf("[ERROR] failed to generate signing key: %v", err)
+		}
+		current, k.ephemeral = string(secret), true
+	}
+	seen := map[string]bool{}
+	for _, s := range append([]string{current}, previous...) {
+		if s == "" || seen[s] {
+			continue
+		}
+		seen[s] = true
+		k.keys = append(k.keys, key{id: KeyID(s), secret: []byte(s)})
+	}
+	return k
+}
+
+// Parse splits a comma-separated list of secrets, as in
+// NCLIP_SESSION_SECRET_PREVIOUS.
+func Parse(list string) []string {
+	var secrets []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			secrets = append(secrets, s)
+		}
+	}
+	return secrets
+}
+
+// KeyID returns the ID naming secret in signatures. It is derived from
+// the secret, so every instance configured alike agrees on it without
+// revealing the secret.
+func KeyID(secret string) string {
+	sum := sha256.Sum256([]byte("nclip-key-id:" + secret))
+	return base64.RawURLEncoding.EncodeToString(sum[:])[:idLength]
+}
+
+// Ephemeral reports whether the current key was generated at startup, so
+// signatures do not survive a restart and other instances reject them.
+func (k *Ring) Ephemeral() bool {
+	return k.ephemeral
+}
+
+// ID returns the ID of the current key.
+func (k *Ring) ID() string {
+	return k.keys[0].id
+}
+
+// Previous returns the number of previous keys still accepted.
+func (k *Ring) Previous() int {
+	return len(k.keys) - 1
+}
+
+// Sign returns the signature of msg with the current key,
+// "<id>~<base64url mac>".
+func (k *Ring) Sign(msg string) string {
+	return k.keys[0].id + idSeparator + base64.RawURLEncoding.EncodeToString(k.MAC(msg))
+}
+
+// Verify reports whether sig is a signature of msg by one of the keys. A
+// signature without a key ID, as made before keys could be rotated, is
+// checked against every key.
+func (k *Ring) Verify(msg, sig string) bool {
+	id, encoded, named := strings.Cut(sig, idSeparator)
+	if !named {
+		id, encoded = "", sig
+	}
+	got, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+	return k.verify(id, msg, got)
+}
+
+// MAC returns the bare HMAC-SHA256 of msg with the current key, for
+// values that are bound to another signed value and keep their own
+// encoding, such as CSRF tokens.
+func (k *Ring) MAC(msg string) []byte {
+	return mac(k.keys[0].secret, msg)
+}
+
+// VerifyMAC reports whether sum is the MAC of msg by one of the keys.
+func (k *Ring) VerifyMAC(msg string, sum []byte) bool {
+	return k.verify("", msg, sum)
+}
+
+// verify checks sum against the key named id, or every key when id is
+// empty.
+func (k *Ring) verify(id, msg string, sum []byte) bool {
+	for _, key := range k.keys {
+		if id != "" && key.id != id {
+			continue
+		}
+		if hmac.Equal(sum, mac(key.secret, msg)) {
+			return true
+		}
+	}
+	return false
+}
+
+func mac(secret []byte, msg string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(msg))
+	return h.Sum(nil)
+}
@@ -0,0 +1,70 @@
+package hmackeys
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestRing_Rotation(t *testing.T) {
+	old := New("old-secret", nil)
+	sig := old.Sign("share:ABCDE:1")
+	if !strings.HasPrefix(sig, KeyID("old-secret")+"~") {
+		t.Fatalf("expected the signature to name its key, got %q", sig)
+	}
+
+	rotated := New("new-secret", []string{"old-secret"})
+	if !rotated.Verify("share:ABCDE:1", sig) {
+		t.Error("expected a signature of the previous key to verify")
+	}
+	if rotated.Verify("share:ABCDE:2", sig) {
+		t.Error("expected the signature to be bound to its message")
+	}
+	if !strings.HasPrefix(rotated.Sign("share:ABCDE:1"), rotated.ID()+"~") || rotated.ID() != KeyID("new-secret") {
+		t.Error("expected new signatures to use the current key")
+	}
+	if New("new-secret", nil).Verify("share:ABCDE:1", sig) {
+		t.Error("expected the signature to be rejected once its key is dropped")
+	}
+
+	// A key ID only selects the key; another key's MAC under it fails.
+	forged := rotated.ID() + sig[strings.Index(sig, "~"):]
+	if rotated.Verify("share:ABCDE:1", forged) {
+		t.Error("expected a signature under the wrong key ID to be rejected")
+	}
+}
+
+func TestRing_Legacy(t *testing.T) {
+	h := hmac.New(sha256.New, []byte("old-secret"))
+	h.Write([]byte("upload-link:payload"))
+	legacy := base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+
+	k := New("new-secret", []string{"old-secret"})
+	if !k.Verify("upload-link:payload", legacy) {
+		t.Error("expected a signature without a key ID to be checked against every key")
+	}
+	if !k.VerifyMAC("upload-link:payload", h.Sum(nil)) {
+		t.Error("expected VerifyMAC to accept the previous key's MAC")
+	}
+	for _, sig := range []string{"", "~", "not base64!", legacy + "x"} {
+		if k.Verify("upload-link:payload", sig) {
+			t.Errorf("expected %q to be rejected", sig)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	k := New("", nil)
+	if !k.Ephemeral() || !k.Verify("msg", k.Sign("msg")) {
+		t.Error("expected a working random key without a secret")
+	}
+	if New("", nil).Verify("msg", k.Sign("msg")) {
+		t.Error("expected random keys to differ")
+	}
+	k = New("current", Parse(" old , current,,older "))
+	if k.Ephemeral() || k.Previous() != 2 {
+		t.Errorf("expected 2 previous keys without the current one and blanks, got %d", k.Previous())
+	}
+}
@@ -0,0 +1,226 @@
+// Package hotkeys finds the most-read slugs over sliding windows, so a
+// paste hotlinked like a CDN asset stands out. Each window is summarized
+// with the space-saving algorithm, which keeps a fixed number of counters
+// however many slugs are read.
+package hotkeys
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// BucketWidth is the time each summary covers. Windows are made of
+	// whole buckets, the newest of which is still filling, so a window
+	// covers between its length minus BucketWidth and its length.
+	BucketWidth = 10 * time.Second
+	// MaxWindow is the longest window that can be queried.
+	MaxWindow = time.Hour
+
+	buckets = int64(MaxWindow / BucketWidth)
+)
+
+// Windows lists the windows served by the API and exported as metrics.
+var Windows = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour}
+
+// ParseWindow parses s as one of Windows.
+func ParseWindow(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err == nil {
+		for _, w := range Windows {
+			if d == w {
+				return d, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("window must be one of 1m, 5m, 15m or 1h, got %q", s)
+}
+
+// WindowName formats a window as it is written in ParseWindow, e.g. "5m".
+func WindowName(d time.Duration) string {
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+	return fmt.Sprintf("%dm", d/time.Minute)
+}
+
+// Entry is a slug's estimated reads over a window. Reads never
+// undercounts; Reads - Error never overcounts.
+type Entry struct {
+	Slug  string `json:"slug"`
+	Reads int64  `json:"reads"`
+	Error int64  `json:"error"`
+}
+
+// Tracker counts reads by slug. It is safe for concurrent use.
+type Tracker struct {
+	capacity int
+	now      func() time.Time
+	reads    *prometheus.CounterVec
+
+	mu      sync.Mutex
+	buckets [buckets]bucket
+}
+
+// bucket is the summary of one BucketWidth of reads, identified by its
+// start in BucketWidth units since the Unix epoch.
+type bucket struct {
+	epoch int64
+	summary
+}
+
+// New returns a Tracker keeping capacity counters per bucket. Slugs read
+// less often than the capacity-th most-read one in a bucket may be missed.
+func New(capacity int) *Tracker {
+	return &Tracker{
+		capacity: capacity,
+		now:      time.Now,
+		reads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "paste_reads_total",
+			Help: "Paste reads by kind, with the slug read as exemplar.",
+		}, []string{"kind"}),
+	}
+}
+
+// Capacity returns the counters kept per bucket.
+func (t *Tracker) Capacity() int {
+	return t.capacity
+}
+
+// Observe records a read of slug; kind labels it in paste_reads_total.
+func (t *Tracker) Observe(slug, kind string) {
+	if c, ok := t.reads.WithLabelValues(kind).(prometheus.ExemplarAdder); ok {
+		c.AddWithExemplar(1, prometheus.Labels{"slug": slug})
+	}
+	epoch := t.now().UnixNano() / int64(BucketWidth)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := &t.buckets[epoch%buckets]
+	if b.epoch != epoch || b.counters == nil {
+		b.epoch = epoch
+		b.summary = newSummary(t.capacity)
+	}
+	b.add(slug)
+}
+
+// Top returns up to n of the most-read slugs over window, most-read first.
+func (t *Tracker) Top(window time.Duration, n int) []Entry {
+	now := t.now().UnixNano() / int64(BucketWidth)
+	span := int64(min(window, MaxWindow) / BucketWidth)
+	merged := map[string]*Entry{}
+	var mins int64
+	t.mu.Lock()
+	for i := range t.buckets {
+		b := &t.buckets[i]
+		if b.counters == nil || b.epoch <= now-span || b.epoch > now {
+			continue
+		}
+		// A slug missing from a full bucket may have been read up to its
+		// smallest count there.
+		low := b.min()
+		mins += low
+		for slug, c := range b.counters {
+			e := merged[slug]
+			if e == nil {
+				e = &Entry{Slug: slug}
+				merged[slug] = e
+			}
+			e.Reads += c.count - low
+			e.Error += c.err - low
+		}
+	}
+	t.mu.Unlock()
+
+	entries := make([]Entry, 0, len(merged))
+	for _, e := range merged {
+		e.Reads += mins
+		e.Error += mins
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Reads != entries[j].Reads {
+			return entries[i].Reads > entries[j].Reads
+		}
+		return entries[i].Slug < entries[j].Slug
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// summary is a space-saving summary: once all counters are taken, a new
+// slug takes over the smallest one, inheriting its count as error.
+type summary struct {
+	capacity int
+	counters map[string]*counter
+	heap     counterHeap
+}
+
+type counter struct {
+	slug  string
+	count int64
+	err   int64
+	index int
+}
+
+func newSummary(capacity int) summary {
+	return summary{capacity: capacity, counters: make(map[string]*counter, capacity)}
+}
+
+func (s *summary) add(slug string) {
+	if c, ok := s.counters[slug]; ok {
+		c.count++
+		heap.Fix(&s.heap, c.index)
+		return
+	}
+	if len(s.heap) < s.capacity {
+		c := &counter{slug: slug, count: 1}
+		s.counters[slug] = c
+		heap.Push(&s.heap, c)
+		return
+	}
+	c := s.heap[0]
+	delete(s.counters, c.slug)
+	c.slug, c.err = slug, c.count
+	c.count++
+	s.counters[slug] = c
+	heap.Fix(&s.heap, 0)
+}
+
+// min returns the smallest count of a full summary, the most a slug not in
+// it can have been read; 0 while counters are free.
+func (s *summary) min() int64 {
+	if len(s.heap) < s.capacity {
+		return 0
+	}
+	return s.heap[0].count
+}
+
+// counterHeap is a min-heap of counters by count.
+type counterHeap []*counter
+
+func (h counterHeap) Len() int           { return len(h) }
+func (h counterHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h counterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *counterHeap) Push(x any) {
+	c := x.(*counter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *counterHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
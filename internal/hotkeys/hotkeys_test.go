@@ -0,0 +1,100 @@
+package hotkeys
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestTracker_Top(t *testing.T) {
+	tr := New(10)
+	now := time.Unix(1700000000, 0)
+	tr.now = func() time.Time { return now }
+
+	// One slug is read 500 times among 2000 reads of distinct slugs, far
+	// more than the tracker has counters for.
+	truth := map[string]int64{}
+	for i := 0; i < 2000; i++ {
+		slug := fmt.Sprintf("S%d", i)
+		if i%4 == 0 {
+			slug = "HOTAB"
+		}
+		truth[slug]++
+		tr.Observe(slug, "raw")
+	}
+	top := tr.Top(time.Minute, 3)
+	if len(top) != 3 || top[0].Slug != "HOTAB" {
+		t.Fatalf("expected HOTAB first, got %+v", top)
+	}
+	for _, e := range top {
+		if e.Reads < truth[e.Slug] || e.Reads-e.Error > truth[e.Slug] {
+			t.Errorf("%s: estimate %d (error %d) does not bound the true count %d", e.Slug, e.Reads, e.Error, truth[e.Slug])
+		}
+	}
+
+	// Reads in later buckets add up; reads older than the window drop out.
+	now = now.Add(2 * BucketWidth)
+	tr.Observe("WARMC", "view")
+	tr.Observe("WARMC", "view")
+	if top := tr.Top(time.Minute, 1); top[0].Slug != "HOTAB" || top[0].Reads < 500 {
+		t.Errorf("expected HOTAB still first within the minute, got %+v", top)
+	}
+	now = now.Add(time.Minute - BucketWidth)
+	top = tr.Top(time.Minute, 10)
+	if len(top) != 1 || top[0].Slug != "WARMC" || top[0].Reads != 2 || top[0].Error != 0 {
+		t.Errorf("expected only WARMC's exact count in the last minute, got %+v", top)
+	}
+	if top := tr.Top(5*time.Minute, 1); top[0].Slug != "HOTAB" {
+		t.Errorf("expected HOTAB first over 5m, got %+v", top)
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	for _, w := range Windows {
+		if got, err := ParseWindow(WindowName(w)); err != nil || got != w {
+			t.Errorf("ParseWindow(%q) = %s, %v", WindowName(w), got, err)
+		}
+	}
+	if _, err := ParseWindow("2m"); err == nil {
+		t.Error("expected an error for a window that is not tracked")
+	}
+}
+
+func TestCollector(t *testing.T) {
+	tr := New(10)
+	tr.Observe("HOTAB", "raw")
+	tr.Observe("HOTAB", "raw")
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(tr.Collector())
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	var exemplar string
+	hot := map[string]float64{}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			switch f.GetName() {
+			case "paste_reads_total":
+				for _, l := range m.GetCounter().GetExemplar().GetLabel() {
+					exemplar = l.GetName() + "=" + l.GetValue()
+				}
+			case "paste_hot_reads":
+				labels := map[string]string{}
+				for _, l := range m.GetLabel() {
+					labels[l.GetName()] = l.GetValue()
+				}
+				hot[labels["window"]+" "+labels["slug"]] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	if exemplar != "slug=HOTAB" {
+		t.Errorf("expected the slug as exemplar, got %q", exemplar)
+	}
+	if hot["1m HOTAB"] != 2 || hot["1h HOTAB"] != 2 {
+		t.Errorf("unexpected hot reads: %v", hot)
+	}
+}
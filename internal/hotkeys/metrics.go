@@ -0,0 +1,38 @@
+package hotkeys
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsTop is how many of the most-read slugs of each window are
+// exported, which bounds the series of paste_hot_reads.
+const MetricsTop = 10
+
+var hotReadsDesc = prometheus.NewDesc("paste_hot_reads",
+	"Estimated reads of the most-read slugs over a sliding window.",
+	[]string{"window", "slug"}, nil)
+
+// Collector returns the tracker's metrics for registration:
+// paste_reads_total, whose exemplars name the slug read, and
+// paste_hot_reads for the MetricsTop slugs of each window.
+func (t *Tracker) Collector() prometheus.Collector {
+	return collector{t}
+}
+
+type collector struct {
+	t *Tracker
+}
+
+func (c collector) Describe(ch chan<- *prometheus.Desc) {
+	c.t.reads.Describe(ch)
+	ch <- hotReadsDesc
+}
+
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	c.t.reads.Collect(ch)
+	for _, w := range Windows {
+		for _, e := range c.t.Top(w, MetricsTop) {
+			ch <- prometheus.MustNewConstMetric(hotReadsDesc, prometheus.GaugeValue, float64(e.Reads), WindowName(w), e.Slug)
+		}
+	}
+}
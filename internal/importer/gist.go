@@ -0,0 +1,140 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/johnwmail/nclip/models"
+)
+
+// gistPageSize is the number of gists requested per page, GitHub's most.
+const gistPageSize = 100
+
+// gist imports GitHub gists, one paste per file. Public gists stay
+// public; secret gists, which anyone with the URL can read, become
+// unlisted.
+type gist struct {
+	client *http.Client
+	api    string
+	token  string
+	user   string
+}
+
+func newGist(client *http.Client, opts Options) (*gist, error) {
+	if opts.User == "" && opts.Token == "" {
+		return nil, fmt.Errorf("gist: a user or a token is required")
+	}
+	api := strings.TrimRight(opts.BaseURL, "/")
+	if api == "" {
+		api = "https://api.github.com"
+	}
+	return &gist{client: client, api: api, token: opts.Token, user: opts.User}, nil
+}
+
+func (g *gist) Name() string { return "gist" }
+
+// gistEntry is a gist as listed by the API.
+type gistEntry struct {
+	ID        string    `json:"id"`
+	HTMLURL   string    `json:"html_url"`
+	Public    bool      `json:"public"`
+	CreatedAt time.Time `json:"created_at"`
+	Files     map[string]struct {
+		Filename string `json:"filename"`
+		Language string `json:"language"`
+		RawURL   string `json:"raw_url"`
+	} `json:"files"`
+}
+
+func (g *gist) List() ([]Item, error) {
+	endpoint := g.api + "/gists"
+	if g.user != "" {
+		endpoint = g.api + "/users/" + url.PathEscape(g.user) + "/gists"
+	}
+	var items []Item
+	for page := 1; ; page++ {
+		entries, err := g.page(fmt.Sprintf("%s?per_page=%d&page=%d", endpoint, gistPageSize, page))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			visibility := models.VisibilityUnlisted
+			if e.Public {
+				visibility = models.VisibilityPublic
+			}
+			names := make([]string, 0, len(e.Files))
+			for name := range e.Files {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				f := e.Files[name]
+				items = append(items, Item{
+					Origin: models.PasteOrigin{
+						Service:   g.Name(),
+						ID:        e.ID + "/" + f.Filename,
+						URL:       e.HTMLURL,
+						Language:  strings.ToLower(f.Language),
+						CreatedAt: utcTime(e.CreatedAt),
+					},
+					Filename:   f.Filename,
+					Visibility: visibility,
+					ref:        f.RawURL,
+				})
+			}
+		}
+		if len(entries) < gistPageSize {
+			return items, nil
+		}
+	}
+}
+
+// page requests one page of the gist listing.
+func (g *gist) page(u string) ([]gistEntry, error) {
+	req, err := g.request(u)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := do(g.client, req)
+	if err != nil {
+		return nil, fmt.Errorf("gist: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var entries []gistEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("gist: invalid listing: %w", err)
+	}
+	return entries, nil
+}
+
+func (g *gist) Content(item Item, maxSize int64) ([]byte, error) {
+	req, err := g.request(item.ref)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := do(g.client, req)
+	if err != nil {
+		return nil, fmt.Errorf("gist: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	return readLimited(resp.Body, maxSize)
+}
+
+// request returns a GET request for u, authenticated when u is on the API
+// host. Raw URLs are on another host, which is not sent the token.
+func (g *gist) request(u string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if api, err := url.Parse(g.api); err == nil && g.token != "" && req.URL.Host == api.Host {
+		req.Header.Set("Authorization", "Bearer "+g.token)
+	}
+	return req, nil
+}
@@ -0,0 +1,97 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/johnwmail/nclip/models"
+)
+
+// hastebin imports hastebin documents. Hastebin cannot list a user's
+// documents, so they are named by key or URL, as in an export of the
+// links. Documents are readable by anyone with the key and become
+// unlisted; the extension of the key, as in "abc.py", is the language.
+type hastebin struct {
+	client *http.Client
+	base   string
+	token  string
+	keys   []string
+}
+
+func newHastebin(client *http.Client, opts Options) (*hastebin, error) {
+	if len(opts.Keys) == 0 {
+		return nil, fmt.Errorf("hastebin: name the documents to import by key or URL")
+	}
+	base := strings.TrimRight(opts.BaseURL, "/")
+	if base == "" {
+		base = "https://hastebin.com"
+	}
+	return &hastebin{client: client, base: base, token: opts.Token, keys: opts.Keys}, nil
+}
+
+func (h *hastebin) Name() string { return "hastebin" }
+
+func (h *hastebin) List() ([]Item, error) {
+	items := make([]Item, 0, len(h.keys))
+	for _, k := range h.keys {
+		// A URL such as https://hastebin.com/share/abc.py names its key
+		// last.
+		if u, err := url.Parse(k); err == nil && u.Host != "" {
+			k = path.Base(u.Path)
+		}
+		language := extLanguage(k)
+		key := strings.TrimSuffix(k, path.Ext(k))
+		if key == "" || key == "." || key == "/" {
+			return nil, fmt.Errorf("hastebin: invalid document %q", k)
+		}
+		items = append(items, Item{
+			Origin: models.PasteOrigin{
+				Service:  h.Name(),
+				ID:       key,
+				URL:      h.base + "/share/" + url.PathEscape(k),
+				Language: language,
+			},
+			Visibility: models.VisibilityUnlisted,
+			ref:        key,
+		})
+	}
+	return items, nil
+}
+
+func (h *hastebin) Content(item Item, maxSize int64) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, h.base+"/documents/"+url.PathEscape(item.ref), nil)
+	if err != nil {
+		return nil, err
+	}
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	resp, err := do(h.client, req)
+	if err != nil {
+		return nil, fmt.Errorf("hastebin: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	// The JSON escaping makes the body larger than the document.
+	limit := maxSize
+	if limit > 0 {
+		limit = 2*maxSize + 1024
+	}
+	body, err := readLimited(resp.Body, limit)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Data string `json:"data"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("hastebin: invalid document %s: %w", item.ref, err)
+	}
+	if maxSize > 0 && int64(len(doc.Data)) > maxSize {
+		return nil, ErrTooLarge
+	}
+	return []byte(doc.Data), nil
+}
@@ -0,0 +1,123 @@
+// Package importer reads a user's pastes from other pastebin services, so
+// "nclip import" can recreate them locally. Each Source lists the pastes
+// with what the service tells about them (filename, language, creation
+// time and visibility) and downloads their content one at a time.
+//
+// Services are reached through egress.Client; their base URLs can be
+// changed for self-hosted instances and tests.
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/egress"
+	"github.com/johnwmail/nclip/models"
+)
+
+// ErrTooLarge is returned by Source.Content for pastes over the size limit.
+var ErrTooLarge = errors.New("paste is larger than the size limit")
+
+// Item is one paste at another service.
+type Item struct {
+	Origin models.PasteOrigin
+	// Filename is the paste's filename or title at the service, if any.
+	Filename string
+	// Visibility is the nearest nclip visibility to the paste's at the
+	// service.
+	Visibility models.Visibility
+	// ref tells the Source where to download the content.
+	ref string
+}
+
+// Source is a service pastes are imported from.
+type Source interface {
+	// Name is the service name recorded in PasteOrigin.Service.
+	Name() string
+	// List returns the pastes to import.
+	List() ([]Item, error)
+	// Content downloads the content of item, failing with ErrTooLarge
+	// when it is over maxSize bytes.
+	Content(item Item, maxSize int64) ([]byte, error)
+}
+
+// Options configures a Source.
+type Options struct {
+	// BaseURL replaces the service's default URL, for self-hosted
+	// instances; for GitHub it is the API URL.
+	BaseURL string
+	// Token authenticates to the service: a GitHub token for gist, the
+	// developer and user keys as "devkey:userkey" for pastebin, and an
+	// API token for hastebin.
+	Token string
+	// User is the GitHub user whose public gists are listed; without it
+	// the gists of Token's user are, secret ones included.
+	User string
+	// Keys are the hastebin documents to import, as keys or URLs.
+	Keys []string
+}
+
+// Services are the names New accepts.
+var Services = []string{"gist", "pastebin", "hastebin"}
+
+// New returns the Source for service.
+func New(service string, opts Options) (Source, error) {
+	client := egress.Client(time.Minute)
+	switch service {
+	case "gist":
+		return newGist(client, opts)
+	case "pastebin":
+		return newPastebin(client, opts)
+	case "hastebin":
+		return newHastebin(client, opts)
+	}
+	return nil, fmt.Errorf("unknown service %q (want one of %s)", service, strings.Join(Services, ", "))
+}
+
+// do sends req and returns the response if it is a 200.
+func do(client *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, egress.Explain(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL.Redacted(), resp.Status)
+	}
+	return resp, nil
+}
+
+// readLimited reads at most maxSize bytes of body, failing with
+// ErrTooLarge if there is more. maxSize <= 0 is no limit.
+func readLimited(body io.Reader, maxSize int64) ([]byte, error) {
+	if maxSize <= 0 {
+		return io.ReadAll(body)
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
+
+// extLanguage returns the extension of name without the dot, lowercased.
+func extLanguage(name string) string {
+	return strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+}
+
+// utcTime returns t in UTC, or nil for the zero time.
+func utcTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
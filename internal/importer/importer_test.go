@@ -0,0 +1,155 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/johnwmail/nclip/models"
+)
+
+func TestGist(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gists":
+			if r.Header.Get("Authorization") != "Bearer tok" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Query().Get("page") != "1" {
+				_, _ = fmt.Fprint(w, "[]")
+				return
+			}
+			_, _ = fmt.Fprintf(w, `[
+				{"id": "g1", "html_url": "https://gist.example/g1", "public": true, "created_at": "2020-01-02T03:04:05+02:00",
+				 "files": {"b.go": {"filename": "b.go", "language": "Go", "raw_url": "%[1]s/raw/b"},
+				           "a.txt": {"filename": "a.txt", "language": "Text", "raw_url": "%[1]s/raw/a"}}},
+				{"id": "g2", "public": false, "created_at": "2021-01-01T00:00:00Z",
+				 "files": {"s.sh": {"filename": "s.sh", "language": "Shell", "raw_url": "%[1]s/raw/s"}}}
+			]`, srv.URL)
+		case "/raw/b":
+			_, _ = fmt.Fprint(w, "package main\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	src, err := New("gist", Options{BaseURL: srv.URL, Token: "tok"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := src.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("got %d items, want one per file", len(items))
+	}
+	a, b, s := items[0], items[1], items[2]
+	if a.Origin.ID != "g1/a.txt" || b.Filename != "b.go" || b.Origin.Language != "go" || b.Visibility != models.VisibilityPublic {
+		t.Errorf("unexpected items %+v %+v", a, b)
+	}
+	if b.Origin.CreatedAt == nil || b.Origin.CreatedAt.Location().String() != "UTC" || b.Origin.CreatedAt.Hour() != 1 {
+		t.Errorf("CreatedAt = %v, want 01:04:05 UTC", b.Origin.CreatedAt)
+	}
+	if s.Visibility != models.VisibilityUnlisted {
+		t.Errorf("secret gist visibility = %q, want unlisted", s.Visibility)
+	}
+	content, err := src.Content(b, 100)
+	if err != nil || string(content) != "package main\n" {
+		t.Fatalf("Content = %q, %v", content, err)
+	}
+	if _, err := src.Content(b, 4); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+
+	if _, err := New("gist", Options{}); err == nil {
+		t.Error("expected an error without a user or token")
+	}
+}
+
+func TestPastebin(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("api_dev_key") != "dev" || r.FormValue("api_user_key") != "user" {
+			_, _ = fmt.Fprint(w, "Bad API request, invalid api_dev_key")
+			return
+		}
+		switch r.URL.Path + " " + r.FormValue("api_option") {
+		case "/api/api_post.php list":
+			_, _ = fmt.Fprint(w, `<paste><paste_key>k1</paste_key><paste_date>1600000000</paste_date><paste_title>notes</paste_title>
+<paste_private>2</paste_private><paste_format_short>text</paste_format_short><paste_url>https://pastebin.com/k1</paste_url></paste>
+<paste><paste_key>k2</paste_key><paste_date>1600000001</paste_date><paste_title></paste_title>
+<paste_private>1</paste_private><paste_format_short>python</paste_format_short><paste_url>https://pastebin.com/k2</paste_url></paste>`)
+		case "/api/api_raw.php show_paste":
+			_, _ = fmt.Fprint(w, "content of "+r.FormValue("api_paste_key"))
+		default:
+			_, _ = fmt.Fprint(w, "Bad API request, invalid api_option")
+		}
+	}))
+	defer srv.Close()
+
+	src, err := New("pastebin", Options{BaseURL: srv.URL, Token: "dev:user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := src.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("got %d items, want 2", len(items))
+	}
+	if items[0].Visibility != models.VisibilityPrivate || items[0].Filename != "notes" || items[0].Origin.Language != "" {
+		t.Errorf("unexpected first item %+v", items[0])
+	}
+	if items[1].Visibility != models.VisibilityUnlisted || items[1].Origin.Language != "python" || items[1].Origin.CreatedAt.Unix() != 1600000001 {
+		t.Errorf("unexpected second item %+v", items[1])
+	}
+	content, err := src.Content(items[1], 0)
+	if err != nil || string(content) != "content of k2" {
+		t.Fatalf("Content = %q, %v", content, err)
+	}
+
+	bad, _ := New("pastebin", Options{BaseURL: srv.URL, Token: "dev:wrong"})
+	if _, err := bad.List(); err == nil || !strings.Contains(err.Error(), "invalid api_dev_key") {
+		t.Errorf("expected the API's error, got %v", err)
+	}
+	if _, err := New("pastebin", Options{Token: "dev"}); err == nil {
+		t.Error("expected an error for a token without a user key")
+	}
+}
+
+func TestHastebin(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/documents/abc" || r.Header.Get("Authorization") != "Bearer tok" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"key": "abc", "data": "print(\"hi\")\n"}`)
+	}))
+	defer srv.Close()
+
+	src, err := New("hastebin", Options{BaseURL: srv.URL, Token: "tok", Keys: []string{"https://hastebin.com/share/abc.py", "missing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := src.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].Origin.ID != "abc" || items[0].Origin.Language != "py" || items[0].Visibility != models.VisibilityUnlisted {
+		t.Fatalf("unexpected items %+v", items)
+	}
+	content, err := src.Content(items[0], 0)
+	if err != nil || string(content) != "print(\"hi\")\n" {
+		t.Fatalf("Content = %q, %v", content, err)
+	}
+	if _, err := src.Content(items[1], 0); err == nil {
+		t.Error("expected an error for a missing document")
+	}
+}
@@ -0,0 +1,121 @@
+package importer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johnwmail/nclip/models"
+)
+
+// pastebinListLimit is the most pastes the Pastebin API lists.
+const pastebinListLimit = 1000
+
+// pastebin imports the pastes of a Pastebin.com user through its API,
+// which needs the developer key and a user key. Public, unlisted and
+// private pastes keep their visibility.
+type pastebin struct {
+	client  *http.Client
+	base    string
+	devKey  string
+	userKey string
+}
+
+func newPastebin(client *http.Client, opts Options) (*pastebin, error) {
+	devKey, userKey, ok := strings.Cut(opts.Token, ":")
+	if !ok || devKey == "" || userKey == "" {
+		return nil, fmt.Errorf("pastebin: the token must be the developer and user keys as devkey:userkey")
+	}
+	base := strings.TrimRight(opts.BaseURL, "/")
+	if base == "" {
+		base = "https://pastebin.com"
+	}
+	return &pastebin{client: client, base: base, devKey: devKey, userKey: userKey}, nil
+}
+
+func (p *pastebin) Name() string { return "pastebin" }
+
+// pastebinEntry is a paste as listed by the API.
+type pastebinEntry struct {
+	Key     string `xml:"paste_key"`
+	Date    int64  `xml:"paste_date"`
+	Title   string `xml:"paste_title"`
+	Private int    `xml:"paste_private"`
+	Format  string `xml:"paste_format_short"`
+	URL     string `xml:"paste_url"`
+}
+
+func (p *pastebin) List() ([]Item, error) {
+	body, err := p.post("/api/api_post.php", url.Values{
+		"api_option":        {"list"},
+		"api_results_limit": {strconv.Itoa(pastebinListLimit)},
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(string(body), "No pastes found") {
+		return nil, nil
+	}
+	// The listing is a sequence of <paste> elements without a root.
+	var list struct {
+		Pastes []pastebinEntry `xml:"paste"`
+	}
+	if err := xml.Unmarshal([]byte("<pastes>"+string(body)+"</pastes>"), &list); err != nil {
+		return nil, fmt.Errorf("pastebin: invalid listing: %w", err)
+	}
+	items := make([]Item, 0, len(list.Pastes))
+	for _, e := range list.Pastes {
+		visibility := models.VisibilityPublic
+		switch e.Private {
+		case 1:
+			visibility = models.VisibilityUnlisted
+		case 2:
+			visibility = models.VisibilityPrivate
+		}
+		origin := models.PasteOrigin{Service: p.Name(), ID: e.Key, URL: e.URL}
+		if e.Format != "text" {
+			origin.Language = e.Format
+		}
+		if e.Date > 0 {
+			origin.CreatedAt = utcTime(time.Unix(e.Date, 0))
+		}
+		items = append(items, Item{Origin: origin, Filename: e.Title, Visibility: visibility, ref: e.Key})
+	}
+	return items, nil
+}
+
+func (p *pastebin) Content(item Item, maxSize int64) ([]byte, error) {
+	return p.post("/api/api_raw.php", url.Values{
+		"api_option":    {"show_paste"},
+		"api_paste_key": {item.ref},
+	}, maxSize)
+}
+
+// post calls the API at path with form and the keys. The API answers
+// errors with a 200 and a "Bad API request" body.
+func (p *pastebin) post(path string, form url.Values, maxSize int64) ([]byte, error) {
+	form.Set("api_dev_key", p.devKey)
+	form.Set("api_user_key", p.userKey)
+	req, err := http.NewRequest(http.MethodPost, p.base+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := do(p.client, req)
+	if err != nil {
+		return nil, fmt.Errorf("pastebin: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := readLimited(resp.Body, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	if msg, ok := strings.CutPrefix(string(body), "Bad API request, "); ok {
+		return nil, fmt.Errorf("pastebin: %s", strings.TrimSpace(msg))
+	}
+	return body, nil
+}
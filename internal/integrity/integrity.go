@@ -0,0 +1,415 @@
+// Package integrity audits a store for pastes whose objects disagree with
+// each other, as a partial restore from backup leaves behind: metadata
+// without content or the reverse, sizes that differ from the stored
+// content, encrypted content that no longer decrypts, kept versions that
+// are gone and expiry times that cannot be right.
+//
+// An audit lists the store's objects, checks every paste with metadata and
+// reports what it finds. With repair enabled it also fixes what can be
+// fixed without guessing: sizes are recomputed from the content, orphans
+// are deleted and pastes whose content fails verification are marked
+// corrupt, so they are no longer served.
+package integrity
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/keyring"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// ErrNotListable is returned for stores that cannot enumerate their
+// objects.
+var ErrNotListable = errors.New("the store cannot list its objects")
+
+// Issue kinds.
+const (
+	// KindMetadataUnreadable: the metadata exists but cannot be read.
+	KindMetadataUnreadable = "metadata_unreadable"
+	// KindContentMissing: the metadata has no content.
+	KindContentMissing = "content_missing"
+	// KindContentUnreadable: the content exists but cannot be read, e.g.
+	// it is encrypted with a key the audit was not given.
+	KindContentUnreadable = "content_unreadable"
+	// KindOrphanContent: content, a cached preview or kept versions have no
+	// metadata.
+	KindOrphanContent = "orphan_content"
+	// KindSizeMismatch: the metadata's size differs from the content's.
+	KindSizeMismatch = "size_mismatch"
+	// KindChecksumMismatch: encrypted content fails authentication.
+	KindChecksumMismatch = "checksum_mismatch"
+	// KindVersionMissing: a kept version listed in the metadata is gone.
+	KindVersionMissing = "version_missing"
+	// KindVersionSizeMismatch: a kept version's size differs from its
+	// content's.
+	KindVersionSizeMismatch = "version_size_mismatch"
+	// KindBadExpiry: the creation or expiry time cannot be right.
+	KindBadExpiry = "bad_expiry"
+)
+
+// Repair actions.
+const (
+	// ActionRecomputeSize sets the size in the metadata to the content's.
+	ActionRecomputeSize = "recompute_size"
+	// ActionDeleteOrphan deletes the objects without a counterpart.
+	ActionDeleteOrphan = "delete_orphan"
+	// ActionMarkCorrupt marks the paste corrupt, so it is no longer
+	// served.
+	ActionMarkCorrupt = "mark_corrupted"
+	// ActionDropVersion removes a missing version from the metadata.
+	ActionDropVersion = "drop_version"
+)
+
+// MaxReported bounds the issues listed in a Report; the counts always
+// cover every issue.
+const MaxReported = 1000
+
+// clockSkew is how far in the future a creation time may lie before it
+// is reported.
+const clockSkew = 5 * time.Minute
+
+// Options configure an audit.
+type Options struct {
+	// Repair applies the repair actions; otherwise they are only reported.
+	Repair bool
+	// Verify reads content the store can verify, which is encrypted
+	// content, in full to check it. Plain content has no stored checksum.
+	Verify bool
+	// MinAge protects uploads in flight: orphans and pastes without
+	// content are only deleted once they are older.
+	MinAge time.Duration
+	// MaxLifetime is the longest time between creation and expiry that is
+	// not reported; 0 disables the check.
+	MaxLifetime time.Duration
+}
+
+// Issue is one problem found by an audit. Action is empty for problems
+// that cannot be repaired automatically.
+type Issue struct {
+	Slug   string `json:"slug"`
+	Kind   string `json:"kind"`
+	Detail string `json:"detail"`
+	Action string `json:"action,omitempty"`
+	// Repaired is set once the action was applied, and Error when it
+	// failed.
+	Repaired bool   `json:"repaired,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Report is the outcome of an audit.
+type Report struct {
+	Repair bool `json:"repair"`
+	// Objects is the number of objects listed and Pastes the number of
+	// pastes with metadata among them.
+	Objects int `json:"objects"`
+	Pastes  int `json:"pastes"`
+	// Expired counts pastes past their expiry. They are not checked, and
+	// a repair removes them as the server would.
+	Expired int `json:"expired"`
+	// Verified counts contents whose checksum was verified.
+	Verified int `json:"verified"`
+	// IssueCounts counts issues by kind.
+	IssueCounts map[string]int `json:"issue_counts"`
+	// Repaired and Failed count the repair actions applied and failed.
+	Repaired int `json:"repaired"`
+	Failed   int `json:"failed"`
+	// Issues lists the first MaxReported issues; Truncated is set when
+	// there were more.
+	Issues     []Issue   `json:"issues"`
+	Truncated  bool      `json:"truncated,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// Unresolved returns the number of issues left unrepaired.
+func (r *Report) Unresolved() int {
+	n := -r.Repaired
+	for _, count := range r.IssueCounts {
+		n += count
+	}
+	return n
+}
+
+// add records issue.
+func (r *Report) add(issue Issue) {
+	r.IssueCounts[issue.Kind]++
+	if issue.Repaired {
+		r.Repaired++
+	} else if issue.Error != "" {
+		r.Failed++
+	}
+	if len(r.Issues) < MaxReported {
+		r.Issues = append(r.Issues, issue)
+	} else {
+		r.Truncated = true
+	}
+}
+
+// auditor holds the state of one audit.
+type auditor struct {
+	store  storage.PasteStore
+	opts   Options
+	now    time.Time
+	report *Report
+}
+
+// group collects the objects of one slug.
+type group struct {
+	slug     string
+	meta     bool
+	objects  []string
+	modified time.Time
+}
+
+// Run audits store, which must implement storage.ObjectLister. Unless
+// opts.Repair is set the store should be read-only, so reading expired
+// pastes does not remove them.
+func Run(store storage.PasteStore, opts Options, now time.Time) (*Report, error) {
+	objects, ok := store.(storage.ObjectLister)
+	if !ok {
+		return nil, ErrNotListable
+	}
+	a := &auditor{store: store, opts: opts, now: now, report: &Report{
+		Repair:      opts.Repair,
+		IssueCounts: map[string]int{},
+		Issues:      []Issue{},
+		StartedAt:   now.UTC(),
+	}}
+	var cur *group
+	err := objects.ListObjects(func(obj storage.Object) error {
+		a.report.Objects++
+		slug, suffix, _ := strings.Cut(obj.Name, ".")
+		if cur == nil || cur.slug != slug {
+			a.check(cur)
+			cur = &group{slug: slug}
+		}
+		switch "." + suffix {
+		case ".json":
+			cur.meta = true
+			return nil
+		case ".", storage.PreviewSuffix:
+		default:
+			if _, _, ok := storage.ParseVersionID(obj.Name); !ok {
+				// Not a paste object, e.g. an upload link marker.
+				return nil
+			}
+		}
+		cur.objects = append(cur.objects, obj.Name)
+		if obj.ModTime.After(cur.modified) {
+			cur.modified = obj.ModTime
+		}
+		return nil
+	})
+	if err != nil {
+		return a.report, fmt.Errorf("list objects: %w", err)
+	}
+	a.check(cur)
+	a.report.FinishedAt = time.Now().UTC()
+	return a.report, nil
+}
+
+// check audits the objects of g.
+func (a *auditor) check(g *group) {
+	if g == nil || !utils.IsValidSlug(g.slug) {
+		return
+	}
+	if g.meta {
+		a.report.Pastes++
+		a.checkPaste(g.slug)
+		return
+	}
+	if len(g.objects) > 0 {
+		a.checkOrphan(g)
+	}
+}
+
+// checkOrphan reports the objects of g, which have no metadata, deleting
+// them in a repair once they are old enough.
+func (a *auditor) checkOrphan(g *group) {
+	// Objects of one slug are listed together unless other names sort
+	// between them, so check through the store that the metadata is
+	// really missing.
+	if exists, err := a.store.Exists(g.slug); err != nil || exists {
+		return
+	}
+	issue := Issue{Slug: g.slug, Kind: KindOrphanContent, Detail: "no metadata for " + strings.Join(g.objects, ", ")}
+	if g.modified.After(a.now.Add(-a.opts.MinAge)) {
+		issue.Detail += fmt.Sprintf(" (modified %s, kept until older than %s)", g.modified.UTC().Format(time.RFC3339), a.opts.MinAge)
+	} else {
+		issue.Action = ActionDeleteOrphan
+		if a.opts.Repair {
+			// Delete removes the content and preview; kept versions are
+			// deleted by name, since there is no metadata listing them.
+			ids := []string{g.slug}
+			for _, name := range g.objects {
+				if _, _, ok := storage.ParseVersionID(name); ok {
+					ids = append(ids, name)
+				}
+			}
+			a.apply(&issue, storage.DeleteBatch(a.store, ids, nil)[g.slug])
+		}
+	}
+	a.report.add(issue)
+}
+
+// checkPaste audits the paste with the given slug, whose metadata exists.
+func (a *auditor) checkPaste(slug string) {
+	paste, err := a.store.Get(slug)
+	if errors.Is(err, storage.ErrNotFound) {
+		a.report.Expired++
+		return
+	}
+	if err != nil {
+		a.report.add(Issue{Slug: slug, Kind: KindMetadataUnreadable, Detail: err.Error()})
+		return
+	}
+	a.checkExpiry(paste)
+
+	exists, size, err := a.store.StatContent(slug)
+	switch {
+	case err != nil:
+		a.report.add(Issue{Slug: slug, Kind: KindContentUnreadable, Detail: err.Error()})
+		return
+	case !exists:
+		a.contentMissing(paste)
+		return
+	case paste.Corrupt:
+		// Already marked; there is nothing more to find out.
+		return
+	}
+
+	// The issues found are fixed in the metadata, which is stored once.
+	var issues []*Issue
+	if size != paste.Size && !paste.Appendable {
+		issue := &Issue{Slug: slug, Kind: KindSizeMismatch, Detail: fmt.Sprintf("metadata says %d bytes, content has %d", paste.Size, size)}
+		if a.undecrypted(slug) {
+			issue.Detail += "; the content is encrypted and the audit has no key for it"
+		} else {
+			issue.Action = ActionRecomputeSize
+			paste.Size = size
+		}
+		issues = append(issues, issue)
+	}
+	if issue := a.verify(slug); issue != nil {
+		if issue.Kind == KindChecksumMismatch {
+			issue.Action = ActionMarkCorrupt
+			paste.Corrupt = true
+		}
+		issues = append(issues, issue)
+	}
+	var kept []models.PasteVersion
+	for _, v := range paste.Versions {
+		exists, size, err := a.store.StatContent(storage.VersionID(slug, v.Number))
+		switch {
+		case err != nil:
+			issues = append(issues, &Issue{Slug: slug, Kind: KindContentUnreadable, Detail: fmt.Sprintf("version %d: %v", v.Number, err)})
+		case !exists:
+			issues = append(issues, &Issue{Slug: slug, Kind: KindVersionMissing, Detail: fmt.Sprintf("version %d has no content", v.Number), Action: ActionDropVersion})
+			continue
+		case size != v.Size:
+			issues = append(issues, &Issue{Slug: slug, Kind: KindVersionSizeMismatch, Detail: fmt.Sprintf("version %d: metadata says %d bytes, content has %d", v.Number, v.Size, size), Action: ActionRecomputeSize})
+			v.Size = size
+		}
+		kept = append(kept, v)
+	}
+	paste.Versions = kept
+
+	var stored bool
+	var storeErr error
+	for _, issue := range issues {
+		if a.opts.Repair && issue.Action != "" {
+			if !stored {
+				storeErr, stored = a.store.Store(paste), true
+			}
+			a.apply(issue, storeErr)
+		}
+		a.report.add(*issue)
+	}
+}
+
+// contentMissing reports paste, whose content is gone, deleting it in a
+// repair once it is old enough. Pastes under legal hold are kept.
+func (a *auditor) contentMissing(paste *models.Paste) {
+	issue := Issue{Slug: paste.ID, Kind: KindContentMissing, Detail: "metadata without content"}
+	switch {
+	case paste.LegalHold:
+		issue.Detail += " (under legal hold)"
+	case paste.ContentCreatedAt().After(a.now.Add(-a.opts.MinAge)):
+		issue.Detail += fmt.Sprintf(" (uploaded %s, kept until older than %s)", paste.ContentCreatedAt().UTC().Format(time.RFC3339), a.opts.MinAge)
+	default:
+		issue.Action = ActionDeleteOrphan
+		if a.opts.Repair {
+			a.apply(&issue, a.store.Delete(paste.ID))
+		}
+	}
+	a.report.add(issue)
+}
+
+// checkExpiry reports creation and expiry times that cannot be right.
+// They are left for an admin to fix.
+func (a *auditor) checkExpiry(paste *models.Paste) {
+	var problem string
+	switch {
+	case paste.CreatedAt.IsZero():
+		problem = "no creation time"
+	case paste.CreatedAt.After(a.now.Add(clockSkew)):
+		problem = fmt.Sprintf("created in the future, at %s", paste.CreatedAt.UTC().Format(time.RFC3339))
+	case paste.ExpiresAt == nil:
+	case paste.ExpiresAt.Before(paste.CreatedAt):
+		problem = fmt.Sprintf("expires at %s, before it was created", paste.ExpiresAt.UTC().Format(time.RFC3339))
+	case a.opts.MaxLifetime > 0 && paste.ExpiresAt.Sub(paste.CreatedAt) > a.opts.MaxLifetime+clockSkew:
+		problem = fmt.Sprintf("lives %s, more than %s", paste.ExpiresAt.Sub(paste.CreatedAt).Round(time.Minute), a.opts.MaxLifetime)
+	}
+	if problem != "" {
+		a.report.add(Issue{Slug: paste.ID, Kind: KindBadExpiry, Detail: problem})
+	}
+}
+
+// verify reads the content of slug in full when the store can verify it,
+// returning the issue found, if any.
+func (a *auditor) verify(slug string) *Issue {
+	if !a.opts.Verify {
+		return nil
+	}
+	rest, err := storage.ContentAtRest(a.store, slug)
+	if err != nil || !rest.Encrypted {
+		return nil
+	}
+	_, err = a.store.GetContent(slug)
+	switch {
+	case errors.Is(err, keyring.ErrCorrupt):
+		return &Issue{Slug: slug, Kind: KindChecksumMismatch, Detail: fmt.Sprintf("content encrypted with key %s fails authentication", rest.KeyID)}
+	case err != nil:
+		return &Issue{Slug: slug, Kind: KindContentUnreadable, Detail: err.Error()}
+	}
+	a.report.Verified++
+	return nil
+}
+
+// undecrypted reports whether the content of slug is encrypted while the
+// store does not decrypt it, so its size is not the paste's.
+func (a *auditor) undecrypted(slug string) bool {
+	if rest, err := storage.ContentAtRest(a.store, slug); err == nil && rest.Encrypted {
+		return false
+	}
+	header, err := a.store.GetContentPrefix(slug, int64(keyring.MaxHeaderSize))
+	if err != nil {
+		return false
+	}
+	_, encrypted := keyring.KeyID(header)
+	return encrypted
+}
+
+// apply records the outcome err of issue's repair action.
+func (a *auditor) apply(issue *Issue, err error) {
+	if err != nil {
+		issue.Error = err.Error()
+		return
+	}
+	issue.Repaired = true
+}
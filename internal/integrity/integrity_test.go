@@ -0,0 +1,195 @@
+package integrity
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/keyring"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := storage.NewFilesystemStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := keyring.Parse("k1:" + base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := storage.NewEncryptedStore(fs, keys)
+	old := time.Now().Add(-2 * time.Hour)
+	expires := time.Now().Add(time.Hour)
+	put := func(p *models.Paste, content string) {
+		t.Helper()
+		if p.CreatedAt.IsZero() {
+			p.CreatedAt = old
+		}
+		if p.ExpiresAt == nil {
+			p.ExpiresAt = &expires
+		}
+		if content != "" {
+			if err := store.StoreContent(p.ID, []byte(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := store.Store(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	age := func(name string) {
+		t.Helper()
+		if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	put(&models.Paste{ID: "GDPST", Size: 5}, "hello")
+	put(&models.Paste{ID: "SZPST", Size: 99}, "hello")
+	put(&models.Paste{ID: "GNPST", Size: 5}, "")
+	put(&models.Paste{ID: "HLDPST", Size: 5, LegalHold: true}, "")
+	put(&models.Paste{ID: "CRPST", Size: 5}, "hello")
+	data, err := os.ReadFile(filepath.Join(dir, "CRPST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(filepath.Join(dir, "CRPST"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	put(&models.Paste{ID: "VRPST", Size: 5, Version: 3, Versions: []models.PasteVersion{{Number: 1, Size: 1}, {Number: 2, Size: 2}}}, "hello")
+	if err := store.StoreContent(storage.VersionID("VRPST", 2), []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	created := expires.Add(time.Hour)
+	put(&models.Paste{ID: "TMPST", Size: 5, CreatedAt: created}, "hello")
+	for _, name := range []string{"RPHAN", "RPHAN.v1"} {
+		if err := store.StoreContent(name, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+		age(name)
+	}
+	if err := store.StoreContent("FRESH", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	fs.SetReadOnly(true)
+
+	opts := Options{Verify: true, MinAge: time.Hour, MaxLifetime: 24 * time.Hour}
+	report, err := Run(store, opts, time.Now())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	want := map[string]int{
+		KindSizeMismatch:        1,
+		KindContentMissing:      2,
+		KindChecksumMismatch:    1,
+		KindVersionMissing:      1,
+		KindVersionSizeMismatch: 1,
+		KindBadExpiry:           1,
+		KindOrphanContent:       2,
+	}
+	for kind, n := range want {
+		if report.IssueCounts[kind] != n {
+			t.Errorf("%s: expected %d issues, got %d (%+v)", kind, n, report.IssueCounts[kind], report.Issues)
+		}
+	}
+	if report.Pastes != 7 || report.Verified != 4 || report.Repaired != 0 || report.Unresolved() != 9 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	actions := map[string]string{}
+	for _, issue := range report.Issues {
+		actions[issue.Slug+" "+issue.Kind] = issue.Action
+	}
+	for key, action := range map[string]string{
+		"SZPST size_mismatch":         ActionRecomputeSize,
+		"GNPST content_missing":       ActionDeleteOrphan,
+		"HLDPST content_missing":      "",
+		"CRPST checksum_mismatch":     ActionMarkCorrupt,
+		"VRPST version_missing":       ActionDropVersion,
+		"VRPST version_size_mismatch": ActionRecomputeSize,
+		"TMPST bad_expiry":            "",
+		"RPHAN orphan_content":        ActionDeleteOrphan,
+		"FRESH orphan_content":        "",
+	} {
+		if got, ok := actions[key]; !ok || got != action {
+			t.Errorf("%s: expected action %q, got %q (found %v)", key, action, got, ok)
+		}
+	}
+
+	fs.SetReadOnly(false)
+	opts.Repair = true
+	report, err = Run(store, opts, time.Now())
+	if err != nil {
+		t.Fatalf("Run with repair: %v", err)
+	}
+	if report.Repaired != 6 || report.Failed != 0 || report.Unresolved() != 3 {
+		t.Errorf("repair: unexpected report %+v", report)
+	}
+	if p, err := store.Get("SZPST"); err != nil || p.Size != 5 {
+		t.Errorf("expected SZPST to be resized to 5 bytes, got %+v, %v", p, err)
+	}
+	if p, err := store.Get("CRPST"); err != nil || !p.Corrupt {
+		t.Errorf("expected CRPST to be marked corrupt, got %+v, %v", p, err)
+	}
+	if p, err := store.Get("VRPST"); err != nil || len(p.Versions) != 1 || p.Versions[0].Size != 3 {
+		t.Errorf("expected VRPST to keep version 2 of 3 bytes, got %+v, %v", p, err)
+	}
+	for _, name := range []string{"GNPST.json", "RPHAN", "RPHAN.v1"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be deleted, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"HLDPST.json", "FRESH"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s to be kept, got %v", name, err)
+		}
+	}
+
+	// What a repair fixed stays fixed.
+	report, err = Run(store, opts, time.Now())
+	if err != nil {
+		t.Fatalf("Run after repair: %v", err)
+	}
+	for _, issue := range report.Issues {
+		if issue.Action != "" {
+			t.Errorf("unexpected issue after repair: %+v", issue)
+		}
+	}
+}
+
+func TestRun_EncryptedWithoutKey(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := storage.NewFilesystemStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := keyring.Parse("k1:" + base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewEncryptedStore(fs, keys).StoreContent("ENCRY", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Store(&models.Paste{ID: "ENCRY", Size: 5, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the key the stored size is not the paste's, and must not be
+	// "repaired".
+	report, err := Run(fs, Options{Repair: true, Verify: true}, time.Now())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Action != "" || !strings.Contains(report.Issues[0].Detail, "encrypted") {
+		t.Fatalf("expected an unrepaired size mismatch, got %+v", report.Issues)
+	}
+	if p, err := fs.Get("ENCRY"); err != nil || p.Size != 5 {
+		t.Errorf("expected the size to be kept, got %+v, %v", p, err)
+	}
+}
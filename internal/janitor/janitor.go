@@ -0,0 +1,327 @@
+// Package janitor reclaims storage that crashes leave behind: content
+// objects whose metadata was never written (or was deleted first), and
+// metadata whose content is gone. Neither is reachable through the API, and
+// since expiry is only enforced when a paste is read, they would otherwise
+// never be removed.
+//
+// A sweep lists the store's objects, pairs each slug's content, cached
+// preview and kept versions with its metadata, and deletes the unpaired ones once all of
+// their objects are older than a minimum age, so uploads still in flight
+// are left alone. Before deleting, the sweep checks again through the
+// PasteStore that the counterpart is still missing.
+//
+// Upload link markers are removed once they are older than
+// uploadlink.MaxValidity, when their links have expired.
+//
+// With SetExpire, a sweep also reads the metadata of every complete paste,
+// so the store removes the expired ones nobody reads anymore, and a CDN
+// purger wrapped around the store learns of them.
+package janitor
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/uploadlink"
+	"github.com/johnwmail/nclip/storage"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// Orphan kinds.
+const (
+	// KindContent is content, a cached preview or kept versions, without
+	// metadata.
+	KindContent = "content"
+	// KindMetadata is metadata without content.
+	KindMetadata = "metadata"
+)
+
+// maxReported bounds the orphans listed in a Report; the counts always
+// cover every orphan.
+const maxReported = 1000
+
+// deleteBatchSize is the number of orphans a sweep collects before
+// deleting them in one batch.
+const deleteBatchSize = 500
+
+// ErrRunning is returned by Sweep while another sweep is running.
+var ErrRunning = errors.New("an orphan sweep is already running")
+
+// Orphan is one slug whose objects have no counterpart.
+type Orphan struct {
+	Slug string `json:"slug"`
+	Kind string `json:"kind"`
+	// Objects are the orphaned object names, e.g. "ABCDE" and "ABCDE.png".
+	Objects    []string  `json:"objects"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// Report is the outcome of a sweep.
+type Report struct {
+	DryRun bool   `json:"dry_run"`
+	MinAge string `json:"min_age"`
+	// Scanned is the number of objects listed.
+	Scanned int `json:"scanned"`
+	// Found is the number of orphans old enough to remove, and Bytes
+	// their total size.
+	Found int   `json:"found"`
+	Bytes int64 `json:"bytes"`
+	// Removed and Failed count deletions; both are 0 in a dry run.
+	Removed int `json:"removed"`
+	Failed  int `json:"failed"`
+	// Expired counts the expired pastes the store removed when the sweep
+	// read them; it is 0 without SetExpire and in a dry run.
+	Expired int `json:"expired"`
+	// Links counts the markers of expired upload links removed; it is 0
+	// in a dry run.
+	Links int `json:"links"`
+	// Orphans lists the first orphans found; Truncated is set when there
+	// were more.
+	Orphans    []Orphan  `json:"orphans"`
+	Truncated  bool      `json:"truncated,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// Janitor sweeps a store for orphans. It is safe for concurrent use; only
+// one sweep runs at a time.
+type Janitor struct {
+	store   storage.PasteStore
+	objects storage.ObjectLister
+	minAge  time.Duration
+	expire  bool
+	now     func() time.Time
+
+	running sync.Mutex
+}
+
+// New creates a Janitor for store, which must implement
+// storage.ObjectLister, removing orphans older than minAge. It returns nil
+// when the store cannot list its objects.
+func New(store storage.PasteStore, minAge time.Duration) *Janitor {
+	objects, ok := store.(storage.ObjectLister)
+	if !ok {
+		return nil
+	}
+	return &Janitor{store: store, objects: objects, minAge: minAge, now: time.Now}
+}
+
+// SetExpire makes sweeps read the metadata of complete pastes too, so the
+// store removes those that expired. It must be called before Start.
+func (j *Janitor) SetExpire(expire bool) {
+	j.expire = expire
+}
+
+// Start sweeps every interval in the background, logging the outcome of
+// sweeps that found orphans.
+func (j *Janitor) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			report, err := j.Sweep(false)
+			switch {
+			case errors.Is(err, ErrRunning):
+			case err != nil:
+				log.Printf("[ERROR] Orphan sweep failed: %v", err)
+			case report.Found > 0:
+				log.Printf("[INFO] Orphan sweep: removed %d of %d orphan(s), %d bytes, %d failed",
+					report.Removed, report.Found, report.Bytes, report.Failed)
+			}
+			if report.Expired > 0 {
+				log.Printf("[INFO] Orphan sweep: removed %d expired paste(s)", report.Expired)
+			}
+			if report.Links > 0 {
+				log.Printf("[INFO] Orphan sweep: removed %d expired upload link marker(s)", report.Links)
+			}
+		}
+	}()
+}
+
+// group collects the objects of one slug during a sweep.
+type group struct {
+	slug     string
+	meta     bool
+	content  bool
+	objects  []string
+	size     int64
+	modified time.Time
+}
+
+// Sweep finds orphans older than the minimum age and, unless dryRun is
+// set, deletes them.
+func (j *Janitor) Sweep(dryRun bool) (Report, error) {
+	if !j.running.TryLock() {
+		return Report{}, ErrRunning
+	}
+	defer j.running.Unlock()
+
+	report := Report{DryRun: dryRun, MinAge: j.minAge.String(), Orphans: []Orphan{}, StartedAt: j.now().UTC()}
+	cutoff := j.now().Add(-j.minAge)
+	linkCutoff := cutoff.Add(-uploadlink.MaxValidity)
+	var cur *group
+	var doomed []*group
+	var complete, links []string
+	flush := func() {
+		if cur != nil && j.check(cur, cutoff, &report) {
+			doomed = append(doomed, cur)
+		}
+		if cur != nil && cur.meta && cur.content && j.expire && !dryRun && utils.IsValidSlug(cur.slug) {
+			complete = append(complete, cur.slug)
+		}
+		if len(doomed) >= deleteBatchSize {
+			j.remove(doomed, &report)
+			doomed = nil
+		}
+		if len(complete) >= deleteBatchSize {
+			j.removeExpired(complete, &report)
+			complete = nil
+		}
+		if len(links) >= deleteBatchSize {
+			j.removeLinks(links, &report)
+			links = nil
+		}
+	}
+	err := j.objects.ListObjects(func(obj storage.Object) error {
+		report.Scanned++
+		slug, suffix, _ := strings.Cut(obj.Name, ".")
+		if cur == nil || cur.slug != slug {
+			flush()
+			cur = &group{slug: slug}
+		}
+		switch "." + suffix {
+		case ".":
+			cur.content = true
+		case ".json":
+			cur.meta = true
+		case storage.PreviewSuffix:
+		case uploadlink.MarkerSuffix:
+			if !dryRun && obj.ModTime.Before(linkCutoff) {
+				links = append(links, obj.Name)
+			}
+			return nil
+		default:
+			if _, _, ok := storage.ParseVersionID(obj.Name); !ok {
+				// Not a paste object.
+				return nil
+			}
+		}
+		cur.objects = append(cur.objects, obj.Name)
+		cur.size += obj.Size
+		if obj.ModTime.After(cur.modified) {
+			cur.modified = obj.ModTime
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	flush()
+	j.remove(doomed, &report)
+	j.removeExpired(complete, &report)
+	j.removeLinks(links, &report)
+	report.FinishedAt = j.now().UTC()
+	return report, nil
+}
+
+// check records g in report when it is an orphan old enough to remove,
+// and reports whether to remove it: not in a dry run, and only when it is
+// still orphaned.
+func (j *Janitor) check(g *group, cutoff time.Time, report *Report) bool {
+	if !utils.IsValidSlug(g.slug) || len(g.objects) == 0 || (g.meta && g.content) || !g.modified.Before(cutoff) {
+		return false
+	}
+	kind := KindContent
+	if g.meta {
+		kind = KindMetadata
+	}
+	report.Found++
+	report.Bytes += g.size
+	if len(report.Orphans) < maxReported {
+		report.Orphans = append(report.Orphans, Orphan{Slug: g.slug, Kind: kind, Objects: g.objects, Size: g.size, ModifiedAt: g.modified.UTC()})
+	} else {
+		report.Truncated = true
+	}
+	return !report.DryRun && j.stillOrphaned(g.slug, kind)
+}
+
+// remove deletes the orphans gs in one batch, counting the outcome in
+// report.
+func (j *Janitor) remove(gs []*group, report *Report) {
+	if len(gs) == 0 {
+		return
+	}
+	var ids, versions []string
+	for _, g := range gs {
+		ids = append(ids, g.slug)
+		// Without metadata, Delete cannot tell which versions were kept.
+		for _, name := range g.objects {
+			if _, _, ok := storage.ParseVersionID(name); ok {
+				versions = append(versions, name)
+			}
+		}
+	}
+	errs := storage.DeleteBatch(j.store, append(ids, versions...), nil)
+	for _, g := range gs {
+		if err, failed := errs[g.slug]; failed {
+			log.Printf("[WARN] Orphan sweep: failed to remove %s: %v", g.slug, err)
+			report.Failed++
+			continue
+		}
+		kind := KindContent
+		if g.meta {
+			kind = KindMetadata
+		}
+		log.Printf("[INFO] Orphan sweep: removed orphaned %s of %s (%d bytes)", kind, g.slug, g.size)
+		report.Removed++
+	}
+	for _, name := range versions {
+		if err, failed := errs[name]; failed {
+			log.Printf("[WARN] Orphan sweep: failed to remove %s: %v", name, err)
+		}
+	}
+}
+
+// removeExpired reads the metadata of slugs in one batch, so the store
+// removes the expired ones, and counts them in report.
+func (j *Janitor) removeExpired(slugs []string, report *Report) {
+	if len(slugs) == 0 {
+		return
+	}
+	for _, r := range storage.GetBatch(j.store, slugs) {
+		if errors.Is(r.Err, storage.ErrNotFound) {
+			report.Expired++
+		}
+	}
+}
+
+// removeLinks deletes the upload link markers ids in one batch, counting
+// them in report.
+func (j *Janitor) removeLinks(ids []string, report *Report) {
+	if len(ids) == 0 {
+		return
+	}
+	errs := storage.DeleteBatch(j.store, ids, nil)
+	for _, id := range ids {
+		if err, failed := errs[id]; failed {
+			log.Printf("[WARN] Orphan sweep: failed to remove upload link marker %s: %v", id, err)
+			continue
+		}
+		report.Links++
+	}
+}
+
+// stillOrphaned checks through the store, which sees spooled pastes the
+// object listing does not, that slug's counterpart is still missing.
+func (j *Janitor) stillOrphaned(slug, kind string) bool {
+	if kind == KindContent {
+		exists, err := j.store.Exists(slug)
+		return err == nil && !exists
+	}
+	exists, _, err := j.store.StatContent(slug)
+	return err == nil && !exists
+}
@@ -0,0 +1,174 @@
+package janitor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/uploadlink"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func TestJanitor_Sweep(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.NewFilesystemStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	put := func(name string, aged bool) {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte("data"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if aged {
+			if err := os.Chtimes(p, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// A complete paste, written through the store.
+	if err := store.StoreContent("WHLE", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Store(&models.Paste{ID: "WHLE", CreatedAt: old}); err != nil {
+		t.Fatal(err)
+	}
+	put("WHLE.json", true)
+	put("WHLE", true)
+	put("WHLE.v1", true)
+	// Orphans old enough to remove.
+	put("CNTNT", true)
+	put("CNTNT.png", true)
+	put("CNTNT.v2", true)
+	put("MTDT.json", true)
+	put("PRVW.png", true)
+	// An upload still in flight, and objects that are not pastes.
+	put("FRSH", false)
+	put("0123abcd.link", true)
+	put(".reencrypt.json", true)
+
+	j := New(store, time.Hour)
+	report, err := j.Sweep(true)
+	if err != nil {
+		t.Fatalf("Sweep: %v", err)
+	}
+	if report.Found != 3 || report.Removed != 0 || len(report.Orphans) != 3 {
+		t.Fatalf("dry run: expected 3 orphans and nothing removed, got %+v", report)
+	}
+	want := map[string]string{"CNTNT": KindContent, "MTDT": KindMetadata, "PRVW": KindContent}
+	for _, o := range report.Orphans {
+		if want[o.Slug] != o.Kind {
+			t.Errorf("unexpected orphan %+v", o)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "CNTNT")); err != nil {
+		t.Fatalf("dry run removed an object: %v", err)
+	}
+
+	report, err = j.Sweep(false)
+	if err != nil {
+		t.Fatalf("Sweep: %v", err)
+	}
+	if report.Found != 3 || report.Removed != 3 || report.Failed != 0 {
+		t.Fatalf("expected 3 orphans removed, got %+v", report)
+	}
+	for _, name := range []string{"CNTNT", "CNTNT.png", "CNTNT.v2", "MTDT.json", "PRVW.png"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"WHLE", "WHLE.json", "WHLE.v1", "FRSH", "0123abcd.link", ".reencrypt.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s to be kept: %v", name, err)
+		}
+	}
+}
+
+func TestJanitor_SweepRunning(t *testing.T) {
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := New(store, time.Hour)
+	j.running.Lock()
+	if _, err := j.Sweep(true); !errors.Is(err, ErrRunning) {
+		t.Errorf("expected ErrRunning, got %v", err)
+	}
+	j.running.Unlock()
+}
+
+func TestJanitor_SweepExpired(t *testing.T) {
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Minute)
+	for _, p := range []*models.Paste{{ID: "KEPT"}, {ID: "XPRD", ExpiresAt: &past}} {
+		if err := store.StoreContent(p.ID, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Store(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	j := New(store, time.Hour)
+	if report, err := j.Sweep(false); err != nil || report.Expired != 0 {
+		t.Fatalf("expected no expiry pass without SetExpire, got %+v, %v", report, err)
+	}
+	j.SetExpire(true)
+	if report, err := j.Sweep(true); err != nil || report.Expired != 0 {
+		t.Fatalf("expected a dry run to leave expired pastes, got %+v, %v", report, err)
+	}
+	report, err := j.Sweep(false)
+	if err != nil || report.Expired != 1 || report.Found != 0 {
+		t.Fatalf("expected 1 expired paste removed, got %+v, %v", report, err)
+	}
+	if exists, _, _ := store.StatContent("XPRD"); exists {
+		t.Error("expected the expired paste's content to be removed")
+	}
+	if _, err := store.Get("KEPT"); err != nil {
+		t.Errorf("expected the live paste to be kept: %v", err)
+	}
+}
+
+func TestJanitor_SweepLinks(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.NewFilesystemStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, age := range map[string]time.Duration{
+		"0123abcd.link": uploadlink.MaxValidity + 2*time.Hour,
+		"4567cdef.link": uploadlink.MaxValidity - time.Hour,
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte("data"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		then := time.Now().Add(-age)
+		if err := os.Chtimes(p, then, then); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	j := New(store, time.Hour)
+	if report, err := j.Sweep(true); err != nil || report.Links != 0 || report.Found != 0 {
+		t.Fatalf("expected a dry run to keep markers, got %+v, %v", report, err)
+	}
+	report, err := j.Sweep(false)
+	if err != nil || report.Links != 1 || report.Found != 0 {
+		t.Fatalf("expected 1 expired marker removed, got %+v, %v", report, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "0123abcd.link")); !os.IsNotExist(err) {
+		t.Errorf("expected the expired marker removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "4567cdef.link")); err != nil {
+		t.Errorf("expected the marker of a link still valid kept: %v", err)
+	}
+}
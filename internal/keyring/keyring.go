@@ -0,0 +1,166 @@
+// Package keyring holds the keys paste content is encrypted with at rest
+// and defines the format of encrypted content.
+//
+// Encrypted content is a header followed by AES-256-GCM ciphertext:
+//
+//	"\x00NCE1" | key ID length (1 byte) | key ID | nonce (12 bytes) | ciphertext and tag
+//
+// The key ID names the key that sealed it, so content written before a key
+// rotation stays readable while older keys are kept. The leading NUL byte
+// keeps text pastes from being mistaken for encrypted content.
+package keyring
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// KeySize is the length of an encryption key in bytes (AES-256).
+const KeySize = 32
+
+// MaxHeaderSize is the longest possible header; reading this many bytes
+// of content is enough for KeyID and PlainSize.
+const MaxHeaderSize = len(magic) + 1 + maxKeyIDLen
+
+const (
+	magic       = "\x00NCE1"
+	maxKeyIDLen = 16
+	nonceSize   = 12
+	tagSize     = 16
+)
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,16}$`)
+
+var (
+	// ErrUnknownKey is returned for content sealed with a key that is not
+	// in the keyring.
+	ErrUnknownKey = errors.New("content is encrypted with an unknown key")
+	// ErrCorrupt is returned for encrypted content that fails to decrypt.
+	ErrCorrupt = errors.New("encrypted content is corrupt")
+)
+
+// Keyring is a set of named keys. New content is sealed with the current
+// key; content sealed with any key in the ring can be opened.
+type Keyring struct {
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// Parse parses a comma-separated list of ID:KEY entries, where KEY is 32
+// bytes in standard base64. The first entry is the current key.
+func Parse(s string) (*Keyring, error) {
+	k := &Keyring{aeads: make(map[string]cipher.AEAD)}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid key %q: want ID:BASE64KEY with an ID of up to 16 letters, digits, - or _", id)
+		}
+		if _, dup := k.aeads[id]; dup {
+			return nil, fmt.Errorf("key %q listed twice", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != KeySize {
+			return nil, fmt.Errorf("key %q must be %d bytes in base64", id, KeySize)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.aeads[id] = aead
+		if k.current == "" {
+			k.current = id
+		}
+	}
+	if k.current == "" {
+		return nil, errors.New("no keys given")
+	}
+	return k, nil
+}
+
+// Current returns the ID of the key new content is sealed with.
+func (k *Keyring) Current() string {
+	return k.current
+}
+
+// Seal encrypts plaintext with the current key. aad is authenticated but
+// not stored; the same aad must be passed to Open.
+func (k *Keyring) Seal(aad, plaintext []byte) ([]byte, error) {
+	aead := k.aeads[k.current]
+	out := make([]byte, 0, len(magic)+1+len(k.current)+nonceSize+len(plaintext)+tagSize)
+	out = append(out, magic...)
+	out = append(out, byte(len(k.current)))
+	out = append(out, k.current...)
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plaintext, aad), nil
+}
+
+// Open decrypts data sealed by Seal with any key in the ring and returns
+// the plaintext with the ID of the key used. Content without the
+// encryption header is returned unchanged with an empty key ID, so content
+// written before encryption was enabled stays readable.
+func (k *Keyring) Open(aad, data []byte) ([]byte, string, error) {
+	id, ok := KeyID(data)
+	if !ok {
+		return data, "", nil
+	}
+	aead, known := k.aeads[id]
+	if !known {
+		return nil, id, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	body := data[len(magic)+1+len(id):]
+	if len(body) < nonceSize+tagSize {
+		return nil, id, ErrCorrupt
+	}
+	plaintext, err := aead.Open(nil, body[:nonceSize], body[nonceSize:], aad)
+	if err != nil {
+		return nil, id, ErrCorrupt
+	}
+	return plaintext, id, nil
+}
+
+// KeyID returns the ID of the key data was sealed with, reporting false
+// when data is not encrypted. data may be just the first MaxHeaderSize
+// bytes of the content.
+func KeyID(data []byte) (string, bool) {
+	if !bytes.HasPrefix(data, []byte(magic)) || len(data) < len(magic)+1 {
+		return "", false
+	}
+	n := int(data[len(magic)])
+	if n == 0 || n > maxKeyIDLen || len(data) < len(magic)+1+n {
+		return "", false
+	}
+	return string(data[len(magic)+1 : len(magic)+1+n]), true
+}
+
+// PlainSize returns the plaintext size of content of the given stored
+// size, whose first bytes are header.
+func PlainSize(header []byte, size int64) int64 {
+	id, ok := KeyID(header)
+	if !ok {
+		return size
+	}
+	plain := size - int64(len(magic)+1+len(id)+nonceSize+tagSize)
+	if plain < 0 {
+		return 0
+	}
+	return plain
+}
@@ -0,0 +1,75 @@
+package keyring
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, KeySize))
+}
+
+func TestSealOpen(t *testing.T) {
+	old, err := Parse("k1:" + testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := old.Seal([]byte("ABCDE"), []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("hello")) {
+		t.Fatal("sealed content contains the plaintext")
+	}
+	if id, ok := KeyID(sealed); !ok || id != "k1" {
+		t.Errorf("KeyID = %q, %v", id, ok)
+	}
+	if got := PlainSize(sealed[:MaxHeaderSize], int64(len(sealed))); got != 5 {
+		t.Errorf("PlainSize = %d, want 5", got)
+	}
+
+	// After rotation the old key still opens old content.
+	rotated, err := Parse("k2:" + testKey(2) + ", k1:" + testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.Current() != "k2" {
+		t.Errorf("current key %q, want k2", rotated.Current())
+	}
+	plain, id, err := rotated.Open([]byte("ABCDE"), sealed)
+	if err != nil || id != "k1" || string(plain) != "hello" {
+		t.Errorf("Open = %q, %q, %v", plain, id, err)
+	}
+
+	// Content is bound to its id.
+	if _, _, err := rotated.Open([]byte("FGHJK"), sealed); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("wrong aad: expected ErrCorrupt, got %v", err)
+	}
+	// Keys that were dropped can no longer open content.
+	newOnly, _ := Parse("k2:" + testKey(2))
+	if _, _, err := newOnly.Open([]byte("ABCDE"), sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("dropped key: expected ErrUnknownKey, got %v", err)
+	}
+	// Plain text passes through.
+	if plain, id, err := newOnly.Open(nil, []byte("plain")); err != nil || id != "" || string(plain) != "plain" {
+		t.Errorf("plain content: %q, %q, %v", plain, id, err)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"k1",
+		"k1:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"bad id:" + testKey(1),
+		"k1:" + testKey(1) + ",k1:" + testKey(2),
+		strings.Repeat("k", 17) + ":" + testKey(1),
+	} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q): expected error", s)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Package mirror copies a primary instance's pastes into local storage by
+// polling its sync feed (GET /api/v1/sync/changes), so an on-prem
+// instance and a cloud instance can serve the same pastes without sharing
+// storage.
+//
+// Changes are applied in order and the cursor is saved to a state file
+// after each one, so a mirror that restarts or loses its connection picks
+// up where it stopped. Burn-after-read pastes are never copied: reading
+// one on the mirror would not burn it on the primary.
+package mirror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/changes"
+	"github.com/johnwmail/nclip/internal/egress"
+	"github.com/johnwmail/nclip/storage"
+)
+
+// pageLimit is the number of changes requested per poll.
+const pageLimit = 500
+
+// errContentGone is returned when the primary no longer has a paste's
+// content; its delete follows later in the feed.
+var errContentGone = errors.New("content is gone from the primary")
+
+// Status is the mirror's progress, as saved in the state file and
+// reported by /health.
+type Status struct {
+	Primary string `json:"primary"`
+	// Cursor is the last change applied.
+	Cursor int64 `json:"cursor"`
+	Copied int   `json:"copied"`
+	// Deleted counts deletes applied, and Skipped burn-after-read pastes
+	// left on the primary.
+	Deleted    int        `json:"deleted"`
+	Skipped    int        `json:"skipped"`
+	LastSyncAt *time.Time `json:"last_sync_at,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+}
+
+// Mirror polls a primary and applies its changes to a local store. It is
+// safe for concurrent use; only one sync runs at a time.
+type Mirror struct {
+	primary   string
+	apiKey    string
+	store     storage.PasteStore
+	statePath string
+	client    *http.Client
+	now       func() time.Time
+
+	running sync.Mutex
+	mu      sync.Mutex
+	status  Status
+}
+
+// New creates a Mirror of the instance at primary (its base URL), which it
+// authenticates to with apiKey, saving progress to statePath. A state file
+// left by a mirror of another primary is ignored.
+func New(primary, apiKey string, store storage.PasteStore, statePath string) *Mirror {
+	primary = strings.TrimRight(primary, "/")
+	m := &Mirror{
+		primary:   primary,
+		apiKey:    apiKey,
+		store:     store,
+		statePath: statePath,
+		client:    egress.Client(time.Minute),
+		now:       time.Now,
+		status:    Status{Primary: primary},
+	}
+	data, err := os.ReadFile(statePath) // #nosec G304 -- operator-configured path
+	if err == nil {
+		var saved Status
+		if err := json.Unmarshal(data, &saved); err != nil {
+			log.Printf("[WARN] Mirror: ignoring unreadable state file %s: %v", statePath, err)
+		} else if saved.Primary == primary {
+			m.status = saved
+		}
+	}
+	return m
+}
+
+// Status returns the mirror's progress.
+func (m *Mirror) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// Start syncs now and then every interval in the background.
+func (m *Mirror) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := m.Sync(); err != nil {
+				log.Printf("[WARN] Mirror: sync with %s failed: %v", m.primary, err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Sync applies every change the primary has made since the saved cursor.
+// It stops at the first change it cannot apply, which is retried on the
+// next sync.
+func (m *Mirror) Sync() error {
+	m.running.Lock()
+	defer m.running.Unlock()
+	err := m.sync()
+	m.mu.Lock()
+	now := m.now().UTC()
+	m.status.LastSyncAt = &now
+	m.status.LastError = ""
+	if err != nil {
+		m.status.LastError = err.Error()
+	}
+	m.saveLocked()
+	m.mu.Unlock()
+	return err
+}
+
+func (m *Mirror) sync() error {
+	for {
+		page, err := m.fetch(m.Status().Cursor)
+		if err != nil {
+			return err
+		}
+		for _, ch := range page.Changes {
+			if err := m.apply(ch); err != nil {
+				return fmt.Errorf("%s %s: %w", ch.Op, ch.Slug, err)
+			}
+		}
+		m.mu.Lock()
+		m.status.Cursor = page.Cursor
+		m.saveLocked()
+		m.mu.Unlock()
+		if !page.More {
+			return nil
+		}
+	}
+}
+
+// fetch requests the changes after cursor. When the primary's journal no
+// longer covers the cursor, the changes in between are lost: the mirror
+// logs it and continues from the oldest cursor the primary offers.
+func (m *Mirror) fetch(cursor int64) (changes.Page, error) {
+	var page changes.Page
+	resp, err := m.get("/api/v1/sync/changes?since=" + strconv.FormatInt(cursor, 10) + "&limit=" + strconv.Itoa(pageLimit))
+	if err != nil {
+		return page, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode == http.StatusGone {
+		var body apierror.Response
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return page, fmt.Errorf("primary returned 410: %w", err)
+		}
+		oldest, err := strconv.ParseInt(body.Detail, 10, 64)
+		if err != nil {
+			return page, fmt.Errorf("primary returned 410 without a cursor: %s", body.Error)
+		}
+		log.Printf("[ERROR] Mirror: changes after %d are no longer in the primary's journal; continuing from %d, reseed the mirror to recover the pastes in between", cursor, oldest)
+		page.Cursor = oldest
+		page.More = true
+		return page, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return page, fmt.Errorf("primary returned %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return page, fmt.Errorf("invalid changes response: %w", err)
+	}
+	return page, nil
+}
+
+// apply makes one change locally.
+func (m *Mirror) apply(ch changes.Change) error {
+	if ch.Op == changes.OpDelete || ch.Paste == nil || ch.Paste.BurnAfterRead {
+		if err := m.store.Delete(ch.Slug); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+		m.mu.Lock()
+		if ch.Op == changes.OpDelete {
+			m.status.Deleted++
+		} else {
+			m.status.Skipped++
+		}
+		m.mu.Unlock()
+		return nil
+	}
+	content, err := m.content(ch.Slug)
+	if errors.Is(err, errContentGone) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	paste := *ch.Paste
+	paste.ID = ch.Slug
+	if err := storage.AssignTenant(m.store, ch.Slug, paste.Tenant); err != nil {
+		return err
+	}
+	if err := m.store.StoreContent(ch.Slug, content); err != nil {
+		return err
+	}
+	if err := m.store.Store(&paste); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.status.Copied++
+	m.mu.Unlock()
+	return nil
+}
+
+// content downloads a paste's content from the primary.
+func (m *Mirror) content(slug string) ([]byte, error) {
+	resp, err := m.get("/api/v1/sync/content/" + url.PathEscape(slug))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, errContentGone
+	default:
+		return nil, fmt.Errorf("primary returned %s for content", resp.Status)
+	}
+}
+
+func (m *Mirror) get(path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, m.primary+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Api-Key", m.apiKey)
+	resp, err := m.client.Do(req)
+	return resp, egress.Explain(err)
+}
+
+// saveLocked writes the status to the state file. The caller holds mu.
+func (m *Mirror) saveLocked() {
+	data, err := json.MarshalIndent(m.status, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp := m.statePath + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(m.statePath), 0o755); err != nil {
+		log.Printf("[ERROR] Mirror: failed to save progress: %v", err)
+		return
+	}
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		log.Printf("[ERROR] Mirror: failed to save progress: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, m.statePath); err != nil {
+		log.Printf("[ERROR] Mirror: failed to save progress: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package mirror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/changes"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+// fakePrimary serves a fixed change feed and content the way the sync
+// handler does.
+type fakePrimary struct {
+	changes []changes.Change
+	content map[string]string
+	oldest  int64
+}
+
+func (p *fakePrimary) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Api-Key") != "sync-key" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if slug, ok := strings.CutPrefix(r.URL.Path, "/api/v1/sync/content/"); ok {
+		content, found := p.content[slug]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+		return
+	}
+	since, _ := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+	if since < p.oldest {
+		w.WriteHeader(http.StatusGone)
+		_ = json.NewEncoder(w).Encode(apierror.Response{
+			Code: apierror.CodeCursorExpired, Error: "expired", Detail: strconv.FormatInt(p.oldest, 10),
+		})
+		return
+	}
+	page := changes.Page{Cursor: since}
+	for _, ch := range p.changes {
+		if ch.Seq > since {
+			page.Changes = append(page.Changes, ch)
+			page.Cursor = ch.Seq
+		}
+	}
+	_ = json.NewEncoder(w).Encode(page)
+}
+
+func TestMirror_Sync(t *testing.T) {
+	primary := &fakePrimary{
+		changes: []changes.Change{
+			{Seq: 1, Op: changes.OpPut, Slug: "KEEP", Paste: &models.Paste{ContentType: "text/plain"}},
+			{Seq: 2, Op: changes.OpPut, Slug: "BURN", Paste: &models.Paste{BurnAfterRead: true}},
+			{Seq: 3, Op: changes.OpPut, Slug: "DRPD", Paste: &models.Paste{}},
+			{Seq: 4, Op: changes.OpDelete, Slug: "DRPD"},
+			{Seq: 5, Op: changes.OpPut, Slug: "LATE", Paste: &models.Paste{}},
+			{Seq: 6, Op: changes.OpPut, Slug: "TNNT", Paste: &models.Paste{Tenant: "eng"}},
+		},
+		content: map[string]string{"KEEP": "kept", "DRPD": "dropped", "TNNT": "tenant's"},
+	}
+	srv := httptest.NewServer(primary)
+	defer srv.Close()
+
+	backend, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := storage.NewTenantStore(backend)
+	statePath := filepath.Join(t.TempDir(), "mirror.json")
+	m := New(srv.URL+"/", "sync-key", store, statePath)
+	if err := m.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	if content, err := store.GetContent("KEEP"); err != nil || string(content) != "kept" {
+		t.Errorf("expected KEEP to be copied, got %q (%v)", content, err)
+	}
+	if paste, err := store.Get("KEEP"); err != nil || paste.ContentType != "text/plain" || paste.ID != "KEEP" {
+		t.Errorf("expected KEEP's metadata, got %+v (%v)", paste, err)
+	}
+	// Pastes of tenants go to their tenant's prefix, as on the primary.
+	if content, err := backend.GetContent("eng~TNNT"); err != nil || string(content) != "tenant's" {
+		t.Errorf("expected TNNT under its tenant's prefix, got %q (%v)", content, err)
+	}
+	if paste, err := store.Get("TNNT"); err != nil || paste.Tenant != "eng" {
+		t.Errorf("expected TNNT's metadata, got %+v (%v)", paste, err)
+	}
+	for _, slug := range []string{"BURN", "DRPD", "LATE"} {
+		if _, err := store.Get(slug); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("expected %s not to be on the mirror, got %v", slug, err)
+		}
+	}
+	status := m.Status()
+	if status.Cursor != 6 || status.Copied != 3 || status.Deleted != 1 || status.Skipped != 1 || status.LastError != "" {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	// A restarted mirror resumes from the saved cursor; one of another
+	// primary starts over.
+	if got := New(srv.URL, "sync-key", store, statePath).Status().Cursor; got != 6 {
+		t.Errorf("expected the cursor to be restored, got %d", got)
+	}
+	if got := New("http://other.example", "sync-key", store, statePath).Status().Cursor; got != 0 {
+		t.Errorf("expected a different primary to start over, got %d", got)
+	}
+}
+
+func TestMirror_CursorExpired(t *testing.T) {
+	primary := &fakePrimary{
+		changes: []changes.Change{
+			{Seq: 8, Op: changes.OpPut, Slug: "KEEP", Paste: &models.Paste{}},
+		},
+		content: map[string]string{"KEEP": "kept"},
+		oldest:  7,
+	}
+	srv := httptest.NewServer(primary)
+	defer srv.Close()
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(srv.URL, "sync-key", store, filepath.Join(t.TempDir(), "mirror.json"))
+	if err := m.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got := m.Status().Cursor; got != 8 {
+		t.Errorf("expected the mirror to continue from the oldest cursor, got %d", got)
+	}
+	if _, err := store.Get("KEEP"); err != nil {
+		t.Errorf("expected KEEP to be copied, got %v", err)
+	}
+}
+
+func TestMirror_Unauthorized(t *testing.T) {
+	srv := httptest.NewServer(&fakePrimary{})
+	defer srv.Close()
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New(srv.URL, "wrong", store, filepath.Join(t.TempDir(), "mirror.json"))
+	if err := m.Sync(); err == nil || m.Status().LastError == "" {
+		t.Errorf("expected the sync to fail, got %v", err)
+	}
+}
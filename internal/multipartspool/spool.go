@@ -0,0 +1,203 @@
+// Package multipartspool keeps multipart upload parts that are too large to
+// buffer in memory in a dedicated directory with a bounded total size.
+//
+// Without it, net/http spools large parts to os.TempDir with no limit, so
+// a burst of big uploads can fill /tmp. Each upload reserves its worst-case
+// size before anything is written; once the reservations reach the maximum,
+// further uploads are refused with ErrFull until space is released.
+//
+// Files are removed when they are closed. Files a crash leaves behind are
+// removed when the spool is opened and, for files no upload owns, by the
+// periodic cleanup started with Start.
+package multipartspool
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// filePrefix names spool files, so cleanup never touches anything else in
+// the directory.
+const filePrefix = "part-"
+
+// ErrFull is returned by Create while the spool cannot hold another
+// reservation of the requested size.
+var ErrFull = errors.New("multipart spool is full")
+
+// ErrTooLarge is returned by Create for reservations larger than the whole
+// spool, and by File.Write past a file's reservation.
+var ErrTooLarge = errors.New("content too large for the multipart spool")
+
+// Spool is a directory of temporary upload files with a maximum total
+// size. It is safe for concurrent use.
+type Spool struct {
+	dir string
+	max int64
+	now func() time.Time
+
+	mu   sync.Mutex
+	used int64
+	open map[string]bool
+}
+
+// New opens the spool in dir, creating it if needed, with room for max
+// bytes. Spool files left in dir by an earlier process are removed.
+func New(dir string, max int64) (*Spool, error) {
+	if max <= 0 {
+		return nil, fmt.Errorf("invalid multipart spool size: %d", max)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create multipart spool: %w", err)
+	}
+	s := &Spool{dir: dir, max: max, now: time.Now, open: map[string]bool{}}
+	if n := s.Clean(0); n > 0 {
+		log.Printf("[INFO] Removed %d abandoned multipart spool file(s) from %s", n, dir)
+	}
+	return s, nil
+}
+
+// Dir returns the spool directory.
+func (s *Spool) Dir() string { return s.dir }
+
+// Max returns the maximum total size of the spool in bytes.
+func (s *Spool) Max() int64 { return s.max }
+
+// Used returns the bytes currently reserved.
+func (s *Spool) Used() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.used
+}
+
+// Create reserves size bytes and creates a file that can hold them. The
+// caller must Close the file, which removes it and releases the
+// reservation.
+func (s *Spool) Create(size int64) (*File, error) {
+	if size > s.max {
+		return nil, ErrTooLarge
+	}
+	s.mu.Lock()
+	if s.used+size > s.max {
+		s.mu.Unlock()
+		return nil, ErrFull
+	}
+	s.used += size
+	s.mu.Unlock()
+
+	f, err := os.CreateTemp(s.dir, filePrefix+"*")
+	if err != nil {
+		s.release(size, "")
+		return nil, fmt.Errorf("create multipart spool file: %w", err)
+	}
+	s.mu.Lock()
+	s.open[f.Name()] = true
+	s.mu.Unlock()
+	return &File{f: f, spool: s, reserved: size}, nil
+}
+
+func (s *Spool) release(size int64, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used -= size
+	if name != "" {
+		delete(s.open, name)
+	}
+}
+
+// Clean removes spool files that no open File owns and that were last
+// modified more than minAge ago. It returns the number of files removed.
+func (s *Spool) Clean(minAge time.Duration) int {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		log.Printf("[ERROR] Failed to list multipart spool %s: %v", s.dir, err)
+		return 0
+	}
+	cutoff := s.now().Add(-minAge)
+	removed := 0
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), filePrefix) {
+			continue
+		}
+		path := filepath.Join(s.dir, e.Name())
+		s.mu.Lock()
+		owned := s.open[path]
+		s.mu.Unlock()
+		if owned {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("[WARN] Failed to remove abandoned multipart spool file %s: %v", path, err)
+			continue
+		}
+		removed++
+	}
+	return removed
+}
+
+// Start removes abandoned spool files every interval in the background.
+// Files younger than interval are kept, since another process sharing the
+// directory may still be writing them.
+func (s *Spool) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if n := s.Clean(interval); n > 0 {
+				log.Printf("[INFO] Removed %d abandoned multipart spool file(s) from %s", n, s.dir)
+			}
+		}
+	}()
+}
+
+// File is a spool file holding at most its reservation.
+type File struct {
+	f        *os.File
+	spool    *Spool
+	reserved int64
+	written  int64
+	closed   bool
+}
+
+// Write writes p, failing with ErrTooLarge past the file's reservation.
+func (f *File) Write(p []byte) (int, error) {
+	if f.written+int64(len(p)) > f.reserved {
+		return 0, ErrTooLarge
+	}
+	n, err := f.f.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+// Size returns the number of bytes written.
+func (f *File) Size() int64 { return f.written }
+
+// Reader returns a reader over everything written so far.
+func (f *File) Reader() io.Reader {
+	return io.NewSectionReader(f.f, 0, f.written)
+}
+
+// Close closes and removes the file and releases its reservation.
+func (f *File) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	name := f.f.Name()
+	err := f.f.Close()
+	if rmErr := os.Remove(name); rmErr != nil && !os.IsNotExist(rmErr) && err == nil {
+		err = rmErr
+	}
+	f.spool.release(f.reserved, name)
+	return err
+}
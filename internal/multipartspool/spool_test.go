@@ -0,0 +1,116 @@
+package multipartspool
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNew_RemovesLeftovers(t *testing.T) {
+	dir := t.TempDir()
+	leftover := filepath.Join(dir, filePrefix+"123")
+	other := filepath.Join(dir, "keep.txt")
+	for _, p := range []string{leftover, other} {
+		if err := os.WriteFile(p, []byte("x"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := New(dir, 100); err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Errorf("leftover spool file still exists: %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("unrelated file removed: %v", err)
+	}
+	if _, err := New(dir, 0); err == nil {
+		t.Error("New with size 0 succeeded")
+	}
+}
+
+func TestSpool_Reservations(t *testing.T) {
+	s, err := New(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(101); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Create(101) = %v, want ErrTooLarge", err)
+	}
+	f, err := s.Create(60)
+	if err != nil {
+		t.Fatalf("Create(60): %v", err)
+	}
+	if _, err := s.Create(41); !errors.Is(err, ErrFull) {
+		t.Fatalf("Create(41) = %v, want ErrFull", err)
+	}
+	if got := s.Used(); got != 60 {
+		t.Errorf("Used() = %d, want 60", got)
+	}
+
+	if _, err := f.Write(make([]byte, 50)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := f.Write(make([]byte, 11)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Write past reservation = %v, want ErrTooLarge", err)
+	}
+	data, err := io.ReadAll(f.Reader())
+	if err != nil || len(data) != 50 {
+		t.Errorf("Reader returned %d bytes, %v; want 50", len(data), err)
+	}
+
+	name := f.f.Name()
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("spool file not removed on Close: %v", err)
+	}
+	if got := s.Used(); got != 0 {
+		t.Errorf("Used() after Close = %d, want 0", got)
+	}
+	if _, err := s.Create(100); err != nil {
+		t.Errorf("Create(100) after release: %v", err)
+	}
+}
+
+func TestSpool_Clean(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owned, err := s.Create(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = owned.Close() }()
+	abandoned := filepath.Join(dir, filePrefix+"abandoned")
+	fresh := filepath.Join(dir, filePrefix+"fresh")
+	for _, p := range []string{abandoned, fresh} {
+		if err := os.WriteFile(p, []byte("x"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	for _, p := range []string{abandoned, owned.f.Name()} {
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := s.Clean(time.Hour); n != 1 {
+		t.Errorf("Clean removed %d file(s), want 1", n)
+	}
+	if _, err := os.Stat(abandoned); !os.IsNotExist(err) {
+		t.Errorf("abandoned file still exists: %v", err)
+	}
+	for _, p := range []string{fresh, owned.f.Name()} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("%s removed: %v", filepath.Base(p), err)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// Package policysim previews lifecycle settings against the pastes in a
+// store: what expires over the next days, where storage use is heading and
+// which pastes break the configured limits. It reads metadata only, so it
+// can run against a live backend before a configuration change is applied.
+package policysim
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+// ErrNotListable is returned for stores that cannot enumerate pastes.
+var ErrNotListable = errors.New("the store cannot list pastes")
+
+// Day is 24 hours.
+const Day = 24 * time.Hour
+
+// MaxExamples is how many violating pastes a report lists; all of them are
+// counted.
+const MaxExamples = 50
+
+// lifetimeSlack absorbs the time between computing a paste's expiry and
+// stamping its creation, so pastes stored with exactly the limit pass.
+const lifetimeSlack = time.Minute
+
+// Rules a paste can violate.
+const (
+	// RuleMinRetention: the paste expires sooner after creation than
+	// NCLIP_MIN_RETENTION allows.
+	RuleMinRetention = "min_retention"
+	// RuleMaxTTL: the paste lives longer than the longest TTL an upload can
+	// ask for (or NCLIP_MIN_RETENTION, if longer).
+	RuleMaxTTL = "max_ttl"
+	// RuleBufferSize: the paste is larger than NCLIP_BUFFER_SIZE.
+	RuleBufferSize = "buffer_size"
+	// RuleKeyMaxSize: the paste is larger than the max_size of the API key
+	// that uploaded it (or of anonymous uploads).
+	RuleKeyMaxSize = "key_max_size"
+	// RuleMaxVersions: the paste keeps more versions than NCLIP_MAX_VERSIONS.
+	RuleMaxVersions = "max_versions"
+)
+
+// Policy is the lifecycle configuration to simulate.
+type Policy struct {
+	// DefaultTTL is the lifetime of uploads without X-TTL.
+	DefaultTTL time.Duration
+	// MinRetention raises shorter lifetimes to it; 0 disables it.
+	MinRetention time.Duration
+	// MaxTTL is the longest lifetime an upload can ask for.
+	MaxTTL time.Duration
+	// MaxSize is the global upload size limit; 0 disables the check.
+	MaxSize int64
+	// OwnerLimits maps owners (audit key IDs, or "" for anonymous uploads)
+	// to their upload size limits.
+	OwnerLimits map[string]int64
+	// MaxVersions is how many earlier contents a paste keeps.
+	MaxVersions int
+}
+
+// newPasteLifetime is how long pastes uploaded from now on live by default.
+func (p Policy) newPasteLifetime() time.Duration {
+	return max(p.DefaultTTL, p.MinRetention)
+}
+
+// DayUsage is the pastes and bytes of one day of the simulation.
+type DayUsage struct {
+	// Date is the end of the day, counted in 24-hour steps from the start
+	// of the simulation.
+	Date   time.Time `json:"date"`
+	Pastes int       `json:"pastes"`
+	Bytes  int64     `json:"bytes"`
+}
+
+// Violation is a paste breaking a rule.
+type Violation struct {
+	Slug   string `json:"slug"`
+	Rule   string `json:"rule"`
+	Detail string `json:"detail"`
+}
+
+// Report is the result of a simulation.
+type Report struct {
+	Now  time.Time `json:"now"`
+	Days int       `json:"days"`
+	// Pastes and Bytes are the live pastes and their content, including
+	// earlier versions.
+	Pastes int   `json:"pastes"`
+	Bytes  int64 `json:"bytes"`
+	// Permanent counts pastes that never expire: pinned, on legal hold or
+	// without an expiry.
+	Permanent int `json:"permanent"`
+	// Expired counts listed pastes that have expired but were not removed
+	// yet. Their size is unknown, since their metadata reads as missing.
+	Expired int `json:"expired"`
+	// Expiring is what expires on each of the next Days days.
+	Expiring []DayUsage `json:"expiring"`
+	// Ingest is what was uploaded in the last 24 hours, the rate the
+	// projection assumes.
+	Ingest DayUsage `json:"ingest"`
+	// NewPasteLifetime is how long the projected uploads live.
+	NewPasteLifetime time.Duration `json:"new_paste_lifetime"`
+	// Projected is the expected storage use at the end of each day.
+	Projected []DayUsage `json:"projected"`
+	// ViolationCounts counts violations by rule.
+	ViolationCounts map[string]int `json:"violation_counts"`
+	// Violations lists the first MaxExamples violations in slug order.
+	Violations []Violation `json:"violations"`
+}
+
+// Simulate runs policy against the pastes in store over the days after
+// now. The store should be read-only, so reading expired pastes does not
+// remove them.
+func Simulate(store storage.PasteStore, policy Policy, days int, now time.Time) (*Report, error) {
+	lister, ok := store.(storage.Lister)
+	if !ok {
+		return nil, ErrNotListable
+	}
+	r := &Report{
+		Now:              now,
+		Days:             days,
+		Expiring:         make([]DayUsage, days),
+		NewPasteLifetime: policy.newPasteLifetime(),
+		Projected:        make([]DayUsage, days),
+		ViolationCounts:  map[string]int{},
+	}
+	for i := range days {
+		r.Expiring[i].Date = now.Add(time.Duration(i+1) * Day)
+		r.Projected[i].Date = r.Expiring[i].Date
+	}
+	r.Ingest.Date = now
+
+	var expiring []*models.Paste
+	cursor := ""
+	for {
+		page, err := lister.List(storage.ListOptions{Cursor: cursor, Limit: 1000})
+		if err != nil {
+			return nil, fmt.Errorf("list pastes: %w", err)
+		}
+		for _, id := range page.IDs {
+			paste, err := store.Get(id)
+			if errors.Is(err, storage.ErrNotFound) || (err == nil && paste.IsExpired()) {
+				r.Expired++
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("read %s: %w", id, err)
+			}
+			if r.add(paste, policy) {
+				expiring = append(expiring, paste)
+			}
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	r.project(expiring)
+	sort.Slice(r.Violations, func(i, j int) bool { return r.Violations[i].Slug < r.Violations[j].Slug })
+	if len(r.Violations) > MaxExamples {
+		r.Violations = r.Violations[:MaxExamples]
+	}
+	return r, nil
+}
+
+// add counts paste, a live paste, and checks it against policy. It reports
+// whether the paste expires at some point.
+func (r *Report) add(paste *models.Paste, policy Policy) bool {
+	size := storedSize(paste)
+	r.Pastes++
+	r.Bytes += size
+	if r.Now.Sub(paste.CreatedAt) <= Day {
+		r.Ingest.Pastes++
+		r.Ingest.Bytes += size
+	}
+
+	permanent := paste.ExpiresAt == nil || paste.Pinned || paste.LegalHold
+	if permanent {
+		r.Permanent++
+	} else {
+		lifetime := paste.ExpiresAt.Sub(paste.CreatedAt)
+		if policy.MinRetention > 0 && lifetime < policy.MinRetention-lifetimeSlack {
+			r.violate(paste, RuleMinRetention, "lives %s, less than %s", lifetime.Round(time.Minute), policy.MinRetention)
+		}
+		if longest := max(policy.MaxTTL, policy.MinRetention); longest > 0 && lifetime > longest+lifetimeSlack {
+			r.violate(paste, RuleMaxTTL, "lives %s, more than %s", lifetime.Round(time.Minute), longest)
+		}
+		for i := range r.Expiring {
+			if !paste.ExpiresAt.After(r.Expiring[i].Date) {
+				r.Expiring[i].Pastes++
+				r.Expiring[i].Bytes += size
+				break
+			}
+		}
+	}
+
+	if policy.MaxSize > 0 && paste.Size > policy.MaxSize {
+		r.violate(paste, RuleBufferSize, "%d bytes, more than %d", paste.Size, policy.MaxSize)
+	}
+	if limit, ok := policy.OwnerLimits[paste.Owner]; ok && paste.Size > limit {
+		owner := paste.Owner
+		if owner == "" {
+			owner = "anonymous uploads"
+		}
+		r.violate(paste, RuleKeyMaxSize, "%d bytes, more than the %d allowed for %s", paste.Size, limit, owner)
+	}
+	if len(paste.Versions) > policy.MaxVersions {
+		r.violate(paste, RuleMaxVersions, "keeps %d versions, more than %d", len(paste.Versions), policy.MaxVersions)
+	}
+	return !permanent
+}
+
+func (r *Report) violate(paste *models.Paste, rule, format string, args ...any) {
+	r.ViolationCounts[rule]++
+	r.Violations = append(r.Violations, Violation{Slug: paste.ID, Rule: rule, Detail: fmt.Sprintf(format, args...)})
+}
+
+// project fills in Projected: today's pastes minus those expired by the
+// end of each day, plus uploads at the Ingest rate that have not expired
+// yet either.
+func (r *Report) project(expiring []*models.Paste) {
+	lifetimeDays := float64(r.NewPasteLifetime) / float64(Day)
+	for i := range r.Projected {
+		day := &r.Projected[i]
+		day.Pastes, day.Bytes = r.Pastes, r.Bytes
+		for _, paste := range expiring {
+			if !paste.ExpiresAt.After(day.Date) {
+				day.Pastes--
+				day.Bytes -= storedSize(paste)
+			}
+		}
+		live := min(float64(i+1), lifetimeDays)
+		day.Pastes += int(float64(r.Ingest.Pastes) * live)
+		day.Bytes += int64(float64(r.Ingest.Bytes) * live)
+	}
+}
+
+// storedSize is the size of paste's content and earlier versions.
+func storedSize(paste *models.Paste) int64 {
+	size := paste.Size
+	for _, v := range paste.Versions {
+		size += v.Size
+	}
+	return size
+}
@@ -0,0 +1,74 @@
+package policysim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func TestSimulate(t *testing.T) {
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	at := func(d time.Duration) *time.Time { t := now.Add(d); return &t }
+	for _, p := range []*models.Paste{
+		// Uploaded an hour ago for a day: expires on day 1.
+		{ID: "NEWEST", CreatedAt: now.Add(-time.Hour), ExpiresAt: at(23 * time.Hour), Size: 100},
+		// Expires on day 3, keeps too many versions.
+		{ID: "VRSNS", CreatedAt: now.Add(-2 * Day), ExpiresAt: at(2*Day + time.Hour), Size: 10,
+			Versions: []models.PasteVersion{{Size: 5}, {Size: 5}}},
+		// Lives 30 days, longer than MaxTTL.
+		{ID: "LENGTHY", CreatedAt: now.Add(-Day - time.Hour), ExpiresAt: at(29 * Day), Size: 1000, Owner: "key:abc"},
+		// Pinned, and larger than its owner may upload.
+		{ID: "PERMA", CreatedAt: now.Add(-10 * Day), ExpiresAt: at(-Day), Pinned: true, Size: 5000, Owner: "key:abc"},
+		// Expired, not yet removed.
+		{ID: "EXPRD", CreatedAt: now.Add(-2 * Day), ExpiresAt: at(-Day), Size: 1},
+	} {
+		if err := store.Store(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.SetReadOnly(true)
+
+	policy := Policy{
+		DefaultTTL:   Day,
+		MinRetention: 2 * Day,
+		MaxTTL:       7 * Day,
+		MaxSize:      4096,
+		OwnerLimits:  map[string]int64{"key:abc": 2048},
+		MaxVersions:  1,
+	}
+	r, err := Simulate(store, policy, 3, now)
+	if err != nil {
+		t.Fatalf("Simulate: %v", err)
+	}
+	if r.Pastes != 4 || r.Bytes != 6120 || r.Permanent != 1 || r.Expired != 1 {
+		t.Errorf("totals = %d pastes, %d bytes, %d permanent, %d expired", r.Pastes, r.Bytes, r.Permanent, r.Expired)
+	}
+	if r.Expiring[0].Pastes != 1 || r.Expiring[0].Bytes != 100 || r.Expiring[1].Pastes != 0 ||
+		r.Expiring[2].Pastes != 1 || r.Expiring[2].Bytes != 20 {
+		t.Errorf("expiring = %+v", r.Expiring)
+	}
+	// Ingest: NEWEST (100 bytes) a day, kept for MinRetention (2 days).
+	if r.Ingest.Pastes != 1 || r.NewPasteLifetime != 2*Day {
+		t.Errorf("ingest = %+v, lifetime %s", r.Ingest, r.NewPasteLifetime)
+	}
+	for i, want := range []int64{6020 + 100, 6020 + 200, 6000 + 200} {
+		if r.Projected[i].Bytes != want {
+			t.Errorf("projected day %d = %d bytes, want %d", i+1, r.Projected[i].Bytes, want)
+		}
+	}
+	want := map[string]int{RuleMinRetention: 1, RuleMaxTTL: 1, RuleBufferSize: 1, RuleKeyMaxSize: 1, RuleMaxVersions: 1}
+	for rule, n := range want {
+		if r.ViolationCounts[rule] != n {
+			t.Errorf("%s violations = %d, want %d (%+v)", rule, r.ViolationCounts[rule], n, r.Violations)
+		}
+	}
+	if ok, _ := store.Exists("EXPRD"); !ok {
+		t.Error("the simulation removed an expired paste")
+	}
+}
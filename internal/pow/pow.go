@@ -0,0 +1,201 @@
+// Package pow implements the hashcash-style proof of work that uploads
+// without an API key can be required to carry. A client fetches a signed
+// challenge, finds a counter such that SHA-256("<nonce>:<counter>") starts
+// with the requested number of zero bits, and sends "<nonce>:<counter>" in
+// the X-PoW header. Challenges are stateless until solved; solved nonces
+// are remembered until they expire so a solution cannot be replayed.
+package pow
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/hmackeys"
+)
+
+// Header is the request header carrying a solution.
+const Header = "X-PoW"
+
+// Algorithm names the hash clients must use, reported with each challenge.
+const Algorithm = "sha256"
+
+// Validity is how long a challenge can be solved and used.
+const Validity = 5 * time.Minute
+
+// MaxDifficulty bounds the configurable difficulty (leading zero bits).
+const MaxDifficulty = 32
+
+// maxUsed bounds the replay set before expired nonces are pruned.
+const maxUsed = 10000
+
+// Errors returned by Verify.
+var (
+	ErrMissing  = errors.New("proof of work required")
+	ErrInvalid  = errors.New("invalid proof of work")
+	ErrExpired  = errors.New("proof of work challenge has expired")
+	ErrReplayed = errors.New("proof of work was already used")
+	// ErrUnavailable wraps errors of the ReplayStore.
+	ErrUnavailable = errors.New("proof of work cannot be checked for replay")
+)
+
+// Challenge is the JSON body of GET /api/v1/challenge.
+type Challenge struct {
+	Nonce      string    `json:"nonce"`
+	Difficulty int       `json:"difficulty"`
+	Algorithm  string    `json:"algorithm"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// ReplayStore remembers used nonces for several instances, such as a
+// Redis server.
+type ReplayStore interface {
+	// Use records nonce as used until expires and reports whether it was
+	// unused before.
+	Use(nonce string, expires time.Time) (bool, error)
+}
+
+// Verifier issues challenges and verifies solutions. It is safe for
+// concurrent use. The replay set is kept in memory unless a ReplayStore is
+// set, so with several instances a solution is otherwise only rejected a
+// second time by the instance that accepted it first.
+type Verifier struct {
+	keys       *hmackeys.Ring
+	difficulty int
+	replay     ReplayStore
+
+	mu   sync.Mutex
+	used map[string]time.Time
+	now  func() time.Time
+}
+
+// NewVerifier creates a Verifier requiring difficulty leading zero bits,
+// signing challenges with keys.
+func NewVerifier(keys *hmackeys.Ring, difficulty int) *Verifier {
+	return &Verifier{
+		keys:       keys,
+		difficulty: difficulty,
+		used:       make(map[string]time.Time),
+		now:        time.Now,
+	}
+}
+
+// SetReplayStore remembers used nonces in r instead of in memory.
+func (v *Verifier) SetReplayStore(r ReplayStore) {
+	v.replay = r
+}
+
+// Difficulty returns the number of leading zero bits required.
+func (v *Verifier) Difficulty() int {
+	return v.difficulty
+}
+
+// Challenge issues a new challenge. The nonce is "<expiry>.<random>.<sig>".
+func (v *Verifier) Challenge() (Challenge, error) {
+	var random [12]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return Challenge{}, err
+	}
+	expires := v.now().Add(Validity)
+	payload := strconv.FormatInt(expires.Unix(), 10) + "." + hex.EncodeToString(random[:])
+	return Challenge{
+		Nonce:      payload + "." + v.keys.Sign(challengeMessage(payload)),
+		Difficulty: v.difficulty,
+		Algorithm:  Algorithm,
+		ExpiresAt:  time.Unix(expires.Unix(), 0).UTC(),
+	}, nil
+}
+
+// Verify checks a solution sent in the X-PoW header and records its nonce
+// as used. It returns ErrMissing for an empty solution, ErrInvalid for a
+// forged nonce or too few zero bits, ErrExpired for an expired challenge
+// and ErrReplayed for a nonce that was already used. Solutions are
+// rejected with ErrUnavailable while the ReplayStore fails.
+func (v *Verifier) Verify(solution string) error {
+	if solution == "" {
+		return ErrMissing
+	}
+	nonce, counter, ok := strings.Cut(solution, ":")
+	if !ok || counter == "" || len(counter) > 20 {
+		return ErrInvalid
+	}
+	payload, sig, ok := cutLast(nonce, ".")
+	if !ok {
+		return ErrInvalid
+	}
+	if !v.keys.Verify(challengeMessage(payload), sig) {
+		return ErrInvalid
+	}
+	exp, _, _ := strings.Cut(payload, ".")
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return ErrInvalid
+	}
+	expires := time.Unix(unix, 0)
+	now := v.now()
+	if !now.Before(expires) {
+		return ErrExpired
+	}
+	sum := sha256.Sum256([]byte(solution))
+	if leadingZeros(sum[:]) < v.difficulty {
+		return ErrInvalid
+	}
+
+	if v.replay != nil {
+		unused, err := v.replay.Use(nonce, expires)
+		switch {
+		case err != nil:
+			return fmt.Errorf("%w: %w", ErrUnavailable, err)
+		case !unused:
+			return ErrReplayed
+		}
+		return nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, seen := v.used[nonce]; seen {
+		return ErrReplayed
+	}
+	if len(v.used) >= maxUsed {
+		for n, exp := range v.used {
+			if !now.Before(exp) {
+				delete(v.used, n)
+			}
+		}
+	}
+	v.used[nonce] = expires
+	return nil
+}
+
+// challengeMessage is the message a challenge signs. The prefix separates
+// challenges from the links and cookies signed with the same keys.
+func challengeMessage(payload string) string {
+	return "pow:" + payload
+}
+
+// leadingZeros returns the number of leading zero bits of b.
+func leadingZeros(b []byte) int {
+	n := 0
+	for _, x := range b {
+		if x != 0 {
+			return n + bits.LeadingZeros8(x)
+		}
+		n += 8
+	}
+	return n
+}
+
+// cutLast is strings.Cut around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,140 @@
+package pow
+
+import (
+	"crypto/sha256"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/hmackeys"
+)
+
+// solve brute-forces a solution for nonce, as clients do.
+func solve(t *testing.T, nonce string, difficulty int) string {
+	t.Helper()
+	for i := 0; i < 1<<24; i++ {
+		solution := nonce + ":" + strconv.Itoa(i)
+		sum := sha256.Sum256([]byte(solution))
+		if leadingZeros(sum[:]) >= difficulty {
+			return solution
+		}
+	}
+	t.Fatalf("no solution found for %s", nonce)
+	return ""
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	v := NewVerifier(hmackeys.New("secret", nil), 8)
+	ch, err := v.Challenge()
+	if err != nil {
+		t.Fatalf("Challenge: %v", err)
+	}
+	if ch.Difficulty != 8 || ch.Algorithm != Algorithm {
+		t.Fatalf("unexpected challenge %+v", ch)
+	}
+	solution := solve(t, ch.Nonce, 8)
+
+	if err := v.Verify(solution); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := v.Verify(solution); !errors.Is(err, ErrReplayed) {
+		t.Errorf("expected ErrReplayed, got %v", err)
+	}
+
+	other, _ := v.Challenge()
+	for name, tc := range map[string]struct {
+		solution string
+		want     error
+	}{
+		"empty":          {"", ErrMissing},
+		"no counter":     {other.Nonce, ErrInvalid},
+		"forged nonce":   {solve(t, other.Nonce+"x", 8), ErrInvalid},
+		"other secret":   {solve(t, mustChallenge(t, NewVerifier(hmackeys.New("other", nil), 8)).Nonce, 8), ErrInvalid},
+		"too few zeroes": {unsolved(t, other.Nonce, 8), ErrInvalid},
+	} {
+		if err := v.Verify(tc.solution); !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", name, tc.want, err)
+		}
+	}
+
+	late := solve(t, other.Nonce, 8)
+	v.now = func() time.Time { return time.Now().Add(Validity + time.Second) }
+	if err := v.Verify(late); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired, got %v", err)
+	}
+}
+
+// sharedReplay is a ReplayStore shared by the verifiers of several
+// instances.
+type sharedReplay struct {
+	used map[string]time.Time
+	err  error
+}
+
+func (s *sharedReplay) Use(nonce string, expires time.Time) (bool, error) {
+	if s.err != nil {
+		return false, s.err
+	}
+	if _, ok := s.used[nonce]; ok {
+		return false, nil
+	}
+	s.used[nonce] = expires
+	return true, nil
+}
+
+func TestVerifier_ReplayStore(t *testing.T) {
+	replay := &sharedReplay{used: map[string]time.Time{}}
+	a, b := NewVerifier(hmackeys.New("secret", nil), 4), NewVerifier(hmackeys.New("secret", nil), 4)
+	a.SetReplayStore(replay)
+	b.SetReplayStore(replay)
+
+	solution := solve(t, mustChallenge(t, a).Nonce, 4)
+	if err := a.Verify(solution); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := b.Verify(solution); !errors.Is(err, ErrReplayed) {
+		t.Errorf("replay on another instance: expected ErrReplayed, got %v", err)
+	}
+
+	replay.err = errors.New("connection refused")
+	if err := a.Verify(solve(t, mustChallenge(t, a).Nonce, 4)); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected ErrUnavailable while the replay store is down, got %v", err)
+	}
+}
+
+func TestLeadingZeros(t *testing.T) {
+	cases := map[int][]byte{
+		0:  {0x80},
+		3:  {0x10, 0xff},
+		8:  {0x00, 0xff},
+		12: {0x00, 0x0f},
+		16: {0x00, 0x00},
+	}
+	for want, b := range cases {
+		if got := leadingZeros(b); got != want {
+			t.Errorf("leadingZeros(%x) = %d, want %d", b, got, want)
+		}
+	}
+}
+
+func mustChallenge(t *testing.T, v *Verifier) Challenge {
+	t.Helper()
+	ch, err := v.Challenge()
+	if err != nil {
+		t.Fatalf("Challenge: %v", err)
+	}
+	return ch
+}
+
+// unsolved returns a counter for nonce whose hash misses the difficulty.
+func unsolved(t *testing.T, nonce string, difficulty int) string {
+	t.Helper()
+	for i := 0; ; i++ {
+		solution := nonce + ":" + strconv.Itoa(i)
+		sum := sha256.Sum256([]byte(solution))
+		if leadingZeros(sum[:]) < difficulty {
+			return solution
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Package presignupload issues the tickets of direct uploads: the client
+// stores the content itself through a presigned storage URL, then presents
+// the ticket to have the paste's metadata written. Tickets are stateless,
+// like upload links: what the client declared travels inside the signed
+// token.
+package presignupload
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/hmackeys"
+)
+
+// Errors returned by Decode.
+var (
+	ErrInvalid = errors.New("invalid upload ticket")
+	ErrExpired = errors.New("upload ticket has expired")
+)
+
+const (
+	// URLValidity is how long the presigned URL accepts the upload.
+	URLValidity = 15 * time.Minute
+	// FinalizeGrace is how long after the URL expires a ticket can still
+	// be finalized, for uploads that started just before.
+	FinalizeGrace = 15 * time.Minute
+)
+
+// Ticket describes one direct upload.
+type Ticket struct {
+	// Slug is the paste the content is uploaded to.
+	Slug string `json:"slug"`
+	// Size is the exact content size in bytes; the presigned URL only
+	// accepts that many.
+	Size int64 `json:"size"`
+	// ContentType is the declared type, or empty to detect it.
+	ContentType string `json:"type,omitempty"`
+	Filename    string `json:"name,omitempty"`
+	// TTL is the lifetime of the created paste, in seconds.
+	TTL  int64 `json:"ttl"`
+	Burn bool  `json:"burn,omitempty"`
+	// Owner is the audit key ID of the API key that asked for the upload.
+	Owner string `json:"owner,omitempty"`
+	// Tenant is the tenant of that key.
+	Tenant string `json:"tenant,omitempty"`
+	// Expires is when the ticket can no longer be finalized (Unix
+	// seconds).
+	Expires int64 `json:"exp"`
+}
+
+// ExpiresAt returns Expires as a time.
+func (t *Ticket) ExpiresAt() time.Time {
+	return time.Unix(t.Expires, 0)
+}
+
+// PasteTTL returns TTL as a duration.
+func (t *Ticket) PasteTTL() time.Duration {
+	return time.Duration(t.TTL) * time.Second
+}
+
+// Signer issues and verifies tickets.
+type Signer struct {
+	keys *hmackeys.Ring
+	now  func() time.Time
+}
+
+// NewSigner creates a Signer signing with keys.
+func NewSigner(keys *hmackeys.Ring) *Signer {
+	return &Signer{keys: keys, now: time.Now}
+}
+
+// New completes t with its expiry: URLValidity plus FinalizeGrace from
+// now.
+func (s *Signer) New(t Ticket) *Ticket {
+	t.Expires = s.now().Add(URLValidity + FinalizeGrace).Unix()
+	return &t
+}
+
+// Encode serializes t as "<payload>.<signature>", the payload base64url
+// and the signature as made by hmackeys.Ring.Sign.
+func (s *Signer) Encode(t *Ticket) string {
+	data, _ := json.Marshal(t)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + s.keys.Sign(ticketMessage(payload))
+}
+
+// Decode verifies token and returns its ticket. It returns ErrInvalid for
+// malformed or forged tokens and ErrExpired once the ticket has expired.
+func (s *Signer) Decode(token string) (*Ticket, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalid
+	}
+	if !s.keys.Verify(ticketMessage(payload), sig) {
+		return nil, ErrInvalid
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	var t Ticket
+	if err := json.Unmarshal(data, &t); err != nil || t.Slug == "" || t.Size <= 0 || t.TTL <= 0 {
+		return nil, ErrInvalid
+	}
+	if !s.now().Before(t.ExpiresAt()) {
+		return nil, ErrExpired
+	}
+	return &t, nil
+}
+
+// ticketMessage is the message a ticket signs. The prefix separates
+// ticket signatures from upload links and session cookies signed with the
+// same keys.
+func ticketMessage(payload string) string {
+	return "presign-upload:" + payload
+}
@@ -0,0 +1,43 @@
+package presignupload
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/hmackeys"
+)
+
+func TestSigner_EncodeDecode(t *testing.T) {
+	s := NewSigner(hmackeys.New("secret", nil))
+	ticket := s.New(Ticket{Slug: "ABCDE", Size: 1 << 20, ContentType: "image/png", TTL: 3600, Owner: "key1"})
+	token := s.Encode(ticket)
+
+	got, err := s.Decode(token)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if *got != *ticket || got.PasteTTL() != time.Hour {
+		t.Errorf("expected %+v, got %+v", ticket, got)
+	}
+
+	payload, sig, _ := strings.Cut(token, ".")
+	forged := *ticket
+	forged.Size = 1 << 30
+	forgedPayload, _, _ := strings.Cut(s.Encode(&forged), ".")
+	for name, bad := range map[string]string{
+		"tampered signature": payload + "." + sig + "x",
+		"swapped payload":    forgedPayload + "." + sig,
+		"no signature":       payload,
+	} {
+		if _, err := s.Decode(bad); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: expected ErrInvalid, got %v", name, err)
+		}
+	}
+
+	s.now = func() time.Time { return time.Now().Add(URLValidity + FinalizeGrace + time.Minute) }
+	if _, err := s.Decode(token); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired, got %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+package preview
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+	"github.com/johnwmail/nclip/utils"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// Lines is the number of content lines rendered.
+	Lines = 20
+	// MaxInput is how much of a paste is read to render a preview.
+	MaxInput = 16 * 1024
+
+	// The image is drawn at half size with the 7x13 bitmap font and scaled
+	// up 2x, giving the 1200x630 size social cards expect.
+	width, height = 600, 315
+	scale         = 2
+	lineHeight    = 15
+	charWidth     = 7
+	marginX       = 8
+	marginY       = 6
+	gutter        = 4 * charWidth
+	maxColumns    = (width - 2*marginX - gutter) / charWidth
+)
+
+// ContentType is the MIME type of rendered previews.
+const ContentType = "image/png"
+
+var (
+	colorBackground = color.RGBA{0x1e, 0x1e, 0x2e, 0xff}
+	colorGutter     = color.RGBA{0x58, 0x5b, 0x70, 0xff}
+)
+
+// kindColors are the colors tokens are drawn in.
+var kindColors = map[Kind]color.Color{
+	KindText:    color.RGBA{0xcd, 0xd6, 0xf4, 0xff},
+	KindComment: color.RGBA{0x7f, 0x84, 0x9c, 0xff},
+	KindString:  color.RGBA{0xa6, 0xe3, 0xa1, 0xff},
+	KindNumber:  color.RGBA{0xfa, 0xb3, 0x87, 0xff},
+	KindKeyword: color.RGBA{0xcb, 0xa6, 0xf7, 0xff},
+}
+
+// keywords are highlighted regardless of language; the set covers the
+// common ground of the languages people paste most.
+var keywords = map[string]bool{
+	"break": true, "case": true, "class": true, "const": true, "continue": true,
+	"def": true, "default": true, "do": true, "elif": true, "else": true,
+	"export": true, "false": true, "fn": true, "for": true, "from": true,
+	"func": true, "function": true, "if": true, "import": true, "in": true,
+	"interface": true, "let": true, "nil": true, "none": true, "null": true,
+	"package": true, "pub": true, "return": true, "select": true, "struct": true,
+	"switch": true, "true": true, "type": true, "var": true, "while": true,
+}
+
+// CacheID is the storage id under which the preview for slug is cached.
+func CacheID(slug string) string {
+	return slug + storage.PreviewSuffix
+}
+
+// Eligible reports whether a preview may be generated for paste. Burn-after-
+// read pastes are skipped because rendering would reveal their content
+// without consuming them, private and quarantined pastes because the image
+// is cached publicly, corrupt pastes because their content is no longer
+// served, and binary pastes have nothing to render.
+func Eligible(paste *models.Paste) bool {
+	return paste != nil && !paste.BurnAfterRead && !paste.IsPrivate() && !paste.Quarantined && !paste.Corrupt && utils.IsTextContent(paste.ContentType)
+}
+
+// Render draws the first Lines lines of content with basic syntax colors
+// and returns the PNG encoding.
+func Render(content []byte) ([]byte, error) {
+	small := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.Draw(small, small.Bounds(), image.NewUniform(colorBackground), image.Point{}, xdraw.Src)
+
+	d := &font.Drawer{Dst: small, Face: basicfont.Face7x13}
+	for i, line := range firstLines(content, Lines) {
+		baseline := marginY + (i+1)*lineHeight - 3
+		num := strconv.Itoa(i + 1)
+		drawText(d, colorGutter, marginX+gutter-(len(num)+1)*charWidth, baseline, num)
+		x := marginX + gutter
+		for _, tok := range Tokenize(line) {
+			drawText(d, kindColors[tok.Kind], x, baseline, tok.Text)
+			x += len([]rune(tok.Text)) * charWidth
+		}
+	}
+
+	big := image.NewRGBA(image.Rect(0, 0, width*scale, height*scale))
+	xdraw.NearestNeighbor.Scale(big, big.Bounds(), small, small.Bounds(), xdraw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, big); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func drawText(d *font.Drawer, c color.Color, x, y int, s string) {
+	d.Src = image.NewUniform(c)
+	d.Dot = fixed.P(x, y)
+	d.DrawString(s)
+}
+
+// firstLines returns up to n lines of content with tabs expanded, control
+// characters removed and each line cut to the visible width.
+func firstLines(content []byte, n int) []string {
+	text := strings.ReplaceAll(string(content), "\r\n", "\n")
+	lines := strings.SplitN(text, "\n", n+1)
+	if len(lines) > n {
+		lines = lines[:n]
+	}
+	for i, line := range lines {
+		line = strings.ReplaceAll(line, "\t", "    ")
+		line = strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) {
+				return -1
+			}
+			return r
+		}, line)
+		if r := []rune(line); len(r) > maxColumns {
+			line = string(r[:maxColumns])
+		}
+		lines[i] = line
+	}
+	return lines
+}
+
+// Kind classifies a highlighted token.
+type Kind string
+
+// Token kinds; they double as CSS class suffixes in embedded pastes.
+const (
+	KindText    Kind = "text"
+	KindComment Kind = "comment"
+	KindString  Kind = "string"
+	KindNumber  Kind = "number"
+	KindKeyword Kind = "keyword"
+)
+
+// Token is a highlighted piece of a line.
+type Token struct {
+	Text string
+	Kind Kind
+}
+
+// Tokenize splits a line into highlighted tokens using language-agnostic
+// heuristics: line comments, quoted strings, numbers and common keywords.
+func Tokenize(line string) []Token {
+	var toks []Token
+	r := []rune(line)
+	for i := 0; i < len(r); {
+		rest := string(r[i:])
+		switch {
+		case strings.HasPrefix(rest, "//") || strings.HasPrefix(rest, "#") || strings.HasPrefix(rest, "-- "):
+			return append(toks, Token{rest, KindComment})
+		case r[i] == '"' || r[i] == '\'' || r[i] == '`':
+			j := i + 1
+			for j < len(r) && r[j] != r[i] {
+				if r[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(r) {
+				j++
+			}
+			if j > len(r) {
+				j = len(r)
+			}
+			toks = append(toks, Token{string(r[i:j]), KindString})
+			i = j
+		case isWordRune(r[i]):
+			j := i
+			for j < len(r) && isWordRune(r[j]) {
+				j++
+			}
+			word := string(r[i:j])
+			kind := KindText
+			switch {
+			case unicode.IsDigit(r[i]):
+				kind = KindNumber
+			case keywords[strings.ToLower(word)]:
+				kind = KindKeyword
+			}
+			toks = append(toks, Token{word, kind})
+			i = j
+		default:
+			toks = append(toks, Token{string(r[i]), KindText})
+			i++
+		}
+	}
+	return toks
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,72 @@
+package preview
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/johnwmail/nclip/models"
+)
+
+func TestRender(t *testing.T) {
+	content := []byte("package main\n\n// say hello\nfunc main() {\n\tprintln(\"hi\", 42)\n}\n" + strings.Repeat("x\n", 50))
+	data, err := Render(content)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("invalid PNG: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 1200 || b.Dy() != 630 {
+		t.Errorf("expected 1200x630 image, got %dx%d", b.Dx(), b.Dy())
+	}
+}
+
+func TestFirstLines(t *testing.T) {
+	lines := firstLines([]byte(strings.Repeat("a\tb\r\n", 30)), Lines)
+	if len(lines) != Lines {
+		t.Fatalf("expected %d lines, got %d", Lines, len(lines))
+	}
+	if lines[0] != "a    b" {
+		t.Errorf("expected tabs expanded and CR removed, got %q", lines[0])
+	}
+	long := firstLines([]byte(strings.Repeat("y", 500)), Lines)
+	if len(long[0]) != maxColumns {
+		t.Errorf("expected line cut to %d columns, got %d", maxColumns, len(long[0]))
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	toks := Tokenize(`return "a \" b" + 10 // done`)
+	want := []Token{
+		{"return", KindKeyword}, {" ", KindText}, {`"a \" b"`, KindString}, {" ", KindText},
+		{"+", KindText}, {" ", KindText}, {"10", KindNumber}, {" ", KindText}, {"// done", KindComment},
+	}
+	if len(toks) != len(want) {
+		t.Fatalf("expected %d tokens, got %+v", len(want), toks)
+	}
+	for i, w := range want {
+		if toks[i] != w {
+			t.Errorf("token %d: expected %q %v, got %q %v", i, w.Text, w.Kind, toks[i].Text, toks[i].Kind)
+		}
+	}
+}
+
+func TestEligible(t *testing.T) {
+	cases := []struct {
+		paste *models.Paste
+		want  bool
+	}{
+		{&models.Paste{ContentType: "text/plain"}, true},
+		{&models.Paste{ContentType: "text/plain", BurnAfterRead: true}, false},
+		{&models.Paste{ContentType: "image/png"}, false},
+		{nil, false},
+	}
+	for _, tc := range cases {
+		if got := Eligible(tc.paste); got != tc.want {
+			t.Errorf("Eligible(%+v) = %v, want %v", tc.paste, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+const (
+	// MaxPerPaste caps the subscriptions kept for one paste.
+	MaxPerPaste = 5
+	// queueSize bounds the burn notifications waiting to be sent; more are
+	// dropped rather than slowing down reads.
+	queueSize = 256
+	// sendTimeout bounds one delivery to a push service.
+	sendTimeout = 30 * time.Second
+)
+
+// ErrTooMany is returned by Subscribe when a paste already has
+// MaxPerPaste subscriptions.
+var ErrTooMany = errors.New("too many push subscriptions for this paste")
+
+// Message is the JSON payload the service worker shows as a notification;
+// clicking it opens URL.
+type Message struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url,omitempty"`
+}
+
+// entry is one subscription to a paste, as saved in the state file.
+type entry struct {
+	Subscription Subscription `json:"subscription"`
+	// CreatedAt is the paste's creation time, so a subscription is not
+	// carried over to a new paste that reuses the slug.
+	CreatedAt time.Time `json:"paste_created_at"`
+	// ManageURL is opened when the expiry notification is clicked.
+	ManageURL string `json:"manage_url"`
+	// NotifiedExpiry is the expiry the uploader was last warned about; a
+	// paste extended since is warned about again.
+	NotifiedExpiry *time.Time `json:"notified_expiry,omitempty"`
+}
+
+type delivery struct {
+	slug string
+	sub  Subscription
+	msg  Message
+}
+
+// Notifier keeps the push subscriptions of pastes and notifies them when
+// a paste is burned or about to expire. It is safe for concurrent use.
+type Notifier struct {
+	sender    *Sender
+	store     storage.PasteStore
+	statePath string
+	// notice is how long before expiry the uploader is warned.
+	notice time.Duration
+	now    func() time.Time
+	queue  chan delivery
+
+	mu   sync.Mutex
+	subs map[string][]entry
+}
+
+// NewNotifier creates a Notifier that sends with sender, looks pastes up
+// in store and saves subscriptions to statePath. Uploaders are warned
+// notice before their paste expires.
+func NewNotifier(sender *Sender, store storage.PasteStore, statePath string, notice time.Duration) *Notifier {
+	n := &Notifier{
+		sender:    sender,
+		store:     store,
+		statePath: statePath,
+		notice:    notice,
+		now:       time.Now,
+		queue:     make(chan delivery, queueSize),
+		subs:      make(map[string][]entry),
+	}
+	data, err := os.ReadFile(statePath) // #nosec G304 -- operator-configured path
+	if err == nil {
+		if err := json.Unmarshal(data, &n.subs); err != nil {
+			log.Printf("[WARN] Push: ignoring unreadable state file %s: %v", statePath, err)
+			n.subs = make(map[string][]entry)
+		}
+	}
+	return n
+}
+
+// PublicKey returns the VAPID public key browsers subscribe with.
+func (n *Notifier) PublicKey() string {
+	return n.sender.PublicKey()
+}
+
+// Subscribe registers sub for notifications about paste. manageURL is
+// opened when the uploader clicks an expiry warning. Subscribing an
+// endpoint again replaces its keys.
+func (n *Notifier) Subscribe(paste *models.Paste, sub Subscription, manageURL string) error {
+	if err := sub.Validate(); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var kept []entry
+	for _, e := range n.subs[paste.ID] {
+		if e.Subscription.Endpoint != sub.Endpoint && e.CreatedAt.Equal(paste.CreatedAt) {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) >= MaxPerPaste {
+		return ErrTooMany
+	}
+	n.subs[paste.ID] = append(kept, entry{Subscription: sub, CreatedAt: paste.CreatedAt, ManageURL: manageURL})
+	n.saveLocked()
+	return nil
+}
+
+// Unsubscribe removes the subscription with endpoint from slug. It
+// reports whether there was one.
+func (n *Notifier) Unsubscribe(slug, endpoint string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.removeLocked(slug, endpoint) {
+		return false
+	}
+	n.saveLocked()
+	return true
+}
+
+// Subscriptions returns the number of subscriptions to slug.
+func (n *Notifier) Subscriptions(slug string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.subs[slug])
+}
+
+// Burned notifies slug's subscribers that it was read and burned, and
+// forgets them. Delivery happens in the background.
+func (n *Notifier) Burned(slug string) {
+	n.mu.Lock()
+	entries := n.subs[slug]
+	if len(entries) == 0 {
+		n.mu.Unlock()
+		return
+	}
+	delete(n.subs, slug)
+	n.saveLocked()
+	n.mu.Unlock()
+	msg := Message{
+		Title: "Paste burned",
+		Body:  fmt.Sprintf("Your paste %s was read and has been deleted.", slug),
+	}
+	for _, e := range entries {
+		select {
+		case n.queue <- delivery{slug: slug, sub: e.Subscription, msg: msg}:
+		default:
+			log.Printf("[WARN] Push: queue full, dropped burn notification for %s", slug)
+		}
+	}
+}
+
+// Start delivers queued notifications and checks for expiring pastes
+// every interval in the background.
+func (n *Notifier) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case d := <-n.queue:
+				n.deliver(d)
+			case <-ticker.C:
+				for _, d := range n.checkExpiring() {
+					n.deliver(d)
+				}
+			}
+		}
+	}()
+}
+
+// checkExpiring returns the warnings due for pastes expiring within the
+// notice period, marking them sent, and forgets the subscriptions of
+// pastes that are gone.
+func (n *Notifier) checkExpiring() []delivery {
+	n.mu.Lock()
+	slugs := make([]string, 0, len(n.subs))
+	for slug := range n.subs {
+		slugs = append(slugs, slug)
+	}
+	n.mu.Unlock()
+
+	var due []delivery
+	changed := false
+	for _, slug := range slugs {
+		paste, err := n.store.Get(slug)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("[WARN] Push: failed to look up %s: %v", slug, err)
+			continue
+		}
+		now := n.now()
+		n.mu.Lock()
+		entries := n.subs[slug]
+		kept := entries[:0]
+		for _, e := range entries {
+			if paste == nil || !e.CreatedAt.Equal(paste.CreatedAt) || paste.IsExpired() {
+				changed = true
+				continue
+			}
+			if exp := paste.ExpiresAt; exp != nil && !paste.Pinned && !paste.LegalHold &&
+				exp.Sub(now) <= n.notice && (e.NotifiedExpiry == nil || !e.NotifiedExpiry.Equal(*exp)) {
+				e.NotifiedExpiry = exp
+				changed = true
+				due = append(due, delivery{slug: slug, sub: e.Subscription, msg: Message{
+					Title: "Paste expiring",
+					Body:  fmt.Sprintf("Your paste %s expires in %s.", slug, exp.Sub(now).Round(time.Minute)),
+					URL:   e.ManageURL,
+				}})
+			}
+			kept = append(kept, e)
+		}
+		if len(kept) == 0 {
+			delete(n.subs, slug)
+		} else {
+			n.subs[slug] = kept
+		}
+		n.mu.Unlock()
+	}
+	if changed {
+		n.mu.Lock()
+		n.saveLocked()
+		n.mu.Unlock()
+	}
+	return due
+}
+
+// deliver sends d, forgetting its subscription if the push service no
+// longer knows it.
+func (n *Notifier) deliver(d delivery) {
+	payload, err := json.Marshal(d.msg)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	err = n.sender.Send(ctx, d.sub, payload)
+	switch {
+	case errors.Is(err, ErrGone):
+		n.Unsubscribe(d.slug, d.sub.Endpoint)
+	case err != nil:
+		log.Printf("[WARN] Push: failed to notify a subscriber of %s: %v", d.slug, err)
+	}
+}
+
+// removeLocked removes endpoint's subscription to slug. The caller holds
+// mu.
+func (n *Notifier) removeLocked(slug, endpoint string) bool {
+	entries := n.subs[slug]
+	for i, e := range entries {
+		if e.Subscription.Endpoint == endpoint {
+			entries = append(entries[:i], entries[i+1:]...)
+			if len(entries) == 0 {
+				delete(n.subs, slug)
+			} else {
+				n.subs[slug] = entries
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// saveLocked writes the subscriptions to the state file. They include the
+// browsers' encryption secrets, so the file is private. The caller holds
+// mu.
+func (n *Notifier) saveLocked() {
+	data, err := json.MarshalIndent(n.subs, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp := n.statePath + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(n.statePath), 0o755); err != nil {
+		log.Printf("[ERROR] Push: failed to save subscriptions: %v", err)
+		return
+	}
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		log.Printf("[ERROR] Push: failed to save subscriptions: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, n.statePath); err != nil {
+		log.Printf("[ERROR] Push: failed to save subscriptions: %v", err)
+	}
+}
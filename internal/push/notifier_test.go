@@ -0,0 +1,142 @@
+package push
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func newTestNotifier(t *testing.T) (*Notifier, *storage.FilesystemStore, string) {
+	t.Helper()
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := filepath.Join(t.TempDir(), ".push.json")
+	return NewNotifier(newTestSender(t), store, state, time.Hour), store, state
+}
+
+func storePaste(t *testing.T, store storage.PasteStore, slug string, expiresIn time.Duration) *models.Paste {
+	t.Helper()
+	exp := time.Now().Add(expiresIn)
+	paste := &models.Paste{ID: slug, CreatedAt: time.Now().UTC().Truncate(time.Second), ExpiresAt: &exp}
+	if err := store.Store(paste); err != nil {
+		t.Fatal(err)
+	}
+	return paste
+}
+
+func TestNotifier_Subscribe(t *testing.T) {
+	n, store, state := newTestNotifier(t)
+	paste := storePaste(t, store, "SUBSC", 24*time.Hour)
+	b := newBrowser(t)
+	for i := range MaxPerPaste {
+		sub := b.subscription("https://push.example.com/" + string(rune('a'+i)))
+		if err := n.Subscribe(paste, sub, ""); err != nil {
+			t.Fatalf("Subscribe %d: %v", i, err)
+		}
+	}
+	// Subscribing an endpoint again replaces it rather than counting.
+	if err := n.Subscribe(paste, b.subscription("https://push.example.com/a"), ""); err != nil {
+		t.Fatalf("resubscribe: %v", err)
+	}
+	if err := n.Subscribe(paste, b.subscription("https://push.example.com/z"), ""); !errors.Is(err, ErrTooMany) {
+		t.Fatalf("Subscribe over the limit = %v, want ErrTooMany", err)
+	}
+	if err := n.Subscribe(paste, b.subscription("http://push.example.com/z"), ""); err == nil {
+		t.Fatal("Subscribe accepted an http endpoint")
+	}
+	if got := n.Subscriptions("SUBSC"); got != MaxPerPaste {
+		t.Errorf("Subscriptions = %d, want %d", got, MaxPerPaste)
+	}
+
+	// Subscriptions survive a restart.
+	reloaded := NewNotifier(n.sender, store, state, time.Hour)
+	if got := reloaded.Subscriptions("SUBSC"); got != MaxPerPaste {
+		t.Errorf("after reload Subscriptions = %d, want %d", got, MaxPerPaste)
+	}
+	if !reloaded.Unsubscribe("SUBSC", "https://push.example.com/a") || reloaded.Unsubscribe("SUBSC", "https://push.example.com/a") {
+		t.Error("Unsubscribe should report removing the endpoint once")
+	}
+}
+
+func TestNotifier_Burned(t *testing.T) {
+	n, store, _ := newTestNotifier(t)
+	svc, srv := newPushService(t, n.sender)
+	paste := storePaste(t, store, "BURNS", time.Hour)
+	b := newBrowser(t)
+	if err := n.Subscribe(paste, b.subscription(srv.URL+"/1"), ""); err != nil {
+		t.Fatal(err)
+	}
+	n.Burned("BURNS")
+	n.Burned("BURNS")
+	if n.Subscriptions("BURNS") != 0 {
+		t.Error("subscriptions kept after burn")
+	}
+	if len(n.queue) != 1 {
+		t.Fatalf("queued %d notifications, want 1", len(n.queue))
+	}
+	n.deliver(<-n.queue)
+	var msg Message
+	if err := json.Unmarshal(b.decrypt(t, svc.bodies[0]), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Title != "Paste burned" || !strings.Contains(msg.Body, "BURNS") {
+		t.Errorf("message = %+v", msg)
+	}
+}
+
+func TestNotifier_CheckExpiring(t *testing.T) {
+	n, store, _ := newTestNotifier(t)
+	svc, srv := newPushService(t, n.sender)
+	soon := storePaste(t, store, "SOONX", 30*time.Minute)
+	later := storePaste(t, store, "LATER", 5*time.Hour)
+	gone := storePaste(t, store, "GONEX", 30*time.Minute)
+	b := newBrowser(t)
+	for _, p := range []*models.Paste{soon, later, gone} {
+		if err := n.Subscribe(p, b.subscription(srv.URL+"/"+p.ID), "https://nclip.example.com/manage/"+p.ID+"?token=t"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete("GONEX"); err != nil {
+		t.Fatal(err)
+	}
+
+	due := n.checkExpiring()
+	if len(due) != 1 || due[0].slug != "SOONX" {
+		t.Fatalf("due = %+v, want one warning for SOONX", due)
+	}
+	if n.Subscriptions("GONEX") != 0 {
+		t.Error("subscription to a deleted paste was kept")
+	}
+	if due[0].msg.URL != "https://nclip.example.com/manage/SOONX?token=t" {
+		t.Errorf("URL = %q, want the manage URL", due[0].msg.URL)
+	}
+	if again := n.checkExpiring(); len(again) != 0 {
+		t.Errorf("warned twice: %+v", again)
+	}
+
+	// Extending the paste re-arms the warning.
+	exp := time.Now().Add(45 * time.Minute)
+	soon.ExpiresAt = &exp
+	if err := store.Store(soon); err != nil {
+		t.Fatal(err)
+	}
+	if again := n.checkExpiring(); len(again) != 1 {
+		t.Errorf("after extending got %d warnings, want 1", len(again))
+	}
+
+	// A subscription the push service has forgotten is dropped.
+	svc.status = http.StatusGone
+	n.deliver(due[0])
+	if n.Subscriptions("SOONX") != 0 {
+		t.Error("gone subscription was kept")
+	}
+}
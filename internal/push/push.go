@@ -0,0 +1,287 @@
+// Package push sends Web Push notifications (RFC 8030) to browsers that
+// subscribed on a paste's manage page, telling the uploader when their
+// burn-after-read paste was read or their paste is about to expire.
+//
+// Messages are encrypted for the subscription with aes128gcm (RFC 8291)
+// and the server identifies itself with a VAPID key (RFC 8292), so no
+// push service account is needed. The VAPID key is a P-256 private key,
+// its 32-byte scalar in unpadded base64url as generated by the common
+// web-push tools.
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/egress"
+)
+
+const (
+	// recordSize is the aes128gcm record size. Notifications are far
+	// smaller, so every message is a single record.
+	recordSize = 4096
+	// MaxPayload is the largest message Send encrypts.
+	MaxPayload = recordSize - 16 - 1 - 86
+	// ttl is how long the push service keeps an undelivered message.
+	ttl = 24 * time.Hour
+)
+
+// ErrGone is returned by Send when the push service no longer knows the
+// subscription; it should be forgotten.
+var ErrGone = errors.New("push subscription is gone")
+
+var b64 = base64.RawURLEncoding
+
+// Subscription is a browser's PushSubscription as serialized by its
+// toJSON method.
+type Subscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// Validate checks that s has an https endpoint and well-formed keys.
+func (s Subscription) Validate() error {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" || u.User != nil {
+		return errors.New("endpoint must be an https URL")
+	}
+	if _, err := s.keys(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// keys decodes the subscription's public key and authentication secret.
+func (s Subscription) keys() (keyPair, error) {
+	var k keyPair
+	pub, err := decode(s.Keys.P256dh)
+	if err != nil {
+		return k, errors.New("keys.p256dh is not valid base64url")
+	}
+	if k.public, err = ecdh.P256().NewPublicKey(pub); err != nil {
+		return k, errors.New("keys.p256dh is not a P-256 public key")
+	}
+	if k.auth, err = decode(s.Keys.Auth); err != nil || len(k.auth) != 16 {
+		return k, errors.New("keys.auth must be 16 bytes of base64url")
+	}
+	return k, nil
+}
+
+type keyPair struct {
+	public *ecdh.PublicKey
+	auth   []byte
+}
+
+// decode accepts base64url with or without padding, as browsers differ.
+func decode(s string) ([]byte, error) {
+	return b64.DecodeString(strings.TrimRight(s, "="))
+}
+
+// Sender signs and delivers push messages.
+type Sender struct {
+	key     *ecdsa.PrivateKey
+	public  []byte
+	subject string
+	client  *http.Client
+	now     func() time.Time
+}
+
+// ParseKey parses a VAPID private key: a P-256 scalar in base64url.
+func ParseKey(s string) (*ecdsa.PrivateKey, error) {
+	raw, err := decode(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("VAPID private key is not valid base64url: %w", err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("VAPID private key must be 32 bytes, got %d", len(raw))
+	}
+	key, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("VAPID private key is not a P-256 key: %w", err)
+	}
+	// crypto/x509 converts between the ecdh and ecdsa forms of a key.
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	return parsed.(*ecdsa.PrivateKey), nil
+}
+
+// ValidSubject reports whether subject is a mailto: or https: URL, the
+// contacts push services accept.
+func ValidSubject(subject string) bool {
+	return strings.HasPrefix(subject, "mailto:") || strings.HasPrefix(subject, "https://")
+}
+
+// NewSender returns a Sender that identifies the server to push services
+// with key and the contact URL subject.
+func NewSender(key *ecdsa.PrivateKey, subject string) *Sender {
+	// P-256 ecdsa keys always convert.
+	pub, _ := key.PublicKey.ECDH()
+	return &Sender{
+		key:     key,
+		public:  pub.Bytes(),
+		subject: subject,
+		client:  &http.Client{Timeout: 30 * time.Second, Transport: egress.PublicTransport("push endpoint")},
+		now:     time.Now,
+	}
+}
+
+// PublicKey returns the VAPID public key in unpadded base64url, the
+// applicationServerKey browsers subscribe with.
+func (s *Sender) PublicKey() string {
+	return b64.EncodeToString(s.public)
+}
+
+// Send encrypts payload for sub and posts it to the subscription's push
+// service.
+func (s *Sender) Send(ctx context.Context, sub Subscription, payload []byte) error {
+	if len(payload) > MaxPayload {
+		return fmt.Errorf("payload of %d bytes exceeds %d", len(payload), MaxPayload)
+	}
+	keys, err := sub.keys()
+	if err != nil {
+		return err
+	}
+	body, err := encrypt(keys, payload, rand.Reader)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(sub.Endpoint)
+	if err != nil {
+		return err
+	}
+	token, err := s.token(u.Scheme + "://" + u.Host)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", fmt.Sprint(int(ttl.Seconds())))
+	req.Header.Set("Authorization", "vapid t="+token+", k="+s.PublicKey())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrGone
+	case resp.StatusCode/100 != 2:
+		return fmt.Errorf("push service returned %s", resp.Status)
+	}
+	return nil
+}
+
+// token returns the VAPID JWT for the push service at audience.
+func (s *Sender) token(audience string) (string, error) {
+	header := b64.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]any{
+		"aud": audience,
+		"exp": s.now().Add(12 * time.Hour).Unix(),
+		"sub": s.subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + b64.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	r, sv, err := ecdsa.Sign(rand.Reader, s.key, sum[:])
+	if err != nil {
+		return "", err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	sv.FillBytes(sig[32:])
+	return unsigned + "." + b64.EncodeToString(sig), nil
+}
+
+// encrypt returns payload as a single aes128gcm record for keys, with a
+// fresh sender key and salt read from random.
+func encrypt(keys keyPair, payload []byte, random io.Reader) ([]byte, error) {
+	local, err := ecdh.P256().GenerateKey(random)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := local.ECDH(keys.public)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(random, salt); err != nil {
+		return nil, err
+	}
+	localPublic := local.PublicKey().Bytes()
+	cek, nonce, err := deriveKeys(secret, keys.auth, salt, keys.public.Bytes(), localPublic)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// The header is salt | record size | key id length | key id.
+	out := make([]byte, 0, 16+4+1+len(localPublic)+len(payload)+1+gcm.Overhead())
+	out = append(out, salt...)
+	out = binary.BigEndian.AppendUint32(out, recordSize)
+	out = append(out, byte(len(localPublic)))
+	out = append(out, localPublic...)
+	// 0x02 marks the last (and only) record.
+	plain := append(append([]byte{}, payload...), 2)
+	return gcm.Seal(out, nonce, plain, nil), nil
+}
+
+// deriveKeys derives the content encryption key and nonce from the ECDH
+// secret, as in RFC 8291 section 3.4.
+func deriveKeys(secret, auth, salt, uaPublic, asPublic []byte) (cek, nonce []byte, err error) {
+	info := "WebPush: info\x00" + string(uaPublic) + string(asPublic)
+	ikm, err := hkdf.Key(sha256.New, secret, auth, info, 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cek, err = hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16); err != nil {
+		return nil, nil, err
+	}
+	if nonce, err = hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12); err != nil {
+		return nil, nil, err
+	}
+	return cek, nonce, nil
+}
@@ -0,0 +1,240 @@
+package push
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// browser is a user agent's side of a push subscription.
+type browser struct {
+	key  *ecdh.PrivateKey
+	auth []byte
+}
+
+func newBrowser(t *testing.T) *browser {
+	t.Helper()
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	_, _ = rand.Read(auth)
+	return &browser{key: key, auth: auth}
+}
+
+func (b *browser) subscription(endpoint string) Subscription {
+	var sub Subscription
+	sub.Endpoint = endpoint
+	sub.Keys.P256dh = b64.EncodeToString(b.key.PublicKey().Bytes())
+	sub.Keys.Auth = b64.EncodeToString(b.auth)
+	return sub
+}
+
+// decrypt reverses encrypt the way the browser does.
+func (b *browser) decrypt(t *testing.T, body []byte) []byte {
+	t.Helper()
+	if len(body) < 21 {
+		t.Fatalf("body of %d bytes has no header", len(body))
+	}
+	salt := body[:16]
+	if rs := binary.BigEndian.Uint32(body[16:20]); rs != recordSize {
+		t.Fatalf("record size = %d, want %d", rs, recordSize)
+	}
+	idLen := int(body[20])
+	senderPublic := body[21 : 21+idLen]
+	pub, err := ecdh.P256().NewPublicKey(senderPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := b.key.ECDH(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cek, nonce, err := deriveKeys(secret, b.auth, salt, b.key.PublicKey().Bytes(), senderPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plain, err := gcm.Open(nil, nonce, body[21+idLen:], nil)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if plain[len(plain)-1] != 2 {
+		t.Fatalf("last record delimiter = %d, want 2", plain[len(plain)-1])
+	}
+	return plain[:len(plain)-1]
+}
+
+func newTestSender(t *testing.T) *Sender {
+	t.Helper()
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseKey(b64.EncodeToString(key.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSender(parsed, "mailto:ops@example.com")
+}
+
+// pushService is a push service that records what it receives.
+type pushService struct {
+	status int
+	auth   []string
+	bodies [][]byte
+}
+
+func (p *pushService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	p.auth = append(p.auth, r.Header.Get("Authorization"))
+	p.bodies = append(p.bodies, body)
+	if r.Header.Get("Content-Encoding") != "aes128gcm" || r.Header.Get("TTL") == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if p.status != 0 {
+		w.WriteHeader(p.status)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// newPushService starts a push service the sender may reach despite it
+// listening on loopback.
+func newPushService(t *testing.T, s *Sender) (*pushService, *httptest.Server) {
+	t.Helper()
+	svc := &pushService{}
+	srv := httptest.NewTLSServer(svc)
+	t.Cleanup(srv.Close)
+	s.client = srv.Client()
+	return svc, srv
+}
+
+func TestSender_Send(t *testing.T) {
+	s := newTestSender(t)
+	svc, srv := newPushService(t, s)
+	b := newBrowser(t)
+	if err := s.Send(context.Background(), b.subscription(srv.URL+"/push/abc"), []byte(`{"title":"hi"}`)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(svc.bodies) != 1 {
+		t.Fatalf("push service got %d requests, want 1", len(svc.bodies))
+	}
+	if got := b.decrypt(t, svc.bodies[0]); string(got) != `{"title":"hi"}` {
+		t.Errorf("decrypted payload = %q", got)
+	}
+
+	// The VAPID token is signed by the key the browser subscribed with.
+	token, pub, ok := strings.Cut(strings.TrimPrefix(svc.auth[0], "vapid t="), ", k=")
+	if !ok || pub != s.PublicKey() {
+		t.Fatalf("Authorization = %q", svc.auth[0])
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token has %d parts", len(parts))
+	}
+	var claims struct {
+		Aud string `json:"aud"`
+		Sub string `json:"sub"`
+	}
+	raw, _ := b64.DecodeString(parts[1])
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Aud != srv.URL || claims.Sub != "mailto:ops@example.com" {
+		t.Errorf("claims = %+v", claims)
+	}
+	keyBytes, _ := b64.DecodeString(pub)
+	x, y := elliptic.Unmarshal(elliptic.P256(), keyBytes) //nolint:staticcheck // verifying a raw public key
+	sig, _ := b64.DecodeString(parts[2])
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	vk := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	if !ecdsa.Verify(vk, sum[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		t.Error("VAPID token signature does not verify")
+	}
+}
+
+func TestSender_SendGone(t *testing.T) {
+	s := newTestSender(t)
+	svc, srv := newPushService(t, s)
+	b := newBrowser(t)
+	svc.status = http.StatusGone
+	if err := s.Send(context.Background(), b.subscription(srv.URL), []byte("x")); !errors.Is(err, ErrGone) {
+		t.Errorf("Send to a gone subscription = %v, want ErrGone", err)
+	}
+	svc.status = http.StatusTooManyRequests
+	if err := s.Send(context.Background(), b.subscription(srv.URL), []byte("x")); err == nil || errors.Is(err, ErrGone) {
+		t.Errorf("Send when throttled = %v, want a non-gone error", err)
+	}
+	if err := s.Send(context.Background(), b.subscription(srv.URL), make([]byte, MaxPayload+1)); err == nil {
+		t.Error("Send accepted an oversized payload")
+	}
+}
+
+func TestSender_RefusesPrivateAddresses(t *testing.T) {
+	s := newTestSender(t)
+	srv := httptest.NewTLSServer(&pushService{})
+	defer srv.Close()
+	err := s.Send(context.Background(), newBrowser(t).subscription(srv.URL), []byte("x"))
+	if err == nil || !strings.Contains(err.Error(), "not public") {
+		t.Errorf("Send to loopback = %v, want a not public error", err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	key, _ := ecdh.P256().GenerateKey(rand.Reader)
+	parsed, err := ParseKey(b64.EncodeToString(key.Bytes()) + "\n")
+	if err != nil {
+		t.Fatalf("ParseKey: %v", err)
+	}
+	if got := NewSender(parsed, "mailto:a@b.c").PublicKey(); got != b64.EncodeToString(key.PublicKey().Bytes()) {
+		t.Errorf("PublicKey = %q", got)
+	}
+	for _, bad := range []string{"!!", b64.EncodeToString([]byte("short")), b64.EncodeToString(make([]byte, 32))} {
+		if _, err := ParseKey(bad); err == nil {
+			t.Errorf("ParseKey(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestSubscription_Validate(t *testing.T) {
+	b := newBrowser(t)
+	tests := []struct {
+		name  string
+		edit  func(*Subscription)
+		valid bool
+	}{
+		{"valid", func(*Subscription) {}, true},
+		{"padded keys", func(s *Subscription) { s.Keys.Auth += "==" }, true},
+		{"http endpoint", func(s *Subscription) { s.Endpoint = "http://push.example.com/x" }, false},
+		{"credentials in endpoint", func(s *Subscription) { s.Endpoint = "https://u:p@push.example.com/x" }, false},
+		{"bad public key", func(s *Subscription) { s.Keys.P256dh = b64.EncodeToString(make([]byte, 65)) }, false},
+		{"short auth", func(s *Subscription) { s.Keys.Auth = b64.EncodeToString(make([]byte, 8)) }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := b.subscription("https://push.example.com/x")
+			tt.edit(&sub)
+			if err := sub.Validate(); (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, want valid %t", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConcurrency(t *testing.T) {
+	c := NewConcurrency("test", 3, 2)
+	a1, err := c.Acquire("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Acquire("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Acquire("a"); !errors.Is(err, ErrTooManyForKey) {
+		t.Fatalf("third request of a key: got %v, want ErrTooManyForKey", err)
+	}
+	if _, err := c.Acquire("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Acquire("c"); !errors.Is(err, ErrTooManyInFlight) {
+		t.Fatalf("fourth request overall: got %v, want ErrTooManyInFlight", err)
+	}
+
+	// Releasing twice frees one slot only.
+	a1()
+	a1()
+	if n := c.InFlight(); n != 2 {
+		t.Fatalf("InFlight = %d after one release, want 2", n)
+	}
+	if _, err := c.Acquire("a"); err != nil {
+		t.Fatalf("expected a released slot to be reused: %v", err)
+	}
+
+	var none *Concurrency
+	if release, err := none.Acquire("a"); err != nil || release == nil {
+		t.Errorf("a nil limiter should admit everything, got %v", err)
+	}
+}
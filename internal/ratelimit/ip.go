@@ -0,0 +1,146 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Default aggregation prefixes. A single IPv6 subscriber is usually handed
+// a whole /64, so limiting individual IPv6 addresses is trivially bypassed.
+const (
+	DefaultIPv4Prefix = 32
+	DefaultIPv6Prefix = 64
+)
+
+var rejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "ratelimit_rejected_total",
+	Help: "Requests rejected by a rate limiter, by limiter and aggregation prefix.",
+}, []string{"limiter", "prefix"})
+
+// Collector returns the rate limiter metrics for registration.
+func Collector() prometheus.Collector {
+	return rejected
+}
+
+// IPOptions configures an IPLimiter. Zero prefixes use the defaults.
+type IPOptions struct {
+	// IPv4Limit and IPv6Limit are the requests allowed per window for each
+	// IPv4 and IPv6 aggregate; <= 0 disables limiting for that family.
+	IPv4Limit int
+	IPv6Limit int
+	// IPv4Prefix and IPv6Prefix are the prefix lengths clients are grouped
+	// by, e.g. 64 to count every address of a /64 together.
+	IPv4Prefix int
+	IPv6Prefix int
+}
+
+// IPLimiter rate limits client addresses aggregated into subnets, with
+// separate limits for IPv4 and IPv6. It is safe for concurrent use.
+type IPLimiter struct {
+	name    string
+	v4, v6  *Limiter
+	v4Bits  int
+	v6Bits  int
+	v4Label string
+	v6Label string
+}
+
+// Decision is the outcome of IPLimiter.Allow.
+type Decision struct {
+	// Key is the aggregate the request was counted against, e.g.
+	// "203.0.113.7/32" or "2001:db8:1:2::/64".
+	Key     string
+	Allowed bool
+	// FirstRejection is set on the first rejected request of a window, so
+	// callers can log once per window instead of once per request.
+	FirstRejection bool
+}
+
+// NewIP creates an IPLimiter allowing the configured number of requests
+// per window. name labels its metrics.
+func NewIP(name string, opts IPOptions, per time.Duration) *IPLimiter {
+	if opts.IPv4Prefix <= 0 {
+		opts.IPv4Prefix = DefaultIPv4Prefix
+	}
+	if opts.IPv6Prefix <= 0 {
+		opts.IPv6Prefix = DefaultIPv6Prefix
+	}
+	return &IPLimiter{
+		name:    name,
+		v4:      New(opts.IPv4Limit, per),
+		v6:      New(opts.IPv6Limit, per),
+		v4Bits:  min(opts.IPv4Prefix, 32),
+		v6Bits:  min(opts.IPv6Prefix, 128),
+		v4Label: fmt.Sprintf("ipv4/%d", min(opts.IPv4Prefix, 32)),
+		v6Label: fmt.Sprintf("ipv6/%d", min(opts.IPv6Prefix, 128)),
+	}
+}
+
+// SetCounter counts requests with c instead of in memory. See
+// Limiter.SetCounter.
+func (l *IPLimiter) SetCounter(c Counter) {
+	l.v4.SetCounter(prefixed{c, l.name + ":"})
+	l.v6.SetCounter(prefixed{c, l.name + ":"})
+}
+
+// SetLimits changes the requests allowed per window for each IPv4 and
+// IPv6 aggregate. See Limiter.SetLimit.
+func (l *IPLimiter) SetLimits(ipv4, ipv6 int) {
+	l.v4.SetLimit(ipv4)
+	l.v6.SetLimit(ipv6)
+}
+
+// prefixed namespaces the keys of a shared Counter by limiter, so limiters
+// with different limits do not count against each other.
+type prefixed struct {
+	Counter
+	prefix string
+}
+
+func (p prefixed) Incr(key string, window time.Duration) (int64, error) {
+	return p.Counter.Incr(p.prefix+key, window)
+}
+
+// Key returns the aggregate host belongs to. IPv4-mapped IPv6 addresses
+// count as IPv4; hosts that are not IP addresses are their own key.
+func (l *IPLimiter) Key(host string) string {
+	key, _ := l.key(host)
+	return key
+}
+
+func (l *IPLimiter) key(host string) (string, bool) {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host, false
+	}
+	addr = addr.Unmap().WithZone("")
+	bits, v6 := l.v4Bits, false
+	if addr.Is6() {
+		bits, v6 = l.v6Bits, true
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return addr.String(), v6
+	}
+	return prefix.String(), v6
+}
+
+// Allow records a request from host and reports the decision.
+func (l *IPLimiter) Allow(host string) Decision {
+	if l == nil {
+		return Decision{Key: host, Allowed: true}
+	}
+	key, v6 := l.key(host)
+	limiter, label := l.v4, l.v4Label
+	if v6 {
+		limiter, label = l.v6, l.v6Label
+	}
+	allowed, first := limiter.allow(key)
+	if !allowed {
+		rejected.WithLabelValues(l.name, label).Inc()
+	}
+	return Decision{Key: key, Allowed: allowed, FirstRejection: first}
+}
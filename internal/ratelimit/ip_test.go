@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIPLimiter_Key(t *testing.T) {
+	l := NewIP("test", IPOptions{}, time.Minute)
+	cases := map[string]string{
+		"203.0.113.7":            "203.0.113.7/32",
+		"2001:db8:1:2:aaaa::1":   "2001:db8:1:2::/64",
+		"2001:db8:1:2:bbbb::9":   "2001:db8:1:2::/64",
+		"2001:db8:1:3::1":        "2001:db8:1:3::/64",
+		"::ffff:203.0.113.7":     "203.0.113.7/32",
+		"fe80::1%eth0":           "fe80::/64",
+		"not-an-ip":              "not-an-ip",
+		"2001:db8:ffff:ffff::1:": "2001:db8:ffff:ffff::1:",
+	}
+	for host, want := range cases {
+		if got := l.Key(host); got != want {
+			t.Errorf("Key(%q) = %q, want %q", host, got, want)
+		}
+	}
+
+	l = NewIP("test", IPOptions{IPv4Prefix: 24, IPv6Prefix: 48}, time.Minute)
+	if got := l.Key("203.0.113.7"); got != "203.0.113.0/24" {
+		t.Errorf("IPv4 /24 key = %q", got)
+	}
+	if got := l.Key("2001:db8:1:2::1"); got != "2001:db8:1::/48" {
+		t.Errorf("IPv6 /48 key = %q", got)
+	}
+}
+
+func TestIPLimiter_Allow(t *testing.T) {
+	l := NewIP("test", IPOptions{IPv4Limit: 1, IPv6Limit: 2}, time.Minute)
+
+	// Rotating addresses within a /64 shares one budget.
+	if !l.Allow("2001:db8::1").Allowed || !l.Allow("2001:db8::2").Allowed {
+		t.Fatal("expected the first two IPv6 requests to be allowed")
+	}
+	d := l.Allow("2001:db8::3")
+	if d.Allowed || !d.FirstRejection || d.Key != "2001:db8::/64" {
+		t.Fatalf("expected first rejection for the /64, got %+v", d)
+	}
+	if d := l.Allow("2001:db8::4"); d.Allowed || d.FirstRejection {
+		t.Fatalf("expected a repeated rejection, got %+v", d)
+	}
+	if !l.Allow("2001:db8:0:1::1").Allowed {
+		t.Fatal("expected a different /64 to be allowed")
+	}
+
+	// IPv4 has its own limit.
+	if !l.Allow("203.0.113.7").Allowed || l.Allow("203.0.113.7").Allowed {
+		t.Fatal("expected IPv4 limit of 1")
+	}
+	if !l.Allow("203.0.113.8").Allowed {
+		t.Fatal("expected a different IPv4 address to be allowed")
+	}
+}
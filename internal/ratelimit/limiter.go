@@ -0,0 +1,124 @@
+package ratelimit
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxEntries bounds the number of tracked keys before expired windows are
+// pruned, so a flood of distinct clients cannot grow the map without limit.
+const maxEntries = 10000
+
+// Counter counts requests in windows shared by several instances, such as
+// a Redis server.
+type Counter interface {
+	// Incr adds a request to key's current window, starting a window of
+	// length window if there is none, and returns the window's count.
+	Incr(key string, window time.Duration) (int64, error)
+}
+
+// Limiter is a fixed-window request limiter keyed by an arbitrary string
+// (typically a client IP). It is safe for concurrent use.
+type Limiter struct {
+	mu      sync.Mutex
+	limit   atomic.Int64
+	window  time.Duration
+	entries map[string]*window
+	counter Counter
+	now     func() time.Time
+}
+
+type window struct {
+	start    time.Time
+	count    int
+	rejected int
+}
+
+// New creates a Limiter allowing up to limit requests per key within each
+// window. A limit <= 0 disables limiting entirely.
+func New(limit int, per time.Duration) *Limiter {
+	l := &Limiter{
+		window:  per,
+		entries: make(map[string]*window),
+		now:     time.Now,
+	}
+	l.limit.Store(int64(limit))
+	return l
+}
+
+// SetLimit changes the number of requests allowed per window, taking
+// effect for requests from now on. A limit <= 0 disables limiting.
+func (l *Limiter) SetLimit(limit int) {
+	l.limit.Store(int64(limit))
+}
+
+// SetCounter counts requests with c instead of in memory, so instances
+// sharing c enforce one limit between them.
+func (l *Limiter) SetCounter(c Counter) {
+	l.counter = c
+}
+
+// Allow records a request for key and reports whether it is within the limit.
+func (l *Limiter) Allow(key string) bool {
+	ok, _ := l.allow(key)
+	return ok
+}
+
+// allow is Allow that also reports whether a rejection is the first one of
+// the key's current window.
+func (l *Limiter) allow(key string) (ok, firstRejection bool) {
+	if l == nil {
+		return true, false
+	}
+	limit := int(l.limit.Load())
+	if limit <= 0 {
+		return true, false
+	}
+	if l.counter != nil {
+		return l.allowShared(key, limit)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	w, ok := l.entries[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		if !ok && len(l.entries) >= maxEntries {
+			l.prune(now)
+		}
+		l.entries[key] = &window{start: now, count: 1}
+		return true, false
+	}
+	if w.count >= limit {
+		w.rejected++
+		return false, w.rejected == 1
+	}
+	w.count++
+	return true, false
+}
+
+// allowShared is allow for a shared counter. Requests are allowed when the
+// counter fails, so an outage of the shared store does not take down the
+// service it protects.
+func (l *Limiter) allowShared(key string, limit int) (ok, firstRejection bool) {
+	n, err := l.counter.Incr(key, l.window)
+	if err != nil {
+		log.Printf("[WARN] rate limit counter unavailable, allowing %s: %v", key, err)
+		return true, false
+	}
+	if n > int64(limit) {
+		return false, n == int64(limit)+1
+	}
+	return true, false
+}
+
+// prune removes windows that have already elapsed. Callers must hold l.mu.
+func (l *Limiter) prune(now time.Time) {
+	for k, w := range l.entries {
+		if now.Sub(w.start) >= l.window {
+			delete(l.entries, k)
+		}
+	}
+}
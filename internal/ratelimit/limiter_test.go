@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiter_AllowWithinWindow(t *testing.T) {
+	l := New(2, time.Minute)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	if !l.Allow("a") || !l.Allow("a") {
+		t.Fatal("expected first two requests to be allowed")
+	}
+	if l.Allow("a") {
+		t.Fatal("expected third request to be rejected")
+	}
+	if !l.Allow("b") {
+		t.Fatal("expected independent key to be allowed")
+	}
+
+	now = now.Add(time.Minute)
+	if !l.Allow("a") {
+		t.Fatal("expected request to be allowed after window elapsed")
+	}
+}
+
+func TestLimiter_Disabled(t *testing.T) {
+	l := New(0, time.Minute)
+	for i := 0; i < 100; i++ {
+		if !l.Allow("a") {
+			t.Fatalf("expected disabled limiter to allow request %d", i)
+		}
+	}
+}
+
+// memCounter is a Counter shared by the limiters of several instances.
+type memCounter struct {
+	counts map[string]int64
+	err    error
+}
+
+func (m *memCounter) Incr(key string, window time.Duration) (int64, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	m.counts[key]++
+	return m.counts[key], nil
+}
+
+func TestLimiter_SharedCounter(t *testing.T) {
+	shared := &memCounter{counts: map[string]int64{}}
+	a, b := New(2, time.Minute), New(2, time.Minute)
+	a.SetCounter(shared)
+	b.SetCounter(shared)
+
+	if !a.Allow("x") || !b.Allow("x") {
+		t.Fatal("expected the first two requests across instances to be allowed")
+	}
+	if ok, first := a.allow("x"); ok || !first {
+		t.Fatalf("third request = %t, first rejection %t; want rejected first", ok, first)
+	}
+	if ok, first := b.allow("x"); ok || first {
+		t.Fatalf("fourth request = %t, first rejection %t; want rejected again", ok, first)
+	}
+
+	shared.err = errors.New("connection refused")
+	if !a.Allow("x") {
+		t.Error("expected requests to be allowed while the counter is down")
+	}
+}
+
+func TestLimiter_SetLimit(t *testing.T) {
+	l := New(1, time.Minute)
+	if !l.Allow("a") || l.Allow("a") {
+		t.Fatal("expected one request to be allowed")
+	}
+	l.SetLimit(3)
+	if !l.Allow("a") || !l.Allow("a") || l.Allow("a") {
+		t.Fatal("expected the raised limit to apply to the current window")
+	}
+	l.SetLimit(0)
+	if !l.Allow("a") {
+		t.Fatal("expected a zero limit to disable limiting")
+	}
+}
@@ -0,0 +1,92 @@
+// Package redisstore keeps the state that nclip otherwise holds in memory
+// in a Redis server, so several instances behind a load balancer share it:
+// rate limit windows, used proof-of-work nonces and handled inbound emails.
+package redisstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/egress"
+	"github.com/redis/go-redis/v9"
+)
+
+// timeout bounds each Redis round trip, so a slow server delays requests
+// by at most this long.
+const timeout = 2 * time.Second
+
+// keyPrefix namespaces nclip's keys in a Redis database shared with other
+// applications.
+const keyPrefix = "nclip:"
+
+// incr counts a request in a fixed window, starting the window's expiry
+// with its first request so a counter never outlives its window.
+var incr = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return n
+`)
+
+// Client is shared state in Redis. It implements ratelimit.Counter,
+// pow.ReplayStore and emailin.ReplayStore and is safe for concurrent use.
+type Client struct {
+	rdb *redis.Client
+}
+
+// Open connects to the Redis server at url, a redis:// or rediss:// URL
+// such as redis://:password@host:6379/0.
+func Open(url string) (*Client, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+	// rediss:// URLs use TLS, trusting the roots of NCLIP_CA_BUNDLE.
+	if roots := egress.RootCAs(); roots != nil && opts.TLSConfig != nil {
+		opts.TLSConfig.RootCAs = roots
+	}
+	rdb := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		_ = rdb.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", egress.Explain(err))
+	}
+	return &Client{rdb: rdb}, nil
+}
+
+// Close closes the connections to the server.
+func (c *Client) Close() error {
+	return c.rdb.Close()
+}
+
+// Incr adds a request to key's current window and returns the window's
+// count.
+func (c *Client) Incr(key string, window time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return incr.Run(ctx, c.rdb, []string{keyPrefix + "ratelimit:" + key}, window.Milliseconds()).Int64()
+}
+
+// Use records a proof-of-work nonce as used until expires and reports
+// whether it was unused before.
+func (c *Client) Use(nonce string, expires time.Time) (bool, error) {
+	return c.setOnce("pow:"+nonce, expires)
+}
+
+// UseMessage records an inbound email's message ID as handled until
+// expires and reports whether it was not handled before.
+func (c *Client) UseMessage(id string, expires time.Time) (bool, error) {
+	return c.setOnce("email:"+id, expires)
+}
+
+// setOnce sets key until expires unless it is set, reporting whether it
+// was not.
+func (c *Client) setOnce(key string, expires time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ttl := max(time.Until(expires), time.Millisecond)
+	return c.rdb.SetNX(ctx, keyPrefix+key, 1, ttl).Result()
+}
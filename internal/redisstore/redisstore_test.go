@@ -0,0 +1,68 @@
+package redisstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestClient(t *testing.T) (*Client, *miniredis.Miniredis) {
+	t.Helper()
+	srv := miniredis.RunT(t)
+	c, err := Open("redis://" + srv.Addr())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c, srv
+}
+
+func TestClient_Incr(t *testing.T) {
+	c, srv := newTestClient(t)
+	for want := int64(1); want <= 3; want++ {
+		n, err := c.Incr("tcp:203.0.113.7/32", time.Minute)
+		if err != nil || n != want {
+			t.Fatalf("Incr = %d, %v; want %d", n, err, want)
+		}
+	}
+	if ttl := srv.TTL("nclip:ratelimit:tcp:203.0.113.7/32"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("window TTL = %s, want at most a minute", ttl)
+	}
+
+	srv.FastForward(time.Minute)
+	if n, err := c.Incr("tcp:203.0.113.7/32", time.Minute); err != nil || n != 1 {
+		t.Errorf("Incr after the window = %d, %v; want a new window", n, err)
+	}
+}
+
+func TestClient_Use(t *testing.T) {
+	c, srv := newTestClient(t)
+	expires := time.Now().Add(5 * time.Minute)
+	if unused, err := c.Use("nonce", expires); err != nil || !unused {
+		t.Fatalf("first Use = %t, %v; want unused", unused, err)
+	}
+	if unused, err := c.Use("nonce", expires); err != nil || unused {
+		t.Fatalf("second Use = %t, %v; want used", unused, err)
+	}
+	if !srv.Exists("nclip:pow:nonce") || srv.TTL("nclip:pow:nonce") <= 0 {
+		t.Error("used nonce was not stored with an expiry")
+	}
+
+	srv.Close()
+	if _, err := c.Use("other", expires); err == nil {
+		t.Error("expected Use to fail with the server down")
+	}
+}
+
+func TestOpen_Errors(t *testing.T) {
+	if _, err := Open("http://localhost:6379"); err == nil {
+		t.Error("Open accepted an http URL")
+	}
+	srv := miniredis.RunT(t)
+	addr := srv.Addr()
+	srv.Close()
+	if _, err := Open("redis://" + addr); err == nil {
+		t.Error("Open succeeded without a server")
+	}
+}
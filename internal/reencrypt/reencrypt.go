@@ -0,0 +1,280 @@
+// Package reencrypt runs the background job that moves paste content to
+// the current encryption key after a key rotation, and encrypts content
+// stored before encryption was enabled.
+//
+// The job walks every paste in slug order at a bounded rate. Its progress
+// is saved to a state file after each paste, so a job interrupted by a
+// restart resumes from the last slug it finished. Re-encrypting a paste
+// already on the current key is a no-op, so repeating a slug is harmless.
+package reencrypt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/johnwmail/nclip/storage"
+)
+
+// Job states.
+const (
+	StateIdle    = "idle"
+	StateRunning = "running"
+	StateStopped = "stopped"
+	StateDone    = "done"
+)
+
+// pageSize is how many slugs are listed at a time.
+const pageSize = 100
+
+var (
+	// ErrRunning is returned by Start while the job is running.
+	ErrRunning = errors.New("re-encryption is already running")
+	// ErrNotRunning is returned by Stop when the job is not running.
+	ErrNotRunning = errors.New("re-encryption is not running")
+)
+
+// Status is the progress of the job, as saved in the state file and
+// reported by the admin API.
+type Status struct {
+	State string `json:"state"`
+	// KeyID is the key the job moves content to.
+	KeyID string `json:"key_id,omitempty"`
+	// Rate is the maximum number of pastes processed per second.
+	Rate int `json:"rate,omitempty"`
+	// Cursor is the last slug processed; a resumed job continues after it.
+	Cursor      string     `json:"cursor,omitempty"`
+	Scanned     int        `json:"scanned"`
+	Reencrypted int        `json:"reencrypted"`
+	Failed      int        `json:"failed"`
+	LastError   string     `json:"last_error,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// Job re-encrypts all pastes of an EncryptedStore. It is safe for
+// concurrent use.
+type Job struct {
+	store     *storage.EncryptedStore
+	statePath string
+	// lock is held while a paste is rewritten; see SetLock.
+	lock sync.Locker
+
+	mu     sync.Mutex
+	status Status
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New creates a Job for store that saves its progress to statePath,
+// loading the progress saved there by an earlier run.
+func New(store *storage.EncryptedStore, statePath string) *Job {
+	j := &Job{store: store, statePath: statePath, status: Status{State: StateIdle}}
+	data, err := os.ReadFile(statePath) // #nosec G304 -- operator-configured path
+	if err == nil {
+		if err := json.Unmarshal(data, &j.status); err != nil {
+			log.Printf("[WARN] Re-encryption: ignoring unreadable state file %s: %v", statePath, err)
+			j.status = Status{State: StateIdle}
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("[WARN] Re-encryption: failed to read state file %s: %v", statePath, err)
+	}
+	return j
+}
+
+// SetLock makes the job hold l while it rewrites a paste, so it does not
+// race the content replacements and appends that hold l and write back
+// the content they replaced. It must be called before Start or Resume.
+func (j *Job) SetLock(l sync.Locker) {
+	j.lock = l
+}
+
+// Resume restarts a job that was running when the process stopped.
+func (j *Job) Resume() {
+	j.mu.Lock()
+	state, rate := j.status.State, j.status.Rate
+	j.mu.Unlock()
+	if state != StateRunning {
+		return
+	}
+	log.Printf("[INFO] Re-encryption: resuming interrupted job")
+	if err := j.Start(rate); err != nil {
+		log.Printf("[ERROR] Re-encryption: failed to resume: %v", err)
+	}
+}
+
+// Status returns the current progress.
+func (j *Job) Status() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// Start runs the job in the background, processing at most rate pastes per
+// second. A stopped or interrupted job for the current key continues
+// where it left off; otherwise the job starts from the first paste.
+func (j *Job) Start(rate int) error {
+	if rate < 1 {
+		return fmt.Errorf("rate must be at least 1")
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.cancel != nil {
+		return ErrRunning
+	}
+	now := time.Now().UTC()
+	keyID := j.store.KeyID()
+	resume := j.status.KeyID == keyID && (j.status.State == StateRunning || j.status.State == StateStopped)
+	if !resume {
+		j.status = Status{KeyID: keyID, StartedAt: &now}
+	}
+	j.status.State = StateRunning
+	j.status.Rate = rate
+	j.status.FinishedAt = nil
+	j.status.UpdatedAt = &now
+	j.saveLocked()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j.cancel = cancel
+	j.done = make(chan struct{})
+	go j.run(ctx, rate, j.status.Cursor, j.done)
+	return nil
+}
+
+// Stop stops the running job and waits for it to save its progress.
+func (j *Job) Stop() error {
+	j.mu.Lock()
+	cancel, done := j.cancel, j.done
+	j.mu.Unlock()
+	if cancel == nil {
+		return ErrNotRunning
+	}
+	cancel()
+	<-done
+	return nil
+}
+
+func (j *Job) run(ctx context.Context, rate int, cursor string, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+	for {
+		page, err := j.store.List(storage.ListOptions{Cursor: cursor, Limit: pageSize})
+		if err != nil {
+			log.Printf("[ERROR] Re-encryption: failed to list pastes after %q: %v", cursor, err)
+			j.finish(StateStopped, err)
+			return
+		}
+		for _, slug := range page.IDs {
+			select {
+			case <-ctx.Done():
+				j.finish(StateStopped, nil)
+				return
+			case <-ticker.C:
+			}
+			changed, err := j.reencrypt(slug)
+			j.record(slug, changed, err)
+		}
+		if page.NextCursor == "" {
+			j.finish(StateDone, nil)
+			return
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// reencrypt moves the content, cached preview and kept versions of slug
+// to the current key.
+func (j *Job) reencrypt(slug string) (bool, error) {
+	if j.lock != nil {
+		j.lock.Lock()
+		defer j.lock.Unlock()
+	}
+	changed, err := j.store.Reencrypt(slug)
+	if err != nil {
+		return false, err
+	}
+	if _, err := j.store.Reencrypt(slug + storage.PreviewSuffix); err != nil {
+		return changed, err
+	}
+	if paste, err := j.store.Get(slug); err == nil {
+		for _, v := range paste.Versions {
+			if _, err := j.store.Reencrypt(storage.VersionID(slug, v.Number)); err != nil {
+				return changed, err
+			}
+		}
+	}
+	if changed {
+		// The paste may have been deleted (or burned) while its content
+		// was rewritten; do not leave the rewritten content behind.
+		if exists, err := j.store.Exists(slug); err == nil && !exists {
+			_ = j.store.Delete(slug)
+		}
+	}
+	return changed, nil
+}
+
+func (j *Job) record(slug string, changed bool, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now().UTC()
+	j.status.Cursor = slug
+	j.status.Scanned++
+	if changed {
+		j.status.Reencrypted++
+	}
+	if err != nil {
+		log.Printf("[ERROR] Re-encryption: failed to re-encrypt %s: %v", slug, err)
+		j.status.Failed++
+		j.status.LastError = slug + ": " + err.Error()
+	}
+	j.status.UpdatedAt = &now
+	j.saveLocked()
+}
+
+func (j *Job) finish(state string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now().UTC()
+	j.status.State = state
+	j.status.UpdatedAt = &now
+	if err != nil {
+		j.status.LastError = err.Error()
+	}
+	if state == StateDone {
+		j.status.FinishedAt = &now
+		log.Printf("[INFO] Re-encryption to key %s done: %d scanned, %d re-encrypted, %d failed",
+			j.status.KeyID, j.status.Scanned, j.status.Reencrypted, j.status.Failed)
+	}
+	j.saveLocked()
+	j.cancel = nil
+	j.done = nil
+}
+
+// saveLocked writes the status to the state file, replacing it atomically.
+// Callers must hold j.mu.
+func (j *Job) saveLocked() {
+	data, err := json.MarshalIndent(j.status, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp := j.statePath + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(j.statePath), 0o755); err != nil {
+		log.Printf("[ERROR] Re-encryption: failed to save progress: %v", err)
+		return
+	}
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		log.Printf("[ERROR] Re-encryption: failed to save progress: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, j.statePath); err != nil {
+		log.Printf("[ERROR] Re-encryption: failed to save progress: %v", err)
+	}
+}
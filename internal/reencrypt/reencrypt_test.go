@@ -0,0 +1,173 @@
+package reencrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/keyring"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func keys(t *testing.T, spec string) *keyring.Keyring {
+	t.Helper()
+	k, err := keyring.Parse(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func key(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, keyring.KeySize))
+}
+
+// setup stores n pastes encrypted with k1 and returns the backend and a
+// store that has rotated to k2.
+func setup(t *testing.T, n int) (*storage.FilesystemStore, *storage.EncryptedStore) {
+	t.Helper()
+	backend, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := storage.NewEncryptedStore(backend, keys(t, "k1:"+key(1)))
+	for i := 0; i < n; i++ {
+		slug := "RKEY" + string("ABCDEFGHJKLMNPQRSTUVWXYZ"[i])
+		if err := old.Store(&models.Paste{ID: slug, CreatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if err := old.StoreContent(slug, []byte("content "+slug)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return backend, storage.NewEncryptedStore(backend, keys(t, "k2:"+key(2)+",k1:"+key(1)))
+}
+
+func wait(t *testing.T, j *Job, state string) Status {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if s := j.Status(); s.State == state {
+			return s
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job did not reach state %s: %+v", state, j.Status())
+	return Status{}
+}
+
+func TestJob_RunsToCompletion(t *testing.T) {
+	backend, store := setup(t, 5)
+	statePath := filepath.Join(t.TempDir(), "reencrypt.json")
+	j := New(store, statePath)
+	if err := j.Start(1000); err != nil {
+		t.Fatal(err)
+	}
+	s := wait(t, j, StateDone)
+	if s.KeyID != "k2" || s.Scanned != 5 || s.Reencrypted != 5 || s.Failed != 0 || s.FinishedAt == nil {
+		t.Errorf("unexpected status %+v", s)
+	}
+	page, _ := backend.List(storage.ListOptions{})
+	for _, slug := range page.IDs {
+		raw, _ := backend.GetContent(slug)
+		if id, _ := keyring.KeyID(raw); id != "k2" {
+			t.Errorf("%s still on key %q", slug, id)
+		}
+	}
+
+	var saved Status
+	data, err := os.ReadFile(statePath)
+	if err != nil || json.Unmarshal(data, &saved) != nil || saved.State != StateDone || saved.Scanned != 5 {
+		t.Errorf("state file not saved: %s (%v)", data, err)
+	}
+
+	// Starting again rescans from the beginning; nothing is left to do.
+	if err := j.Start(1000); err != nil {
+		t.Fatal(err)
+	}
+	if s := wait(t, j, StateDone); s.Scanned != 5 || s.Reencrypted != 0 {
+		t.Errorf("second run: %+v", s)
+	}
+	if err := j.Stop(); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Stop of finished job: expected ErrNotRunning, got %v", err)
+	}
+}
+
+func TestJob_ResumesAfterRestart(t *testing.T) {
+	backend, store := setup(t, 6)
+	statePath := filepath.Join(t.TempDir(), "reencrypt.json")
+	// The state a process left behind when it was killed after two pastes.
+	started := time.Now().UTC()
+	interrupted := Status{State: StateRunning, KeyID: "k2", Rate: 1000, Cursor: "RKEYB", Scanned: 2, Reencrypted: 2, StartedAt: &started}
+	data, _ := json.Marshal(interrupted)
+	if err := os.WriteFile(statePath, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	j := New(store, statePath)
+	j.Resume()
+	s := wait(t, j, StateDone)
+	if s.Scanned != 6 || s.Reencrypted != 6 || !s.StartedAt.Equal(started) {
+		t.Errorf("expected the job to continue after RKEYB, got %+v", s)
+	}
+	for slug, want := range map[string]string{"RKEYB": "k1", "RKEYC": "k2"} {
+		raw, _ := backend.GetContent(slug)
+		if id, _ := keyring.KeyID(raw); id != want {
+			t.Errorf("%s on key %q, want %q", slug, id, want)
+		}
+	}
+}
+
+func TestJob_StopAndStart(t *testing.T) {
+	_, store := setup(t, 20)
+	j := New(store, filepath.Join(t.TempDir(), "reencrypt.json"))
+	if err := j.Start(50); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Start(50); !errors.Is(err, ErrRunning) {
+		t.Errorf("second Start: expected ErrRunning, got %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := j.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	stopped := j.Status()
+	if stopped.State != StateStopped || stopped.Scanned == 0 || stopped.Scanned == 20 {
+		t.Fatalf("unexpected status after stop: %+v", stopped)
+	}
+	if err := j.Start(1000); err != nil {
+		t.Fatal(err)
+	}
+	if s := wait(t, j, StateDone); s.Scanned != 20 || s.Reencrypted != 20 {
+		t.Errorf("resumed job: %+v", s)
+	}
+}
+
+func TestJob_HoldsLock(t *testing.T) {
+	backend, store := setup(t, 1)
+	var mu sync.Mutex
+	j := New(store, filepath.Join(t.TempDir(), "reencrypt.json"))
+	j.SetLock(&mu)
+
+	// A content replacement is running.
+	mu.Lock()
+	if err := j.Start(1000); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	raw, _ := backend.GetContent("RKEYA")
+	if id, _ := keyring.KeyID(raw); id != "k1" {
+		t.Fatalf("expected the job to wait for the lock, content on key %q", id)
+	}
+	mu.Unlock()
+	if s := wait(t, j, StateDone); s.Reencrypted != 1 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
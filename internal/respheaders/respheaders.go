@@ -0,0 +1,291 @@
+// Package respheaders brings every response to a canonical header set
+// before it leaves nclip, since CDNs, API Gateway and some proxies reject
+// or mangle responses with odd headers: hop-by-hop headers are removed,
+// headers that take one value keep one, text types name their charset,
+// Content-Length is dropped where it cannot be right, and GET responses
+// get the Cache-Control default of their route class unless the handler
+// chose one.
+//
+// The same scrubbing covers the Lambda adapters, which skip net/http's
+// server: they join repeated headers with commas (or pass them on as a
+// multimap) and take the header map as it is when the handler returns.
+package respheaders
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Class groups routes that share a Cache-Control default.
+type Class string
+
+// Route classes.
+const (
+	// ClassStatic is static assets, such as /static/ and /embed.js.
+	ClassStatic Class = "static"
+	// ClassPage is HTML pages, such as /{slug}.
+	ClassPage Class = "page"
+	// ClassContent is paste content, such as /raw/{slug}.
+	ClassContent Class = "content"
+	// ClassAPI is /api/ and the other JSON endpoints.
+	ClassAPI Class = "api"
+)
+
+// Options configures Middleware.
+type Options struct {
+	// RoutePrefix is removed from paths before they are classified.
+	RoutePrefix string
+	// CacheControl is the Cache-Control default of each class; classes
+	// without one get none.
+	CacheControl map[Class]string
+}
+
+// NoStoreCacheControl is the Cache-Control of responses that no cache may
+// keep.
+const NoStoreCacheControl = "no-store, private"
+
+// SetNoStore marks h as a response that no cache may keep, such as the
+// content of a burn-after-read paste that is about to be deleted or of a
+// private paste. Pragma covers HTTP/1.0 proxies, and Surrogate-Control
+// the CDNs that give it precedence over Cache-Control.
+func SetNoStore(h http.Header) {
+	h.Set("Cache-Control", NoStoreCacheControl)
+	h.Set("Pragma", "no-cache")
+	h.Set("Surrogate-Control", "no-store")
+}
+
+// hopByHop are the headers that only concern one connection (RFC 9110,
+// section 7.6.1) and must not be forwarded by intermediaries.
+var hopByHop = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// singletons are headers that take a single value. Repeating them is
+// invalid, and the Lambda adapters would join the values into one.
+var singletons = []string{
+	"Content-Type",
+	"Content-Length",
+	"Content-Disposition",
+	"Cache-Control",
+	"Pragma",
+	"Surrogate-Control",
+	"ETag",
+	"Last-Modified",
+	"Location",
+	"Retry-After",
+}
+
+// Middleware scrubs the headers of every response as it is committed. It
+// must run before any middleware that writes responses, so that it sees
+// their final form.
+func Middleware(opts Options) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w := &writer{ResponseWriter: c.Writer, c: c, opts: opts}
+		c.Writer = w
+		c.Next()
+		// Responses without a body are committed by gin after the
+		// handlers return, bypassing w.
+		if !w.ResponseWriter.Written() {
+			w.scrub()
+		}
+		w.fixLength()
+	}
+}
+
+// writer scrubs the headers before they are written.
+type writer struct {
+	gin.ResponseWriter
+	c        *gin.Context
+	opts     Options
+	scrubbed bool
+}
+
+func (w *writer) scrub() {
+	if w.scrubbed {
+		return
+	}
+	w.scrubbed = true
+	cacheControl := ""
+	if method := w.c.Request.Method; method == http.MethodGet || method == http.MethodHead {
+		cacheControl = w.opts.CacheControl[Classify(w.c.Request.URL.Path, w.opts.RoutePrefix)]
+	}
+	Scrub(w.Header(), w.Status(), cacheControl)
+}
+
+func (w *writer) WriteHeaderNow() {
+	w.scrub()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	w.scrub()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *writer) WriteString(s string) (int, error) {
+	w.scrub()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *writer) Flush() {
+	w.scrub()
+	w.ResponseWriter.Flush()
+}
+
+// fixLength corrects a Content-Length that disagrees with the body
+// written. net/http has sent the headers by now and fails such writes
+// itself, but the Lambda adapters read the header map only after the
+// handler returned and would pass the wrong length on.
+func (w *writer) fixLength() {
+	if w.c.Request.Method == http.MethodHead || !w.ResponseWriter.Written() {
+		return
+	}
+	h := w.Header()
+	declared := h.Get("Content-Length")
+	if declared == "" {
+		return
+	}
+	if size := w.Size(); size >= 0 && declared != strconv.Itoa(size) {
+		h.Set("Content-Length", strconv.Itoa(size))
+	}
+}
+
+// Scrub brings h, the headers of a response with status, to the
+// canonical set. cacheControl is set unless h has a Cache-Control
+// already; empty sets none.
+func Scrub(h http.Header, status int, cacheControl string) {
+	for _, name := range strings.Split(h.Get("Connection"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			h.Del(name)
+		}
+	}
+	for _, name := range hopByHop {
+		h.Del(name)
+	}
+	// Headers set through the map directly may not be in canonical form,
+	// which Del and the singleton check below would miss.
+	for name, values := range h {
+		if canonical := http.CanonicalHeaderKey(name); canonical != name {
+			delete(h, name)
+			h[canonical] = append(h[canonical], values...)
+		}
+	}
+	for _, name := range singletons {
+		// The last value set is the one the handler meant.
+		if values := h.Values(name); len(values) > 1 {
+			h.Set(name, values[len(values)-1])
+		}
+	}
+	if ct := h.Get("Content-Type"); ct != "" {
+		h.Set("Content-Type", NormalizeContentType(ct))
+	}
+	if !bodyAllowed(status) {
+		h.Del("Content-Length")
+	} else if n := h.Get("Content-Length"); n != "" {
+		if v, err := strconv.ParseInt(n, 10, 64); err != nil || v < 0 {
+			h.Del("Content-Length")
+		}
+	}
+	if cacheControl != "" && h.Get("Cache-Control") == "" {
+		h.Set("Cache-Control", cacheControl)
+	}
+}
+
+// bodyAllowed reports whether a response with status may have a body, and
+// so a Content-Length.
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// NormalizeContentType lowercases the media type and charset of ct and
+// spells UTF-8 one way, and names utf-8 as the charset of text types that
+// name none, since nclip stores and generates text as UTF-8. Values that
+// do not parse are returned unchanged.
+func NormalizeContentType(ct string) string {
+	mediaType, params, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return ct
+	}
+	charset, ok := params["charset"]
+	switch {
+	case ok:
+		charset = strings.ToLower(charset)
+		if charset == "utf8" {
+			charset = "utf-8"
+		}
+		params["charset"] = charset
+	case isText(mediaType):
+		params["charset"] = "utf-8"
+	}
+	if out := mime.FormatMediaType(mediaType, params); out != "" {
+		return out
+	}
+	return ct
+}
+
+// isText reports whether mediaType is text that needs a charset.
+func isText(mediaType string) bool {
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml", "image/svg+xml":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/")
+}
+
+// Classify returns the class of the route serving path, after removing
+// routePrefix from it.
+func Classify(path, routePrefix string) Class {
+	path = strings.TrimPrefix(path, routePrefix)
+	switch {
+	case strings.HasPrefix(path, "/static/"), path == "/favicon.ico", path == "/embed.js":
+		return ClassStatic
+	case strings.HasPrefix(path, "/api/"), strings.HasPrefix(path, "/json/"), strings.HasPrefix(path, "/.well-known/"),
+		path == "/health", path == "/metrics":
+		return ClassAPI
+	}
+	for _, prefix := range []string{"/raw/", "/download/", "/r/", "/d/", "/sha256/", "/preview/", "/t/"} {
+		if strings.HasPrefix(path, prefix) {
+			return ClassContent
+		}
+	}
+	return ClassPage
+}
+
+// CheckCacheControl fails unless v is a list of Cache-Control directives,
+// such as "public, max-age=3600". Empty is allowed.
+func CheckCacheControl(v string) error {
+	if v == "" {
+		return nil
+	}
+	for _, directive := range strings.Split(v, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if !isToken(name) || strings.ContainsAny(arg, "\r\n") {
+			return fmt.Errorf("invalid Cache-Control directive %q", strings.TrimSpace(directive))
+		}
+	}
+	return nil
+}
+
+// isToken reports whether s is a non-empty directive name.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
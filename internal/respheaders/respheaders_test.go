@@ -0,0 +1,175 @@
+package respheaders
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	ginadapter "github.com/awslabs/aws-lambda-go-api-proxy/gin"
+	"github.com/gin-gonic/gin"
+)
+
+func TestScrub(t *testing.T) {
+	h := http.Header{}
+	h.Set("Connection", "close, X-Internal")
+	h.Set("X-Internal", "1")
+	h.Set("Keep-Alive", "timeout=5")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Add("Content-Type", "text/html")
+	h.Add("Content-Type", "text/plain; charset=UTF8")
+	h["content-disposition"] = []string{"inline"}
+	h.Set("Content-Length", "-3")
+	h.Set("X-Request-Id", "abc")
+
+	Scrub(h, http.StatusOK, "no-cache")
+	for _, name := range []string{"Connection", "X-Internal", "Keep-Alive", "Transfer-Encoding", "Content-Length"} {
+		if v := h.Get(name); v != "" {
+			t.Errorf("expected %s to be removed, got %q", name, v)
+		}
+	}
+	if v := h.Values("Content-Type"); len(v) != 1 || v[0] != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want the last value with a normalized charset", v)
+	}
+	if v := h.Get("Content-Disposition"); v != "inline" {
+		t.Errorf("expected a non-canonical header to be kept under its canonical name, got %q", v)
+	}
+	if v := h.Get("Cache-Control"); v != "no-cache" {
+		t.Errorf("Cache-Control = %q, want the default", v)
+	}
+	if v := h.Get("X-Request-Id"); v != "abc" {
+		t.Errorf("expected other headers to be kept, got %q", v)
+	}
+
+	h = http.Header{"Content-Length": {"0"}, "Cache-Control": {"private, no-store"}}
+	Scrub(h, http.StatusNotModified, "no-cache")
+	if h.Get("Content-Length") != "" || h.Get("Cache-Control") != "private, no-store" {
+		t.Errorf("304: got %v, want no Content-Length and the handler's Cache-Control", h)
+	}
+}
+
+func TestNormalizeContentType(t *testing.T) {
+	for in, want := range map[string]string{
+		"text/plain":                     "text/plain; charset=utf-8",
+		"Text/HTML; Charset=UTF-8":       "text/html; charset=utf-8",
+		"application/json":               "application/json; charset=utf-8",
+		"text/plain; charset=Shift_JIS":  "text/plain; charset=shift_jis",
+		"image/png":                      "image/png",
+		"application/octet-stream":       "application/octet-stream",
+		"not a media type; ===":          "not a media type; ===",
+		"text/csv; header=present":       "text/csv; charset=utf-8; header=present",
+		"application/xml; charset=utf-8": "application/xml; charset=utf-8",
+	} {
+		if got := NormalizeContentType(in); got != want {
+			t.Errorf("NormalizeContentType(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	for path, want := range map[string]Class{
+		"/static/app.js":           ClassStatic,
+		"/embed.js":                ClassStatic,
+		"/api/v1/meta/ABCDE":       ClassAPI,
+		"/json/ABCDE":              ClassAPI,
+		"/health":                  ClassAPI,
+		"/raw/ABCDE":               ClassContent,
+		"/d/ABCDE":                 ClassContent,
+		"/ABCDE":                   ClassPage,
+		"/":                        ClassPage,
+		"/paste/raw/ABCDE":         ClassContent,
+		"/paste/static/styles.css": ClassStatic,
+	} {
+		if got := Classify(path, "/paste"); got != want {
+			t.Errorf("Classify(%q) = %s, want %s", path, got, want)
+		}
+	}
+}
+
+func TestCheckCacheControl(t *testing.T) {
+	for v, ok := range map[string]bool{
+		"":                           true,
+		"no-store":                   true,
+		"public, max-age=3600":       true,
+		`private="Set-Cookie"`:       true,
+		"max age":                    false,
+		"no-store,":                  false,
+		"no-store\r\nX-Injected: 1":  false,
+		"public, max-age=60\nX-A: 1": false,
+	} {
+		if err := CheckCacheControl(v); (err == nil) != ok {
+			t.Errorf("CheckCacheControl(%q) = %v, want ok=%v", v, err, ok)
+		}
+	}
+}
+
+// newRouter returns a router with Middleware and handlers that send odd
+// headers.
+func newRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware(Options{CacheControl: map[Class]string{ClassContent: "no-cache", ClassAPI: "no-store"}}))
+	router.GET("/raw/:slug", func(c *gin.Context) {
+		c.Writer.Header().Add("Content-Type", "text/html")
+		c.Writer.Header().Add("Content-Type", "text/plain")
+		c.Header("Connection", "keep-alive")
+		c.Header("Content-Length", "999")
+		c.String(http.StatusOK, "hello")
+	})
+	router.DELETE("/api/v1/pastes/:slug", func(c *gin.Context) {
+		c.Header("Content-Length", "12")
+		c.Status(http.StatusNoContent)
+	})
+	return router
+}
+
+func TestMiddleware(t *testing.T) {
+	router := newRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/raw/ABCDE", nil))
+	if got := w.Header().Values("Content-Type"); len(got) != 1 || got[0] != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if w.Header().Get("Connection") != "" || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("unexpected headers %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v1/pastes/ABCDE", nil))
+	if w.Code != http.StatusNoContent || w.Header().Get("Content-Length") != "" || w.Header().Get("Cache-Control") != "" {
+		t.Errorf("204: got %d %v, want no Content-Length and no default Cache-Control outside GET", w.Code, w.Header())
+	}
+}
+
+// TestMiddleware_Lambda runs the middleware behind the Lambda adapters,
+// which join repeated headers (v2) or pass them as a multimap (v1), and
+// read the headers only once the handler returned.
+func TestMiddleware_Lambda(t *testing.T) {
+	router := newRouter()
+	ctx := context.Background()
+
+	v2 := events.APIGatewayV2HTTPRequest{RawPath: "/raw/ABCDE"}
+	v2.RequestContext.HTTP.Method = "GET"
+	v2.RequestContext.HTTP.Path = "/raw/ABCDE"
+	resp, err := ginadapter.NewV2(router).ProxyWithContext(ctx, v2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Body != "hello" || resp.Headers["Content-Type"] != "text/plain; charset=utf-8" || resp.Headers["Content-Length"] != "5" {
+		t.Errorf("v2 response: body %q, headers %v", resp.Body, resp.Headers)
+	}
+	if _, ok := resp.Headers["Connection"]; ok {
+		t.Errorf("v2 response kept Connection: %v", resp.Headers)
+	}
+
+	v1 := events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/raw/ABCDE"}
+	resp1, err := ginadapter.New(router).ProxyWithContext(ctx, v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp1.MultiValueHeaders["Content-Type"]; len(ct) != 1 || resp1.MultiValueHeaders["Content-Length"][0] != "5" {
+		t.Errorf("v1 response headers %v", resp1.MultiValueHeaders)
+	}
+}
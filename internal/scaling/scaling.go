@@ -0,0 +1,109 @@
+// Package scaling checks a configuration for features that keep their
+// state in one instance's memory or on its local disk, which silently
+// misbehave when several instances serve the same pastes behind a load
+// balancer without sticky sessions.
+package scaling
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/johnwmail/nclip/config"
+)
+
+// Severity ranks a Finding.
+type Severity int
+
+const (
+	// Warning marks state that is per instance but degrades gracefully,
+	// such as a rate limit that each instance enforces on its own.
+	Warning Severity = iota
+	// Error marks state that breaks requests served by another instance.
+	Error
+)
+
+func (s Severity) String() string {
+	if s == Error {
+		return "ERROR"
+	}
+	return "WARN"
+}
+
+// Finding is a feature that does not scale out as configured.
+type Finding struct {
+	Severity Severity
+	// Setting is the environment variable that enables the feature.
+	Setting string
+	Message string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s", f.Setting, f.Message)
+}
+
+// Diagnose returns the findings for cfg when it runs as one of
+// cfg.Instances instances, or as a Lambda function, which AWS scales out
+// on its own. It returns nil for a single instance.
+func Diagnose(cfg *config.Config, lambda bool) []Finding {
+	if cfg.Instances <= 1 && !lambda {
+		return nil
+	}
+	var findings []Finding
+	add := func(severity Severity, setting, format string, args ...any) {
+		findings = append(findings, Finding{severity, setting, fmt.Sprintf(format, args...)})
+	}
+	shared := cfg.RedisURL != ""
+
+	if cfg.SessionSecret == "" {
+		add(Error, "NCLIP_SESSION_SECRET", "not set, so each instance signs sessions, CSRF tokens, upload links and proof-of-work challenges with its own random key and rejects those of the others")
+	}
+	if cfg.PoWDifficulty > 0 && !shared {
+		add(Warning, "NCLIP_POW_DIFFICULTY", "used proof-of-work nonces are remembered in memory, so a solution can be replayed once against each instance; set NCLIP_REDIS_URL to share them")
+	}
+	if cfg.EmailSNSTopic != "" && !shared && !cfg.IsReplica() {
+		add(Warning, "NCLIP_EMAIL_SNS_TOPIC", "handled inbound emails are remembered in memory, so an SNS redelivery to another instance creates the pastes again; set NCLIP_REDIS_URL to share them")
+	}
+	if cfg.HotSlugs > 0 {
+		add(Warning, "NCLIP_HOT_SLUGS", "the hot slug tracker only counts the reads of its own instance; sum /api/v1/stats/hot or the metrics over all instances")
+	}
+	if cfg.IsMemory() {
+		add(Error, "NCLIP_STORAGE_TYPE", "the memory backend keeps pastes in the instance that stored them, so the others answer 404 for them")
+	}
+	if lambda {
+		// The remaining features need a long-running process and a local
+		// disk, and are ignored in Lambda mode.
+		return findings
+	}
+
+	if cfg.MongoURI == "" && !cfg.IsMemory() {
+		add(Warning, "NCLIP_DATA_DIR", "the filesystem backend keeps pastes in %s, which must be a volume shared by all instances; otherwise use NCLIP_MONGO_URI", cfg.DataDir)
+	}
+	if cfg.TCPRateLimit > 0 && (cfg.TCPPort != 0 || cfg.GopherPort != 0) && !shared {
+		add(Warning, "NCLIP_TCP_RATE_LIMIT", "each instance counts requests on its own, allowing a client up to %d per minute in total; set NCLIP_REDIS_URL to share the limit", cfg.TCPRateLimit*cfg.Instances)
+	}
+	if cfg.IsReplica() {
+		// Replicas never write, so they neither spool nor journal uploads
+		// nor see the reads that burn pastes.
+		return findings
+	}
+	if cfg.SpoolDir != "" {
+		add(Warning, "NCLIP_SPOOL_DIR", "uploads spooled while storage is unavailable can only be read from the instance that accepted them until they are flushed")
+	}
+	if cfg.SyncJournal != "" {
+		add(Error, "NCLIP_SYNC_JOURNAL", "each instance journals only its own writes, so mirrors miss the changes made through the others; run a single writer and scale out with replicas")
+	}
+	if cfg.VAPIDPrivateKey != "" && !cfg.IsMirror() {
+		add(Error, "NCLIP_VAPID_PRIVATE_KEY", "push subscriptions are kept in %s by the instance they were made on: other instances do not notify burns, and a shared data directory is overwritten by each of them", filepath.Join(cfg.DataDir, ".push.json"))
+	}
+	return findings
+}
+
+// Failed reports whether findings include an Error.
+func Failed(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package scaling
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/johnwmail/nclip/config"
+)
+
+// settings returns the settings findings name, in order.
+func settings(findings []Finding) []string {
+	var names []string
+	for _, f := range findings {
+		names = append(names, f.Setting)
+	}
+	return names
+}
+
+func TestDiagnose(t *testing.T) {
+	stateful := func(c *config.Config) {
+		c.Instances = 3
+		c.SessionSecret = ""
+		c.PoWDifficulty = 16
+		c.TCPPort = 2323
+		c.TCPRateLimit = 60
+		c.SpoolDir = "/var/spool/nclip"
+		c.SyncJournal = "/var/lib/nclip/journal"
+		c.VAPIDPrivateKey = "key"
+		c.EmailSNSTopic = "arn:aws:sns:us-east-1:123456789012:nclip-mail"
+	}
+	tests := []struct {
+		name   string
+		edit   func(*config.Config)
+		lambda bool
+		want   []string
+		failed bool
+	}{
+		{"single instance", func(c *config.Config) { stateful(c); c.Instances = 1 }, false, nil, false},
+		{"defaults", func(c *config.Config) { c.Instances = 2; c.SessionSecret = "s" }, false,
+			[]string{"NCLIP_HOT_SLUGS", "NCLIP_DATA_DIR"}, false},
+		{"stateful", stateful, false,
+			[]string{"NCLIP_SESSION_SECRET", "NCLIP_POW_DIFFICULTY", "NCLIP_EMAIL_SNS_TOPIC", "NCLIP_HOT_SLUGS", "NCLIP_DATA_DIR", "NCLIP_TCP_RATE_LIMIT",
+				"NCLIP_SPOOL_DIR", "NCLIP_SYNC_JOURNAL", "NCLIP_VAPID_PRIVATE_KEY"}, true},
+		{"shared", func(c *config.Config) {
+			stateful(c)
+			c.SessionSecret, c.RedisURL, c.MongoURI, c.HotSlugs = "s", "redis://cache:6379", "mongodb://db", 0
+			c.SpoolDir, c.SyncJournal, c.VAPIDPrivateKey = "", "", ""
+		}, false, nil, false},
+		{"replicas", func(c *config.Config) {
+			stateful(c)
+			c.Role = config.RoleReplica
+			c.SessionSecret = "s"
+			c.HotSlugs = 0
+		}, false,
+			[]string{"NCLIP_POW_DIFFICULTY", "NCLIP_DATA_DIR", "NCLIP_TCP_RATE_LIMIT"}, false},
+		{"memory", func(c *config.Config) { c.Instances = 2; c.SessionSecret = "s"; c.StorageType = config.StorageMemory }, false,
+			[]string{"NCLIP_HOT_SLUGS", "NCLIP_STORAGE_TYPE"}, true},
+		{"lambda", func(c *config.Config) { stateful(c); c.Instances = 1 }, true,
+			[]string{"NCLIP_SESSION_SECRET", "NCLIP_POW_DIFFICULTY", "NCLIP_EMAIL_SNS_TOPIC", "NCLIP_HOT_SLUGS"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			tt.edit(cfg)
+			findings := Diagnose(cfg, tt.lambda)
+			if got := settings(findings); !slices.Equal(got, tt.want) {
+				t.Fatalf("Diagnose() = %v, want %v", got, tt.want)
+			}
+			if Failed(findings) != tt.failed {
+				t.Errorf("Failed() = %t, want %t", Failed(findings), tt.failed)
+			}
+		})
+	}
+}
+
+func TestDiagnose_TCPLimitTotal(t *testing.T) {
+	cfg := config.Default()
+	cfg.Instances, cfg.SessionSecret, cfg.HotSlugs, cfg.MongoURI = 4, "s", 0, "mongodb://db"
+	cfg.TCPPort, cfg.TCPRateLimit = 2323, 30
+	findings := Diagnose(cfg, false)
+	if len(findings) != 1 || findings[0].String() != "NCLIP_TCP_RATE_LIMIT: each instance counts requests on its own, allowing a client up to 120 per minute in total; set NCLIP_REDIS_URL to share the limit" {
+		t.Errorf("Diagnose() = %v", findings)
+	}
+}
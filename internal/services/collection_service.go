@@ -0,0 +1,266 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// collectionIDLength is the length of generated collection ids. They share
+// the slug alphabet but live in their own namespace under /c/.
+const collectionIDLength = 8
+
+// Errors returned by CollectionService.
+var (
+	ErrInvalidCollection   = errors.New("invalid collection")
+	ErrCollectionNotFound  = errors.New("collection not found")
+	ErrCollectionForbidden = errors.New("collection belongs to another API key")
+	ErrCollectionFull      = fmt.Errorf("a collection holds at most %d pastes", models.MaxCollectionPastes)
+	ErrCollectionsDisabled = errors.New("the storage backend does not support collections")
+)
+
+// Actor identifies who is changing a collection: the audit key ID of their
+// API key ("" without one) and whether that key has the admin scope.
+type Actor struct {
+	Owner string
+	Admin bool
+}
+
+// canManage reports whether a may change col: its owner, an admin, or
+// anyone for collections created without an API key.
+func (a Actor) canManage(col *models.Collection) bool {
+	return a.Admin || col.Owner == "" || col.Owner == a.Owner
+}
+
+// CollectionDeleteResult reports what DeleteCollection did with the
+// members of a collection deleted with cascade.
+type CollectionDeleteResult struct {
+	// Deleted lists member pastes that were deleted.
+	Deleted []string `json:"deleted"`
+	// Kept lists members left in place: pastes of other API keys, pastes
+	// under legal hold, and pastes that could not be deleted.
+	Kept []string `json:"kept"`
+}
+
+// CollectionService handles collections of pastes.
+type CollectionService struct {
+	store  storage.PasteStore
+	pastes *PasteService
+	// mu serializes membership changes within this process, since
+	// collections are rewritten as a whole.
+	mu sync.Mutex
+}
+
+// NewCollectionService creates a collection service. Pastes are deleted
+// through pastes, so cascading deletes honour legal holds.
+func NewCollectionService(store storage.PasteStore, pastes *PasteService) *CollectionService {
+	return &CollectionService{store: store, pastes: pastes}
+}
+
+func (s *CollectionService) backend() (storage.CollectionStore, error) {
+	cs, ok := s.store.(storage.CollectionStore)
+	if !ok {
+		return nil, ErrCollectionsDisabled
+	}
+	return cs, nil
+}
+
+// CreateCollection creates an empty collection owned by owner.
+func (s *CollectionService) CreateCollection(title, description, owner string) (*models.Collection, error) {
+	title = strings.TrimSpace(title)
+	description = strings.TrimSpace(description)
+	if title == "" || len(title) > models.MaxCollectionTitle {
+		return nil, fmt.Errorf("%w: title must be 1 to %d bytes", ErrInvalidCollection, models.MaxCollectionTitle)
+	}
+	if len(description) > models.MaxCollectionDescription {
+		return nil, fmt.Errorf("%w: description must be at most %d bytes", ErrInvalidCollection, models.MaxCollectionDescription)
+	}
+	cs, err := s.backend()
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := utils.GenerateSlugBatch(5, collectionIDLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate collection id: %w", err)
+	}
+	for _, id := range candidates {
+		if _, err := cs.GetCollection(id); !errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		col := &models.Collection{
+			ID:          id,
+			Title:       title,
+			Description: description,
+			CreatedAt:   time.Now().UTC(),
+			Owner:       owner,
+			Pastes:      []string{},
+		}
+		if err := cs.StoreCollection(col); err != nil {
+			return nil, fmt.Errorf("failed to store collection: %w", err)
+		}
+		return col, nil
+	}
+	return nil, fmt.Errorf("failed to generate a unique collection id")
+}
+
+// GetCollection returns the collection with id.
+func (s *CollectionService) GetCollection(id string) (*models.Collection, error) {
+	if !utils.IsValidSlug(id) {
+		return nil, ErrCollectionNotFound
+	}
+	cs, err := s.backend()
+	if err != nil {
+		return nil, err
+	}
+	col, err := cs.GetCollection(id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrCollectionNotFound
+	}
+	return col, err
+}
+
+// Members returns the metadata of the collection's pastes that still
+// exist, in the order they were added.
+func (s *CollectionService) Members(col *models.Collection) []*models.Paste {
+	results := storage.GetBatch(s.store, col.Pastes)
+	members := make([]*models.Paste, 0, len(col.Pastes))
+	for _, slug := range col.Pastes {
+		if p := results[slug].Paste; p != nil && !p.IsExpired() {
+			members = append(members, p)
+		}
+	}
+	return members
+}
+
+// CheckAttach reports whether actor may add pastes to the collection with
+// id, so uploads can fail before the paste is stored.
+func (s *CollectionService) CheckAttach(id string, actor Actor) error {
+	col, err := s.GetCollection(id)
+	if err != nil {
+		return err
+	}
+	if !actor.canManage(col) {
+		return ErrCollectionForbidden
+	}
+	return nil
+}
+
+// Attach adds existing pastes to the collection. Slugs already in it are
+// ignored, and a missing paste fails with storage.ErrNotFound. When the collection is full, members that no longer exist are
+// dropped to make room.
+func (s *CollectionService) Attach(id string, actor Actor, slugs ...string) (*models.Collection, error) {
+	for _, slug := range slugs {
+		if !utils.IsValidSlug(slug) {
+			return nil, fmt.Errorf("%w: invalid slug format: %q", ErrInvalidCollection, slug)
+		}
+	}
+	return s.update(id, actor, func(col *models.Collection) error {
+		for _, slug := range slugs {
+			if col.Has(slug) {
+				continue
+			}
+			if _, err := s.store.Get(slug); err != nil {
+				return fmt.Errorf("paste %s: %w", slug, err)
+			}
+			col.Pastes = append(col.Pastes, slug)
+		}
+		if len(col.Pastes) > models.MaxCollectionPastes {
+			live := col.Pastes[:0]
+			for _, p := range s.Members(col) {
+				live = append(live, p.ID)
+			}
+			col.Pastes = live
+		}
+		if len(col.Pastes) > models.MaxCollectionPastes {
+			return ErrCollectionFull
+		}
+		return nil
+	})
+}
+
+// Detach removes a paste from the collection. The paste itself is kept.
+func (s *CollectionService) Detach(id string, actor Actor, slug string) (*models.Collection, error) {
+	return s.update(id, actor, func(col *models.Collection) error {
+		kept := col.Pastes[:0]
+		for _, p := range col.Pastes {
+			if p != slug {
+				kept = append(kept, p)
+			}
+		}
+		col.Pastes = kept
+		return nil
+	})
+}
+
+// update applies change to the collection with id and stores it.
+func (s *CollectionService) update(id string, actor Actor, change func(*models.Collection) error) (*models.Collection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	col, err := s.GetCollection(id)
+	if err != nil {
+		return nil, err
+	}
+	if !actor.canManage(col) {
+		return nil, ErrCollectionForbidden
+	}
+	if err := change(col); err != nil {
+		return nil, err
+	}
+	cs, err := s.backend()
+	if err != nil {
+		return nil, err
+	}
+	if err := cs.StoreCollection(col); err != nil {
+		return nil, fmt.Errorf("failed to store collection: %w", err)
+	}
+	return col, nil
+}
+
+// DeleteCollection deletes the collection with id. With cascade, member
+// pastes are deleted too, but only those actor could delete one by one:
+// an admin deletes every member not under legal hold, anyone else only
+// the pastes uploaded with the collection owner's key.
+func (s *CollectionService) DeleteCollection(id string, actor Actor, cascade bool) (CollectionDeleteResult, error) {
+	result := CollectionDeleteResult{Deleted: []string{}, Kept: []string{}}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	col, err := s.GetCollection(id)
+	if err != nil {
+		return result, err
+	}
+	if !actor.canManage(col) {
+		return result, ErrCollectionForbidden
+	}
+	if cascade {
+		var doomed []*models.Paste
+		for _, p := range s.Members(col) {
+			if !actor.Admin && p.Owner != col.Owner {
+				result.Kept = append(result.Kept, p.ID)
+				continue
+			}
+			doomed = append(doomed, p)
+		}
+		errs := s.pastes.RemovePastes(doomed, storage.LogDeleteProgress("Delete collection "+id))
+		for _, p := range doomed {
+			if _, failed := errs[p.ID]; failed {
+				result.Kept = append(result.Kept, p.ID)
+				continue
+			}
+			result.Deleted = append(result.Deleted, p.ID)
+		}
+	}
+	cs, err := s.backend()
+	if err != nil {
+		return result, err
+	}
+	if err := cs.DeleteCollection(id); err != nil {
+		return result, fmt.Errorf("failed to delete collection: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+	"github.com/johnwmail/nclip/utils"
+)
+
+func newCollectionTestServices(t *testing.T) (*PasteService, *CollectionService) {
+	t.Helper()
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	pastes := NewPasteService(store, &config.Config{})
+	collections := NewCollectionService(store, pastes)
+	pastes.SetCollections(collections)
+	return pastes, collections
+}
+
+func TestCollections_Membership(t *testing.T) {
+	pastes, collections := newCollectionTestServices(t)
+	alice, bob := Actor{Owner: "alice"}, Actor{Owner: "bob"}
+
+	if _, err := collections.CreateCollection("  ", "", "alice"); !errors.Is(err, ErrInvalidCollection) {
+		t.Fatalf("expected ErrInvalidCollection for an empty title, got %v", err)
+	}
+	col, err := collections.CreateCollection("Incident 42", "logs", "alice")
+	if err != nil {
+		t.Fatalf("CreateCollection: %v", err)
+	}
+
+	// Uploads can join a collection their key owns.
+	resp, err := pastes.CreatePaste(CreatePasteRequest{Content: []byte("log"), TTL: time.Hour, Owner: "alice", Collection: col.ID})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	if _, err := pastes.CreatePaste(CreatePasteRequest{Content: []byte("x"), TTL: time.Hour, Owner: "bob", Collection: col.ID}); !errors.Is(err, ErrCollectionForbidden) {
+		t.Errorf("expected ErrCollectionForbidden for another key, got %v", err)
+	}
+	if _, err := pastes.CreatePaste(CreatePasteRequest{Content: []byte("x"), TTL: time.Hour, Collection: "NPQRSTUV"}); !errors.Is(err, ErrCollectionNotFound) {
+		t.Errorf("expected ErrCollectionNotFound, got %v", err)
+	}
+
+	other, err := pastes.CreatePaste(CreatePasteRequest{Content: []byte("notes"), TTL: time.Hour, Owner: "bob"})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	if _, err := collections.Attach(col.ID, bob, other.Slug); !errors.Is(err, ErrCollectionForbidden) {
+		t.Errorf("expected bob not to change alice's collection, got %v", err)
+	}
+	if _, err := collections.Attach(col.ID, alice, "MSSNG"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing paste, got %v", err)
+	}
+	col, err = collections.Attach(col.ID, alice, other.Slug, resp.Slug)
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	if len(col.Pastes) != 2 || col.Pastes[0] != resp.Slug || col.Pastes[1] != other.Slug {
+		t.Fatalf("expected both pastes once, in order, got %v", col.Pastes)
+	}
+
+	// Members skips pastes that are gone.
+	if err := pastes.DeletePaste(resp.Slug); err != nil {
+		t.Fatal(err)
+	}
+	if members := collections.Members(col); len(members) != 1 || members[0].ID != other.Slug {
+		t.Errorf("expected only %s, got %v", other.Slug, members)
+	}
+	col, err = collections.Detach(col.ID, Actor{Admin: true}, resp.Slug)
+	if err != nil || len(col.Pastes) != 1 {
+		t.Errorf("expected an admin to detach, got %v (%v)", col, err)
+	}
+}
+
+func TestCollections_DeleteCascade(t *testing.T) {
+	pastes, collections := newCollectionTestServices(t)
+	col, err := collections.CreateCollection("Release", "", "alice")
+	if err != nil {
+		t.Fatalf("CreateCollection: %v", err)
+	}
+	var slugs []string
+	for _, req := range []CreatePasteRequest{
+		{Content: []byte("mine"), TTL: time.Hour, Owner: "alice"},
+		{Content: []byte("held"), TTL: time.Hour, Owner: "alice"},
+		{Content: []byte("bob's"), TTL: time.Hour, Owner: "bob"},
+	} {
+		resp, err := pastes.CreatePaste(req)
+		if err != nil {
+			t.Fatalf("CreatePaste: %v", err)
+		}
+		slugs = append(slugs, resp.Slug)
+	}
+	held, err := pastes.GetPaste(slugs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	held.LegalHold = true
+	if err := pastes.store.Store(held); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := collections.Attach(col.ID, Actor{Owner: "alice"}, slugs...); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+
+	if _, err := collections.DeleteCollection(col.ID, Actor{Owner: "bob"}, true); !errors.Is(err, ErrCollectionForbidden) {
+		t.Fatalf("expected ErrCollectionForbidden, got %v", err)
+	}
+	result, err := collections.DeleteCollection(col.ID, Actor{Owner: "alice"}, true)
+	if err != nil {
+		t.Fatalf("DeleteCollection: %v", err)
+	}
+	if len(result.Deleted) != 1 || result.Deleted[0] != slugs[0] || len(result.Kept) != 2 {
+		t.Errorf("expected only alice's unheld paste to be deleted, got %+v", result)
+	}
+	if _, err := collections.GetCollection(col.ID); !errors.Is(err, ErrCollectionNotFound) {
+		t.Errorf("expected the collection to be gone, got %v", err)
+	}
+	for _, slug := range slugs[1:] {
+		if _, err := pastes.GetPaste(slug); err != nil {
+			t.Errorf("expected %s to be kept, got %v", slug, err)
+		}
+	}
+}
+
+func TestCollections_Full(t *testing.T) {
+	pastes, collections := newCollectionTestServices(t)
+	col, err := collections.CreateCollection("Big", "", "")
+	if err != nil {
+		t.Fatalf("CreateCollection: %v", err)
+	}
+	// Fill the collection with slugs of pastes that no longer exist; they
+	// are dropped to make room.
+	if col.Pastes, err = utils.GenerateSlugBatch(models.MaxCollectionPastes, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := pastes.store.(storage.CollectionStore).StoreCollection(col); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := pastes.CreatePaste(CreatePasteRequest{Content: []byte("x"), TTL: time.Hour, Collection: col.ID})
+	if err != nil {
+		t.Fatalf("expected dead members to make room, got %v", err)
+	}
+	col, err = collections.GetCollection(col.ID)
+	if err != nil || len(col.Pastes) != 1 || col.Pastes[0] != resp.Slug {
+		t.Errorf("expected only the new paste to remain, got %v (%v)", col, err)
+	}
+}
@@ -1,19 +1,112 @@
 package services
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"log"
+	"sync"
 	"time"
 
 	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/burnnotify"
+	"github.com/johnwmail/nclip/internal/hotkeys"
+	"github.com/johnwmail/nclip/internal/preview"
+	"github.com/johnwmail/nclip/internal/push"
+	"github.com/johnwmail/nclip/internal/tenancy"
+	"github.com/johnwmail/nclip/internal/uploadlink"
 	"github.com/johnwmail/nclip/models"
 	"github.com/johnwmail/nclip/storage"
 	"github.com/johnwmail/nclip/utils"
 )
 
+// ErrWriterOnly is returned by replicas for reads that must modify the
+// backend, such as burn-after-read pastes.
+var ErrWriterOnly = errors.New("burn-after-read pastes are only served by the writer")
+
+// ErrBurnClaimed is returned by ClaimBurn when another client is reading
+// the paste, or the claimant has used up its retries.
+var ErrBurnClaimed = errors.New("burn-after-read paste is being read by another client")
+
+// ErrUploadLinkUsed is returned by ClaimUploadLink for links already used.
+var ErrUploadLinkUsed = errors.New("upload link has already been used")
+
+// ErrLegalHold is returned by RemovePaste, and by UpdatePaste when asked to
+// burn, for pastes under legal hold.
+var ErrLegalHold = errors.New("paste is under legal hold")
+
+// ErrContentCorrupt is returned by ReadPaste, and by TokenService.UseToken,
+// for pastes "nclip audit --repair" marked corrupt, whose content is no
+// longer served.
+var ErrContentCorrupt = errors.New("paste content is corrupt")
+
+// ErrNoOwner is returned when a paste without an owning API key is made
+// private.
+var ErrNoOwner = errors.New("private pastes require an API key")
+
+// ErrBurnReplace is returned by ReplaceContent for burn-after-read pastes.
+var ErrBurnReplace = errors.New("burn-after-read pastes cannot be replaced")
+
+// ErrNotAppendable is returned by AppendContent for pastes that were not
+// created appendable, or whose final chunk was appended.
+var ErrNotAppendable = errors.New("paste is not appendable")
+
+// ErrBurnAppendable is returned by CreatePaste and UpdatePaste for
+// burn-after-read pastes that ask to be appendable; the first read would
+// end them.
+var ErrBurnAppendable = errors.New("burn-after-read pastes cannot be appendable")
+
+// ErrAppendTooLarge is returned by AppendContent when the chunk would take
+// the paste past the size limit.
+var ErrAppendTooLarge = errors.New("content too large")
+
+// ErrUploadMissing is returned by CreatePaste for presigned uploads whose
+// content has not been stored yet.
+var ErrUploadMissing = errors.New("the upload has not been stored yet")
+
+// ErrUploadMismatch is returned by CreatePaste for presigned uploads whose
+// stored content differs from what was declared.
+var ErrUploadMismatch = errors.New("the uploaded content does not match the declared size or type")
+
+// ErrVersionNotFound is returned by GetVersion for versions that were
+// never kept or have been pruned.
+var ErrVersionNotFound = errors.New("version not found")
+
+// ErrChecksumNotFound is returned by FindByChecksum when no paste that may
+// be served by its checksum has that content.
+var ErrChecksumNotFound = errors.New("no paste with this checksum")
+
+// ErrVerifyFailed is returned by CreatePaste when a paste created with
+// Verify could not be read back as it was stored. The paste is removed.
+var ErrVerifyFailed = errors.New("the stored paste could not be read back")
+
 // PasteService handles paste business logic
 type PasteService struct {
-	store  storage.PasteStore
-	config *config.Config
+	store    storage.PasteStore
+	config   *config.Config
+	reserved *utils.ReservedSlugs
+	// recent tracks pastes created by this process, whose metadata may not
+	// be visible yet on eventually consistent backends.
+	recent *recentWrites
+	// linkMu serializes upload link claims within this process.
+	linkMu sync.Mutex
+	// burnMu serializes burn-after-read claims within this process.
+	burnMu sync.Mutex
+	// versionMu serializes content replacements within this process; see
+	// ContentLock.
+	versionMu sync.Mutex
+	// collections adds new pastes to the collection named at upload.
+	collections *CollectionService
+	// hot tracks the most-read slugs.
+	hot *hotkeys.Tracker
+	// push notifies uploaders subscribed to a paste when it is burned.
+	push *push.Notifier
+	// burnNotify tells the target a paste was uploaded with when it is
+	// burned.
+	burnNotify *burnnotify.Notifier
+	// tenants enforces the storage quotas of tenants.
+	tenants *tenancy.Tracker
 }
 
 // NewPasteService creates a new paste service
@@ -21,9 +114,44 @@ func NewPasteService(store storage.PasteStore, config *config.Config) *PasteServ
 	return &PasteService{
 		store:  store,
 		config: config,
+		recent: newRecentWrites(),
 	}
 }
 
+// SetCollections enables adding pastes to a collection at upload.
+func (s *PasteService) SetCollections(collections *CollectionService) {
+	s.collections = collections
+}
+
+// SetReservedSlugs sets the words that custom slugs may not use.
+func (s *PasteService) SetReservedSlugs(reserved *utils.ReservedSlugs) {
+	s.reserved = reserved
+}
+
+// SetHotTracker makes every read counted with IncrementReadCount also
+// recorded by hot, replicas included.
+func (s *PasteService) SetHotTracker(hot *hotkeys.Tracker) {
+	s.hot = hot
+}
+
+// SetPushNotifier makes burning a paste notify the uploader's subscribed
+// browsers.
+func (s *PasteService) SetPushNotifier(n *push.Notifier) {
+	s.push = n
+}
+
+// SetBurnNotifier makes burning a paste notify the webhook or email
+// address it was uploaded with.
+func (s *PasteService) SetBurnNotifier(n *burnnotify.Notifier) {
+	s.burnNotify = n
+}
+
+// SetTenantUsage makes new pastes count against the storage quota of
+// their tenant.
+func (s *PasteService) SetTenantUsage(t *tenancy.Tracker) {
+	s.tenants = t
+}
+
 // CreatePasteRequest represents a request to create a paste
 type CreatePasteRequest struct {
 	Content       []byte
@@ -32,14 +160,67 @@ type CreatePasteRequest struct {
 	CustomSlug    string
 	BurnAfterRead bool
 	TTL           time.Duration
+	Tags          []string
+	Visibility    models.Visibility
+	// Owner is the audit key ID of the uploading API key; private pastes
+	// are only readable with it.
+	Owner string
+	// Tenant is the tenant of the uploading API key, whose quota the
+	// paste counts against.
+	Tenant string
+	// Collection is the id of a collection to add the paste to. Admin
+	// reports whether the uploading key has the admin scope, which may add
+	// to any collection.
+	Collection string
+	Admin      bool
+	// Appendable creates a live paste; see AppendContent.
+	Appendable bool
+	// Uploaded marks content the client already stored under CustomSlug
+	// through a presigned URL, instead of sending it as Content. It must
+	// be UploadedSize bytes of ContentType.
+	Uploaded     bool
+	UploadedSize int64
+	// NotifyOnBurn is a target parsed by burnnotify.ParseTarget, told
+	// when the burn-after-read paste is read.
+	NotifyOnBurn string
+	// Origin records the service an imported paste came from.
+	Origin *models.PasteOrigin
+	// Source is parsed by models.ParseSource.
+	Source map[string]string
+	// FileMtime is parsed by models.ParseFileMtime.
+	FileMtime *time.Time
+	// Verify reads the paste back from the store before CreatePaste
+	// returns, so a returned paste is known to be durable; see
+	// verifyStored.
+	Verify bool
 }
 
 // CreatePasteResponse represents the response from creating a paste
 type CreatePasteResponse struct {
-	Slug string
-	URL  string
+	Slug      string
+	URL       string
+	CreatedAt time.Time
+	// BurnToken is set for burn-after-read pastes; see models.Paste.
+	BurnToken string
+	// ExpiresAt, Size and ContentType are as stored: the TTL after
+	// MinRetention, and the content after transcoding to UTF-8.
+	ExpiresAt     *time.Time
+	Size          int64
+	ContentType   string
+	BurnAfterRead bool
+	// Verification is the outcome of Verify, empty when it was not asked.
+	Verification string
 }
 
+// Outcomes of CreatePasteRequest.Verify.
+const (
+	// VerificationVerified means the paste was read back from the store.
+	VerificationVerified = "verified"
+	// VerificationSpooled means the paste was read back from the local
+	// write-behind spool, and is not on the backend yet.
+	VerificationSpooled = "spooled"
+)
+
 // GenerateSlug generates a unique slug for a paste
 func (s *PasteService) GenerateSlug() (string, error) {
 	batchSize := 5
@@ -51,6 +232,11 @@ func (s *PasteService) GenerateSlug() (string, error) {
 			return "", fmt.Errorf("failed to generate slug batch: %w", err)
 		}
 		for _, candidate := range candidates {
+			// A slug index answers most candidates without a backend
+			// request; only possible collisions are checked.
+			if !storage.MayExist(s.store, candidate) {
+				return candidate, nil
+			}
 			exists, err := s.store.Exists(candidate)
 			if err != nil {
 				continue // skip on error
@@ -62,6 +248,7 @@ func (s *PasteService) GenerateSlug() (string, error) {
 			// exists, check if expired
 			existing, err := s.store.Get(candidate)
 			if err != nil || existing == nil || existing.IsExpired() {
+				s.deletePreview(candidate)
 				slug = candidate
 				return slug, nil
 			}
@@ -70,11 +257,47 @@ func (s *PasteService) GenerateSlug() (string, error) {
 	return "", fmt.Errorf("failed to generate unique slug after 3 batches")
 }
 
+// AssignTenant makes the objects of the paste stored under slug go to the
+// storage prefix of tenant. CreatePaste does it for the pastes it stores;
+// presigned uploads, whose content is stored before CreatePaste runs, need
+// it before their URL is signed.
+func (s *PasteService) AssignTenant(slug, tenant string) error {
+	if err := storage.AssignTenant(s.store, slug, tenant); err != nil {
+		return fmt.Errorf("failed to assign tenant: %w", err)
+	}
+	return nil
+}
+
+// SlugTaken reports whether slug cannot be used for a new paste: it is
+// reserved, or a paste that has not expired holds it. It reads metadata
+// only, so it never counts a read or burns a paste; like any read, it lets
+// the store clean up an expired paste.
+func (s *PasteService) SlugTaken(slug string) (bool, error) {
+	if s.reserved.IsReserved(slug) {
+		return true, nil
+	}
+	exists, err := s.store.Exists(slug)
+	if err != nil || !exists {
+		return false, err
+	}
+	existing, err := s.store.Get(slug)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return existing != nil && !existing.IsExpired(), nil
+}
+
 // ValidateCustomSlug validates and checks if a custom slug is available
 func (s *PasteService) ValidateCustomSlug(slug string) error {
 	if !utils.IsValidSlug(slug) {
 		return fmt.Errorf("invalid slug format")
 	}
+	if s.reserved.IsReserved(slug) {
+		return fmt.Errorf("slug is reserved")
+	}
 
 	exists, err := s.store.Exists(slug)
 	if err != nil {
@@ -88,6 +311,7 @@ func (s *PasteService) ValidateCustomSlug(slug string) error {
 		if existing != nil && !existing.IsExpired() {
 			return fmt.Errorf("slug already exists")
 		}
+		s.deletePreview(slug)
 	}
 	return nil
 }
@@ -97,6 +321,19 @@ func (s *PasteService) CreatePaste(req CreatePasteRequest) (*CreatePasteResponse
 	var slug string
 	var err error
 
+	if req.Appendable && req.BurnAfterRead {
+		return nil, ErrBurnAppendable
+	}
+	actor := Actor{Owner: req.Owner, Admin: req.Admin}
+	if req.Collection != "" {
+		if s.collections == nil {
+			return nil, ErrCollectionsDisabled
+		}
+		if err := s.collections.CheckAttach(req.Collection, actor); err != nil {
+			return nil, err
+		}
+	}
+
 	if req.CustomSlug != "" {
 		if err := s.ValidateCustomSlug(req.CustomSlug); err != nil {
 			return nil, err
@@ -109,38 +346,196 @@ func (s *PasteService) CreatePaste(req CreatePasteRequest) (*CreatePasteResponse
 		}
 	}
 
-	expiresAt := time.Now().Add(req.TTL)
+	if s.config != nil && req.TTL < s.config.MinRetention {
+		req.TTL = s.config.MinRetention
+	}
+	if err := s.AssignTenant(slug, req.Tenant); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	expiresAt := now.Add(req.TTL)
 
-	contentType := req.ContentType
-	if contentType == "" {
-		contentType = utils.DetectContentType(req.Filename, req.Content)
+	var content []byte
+	var contentType, encoding string
+	size := req.UploadedSize
+	if req.Uploaded {
+		if contentType, err = s.checkUpload(slug, req); err != nil {
+			return nil, err
+		}
+	} else {
+		contentType = req.ContentType
+		if contentType == "" {
+			contentType = utils.DetectContentType(req.Filename, req.Content)
+		}
+		// Text from Windows tools often arrives as UTF-16 or Latin-1; it is
+		// stored as UTF-8 so every reader renders it.
+		content, contentType, encoding = utils.ToUTF8(req.Content, contentType)
+		size = int64(len(content))
+	}
+	kept := false
+	if s.tenants != nil {
+		if err := s.tenants.Reserve(req.Tenant, size); err != nil {
+			return nil, err
+		}
+		// A paste that is not kept does not count against the quota.
+		defer func() {
+			if !kept {
+				s.tenants.Release(req.Tenant, size)
+			}
+		}()
 	}
 	paste := &models.Paste{
+		SchemaVersion: models.MetadataSchema,
 		ID:            slug,
-		CreatedAt:     time.Now(),
+		CreatedAt:     now,
 		ExpiresAt:     &expiresAt,
-		Size:          int64(len(req.Content)),
+		Size:          size,
 		ContentType:   contentType,
+		Encoding:      encoding,
 		BurnAfterRead: req.BurnAfterRead,
 		ReadCount:     0,
+		Tags:          req.Tags,
+		Visibility:    req.Visibility,
+		Owner:         req.Owner,
+		Tenant:        req.Tenant,
+		Filename:      utils.SanitizeFilename(req.Filename),
+		Appendable:    req.Appendable,
+		Origin:        req.Origin,
+		Source:        req.Source,
+		FileMtime:     req.FileMtime,
+	}
+	if req.BurnAfterRead {
+		if paste.BurnToken, err = newBurnToken(); err != nil {
+			return nil, err
+		}
+		paste.NotifyOnBurn = req.NotifyOnBurn
 	}
 
-	if err := s.store.StoreContent(slug, req.Content); err != nil {
-		return nil, fmt.Errorf("failed to store content: %w", err)
+	if !req.Uploaded {
+		// The content of presigned uploads never passes through here, so
+		// they are not hashed.
+		paste.SHA256 = s.checksum(content)
+		if err := s.store.StoreContent(slug, content); err != nil {
+			return nil, fmt.Errorf("failed to store content: %w", err)
+		}
 	}
 	if err := s.store.Store(paste); err != nil {
 		return nil, fmt.Errorf("failed to store metadata: %w", err)
 	}
+	s.recent.add(slug)
+
+	if req.Collection != "" {
+		if _, err := s.collections.Attach(req.Collection, actor, slug); err != nil {
+			// Do not leave behind a paste the uploader asked to file away.
+			if derr := s.DeletePaste(slug); derr != nil {
+				log.Printf("[ERROR] CreatePaste: failed to remove %s after it could not join collection %s: %v", slug, req.Collection, derr)
+			}
+			return nil, err
+		}
+	}
+	verification := ""
+	if req.Verify {
+		if verification, err = s.verifyStored(paste, content, req.Uploaded); err != nil {
+			log.Printf("[ERROR] CreatePaste: verification of %s failed: %v", slug, err)
+			// A paste that cannot be read back is not handed out.
+			if derr := s.DeletePaste(slug); derr != nil {
+				log.Printf("[ERROR] CreatePaste: failed to remove %s after its verification failed: %v", slug, derr)
+			}
+			return nil, fmt.Errorf("%w: %v", ErrVerifyFailed, err)
+		}
+	}
 
+	kept = true
 	return &CreatePasteResponse{
-		Slug: slug,
-		URL:  "", // Will be set by handler based on request context
+		Slug:          slug,
+		URL:           "", // Will be set by handler based on request context
+		CreatedAt:     paste.CreatedAt,
+		BurnToken:     paste.BurnToken,
+		ExpiresAt:     paste.ExpiresAt,
+		Size:          paste.Size,
+		ContentType:   paste.ContentType,
+		BurnAfterRead: paste.BurnAfterRead,
+		Verification:  verification,
 	}, nil
 }
 
+// verifyStored reads paste back from the store and checks that its
+// metadata and content are as they were written, returning the
+// verification outcome. The content of presigned uploads, which may be
+// large and was never held here, is only checked for its size, as a HEAD
+// request would.
+func (s *PasteService) verifyStored(paste *models.Paste, content []byte, uploaded bool) (string, error) {
+	stored, err := s.store.Get(paste.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to read metadata: %w", err)
+	}
+	if stored.ID != paste.ID || stored.Size != paste.Size || stored.BurnAfterRead != paste.BurnAfterRead {
+		return "", errors.New("metadata differs from what was written")
+	}
+	if uploaded {
+		exists, size, err := s.store.StatContent(paste.ID)
+		if err != nil {
+			return "", fmt.Errorf("failed to stat content: %w", err)
+		}
+		if !exists || size != paste.Size {
+			return "", fmt.Errorf("stored content is %d bytes, want %d", size, paste.Size)
+		}
+	} else {
+		data, err := s.store.GetContent(paste.ID)
+		if err != nil {
+			return "", fmt.Errorf("failed to read content: %w", err)
+		}
+		if utils.Checksum(data) != utils.Checksum(content) {
+			return "", errors.New("content checksum differs from what was written")
+		}
+	}
+	if spool, ok := storage.Find[*storage.SpoolStore](s.store); ok && spool.Spooled(paste.ID) {
+		return VerificationSpooled, nil
+	}
+	return VerificationVerified, nil
+}
+
+// checkUpload verifies that the content of a presigned upload to slug was
+// stored with the declared size, and returns its content type. Content
+// declared as text must look like text, so the binary upload guard cannot
+// be sidestepped by declaring a wrong type.
+func (s *PasteService) checkUpload(slug string, req CreatePasteRequest) (string, error) {
+	exists, size, err := s.store.StatContent(slug)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat uploaded content: %w", err)
+	}
+	if !exists {
+		return "", ErrUploadMissing
+	}
+	if size != req.UploadedSize {
+		return "", fmt.Errorf("%w: stored %d bytes, declared %d", ErrUploadMismatch, size, req.UploadedSize)
+	}
+	prefix, err := s.store.GetContentPrefix(slug, 512)
+	if err != nil {
+		return "", fmt.Errorf("failed to read uploaded content: %w", err)
+	}
+	if req.ContentType == "" {
+		return utils.DetectContentType(req.Filename, prefix), nil
+	}
+	if detected := utils.DetectContentType("", prefix); utils.IsTextContent(req.ContentType) && !utils.IsTextContent(detected) {
+		return "", fmt.Errorf("%w: declared %s, content looks like %s", ErrUploadMismatch, req.ContentType, detected)
+	}
+	return req.ContentType, nil
+}
+
+// newBurnToken returns a random token for a burn-after-read link, short
+// and URL-safe enough to sit in a fragment.
+func newBurnToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate burn token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
 // GetPaste retrieves a paste by slug
 func (s *PasteService) GetPaste(slug string) (*models.Paste, error) {
-	paste, err := s.store.Get(slug)
+	paste, err := s.getMetadata(slug)
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve paste: %w", err)
 	}
@@ -148,6 +543,9 @@ func (s *PasteService) GetPaste(slug string) (*models.Paste, error) {
 		return nil, fmt.Errorf("paste not found")
 	}
 	if paste.IsExpired() {
+		if s.isReplica() {
+			return nil, fmt.Errorf("paste expired")
+		}
 		// Log a warning that an expired paste was requested and attempt deletion.
 		// We keep returning not-found semantics (handlers will map to 404) but
 		// emit a WARN so the event is visible in logs.
@@ -156,10 +554,109 @@ func (s *PasteService) GetPaste(slug string) (*models.Paste, error) {
 		}
 		return nil, fmt.Errorf("paste expired")
 	}
+	if paste.BurnClaim != nil && paste.BurnClaim.Expired() && !paste.LegalHold {
+		// The claimant's transfer did not complete in time; it may well
+		// have been read, so the paste is burned now, unless it was put
+		// under legal hold while claimed.
+		if !s.isReplica() {
+			if err := s.DeletePaste(slug); err != nil {
+				log.Printf("[WARN] GetPaste: failed to delete burned paste %s: %v", slug, err)
+			} else {
+				// Whether the claimant got the content is unknown.
+				s.burned(paste, burnnotify.Reader{})
+			}
+		}
+		return nil, fmt.Errorf("paste burned")
+	}
 
 	return paste, nil
 }
 
+// ClaimBurn claims a burn-after-read paste for claimant before its content
+// is sent; CompleteBurn deletes it once the transfer succeeded. While the
+// claim lasts, the same claimant may retry a failed transfer, up to
+// models.MaxBurnAttempts in all. Other clients, and readers of a paste
+// burned in the meantime, get ErrBurnClaimed.
+func (s *PasteService) ClaimBurn(slug, claimant string) error {
+	s.burnMu.Lock()
+	defer s.burnMu.Unlock()
+	paste, err := s.GetPaste(slug)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBurnClaimed, err)
+	}
+	if claim := paste.BurnClaim; claim != nil {
+		if claim.Claimant != claimant || claim.Attempts >= models.MaxBurnAttempts {
+			return ErrBurnClaimed
+		}
+		claim.Attempts++
+	} else {
+		paste.BurnClaim = &models.BurnClaim{Claimant: claimant, ClaimedAt: time.Now().UTC(), Attempts: 1}
+	}
+	if err := s.store.Store(paste); err != nil {
+		return fmt.Errorf("failed to claim burn-after-read paste: %w", err)
+	}
+	return nil
+}
+
+// CompleteBurn deletes a paste claimed with ClaimBurn once its content
+// reached reader. paste was read before the transfer, so the metadata is
+// read again: a paste put under legal hold in the meantime is kept and
+// ErrLegalHold returned.
+func (s *PasteService) CompleteBurn(paste *models.Paste, reader burnnotify.Reader) error {
+	current, err := s.store.Get(paste.ID)
+	if err != nil {
+		return err
+	}
+	if current.LegalHold {
+		return ErrLegalHold
+	}
+	if err := s.DeletePaste(paste.ID); err != nil {
+		return err
+	}
+	s.burned(paste, reader)
+	return nil
+}
+
+// burned notifies the uploader that paste was read by reader and burned.
+func (s *PasteService) burned(paste *models.Paste, reader burnnotify.Reader) {
+	if s.push != nil {
+		s.push.Burned(paste.ID)
+	}
+	if s.burnNotify != nil {
+		s.burnNotify.Burned(paste, reader)
+	}
+}
+
+// getMetadata loads the metadata for slug. A paste this process created
+// within the last few seconds that is not found yet is retried with
+// exponential backoff, since the backend may still be propagating it.
+func (s *PasteService) getMetadata(slug string) (*models.Paste, error) {
+	paste, err := s.store.Get(slug)
+	if s.config == nil || !isMissing(paste, err) || !s.recent.has(slug) {
+		return paste, err
+	}
+	backoff := s.config.ReadRetryBackoff
+	for attempt := 1; attempt <= s.config.ReadRetryAttempts; attempt++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		paste, err = s.store.Get(slug)
+		if !isMissing(paste, err) {
+			if utils.IsDebugEnabled() {
+				log.Printf("[DEBUG] GetPaste: %s became visible after %d retries", slug, attempt)
+			}
+			break
+		}
+	}
+	return paste, err
+}
+
+func isMissing(paste *models.Paste, err error) bool {
+	if err != nil {
+		return errors.Is(err, storage.ErrNotFound)
+	}
+	return paste == nil
+}
+
 // GetPasteContent retrieves paste content
 func (s *PasteService) GetPasteContent(slug string) ([]byte, error) {
 	content, err := s.store.GetContent(slug)
@@ -169,12 +666,421 @@ func (s *PasteService) GetPasteContent(slug string) ([]byte, error) {
 	return content, nil
 }
 
-// IncrementReadCount increments the read count for a paste
-func (s *PasteService) IncrementReadCount(slug string) error {
-	return s.store.IncrementReadCount(slug)
+// ReadPaste performs a single full read of a paste for non-HTTP transports:
+// it loads the metadata and content, increments the read count and, for
+// burn-after-read pastes, deletes the paste before returning the content so
+// subsequent reads fail. Pastes under legal hold are never burned. reader
+// describes the client for burn notifications.
+func (s *PasteService) ReadPaste(slug string, reader burnnotify.Reader) (*models.Paste, []byte, error) {
+	paste, err := s.GetPaste(slug)
+	if err != nil {
+		return nil, nil, err
+	}
+	if paste.Corrupt {
+		return nil, nil, ErrContentCorrupt
+	}
+	if paste.BurnAfterRead && s.isReplica() {
+		return nil, nil, ErrWriterOnly
+	}
+	if err := s.IncrementReadCount(slug, models.ReadRaw); err != nil {
+		log.Printf("[WARN] ReadPaste: failed to increment read count for %s: %v", slug, err)
+	}
+	content, err := s.GetPasteContent(slug)
+	if err != nil {
+		return nil, nil, err
+	}
+	if paste.BurnAfterRead && !paste.LegalHold {
+		if err := s.store.Delete(slug); err != nil {
+			return nil, nil, fmt.Errorf("failed to delete burn-after-read paste: %w", err)
+		}
+		s.recent.forget(slug)
+		s.burned(paste, reader)
+	}
+	return paste, content, nil
+}
+
+// IncrementReadCount increments the read count for a paste and its counter
+// for kind. Replicas never write to the shared backend, so their reads are
+// not counted there; the hot slug tracker still sees them.
+func (s *PasteService) IncrementReadCount(slug string, kind models.ReadKind) error {
+	if s.hot != nil {
+		s.hot.Observe(slug, string(kind))
+	}
+	if s.isReplica() {
+		return nil
+	}
+	return storage.IncrementReads(s.store, slug, kind)
+}
+
+// isReplica reports whether the service runs on a read-only replica.
+func (s *PasteService) isReplica() bool {
+	return s.config != nil && s.config.IsReplica()
 }
 
 // DeletePaste deletes a paste
 func (s *PasteService) DeletePaste(slug string) error {
-	return s.store.Delete(slug)
+	// Later lookups of a deleted paste should 404 at once, not retry.
+	s.recent.forget(slug)
+	if err := s.store.Delete(slug); err != nil {
+		return err
+	}
+	s.deleteTokens(slug)
+	return nil
+}
+
+// deleteTokens removes the share tokens of a deleted paste (best-effort).
+// Tokens left behind never read a later paste under the same slug, and
+// are removed when next used.
+func (s *PasteService) deleteTokens(slug string) {
+	ts, ok := s.store.(storage.TokenStore)
+	if !ok {
+		return
+	}
+	tokens, err := ts.ListTokens(slug)
+	if err != nil {
+		log.Printf("[WARN] Failed to list share tokens of %s: %v", slug, err)
+		return
+	}
+	for _, t := range tokens {
+		if err := ts.DeleteToken(t); err != nil {
+			log.Printf("[WARN] Failed to delete share token of %s: %v", slug, err)
+		}
+	}
+}
+
+// UpdatePasteRequest lists the settings to change on an existing paste.
+// Nil fields are left as they are.
+type UpdatePasteRequest struct {
+	// TTL replaces the expiry with now+TTL. It must be within
+	// config.MinTTL..config.MaxTTL and is raised to MinRetention.
+	TTL           *time.Duration
+	BurnAfterRead *bool
+	Visibility    *models.Visibility
+}
+
+// UpdatePaste applies req to the paste and returns its new metadata.
+// Turning burn-after-read on gives the paste a new BurnToken; it is
+// refused for appendable pastes and pastes under legal hold. It holds
+// ContentLock so the metadata it writes back is not older than a
+// concurrent replacement's.
+func (s *PasteService) UpdatePaste(slug string, req UpdatePasteRequest) (*models.Paste, error) {
+	s.versionMu.Lock()
+	defer s.versionMu.Unlock()
+	paste, err := s.GetPaste(slug)
+	if err != nil {
+		return nil, err
+	}
+	if req.TTL != nil {
+		ttl := *req.TTL
+		if ttl < config.MinTTL || ttl > config.MaxTTL {
+			return nil, fmt.Errorf("TTL must be between 1h and 7d")
+		}
+		if s.config != nil && ttl < s.config.MinRetention {
+			ttl = s.config.MinRetention
+		}
+		expiresAt := time.Now().UTC().Add(ttl)
+		paste.ExpiresAt = &expiresAt
+	}
+	if req.BurnAfterRead != nil && *req.BurnAfterRead != paste.BurnAfterRead {
+		if *req.BurnAfterRead {
+			switch {
+			case paste.Appendable:
+				return nil, ErrBurnAppendable
+			case paste.LegalHold:
+				return nil, ErrLegalHold
+			}
+			if paste.BurnToken, err = newBurnToken(); err != nil {
+				return nil, err
+			}
+		} else {
+			paste.BurnToken, paste.BurnClaim = "", nil
+		}
+		paste.BurnAfterRead = *req.BurnAfterRead
+	}
+	if req.Visibility != nil {
+		if *req.Visibility == models.VisibilityPrivate && paste.Owner == "" {
+			return nil, ErrNoOwner
+		}
+		paste.Visibility = *req.Visibility
+	}
+	if err := s.store.Store(paste); err != nil {
+		return nil, fmt.Errorf("failed to update paste: %w", err)
+	}
+	return paste, nil
+}
+
+// ReplaceContent replaces the content of slug, keeping the previous
+// content as a version when config.MaxVersions allows. The oldest versions
+// beyond MaxVersions are deleted. Pastes under legal hold and
+// burn-after-read pastes cannot be replaced.
+func (s *PasteService) ReplaceContent(slug string, content []byte, contentType string) (*models.Paste, error) {
+	s.versionMu.Lock()
+	defer s.versionMu.Unlock()
+	paste, err := s.GetPaste(slug)
+	if err != nil {
+		return nil, err
+	}
+	if paste.LegalHold {
+		return nil, ErrLegalHold
+	}
+	if paste.BurnAfterRead {
+		return nil, ErrBurnReplace
+	}
+	keep := 0
+	if s.config != nil {
+		keep = s.config.MaxVersions
+	}
+	current := paste.CurrentVersion()
+	if keep > 0 {
+		previous, err := s.store.GetContent(slug)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read current content: %w", err)
+		}
+		if err := s.store.StoreContent(storage.VersionID(slug, current), previous); err != nil {
+			return nil, fmt.Errorf("failed to store version %d: %w", current, err)
+		}
+		paste.Versions = append(paste.Versions, models.PasteVersion{
+			Number:      current,
+			Size:        paste.Size,
+			ContentType: paste.ContentType,
+			Encoding:    paste.Encoding,
+			CreatedAt:   paste.ContentCreatedAt(),
+		})
+	}
+	var pruned []models.PasteVersion
+	if n := len(paste.Versions) - keep; n > 0 {
+		pruned = paste.Versions[:n]
+		paste.Versions = append([]models.PasteVersion(nil), paste.Versions[n:]...)
+	}
+
+	if contentType == "" {
+		contentType = utils.DetectContentType(paste.Filename, content)
+	}
+	content, contentType, encoding := utils.ToUTF8(content, contentType)
+	if err := s.store.StoreContent(slug, content); err != nil {
+		return nil, fmt.Errorf("failed to store content: %w", err)
+	}
+	now := time.Now().UTC()
+	paste.Version = current + 1
+	paste.UpdatedAt = &now
+	paste.FileMtime = nil
+	paste.Size = int64(len(content))
+	paste.ContentType = contentType
+	paste.Encoding = encoding
+	paste.SHA256 = s.checksum(content)
+	if err := s.store.Store(paste); err != nil {
+		return nil, fmt.Errorf("failed to store metadata: %w", err)
+	}
+	for _, v := range pruned {
+		if err := s.store.Delete(storage.VersionID(slug, v.Number)); err != nil {
+			log.Printf("[WARN] Failed to delete version %d of %s: %v", v.Number, slug, err)
+		}
+	}
+	// The cached preview shows the old content.
+	s.deletePreview(slug)
+	return paste, nil
+}
+
+// ContentLock returns the lock ReplaceContent and AppendContent hold while
+// they rewrite a paste's content. Other writers of existing content or
+// metadata, such as the re-encryption job and UpdatePaste, hold it too, so
+// they never write back what they read before a replacement.
+func (s *PasteService) ContentLock() sync.Locker {
+	return &s.versionMu
+}
+
+// AppendContent appends chunk to the content of an appendable paste, as
+// long as the result stays within limit bytes. The chunk is stored as
+// sent, without transcoding. With final set, the paste stops being
+// appendable, which ends ?follow reads once they have sent everything.
+// Earlier content is not kept as a version.
+func (s *PasteService) AppendContent(slug string, chunk []byte, limit int64, final bool) (*models.Paste, error) {
+	s.versionMu.Lock()
+	defer s.versionMu.Unlock()
+	paste, err := s.GetPaste(slug)
+	if err != nil {
+		return nil, err
+	}
+	if !paste.Appendable {
+		return nil, ErrNotAppendable
+	}
+	if paste.LegalHold {
+		return nil, ErrLegalHold
+	}
+	if paste.Size+int64(len(chunk)) > limit {
+		return nil, fmt.Errorf("%w: appending %d bytes to %d exceeds limit of %d bytes", ErrAppendTooLarge, len(chunk), paste.Size, limit)
+	}
+	if len(chunk) > 0 {
+		content, err := s.store.GetContent(slug)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read current content: %w", err)
+		}
+		content = append(content, chunk...)
+		if err := s.store.StoreContent(slug, content); err != nil {
+			return nil, fmt.Errorf("failed to store content: %w", err)
+		}
+		now := time.Now().UTC()
+		paste.UpdatedAt = &now
+		paste.FileMtime = nil
+		paste.Size = int64(len(content))
+		paste.SHA256 = s.checksum(content)
+	}
+	paste.Appendable = !final
+	if err := s.store.Store(paste); err != nil {
+		return nil, fmt.Errorf("failed to store metadata: %w", err)
+	}
+	// The cached preview shows the old content.
+	if len(chunk) > 0 {
+		s.deletePreview(slug)
+	}
+	return paste, nil
+}
+
+// GetVersion returns the metadata and content of version n of paste, which
+// may be its current version.
+func (s *PasteService) GetVersion(paste *models.Paste, n int) (*models.PasteVersion, []byte, error) {
+	if n == paste.CurrentVersion() {
+		content, err := s.GetPasteContent(paste.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &models.PasteVersion{Number: n, Size: paste.Size, ContentType: paste.ContentType, Encoding: paste.Encoding, CreatedAt: paste.ContentCreatedAt()}, content, nil
+	}
+	v := paste.FindVersion(n)
+	if v == nil {
+		return nil, nil, ErrVersionNotFound
+	}
+	content, err := s.store.GetContent(storage.VersionID(paste.ID, n))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to retrieve version %d: %w", n, err)
+	}
+	return v, content, nil
+}
+
+// checksum returns the hex SHA-256 of content when config.ChecksumURLs
+// is set, and "" otherwise.
+func (s *PasteService) checksum(content []byte) string {
+	if s.config == nil || !s.config.ChecksumURLs {
+		return ""
+	}
+	return utils.Checksum(content)
+}
+
+// FindByChecksum returns a paste whose content has the hex SHA-256 sum,
+// and that content. The checksum index may hold pastes that changed since
+// they were indexed, and distinct slugs may share content, so every
+// candidate's metadata is re-checked and its content hashed again before
+// it is returned. Burn-after-read, private, appendable and corrupt pastes
+// are never returned.
+func (s *PasteService) FindByChecksum(sum string) (*models.Paste, []byte, error) {
+	if !utils.IsValidChecksum(sum) {
+		return nil, nil, ErrChecksumNotFound
+	}
+	lister, ok := s.store.(storage.Lister)
+	if !ok {
+		return nil, nil, fmt.Errorf("store does not support checksum lookups")
+	}
+	opts := storage.ListOptions{Checksum: sum}
+	for {
+		page, err := lister.List(opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list checksum %s: %w", sum, err)
+		}
+		for _, id := range page.IDs {
+			paste, err := s.GetPaste(id)
+			if err != nil || paste.SHA256 != sum || paste.BurnAfterRead || paste.IsPrivate() || paste.Appendable || paste.Corrupt || paste.Quarantined {
+				continue
+			}
+			content, err := s.GetPasteContent(id)
+			if err != nil {
+				log.Printf("[WARN] FindByChecksum: failed to read %s: %v", id, err)
+				continue
+			}
+			if utils.Checksum(content) != sum {
+				log.Printf("[WARN] FindByChecksum: content of %s does not match its recorded checksum", id)
+				continue
+			}
+			return paste, content, nil
+		}
+		if page.NextCursor == "" {
+			return nil, nil, ErrChecksumNotFound
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// RemovePaste deletes a paste on request of its uploader or an admin.
+// Unlike DeletePaste it refuses pastes under legal hold.
+func (s *PasteService) RemovePaste(slug string) error {
+	paste, err := s.GetPaste(slug)
+	if err != nil {
+		return err
+	}
+	if paste.LegalHold {
+		return ErrLegalHold
+	}
+	return s.DeletePaste(slug)
+}
+
+// RemovePastes deletes pastes in one batch, as RemovePaste would one by
+// one, and returns the errors of those it did not delete: ErrLegalHold
+// for pastes under legal hold, the store's error for the others.
+func (s *PasteService) RemovePastes(pastes []*models.Paste, progress storage.DeleteProgress) map[string]error {
+	errs := map[string]error{}
+	ids := make([]string, 0, len(pastes))
+	for _, p := range pastes {
+		if p.LegalHold {
+			errs[p.ID] = ErrLegalHold
+			continue
+		}
+		s.recent.forget(p.ID)
+		ids = append(ids, p.ID)
+	}
+	for id, err := range storage.DeleteBatch(s.store, ids, progress) {
+		errs[id] = err
+	}
+	for _, id := range ids {
+		if _, failed := errs[id]; !failed {
+			s.deleteTokens(id)
+		}
+	}
+	return errs
+}
+
+// deletePreview removes a cached preview image left behind for slug, so a
+// reused slug never serves a stale preview. Stores delete the preview along
+// with the paste; this covers pastes that expired without being deleted.
+func (s *PasteService) deletePreview(slug string) {
+	id := preview.CacheID(slug)
+	if exists, _, err := s.store.StatContent(id); err == nil && exists {
+		if err := s.store.Delete(id); err != nil {
+			log.Printf("[WARN] Failed to delete preview for %s: %v", slug, err)
+		}
+	}
+}
+
+// ClaimUploadLink marks the upload link id as used, returning
+// ErrUploadLinkUsed if it already was. Call it before creating the paste so
+// concurrent uploads with the same link cannot both succeed. The marker is
+// created with a conditional write, so this holds across instances on
+// backends that support one.
+func (s *PasteService) ClaimUploadLink(id string) error {
+	s.linkMu.Lock()
+	defer s.linkMu.Unlock()
+	created, err := storage.CreateContent(s.store, uploadlink.MarkerID(id), []byte(time.Now().UTC().Format(time.RFC3339)))
+	if err != nil {
+		return fmt.Errorf("failed to claim upload link: %w", err)
+	}
+	if !created {
+		return ErrUploadLinkUsed
+	}
+	return nil
+}
+
+// ReleaseUploadLink undoes ClaimUploadLink after the upload failed, so the
+// link can be retried.
+func (s *PasteService) ReleaseUploadLink(id string) {
+	if err := s.store.Delete(uploadlink.MarkerID(id)); err != nil {
+		log.Printf("[WARN] Failed to release upload link %s: %v", id, err)
+	}
 }
@@ -1,12 +1,22 @@
 package services
 
 import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/internal/burnnotify"
+	"github.com/johnwmail/nclip/internal/hotkeys"
+	"github.com/johnwmail/nclip/internal/push"
+	"github.com/johnwmail/nclip/internal/tenancy"
 	"github.com/johnwmail/nclip/models"
 	"github.com/johnwmail/nclip/storage"
 )
@@ -127,3 +137,568 @@ func TestDeleteBurnAfterRead(t *testing.T) {
 		t.Fatalf("expected metadata removed after delete, still exists")
 	}
 }
+
+// laggingStore hides metadata for the first few Get calls, like an
+// eventually consistent backend right after a write.
+type laggingStore struct {
+	storage.PasteStore
+	misses int
+	gets   int
+}
+
+func (s *laggingStore) Get(id string) (*models.Paste, error) {
+	s.gets++
+	if s.gets <= s.misses {
+		return nil, storage.ErrNotFound
+	}
+	return s.PasteStore.Get(id)
+}
+
+func TestGetPasteRetriesRecentWrites(t *testing.T) {
+	fs, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	store := &laggingStore{PasteStore: fs}
+	cfg := config.Default()
+	cfg.ReadRetryBackoff = time.Millisecond
+	service := NewPasteService(store, cfg)
+
+	resp, err := service.CreatePaste(CreatePasteRequest{Content: []byte("hello"), TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+
+	// Found on the last allowed retry.
+	store.misses = cfg.ReadRetryAttempts
+	if _, err := service.GetPaste(resp.Slug); err != nil {
+		t.Fatalf("expected paste after retries, got %v", err)
+	}
+	if store.gets != cfg.ReadRetryAttempts+1 {
+		t.Errorf("expected %d lookups, got %d", cfg.ReadRetryAttempts+1, store.gets)
+	}
+
+	// Retries are bounded.
+	store.gets, store.misses = 0, cfg.ReadRetryAttempts+1
+	if _, err := service.GetPaste(resp.Slug); err == nil {
+		t.Fatal("expected not found once retries are exhausted")
+	}
+
+	// Pastes this process did not just create are not retried.
+	store.gets, store.misses = 0, 1
+	if _, err := service.GetPaste("NXSTS"); err == nil || store.gets != 1 {
+		t.Errorf("expected a single lookup for an unknown slug, got %d (err %v)", store.gets, err)
+	}
+
+	// Nor are deleted ones.
+	if err := service.DeletePaste(resp.Slug); err != nil {
+		t.Fatalf("DeletePaste: %v", err)
+	}
+	store.gets, store.misses = 0, 0
+	if _, err := service.GetPaste(resp.Slug); err == nil || store.gets != 1 {
+		t.Errorf("expected a single lookup after delete, got %d (err %v)", store.gets, err)
+	}
+}
+
+func TestLegalHoldAndMinRetention(t *testing.T) {
+	fs, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	cfg := config.Default()
+	cfg.MinRetention = 30 * 24 * time.Hour
+	service := NewPasteService(fs, cfg)
+
+	resp, err := service.CreatePaste(CreatePasteRequest{Content: []byte("record"), TTL: time.Hour, BurnAfterRead: true})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	paste, err := fs.Get(resp.Slug)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if time.Until(*paste.ExpiresAt) < cfg.MinRetention-time.Minute {
+		t.Errorf("expected TTL raised to the minimum retention, expires at %v", paste.ExpiresAt)
+	}
+
+	// A held burn-after-read paste is served but not burned.
+	paste.LegalHold = true
+	if err := fs.Store(paste); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if _, content, err := service.ReadPaste(resp.Slug, burnnotify.Reader{}); err != nil || string(content) != "record" {
+		t.Fatalf("ReadPaste: %q, %v", content, err)
+	}
+	if _, err := fs.Get(resp.Slug); err != nil {
+		t.Errorf("expected held paste to survive the read, got %v", err)
+	}
+}
+
+func TestUpdateAndRemovePaste(t *testing.T) {
+	fs, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	service := NewPasteService(fs, config.Default())
+
+	resp, err := service.CreatePaste(CreatePasteRequest{Content: []byte("notes"), TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	ttl, burn, public := 72*time.Hour, true, models.VisibilityPublic
+	paste, err := service.UpdatePaste(resp.Slug, UpdatePasteRequest{TTL: &ttl, BurnAfterRead: &burn, Visibility: &public})
+	if err != nil {
+		t.Fatalf("UpdatePaste: %v", err)
+	}
+	if time.Until(*paste.ExpiresAt) < ttl-time.Minute || !paste.BurnAfterRead || paste.Visibility != public {
+		t.Errorf("unexpected paste after update: %+v", paste)
+	}
+
+	private := models.VisibilityPrivate
+	if _, err := service.UpdatePaste(resp.Slug, UpdatePasteRequest{Visibility: &private}); !errors.Is(err, ErrNoOwner) {
+		t.Errorf("expected ErrNoOwner for a paste uploaded without a key, got %v", err)
+	}
+	tooLong := 8 * 24 * time.Hour
+	if _, err := service.UpdatePaste(resp.Slug, UpdatePasteRequest{TTL: &tooLong}); err == nil {
+		t.Error("expected a TTL over 7d to be rejected")
+	}
+
+	paste.LegalHold = true
+	if err := fs.Store(paste); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if err := service.RemovePaste(resp.Slug); !errors.Is(err, ErrLegalHold) {
+		t.Fatalf("expected ErrLegalHold, got %v", err)
+	}
+	paste.LegalHold = false
+	if err := fs.Store(paste); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if err := service.RemovePaste(resp.Slug); err != nil {
+		t.Fatalf("RemovePaste: %v", err)
+	}
+	if _, err := service.GetPaste(resp.Slug); err == nil {
+		t.Error("expected paste to be removed")
+	}
+}
+
+func TestUpdatePasteBurn(t *testing.T) {
+	fs, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	service := NewPasteService(fs, config.Default())
+	burn := true
+
+	resp, err := service.CreatePaste(CreatePasteRequest{Content: []byte("notes"), TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	paste, err := service.UpdatePaste(resp.Slug, UpdatePasteRequest{BurnAfterRead: &burn})
+	if err != nil {
+		t.Fatalf("UpdatePaste: %v", err)
+	}
+	if paste.BurnToken == "" {
+		t.Error("expected a paste turned burn-after-read to get a burn token")
+	}
+
+	live, err := service.CreatePaste(CreatePasteRequest{Content: []byte("log"), TTL: time.Hour, Appendable: true})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	if _, err := service.UpdatePaste(live.Slug, UpdatePasteRequest{BurnAfterRead: &burn}); !errors.Is(err, ErrBurnAppendable) {
+		t.Errorf("expected ErrBurnAppendable for an appendable paste, got %v", err)
+	}
+
+	held, err := service.CreatePaste(CreatePasteRequest{Content: []byte("evidence"), TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	paste, err = fs.Get(held.Slug)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	paste.LegalHold = true
+	if err := fs.Store(paste); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if _, err := service.UpdatePaste(held.Slug, UpdatePasteRequest{BurnAfterRead: &burn}); !errors.Is(err, ErrLegalHold) {
+		t.Errorf("expected ErrLegalHold for a held paste, got %v", err)
+	}
+}
+
+func TestClaimBurn(t *testing.T) {
+	fs, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	service := NewPasteService(fs, &config.Config{})
+	resp, err := service.CreatePaste(CreatePasteRequest{Content: []byte("secret"), BurnAfterRead: true, TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	slug := resp.Slug
+
+	if err := service.ClaimBurn(slug, "alice"); err != nil {
+		t.Fatalf("first claim: %v", err)
+	}
+	if err := service.ClaimBurn(slug, "bob"); !errors.Is(err, ErrBurnClaimed) {
+		t.Fatalf("claim by another client: got %v, want ErrBurnClaimed", err)
+	}
+	// The claimant may retry a dropped transfer once.
+	if err := service.ClaimBurn(slug, "alice"); err != nil {
+		t.Fatalf("retry by claimant: %v", err)
+	}
+	if err := service.ClaimBurn(slug, "alice"); !errors.Is(err, ErrBurnClaimed) {
+		t.Fatalf("second retry: got %v, want ErrBurnClaimed", err)
+	}
+
+	// A claim that times out burns the paste.
+	paste, err := service.GetPaste(slug)
+	if err != nil {
+		t.Fatalf("GetPaste while claimed: %v", err)
+	}
+	paste.BurnClaim.ClaimedAt = time.Now().Add(-models.BurnClaimTimeout - time.Second)
+	if err := fs.Store(paste); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if _, err := service.GetPaste(slug); err == nil {
+		t.Fatal("expected expired claim to burn the paste")
+	}
+	if p, _ := fs.Get(slug); p != nil {
+		t.Fatal("expected burned paste to be deleted")
+	}
+
+	// A completed transfer burns the paste at once.
+	resp, err = service.CreatePaste(CreatePasteRequest{Content: []byte("secret"), BurnAfterRead: true, TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	if err := service.ClaimBurn(resp.Slug, "alice"); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if err := service.CompleteBurn(&models.Paste{ID: resp.Slug}, burnnotify.Reader{}); err != nil {
+		t.Fatalf("CompleteBurn: %v", err)
+	}
+	if err := service.ClaimBurn(resp.Slug, "alice"); !errors.Is(err, ErrBurnClaimed) {
+		t.Fatalf("claim after burn: got %v, want ErrBurnClaimed", err)
+	}
+}
+
+func TestClaimBurn_LegalHold(t *testing.T) {
+	fs, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	service := NewPasteService(fs, &config.Config{})
+	resp, err := service.CreatePaste(CreatePasteRequest{Content: []byte("secret"), BurnAfterRead: true, TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	claimed, err := service.GetPaste(resp.Slug)
+	if err != nil {
+		t.Fatalf("GetPaste: %v", err)
+	}
+	if err := service.ClaimBurn(resp.Slug, "alice"); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+
+	// The paste is put under legal hold while the transfer runs.
+	held, err := fs.Get(resp.Slug)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	held.LegalHold = true
+	if err := fs.Store(held); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if err := service.CompleteBurn(claimed, burnnotify.Reader{}); !errors.Is(err, ErrLegalHold) {
+		t.Fatalf("CompleteBurn: got %v, want ErrLegalHold", err)
+	}
+
+	// Nor does the claim timing out burn it.
+	held.BurnClaim.ClaimedAt = time.Now().Add(-models.BurnClaimTimeout - time.Second)
+	if err := fs.Store(held); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if _, err := service.GetPaste(resp.Slug); err != nil {
+		t.Fatalf("GetPaste after the claim timed out: %v", err)
+	}
+	if p, _ := fs.Get(resp.Slug); p == nil {
+		t.Fatal("expected the held paste to be kept")
+	}
+}
+
+func TestBurnNotifiesPush(t *testing.T) {
+	fs, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	service := NewPasteService(fs, &config.Config{})
+	vapid, _ := ecdh.P256().GenerateKey(rand.Reader)
+	key, err := push.ParseKey(base64.RawURLEncoding.EncodeToString(vapid.Bytes()))
+	if err != nil {
+		t.Fatalf("ParseKey: %v", err)
+	}
+	notifier := push.NewNotifier(push.NewSender(key, "mailto:ops@example.com"), fs, filepath.Join(t.TempDir(), ".push.json"), time.Hour)
+	service.SetPushNotifier(notifier)
+
+	browser, _ := ecdh.P256().GenerateKey(rand.Reader)
+	var sub push.Subscription
+	sub.Endpoint = "https://push.example.com/1"
+	sub.Keys.P256dh = base64.RawURLEncoding.EncodeToString(browser.PublicKey().Bytes())
+	sub.Keys.Auth = base64.RawURLEncoding.EncodeToString(make([]byte, 16))
+
+	resp, err := service.CreatePaste(CreatePasteRequest{Content: []byte("secret"), BurnAfterRead: true, TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	paste, _ := service.GetPaste(resp.Slug)
+	if err := notifier.Subscribe(paste, sub, ""); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if _, _, err := service.ReadPaste(resp.Slug, burnnotify.Reader{}); err != nil {
+		t.Fatalf("ReadPaste: %v", err)
+	}
+	if n := notifier.Subscriptions(resp.Slug); n != 0 {
+		t.Errorf("expected the burn to notify and forget subscribers, %d left", n)
+	}
+}
+
+// recordingMailer records the emails it is asked to send.
+type recordingMailer struct {
+	to, subject, body []string
+}
+
+func (m *recordingMailer) Reply(to, subject, body string) error {
+	m.to = append(m.to, to)
+	m.subject = append(m.subject, subject)
+	m.body = append(m.body, body)
+	return nil
+}
+
+func TestBurnNotifiesTarget(t *testing.T) {
+	fs, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	service := NewPasteService(fs, &config.Config{})
+	mailer := &recordingMailer{}
+	service.SetBurnNotifier(burnnotify.NewNotifier(mailer))
+
+	resp, err := service.CreatePaste(CreatePasteRequest{Content: []byte("secret"), BurnAfterRead: true, TTL: time.Hour, NotifyOnBurn: "mailto:alice@example.com"})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	if _, _, err := service.ReadPaste(resp.Slug, burnnotify.NewReader("tcp", "203.0.113.9", "")); err != nil {
+		t.Fatalf("ReadPaste: %v", err)
+	}
+	if len(mailer.to) != 1 || mailer.to[0] != "alice@example.com" || !strings.Contains(mailer.body[0], "203.0.113.0/24") {
+		t.Fatalf("expected one email to the uploader naming the reader's network, got %v %q", mailer.to, mailer.body)
+	}
+
+	// Pastes that are not burn-after-read never keep a target.
+	resp, err = service.CreatePaste(CreatePasteRequest{Content: []byte("plain"), TTL: time.Hour, NotifyOnBurn: "mailto:alice@example.com"})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	if paste, _ := fs.Get(resp.Slug); paste.NotifyOnBurn != "" {
+		t.Errorf("expected no target on a regular paste, got %q", paste.NotifyOnBurn)
+	}
+}
+
+func TestReplaceContent(t *testing.T) {
+	fs, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	cfg := &config.Config{MaxVersions: 1}
+	service := NewPasteService(fs, cfg)
+	resp, err := service.CreatePaste(CreatePasteRequest{Content: []byte("one"), TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	slug := resp.Slug
+
+	if _, err := service.ReplaceContent(slug, []byte("two"), ""); err != nil {
+		t.Fatalf("ReplaceContent: %v", err)
+	}
+	paste, err := service.ReplaceContent(slug, []byte("three!"), "")
+	if err != nil {
+		t.Fatalf("ReplaceContent: %v", err)
+	}
+	if paste.Version != 3 || paste.Size != 6 || len(paste.Versions) != 1 || paste.Versions[0].Number != 2 {
+		t.Fatalf("unexpected metadata after two replacements: %+v", paste)
+	}
+	if _, content, err := service.GetVersion(paste, 2); err != nil || string(content) != "two" {
+		t.Errorf("GetVersion(2) = %q, %v", content, err)
+	}
+	if _, _, err := service.GetVersion(paste, 1); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("GetVersion(1): got %v, want ErrVersionNotFound", err)
+	}
+	if ok, _, _ := fs.StatContent(storage.VersionID(slug, 1)); ok {
+		t.Error("pruned version 1 was not deleted")
+	}
+
+	// With no versions kept, content is overwritten and old versions go.
+	cfg.MaxVersions = 0
+	if paste, err = service.ReplaceContent(slug, []byte("four"), ""); err != nil || len(paste.Versions) != 0 {
+		t.Fatalf("ReplaceContent without history: %+v, %v", paste, err)
+	}
+
+	burn, err := service.CreatePaste(CreatePasteRequest{Content: []byte("secret"), BurnAfterRead: true, TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	if _, err := service.ReplaceContent(burn.Slug, []byte("other"), ""); !errors.Is(err, ErrBurnReplace) {
+		t.Errorf("replacing a burn-after-read paste: got %v, want ErrBurnReplace", err)
+	}
+}
+
+func TestRemovePastes(t *testing.T) {
+	fs, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	service := NewPasteService(fs, &config.Config{})
+	var pastes []*models.Paste
+	for i := 0; i < 3; i++ {
+		resp, err := service.CreatePaste(CreatePasteRequest{Content: []byte("data"), TTL: time.Hour})
+		if err != nil {
+			t.Fatalf("CreatePaste: %v", err)
+		}
+		paste, err := service.GetPaste(resp.Slug)
+		if err != nil {
+			t.Fatalf("GetPaste: %v", err)
+		}
+		pastes = append(pastes, paste)
+	}
+	pastes[1].LegalHold = true
+
+	errs := service.RemovePastes(pastes, nil)
+	if len(errs) != 1 || !errors.Is(errs[pastes[1].ID], ErrLegalHold) {
+		t.Fatalf("RemovePastes errors = %v, want ErrLegalHold for %s only", errs, pastes[1].ID)
+	}
+	for i, p := range pastes {
+		_, err := service.GetPaste(p.ID)
+		if kept := err == nil; kept != (i == 1) {
+			t.Errorf("paste %d kept = %v", i, kept)
+		}
+	}
+}
+
+func TestIncrementReadCountTracksHotSlugs(t *testing.T) {
+	fs, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	// Replicas do not count reads in the backend, but the tracker sees them.
+	service := NewPasteService(fs, &config.Config{Role: config.RoleReplica})
+	hot := hotkeys.New(10)
+	service.SetHotTracker(hot)
+	for i := 0; i < 2; i++ {
+		if err := service.IncrementReadCount("HOTAB", models.ReadRaw); err != nil {
+			t.Fatalf("IncrementReadCount: %v", err)
+		}
+	}
+	if top := hot.Top(time.Minute, 1); len(top) != 1 || top[0].Slug != "HOTAB" || top[0].Reads != 2 {
+		t.Errorf("unexpected hot slugs: %+v", top)
+	}
+}
+
+// corruptingStore stores content with its last byte flipped, like a
+// backend that acknowledged a write it did not keep.
+type corruptingStore struct {
+	storage.PasteStore
+}
+
+func (s *corruptingStore) StoreContent(id string, content []byte) error {
+	bad := append([]byte(nil), content...)
+	bad[len(bad)-1] ^= 1
+	return s.PasteStore.StoreContent(id, bad)
+}
+
+func TestCreatePasteVerify(t *testing.T) {
+	fs, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	service := NewPasteService(fs, config.Default())
+	resp, err := service.CreatePaste(CreatePasteRequest{Content: []byte("durable"), TTL: time.Hour, Verify: true})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	if resp.Verification != VerificationVerified {
+		t.Errorf("Verification = %q, want %q", resp.Verification, VerificationVerified)
+	}
+	if resp, _ := service.CreatePaste(CreatePasteRequest{Content: []byte("unchecked"), TTL: time.Hour}); resp.Verification != "" {
+		t.Errorf("expected no verification unless asked, got %q", resp.Verification)
+	}
+
+	bad := NewPasteService(&corruptingStore{PasteStore: fs}, config.Default())
+	if _, err := bad.CreatePaste(CreatePasteRequest{Content: []byte("lost"), CustomSlug: "LXSTX", TTL: time.Hour, Verify: true}); !errors.Is(err, ErrVerifyFailed) {
+		t.Fatalf("expected ErrVerifyFailed, got %v", err)
+	}
+	if exists, _ := fs.Exists("LXSTX"); exists {
+		t.Error("expected the paste that failed verification to be removed")
+	}
+}
+
+// failingStore refuses every metadata write.
+type failingStore struct {
+	storage.PasteStore
+}
+
+func (s *failingStore) Store(*models.Paste) error {
+	return errors.New("disk full")
+}
+
+func TestCreatePasteReleasesQuota(t *testing.T) {
+	fs, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create filesystem store: %v", err)
+	}
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(keysFile, []byte("ci write tenant=eng\n@eng quota=10\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tenants, err := apikeys.LoadTenancy(keysFile)
+	if err != nil {
+		t.Fatalf("LoadTenancy: %v", err)
+	}
+	usage, err := tenancy.New(fs, tenants)
+	if err != nil {
+		t.Fatalf("tenancy.New: %v", err)
+	}
+
+	bad := NewPasteService(&failingStore{PasteStore: fs}, config.Default())
+	bad.SetTenantUsage(usage)
+	if _, err := bad.CreatePaste(CreatePasteRequest{Content: []byte("eight by"), Tenant: "eng", TTL: time.Hour}); err == nil {
+		t.Fatal("expected the failed metadata write to fail CreatePaste")
+	}
+	service := NewPasteService(fs, config.Default())
+	service.SetTenantUsage(usage)
+	if _, err := service.CreatePaste(CreatePasteRequest{Content: []byte("eight by"), Tenant: "eng", TTL: time.Hour}); err != nil {
+		t.Errorf("expected the failed upload not to count against the quota, got %v", err)
+	}
+}
+
+func TestCreatePasteTenantPrefix(t *testing.T) {
+	backend := storage.NewMemoryStore()
+	service := NewPasteService(storage.NewTenantStore(backend), config.Default())
+	resp, err := service.CreatePaste(CreatePasteRequest{Content: []byte("hello"), Tenant: "eng", TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	if _, err := backend.Get("eng~" + resp.Slug); err != nil {
+		t.Errorf("the paste is not stored under its tenant's prefix: %v", err)
+	}
+	if _, err := backend.Get(resp.Slug); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("the paste is stored under its bare slug: %v", err)
+	}
+	// Another instance reads it by slug.
+	other := NewPasteService(storage.NewTenantStore(backend), config.Default())
+	if _, content, err := other.ReadPaste(resp.Slug, burnnotify.Reader{}); err != nil || string(content) != "hello" {
+		t.Errorf("ReadPaste = %q, %v", content, err)
+	}
+}
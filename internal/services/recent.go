@@ -0,0 +1,50 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// recentWriteWindow is how long after creation a missing paste is assumed
+// to be still propagating through the backend rather than absent.
+const recentWriteWindow = 10 * time.Second
+
+// recentWrites remembers slugs this process created recently. It is safe
+// for concurrent use.
+type recentWrites struct {
+	mu    sync.Mutex
+	slugs map[string]time.Time
+}
+
+func newRecentWrites() *recentWrites {
+	return &recentWrites{slugs: make(map[string]time.Time)}
+}
+
+// add records slug as written now and forgets entries outside the window,
+// so the map only ever holds the last few seconds of uploads.
+func (r *recentWrites) add(slug string) {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for s, t := range r.slugs {
+		if now.Sub(t) > recentWriteWindow {
+			delete(r.slugs, s)
+		}
+	}
+	r.slugs[slug] = now
+}
+
+// has reports whether slug was written within the window.
+func (r *recentWrites) has(slug string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.slugs[slug]
+	return ok && time.Since(t) <= recentWriteWindow
+}
+
+// forget drops slug, e.g. once it has been deleted.
+func (r *recentWrites) forget(slug string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.slugs, slug)
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+// MaxTokenTTL bounds the lifetime of a share token.
+const MaxTokenTTL = 30 * 24 * time.Hour
+
+// Errors returned by TokenService.
+var (
+	ErrTokenNotFound  = errors.New("share token not found")
+	ErrTokenLimit     = fmt.Errorf("a paste has at most %d share tokens", models.MaxTokensPerPaste)
+	ErrTokenBurn      = errors.New("burn-after-read pastes cannot have share tokens")
+	ErrTokensDisabled = errors.New("the storage backend does not support share tokens")
+)
+
+// tokenEncoding encodes share tokens: lowercase base32 without padding.
+var tokenEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TokenService handles share tokens, which read a paste at /t/<token>
+// without revealing its slug.
+type TokenService struct {
+	store  storage.PasteStore
+	pastes *PasteService
+	// mu serializes token uses within this process, so concurrent reads
+	// cannot exceed a token's use limit.
+	mu sync.Mutex
+}
+
+// NewTokenService creates a share token service.
+func NewTokenService(store storage.PasteStore, pastes *PasteService) *TokenService {
+	return &TokenService{store: store, pastes: pastes}
+}
+
+func (s *TokenService) backend() (storage.TokenStore, error) {
+	ts, ok := s.store.(storage.TokenStore)
+	if !ok {
+		return nil, ErrTokensDisabled
+	}
+	return ts, nil
+}
+
+// CreateToken issues a token for paste allowing maxUses reads (0 is
+// unlimited) for ttl (0 lasts as long as the paste).
+func (s *TokenService) CreateToken(paste *models.Paste, owner string, maxUses int, ttl time.Duration) (*models.ShareToken, error) {
+	if paste.BurnAfterRead {
+		return nil, ErrTokenBurn
+	}
+	ts, err := s.backend()
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.ListTokens(paste.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= models.MaxTokensPerPaste {
+		return nil, ErrTokenLimit
+	}
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	t := &models.ShareToken{
+		Token:          strings.ToLower(tokenEncoding.EncodeToString(b)),
+		Slug:           paste.ID,
+		PasteCreatedAt: paste.CreatedAt,
+		CreatedAt:      now,
+		MaxUses:        maxUses,
+		Owner:          owner,
+	}
+	if ttl > 0 {
+		expires := now.Add(ttl)
+		t.ExpiresAt = &expires
+	}
+	if err := ts.StoreToken(t); err != nil {
+		return nil, fmt.Errorf("failed to store token: %w", err)
+	}
+	return t, nil
+}
+
+// ListTokens returns the live tokens of slug, deleting expired ones.
+func (s *TokenService) ListTokens(slug string) ([]*models.ShareToken, error) {
+	ts, err := s.backend()
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := ts.ListTokens(slug)
+	if err != nil {
+		return nil, err
+	}
+	live := tokens[:0]
+	for _, t := range tokens {
+		if t.IsExpired() {
+			s.delete(ts, t)
+			continue
+		}
+		live = append(live, t)
+	}
+	return live, nil
+}
+
+// RevokeToken deletes token, which must belong to slug.
+func (s *TokenService) RevokeToken(slug, token string) error {
+	ts, err := s.backend()
+	if err != nil {
+		return err
+	}
+	if !storage.ValidToken(token) {
+		return ErrTokenNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := ts.GetToken(token)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrTokenNotFound
+		}
+		return err
+	}
+	if t.Slug != slug {
+		return ErrTokenNotFound
+	}
+	return ts.DeleteToken(t)
+}
+
+// UseToken spends one use of token and returns it with the paste it
+// reads. Expired and used-up tokens, and tokens whose paste is gone, are
+// deleted and reported as ErrTokenNotFound. So are, without spending a
+// use, tokens of quarantined pastes and of pastes made burn-after-read
+// since the token was created. Tokens of corrupt pastes return
+// ErrContentCorrupt without spending a use.
+func (s *TokenService) UseToken(token string) (*models.ShareToken, *models.Paste, error) {
+	ts, err := s.backend()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !storage.ValidToken(token) {
+		return nil, nil, ErrTokenNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := ts.GetToken(token)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil, ErrTokenNotFound
+		}
+		return nil, nil, err
+	}
+	if t.IsExpired() || t.Exhausted() {
+		s.delete(ts, t)
+		return nil, nil, ErrTokenNotFound
+	}
+	paste, err := s.pastes.GetPaste(t.Slug)
+	if err != nil || !paste.CreatedAt.Equal(t.PasteCreatedAt) {
+		// The paste expired or was deleted, maybe replaced by another
+		// paste under the same slug.
+		s.delete(ts, t)
+		return nil, nil, ErrTokenNotFound
+	}
+	if paste.Quarantined || paste.BurnAfterRead {
+		return nil, nil, ErrTokenNotFound
+	}
+	if paste.Corrupt {
+		return nil, nil, ErrContentCorrupt
+	}
+	t.Uses++
+	if t.Exhausted() {
+		s.delete(ts, t)
+	} else if err := ts.StoreToken(t); err != nil {
+		return nil, nil, fmt.Errorf("failed to store token: %w", err)
+	}
+	return t, paste, nil
+}
+
+// delete removes t, logging failures.
+func (s *TokenService) delete(ts storage.TokenStore, t *models.ShareToken) {
+	if err := ts.DeleteToken(t); err != nil {
+		log.Printf("[WARN] Failed to delete share token of %s: %v", t.Slug, err)
+	}
+}
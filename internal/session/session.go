@@ -0,0 +1,215 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/hmackeys"
+)
+
+const (
+	// CookieName is the name of the signed session cookie.
+	CookieName = "nclip_session"
+	// CSRFHeader carries the CSRF token. The web UI reads the token from
+	// the upload form and sends it on every mutating request.
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// gin context keys.
+const (
+	managerKey  = "nclip.session.manager"
+	sessionKey  = "nclip.session"
+	verifiedKey = "nclip.session.verified"
+)
+
+// Session is a browser session identified by a signed cookie. Sessions are
+// stateless: everything needed to validate one is in the cookie itself.
+type Session struct {
+	ID        string
+	ExpiresAt time.Time
+}
+
+// Manager issues and validates session cookies and CSRF tokens.
+type Manager struct {
+	keys *hmackeys.Ring
+	ttl  time.Duration
+	now  func() time.Time
+}
+
+// NewManager creates a Manager signing with keys.
+func NewManager(keys *hmackeys.Ring, ttl time.Duration) *Manager {
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return &Manager{keys: keys, ttl: ttl, now: time.Now}
+}
+
+// Middleware loads the session cookie into the request context and enforces
+// CSRF protection: unsafe requests that carry a valid session cookie must
+// also present the matching token, or they are rejected with 403. Requests
+// without a session (API clients) pass through untouched.
+func (m *Manager) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(managerKey, m)
+		sess, ok := m.load(c.Request)
+		if !ok {
+			c.Next()
+			return
+		}
+		c.Set(sessionKey, sess)
+		if isSafeMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+		if !m.ValidToken(sess, c.GetHeader(CSRFHeader)) {
+			apierror.Abort(c, http.StatusForbidden, apierror.CodeCSRFInvalid, "invalid or missing CSRF token")
+			return
+		}
+		c.Set(verifiedKey, true)
+		c.Next()
+	}
+}
+
+// Ensure returns the current session, issuing a new cookie when the request
+// has none. It returns nil when the session middleware is not installed.
+func Ensure(c *gin.Context) *Session {
+	if sess := FromContext(c); sess != nil {
+		return sess
+	}
+	m := managerFrom(c)
+	if m == nil {
+		return nil
+	}
+	sess := m.issue(c)
+	c.Set(sessionKey, sess)
+	return sess
+}
+
+// CSRFToken returns the CSRF token for the current session, issuing a
+// session when needed. It returns "" when sessions are not enabled.
+func CSRFToken(c *gin.Context) string {
+	sess := Ensure(c)
+	if sess == nil {
+		return ""
+	}
+	return managerFrom(c).Token(sess)
+}
+
+// FromContext returns the session loaded for this request, if any.
+func FromContext(c *gin.Context) *Session {
+	if v, ok := c.Get(sessionKey); ok {
+		if sess, ok := v.(*Session); ok {
+			return sess
+		}
+	}
+	return nil
+}
+
+// Verified reports whether the request carried a valid session cookie and
+// a matching CSRF token.
+func Verified(c *gin.Context) bool {
+	return c.GetBool(verifiedKey)
+}
+
+// Token derives the CSRF token bound to sess.
+func (m *Manager) Token(sess *Session) string {
+	return hex.EncodeToString(m.keys.MAC("csrf:" + sess.ID))
+}
+
+// ValidToken reports whether token is the CSRF token for sess. Its session
+// cookie names the key, so the token is checked against each; there are
+// only a few.
+func (m *Manager) ValidToken(sess *Session, token string) bool {
+	if sess == nil || token == "" {
+		return false
+	}
+	sum, err := hex.DecodeString(token)
+	return err == nil && m.keys.VerifyMAC("csrf:"+sess.ID, sum)
+}
+
+// issue creates a new session and sets its cookie on the response.
+func (m *Manager) issue(c *gin.Context) *Session {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		log.Printf("[ERROR] failed to generate session id: %v", err)
+	}
+	sess := &Session{ID: hex.EncodeToString(id[:]), ExpiresAt: m.now().Add(m.ttl)}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     CookieName,
+		Value:    m.encode(sess),
+		Path:     "/",
+		Expires:  sess.ExpiresAt,
+		MaxAge:   int(m.ttl / time.Second),
+		HttpOnly: true,
+		Secure:   isHTTPS(c.Request),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return sess
+}
+
+// load parses and verifies the session cookie on r.
+func (m *Manager) load(r *http.Request) (*Session, bool) {
+	cookie, err := r.Cookie(CookieName)
+	if err != nil {
+		return nil, false
+	}
+	return m.decode(cookie.Value)
+}
+
+// encode serializes sess as "<id>.<expiry>.<signature>".
+func (m *Manager) encode(sess *Session) string {
+	payload := sess.ID + "." + strconv.FormatInt(sess.ExpiresAt.Unix(), 10)
+	return payload + "." + m.keys.Sign(payload)
+}
+
+func (m *Manager) decode(value string) (*Session, bool) {
+	i := strings.LastIndexByte(value, '.')
+	if i < 0 {
+		return nil, false
+	}
+	payload, sig := value[:i], value[i+1:]
+	if !m.keys.Verify(payload, sig) {
+		return nil, false
+	}
+	id, exp, ok := strings.Cut(payload, ".")
+	if !ok || id == "" {
+		return nil, false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	expiresAt := time.Unix(unix, 0)
+	if !m.now().Before(expiresAt) {
+		return nil, false
+	}
+	return &Session{ID: id, ExpiresAt: expiresAt}, true
+}
+
+func managerFrom(c *gin.Context) *Manager {
+	if v, ok := c.Get(managerKey); ok {
+		if m, ok := v.(*Manager); ok {
+			return m
+		}
+	}
+	return nil
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
@@ -0,0 +1,101 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/internal/hmackeys"
+)
+
+func TestManager_EncodeDecode(t *testing.T) {
+	m := NewManager(hmackeys.New("secret", nil), time.Hour)
+	sess := &Session{ID: "abc123", ExpiresAt: time.Now().Add(time.Hour)}
+	value := m.encode(sess)
+
+	got, ok := m.decode(value)
+	if !ok || got.ID != sess.ID {
+		t.Fatalf("expected valid session %q, got %+v ok=%v", sess.ID, got, ok)
+	}
+	if _, ok := m.decode(value + "x"); ok {
+		t.Error("expected tampered signature to be rejected")
+	}
+	if _, ok := NewManager(hmackeys.New("other", nil), time.Hour).decode(value); ok {
+		t.Error("expected cookie signed with another secret to be rejected")
+	}
+
+	m.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, ok := m.decode(value); ok {
+		t.Error("expected expired session to be rejected")
+	}
+}
+
+func TestManager_Token(t *testing.T) {
+	m := NewManager(hmackeys.New("secret", nil), time.Hour)
+	a := &Session{ID: "a"}
+	b := &Session{ID: "b"}
+	if !m.ValidToken(a, m.Token(a)) {
+		t.Error("expected token to validate for its session")
+	}
+	if m.ValidToken(b, m.Token(a)) {
+		t.Error("expected token to be bound to its session")
+	}
+	if m.ValidToken(a, "") {
+		t.Error("expected empty token to be rejected")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := NewManager(hmackeys.New("secret", nil), time.Hour)
+	router := gin.New()
+	router.Use(m.Middleware())
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, CSRFToken(c))
+	})
+	router.POST("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "%v", Verified(c))
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != CookieName {
+		t.Fatalf("expected session cookie, got %v", cookies)
+	}
+	token := w.Body.String()
+
+	post := func(cookie *http.Cookie, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		if token != "" {
+			req.Header.Set(CSRFHeader, token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := post(nil, ""); w.Code != http.StatusOK || w.Body.String() != "false" {
+		t.Errorf("expected request without session to pass unverified, got %d %q", w.Code, w.Body.String())
+	}
+	if w := post(cookies[0], ""); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for session without token, got %d", w.Code)
+	}
+	if w := post(cookies[0], token); w.Code != http.StatusOK || w.Body.String() != "true" {
+		t.Errorf("expected verified request, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestCSRFToken_NoManager(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/", nil)
+	if token := CSRFToken(c); token != "" {
+		t.Errorf("expected empty token without middleware, got %q", token)
+	}
+}
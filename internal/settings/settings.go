@@ -0,0 +1,301 @@
+// Package settings holds the settings operators can change while nclip is
+// running, through the admin API. Changes are saved to the storage backend
+// and every instance polls for them, so all instances of a deployment pick
+// them up within a poll interval, without a rollout.
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/storage"
+)
+
+// ObjectID is the storage id the changed settings are saved under. It is
+// not a valid slug, so paste listings and the janitor never see it.
+const ObjectID = "settings.runtime"
+
+// PollInterval is how often instances check the store for changes.
+const PollInterval = 15 * time.Second
+
+// ErrInvalid is returned for changes that name an unknown setting or give
+// a setting an invalid value.
+var ErrInvalid = errors.New("invalid settings")
+
+// Values are the settings in effect.
+type Values struct {
+	// DefaultTTL is the lifetime of uploads that do not ask for one.
+	DefaultTTL time.Duration
+	// MaxRenderSize is the largest paste rendered inline in the HTML view.
+	MaxRenderSize int64
+	// TCPRateLimit and TCPRateLimitIPv6 are the per-minute request limits
+	// of the TCP and gopher listeners; see config.Config.
+	TCPRateLimit     int
+	TCPRateLimitIPv6 int
+	// ReadOnly refuses every write except changing the settings, e.g.
+	// during a backend migration.
+	ReadOnly bool
+}
+
+// FromConfig returns the values cfg configures.
+func FromConfig(cfg *config.Config) Values {
+	return Values{
+		DefaultTTL:       cfg.DefaultTTL,
+		MaxRenderSize:    cfg.MaxRenderSize,
+		TCPRateLimit:     cfg.TCPRateLimit,
+		TCPRateLimitIPv6: cfg.TCPRateLimitIPv6,
+	}
+}
+
+// MarshalJSON writes v with the names Overrides uses and DefaultTTL as a
+// duration string.
+func (v Values) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"default_ttl":         v.DefaultTTL.String(),
+		"max_render_size":     v.MaxRenderSize,
+		"tcp_rate_limit":      v.TCPRateLimit,
+		"tcp_rate_limit_ipv6": v.TCPRateLimitIPv6,
+		"read_only":           v.ReadOnly,
+	})
+}
+
+// Overrides are the settings changed through the admin API, as saved in
+// the store. Nil fields keep the value each instance is configured with.
+type Overrides struct {
+	DefaultTTL       *string `json:"default_ttl,omitempty"`
+	MaxRenderSize    *int64  `json:"max_render_size,omitempty"`
+	TCPRateLimit     *int    `json:"tcp_rate_limit,omitempty"`
+	TCPRateLimitIPv6 *int    `json:"tcp_rate_limit_ipv6,omitempty"`
+	ReadOnly         *bool   `json:"read_only,omitempty"`
+	// UpdatedAt and UpdatedBy record the last change; UpdatedBy is the
+	// audit actor, such as an API key ID.
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+	UpdatedBy string    `json:"updated_by,omitempty"`
+}
+
+// apply returns base with o applied, or an ErrInvalid error naming the
+// first invalid value.
+func (o Overrides) apply(base Values) (Values, error) {
+	v := base
+	if o.DefaultTTL != nil {
+		d, err := time.ParseDuration(*o.DefaultTTL)
+		if err != nil || d <= 0 {
+			return v, fmt.Errorf("%w: default_ttl must be a positive duration, got %q", ErrInvalid, *o.DefaultTTL)
+		}
+		v.DefaultTTL = d
+	}
+	if o.MaxRenderSize != nil {
+		if *o.MaxRenderSize < 0 {
+			return v, fmt.Errorf("%w: max_render_size must not be negative, got %d", ErrInvalid, *o.MaxRenderSize)
+		}
+		v.MaxRenderSize = *o.MaxRenderSize
+	}
+	if o.TCPRateLimit != nil {
+		if *o.TCPRateLimit < 0 {
+			return v, fmt.Errorf("%w: tcp_rate_limit must not be negative, got %d", ErrInvalid, *o.TCPRateLimit)
+		}
+		v.TCPRateLimit = *o.TCPRateLimit
+	}
+	if o.TCPRateLimitIPv6 != nil {
+		if *o.TCPRateLimitIPv6 < 0 {
+			return v, fmt.Errorf("%w: tcp_rate_limit_ipv6 must not be negative, got %d", ErrInvalid, *o.TCPRateLimitIPv6)
+		}
+		v.TCPRateLimitIPv6 = *o.TCPRateLimitIPv6
+	}
+	if o.ReadOnly != nil {
+		v.ReadOnly = *o.ReadOnly
+	}
+	return v, nil
+}
+
+// patch applies a JSON merge patch to o: a setting set to null is reset to
+// the configured value, other settings are changed and omitted ones are
+// kept.
+func (o *Overrides) patch(body []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return fmt.Errorf("%w: body must be a JSON object", ErrInvalid)
+	}
+	targets := map[string]any{
+		"default_ttl":         &o.DefaultTTL,
+		"max_render_size":     &o.MaxRenderSize,
+		"tcp_rate_limit":      &o.TCPRateLimit,
+		"tcp_rate_limit_ipv6": &o.TCPRateLimitIPv6,
+		"read_only":           &o.ReadOnly,
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		target, ok := targets[name]
+		if !ok {
+			known := make([]string, 0, len(targets))
+			for k := range targets {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("%w: unknown setting %q (known: %s)", ErrInvalid, name, strings.Join(known, ", "))
+		}
+		// Unmarshaling null into a pointer field sets it to nil.
+		if err := json.Unmarshal(fields[name], target); err != nil {
+			return fmt.Errorf("%w: %s has the wrong type", ErrInvalid, name)
+		}
+	}
+	return nil
+}
+
+// Runtime holds the settings in effect on this instance: the configured
+// values with the saved overrides applied. It is safe for concurrent use.
+type Runtime struct {
+	store   storage.PasteStore
+	base    Values
+	current atomic.Pointer[Values]
+
+	// mu serializes loading and saving, and guards the fields below.
+	mu        sync.Mutex
+	overrides Overrides
+	saved     []byte
+	listeners []func(Values)
+	now       func() time.Time
+}
+
+// New creates a Runtime that starts with base and keeps its overrides in
+// store. Call Reload to pick up overrides saved earlier.
+func New(store storage.PasteStore, base Values) *Runtime {
+	r := &Runtime{store: store, base: base, now: time.Now}
+	r.current.Store(&base)
+	return r
+}
+
+// Current returns the settings in effect.
+func (r *Runtime) Current() Values {
+	return *r.current.Load()
+}
+
+// Overrides returns the saved overrides as last loaded.
+func (r *Runtime) Overrides() Overrides {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.overrides
+}
+
+// OnChange calls fn with the new settings whenever they change.
+func (r *Runtime) OnChange(fn func(Values)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners = append(r.listeners, fn)
+}
+
+// Reload reads the saved overrides from the store. On error the current
+// settings stay in effect.
+func (r *Runtime) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.load()
+}
+
+// load is Reload; callers must hold r.mu.
+func (r *Runtime) load() error {
+	exists, _, err := r.store.StatContent(ObjectID)
+	if err != nil {
+		return fmt.Errorf("check saved settings: %w", err)
+	}
+	var data []byte
+	if exists {
+		if data, err = r.store.GetContent(ObjectID); err != nil {
+			return fmt.Errorf("read saved settings: %w", err)
+		}
+	}
+	if bytes.Equal(data, r.saved) {
+		return nil
+	}
+	var o Overrides
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &o); err != nil {
+			return fmt.Errorf("parse saved settings: %w", err)
+		}
+	}
+	v, err := o.apply(r.base)
+	if err != nil {
+		return fmt.Errorf("saved settings: %w", err)
+	}
+	r.saved = data
+	r.set(o, v)
+	return nil
+}
+
+// set makes o and v current and tells the listeners. Callers must hold
+// r.mu.
+func (r *Runtime) set(o Overrides, v Values) {
+	r.overrides = o
+	if v == r.Current() {
+		return
+	}
+	r.current.Store(&v)
+	for _, fn := range r.listeners {
+		fn(v)
+	}
+}
+
+// Update applies a JSON merge patch (see Overrides) on top of the latest
+// saved overrides, saves the result and returns it. Other instances pick
+// it up on their next poll. Concurrent updates from different instances
+// are not merged: the last one saved wins.
+func (r *Runtime) Update(patch []byte, actor string) (Overrides, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.load(); err != nil {
+		return Overrides{}, err
+	}
+	o := r.overrides
+	if err := o.patch(patch); err != nil {
+		return Overrides{}, err
+	}
+	v, err := o.apply(r.base)
+	if err != nil {
+		return Overrides{}, err
+	}
+	o.UpdatedAt = r.now().UTC()
+	o.UpdatedBy = actor
+	data, err := json.Marshal(o)
+	if err != nil {
+		return Overrides{}, err
+	}
+	if err := r.store.StoreContent(ObjectID, data); err != nil {
+		return Overrides{}, fmt.Errorf("save settings: %w", err)
+	}
+	r.saved = data
+	r.set(o, v)
+	return o, nil
+}
+
+// Watch reloads the settings every interval until stop is closed. Errors
+// are logged and the current settings kept.
+func (r *Runtime) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			before := r.Current()
+			if err := r.Reload(); err != nil {
+				log.Printf("[ERROR] Failed to reload runtime settings: %v", err)
+			} else if after := r.Current(); after != before {
+				log.Printf("Runtime settings changed: %+v", after)
+			}
+		}
+	}
+}
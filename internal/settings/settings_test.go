@@ -0,0 +1,89 @@
+package settings
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/storage"
+)
+
+func TestRuntime(t *testing.T) {
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	base := Values{DefaultTTL: 24 * time.Hour, MaxRenderSize: 1024, TCPRateLimit: 60}
+	a := New(store, base)
+	b := New(store, base)
+	if err := b.Reload(); err != nil {
+		t.Fatalf("Reload without saved settings: %v", err)
+	}
+	if b.Current() != base {
+		t.Errorf("expected the configured values, got %+v", b.Current())
+	}
+	var changed []Values
+	b.OnChange(func(v Values) { changed = append(changed, v) })
+
+	o, err := a.Update([]byte(`{"default_ttl": "2h", "read_only": true, "tcp_rate_limit": 0}`), "key:admin")
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if o.UpdatedBy != "key:admin" || o.UpdatedAt.IsZero() {
+		t.Errorf("expected the change to be attributed, got %+v", o)
+	}
+	want := Values{DefaultTTL: 2 * time.Hour, MaxRenderSize: 1024, ReadOnly: true}
+	if a.Current() != want {
+		t.Errorf("expected %+v on the updating instance, got %+v", want, a.Current())
+	}
+	if err := b.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if b.Current() != want || len(changed) != 1 || changed[0] != want {
+		t.Errorf("expected %+v on the other instance, got %+v (changes: %v)", want, b.Current(), changed)
+	}
+	if err := b.Reload(); err != nil || len(changed) != 1 {
+		t.Errorf("expected an unchanged reload to notify nobody, got %d changes (%v)", len(changed), err)
+	}
+
+	// Changes made on the other instance are merged, and null resets a
+	// setting.
+	if _, err := b.Update([]byte(`{"read_only": null, "max_render_size": 2048}`), ""); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if err := a.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	want = Values{DefaultTTL: 2 * time.Hour, MaxRenderSize: 2048}
+	if a.Current() != want {
+		t.Errorf("expected %+v, got %+v", want, a.Current())
+	}
+
+	for _, patch := range []string{
+		`{"default_ttl": "0s"}`,
+		`{"default_ttl": "soon"}`,
+		`{"max_render_size": -1}`,
+		`{"tcp_rate_limit_ipv6": -5}`,
+		`{"read_only": "yes"}`,
+		`{"buffer_size": 10}`,
+		`[]`,
+	} {
+		if _, err := a.Update([]byte(patch), ""); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: expected ErrInvalid, got %v", patch, err)
+		}
+	}
+	if a.Current() != want {
+		t.Errorf("expected invalid changes to be ignored, got %+v", a.Current())
+	}
+
+	// Corrupt saved settings keep the current ones.
+	if err := store.StoreContent(ObjectID, []byte(`{"max_render_size": -1}`)); err != nil {
+		t.Fatalf("StoreContent: %v", err)
+	}
+	if err := a.Reload(); err == nil {
+		t.Error("expected invalid saved settings to fail to load")
+	}
+	if a.Current() != want {
+		t.Errorf("expected the previous settings to stay, got %+v", a.Current())
+	}
+}
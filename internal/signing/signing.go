@@ -0,0 +1,94 @@
+// Package signing signs raw paste downloads so clients can detect content
+// modified between the server and them, e.g. by a proxy or CDN.
+//
+// The signature is Ed25519 over the message
+//
+//	"nclip-signature-v1\n" | slug | "\n" | hex(SHA-256(content))
+//
+// and is sent base64-encoded in the Header response header, next to the
+// content hash in HashHeader.
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// Header carries the base64 signature of a response.
+	Header = "X-Nclip-Signature"
+	// HashHeader carries the hex SHA-256 of the full content that was
+	// signed.
+	HashHeader = "X-Nclip-Content-Sha256"
+	// Algorithm names the signature algorithm.
+	Algorithm = "ed25519"
+	// Prefix starts every signed message.
+	Prefix = "nclip-signature-v1\n"
+)
+
+// Signer signs paste content with an Ed25519 key.
+type Signer struct {
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+// Parse returns a Signer for a 32-byte Ed25519 seed in standard base64.
+func Parse(s string) (*Signer, error) {
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("signing key is not valid base64: %w", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing key must be %d bytes, got %d", ed25519.SeedSize, len(seed))
+	}
+	key := ed25519.NewKeyFromSeed(seed)
+	sum := sha256.Sum256(key.Public().(ed25519.PublicKey))
+	return &Signer{key: key, keyID: hex.EncodeToString(sum[:8])}, nil
+}
+
+// PublicKey returns the public key signatures verify against.
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// KeyID identifies the key: the first 8 bytes of the SHA-256 of the public
+// key, in hex.
+func (s *Signer) KeyID() string {
+	return s.keyID
+}
+
+// Sign returns the hex SHA-256 of content and the base64 signature over it
+// and slug.
+func (s *Signer) Sign(slug string, content []byte) (hash, signature string) {
+	sum := sha256.Sum256(content)
+	hash = hex.EncodeToString(sum[:])
+	return hash, base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, Message(slug, hash)))
+}
+
+// Headers returns the response headers that sign content as the content
+// of slug: the hash in HashHeader and the signature in Header.
+func (s *Signer) Headers(slug string, content []byte) map[string]string {
+	hash, signature := s.Sign(slug, content)
+	return map[string]string{HashHeader: hash, Header: signature}
+}
+
+// Message returns the signed message for slug and the hex SHA-256 of its
+// content.
+func Message(slug, hash string) []byte {
+	return []byte(Prefix + slug + "\n" + hash)
+}
+
+// Verify reports whether signature is a valid base64 signature by pub
+// over slug and content.
+func Verify(pub ed25519.PublicKey, slug string, content []byte, signature string) bool {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	sum := sha256.Sum256(content)
+	return ed25519.Verify(pub, Message(slug, hex.EncodeToString(sum[:])), sig)
+}
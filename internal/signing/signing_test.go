@@ -0,0 +1,50 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+)
+
+func testSeed(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, ed25519.SeedSize))
+}
+
+func TestSignVerify(t *testing.T) {
+	s, err := Parse(testSeed(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, sig := s.Sign("ABCDE", []byte("hello"))
+	sum := sha256.Sum256([]byte("hello"))
+	if hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("hash = %s", hash)
+	}
+	if !Verify(s.PublicKey(), "ABCDE", []byte("hello"), sig) {
+		t.Fatal("signature does not verify")
+	}
+	if Verify(s.PublicKey(), "ABCDE", []byte("hellO"), sig) {
+		t.Error("signature verifies for modified content")
+	}
+	if Verify(s.PublicKey(), "ABCDF", []byte("hello"), sig) {
+		t.Error("signature verifies for another slug")
+	}
+	other, _ := Parse(testSeed(2))
+	if Verify(other.PublicKey(), "ABCDE", []byte("hello"), sig) {
+		t.Error("signature verifies with another key")
+	}
+	if len(s.KeyID()) != 16 || s.KeyID() == other.KeyID() {
+		t.Errorf("unexpected key IDs %q and %q", s.KeyID(), other.KeyID())
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, bad := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q): expected an error", bad)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Package slashcmd verifies and parses Slack and Mattermost slash-command
+// requests.
+package slashcmd
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxSkew is how far a Slack request timestamp may be from the current
+// time. Older requests are rejected so captured requests cannot be
+// replayed.
+const MaxSkew = 5 * time.Minute
+
+var (
+	// ErrInvalidSignature is returned for requests that are not signed
+	// with the workspace's secret.
+	ErrInvalidSignature = errors.New("invalid slash command signature")
+	// ErrStale is returned for Slack requests outside MaxSkew.
+	ErrStale = errors.New("slash command timestamp is too old")
+)
+
+// Workspace is a Slack or Mattermost team allowed to create pastes.
+type Workspace struct {
+	// TeamID is the team_id sent with every command.
+	TeamID string
+	// Secret is the Slack signing secret or the Mattermost command token.
+	Secret string
+	// APIKey, when set, is the API key pastes from this workspace are
+	// attributed to, so they are accounted for like uploads with that key.
+	APIKey string
+}
+
+// ParseWorkspaces parses a comma-separated list of TEAM_ID:SECRET or
+// TEAM_ID:SECRET:API_KEY entries.
+func ParseWorkspaces(s string) (map[string]Workspace, error) {
+	workspaces := make(map[string]Workspace)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid workspace %q: want TEAM_ID:SECRET[:API_KEY]", parts[0])
+		}
+		ws := Workspace{TeamID: parts[0], Secret: parts[1]}
+		if len(parts) == 3 {
+			ws.APIKey = parts[2]
+		}
+		if _, dup := workspaces[ws.TeamID]; dup {
+			return nil, fmt.Errorf("workspace %q listed twice", ws.TeamID)
+		}
+		workspaces[ws.TeamID] = ws
+	}
+	return workspaces, nil
+}
+
+// VerifySlack checks a Slack request signature (X-Slack-Signature) over
+// the X-Slack-Request-Timestamp header and the raw body.
+func VerifySlack(secret, timestamp, signature string, body []byte, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if skew := now.Sub(time.Unix(ts, 0)); skew > MaxSkew || skew < -MaxSkew {
+		return ErrStale
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(want), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// VerifyToken checks a Mattermost command token.
+func VerifyToken(secret, token string) error {
+	if token == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(token)) != 1 {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Content returns the paste content for the command text. A snippet
+// wrapped in ``` fences is unwrapped, dropping a language hint on the
+// opening fence line.
+func Content(text string) string {
+	text = strings.TrimSpace(text)
+	inner, ok := strings.CutPrefix(text, "```")
+	if !ok {
+		return text
+	}
+	inner, ok = strings.CutSuffix(inner, "```")
+	if !ok {
+		return text
+	}
+	if first, rest, found := strings.Cut(inner, "\n"); found && !strings.ContainsAny(strings.TrimSpace(first), " \t") {
+		inner = rest
+	}
+	return strings.Trim(inner, "\n")
+}
@@ -0,0 +1,84 @@
+package slashcmd
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func sign(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySlack(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	body := []byte("team_id=T1&text=hello")
+	sig := sign("secret", ts, body)
+
+	if err := VerifySlack("secret", ts, sig, body, now); err != nil {
+		t.Fatalf("expected valid signature, got %v", err)
+	}
+	if err := VerifySlack("secret", ts, sig, []byte("team_id=T1&text=bye"), now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("tampered body: expected ErrInvalidSignature, got %v", err)
+	}
+	if err := VerifySlack("other", ts, sig, body, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("wrong secret: expected ErrInvalidSignature, got %v", err)
+	}
+	if err := VerifySlack("secret", ts, sig, body, now.Add(MaxSkew+time.Second)); !errors.Is(err, ErrStale) {
+		t.Errorf("replay: expected ErrStale, got %v", err)
+	}
+	if err := VerifySlack("secret", "soon", sig, body, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("bad timestamp: expected ErrInvalidSignature, got %v", err)
+	}
+}
+
+func TestVerifyToken(t *testing.T) {
+	if err := VerifyToken("tok", "tok"); err != nil {
+		t.Errorf("expected valid token, got %v", err)
+	}
+	for _, token := range []string{"", "tok2", "to"} {
+		if err := VerifyToken("tok", token); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("token %q: expected ErrInvalidSignature, got %v", token, err)
+		}
+	}
+}
+
+func TestParseWorkspaces(t *testing.T) {
+	ws, err := ParseWorkspaces("T1:s1, T2:s2:key:with:colons,")
+	if err != nil {
+		t.Fatalf("ParseWorkspaces: %v", err)
+	}
+	if len(ws) != 2 || ws["T1"].Secret != "s1" || ws["T1"].APIKey != "" || ws["T2"].APIKey != "key:with:colons" {
+		t.Errorf("unexpected workspaces: %+v", ws)
+	}
+	for _, bad := range []string{"T1", "T1:", ":s1", "T1:a,T1:b"} {
+		if _, err := ParseWorkspaces(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestContent(t *testing.T) {
+	cases := map[string]string{
+		"  plain text ":              "plain text",
+		"```\nfunc main() {}\n```":   "func main() {}",
+		"```go\nfunc main() {}\n```": "func main() {}",
+		"```x := 1\ny := 2\n```":     "x := 1\ny := 2",
+		"```inline```":               "inline",
+		"```unterminated":            "```unterminated",
+		"before ```not a snippet```": "before ```not a snippet```",
+	}
+	for in, want := range cases {
+		if got := Content(in); got != want {
+			t.Errorf("Content(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
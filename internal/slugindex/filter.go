@@ -0,0 +1,112 @@
+// Package slugindex provides a Bloom filter of taken slugs, so most
+// availability checks can be answered without asking the storage backend.
+//
+// A filter never reports a slug it was given as absent; it may report a
+// slug it was never given as present, with a probability that grows as it
+// fills beyond the capacity it was sized for. Slugs cannot be removed, so
+// filters are rebuilt from a listing of the store from time to time.
+package slugindex
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+)
+
+// FalsePositiveRate is the probability of a false "maybe taken" that
+// filters are sized for at their capacity.
+const FalsePositiveRate = 0.01
+
+// MinCapacity is the smallest capacity New sizes a filter for, so a new
+// or nearly empty store does not start with a filter that fills at once.
+const MinCapacity = 1 << 16
+
+// ErrInvalid is returned by UnmarshalBinary for data MarshalBinary did not
+// produce.
+var ErrInvalid = errors.New("invalid slug index")
+
+// Filter is a Bloom filter of slugs. It is not safe for concurrent use.
+type Filter struct {
+	bits   []uint64
+	hashes uint32
+	count  uint64
+}
+
+// New returns an empty filter sized for capacity slugs at
+// FalsePositiveRate, or for MinCapacity if that is larger.
+func New(capacity int) *Filter {
+	n := float64(max(capacity, MinCapacity))
+	m := math.Ceil(-n * math.Log(FalsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / n * math.Ln2)
+	return &Filter{
+		bits:   make([]uint64, (uint64(m)+63)/64),
+		hashes: uint32(max(k, 1)),
+	}
+}
+
+// Add records slug as taken.
+func (f *Filter) Add(slug string) {
+	h1, h2 := hash(slug)
+	m := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		bit := (h1 + i*h2) % m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.count++
+}
+
+// MayContain reports whether slug may be taken. False means it was never
+// added.
+func (f *Filter) MayContain(slug string) bool {
+	h1, h2 := hash(slug)
+	m := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		bit := (h1 + i*h2) % m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Count returns the number of Add calls, counting repeats.
+func (f *Filter) Count() uint64 { return f.count }
+
+// hash returns the two hashes the filter's bit positions are derived
+// from (Kirsch-Mitzenmacher double hashing). h2 is odd, so the positions
+// do not repeat early.
+func hash(slug string) (h1, h2 uint64) {
+	h := fnv.New128a()
+	_, _ = h.Write([]byte(slug))
+	sum := h.Sum(nil)
+	return binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:]) | 1
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	out := make([]byte, 12, 12+8*len(f.bits))
+	binary.BigEndian.PutUint32(out[0:4], f.hashes)
+	binary.BigEndian.PutUint64(out[4:12], f.count)
+	for _, w := range f.bits {
+		out = binary.BigEndian.AppendUint64(out, w)
+	}
+	return out, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	if len(data) < 20 || (len(data)-12)%8 != 0 {
+		return ErrInvalid
+	}
+	hashes := binary.BigEndian.Uint32(data[0:4])
+	if hashes == 0 || hashes > 64 {
+		return ErrInvalid
+	}
+	bits := make([]uint64, (len(data)-12)/8)
+	for i := range bits {
+		bits[i] = binary.BigEndian.Uint64(data[12+8*i:])
+	}
+	*f = Filter{bits: bits, hashes: hashes, count: binary.BigEndian.Uint64(data[4:12])}
+	return nil
+}
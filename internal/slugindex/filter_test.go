@@ -0,0 +1,55 @@
+package slugindex
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	f := New(1000)
+	for i := 0; i < MinCapacity; i++ {
+		f.Add(fmt.Sprintf("T%06d", i))
+	}
+	for i := 0; i < MinCapacity; i++ {
+		if !f.MayContain(fmt.Sprintf("T%06d", i)) {
+			t.Fatalf("added slug T%06d reported absent", i)
+		}
+	}
+	falsePositives := 0
+	const probes = 100000
+	for i := 0; i < probes; i++ {
+		if f.MayContain(fmt.Sprintf("F%06d", i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / probes; rate > 2*FalsePositiveRate {
+		t.Errorf("false positive rate at capacity = %.4f, want about %.2f", rate, FalsePositiveRate)
+	}
+	if f.Count() != MinCapacity {
+		t.Errorf("Count() = %d, want %d", f.Count(), MinCapacity)
+	}
+}
+
+func TestFilter_Binary(t *testing.T) {
+	f := New(10)
+	f.Add("ABCDE")
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g Filter
+	if err := g.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if !g.MayContain("ABCDE") || g.Count() != 1 {
+		t.Errorf("round trip lost the filter contents")
+	}
+	if g.MayContain("FGHJK") != f.MayContain("FGHJK") {
+		t.Errorf("round trip changed the filter")
+	}
+	for _, bad := range [][]byte{nil, data[:15], append(data[:len(data):len(data)], 1)} {
+		if err := g.UnmarshalBinary(bad); err != ErrInvalid {
+			t.Errorf("UnmarshalBinary(%d bytes) = %v, want ErrInvalid", len(bad), err)
+		}
+	}
+}
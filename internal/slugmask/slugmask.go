@@ -0,0 +1,80 @@
+// Package slugmask maps the custom slugs clients choose with X-Slug to the
+// slugs their pastes are stored under. Plain mode keeps them as chosen. In
+// obfuscate mode the stored slug is an HMAC of the custom slug keyed with
+// a server secret, so custom slugs that follow a sequence, such as build
+// numbers, do not lead to each other's pastes.
+//
+// The mapping is deterministic: a custom slug maps to the same paste until
+// the secret changes, so it stays taken once used, and the API key that
+// uploaded a paste can find it again by its custom slug.
+package slugmask
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+)
+
+// Modes.
+const (
+	ModePlain     = "plain"
+	ModeObfuscate = "obfuscate"
+)
+
+// MinSecretLength is the shortest secret obfuscate mode accepts.
+const MinSecretLength = 16
+
+// Length is the length of obfuscated slugs: 16 characters of the slug
+// alphabet carry 80 bits of the HMAC.
+const Length = 16
+
+// encoding writes HMACs in the slug alphabet, which has exactly 32
+// characters.
+var encoding = base32.NewEncoding("ABCDEFGHJKLMNPQRSTUVWXYZ23456789").WithPadding(base32.NoPadding)
+
+// Transformer maps a valid custom slug to the slug its paste is stored
+// under, which is also a valid slug.
+type Transformer interface {
+	Transform(slug string) string
+}
+
+// Plain keeps custom slugs as they are.
+type Plain struct{}
+
+// Transform implements Transformer.
+func (Plain) Transform(slug string) string {
+	return slug
+}
+
+// HMAC replaces custom slugs by their HMAC-SHA256 under a secret.
+type HMAC struct {
+	secret []byte
+}
+
+// NewHMAC creates an HMAC transformer keyed with secret.
+func NewHMAC(secret string) *HMAC {
+	return &HMAC{secret: []byte(secret)}
+}
+
+// Transform implements Transformer.
+func (h *HMAC) Transform(slug string) string {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte("slug:" + slug))
+	return encoding.EncodeToString(mac.Sum(nil))[:Length]
+}
+
+// New returns the transformer of mode, keyed with secret in obfuscate
+// mode. An empty mode is plain.
+func New(mode, secret string) (Transformer, error) {
+	switch mode {
+	case "", ModePlain:
+		return Plain{}, nil
+	case ModeObfuscate:
+		if len(secret) < MinSecretLength {
+			return nil, fmt.Errorf("obfuscate mode needs a secret of at least %d characters", MinSecretLength)
+		}
+		return NewHMAC(secret), nil
+	}
+	return nil, fmt.Errorf("unknown slug mode %q: want plain or obfuscate", mode)
+}
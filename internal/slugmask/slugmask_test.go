@@ -0,0 +1,45 @@
+package slugmask
+
+import (
+	"testing"
+
+	"github.com/johnwmail/nclip/utils"
+)
+
+func TestHMAC(t *testing.T) {
+	h := NewHMAC("0123456789abcdef")
+	a, b := h.Transform("BATCH2345"), h.Transform("BATCH2346")
+	if len(a) != Length || !utils.IsValidSlug(a) || !utils.IsValidSlug(b) {
+		t.Fatalf("expected valid slugs of %d characters, got %q and %q", Length, a, b)
+	}
+	if a == b || a == "BATCH2345" {
+		t.Errorf("expected distinct obfuscated slugs, got %q and %q", a, b)
+	}
+	if again := h.Transform("BATCH2345"); again != a {
+		t.Errorf("expected a stable mapping, got %q then %q", a, again)
+	}
+	if other := NewHMAC("fedcba9876543210").Transform("BATCH2345"); other == a {
+		t.Errorf("expected the mapping to depend on the secret")
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, tc := range []struct {
+		mode, secret string
+		ok           bool
+	}{
+		{"", "", true},
+		{ModePlain, "", true},
+		{ModeObfuscate, "0123456789abcdef", true},
+		{ModeObfuscate, "short", false},
+		{"hash", "0123456789abcdef", false},
+	} {
+		tr, err := New(tc.mode, tc.secret)
+		if (err == nil) != tc.ok {
+			t.Errorf("New(%q, %q) error = %v, want ok=%v", tc.mode, tc.secret, err, tc.ok)
+		}
+		if err == nil && tc.mode != ModeObfuscate && tr.Transform("ABC") != "ABC" {
+			t.Errorf("New(%q) should keep slugs as they are", tc.mode)
+		}
+	}
+}
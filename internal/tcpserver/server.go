@@ -0,0 +1,294 @@
+package tcpserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/burnnotify"
+	"github.com/johnwmail/nclip/internal/ratelimit"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/utils"
+)
+
+const (
+	// maxRequestLine bounds the request line a client may send. Slugs are
+	// at most 32 characters; the extra room covers gopher selectors.
+	maxRequestLine = 128
+	// readTimeout is how long a client has to send its request line.
+	readTimeout = 10 * time.Second
+	// writeTimeout bounds how long a response may take to be written.
+	writeTimeout = 60 * time.Second
+)
+
+// Protocol selects the wire format spoken by a Server.
+type Protocol int
+
+const (
+	// ProtocolTCP is the "type and go" protocol: the client sends
+	// "<slug>\n" and receives the raw content, then the connection closes.
+	ProtocolTCP Protocol = iota
+	// ProtocolGopher speaks a minimal read-only subset of RFC 1436.
+	ProtocolGopher
+)
+
+// Server serves pastes read-only over a plain TCP line protocol or gopher.
+// It shares the PasteService with the HTTP handlers so read-count and
+// burn-after-read semantics are identical across transports.
+type Server struct {
+	service  *services.PasteService
+	protocol Protocol
+	maxSize  int64
+	limiter  *ratelimit.IPLimiter
+	audit    *audit.Logger
+
+	mu       sync.Mutex
+	listener net.Listener
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// New creates a Server speaking the given protocol.
+func New(service *services.PasteService, cfg *config.Config, protocol Protocol) *Server {
+	s := &Server{
+		service:  service,
+		protocol: protocol,
+		maxSize:  cfg.TCPMaxSize,
+	}
+	s.limiter = newLimiter(cfg, s.protocolName())
+	return s
+}
+
+// newLimiter builds the per-client limiter, aggregating IPv6 clients by
+// subnet so address rotation does not bypass it.
+func newLimiter(cfg *config.Config, name string) *ratelimit.IPLimiter {
+	return ratelimit.NewIP(name, ratelimit.IPOptions{
+		IPv4Limit:  cfg.TCPRateLimit,
+		IPv6Limit:  ipv6Limit(cfg.TCPRateLimit, cfg.TCPRateLimitIPv6),
+		IPv4Prefix: cfg.TCPRateLimitIPv4Prefix,
+		IPv6Prefix: cfg.TCPRateLimitIPv6Prefix,
+	}, time.Minute)
+}
+
+// ipv6Limit returns the IPv6 limit, which defaults to the IPv4 one.
+func ipv6Limit(limit, v6Limit int) int {
+	if v6Limit == 0 {
+		return limit
+	}
+	return v6Limit
+}
+
+// SetRateLimits changes the per-minute request limits for each client
+// aggregate, with the same meaning as cfg.TCPRateLimit and
+// cfg.TCPRateLimitIPv6.
+func (s *Server) SetRateLimits(limit, v6Limit int) {
+	s.limiter.SetLimits(limit, ipv6Limit(limit, v6Limit))
+}
+
+// SetRateCounter counts client requests with c, shared with the other
+// instances, instead of in memory.
+func (s *Server) SetRateCounter(c ratelimit.Counter) {
+	s.limiter.SetCounter(c)
+}
+
+// SetAuditLogger records burns served by this Server to l.
+func (s *Server) SetAuditLogger(l *audit.Logger) {
+	s.audit = l
+}
+
+// ListenAndServe listens on addr and serves connections until Close is called.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Close is called. It always returns a
+// non-nil error; after Close it returns net.ErrClosed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = l.Close()
+		return net.ErrClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return net.ErrClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(50 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConn(conn)
+		}()
+	}
+}
+
+// Close stops accepting connections and waits for in-flight requests.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	l := s.listener
+	s.mu.Unlock()
+	var err error
+	if l != nil {
+		err = l.Close()
+	}
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer func() {
+		if err := conn.Close(); err != nil && utils.IsDebugEnabled() {
+			log.Printf("[DEBUG] TCP: failed to close connection: %v", err)
+		}
+	}()
+
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		host = conn.RemoteAddr().String()
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(readTimeout))
+	line, err := readRequestLine(conn)
+	_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	// Check the limit after consuming the request line so the client
+	// receives the error instead of a connection reset.
+	if d := s.limiter.Allow(host); !d.Allowed {
+		if d.FirstRejection {
+			log.Printf("[WARN] %s: rate limit exceeded for %s (client %s)", s.protocolName(), d.Key, host)
+		}
+		s.writeError(conn, "rate limit exceeded")
+		return
+	}
+	if err != nil {
+		s.writeError(conn, "bad request")
+		return
+	}
+
+	slug := line
+	if s.protocol == ProtocolGopher {
+		slug = strings.TrimPrefix(line, "/")
+		if slug == "" {
+			s.writeGopherMenu(conn)
+			return
+		}
+	}
+	s.serveSlug(conn, host, slug)
+}
+
+// serveSlug writes the raw content for slug, enforcing the size limit before
+// the read so oversized burn pastes are not consumed.
+func (s *Server) serveSlug(w io.Writer, host, slug string) {
+	if !utils.IsValidSlug(slug) {
+		s.writeError(w, "invalid slug format")
+		return
+	}
+	paste, err := s.service.GetPaste(slug)
+	if err != nil || paste.IsPrivate() || paste.Quarantined {
+		// Private and quarantined pastes need an API key or share link,
+		// which these protocols cannot carry.
+		s.writeError(w, "paste not found")
+		return
+	}
+	if s.maxSize > 0 && paste.Size > s.maxSize {
+		s.writeError(w, "paste too large for this protocol, use HTTP")
+		return
+	}
+	read, content, err := s.service.ReadPaste(slug, burnnotify.NewReader(s.protocolName(), host, ""))
+	if errors.Is(err, services.ErrWriterOnly) {
+		s.writeError(w, "burn-after-read pastes are only served by the writer, use HTTP")
+		return
+	}
+	if errors.Is(err, services.ErrContentCorrupt) {
+		s.writeError(w, "paste content is corrupt")
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] TCP: failed to read paste %s: %v", slug, err)
+		s.writeError(w, "paste not found")
+		return
+	}
+	if read.BurnAfterRead {
+		result, detail := audit.ResultSuccess, s.protocolName()
+		if read.LegalHold {
+			result, detail = audit.ResultFailure, detail+": legal hold"
+		}
+		s.audit.Log(audit.Entry{
+			Action:  audit.ActionBurn,
+			Slug:    slug,
+			ActorIP: host,
+			Result:  result,
+			Detail:  detail,
+		})
+	}
+	if _, err := w.Write(content); err != nil {
+		log.Printf("[WARN] TCP: failed to write content for %s: %v", slug, err)
+	}
+}
+
+func (s *Server) protocolName() string {
+	if s.protocol == ProtocolGopher {
+		return "gopher"
+	}
+	return "tcp"
+}
+
+// readRequestLine reads a single CRLF- or LF-terminated line of at most
+// maxRequestLine bytes.
+func readRequestLine(r io.Reader) (string, error) {
+	br := bufio.NewReaderSize(io.LimitReader(r, maxRequestLine+2), maxRequestLine+2)
+	line, err := br.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) > maxRequestLine {
+		return "", fmt.Errorf("request line too long")
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// writeError writes an error in the server's protocol format.
+func (s *Server) writeError(w io.Writer, msg string) {
+	var out string
+	if s.protocol == ProtocolGopher {
+		out = fmt.Sprintf("3%s\t\terror.host\t1\r\n.\r\n", msg)
+	} else {
+		out = "error: " + msg + "\n"
+	}
+	_, _ = io.WriteString(w, out)
+}
+
+// writeGopherMenu answers the empty selector with a short informational menu.
+func (s *Server) writeGopherMenu(w io.Writer) {
+	_, _ = io.WriteString(w, "iNCLIP - HTTP Clipboard\t\terror.host\t1\r\n"+
+		"iRequest a paste by sending its slug as the selector.\t\terror.host\t1\r\n"+
+		".\r\n")
+}
@@ -0,0 +1,163 @@
+package tcpserver
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func startServer(t *testing.T, cfg *config.Config, protocol Protocol) (string, storage.PasteStore) {
+	t.Helper()
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	srv := New(services.NewPasteService(store, cfg), cfg, protocol)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return l.Addr().String(), store
+}
+
+func putPaste(t *testing.T, store storage.PasteStore, slug, content string, burn bool) {
+	t.Helper()
+	expires := time.Now().Add(time.Hour)
+	if err := store.StoreContent(slug, []byte(content)); err != nil {
+		t.Fatalf("StoreContent failed: %v", err)
+	}
+	if err := store.Store(&models.Paste{
+		ID:            slug,
+		CreatedAt:     time.Now(),
+		ExpiresAt:     &expires,
+		Size:          int64(len(content)),
+		ContentType:   "text/plain",
+		BurnAfterRead: burn,
+	}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+}
+
+func request(t *testing.T, addr, line string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err := io.WriteString(conn, line); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	out, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	return string(out)
+}
+
+func TestTCP_ServesRawContent(t *testing.T) {
+	cfg := &config.Config{TCPMaxSize: 1024}
+	addr, store := startServer(t, cfg, ProtocolTCP)
+	putPaste(t, store, "ABCDE", "hello tcp", false)
+
+	if got := request(t, addr, "ABCDE\n"); got != "hello tcp" {
+		t.Fatalf("expected raw content, got %q", got)
+	}
+	p, err := store.Get("ABCDE")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if p.ReadCount != 1 {
+		t.Errorf("expected read count 1, got %d", p.ReadCount)
+	}
+}
+
+func TestTCP_BurnAfterRead(t *testing.T) {
+	cfg := &config.Config{TCPMaxSize: 1024}
+	addr, store := startServer(t, cfg, ProtocolTCP)
+	putPaste(t, store, "BURNS", "secret", true)
+
+	if got := request(t, addr, "BURNS\r\n"); got != "secret" {
+		t.Fatalf("expected content on first read, got %q", got)
+	}
+	if got := request(t, addr, "BURNS\n"); !strings.HasPrefix(got, "error:") {
+		t.Fatalf("expected error on second read, got %q", got)
+	}
+}
+
+func TestTCP_Quarantined(t *testing.T) {
+	cfg := &config.Config{TCPMaxSize: 1024}
+	addr, store := startServer(t, cfg, ProtocolTCP)
+	putPaste(t, store, "QRNTN", "flagged", false)
+	p, err := store.Get("QRNTN")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	p.Quarantined = true
+	if err := store.Store(p); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	if got := request(t, addr, "QRNTN\n"); !strings.HasPrefix(got, "error:") {
+		t.Fatalf("expected quarantined paste to be hidden, got %q", got)
+	}
+}
+
+func TestTCP_Corrupt(t *testing.T) {
+	cfg := &config.Config{TCPMaxSize: 1024}
+	addr, store := startServer(t, cfg, ProtocolTCP)
+	putPaste(t, store, "CRPTD", "garbled", false)
+	p, err := store.Get("CRPTD")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	p.Corrupt = true
+	if err := store.Store(p); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	if got := request(t, addr, "CRPTD\n"); got != "error: paste content is corrupt\n" {
+		t.Fatalf("expected corrupt paste to be refused, got %q", got)
+	}
+}
+
+func TestTCP_SizeAndRateLimits(t *testing.T) {
+	cfg := &config.Config{TCPMaxSize: 4, TCPRateLimit: 1}
+	addr, store := startServer(t, cfg, ProtocolTCP)
+	putPaste(t, store, "LARGE", "too large", true)
+
+	if got := request(t, addr, "LARGE\n"); !strings.Contains(got, "too large") {
+		t.Fatalf("expected size error, got %q", got)
+	}
+	if exists, _ := store.Exists("LARGE"); !exists {
+		t.Fatal("oversized burn paste must not be consumed")
+	}
+	if got := request(t, addr, "LARGE\n"); !strings.Contains(got, "rate limit") {
+		t.Fatalf("expected rate limit error, got %q", got)
+	}
+}
+
+func TestGopher_SelectorAndMenu(t *testing.T) {
+	cfg := &config.Config{TCPMaxSize: 1024}
+	addr, store := startServer(t, cfg, ProtocolGopher)
+	putPaste(t, store, "GPHRS", "gopher content", false)
+
+	if got := request(t, addr, "/GPHRS\r\n"); got != "gopher content" {
+		t.Fatalf("expected gopher content, got %q", got)
+	}
+	if got := request(t, addr, "\r\n"); !strings.HasSuffix(got, ".\r\n") || !strings.HasPrefix(got, "i") {
+		t.Fatalf("expected gopher menu, got %q", got)
+	}
+	if got := request(t, addr, "NPE23\r\n"); !strings.HasPrefix(got, "3") {
+		t.Fatalf("expected gopher error item, got %q", got)
+	}
+}
@@ -0,0 +1,236 @@
+// Package tenancy accounts for the storage each tenant of a shared
+// instance uses, so the quotas of the keys file can be enforced and
+// reported, and the instance's totals shown on its public pages. Usage
+// comes from a scan of every paste's metadata, repeated once it is older
+// than MaxAge. Uploads made since the scan are added as they happen;
+// deletions and expiries are only seen by the next scan, so a tenant that
+// frees space may wait up to MaxAge before it can use it.
+package tenancy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/storage"
+)
+
+// MaxAge is how old a scan may get before usage is scanned again.
+const MaxAge = 5 * time.Minute
+
+// ErrQuotaExceeded is returned by Reserve for uploads that do not fit in
+// the tenant's quota.
+var ErrQuotaExceeded = errors.New("tenant storage quota exceeded")
+
+// ErrNotListable is returned by New for stores that cannot enumerate
+// pastes.
+var ErrNotListable = errors.New("the store cannot list pastes")
+
+// Usage is the storage one tenant uses. Tenant is "" for the default
+// tenant.
+type Usage struct {
+	Tenant string `json:"tenant"`
+	Pastes int    `json:"pastes"`
+	// Bytes is the total content size of the tenant's live pastes.
+	Bytes int64 `json:"bytes"`
+	// Reads is the total read count of those pastes.
+	Reads int64 `json:"reads"`
+	// Quota is the tenant's quota in bytes, or 0 for none.
+	Quota int64 `json:"quota,omitempty"`
+}
+
+// Tracker keeps the usage of every tenant. It is safe for concurrent use.
+type Tracker struct {
+	store   storage.PasteStore
+	lister  storage.Lister
+	tenancy *apikeys.Tenancy
+	now     func() time.Time
+	// scanMu serializes scans.
+	scanMu    sync.Mutex
+	mu        sync.Mutex
+	usage     map[string]*Usage
+	scannedAt time.Time
+}
+
+// New creates a Tracker for the pastes in store and the tenants of
+// tenancy. Nothing is scanned until usage is first needed.
+func New(store storage.PasteStore, tenancy *apikeys.Tenancy) (*Tracker, error) {
+	lister, ok := store.(storage.Lister)
+	if !ok {
+		return nil, ErrNotListable
+	}
+	return &Tracker{store: store, lister: lister, tenancy: tenancy, now: time.Now}, nil
+}
+
+// Start scans usage in the background whenever it is about to go stale,
+// so uploads rarely wait for a scan. Without Start, as in Lambda, usage is
+// scanned when it is needed.
+func (t *Tracker) Start() {
+	go func() {
+		for {
+			if err := t.Refresh(); err != nil {
+				log.Printf("[WARN] Tenant usage: scan failed: %v", err)
+			}
+			time.Sleep(MaxAge * 4 / 5)
+		}
+	}()
+}
+
+// Refresh scans the metadata of every paste and replaces the usage with
+// the result.
+func (t *Tracker) Refresh() error {
+	t.scanMu.Lock()
+	defer t.scanMu.Unlock()
+	started := t.now()
+	usage := map[string]*Usage{}
+	cursor := ""
+	for {
+		page, err := t.lister.List(storage.ListOptions{Cursor: cursor, Limit: 1000})
+		if err != nil {
+			return fmt.Errorf("list pastes: %w", err)
+		}
+		for id, r := range storage.GetBatch(t.store, page.IDs) {
+			if r.Err != nil && !errors.Is(r.Err, storage.ErrNotFound) {
+				return fmt.Errorf("read %s: %w", id, r.Err)
+			}
+			if r.Paste == nil || r.Paste.IsExpired() {
+				continue
+			}
+			u := usage[r.Paste.Tenant]
+			if u == nil {
+				u = &Usage{Tenant: r.Paste.Tenant}
+				usage[r.Paste.Tenant] = u
+			}
+			u.Pastes++
+			u.Bytes += r.Paste.Size
+			u.Reads += int64(r.Paste.ReadCount)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	t.mu.Lock()
+	t.usage, t.scannedAt = usage, started
+	t.mu.Unlock()
+	return nil
+}
+
+// fresh scans usage again when the last scan is older than MaxAge.
+func (t *Tracker) fresh() error {
+	t.mu.Lock()
+	stale := t.usage == nil || t.now().Sub(t.scannedAt) > MaxAge
+	t.mu.Unlock()
+	if !stale {
+		return nil
+	}
+	return t.Refresh()
+}
+
+// Reserve counts a new paste of size bytes against tenant, returning
+// ErrQuotaExceeded when it does not fit in the tenant's quota. When usage
+// cannot be scanned the upload is let through, so a storage hiccup does
+// not block every upload of the tenant.
+func (t *Tracker) Reserve(tenant string, size int64) error {
+	quota, limited := t.tenancy.Quota(tenant)
+	if limited {
+		if err := t.fresh(); err != nil {
+			log.Printf("[WARN] Tenant usage: cannot check the quota of %q: %v", tenant, err)
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.usage == nil {
+		return nil
+	}
+	u := t.usage[tenant]
+	if u == nil {
+		u = &Usage{Tenant: tenant}
+		t.usage[tenant] = u
+	}
+	if limited && u.Bytes+size > quota {
+		return fmt.Errorf("%w: %d of %d bytes used", ErrQuotaExceeded, u.Bytes, quota)
+	}
+	u.Pastes++
+	u.Bytes += size
+	return nil
+}
+
+// Release takes back a Reserve of size bytes for tenant whose paste was
+// not stored after all. A scan made in between may not have counted the
+// paste, so usage never drops below zero.
+func (t *Tracker) Release(tenant string, size int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u := t.usage[tenant]
+	if u == nil {
+		return
+	}
+	u.Pastes = max(u.Pastes-1, 0)
+	u.Bytes = max(u.Bytes-size, 0)
+}
+
+// Usage returns the usage of every tenant that has keys, a quota or
+// pastes, sorted by tenant with the default tenant first, and the time of
+// the scan it is based on.
+func (t *Tracker) Usage() ([]Usage, time.Time, error) {
+	if err := t.fresh(); err != nil {
+		return nil, time.Time{}, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	names := map[string]bool{}
+	for _, name := range t.tenancy.Names() {
+		names[name] = true
+	}
+	for name := range t.usage {
+		names[name] = true
+	}
+	all := make([]Usage, 0, len(names))
+	for name := range names {
+		all = append(all, t.usageOf(name))
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Tenant < all[j].Tenant })
+	return all, t.scannedAt, nil
+}
+
+// Total returns the usage of all tenants together, with Tenant and Quota
+// left empty, and the time of the scan it is based on.
+func (t *Tracker) Total() (Usage, time.Time, error) {
+	all, scannedAt, err := t.Usage()
+	if err != nil {
+		return Usage{}, time.Time{}, err
+	}
+	var total Usage
+	for _, u := range all {
+		total.Pastes += u.Pastes
+		total.Bytes += u.Bytes
+		total.Reads += u.Reads
+	}
+	return total, scannedAt, nil
+}
+
+// UsageOf returns the usage of tenant and the time of the scan it is
+// based on.
+func (t *Tracker) UsageOf(tenant string) (Usage, time.Time, error) {
+	if err := t.fresh(); err != nil {
+		return Usage{}, time.Time{}, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.usageOf(tenant), t.scannedAt, nil
+}
+
+// usageOf returns the usage of tenant with its quota. t.mu must be held.
+func (t *Tracker) usageOf(tenant string) Usage {
+	u := Usage{Tenant: tenant}
+	if scanned := t.usage[tenant]; scanned != nil {
+		u = *scanned
+	}
+	u.Quota, _ = t.tenancy.Quota(tenant)
+	return u
+}
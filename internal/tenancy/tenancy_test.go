@@ -0,0 +1,93 @@
+package tenancy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+func TestTracker(t *testing.T) {
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(keysFile, []byte("ci write tenant=eng\nmkt write tenant=marketing\n@eng quota=100\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tenants, err := apikeys.LoadTenancy(keysFile)
+	if err != nil {
+		t.Fatalf("LoadTenancy: %v", err)
+	}
+	expires := time.Now().Add(time.Hour)
+	expired := time.Now().Add(-time.Hour)
+	for _, p := range []*models.Paste{
+		{ID: "ENGAA", Tenant: "eng", Size: 60, ReadCount: 3, ExpiresAt: &expires},
+		{ID: "ENGBB", Tenant: "eng", Size: 500, ExpiresAt: &expired},
+		{ID: "DEFAA", Size: 7, ExpiresAt: &expires},
+	} {
+		if err := store.StoreContent(p.ID, make([]byte, p.Size)); err != nil {
+			t.Fatalf("StoreContent: %v", err)
+		}
+		if err := store.Store(p); err != nil {
+			t.Fatalf("Store: %v", err)
+		}
+	}
+
+	tr, err := New(store, tenants)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := tr.Reserve("eng", 50); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected 60+50 bytes to exceed a quota of 100, got %v", err)
+	}
+	if err := tr.Reserve("eng", 40); err != nil {
+		t.Errorf("expected 60+40 bytes to fit a quota of 100, got %v", err)
+	}
+	if err := tr.Reserve("eng", 1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected the reserved bytes to count, got %v", err)
+	}
+	if err := tr.Reserve("marketing", 1<<30); err != nil {
+		t.Errorf("expected a tenant without a quota to be unlimited, got %v", err)
+	}
+	tr.Release("eng", 40)
+	if err := tr.Reserve("eng", 40); err != nil {
+		t.Errorf("expected a released reservation to free its bytes, got %v", err)
+	}
+
+	all, _, err := tr.Usage()
+	if err != nil {
+		t.Fatalf("Usage: %v", err)
+	}
+	want := []Usage{
+		{Tenant: "", Pastes: 1, Bytes: 7},
+		{Tenant: "eng", Pastes: 2, Bytes: 100, Reads: 3, Quota: 100},
+		{Tenant: "marketing", Pastes: 1, Bytes: 1 << 30},
+	}
+	if len(all) != len(want) {
+		t.Fatalf("Usage() = %+v, want %+v", all, want)
+	}
+	for i := range want {
+		if all[i] != want[i] {
+			t.Errorf("Usage()[%d] = %+v, want %+v", i, all[i], want[i])
+		}
+	}
+
+	if total, _, err := tr.Total(); err != nil || total != (Usage{Pastes: 4, Bytes: 107 + 1<<30, Reads: 3}) {
+		t.Errorf("Total() = %+v, %v", total, err)
+	}
+
+	// A new scan replaces what was reserved with what is stored.
+	if err := tr.Refresh(); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if u, _, err := tr.UsageOf("eng"); err != nil || u.Pastes != 1 || u.Bytes != 60 {
+		t.Errorf("UsageOf(eng) after a scan = %+v, %v", u, err)
+	}
+}
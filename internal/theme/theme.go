@@ -0,0 +1,255 @@
+// Package theme loads the web UI's HTML templates and static assets,
+// letting operators override any of them from a directory, and carries the
+// branding every page shows.
+package theme
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+// DefaultSiteName is the site name shown when none is configured.
+const DefaultSiteName = "NCLIP"
+
+// Branding identifies the operator on every page. Empty fields keep the
+// built-in look.
+type Branding struct {
+	SiteName   string
+	LogoURL    string
+	FooterHTML template.HTML
+	ImprintURL string
+}
+
+// Name returns the configured site name, or DefaultSiteName.
+func (b Branding) Name() string {
+	if b.SiteName == "" {
+		return DefaultSiteName
+	}
+	return b.SiteName
+}
+
+// Title returns a page title with the built-in "NCLIP" prefix handlers use,
+// as in "NCLIP - Paste abc", replaced by the site name.
+func (b Branding) Title(title string) string {
+	if rest, ok := strings.CutPrefix(title, DefaultSiteName); ok {
+		return b.Name() + rest
+	}
+	return title
+}
+
+// Funcs returns the template functions exposing b: brand returns it and
+// title applies Title.
+func (b Branding) Funcs() template.FuncMap {
+	return template.FuncMap{
+		"brand": func() Branding { return b },
+		"title": b.Title,
+	}
+}
+
+// maxPrefixedSets bounds the template sets kept for forwarded path
+// prefixes; pages for further prefixes are rendered from a fresh copy.
+const maxPrefixedSets = 16
+
+// Templates is a gin HTML renderer for the *.html templates of a base
+// directory, where a same-named file in the override directory replaces a
+// template and other files there add templates. Reload swaps in a freshly
+// parsed set while requests are being rendered.
+//
+// Responses whose writer has a PathPrefix method, as a
+// forwarded.Writer does, are rendered with a path function that puts the
+// prefix in front of every link.
+type Templates struct {
+	baseDir     string
+	overrideDir string
+	funcs       template.FuncMap
+	current     atomic.Pointer[templateSet]
+	stamp       string
+}
+
+// templateSet is one parse of the templates.
+type templateSet struct {
+	// parsed is never executed, so it can still be cloned for a prefix.
+	parsed   *template.Template
+	plain    *template.Template
+	path     func(string) string
+	mu       sync.Mutex
+	prefixed map[string]*template.Template
+}
+
+// forPrefix returns the templates with links below prefix.
+func (s *templateSet) forPrefix(prefix string) (*template.Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tmpl, ok := s.prefixed[prefix]; ok {
+		return tmpl, nil
+	}
+	tmpl, err := s.parsed.Clone()
+	if err != nil {
+		return nil, err
+	}
+	tmpl.Funcs(template.FuncMap{"path": func(p string) string { return prefix + s.path(p) }})
+	if len(s.prefixed) < maxPrefixedSets {
+		s.prefixed[prefix] = tmpl
+	}
+	return tmpl, nil
+}
+
+// NewTemplates parses the templates of baseDir and overrideDir (which may
+// be empty) with funcs.
+func NewTemplates(baseDir, overrideDir string, funcs template.FuncMap) (*Templates, error) {
+	t := &Templates{baseDir: baseDir, overrideDir: overrideDir, funcs: funcs}
+	t.stamp = t.overrideStamp()
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Instance implements render.HTMLRender.
+func (t *Templates) Instance(name string, data any) render.Render {
+	return page{set: t.current.Load(), name: name, data: data}
+}
+
+// page renders a template once the response, and so the path prefix, is
+// known.
+type page struct {
+	set  *templateSet
+	name string
+	data any
+}
+
+func (p page) Render(w http.ResponseWriter) error {
+	tmpl := p.set.plain
+	if pw, ok := w.(interface{ PathPrefix() string }); ok && pw.PathPrefix() != "" {
+		var err error
+		if tmpl, err = p.set.forPrefix(pw.PathPrefix()); err != nil {
+			return err
+		}
+	}
+	return render.HTML{Template: tmpl, Name: p.name, Data: p.data}.Render(w)
+}
+
+func (p page) WriteContentType(w http.ResponseWriter) {
+	render.HTML{}.WriteContentType(w)
+}
+
+// Reload parses the templates again. On error the previous set stays in
+// use.
+func (t *Templates) Reload() error {
+	tmpl, err := template.New("").Funcs(t.funcs).ParseGlob(filepath.Join(t.baseDir, "*.html"))
+	if err != nil {
+		return err
+	}
+	if t.overrideDir != "" {
+		overrides, err := filepath.Glob(filepath.Join(t.overrideDir, "*.html"))
+		if err != nil {
+			return err
+		}
+		if len(overrides) > 0 {
+			if tmpl, err = tmpl.ParseFiles(overrides...); err != nil {
+				return fmt.Errorf("override templates: %w", err)
+			}
+		}
+	}
+	plain, err := tmpl.Clone()
+	if err != nil {
+		return err
+	}
+	path, ok := t.funcs["path"].(func(string) string)
+	if !ok {
+		path = func(p string) string { return p }
+	}
+	t.current.Store(&templateSet{parsed: tmpl, plain: plain, path: path, prefixed: map[string]*template.Template{}})
+	return nil
+}
+
+// Watch reloads the templates whenever a template in the override
+// directory is added, removed or modified, polling every interval until
+// stop is closed. Parse errors are logged and the previous set is kept.
+func (t *Templates) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if t.poll() {
+				log.Printf("Templates reloaded from %s", t.overrideDir)
+			}
+		}
+	}
+}
+
+// poll reloads the templates if the override directory changed since the
+// last load, reporting whether it did.
+func (t *Templates) poll() bool {
+	stamp := t.overrideStamp()
+	if stamp == t.stamp {
+		return false
+	}
+	t.stamp = stamp
+	if err := t.Reload(); err != nil {
+		log.Printf("[ERROR] Template reload failed, keeping the previous templates: %v", err)
+		return false
+	}
+	return true
+}
+
+// overrideStamp summarizes the names, sizes and modification times of the
+// override templates, so any change to them changes it.
+func (t *Templates) overrideStamp() string {
+	if t.overrideDir == "" {
+		return ""
+	}
+	names, err := filepath.Glob(filepath.Join(t.overrideDir, "*.html"))
+	if err != nil {
+		return ""
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", filepath.Base(name), info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String()
+}
+
+// Assets serves the files of baseDir, preferring those of overrideDir
+// (which may be empty). Like gin's Static, directories are not listed.
+func Assets(baseDir, overrideDir string) http.FileSystem {
+	fs := assets{gin.Dir(baseDir, false)}
+	if overrideDir != "" {
+		fs = append(assets{gin.Dir(overrideDir, false)}, fs...)
+	}
+	return fs
+}
+
+// assets is a stack of file systems, searched in order.
+type assets []http.FileSystem
+
+func (a assets) Open(name string) (http.File, error) {
+	var err error
+	for _, fs := range a {
+		var f http.File
+		if f, err = fs.Open(name); err == nil {
+			return f, nil
+		}
+	}
+	return nil, err
+}
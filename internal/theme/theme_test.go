@@ -0,0 +1,138 @@
+package theme
+
+import (
+	"html/template"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+}
+
+func renderPage(t *testing.T, templates *Templates, name string, data any) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	if err := templates.Instance(name, data).Render(w); err != nil {
+		t.Fatalf("render %s: %v", name, err)
+	}
+	return w.Body.String()
+}
+
+func TestTemplates_Overrides(t *testing.T) {
+	base, override := t.TempDir(), t.TempDir()
+	writeFile(t, base, "page.html", `<title>{{title .}}</title>{{template "footer"}}`)
+	writeFile(t, base, "layout.html", `{{define "footer"}}built-in{{end}}`)
+
+	b := Branding{SiteName: "Acme Paste", FooterHTML: template.HTML("<b>&copy; Acme</b>")}
+	templates, err := NewTemplates(base, override, b.Funcs())
+	if err != nil {
+		t.Fatalf("NewTemplates failed: %v", err)
+	}
+	if got := renderPage(t, templates, "page.html", "NCLIP - Paste abc"); got != "<title>Acme Paste - Paste abc</title>built-in" {
+		t.Errorf("unexpected page: %q", got)
+	}
+
+	// A changed override is picked up by the next poll.
+	writeFile(t, override, "layout.html", `{{define "footer"}}{{brand.FooterHTML}}{{end}}`)
+	if !templates.poll() {
+		t.Fatal("expected the new override to reload the templates")
+	}
+	if got := renderPage(t, templates, "page.html", "x"); !strings.HasSuffix(got, "<b>&copy; Acme</b>") {
+		t.Errorf("expected the overridden footer, got %q", got)
+	}
+	if templates.poll() {
+		t.Error("expected no reload without changes")
+	}
+
+	// A broken override is reported and the previous templates stay.
+	writeFile(t, override, "page.html", `{{if}}`)
+	if templates.poll() {
+		t.Error("expected a broken override not to reload")
+	}
+	if got := renderPage(t, templates, "page.html", "x"); !strings.HasPrefix(got, "<title>x</title>") {
+		t.Errorf("expected the previous templates, got %q", got)
+	}
+}
+
+func TestTemplates_Watch(t *testing.T) {
+	base, override := t.TempDir(), t.TempDir()
+	writeFile(t, base, "page.html", "old")
+	templates, err := NewTemplates(base, override, nil)
+	if err != nil {
+		t.Fatalf("NewTemplates failed: %v", err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go templates.Watch(10*time.Millisecond, stop)
+
+	writeFile(t, override, "page.html", "new")
+	deadline := time.Now().Add(2 * time.Second)
+	for renderPage(t, templates, "page.html", nil) != "new" {
+		if time.Now().After(deadline) {
+			t.Fatal("override was not picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// prefixedRecorder is a response writer of a request with a forwarded
+// path prefix.
+type prefixedRecorder struct {
+	*httptest.ResponseRecorder
+	prefix string
+}
+
+func (w prefixedRecorder) PathPrefix() string { return w.prefix }
+
+func TestTemplates_PathPrefix(t *testing.T) {
+	base := t.TempDir()
+	writeFile(t, base, "page.html", `<a href="{{path "/raw/ABC"}}">`)
+	templates, err := NewTemplates(base, "", template.FuncMap{"path": func(p string) string { return "/paste" + p }})
+	if err != nil {
+		t.Fatalf("NewTemplates failed: %v", err)
+	}
+	for _, prefix := range []string{"/tools", "", "/tools", "/other"} {
+		w := prefixedRecorder{httptest.NewRecorder(), prefix}
+		if err := templates.Instance("page.html", nil).Render(w); err != nil {
+			t.Fatalf("render for %q: %v", prefix, err)
+		}
+		if want := `<a href="` + prefix + `/paste/raw/ABC">`; w.Body.String() != want {
+			t.Errorf("prefix %q: expected %q, got %q", prefix, want, w.Body.String())
+		}
+	}
+	if got := renderPage(t, templates, "page.html", nil); got != `<a href="/paste/raw/ABC">` {
+		t.Errorf("expected no prefix without one, got %q", got)
+	}
+}
+
+func TestAssets(t *testing.T) {
+	base, override := t.TempDir(), t.TempDir()
+	writeFile(t, base, "style.css", "base")
+	writeFile(t, base, "script.js", "script")
+	writeFile(t, override, "style.css", "override")
+
+	fs := Assets(base, override)
+	for name, want := range map[string]string{"/style.css": "override", "/script.js": "script"} {
+		f, err := fs.Open(name)
+		if err != nil {
+			t.Fatalf("Open(%s): %v", name, err)
+		}
+		got, _ := io.ReadAll(f)
+		_ = f.Close()
+		if string(got) != want {
+			t.Errorf("%s: expected %q, got %q", name, want, got)
+		}
+	}
+	if _, err := fs.Open("/missing.css"); err == nil {
+		t.Error("expected an error for a missing asset")
+	}
+}
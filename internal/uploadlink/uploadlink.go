@@ -0,0 +1,127 @@
+package uploadlink
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/hmackeys"
+)
+
+// Errors returned by Decode.
+var (
+	ErrInvalid = errors.New("invalid upload link")
+	ErrExpired = errors.New("upload link has expired")
+)
+
+// Bounds for the validity period of a link.
+const (
+	DefaultValidity = 24 * time.Hour
+	MaxValidity     = 7 * 24 * time.Hour
+)
+
+// Link grants one paste upload without an API key. Links are stateless:
+// the constraints travel inside the signed token, and only the fact that a
+// link was used is recorded in storage.
+type Link struct {
+	// ID identifies the link for single-use tracking and auditing.
+	ID string `json:"id"`
+	// Expires is when the link stops accepting uploads (Unix seconds).
+	Expires int64 `json:"exp"`
+	// MaxSize is the largest upload accepted, in bytes.
+	MaxSize int64 `json:"max"`
+	// TTL is the lifetime of the created paste, in seconds.
+	TTL int64 `json:"ttl"`
+	// Burn makes the created paste burn-after-read.
+	Burn bool `json:"burn,omitempty"`
+}
+
+// ExpiresAt returns Expires as a time.
+func (l *Link) ExpiresAt() time.Time {
+	return time.Unix(l.Expires, 0)
+}
+
+// PasteTTL returns TTL as a duration.
+func (l *Link) PasteTTL() time.Duration {
+	return time.Duration(l.TTL) * time.Second
+}
+
+// MarkerSuffix ends the storage ids of link markers.
+const MarkerSuffix = ".link"
+
+// MarkerID is the storage id of the content object recording that the link
+// id was used. Markers are tiny and outlive the paste, so burning or
+// deleting the paste never makes its link reusable. A marker is written
+// before its link expires, which is at most MaxValidity after it was
+// issued, so one older than MaxValidity is no longer needed.
+func MarkerID(id string) string {
+	return id + MarkerSuffix
+}
+
+// Signer issues and verifies upload link tokens.
+type Signer struct {
+	keys *hmackeys.Ring
+	now  func() time.Time
+}
+
+// NewSigner creates a Signer signing with keys.
+func NewSigner(keys *hmackeys.Ring) *Signer {
+	return &Signer{keys: keys, now: time.Now}
+}
+
+// New creates a link valid for validity with the given constraints.
+func (s *Signer) New(validity time.Duration, maxSize int64, ttl time.Duration, burn bool) (*Link, error) {
+	var id [12]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	return &Link{
+		ID:      hex.EncodeToString(id[:]),
+		Expires: s.now().Add(validity).Unix(),
+		MaxSize: maxSize,
+		TTL:     int64(ttl / time.Second),
+		Burn:    burn,
+	}, nil
+}
+
+// Encode serializes l as "<payload>.<signature>", the payload base64url
+// and the signature as made by hmackeys.Ring.Sign.
+func (s *Signer) Encode(l *Link) string {
+	data, _ := json.Marshal(l)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + s.keys.Sign(linkMessage(payload))
+}
+
+// Decode verifies token and returns its link. It returns ErrInvalid for
+// malformed or forged tokens and ErrExpired once the link has expired.
+func (s *Signer) Decode(token string) (*Link, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalid
+	}
+	if !s.keys.Verify(linkMessage(payload), sig) {
+		return nil, ErrInvalid
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	var l Link
+	if err := json.Unmarshal(data, &l); err != nil || l.ID == "" || l.MaxSize <= 0 || l.TTL <= 0 {
+		return nil, ErrInvalid
+	}
+	if !s.now().Before(l.ExpiresAt()) {
+		return nil, ErrExpired
+	}
+	return &l, nil
+}
+
+// linkMessage is the message a link signs. The prefix separates link
+// signatures from the session cookies signed with the same keys.
+func linkMessage(payload string) string {
+	return "upload-link:" + payload
+}
@@ -0,0 +1,49 @@
+package uploadlink
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/hmackeys"
+)
+
+func TestSigner_EncodeDecode(t *testing.T) {
+	s := NewSigner(hmackeys.New("secret", nil))
+	link, err := s.New(time.Hour, 1024, 2*time.Hour, true)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	token := s.Encode(link)
+
+	got, err := s.Decode(token)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if *got != *link || got.PasteTTL() != 2*time.Hour {
+		t.Errorf("expected %+v, got %+v", link, got)
+	}
+
+	payload, sig, _ := strings.Cut(token, ".")
+	forged := *link
+	forged.MaxSize = 1 << 30
+	forgedPayload, _, _ := strings.Cut(s.Encode(&forged), ".")
+	for name, bad := range map[string]string{
+		"tampered signature": payload + "." + sig + "x",
+		"swapped payload":    forgedPayload + "." + sig,
+		"no signature":       payload,
+	} {
+		if _, err := s.Decode(bad); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: expected ErrInvalid, got %v", name, err)
+		}
+	}
+	if _, err := NewSigner(hmackeys.New("other", nil)).Decode(token); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected token signed with another secret to be rejected, got %v", err)
+	}
+
+	s.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err := s.Decode(token); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired, got %v", err)
+	}
+}
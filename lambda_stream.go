@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+)
+
+// functionURLDomainMarker identifies Lambda Function URL hosts
+// (<url-id>.lambda-url.<region>.on.aws). HTTP API events share the v2
+// payload format but use execute-api domains, which cannot stream.
+const functionURLDomainMarker = ".lambda-url."
+
+// isFunctionURLRequest reports whether a v2 event came from a Lambda
+// Function URL rather than an API Gateway HTTP API.
+func isFunctionURLRequest(req events.APIGatewayV2HTTPRequest) bool {
+	return strings.Contains(req.RequestContext.DomainName, functionURLDomainMarker)
+}
+
+// shouldStream reports whether the response for req should use Lambda
+// response streaming. Only /raw and /download responses, and those of
+// their /r and /d aliases, are streamed, and only when the deployment has
+// opted in, because a Function URL in BUFFERED invoke mode cannot decode a
+// streaming response.
+func shouldStream(cfg *config.Config, req events.APIGatewayV2HTTPRequest) bool {
+	if cfg == nil || !cfg.LambdaStreaming || !isFunctionURLRequest(req) {
+		return false
+	}
+	method := req.RequestContext.HTTP.Method
+	if method != http.MethodGet && method != http.MethodHead {
+		return false
+	}
+	path, ok := strings.CutPrefix(req.RawPath, cfg.RoutePrefix)
+	if !ok {
+		return false
+	}
+	for _, route := range []string{"/raw/", "/download/", "/r/", "/d/"} {
+		if strings.HasPrefix(path, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveStreaming runs req through router and returns a streaming response
+// whose body is fed by the handler as it writes. It returns as soon as the
+// handler has committed its status and headers.
+func serveStreaming(router *gin.Engine, req *http.Request) *events.LambdaFunctionURLStreamingResponse {
+	pr, pw := io.Pipe()
+	w := newStreamingResponseWriter(pw)
+	go func() {
+		defer w.finish()
+		router.ServeHTTP(w, req)
+	}()
+	<-w.ready
+
+	resp := &events.LambdaFunctionURLStreamingResponse{
+		StatusCode: w.status,
+		Headers:    make(map[string]string, len(w.snapshot)),
+		Body:       pr,
+	}
+	for k, v := range w.snapshot {
+		if k == "Set-Cookie" {
+			resp.Cookies = append(resp.Cookies, v...)
+			continue
+		}
+		resp.Headers[k] = strings.Join(v, ",")
+	}
+	return resp
+}
+
+// streamingResponseWriter is an http.ResponseWriter that pipes the body to
+// the Lambda runtime. Headers are snapshotted when the status is written,
+// since the streaming prelude is sent before any body bytes.
+type streamingResponseWriter struct {
+	header   http.Header
+	snapshot http.Header
+	status   int
+	pw       *io.PipeWriter
+	ready    chan struct{}
+	once     sync.Once
+}
+
+func newStreamingResponseWriter(pw *io.PipeWriter) *streamingResponseWriter {
+	return &streamingResponseWriter{
+		header: make(http.Header),
+		pw:     pw,
+		ready:  make(chan struct{}),
+	}
+}
+
+// Header returns the response headers.
+func (w *streamingResponseWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader commits the status and headers; later calls are ignored.
+func (w *streamingResponseWriter) WriteHeader(status int) {
+	w.once.Do(func() {
+		w.status = status
+		w.snapshot = w.header.Clone()
+		close(w.ready)
+	})
+}
+
+// Write commits an implicit 200 status if needed and streams b.
+func (w *streamingResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.pw.Write(b)
+}
+
+// Flush implements http.Flusher. Writes go straight to the pipe, so there
+// is nothing to flush beyond committing the headers.
+func (w *streamingResponseWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+}
+
+// finish commits the headers if the handler wrote nothing and closes the
+// body so the runtime completes the response.
+func (w *streamingResponseWriter) finish() {
+	w.WriteHeader(http.StatusOK)
+	_ = w.pw.Close()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+)
+
+func v2Request(domain, method, path string) events.APIGatewayV2HTTPRequest {
+	req := events.APIGatewayV2HTTPRequest{RawPath: path}
+	req.RequestContext.DomainName = domain
+	req.RequestContext.HTTP.Method = method
+	return req
+}
+
+func TestShouldStream(t *testing.T) {
+	enabled := &config.Config{LambdaStreaming: true}
+	prefixed := &config.Config{LambdaStreaming: true, RoutePrefix: "/paste"}
+	furl := "abc123.lambda-url.us-east-1.on.aws"
+	apigw := "abc123.execute-api.us-east-1.amazonaws.com"
+
+	cases := []struct {
+		name string
+		cfg  *config.Config
+		req  events.APIGatewayV2HTTPRequest
+		want bool
+	}{
+		{"function url raw", enabled, v2Request(furl, "GET", "/raw/ABCDE"), true},
+		{"function url head", enabled, v2Request(furl, "HEAD", "/raw/ABCDE"), true},
+		{"disabled", &config.Config{}, v2Request(furl, "GET", "/raw/ABCDE"), false},
+		{"nil config", nil, v2Request(furl, "GET", "/raw/ABCDE"), false},
+		{"api gateway", enabled, v2Request(apigw, "GET", "/raw/ABCDE"), false},
+		{"function url download", enabled, v2Request(furl, "GET", "/download/ABCDE"), true},
+		{"not raw", enabled, v2Request(furl, "GET", "/ABCDE"), false},
+		{"upload", enabled, v2Request(furl, "POST", "/raw/ABCDE"), false},
+		{"raw alias", enabled, v2Request(furl, "GET", "/r/ABCDE"), true},
+		{"route prefix", prefixed, v2Request(furl, "GET", "/paste/d/ABCDE"), true},
+		{"outside route prefix", prefixed, v2Request(furl, "GET", "/raw/ABCDE"), false},
+	}
+	for _, tc := range cases {
+		if got := shouldStream(tc.cfg, tc.req); got != tc.want {
+			t.Errorf("%s: shouldStream = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestServeStreaming(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/raw/:slug", func(c *gin.Context) {
+		c.SetCookie("a", "1", 0, "/", "", false, false)
+		c.SetCookie("b", "2", 0, "/", "", false, false)
+		c.Header("Content-Type", "text/plain")
+		c.Status(http.StatusOK)
+		for i := 0; i < 3; i++ {
+			_, _ = c.Writer.Write([]byte("chunk"))
+			c.Writer.Flush()
+		}
+	})
+
+	resp := serveStreaming(router, httptest.NewRequest("GET", "/raw/ABCDE", nil))
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Headers["Content-Type"]; ct != "text/plain" {
+		t.Errorf("expected text/plain content type, got %q", ct)
+	}
+	if len(resp.Cookies) != 2 {
+		t.Errorf("expected 2 cookies, got %v", resp.Cookies)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	if string(body) != "chunkchunkchunk" {
+		t.Errorf("unexpected body %q", body)
+	}
+}
+
+func TestServeStreaming_NoBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	resp := serveStreaming(router, httptest.NewRequest("GET", "/raw/NPE23", nil))
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", resp.StatusCode)
+	}
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+}
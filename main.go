@@ -3,12 +3,19 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -19,9 +26,46 @@ import (
 	"github.com/johnwmail/nclip/handlers"
 	"github.com/johnwmail/nclip/handlers/retrieval"
 	"github.com/johnwmail/nclip/handlers/upload"
+	"github.com/johnwmail/nclip/internal/access"
+	"github.com/johnwmail/nclip/internal/apierror"
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/burnnotify"
+	"github.com/johnwmail/nclip/internal/cdnpurge"
+	"github.com/johnwmail/nclip/internal/certs"
+	"github.com/johnwmail/nclip/internal/changes"
+	"github.com/johnwmail/nclip/internal/egress"
+	"github.com/johnwmail/nclip/internal/emailin"
+	"github.com/johnwmail/nclip/internal/forwarded"
+	"github.com/johnwmail/nclip/internal/hmackeys"
+	"github.com/johnwmail/nclip/internal/hotkeys"
+	"github.com/johnwmail/nclip/internal/janitor"
+	"github.com/johnwmail/nclip/internal/keyring"
+	"github.com/johnwmail/nclip/internal/mirror"
+	"github.com/johnwmail/nclip/internal/multipartspool"
+	"github.com/johnwmail/nclip/internal/pow"
+	"github.com/johnwmail/nclip/internal/presignupload"
+	"github.com/johnwmail/nclip/internal/push"
+	"github.com/johnwmail/nclip/internal/ratelimit"
+	"github.com/johnwmail/nclip/internal/redisstore"
+	"github.com/johnwmail/nclip/internal/reencrypt"
+	"github.com/johnwmail/nclip/internal/respheaders"
+	"github.com/johnwmail/nclip/internal/scaling"
 	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/internal/session"
+	"github.com/johnwmail/nclip/internal/settings"
+	"github.com/johnwmail/nclip/internal/signing"
+	"github.com/johnwmail/nclip/internal/slashcmd"
+	"github.com/johnwmail/nclip/internal/slugmask"
+	"github.com/johnwmail/nclip/internal/tcpserver"
+	"github.com/johnwmail/nclip/internal/tenancy"
+	"github.com/johnwmail/nclip/internal/theme"
+	"github.com/johnwmail/nclip/internal/uploadlink"
 	"github.com/johnwmail/nclip/storage"
 	"github.com/johnwmail/nclip/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/quic-go/quic-go/http3"
 
 	// Lambda imports (only used when in Lambda mode)
 	"github.com/aws/aws-lambda-go/events"
@@ -36,27 +80,96 @@ var (
 	CommitHash = "none"
 )
 
+// readOnlyRetryAfter is the Retry-After, in seconds, of writes refused
+// while the deployment is read-only.
+const readOnlyRetryAfter = "60"
+
+// templateWatchInterval is how often the override directory is checked
+// for changed templates.
+const templateWatchInterval = 2 * time.Second
+
+// keysWatchInterval is how often the API keys file is checked for
+// changes made by hand.
+const keysWatchInterval = 5 * time.Second
+
 // Lambda-specific variables
 var (
 	ginLambdaV1   *ginadapter.GinLambda
 	ginLambdaV2   *ginadapter.GinLambdaV2
 	ginLambdaOnce sync.Once
+	// lambdaRouter and lambdaConfig back the response streaming path,
+	// which bypasses the buffered proxy adapters.
+	lambdaRouter *gin.Engine
+	lambdaConfig *config.Config
 )
 
+// sharedState holds the rate limit windows, used proof-of-work nonces and
+// handled inbound emails of all instances when NCLIP_REDIS_URL is set; nil keeps them in memory.
+var sharedState *redisstore.Client
+
+// runtimeSettings are the settings admins change through the admin API,
+// loaded from and polled in the store; nil outside serve.
+var runtimeSettings *settings.Runtime
+
 // isLambdaEnvironment detects if running in AWS Lambda
 func isLambdaEnvironment() bool {
 	return os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != ""
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfigCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "service" {
+		os.Exit(runServiceCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate-metadata" {
+		os.Exit(runMigrateCommand(os.Args[2:], os.Stdout, os.Stderr, os.Getenv))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate-tenants" {
+		os.Exit(runMigrateTenantsCommand(os.Args[2:], os.Stdout, os.Stderr, os.Getenv))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		os.Exit(runAuditCommand(os.Args[2:], os.Stdout, os.Stderr, os.Getenv))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "policy" {
+		os.Exit(runPolicyCommand(os.Args[2:], os.Stdout, os.Stderr, os.Getenv))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runCheckCommand(os.Args[2:], os.Stdout, os.Stderr, os.Getenv))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(runImportCommand(os.Args[2:], os.Stdout, os.Stderr, os.Getenv))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "push" {
+		os.Exit(runPushCommand(os.Args[2:], os.Stdin, os.Stdout, os.Stderr, os.Getenv, shutdownSignal()))
+	}
+
+	// Load configuration
+	cfg := config.LoadConfig()
+	serve(cfg, shutdownSignal())
+}
 
+// shutdownSignal returns a channel that is closed on SIGINT or SIGTERM.
+func shutdownSignal() <-chan struct{} {
+	stop := make(chan struct{})
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-quit
+		close(stop)
+	}()
+	return stop
+}
+
+// serve runs nclip with cfg until stop is closed. In Lambda mode it never
+// returns.
+func serve(cfg *config.Config, stop <-chan struct{}) {
 	// Print version/build info at startup
 	log.Printf("NCLIP Version: %s", Version)
 	log.Printf("Build Time:    %s", BuildTime)
 	log.Printf("Commit Hash:   %s", CommitHash)
 
-	// Load configuration
-	cfg := config.LoadConfig()
 	cfg.Version = Version
 	cfg.BuildTime = BuildTime
 	cfg.CommitHash = CommitHash
@@ -69,16 +182,34 @@ func main() {
 	// Print the NCLIP_UPLOAD_AUTH settings at startup
 	log.Printf("Upload Authentication Enabled: %v", cfg.UploadAuth)
 	if cfg.UploadAuth {
-		// Print the number of configured API keys without exposing them
-		// Count and log the number of non-empty API keys (do not print the keys themselves)
-		keys := strings.Split(cfg.APIKeys, ",")
-		numKeys := 0
-		for _, k := range keys {
-			if strings.TrimSpace(k) != "" {
-				numKeys++
-			}
+		// Log the number of configured API keys without exposing them
+		if keys, err := apikeys.Load(cfg.APIKeys, cfg.APIKeysFile); err == nil {
+			log.Printf("Configured API Keys: %d", len(keys))
 		}
-		log.Printf("Configured API Keys: %d", numKeys)
+	}
+	if cfg.PoWDifficulty > 0 {
+		log.Printf("Proof of work required for uploads without an API key: %d bits", cfg.PoWDifficulty)
+	}
+
+	// Per-instance state is reported for every scaled-out deployment;
+	// NCLIP_DIAGNOSE_SCALING makes the findings that break one fatal.
+	findings := scaling.Diagnose(cfg, isLambdaEnvironment())
+	for _, f := range findings {
+		log.Printf("[%s] Scaling: %s", f.Severity, f)
+	}
+	if cfg.DiagnoseScaling {
+		if scaling.Failed(findings) {
+			log.Fatalf("Scaling diagnostics failed for %d instances; fix the errors above or unset NCLIP_DIAGNOSE_SCALING", cfg.Instances)
+		}
+		log.Printf("Scaling diagnostics passed for %d instances with %d warnings", cfg.Instances, len(findings))
+	}
+	if cfg.RedisURL != "" {
+		client, err := redisstore.Open(cfg.RedisURL)
+		if err != nil {
+			log.Fatalf("Failed to initialize shared state: %v", err)
+		}
+		sharedState = client
+		log.Printf("Shared state enabled: rate limits and proof-of-work nonces are kept in Redis")
 	}
 
 	// Aggressive logging: print all environment variables
@@ -94,35 +225,194 @@ func main() {
 		log.Printf("[DEBUG] Loaded config: %+v", cfg)
 	}
 
+	// Outbound clients trust the extra roots from here on.
+	if n, err := egress.UseCABundle(cfg.CABundle); err != nil {
+		log.Fatalf("Failed to load CA bundle: %v", err)
+	} else if n > 0 {
+		log.Printf("Trusting %d extra CA certificates from %s for outbound TLS", n, cfg.CABundle)
+	}
+
 	// Initialize storage backend based on deployment mode
 	var store storage.PasteStore
 	var err error
 
-	if isLambdaEnvironment() {
+	if cfg.IsMemory() {
+		// Demos and CI: nothing outlives the process.
+		store = storage.NewMemoryStore()
+		log.Printf("Using in-memory storage: pastes are lost when nclip exits")
+	} else if cfg.MongoURI != "" {
+		// MongoDB in either mode
+		store, err = storage.NewMongoStore(cfg.MongoURI, cfg.MongoDatabase)
+		if err != nil {
+			log.Fatalf("Failed to initialize MongoDB storage: %v", err)
+		}
+		log.Printf("Using MongoDB storage, database: %s", cfg.MongoDatabase)
+	} else if isLambdaEnvironment() {
 		// Lambda mode: Use S3
-		store, err = storage.NewS3Store(cfg.S3Bucket, cfg.S3Prefix)
+		s3Store, err := storage.NewS3StoreWithOptions(cfg.S3Bucket, cfg.S3Prefix, "", cfg.S3ClientOptions())
 		if err != nil {
 			log.Fatalf("Failed to initialize S3 storage for Lambda: %v", err)
 		}
+		// A proxy or CA that keeps the function from reaching the bucket
+		// fails the cold start with a hint rather than every request.
+		if err := s3Store.CheckConnection(); err != nil {
+			log.Fatalf("Failed to reach S3: %v", err)
+		}
+		// Config validation has already checked the mode.
+		mode, _ := storage.ParseReadCountMode(cfg.S3ReadCounting)
+		s3Store.SetReadCounting(mode, cfg.S3ReadFlushInterval)
+		if err := s3Store.SetPutOptions(cfg.S3PutOptions()); err != nil {
+			log.Fatalf("Invalid S3 object settings: %v", err)
+		}
+		// A KMS key or bucket policy that rejects the configured writes
+		// fails the cold start rather than every upload.
+		if !cfg.S3PutOptions().IsZero() && !cfg.IsReplica() {
+			if err := s3Store.CheckWrite(); err != nil {
+				log.Fatalf("S3 bucket rejects writes with the configured object settings: %v", err)
+			}
+		}
+		if cfg.S3SlugIndex && !cfg.IsReplica() {
+			s3Store.EnableSlugIndex()
+		}
+		// Connections opened during the init phase are ready when the first
+		// invocation arrives.
+		if cfg.S3PrewarmConns > 0 {
+			start := time.Now()
+			n := s3Store.Prewarm(cfg.S3PrewarmConns)
+			log.Printf("Prewarmed %d of %d S3 connections in %s", n, cfg.S3PrewarmConns, time.Since(start).Round(time.Millisecond))
+		}
+		store = s3Store
 		if utils.IsDebugEnabled() {
 			log.Printf("S3 Bucket: %s", cfg.S3Bucket)
 			log.Printf("S3 Prefix: %s", cfg.S3Prefix)
 		}
-		log.Println("Lambda mode: Using S3 storage")
+		log.Printf("Lambda mode: Using S3 storage, read counting: %s", mode)
 	} else {
 		// Server mode: Use filesystem. Use configured DataDir.
-		store, err = storage.NewFilesystemStore(cfg.DataDir)
+		fsStore, err := storage.NewFilesystemStore(cfg.DataDir)
 		if err != nil {
 			log.Fatalf("Failed to initialize filesystem storage: %v", err)
 		}
-		log.Println("Server mode: Using filesystem storage")
+		fsStore.SetFsync(cfg.Fsync)
+		store = fsStore
+		log.Printf("Server mode: Using filesystem storage, fsync: %v", cfg.Fsync)
 		if utils.IsDebugEnabled() {
 			log.Printf("Listening on port: %d", cfg.Port)
 		}
 	}
 
+	if cfg.IsReplica() {
+		if ro, ok := store.(storage.ReadOnlySetter); ok {
+			ro.SetReadOnly(true)
+		}
+		if cfg.WriterURL == "" {
+			log.Printf("[WARN] Replica mode without NCLIP_WRITER_URL: writes will be rejected without a redirect target")
+		}
+		log.Printf("Replica mode: storage is read-only, writer: %s", cfg.WriterURL)
+	}
+
+	if cfg.ACMEEnabled() && isLambdaEnvironment() {
+		log.Printf("[WARN] NCLIP_ACME_DOMAINS is ignored in Lambda mode: API Gateway or the function URL terminates TLS")
+	}
+
+	if cfg.MetricsPort != 0 {
+		if isLambdaEnvironment() {
+			log.Printf("[WARN] NCLIP_METRICS_PORT is ignored in Lambda mode: use CloudWatch metrics instead")
+		} else {
+			store = storage.NewInstrumentedStore(store, backendName(store), storage.NewStoreMetrics(prometheus.DefaultRegisterer))
+			prometheus.MustRegister(ratelimit.Collector(), upload.Collector())
+			prometheus.MustRegister(ratelimit.ConcurrencyCollectors()...)
+		}
+	}
+
+	// Load shedding watches the backend through the instrumented store,
+	// which runs without Prometheus when metrics are off, as in Lambda.
+	if cfg.ShedErrorPercent > 0 || cfg.ShedLatency > 0 {
+		is, ok := store.(*storage.InstrumentedStore)
+		if !ok {
+			is = storage.NewInstrumentedStore(store, backendName(store), nil)
+			store = is
+		}
+		is.SetHealth(storage.NewHealthTracker(cfg.ShedErrorPercent, cfg.ShedLatency, cfg.ShedWindow))
+		log.Printf("Load shedding enabled: uploads are rejected above %d%% storage errors or %s average latency over %s",
+			cfg.ShedErrorPercent, cfg.ShedLatency, cfg.ShedWindow)
+	}
+
+	// Each tenant's pastes are stored under a prefix of its own. Everything
+	// above sees slugs, and encryption binds content to them, so pastes
+	// keep decrypting when they move to their tenant's prefix.
+	store = storage.NewTenantStore(store)
+
+	// The sync journal sits below the spool, so spooled uploads are recorded
+	// once the backend has them and mirrors can copy them.
+	if cfg.SyncJournal != "" {
+		switch {
+		case isLambdaEnvironment():
+			log.Printf("[WARN] NCLIP_SYNC_JOURNAL is ignored in Lambda mode: the journal needs a persistent local disk")
+		case cfg.IsReplica():
+			log.Printf("[WARN] NCLIP_SYNC_JOURNAL is ignored on replicas: they never write")
+		default:
+			journal, err := changes.Open(cfg.SyncJournal, changes.DefaultRetain)
+			if err != nil {
+				log.Fatalf("Failed to open sync journal: %v", err)
+			}
+			store = storage.NewJournaledStore(store, journal)
+			log.Printf("Sync feed enabled, journal: %s (at change %d)", cfg.SyncJournal, journal.Head())
+		}
+	}
+
+	// CDN purging sits below the spool too, so a paste is purged once the
+	// backend no longer has it.
+	if purger := newCDNPurger(cfg); purger != nil {
+		store = storage.NewPurgingStore(store, purger)
+	}
+
+	if cfg.SpoolDir != "" {
+		switch {
+		case isLambdaEnvironment():
+			log.Printf("[WARN] NCLIP_SPOOL_DIR is ignored in Lambda mode: there is no persistent local disk")
+		case cfg.IsReplica():
+			log.Printf("[WARN] NCLIP_SPOOL_DIR is ignored on replicas: they never write")
+		default:
+			spool, err := storage.NewSpoolStore(store, cfg.SpoolDir, cfg.SpoolMaxSize)
+			if err != nil {
+				log.Fatalf("Failed to initialize upload spool: %v", err)
+			}
+			spool.Start()
+			store = spool
+			log.Printf("Upload spool enabled: %s (max %d bytes)", cfg.SpoolDir, cfg.SpoolMaxSize)
+		}
+	}
+
+	// Encryption wraps the spool too, so spooled uploads are encrypted on
+	// local disk.
+	if cfg.EncryptionKeys != "" {
+		keys, err := keyring.Parse(cfg.EncryptionKeys)
+		if err != nil {
+			log.Fatalf("Failed to load encryption keys: %v", err)
+		}
+		store = storage.NewEncryptedStore(store, keys)
+		log.Printf("Content encryption enabled, current key: %s", keys.Current())
+	}
+
+	auditLog, err := audit.Open(cfg.AuditLog, cfg.AuditMaxSize, cfg.AuditMaxBackups)
+	if err != nil {
+		log.Fatalf("Failed to initialize audit log: %v", err)
+	}
+	if auditLog != nil {
+		log.Printf("Audit log enabled: %s", cfg.AuditLog)
+	}
+
+	// Runtime settings are kept in the store, so a change made through
+	// any instance reaches the others on their next poll.
+	runtimeSettings = settings.New(store, settings.FromConfig(cfg))
+	if err := runtimeSettings.Reload(); err != nil {
+		log.Printf("[ERROR] Failed to load runtime settings, using the configured ones: %v", err)
+	}
+	go runtimeSettings.Watch(settings.PollInterval, stop)
+
 	// Setup router
-	router := setupRouter(store, cfg)
+	router := setupRouter(store, cfg, auditLog)
 
 	// Check if running in Lambda environment
 	if isLambdaEnvironment() {
@@ -131,13 +421,15 @@ func main() {
 			ginLambdaV1 = ginadapter.New(router)
 			ginLambdaV2 = ginadapter.NewV2(router)
 		})
+		lambdaRouter = router
+		lambdaConfig = cfg
 		lambda.Start(lambdaHandler)
 		return
 	}
 
 	// Run in container/server mode
 	log.Println("Starting in HTTP server mode")
-	runHTTPServer(router, cfg, store)
+	runHTTPServer(router, cfg, store, auditLog, stop)
 }
 
 // lambdaHandler handles Lambda requests for both v1 and v2 formats
@@ -170,6 +462,15 @@ func lambdaHandler(ctx context.Context, event interface{}) (interface{}, error)
 	if err := json.Unmarshal(eventBytes, &reqV2); err == nil && reqV2.RequestContext.HTTP.Method != "" {
 		log.Printf("Handling as APIGatewayV2HTTPRequest (Lambda Function URL/HTTP API)")
 		log.Printf("Method: %s, Path: %s", reqV2.RequestContext.HTTP.Method, reqV2.RawPath)
+		if shouldStream(lambdaConfig, reqV2) {
+			httpReq, err := ginLambdaV2.EventToRequestWithContext(ctx, reqV2)
+			if err != nil {
+				log.Printf("[ERROR] Failed to convert streaming request: %v", err)
+				return ginLambdaV2.ProxyWithContext(ctx, reqV2)
+			}
+			log.Printf("Streaming response via Lambda Function URL")
+			return serveStreaming(lambdaRouter, httpReq), nil
+		}
 		return ginLambdaV2.ProxyWithContext(ctx, reqV2)
 	}
 
@@ -210,16 +511,237 @@ func lambdaHandler(ctx context.Context, event interface{}) (interface{}, error)
 }
 
 // setupRouter creates and configures the Gin router
-func setupRouter(store storage.PasteStore, cfg *config.Config) *gin.Engine {
+func setupRouter(store storage.PasteStore, cfg *config.Config, auditLog *audit.Logger) *gin.Engine {
 	// Initialize service
 	pasteService := services.NewPasteService(store, cfg)
+	reserved := utils.NewReservedSlugs(utils.DefaultReservedSlugs...)
+	reserved.Add(strings.Split(cfg.ReservedSlugs, ",")...)
+	pasteService.SetReservedSlugs(reserved)
+	collectionService := services.NewCollectionService(store, pasteService)
+	pasteService.SetCollections(collectionService)
+	// Outside serve, as in tests, the settings start from cfg and are not
+	// polled.
+	rt := runtimeSettings
+	if rt == nil {
+		rt = settings.New(store, settings.FromConfig(cfg))
+	}
+	// The hot slug tracker counts reads in memory, so each replica and
+	// Lambda instance reports its own traffic.
+	var statsHandler *handlers.StatsHandler
+	if cfg.HotSlugs > 0 {
+		hot := hotkeys.New(cfg.HotSlugs)
+		pasteService.SetHotTracker(hot)
+		statsHandler = handlers.NewStatsHandler(hot)
+		if cfg.MetricsPort != 0 && !isLambdaEnvironment() {
+			prometheus.MustRegister(hot.Collector())
+		}
+	}
+
+	// Config validation has already loaded the keys file; a file that has
+	// become unreadable since leaves only the keys in cfg.APIKeys. The
+	// registry picks up changes to the file, including those made through
+	// the admin console, without a restart.
+	keys, err := apikeys.NewRegistry(cfg.APIKeys, cfg.APIKeysFile)
+	if err != nil {
+		log.Printf("[ERROR] Failed to load API keys file: %v", err)
+		keys, _ = apikeys.NewRegistry(cfg.APIKeys, "")
+	}
+	if cfg.APIKeysFile != "" && !isLambdaEnvironment() {
+		go keys.Watch(keysWatchInterval, nil)
+	}
+
+	// Share links, manage tokens, upload links, direct upload tickets,
+	// sessions and proof-of-work challenges are signed with the session
+	// secret. Signatures name their key, so those made with a previous
+	// secret keep working while it is rotated out.
+	macKeys := hmackeys.New(cfg.SessionSecret, hmackeys.Parse(cfg.SessionSecretPrevious))
+	if macKeys.Ephemeral() {
+		log.Printf("[WARN] NCLIP_SESSION_SECRET not set; sessions, share links and upload links are only valid until restart")
+	} else if macKeys.Previous() > 0 {
+		log.Printf("Signing with key %s, also accepting %d previous keys", macKeys.ID(), macKeys.Previous())
+	}
+
+	// Private pastes are read with the uploader's API key or a share link.
+	checker := access.NewChecker(keys, macKeys)
+
+	// Keys put in tenants by the keys file only manage their tenant's
+	// pastes, and the tenants' uploads count against their quotas.
+	tenants := keys.Tenancy()
+	checker.SetTenancy(tenants)
+	// The usage tracker also counts the pastes of the public stats page.
+	var usage *tenancy.Tracker
+	var tenantsHandler *handlers.TenantsHandler
+	if tenants.Enabled() || cfg.PublicPages {
+		if usage, err = tenancy.New(store, tenants); err != nil {
+			log.Printf("[WARN] Tenant quotas and paste usage are unavailable: %v", err)
+		} else {
+			pasteService.SetTenantUsage(usage)
+			if tenants.Enabled() {
+				tenantsHandler = handlers.NewTenantsHandler(usage, checker)
+			}
+			if !isLambdaEnvironment() && !cfg.IsReplica() && !cfg.IsMirror() {
+				usage.Start()
+			}
+		}
+	}
 
 	// Initialize handlers
 	uploadHandler := upload.NewHandler(pasteService, cfg)
+	uploadHandler.SetAccess(checker)
+	uploadHandler.SetSettings(rt)
+	uploadHandler.SetSizeLimits(keys)
+	if cfg.UploadAuth {
+		uploadHandler.SetUploadLinks(uploadlink.NewSigner(macKeys))
+	}
+	slugs, err := slugmask.New(cfg.CustomSlugMode, cfg.SlugSecret)
+	if err != nil {
+		log.Fatalf("Failed to set up custom slugs: %v", err)
+	}
+	uploadHandler.SetSlugTransformer(slugs)
+	// Direct uploads store content in S3 behind the back of the encryption
+	// layer, so they need an unencrypted S3 backend.
+	directUploads := false
+	if cfg.PresignMaxSize > 0 && !cfg.IsReplica() {
+		_, isS3 := storage.Find[*storage.S3Store](store)
+		_, encrypted := storage.Find[*storage.EncryptedStore](store)
+		// The tenant store signs the URL for the key of the slug's tenant.
+		tenants, _ := storage.Find[*storage.TenantStore](store)
+		switch {
+		case !isS3:
+			log.Printf("[WARN] NCLIP_PRESIGN_MAX_SIZE needs S3 storage: direct uploads are disabled")
+		case encrypted:
+			log.Printf("[WARN] Direct uploads bypass encryption at rest: they are disabled while NCLIP_ENCRYPTION_KEYS is set")
+		default:
+			uploadHandler.SetDirectUploads(tenants, presignupload.NewSigner(macKeys))
+			directUploads = true
+		}
+	}
+	// The multipart spool is local disk, which Lambda does not keep, and
+	// replicas take no uploads.
+	if cfg.MultipartSpoolDir != "" && !isLambdaEnvironment() && !cfg.IsReplica() {
+		if spool, err := multipartspool.New(cfg.MultipartSpoolDir, cfg.MultipartSpoolMaxSize); err != nil {
+			log.Printf("[ERROR] Multipart spool disabled, large parts use the system temp directory: %v", err)
+		} else {
+			spool.Start(time.Hour)
+			uploadHandler.SetMultipartSpool(spool)
+		}
+	}
+	if workspaces, _ := slashcmd.ParseWorkspaces(cfg.SlackWorkspaces); len(workspaces) > 0 {
+		uploadHandler.SetSlashCommands(workspaces)
+	}
+	if cfg.EmailSNSTopic != "" {
+		senders, _ := emailin.ParseSenders(cfg.EmailSenders)
+		var replier emailin.Replier
+		if cfg.EmailReplyFrom != "" {
+			r, err := emailin.NewSESReplier(cfg.EmailReplyFrom)
+			if err != nil {
+				log.Printf("[WARN] Email-in replies disabled: %v", err)
+			} else {
+				replier = r
+			}
+		}
+		verifier := emailin.NewVerifier(cfg.EmailSNSTopic)
+		if sharedState != nil {
+			verifier.SetReplayStore(sharedState)
+		}
+		uploadHandler.SetEmailIn(verifier, senders, replier)
+	}
 	retrievalHandler := retrieval.NewHandler(pasteService, store, cfg)
+	retrievalHandler.SetAccess(checker)
+	retrievalHandler.SetTokens(services.NewTokenService(store, pasteService))
+	retrievalHandler.SetSettings(rt)
+	var signingHandler *handlers.SigningHandler
+	// Config validation has already parsed the signing key.
+	if signer, err := signing.Parse(cfg.SigningKey); cfg.SigningKey != "" && err == nil {
+		retrievalHandler.SetSigner(signer)
+		signingHandler = handlers.NewSigningHandler(signer)
+	}
 	metaHandler := handlers.NewMetaHandler(store)
-	systemHandler := handlers.NewSystemHandler()
+	metaHandler.SetAccess(checker)
+	metaHandler.SetContentLock(pasteService.ContentLock())
+	systemHandler := handlers.NewSystemHandler(cfg, store)
+	var health *storage.HealthTracker
+	if is, ok := storage.Find[*storage.InstrumentedStore](store); ok && is.Health() != nil {
+		health = is.Health()
+		systemHandler.SetHealth(health)
+	}
 	webuiHandler := handlers.NewWebUIHandler(cfg)
+	configHandler := handlers.NewConfigHandler(cfg)
+	configHandler.SetDirectUploads(directUploads)
+	configHandler.SetSettings(rt)
+	instanceHandler := handlers.NewInstanceHandler(cfg, usage)
+	instanceHandler.SetSettings(rt)
+	instanceHandler.SetFeature("direct_uploads", directUploads)
+	instanceHandler.SetFeature("signing", signingHandler != nil)
+	listHandler := handlers.NewListHandler(store)
+	listHandler.SetAccess(checker)
+	manageHandler := handlers.NewManageHandler(pasteService, checker, cfg)
+	exportHandler := handlers.NewExportHandler(store, checker)
+	collectionHandler := handlers.NewCollectionHandler(collectionService, checker, cfg)
+	auditHandler := handlers.NewAuditHandler(auditLog)
+	settingsHandler := handlers.NewSettingsHandler(rt)
+	keysHandler := handlers.NewKeysHandler(keys, checker)
+	debugHandler := handlers.NewDebugHandler(cfg)
+	// The re-encryption job runs in the background, which Lambda does not
+	// allow between invocations, and writes, which replicas never do.
+	var reencryptHandler *handlers.ReencryptHandler
+	if enc, ok := store.(*storage.EncryptedStore); ok && !isLambdaEnvironment() && !cfg.IsReplica() {
+		job := reencrypt.New(enc, filepath.Join(cfg.DataDir, ".reencrypt.json"))
+		job.SetLock(pasteService.ContentLock())
+		job.Resume()
+		reencryptHandler = handlers.NewReencryptHandler(job, cfg.ReencryptRate)
+	}
+	// The orphan sweep deletes objects, which replicas never do, and runs
+	// in the background only outside Lambda.
+	var orphansHandler *handlers.OrphansHandler
+	if jan := janitor.New(store, cfg.OrphanMinAge); jan != nil && !cfg.IsReplica() {
+		// Expired pastes nobody reads are only purged from the CDN once a
+		// sweep finds them.
+		if _, ok := storage.Find[*storage.PurgingStore](store); ok {
+			jan.SetExpire(true)
+		}
+		if cfg.OrphanSweepInterval > 0 && !isLambdaEnvironment() {
+			jan.Start(cfg.OrphanSweepInterval)
+		}
+		orphansHandler = handlers.NewOrphansHandler(jan)
+	}
+
+	// Push notifications are sent in the background, which Lambda does not
+	// allow, and pastes are only burned on the writer.
+	var notifier *push.Notifier
+	if key, err := push.ParseKey(cfg.VAPIDPrivateKey); cfg.VAPIDPrivateKey != "" && err == nil {
+		if isLambdaEnvironment() || cfg.IsReplica() || cfg.IsMirror() {
+			log.Printf("[WARN] Web Push is not supported in Lambda mode or on replicas and mirrors: no notifications will be sent")
+		} else {
+			notifier = push.NewNotifier(push.NewSender(key, cfg.VAPIDSubject), store, filepath.Join(cfg.DataDir, ".push.json"), cfg.PushExpiryNotice)
+			notifier.Start(time.Minute)
+			pasteService.SetPushNotifier(notifier)
+			manageHandler.SetPush(notifier)
+			instanceHandler.SetFeature("push", true)
+		}
+	}
+
+	if burnNotifier := newBurnNotifier(cfg); burnNotifier != nil {
+		pasteService.SetBurnNotifier(burnNotifier)
+		uploadHandler.SetBurnNotifier(burnNotifier)
+	}
+
+	// The sync feed serves the journal to mirrors; a mirror copies from
+	// the writer in the background, which Lambda does not allow.
+	var syncHandler *handlers.SyncHandler
+	if js, ok := storage.Find[*storage.JournaledStore](store); ok {
+		syncHandler = handlers.NewSyncHandler(js.Journal(), store)
+	}
+	if cfg.IsMirror() {
+		if isLambdaEnvironment() {
+			log.Printf("[WARN] Mirror role is not supported in Lambda mode: nothing will be copied from %s", cfg.WriterURL)
+		} else {
+			m := mirror.New(cfg.WriterURL, cfg.MirrorAPIKey, store, filepath.Join(cfg.DataDir, ".mirror.json"))
+			m.Start(cfg.MirrorInterval)
+			systemHandler.SetMirror(m)
+			log.Printf("Mirror mode: copying pastes from %s every %s", cfg.WriterURL, cfg.MirrorInterval)
+		}
+	}
 
 	// Create Gin router
 	router := gin.New()
@@ -228,62 +750,324 @@ func setupRouter(store storage.PasteStore, cfg *config.Config) *gin.Engine {
 	// Use a JSON-safe recovery middleware and canonicalErrors middleware so
 	// API endpoints always return JSON error responses instead of HTML error
 	// pages that the web UI cannot parse.
+	// Behind trusted proxies the client IP and the path prefix the proxy
+	// strips come from their forwarded headers.
+	proxies, err := forwarded.ParseProxies(cfg.TrustedProxies)
+	if err != nil {
+		log.Printf("[ERROR] Ignoring trusted proxies: %v", err)
+	}
+	if len(proxies.List()) > 0 {
+		if err := router.SetTrustedProxies(proxies.List()); err != nil {
+			log.Printf("[ERROR] Failed to set trusted proxies: %v", err)
+		}
+	}
+	// Headers are scrubbed last, so the middleware runs first.
+	router.Use(respheaders.Middleware(respheaders.Options{RoutePrefix: cfg.RoutePrefix, CacheControl: cfg.CacheControl()}))
 	router.Use(gin.Logger())
+	router.Use(apierror.RequestID())
 	router.Use(jsonRecovery())
 	router.Use(canonicalErrors())
+	router.Use(forwarded.Middleware(proxies))
 	router.Use(gin.Recovery())
+	if cfg.IsReplica() || cfg.IsMirror() {
+		router.Use(replicaGuard(cfg))
+	}
+	router.Use(readOnlyGuard(cfg, rt))
+	router.Use(session.NewManager(macKeys, cfg.SessionTTL).Middleware())
+	router.Use(auditLog.Middleware())
 
-	// Load favicon
-	router.StaticFile("/favicon.ico", "./static/favicon.ico")
+	// Every route is served under the route prefix, if one is set.
+	routes := router.Group(cfg.RoutePrefix)
 
-	// Load HTML templates
-	router.LoadHTMLGlob("static/*.html")
+	// Load favicon and static files, preferring those in the override
+	// directory
+	assets := theme.Assets("./static", cfg.OverrideDir)
+	routes.StaticFileFS("/favicon.ico", "favicon.ico", assets)
+	routes.StaticFS("/static", assets)
 
-	// Serve static files
-	router.Static("/static", "./static")
+	// Load HTML templates
+	loadTemplates(router, cfg)
 
 	// Web UI routes
-	router.GET("/", webuiHandler.Index)
+	routes.GET("/", webuiHandler.Index)
 
-	// Core API routes
+	// Core API routes. While the storage backend is degraded uploads are
+	// shed before authentication spends any work on them.
+	var shed []gin.HandlerFunc
+	if health != nil {
+		shed = append(shed, loadShedder(health))
+	}
+	sheddable := func(h ...gin.HandlerFunc) []gin.HandlerFunc {
+		return append(append([]gin.HandlerFunc{}, shed...), h...)
+	}
+	// Uploads from clients are also capped in flight, so one client
+	// uploading in parallel cannot take all of the storage bandwidth.
+	// Integrations are left out: all their uploads come from one server.
+	var limited []gin.HandlerFunc
+	if cfg.MaxConcurrentUploads > 0 || cfg.MaxConcurrentUploadsPerKey > 0 {
+		limit := ratelimit.NewConcurrency("uploads", cfg.MaxConcurrentUploads, cfg.MaxConcurrentUploadsPerKey)
+		limited = append(limited, uploadConcurrency(limit, checker))
+	}
+	limitable := func(h ...gin.HandlerFunc) []gin.HandlerFunc {
+		return sheddable(append(append([]gin.HandlerFunc{}, limited...), h...)...)
+	}
+	guards := limitable()
 	if cfg.UploadAuth {
-		auth := apiKeyAuth(cfg)
-		router.POST("/", auth, uploadHandler.Upload)
-		router.POST("/burn/", auth, uploadHandler.UploadBurn)
-		// Base64 upload routes (shortcut that auto-sets X-Content-Encoding header)
-		router.POST("/base64", auth, base64UploadMiddleware(), uploadHandler.Upload)
-	} else {
-		router.POST("/", uploadHandler.Upload)
-		router.POST("/burn/", uploadHandler.UploadBurn)
-		// Base64 upload routes (shortcut that auto-sets X-Content-Encoding header)
-		router.POST("/base64", base64UploadMiddleware(), uploadHandler.Upload)
+		guards = append(guards, uploadAuth(cfg, keys))
+	}
+	if cfg.PoWDifficulty > 0 {
+		verifier := pow.NewVerifier(macKeys, cfg.PoWDifficulty)
+		if sharedState != nil {
+			verifier.SetReplayStore(sharedState)
+		}
+		guards = append(guards, powGuard(verifier, checker))
+		routes.GET("/api/v1/challenge", handlers.NewChallengeHandler(verifier).Challenge)
+	}
+	uploadRoute := func(h ...gin.HandlerFunc) []gin.HandlerFunc {
+		return append(append([]gin.HandlerFunc{}, guards...), h...)
+	}
+	routes.POST("/", uploadRoute(uploadHandler.Upload)...)
+	routes.POST("/burn/", uploadRoute(uploadHandler.UploadBurn)...)
+	// Base64 upload routes (shortcut that auto-sets X-Content-Encoding header)
+	routes.POST("/base64", uploadRoute(base64UploadMiddleware(), uploadHandler.Upload)...)
+	// Direct uploads: the content goes straight to S3, and finalizing is
+	// authorized by the ticket issued with the presigned URL.
+	routes.POST("/api/v1/presign-upload", uploadRoute(uploadHandler.PresignUpload)...)
+	routes.POST("/api/v1/finalize/:slug", sheddable(uploadHandler.Finalize)...)
+	// Slug availability for custom slugs; it takes the same API key as
+	// uploads, since it also reveals private pastes.
+	existsGuards := sheddable()
+	if cfg.UploadAuth {
+		existsGuards = append(existsGuards, uploadAuth(cfg, keys))
+	}
+	existsGuards = append(existsGuards, uploadHandler.Exists)
+	routes.GET("/api/v1/exists/:slug", existsGuards...)
+	routes.HEAD("/api/v1/exists/:slug", existsGuards...)
+	routes.GET("/:slug", retrievalHandler.View)
+	routes.GET("/raw/:slug", retrievalHandler.Raw)
+	routes.GET("/download/:slug", retrievalHandler.Download)
+	// Short aliases for terse links.
+	routes.GET("/r/:slug", retrievalHandler.Raw)
+	routes.GET("/d/:slug", retrievalHandler.Download)
+	routes.GET("/preview/:file", retrievalHandler.Preview)
+	// Embeddable pages and the snippet that frames them.
+	if cfg.EmbedFrameAncestors != "" {
+		routes.GET("/embed/:slug", retrievalHandler.Embed)
+		routes.StaticFileFS("/embed.js", "embed.js", assets)
 	}
-	router.GET("/:slug", retrievalHandler.View)
-	router.GET("/raw/:slug", retrievalHandler.Raw)
+	routes.GET("/t/:token", retrievalHandler.Token)
+	if cfg.ChecksumURLs {
+		routes.GET("/sha256/:hash", retrievalHandler.Checksum)
+		routes.HEAD("/sha256/:hash", retrievalHandler.Checksum)
+	}
+	// Burn-after-read links: the page is safe for link previews to fetch,
+	// only posting the token from the URL fragment burns the paste.
+	routes.GET("/b/:slug", retrievalHandler.BurnPage)
+	routes.POST("/b/:slug", retrievalHandler.BurnReveal)
+	// Replacing content keeps earlier versions; the handler checks for
+	// the owner's key, an admin key or the manage token.
+	routes.PUT("/:slug", limitable(uploadHandler.Replace)...)
+	// Live pastes take appended chunks with the same credentials.
+	routes.POST("/api/v1/pastes/:slug/append", limitable(uploadHandler.Append)...)
+	routes.GET("/api/v1/pastes/:slug/versions", retrievalHandler.Versions)
 	if cfg.UploadAuth {
-		auth := apiKeyAuth(cfg)
-		router.DELETE("/:slug", auth, metaHandler.DeletePaste)
+		routes.DELETE("/:slug", apiKeyAuth(keys, apikeys.ScopeAdmin), metaHandler.DeletePaste)
 	} else {
-		router.DELETE("/:slug", metaHandler.DeletePaste)
+		routes.DELETE("/:slug", metaHandler.DeletePaste)
+	}
+
+	// Collections group pastes under one index page. Changing one takes a
+	// key that may upload; the handler checks it owns the collection.
+	var collectionGuards []gin.HandlerFunc
+	if cfg.UploadAuth {
+		collectionGuards = append(collectionGuards, apiKeyAuth(keys, apikeys.ScopeWrite))
+	}
+	collectionRoute := func(h gin.HandlerFunc) []gin.HandlerFunc {
+		return append(append([]gin.HandlerFunc{}, collectionGuards...), h)
+	}
+	routes.GET("/c/:id", collectionHandler.Show)
+	routes.GET("/api/v1/collections/:id", collectionHandler.Get)
+	routes.POST("/api/v1/collections", collectionRoute(collectionHandler.Create)...)
+	routes.POST("/api/v1/collections/:id/pastes", collectionRoute(collectionHandler.Attach)...)
+	routes.DELETE("/api/v1/collections/:id/pastes/:slug", collectionRoute(collectionHandler.Detach)...)
+	routes.DELETE("/api/v1/collections/:id", collectionRoute(collectionHandler.Delete)...)
+
+	// Self-service management with the manage URL returned at upload; the
+	// token replaces the API key and the session CSRF token is required.
+	routes.GET("/manage/:slug", manageHandler.Page)
+	routes.POST("/manage/:slug", manageHandler.ManageUpdate)
+	routes.DELETE("/manage/:slug", manageHandler.ManageDelete)
+	// The service worker is served from the root so its scope covers the
+	// manage pages that subscribe through it.
+	if notifier != nil {
+		routes.POST("/manage/:slug/push", manageHandler.PushSubscribe)
+		routes.DELETE("/manage/:slug/push", manageHandler.PushUnsubscribe)
+		routes.GET("/api/v1/push/public-key", manageHandler.PushPublicKey)
+		routes.StaticFileFS("/sw.js", "sw.js", assets)
 	}
 
 	// Metadata API
-	router.GET("/api/v1/meta/:slug", metaHandler.GetMetadata)
+	routes.GET("/api/v1/meta/:slug", metaHandler.GetMetadata)
+	routes.POST("/api/v1/meta/batch", metaHandler.GetMetadataBatch)
+
+	// Listing and bulk delete expose every slug, so they are admin-only and
+	// only available when API keys are configured. Read-only keys may list.
+	if cfg.UploadAuth {
+		auth := apiKeyAuth(keys, apikeys.ScopeAdmin)
+		// Routes that concern the whole instance rather than pastes take a
+		// superadmin key once admin keys are confined to tenants.
+		instanceAuth := auth
+		if tenants.Enabled() {
+			instanceAuth = apiKeyAuth(keys, apikeys.ScopeSuperAdmin)
+		}
+		routes.GET("/api/v1/pastes", apiKeyAuth(keys, apikeys.ScopeRead), listHandler.List)
+		// Any key may export the pastes it owns.
+		routes.GET("/api/v1/pastes/export", apiKeyAuth(keys), exportHandler.Export)
+		routes.DELETE("/api/v1/pastes", auth, listHandler.DeleteByTag)
+		routes.POST("/api/v1/pastes/:slug/pin", auth, metaHandler.Pin)
+		routes.DELETE("/api/v1/pastes/:slug/pin", auth, metaHandler.Unpin)
+		routes.POST("/api/v1/pastes/:slug/hold", auth, metaHandler.Hold)
+		routes.DELETE("/api/v1/pastes/:slug/hold", auth, metaHandler.Release)
+		routes.POST("/api/v1/pastes/:slug/quarantine", auth, metaHandler.Quarantine)
+		routes.DELETE("/api/v1/pastes/:slug/quarantine", auth, metaHandler.Unquarantine)
+		routes.POST("/api/v1/pastes/:slug/share", apiKeyAuth(keys, apikeys.ScopeWrite), retrievalHandler.Share)
+		routes.POST("/api/v1/pastes/:slug/tokens", apiKeyAuth(keys, apikeys.ScopeWrite), retrievalHandler.CreateToken)
+		routes.GET("/api/v1/pastes/:slug/tokens", apiKeyAuth(keys, apikeys.ScopeWrite), retrievalHandler.ListTokens)
+		routes.DELETE("/api/v1/pastes/:slug/tokens/:token", apiKeyAuth(keys, apikeys.ScopeWrite), retrievalHandler.RevokeToken)
+		routes.PATCH("/api/v1/pastes/:slug", auth, manageHandler.Update)
+		// The uploader of an obfuscated custom slug looks up where it went.
+		routes.GET("/api/v1/slugs/:name", apiKeyAuth(keys, apikeys.ScopeRead), uploadHandler.LookupSlug)
+		routes.GET("/api/v1/debug/request", instanceAuth, debugHandler.Request)
+		if auditLog != nil {
+			routes.GET("/api/v1/audit", instanceAuth, auditHandler.Recent)
+		}
+		if reencryptHandler != nil {
+			routes.GET("/api/v1/reencrypt", instanceAuth, reencryptHandler.Status)
+			routes.POST("/api/v1/reencrypt", instanceAuth, reencryptHandler.Start)
+			routes.DELETE("/api/v1/reencrypt", instanceAuth, reencryptHandler.Stop)
+		}
+		if statsHandler != nil {
+			routes.GET("/api/v1/stats/hot", instanceAuth, statsHandler.Hot)
+		}
+		if tenantsHandler != nil {
+			routes.GET("/api/v1/stats/tenants", auth, tenantsHandler.Usage)
+		}
+		if orphansHandler != nil {
+			routes.GET("/api/v1/orphans", instanceAuth, orphansHandler.List)
+			routes.POST("/api/v1/orphans", instanceAuth, orphansHandler.Sweep)
+		}
+		routes.GET("/api/v1/admin/settings", instanceAuth, settingsHandler.Get)
+		routes.PATCH("/api/v1/admin/settings", instanceAuth, settingsHandler.Update)
+		// Any key may ask what it is; the admin console uses the answer to
+		// decide which sections to show.
+		routes.GET("/api/v1/admin/me", apiKeyAuth(keys), keysHandler.Me)
+		routes.GET("/api/v1/admin/keys", instanceAuth, keysHandler.List)
+		routes.POST("/api/v1/admin/keys", instanceAuth, keysHandler.Create)
+		routes.PATCH("/api/v1/admin/keys/:id", instanceAuth, keysHandler.Update)
+		routes.DELETE("/api/v1/admin/keys/:id", instanceAuth, keysHandler.Revoke)
+		routes.PUT("/api/v1/admin/quotas/:tenant", instanceAuth, keysHandler.SetQuota)
+		// The console page holds no data; it calls the routes above with
+		// the key the admin signs in with.
+		routes.GET("/admin", webuiHandler.Admin)
+		if syncHandler != nil {
+			routes.GET("/api/v1/sync/changes", instanceAuth, syncHandler.Changes)
+			routes.GET("/api/v1/sync/content/:slug", instanceAuth, syncHandler.Content)
+		}
+
+		// One-time upload links let someone without an API key create a
+		// single paste; without upload auth anyone can upload anyway.
+		routes.POST("/api/v1/upload-links", apiKeyAuth(keys, apikeys.ScopeWrite), uploadHandler.CreateLink)
+		routes.POST("/u/:token", limitable(uploadHandler.UploadWithLink)...)
+	}
+
+	// Slash commands authenticate with the workspace's signing secret or
+	// token instead of an API key.
+	if cfg.SlackWorkspaces != "" {
+		routes.POST("/integrations/slack", sheddable(uploadHandler.SlashCommand)...)
+	}
+	if cfg.EmailSNSTopic != "" {
+		routes.POST("/integrations/email", sheddable(uploadHandler.EmailIn)...)
+	}
 
 	// Alias for metadata API (shortcut)
-	router.GET("/json/:slug", metaHandler.GetMetadata)
+	routes.GET("/json/:slug", metaHandler.GetMetadata)
+
+	// Public client configuration (upload limits, TTL bounds)
+	routes.GET("/api/v1/config", configHandler.GetConfig)
+
+	if signingHandler != nil {
+		routes.GET("/api/v1/public-key", signingHandler.PublicKey)
+	}
+
+	// Clients discover the instance's API and limits from its address.
+	routes.GET("/.well-known/nclip.json", instanceHandler.Discovery)
+	if cfg.PublicPages {
+		routes.GET("/about", instanceHandler.About)
+		routes.GET("/stats", instanceHandler.Stats)
+	}
 
 	// System routes
-	router.GET("/health", systemHandler.Health)
+	routes.GET("/health", systemHandler.Health)
+
+	// Every route's first path segment is reserved so a custom slug can
+	// never shadow a route, including ones added later.
+	reserved.Add(routePrefixes(router.Routes(), cfg.RoutePrefix)...)
 
 	// Global 404 handler
 	router.NoRoute(func(c *gin.Context) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Resource not found"})
+		if strings.Contains(c.GetHeader("Accept"), "text/html") {
+			apierror.HTML(c, http.StatusNotFound, apierror.CodeNotFound, "Resource not found", gin.H{
+				"Version": cfg.Version,
+			})
+			return
+		}
+		apierror.JSON(c, http.StatusNotFound, apierror.CodeNotFound, "Resource not found")
 	})
 
 	return router
 }
 
+// loadTemplates loads the HTML templates into engine, with those in
+// cfg.OverrideDir replacing the built-in ones. They link to routes through
+// the path function, which honors cfg's route prefix, and get cfg's
+// branding through brand and title.
+func loadTemplates(engine *gin.Engine, cfg *config.Config) *theme.Templates {
+	funcs := branding(cfg).Funcs()
+	funcs["path"] = cfg.Path
+	templates, err := theme.NewTemplates("static", cfg.OverrideDir, funcs)
+	if err != nil {
+		log.Fatalf("Failed to load templates: %v", err)
+	}
+	engine.HTMLRender = templates
+	return templates
+}
+
+// branding returns the branding settings of cfg.
+func branding(cfg *config.Config) theme.Branding {
+	return theme.Branding{
+		SiteName:   cfg.SiteName,
+		LogoURL:    cfg.LogoURL,
+		FooterHTML: template.HTML(cfg.FooterHTML),
+		ImprintURL: cfg.ImprintURL,
+	}
+}
+
+// routePrefixes returns the literal first path segment of each route
+// below routePrefix, e.g. "api" for /api/v1/meta/:slug. Parameter segments
+// are skipped.
+func routePrefixes(routes gin.RoutesInfo, routePrefix string) []string {
+	var prefixes []string
+	for _, route := range routes {
+		path := strings.TrimPrefix(route.Path, routePrefix)
+		segment, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+		if segment == "" || segment[0] == ':' || segment[0] == '*' {
+			continue
+		}
+		prefixes = append(prefixes, segment)
+	}
+	return prefixes
+}
+
 // base64UploadMiddleware sets the X-Base64 header
 // This allows /base64 route to automatically enable base64 decoding
 func base64UploadMiddleware() gin.HandlerFunc {
@@ -301,8 +1085,7 @@ func jsonRecovery() gin.HandlerFunc {
 			if r := recover(); r != nil {
 				// Log the panic for diagnostics
 				log.Printf("[PANIC] %v", r)
-				c.Header("Content-Type", "application/json; charset=utf-8")
-				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+				apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error")
 			}
 		}()
 		c.Next()
@@ -344,13 +1127,10 @@ func canonicalErrors() gin.HandlerFunc {
 				return
 			}
 
-			// Determine a suitable message to expose
-			msg := getErrorMessage(buf, ct, c, status)
-
 			// Write canonical JSON to the original writer
 			origWriter.Header().Set("Content-Type", "application/json; charset=utf-8")
 			origWriter.WriteHeader(status)
-			out, _ := json.Marshal(gin.H{"error": msg})
+			out, _ := json.Marshal(errorResponse(buf, ct, c, status))
 			if _, err := origWriter.Write(out); err != nil {
 				log.Printf("[ERROR] canonicalErrors: failed to write error response: %v", err)
 			}
@@ -368,6 +1148,22 @@ func canonicalErrors() gin.HandlerFunc {
 	}
 }
 
+// errorResponse builds the typed error body for an error response. Bodies
+// already produced through the apierror helpers are preserved as-is; any
+// other error output is wrapped with a code derived from the status.
+func errorResponse(buf []byte, ct string, c *gin.Context, status int) apierror.Response {
+	if len(buf) > 0 && strings.Contains(ct, "application/json") {
+		var typed apierror.Response
+		if err := json.Unmarshal(buf, &typed); err == nil && typed.Code != "" && typed.Error != "" {
+			if typed.RequestID == "" {
+				typed.RequestID = apierror.GetRequestID(c)
+			}
+			return typed
+		}
+	}
+	return apierror.New(c, apierror.CodeForStatus(status), getErrorMessage(buf, ct, c, status))
+}
+
 // getErrorMessage extracts a suitable error message from the response body,
 // gin.Context errors, or HTTP status text.
 //
@@ -409,48 +1205,188 @@ func getErrorMessage(buf []byte, ct string, c *gin.Context, status int) string {
 }
 
 // apiKeyAuth returns a middleware that validates API keys supplied via
-// Authorization: Bearer <key> or X-Api-Key: <key> headers. It reads keys
-// from cfg.APIKeys (comma-separated) and denies unauthorized requests with
-// HTTP 401.
-func apiKeyAuth(cfg *config.Config) gin.HandlerFunc {
-	// Build a map of allowed keys for fast lookup
-	allowed := map[string]struct{}{}
-	for _, k := range strings.Split(cfg.APIKeys, ",") {
-		kk := strings.TrimSpace(k)
-		if kk != "" {
-			allowed[kk] = struct{}{}
+// Authorization: Bearer <key> or X-Api-Key: <key> headers against keys,
+// denying unknown keys with HTTP 401 and keys granted none of scopes with
+// HTTP 403. The key's scopes are attached to the context for handlers.
+func apiKeyAuth(keys apikeys.Source, scopes ...apikeys.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := access.APIKey(c)
+		if key == "" {
+			apierror.Abort(c, http.StatusUnauthorized, apierror.CodeMissingAPIKey, "missing api key")
+			return
 		}
+
+		granted, ok := keys.Lookup(key)
+		if !ok {
+			apierror.Abort(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized")
+			return
+		}
+		audit.SetActor(c, audit.KeyID(key))
+		if len(scopes) > 0 && !granted.HasAny(scopes...) {
+			apierror.Abort(c, http.StatusForbidden, apierror.CodeInsufficientScope,
+				"api key lacks the "+apikeys.Scopes(scopes).String()+" scope")
+			return
+		}
+		access.SetScopes(c, granted)
+		c.Next()
 	}
+}
 
+// replicaGuard rejects mutating requests on a read-only replica or a
+// mirror with 403, pointing clients at the writer via the Location header.
+// POST routes that only read (the metadata batch lookup) are allowed
+// through.
+func replicaGuard(cfg *config.Config) gin.HandlerFunc {
+	writer := strings.TrimRight(cfg.WriterURL, "/")
 	return func(c *gin.Context) {
-		// Extract key from Authorization: Bearer <key>
-		var key string
-		if auth := c.GetHeader("Authorization"); auth != "" {
-			if strings.HasPrefix(strings.ToLower(auth), "bearer ") {
-				key = strings.TrimSpace(auth[7:])
-			}
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
 		}
-		// If not found, try X-Api-Key
-		if key == "" {
-			key = strings.TrimSpace(c.GetHeader("X-Api-Key"))
+		if c.FullPath() == cfg.Path("/api/v1/meta/batch") {
+			c.Next()
+			return
+		}
+		detail := ""
+		if writer != "" {
+			detail = writer + c.Request.URL.RequestURI()
+			c.Header("Location", detail)
 		}
+		apierror.JSONDetail(c, http.StatusForbidden, apierror.CodeReadOnlyReplica,
+			"This instance is a read-only replica; send writes to the writer", detail)
+		c.Abort()
+	}
+}
 
-		if key == "" {
-			c.Header("Content-Type", "application/json; charset=utf-8")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing api key"})
+// readOnlyGuard rejects mutating requests with 503 while an admin has made
+// the deployment read-only through the runtime settings. Changing the
+// settings, and POST routes that only read, are allowed through.
+func readOnlyGuard(cfg *config.Config, rt *settings.Runtime) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		if !rt.Current().ReadOnly {
+			c.Next()
+			return
+		}
+		switch c.FullPath() {
+		case cfg.Path("/api/v1/admin/settings"), cfg.Path("/api/v1/meta/batch"):
+			c.Next()
+			return
+		}
+		c.Header("Retry-After", readOnlyRetryAfter)
+		apierror.Abort(c, http.StatusServiceUnavailable, apierror.CodeReadOnly,
+			"This deployment is read-only for maintenance; please retry later")
+	}
+}
+
+// uploadAuth returns the authentication middleware for upload routes. It
+// is apiKeyAuth requiring the write or burn scope, except that with
+// cfg.SessionUploads requests that passed the session CSRF check are
+// accepted without an API key. Since anyone loading the web UI gets a
+// session, that alone would let scripts upload freely; session uploads are
+// therefore only accepted alongside powGuard, which makes each of them
+// carry a proof of work that loading the page does not provide.
+func uploadAuth(cfg *config.Config, keys apikeys.Source) gin.HandlerFunc {
+	auth := apiKeyAuth(keys, apikeys.ScopeWrite, apikeys.ScopeBurn)
+	if !cfg.SessionUploads || cfg.PoWDifficulty == 0 {
+		return auth
+	}
+	return func(c *gin.Context) {
+		if session.Verified(c) {
+			audit.SetActor(c, "session")
+			c.Next()
 			return
 		}
+		auth(c)
+	}
+}
 
-		if _, ok := allowed[key]; !ok {
-			// constant-time compare could be added, but we are checking map membership
-			c.Header("Content-Type", "application/json; charset=utf-8")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+// powGuard requires uploads without a valid API key to carry a solved
+// proof-of-work challenge in the X-PoW header, so spam bots pay for every
+// paste. It runs after uploadAuth, which has already rejected invalid keys
+// when upload auth is enabled.
+func powGuard(verifier *pow.Verifier, checker *access.Checker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if checker.Owner(c) != "" {
+			c.Next()
+			return
+		}
+		switch err := verifier.Verify(c.GetHeader(pow.Header)); {
+		case errors.Is(err, pow.ErrMissing):
+			apierror.Abort(c, http.StatusForbidden, apierror.CodePoWRequired, "proof of work required; solve GET /api/v1/challenge and send X-PoW")
+		case errors.Is(err, pow.ErrUnavailable):
+			log.Printf("[ERROR] %v", err)
+			apierror.Abort(c, http.StatusServiceUnavailable, apierror.CodeInternal, "proof of work cannot be verified right now, please retry later")
+		case err != nil:
+			apierror.Abort(c, http.StatusForbidden, apierror.CodePoWInvalid, err.Error())
+		default:
+			c.Next()
+		}
+	}
+}
+
+// concurrencyRetryAfter is the Retry-After, in seconds, of uploads
+// refused while too many are in flight.
+const concurrencyRetryAfter = "5"
+
+// uploadConcurrency rejects uploads with 429 and a Retry-After header
+// while limit has too many in flight, overall or for the same API key.
+// Uploads without a valid key are counted by client address instead.
+func uploadConcurrency(limit *ratelimit.Concurrency, checker *access.Checker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := checker.Owner(c)
+		if key == "" {
+			key = "ip:" + c.ClientIP()
+		}
+		release, err := limit.Acquire(key)
+		if err != nil {
+			c.Header("Retry-After", concurrencyRetryAfter)
+			msg := "Too many uploads in progress; please retry later"
+			if errors.Is(err, ratelimit.ErrTooManyForKey) {
+				msg = "Too many uploads in progress from this client; wait for one to finish"
+			}
+			apierror.Abort(c, http.StatusTooManyRequests, apierror.CodeRateLimited, msg)
 			return
 		}
+		defer release()
 		c.Next()
 	}
 }
 
+// loadShedder rejects uploads with 503 and a Retry-After header while the
+// storage backend is degraded, so they fail fast instead of piling up on
+// it. Reads are not routed through it.
+func loadShedder(health *storage.HealthTracker) gin.HandlerFunc {
+	retryAfter := strconv.Itoa(int(health.RetryAfter().Seconds()))
+	return func(c *gin.Context) {
+		if !health.Status().Degraded {
+			c.Next()
+			return
+		}
+		c.Header("Retry-After", retryAfter)
+		apierror.Abort(c, http.StatusServiceUnavailable, apierror.CodeOverloaded,
+			"The storage backend is degraded; uploads are paused, please retry later")
+	}
+}
+
+// backendName labels store in metrics and logs.
+func backendName(store storage.PasteStore) string {
+	switch store.(type) {
+	case *storage.S3Store:
+		return "s3"
+	case *storage.MongoStore:
+		return "mongodb"
+	case *storage.MemoryStore:
+		return "memory"
+	}
+	return "filesystem"
+}
+
 // bodyCaptureWriter buffers response body writes so middleware can inspect
 // and optionally rewrite the output before sending to the client.
 type bodyCaptureWriter struct {
@@ -458,40 +1394,112 @@ type bodyCaptureWriter struct {
 	body bytes.Buffer
 }
 
-// Write implements io.Writer; buffer the bytes but do not write to the
-// underlying writer until the middleware decides to forward them.
+// Write implements io.Writer. Error bodies are buffered until the middleware
+// decides how to forward them; successful responses pass straight through so
+// large downloads (and Lambda response streaming) are not held in memory.
 func (w *bodyCaptureWriter) Write(b []byte) (int, error) {
+	if w.Status() < http.StatusBadRequest {
+		return w.ResponseWriter.Write(b)
+	}
 	return w.body.Write(b)
 }
 
-// runHTTPServer starts the HTTP server for container mode
-func runHTTPServer(router *gin.Engine, cfg *config.Config, store storage.PasteStore) {
+// runHTTPServer starts the HTTP server for container mode and shuts it
+// down gracefully once stop is closed.
+func runHTTPServer(router *gin.Engine, cfg *config.Config, store storage.PasteStore, auditLog *audit.Logger, stop <-chan struct{}) {
 	// Ensure cleanup on exit
 	defer func() {
 		if err := store.Close(); err != nil {
 			log.Printf("Error closing storage: %v", err)
 		}
+		if err := auditLog.Close(); err != nil {
+			log.Printf("Error closing audit log: %v", err)
+		}
 	}()
 
-	// Create HTTP server
+	// ACME certificates are obtained and renewed in the background;
+	// handshakes fail until the first one is available.
+	var tlsConfig *tls.Config
+	if cfg.ACMEEnabled() {
+		manager, err := certManager(cfg, store)
+		if err != nil {
+			log.Fatalf("Failed to set up ACME certificates: %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		manager.Start(ctx)
+		tlsConfig = manager.TLSConfig()
+		log.Printf("ACME certificates enabled for %s (DNS-01 via %s)", cfg.ACMEDomains, cfg.ACMEDNSProvider)
+	}
+
+	// Templates in the override directory are picked up without a restart.
+	if templates, ok := router.HTMLRender.(*theme.Templates); ok && cfg.OverrideDir != "" {
+		go templates.Watch(templateWatchInterval, stop)
+		log.Printf("Template overrides enabled: %s", cfg.OverrideDir)
+	}
+
+	// Create HTTP server, plus the HTTP/3 server when enabled
+	addr := fmt.Sprintf(":%d", cfg.Port)
+	var h3 *http3.Server
+	if cfg.HTTP3 {
+		h3 = &http3.Server{Addr: addr, Handler: router, TLSConfig: tlsConfig}
+	}
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.Port),
-		Handler: router,
+		Addr:      addr,
+		Handler:   httpHandler(router, cfg, h3),
+		TLSConfig: tlsConfig,
 	}
 
 	// Start server in a goroutine
 	go func() {
-		log.Printf("Starting nclip server on port %d", cfg.Port)
-
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if cfg.TLSEnabled() {
+			log.Printf("Starting nclip server on port %d (TLS, HTTP/2)", cfg.Port)
+			err = server.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+		} else {
+			log.Printf("Starting nclip server on port %d", cfg.Port)
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
+	if h3 != nil {
+		go func() {
+			log.Printf("Starting HTTP/3 listener on UDP port %d", cfg.Port)
+			var err error
+			if tlsConfig != nil {
+				err = h3.ListenAndServe()
+			} else {
+				err = h3.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to start HTTP/3 listener: %v", err)
+			}
+		}()
+	}
 
-	// Wait for interrupt signal to gracefully shutdown the server
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	// Optional plain-TCP and gopher retrieval listeners
+	tcpServers := startTCPServers(cfg, store, auditLog)
+
+	// Optional Prometheus listener, separate from the public port
+	var metricsServer *http.Server
+	if cfg.MetricsPort != 0 {
+		mux := http.NewServeMux()
+		// OpenMetrics carries the exemplars of paste_reads_total.
+		mux.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+			promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})))
+		metricsServer = &http.Server{Addr: fmt.Sprintf(":%d", cfg.MetricsPort), Handler: mux}
+		go func() {
+			log.Printf("Starting metrics listener on port %d", cfg.MetricsPort)
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to start metrics listener: %v", err)
+			}
+		}()
+	}
+
+	// Wait for the stop signal to gracefully shutdown the server
+	<-stop
 	log.Println("Shutting down server...")
 
 	// Create a deadline for shutdown
@@ -499,9 +1507,182 @@ func runHTTPServer(router *gin.Engine, cfg *config.Config, store storage.PasteSt
 	defer cancel()
 
 	// Attempt graceful shutdown
+	for _, ts := range tcpServers {
+		if err := ts.Close(); err != nil {
+			log.Printf("Error closing TCP listener: %v", err)
+		}
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Close(); err != nil {
+			log.Printf("Error closing metrics listener: %v", err)
+		}
+	}
+	if h3 != nil {
+		if err := h3.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down HTTP/3 listener: %v", err)
+		}
+	}
 	if err := server.Shutdown(ctx); err != nil {
 		log.Printf("Server forced to shutdown: %v", err)
 	} else {
 		log.Println("Server shutdown complete")
 	}
 }
+
+// certManager creates the ACME certificate manager configured in cfg,
+// keeping certificates in store. Replicas only load the certificates the
+// writer obtains.
+func certManager(cfg *config.Config, store storage.PasteStore) (*certs.Manager, error) {
+	domains, err := certs.ParseDomains(cfg.ACMEDomains)
+	if err != nil {
+		return nil, err
+	}
+	certStore, ok := store.(storage.CertStore)
+	if !ok {
+		return nil, errors.New("the storage backend cannot hold certificates")
+	}
+	var provider certs.DNSProvider
+	switch cfg.ACMEDNSProvider {
+	case "cloudflare":
+		provider = certs.NewCloudflare(cfg.CloudflareAPIToken)
+	default:
+		if provider, err = certs.NewRoute53(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	return certs.New(certs.Options{
+		Domains:     domains,
+		Email:       cfg.ACMEEmail,
+		Directory:   cfg.ACMEDirectory,
+		Provider:    provider,
+		Propagation: cfg.ACMEPropagation,
+		Store:       certStore,
+		ReadOnly:    cfg.IsReplica(),
+	}), nil
+}
+
+// httpHandler returns the handler for the TCP listener. Without TLS it
+// accepts h2c when cfg.H2C is set; when h3 is non-nil every response
+// advertises HTTP/3 with an Alt-Svc header so clients can switch to QUIC.
+func httpHandler(router *gin.Engine, cfg *config.Config, h3 *http3.Server) http.Handler {
+	router.UseH2C = cfg.H2C && !cfg.TLSEnabled()
+	handler := router.Handler()
+	if h3 == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fails only until the QUIC listener is up; nothing to advertise yet.
+		_ = h3.SetQUICHeaders(w.Header())
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// newBurnNotifier returns the notifier of X-Notify-On-Burn targets, or nil
+// on replicas and mirrors, which never burn pastes. Outside Lambda it
+// sends in the background; Lambda freezes between invocations, so there
+// the burning read sends the notification before it returns.
+func newBurnNotifier(cfg *config.Config) *burnnotify.Notifier {
+	if cfg.IsReplica() || cfg.IsMirror() {
+		return nil
+	}
+	var mailer emailin.Replier
+	if cfg.BurnNotifyFrom != "" {
+		r, err := emailin.NewSESReplier(cfg.BurnNotifyFrom)
+		if err != nil {
+			log.Printf("[WARN] Burn notification emails disabled: %v", err)
+		} else {
+			mailer = r
+		}
+	}
+	n := burnnotify.NewNotifier(mailer)
+	if !isLambdaEnvironment() {
+		n.Start()
+	}
+	return n
+}
+
+// newCDNPurger returns a purger for the CDN purge targets configured in
+// cfg, or nil when there are none. Replicas never delete, so the writer
+// purges for them.
+func newCDNPurger(cfg *config.Config) *cdnpurge.Purger {
+	if cfg.CDNPurgeCloudFront == "" && cfg.CDNPurgeWebhook == "" {
+		return nil
+	}
+	if cfg.IsReplica() {
+		log.Printf("[WARN] CDN purge is ignored on replicas: the writer purges the pastes it deletes")
+		return nil
+	}
+	// Paths are those of the public URL, which may differ from the route
+	// prefix behind a proxy.
+	prefix, origin := cfg.RoutePrefix, ""
+	if u, err := url.Parse(cfg.URL); err == nil && u.Host != "" {
+		prefix, origin = u.Path, u.Scheme+"://"+u.Host
+	}
+	var targets []cdnpurge.Target
+	if cfg.CDNPurgeCloudFront != "" {
+		cf, err := cdnpurge.NewCloudFront(context.Background(), cfg.CDNPurgeCloudFront)
+		if err != nil {
+			log.Printf("[WARN] CloudFront invalidation disabled: %v", err)
+		} else {
+			targets = append(targets, cf)
+		}
+	}
+	if cfg.CDNPurgeWebhook != "" {
+		targets = append(targets, cdnpurge.NewWebhook(cfg.CDNPurgeWebhook, origin))
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	p := cdnpurge.New(cdnpurge.ParseTemplates(cfg.CDNPurgePaths, prefix), cfg.CDNPurgeInterval, targets...)
+	if !isLambdaEnvironment() {
+		p.Start()
+	}
+	log.Printf("CDN purge enabled: %s, batches every %s", strings.Join(p.Targets(), ", "), cfg.CDNPurgeInterval)
+	return p
+}
+
+// startTCPServers starts the plain-TCP and gopher retrieval listeners that
+// are enabled in cfg and returns them so they can be closed on shutdown.
+func startTCPServers(cfg *config.Config, store storage.PasteStore, auditLog *audit.Logger) []*tcpserver.Server {
+	pasteService := services.NewPasteService(store, cfg)
+	if burnNotifier := newBurnNotifier(cfg); burnNotifier != nil {
+		pasteService.SetBurnNotifier(burnNotifier)
+	}
+	listeners := []struct {
+		port     int
+		protocol tcpserver.Protocol
+		name     string
+	}{
+		{cfg.TCPPort, tcpserver.ProtocolTCP, "TCP"},
+		{cfg.GopherPort, tcpserver.ProtocolGopher, "gopher"},
+	}
+
+	var servers []*tcpserver.Server
+	for _, l := range listeners {
+		if l.port == 0 {
+			continue
+		}
+		ts := tcpserver.New(pasteService, cfg, l.protocol)
+		ts.SetAuditLogger(auditLog)
+		if runtimeSettings != nil {
+			runtimeSettings.OnChange(func(v settings.Values) {
+				ts.SetRateLimits(v.TCPRateLimit, v.TCPRateLimitIPv6)
+			})
+			current := runtimeSettings.Current()
+			ts.SetRateLimits(current.TCPRateLimit, current.TCPRateLimitIPv6)
+		}
+		if sharedState != nil {
+			ts.SetRateCounter(sharedState)
+		}
+		addr := fmt.Sprintf(":%d", l.port)
+		name := l.name
+		go func() {
+			log.Printf("Starting %s retrieval listener on %s", name, addr)
+			if err := ts.ListenAndServe(addr); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Printf("[ERROR] %s listener stopped: %v", name, err)
+			}
+		}()
+		servers = append(servers, ts)
+	}
+	return servers
+}
@@ -2,13 +2,23 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -17,179 +27,69 @@ import (
 	"github.com/johnwmail/nclip/handlers"
 	"github.com/johnwmail/nclip/handlers/retrieval"
 	"github.com/johnwmail/nclip/handlers/upload"
+	"github.com/johnwmail/nclip/internal/apikeys"
 	"github.com/johnwmail/nclip/internal/services"
+	"github.com/johnwmail/nclip/internal/session"
 	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+	"github.com/johnwmail/nclip/utils"
+	"golang.org/x/net/http2"
 )
 
-// MockStore implements PasteStore for testing
-type MockStore struct {
-	pastes    map[string]*models.Paste
-	readCount map[string]int
-	// For content storage
-	content map[string][]byte
-	dataDir string
+// testStore is the in-memory store main's tests run against. Nothing is
+// written to disk, so tests need no cleanup.
+type testStore struct {
+	*storage.MemoryStore
 }
 
-func NewMockStore(dataDir string) *MockStore {
-	return &MockStore{
-		pastes:    make(map[string]*models.Paste),
-		readCount: make(map[string]int),
-		content:   make(map[string][]byte),
-		dataDir:   dataDir,
-	}
-}
-
-// cleanupTestData removes the test data directory and all its contents.
-// Should be called with defer in each test that creates files.
-func cleanupTestData(dataDir string) {
-	if dataDir == "" {
-		dataDir = "./data"
-	}
-	_ = os.RemoveAll(dataDir)
-}
-
-// StoreContent saves the raw content for a paste
-func (m *MockStore) StoreContent(id string, content []byte) error {
-	m.content[id] = content
-	// Also write the content to disk inside NCLIP_DATA_DIR so handlers that
-	// perform rename/mv inside that directory succeed during tests.
-	dataDir := m.dataDir
-	if dataDir == "" {
-		dataDir = "./data"
-	}
-	if err := os.MkdirAll(dataDir, 0o755); err != nil {
-		return err
-	}
-	contentPath := filepath.Join(dataDir, id)
-	if err := os.WriteFile(contentPath, content, 0o644); err != nil {
-		return err
-	}
-	return nil
-}
-
-// GetContent retrieves the raw content for a paste
-func (m *MockStore) GetContent(id string) ([]byte, error) {
-	c, ok := m.content[id]
-	if !ok {
-		return nil, nil
-	}
-	return c, nil
-}
-
-// GetContentPrefix reads up to n bytes of content for tests and also from the
-// on-disk copy so handlers that do rename/mv in data dir can read preview.
-func (m *MockStore) GetContentPrefix(id string, n int64) (b []byte, reterr error) {
-	if c, ok := m.content[id]; ok {
-		if int64(len(c)) <= n {
-			return c, nil
-		}
-		return c[:n], nil
-	}
-	// Fallback to disk using the configured dataDir on the mock
-	dataDir := m.dataDir
-	if dataDir == "" {
-		dataDir = "./data"
-	}
-	contentPath := filepath.Join(dataDir, id)
-	f, err := os.Open(contentPath)
-	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		if cerr := f.Close(); cerr != nil && reterr == nil {
-			reterr = cerr
-		}
-	}()
-	buf := make([]byte, n)
-	read, err := f.Read(buf)
-	if err != nil && err != io.EOF {
-		return nil, err
-	}
-	b = buf[:read]
-	return b, nil
+func newTestStore() *testStore {
+	return &testStore{MemoryStore: storage.NewMemoryStore()}
 }
 
-func (m *MockStore) Store(paste *models.Paste) error {
-	m.pastes[paste.ID] = paste
+// Store also saves paste.Content, so a fixture is set up in one call.
+func (s *testStore) Store(paste *models.Paste) error {
 	if paste.Content != nil {
-		if err := m.StoreContent(paste.ID, paste.Content); err != nil {
+		if err := s.StoreContent(paste.ID, paste.Content); err != nil {
 			return err
 		}
 	}
-	// Also write metadata file to disk to emulate FilesystemStore behavior for tests.
-	dataDir := m.dataDir
-	if dataDir == "" {
-		dataDir = "./data"
-	}
-	if err := os.MkdirAll(dataDir, 0o755); err != nil {
-		return err
-	}
-	metaPath := filepath.Join(dataDir, paste.ID+".json")
-	b, _ := json.MarshalIndent(paste, "", "  ")
-	_ = os.WriteFile(metaPath, b, 0o644)
-	return nil
-}
-
-func (m *MockStore) Get(id string) (*models.Paste, error) {
-	paste, exists := m.pastes[id]
-	if !exists {
-		return nil, nil
-	}
-	// Check if expired
-	if paste.IsExpired() {
-		delete(m.pastes, id)
-		return nil, nil
-	}
-	return paste, nil
-}
-
-func (m *MockStore) Exists(id string) (bool, error) {
-	_, exists := m.pastes[id]
-	return exists, nil
+	return s.MemoryStore.Store(paste)
 }
 
-func (m *MockStore) Delete(id string) error {
-	delete(m.pastes, id)
-	dataDir := m.dataDir
-	if dataDir == "" {
-		dataDir = "./data"
+// pastes returns every unexpired paste by slug.
+func (s *testStore) pastes() map[string]*models.Paste {
+	pastes := make(map[string]*models.Paste)
+	opts := storage.ListOptions{}
+	for {
+		page, _ := s.List(opts)
+		for _, id := range page.IDs {
+			if paste, err := s.Get(id); err == nil {
+				pastes[id] = paste
+			}
+		}
+		if page.NextCursor == "" {
+			return pastes
+		}
+		opts.Cursor = page.NextCursor
 	}
-	_ = os.Remove(filepath.Join(dataDir, id))
-	_ = os.Remove(filepath.Join(dataDir, id+".json"))
-	delete(m.content, id)
-	return nil
 }
 
-func (m *MockStore) IncrementReadCount(id string) error {
-	if paste, exists := m.pastes[id]; exists {
-		paste.ReadCount++
-		m.readCount[id]++
+// readCount returns the read count of the paste with id, 0 if it is gone.
+func (s *testStore) readCount(id string) int {
+	paste, err := s.Get(id)
+	if err != nil {
+		return 0
 	}
-	return nil
-}
-
-func (m *MockStore) Close() error {
-	return nil
+	return paste.ReadCount
 }
 
-// StatContent reports whether content exists on disk (from dataDir) and its size.
-func (m *MockStore) StatContent(id string) (bool, int64, error) {
-	dataDir := m.dataDir
-	if dataDir == "" {
-		dataDir = "./data"
-	}
-	contentPath := filepath.Join(dataDir, id)
-	st, err := os.Stat(contentPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return false, 0, nil
-		}
-		return false, 0, err
-	}
-	return true, st.Size(), nil
+// hasContent reports whether content is stored under id.
+func (s *testStore) hasContent(id string) bool {
+	ok, _, _ := s.StatContent(id)
+	return ok
 }
 
-func setupTestRouter() (*gin.Engine, *MockStore) {
+func setupTestRouter() (*gin.Engine, *testStore) {
 	gin.SetMode(gin.TestMode)
 
 	cfg := &config.Config{
@@ -199,17 +99,17 @@ func setupTestRouter() (*gin.Engine, *MockStore) {
 		DefaultTTL: 24 * time.Hour,
 	}
 
-	store := NewMockStore(cfg.DataDir)
+	store := newTestStore()
 
 	pasteService := services.NewPasteService(store, cfg)
 	uploadHandler := upload.NewHandler(pasteService, cfg)
 	retrievalHandler := retrieval.NewHandler(pasteService, store, cfg)
 	metaHandler := handlers.NewMetaHandler(store)
-	systemHandler := handlers.NewSystemHandler()
+	systemHandler := handlers.NewSystemHandler(cfg, nil)
 	webuiHandler := handlers.NewWebUIHandler(cfg)
 
 	router := gin.New()
-	router.LoadHTMLGlob("static/*.html")
+	loadTemplates(router, cfg)
 	router.Static("/static", "./static")
 
 	// Routes (WebUI always enabled)
@@ -218,6 +118,8 @@ func setupTestRouter() (*gin.Engine, *MockStore) {
 	router.POST("/burn/", uploadHandler.UploadBurn)
 	router.GET("/:slug", retrievalHandler.View)
 	router.GET("/raw/:slug", retrievalHandler.Raw)
+	router.GET("/download/:slug", retrievalHandler.Download)
+	router.GET("/preview/:file", retrievalHandler.Preview)
 	router.DELETE("/:slug", metaHandler.DeletePaste)
 	router.GET("/api/v1/meta/:slug", metaHandler.GetMetadata)
 	router.GET("/json/:slug", metaHandler.GetMetadata)
@@ -270,7 +172,6 @@ func TestMetricsEndpointRemoved(t *testing.T) {
 
 func TestUploadText(t *testing.T) {
 	router, store := setupTestRouter()
-	defer cleanupTestData(store.dataDir)
 
 	content := "Hello, World!"
 	w := httptest.NewRecorder()
@@ -283,8 +184,8 @@ func TestUploadText(t *testing.T) {
 	}
 
 	// Check if paste was stored
-	if len(store.pastes) != 1 {
-		t.Errorf("Expected 1 paste in store, got %d", len(store.pastes))
+	if len(store.pastes()) != 1 {
+		t.Errorf("Expected 1 paste in store, got %d", len(store.pastes()))
 	}
 
 	// Verify the response contains a URL
@@ -296,7 +197,6 @@ func TestUploadText(t *testing.T) {
 
 func TestGetPaste(t *testing.T) {
 	router, store := setupTestRouter()
-	defer cleanupTestData(store.dataDir)
 
 	// First, create a paste
 	paste := &models.Paste{
@@ -322,7 +222,6 @@ func TestGetPaste(t *testing.T) {
 
 func TestGetRawPaste(t *testing.T) {
 	router, store := setupTestRouter()
-	defer cleanupTestData(store.dataDir)
 
 	content := []byte("raw content")
 	paste := &models.Paste{
@@ -351,7 +250,6 @@ func TestGetRawPaste(t *testing.T) {
 
 func TestGetMetadata(t *testing.T) {
 	router, store := setupTestRouter()
-	defer cleanupTestData(store.dataDir)
 
 	paste := &models.Paste{
 		ID:          "TEST4",
@@ -388,7 +286,6 @@ func TestGetMetadata(t *testing.T) {
 
 func TestGetMetadataAlias(t *testing.T) {
 	router, store := setupTestRouter()
-	defer cleanupTestData(store.dataDir)
 
 	paste := &models.Paste{
 		ID:          "TEST5",
@@ -435,7 +332,6 @@ func TestGetMetadataAlias(t *testing.T) {
 
 func TestBurnAfterRead(t *testing.T) {
 	router, store := setupTestRouter()
-	defer cleanupTestData(store.dataDir)
 
 	content := "burn this"
 	w := httptest.NewRecorder()
@@ -455,7 +351,7 @@ func TestBurnAfterRead(t *testing.T) {
 
 	// Find the paste in store to get its ID
 	var pasteID string
-	for id, paste := range store.pastes {
+	for id, paste := range store.pastes() {
 		if paste.BurnAfterRead {
 			pasteID = id
 			break
@@ -485,9 +381,561 @@ func TestBurnAfterRead(t *testing.T) {
 	}
 }
 
+// Test that a burn link survives link previews fetching its page and only
+// burns the paste when its fragment token is posted.
+func TestBurnLink(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		SlugLength: 5,
+		BufferSize: 5 * 1024 * 1024,
+		DefaultTTL: 24 * time.Hour,
+	}
+	store := newTestStore()
+	router := setupRouter(store, cfg, nil)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+	w := do("POST", "/burn/", "burn this")
+	var resp struct {
+		Slug    string `json:"slug"`
+		BurnURL string `json:"burn_url"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("upload: %d %s", w.Code, w.Body.String())
+	}
+	prefix := "/b/" + resp.Slug + "#k="
+	i := strings.Index(resp.BurnURL, prefix)
+	if i < 0 || w.Header().Get("X-Burn-URL") != resp.BurnURL {
+		t.Fatalf("unexpected burn URL %q (header %q)", resp.BurnURL, w.Header().Get("X-Burn-URL"))
+	}
+	token := resp.BurnURL[i+len(prefix):]
+
+	// A link preview fetches the page without the fragment.
+	if w := do("GET", "/b/"+resp.Slug, ""); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "burn this") {
+		t.Fatalf("burn page: %d, content leaked: %v", w.Code, strings.Contains(w.Body.String(), "burn this"))
+	}
+	if w := do("POST", "/b/"+resp.Slug, `{"token":"wrong"}`); w.Code != http.StatusNotFound {
+		t.Errorf("wrong token: expected 404, got %d", w.Code)
+	}
+	if _, ok := store.pastes()[resp.Slug]; !ok {
+		t.Fatal("paste burned without its token")
+	}
+
+	w = do("POST", "/b/"+resp.Slug, `{"token":"`+token+`"}`)
+	if w.Code != http.StatusOK || w.Body.String() != "burn this" {
+		t.Fatalf("reveal: expected content, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("POST", "/b/"+resp.Slug, `{"token":"`+token+`"}`); w.Code != http.StatusNotFound {
+		t.Errorf("second reveal: expected 404, got %d", w.Code)
+	}
+	if w := do("GET", "/api/v1/meta/"+resp.Slug, ""); w.Code != http.StatusNotFound {
+		t.Errorf("metadata after burn: expected 404, got %d", w.Code)
+	}
+}
+
+func TestRoutePrefix(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		SlugLength:    5,
+		BufferSize:    5 * 1024 * 1024,
+		DefaultTTL:    24 * time.Hour,
+		MaxRenderSize: 1024,
+		RoutePrefix:   "/paste",
+	}
+	store := newTestStore()
+	router := setupRouter(store, cfg, nil)
+
+	do := func(method, path, body string, header ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/paste/", "hello prefix", "Accept", "application/json")
+	var resp struct {
+		URL       string `json:"url"`
+		Slug      string `json:"slug"`
+		ManageURL string `json:"manage_url"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("upload: %d %s", w.Code, w.Body.String())
+	}
+	if !strings.HasSuffix(resp.URL, "/paste/"+resp.Slug) || !strings.Contains(resp.ManageURL, "/paste/manage/"+resp.Slug) {
+		t.Errorf("generated URLs miss the prefix: %s, %s", resp.URL, resp.ManageURL)
+	}
+
+	for _, path := range []string{"/paste/raw/", "/paste/r/", "/paste/d/"} {
+		if w := do("GET", path+resp.Slug, ""); w.Code != http.StatusOK || w.Body.String() != "hello prefix" {
+			t.Errorf("GET %s: %d %q", path+resp.Slug, w.Code, w.Body.String())
+		}
+	}
+	if w := do("GET", "/paste/d/"+resp.Slug, ""); !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment") {
+		t.Errorf("/d/ alias: expected an attachment, got %q", w.Header().Get("Content-Disposition"))
+	}
+	if w := do("GET", "/raw/"+resp.Slug, ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET outside the prefix: expected 404, got %d", w.Code)
+	}
+
+	w = do("GET", "/paste/"+resp.Slug, "", "Accept", "text/html", "User-Agent", "Mozilla/5.0")
+	if w.Code != http.StatusOK {
+		t.Fatalf("view: %d", w.Code)
+	}
+	for _, link := range []string{`href="/paste/static/style.css`, `href="/paste/raw/` + resp.Slug} {
+		if !strings.Contains(w.Body.String(), link) {
+			t.Errorf("view page lacks %s", link)
+		}
+	}
+	if w := do("GET", "/paste/static/script.js", ""); w.Code != http.StatusOK {
+		t.Errorf("static asset under the prefix: %d", w.Code)
+	}
+}
+
+func TestForwardedPrefix(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		SlugLength:     5,
+		BufferSize:     5 * 1024 * 1024,
+		DefaultTTL:     24 * time.Hour,
+		MaxRenderSize:  1024,
+		RoutePrefix:    "/paste",
+		TrustedProxies: "10.0.0.0/8",
+	}
+	store := newTestStore()
+	router := setupRouter(store, cfg, nil)
+
+	do := func(remote, method, path, body string, header ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-Prefix", "/tools/nclip")
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	upload := func(remote string) string {
+		w := do(remote, "POST", "/paste/", "hello proxy", "Accept", "application/json")
+		var resp struct {
+			URL string `json:"url"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("upload: %d %s", w.Code, w.Body.String())
+		}
+		return resp.URL
+	}
+
+	pasteURL := upload("10.1.2.3:41000")
+	if !strings.Contains(pasteURL, "/tools/nclip/paste/") {
+		t.Errorf("expected the URL below the forwarded prefix, got %s", pasteURL)
+	}
+	if url := upload("203.0.113.9:41000"); strings.Contains(url, "/tools/nclip") {
+		t.Errorf("expected the prefix of an untrusted client to be ignored, got %s", url)
+	}
+
+	slug := pasteURL[strings.LastIndex(pasteURL, "/")+1:]
+	w := do("10.1.2.3:41000", "GET", "/paste/"+slug, "", "Accept", "text/html", "User-Agent", "Mozilla/5.0")
+	for _, link := range []string{`href="/tools/nclip/paste/static/style.css`, `href="/tools/nclip/paste/raw/` + slug} {
+		if !strings.Contains(w.Body.String(), link) {
+			t.Errorf("view page lacks %s", link)
+		}
+	}
+	w = do("10.1.2.3:41000", "GET", "/paste/", "", "Accept", "text/html")
+	if !strings.Contains(w.Body.String(), `<meta name="route-prefix" content="/tools/nclip/paste">`) {
+		t.Errorf("expected the web UI to call the API below the prefix")
+	}
+}
+
+func TestBrandingAndOverrides(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	override := t.TempDir()
+	if err := os.WriteFile(filepath.Join(override, "style.css"), []byte("body { color: teal; }"), 0644); err != nil {
+		t.Fatalf("failed to write override: %v", err)
+	}
+	cfg := &config.Config{
+		SlugLength:  5,
+		BufferSize:  5 * 1024 * 1024,
+		DefaultTTL:  24 * time.Hour,
+		OverrideDir: override,
+		SiteName:    "Acme Paste",
+		LogoURL:     "https://cdn.example.com/logo.svg",
+		FooterHTML:  `<span class="legal">&copy; Acme Corp</span>`,
+		ImprintURL:  "https://example.com/imprint",
+	}
+	store := newTestStore()
+	router := setupRouter(store, cfg, nil)
+
+	for _, path := range []string{"/", "/missing-page"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", "text/html")
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		router.ServeHTTP(w, req)
+		body := w.Body.String()
+		for _, want := range []string{"<title>Acme Paste - ", `src="https://cdn.example.com/logo.svg"`,
+			`<span class="legal">&copy; Acme Corp</span>`, `href="https://example.com/imprint"`} {
+			if !strings.Contains(body, want) {
+				t.Errorf("GET %s: page lacks %s", path, want)
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/static/style.css", nil)
+	router.ServeHTTP(w, req)
+	if w.Body.String() != "body { color: teal; }" {
+		t.Errorf("expected the overriding stylesheet, got %q", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/static/script.js", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.Len() == 0 {
+		t.Errorf("expected the built-in script without an override, got %d", w.Code)
+	}
+}
+
+// Test that pastes can be framed from /embed/:slug, except burn-after-read
+// and private ones, and that embedding can be switched off.
+func TestEmbed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		SlugLength:          5,
+		BufferSize:          5 * 1024 * 1024,
+		DefaultTTL:          24 * time.Hour,
+		MaxRenderSize:       1024,
+		EmbedFrameAncestors: "https://blog.example.com",
+	}
+	store := newTestStore()
+	router := setupRouter(store, cfg, nil)
+
+	add := func(id string, paste models.Paste, content string) {
+		paste.ID, paste.CreatedAt, paste.Size = id, time.Now(), int64(len(content))
+		if err := store.StoreContent(id, []byte(content)); err != nil {
+			t.Fatalf("failed to store content: %v", err)
+		}
+		if err := store.Store(&paste); err != nil {
+			t.Fatalf("failed to store paste: %v", err)
+		}
+	}
+	add("EMBED", models.Paste{ContentType: "text/plain"}, "func main() { // hi\n\treturn\n}\n")
+	add("BURNS", models.Paste{ContentType: "text/plain", BurnAfterRead: true}, "secret")
+	add("PRVTE", models.Paste{ContentType: "text/plain", Visibility: models.VisibilityPrivate}, "private")
+	add("PHQTS", models.Paste{ContentType: "image/png"}, "\x89PNG")
+
+	get := func(r *gin.Engine, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		r.ServeHTTP(w, req)
+		return w
+	}
+	w := get(router, "/embed/EMBED")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if csp := w.Header().Get("Content-Security-Policy"); !strings.HasSuffix(csp, "frame-ancestors https://blog.example.com") {
+		t.Errorf("unexpected CSP %q", csp)
+	}
+	body := w.Body.String()
+	for _, want := range []string{`<span class="tok-keyword">func</span>`, `<span class="tok-comment">// hi</span>`, "/raw/EMBED", "/EMBED\""} {
+		if !strings.Contains(body, want) {
+			t.Errorf("embed page lacks %s", want)
+		}
+	}
+	if _, ok := store.pastes()["EMBED"]; !ok {
+		t.Error("embedding deleted the paste")
+	}
+
+	for path, code := range map[string]int{
+		"/embed/BURNS": http.StatusForbidden,
+		"/embed/PHQTS": http.StatusForbidden,
+		"/embed/PRVTE": http.StatusNotFound,
+		"/embed/NXPES": http.StatusNotFound,
+	} {
+		if w := get(router, path); w.Code != code {
+			t.Errorf("GET %s: expected %d, got %d", path, code, w.Code)
+		}
+	}
+	if _, ok := store.pastes()["BURNS"]; !ok {
+		t.Error("refusing to embed burned the paste")
+	}
+	if w := get(router, "/embed.js"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "/embed/") {
+		t.Errorf("expected the embed script, got %d", w.Code)
+	}
+
+	cfg.EmbedFrameAncestors = ""
+	router = setupRouter(store, cfg, nil)
+	if w := get(router, "/embed.js"); w.Code == http.StatusOK {
+		t.Errorf("embedding disabled: /embed.js still served (%d)", w.Code)
+	}
+	if w := get(router, "/embed/EMBED"); w.Code == http.StatusOK && strings.Contains(w.Body.String(), "tok-keyword") {
+		t.Error("embedding disabled: embed page still served")
+	}
+}
+
+func TestVersionHistory(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		SlugLength:    5,
+		BufferSize:    5 * 1024 * 1024,
+		DefaultTTL:    24 * time.Hour,
+		MaxRenderSize: 1024,
+		MaxVersions:   2,
+	}
+	store := newTestStore()
+	router := setupRouter(store, cfg, nil)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("User-Agent", "curl/8.0")
+		router.ServeHTTP(w, req)
+		return w
+	}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/", strings.NewReader("one"))
+	req.Header.Set("Accept", "application/json")
+	router.ServeHTTP(w, req)
+	var resp struct {
+		Slug      string `json:"slug"`
+		ManageURL string `json:"manage_url"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("upload: %d %s", w.Code, w.Body.String())
+	}
+	token := resp.ManageURL[strings.Index(resp.ManageURL, "?token=")+len("?token="):]
+
+	if w := do("PUT", "/"+resp.Slug, "two"); w.Code != http.StatusNotFound {
+		t.Fatalf("PUT without manage token: expected 404, got %d", w.Code)
+	}
+	for i, body := range []string{"two", "three", "four"} {
+		w := do("PUT", "/"+resp.Slug+"?token="+token, body)
+		if w.Code != http.StatusOK || w.Header().Get("X-Paste-Version") != strconv.Itoa(i+2) {
+			t.Fatalf("PUT %q: %d %s (version %q)", body, w.Code, w.Body.String(), w.Header().Get("X-Paste-Version"))
+		}
+	}
+
+	if w := do("GET", "/"+resp.Slug, ""); w.Body.String() != "four" {
+		t.Errorf("current content: got %q", w.Body.String())
+	}
+	for n, want := range map[int]string{2: "two", 3: "three", 4: "four"} {
+		if w := do("GET", "/"+resp.Slug+"?version="+strconv.Itoa(n), ""); w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("version %d: got %d %q, want %q", n, w.Code, w.Body.String(), want)
+		}
+	}
+	if w := do("GET", "/raw/"+resp.Slug+"?version=3", ""); w.Body.String() != "three" {
+		t.Errorf("raw version 3: got %q", w.Body.String())
+	}
+	// Only MaxVersions earlier versions are kept.
+	if w := do("GET", "/"+resp.Slug+"?version=1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("pruned version: expected 404, got %d", w.Code)
+	}
+	if store.hasContent(storage.VersionID(resp.Slug, 1)) {
+		t.Error("pruned version content was not deleted")
+	}
+
+	w = do("GET", "/api/v1/pastes/"+resp.Slug+"/versions", "")
+	var list struct {
+		Current  int `json:"current"`
+		Versions []struct {
+			Version int   `json:"version"`
+			Size    int64 `json:"size"`
+			Current bool  `json:"current"`
+		} `json:"versions"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("versions: %d %s", w.Code, w.Body.String())
+	}
+	if list.Current != 4 || len(list.Versions) != 3 || list.Versions[0].Version != 2 || list.Versions[0].Size != 3 || !list.Versions[2].Current {
+		t.Errorf("unexpected versions listing: %s", w.Body.String())
+	}
+
+	// Versions go with the paste.
+	if w := do("DELETE", "/"+resp.Slug, ""); w.Code != http.StatusOK {
+		t.Fatalf("delete: %d %s", w.Code, w.Body.String())
+	}
+	for _, n := range []int{2, 3} {
+		if store.hasContent(storage.VersionID(resp.Slug, n)) {
+			t.Errorf("version %d left behind after delete", n)
+		}
+	}
+}
+
+func TestAppendablePaste(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		SlugLength:    5,
+		BufferSize:    16,
+		DefaultTTL:    24 * time.Hour,
+		MaxRenderSize: 1024,
+	}
+	store := newTestStore()
+	router := setupRouter(store, cfg, nil)
+
+	upload := func(headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/", strings.NewReader("start\n"))
+		req.Header.Set("Accept", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	if w := upload(map[string]string{"X-Appendable": "true", "X-Burn": "true"}); w.Code != http.StatusBadRequest {
+		t.Errorf("burn-after-read appendable upload: expected 400, got %d", w.Code)
+	}
+	w := upload(map[string]string{"X-Appendable": "true"})
+	var resp struct {
+		Slug      string `json:"slug"`
+		AppendURL string `json:"append_url"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.AppendURL == "" {
+		t.Fatalf("upload: %d %s", w.Code, w.Body.String())
+	}
+	appendPath := resp.AppendURL[strings.Index(resp.AppendURL, "/api/"):]
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+	if w := post("/api/v1/pastes/"+resp.Slug+"/append", "x"); w.Code != http.StatusNotFound {
+		t.Errorf("append without manage token: expected 404, got %d", w.Code)
+	}
+	if w := post(appendPath, "more\n"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"size":11`) {
+		t.Fatalf("append: %d %s", w.Code, w.Body.String())
+	}
+	if w := post(appendPath, "far too much\n"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("append past the upload limit: expected 413, got %d", w.Code)
+	}
+	if w := post(appendPath+"&final=true", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"appendable":false`) {
+		t.Fatalf("final append: %d %s", w.Code, w.Body.String())
+	}
+	if w := post(appendPath, "late"); w.Code != http.StatusConflict {
+		t.Errorf("append after final: expected 409, got %d", w.Code)
+	}
+
+	// A finished paste is sent in full and the follow read ends.
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/raw/"+resp.Slug+"?follow=true", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "start\nmore\n" {
+		t.Errorf("follow: %d %q", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/raw/"+resp.Slug+"?follow=true&tail=1", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("follow with a line filter: expected 400, got %d", w.Code)
+	}
+}
+
+func TestChecksumURLs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		SlugLength:   5,
+		BufferSize:   5 * 1024 * 1024,
+		DefaultTTL:   24 * time.Hour,
+		MaxVersions:  1,
+		ChecksumURLs: true,
+	}
+	store := newTestStore()
+	router := setupRouter(store, cfg, nil)
+
+	do := func(method, path, body string, header ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Accept", "application/json")
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	upload := func(body string, header ...string) (slug, token string) {
+		w := do("POST", "/", body, header...)
+		var resp struct {
+			Slug      string `json:"slug"`
+			ManageURL string `json:"manage_url"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("upload: %d %s", w.Code, w.Body.String())
+		}
+		return resp.Slug, resp.ManageURL[strings.Index(resp.ManageURL, "?token=")+len("?token="):]
+	}
+
+	slug, token := upload("checksum me")
+	sum := utils.Checksum([]byte("checksum me"))
+	w := do("GET", "/sha256/"+sum, "")
+	if w.Code != http.StatusOK || w.Body.String() != "checksum me" || w.Header().Get("ETag") != `"`+sum+`"` {
+		t.Fatalf("GET by checksum: %d %q (ETag %q)", w.Code, w.Body.String(), w.Header().Get("ETag"))
+	}
+	if store.readCount(slug) != 1 {
+		t.Errorf("expected a read by checksum to count, got %d reads", store.readCount(slug))
+	}
+	var meta struct {
+		SHA256 string `json:"sha256"`
+	}
+	if w := do("GET", "/api/v1/meta/"+slug, ""); json.Unmarshal(w.Body.Bytes(), &meta) != nil || meta.SHA256 != sum {
+		t.Errorf("metadata does not report the checksum: %s", w.Body.String())
+	}
+	if w := do("GET", "/sha256/"+strings.ToUpper(sum), ""); w.Code != http.StatusBadRequest {
+		t.Errorf("uppercase hash: expected 400, got %d", w.Code)
+	}
+
+	// Burn-after-read pastes are not served by checksum.
+	upload("burn me", "X-Burn", "true")
+	if w := do("GET", "/sha256/"+utils.Checksum([]byte("burn me")), ""); w.Code != http.StatusNotFound {
+		t.Errorf("burn-after-read paste: expected 404, got %d", w.Code)
+	}
+
+	// Replacing the content moves the paste to the new checksum.
+	if w := do("PUT", "/"+slug+"?token="+token, "replaced"); w.Code != http.StatusOK {
+		t.Fatalf("PUT: %d %s", w.Code, w.Body.String())
+	}
+	if w := do("GET", "/sha256/"+sum, ""); w.Code != http.StatusNotFound {
+		t.Errorf("old checksum after replace: expected 404, got %d", w.Code)
+	}
+	if w := do("GET", "/sha256/"+utils.Checksum([]byte("replaced")), ""); w.Code != http.StatusOK || w.Body.String() != "replaced" {
+		t.Errorf("new checksum after replace: %d %q", w.Code, w.Body.String())
+	}
+
+	// A paste whose content changed behind the index is not served.
+	if err := store.StoreContent(slug, []byte("tampered")); err != nil {
+		t.Fatal(err)
+	}
+	if w := do("GET", "/sha256/"+utils.Checksum([]byte("replaced")), ""); w.Code != http.StatusNotFound {
+		t.Errorf("tampered content: expected 404, got %d", w.Code)
+	}
+
+	// Without the setting the route does not exist.
+	cfg.ChecksumURLs = false
+	router = setupRouter(newTestStore(), cfg, nil)
+	if w := do("GET", "/sha256/"+sum, ""); w.Code != http.StatusNotFound {
+		t.Errorf("checksum URLs disabled: expected 404, got %d", w.Code)
+	}
+}
+
 func TestNotFound(t *testing.T) {
-	router, store := setupTestRouter()
-	defer cleanupTestData(store.dataDir)
+	router, _ := setupTestRouter()
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/ABCDE", nil) // Valid slug format that doesn't exist
@@ -499,8 +947,7 @@ func TestNotFound(t *testing.T) {
 }
 
 func TestInvalidSlug(t *testing.T) {
-	router, store := setupTestRouter()
-	defer cleanupTestData(store.dataDir)
+	router, _ := setupTestRouter()
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/invalid-slug!", nil)
@@ -614,7 +1061,7 @@ func TestAPIKeyAuth(t *testing.T) {
 
 			// Create test router with auth middleware
 			router := gin.New()
-			authMiddleware := apiKeyAuth(cfg)
+			authMiddleware := apiKeyAuth(apikeys.Parse(cfg.APIKeys))
 			router.POST("/test", authMiddleware, func(c *gin.Context) {
 				c.JSON(http.StatusOK, gin.H{"message": "success"})
 			})
@@ -654,6 +1101,79 @@ func TestAPIKeyAuth(t *testing.T) {
 }
 
 // TestAPIKeyAuthEmptyKeys tests behavior when no API keys are configured
+// stalledBody is an upload body that signals when it is first read and
+// then blocks until it is released.
+type stalledBody struct {
+	started, release chan struct{}
+	once             sync.Once
+}
+
+func newStalledBody() *stalledBody {
+	return &stalledBody{started: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (b *stalledBody) Read(p []byte) (int, error) {
+	b.once.Do(func() { close(b.started) })
+	<-b.release
+	return 0, io.EOF
+}
+
+func TestUploadConcurrency(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		APIKeys:                    "key1,key2",
+		SlugLength:                 5,
+		BufferSize:                 5 * 1024 * 1024,
+		DefaultTTL:                 24 * time.Hour,
+		MaxConcurrentUploads:       2,
+		MaxConcurrentUploadsPerKey: 1,
+	}
+	router := setupRouter(newTestStore(), cfg, nil)
+
+	upload := func(body io.Reader, key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/", body)
+		if key != "" {
+			req.Header.Set("X-Api-Key", key)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	var wg sync.WaitGroup
+	stall := func(key string) *stalledBody {
+		body := newStalledBody()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			upload(body, key)
+		}()
+		<-body.started
+		return body
+	}
+
+	first := stall("key1")
+	w := upload(strings.NewReader("hello"), "key1")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" || !strings.Contains(w.Body.String(), "from this client") {
+		t.Fatalf("second upload of key1: got %d %s (Retry-After %q)", w.Code, w.Body.String(), w.Header().Get("Retry-After"))
+	}
+	if w := upload(strings.NewReader("hello"), "key2"); w.Code != http.StatusOK {
+		t.Fatalf("upload of key2: got %d %s", w.Code, w.Body.String())
+	}
+
+	second := stall("key2")
+	if w := upload(strings.NewReader("hello"), ""); w.Code != http.StatusTooManyRequests || strings.Contains(w.Body.String(), "from this client") {
+		t.Fatalf("upload beyond the overall cap: got %d %s", w.Code, w.Body.String())
+	}
+
+	close(first.release)
+	close(second.release)
+	wg.Wait()
+	if w := upload(strings.NewReader("hello"), "key1"); w.Code != http.StatusOK {
+		t.Errorf("upload after the others finished: got %d %s", w.Code, w.Body.String())
+	}
+}
+
 func TestAPIKeyAuthEmptyKeys(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -662,7 +1182,7 @@ func TestAPIKeyAuthEmptyKeys(t *testing.T) {
 	}
 
 	router := gin.New()
-	authMiddleware := apiKeyAuth(cfg)
+	authMiddleware := apiKeyAuth(apikeys.Parse(cfg.APIKeys))
 	router.POST("/test", authMiddleware, func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "success"})
 	})
@@ -688,7 +1208,7 @@ func TestAPIKeyAuthWhitespaceInConfig(t *testing.T) {
 	}
 
 	router := gin.New()
-	authMiddleware := apiKeyAuth(cfg)
+	authMiddleware := apiKeyAuth(apikeys.Parse(cfg.APIKeys))
 	router.POST("/test", authMiddleware, func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "success"})
 	})
@@ -733,11 +1253,10 @@ func TestUploadAuthEnforced(t *testing.T) {
 		DefaultTTL: 24 * time.Hour,
 	}
 
-	store := NewMockStore(cfg.DataDir)
-	defer cleanupTestData(store.dataDir)
+	store := newTestStore()
 
 	// Use the real setupRouter so middleware wiring is exercised
-	router := setupRouter(store, cfg)
+	router := setupRouter(store, cfg, nil)
 
 	// POST without any auth should be rejected
 	w := httptest.NewRecorder()
@@ -757,19 +1276,488 @@ func TestUploadAuthEnforced(t *testing.T) {
 	}
 }
 
-func TestNotFoundBrowser(t *testing.T) {
-	// The main router setup includes the canonicalErrors middleware,
-	// so this test will correctly exercise the logic that returns
-	// HTML for browser-like requests.
-	router, store := setupTestRouter()
-	defer cleanupTestData(store.dataDir)
-
-	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("GET", "/ABADPASTE", nil)
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/117.0.0.0 Safari/537.36")
-
-	router.ServeHTTP(w, req)
+// TestAPIKeyScopes verifies that keys from the keys file reach only the
+// routes their scopes allow, while keys from NCLIP_API_KEYS reach all.
+func TestAPIKeyScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(keysFile, []byte("ci write\nburner burn\ndash read\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		APIKeys:     "testkey",
+		APIKeysFile: keysFile,
+		UploadAuth:  true,
+		SlugLength:  5,
+		BufferSize:  5 * 1024 * 1024,
+		DefaultTTL:  24 * time.Hour,
+	}
+	store := newTestStore()
+	router := setupRouter(store, cfg, nil)
+
+	do := func(method, path, key string, headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString("hello"))
+		req.Header.Set("X-Api-Key", key)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	cases := []struct {
+		name    string
+		method  string
+		path    string
+		key     string
+		headers map[string]string
+		want    int
+	}{
+		{"write uploads", "POST", "/", "ci", nil, http.StatusOK},
+		{"burn-only burns", "POST", "/burn/", "burner", nil, http.StatusOK},
+		{"burn-only burns via header", "POST", "/", "burner", map[string]string{"X-Burn": "1"}, http.StatusOK},
+		{"burn-only cannot keep", "POST", "/", "burner", nil, http.StatusForbidden},
+		{"read cannot upload", "POST", "/", "dash", nil, http.StatusForbidden},
+		{"read lists", "GET", "/api/v1/pastes", "dash", nil, http.StatusOK},
+		{"write cannot list", "GET", "/api/v1/pastes", "ci", nil, http.StatusForbidden},
+		{"write cannot delete", "DELETE", "/api/v1/pastes?tag=x", "ci", nil, http.StatusForbidden},
+		{"admin lists", "GET", "/api/v1/pastes", "testkey", nil, http.StatusOK},
+		{"unknown key", "GET", "/api/v1/pastes", "mallory", nil, http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		w := do(tc.method, tc.path, tc.key, tc.headers)
+		if w.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.want, w.Code, w.Body.String())
+		}
+		if w.Code == http.StatusForbidden && !strings.Contains(w.Body.String(), "insufficient_scope") {
+			t.Errorf("%s: expected insufficient_scope, got %s", tc.name, w.Body.String())
+		}
+	}
+}
+
+// Test that /:slug.txt, .md and .html serve their representation whatever
+// the client asks for, and refuse pastes a link preview would burn.
+func TestSnippetFormats(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		SlugLength:    5,
+		BufferSize:    5 * 1024 * 1024,
+		DefaultTTL:    24 * time.Hour,
+		MaxRenderSize: 1024,
+	}
+	store := newTestStore()
+	router := setupRouter(store, cfg, nil)
+
+	content := "fmt.Println(\"<b>\")\n```\n"
+	expires := time.Now().Add(time.Hour)
+	for _, p := range []*models.Paste{
+		{ID: "SNPET", ContentType: "text/plain; charset=utf-8", Filename: "main.go", ExpiresAt: &expires, Content: []byte(content)},
+		{ID: "BURNS", ContentType: "text/plain", BurnAfterRead: true, Content: []byte("secret")},
+		{ID: "PHQTS", ContentType: "image/png", Content: []byte("\x89PNG")},
+	} {
+		p.CreatedAt, p.Size = time.Now(), int64(len(p.Content))
+		if err := store.Store(p); err != nil {
+			t.Fatalf("failed to store paste: %v", err)
+		}
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/SNPET.txt")
+	if w.Code != http.StatusOK || w.Body.String() != content || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf(".txt: unexpected response %d %q (%s)", w.Code, w.Body.String(), w.Header().Get("Content-Type"))
+	}
+	w = get("/SNPET.md")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/markdown") {
+		t.Fatalf(".md: unexpected response %d (%s)", w.Code, w.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{"# main.go\n", "/SNPET\n", "- Size: ", "````go\n" + strings.TrimSuffix(content, "\n") + "\n````\n"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf(".md lacks %q:\n%s", want, w.Body.String())
+		}
+	}
+	w = get("/SNPET.html")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf(".html: unexpected response %d (%s)", w.Code, w.Header().Get("Content-Type"))
+	}
+	if body := w.Body.String(); !strings.Contains(body, "&lt;b&gt;") || strings.Contains(body, "<b>") || strings.Contains(body, "<script") {
+		t.Errorf(".html: expected escaped content without scripts, got %s", body)
+	}
+
+	for path, code := range map[string]int{
+		"/BURNS.txt":  http.StatusForbidden,
+		"/PHQTS.html": http.StatusForbidden,
+		"/NXPES.md":   http.StatusNotFound,
+		"/SNPET.exe":  http.StatusBadRequest,
+	} {
+		if w := get(path); w.Code != code {
+			t.Errorf("GET %s: expected %d, got %d", path, code, w.Code)
+		}
+	}
+	if _, ok := store.pastes()["BURNS"]; !ok {
+		t.Error("refusing a snippet burned the paste")
+	}
+}
+
+// TestRuntimeSettings verifies that admins change the default TTL and the
+// read-only flag through the admin API, and that a read-only deployment
+// refuses uploads but still lets the settings be changed back.
+func TestRuntimeSettings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		APIKeys:    "testkey",
+		UploadAuth: true,
+		SlugLength: 5,
+		BufferSize: 5 * 1024 * 1024,
+		DefaultTTL: 24 * time.Hour,
+	}
+	store := newTestStore()
+	router := setupRouter(store, cfg, nil)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Api-Key", "testkey")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("PATCH", "/api/v1/admin/settings", `{"default_ttl": "-1h"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid TTL, got %d: %s", w.Code, w.Body.String())
+	}
+	w := do("PATCH", "/api/v1/admin/settings", `{"default_ttl": "2h", "read_only": true}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"default_ttl":"2h0m0s"`) {
+		t.Fatalf("expected the settings to change, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("GET", "/api/v1/config", ""); !strings.Contains(w.Body.String(), `"read_only":true`) {
+		t.Errorf("expected the public config to report read-only mode, got %s", w.Body.String())
+	}
+
+	w = do("POST", "/", "hello")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "read_only") || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 503 read_only for an upload, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := do("PATCH", "/api/v1/admin/settings", `{"read_only": null}`); w.Code != http.StatusOK {
+		t.Fatalf("expected read-only mode to be turned off, got %d: %s", w.Code, w.Body.String())
+	}
+	w = do("POST", "/", "hello")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the upload to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, paste := range store.pastes() {
+		if paste.ExpiresAt == nil || time.Until(*paste.ExpiresAt) > 2*time.Hour {
+			t.Errorf("expected the paste to expire within the new default TTL, got %v", paste.ExpiresAt)
+		}
+	}
+}
+
+// TestUploadSizeTiers verifies that the keys file overrides the upload
+// size limit per API key and for uploads without one.
+func TestUploadSizeTiers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(keysFile, []byte("* max_size=8\nbig write max_size=64\nplain write\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		APIKeysFile: keysFile,
+		SlugLength:  5,
+		BufferSize:  16,
+		DefaultTTL:  24 * time.Hour,
+	}
+	store := newTestStore()
+	router := setupRouter(store, cfg, nil)
+
+	cases := []struct {
+		name   string
+		key    string
+		size   int
+		want   int
+		detail string
+	}{
+		{"anonymous within its tier", "", 8, http.StatusOK, ""},
+		{"anonymous over its tier", "", 9, http.StatusRequestEntityTooLarge, "the upload limit for uploads without an API key is 8 bytes"},
+		{"unknown key is anonymous", "mallory", 9, http.StatusRequestEntityTooLarge, "uploads without an API key"},
+		{"key above the global limit", "big", 64, http.StatusOK, ""},
+		{"key over its tier", "big", 65, http.StatusRequestEntityTooLarge, "the upload limit for this API key is 64 bytes"},
+		{"key without a tier", "plain", 17, http.StatusRequestEntityTooLarge, "the upload limit for this server is 16 bytes"},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/", strings.NewReader(strings.Repeat("a", tc.size)))
+		if tc.key != "" {
+			req.Header.Set("X-Api-Key", tc.key)
+		}
+		router.ServeHTTP(w, req)
+		if w.Code != tc.want || !strings.Contains(w.Body.String(), tc.detail) {
+			t.Errorf("%s: expected %d with %q, got %d: %s", tc.name, tc.want, tc.detail, w.Code, w.Body.String())
+		}
+	}
+}
+
+// TestProofOfWork verifies that uploads without an API key need a solved
+// challenge that can be used only once, while API key uploads skip it.
+func TestProofOfWork(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		APIKeys:       "testkey",
+		PoWDifficulty: 4,
+		SessionSecret: "test-secret",
+		SlugLength:    5,
+		BufferSize:    5 * 1024 * 1024,
+		DefaultTTL:    24 * time.Hour,
+	}
+	store := newTestStore()
+	router := setupRouter(store, cfg, nil)
+
+	upload := func(headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/", bytes.NewBufferString("hello"))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	if w := upload(nil); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "pow_required") {
+		t.Fatalf("expected 403 pow_required without a solution, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := upload(map[string]string{"X-Api-Key": "testkey"}); w.Code != http.StatusOK {
+		t.Fatalf("expected API key upload to skip proof of work, got %d: %s", w.Code, w.Body.String())
+	}
+
+	solution := solvePoW(t, router)
+	if w := upload(map[string]string{"X-PoW": solution}); w.Code != http.StatusOK {
+		t.Fatalf("expected upload with a solution to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := upload(map[string]string{"X-PoW": solution}); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "pow_invalid") {
+		t.Fatalf("expected replayed solution to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// solvePoW fetches a challenge of difficulty 4 from router and returns a
+// solution for the X-PoW header.
+func solvePoW(t *testing.T, router http.Handler) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/challenge", nil)
+	router.ServeHTTP(w, req)
+	var ch struct {
+		Nonce      string `json:"nonce"`
+		Difficulty int    `json:"difficulty"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &ch); err != nil || ch.Nonce == "" || ch.Difficulty != 4 {
+		t.Fatalf("unexpected challenge response %d: %s", w.Code, w.Body.String())
+	}
+	for i := 0; ; i++ {
+		candidate := ch.Nonce + ":" + strconv.Itoa(i)
+		if sum := sha256.Sum256([]byte(candidate)); sum[0]>>4 == 0 {
+			return candidate
+		}
+	}
+}
+
+// TestSessionUploads verifies that the web UI session cookie and CSRF token
+// issued at / allow browser uploads with a proof of work when
+// SessionUploads is enabled, while requests with a session but no token
+// or no proof of work are rejected.
+func TestSessionUploads(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		APIKeys:        "testkey",
+		UploadAuth:     true,
+		SessionUploads: true,
+		PoWDifficulty:  4,
+		SessionSecret:  "test-secret",
+		SessionTTL:     time.Hour,
+		SlugLength:     5,
+		BufferSize:     5 * 1024 * 1024,
+		DefaultTTL:     24 * time.Hour,
+	}
+
+	store := newTestStore()
+	router := setupRouter(store, cfg, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/html")
+	router.ServeHTTP(w, req)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != session.CookieName || !cookies[0].HttpOnly {
+		t.Fatalf("expected HttpOnly session cookie, got %v", cookies)
+	}
+	m := regexp.MustCompile(`name="csrf_token" value="([0-9a-f]+)"`).FindStringSubmatch(w.Body.String())
+	if m == nil {
+		t.Fatal("expected CSRF token embedded in the upload form")
+	}
+	token := m[1]
+
+	upload := func(csrf, solution string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/", bytes.NewBufferString("hello"))
+		req.AddCookie(cookies[0])
+		if csrf != "" {
+			req.Header.Set(session.CSRFHeader, csrf)
+		}
+		if solution != "" {
+			req.Header.Set("X-PoW", solution)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := upload("", solvePoW(t, router)); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "csrf_invalid") {
+		t.Fatalf("expected 403 csrf_invalid without token, got %d (body: %s)", w.Code, w.Body.String())
+	}
+	if w := upload("deadbeef", solvePoW(t, router)); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 with wrong token, got %d", w.Code)
+	}
+	// A session is handed to anyone loading /, so it does not spare the
+	// proof of work.
+	if w := upload(token, ""); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "pow_required") {
+		t.Fatalf("expected 403 pow_required without a solution, got %d (body: %s)", w.Code, w.Body.String())
+	}
+	w = upload(token, solvePoW(t, router))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected session upload to succeed, got %d (body: %s)", w.Code, w.Body.String())
+	}
+
+	// Session authentication covers uploads only; deletes still need a key.
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse upload response: %v", err)
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/"+resp["slug"].(string), nil)
+	req.AddCookie(cookies[0])
+	req.Header.Set(session.CSRFHeader, token)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for session delete without key, got %d", w.Code)
+	}
+
+	// The view page carries the token, which its delete button sends.
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/"+resp["slug"].(string), nil)
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.AddCookie(cookies[0])
+	router.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `name="csrf-token" content="`+token+`"`) {
+		t.Fatalf("expected the session's CSRF token on the view page, got %d (body: %.300s)", w.Code, w.Body.String())
+	}
+
+	// Non-browser clients without a session still need an API key.
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/", bytes.NewBufferString("hello"))
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without session or key, got %d", w.Code)
+	}
+}
+
+// TestReplicaMode verifies that a replica rejects writes with a pointer to
+// the writer, serves reads without counting them, and sends burn-after-read
+// reads to the writer.
+func TestReplicaMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Role:       config.RoleReplica,
+		WriterURL:  "https://writer.example.com/",
+		SlugLength: 5,
+		BufferSize: 5 * 1024 * 1024,
+		DefaultTTL: 24 * time.Hour,
+	}
+	store := newTestStore()
+	router := setupRouter(store, cfg, nil)
+
+	expires := time.Now().Add(time.Hour)
+	for _, p := range []*models.Paste{
+		{ID: "RPLCA", ExpiresAt: &expires, Size: 5, ContentType: "text/plain", Content: []byte("hello")},
+		{ID: "BURNR", ExpiresAt: &expires, Size: 5, ContentType: "text/plain", Content: []byte("burnt"), BurnAfterRead: true},
+	} {
+		if err := store.Store(p); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/", bytes.NewBufferString("hello"))
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "read_only_replica") {
+		t.Fatalf("expected 403 read_only_replica for upload, got %d (body: %s)", w.Code, w.Body.String())
+	}
+	if loc := w.Header().Get("Location"); loc != "https://writer.example.com/" {
+		t.Errorf("expected Location pointing at the writer, got %q", loc)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/RPLCA", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for delete, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/meta/batch", strings.NewReader(`{"slugs":["RPLCA"]}`))
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected read-only batch lookup to be allowed, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/raw/RPLCA", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "hello" {
+		t.Fatalf("expected raw content, got %d %q", w.Code, w.Body.String())
+	}
+	if store.pastes()["RPLCA"].ReadCount != 0 {
+		t.Errorf("expected replica reads not to be counted, got %d", store.pastes()["RPLCA"].ReadCount)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/raw/BURNR", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != "https://writer.example.com/raw/BURNR" {
+		t.Fatalf("expected redirect to writer for burn paste, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	if _, ok := store.pastes()["BURNR"]; !ok {
+		t.Error("replica must not burn pastes")
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/health", nil)
+	router.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"role":"replica"`) {
+		t.Errorf("expected health to report replica role, got %s", w.Body.String())
+	}
+}
+
+func TestNotFoundBrowser(t *testing.T) {
+	// The main router setup includes the canonicalErrors middleware,
+	// so this test will correctly exercise the logic that returns
+	// HTML for browser-like requests.
+	router, _ := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/ABADPASTE", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/117.0.0.0 Safari/537.36")
+
+	router.ServeHTTP(w, req)
 
 	if w.Code != http.StatusNotFound {
 		t.Errorf("Expected status 404, got %d", w.Code)
@@ -787,8 +1775,7 @@ func TestNotFoundBrowser(t *testing.T) {
 }
 
 func TestNotFoundCLI(t *testing.T) {
-	router, store := setupTestRouter()
-	defer cleanupTestData(store.dataDir)
+	router, _ := setupTestRouter()
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/ABADPASTE", nil)
@@ -819,7 +1806,6 @@ func TestNotFoundCLI(t *testing.T) {
 
 func TestDeletePaste(t *testing.T) {
 	router, store := setupTestRouter()
-	defer cleanupTestData(store.dataDir)
 
 	// Create a paste to delete
 	paste := &models.Paste{
@@ -876,8 +1862,7 @@ func TestDeletePasteAuthEnforced(t *testing.T) {
 		DefaultTTL: 24 * time.Hour,
 	}
 
-	store := NewMockStore(cfg.DataDir)
-	defer cleanupTestData(store.dataDir)
+	store := newTestStore()
 
 	// Seed a paste so DELETE has something to find
 	paste := &models.Paste{
@@ -891,7 +1876,7 @@ func TestDeletePasteAuthEnforced(t *testing.T) {
 		t.Fatalf("failed to store paste: %v", err)
 	}
 
-	router := setupRouter(store, cfg)
+	router := setupRouter(store, cfg, nil)
 
 	// DELETE without auth should be rejected
 	w := httptest.NewRecorder()
@@ -918,3 +1903,703 @@ func TestDeletePasteAuthEnforced(t *testing.T) {
 		t.Errorf("Expected deleted=true, got %v", response["deleted"])
 	}
 }
+
+func TestGetRawPaste_Range(t *testing.T) {
+	router, store := setupTestRouter()
+
+	content := []byte("0123456789")
+	paste := &models.Paste{
+		ID:          "RNG23",
+		CreatedAt:   time.Now(),
+		Size:        int64(len(content)),
+		ContentType: "text/plain",
+		Content:     content,
+	}
+	if err := store.Store(paste); err != nil {
+		t.Fatalf("failed to store paste: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/raw/RNG23", nil)
+	req.Header.Set("Range", "bytes=4-")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("Expected status 206, got %d", w.Code)
+	}
+	if w.Body.String() != "456789" {
+		t.Errorf("Expected body 456789, got %q", w.Body.String())
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 4-9/10" {
+		t.Errorf("Expected Content-Range bytes 4-9/10, got %q", got)
+	}
+	if store.readCount("RNG23") != 0 {
+		t.Errorf("Range requests should not increment read count, got %d", store.readCount("RNG23"))
+	}
+}
+
+func TestDownload(t *testing.T) {
+	router, store := setupTestRouter()
+
+	content := []byte("<h1>hello</h1>")
+	for _, p := range []*models.Paste{
+		{ID: "DLD23", ContentType: "text/html", Content: content},
+		{ID: "DLB23", ContentType: "text/plain", Content: content, BurnAfterRead: true},
+		{ID: "DLN23", ContentType: "text/plain", Content: content, Filename: "naïve notes.txt"},
+	} {
+		p.CreatedAt = time.Now()
+		p.Size = int64(len(content))
+		if err := store.Store(p); err != nil {
+			t.Fatalf("failed to store paste: %v", err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/download/DLD23?filename=../../page.html", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if got := w.Header().Get("Content-Disposition"); !strings.HasPrefix(got, `attachment; filename="page.html"`) {
+		t.Errorf("Expected sanitized attachment filename, got %q", got)
+	}
+	if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("Expected nosniff, got %q", got)
+	}
+	if w.Body.String() != string(content) {
+		t.Errorf("Expected raw content, got %q", w.Body.String())
+	}
+	if store.readCount("DLD23") != 1 {
+		t.Errorf("Expected read count 1, got %d", store.readCount("DLD23"))
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/download/DLD23?filename=..", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for unusable filename, got %d", w.Code)
+	}
+
+	// The uploader's filename is the default, with an ASCII fallback.
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/download/DLN23", nil)
+	router.ServeHTTP(w, req)
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="na_ve notes.txt"; filename*=UTF-8''na%C3%AFve%20notes.txt` {
+		t.Errorf("Expected the uploaded filename, got %q", got)
+	}
+
+	// Text is still an attachment, and burn pastes are deleted after one download.
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/download/DLB23", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if got := w.Header().Get("Content-Disposition"); !strings.HasPrefix(got, `attachment; filename="DLB23.txt"`) {
+		t.Errorf("Expected default attachment filename, got %q", got)
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/download/DLB23", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 after burn, got %d", w.Code)
+	}
+}
+
+// TestReservedSlugs verifies that custom slugs matching built-in, configured
+// or route-derived reserved words are rejected with 400 slug_reserved.
+func TestReservedSlugs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Port:          8080,
+		SlugLength:    5,
+		BufferSize:    5 * 1024 * 1024,
+		DefaultTTL:    24 * time.Hour,
+		ReservedSlugs: "TEAM, paste",
+	}
+	store := newTestStore()
+	router := setupRouter(store, cfg, nil)
+
+	for slug, want := range map[string]int{
+		"RAW":    http.StatusBadRequest, // built-in
+		"HEALTH": http.StatusBadRequest, // built-in and route prefix
+		"TEAM":   http.StatusBadRequest, // NCLIP_RESERVED_SLUGS
+		"PASTE":  http.StatusBadRequest,
+		"TEAMS":  http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/", bytes.NewBufferString("hello"))
+		req.Header.Set("X-Slug", slug)
+		router.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("X-Slug %s: expected %d, got %d (body: %s)", slug, want, w.Code, w.Body.String())
+			continue
+		}
+		if want == http.StatusBadRequest && !strings.Contains(w.Body.String(), "slug_reserved") {
+			t.Errorf("X-Slug %s: expected slug_reserved code, got %s", slug, w.Body.String())
+		}
+	}
+
+	prefixes := routePrefixes(router.Routes(), "")
+	for _, p := range []string{"api", "raw", "download", "health", "static"} {
+		found := false
+		for _, got := range prefixes {
+			found = found || got == p
+		}
+		if !found {
+			t.Errorf("expected route prefix %q in %v", p, prefixes)
+		}
+	}
+}
+
+func TestPreview(t *testing.T) {
+	router, store := setupTestRouter()
+
+	content := []byte("func main() {\n\tprintln(\"hi\")\n}\n")
+	for _, p := range []*models.Paste{
+		{ID: "PRV23", ContentType: "text/plain"},
+		{ID: "PRB23", ContentType: "text/plain", BurnAfterRead: true},
+		{ID: "PRI23", ContentType: "image/png"},
+	} {
+		p.CreatedAt = time.Now()
+		p.Content = content
+		p.Size = int64(len(content))
+		if err := store.Store(p); err != nil {
+			t.Fatalf("failed to store paste: %v", err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/preview/PRV23.png", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("Expected image/png, got %q", ct)
+	}
+	if store.readCount("PRV23") != 0 {
+		t.Errorf("Previews should not count as reads, got %d", store.readCount("PRV23"))
+	}
+	if cached, err := store.GetContent("PRV23.png"); err != nil || !bytes.Equal(cached, w.Body.Bytes()) {
+		t.Errorf("Expected preview to be cached under PRV23.png, got err %v", err)
+	}
+
+	// Deleting the paste removes the cached preview too.
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/PRV23", nil)
+	router.ServeHTTP(w, req)
+	if exists, _, _ := store.StatContent("PRV23.png"); exists {
+		t.Error("Expected cached preview to be deleted with the paste")
+	}
+
+	for _, path := range []string{"/preview/PRB23.png", "/preview/PRI23.png", "/preview/PRV23", "/preview/NOPE2.png"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, w.Code)
+		}
+	}
+	if _, err := store.Get("PRB23"); err != nil {
+		t.Error("Preview request must not burn the paste")
+	}
+}
+
+func TestHTTPHandler_H2C(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/proto", func(c *gin.Context) { c.String(http.StatusOK, c.Request.Proto) })
+
+	cfg := config.Default()
+	cfg.H2C = true
+	srv := httptest.NewServer(httpHandler(router, cfg, nil))
+	defer srv.Close()
+
+	// Prior-knowledge HTTP/2 over a plain TCP connection, as a proxy would.
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	resp, err := client.Get(srv.URL + "/proto")
+	if err != nil {
+		t.Fatalf("h2c request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.ProtoMajor != 2 || string(body) != "HTTP/2.0" {
+		t.Errorf("expected HTTP/2 end to end, got response %s with request proto %q", resp.Proto, body)
+	}
+
+	// HTTP/1.1 clients keep working.
+	resp, err = http.Get(srv.URL + "/proto")
+	if err != nil {
+		t.Fatalf("HTTP/1.1 request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.ProtoMajor != 1 {
+		t.Errorf("expected HTTP/1.1 response, got %s", resp.Proto)
+	}
+}
+
+// TestManagePage verifies the self-service flow: the manage URL returned at
+// upload opens the manage page, whose actions need both the manage token
+// and the session CSRF token.
+func TestManagePage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		APIKeys:       "testkey",
+		UploadAuth:    true,
+		SessionSecret: "test-secret",
+		SessionTTL:    time.Hour,
+		SlugLength:    5,
+		BufferSize:    5 * 1024 * 1024,
+		DefaultTTL:    24 * time.Hour,
+	}
+	store := newTestStore()
+	router := setupRouter(store, cfg, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/", bytes.NewBufferString("hello"))
+	req.Header.Set("X-Api-Key", "testkey")
+	router.ServeHTTP(w, req)
+	var created struct {
+		Slug      string `json:"slug"`
+		ManageURL string `json:"manage_url"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || created.ManageURL == "" {
+		t.Fatalf("expected manage_url in upload response, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-Manage-URL") != created.ManageURL {
+		t.Errorf("expected X-Manage-URL header %q, got %q", created.ManageURL, w.Header().Get("X-Manage-URL"))
+	}
+	manage := strings.TrimPrefix(created.ManageURL, "http://")
+	manage = manage[strings.Index(manage, "/"):]
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", manage, nil)
+	req.Header.Set("Accept", "text/html")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected manage page, got %d: %s", w.Code, w.Body.String())
+	}
+	cookies := w.Result().Cookies()
+	m := regexp.MustCompile(`name="csrf-token" content="([0-9a-f]+)"`).FindStringSubmatch(w.Body.String())
+	if len(cookies) != 1 || m == nil {
+		t.Fatalf("expected session cookie and CSRF token on the manage page")
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/manage/"+created.Slug+"?token=forged", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a forged manage token, got %d", w.Code)
+	}
+
+	act := func(method, path, body, csrf string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(cookies[0])
+		if csrf != "" {
+			req.Header.Set(session.CSRFHeader, csrf)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	update := `{"ttl": "72h", "burn_after_read": true, "visibility": "public"}`
+	if w := act("POST", manage, update, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without CSRF token, got %d", w.Code)
+	}
+	if w := act("POST", "/manage/"+created.Slug+"?token=forged", update, m[1]); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 with a forged manage token, got %d", w.Code)
+	}
+	if w := act("POST", manage, update, m[1]); w.Code != http.StatusOK {
+		t.Fatalf("expected update to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	paste, err := store.Get(created.Slug)
+	if err != nil || !paste.BurnAfterRead || paste.Visibility != models.VisibilityPublic ||
+		paste.ExpiresAt.Before(time.Now().Add(71*time.Hour)) {
+		t.Fatalf("expected updated paste, got %+v (%v)", paste, err)
+	}
+	if w := act("POST", manage, `{"visibility": "private"}`, m[1]); w.Code != http.StatusOK {
+		t.Fatalf("expected the key-owned paste to become private, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := act("DELETE", manage, "", m[1]); w.Code != http.StatusOK {
+		t.Fatalf("expected delete to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if p, _ := store.Get(created.Slug); p != nil {
+		t.Error("expected paste to be deleted")
+	}
+}
+
+// TestPushSubscriptions verifies that the manage page can subscribe a
+// browser to push notifications with the manage and CSRF tokens, and that
+// the routes only exist when a VAPID key is configured.
+func TestPushSubscriptions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	vapid, _ := ecdh.P256().GenerateKey(rand.Reader)
+	cfg := &config.Config{
+		SessionSecret:    "test-secret",
+		SessionTTL:       time.Hour,
+		SlugLength:       5,
+		BufferSize:       5 * 1024 * 1024,
+		DefaultTTL:       24 * time.Hour,
+		DataDir:          t.TempDir(),
+		VAPIDPrivateKey:  base64.RawURLEncoding.EncodeToString(vapid.Bytes()),
+		VAPIDSubject:     "mailto:ops@example.com",
+		PushExpiryNotice: time.Hour,
+	}
+	store := newTestStore()
+	router := setupRouter(store, cfg, nil)
+
+	do := func(method, path, body string, cookie *http.Cookie, csrf string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Accept", "text/html")
+		if cookie != nil {
+			req.AddCookie(cookie)
+			req.Header.Set(session.CSRFHeader, csrf)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("GET", "/api/v1/push/public-key", "", nil, "")
+	if want := base64.RawURLEncoding.EncodeToString(vapid.PublicKey().Bytes()); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), want) {
+		t.Fatalf("public key: got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("GET", "/sw.js", "", nil, ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "showNotification") {
+		t.Fatalf("expected the service worker at /sw.js, got %d", w.Code)
+	}
+
+	w = do("POST", "/", "notify me", nil, "")
+	var created struct {
+		Slug      string `json:"slug"`
+		ManageURL string `json:"manage_url"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || created.ManageURL == "" {
+		t.Fatalf("expected manage_url in upload response, got %d: %s", w.Code, w.Body.String())
+	}
+	manage := strings.TrimPrefix(created.ManageURL, "http://")
+	manage = manage[strings.Index(manage, "/"):]
+	w = do("GET", manage, "", nil, "")
+	m := regexp.MustCompile(`name="csrf-token" content="([0-9a-f]+)"`).FindStringSubmatch(w.Body.String())
+	if w.Code != http.StatusOK || m == nil || !strings.Contains(w.Body.String(), "manage-notify") {
+		t.Fatalf("expected manage page with a notify button, got %d", w.Code)
+	}
+	cookie := w.Result().Cookies()[0]
+	pushPath := strings.Replace(manage, "?token=", "/push?token=", 1)
+
+	browser, _ := ecdh.P256().GenerateKey(rand.Reader)
+	subscription := func(endpoint string) string {
+		return `{"endpoint":"` + endpoint + `","keys":{"p256dh":"` + base64.RawURLEncoding.EncodeToString(browser.PublicKey().Bytes()) +
+			`","auth":"` + base64.RawURLEncoding.EncodeToString(make([]byte, 16)) + `"}}`
+	}
+	if w := do("POST", pushPath, subscription("https://push.example.com/0"), nil, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without the session, got %d", w.Code)
+	}
+	if w := do("POST", "/manage/"+created.Slug+"/push?token=forged", subscription("https://push.example.com/0"), cookie, m[1]); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 with a forged manage token, got %d", w.Code)
+	}
+	if w := do("POST", pushPath, subscription("http://push.example.com/0"), cookie, m[1]); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an http endpoint, got %d", w.Code)
+	}
+	for i := range 5 {
+		if w := do("POST", pushPath, subscription("https://push.example.com/"+strconv.Itoa(i)), cookie, m[1]); w.Code != http.StatusCreated {
+			t.Fatalf("subscribe %d: expected 201, got %d: %s", i, w.Code, w.Body.String())
+		}
+	}
+	if w := do("POST", pushPath, subscription("https://push.example.com/5"), cookie, m[1]); w.Code != http.StatusConflict ||
+		!strings.Contains(w.Body.String(), "push_subscription_limit") {
+		t.Fatalf("expected 409 over the subscription limit, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("DELETE", pushPath, `{"endpoint":"https://push.example.com/0"}`, cookie, m[1]); w.Code != http.StatusOK {
+		t.Fatalf("unsubscribe: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("DELETE", pushPath, `{"endpoint":"https://push.example.com/0"}`, cookie, m[1]); w.Code != http.StatusNotFound {
+		t.Fatalf("second unsubscribe: expected 404, got %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.DataDir, ".push.json")); err != nil {
+		t.Errorf("expected subscriptions saved to the data directory: %v", err)
+	}
+
+	cfg.VAPIDPrivateKey = ""
+	router = setupRouter(store, cfg, nil)
+	if w := do("GET", "/api/v1/push/public-key", "", nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected no push routes without a VAPID key, got %d", w.Code)
+	}
+}
+
+func TestLoadShedding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		SlugLength: 5,
+		BufferSize: 5 * 1024 * 1024,
+		DefaultTTL: 24 * time.Hour,
+	}
+	mock := newTestStore()
+	health := storage.NewHealthTracker(50, 0, time.Minute)
+	store := storage.NewInstrumentedStore(mock, "filesystem", nil)
+	store.SetHealth(health)
+	router := setupRouter(store, cfg, nil)
+
+	expires := time.Now().Add(time.Hour)
+	if err := mock.Store(&models.Paste{ID: "SHEDR", ExpiresAt: &expires, Size: 5, ContentType: "text/plain", Content: []byte("hello")}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("POST", "/", "healthy"); w.Code != http.StatusOK {
+		t.Fatalf("upload while healthy: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	for i := 0; i < 50; i++ {
+		health.Observe(time.Millisecond, true)
+	}
+	w := do("POST", "/", "shed me")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("upload while degraded: expected 503, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+	if !strings.Contains(w.Body.String(), `"overloaded"`) {
+		t.Errorf("expected overloaded code, got %s", w.Body.String())
+	}
+	if w := do("GET", "/raw/SHEDR", ""); w.Code != http.StatusOK || w.Body.String() != "hello" {
+		t.Errorf("read while degraded: expected content, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Status   string `json:"status"`
+		Shedding bool   `json:"shedding"`
+	}
+	if err := json.Unmarshal(do("GET", "/health", "").Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Shedding || resp.Status != "degraded" {
+		t.Errorf("expected /health to report shedding, got %+v", resp)
+	}
+}
+
+func TestNotifyOnBurnHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		SlugLength: 5,
+		BufferSize: 5 * 1024 * 1024,
+		DefaultTTL: 24 * time.Hour,
+	}
+	store := newTestStore()
+	router := setupRouter(store, cfg, nil)
+
+	upload := func(path, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, strings.NewReader("secret"))
+		req.Header.Set("X-Notify-On-Burn", target)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, tc := range []struct{ path, target string }{
+		{"/", "https://hooks.example.com/nclip"},
+		{"/burn/", "alice@example.com"},
+		{"/burn/", "ftp://hooks.example.com/"},
+	} {
+		if w := upload(tc.path, tc.target); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_notify") {
+			t.Errorf("%s with %q: expected 400 invalid_notify, got %d %s", tc.path, tc.target, w.Code, w.Body.String())
+		}
+	}
+	if len(store.pastes()) != 0 {
+		t.Fatalf("expected rejected uploads to store nothing, got %d pastes", len(store.pastes()))
+	}
+
+	if w := upload("/burn/", "https://hooks.example.com/nclip"); w.Code != http.StatusOK {
+		t.Fatalf("expected burn upload with a webhook to succeed, got %d %s", w.Code, w.Body.String())
+	}
+	for _, p := range store.pastes() {
+		if p.NotifyOnBurn != "https://hooks.example.com/nclip" {
+			t.Errorf("expected the webhook stored with the paste, got %q", p.NotifyOnBurn)
+		}
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/meta/"+p.ID, nil)
+		router.ServeHTTP(w, req)
+		if strings.Contains(w.Body.String(), "hooks.example.com") {
+			t.Errorf("expected the metadata API not to serve the target, got %s", w.Body.String())
+		}
+	}
+}
+
+// TestTenantScoping verifies that admin keys in a tenant only manage the
+// pastes of their tenant, and that instance-wide admin routes take a
+// superadmin key once keys are in tenants.
+func TestTenantScoping(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	keys := "eng-ci write tenant=eng\neng-ops admin tenant=eng\nmkt-ops admin tenant=marketing\n"
+	if err := os.WriteFile(keysFile, []byte(keys), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		APIKeys:     "root",
+		APIKeysFile: keysFile,
+		UploadAuth:  true,
+		SlugLength:  5,
+		BufferSize:  5 * 1024 * 1024,
+		DefaultTTL:  24 * time.Hour,
+	}
+	store := newTestStore()
+	router := setupRouter(store, cfg, nil)
+
+	do := func(method, path, key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader("{}"))
+		req.Header.Set("X-Api-Key", key)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	if w := do("POST", "/", "eng-ci"); w.Code != http.StatusOK {
+		t.Fatalf("upload: expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	var slug string
+	for id, p := range store.pastes() {
+		slug = id
+		if p.Tenant != "eng" {
+			t.Errorf("expected the paste to belong to tenant eng, got %q", p.Tenant)
+		}
+	}
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		want   int
+	}{
+		{"other tenant cannot pin", "POST", "/api/v1/pastes/" + slug + "/pin", "mkt-ops", http.StatusNotFound},
+		{"other tenant cannot update", "PATCH", "/api/v1/pastes/" + slug, "mkt-ops", http.StatusNotFound},
+		{"other tenant cannot delete", "DELETE", "/" + slug, "mkt-ops", http.StatusNotFound},
+		{"same tenant pins", "POST", "/api/v1/pastes/" + slug + "/pin", "eng-ops", http.StatusOK},
+		{"superadmin unpins", "DELETE", "/api/v1/pastes/" + slug + "/pin", "root", http.StatusOK},
+		{"tenant admin cannot read settings", "GET", "/api/v1/admin/settings", "eng-ops", http.StatusForbidden},
+		{"superadmin reads settings", "GET", "/api/v1/admin/settings", "root", http.StatusOK},
+		{"same tenant deletes", "DELETE", "/" + slug, "eng-ops", http.StatusOK},
+	}
+	for _, tc := range cases {
+		if w := do(tc.method, tc.path, tc.key); w.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+// TestAdminConsole verifies the admin console page and that keys created
+// and revoked through the keys API take effect at once.
+func TestAdminConsole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(keysFile, []byte("# operators\nops admin\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		APIKeysFile: keysFile,
+		UploadAuth:  true,
+		SlugLength:  5,
+		BufferSize:  5 * 1024 * 1024,
+		DefaultTTL:  24 * time.Hour,
+	}
+	router := setupRouter(newTestStore(), cfg, nil)
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Api-Key", key)
+		req.Header.Set("Accept", "text/html")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("GET", "/admin", "", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Admin Console") || w.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("expected the console page, got %d %q %s", w.Code, w.Header().Get("Cache-Control"), w.Body.String())
+	}
+	if w := do("GET", "/api/v1/admin/me", "ops", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"superadmin":true`) {
+		t.Errorf("me: expected 200 for a superadmin, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = do("POST", "/api/v1/admin/keys", "ops", `{"scopes":["write"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		ID  string `json:"id"`
+		Key string `json:"key"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || created.Key == "" {
+		t.Fatalf("create: expected a key, got %s", w.Body.String())
+	}
+	if w := do("POST", "/", created.Key, "hello"); w.Code != http.StatusOK {
+		t.Errorf("upload with the new key: expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	if w := do("GET", "/api/v1/admin/keys", created.Key, ""); w.Code != http.StatusForbidden {
+		t.Errorf("keys with a write key: expected 403, got %d", w.Code)
+	}
+
+	if w := do("DELETE", "/api/v1/admin/keys/"+created.ID, "ops", ""); w.Code != http.StatusOK {
+		t.Fatalf("revoke: expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	if w := do("POST", "/", created.Key, "hello"); w.Code != http.StatusUnauthorized {
+		t.Errorf("upload with the revoked key: expected 401, got %d", w.Code)
+	}
+	if data, _ := os.ReadFile(keysFile); string(data) != "# operators\nops admin\n" {
+		t.Errorf("expected the keys file to be back as written, got %q", data)
+	}
+}
+
+func TestPublicPages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{SlugLength: 5, BufferSize: 5 * 1024 * 1024, DefaultTTL: 24 * time.Hour}
+	store := newTestStore()
+	get := func(router *gin.Engine, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", "text/html")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	router := setupRouter(store, cfg, nil)
+	// Without the pages /about is taken for a slug, and an invalid one.
+	if w := get(router, "/about"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected /about to be off by default, got %d", w.Code)
+	}
+	if w := get(router, "/.well-known/nclip.json"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"max_size":5242880`) {
+		t.Errorf("expected the discovery document, got %d %s", w.Code, w.Body.String())
+	}
+
+	cfg.PublicPages = true
+	cfg.AbuseContact = "abuse@example.com"
+	router = setupRouter(store, cfg, nil)
+	w := get(router, "/about")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `href="mailto:abuse@example.com"`) || !strings.Contains(w.Body.String(), "5.0 MiB") {
+		t.Errorf("expected the about page with the abuse contact, got %d %s", w.Code, w.Body.String())
+	}
+	w = get(router, "/stats")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Uptime:") || !strings.Contains(w.Body.String(), "Pastes:") {
+		t.Errorf("expected the stats page with paste counts, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -3,8 +3,6 @@ package main
 import (
 	"net/http"
 	"net/http/httptest"
-	"os"
-	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -21,8 +19,7 @@ import (
 func TestViewSmallRendersFull(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	cfg := &config.Config{MaxRenderSize: 1024}
-	store := NewMockStore(cfg.DataDir)
-	defer cleanupTestData(store.dataDir)
+	store := newTestStore()
 	svc := services.NewPasteService(store, cfg)
 	rh := retrieval.NewHandler(svc, store, cfg)
 
@@ -35,7 +32,7 @@ func TestViewSmallRendersFull(t *testing.T) {
 	// Call handler directly using a test context to avoid router matching issues
 	w := httptest.NewRecorder()
 	c, engine := gin.CreateTestContext(w)
-	engine.LoadHTMLGlob("static/*.html")
+	loadTemplates(engine, cfg)
 	c.Request = httptest.NewRequest("GET", "/SMALLA", nil)
 	c.Params = gin.Params{{Key: "slug", Value: "SMALLA"}}
 	rh.View(c)
@@ -53,8 +50,7 @@ func TestViewSmallRendersFull(t *testing.T) {
 func TestViewLargeShowsPreview(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	cfg := &config.Config{MaxRenderSize: 10}
-	store := NewMockStore(cfg.DataDir)
-	defer cleanupTestData(store.dataDir)
+	store := newTestStore()
 	svc := services.NewPasteService(store, cfg)
 	rh := retrieval.NewHandler(svc, store, cfg)
 
@@ -67,7 +63,7 @@ func TestViewLargeShowsPreview(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	c, engine := gin.CreateTestContext(w)
-	engine.LoadHTMLGlob("static/*.html")
+	loadTemplates(engine, cfg)
 	c.Request = httptest.NewRequest("GET", "/LARGEA", nil)
 	c.Params = gin.Params{{Key: "slug", Value: "LARGEA"}}
 	rh.View(c)
@@ -85,12 +81,11 @@ func TestViewLargeShowsPreview(t *testing.T) {
 	}
 }
 
-// Integration-style test: burn-after-read preview reads prefix from temp file and deletes it
+// Integration-style test: burn-after-read preview reads the prefix and deletes the content
 func TestBurnAfterReadPreviewDeletesTemp(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	cfg := &config.Config{MaxRenderSize: 10}
-	store := NewMockStore(cfg.DataDir)
-	defer cleanupTestData(store.dataDir)
+	store := newTestStore()
 	svc := services.NewPasteService(store, cfg)
 	rh := retrieval.NewHandler(svc, store, cfg)
 
@@ -101,19 +96,13 @@ func TestBurnAfterReadPreviewDeletesTemp(t *testing.T) {
 		t.Fatalf("failed to store paste: %v", err)
 	}
 
-	// ensure data dir exists and the file is present (MockStore writes file)
-	dataDir := cfg.DataDir
-	if dataDir == "" {
-		dataDir = "./data"
-	}
-	// sanity check file exists
-	if _, err := os.Stat(filepath.Join(dataDir, "BURN2")); err != nil {
-		t.Fatalf("expected content file on disk before request: %v", err)
+	if !store.hasContent("BURN2") {
+		t.Fatalf("expected content stored before request")
 	}
 
 	w := httptest.NewRecorder()
 	c, engine := gin.CreateTestContext(w)
-	engine.LoadHTMLGlob("static/*.html")
+	loadTemplates(engine, cfg)
 	c.Request = httptest.NewRequest("GET", "/BURN2", nil)
 	c.Params = gin.Params{{Key: "slug", Value: "BURN2"}}
 	rh.View(c)
@@ -122,8 +111,8 @@ func TestBurnAfterReadPreviewDeletesTemp(t *testing.T) {
 		t.Fatalf("expected 200 OK, got %d", w.Code)
 	}
 
-	// after request, original file should be gone (moved then deleted by handler)
-	if _, err := os.Stat(filepath.Join(dataDir, "BURN2")); !os.IsNotExist(err) {
-		t.Fatalf("expected original content removed after burn, stat err: %v", err)
+	// after request, the content should be gone
+	if store.hasContent("BURN2") {
+		t.Fatalf("expected content removed after burn")
 	}
 }
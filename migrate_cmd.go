@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/egress"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+const migrateUsage = `Usage: nclip migrate-metadata [--dry-run] [flags]
+
+Rewrite the metadata of every paste stored with an older schema in the
+current one (schema %d). The store is chosen as nclip chooses it:
+MongoDB when NCLIP_MONGO_URI is set, else the S3 bucket when
+NCLIP_S3_BUCKET is set, else the filesystem data directory. Running
+instances may keep serving; pastes are upgraded as they are read either
+way.
+
+`
+
+// runMigrateCommand implements the "nclip migrate-metadata" subcommand and
+// returns the process exit code.
+func runMigrateCommand(args []string, stdout, stderr io.Writer, getenv func(string) string) int {
+	fs := flag.NewFlagSet("nclip migrate-metadata", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(stderr, migrateUsage, models.MetadataSchema)
+		fs.PrintDefaults()
+	}
+	dryRun := fs.Bool("dry-run", false, "Only count the pastes that would be upgraded")
+	cfg, _, err := config.Load(fs, args, getenv)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+		return 1
+	}
+
+	store, name, err := openBackendStore(cfg)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+		return 1
+	}
+	defer func() { _ = store.Close() }()
+	migrator, ok := store.(storage.MetadataMigrator)
+	if !ok {
+		_, _ = fmt.Fprintf(stderr, "nclip: the %s store cannot migrate metadata\n", name)
+		return 1
+	}
+	stats, err := migrator.MigrateMetadata(*dryRun)
+	verb := "upgraded"
+	if *dryRun {
+		verb = "to upgrade"
+	}
+	_, _ = fmt.Fprintf(stdout, "%s: %d pastes scanned, %d %s to schema %d, %d failed\n",
+		name, stats.Scanned, stats.Upgraded, verb, models.MetadataSchema, stats.Failed)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: migration stopped: %v\n", err)
+		return 1
+	}
+	if stats.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// openBackendStore opens the backend cfg stores pastes in, without the
+// spool, encryption or journal layers, which do not change metadata. The
+// commands working on metadata use it.
+func openBackendStore(cfg *config.Config) (storage.PasteStore, string, error) {
+	if _, err := egress.UseCABundle(cfg.CABundle); err != nil {
+		return nil, "", err
+	}
+	switch {
+	case cfg.IsMemory():
+		return nil, "memory", fmt.Errorf("the memory backend only lives inside a running nclip server")
+	case cfg.MongoURI != "":
+		store, err := storage.NewMongoStore(cfg.MongoURI, cfg.MongoDatabase)
+		return store, "mongodb", err
+	case cfg.S3Bucket != "":
+		store, err := storage.NewS3StoreWithOptions(cfg.S3Bucket, cfg.S3Prefix, "", cfg.S3ClientOptions())
+		if err != nil {
+			return nil, "s3", err
+		}
+		if err := store.SetPutOptions(cfg.S3PutOptions()); err != nil {
+			return nil, "s3", err
+		}
+		return store, "s3", nil
+	default:
+		store, err := storage.NewFilesystemStore(cfg.DataDir)
+		return store, "filesystem", err
+	}
+}
+
+const migrateTenantsUsage = `Usage: nclip migrate-tenants [--dry-run] [flags]
+
+Move the pastes of tenants that were stored before each tenant had a key
+prefix of its own to that prefix. The store is chosen as for
+migrate-metadata. Pastes changed while they are moved may lose the
+change, so run it while no instance takes writes.
+
+`
+
+// runMigrateTenantsCommand implements the "nclip migrate-tenants"
+// subcommand and returns the process exit code.
+func runMigrateTenantsCommand(args []string, stdout, stderr io.Writer, getenv func(string) string) int {
+	fs := flag.NewFlagSet("nclip migrate-tenants", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprint(stderr, migrateTenantsUsage)
+		fs.PrintDefaults()
+	}
+	dryRun := fs.Bool("dry-run", false, "Only count the pastes that would be moved")
+	cfg, _, err := config.Load(fs, args, getenv)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+		return 1
+	}
+
+	backend, name, err := openBackendStore(cfg)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+		return 1
+	}
+	defer func() { _ = backend.Close() }()
+	stats, err := storage.NewTenantStore(backend).MigrateTenants(*dryRun)
+	verb := "moved"
+	if *dryRun {
+		verb = "to move"
+	}
+	_, _ = fmt.Fprintf(stdout, "%s: %d pastes scanned, %d %s to their tenant's prefix, %d failed\n",
+		name, stats.Scanned, stats.Upgraded, verb, stats.Failed)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: migration stopped: %v\n", err)
+		return 1
+	}
+	if stats.Failed > 0 {
+		return 1
+	}
+	return 0
+}
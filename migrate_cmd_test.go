@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateCommand(t *testing.T) {
+	dir := t.TempDir()
+	old := `{"id":"LEGACY","created_at":"2099-06-01T09:00:00+02:00","content_type":"text/plain"}`
+	if err := os.WriteFile(filepath.Join(dir, "LEGACY.json"), []byte(old), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	getenv := func(key string) string {
+		if key == "NCLIP_DATA_DIR" {
+			return dir
+		}
+		return ""
+	}
+	run := func(args ...string) (int, string) {
+		var stdout, stderr bytes.Buffer
+		code := runMigrateCommand(args, &stdout, &stderr, getenv)
+		return code, stdout.String() + stderr.String()
+	}
+
+	if code, out := run("--dry-run"); code != 0 || !strings.Contains(out, "filesystem: 1 pastes scanned, 1 to upgrade to schema 1, 0 failed") {
+		t.Fatalf("dry run exited %d: %s", code, out)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "LEGACY.json")); string(data) != old {
+		t.Fatalf("dry run rewrote the metadata: %s", data)
+	}
+	if code, out := run(); code != 0 || !strings.Contains(out, "1 upgraded to schema 1") {
+		t.Fatalf("migration exited %d: %s", code, out)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "LEGACY.json")); !strings.Contains(string(data), `"schema_version": 1`) {
+		t.Errorf("migrated metadata = %s", data)
+	}
+	if code, out := run("--bogus"); code == 0 {
+		t.Errorf("unknown flag exited 0: %s", out)
+	}
+}
+
+func TestMigrateTenantsCommand(t *testing.T) {
+	dir := t.TempDir()
+	meta := `{"schema_version":1,"id":"TNNTA","created_at":"2099-06-01T09:00:00Z","content_type":"text/plain","tenant":"eng"}`
+	if err := os.WriteFile(filepath.Join(dir, "TNNTA.json"), []byte(meta), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "TNNTA"), []byte("hi"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	getenv := func(key string) string {
+		if key == "NCLIP_DATA_DIR" {
+			return dir
+		}
+		return ""
+	}
+	run := func(args ...string) (int, string) {
+		var stdout, stderr bytes.Buffer
+		code := runMigrateTenantsCommand(args, &stdout, &stderr, getenv)
+		return code, stdout.String() + stderr.String()
+	}
+
+	if code, out := run("--dry-run"); code != 0 || !strings.Contains(out, "filesystem: 1 pastes scanned, 1 to move to their tenant's prefix, 0 failed") {
+		t.Fatalf("dry run exited %d: %s", code, out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "TNNTA.json")); err != nil {
+		t.Fatalf("dry run moved the paste: %v", err)
+	}
+	if code, out := run(); code != 0 || !strings.Contains(out, "1 moved") {
+		t.Fatalf("migration exited %d: %s", code, out)
+	}
+	for _, name := range []string{"eng~TNNTA", "eng~TNNTA.json", ".tenant~TNNTA"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("after the migration: %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "TNNTA.json")); !os.IsNotExist(err) {
+		t.Errorf("the bare metadata was kept: %v", err)
+	}
+}
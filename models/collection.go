@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// Limits on collections.
+const (
+	// MaxCollectionPastes is the most pastes a collection can hold.
+	MaxCollectionPastes = 500
+	// MaxCollectionTitle and MaxCollectionDescription bound the text shown
+	// on a collection's index page, in bytes.
+	MaxCollectionTitle       = 200
+	MaxCollectionDescription = 2000
+)
+
+// Collection groups related pastes, such as the logs of one incident,
+// under a single index page at /c/<id>. Pastes stay independent: they
+// expire and are deleted on their own, and can belong to several
+// collections.
+type Collection struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Owner is the audit key ID of the API key that created the
+	// collection; only it or an admin key may change it. Collections
+	// created without a key have no owner.
+	Owner string `json:"owner,omitempty"`
+	// Pastes lists member slugs in the order they were added. Members that
+	// expired or were deleted stay listed until they are detached, and are
+	// skipped when the collection is shown.
+	Pastes []string `json:"pastes"`
+}
+
+// Has reports whether slug is a member of the collection.
+func (c *Collection) Has(slug string) bool {
+	for _, s := range c.Pastes {
+		if s == slug {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxFileMtimeSkew is how far past the upload time a file modification
+// time may lie, to allow for clocks that are a little off.
+const MaxFileMtimeSkew = 24 * time.Hour
+
+// ParseFileMtime parses a file modification time as sent in X-File-Mtime
+// or a multipart part: Unix seconds, RFC 3339 or an HTTP date. The time
+// must lie between the Unix epoch and MaxFileMtimeSkew past now; it is
+// returned in UTC, truncated to the second as Last-Modified carries no
+// more.
+func ParseFileMtime(v string, now time.Time) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	var t time.Time
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		t = time.Unix(secs, 0)
+	} else if t, err = time.Parse(time.RFC3339, v); err != nil {
+		if t, err = http.ParseTime(v); err != nil {
+			return time.Time{}, fmt.Errorf("invalid file mtime %q: Unix seconds, RFC 3339 or an HTTP date is required", v)
+		}
+	}
+	if t.Before(time.Unix(0, 0)) || t.After(now.Add(MaxFileMtimeSkew)) {
+		return time.Time{}, fmt.Errorf("invalid file mtime %q: the time must lie between 1970 and now", v)
+	}
+	return t.UTC().Truncate(time.Second), nil
+}
@@ -1,11 +1,16 @@
 package models
 
 import (
+	"fmt"
+	"strings"
 	"time"
 )
 
 // Paste represents a paste/clipboard entry in the system
 type Paste struct {
+	// SchemaVersion is the metadata schema the paste was stored with; see
+	// MetadataSchema.
+	SchemaVersion int        `json:"schema_version" bson:"schema_version"`
 	ID            string     `json:"id" bson:"_id"`
 	CreatedAt     time.Time  `json:"created_at" bson:"created_at"`
 	ExpiresAt     *time.Time `json:"expires_at" bson:"expires_at,omitempty"`
@@ -13,17 +18,242 @@ type Paste struct {
 	ContentType   string     `json:"content_type" bson:"content_type"`
 	BurnAfterRead bool       `json:"burn_after_read" bson:"burn_after_read"`
 	ReadCount     int        `json:"read_count" bson:"read_count"`
-	Content       []byte     `json:"-" bson:"content"` // Not exposed in JSON
+	// Views, RawReads and Downloads break ReadCount down by how the paste
+	// was read. Reads recorded before they existed only count in ReadCount.
+	Views     int      `json:"views,omitempty" bson:"views,omitempty"`
+	RawReads  int      `json:"raw_reads,omitempty" bson:"raw_reads,omitempty"`
+	Downloads int      `json:"downloads,omitempty" bson:"downloads,omitempty"`
+	Tags      []string `json:"tags,omitempty" bson:"tags,omitempty"`
+	// Pinned pastes never expire, whatever their ExpiresAt.
+	Pinned bool `json:"pinned,omitempty" bson:"pinned,omitempty"`
+	// LegalHold blocks expiry, burn-after-read and deletion until an admin
+	// releases the hold.
+	LegalHold bool `json:"legal_hold,omitempty" bson:"legal_hold,omitempty"`
+	// Quarantined pastes are hidden from everyone but admin keys of their
+	// tenant, which review them and release or delete them.
+	Quarantined bool `json:"quarantined,omitempty" bson:"quarantined,omitempty"`
+	// Visibility is empty for pastes created before visibility levels
+	// existed; they are unlisted.
+	Visibility Visibility `json:"visibility,omitempty" bson:"visibility,omitempty"`
+	// Owner is the audit key ID of the API key that uploaded the paste, or
+	// empty for uploads without one.
+	Owner string `json:"owner,omitempty" bson:"owner,omitempty"`
+	// Tenant is the tenant of the uploading API key, or empty for the
+	// default tenant; see apikeys.Tenancy.
+	Tenant string `json:"tenant,omitempty" bson:"tenant,omitempty"`
+	// Filename is the uploader's original filename, sanitized, or empty
+	// when none was given. Downloads are named after it.
+	Filename string `json:"filename,omitempty" bson:"filename,omitempty"`
+	// FileMtime is the modification time of the uploaded file, from
+	// X-File-Mtime or the multipart part, or nil when none was sent. It is
+	// cleared when the content is replaced or appended to.
+	FileMtime *time.Time `json:"file_mtime,omitempty" bson:"file_mtime,omitempty"`
+	// Encoding is the charset a text upload arrived in before it was
+	// transcoded to UTF-8 for storage, or empty when it was stored as
+	// sent. /raw?encoding=original converts it back.
+	Encoding string `json:"encoding,omitempty" bson:"encoding,omitempty"`
+	// SHA256 is the hex SHA-256 of the current content, recorded when
+	// checksum URLs are enabled. It is empty for pastes stored without it
+	// and for presigned uploads.
+	SHA256 string `json:"sha256,omitempty" bson:"sha256,omitempty"`
+	// Origin is set on pastes imported from another pastebin service.
+	Origin *PasteOrigin `json:"origin,omitempty" bson:"origin,omitempty"`
+	// Source records the CI run that uploaded the paste, by the keys
+	// SourceRepo, SourcePipeline, SourceJobURL and SourceCommit.
+	Source map[string]string `json:"source,omitempty" bson:"source,omitempty"`
+	// BurnToken is the secret that reads a burn-after-read paste through
+	// its /b/ link. It is stored with the metadata but never served.
+	BurnToken string `json:"burn_token,omitempty" bson:"burn_token,omitempty"`
+	// BurnClaim is set while a burn-after-read paste is being sent.
+	BurnClaim *BurnClaim `json:"burn_claim,omitempty" bson:"burn_claim,omitempty"`
+	// NotifyOnBurn is the https webhook URL or mailto: address told when
+	// a burn-after-read paste is read. Like BurnToken it is never served.
+	NotifyOnBurn string `json:"notify_on_burn,omitempty" bson:"notify_on_burn,omitempty"`
+	// Appendable pastes are live: their owner may append to them until
+	// the final append clears the flag.
+	Appendable bool `json:"appendable,omitempty" bson:"appendable,omitempty"`
+	// Corrupt is set by "nclip audit --repair" on pastes whose content
+	// failed verification. Their content is no longer served.
+	Corrupt bool `json:"corrupt,omitempty" bson:"corrupt,omitempty"`
+	// Version numbers the current content, starting at 1; it is 0 for
+	// pastes whose content was never replaced. UpdatedAt is when the
+	// current content replaced the previous one. Versions lists the
+	// earlier contents still kept, oldest first.
+	UpdatedAt *time.Time     `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	Version   int            `json:"version,omitempty" bson:"version,omitempty"`
+	Versions  []PasteVersion `json:"versions,omitempty" bson:"versions,omitempty"`
+	Content   []byte         `json:"-" bson:"content"` // Not exposed in JSON
 }
 
-// IsExpired checks if the paste has expired
+// PasteOrigin records where an imported paste came from.
+type PasteOrigin struct {
+	// Service is the service it was imported from, e.g. "gist".
+	Service string `json:"service" bson:"service"`
+	// ID identifies it at the service, unique within Service.
+	ID  string `json:"id" bson:"id"`
+	URL string `json:"url,omitempty" bson:"url,omitempty"`
+	// Language is the language the service reported, if any.
+	Language string `json:"language,omitempty" bson:"language,omitempty"`
+	// CreatedAt is when it was created at the service, or nil when the
+	// service does not tell.
+	CreatedAt  *time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	ImportedAt time.Time  `json:"imported_at" bson:"imported_at"`
+}
+
+// Visibility controls who may read a paste and where it is listed.
+type Visibility string
+
+const (
+	// VisibilityPublic pastes are readable by anyone and may be listed.
+	VisibilityPublic Visibility = "public"
+	// VisibilityUnlisted pastes are readable by anyone with the URL but
+	// never listed. It is the default.
+	VisibilityUnlisted Visibility = "unlisted"
+	// VisibilityPrivate pastes are only readable with the owner's API key
+	// or a signed share link.
+	VisibilityPrivate Visibility = "private"
+)
+
+// ParseVisibility parses a visibility level, case-insensitively. An empty
+// string is the default, unlisted.
+func ParseVisibility(s string) (Visibility, error) {
+	switch v := Visibility(strings.ToLower(strings.TrimSpace(s))); v {
+	case "":
+		return VisibilityUnlisted, nil
+	case VisibilityPublic, VisibilityUnlisted, VisibilityPrivate:
+		return v, nil
+	}
+	return "", fmt.Errorf("visibility must be public, unlisted or private")
+}
+
+// VisibilityLevel returns the paste's visibility, treating an empty value
+// as unlisted.
+func (p *Paste) VisibilityLevel() Visibility {
+	if p.Visibility == "" {
+		return VisibilityUnlisted
+	}
+	return p.Visibility
+}
+
+// IsPrivate reports whether the paste is private.
+func (p *Paste) IsPrivate() bool {
+	return p.Visibility == VisibilityPrivate
+}
+
+// IsExpired checks if the paste has expired. Pinned pastes and pastes under
+// legal hold never expire.
 func (p *Paste) IsExpired() bool {
-	if p.ExpiresAt == nil {
+	if p.ExpiresAt == nil || p.Pinned || p.LegalHold {
 		return false
 	}
 	return time.Now().After(*p.ExpiresAt)
 }
 
+// ReadKind identifies how a paste was read.
+type ReadKind string
+
+const (
+	// ReadView is a GET /:slug, rendered for browsers or sent raw to CLIs.
+	ReadView ReadKind = "view"
+	// ReadRaw is a GET /raw/:slug, or a TCP or gopher fetch.
+	ReadRaw ReadKind = "raw"
+	// ReadDownload is a GET /download/:slug.
+	ReadDownload ReadKind = "download"
+)
+
+// RecordRead increments ReadCount and the counter for kind. Unknown kinds
+// only count towards ReadCount.
+func (p *Paste) RecordRead(kind ReadKind) {
+	p.ReadCount++
+	switch kind {
+	case ReadView:
+		p.Views++
+	case ReadRaw:
+		p.RawReads++
+	case ReadDownload:
+		p.Downloads++
+	}
+}
+
+// HasTag reports whether the paste is labelled with tag
+func (p *Paste) HasTag(tag string) bool {
+	for _, t := range p.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Burn claims: a burn-after-read paste is claimed before its content is
+// sent and deleted once the transfer completes. If the transfer fails, the
+// claimant may retry until the claim times out, after which the paste
+// counts as burned.
+const (
+	BurnClaimTimeout = 2 * time.Minute
+	MaxBurnAttempts  = 2
+)
+
+// BurnClaim records who is reading a burn-after-read paste.
+type BurnClaim struct {
+	// Claimant is an opaque hash of the client address and user agent.
+	Claimant  string    `json:"claimant" bson:"claimant"`
+	ClaimedAt time.Time `json:"claimed_at" bson:"claimed_at"`
+	Attempts  int       `json:"attempts" bson:"attempts"`
+}
+
+// Expired reports whether the claim has timed out, so the paste counts as
+// burned.
+func (b *BurnClaim) Expired() bool {
+	return time.Since(b.ClaimedAt) > BurnClaimTimeout
+}
+
+// PasteVersion describes an earlier content of a paste, kept when the
+// content was replaced. CreatedAt is when that content was uploaded.
+type PasteVersion struct {
+	Number      int       `json:"version" bson:"version"`
+	Size        int64     `json:"size" bson:"size"`
+	ContentType string    `json:"content_type" bson:"content_type"`
+	Encoding    string    `json:"encoding,omitempty" bson:"encoding,omitempty"`
+	CreatedAt   time.Time `json:"created_at" bson:"created_at"`
+}
+
+// CurrentVersion returns the number of the paste's current content.
+func (p *Paste) CurrentVersion() int {
+	if p.Version == 0 {
+		return 1
+	}
+	return p.Version
+}
+
+// ContentCreatedAt returns when the current content was uploaded.
+func (p *Paste) ContentCreatedAt() time.Time {
+	if p.UpdatedAt != nil {
+		return *p.UpdatedAt
+	}
+	return p.CreatedAt
+}
+
+// ModTime returns the Last-Modified time of the current content: the
+// uploaded file's modification time when one was sent, else when the
+// content was uploaded.
+func (p *Paste) ModTime() time.Time {
+	if p.FileMtime != nil {
+		return *p.FileMtime
+	}
+	return p.ContentCreatedAt()
+}
+
+// FindVersion returns the kept earlier version n, or nil.
+func (p *Paste) FindVersion(n int) *PasteVersion {
+	for i := range p.Versions {
+		if p.Versions[i].Number == n {
+			return &p.Versions[i]
+		}
+	}
+	return nil
+}
+
 // ShouldBurn returns true if this paste should be deleted after reading
 func (p *Paste) ShouldBurn() bool {
 	return p.BurnAfterRead && p.ReadCount > 0
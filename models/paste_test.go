@@ -151,7 +151,48 @@ func TestPaste_StructFields(t *testing.T) {
 	//	}
 }
 
+func TestParseVisibility(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Visibility
+		wantErr bool
+	}{
+		{in: "", want: VisibilityUnlisted},
+		{in: "public", want: VisibilityPublic},
+		{in: " Private ", want: VisibilityPrivate},
+		{in: "UNLISTED", want: VisibilityUnlisted},
+		{in: "secret", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseVisibility(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseVisibility(%q) = %q, %v; want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	if got := (&Paste{}).VisibilityLevel(); got != VisibilityUnlisted {
+		t.Errorf("VisibilityLevel() of a paste without visibility = %q, want unlisted", got)
+	}
+}
+
 // timePtr is a helper function to create a time pointer
 func timePtr(t time.Time) *time.Time {
 	return &t
 }
+
+func TestParseFileMtime(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	want := time.Date(2026, 9, 30, 8, 15, 0, 0, time.UTC)
+
+	for _, v := range []string{"1790756100", "2026-09-30T08:15:00Z", "2026-09-30T10:15:00.5+02:00", "Wed, 30 Sep 2026 08:15:00 GMT"} {
+		got, err := ParseFileMtime(v, now)
+		if err != nil || !got.Equal(want) || got.Location() != time.UTC {
+			t.Errorf("ParseFileMtime(%q) = %v, %v; want %v", v, got, err, want)
+		}
+	}
+	for _, v := range []string{"", "yesterday", "-1", "2027-01-01T00:00:00Z"} {
+		if _, err := ParseFileMtime(v, now); err == nil {
+			t.Errorf("ParseFileMtime(%q): expected an error", v)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// MetadataSchema is the version of the paste metadata format this build
+// writes. Metadata stored before schema versions existed has none and is
+// version 0.
+//
+// Version 1 stores every timestamp in UTC. Earlier metadata kept the
+// offset of the server's local zone.
+const MetadataSchema = 1
+
+// Upgrade migrates metadata read from storage to MetadataSchema in place
+// and reports whether it was stored with an older schema. Metadata written
+// by a newer nclip is left as it is.
+func (p *Paste) Upgrade() bool {
+	if p.SchemaVersion >= MetadataSchema {
+		return false
+	}
+	p.normalizeTimes()
+	p.SchemaVersion = MetadataSchema
+	return true
+}
+
+// normalizeTimes converts the paste's timestamps to UTC. Pointers and
+// slices are replaced rather than written through, so a shallow copy can
+// be normalized without changing the original.
+func (p *Paste) normalizeTimes() {
+	p.CreatedAt = p.CreatedAt.UTC()
+	p.ExpiresAt = utcPtr(p.ExpiresAt)
+	p.UpdatedAt = utcPtr(p.UpdatedAt)
+	if p.BurnClaim != nil {
+		claim := *p.BurnClaim
+		claim.ClaimedAt = claim.ClaimedAt.UTC()
+		p.BurnClaim = &claim
+	}
+	if p.Versions != nil {
+		versions := make([]PasteVersion, len(p.Versions))
+		for i, v := range p.Versions {
+			v.CreatedAt = v.CreatedAt.UTC()
+			versions[i] = v
+		}
+		p.Versions = versions
+	}
+}
+
+func utcPtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+// MarshalJSON writes the paste in the current schema: its timestamps are
+// RFC 3339 in UTC, whatever zone they are held in.
+func (p Paste) MarshalJSON() ([]byte, error) {
+	type paste Paste
+	p.normalizeTimes()
+	p.SchemaVersion = MetadataSchema
+	return json.Marshal(paste(p))
+}
+
+// UnmarshalJSON reads a paste stored with any schema and upgrades it, so
+// every backend serves old metadata in the current schema.
+func (p *Paste) UnmarshalJSON(data []byte) error {
+	type paste Paste
+	if err := json.Unmarshal(data, (*paste)(p)); err != nil {
+		return err
+	}
+	p.Upgrade()
+	return nil
+}
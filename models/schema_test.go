@@ -0,0 +1,66 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPaste_MarshalJSON_UTC(t *testing.T) {
+	zone := time.FixedZone("UTC+8", 8*3600)
+	created := time.Date(2025, 3, 1, 20, 30, 0, 0, zone)
+	expires := created.Add(time.Hour)
+	p := &Paste{
+		ID:        "ZONED",
+		CreatedAt: created,
+		ExpiresAt: &expires,
+		BurnClaim: &BurnClaim{Claimant: "c", ClaimedAt: created},
+		Versions:  []PasteVersion{{Number: 1, CreatedAt: created}},
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"schema_version":1`, `"created_at":"2025-03-01T12:30:00Z"`, `"expires_at":"2025-03-01T13:30:00Z"`, `"claimed_at":"2025-03-01T12:30:00Z"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("JSON %s lacks %s", data, want)
+		}
+	}
+	if strings.Contains(string(data), "+08:00") {
+		t.Errorf("JSON %s has a local offset", data)
+	}
+	// Marshaling must not change the paste it was given.
+	if p.CreatedAt.Location() != zone || p.ExpiresAt.Location() != zone || p.Versions[0].CreatedAt.Location() != zone || p.SchemaVersion != 0 {
+		t.Error("MarshalJSON modified the paste")
+	}
+}
+
+func TestPaste_UnmarshalJSON_Upgrades(t *testing.T) {
+	old := `{"id":"OLDMETA","created_at":"2024-06-01T09:00:00.123456789+02:00","expires_at":"2024-06-02T09:00:00+02:00",` +
+		`"updated_at":"2024-06-01T10:00:00-05:00","versions":[{"version":1,"size":3,"content_type":"text/plain","created_at":"2024-06-01T09:00:00+02:00"}]}`
+	var p Paste
+	if err := json.Unmarshal([]byte(old), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.SchemaVersion != MetadataSchema {
+		t.Errorf("SchemaVersion = %d, want %d", p.SchemaVersion, MetadataSchema)
+	}
+	for name, ts := range map[string]time.Time{"created_at": p.CreatedAt, "expires_at": *p.ExpiresAt, "updated_at": *p.UpdatedAt, "versions": p.Versions[0].CreatedAt} {
+		if ts.Location() != time.UTC {
+			t.Errorf("%s = %v, want UTC", name, ts)
+		}
+	}
+	if want := time.Date(2024, 6, 1, 7, 0, 0, 123456789, time.UTC); !p.CreatedAt.Equal(want) {
+		t.Errorf("created_at = %v, want %v", p.CreatedAt, want)
+	}
+
+	// Metadata from a newer nclip is not touched.
+	var newer Paste
+	if err := json.Unmarshal([]byte(`{"schema_version":99,"id":"NEWER","created_at":"2024-06-01T09:00:00+02:00"}`), &newer); err != nil {
+		t.Fatal(err)
+	}
+	if newer.SchemaVersion != 99 || newer.Upgrade() {
+		t.Errorf("newer metadata was upgraded to %d", newer.SchemaVersion)
+	}
+}
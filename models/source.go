@@ -0,0 +1,65 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+// Keys of Paste.Source, with the upload headers they are read from.
+const (
+	// SourceRepo is the repository, e.g. "org/app" (X-Source-Repo).
+	SourceRepo = "repo"
+	// SourcePipeline names the pipeline or workflow (X-Source-Pipeline).
+	SourcePipeline = "pipeline"
+	// SourceJobURL links to the job's page (X-Source-Job-URL).
+	SourceJobURL = "job_url"
+	// SourceCommit is the commit SHA built (X-Source-Commit).
+	SourceCommit = "commit"
+)
+
+// MaxSourceLength is the longest source value accepted, in bytes.
+const MaxSourceLength = 256
+
+// sourceHeaders maps the X-Source-* upload headers to their Source keys.
+var sourceHeaders = []struct{ header, key string }{
+	{"X-Source-Repo", SourceRepo},
+	{"X-Source-Pipeline", SourcePipeline},
+	{"X-Source-Job-URL", SourceJobURL},
+	{"X-Source-Commit", SourceCommit},
+}
+
+// ParseSource reads the X-Source-* headers through header and returns the
+// Source of the paste, or nil when none is set. Values are trimmed; the
+// job URL must be an http(s) URL and the commit a hex SHA of 7 to 64
+// digits, which is lowercased.
+func ParseSource(header func(name string) string) (map[string]string, error) {
+	var source map[string]string
+	for _, h := range sourceHeaders {
+		v := strings.TrimSpace(header(h.header))
+		if v == "" {
+			continue
+		}
+		if len(v) > MaxSourceLength || strings.IndexFunc(v, unicode.IsControl) >= 0 {
+			return nil, fmt.Errorf("invalid %s: at most %d printable characters are allowed", h.header, MaxSourceLength)
+		}
+		switch h.key {
+		case SourceJobURL:
+			u, err := url.Parse(v)
+			if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return nil, fmt.Errorf("invalid %s: an http or https URL is required", h.header)
+			}
+		case SourceCommit:
+			v = strings.ToLower(v)
+			if len(v) < 7 || len(v) > 64 || strings.Trim(v, "0123456789abcdef") != "" {
+				return nil, fmt.Errorf("invalid %s: a hex commit SHA is required", h.header)
+			}
+		}
+		if source == nil {
+			source = map[string]string{}
+		}
+		source[h.key] = v
+	}
+	return source, nil
+}
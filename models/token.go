@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Limits on share tokens.
+const (
+	// MaxTokensPerPaste is the most share tokens a paste can have.
+	MaxTokensPerPaste = 100
+	// MaxTokenUses bounds ShareToken.MaxUses.
+	MaxTokenUses = 1000000
+)
+
+// ShareToken grants reads of a paste at /t/<token> without revealing its
+// slug. Each token has its own use limit and expiry, and revoking one
+// leaves the others working.
+type ShareToken struct {
+	Token string `json:"token"`
+	Slug  string `json:"slug"`
+	// PasteCreatedAt is the creation time of the paste the token was
+	// issued for, so a token never reads a later paste reusing the slug.
+	PasteCreatedAt time.Time  `json:"paste_created_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	// MaxUses is how many reads the token allows; 0 is unlimited.
+	MaxUses int `json:"max_uses,omitempty"`
+	Uses    int `json:"uses"`
+	// Owner is the audit key ID of the API key that issued the token.
+	Owner string `json:"owner,omitempty"`
+}
+
+// IsExpired reports whether the token's expiry has passed.
+func (t *ShareToken) IsExpired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// Exhausted reports whether the token has no uses left.
+func (t *ShareToken) Exhausted() bool {
+	return t.MaxUses > 0 && t.Uses >= t.MaxUses
+}
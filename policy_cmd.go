@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/johnwmail/nclip/config"
+	"github.com/johnwmail/nclip/internal/apikeys"
+	"github.com/johnwmail/nclip/internal/audit"
+	"github.com/johnwmail/nclip/internal/policysim"
+	"github.com/johnwmail/nclip/storage"
+)
+
+const policyUsage = `Usage: nclip policy simulate [--days N] [--json] [flags]
+
+Preview the lifecycle settings against the pastes in the store: what
+expires in each of the next days, the storage use they leave assuming
+uploads continue at the rate of the last 24 hours, and the pastes that
+break the TTL, size and version limits. The settings are read like the
+server's, so a changed value can be tried with its flag or environment
+variable before it is deployed, e.g.
+
+    nclip policy simulate --days 30 --min-retention 720h
+
+The store is chosen as nclip chooses it and only read; expired pastes are
+not removed.
+
+`
+
+// runPolicyCommand implements the "nclip policy" subcommand and returns the
+// process exit code.
+func runPolicyCommand(args []string, stdout, stderr io.Writer, getenv func(string) string) int {
+	if len(args) == 0 || args[0] != "simulate" {
+		_, _ = fmt.Fprint(stderr, policyUsage)
+		return 2
+	}
+	fs := flag.NewFlagSet("nclip policy simulate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprint(stderr, policyUsage)
+		fs.PrintDefaults()
+	}
+	days := fs.Int("days", 7, "Number of days to simulate (1-365)")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	cfg, _, err := config.Load(fs, args[1:], getenv)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+		return 1
+	}
+	if *days < 1 || *days > 365 {
+		_, _ = fmt.Fprintf(stderr, "nclip: --days must be between 1 and 365, got %d\n", *days)
+		return 2
+	}
+	limits, err := apikeys.LoadSizeLimits(cfg.APIKeysFile)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+		return 1
+	}
+
+	store, name, err := openBackendStore(cfg)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+		return 1
+	}
+	defer func() { _ = store.Close() }()
+	if ro, ok := store.(storage.ReadOnlySetter); ok {
+		ro.SetReadOnly(true)
+	}
+	report, err := policysim.Simulate(storage.NewTenantStore(store), simulationPolicy(cfg, limits), *days, time.Now().UTC())
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %s: %v\n", name, err)
+		return 1
+	}
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	printPolicyReport(stdout, name, report)
+	return 0
+}
+
+// simulationPolicy returns the lifecycle settings of cfg. Pastes record
+// their owner by audit key ID, so the keys file's size limits are keyed
+// the same way.
+func simulationPolicy(cfg *config.Config, limits apikeys.SizeLimits) policysim.Policy {
+	owners := make(map[string]int64, len(limits))
+	for key, size := range limits {
+		if key == apikeys.Anonymous {
+			owners[""] = size
+		} else {
+			owners[audit.KeyID(key)] = size
+		}
+	}
+	return policysim.Policy{
+		DefaultTTL:   cfg.DefaultTTL,
+		MinRetention: cfg.MinRetention,
+		MaxTTL:       config.MaxTTL,
+		MaxSize:      cfg.BufferSize,
+		OwnerLimits:  owners,
+		MaxVersions:  cfg.MaxVersions,
+	}
+}
+
+// printPolicyReport writes r as text.
+func printPolicyReport(w io.Writer, store string, r *policysim.Report) {
+	_, _ = fmt.Fprintf(w, "%s: %d pastes, %s (%d never expire, %d expired awaiting removal)\n\n",
+		store, r.Pastes, formatSize(r.Bytes), r.Permanent, r.Expired)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "Day ending\tExpiring\t\tProjected\t\n")
+	for i, day := range r.Expiring {
+		projected := r.Projected[i]
+		_, _ = fmt.Fprintf(tw, "%s\t%d pastes\t%s\t%d pastes\t%s\n", day.Date.Format("2006-01-02 15:04"),
+			day.Pastes, formatSize(day.Bytes), projected.Pastes, formatSize(projected.Bytes))
+	}
+	_ = tw.Flush()
+	_, _ = fmt.Fprintf(w, "\nThe projection assumes %d uploads (%s) a day, as in the last 24 hours, each kept for %s.\n",
+		r.Ingest.Pastes, formatSize(r.Ingest.Bytes), r.NewPasteLifetime)
+
+	total := 0
+	rules := make([]string, 0, len(r.ViolationCounts))
+	for rule, n := range r.ViolationCounts {
+		rules = append(rules, rule)
+		total += n
+	}
+	if total == 0 {
+		_, _ = fmt.Fprintln(w, "\nNo paste violates the policy.")
+		return
+	}
+	sort.Strings(rules)
+	_, _ = fmt.Fprintf(w, "\n%d policy violations:\n", total)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, rule := range rules {
+		_, _ = fmt.Fprintf(tw, "  %s\t%d\n", rule, r.ViolationCounts[rule])
+	}
+	_ = tw.Flush()
+	if total > len(r.Violations) {
+		_, _ = fmt.Fprintf(w, "\nThe first %d:\n", len(r.Violations))
+	} else {
+		_, _ = fmt.Fprintln(w)
+	}
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, v := range r.Violations {
+		_, _ = fmt.Fprintf(tw, "  %s\t%s\t%s\n", v.Slug, v.Rule, v.Detail)
+	}
+	_ = tw.Flush()
+}
+
+// formatSize formats n bytes with a binary unit.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPolicyCommand(t *testing.T) {
+	dir := t.TempDir()
+	created := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+	expires := time.Now().UTC().Add(23 * time.Hour).Format(time.RFC3339)
+	meta := `{"id":"SHRT","created_at":"` + created + `","expires_at":"` + expires + `","size":2048,"content_type":"text/plain"}`
+	if err := os.WriteFile(filepath.Join(dir, "SHRT.json"), []byte(meta), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	getenv := func(key string) string {
+		if key == "NCLIP_DATA_DIR" {
+			return dir
+		}
+		return ""
+	}
+	run := func(args ...string) (int, string) {
+		var stdout, stderr bytes.Buffer
+		code := runPolicyCommand(args, &stdout, &stderr, getenv)
+		return code, stdout.String() + stderr.String()
+	}
+
+	code, out := run("simulate", "--days", "2")
+	if code != 0 || !strings.Contains(out, "filesystem: 1 pastes, 2.0 KiB") || !strings.Contains(out, "No paste violates the policy.") {
+		t.Fatalf("simulate exited %d: %s", code, out)
+	}
+	// A stricter configuration, tried with flags.
+	code, out = run("simulate", "--min-retention", "48h", "--buffer-size", "1024")
+	if code != 0 || !strings.Contains(out, "2 policy violations") || !strings.Contains(out, "SHRT  min_retention") {
+		t.Fatalf("simulate with new limits exited %d: %s", code, out)
+	}
+	code, out = run("simulate", "--json")
+	var report struct {
+		Pastes   int `json:"pastes"`
+		Expiring []struct {
+			Pastes int `json:"pastes"`
+		} `json:"expiring"`
+	}
+	if code != 0 || json.Unmarshal([]byte(out), &report) != nil || report.Pastes != 1 || len(report.Expiring) != 7 || report.Expiring[0].Pastes != 1 {
+		t.Fatalf("simulate --json exited %d: %s", code, out)
+	}
+	if code, out := run("simulate", "--days", "0"); code != 2 {
+		t.Errorf("--days 0 exited %d: %s", code, out)
+	}
+	if code, out := run("apply"); code != 2 {
+		t.Errorf("unknown subcommand exited %d: %s", code, out)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const pushUsage = `Usage: nclip push [--url URL] [--follow] [flags] < file
+
+Upload standard input to an nclip server and print the paste URL.
+
+With --follow, the paste is created as soon as the first data arrives and
+stays appendable: later input is appended as it is read, and the paste is
+finalized at end of input or on interrupt. Readers follow it with
+GET /raw/{slug}?follow=true, e.g.
+
+    tail -f build.log | nclip push --follow
+
+`
+
+// pushFlushInterval is how long --follow collects input before appending
+// it, so a chatty log does not cost one request per line.
+const pushFlushInterval = 500 * time.Millisecond
+
+// pushMaxChunk is the most input --follow collects before appending it
+// without waiting for the flush interval.
+const pushMaxChunk = 256 * 1024
+
+// runPushCommand implements the "nclip push" subcommand and returns the
+// process exit code. Closing stop finalizes a --follow paste as if input
+// had ended.
+func runPushCommand(args []string, stdin io.Reader, stdout, stderr io.Writer, getenv func(string) string, stop <-chan struct{}) int {
+	fs := flag.NewFlagSet("nclip push", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprint(stderr, pushUsage)
+		fs.PrintDefaults()
+	}
+	server := fs.String("url", envOr(getenv, "NCLIP_URL", "http://localhost:8080"), "Server URL, including any route prefix (env NCLIP_URL)")
+	apiKey := fs.String("api-key", getenv("NCLIP_API_KEY"), "API key to upload with (env NCLIP_API_KEY)")
+	follow := fs.Bool("follow", false, "Keep appending input to the paste until it ends")
+	ttl := fs.String("ttl", "", "Paste lifetime, e.g. 1h (X-TTL)")
+	slug := fs.String("slug", "", "Custom slug (X-Slug)")
+	filename := fs.String("filename", "", "Filename to store with the paste (X-Filename)")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	p := &pusher{
+		server: strings.TrimRight(*server, "/"),
+		apiKey: *apiKey,
+		client: &http.Client{Timeout: time.Minute},
+		header: http.Header{},
+	}
+	for name, value := range map[string]string{"X-TTL": *ttl, "X-Slug": *slug, "X-Filename": *filename} {
+		if value != "" {
+			p.header.Set(name, value)
+		}
+	}
+
+	if *slug != "" {
+		if err := p.checkSlug(*slug); err != nil {
+			_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+			return 1
+		}
+	}
+
+	var err error
+	if *follow {
+		err = p.follow(stdin, stdout, stop)
+	} else {
+		err = p.once(stdin, stdout)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// envOr returns the environment variable name, or def when it is unset.
+func envOr(getenv func(string) string, name, def string) string {
+	if v := getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// pusher uploads to one server.
+type pusher struct {
+	server string
+	apiKey string
+	client *http.Client
+	header http.Header
+}
+
+// pushResponse is the part of the upload response push uses.
+type pushResponse struct {
+	URL       string `json:"url"`
+	AppendURL string `json:"append_url"`
+}
+
+// once uploads all of r as one paste.
+func (p *pusher) once(r io.Reader, stdout io.Writer) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read input: %w", err)
+	}
+	resp, err := p.create(content, false)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(stdout, resp.URL)
+	return nil
+}
+
+// follow creates an appendable paste from the first input and appends the
+// rest as it arrives, finalizing the paste at end of input or when stop
+// is closed.
+func (p *pusher) follow(r io.Reader, stdout io.Writer, stop <-chan struct{}) error {
+	chunks := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			buf := make([]byte, 32*1024)
+			n, err := r.Read(buf)
+			if n > 0 {
+				chunks <- buf[:n]
+			}
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				readErr <- err
+				close(chunks)
+				return
+			}
+		}
+	}()
+
+	var pending []byte
+	var appendURL string
+	ticker := time.NewTicker(pushFlushInterval)
+	defer ticker.Stop()
+	flush := func(final bool) error {
+		if appendURL == "" {
+			if len(pending) == 0 {
+				// Input ended before anything arrived: nothing to share.
+				return nil
+			}
+			resp, err := p.create(pending, true)
+			if err != nil {
+				return err
+			}
+			if resp.AppendURL == "" {
+				return errors.New("the server did not return an append URL")
+			}
+			appendURL = resp.AppendURL
+			_, _ = fmt.Fprintln(stdout, resp.URL)
+			pending = nil
+			if !final {
+				return nil
+			}
+		}
+		if len(pending) == 0 && !final {
+			return nil
+		}
+		if err := p.appendChunk(appendURL, pending, final); err != nil {
+			return err
+		}
+		pending = nil
+		return nil
+	}
+
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				if err := <-readErr; err != nil {
+					_ = flush(true)
+					return fmt.Errorf("read input: %w", err)
+				}
+				return flush(true)
+			}
+			pending = append(pending, chunk...)
+			if len(pending) >= pushMaxChunk {
+				if err := flush(false); err != nil {
+					return err
+				}
+			}
+		case <-ticker.C:
+			if err := flush(false); err != nil {
+				return err
+			}
+		case <-stop:
+			return flush(true)
+		}
+	}
+}
+
+// create uploads content as a new paste.
+func (p *pusher) create(content []byte, appendable bool) (*pushResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, p.server+"/", bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range p.header {
+		req.Header[name] = values
+	}
+	if appendable {
+		req.Header.Set("X-Appendable", "true")
+	}
+	var resp pushResponse
+	if err := p.do(req, &resp); err != nil {
+		return nil, fmt.Errorf("upload: %w", err)
+	}
+	return &resp, nil
+}
+
+// appendChunk appends chunk through appendURL.
+func (p *pusher) appendChunk(appendURL string, chunk []byte, final bool) error {
+	if final {
+		appendURL += "&final=true"
+	}
+	req, err := http.NewRequest(http.MethodPost, appendURL, bytes.NewReader(chunk))
+	if err != nil {
+		return err
+	}
+	if err := p.do(req, nil); err != nil {
+		return fmt.Errorf("append: %w", err)
+	}
+	return nil
+}
+
+// checkSlug fails when slug is taken, before any input is read, so a
+// --follow session is not refused only once the first data arrives.
+// Servers without the existence API answer 404, leaving the check to the
+// upload.
+func (p *pusher) checkSlug(slug string) error {
+	req, err := http.NewRequest(http.MethodHead, p.server+"/api/v1/exists/"+url.PathEscape(slug), nil)
+	if err != nil {
+		return err
+	}
+	p.authorize(req)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("check slug: %w", err)
+	}
+	_ = resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return fmt.Errorf("slug %q is already taken", slug)
+	case http.StatusBadRequest:
+		return fmt.Errorf("invalid slug %q", slug)
+	}
+	return nil
+}
+
+// authorize identifies the client and adds the API key, if any, to req.
+func (p *pusher) authorize(req *http.Request) {
+	req.Header.Set("User-Agent", "nclip-push")
+	if p.apiKey != "" {
+		req.Header.Set("X-Api-Key", p.apiKey)
+	}
+}
+
+// do sends req, asking for JSON, and decodes a successful response into
+// out when it is not nil. Error responses are reported with the server's
+// message.
+func (p *pusher) do(req *http.Request, out any) error {
+	req.Header.Set("Accept", "application/json")
+	p.authorize(req)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s: %s", resp.Status, apiErr.Error)
+		}
+		return errors.New(resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwmail/nclip/config"
+)
+
+func TestPushCommand(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{
+		SlugLength:    5,
+		BufferSize:    1024 * 1024,
+		DefaultTTL:    24 * time.Hour,
+		MaxRenderSize: 1024,
+	}
+	store := newTestStore()
+	srv := httptest.NewServer(setupRouter(store, cfg, nil))
+	defer srv.Close()
+	getenv := func(key string) string {
+		if key == "NCLIP_URL" {
+			return srv.URL
+		}
+		return ""
+	}
+	slugOf := func(url string) string { return url[strings.LastIndex(url, "/")+1:] }
+
+	var stdout, stderr bytes.Buffer
+	if code := runPushCommand([]string{"--ttl", "1h"}, strings.NewReader("hello"), &stdout, &stderr, getenv, nil); code != 0 {
+		t.Fatalf("push exited %d: %s", code, stderr.String())
+	}
+	paste, err := store.Get(slugOf(strings.TrimSpace(stdout.String())))
+	if err != nil || paste.Appendable || paste.Size != 5 {
+		t.Fatalf("pushed paste = %+v, %v", paste, err)
+	}
+
+	// Follow: the paste is created from the first input, readers following
+	// it see later input, and it is finalized when input ends.
+	in, feed := io.Pipe()
+	urls, out := io.Pipe()
+	done := make(chan int, 1)
+	go func() {
+		defer func() { _ = out.Close() }()
+		done <- runPushCommand([]string{"--follow"}, in, out, &stderr, getenv, nil)
+	}()
+	if _, err := io.WriteString(feed, "line 1\n"); err != nil {
+		t.Fatal(err)
+	}
+	pasteURL, err := bufio.NewReader(urls).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading paste URL: %v (%s)", err, stderr.String())
+	}
+	go func() { _, _ = io.Copy(io.Discard, urls) }()
+	slug := slugOf(strings.TrimSpace(pasteURL))
+
+	resp, err := http.Get(srv.URL + "/raw/" + slug + "?follow=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	followed := make(chan string, 1)
+	go func() {
+		body, _ := io.ReadAll(resp.Body)
+		followed <- string(body)
+	}()
+	if _, err := io.WriteString(feed, "line 2\n"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * pushFlushInterval)
+	_ = feed.Close()
+	if code := <-done; code != 0 {
+		t.Fatalf("push --follow exited %d: %s", code, stderr.String())
+	}
+
+	select {
+	case body := <-followed:
+		if body != "line 1\nline 2\n" {
+			t.Errorf("followed %q", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("follow read did not end after the final append")
+	}
+	if paste, err := store.Get(slug); err != nil || paste.Appendable {
+		t.Errorf("paste after push --follow = %+v, %v; want finalized", paste, err)
+	}
+
+	// A taken slug is refused before any input is read.
+	stderr.Reset()
+	if code := runPushCommand([]string{"--follow", "--slug", slug}, iotest.ErrReader(io.ErrUnexpectedEOF), io.Discard, &stderr, getenv, nil); code != 1 || !strings.Contains(stderr.String(), "already taken") {
+		t.Errorf("push --slug %s exited %d: %s", slug, code, stderr.String())
+	}
+	stdout.Reset()
+	if code := runPushCommand([]string{"--slug", "FREESLUG"}, strings.NewReader("hi"), &stdout, &stderr, getenv, nil); code != 0 || slugOf(strings.TrimSpace(stdout.String())) != "FREESLUG" {
+		t.Errorf("push --slug FREESLUG exited %d: %s%s", code, stdout.String(), stderr.String())
+	}
+}
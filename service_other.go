@@ -0,0 +1,16 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// runServiceCommand implements the "nclip service" subcommand, which only
+// exists on Windows. Elsewhere nclip runs under the platform's own service
+// manager (systemd, launchd) as a normal foreground process.
+func runServiceCommand(_ []string, _, stderr io.Writer) int {
+	_, _ = fmt.Fprintln(stderr, "nclip: the service command is only available on Windows; run nclip under systemd or launchd instead")
+	return 2
+}
@@ -0,0 +1,206 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/johnwmail/nclip/config"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceName is the name nclip is registered under with the Windows
+// service manager and the event log.
+const serviceName = "nclip"
+
+const serviceUsage = `Usage: nclip service <install|uninstall|run> [flags]
+
+Manage nclip as a Windows service named "nclip".
+
+  install     Register the service to start automatically. Any flags are
+              passed to the service on every start, e.g.
+              nclip service install --config C:\nclip\nclip.yaml
+  uninstall   Stop and remove the service.
+  run         Run as the service. The service manager starts this; it is
+              not meant to be run from a console.
+
+The service runs with the directory holding nclip.exe as its working
+directory, so relative paths (./data, nclip.yaml) resolve there. Logs go
+to the Windows event log under the source "nclip".
+`
+
+// runServiceCommand implements the "nclip service" subcommand and returns
+// the process exit code.
+func runServiceCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		_, _ = fmt.Fprint(stderr, serviceUsage)
+		return 2
+	}
+	var err error
+	switch args[0] {
+	case "install":
+		err = installService(args[1:])
+		if err == nil {
+			_, _ = fmt.Fprintf(stdout, "Installed service %q; start it with: sc start %s\n", serviceName, serviceName)
+		}
+	case "uninstall":
+		err = uninstallService()
+		if err == nil {
+			_, _ = fmt.Fprintf(stdout, "Removed service %q\n", serviceName)
+		}
+	case "run":
+		err = runService(args[1:])
+	default:
+		_, _ = fmt.Fprint(stderr, serviceUsage)
+		return 2
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "nclip: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func installService(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer func() { _ = m.Disconnect() }()
+	if s, err := m.OpenService(serviceName); err == nil {
+		_ = s.Close()
+		return fmt.Errorf("service %q is already installed", serviceName)
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "nclip",
+		Description: "nclip paste service",
+		StartType:   mgr.StartAutomatic,
+	}, append([]string{"service", "run"}, args...)...)
+	if err != nil {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+	defer func() { _ = s.Close() }()
+	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		_ = s.Delete()
+		return fmt.Errorf("failed to register event log source: %w", err)
+	}
+	return nil
+}
+
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer func() { _ = m.Disconnect() }()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %q is not installed", serviceName)
+	}
+	defer func() { _ = s.Close() }()
+	// Deletion takes effect once the service has stopped.
+	_, _ = s.Control(svc.Stop)
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to delete service: %w", err)
+	}
+	if err := eventlog.Remove(serviceName); err != nil {
+		log.Printf("[WARN] Failed to remove event log source: %v", err)
+	}
+	return nil
+}
+
+func runService(args []string) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		return errors.New("service run is started by the service manager; run nclip without it to use the console")
+	}
+	// Services start in the system directory; resolve relative paths next
+	// to the executable instead.
+	if exe, err := os.Executable(); err == nil {
+		_ = os.Chdir(filepath.Dir(exe))
+	}
+	if elog, err := eventlog.Open(serviceName); err == nil {
+		defer func() { _ = elog.Close() }()
+		log.SetFlags(0)
+		log.SetOutput(eventLogWriter{elog})
+	}
+	cfg, _, err := config.Load(flag.NewFlagSet("nclip service run", flag.ContinueOnError), args, os.Getenv)
+	if err != nil {
+		log.Printf("[ERROR] Invalid configuration: %v", err)
+		return err
+	}
+	return svc.Run(serviceName, &windowsService{cfg: cfg})
+}
+
+// windowsService runs nclip under the service manager.
+type windowsService struct {
+	cfg *config.Config
+}
+
+// Execute implements svc.Handler.
+func (s *windowsService) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serve(s.cfg, stop)
+	}()
+	running := svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	status <- running
+	for {
+		select {
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				// runHTTPServer allows 30s for graceful shutdown.
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32((35 * time.Second).Milliseconds())}
+				close(stop)
+				<-done
+				return false, 0
+			}
+		case <-done:
+			// The server stopped on its own.
+			return false, 1
+		}
+	}
+}
+
+// eventLogWriter sends log output to the event log, picking the event
+// type from the [ERROR]/[WARN] prefix.
+type eventLogWriter struct {
+	elog *eventlog.Log
+}
+
+func (w eventLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+	var err error
+	switch {
+	case strings.Contains(msg, "[ERROR]"):
+		err = w.elog.Error(1, msg)
+	case strings.Contains(msg, "[WARN]"):
+		err = w.elog.Warning(1, msg)
+	default:
+		err = w.elog.Info(1, msg)
+	}
+	return len(p), err
+}
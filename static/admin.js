@@ -0,0 +1,372 @@
+// Admin console: every action is a call to the admin API with the key the
+// admin signed in with, which is kept in sessionStorage for the tab only.
+document.addEventListener('DOMContentLoaded', function () {
+    const base = document.getElementById('admin').dataset.base;
+    const storageKey = 'nclip.adminKey';
+    const statsInterval = 10000;
+
+    const login = document.getElementById('admin-login');
+    const loginForm = document.getElementById('admin-login-form');
+    const loginStatus = document.getElementById('admin-login-status');
+    const consoleSection = document.getElementById('admin-console');
+    const filters = document.getElementById('admin-filters');
+    const pastesBody = document.querySelector('#admin-pastes tbody');
+    const moreBtn = document.getElementById('admin-more');
+    const detail = document.getElementById('admin-detail');
+    const detailStatus = document.getElementById('admin-detail-status');
+    const pinBtn = document.getElementById('admin-pin');
+    const quarantineBtn = document.getElementById('admin-quarantine');
+    const keysSection = document.getElementById('admin-keys');
+    const keyStatus = document.getElementById('admin-key-status');
+
+    let me = null;
+    let cursor = '';
+    let current = null;
+    let statsTimer = null;
+
+    // api calls the API at path and returns the decoded JSON body, throwing
+    // the server's error message on failure.
+    async function api(method, path, body) {
+        const headers = { 'Accept': 'application/json', 'X-Api-Key': sessionStorage.getItem(storageKey) || '' };
+        if (body !== undefined) {
+            headers['Content-Type'] = 'application/json';
+        }
+        const response = await fetch(base + path, {
+            method: method,
+            headers: headers,
+            body: body === undefined ? undefined : JSON.stringify(body)
+        });
+        const data = await response.json().catch(function () { return {}; });
+        if (!response.ok) {
+            const err = new Error(data.error || response.statusText);
+            err.status = response.status;
+            throw err;
+        }
+        return data;
+    }
+
+    function cell(row, text) {
+        const td = document.createElement('td');
+        td.textContent = text === undefined || text === null ? '' : String(text);
+        row.appendChild(td);
+        return td;
+    }
+
+    function item(grid, label, value) {
+        const div = document.createElement('div');
+        div.className = 'info-item';
+        const l = document.createElement('label');
+        l.textContent = label + ':';
+        const v = document.createElement('span');
+        v.textContent = String(value);
+        div.append(l, v);
+        grid.appendChild(div);
+    }
+
+    function formatSize(bytes) {
+        const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+        let i = 0;
+        while (bytes >= 1024 && i < units.length - 1) {
+            bytes /= 1024;
+            i++;
+        }
+        return (i === 0 ? bytes : bytes.toFixed(1)) + ' ' + units[i];
+    }
+
+    function formatTime(value) {
+        return value ? new Date(value).toLocaleString() : 'never';
+    }
+
+    async function signIn() {
+        try {
+            me = await api('GET', 'api/v1/admin/me');
+        } catch (err) {
+            sessionStorage.removeItem(storageKey);
+            loginStatus.textContent = err.status === 401 ? 'Unknown API key.' : 'Sign-in failed: ' + err.message;
+            return;
+        }
+        login.hidden = true;
+        consoleSection.hidden = false;
+        let role = me.superadmin ? 'superadmin' : me.scopes.join(', ');
+        if (me.tenant) {
+            role += ' of tenant ' + me.tenant;
+        }
+        document.getElementById('admin-whoami').textContent = 'Signed in as ' + me.id + ' (' + role + ')';
+        keysSection.hidden = !me.superadmin;
+        refreshStats();
+        statsTimer = setInterval(refreshStats, statsInterval);
+        searchPastes(true);
+        if (me.superadmin) {
+            loadKeys();
+        }
+    }
+
+    loginForm.addEventListener('submit', function (e) {
+        e.preventDefault();
+        sessionStorage.setItem(storageKey, document.getElementById('admin-key').value.trim());
+        signIn();
+    });
+
+    document.getElementById('admin-logout').addEventListener('click', function () {
+        sessionStorage.removeItem(storageKey);
+        clearInterval(statsTimer);
+        location.reload();
+    });
+
+    // Stats panels whose endpoint is not enabled, or not open to the key,
+    // stay hidden.
+    async function refreshStats() {
+        const health = document.getElementById('admin-health');
+        try {
+            const h = await api('GET', 'health');
+            health.replaceChildren();
+            item(health, 'Status', h.status);
+            item(health, 'Role', h.role);
+            item(health, 'Updated', new Date().toLocaleTimeString());
+        } catch (err) {
+            health.replaceChildren();
+            item(health, 'Status', 'unreachable: ' + err.message);
+        }
+        panel('admin-hot', 'api/v1/stats/hot', function (data, body) {
+            data.slugs.forEach(function (s) {
+                const row = body.insertRow();
+                cell(row, s.slug);
+                cell(row, s.reads);
+                cell(row, s.error);
+            });
+        });
+        panel('admin-tenants', 'api/v1/stats/tenants', function (data, body) {
+            data.tenants.forEach(function (t) {
+                const row = body.insertRow();
+                cell(row, t.tenant || '(default)');
+                cell(row, t.pastes);
+                cell(row, formatSize(t.bytes));
+                cell(row, t.reads);
+                cell(row, t.quota ? formatSize(t.quota) : '');
+            });
+        });
+    }
+
+    async function panel(id, path, render) {
+        const section = document.getElementById(id);
+        try {
+            const data = await api('GET', path);
+            const body = section.querySelector('tbody');
+            body.replaceChildren();
+            render(data, body);
+            section.hidden = false;
+        } catch (err) {
+            section.hidden = true;
+        }
+    }
+
+    async function searchPastes(reset) {
+        if (reset) {
+            cursor = '';
+            pastesBody.replaceChildren();
+        }
+        const params = new URLSearchParams();
+        new FormData(filters).forEach(function (value, name) {
+            if (value) {
+                params.set(name, value);
+            }
+        });
+        if (cursor) {
+            params.set('cursor', cursor);
+        }
+        let data;
+        try {
+            data = await api('GET', 'api/v1/pastes?' + params.toString());
+        } catch (err) {
+            const row = pastesBody.insertRow();
+            cell(row, 'Listing failed: ' + err.message).colSpan = 7;
+            moreBtn.hidden = true;
+            return;
+        }
+        data.pastes.forEach(function (p) {
+            const row = pastesBody.insertRow();
+            const id = cell(row, '');
+            const link = document.createElement('a');
+            link.href = '#';
+            link.textContent = p.id;
+            link.addEventListener('click', function (e) {
+                e.preventDefault();
+                showPaste(p.id);
+            });
+            id.appendChild(link);
+            cell(row, formatTime(p.created_at));
+            cell(row, p.pinned ? 'never' : formatTime(p.expires_at));
+            cell(row, formatSize(p.size));
+            cell(row, p.visibility);
+            cell(row, p.tags.join(', '));
+            const flags = [];
+            if (p.pinned) flags.push('pinned');
+            if (p.legal_hold) flags.push('held');
+            if (p.quarantined) flags.push('quarantined');
+            if (p.burn_after_read) flags.push('burn');
+            cell(row, flags.join(', '));
+        });
+        cursor = data.next_cursor || '';
+        moreBtn.hidden = !cursor;
+    }
+
+    filters.addEventListener('submit', function (e) {
+        e.preventDefault();
+        searchPastes(true);
+    });
+    moreBtn.addEventListener('click', function () {
+        searchPastes(false);
+    });
+
+    async function showPaste(slug) {
+        detailStatus.textContent = '';
+        try {
+            current = await api('GET', 'api/v1/meta/' + encodeURIComponent(slug));
+        } catch (err) {
+            detail.hidden = false;
+            detailStatus.textContent = 'Failed to load ' + slug + ': ' + err.message;
+            return;
+        }
+        const info = document.getElementById('admin-detail-info');
+        info.replaceChildren();
+        document.getElementById('admin-detail-id').textContent = current.id;
+        item(info, 'Created', formatTime(current.created_at));
+        item(info, 'Expires', current.pinned ? 'never (pinned)' : formatTime(current.expires_at));
+        item(info, 'Size', formatSize(current.size));
+        item(info, 'Type', current.content_type);
+        item(info, 'Visibility', current.visibility);
+        item(info, 'Reads', current.read_count);
+        if (current.tags.length) item(info, 'Tags', current.tags.join(', '));
+        if (current.filename) item(info, 'Filename', current.filename);
+        if (current.sha256) item(info, 'SHA-256', current.sha256);
+        if (current.source && current.source.repo) item(info, 'Source', current.source.repo);
+        if (current.legal_hold) item(info, 'Legal hold', 'yes');
+        if (current.quarantined) item(info, 'Quarantined', 'yes');
+        document.getElementById('admin-detail-view').href = base + encodeURIComponent(current.id);
+        pinBtn.textContent = current.pinned ? 'Unpin' : 'Pin';
+        quarantineBtn.textContent = current.quarantined ? 'Release' : 'Quarantine';
+        detail.hidden = false;
+        detail.scrollIntoView({ behavior: 'smooth' });
+    }
+
+    async function act(method, path, done) {
+        try {
+            await api(method, path);
+            detailStatus.textContent = done;
+        } catch (err) {
+            detailStatus.textContent = 'Failed: ' + err.message;
+            return false;
+        }
+        return true;
+    }
+
+    pinBtn.addEventListener('click', async function () {
+        const slug = current.id;
+        if (await act(current.pinned ? 'DELETE' : 'POST', 'api/v1/pastes/' + encodeURIComponent(slug) + '/pin',
+            current.pinned ? 'Unpinned.' : 'Pinned.')) {
+            showPaste(slug);
+        }
+    });
+
+    quarantineBtn.addEventListener('click', async function () {
+        const slug = current.id;
+        if (await act(current.quarantined ? 'DELETE' : 'POST', 'api/v1/pastes/' + encodeURIComponent(slug) + '/quarantine',
+            current.quarantined ? 'Released.' : 'Quarantined.')) {
+            showPaste(slug);
+        }
+    });
+
+    document.getElementById('admin-delete').addEventListener('click', async function () {
+        if (!confirm('Delete paste ' + current.id + '? This cannot be undone.')) {
+            return;
+        }
+        if (await act('DELETE', encodeURIComponent(current.id), 'Deleted.')) {
+            detail.hidden = true;
+            searchPastes(true);
+        }
+    });
+
+    async function loadKeys() {
+        let data;
+        try {
+            data = await api('GET', 'api/v1/admin/keys');
+        } catch (err) {
+            keyStatus.textContent = 'Failed to list keys: ' + err.message;
+            return;
+        }
+        document.getElementById('admin-keys-readonly').hidden = data.keys_file;
+        document.getElementById('admin-key-form').hidden = !data.keys_file;
+        document.getElementById('admin-quota-form').hidden = !data.keys_file;
+        const body = document.querySelector('#admin-keys-table tbody');
+        body.replaceChildren();
+        data.keys.forEach(function (k) {
+            const row = body.insertRow();
+            cell(row, k.id);
+            cell(row, k.hint);
+            cell(row, k.scopes.join(','));
+            cell(row, k.max_size ? formatSize(k.max_size) : '');
+            cell(row, k.tenant || '');
+            const actions = cell(row, '');
+            if (k.in_file && k.id !== me.id) {
+                const revoke = document.createElement('button');
+                revoke.className = 'btn btn-danger';
+                revoke.textContent = 'Revoke';
+                revoke.addEventListener('click', async function () {
+                    if (!confirm('Revoke key ' + k.id + '?')) {
+                        return;
+                    }
+                    try {
+                        await api('DELETE', 'api/v1/admin/keys/' + encodeURIComponent(k.id));
+                        keyStatus.textContent = 'Revoked ' + k.id + '.';
+                    } catch (err) {
+                        keyStatus.textContent = 'Failed: ' + err.message;
+                    }
+                    loadKeys();
+                });
+                actions.appendChild(revoke);
+            }
+        });
+        const quotas = document.getElementById('admin-quotas');
+        quotas.replaceChildren();
+        Object.keys(data.quotas).forEach(function (tenant) {
+            const li = document.createElement('li');
+            li.textContent = (tenant || '(default)') + ': ' + formatSize(data.quotas[tenant]);
+            quotas.appendChild(li);
+        });
+    }
+
+    document.getElementById('admin-key-form').addEventListener('submit', async function (e) {
+        e.preventDefault();
+        const form = new FormData(e.target);
+        try {
+            const created = await api('POST', 'api/v1/admin/keys', {
+                scopes: form.get('scopes').split(',').map(function (s) { return s.trim(); }).filter(Boolean),
+                max_size: Number(form.get('max_size')) || 0,
+                tenant: form.get('tenant').trim()
+            });
+            keyStatus.textContent = 'Created ' + created.id + ': ' + created.key + ' (copy it now, it is not shown again)';
+            e.target.reset();
+        } catch (err) {
+            keyStatus.textContent = 'Failed: ' + err.message;
+        }
+        loadKeys();
+    });
+
+    document.getElementById('admin-quota-form').addEventListener('submit', async function (e) {
+        e.preventDefault();
+        const form = new FormData(e.target);
+        try {
+            await api('PUT', 'api/v1/admin/quotas/' + encodeURIComponent(form.get('tenant').trim()),
+                { quota: Number(form.get('quota')) });
+            keyStatus.textContent = 'Quota saved.';
+            e.target.reset();
+        } catch (err) {
+            keyStatus.textContent = 'Failed: ' + err.message;
+        }
+        loadKeys();
+    });
+
+    if (sessionStorage.getItem(storageKey)) {
+        signIn();
+    }
+});
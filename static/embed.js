@@ -0,0 +1,30 @@
+// Embeds a paste in another page, like a gist:
+//
+//   <script src="https://paste.example.com/embed.js" data-slug="ABCDE"></script>
+//
+// The script replaces itself with an iframe showing /embed/ABCDE. Set
+// data-height to change the frame's height in pixels (default 300). The
+// server's NCLIP_EMBED_FRAME_ANCESTORS decides which sites may frame it.
+(function () {
+    const script = document.currentScript;
+    if (!script) {
+        return;
+    }
+    const slug = script.dataset.slug || '';
+    if (!/^[A-HJ-NP-Z2-9]{3,32}$/.test(slug)) {
+        console.error('nclip embed: missing or invalid data-slug', slug);
+        return;
+    }
+    const height = /^[0-9]+$/.test(script.dataset.height || '') ? script.dataset.height : '300';
+
+    // The embed page sits next to this script, under any route prefix.
+    const base = script.src.replace(/\/embed\.js(\?.*)?$/, '');
+    const frame = document.createElement('iframe');
+    frame.src = base + '/embed/' + slug;
+    frame.title = 'Paste ' + slug;
+    frame.height = height;
+    frame.loading = 'lazy';
+    frame.setAttribute('sandbox', 'allow-popups allow-popups-to-escape-sandbox');
+    frame.style.cssText = 'width: 100%; border: 1px solid #313244; border-radius: 6px;';
+    script.replaceWith(frame);
+})();
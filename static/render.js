@@ -0,0 +1,291 @@
+// Content renderers for the paste view. A renderer turns the text of a
+// paste into a richer view (a table, a tree) and the source stays one click
+// away. Renderers are looked up by content type; to add one, call
+// nclipRenderers.register from this file or from a script loaded after it,
+// for example one added to the view template in NCLIP_OVERRIDE_DIR.
+(function () {
+    'use strict';
+
+    const renderers = {};
+
+    // register makes renderer handle pastes of the given content types,
+    // without parameters (e.g. "text/csv"). A renderer has a label for the
+    // toggle button and render(text, container, contentType), which fills
+    // container or throws to fall back to the source.
+    function register(types, renderer) {
+        for (const type of types) {
+            renderers[type.toLowerCase()] = renderer;
+        }
+    }
+
+    // lookup returns the renderer for contentType, or null.
+    function lookup(contentType) {
+        const base = (contentType || '').split(';')[0].trim().toLowerCase();
+        return renderers[base] || null;
+    }
+
+    window.nclipRenderers = { register: register, lookup: lookup };
+
+    function el(tag, className, text) {
+        const node = document.createElement(tag);
+        if (className) node.className = className;
+        if (text !== undefined) node.textContent = text;
+        return node;
+    }
+
+    // --- CSV and TSV: sortable table ---
+
+    // Tables stop after this many rows; the source has the rest.
+    const MAX_TABLE_ROWS = 5000;
+
+    // parseDelimited splits text into rows of fields, following RFC 4180
+    // quoting.
+    function parseDelimited(text, delimiter) {
+        const rows = [];
+        let row = [];
+        let field = '';
+        let quoted = false;
+        for (let i = 0; i < text.length; i++) {
+            const ch = text[i];
+            if (quoted) {
+                if (ch === '"' && text[i + 1] === '"') {
+                    field += '"';
+                    i++;
+                } else if (ch === '"') {
+                    quoted = false;
+                } else {
+                    field += ch;
+                }
+            } else if (ch === '"' && field === '') {
+                quoted = true;
+            } else if (ch === delimiter) {
+                row.push(field);
+                field = '';
+            } else if (ch === '\n' || ch === '\r') {
+                if (ch === '\r' && text[i + 1] === '\n') i++;
+                row.push(field);
+                rows.push(row);
+                row = [];
+                field = '';
+            } else {
+                field += ch;
+            }
+        }
+        if (field !== '' || row.length > 0) {
+            row.push(field);
+            rows.push(row);
+        }
+        return rows;
+    }
+
+    // compareCells orders numbers numerically and everything else as text.
+    function compareCells(a, b) {
+        const x = Number(a);
+        const y = Number(b);
+        if (a.trim() !== '' && b.trim() !== '' && !isNaN(x) && !isNaN(y)) {
+            return x - y;
+        }
+        return a.localeCompare(b, undefined, { numeric: true, sensitivity: 'base' });
+    }
+
+    function renderTable(text, container, delimiter) {
+        const rows = parseDelimited(text, delimiter).filter(function (r) {
+            return r.length > 1 || r[0] !== '';
+        });
+        if (rows.length === 0) throw new Error('no rows');
+        const header = rows[0];
+        let body = rows.slice(1);
+        if (body.length > MAX_TABLE_ROWS) {
+            container.appendChild(el('p', 'render-note',
+                'Showing the first ' + MAX_TABLE_ROWS + ' of ' + body.length + ' rows.'));
+            body = body.slice(0, MAX_TABLE_ROWS);
+        }
+
+        const table = el('table', 'render-table');
+        const thead = el('thead');
+        const headRow = el('tr');
+        const tbody = el('tbody');
+        let sortColumn = -1;
+        let ascending = true;
+
+        function fill() {
+            tbody.textContent = '';
+            for (const cells of body) {
+                const tr = el('tr');
+                for (let i = 0; i < header.length; i++) {
+                    tr.appendChild(el('td', null, cells[i] === undefined ? '' : cells[i]));
+                }
+                tbody.appendChild(tr);
+            }
+        }
+
+        header.forEach(function (name, column) {
+            const th = el('th', null, name);
+            th.title = 'Sort by ' + name;
+            th.addEventListener('click', function () {
+                ascending = sortColumn === column ? !ascending : true;
+                sortColumn = column;
+                body.sort(function (a, b) {
+                    const order = compareCells(a[column] || '', b[column] || '');
+                    return ascending ? order : -order;
+                });
+                for (const cell of headRow.children) cell.removeAttribute('aria-sort');
+                th.setAttribute('aria-sort', ascending ? 'ascending' : 'descending');
+                fill();
+            });
+            headRow.appendChild(th);
+        });
+        thead.appendChild(headRow);
+        table.appendChild(thead);
+        table.appendChild(tbody);
+        fill();
+
+        const wrapper = el('div', 'render-table-wrapper');
+        wrapper.appendChild(table);
+        container.appendChild(wrapper);
+    }
+
+    register(['text/csv'], {
+        label: 'Table',
+        render: function (text, container) { renderTable(text, container, ','); },
+    });
+    register(['text/tab-separated-values'], {
+        label: 'Table',
+        render: function (text, container) { renderTable(text, container, '\t'); },
+    });
+
+    // --- JSON: collapsible tree ---
+
+    // Levels below this start collapsed.
+    const OPEN_DEPTH = 2;
+
+    function jsonValue(value) {
+        const type = value === null ? 'null' : typeof value;
+        return el('span', 'json-' + type, JSON.stringify(value));
+    }
+
+    function jsonNode(key, value, depth) {
+        const label = key === null ? null : el('span', 'json-key', key + ': ');
+        if (value === null || typeof value !== 'object') {
+            const leaf = el('div', 'json-leaf');
+            if (label) leaf.appendChild(label);
+            leaf.appendChild(jsonValue(value));
+            return leaf;
+        }
+        const isArray = Array.isArray(value);
+        const entries = isArray ? value.map(function (v, i) { return [i, v]; }) : Object.entries(value);
+        const details = el('details', 'json-node');
+        details.open = depth < OPEN_DEPTH;
+        const summary = el('summary');
+        if (label) summary.appendChild(label);
+        summary.appendChild(el('span', 'json-count', isArray
+            ? '[' + entries.length + (entries.length === 1 ? ' item]' : ' items]')
+            : '{' + entries.length + (entries.length === 1 ? ' key}' : ' keys}')));
+        details.appendChild(summary);
+        for (const [k, v] of entries) {
+            details.appendChild(jsonNode(k, v, depth + 1));
+        }
+        return details;
+    }
+
+    function renderJSON(text, container) {
+        const tree = el('div', 'json-tree');
+        tree.appendChild(jsonNode(null, JSON.parse(text), 0));
+        container.appendChild(tree);
+    }
+
+    register(['application/json'], { label: 'Tree', render: renderJSON });
+
+    // --- GeoJSON: map placeholder and tree ---
+
+    // geoSummary counts the features and finds the bounding box of a
+    // GeoJSON object.
+    function geoSummary(geo) {
+        const summary = { features: 0, bbox: null };
+        function visit(coords) {
+            if (typeof coords[0] === 'number') {
+                const [x, y] = coords;
+                const b = summary.bbox || [x, y, x, y];
+                summary.bbox = [Math.min(b[0], x), Math.min(b[1], y), Math.max(b[2], x), Math.max(b[3], y)];
+                return;
+            }
+            for (const c of coords) visit(c);
+        }
+        function walk(obj) {
+            if (!obj || typeof obj !== 'object') return;
+            if (obj.type === 'FeatureCollection') {
+                for (const f of obj.features || []) walk(f);
+            } else if (obj.type === 'Feature') {
+                summary.features++;
+                walk(obj.geometry);
+            } else if (obj.type === 'GeometryCollection') {
+                for (const g of obj.geometries || []) walk(g);
+            } else if (Array.isArray(obj.coordinates)) {
+                visit(obj.coordinates);
+            }
+        }
+        walk(geo);
+        return summary;
+    }
+
+    register(['application/geo+json'], {
+        label: 'Map',
+        render: function (text, container) {
+            const geo = JSON.parse(text);
+            const s = geoSummary(geo);
+            const box = el('div', 'map-placeholder');
+            box.appendChild(el('strong', null, 'GeoJSON ' + (geo.type || 'object')));
+            box.appendChild(el('p', null, s.features + (s.features === 1 ? ' feature' : ' features') +
+                (s.bbox ? ', bounds ' + s.bbox.map(function (n) { return n.toFixed(5); }).join(', ') : '')));
+            box.appendChild(el('p', 'render-note', 'Map view is not available; download the file to open it in a GIS tool.'));
+            container.appendChild(box);
+            renderJSON(text, container);
+        },
+    });
+
+    // --- Paste view wiring ---
+
+    function init() {
+        const display = document.querySelector('.content-display[data-content-type]');
+        const source = display && display.querySelector('pre');
+        if (!source) return;
+        const contentType = display.getAttribute('data-content-type');
+        const renderer = lookup(contentType);
+        if (!renderer) return;
+
+        const rendered = el('div', 'rendered-content');
+        try {
+            renderer.render(source.textContent, rendered, contentType);
+        } catch (err) {
+            // Truncated previews and malformed content land here.
+            display.insertBefore(el('p', 'render-note',
+                'Could not render this paste (' + err.message + '); showing the source.'), source);
+            return;
+        }
+
+        const toolbar = el('div', 'render-toolbar');
+        const renderedBtn = el('button', 'btn btn-secondary', renderer.label);
+        const sourceBtn = el('button', 'btn btn-secondary', 'View source');
+        function show(showRendered) {
+            rendered.hidden = !showRendered;
+            source.hidden = showRendered;
+            renderedBtn.classList.toggle('active', showRendered);
+            sourceBtn.classList.toggle('active', !showRendered);
+        }
+        renderedBtn.addEventListener('click', function () { show(true); });
+        sourceBtn.addEventListener('click', function () { show(false); });
+        toolbar.appendChild(renderedBtn);
+        toolbar.appendChild(sourceBtn);
+        display.insertBefore(toolbar, source);
+        display.insertBefore(rendered, source);
+        show(true);
+    }
+
+    // Renderers registered by scripts loaded after this one are in place
+    // by the time the document is parsed.
+    if (document.readyState === 'loading') {
+        document.addEventListener('DOMContentLoaded', init);
+    } else {
+        init();
+    }
+})();
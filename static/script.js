@@ -13,6 +13,7 @@ document.addEventListener('DOMContentLoaded', function () {
     const copyUrlBtn = document.getElementById('copy-url');
     const viewPasteLink = document.getElementById('view-paste');
     const rawPasteLink = document.getElementById('raw-paste');
+    const managePasteLink = document.getElementById('manage-paste');
     const newPasteBtn = document.getElementById('new-paste');
     const deletePasteBtn = document.getElementById('delete-paste');
     let currentSlug = null;
@@ -42,6 +43,73 @@ document.addEventListener('DOMContentLoaded', function () {
         return apiKeyInput ? apiKeyInput.value.trim() : '';
     }
 
+    // Path prefix the server is mounted under (NCLIP_ROUTE_PREFIX), empty
+    // when it serves from the root.
+    const routePrefixMeta = document.querySelector('meta[name="route-prefix"]');
+    const routePrefix = routePrefixMeta ? routePrefixMeta.content : '';
+
+    // CSRF token for the session cookie, embedded in the upload form by the server.
+    const csrfInput = document.getElementById('csrf-token');
+    function getCsrfToken() {
+        return csrfInput ? csrfInput.value : '';
+    }
+
+    // Optional custom slug (X-Slug), checked for availability as it is typed.
+    const customSlugInput = document.getElementById('custom-slug');
+    const slugStatus = document.getElementById('slug-status');
+    const SLUG_PATTERN = /^[ABCDEFGHJKLMNPQRSTUVWXYZ23456789]{3,32}$/;
+    let slugCheckTimer = null;
+    let slugCheckSeq = 0;
+
+    function getCustomSlug() {
+        return customSlugInput ? customSlugInput.value.trim() : '';
+    }
+
+    function setSlugStatus(text, state) {
+        if (!slugStatus) return;
+        slugStatus.textContent = text;
+        slugStatus.className = 'slug-status' + (state ? ' slug-' + state : '');
+    }
+
+    // checkSlug asks GET /api/v1/exists/{slug}, which answers 204 when the
+    // slug is taken and 404 when it is free, without touching the paste.
+    async function checkSlug(slug) {
+        const seq = ++slugCheckSeq;
+        const headers = {};
+        const key = getApiKey();
+        if (key) headers['Authorization'] = 'Bearer ' + key;
+        try {
+            const response = await fetch(routePrefix + '/api/v1/exists/' + encodeURIComponent(slug), {
+                method: 'HEAD',
+                headers: headers,
+            });
+            if (seq !== slugCheckSeq) return; // a newer check is under way
+            if (response.status === 204) setSlugStatus('Taken', 'taken');
+            else if (response.status === 404) setSlugStatus('Available', 'free');
+            else setSlugStatus('');
+        } catch (e) {
+            if (seq === slugCheckSeq) setSlugStatus('');
+        }
+    }
+
+    if (customSlugInput) {
+        customSlugInput.addEventListener('input', function () {
+            const upper = customSlugInput.value.toUpperCase();
+            if (upper !== customSlugInput.value) customSlugInput.value = upper;
+            clearTimeout(slugCheckTimer);
+            slugCheckSeq++;
+            const slug = getCustomSlug();
+            if (!slug) {
+                setSlugStatus('');
+            } else if (!SLUG_PATTERN.test(slug)) {
+                setSlugStatus('3–32 characters: A–Z without I and O, digits 2–9', 'invalid');
+            } else {
+                setSlugStatus('Checking…');
+                slugCheckTimer = setTimeout(function () { checkSlug(slug); }, 300);
+            }
+        });
+    }
+
     // Text upload
     uploadTextBtn.addEventListener('click', function () {
         const content = textContent.value.trim();
@@ -51,7 +119,7 @@ document.addEventListener('DOMContentLoaded', function () {
         }
 
         const isBurn = burnTextCheckbox.checked;
-        const endpoint = isBurn ? '/burn/' : '/';
+        const endpoint = routePrefix + (isBurn ? '/burn/' : '/');
 
         uploadTextBtn.disabled = true;
         uploadTextBtn.textContent = 'Uploading...';
@@ -62,12 +130,20 @@ document.addEventListener('DOMContentLoaded', function () {
         };
         const key = getApiKey();
         if (key) headers['Authorization'] = 'Bearer ' + key;
-
-        fetch(endpoint, {
-            method: 'POST',
-            headers: headers,
-            body: content
-        })
+        const csrf = getCsrfToken();
+        if (csrf) headers['X-CSRF-Token'] = csrf;
+        const slug = getCustomSlug();
+        if (slug) headers['X-Slug'] = slug;
+
+        solveChallenge()
+            .then(pow => {
+                if (pow) headers['X-PoW'] = pow;
+                return fetch(endpoint, {
+                    method: 'POST',
+                    headers: headers,
+                    body: content
+                });
+            })
             .then(async response => {
                 if (!response.ok) {
                     const msg = await extractErrorMessage(response);
@@ -75,7 +151,7 @@ document.addEventListener('DOMContentLoaded', function () {
                 }
                 const data = await response.json();
                 if (data.error) throw new Error(data.error);
-                showResult(data.url, data.slug);
+                showResult(data.url, data.slug, data.manage_url, data.burn_url);
             })
             .catch(error => {
                 alert('Upload failed: ' + error.message);
@@ -123,57 +199,259 @@ document.addEventListener('DOMContentLoaded', function () {
         }
     }
 
-    // File upload
+    // Server limits from /api/v1/config (loaded once; uploads still work if it fails)
+    let serverConfig = null;
+    const serverConfigLoaded = fetch(routePrefix + '/api/v1/config', { headers: { 'Accept': 'application/json' } })
+        .then(response => response.ok ? response.json() : null)
+        .then(data => { serverConfig = data; })
+        .catch(() => { /* optional */ });
+
+    // Count the leading zero bits of a digest
+    function leadingZeroBits(bytes) {
+        let n = 0;
+        for (const b of bytes) {
+            if (b !== 0) return n + Math.clz32(b) - 24;
+            n += 8;
+        }
+        return n;
+    }
+
+    // Proof of work for uploads without an API key, when the server requires
+    // it: fetch a challenge and find a counter whose SHA-256 hash has enough
+    // leading zero bits. Resolves to the X-PoW header value, or '' if none
+    // is needed.
+    async function solveChallenge() {
+        await serverConfigLoaded;
+        if (!serverConfig || !serverConfig.pow_difficulty || getApiKey()) return '';
+        if (!window.crypto || !window.crypto.subtle) {
+            throw new Error('this server requires a proof of work, which needs HTTPS in the browser');
+        }
+        const response = await fetch(routePrefix + '/api/v1/challenge', { headers: { 'Accept': 'application/json' } });
+        if (!response.ok) throw new Error(await extractErrorMessage(response));
+        const challenge = await response.json();
+        const encoder = new TextEncoder();
+        for (let i = 0; ; i++) {
+            const solution = challenge.nonce + ':' + i;
+            const digest = await window.crypto.subtle.digest('SHA-256', encoder.encode(solution));
+            if (leadingZeroBits(new Uint8Array(digest)) >= challenge.difficulty) return solution;
+        }
+    }
+
+    const uploadProgress = document.getElementById('upload-progress');
+
+    // Format a byte count for display
+    function formatBytes(n) {
+        if (n < 1024) return n + ' B';
+        if (n < 1024 * 1024) return (n / 1024).toFixed(1) + ' KiB';
+        return (n / (1024 * 1024)).toFixed(1) + ' MiB';
+    }
+
+    // Update the upload progress bar with percentage and estimated time left
+    function showUploadProgress(loaded, total, startedAt) {
+        if (!uploadProgress) return;
+        uploadProgress.style.display = 'block';
+        const pct = total > 0 ? Math.floor((loaded / total) * 100) : 0;
+        const elapsed = (Date.now() - startedAt) / 1000;
+        let eta = '';
+        if (loaded > 0 && elapsed > 0.5 && loaded < total) {
+            const rate = loaded / elapsed;
+            eta = ' — about ' + Math.ceil((total - loaded) / rate) + 's left';
+        }
+        uploadProgress.querySelector('.progress-bar').style.width = pct + '%';
+        uploadProgress.querySelector('.progress-text').textContent =
+            pct + '% (' + formatBytes(loaded) + ' of ' + formatBytes(total) + ')' + eta;
+    }
+
+    function hideUploadProgress() {
+        if (uploadProgress) uploadProgress.style.display = 'none';
+    }
+
+    // isTextFile guesses from the browser's MIME type whether the server
+    // will treat file as text.
+    function isTextFile(file) {
+        const type = (file.type || '').toLowerCase();
+        return type.startsWith('text/') || ['application/json', 'application/xml', 'application/javascript', 'application/x-sh', 'application/x-yaml'].some(t => type.startsWith(t));
+    }
+
+    // Files at least this large go straight to storage when the server
+    // offers direct uploads (presign_max_size).
+    const DIRECT_UPLOAD_MIN = 1024 * 1024;
+
+    // Upload file directly to the server's storage through a presigned URL,
+    // then have the server finalize the paste. The content never passes
+    // through the server, which matters most in Lambda mode.
+    async function uploadDirect(file, isBurn, allowBinary) {
+        const headers = { 'Content-Type': 'application/json', 'Accept': 'application/json' };
+        const key = getApiKey();
+        if (key) headers['Authorization'] = 'Bearer ' + key;
+        const csrf = getCsrfToken();
+        if (csrf) headers['X-CSRF-Token'] = csrf;
+        const pow = await solveChallenge();
+        if (pow) headers['X-PoW'] = pow;
+        let response = await fetch(routePrefix + '/api/v1/presign-upload', {
+            method: 'POST',
+            headers: headers,
+            body: JSON.stringify({
+                size: file.size,
+                content_type: file.type,
+                filename: file.name,
+                burn_after_read: isBurn,
+                allow_binary: allowBinary,
+            }),
+        });
+        if (!response.ok) throw new Error(await extractErrorMessage(response));
+        const presign = await response.json();
+
+        await new Promise((resolve, reject) => {
+            const xhr = new XMLHttpRequest();
+            const startedAt = Date.now();
+            xhr.open(presign.method, presign.upload_url);
+            for (const [name, value] of Object.entries(presign.headers || {})) {
+                xhr.setRequestHeader(name, value);
+            }
+            xhr.upload.addEventListener('progress', function (e) {
+                if (e.lengthComputable) showUploadProgress(e.loaded, e.total, startedAt);
+            });
+            xhr.addEventListener('load', function () {
+                if (xhr.status >= 200 && xhr.status < 300) resolve();
+                else reject(new Error('storage rejected the upload (HTTP ' + xhr.status + ')'));
+            });
+            xhr.addEventListener('error', function () {
+                reject(new Error('network error while uploading to storage'));
+            });
+            xhr.send(file);
+        });
+
+        delete headers['X-PoW'];
+        response = await fetch(routePrefix + '/api/v1/finalize/' + encodeURIComponent(presign.slug), {
+            method: 'POST',
+            headers: headers,
+            body: JSON.stringify({ token: presign.token }),
+        });
+        if (!response.ok) throw new Error(await extractErrorMessage(response));
+        const data = await response.json();
+        showResult(data.url, data.slug, data.manage_url, data.burn_url);
+    }
+
+    // File upload. Uses XMLHttpRequest because fetch() cannot report upload progress.
     uploadFileBtn.addEventListener('click', function () {
         const file = fileInput.files[0];
         if (!file) {
             alert('Please select a file to upload.');
             return;
         }
+        // Direct uploads reserve a random slug, so a custom one goes
+        // through the server.
+        const direct = !getCustomSlug() && serverConfig && serverConfig.presign_max_size > 0 &&
+            file.size >= DIRECT_UPLOAD_MIN && file.size <= serverConfig.presign_max_size;
+        if (!direct && serverConfig && serverConfig.buffer_size && file.size > serverConfig.buffer_size) {
+            alert('File is too large: ' + formatBytes(file.size) + ' exceeds the limit of ' + formatBytes(serverConfig.buffer_size) + '.');
+            return;
+        }
+
+        // Large binaries need explicit confirmation (X-Allow-Binary), so a
+        // wrong file picked by accident is not uploaded.
+        let allowBinary = false;
+        if (serverConfig && serverConfig.binary_confirm_size && file.size > serverConfig.binary_confirm_size && !isTextFile(file)) {
+            if (!confirm(file.name + ' (' + formatBytes(file.size) + ') looks like a binary file. Upload it anyway?')) {
+                return;
+            }
+            allowBinary = true;
+        }
 
         const isBurn = burnFileCheckbox.checked;
-        const endpoint = isBurn ? '/burn/' : '/';
+        if (direct) {
+            uploadFileBtn.disabled = true;
+            uploadFileBtn.textContent = 'Uploading...';
+            uploadDirect(file, isBurn, allowBinary)
+                .catch(error => alert('Upload failed: ' + error.message))
+                .finally(() => {
+                    hideUploadProgress();
+                    uploadFileBtn.disabled = false;
+                    uploadFileBtn.textContent = 'Upload File';
+                });
+            return;
+        }
+        const endpoint = routePrefix + (isBurn ? '/burn/' : '/');
         const formData = new FormData();
         formData.append('file', file);
 
         uploadFileBtn.disabled = true;
         uploadFileBtn.textContent = 'Uploading...';
 
-        const headers = { 'Accept': 'application/json' };
+        const xhr = new XMLHttpRequest();
+        let startedAt = Date.now();
+        xhr.open('POST', endpoint);
+        xhr.setRequestHeader('Accept', 'application/json');
         const key = getApiKey();
-        if (key) headers['Authorization'] = 'Bearer ' + key;
+        if (key) xhr.setRequestHeader('Authorization', 'Bearer ' + key);
+        const csrf = getCsrfToken();
+        if (csrf) xhr.setRequestHeader('X-CSRF-Token', csrf);
+        if (allowBinary) xhr.setRequestHeader('X-Allow-Binary', 'true');
+        const customSlug = getCustomSlug();
+        if (customSlug) xhr.setRequestHeader('X-Slug', customSlug);
+
+        xhr.upload.addEventListener('progress', function (e) {
+            if (e.lengthComputable) showUploadProgress(e.loaded, e.total, startedAt);
+        });
 
-        fetch(endpoint, {
-            method: 'POST',
-            body: formData,
-            headers: headers
-        })
-            .then(async response => {
-                if (!response.ok) {
-                    const msg = await extractErrorMessage(response);
-                    throw new Error(msg);
-                }
-                const data = await response.json();
+        function done() {
+            hideUploadProgress();
+            uploadFileBtn.disabled = false;
+            uploadFileBtn.textContent = 'Upload File';
+        }
+
+        xhr.addEventListener('load', async function () {
+            done();
+            const response = new Response(xhr.responseText, {
+                status: xhr.status,
+                headers: { 'content-type': xhr.getResponseHeader('content-type') || '' },
+            });
+            if (xhr.status < 200 || xhr.status >= 300) {
+                alert('Upload failed: ' + await extractErrorMessage(response));
+                return;
+            }
+            try {
+                const data = JSON.parse(xhr.responseText);
                 if (data.error) throw new Error(data.error);
-                showResult(data.url, data.slug);
+                showResult(data.url, data.slug, data.manage_url, data.burn_url);
+            } catch (error) {
+                alert('Upload failed: ' + error.message);
+            }
+        });
+        xhr.addEventListener('error', function () {
+            done();
+            alert('Upload failed: network error');
+        });
+
+        solveChallenge()
+            .then(pow => {
+                if (pow) xhr.setRequestHeader('X-PoW', pow);
+                startedAt = Date.now();
+                xhr.send(formData);
             })
             .catch(error => {
+                done();
                 alert('Upload failed: ' + error.message);
-            })
-            .finally(() => {
-                uploadFileBtn.disabled = false;
-                uploadFileBtn.textContent = 'Upload File';
             });
     });
 
     // Show result
-    function showResult(url, slug) {
+    function showResult(url, slug, manageUrl, burnUrl) {
         currentSlug = slug;
-        pasteUrlInput.value = url;
+        // Burn-after-read pastes are shared through their /b/ link, which
+        // link previews cannot burn; the usage examples keep the direct URL.
+        pasteUrlInput.value = burnUrl || url;
         if (viewPasteLink) {
-            viewPasteLink.href = '/' + slug;
+            viewPasteLink.href = routePrefix + '/' + slug;
+        }
+        rawPasteLink.href = routePrefix + '/raw/' + slug;
+        // The manage link lets the uploader extend or delete the paste later
+        // without an API key; it is only shown once.
+        if (managePasteLink) {
+            managePasteLink.href = manageUrl || '#';
+            managePasteLink.style.display = manageUrl ? '' : 'none';
         }
-        rawPasteLink.href = '/raw/' + slug;
 
         // Hide the upload section
         const uploadSection = document.querySelector('.upload-section');
@@ -223,7 +501,7 @@ document.addEventListener('DOMContentLoaded', function () {
     newPasteBtn.addEventListener('click', function (event) {
         // Always reload the main page to ensure a pristine, server-rendered state
         event.preventDefault();
-        window.location.assign('/');
+        window.location.assign(routePrefix + '/');
     });
 
     // Delete Paste button functionality
@@ -237,14 +515,16 @@ document.addEventListener('DOMContentLoaded', function () {
             const headers = {};
             const key = getApiKey();
             if (key) headers['Authorization'] = 'Bearer ' + key;
+            const csrf = getCsrfToken();
+            if (csrf) headers['X-CSRF-Token'] = csrf;
 
-            fetch('/' + currentSlug, { method: 'DELETE', headers: headers })
+            fetch(routePrefix + '/' + currentSlug, { method: 'DELETE', headers: headers })
                 .then(async response => {
                     if (!response.ok) {
                         const msg = await extractErrorMessage(response);
                         throw new Error(msg);
                     }
-                    window.location.assign('/');
+                    window.location.assign(routePrefix + '/');
                 })
                 .catch(error => {
                     alert('Delete failed: ' + error.message);
@@ -0,0 +1,23 @@
+// Service worker for Web Push notifications subscribed on the manage
+// page. Each message is JSON with a title, body and optional URL opened
+// when the notification is clicked.
+self.addEventListener('push', function (event) {
+    let data = {};
+    try {
+        data = event.data ? event.data.json() : {};
+    } catch (e) {
+        data = { body: event.data.text() };
+    }
+    event.waitUntil(self.registration.showNotification(data.title || 'nclip', {
+        body: data.body || '',
+        data: { url: data.url || '' }
+    }));
+});
+
+self.addEventListener('notificationclick', function (event) {
+    event.notification.close();
+    const url = event.notification.data && event.notification.data.url;
+    if (url) {
+        event.waitUntil(self.clients.openWindow(url));
+    }
+});
@@ -0,0 +1,74 @@
+package storage
+
+// AtRest describes how the backend keeps the content of one paste.
+// Compression and deduplication are reported for clients that switch on
+// them; no backend uses either yet.
+type AtRest struct {
+	Compressed   bool `json:"compressed"`
+	Encrypted    bool `json:"encrypted"`
+	Deduplicated bool `json:"deduplicated"`
+	// Chunked content is split over several backend objects, e.g. MongoDB
+	// GridFS chunks.
+	Chunked bool `json:"chunked"`
+	// KeyID names the encryption key of encrypted content.
+	KeyID string `json:"key_id,omitempty"`
+}
+
+// AtRestReporter is implemented by stores that can tell how they keep a
+// paste's content.
+type AtRestReporter interface {
+	AtRest(id string) (AtRest, error)
+}
+
+// ContentAtRest reports how the content stored under id is kept, asking
+// the first store in the decorator chain that implements AtRestReporter.
+// Stores that keep content as a single plain object report the zero value.
+func ContentAtRest(store PasteStore, id string) (AtRest, error) {
+	for store != nil {
+		if r, ok := store.(AtRestReporter); ok {
+			return r.AtRest(id)
+		}
+		d, ok := store.(interface{ Backend() PasteStore })
+		if !ok {
+			break
+		}
+		store = d.Backend()
+	}
+	return AtRest{}, nil
+}
+
+// Capabilities lists the features a store offers, for clients deciding
+// what to use without probing.
+type Capabilities struct {
+	// Encryption, Compression, Deduplication and Chunking are the at-rest
+	// features new content may get; see AtRest.
+	Encryption    bool `json:"encryption"`
+	Compression   bool `json:"compression"`
+	Deduplication bool `json:"deduplication"`
+	Chunking      bool `json:"chunking"`
+	// Listing, Collections and ShareTokens report the optional APIs the
+	// backend supports.
+	Listing     bool `json:"listing"`
+	Collections bool `json:"collections"`
+	ShareTokens bool `json:"share_tokens"`
+}
+
+// StoreCapabilities reports the capabilities of store and the decorators
+// wrapping it. Decorators forward the optional interfaces whether or not
+// their backend has them, so those are checked on the innermost store.
+func StoreCapabilities(store PasteStore) Capabilities {
+	var caps Capabilities
+	_, caps.Encryption = Find[*EncryptedStore](store)
+	for {
+		d, ok := store.(interface{ Backend() PasteStore })
+		if !ok {
+			break
+		}
+		store = d.Backend()
+	}
+	_, caps.Chunking = store.(*MongoStore)
+	_, caps.Listing = store.(Lister)
+	_, caps.Collections = store.(CollectionStore)
+	_, caps.ShareTokens = store.(TokenStore)
+	return caps
+}
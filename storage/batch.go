@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"log"
+
+	"github.com/johnwmail/nclip/models"
+)
+
+// BatchResult holds the outcome of fetching a single paste in a batch.
+// Exactly one of Paste or Err is set.
+type BatchResult struct {
+	Paste *models.Paste
+	Err   error
+}
+
+// BatchGetter is implemented by stores that can fetch metadata for many
+// pastes more efficiently than repeated Get calls.
+type BatchGetter interface {
+	// GetBatch returns a result for every requested id. Missing pastes
+	// must be reported with an error matching ErrNotFound.
+	GetBatch(ids []string) map[string]BatchResult
+}
+
+// GetBatch fetches metadata for ids using the store's BatchGetter
+// implementation when available, falling back to sequential Get calls.
+func GetBatch(store PasteStore, ids []string) map[string]BatchResult {
+	if bg, ok := store.(BatchGetter); ok {
+		return bg.GetBatch(ids)
+	}
+	results := make(map[string]BatchResult, len(ids))
+	for _, id := range ids {
+		results[id] = batchResult(store.Get(id))
+	}
+	return results
+}
+
+// batchResult normalizes a Get return pair into a BatchResult, mapping a
+// nil paste to ErrNotFound.
+func batchResult(paste *models.Paste, err error) BatchResult {
+	if err != nil {
+		return BatchResult{Err: err}
+	}
+	if paste == nil {
+		return BatchResult{Err: ErrNotFound}
+	}
+	return BatchResult{Paste: paste}
+}
+
+// DeleteProgress is called while a batch delete runs with the number of
+// ids processed so far, whether or not they could be deleted, and the
+// number of ids in the batch.
+type DeleteProgress func(done, total int)
+
+// BatchDeleter is implemented by stores that can delete many pastes more
+// efficiently than repeated Delete calls.
+type BatchDeleter interface {
+	// DeleteBatch deletes every paste in ids as Delete would, calling
+	// progress (when not nil) as ids are processed. It returns the errors
+	// of the ids it could not delete; the others are gone.
+	DeleteBatch(ids []string, progress DeleteProgress) map[string]error
+}
+
+// DeleteBatch deletes ids using the store's BatchDeleter implementation
+// when available, falling back to sequential Delete calls.
+func DeleteBatch(store PasteStore, ids []string, progress DeleteProgress) map[string]error {
+	if bd, ok := store.(BatchDeleter); ok {
+		return bd.DeleteBatch(ids, progress)
+	}
+	errs := map[string]error{}
+	for i, id := range ids {
+		if err := store.Delete(id); err != nil {
+			errs[id] = err
+		}
+		if progress != nil {
+			progress(i+1, len(ids))
+		}
+	}
+	return errs
+}
+
+// LogDeleteProgress returns a DeleteProgress that logs how far the batch
+// delete named what has come at every tenth of a batch of at least 100
+// ids; smaller batches finish too fast to be worth it.
+func LogDeleteProgress(what string) DeleteProgress {
+	last := 0
+	return func(done, total int) {
+		if total < 100 {
+			return
+		}
+		if step := done * 10 / total; step > last {
+			last = step
+			log.Printf("[INFO] %s: deleted %d of %d", what, done, total)
+		}
+	}
+}
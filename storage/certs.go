@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"errors"
+	"regexp"
+)
+
+// certDir is the directory (filesystem) or key prefix (S3) holding TLS
+// certificates and ACME account keys. The leading dot keeps it out of the
+// slug namespace and the orphan sweep.
+const certDir = ".certs"
+
+// errInvalidCertName is returned when a certificate name is malformed.
+var errInvalidCertName = errors.New("invalid certificate name")
+
+// certNamePattern matches the names certificates are stored under.
+var certNamePattern = regexp.MustCompile(`^[a-z0-9_-][a-z0-9._-]{0,127}$`)
+
+// CertStore is implemented by stores that can hold TLS certificates, so
+// every instance sharing the backend serves the same certificate and only
+// one of them has to obtain it. GetCert returns ErrNotFound for unknown
+// names.
+type CertStore interface {
+	GetCert(name string) ([]byte, error)
+	PutCert(name string, data []byte) error
+	DeleteCert(name string) error
+}
+
+// validCertName reports whether name is usable as a certificate key.
+func validCertName(name string) bool {
+	return certNamePattern.MatchString(name)
+}
@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"errors"
+
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// collectionDir is the directory (filesystem) or key prefix (S3) holding
+// one "<id>.json" object per collection. The leading dot keeps it out of
+// the slug namespace and the orphan sweep.
+const collectionDir = ".collections"
+
+// errInvalidCollectionID is returned when a collection id is malformed.
+var errInvalidCollectionID = errors.New("invalid collection id")
+
+// CollectionStore is implemented by stores that can save collections.
+// Collections are small JSON documents kept next to paste metadata;
+// GetCollection returns ErrNotFound for unknown ids.
+type CollectionStore interface {
+	StoreCollection(col *models.Collection) error
+	GetCollection(id string) (*models.Collection, error)
+	DeleteCollection(id string) error
+}
+
+// validCollectionID reports whether id is usable as a collection key.
+// Collection ids use the slug alphabet.
+func validCollectionID(id string) bool {
+	return utils.IsValidSlug(id)
+}
@@ -0,0 +1,126 @@
+package storage_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/johnwmail/nclip/internal/changes"
+	"github.com/johnwmail/nclip/internal/keyring"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+	"github.com/johnwmail/nclip/storage/conformancetest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Every backend and decorator must pass the conformance suite. S3 and
+// MongoDB run against real deployments in s3_conformance_test.go and
+// mongodb_conformance_test.go (build tag integration).
+
+func newFilesystem(t *testing.T) *storage.FilesystemStore {
+	t.Helper()
+	store, err := storage.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystemStore: %v", err)
+	}
+	return store
+}
+
+func TestConformance_Filesystem(t *testing.T) {
+	conformancetest.Run(t, func(t *testing.T) storage.PasteStore {
+		return newFilesystem(t)
+	})
+}
+
+func TestConformance_FilesystemFsync(t *testing.T) {
+	conformancetest.Run(t, func(t *testing.T) storage.PasteStore {
+		store := newFilesystem(t)
+		store.SetFsync(true)
+		return store
+	})
+}
+
+func TestConformance_Memory(t *testing.T) {
+	conformancetest.Run(t, func(t *testing.T) storage.PasteStore {
+		return storage.NewMemoryStore()
+	})
+}
+
+func TestConformance_Encrypted(t *testing.T) {
+	keys, err := keyring.Parse("k1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("k"), keyring.KeySize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conformancetest.Run(t, func(t *testing.T) storage.PasteStore {
+		return storage.NewEncryptedStore(newFilesystem(t), keys)
+	})
+}
+
+func TestConformance_Spool(t *testing.T) {
+	conformancetest.Run(t, func(t *testing.T) storage.PasteStore {
+		store, err := storage.NewSpoolStore(newFilesystem(t), filepath.Join(t.TempDir(), "spool"), 1<<20)
+		if err != nil {
+			t.Fatalf("NewSpoolStore: %v", err)
+		}
+		store.Start()
+		return store
+	})
+}
+
+func TestConformance_Instrumented(t *testing.T) {
+	conformancetest.Run(t, func(t *testing.T) storage.PasteStore {
+		m := storage.NewStoreMetrics(prometheus.NewRegistry())
+		return storage.NewInstrumentedStore(newFilesystem(t), "filesystem", m)
+	})
+}
+
+func TestConformance_Journaled(t *testing.T) {
+	conformancetest.Run(t, func(t *testing.T) storage.PasteStore {
+		journal, err := changes.Open(filepath.Join(t.TempDir(), "changes.jsonl"), changes.DefaultRetain)
+		if err != nil {
+			t.Fatalf("changes.Open: %v", err)
+		}
+		t.Cleanup(func() { _ = journal.Close() })
+		return storage.NewJournaledStore(newFilesystem(t), journal)
+	})
+}
+
+func TestConformance_Tenant(t *testing.T) {
+	conformancetest.Run(t, func(t *testing.T) storage.PasteStore {
+		return storage.NewTenantStore(newFilesystem(t))
+	})
+}
+
+// tenantAssigning assigns the slug of everything it stores to a tenant
+// first, so the suite runs against keys under the tenant's prefix.
+type tenantAssigning struct {
+	*storage.TenantStore
+}
+
+func (s tenantAssigning) assign(id string) {
+	slug, _, _ := strings.Cut(id, ".")
+	_ = s.AssignTenant(slug, "eng")
+}
+
+func (s tenantAssigning) Store(paste *models.Paste) error {
+	s.assign(paste.ID)
+	return s.TenantStore.Store(paste)
+}
+
+func (s tenantAssigning) StoreContent(id string, content []byte) error {
+	s.assign(id)
+	return s.TenantStore.StoreContent(id, content)
+}
+
+func (s tenantAssigning) CreateContent(id string, content []byte) (bool, error) {
+	s.assign(id)
+	return s.TenantStore.CreateContent(id, content)
+}
+
+func TestConformance_TenantPrefixed(t *testing.T) {
+	conformancetest.Run(t, func(t *testing.T) storage.PasteStore {
+		return tenantAssigning{storage.NewTenantStore(newFilesystem(t))}
+	})
+}
@@ -0,0 +1,695 @@
+// Package conformancetest checks that a storage.PasteStore behaves the way
+// the handlers and services rely on, so every backend and decorator agrees
+// on not-found errors, expiry, overwrites and the optional interfaces.
+//
+// A backend's tests call Run with a factory for fresh, empty stores:
+//
+//	func TestConformance(t *testing.T) {
+//		conformancetest.Run(t, func(t *testing.T) storage.PasteStore {
+//			store, err := storage.NewFilesystemStore(t.TempDir())
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//			return store
+//		})
+//	}
+//
+// Optional interfaces (storage.Lister, storage.BatchGetter,
+// storage.ReadCounter, storage.ObjectLister and storage.ReadOnlySetter) are
+// checked when the store implements them.
+package conformancetest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/storage"
+)
+
+// Factory returns a new, empty store. Run closes it when the subtest ends.
+type Factory func(t *testing.T) storage.PasteStore
+
+// Run runs the conformance suite against stores created by newStore, one
+// store per subtest.
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.PasteStore)
+	}{
+		{"GetMissing", testGetMissing},
+		{"StoreAndGet", testStoreAndGet},
+		{"Overwrite", testOverwrite},
+		{"Exists", testExists},
+		{"Expired", testExpired},
+		{"PinnedNeverExpires", testPinned},
+		{"Content", testContent},
+		{"ContentMissing", testContentMissing},
+		{"CreateContent", testCreateContent},
+		{"Delete", testDelete},
+		{"DeleteBatch", testDeleteBatch},
+		{"IncrementReadCount", testIncrementReadCount},
+		{"List", testList},
+		{"ListChecksum", testListChecksum},
+		{"GetBatch", testGetBatch},
+		{"ListObjects", testListObjects},
+		{"Collections", testCollections},
+		{"Tokens", testTokens},
+		{"Certs", testCerts},
+		{"ReadOnly", testReadOnly},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStore(t)
+			defer func() {
+				if err := s.Close(); err != nil {
+					t.Errorf("Close: %v", err)
+				}
+			}()
+			tt.fn(t, s)
+		})
+	}
+}
+
+// newPaste returns a paste expiring in an hour with every persisted field
+// set, so round trips catch fields a backend drops.
+func newPaste(id string) *models.Paste {
+	created := time.Now().UTC().Truncate(time.Second)
+	expires := created.Add(time.Hour)
+	return &models.Paste{
+		ID:            id,
+		CreatedAt:     created,
+		ExpiresAt:     &expires,
+		Size:          11,
+		ContentType:   "text/plain; charset=utf-8",
+		BurnAfterRead: true,
+		ReadCount:     2,
+		Views:         1,
+		RawReads:      1,
+		Tags:          []string{"deploy", "prod"},
+		Visibility:    models.VisibilityPrivate,
+		Owner:         "key:abcdef",
+	}
+}
+
+func mustStore(t *testing.T, s storage.PasteStore, p *models.Paste) {
+	t.Helper()
+	if err := s.Store(p); err != nil {
+		t.Fatalf("Store(%s): %v", p.ID, err)
+	}
+}
+
+func mustGet(t *testing.T, s storage.PasteStore, id string) *models.Paste {
+	t.Helper()
+	p, err := s.Get(id)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", id, err)
+	}
+	if p == nil {
+		t.Fatalf("Get(%s) returned a nil paste without an error", id)
+	}
+	return p
+}
+
+// assertNotFound checks the result of a Get for a paste that must be gone.
+func assertNotFound(t *testing.T, s storage.PasteStore, id string) {
+	t.Helper()
+	p, err := s.Get(id)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Get(%s): expected storage.ErrNotFound, got paste %v and error %v", id, p, err)
+	}
+	if p != nil {
+		t.Errorf("Get(%s): expected a nil paste with ErrNotFound, got %+v", id, p)
+	}
+}
+
+func testGetMissing(t *testing.T, s storage.PasteStore) {
+	assertNotFound(t, s, "MSSNG")
+}
+
+func testStoreAndGet(t *testing.T, s storage.PasteStore) {
+	want := newPaste("RNDTRP")
+	mustStore(t, s, want)
+	got := mustGet(t, s, "RNDTRP")
+
+	if !got.CreatedAt.Equal(want.CreatedAt) {
+		t.Errorf("CreatedAt = %v, want %v", got.CreatedAt, want.CreatedAt)
+	}
+	if got.ExpiresAt == nil || !got.ExpiresAt.Equal(*want.ExpiresAt) {
+		t.Errorf("ExpiresAt = %v, want %v", got.ExpiresAt, want.ExpiresAt)
+	}
+	// Pastes are stored in the current schema whatever they carry.
+	if got.SchemaVersion != models.MetadataSchema {
+		t.Errorf("SchemaVersion = %d, want %d", got.SchemaVersion, models.MetadataSchema)
+	}
+	// Times are compared above; location differences are not drift.
+	got.CreatedAt, got.ExpiresAt = want.CreatedAt, want.ExpiresAt
+	want.SchemaVersion = models.MetadataSchema
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Get returned %+v, want %+v", got, want)
+	}
+}
+
+func testOverwrite(t *testing.T, s storage.PasteStore) {
+	first := newPaste("VRWRT")
+	mustStore(t, s, first)
+	second := newPaste("VRWRT")
+	second.ContentType = "application/json"
+	second.Tags = nil
+	second.Pinned = true
+	mustStore(t, s, second)
+
+	got := mustGet(t, s, "VRWRT")
+	if got.ContentType != "application/json" || !got.Pinned || len(got.Tags) != 0 {
+		t.Errorf("expected the second Store to replace the metadata, got %+v", got)
+	}
+}
+
+func testExists(t *testing.T, s storage.PasteStore) {
+	if ok, err := s.Exists("XSTS"); err != nil || ok {
+		t.Fatalf("Exists before Store = %v, %v; want false, nil", ok, err)
+	}
+	mustStore(t, s, newPaste("XSTS"))
+	if ok, err := s.Exists("XSTS"); err != nil || !ok {
+		t.Fatalf("Exists after Store = %v, %v; want true, nil", ok, err)
+	}
+}
+
+func testExpired(t *testing.T, s storage.PasteStore) {
+	p := newPaste("XPRD")
+	past := time.Now().Add(-time.Minute)
+	p.ExpiresAt = &past
+	if err := s.StoreContent("XPRD", []byte("hello world")); err != nil {
+		t.Fatalf("StoreContent: %v", err)
+	}
+	mustStore(t, s, p)
+
+	assertNotFound(t, s, "XPRD")
+	// Get removes expired pastes, so the slug becomes free again.
+	if ok, err := s.Exists("XPRD"); err != nil || ok {
+		t.Errorf("Exists after reading an expired paste = %v, %v; want false, nil", ok, err)
+	}
+	if ok, _, err := s.StatContent("XPRD"); err != nil || ok {
+		t.Errorf("StatContent after reading an expired paste = %v, %v; want false, nil", ok, err)
+	}
+}
+
+func testPinned(t *testing.T, s storage.PasteStore) {
+	p := newPaste("PNND")
+	past := time.Now().Add(-time.Minute)
+	p.ExpiresAt = &past
+	p.Pinned = true
+	mustStore(t, s, p)
+	mustGet(t, s, "PNND")
+
+	p = newPaste("HLDD")
+	p.ExpiresAt = &past
+	p.LegalHold = true
+	mustStore(t, s, p)
+	mustGet(t, s, "HLDD")
+}
+
+func testContent(t *testing.T, s storage.PasteStore) {
+	content := []byte("hello world")
+	if err := s.StoreContent("CNTNT", content); err != nil {
+		t.Fatalf("StoreContent: %v", err)
+	}
+	got, err := s.GetContent("CNTNT")
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("GetContent = %q, %v; want %q", got, err, content)
+	}
+	if ok, size, err := s.StatContent("CNTNT"); err != nil || !ok || size != int64(len(content)) {
+		t.Errorf("StatContent = %v, %d, %v; want true, %d, nil", ok, size, err, len(content))
+	}
+	for n, want := range map[int64]string{0: "", 5: "hello", 11: "hello world", 100: "hello world"} {
+		got, err := s.GetContentPrefix("CNTNT", n)
+		if err != nil || string(got) != want {
+			t.Errorf("GetContentPrefix(%d) = %q, %v; want %q", n, got, err, want)
+		}
+	}
+	r, err := storage.OpenContent(s, "CNTNT")
+	if err != nil {
+		t.Fatalf("OpenContent: %v", err)
+	}
+	got, err = io.ReadAll(r)
+	if cerr := r.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("OpenContent read %q, %v; want %q", got, err, content)
+	}
+
+	// Content is replaced, not appended to.
+	if err := s.StoreContent("CNTNT", []byte("bye")); err != nil {
+		t.Fatalf("StoreContent: %v", err)
+	}
+	if got, err := s.GetContent("CNTNT"); err != nil || string(got) != "bye" {
+		t.Errorf("GetContent after overwrite = %q, %v; want %q", got, err, "bye")
+	}
+
+	// Empty content is valid and distinct from missing content.
+	if err := s.StoreContent("MPTY", nil); err != nil {
+		t.Fatalf("StoreContent(empty): %v", err)
+	}
+	if ok, size, err := s.StatContent("MPTY"); err != nil || !ok || size != 0 {
+		t.Errorf("StatContent(empty) = %v, %d, %v; want true, 0, nil", ok, size, err)
+	}
+}
+
+func testContentMissing(t *testing.T, s storage.PasteStore) {
+	if ok, size, err := s.StatContent("MSSNG"); err != nil || ok || size != 0 {
+		t.Errorf("StatContent(missing) = %v, %d, %v; want false, 0, nil", ok, size, err)
+	}
+	if _, err := s.GetContent("MSSNG"); err == nil {
+		t.Error("GetContent(missing): expected an error")
+	}
+	if _, err := s.GetContentPrefix("MSSNG", 10); err == nil {
+		t.Error("GetContentPrefix(missing): expected an error")
+	}
+	if r, err := storage.OpenContent(s, "MSSNG"); err == nil {
+		_ = r.Close()
+		t.Error("OpenContent(missing): expected an error")
+	}
+}
+
+func testCreateContent(t *testing.T, s storage.PasteStore) {
+	if _, ok := s.(storage.ContentCreator); !ok {
+		t.Fatal("store does not implement storage.ContentCreator")
+	}
+	if created, err := storage.CreateContent(s, "0123abcd.link", []byte("first")); err != nil || !created {
+		t.Fatalf("CreateContent = %v, %v; want true, nil", created, err)
+	}
+	if created, err := storage.CreateContent(s, "0123abcd.link", []byte("second")); err != nil || created {
+		t.Fatalf("CreateContent(existing) = %v, %v; want false, nil", created, err)
+	}
+	if got, err := s.GetContent("0123abcd.link"); err != nil || string(got) != "first" {
+		t.Errorf("GetContent = %q, %v; want the first content", got, err)
+	}
+}
+
+func testDelete(t *testing.T, s storage.PasteStore) {
+	paste := newPaste("DLT")
+	paste.Version = 2
+	paste.Versions = []models.PasteVersion{{Number: 1, Size: 4}}
+	mustStore(t, s, paste)
+	ids := []string{"DLT", "DLT" + storage.PreviewSuffix, storage.VersionID("DLT", 1)}
+	for _, id := range ids {
+		if err := s.StoreContent(id, []byte("data")); err != nil {
+			t.Fatalf("StoreContent(%s): %v", id, err)
+		}
+	}
+	if err := s.Delete("DLT"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	assertNotFound(t, s, "DLT")
+	if ok, err := s.Exists("DLT"); err != nil || ok {
+		t.Errorf("Exists after Delete = %v, %v; want false, nil", ok, err)
+	}
+	for _, id := range ids {
+		if ok, _, err := s.StatContent(id); err != nil || ok {
+			t.Errorf("StatContent(%s) after Delete = %v, %v; want false, nil", id, ok, err)
+		}
+	}
+	// Deleting is idempotent: burn-after-read and expiry race with it.
+	if err := s.Delete("DLT"); err != nil {
+		t.Errorf("Delete of a deleted paste: %v", err)
+	}
+}
+
+func testDeleteBatch(t *testing.T, s storage.PasteStore) {
+	ids := []string{"DLBA", "DLBB", "DLBC"}
+	for _, id := range ids {
+		mustStore(t, s, newPaste(id))
+		if err := s.StoreContent(id, []byte("data")); err != nil {
+			t.Fatalf("StoreContent(%s): %v", id, err)
+		}
+	}
+	mustStore(t, s, newPaste("DLBKEEP"))
+	var calls, last int
+	errs := storage.DeleteBatch(s, append(ids, "DLBMISS"), func(done, total int) {
+		calls++
+		last = done
+		if total != len(ids)+1 {
+			t.Errorf("progress total = %d, want %d", total, len(ids)+1)
+		}
+	})
+	if len(errs) != 0 {
+		t.Fatalf("DeleteBatch: %v", errs)
+	}
+	if calls != len(ids)+1 || last != len(ids)+1 {
+		t.Errorf("progress called %d times, last with %d; want %d", calls, last, len(ids)+1)
+	}
+	for _, id := range ids {
+		assertNotFound(t, s, id)
+		if ok, _, err := s.StatContent(id); err != nil || ok {
+			t.Errorf("StatContent(%s) after DeleteBatch = %v, %v; want false, nil", id, ok, err)
+		}
+	}
+	mustGet(t, s, "DLBKEEP")
+}
+
+func testIncrementReadCount(t *testing.T, s storage.PasteStore) {
+	mustStore(t, s, newPaste("RDS"))
+	if err := s.IncrementReadCount("RDS"); err != nil {
+		t.Fatalf("IncrementReadCount: %v", err)
+	}
+	if err := storage.IncrementReads(s, "RDS", models.ReadDownload); err != nil {
+		t.Fatalf("IncrementReads: %v", err)
+	}
+	got := mustGet(t, s, "RDS")
+	if got.ReadCount != 4 {
+		t.Errorf("ReadCount = %d, want 4", got.ReadCount)
+	}
+	if _, ok := s.(storage.ReadCounter); ok && got.Downloads != 1 {
+		t.Errorf("Downloads = %d, want 1 for a ReadCounter", got.Downloads)
+	}
+	if err := s.IncrementReadCount("MSSNG"); err == nil {
+		t.Error("IncrementReadCount(missing): expected an error")
+	}
+}
+
+func testList(t *testing.T, s storage.PasteStore) {
+	lister, ok := s.(storage.Lister)
+	if !ok {
+		t.Skip("store does not implement storage.Lister")
+	}
+	for _, id := range []string{"LSTC", "LSTA", "LSTB"} {
+		p := newPaste(id)
+		p.Tags = nil
+		if id != "LSTB" {
+			p.Tags = []string{"keep"}
+		}
+		mustStore(t, s, p)
+		if err := s.StoreContent(id, []byte("x")); err != nil {
+			t.Fatalf("StoreContent(%s): %v", id, err)
+		}
+	}
+
+	page, err := lister.List(storage.ListOptions{Limit: 2})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if !reflect.DeepEqual(page.IDs, []string{"LSTA", "LSTB"}) || page.NextCursor != "LSTB" {
+		t.Fatalf("first page = %v (next %q); want [LSTA LSTB] (next LSTB)", page.IDs, page.NextCursor)
+	}
+	page, err = lister.List(storage.ListOptions{Limit: 2, Cursor: page.NextCursor})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if !reflect.DeepEqual(page.IDs, []string{"LSTC"}) || page.NextCursor != "" {
+		t.Fatalf("second page = %v (next %q); want [LSTC] and no cursor", page.IDs, page.NextCursor)
+	}
+
+	page, err = lister.List(storage.ListOptions{Tag: "keep"})
+	if err != nil {
+		t.Fatalf("List by tag: %v", err)
+	}
+	if !reflect.DeepEqual(page.IDs, []string{"LSTA", "LSTC"}) {
+		t.Errorf("tag listing = %v; want [LSTA LSTC]", page.IDs)
+	}
+	if err := s.Delete("LSTA"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	page, err = lister.List(storage.ListOptions{Tag: "keep"})
+	if err != nil {
+		t.Fatalf("List by tag: %v", err)
+	}
+	if !reflect.DeepEqual(page.IDs, []string{"LSTC"}) {
+		t.Errorf("tag listing after Delete = %v; want [LSTC]", page.IDs)
+	}
+	if page, err := lister.List(storage.ListOptions{Tag: "none"}); err != nil || len(page.IDs) != 0 {
+		t.Errorf("listing an unused tag = %v, %v; want no ids", page.IDs, err)
+	}
+}
+
+func testListChecksum(t *testing.T, s storage.PasteStore) {
+	lister, ok := s.(storage.Lister)
+	if !ok {
+		t.Skip("store does not implement storage.Lister")
+	}
+	same, other := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	for _, id := range []string{"SUMB", "SUMA", "SUMC"} {
+		p := newPaste(id)
+		p.SHA256 = same
+		if id == "SUMC" {
+			p.SHA256 = other
+		}
+		mustStore(t, s, p)
+	}
+
+	page, err := lister.List(storage.ListOptions{Checksum: same})
+	if err != nil {
+		t.Fatalf("List by checksum: %v", err)
+	}
+	if !reflect.DeepEqual(page.IDs, []string{"SUMA", "SUMB"}) {
+		t.Errorf("checksum listing = %v; want [SUMA SUMB]", page.IDs)
+	}
+	p := newPaste("SUMA")
+	p.SHA256 = other
+	mustStore(t, s, p)
+	page, err = lister.List(storage.ListOptions{Checksum: other})
+	if err != nil {
+		t.Fatalf("List by checksum: %v", err)
+	}
+	if !reflect.DeepEqual(page.IDs, []string{"SUMA", "SUMC"}) {
+		t.Errorf("checksum listing after overwrite = %v; want [SUMA SUMC]", page.IDs)
+	}
+	if err := s.Delete("SUMB"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if page, err := lister.List(storage.ListOptions{Checksum: same}); err != nil || slices.Contains(page.IDs, "SUMB") {
+		t.Errorf("checksum listing after Delete = %v, %v; want no SUMB", page.IDs, err)
+	}
+	if _, err := lister.List(storage.ListOptions{Checksum: "ABC"}); err == nil {
+		t.Error("expected a malformed checksum to be rejected")
+	}
+}
+
+func testGetBatch(t *testing.T, s storage.PasteStore) {
+	mustStore(t, s, newPaste("BTCH"))
+	results := storage.GetBatch(s, []string{"BTCH", "MSSNG"})
+	if len(results) != 2 {
+		t.Fatalf("GetBatch returned %d results, want 2", len(results))
+	}
+	if r := results["BTCH"]; r.Err != nil || r.Paste == nil || r.Paste.ID != "BTCH" {
+		t.Errorf("GetBatch(BTCH) = %+v", r)
+	}
+	if r := results["MSSNG"]; !errors.Is(r.Err, storage.ErrNotFound) || r.Paste != nil {
+		t.Errorf("GetBatch(MSSNG) = %+v, want ErrNotFound", r)
+	}
+}
+
+func testListObjects(t *testing.T, s storage.PasteStore) {
+	lister, ok := s.(storage.ObjectLister)
+	if !ok {
+		t.Skip("store does not implement storage.ObjectLister")
+	}
+	p := newPaste("BJCTB")
+	mustStore(t, s, p)
+	for _, id := range []string{"BJCTB", "BJCTA", "BJCTB" + storage.PreviewSuffix} {
+		if err := s.StoreContent(id, []byte("data")); err != nil {
+			t.Fatalf("StoreContent(%s): %v", id, err)
+		}
+	}
+	var names []string
+	err := lister.ListObjects(func(obj storage.Object) error {
+		names = append(names, obj.Name)
+		if obj.ModTime.IsZero() {
+			t.Errorf("object %s has no modification time", obj.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListObjects: %v", err)
+	}
+	// The paste's tags are indexed, but the index is internal.
+	want := []string{"BJCTA", "BJCTB", "BJCTB.json", "BJCTB" + storage.PreviewSuffix}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("ListObjects = %v; want %v", names, want)
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	if err := lister.ListObjects(func(storage.Object) error { n++; return stop }); !errors.Is(err, stop) || n != 1 {
+		t.Errorf("ListObjects did not stop at the callback's error: %v after %d objects", err, n)
+	}
+}
+
+func testCollections(t *testing.T, s storage.PasteStore) {
+	cs, ok := s.(storage.CollectionStore)
+	if !ok {
+		t.Skip("store does not implement storage.CollectionStore")
+	}
+	if _, err := cs.GetCollection("CLLCTN"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetCollection of a missing collection: expected ErrNotFound, got %v", err)
+	}
+	want := &models.Collection{
+		ID:          "CLLCTN",
+		Title:       "Incident 42",
+		Description: "Logs and notes",
+		CreatedAt:   time.Now().UTC().Truncate(time.Second),
+		Owner:       "key-1",
+		Pastes:      []string{"MMBRB", "MMBRA"},
+	}
+	if err := cs.StoreCollection(want); err != nil {
+		t.Fatalf("StoreCollection: %v", err)
+	}
+	got, err := cs.GetCollection("CLLCTN")
+	if err != nil {
+		t.Fatalf("GetCollection: %v", err)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) {
+		t.Errorf("CreatedAt = %v; want %v", got.CreatedAt, want.CreatedAt)
+	}
+	got.CreatedAt = want.CreatedAt
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetCollection = %+v; want %+v", got, want)
+	}
+	if lister, ok := s.(storage.Lister); ok {
+		if page, err := lister.List(storage.ListOptions{}); err != nil || len(page.IDs) != 0 {
+			t.Errorf("List = %v, %v; collections must not be listed as pastes", page.IDs, err)
+		}
+	}
+
+	if err := cs.DeleteCollection("CLLCTN"); err != nil {
+		t.Fatalf("DeleteCollection: %v", err)
+	}
+	if _, err := cs.GetCollection("CLLCTN"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetCollection after delete: expected ErrNotFound, got %v", err)
+	}
+	if err := cs.DeleteCollection("CLLCTN"); err != nil {
+		t.Errorf("DeleteCollection of a missing collection: %v", err)
+	}
+	if err := cs.StoreCollection(&models.Collection{ID: "../x"}); err == nil {
+		t.Error("StoreCollection accepted an unsafe id")
+	}
+}
+
+func testTokens(t *testing.T, s storage.PasteStore) {
+	ts, ok := s.(storage.TokenStore)
+	if !ok {
+		t.Skip("store does not implement storage.TokenStore")
+	}
+	const tokA, tokB = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa2", "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb3"
+	if _, err := ts.GetToken(tokA); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetToken of a missing token: expected ErrNotFound, got %v", err)
+	}
+	if tokens, err := ts.ListTokens("TKNPST"); err != nil || len(tokens) != 0 {
+		t.Fatalf("ListTokens of a paste without tokens = %v, %v", tokens, err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	want := &models.ShareToken{Token: tokA, Slug: "TKNPST", PasteCreatedAt: now, CreatedAt: now, MaxUses: 3, Owner: "key-1"}
+	if err := ts.StoreToken(want); err != nil {
+		t.Fatalf("StoreToken: %v", err)
+	}
+	if err := ts.StoreToken(&models.ShareToken{Token: tokB, Slug: "TKNPST", PasteCreatedAt: now, CreatedAt: now}); err != nil {
+		t.Fatalf("StoreToken: %v", err)
+	}
+	want.Uses = 1
+	if err := ts.StoreToken(want); err != nil {
+		t.Fatalf("StoreToken update: %v", err)
+	}
+	got, err := ts.GetToken(tokA)
+	if err != nil {
+		t.Fatalf("GetToken: %v", err)
+	}
+	if got.Uses != 1 || got.MaxUses != 3 || got.Slug != "TKNPST" || !got.PasteCreatedAt.Equal(now) {
+		t.Errorf("GetToken = %+v; want %+v", got, want)
+	}
+	if tokens, err := ts.ListTokens("TKNPST"); err != nil || len(tokens) != 2 {
+		t.Fatalf("ListTokens = %v, %v; want 2 tokens", tokens, err)
+	}
+	if lister, ok := s.(storage.Lister); ok {
+		if page, err := lister.List(storage.ListOptions{}); err != nil || len(page.IDs) != 0 {
+			t.Errorf("List = %v, %v; tokens must not be listed as pastes", page.IDs, err)
+		}
+	}
+
+	if err := ts.DeleteToken(want); err != nil {
+		t.Fatalf("DeleteToken: %v", err)
+	}
+	if _, err := ts.GetToken(tokA); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetToken after delete: expected ErrNotFound, got %v", err)
+	}
+	if tokens, err := ts.ListTokens("TKNPST"); err != nil || len(tokens) != 1 || tokens[0].Token != tokB {
+		t.Errorf("ListTokens after delete = %v, %v; want only %s", tokens, err, tokB)
+	}
+	if err := ts.DeleteToken(want); err != nil {
+		t.Errorf("DeleteToken of a missing token: %v", err)
+	}
+	if err := ts.StoreToken(&models.ShareToken{Token: "../x", Slug: "TKNPST"}); err == nil {
+		t.Error("StoreToken accepted an unsafe token")
+	}
+}
+
+func testCerts(t *testing.T, s storage.PasteStore) {
+	cs, ok := s.(storage.CertStore)
+	if !ok {
+		t.Skip("store does not implement storage.CertStore")
+	}
+	if _, err := cs.GetCert("example.com.pem"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetCert of a missing certificate: expected ErrNotFound, got %v", err)
+	}
+	want := []byte("-----BEGIN CERTIFICATE-----\n...\n-----END CERTIFICATE-----\n")
+	if err := cs.PutCert("example.com.pem", want); err != nil {
+		t.Fatalf("PutCert: %v", err)
+	}
+	if got, err := cs.GetCert("example.com.pem"); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("GetCert = %q, %v; want %q", got, err, want)
+	}
+	if lister, ok := s.(storage.Lister); ok {
+		if page, err := lister.List(storage.ListOptions{}); err != nil || len(page.IDs) != 0 {
+			t.Errorf("List = %v, %v; certificates must not be listed as pastes", page.IDs, err)
+		}
+	}
+
+	if err := cs.DeleteCert("example.com.pem"); err != nil {
+		t.Fatalf("DeleteCert: %v", err)
+	}
+	if _, err := cs.GetCert("example.com.pem"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetCert after delete: expected ErrNotFound, got %v", err)
+	}
+	if err := cs.DeleteCert("example.com.pem"); err != nil {
+		t.Errorf("DeleteCert of a missing certificate: %v", err)
+	}
+	if err := cs.PutCert("../x", want); err == nil {
+		t.Error("PutCert accepted an unsafe name")
+	}
+}
+
+func testReadOnly(t *testing.T, s storage.PasteStore) {
+	setter, ok := s.(storage.ReadOnlySetter)
+	if !ok {
+		t.Skip("store does not implement storage.ReadOnlySetter")
+	}
+	p := newPaste("RDNLY")
+	past := time.Now().Add(-time.Minute)
+	p.ExpiresAt = &past
+	mustStore(t, s, p)
+	setter.SetReadOnly(true)
+
+	if err := s.Store(newPaste("WRT")); !errors.Is(err, storage.ErrReadOnly) {
+		t.Errorf("Store: expected ErrReadOnly, got %v", err)
+	}
+	if err := s.StoreContent("WRT", []byte("x")); !errors.Is(err, storage.ErrReadOnly) {
+		t.Errorf("StoreContent: expected ErrReadOnly, got %v", err)
+	}
+	if err := s.Delete("RDNLY"); !errors.Is(err, storage.ErrReadOnly) {
+		t.Errorf("Delete: expected ErrReadOnly, got %v", err)
+	}
+	if err := s.IncrementReadCount("RDNLY"); !errors.Is(err, storage.ErrReadOnly) {
+		t.Errorf("IncrementReadCount: expected ErrReadOnly, got %v", err)
+	}
+	// Reading an expired paste must not clean it up on a read-only store.
+	assertNotFound(t, s, "RDNLY")
+	setter.SetReadOnly(false)
+	if ok, err := s.Exists("RDNLY"); err != nil || !ok {
+		t.Errorf("expired paste was removed by a read-only store: Exists = %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,25 @@
+package storage
+
+// ContentCreator is implemented by stores that can store content only
+// where none is stored yet, as one atomic operation that also holds
+// against other instances sharing the backend.
+type ContentCreator interface {
+	// CreateContent stores content under id unless content is already
+	// stored there, and reports whether it stored it.
+	CreateContent(id string, content []byte) (bool, error)
+}
+
+// CreateContent stores content under id unless content is already stored
+// there, and reports whether it stored it. Stores without a ContentCreator
+// implementation are checked with StatContent first, which only holds
+// against callers in the same process that serialize their calls.
+func CreateContent(store PasteStore, id string, content []byte) (bool, error) {
+	if cc, ok := store.(ContentCreator); ok {
+		return cc.CreateContent(id, content)
+	}
+	exists, _, err := store.StatContent(id)
+	if err != nil || exists {
+		return false, err
+	}
+	return true, store.StoreContent(id, content)
+}
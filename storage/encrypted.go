@@ -0,0 +1,323 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/johnwmail/nclip/internal/keyring"
+	"github.com/johnwmail/nclip/models"
+)
+
+// EncryptedStore wraps a PasteStore and encrypts paste content (and cached
+// previews) with the keyring's current key before it reaches the backend.
+// Metadata is stored as is. Content is bound to its id, so encrypted
+// objects cannot be swapped between pastes. Content stored before
+// encryption was enabled is read back unchanged until it is re-encrypted.
+//
+// GCM authenticates whole objects, so GetContentPrefix reads and decrypts
+// the full content.
+type EncryptedStore struct {
+	backend PasteStore
+	keys    *keyring.Keyring
+}
+
+// NewEncryptedStore wraps backend, encrypting with keys.
+func NewEncryptedStore(backend PasteStore, keys *keyring.Keyring) *EncryptedStore {
+	return &EncryptedStore{backend: backend, keys: keys}
+}
+
+// Backend returns the wrapped store.
+func (s *EncryptedStore) Backend() PasteStore {
+	return s.backend
+}
+
+// KeyID returns the ID of the key new content is encrypted with.
+func (s *EncryptedStore) KeyID() string {
+	return s.keys.Current()
+}
+
+// Store implements PasteStore.
+func (s *EncryptedStore) Store(paste *models.Paste) error {
+	return s.backend.Store(paste)
+}
+
+// Get implements PasteStore.
+func (s *EncryptedStore) Get(id string) (*models.Paste, error) {
+	return s.backend.Get(id)
+}
+
+// GetBatch implements BatchGetter.
+func (s *EncryptedStore) GetBatch(ids []string) map[string]BatchResult {
+	return GetBatch(s.backend, ids)
+}
+
+// DeleteBatch implements BatchDeleter.
+func (s *EncryptedStore) DeleteBatch(ids []string, progress DeleteProgress) map[string]error {
+	return DeleteBatch(s.backend, ids, progress)
+}
+
+// Exists implements PasteStore.
+func (s *EncryptedStore) Exists(id string) (bool, error) {
+	return s.backend.Exists(id)
+}
+
+// Delete implements PasteStore.
+func (s *EncryptedStore) Delete(id string) error {
+	return s.backend.Delete(id)
+}
+
+// IncrementReadCount implements PasteStore.
+func (s *EncryptedStore) IncrementReadCount(id string) error {
+	return s.backend.IncrementReadCount(id)
+}
+
+// IncrementReads implements ReadCounter.
+func (s *EncryptedStore) IncrementReads(id string, kind models.ReadKind) error {
+	return IncrementReads(s.backend, id, kind)
+}
+
+// Close implements PasteStore.
+func (s *EncryptedStore) Close() error {
+	return s.backend.Close()
+}
+
+// StoreContent implements PasteStore, encrypting content.
+func (s *EncryptedStore) StoreContent(id string, content []byte) error {
+	sealed, err := s.keys.Seal([]byte(id), content)
+	if err != nil {
+		return err
+	}
+	return s.backend.StoreContent(id, sealed)
+}
+
+// CreateContent implements ContentCreator, encrypting content.
+func (s *EncryptedStore) CreateContent(id string, content []byte) (bool, error) {
+	sealed, err := s.keys.Seal([]byte(id), content)
+	if err != nil {
+		return false, err
+	}
+	return CreateContent(s.backend, id, sealed)
+}
+
+// GetContent implements PasteStore, decrypting content.
+func (s *EncryptedStore) GetContent(id string) ([]byte, error) {
+	data, err := s.backend.GetContent(id)
+	if err != nil {
+		return nil, err
+	}
+	content, _, err := s.keys.Open([]byte(id), data)
+	return content, err
+}
+
+// OpenContent implements ContentOpener. Content stored in plain text is
+// streamed; encrypted content is decrypted in memory first.
+func (s *EncryptedStore) OpenContent(id string) (io.ReadCloser, error) {
+	header, err := s.backend.GetContentPrefix(id, int64(keyring.MaxHeaderSize))
+	if err != nil {
+		return nil, err
+	}
+	if _, encrypted := keyring.KeyID(header); !encrypted {
+		return OpenContent(s.backend, id)
+	}
+	content, err := s.GetContent(id)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// GetContentPrefix implements PasteStore.
+func (s *EncryptedStore) GetContentPrefix(id string, n int64) ([]byte, error) {
+	header, err := s.backend.GetContentPrefix(id, int64(keyring.MaxHeaderSize))
+	if err != nil {
+		return nil, err
+	}
+	if _, encrypted := keyring.KeyID(header); !encrypted {
+		return s.backend.GetContentPrefix(id, n)
+	}
+	content, err := s.GetContent(id)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > n {
+		content = content[:n]
+	}
+	return content, nil
+}
+
+// StatContent implements PasteStore, reporting the decrypted size.
+func (s *EncryptedStore) StatContent(id string) (bool, int64, error) {
+	exists, size, err := s.backend.StatContent(id)
+	if err != nil || !exists {
+		return exists, size, err
+	}
+	header, err := s.backend.GetContentPrefix(id, int64(keyring.MaxHeaderSize))
+	if err != nil {
+		return false, 0, err
+	}
+	return true, keyring.PlainSize(header, size), nil
+}
+
+// AtRest implements AtRestReporter, adding whether the content is
+// encrypted, and with which key, to what the backend reports.
+func (s *EncryptedStore) AtRest(id string) (AtRest, error) {
+	rest, err := ContentAtRest(s.backend, id)
+	if err != nil {
+		return AtRest{}, err
+	}
+	header, err := s.backend.GetContentPrefix(id, int64(keyring.MaxHeaderSize))
+	if err != nil {
+		return AtRest{}, err
+	}
+	rest.KeyID, rest.Encrypted = keyring.KeyID(header)
+	return rest, nil
+}
+
+// List implements Lister by delegating to the backend.
+func (s *EncryptedStore) List(opts ListOptions) (ListPage, error) {
+	l, ok := s.backend.(Lister)
+	if !ok {
+		return ListPage{}, errUnsupported
+	}
+	return l.List(opts)
+}
+
+// ListObjects implements ObjectLister by delegating to the backend.
+func (s *EncryptedStore) ListObjects(fn func(Object) error) error {
+	l, ok := s.backend.(ObjectLister)
+	if !ok {
+		return errUnsupported
+	}
+	return l.ListObjects(fn)
+}
+
+// Reencrypt rewrites the content stored under id with the current key
+// when it is in plain text or encrypted with an older key, and reports
+// whether it did. Missing content is not an error.
+func (s *EncryptedStore) Reencrypt(id string) (bool, error) {
+	exists, _, err := s.backend.StatContent(id)
+	if err != nil || !exists {
+		return false, err
+	}
+	data, err := s.backend.GetContent(id)
+	if err != nil {
+		return false, err
+	}
+	if keyID, ok := keyring.KeyID(data); ok && keyID == s.keys.Current() {
+		return false, nil
+	}
+	content, _, err := s.keys.Open([]byte(id), data)
+	if err != nil {
+		return false, err
+	}
+	if err := s.StoreContent(id, content); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// StoreCollection implements CollectionStore by delegating to the backend.
+func (s *EncryptedStore) StoreCollection(col *models.Collection) error {
+	cs, ok := s.backend.(CollectionStore)
+	if !ok {
+		return errUnsupported
+	}
+	return cs.StoreCollection(col)
+}
+
+// GetCollection implements CollectionStore by delegating to the backend.
+func (s *EncryptedStore) GetCollection(id string) (*models.Collection, error) {
+	cs, ok := s.backend.(CollectionStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return cs.GetCollection(id)
+}
+
+// DeleteCollection implements CollectionStore by delegating to the backend.
+func (s *EncryptedStore) DeleteCollection(id string) error {
+	cs, ok := s.backend.(CollectionStore)
+	if !ok {
+		return errUnsupported
+	}
+	return cs.DeleteCollection(id)
+}
+
+// StoreToken implements TokenStore by delegating to the backend.
+func (s *EncryptedStore) StoreToken(t *models.ShareToken) error {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return errUnsupported
+	}
+	return ts.StoreToken(t)
+}
+
+// GetToken implements TokenStore by delegating to the backend.
+func (s *EncryptedStore) GetToken(token string) (*models.ShareToken, error) {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return ts.GetToken(token)
+}
+
+// DeleteToken implements TokenStore by delegating to the backend.
+func (s *EncryptedStore) DeleteToken(t *models.ShareToken) error {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return errUnsupported
+	}
+	return ts.DeleteToken(t)
+}
+
+// ListTokens implements TokenStore by delegating to the backend.
+func (s *EncryptedStore) ListTokens(slug string) ([]*models.ShareToken, error) {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return ts.ListTokens(slug)
+}
+
+// certAAD binds an encrypted certificate to its name, keeping it apart
+// from paste content stored under the same string.
+func certAAD(name string) []byte {
+	return []byte(certDir + "/" + name)
+}
+
+// GetCert implements CertStore, decrypting the certificate.
+func (s *EncryptedStore) GetCert(name string) ([]byte, error) {
+	cs, ok := s.backend.(CertStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	data, err := cs.GetCert(name)
+	if err != nil {
+		return nil, err
+	}
+	plain, _, err := s.keys.Open(certAAD(name), data)
+	return plain, err
+}
+
+// PutCert implements CertStore, encrypting the certificate: it holds the
+// private key.
+func (s *EncryptedStore) PutCert(name string, data []byte) error {
+	cs, ok := s.backend.(CertStore)
+	if !ok {
+		return errUnsupported
+	}
+	sealed, err := s.keys.Seal(certAAD(name), data)
+	if err != nil {
+		return err
+	}
+	return cs.PutCert(name, sealed)
+}
+
+// DeleteCert implements CertStore by delegating to the backend.
+func (s *EncryptedStore) DeleteCert(name string) error {
+	cs, ok := s.backend.(CertStore)
+	if !ok {
+		return errUnsupported
+	}
+	return cs.DeleteCert(name)
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/keyring"
+	"github.com/johnwmail/nclip/models"
+)
+
+// testKeys returns a keyring of the given ID:BYTE entries, each key being
+// the byte repeated.
+func testKeys(t *testing.T, entries ...string) *keyring.Keyring {
+	t.Helper()
+	spec := make([]string, len(entries))
+	for i, e := range entries {
+		id, b, _ := strings.Cut(e, ":")
+		spec[i] = id + ":" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte(b), keyring.KeySize))
+	}
+	k, err := keyring.Parse(strings.Join(spec, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestEncryptedStore(t *testing.T) {
+	backend, err := NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("top secret paste content")
+
+	// Content stored before encryption was enabled.
+	if err := backend.StoreContent("PLAN2", content); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewEncryptedStore(backend, testKeys(t, "k1:a"))
+	if err := store.Store(&models.Paste{ID: "ENC22", CreatedAt: time.Now(), Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.StoreContent("ENC22", content); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := backend.GetContent("ENC22")
+	if bytes.Contains(raw, content) {
+		t.Fatal("backend holds the plaintext")
+	}
+	if got, err := store.GetContent("ENC22"); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("GetContent = %q, %v", got, err)
+	}
+	if got, err := store.GetContentPrefix("ENC22", 3); err != nil || string(got) != "top" {
+		t.Errorf("GetContentPrefix = %q, %v", got, err)
+	}
+	if exists, size, err := store.StatContent("ENC22"); err != nil || !exists || size != int64(len(content)) {
+		t.Errorf("StatContent = %v, %d, %v; want plaintext size %d", exists, size, err, len(content))
+	}
+	if got, err := store.GetContent("PLAN2"); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("legacy plaintext GetContent = %q, %v", got, err)
+	}
+	if exists, size, _ := store.StatContent("MSSNG"); exists || size != 0 {
+		t.Errorf("missing content reported as %v, %d", exists, size)
+	}
+
+	// Re-encrypt both after rotating to k2; a second pass changes nothing.
+	rotated := NewEncryptedStore(backend, testKeys(t, "k2:b", "k1:a"))
+	for _, id := range []string{"ENC22", "PLAN2"} {
+		if changed, err := rotated.Reencrypt(id); err != nil || !changed {
+			t.Errorf("Reencrypt(%s) = %v, %v", id, changed, err)
+		}
+		if changed, err := rotated.Reencrypt(id); err != nil || changed {
+			t.Errorf("second Reencrypt(%s) = %v, %v", id, changed, err)
+		}
+		raw, _ := backend.GetContent(id)
+		if keyID, _ := keyring.KeyID(raw); keyID != "k2" {
+			t.Errorf("%s encrypted with %q after rotation, want k2", id, keyID)
+		}
+		if got, err := rotated.GetContent(id); err != nil || !bytes.Equal(got, content) {
+			t.Errorf("%s after rotation: %q, %v", id, got, err)
+		}
+	}
+	if changed, err := rotated.Reencrypt("MSSNG"); err != nil || changed {
+		t.Errorf("Reencrypt of missing content = %v, %v", changed, err)
+	}
+}
+
+func TestEncryptedStore_AtRest(t *testing.T) {
+	backend, err := NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.StoreContent("PLAIN", []byte("stored before encryption")); err != nil {
+		t.Fatal(err)
+	}
+	store := NewInstrumentedStore(NewEncryptedStore(backend, testKeys(t, "k1:a")), "filesystem", nil)
+	if err := store.StoreContent("SEALED", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]AtRest{
+		"SEALED": {Encrypted: true, KeyID: "k1"},
+		"PLAIN":  {},
+	}
+	for id, want := range cases {
+		got, err := ContentAtRest(store, id)
+		if err != nil || got != want {
+			t.Errorf("ContentAtRest(%s) = %+v, %v; want %+v", id, got, err, want)
+		}
+	}
+	if got, err := ContentAtRest(backend, "PLAIN"); err != nil || got != (AtRest{}) {
+		t.Errorf("ContentAtRest on the filesystem = %+v, %v; want the zero value", got, err)
+	}
+	if _, err := ContentAtRest(store, "GONE"); err == nil {
+		t.Error("ContentAtRest of missing content succeeded")
+	}
+
+	caps := StoreCapabilities(store)
+	if !caps.Encryption || caps.Chunking || !caps.Listing || caps.Compression {
+		t.Errorf("StoreCapabilities = %+v", caps)
+	}
+	if caps := StoreCapabilities(backend); caps.Encryption {
+		t.Errorf("StoreCapabilities of the plain backend = %+v", caps)
+	}
+}
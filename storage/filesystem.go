@@ -2,6 +2,7 @@ package storage
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -9,6 +10,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/johnwmail/nclip/models"
 	"github.com/johnwmail/nclip/utils"
@@ -23,14 +25,19 @@ var errUnsafeID = fmt.Errorf("invalid paste id")
 // stays within baseDir, which is the pattern recognised by CodeQL.
 func safePath(baseDir, id string) (string, error) {
 	// Reject obvious traversal characters early for clear error messages.
-	if strings.Contains(id, "/") || strings.Contains(id, "\\") || strings.Contains(id, "..") {
+	if strings.Contains(id, "/") || strings.Contains(id, "\\") || strings.Contains(id, "..") || reservedName(id) {
 		log.Printf("[ERROR] FS: unsafe id rejected: %q", id)
 		return "", errUnsafeID
 	}
 	p := filepath.Join(baseDir, id)
 	p = filepath.Clean(p)
-	// Ensure the cleaned path is still within baseDir.
-	if !strings.HasPrefix(p, filepath.Clean(baseDir)+string(os.PathSeparator)) {
+	// Ensure the cleaned path is still within baseDir. A volume root such
+	// as C:\ already ends in a separator.
+	base := filepath.Clean(baseDir)
+	if !strings.HasSuffix(base, string(os.PathSeparator)) {
+		base += string(os.PathSeparator)
+	}
+	if !strings.HasPrefix(p, base) {
 		log.Printf("[ERROR] FS: path escapes base dir: %q", p)
 		return "", errUnsafeID
 	}
@@ -41,15 +48,30 @@ func safePath(baseDir, id string) (string, error) {
 type FilesystemStore struct {
 	dataDir    string
 	bufferSize int
+	readOnly   bool
+	fsync      bool
 	mu         sync.Mutex
+	// onExpire is told the ids of expired pastes Get removed.
+	onExpire func(id string)
+	// writeTemp and replace write and rename the temporary files of
+	// atomic writes; tests replace them to inject faults.
+	writeTemp func(f *os.File, data []byte) error
+	replace   func(oldpath, newpath string) error
 }
 
 // NewFilesystemStore creates a FilesystemStore for the given data directory.
-// If dataDir is empty it defaults to "./data". The directory is created if it does not exist.
+// If dataDir is empty it defaults to "./data". Either slash works as the
+// separator, and the path is made absolute so later changes of working
+// directory (as when running as a Windows service) do not move the store.
+// The directory is created if it does not exist.
 func NewFilesystemStore(dataDir string) (*FilesystemStore, error) {
 	if dataDir == "" {
 		dataDir = "./data"
 	}
+	dataDir, err := filepath.Abs(filepath.FromSlash(dataDir))
+	if err != nil {
+		return nil, fmt.Errorf("invalid data directory: %w", err)
+	}
 	// Check if the dataDir not exists and create it with logging
 	if _, err := os.Stat(dataDir); os.IsNotExist(err) {
 		log.Printf("[INFO] Creating data directory: %s", dataDir)
@@ -57,12 +79,41 @@ func NewFilesystemStore(dataDir string) (*FilesystemStore, error) {
 			return nil, fmt.Errorf("failed to create data directory %s: %w", dataDir, err)
 		}
 	}
+	now := time.Now()
+	for _, dir := range []string{dataDir, filepath.Join(dataDir, collectionDir), filepath.Join(dataDir, tokenDir)} {
+		removeStaleTemps(dir, now)
+	}
 	return &FilesystemStore{
 		dataDir:    dataDir,
 		bufferSize: 4096,
+		writeTemp:  writeTemp,
+		replace:    replaceFile,
 	}, nil
 }
 
+// SetReadOnly implements ReadOnlySetter.
+func (fs *FilesystemStore) SetReadOnly(readOnly bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.readOnly = readOnly
+}
+
+// SetOnExpire implements ExpiryHook.
+func (fs *FilesystemStore) SetOnExpire(fn func(id string)) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.onExpire = fn
+}
+
+// SetFsync makes every write of content and metadata wait until its
+// directory entry reached the disk, so an acknowledged upload survives a
+// power loss. The files themselves are always synced before they replace
+// the old ones. It must be called before the
+// store is shared between goroutines.
+func (fs *FilesystemStore) SetFsync(fsync bool) {
+	fs.fsync = fsync
+}
+
 // Store saves the paste metadata (JSON) to local filesystem
 func (fs *FilesystemStore) Store(paste *models.Paste) error {
 	metaPath, err := safePath(fs.dataDir, paste.ID+".json")
@@ -71,19 +122,61 @@ func (fs *FilesystemStore) Store(paste *models.Paste) error {
 	}
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
+	if fs.readOnly {
+		return ErrReadOnly
+	}
 	metaData, err := json.MarshalIndent(paste, "", "  ")
 	if err != nil {
 		log.Printf("[ERROR] FS Store: failed to marshal metadata for %s: %v", paste.ID, err)
 		return err
 	}
-	if err := os.WriteFile(metaPath, metaData, 0o644); err != nil { // #nosec G306 -- path sanitised by safePath
+	// Replaced content leaves its checksum behind.
+	if oldData, err := readMeta(metaPath); err == nil {
+		var old models.Paste
+		if json.Unmarshal(oldData, &old) == nil && old.SHA256 != "" && old.SHA256 != paste.SHA256 {
+			fs.unindexChecksumLocked(&old)
+		}
+	}
+	if err := fs.writeMeta(metaPath, metaData); err != nil {
 		log.Printf("[ERROR] FS Store: failed to write metadata for %s: %v", paste.ID, err)
 		return err
 	}
+	for _, tag := range paste.Tags {
+		if err := fs.indexTagLocked(tag, paste.ID); err != nil {
+			log.Printf("[ERROR] FS Store: failed to index tag %q for %s: %v", tag, paste.ID, err)
+			return err
+		}
+	}
+	if utils.IsValidChecksum(paste.SHA256) {
+		if err := fs.indexLocked(filepath.Join(fs.dataDir, checksumIndexDir, paste.SHA256), paste.ID); err != nil {
+			log.Printf("[ERROR] FS Store: failed to index checksum of %s: %v", paste.ID, err)
+			return err
+		}
+	}
 	return nil
 }
 
 func (fs *FilesystemStore) Get(id string) (*models.Paste, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.getLocked(id)
+}
+
+// GetBatch reads metadata for all ids while holding the store lock once,
+// avoiding lock churn when dashboards request many slugs at a time.
+func (fs *FilesystemStore) GetBatch(ids []string) map[string]BatchResult {
+	results := make(map[string]BatchResult, len(ids))
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for _, id := range ids {
+		results[id] = batchResult(fs.getLocked(id))
+	}
+	return results
+}
+
+// getLocked loads and validates metadata for id, removing expired pastes.
+// Callers must hold fs.mu.
+func (fs *FilesystemStore) getLocked(id string) (*models.Paste, error) {
 	metaPath, err := safePath(fs.dataDir, id+".json")
 	if err != nil {
 		return nil, err
@@ -92,9 +185,7 @@ func (fs *FilesystemStore) Get(id string) (*models.Paste, error) {
 	if err != nil {
 		return nil, err
 	}
-	fs.mu.Lock()
-	defer fs.mu.Unlock()
-	metaData, err := os.ReadFile(metaPath) // #nosec G304 -- path sanitised by safePath
+	metaData, err := readMeta(metaPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, ErrNotFound
@@ -109,11 +200,19 @@ func (fs *FilesystemStore) Get(id string) (*models.Paste, error) {
 	}
 	if paste.IsExpired() {
 		log.Printf("[WARN] FS Get: paste %s is expired", id)
+		if fs.readOnly {
+			return nil, ErrNotFound
+		}
 		// Delete expired paste files directly (we already hold the mutex) so subsequent accesses are clean
+		fs.unindexTagsLocked(&paste)
+		fs.removeVersionsLocked(&paste)
 		_ = os.Remove(contentPath)
 		if err := os.Remove(metaPath); err != nil {
 			log.Printf("[WARN] FS Get: failed to remove expired metadata for %s: %v", id, err)
 		}
+		if fs.onExpire != nil {
+			fs.onExpire(id)
+		}
 		return nil, ErrNotFound
 	}
 	return &paste, nil
@@ -148,40 +247,239 @@ func (fs *FilesystemStore) Delete(id string) error {
 	}
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
+	if fs.readOnly {
+		return ErrReadOnly
+	}
+	if metaData, err := readMeta(metaPath); err == nil {
+		var paste models.Paste
+		if json.Unmarshal(metaData, &paste) == nil {
+			fs.unindexTagsLocked(&paste)
+			fs.removeVersionsLocked(&paste)
+		}
+	}
 	_ = os.Remove(contentPath)
 	_ = os.Remove(metaPath)
+	_ = os.Remove(contentPath + PreviewSuffix)
 	return nil
 }
 
-func (fs *FilesystemStore) IncrementReadCount(id string) error {
-	metaPath, err := safePath(fs.dataDir, id+".json")
+// removeVersionsLocked removes the kept earlier contents of paste.
+// Callers must hold fs.mu.
+func (fs *FilesystemStore) removeVersionsLocked(paste *models.Paste) {
+	for _, v := range paste.Versions {
+		path, err := safePath(fs.dataDir, VersionID(paste.ID, v.Number))
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("[WARN] FS: failed to remove version %d of %s: %v", v.Number, paste.ID, err)
+		}
+	}
+}
+
+// tagPath returns the index marker path for id under tag.
+func (fs *FilesystemStore) tagPath(tag, id string) (string, error) {
+	if !utils.IsValidTag(tag) {
+		return "", errInvalidTag
+	}
+	return safePath(filepath.Join(fs.dataDir, tagIndexDir, tag), id)
+}
+
+// indexTagLocked records id in the index for tag. Callers must hold fs.mu.
+func (fs *FilesystemStore) indexTagLocked(tag, id string) error {
+	if !utils.IsValidTag(tag) {
+		return errInvalidTag
+	}
+	return fs.indexLocked(filepath.Join(fs.dataDir, tagIndexDir, tag), id)
+}
+
+// indexLocked writes the index marker for id into dir. Callers must hold
+// fs.mu.
+func (fs *FilesystemStore) indexLocked(dir, id string) error {
+	p, err := safePath(dir, id)
 	if err != nil {
 		return err
 	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, nil, 0o644) // #nosec G306 -- path sanitised by safePath
+}
+
+// unindexTagsLocked removes paste from the index of each of its tags and
+// of its checksum. Callers must hold fs.mu.
+func (fs *FilesystemStore) unindexTagsLocked(paste *models.Paste) {
+	for _, tag := range paste.Tags {
+		if p, err := fs.tagPath(tag, paste.ID); err == nil {
+			_ = os.Remove(p)
+		}
+	}
+	fs.unindexChecksumLocked(paste)
+}
+
+// unindexChecksumLocked removes paste from the index of its checksum.
+// Callers must hold fs.mu.
+func (fs *FilesystemStore) unindexChecksumLocked(paste *models.Paste) {
+	if !utils.IsValidChecksum(paste.SHA256) {
+		return
+	}
+	dir := filepath.Join(fs.dataDir, checksumIndexDir, paste.SHA256)
+	if p, err := safePath(dir, paste.ID); err == nil {
+		_ = os.Remove(p)
+		// Most checksums belong to one paste.
+		_ = os.Remove(dir)
+	}
+}
+
+// List returns a page of slugs in ascending order, read from the tag or
+// checksum index when opts.Tag or opts.Checksum is set and from the
+// metadata files otherwise.
+func (fs *FilesystemStore) List(opts ListOptions) (ListPage, error) {
+	if err := checkChecksumListing(opts); err != nil {
+		return ListPage{}, err
+	}
+	dir, suffix := fs.dataDir, ".json"
+	if opts.Tag != "" {
+		if !utils.IsValidTag(opts.Tag) {
+			return ListPage{}, errInvalidTag
+		}
+		dir, suffix = filepath.Join(fs.dataDir, tagIndexDir, opts.Tag), ""
+	} else if opts.Checksum != "" {
+		dir, suffix = filepath.Join(fs.dataDir, checksumIndexDir, opts.Checksum), ""
+	}
 	fs.mu.Lock()
-	defer fs.mu.Unlock()
-	metaData, err := os.ReadFile(metaPath) // #nosec G304 -- path sanitised by safePath
+	entries, err := os.ReadDir(dir)
+	fs.mu.Unlock()
 	if err != nil {
-		log.Printf("[ERROR] FS IncrementReadCount: failed to read metadata for %s: %v", id, err)
-		return err
+		if os.IsNotExist(err) {
+			return ListPage{}, nil
+		}
+		log.Printf("[ERROR] FS List: failed to read %s: %v", dir, err)
+		return ListPage{}, err
+	}
+	// os.ReadDir sorts by name, and '.' sorts before every slug character,
+	// so "<id>.json" names are in the same order as their ids.
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		name := e.Name()
+		if suffix != "" {
+			if !strings.HasSuffix(name, suffix) {
+				continue
+			}
+			name = strings.TrimSuffix(name, suffix)
+		}
+		if isPasteKey(name) {
+			ids = append(ids, name)
+		}
 	}
-	var paste models.Paste
-	if err := json.Unmarshal(metaData, &paste); err != nil {
-		log.Printf("[ERROR] FS IncrementReadCount: failed to unmarshal metadata for %s: %v", id, err)
+	return pageFromSorted(ids, opts.Cursor, opts.Limit), nil
+}
+
+// ListObjects implements ObjectLister.
+func (fs *FilesystemStore) ListObjects(fn func(Object) error) error {
+	fs.mu.Lock()
+	entries, err := os.ReadDir(fs.dataDir)
+	fs.mu.Unlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		log.Printf("[ERROR] FS ListObjects: failed to read %s: %v", fs.dataDir, err)
 		return err
 	}
-	paste.ReadCount++
-	newMeta, err := json.MarshalIndent(&paste, "", "  ")
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			// Removed since the directory was read.
+			continue
+		}
+		if err := fn(Object{Name: e.Name(), Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *FilesystemStore) IncrementReadCount(id string) error {
+	return fs.IncrementReads(id, "")
+}
+
+// IncrementReads implements ReadCounter.
+func (fs *FilesystemStore) IncrementReads(id string, kind models.ReadKind) error {
+	metaPath, err := safePath(fs.dataDir, id+".json")
 	if err != nil {
 		return err
 	}
-	if err := os.WriteFile(metaPath, newMeta, 0o644); err != nil { // #nosec G306 -- path sanitised by safePath
-		log.Printf("[ERROR] FS IncrementReadCount: failed to write metadata for %s: %v", id, err)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.readOnly {
+		return ErrReadOnly
+	}
+	err = fs.updateMeta(metaPath, func(metaData []byte) ([]byte, error) {
+		var paste models.Paste
+		if err := json.Unmarshal(metaData, &paste); err != nil {
+			return nil, err
+		}
+		paste.RecordRead(kind)
+		return json.MarshalIndent(&paste, "", "  ")
+	})
+	if err != nil {
+		log.Printf("[ERROR] FS IncrementReadCount: failed to update metadata for %s: %v", id, err)
 		return err
 	}
 	return nil
 }
 
+// MigrateMetadata implements MetadataMigrator.
+func (fs *FilesystemStore) MigrateMetadata(dryRun bool) (MigrateStats, error) {
+	return migrateListed(fs, func(id string) (bool, error) {
+		return fs.migrateMeta(id, dryRun)
+	})
+}
+
+// migrateMeta rewrites the metadata of id if it has an older schema and
+// reports whether it did (or, with dryRun, would have).
+func (fs *FilesystemStore) migrateMeta(id string, dryRun bool) (bool, error) {
+	metaPath, err := safePath(fs.dataDir, id+".json")
+	if err != nil {
+		return false, err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.readOnly {
+		return false, ErrReadOnly
+	}
+	metaData, err := readMeta(metaPath)
+	if os.IsNotExist(err) {
+		// Deleted since it was listed.
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	schema, err := storedSchema(metaData)
+	if err != nil {
+		return false, err
+	}
+	if schema >= models.MetadataSchema || dryRun {
+		return schema < models.MetadataSchema, nil
+	}
+	err = fs.updateMeta(metaPath, func(metaData []byte) ([]byte, error) {
+		var paste models.Paste
+		if err := json.Unmarshal(metaData, &paste); err != nil {
+			return nil, err
+		}
+		return json.MarshalIndent(&paste, "", "  ")
+	})
+	return err == nil, err
+}
+
 func (fs *FilesystemStore) StoreContent(id string, content []byte) error {
 	contentPath, err := safePath(fs.dataDir, id)
 	if err != nil {
@@ -189,6 +487,9 @@ func (fs *FilesystemStore) StoreContent(id string, content []byte) error {
 	}
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
+	if fs.readOnly {
+		return ErrReadOnly
+	}
 	if utils.IsDebugEnabled() {
 		log.Printf("[DEBUG] StoreContent: id=%s, content_len=%d, first_bytes=%q", id, len(content), string(content[:min(32, len(content))]))
 	}
@@ -196,13 +497,50 @@ func (fs *FilesystemStore) StoreContent(id string, content []byte) error {
 		log.Printf("[ERROR] FS StoreContent: failed to create data directory %s: %v", fs.dataDir, err)
 		return err
 	}
-	if err := os.WriteFile(contentPath, content, 0o644); err != nil { // #nosec G306 -- path sanitised by safePath
+	if err := fs.writeAtomic(contentPath, content); err != nil {
 		log.Printf("[ERROR] FS StoreContent: failed to write content for %s: %v", id, err)
 		return err
 	}
 	return nil
 }
 
+// CreateContent implements ContentCreator, creating the file with O_EXCL
+// so that of several processes sharing the data directory only one
+// succeeds.
+func (fs *FilesystemStore) CreateContent(id string, content []byte) (bool, error) {
+	contentPath, err := safePath(fs.dataDir, id)
+	if err != nil {
+		return false, err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.readOnly {
+		return false, ErrReadOnly
+	}
+	if err := os.MkdirAll(fs.dataDir, 0o755); err != nil {
+		return false, err
+	}
+	f, err := os.OpenFile(contentPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644) // #nosec G302 G304 -- path checked by safePath
+	if os.IsExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	err = fs.writeTemp(f, content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(contentPath)
+		return false, err
+	}
+	if fs.fsync {
+		return true, syncDir(fs.dataDir)
+	}
+	return true, nil
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -225,6 +563,20 @@ func (fs *FilesystemStore) GetContent(id string) ([]byte, error) {
 	return data, nil
 }
 
+// OpenContent implements ContentOpener.
+func (fs *FilesystemStore) OpenContent(id string) (io.ReadCloser, error) {
+	contentPath, err := safePath(fs.dataDir, id)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(contentPath) // #nosec G304 -- path sanitised by safePath
+	if err != nil {
+		log.Printf("[ERROR] FS OpenContent: failed to open content for %s: %v", id, err)
+		return nil, err
+	}
+	return f, nil
+}
+
 // StatContent reports whether content exists on disk and its size.
 func (fs *FilesystemStore) StatContent(id string) (bool, int64, error) {
 	contentPath, err := safePath(fs.dataDir, id)
@@ -263,19 +615,401 @@ func (fs *FilesystemStore) GetContentPrefix(id string, n int64) ([]byte, error)
 			log.Printf("[WARN] FS GetContentPrefix: failed to close file for %s: %v", id, cerr)
 		}
 	}()
-	// If n is small enough to allocate, read into buffer
-	buf := make([]byte, n)
-	read, err := io.ReadFull(f, buf)
+	if n <= 0 {
+		return []byte{}, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(f, n))
+	if err != nil {
+		log.Printf("[ERROR] FS GetContentPrefix: failed to read content for %s: %v", id, err)
+		return nil, err
+	}
+	return data, nil
+}
+
+// Content and metadata files are replaced atomically: the new contents are
+// written to a temporary file in the same directory, synced and renamed
+// over the old file, so a crash leaves either the old or the new file and
+// never half of one. Readers need no lock. The service writes a paste's
+// content before its metadata, so metadata that exists implies complete
+// content.
+//
+// Metadata files are also rewritten after creation (read counts), and
+// several processes may share a data directory, so read-modify-write
+// updates hold an exclusive lock on lockFileName in the data directory.
+
+// lockFileName is the file locked around metadata updates.
+const lockFileName = ".lock"
+
+// tempSuffix ends the names of temporary files, which start with a dot so
+// that listings skip them.
+const tempSuffix = ".tmp"
+
+// staleTempAge is the age after which a temporary file is left over from
+// a crash and removed when the store is opened.
+const staleTempAge = time.Hour
+
+// readMeta reads the metadata file at path.
+func readMeta(path string) ([]byte, error) {
+	return os.ReadFile(path) // #nosec G304 -- path sanitised by safePath
+}
+
+// writeMeta replaces the contents of path under the metadata lock.
+func (fs *FilesystemStore) writeMeta(path string, data []byte) error {
+	return fs.updateMeta(path, func([]byte) ([]byte, error) { return data, nil })
+}
+
+// updateMeta replaces path with update(current contents) while holding
+// the metadata lock. update is passed nil when path does not exist.
+func (fs *FilesystemStore) updateMeta(path string, update func([]byte) ([]byte, error)) error {
+	lock, err := os.OpenFile(filepath.Join(fs.dataDir, lockFileName), os.O_RDWR|os.O_CREATE, 0o644) // #nosec G302 G304 -- fixed name in the data directory
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.Close() }()
+	if err := lockFile(lock, true); err != nil {
+		return fmt.Errorf("failed to lock %s: %w", lock.Name(), err)
+	}
+	defer func() { _ = unlockFile(lock) }()
+	current, err := readMeta(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	data, err := update(current)
 	if err != nil {
-		if err == io.ErrUnexpectedEOF || err == io.EOF {
-			return buf[:read], nil
+		return err
+	}
+	return fs.writeAtomic(path, data)
+}
+
+// writeAtomic replaces the file at path with data through a synced
+// temporary file. With fsync set, the directory is synced too, so the
+// rename survives a power loss.
+func (fs *FilesystemStore) writeAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*"+tempSuffix)
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	err = fs.writeTemp(f, data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = fs.replace(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if fs.fsync {
+		return syncDir(dir)
+	}
+	return nil
+}
+
+// writeTemp writes data to the temporary file f and syncs it, so the data
+// is on disk before the rename makes it visible.
+func writeTemp(f *os.File, data []byte) error {
+	if err := f.Chmod(0o644); err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// removeStaleTemps removes the temporary files that writes interrupted by
+// a crash left in dir.
+func removeStaleTemps(dir string, now time.Time) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, ".") || !strings.HasSuffix(name, tempSuffix) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || now.Sub(info.ModTime()) < staleTempAge {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err == nil {
+			log.Printf("[INFO] FS: removed %s, left over from an interrupted write", filepath.Join(dir, name))
+		}
+	}
+}
+
+// collectionPath returns the path of the collection with id.
+func (fs *FilesystemStore) collectionPath(id string) (string, error) {
+	if !validCollectionID(id) {
+		return "", errInvalidCollectionID
+	}
+	return safePath(filepath.Join(fs.dataDir, collectionDir), id+".json")
+}
+
+// StoreCollection implements CollectionStore.
+func (fs *FilesystemStore) StoreCollection(col *models.Collection) error {
+	p, err := fs.collectionPath(col.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(col, "", "  ")
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.readOnly {
+		return ErrReadOnly
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	if err := fs.writeMeta(p, data); err != nil {
+		log.Printf("[ERROR] FS StoreCollection: failed to write %s: %v", col.ID, err)
+		return err
+	}
+	return nil
+}
+
+// GetCollection implements CollectionStore.
+func (fs *FilesystemStore) GetCollection(id string) (*models.Collection, error) {
+	p, err := fs.collectionPath(id)
+	if err != nil {
+		return nil, err
+	}
+	fs.mu.Lock()
+	data, err := readMeta(p)
+	fs.mu.Unlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		log.Printf("[ERROR] FS GetCollection: failed to read %s: %v", id, err)
+		return nil, err
+	}
+	var col models.Collection
+	if err := json.Unmarshal(data, &col); err != nil {
+		log.Printf("[ERROR] FS GetCollection: failed to unmarshal %s: %v", id, err)
+		return nil, err
+	}
+	return &col, nil
+}
+
+// DeleteCollection implements CollectionStore.
+func (fs *FilesystemStore) DeleteCollection(id string) error {
+	p, err := fs.collectionPath(id)
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.readOnly {
+		return ErrReadOnly
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// tokenPaths returns the path of the share token, and indexPath the path
+// of its marker in the index of its paste.
+func (fs *FilesystemStore) tokenPaths(token, slug string) (tokenPath, indexPath string, err error) {
+	if !ValidToken(token) || !utils.IsValidSlug(slug) {
+		return "", "", errInvalidToken
+	}
+	dir := filepath.Join(fs.dataDir, tokenDir)
+	if tokenPath, err = safePath(dir, token+".json"); err != nil {
+		return "", "", err
+	}
+	if indexPath, err = safePath(filepath.Join(dir, slug), token); err != nil {
+		return "", "", err
+	}
+	return tokenPath, indexPath, nil
+}
+
+// StoreToken implements TokenStore.
+func (fs *FilesystemStore) StoreToken(t *models.ShareToken) error {
+	p, idx, err := fs.tokenPaths(t.Token, t.Slug)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.readOnly {
+		return ErrReadOnly
+	}
+	if err := os.MkdirAll(filepath.Dir(idx), 0o755); err != nil {
+		return err
+	}
+	if err := fs.writeMeta(p, data); err != nil {
+		log.Printf("[ERROR] FS StoreToken: failed to write token for %s: %v", t.Slug, err)
+		return err
+	}
+	return os.WriteFile(idx, nil, 0o644) // #nosec G306 -- path sanitised by safePath
+}
+
+// GetToken implements TokenStore.
+func (fs *FilesystemStore) GetToken(token string) (*models.ShareToken, error) {
+	if !ValidToken(token) {
+		return nil, errInvalidToken
+	}
+	p, err := safePath(filepath.Join(fs.dataDir, tokenDir), token+".json")
+	if err != nil {
+		return nil, err
+	}
+	fs.mu.Lock()
+	data, err := readMeta(p)
+	fs.mu.Unlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		log.Printf("[ERROR] FS GetToken: failed to read token: %v", err)
+		return nil, err
+	}
+	var t models.ShareToken
+	if err := json.Unmarshal(data, &t); err != nil {
+		log.Printf("[ERROR] FS GetToken: failed to unmarshal token: %v", err)
+		return nil, err
+	}
+	return &t, nil
+}
+
+// DeleteToken implements TokenStore.
+func (fs *FilesystemStore) DeleteToken(t *models.ShareToken) error {
+	p, idx, err := fs.tokenPaths(t.Token, t.Slug)
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.readOnly {
+		return ErrReadOnly
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(idx); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Drop the index directory once the paste has no tokens left.
+	_ = os.Remove(filepath.Dir(idx))
+	return nil
+}
+
+// ListTokens implements TokenStore. Index markers whose token is gone are
+// skipped.
+func (fs *FilesystemStore) ListTokens(slug string) ([]*models.ShareToken, error) {
+	if !utils.IsValidSlug(slug) {
+		return nil, errInvalidToken
+	}
+	dir, err := safePath(filepath.Join(fs.dataDir, tokenDir), slug)
+	if err != nil {
+		return nil, err
+	}
+	fs.mu.Lock()
+	entries, err := os.ReadDir(dir)
+	fs.mu.Unlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
 		}
-		if err == io.ErrUnexpectedEOF {
-			return buf[:read], nil
+		return nil, err
+	}
+	var tokens []*models.ShareToken
+	for _, e := range entries {
+		t, err := fs.GetToken(e.Name())
+		if err != nil {
+			if errors.Is(err, ErrNotFound) || errors.Is(err, errInvalidToken) {
+				continue
+			}
+			return nil, err
+		}
+		if t.Slug == slug {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+
+// certPath returns the path of the certificate stored as name.
+func (fs *FilesystemStore) certPath(name string) (string, error) {
+	if !validCertName(name) {
+		return "", errInvalidCertName
+	}
+	return safePath(filepath.Join(fs.dataDir, certDir), name)
+}
+
+// GetCert implements CertStore.
+func (fs *FilesystemStore) GetCert(name string) ([]byte, error) {
+	p, err := fs.certPath(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p) // #nosec G304 -- path sanitised by safePath
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
 		}
+		log.Printf("[ERROR] FS GetCert: failed to read %s: %v", name, err)
 		return nil, err
 	}
-	return buf[:read], nil
+	return data, nil
+}
+
+// PutCert implements CertStore. The file is readable by the owner only,
+// since it holds a private key, and replaced atomically so instances
+// sharing the directory never read half a certificate.
+func (fs *FilesystemStore) PutCert(name string, data []byte) error {
+	p, err := fs.certPath(name)
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.readOnly {
+		return ErrReadOnly
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		log.Printf("[ERROR] FS PutCert: failed to write %s: %v", name, err)
+		return err
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		_ = os.Remove(tmp)
+		log.Printf("[ERROR] FS PutCert: failed to write %s: %v", name, err)
+		return err
+	}
+	return nil
+}
+
+// DeleteCert implements CertStore. A missing certificate is not an error.
+func (fs *FilesystemStore) DeleteCert(name string) error {
+	p, err := fs.certPath(name)
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.readOnly {
+		return ErrReadOnly
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
 
 func (fs *FilesystemStore) Close() error {
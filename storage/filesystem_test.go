@@ -1,8 +1,13 @@
 package storage
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/johnwmail/nclip/models"
 )
@@ -12,8 +17,52 @@ func TestNewFilesystemStore_Defaults(t *testing.T) {
 	if err != nil {
 		t.Fatalf("NewFilesystemStore failed: %v", err)
 	}
-	if store.dataDir != "./testdata" {
-		t.Errorf("expected dataDir ./testdata, got %s", store.dataDir)
+	want, _ := filepath.Abs("testdata")
+	if store.dataDir != want {
+		t.Errorf("expected dataDir %s, got %s", want, store.dataDir)
+	}
+}
+
+func TestFilesystemStore_SharedMetadataLocking(t *testing.T) {
+	// Two stores on one directory stand in for two processes: each has its
+	// own mutex, so only the file lock keeps their updates from racing.
+	dir := t.TempDir()
+	a, err := NewFilesystemStore(filepath.ToSlash(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewFilesystemStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Store(&models.Paste{ID: "LCK22", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	const n = 50
+	var wg sync.WaitGroup
+	for _, s := range []*FilesystemStore{a, b} {
+		wg.Add(1)
+		go func(s *FilesystemStore) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if err := s.IncrementReads("LCK22", models.ReadRaw); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := s.Get("LCK22"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(s)
+	}
+	wg.Wait()
+	p, err := a.Get("LCK22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ReadCount != 2*n || p.RawReads != 2*n {
+		t.Errorf("expected %d reads, got read_count=%d raw_reads=%d", 2*n, p.ReadCount, p.RawReads)
 	}
 }
 
@@ -40,8 +89,8 @@ func TestNewFilesystemStore_CreatesDataDir(t *testing.T) {
 		t.Fatalf("NewFilesystemStore failed: %v", err)
 	}
 
-	if store.dataDir != testDir {
-		t.Errorf("expected dataDir %s, got %s", testDir, store.dataDir)
+	if want, _ := filepath.Abs(testDir); store.dataDir != want {
+		t.Errorf("expected dataDir %s, got %s", want, store.dataDir)
 	}
 }
 
@@ -83,3 +132,239 @@ func TestFilesystemStore_Delete_LocalFS(t *testing.T) {
 		t.Errorf("Delete failed: %v", err)
 	}
 }
+
+func TestFilesystemStore_GetBatch(t *testing.T) {
+	store, err := NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystemStore failed: %v", err)
+	}
+	if err := store.Store(&models.Paste{ID: "BATCH", Size: 1}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	results := GetBatch(store, []string{"BATCH", "MISSN"})
+	if r := results["BATCH"]; r.Err != nil || r.Paste == nil || r.Paste.ID != "BATCH" {
+		t.Errorf("expected BATCH metadata, got %+v", r)
+	}
+	if r := results["MISSN"]; !errors.Is(r.Err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for MISSN, got %+v", r)
+	}
+}
+
+func TestFilesystemStore_ListAndTagIndex(t *testing.T) {
+	store, err := NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystemStore failed: %v", err)
+	}
+	for _, p := range []*models.Paste{
+		{ID: "AAAAA", Tags: []string{"work"}},
+		{ID: "BBBBB", Tags: []string{"work", "logs"}},
+		{ID: "CCCCC"},
+	} {
+		if err := store.Store(p); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+	}
+
+	page, err := store.List(ListOptions{Limit: 2})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(page.IDs) != 2 || page.IDs[0] != "AAAAA" || page.NextCursor != "BBBBB" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+	page, err = store.List(ListOptions{Cursor: page.NextCursor, Limit: 2})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(page.IDs) != 1 || page.IDs[0] != "CCCCC" || page.NextCursor != "" {
+		t.Fatalf("unexpected second page %+v", page)
+	}
+
+	page, err = store.List(ListOptions{Tag: "work"})
+	if err != nil {
+		t.Fatalf("List by tag failed: %v", err)
+	}
+	if len(page.IDs) != 2 {
+		t.Fatalf("expected 2 pastes tagged work, got %v", page.IDs)
+	}
+
+	if err := store.Delete("BBBBB"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	page, _ = store.List(ListOptions{Tag: "logs"})
+	if len(page.IDs) != 0 {
+		t.Errorf("expected deleted paste to be unindexed, got %v", page.IDs)
+	}
+	if _, err := store.List(ListOptions{Tag: "../x"}); err == nil {
+		t.Error("expected invalid tag to be rejected")
+	}
+}
+
+func TestFilesystemStore_ReadOnly(t *testing.T) {
+	store, err := NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystemStore failed: %v", err)
+	}
+	expired := time.Now().Add(-time.Hour)
+	if err := store.Store(&models.Paste{ID: "EXPRD", ExpiresAt: &expired}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	store.SetReadOnly(true)
+
+	if _, err := store.Get("EXPRD"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected expired paste to read as not found, got %v", err)
+	}
+	if exists, _ := store.Exists("EXPRD"); !exists {
+		t.Error("read-only store must not clean up expired pastes")
+	}
+	if err := store.Store(&models.Paste{ID: "NEWPS"}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly from Store, got %v", err)
+	}
+	if err := store.Delete("EXPRD"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly from Delete, got %v", err)
+	}
+	if err := store.IncrementReadCount("EXPRD"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly from IncrementReadCount, got %v", err)
+	}
+}
+
+func TestFilesystemStore_MigrateMetadata(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFilesystemStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Metadata as nclip wrote it before schema versions, in local time.
+	old := `{"id":"LEGACY","created_at":"2099-06-01T09:00:00+02:00","expires_at":"2099-06-02T09:00:00+02:00","size":5,"content_type":"text/plain","burn_after_read":false,"read_count":3}`
+	if err := os.WriteFile(filepath.Join(dir, "LEGACY.json"), []byte(old), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Store(&models.Paste{ID: "NEWMETA", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads upgrade old metadata without rewriting it.
+	p, err := store.Get("LEGACY")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if p.SchemaVersion != models.MetadataSchema || p.CreatedAt.Location() != time.UTC || p.ReadCount != 3 {
+		t.Errorf("Get = %+v, want schema %d in UTC", p, models.MetadataSchema)
+	}
+
+	stats, err := store.MigrateMetadata(true)
+	if err != nil || stats != (MigrateStats{Scanned: 2, Upgraded: 1}) {
+		t.Fatalf("dry run = %+v, %v", stats, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "LEGACY.json")); string(data) != old {
+		t.Fatalf("dry run rewrote the metadata: %s", data)
+	}
+
+	stats, err = store.MigrateMetadata(false)
+	if err != nil || stats != (MigrateStats{Scanned: 2, Upgraded: 1}) {
+		t.Fatalf("MigrateMetadata = %+v, %v", stats, err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "LEGACY.json"))
+	if schema, _ := storedSchema(data); schema != models.MetadataSchema {
+		t.Errorf("migrated metadata has schema %d: %s", schema, data)
+	}
+	if !strings.Contains(string(data), `"created_at": "2099-06-01T07:00:00Z"`) || !strings.Contains(string(data), `"read_count": 3`) {
+		t.Errorf("migrated metadata = %s", data)
+	}
+
+	if stats, err := store.MigrateMetadata(false); err != nil || stats.Upgraded != 0 {
+		t.Errorf("second migration = %+v, %v; want nothing to upgrade", stats, err)
+	}
+}
+
+func TestFilesystemStore_CrashConsistency(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFilesystemStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paste := &models.Paste{ID: "CRSH2", CreatedAt: time.Now().UTC(), Size: 5}
+	if err := store.StoreContent(paste.ID, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Store(paste); err != nil {
+		t.Fatal(err)
+	}
+	errCrash := errors.New("crash")
+
+	// A write that dies halfway leaves the old file in place and no
+	// temporary file behind.
+	store.writeTemp = func(f *os.File, data []byte) error {
+		_, _ = f.Write(data[:len(data)/2])
+		return errCrash
+	}
+	if err := store.StoreContent(paste.ID, []byte("goodbye")); !errors.Is(err, errCrash) {
+		t.Fatalf("StoreContent = %v, want the injected fault", err)
+	}
+	if err := store.IncrementReads(paste.ID, models.ReadRaw); !errors.Is(err, errCrash) {
+		t.Fatalf("IncrementReads = %v, want the injected fault", err)
+	}
+	if content, err := store.GetContent(paste.ID); err != nil || string(content) != "hello" {
+		t.Errorf("content after a failed write = %q, %v; want the old content", content, err)
+	}
+	if got, err := store.Get(paste.ID); err != nil || got.ReadCount != 0 {
+		t.Errorf("metadata after a failed write = %+v, %v; want the old metadata", got, err)
+	}
+	store.writeTemp = writeTemp
+
+	// A crash between content and metadata leaves no paste.
+	store.replace = func(oldpath, newpath string) error {
+		if strings.HasSuffix(newpath, ".json") {
+			return errCrash
+		}
+		return replaceFile(oldpath, newpath)
+	}
+	if err := store.StoreContent("CRSH3", []byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Store(&models.Paste{ID: "CRSH3", CreatedAt: time.Now().UTC()}); !errors.Is(err, errCrash) {
+		t.Fatalf("Store = %v, want the injected fault", err)
+	}
+	if _, err := store.Get("CRSH3"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a paste whose metadata was never written = %v, want ErrNotFound", err)
+	}
+	store.replace = replaceFile
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), tempSuffix) {
+			t.Errorf("temporary file %s left behind", e.Name())
+		}
+	}
+
+	// Temporary files of writes a real crash interrupted are invisible to
+	// listings, and removed on open once they are old.
+	stale := filepath.Join(dir, ".CRSH4.json.123"+tempSuffix)
+	fresh := filepath.Join(dir, ".CRSH5.json.456"+tempSuffix)
+	for _, p := range []string{stale, fresh} {
+		if err := os.WriteFile(p, []byte(`{"id": "CRS`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * staleTempAge)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+	page, err := store.List(ListOptions{})
+	if err != nil || len(page.IDs) != 1 || page.IDs[0] != paste.ID {
+		t.Errorf("List = %v, %v; want only %s", page.IDs, err, paste.ID)
+	}
+	if _, err := NewFilesystemStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale temporary file was kept: %v", err)
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Errorf("a temporary file that may belong to a write in progress was removed: %v", err)
+	}
+}
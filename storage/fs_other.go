@@ -0,0 +1,28 @@
+//go:build !unix && !windows
+
+package storage
+
+import "os"
+
+// lockFile is a no-op where the OS has no file locks; the store's mutex
+// still serialises access within the process.
+func lockFile(*os.File, bool) error {
+	return nil
+}
+
+func unlockFile(*os.File) error {
+	return nil
+}
+
+func reservedName(string) bool {
+	return false
+}
+
+func syncDir(string) error {
+	return nil
+}
+
+// replaceFile renames oldpath over newpath, which rename does atomically.
+func replaceFile(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
@@ -0,0 +1,49 @@
+//go:build unix
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile places an advisory flock on f, shared or exclusive, waiting
+// until it is granted.
+func lockFile(f *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	for {
+		err := syscall.Flock(int(f.Fd()), how) // #nosec G115 -- file descriptors fit in an int
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN) // #nosec G115 -- file descriptors fit in an int
+}
+
+// reservedName reports whether name cannot be used as a file name. Every
+// name safePath lets through is usable on Unix.
+func reservedName(string) bool {
+	return false
+}
+
+// syncDir syncs the directory at path, making the entries of files created
+// in it durable.
+func syncDir(path string) error {
+	d, err := os.Open(path) // #nosec G304 -- the store's own directories
+	if err != nil {
+		return err
+	}
+	defer func() { _ = d.Close() }()
+	return d.Sync()
+}
+
+// replaceFile renames oldpath over newpath, which rename does atomically.
+func replaceFile(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
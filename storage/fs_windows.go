@@ -0,0 +1,68 @@
+//go:build windows
+
+package storage
+
+import (
+	"errors"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFile locks all of f with LockFileEx, shared or exclusive, waiting
+// until it is granted. NTFS locks are mandatory: while a writer holds the
+// exclusive lock, reads through other handles fail, so readers take the
+// shared lock too.
+func lockFile(f *os.File, exclusive bool) error {
+	var flags uint32
+	if exclusive {
+		flags = windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	ol := new(windows.Overlapped)
+	return windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, math.MaxUint32, math.MaxUint32, ol)
+}
+
+func unlockFile(f *os.File) error {
+	ol := new(windows.Overlapped)
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, math.MaxUint32, math.MaxUint32, ol)
+}
+
+// reservedName reports whether name is a DOS device name (such as CON or
+// NUL, with or without an extension) or names an alternate data stream.
+// Both are valid slugs or tags but cannot be used as file names.
+func reservedName(name string) bool {
+	if strings.Contains(name, ":") {
+		return true
+	}
+	base, _, _ := strings.Cut(strings.ToUpper(name), ".")
+	switch base {
+	case "CON", "PRN", "AUX", "NUL":
+		return true
+	}
+	if len(base) == 4 && (strings.HasPrefix(base, "COM") || strings.HasPrefix(base, "LPT")) {
+		return base[3] >= '1' && base[3] <= '9'
+	}
+	return false
+}
+
+// syncDir is a no-op: directories cannot be flushed on Windows, and NTFS
+// journals their entries.
+func syncDir(string) error {
+	return nil
+}
+
+// replaceFile renames oldpath over newpath. Windows refuses to replace a
+// file another handle has open, as a reader may for a moment, so the
+// rename is retried for up to a second.
+func replaceFile(oldpath, newpath string) error {
+	for i := 0; ; i++ {
+		err := os.Rename(oldpath, newpath)
+		if err == nil || i == 50 || !errors.Is(err, windows.ERROR_SHARING_VIOLATION) && !errors.Is(err, windows.ERROR_ACCESS_DENIED) {
+			return err
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
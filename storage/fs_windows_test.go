@@ -0,0 +1,22 @@
+//go:build windows
+
+package storage
+
+import "testing"
+
+func TestReservedName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"CON":       true,
+		"nul.json":  true,
+		"COM3":      true,
+		"LPT9.json": true,
+		"ABC:DEF":   true,
+		"CONX":      false,
+		"COMX":      false,
+		"ABCDE":     false,
+	} {
+		if got := reservedName(name); got != want {
+			t.Errorf("reservedName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
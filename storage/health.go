@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// healthBuckets is how many slices a HealthTracker window is split into;
+// the oldest slice is dropped as the window slides.
+const healthBuckets = 10
+
+// minHealthSamples is how many operations a window needs before the
+// backend can be considered degraded, so a single slow or failed request
+// on an idle instance does not trip it.
+const minHealthSamples = 20
+
+// HealthStatus summarizes the storage operations of the current window.
+type HealthStatus struct {
+	Degraded bool `json:"degraded"`
+	// Reason says which threshold was crossed when Degraded is set.
+	Reason       string  `json:"reason,omitempty"`
+	Operations   int     `json:"operations"`
+	ErrorPercent float64 `json:"error_percent"`
+	AvgLatencyMS float64 `json:"avg_latency_ms"`
+}
+
+// HealthTracker watches the outcome and latency of storage operations
+// over a sliding window and reports the backend as degraded when too many
+// fail or they are too slow on average. InstrumentedStore feeds it. It
+// keeps no goroutine, so it works in Lambda, and is safe for concurrent
+// use.
+type HealthTracker struct {
+	maxErrorPercent int
+	maxLatency      time.Duration
+	width           time.Duration
+	now             func() time.Time
+
+	mu       sync.Mutex
+	buckets  [healthBuckets]healthBucket
+	degraded bool
+}
+
+// healthBucket holds the operations of one slice of the window.
+type healthBucket struct {
+	start   time.Time
+	ops     int
+	errors  int
+	latency time.Duration
+}
+
+// NewHealthTracker creates a tracker that reports the backend degraded
+// when more than maxErrorPercent of the operations in window failed, or
+// they took longer than maxLatency on average. Zero disables a threshold.
+func NewHealthTracker(maxErrorPercent int, maxLatency, window time.Duration) *HealthTracker {
+	return &HealthTracker{
+		maxErrorPercent: maxErrorPercent,
+		maxLatency:      maxLatency,
+		width:           max(window/healthBuckets, time.Second),
+		now:             time.Now,
+	}
+}
+
+// Observe records one operation that took d and failed when failed is
+// set.
+func (t *HealthTracker) Observe(d time.Duration, failed bool) {
+	now := t.now()
+	start := now.Truncate(t.width)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := &t.buckets[start.UnixNano()/int64(t.width)%healthBuckets]
+	if !b.start.Equal(start) {
+		*b = healthBucket{start: start}
+	}
+	b.ops++
+	b.latency += d
+	if failed {
+		b.errors++
+	}
+}
+
+// Status returns the health of the backend over the current window,
+// logging when it becomes degraded or recovers.
+func (t *HealthTracker) Status() HealthStatus {
+	now := t.now()
+	oldest := now.Truncate(t.width).Add(-t.width * (healthBuckets - 1))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var ops, errs int
+	var latency time.Duration
+	for _, b := range t.buckets {
+		if b.start.Before(oldest) {
+			continue
+		}
+		ops += b.ops
+		errs += b.errors
+		latency += b.latency
+	}
+	st := HealthStatus{Operations: ops}
+	if ops > 0 {
+		st.ErrorPercent = float64(errs) * 100 / float64(ops)
+		st.AvgLatencyMS = float64(latency) / float64(ops) / float64(time.Millisecond)
+	}
+	if ops >= minHealthSamples {
+		switch {
+		case t.maxErrorPercent > 0 && st.ErrorPercent > float64(t.maxErrorPercent):
+			st.Degraded, st.Reason = true, "error_rate"
+		case t.maxLatency > 0 && latency/time.Duration(ops) > t.maxLatency:
+			st.Degraded, st.Reason = true, "latency"
+		}
+	}
+	if st.Degraded != t.degraded {
+		t.degraded = st.Degraded
+		if st.Degraded {
+			log.Printf("[WARN] Storage backend degraded (%s: %.1f%% errors, %.0fms average over %d operations), shedding uploads",
+				st.Reason, st.ErrorPercent, st.AvgLatencyMS, ops)
+		} else {
+			log.Printf("[INFO] Storage backend recovered, accepting uploads again")
+		}
+	}
+	return st
+}
+
+// RetryAfter is how long clients should wait before retrying a shed
+// request: the time until the oldest slice leaves the window.
+func (t *HealthTracker) RetryAfter() time.Duration {
+	return t.width
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHealthTracker(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	h := NewHealthTracker(50, 200*time.Millisecond, time.Minute)
+	h.now = func() time.Time { return now }
+
+	// Too few operations never count as degraded.
+	for i := 0; i < minHealthSamples-1; i++ {
+		h.Observe(time.Millisecond, true)
+	}
+	if st := h.Status(); st.Degraded {
+		t.Fatalf("degraded after %d operations: %+v", st.Operations, st)
+	}
+	h.Observe(time.Millisecond, true)
+	if st := h.Status(); !st.Degraded || st.Reason != "error_rate" || st.ErrorPercent != 100 {
+		t.Fatalf("expected error_rate degradation, got %+v", st)
+	}
+
+	// Failures age out of the window.
+	now = now.Add(time.Minute)
+	if st := h.Status(); st.Degraded || st.Operations != 0 {
+		t.Fatalf("expected recovery once the window passed, got %+v", st)
+	}
+
+	for i := 0; i < minHealthSamples; i++ {
+		h.Observe(time.Second, false)
+	}
+	if st := h.Status(); !st.Degraded || st.Reason != "latency" || st.AvgLatencyMS != 1000 {
+		t.Fatalf("expected latency degradation, got %+v", st)
+	}
+	for i := 0; i < 10*minHealthSamples; i++ {
+		h.Observe(time.Millisecond, false)
+	}
+	if st := h.Status(); st.Degraded {
+		t.Fatalf("fast operations should bring the average down, got %+v", st)
+	}
+}
+
+func TestInstrumentedStore_Health(t *testing.T) {
+	fs, err := NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystemStore: %v", err)
+	}
+	backend := &flakyStore{FilesystemStore: fs}
+	h := NewHealthTracker(10, 0, time.Minute)
+	s := NewInstrumentedStore(backend, "filesystem", nil)
+	s.SetHealth(h)
+
+	for i := 0; i < minHealthSamples; i++ {
+		if _, err := s.Get("missing"); err == nil {
+			t.Fatal("expected not found")
+		}
+	}
+	if st := h.Status(); st.Degraded || st.Operations != minHealthSamples {
+		t.Fatalf("missing pastes must not count as errors, got %+v", st)
+	}
+	backend.down = true
+	for i := 0; i < minHealthSamples; i++ {
+		_, _ = s.Get("abcde")
+	}
+	if st := h.Status(); !st.Degraded {
+		t.Fatalf("expected degraded backend, got %+v", st)
+	}
+}
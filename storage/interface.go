@@ -2,6 +2,8 @@ package storage
 
 import (
 	"errors"
+	"strconv"
+	"strings"
 
 	"github.com/johnwmail/nclip/models"
 )
@@ -11,6 +13,80 @@ import (
 // so callers can use errors.Is(err, ErrNotFound) regardless of backend.
 var ErrNotFound = errors.New("paste not found")
 
+// ErrReadOnly is returned by mutating methods of a store switched into
+// read-only mode.
+var ErrReadOnly = errors.New("storage is read-only")
+
+// errUnsupported is returned by decorators for optional methods the
+// wrapped backend lacks.
+var errUnsupported = errors.New("storage: operation not supported by backend")
+
+// ReadOnlySetter is implemented by stores that can be switched into a mode
+// that never modifies the backend. Replicas sharing the writer's storage use
+// it so that reads, including lazy cleanup of expired pastes, never write.
+type ReadOnlySetter interface {
+	SetReadOnly(readOnly bool)
+}
+
+// ExpiryHook is implemented by stores that remove expired pastes when
+// they are read. SetOnExpire registers fn to be called with the id of each
+// paste removed that way. fn may be called while the store holds a lock,
+// so it must not call back into the store.
+type ExpiryHook interface {
+	SetOnExpire(fn func(id string))
+}
+
+// Find returns the first store of type T in the decorator chain starting
+// at store, following the Backend method decorators provide.
+func Find[T PasteStore](store PasteStore) (T, bool) {
+	for store != nil {
+		if t, ok := store.(T); ok {
+			return t, true
+		}
+		d, ok := store.(interface{ Backend() PasteStore })
+		if !ok {
+			break
+		}
+		store = d.Backend()
+	}
+	var zero T
+	return zero, false
+}
+
+// PreviewSuffix is appended to a slug to form the id under which its
+// preview image is cached with StoreContent. Delete must remove the
+// preview together with the paste.
+const PreviewSuffix = ".png"
+
+// VersionSuffix separates a slug from a version number in the ids under
+// which earlier contents of a paste are kept with StoreContent. Delete must
+// remove the versions listed in the paste's metadata with the paste.
+const VersionSuffix = ".v"
+
+// VersionID returns the id of version n of slug's content.
+func VersionID(slug string, n int) string {
+	return slug + VersionSuffix + strconv.Itoa(n)
+}
+
+// ParseVersionID splits an id made by VersionID, or the name of its object,
+// into the slug and version number. It reports false for other ids.
+func ParseVersionID(id string) (string, int, bool) {
+	slug, suffix, ok := strings.Cut(id, VersionSuffix)
+	if !ok || slug == "" || suffix == "" {
+		return "", 0, false
+	}
+	for _, r := range suffix {
+		if r < '0' || r > '9' {
+			return "", 0, false
+		}
+	}
+	n, err := strconv.Atoi(suffix)
+	if err != nil {
+		return "", 0, false
+	}
+	return slug, n, true
+}
+
 // PasteStore defines the interface for paste storage backends
 type PasteStore interface {
 	// Store saves a paste to the storage backend
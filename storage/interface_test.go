@@ -67,3 +67,14 @@ func TestPasteStoreInterfaceUsage(t *testing.T) {
 		t.Errorf("Interface Close failed: %v", err)
 	}
 }
+
+func TestParseVersionID(t *testing.T) {
+	if slug, n, ok := ParseVersionID(VersionID("ABCDE", 12)); !ok || slug != "ABCDE" || n != 12 {
+		t.Errorf("ParseVersionID(VersionID(ABCDE, 12)) = %q, %d, %v", slug, n, ok)
+	}
+	for _, id := range []string{"ABCDE", "ABCDE.png", "ABCDE.v", "ABCDE.v+1", "ABCDE.v1x", ".v1"} {
+		if _, _, ok := ParseVersionID(id); ok {
+			t.Errorf("ParseVersionID(%q) should fail", id)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"io"
+
+	"github.com/johnwmail/nclip/internal/changes"
+	"github.com/johnwmail/nclip/models"
+)
+
+// JournaledStore wraps a PasteStore and records every stored or deleted
+// paste in a change journal, which the sync feed serves to mirrors. Read
+// counts and content writes are not recorded: metadata is always stored
+// after content, and mirrors keep their own counts.
+type JournaledStore struct {
+	backend PasteStore
+	journal *changes.Journal
+}
+
+// NewJournaledStore wraps backend, recording changes in journal.
+func NewJournaledStore(backend PasteStore, journal *changes.Journal) *JournaledStore {
+	return &JournaledStore{backend: backend, journal: journal}
+}
+
+// Backend returns the wrapped store.
+func (s *JournaledStore) Backend() PasteStore {
+	return s.backend
+}
+
+// Journal returns the change journal.
+func (s *JournaledStore) Journal() *changes.Journal {
+	return s.journal
+}
+
+// Store implements PasteStore, recording a put.
+func (s *JournaledStore) Store(paste *models.Paste) error {
+	if err := s.backend.Store(paste); err != nil {
+		return err
+	}
+	s.journal.Record(changes.OpPut, paste.ID)
+	return nil
+}
+
+// Get implements PasteStore.
+func (s *JournaledStore) Get(id string) (*models.Paste, error) {
+	return s.backend.Get(id)
+}
+
+// GetBatch implements BatchGetter.
+func (s *JournaledStore) GetBatch(ids []string) map[string]BatchResult {
+	return GetBatch(s.backend, ids)
+}
+
+// Exists implements PasteStore.
+func (s *JournaledStore) Exists(id string) (bool, error) {
+	return s.backend.Exists(id)
+}
+
+// Delete implements PasteStore, recording a delete.
+func (s *JournaledStore) Delete(id string) error {
+	if err := s.backend.Delete(id); err != nil {
+		return err
+	}
+	s.journal.Record(changes.OpDelete, id)
+	return nil
+}
+
+// DeleteBatch implements BatchDeleter, recording a delete for every id
+// the backend deleted.
+func (s *JournaledStore) DeleteBatch(ids []string, progress DeleteProgress) map[string]error {
+	errs := DeleteBatch(s.backend, ids, progress)
+	for _, id := range ids {
+		if _, failed := errs[id]; !failed {
+			s.journal.Record(changes.OpDelete, id)
+		}
+	}
+	return errs
+}
+
+// IncrementReadCount implements PasteStore.
+func (s *JournaledStore) IncrementReadCount(id string) error {
+	return s.backend.IncrementReadCount(id)
+}
+
+// IncrementReads implements ReadCounter.
+func (s *JournaledStore) IncrementReads(id string, kind models.ReadKind) error {
+	return IncrementReads(s.backend, id, kind)
+}
+
+// Close implements PasteStore, closing the journal too.
+func (s *JournaledStore) Close() error {
+	err := s.backend.Close()
+	if jerr := s.journal.Close(); err == nil {
+		err = jerr
+	}
+	return err
+}
+
+// StoreContent implements PasteStore.
+func (s *JournaledStore) StoreContent(id string, content []byte) error {
+	return s.backend.StoreContent(id, content)
+}
+
+// CreateContent implements ContentCreator.
+func (s *JournaledStore) CreateContent(id string, content []byte) (bool, error) {
+	return CreateContent(s.backend, id, content)
+}
+
+// GetContent implements PasteStore.
+func (s *JournaledStore) GetContent(id string) ([]byte, error) {
+	return s.backend.GetContent(id)
+}
+
+// OpenContent implements ContentOpener.
+func (s *JournaledStore) OpenContent(id string) (io.ReadCloser, error) {
+	return OpenContent(s.backend, id)
+}
+
+// GetContentPrefix implements PasteStore.
+func (s *JournaledStore) GetContentPrefix(id string, n int64) ([]byte, error) {
+	return s.backend.GetContentPrefix(id, n)
+}
+
+// StatContent implements PasteStore.
+func (s *JournaledStore) StatContent(id string) (bool, int64, error) {
+	return s.backend.StatContent(id)
+}
+
+// List implements Lister by delegating to the backend.
+func (s *JournaledStore) List(opts ListOptions) (ListPage, error) {
+	l, ok := s.backend.(Lister)
+	if !ok {
+		return ListPage{}, errUnsupported
+	}
+	return l.List(opts)
+}
+
+// ListObjects implements ObjectLister by delegating to the backend.
+func (s *JournaledStore) ListObjects(fn func(Object) error) error {
+	l, ok := s.backend.(ObjectLister)
+	if !ok {
+		return errUnsupported
+	}
+	return l.ListObjects(fn)
+}
+
+// StoreCollection implements CollectionStore by delegating to the backend.
+func (s *JournaledStore) StoreCollection(col *models.Collection) error {
+	cs, ok := s.backend.(CollectionStore)
+	if !ok {
+		return errUnsupported
+	}
+	return cs.StoreCollection(col)
+}
+
+// GetCollection implements CollectionStore by delegating to the backend.
+func (s *JournaledStore) GetCollection(id string) (*models.Collection, error) {
+	cs, ok := s.backend.(CollectionStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return cs.GetCollection(id)
+}
+
+// DeleteCollection implements CollectionStore by delegating to the backend.
+func (s *JournaledStore) DeleteCollection(id string) error {
+	cs, ok := s.backend.(CollectionStore)
+	if !ok {
+		return errUnsupported
+	}
+	return cs.DeleteCollection(id)
+}
+
+// StoreToken implements TokenStore by delegating to the backend.
+func (s *JournaledStore) StoreToken(t *models.ShareToken) error {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return errUnsupported
+	}
+	return ts.StoreToken(t)
+}
+
+// GetToken implements TokenStore by delegating to the backend.
+func (s *JournaledStore) GetToken(token string) (*models.ShareToken, error) {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return ts.GetToken(token)
+}
+
+// DeleteToken implements TokenStore by delegating to the backend.
+func (s *JournaledStore) DeleteToken(t *models.ShareToken) error {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return errUnsupported
+	}
+	return ts.DeleteToken(t)
+}
+
+// ListTokens implements TokenStore by delegating to the backend.
+func (s *JournaledStore) ListTokens(slug string) ([]*models.ShareToken, error) {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return ts.ListTokens(slug)
+}
+
+// GetCert implements CertStore by delegating to the backend.
+func (s *JournaledStore) GetCert(name string) ([]byte, error) {
+	cs, ok := s.backend.(CertStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return cs.GetCert(name)
+}
+
+// PutCert implements CertStore by delegating to the backend.
+func (s *JournaledStore) PutCert(name string, data []byte) error {
+	cs, ok := s.backend.(CertStore)
+	if !ok {
+		return errUnsupported
+	}
+	return cs.PutCert(name, data)
+}
+
+// DeleteCert implements CertStore by delegating to the backend.
+func (s *JournaledStore) DeleteCert(name string) error {
+	cs, ok := s.backend.(CertStore)
+	if !ok {
+		return errUnsupported
+	}
+	return cs.DeleteCert(name)
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/johnwmail/nclip/internal/changes"
+	"github.com/johnwmail/nclip/models"
+)
+
+func TestJournaledStore_Records(t *testing.T) {
+	backend, err := NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal, err := changes.Open(filepath.Join(t.TempDir(), "changes.jsonl"), changes.DefaultRetain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = journal.Close() }()
+	store := NewJournaledStore(backend, journal)
+
+	if err := store.StoreContent("JRNL", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Store(&models.Paste{ID: "JRNL"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := IncrementReads(store, "JRNL", models.ReadView); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("JRNL"); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := journal.Since(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Changes) != 2 || page.Changes[0].Op != changes.OpPut || page.Changes[1].Op != changes.OpDelete || page.Changes[1].Slug != "JRNL" {
+		t.Fatalf("expected a put and a delete, got %+v", page.Changes)
+	}
+}
+
+func TestFind(t *testing.T) {
+	backend, err := NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal, err := changes.Open(filepath.Join(t.TempDir(), "changes.jsonl"), changes.DefaultRetain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = journal.Close() }()
+	journaled := NewJournaledStore(backend, journal)
+	spool, err := NewSpoolStore(journaled, filepath.Join(t.TempDir(), "spool"), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, ok := Find[*JournaledStore](spool); !ok || got != journaled {
+		t.Errorf("expected to find the journaled store below the spool, got %v %v", got, ok)
+	}
+	if got, ok := Find[*FilesystemStore](spool); !ok || got != backend {
+		t.Errorf("expected to find the backend, got %v %v", got, ok)
+	}
+	if _, ok := Find[*EncryptedStore](spool); ok {
+		t.Error("expected no encrypted store in the chain")
+	}
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"errors"
+
+	"github.com/johnwmail/nclip/utils"
+)
+
+// DefaultListLimit is used when ListOptions.Limit is not positive.
+const DefaultListLimit = 50
+
+// tagIndexDir is the directory (filesystem) or key prefix (S3) holding the
+// per-tag slug index. The leading dot keeps it out of the slug namespace.
+const tagIndexDir = ".tags"
+
+// checksumIndexDir holds the index of pastes by models.Paste.SHA256 the
+// same way.
+const checksumIndexDir = ".sha256"
+
+// ListOptions selects a page of slugs from a Lister.
+type ListOptions struct {
+	// Tag restricts the listing to pastes carrying this tag.
+	Tag string
+	// Checksum restricts the listing to pastes whose content has this hex
+	// SHA-256, if they recorded it. It cannot be combined with Tag.
+	Checksum string
+	// Cursor is the NextCursor of the previous page; empty starts at the
+	// beginning.
+	Cursor string
+	// Limit is the maximum number of slugs to return.
+	Limit int
+}
+
+// ListPage is one page of slugs in ascending order.
+type ListPage struct {
+	IDs []string
+	// NextCursor is empty when there are no further pages.
+	NextCursor string
+}
+
+// Lister is implemented by stores that can enumerate pastes. Tag and
+// checksum listings are served from an index and may include slugs of
+// pastes that expired or changed since they were indexed; callers should
+// re-check metadata.
+type Lister interface {
+	List(opts ListOptions) (ListPage, error)
+}
+
+// errInvalidTag is returned when a tag used as an index key is malformed.
+var errInvalidTag = errors.New("invalid tag")
+
+// errInvalidChecksum is returned for a checksum listing by anything but a
+// lowercase hex SHA-256, or combined with a tag.
+var errInvalidChecksum = errors.New("invalid checksum listing")
+
+// checkChecksumListing validates the checksum of opts, if any.
+func checkChecksumListing(opts ListOptions) error {
+	if opts.Checksum != "" && (opts.Tag != "" || !utils.IsValidChecksum(opts.Checksum)) {
+		return errInvalidChecksum
+	}
+	return nil
+}
+
+// pageFromSorted builds a page from ids sorted ascending, skipping ids at or
+// before cursor.
+func pageFromSorted(ids []string, cursor string, limit int) ListPage {
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	page := ListPage{}
+	for _, id := range ids {
+		if cursor != "" && id <= cursor {
+			continue
+		}
+		if len(page.IDs) == limit {
+			page.NextCursor = page.IDs[limit-1]
+			break
+		}
+		page.IDs = append(page.IDs, id)
+	}
+	return page
+}
@@ -0,0 +1,523 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// memoryObject is a stored metadata document or content blob.
+type memoryObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+// MemoryStore keeps pastes in process memory. It behaves like the
+// filesystem store, expiry and the optional interfaces included, so it
+// suits tests and ephemeral instances for demos and CI; everything is lost
+// when the process exits.
+//
+// Metadata is kept as JSON, as the other backends keep it, so callers
+// never share a *models.Paste with the store and pastes come back in the
+// current schema.
+type MemoryStore struct {
+	mu       sync.Mutex
+	readOnly bool
+	onExpire func(id string)
+	meta     map[string]memoryObject
+	content  map[string]memoryObject
+	// tags maps each tag to the slugs carrying it, sums each checksum to
+	// the slugs whose content has it.
+	tags        map[string]map[string]bool
+	sums        map[string]map[string]bool
+	collections map[string][]byte
+	tokens      map[string][]byte
+	certs       map[string][]byte
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		meta:        map[string]memoryObject{},
+		content:     map[string]memoryObject{},
+		tags:        map[string]map[string]bool{},
+		sums:        map[string]map[string]bool{},
+		collections: map[string][]byte{},
+		tokens:      map[string][]byte{},
+		certs:       map[string][]byte{},
+	}
+}
+
+// validMemoryID rejects the ids the filesystem store cannot hold, so code
+// tested against a MemoryStore does not pass ids other backends refuse.
+func validMemoryID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, "/\\") && !strings.Contains(id, "..")
+}
+
+// SetReadOnly implements ReadOnlySetter.
+func (m *MemoryStore) SetReadOnly(readOnly bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.readOnly = readOnly
+}
+
+// SetOnExpire implements ExpiryHook.
+func (m *MemoryStore) SetOnExpire(fn func(id string)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onExpire = fn
+}
+
+// Store saves the paste metadata and indexes its tags and checksum.
+func (m *MemoryStore) Store(paste *models.Paste) error {
+	if !validMemoryID(paste.ID) {
+		return errUnsafeID
+	}
+	for _, tag := range paste.Tags {
+		if !utils.IsValidTag(tag) {
+			return errInvalidTag
+		}
+	}
+	data, err := json.Marshal(paste)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	if old, err := m.decodeLocked(paste.ID); err == nil {
+		m.unindexLocked(old)
+	}
+	m.meta[paste.ID] = memoryObject{data: data, modTime: time.Now()}
+	for _, tag := range paste.Tags {
+		addToIndex(m.tags, tag, paste.ID)
+	}
+	if utils.IsValidChecksum(paste.SHA256) {
+		addToIndex(m.sums, paste.SHA256, paste.ID)
+	}
+	return nil
+}
+
+// addToIndex records id under key in index.
+func addToIndex(index map[string]map[string]bool, key, id string) {
+	if index[key] == nil {
+		index[key] = map[string]bool{}
+	}
+	index[key][id] = true
+}
+
+// removeFromIndex removes id from under key in index.
+func removeFromIndex(index map[string]map[string]bool, key, id string) {
+	delete(index[key], id)
+	if len(index[key]) == 0 {
+		delete(index, key)
+	}
+}
+
+// Get returns the paste with id, removing it if it expired.
+func (m *MemoryStore) Get(id string) (*models.Paste, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.getLocked(id)
+}
+
+// GetBatch implements BatchGetter.
+func (m *MemoryStore) GetBatch(ids []string) map[string]BatchResult {
+	results := make(map[string]BatchResult, len(ids))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		results[id] = batchResult(m.getLocked(id))
+	}
+	return results
+}
+
+// getLocked is Get. Callers must hold m.mu.
+func (m *MemoryStore) getLocked(id string) (*models.Paste, error) {
+	paste, err := m.decodeLocked(id)
+	if err != nil {
+		return nil, err
+	}
+	if paste.IsExpired() {
+		if !m.readOnly {
+			m.deleteLocked(paste)
+			if m.onExpire != nil {
+				m.onExpire(id)
+			}
+		}
+		return nil, ErrNotFound
+	}
+	return paste, nil
+}
+
+// decodeLocked returns the stored metadata of id, expired or not. Callers
+// must hold m.mu.
+func (m *MemoryStore) decodeLocked(id string) (*models.Paste, error) {
+	obj, ok := m.meta[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var paste models.Paste
+	if err := json.Unmarshal(obj.data, &paste); err != nil {
+		return nil, err
+	}
+	return &paste, nil
+}
+
+// Exists reports whether metadata is stored for id.
+func (m *MemoryStore) Exists(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.meta[id]
+	return ok, nil
+}
+
+// Delete removes the paste with its content, preview and versions.
+// Deleting a missing paste is not an error.
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	paste, err := m.decodeLocked(id)
+	if err != nil {
+		paste = &models.Paste{ID: id}
+	}
+	m.deleteLocked(paste)
+	return nil
+}
+
+// deleteLocked removes paste and everything stored with it. Callers must
+// hold m.mu.
+func (m *MemoryStore) deleteLocked(paste *models.Paste) {
+	m.unindexLocked(paste)
+	for _, v := range paste.Versions {
+		delete(m.content, VersionID(paste.ID, v.Number))
+	}
+	delete(m.meta, paste.ID)
+	delete(m.content, paste.ID)
+	delete(m.content, paste.ID+PreviewSuffix)
+}
+
+// unindexLocked removes paste from the index of each of its tags and
+// of its checksum. Callers must hold m.mu.
+func (m *MemoryStore) unindexLocked(paste *models.Paste) {
+	for _, tag := range paste.Tags {
+		removeFromIndex(m.tags, tag, paste.ID)
+	}
+	if paste.SHA256 != "" {
+		removeFromIndex(m.sums, paste.SHA256, paste.ID)
+	}
+}
+
+// List implements Lister.
+func (m *MemoryStore) List(opts ListOptions) (ListPage, error) {
+	if opts.Tag != "" && !utils.IsValidTag(opts.Tag) {
+		return ListPage{}, errInvalidTag
+	}
+	if err := checkChecksumListing(opts); err != nil {
+		return ListPage{}, err
+	}
+	m.mu.Lock()
+	var ids []string
+	if opts.Tag != "" {
+		for id := range m.tags[opts.Tag] {
+			ids = append(ids, id)
+		}
+	} else if opts.Checksum != "" {
+		for id := range m.sums[opts.Checksum] {
+			ids = append(ids, id)
+		}
+	} else {
+		for id := range m.meta {
+			if isPasteKey(id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	m.mu.Unlock()
+	sort.Strings(ids)
+	return pageFromSorted(ids, opts.Cursor, opts.Limit), nil
+}
+
+// ListObjects implements ObjectLister, naming metadata "<id>.json" as the
+// filesystem store does.
+func (m *MemoryStore) ListObjects(fn func(Object) error) error {
+	m.mu.Lock()
+	objects := make([]Object, 0, len(m.meta)+len(m.content))
+	for id, obj := range m.meta {
+		objects = append(objects, Object{Name: id + ".json", Size: int64(len(obj.data)), ModTime: obj.modTime})
+	}
+	for id, obj := range m.content {
+		objects = append(objects, Object{Name: id, Size: int64(len(obj.data)), ModTime: obj.modTime})
+	}
+	m.mu.Unlock()
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	for _, obj := range objects {
+		if strings.HasPrefix(obj.Name, ".") {
+			continue
+		}
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IncrementReadCount increments the read count of the paste with id.
+func (m *MemoryStore) IncrementReadCount(id string) error {
+	return m.IncrementReads(id, "")
+}
+
+// IncrementReads implements ReadCounter.
+func (m *MemoryStore) IncrementReads(id string, kind models.ReadKind) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	paste, err := m.decodeLocked(id)
+	if err != nil {
+		return err
+	}
+	paste.RecordRead(kind)
+	data, err := json.Marshal(paste)
+	if err != nil {
+		return err
+	}
+	m.meta[id] = memoryObject{data: data, modTime: time.Now()}
+	return nil
+}
+
+// StoreContent saves a copy of content under id, replacing what was there.
+func (m *MemoryStore) StoreContent(id string, content []byte) error {
+	if !validMemoryID(id) {
+		return errUnsafeID
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	m.content[id] = memoryObject{data: append([]byte{}, content...), modTime: time.Now()}
+	return nil
+}
+
+// CreateContent implements ContentCreator.
+func (m *MemoryStore) CreateContent(id string, content []byte) (bool, error) {
+	if !validMemoryID(id) {
+		return false, errUnsafeID
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.readOnly {
+		return false, ErrReadOnly
+	}
+	if _, ok := m.content[id]; ok {
+		return false, nil
+	}
+	m.content[id] = memoryObject{data: append([]byte{}, content...), modTime: time.Now()}
+	return true, nil
+}
+
+// GetContent returns a copy of the content stored under id.
+func (m *MemoryStore) GetContent(id string) ([]byte, error) {
+	return m.GetContentPrefix(id, -1)
+}
+
+// GetContentPrefix returns a copy of up to n bytes of the content stored
+// under id; a negative n returns all of it.
+func (m *MemoryStore) GetContentPrefix(id string, n int64) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, ok := m.content[id]
+	if !ok {
+		return nil, fmt.Errorf("content of %s: %w", id, ErrNotFound)
+	}
+	data := obj.data
+	if n >= 0 && int64(len(data)) > n {
+		data = data[:n]
+	}
+	return append([]byte{}, data...), nil
+}
+
+// StatContent reports whether content is stored under id and its size.
+func (m *MemoryStore) StatContent(id string) (bool, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, ok := m.content[id]
+	return ok, int64(len(obj.data)), nil
+}
+
+// StoreCollection implements CollectionStore.
+func (m *MemoryStore) StoreCollection(col *models.Collection) error {
+	if !validCollectionID(col.ID) {
+		return errInvalidCollectionID
+	}
+	data, err := json.Marshal(col)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	m.collections[col.ID] = data
+	return nil
+}
+
+// GetCollection implements CollectionStore.
+func (m *MemoryStore) GetCollection(id string) (*models.Collection, error) {
+	m.mu.Lock()
+	data, ok := m.collections[id]
+	m.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var col models.Collection
+	if err := json.Unmarshal(data, &col); err != nil {
+		return nil, err
+	}
+	return &col, nil
+}
+
+// DeleteCollection implements CollectionStore.
+func (m *MemoryStore) DeleteCollection(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	delete(m.collections, id)
+	return nil
+}
+
+// StoreToken implements TokenStore.
+func (m *MemoryStore) StoreToken(t *models.ShareToken) error {
+	if !ValidToken(t.Token) || !utils.IsValidSlug(t.Slug) {
+		return errInvalidToken
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	m.tokens[t.Token] = data
+	return nil
+}
+
+// GetToken implements TokenStore.
+func (m *MemoryStore) GetToken(token string) (*models.ShareToken, error) {
+	if !ValidToken(token) {
+		return nil, errInvalidToken
+	}
+	m.mu.Lock()
+	data, ok := m.tokens[token]
+	m.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var t models.ShareToken
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// DeleteToken implements TokenStore.
+func (m *MemoryStore) DeleteToken(t *models.ShareToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	delete(m.tokens, t.Token)
+	return nil
+}
+
+// ListTokens implements TokenStore.
+func (m *MemoryStore) ListTokens(slug string) ([]*models.ShareToken, error) {
+	if !utils.IsValidSlug(slug) {
+		return nil, errInvalidToken
+	}
+	m.mu.Lock()
+	var names []string
+	for token := range m.tokens {
+		names = append(names, token)
+	}
+	m.mu.Unlock()
+	sort.Strings(names)
+	var tokens []*models.ShareToken
+	for _, name := range names {
+		t, err := m.GetToken(name)
+		if err != nil {
+			// Deleted since it was listed.
+			continue
+		}
+		if t.Slug == slug {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+
+// GetCert implements CertStore.
+func (m *MemoryStore) GetCert(name string) ([]byte, error) {
+	if !validCertName(name) {
+		return nil, errInvalidCertName
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.certs[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, data...), nil
+}
+
+// PutCert implements CertStore.
+func (m *MemoryStore) PutCert(name string, data []byte) error {
+	if !validCertName(name) {
+		return errInvalidCertName
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	m.certs[name] = append([]byte{}, data...)
+	return nil
+}
+
+// DeleteCert implements CertStore. A missing certificate is not an error.
+func (m *MemoryStore) DeleteCert(name string) error {
+	if !validCertName(name) {
+		return errInvalidCertName
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	delete(m.certs, name)
+	return nil
+}
+
+// Close implements PasteStore. The pastes stay readable.
+func (m *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,378 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/johnwmail/nclip/models"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Operation labels recorded by InstrumentedStore.
+const (
+	opStore  = "store"
+	opGet    = "get"
+	opDelete = "delete"
+	opStat   = "stat"
+	opPrefix = "prefix"
+	opList   = "list"
+)
+
+var instrumentedOps = []string{opStore, opGet, opDelete, opStat, opPrefix, opList}
+
+// StoreMetrics holds the collectors shared by all instrumented stores.
+type StoreMetrics struct {
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+}
+
+// NewStoreMetrics creates the storage collectors and registers them with reg.
+func NewStoreMetrics(reg prometheus.Registerer) *StoreMetrics {
+	m := &StoreMetrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "storage_operation_duration_seconds",
+			Help: "Latency of storage backend operations.",
+			// Local disk answers in well under a millisecond, S3 in tens to
+			// hundreds; the buckets cover both.
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"backend", "operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "storage_errors_total",
+			Help: "Storage backend operations that failed. Missing pastes are not errors.",
+		}, []string{"backend", "operation"}),
+	}
+	reg.MustRegister(m.duration, m.errors)
+	return m
+}
+
+// InstrumentedStore wraps a PasteStore and records the latency and errors
+// of every operation, labeled with the backend name, and feeds them to a
+// HealthTracker when one is set.
+type InstrumentedStore struct {
+	backend PasteStore
+	name    string
+	metrics *StoreMetrics
+	health  *HealthTracker
+}
+
+// NewInstrumentedStore wraps backend, reporting to m under the given
+// backend name (e.g. "filesystem" or "s3"). m may be nil when only a
+// HealthTracker watches the store.
+func NewInstrumentedStore(backend PasteStore, name string, m *StoreMetrics) *InstrumentedStore {
+	// Export zero-valued error series up front so alerts on rate() see
+	// every backend/operation pair before its first failure.
+	if m != nil {
+		for _, op := range instrumentedOps {
+			m.errors.WithLabelValues(name, op)
+		}
+	}
+	return &InstrumentedStore{backend: backend, name: name, metrics: m}
+}
+
+// SetHealth makes the store report every operation to h. It must be
+// called before the store is shared between goroutines.
+func (s *InstrumentedStore) SetHealth(h *HealthTracker) {
+	s.health = h
+}
+
+// Health returns the tracker set with SetHealth, or nil.
+func (s *InstrumentedStore) Health() *HealthTracker {
+	return s.health
+}
+
+// Backend returns the wrapped store.
+func (s *InstrumentedStore) Backend() PasteStore {
+	return s.backend
+}
+
+// observe records one operation that started at start and returned err.
+func (s *InstrumentedStore) observe(op string, start time.Time, err error) {
+	d := time.Since(start)
+	failed := err != nil && !errors.Is(err, ErrNotFound)
+	if s.metrics != nil {
+		s.metrics.duration.WithLabelValues(s.name, op).Observe(d.Seconds())
+		if failed {
+			s.metrics.errors.WithLabelValues(s.name, op).Inc()
+		}
+	}
+	if s.health != nil {
+		s.health.Observe(d, failed)
+	}
+}
+
+// Store implements PasteStore.
+func (s *InstrumentedStore) Store(paste *models.Paste) error {
+	start := time.Now()
+	err := s.backend.Store(paste)
+	s.observe(opStore, start, err)
+	return err
+}
+
+// Get implements PasteStore.
+func (s *InstrumentedStore) Get(id string) (*models.Paste, error) {
+	start := time.Now()
+	paste, err := s.backend.Get(id)
+	s.observe(opGet, start, err)
+	return paste, err
+}
+
+// GetBatch implements BatchGetter, recording the whole batch as one get.
+func (s *InstrumentedStore) GetBatch(ids []string) map[string]BatchResult {
+	start := time.Now()
+	results := GetBatch(s.backend, ids)
+	var err error
+	for _, r := range results {
+		if r.Err != nil && !errors.Is(r.Err, ErrNotFound) {
+			err = r.Err
+			break
+		}
+	}
+	s.observe(opGet, start, err)
+	return results
+}
+
+// Exists implements PasteStore.
+func (s *InstrumentedStore) Exists(id string) (bool, error) {
+	start := time.Now()
+	ok, err := s.backend.Exists(id)
+	s.observe(opStat, start, err)
+	return ok, err
+}
+
+// Delete implements PasteStore.
+func (s *InstrumentedStore) Delete(id string) error {
+	start := time.Now()
+	err := s.backend.Delete(id)
+	s.observe(opDelete, start, err)
+	return err
+}
+
+// DeleteBatch implements BatchDeleter, recording the whole batch as one
+// delete.
+func (s *InstrumentedStore) DeleteBatch(ids []string, progress DeleteProgress) map[string]error {
+	start := time.Now()
+	errs := DeleteBatch(s.backend, ids, progress)
+	var err error
+	for _, e := range errs {
+		err = e
+		break
+	}
+	s.observe(opDelete, start, err)
+	return errs
+}
+
+// IncrementReadCount implements PasteStore. It rewrites the metadata and
+// is recorded as a store.
+func (s *InstrumentedStore) IncrementReadCount(id string) error {
+	start := time.Now()
+	err := s.backend.IncrementReadCount(id)
+	s.observe(opStore, start, err)
+	return err
+}
+
+// IncrementReads implements ReadCounter and is recorded as a store.
+func (s *InstrumentedStore) IncrementReads(id string, kind models.ReadKind) error {
+	start := time.Now()
+	err := IncrementReads(s.backend, id, kind)
+	s.observe(opStore, start, err)
+	return err
+}
+
+// Close implements PasteStore.
+func (s *InstrumentedStore) Close() error {
+	return s.backend.Close()
+}
+
+// StoreContent implements PasteStore.
+func (s *InstrumentedStore) StoreContent(id string, content []byte) error {
+	start := time.Now()
+	err := s.backend.StoreContent(id, content)
+	s.observe(opStore, start, err)
+	return err
+}
+
+// CreateContent implements ContentCreator.
+func (s *InstrumentedStore) CreateContent(id string, content []byte) (bool, error) {
+	start := time.Now()
+	created, err := CreateContent(s.backend, id, content)
+	s.observe(opStore, start, err)
+	return created, err
+}
+
+// GetContent implements PasteStore.
+func (s *InstrumentedStore) GetContent(id string) ([]byte, error) {
+	start := time.Now()
+	content, err := s.backend.GetContent(id)
+	s.observe(opGet, start, err)
+	return content, err
+}
+
+// OpenContent implements ContentOpener. Only opening is timed, not the
+// reads that follow.
+func (s *InstrumentedStore) OpenContent(id string) (io.ReadCloser, error) {
+	start := time.Now()
+	r, err := OpenContent(s.backend, id)
+	s.observe(opGet, start, err)
+	return r, err
+}
+
+// GetContentPrefix implements PasteStore.
+func (s *InstrumentedStore) GetContentPrefix(id string, n int64) ([]byte, error) {
+	start := time.Now()
+	content, err := s.backend.GetContentPrefix(id, n)
+	s.observe(opPrefix, start, err)
+	return content, err
+}
+
+// StatContent implements PasteStore.
+func (s *InstrumentedStore) StatContent(id string) (bool, int64, error) {
+	start := time.Now()
+	exists, size, err := s.backend.StatContent(id)
+	s.observe(opStat, start, err)
+	return exists, size, err
+}
+
+// List implements Lister by delegating to the backend.
+func (s *InstrumentedStore) List(opts ListOptions) (ListPage, error) {
+	l, ok := s.backend.(Lister)
+	if !ok {
+		return ListPage{}, errUnsupported
+	}
+	start := time.Now()
+	page, err := l.List(opts)
+	s.observe(opList, start, err)
+	return page, err
+}
+
+// ListObjects implements ObjectLister by delegating to the backend.
+func (s *InstrumentedStore) ListObjects(fn func(Object) error) error {
+	l, ok := s.backend.(ObjectLister)
+	if !ok {
+		return errUnsupported
+	}
+	start := time.Now()
+	err := l.ListObjects(fn)
+	s.observe(opList, start, err)
+	return err
+}
+
+// StoreCollection implements CollectionStore by delegating to the backend.
+func (s *InstrumentedStore) StoreCollection(col *models.Collection) error {
+	cs, ok := s.backend.(CollectionStore)
+	if !ok {
+		return errUnsupported
+	}
+	start := time.Now()
+	err := cs.StoreCollection(col)
+	s.observe(opStore, start, err)
+	return err
+}
+
+// GetCollection implements CollectionStore by delegating to the backend.
+func (s *InstrumentedStore) GetCollection(id string) (*models.Collection, error) {
+	cs, ok := s.backend.(CollectionStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	start := time.Now()
+	col, err := cs.GetCollection(id)
+	s.observe(opGet, start, err)
+	return col, err
+}
+
+// DeleteCollection implements CollectionStore by delegating to the backend.
+func (s *InstrumentedStore) DeleteCollection(id string) error {
+	cs, ok := s.backend.(CollectionStore)
+	if !ok {
+		return errUnsupported
+	}
+	start := time.Now()
+	err := cs.DeleteCollection(id)
+	s.observe(opDelete, start, err)
+	return err
+}
+
+// StoreToken implements TokenStore by delegating to the backend.
+func (s *InstrumentedStore) StoreToken(t *models.ShareToken) error {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return errUnsupported
+	}
+	start := time.Now()
+	err := ts.StoreToken(t)
+	s.observe(opStore, start, err)
+	return err
+}
+
+// GetToken implements TokenStore by delegating to the backend.
+func (s *InstrumentedStore) GetToken(token string) (*models.ShareToken, error) {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	start := time.Now()
+	t, err := ts.GetToken(token)
+	s.observe(opGet, start, err)
+	return t, err
+}
+
+// DeleteToken implements TokenStore by delegating to the backend.
+func (s *InstrumentedStore) DeleteToken(t *models.ShareToken) error {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return errUnsupported
+	}
+	start := time.Now()
+	err := ts.DeleteToken(t)
+	s.observe(opDelete, start, err)
+	return err
+}
+
+// ListTokens implements TokenStore by delegating to the backend.
+func (s *InstrumentedStore) ListTokens(slug string) ([]*models.ShareToken, error) {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	start := time.Now()
+	tokens, err := ts.ListTokens(slug)
+	s.observe(opList, start, err)
+	return tokens, err
+}
+
+// GetCert implements CertStore by delegating to the backend.
+func (s *InstrumentedStore) GetCert(name string) ([]byte, error) {
+	cs, ok := s.backend.(CertStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	start := time.Now()
+	data, err := cs.GetCert(name)
+	s.observe(opGet, start, err)
+	return data, err
+}
+
+// PutCert implements CertStore by delegating to the backend.
+func (s *InstrumentedStore) PutCert(name string, data []byte) error {
+	cs, ok := s.backend.(CertStore)
+	if !ok {
+		return errUnsupported
+	}
+	start := time.Now()
+	err := cs.PutCert(name, data)
+	s.observe(opStore, start, err)
+	return err
+}
+
+// DeleteCert implements CertStore by delegating to the backend.
+func (s *InstrumentedStore) DeleteCert(name string) error {
+	cs, ok := s.backend.(CertStore)
+	if !ok {
+		return errUnsupported
+	}
+	start := time.Now()
+	err := cs.DeleteCert(name)
+	s.observe(opDelete, start, err)
+	return err
+}
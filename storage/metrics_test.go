@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentedStore(t *testing.T) {
+	fs, err := NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystemStore: %v", err)
+	}
+	backend := &flakyStore{FilesystemStore: fs}
+	reg := prometheus.NewRegistry()
+	metrics := NewStoreMetrics(reg)
+	s := NewInstrumentedStore(backend, "filesystem", metrics)
+
+	spoolPaste(t, s, "abcde", "hello")
+	if _, err := s.Get("missing"); err == nil {
+		t.Fatal("expected not found")
+	}
+	if _, err := s.GetContentPrefix("abcde", 2); err != nil {
+		t.Fatalf("GetContentPrefix: %v", err)
+	}
+	backend.down = true
+	if _, err := s.Get("abcde"); err == nil {
+		t.Fatal("expected backend error")
+	}
+
+	if got := testutil.ToFloat64(metrics.errors.WithLabelValues("filesystem", opGet)); got != 1 {
+		t.Errorf("get errors = %v, want 1 (not-found must not count)", got)
+	}
+	if got := testutil.ToFloat64(metrics.errors.WithLabelValues("filesystem", opStore)); got != 0 {
+		t.Errorf("store errors = %v, want 0", got)
+	}
+
+	want := `
+# HELP storage_errors_total Storage backend operations that failed. Missing pastes are not errors.
+# TYPE storage_errors_total counter
+storage_errors_total{backend="filesystem",operation="delete"} 0
+storage_errors_total{backend="filesystem",operation="get"} 1
+storage_errors_total{backend="filesystem",operation="list"} 0
+storage_errors_total{backend="filesystem",operation="prefix"} 0
+storage_errors_total{backend="filesystem",operation="stat"} 0
+storage_errors_total{backend="filesystem",operation="store"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "storage_errors_total"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(metrics.duration); n != 3 {
+		t.Errorf("expected latency series for store, get and prefix, got %d", n)
+	}
+}
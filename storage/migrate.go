@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// MigrateStats counts the pastes a metadata migration visited.
+type MigrateStats struct {
+	Scanned  int
+	Upgraded int
+	Failed   int
+}
+
+// MetadataMigrator is implemented by stores that can rewrite the stored
+// metadata of older schemas in the current one. Stores upgrade metadata
+// as they read it either way; migrating makes the stored objects current,
+// so an nclip without the upgrade code can read them.
+type MetadataMigrator interface {
+	// MigrateMetadata rewrites the metadata of every paste stored with a
+	// schema older than models.MetadataSchema. With dryRun set it only
+	// counts them. Pastes that fail are logged and counted; the error is
+	// for failures that stop the migration, such as a failed listing.
+	MigrateMetadata(dryRun bool) (MigrateStats, error)
+}
+
+// storedSchema returns the schema version of raw paste metadata without
+// upgrading it.
+func storedSchema(data []byte) (int, error) {
+	var meta struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	err := json.Unmarshal(data, &meta)
+	return meta.SchemaVersion, err
+}
+
+// migrateListed runs migrate on every paste l lists, for stores whose
+// metadata objects are rewritten one at a time.
+func migrateListed(l Lister, migrate func(id string) (bool, error)) (MigrateStats, error) {
+	var stats MigrateStats
+	cursor := ""
+	for {
+		page, err := l.List(ListOptions{Cursor: cursor, Limit: 1000})
+		if err != nil {
+			return stats, err
+		}
+		for _, id := range page.IDs {
+			stats.Scanned++
+			upgraded, err := migrate(id)
+			switch {
+			case err != nil:
+				log.Printf("[ERROR] Migrate: failed to upgrade metadata of %s: %v", id, err)
+				stats.Failed++
+			case upgraded:
+				stats.Upgraded++
+			}
+		}
+		if page.NextCursor == "" {
+			return stats, nil
+		}
+		cursor = page.NextCursor
+	}
+}
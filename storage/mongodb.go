@@ -0,0 +1,772 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/egress"
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/utils"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	// mongoTimeout bounds a single metadata or content operation.
+	mongoTimeout = 10 * time.Second
+	// mongoInlineLimit is the largest content kept inside its document;
+	// larger content goes to GridFS, as documents are limited to 16 MiB.
+	mongoInlineLimit = 1 << 20
+	// mongoChunkSize is the GridFS chunk size, the default of the driver.
+	mongoChunkSize = 255 * 1024
+	// mongoPurgeDelay is how long after expiry the TTL index removes the
+	// metadata of a paste that was never read again. Reads remove expired
+	// pastes right away; the delay keeps the TTL monitor from racing them.
+	mongoPurgeDelay = time.Hour
+)
+
+// MongoStore stores paste metadata in the "pastes" collection of a MongoDB
+// database and content in the "contents" collection, moving content larger
+// than 1 MiB to the "content" GridFS bucket. A TTL index removes the
+// metadata of expired pastes nobody reads; their content is left to the
+// orphan sweep.
+type MongoStore struct {
+	client   *mongo.Client
+	pastes   *mongo.Collection
+	contents *mongo.Collection
+	files    *mongo.GridFSBucket
+	// tokens, collections and certs hold one document per share token,
+	// collection and certificate, keyed by token, id and name.
+	tokens      *mongo.Collection
+	collections *mongo.Collection
+	certs       *mongo.Collection
+	readOnly    bool
+	onExpire    func(id string)
+}
+
+// mongoPaste is the document of a paste's metadata.
+type mongoPaste struct {
+	models.Paste `bson:",inline"`
+	// PurgeAt is indexed with a TTL. It is unset for pastes that never
+	// expire.
+	PurgeAt    *time.Time `bson:"purge_at,omitempty"`
+	ModifiedAt time.Time  `bson:"modified_at"`
+}
+
+// mongoContent is the document of a paste's content. Data holds the
+// content unless FileID names the GridFS file holding it.
+type mongoContent struct {
+	ID         string         `bson:"_id"`
+	Size       int64          `bson:"size"`
+	Data       []byte         `bson:"data,omitempty"`
+	FileID     *bson.ObjectID `bson:"file_id,omitempty"`
+	ModifiedAt time.Time      `bson:"modified_at"`
+}
+
+// readCountFields maps read kinds to the counters they increment.
+var readCountFields = map[models.ReadKind]string{
+	models.ReadView:     "views",
+	models.ReadRaw:      "raw_reads",
+	models.ReadDownload: "downloads",
+}
+
+// NewMongoStore connects to the MongoDB deployment at uri and creates the
+// indexes of database if they are missing.
+func NewMongoStore(uri, database string) (*MongoStore, error) {
+	if database == "" {
+		return nil, fmt.Errorf("mongodb database name must not be empty")
+	}
+	opts := options.Client().ApplyURI(uri)
+	// The driver speaks its own protocol and cannot go through an HTTP
+	// proxy, so MongoDB must be reachable directly. The roots of
+	// NCLIP_CA_BUNDLE are trusted unless the URI names tlsCAFile.
+	if roots := egress.RootCAs(); roots != nil && opts.TLSConfig != nil && opts.TLSConfig.RootCAs == nil {
+		opts.TLSConfig.RootCAs = roots
+	}
+	client, err := mongo.Connect(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mongodb client: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	if err := client.Ping(ctx, nil); err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, fmt.Errorf("failed to connect to mongodb: %w", egress.Explain(err))
+	}
+	db := client.Database(database)
+	s := &MongoStore{
+		client:      client,
+		pastes:      db.Collection("pastes"),
+		contents:    db.Collection("contents"),
+		files:       db.GridFSBucket(options.GridFSBucket().SetName("content").SetChunkSizeBytes(mongoChunkSize)),
+		tokens:      db.Collection("tokens"),
+		collections: db.Collection("collections"),
+		certs:       db.Collection("certs"),
+	}
+	_, err = s.pastes.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "purge_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.D{{Key: "tags", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "sha256", Value: 1}, {Key: "_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	if err == nil {
+		_, err = s.tokens.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "slug", Value: 1}}})
+	}
+	if err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, fmt.Errorf("failed to create mongodb indexes: %w", err)
+	}
+	return s, nil
+}
+
+// SetReadOnly implements ReadOnlySetter. It must be called before the store
+// is shared between goroutines.
+func (s *MongoStore) SetReadOnly(readOnly bool) {
+	s.readOnly = readOnly
+}
+
+// SetOnExpire implements ExpiryHook. It must be called before the store
+// is shared between goroutines.
+func (s *MongoStore) SetOnExpire(fn func(id string)) {
+	s.onExpire = fn
+}
+
+// purgeAt returns when the TTL index may remove paste, or nil if never.
+func purgeAt(paste *models.Paste) *time.Time {
+	if paste.ExpiresAt == nil || paste.Pinned || paste.LegalHold {
+		return nil
+	}
+	t := paste.ExpiresAt.Add(mongoPurgeDelay)
+	return &t
+}
+
+// Store saves the paste metadata, replacing any previous metadata.
+func (s *MongoStore) Store(paste *models.Paste) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	doc := mongoPaste{Paste: *paste, PurgeAt: purgeAt(paste), ModifiedAt: time.Now().UTC()}
+	doc.Content = nil
+	doc.SchemaVersion = models.MetadataSchema
+	_, err := s.pastes.ReplaceOne(ctx, bson.D{{Key: "_id", Value: paste.ID}}, doc, options.Replace().SetUpsert(true))
+	if err != nil {
+		log.Printf("[ERROR] Mongo Store: failed to store metadata for %s: %v", paste.ID, err)
+	}
+	return err
+}
+
+// Get retrieves the paste metadata, removing the paste if it expired.
+func (s *MongoStore) Get(id string) (*models.Paste, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	var doc mongoPaste
+	err := s.pastes.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("[ERROR] Mongo Get: failed to read metadata for %s: %v", id, err)
+		return nil, err
+	}
+	paste := &doc.Paste
+	paste.Upgrade()
+	if paste.IsExpired() {
+		log.Printf("[WARN] Mongo Get: paste %s is expired", id)
+		if !s.readOnly && s.remove(ctx, paste) == nil && s.onExpire != nil {
+			s.onExpire(id)
+		}
+		return nil, ErrNotFound
+	}
+	return paste, nil
+}
+
+func (s *MongoStore) Exists(id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	n, err := s.pastes.CountDocuments(ctx, bson.D{{Key: "_id", Value: id}}, options.Count().SetLimit(1))
+	if err != nil {
+		log.Printf("[ERROR] Mongo Exists: failed to check %s: %v", id, err)
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Delete removes the paste's metadata, content, preview and versions.
+// Deleting a missing paste is not an error.
+func (s *MongoStore) Delete(id string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	var doc mongoPaste
+	err := s.pastes.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&doc)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Printf("[ERROR] Mongo Delete: failed to read metadata for %s: %v", id, err)
+		return err
+	}
+	doc.ID = id
+	return s.remove(ctx, &doc.Paste)
+}
+
+// remove deletes the content, preview and versions of paste, then its
+// metadata, so a failure leaves nothing that cannot be deleted again.
+func (s *MongoStore) remove(ctx context.Context, paste *models.Paste) error {
+	ids := []string{paste.ID, paste.ID + PreviewSuffix}
+	for _, v := range paste.Versions {
+		ids = append(ids, VersionID(paste.ID, v.Number))
+	}
+	for _, id := range ids {
+		if err := s.deleteContent(ctx, id); err != nil {
+			log.Printf("[ERROR] Mongo Delete: failed to delete content %s: %v", id, err)
+			return err
+		}
+	}
+	if _, err := s.pastes.DeleteOne(ctx, bson.D{{Key: "_id", Value: paste.ID}}); err != nil {
+		log.Printf("[ERROR] Mongo Delete: failed to delete metadata for %s: %v", paste.ID, err)
+		return err
+	}
+	return nil
+}
+
+// IncrementReadCount increments the read count with a single $inc.
+func (s *MongoStore) IncrementReadCount(id string) error {
+	return s.IncrementReads(id, "")
+}
+
+// IncrementReads implements ReadCounter.
+func (s *MongoStore) IncrementReads(id string, kind models.ReadKind) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	inc := bson.D{{Key: "read_count", Value: 1}}
+	if field, ok := readCountFields[kind]; ok {
+		inc = append(inc, bson.E{Key: field, Value: 1})
+	}
+	res, err := s.pastes.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}}, bson.D{{Key: "$inc", Value: inc}})
+	if err != nil {
+		log.Printf("[ERROR] Mongo IncrementReads: failed to update %s: %v", id, err)
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// List implements Lister from the tags and sha256 indexes of the pastes
+// collection.
+func (s *MongoStore) List(opts ListOptions) (ListPage, error) {
+	if err := checkChecksumListing(opts); err != nil {
+		return ListPage{}, err
+	}
+	filter := bson.D{}
+	if opts.Tag != "" {
+		if !utils.IsValidTag(opts.Tag) {
+			return ListPage{}, errInvalidTag
+		}
+		filter = append(filter, bson.E{Key: "tags", Value: opts.Tag})
+	}
+	if opts.Checksum != "" {
+		filter = append(filter, bson.E{Key: "sha256", Value: opts.Checksum})
+	}
+	if opts.Cursor != "" {
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$gt", Value: opts.Cursor}}})
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	find := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetProjection(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(int64(limit) + 1)
+	cur, err := s.pastes.Find(ctx, filter, find)
+	if err != nil {
+		log.Printf("[ERROR] Mongo List: failed to list pastes: %v", err)
+		return ListPage{}, err
+	}
+	var docs []struct {
+		ID string `bson:"_id"`
+	}
+	if err := cur.All(ctx, &docs); err != nil {
+		log.Printf("[ERROR] Mongo List: failed to read pastes: %v", err)
+		return ListPage{}, err
+	}
+	ids := make([]string, len(docs))
+	for i, d := range docs {
+		ids[i] = d.ID
+	}
+	return pageFromSorted(ids, "", limit), nil
+}
+
+// MigrateMetadata implements MetadataMigrator. BSON dates are always
+// UTC, so up to schema 1 documents only lack the version field, which is
+// set with a single update.
+func (s *MongoStore) MigrateMetadata(dryRun bool) (MigrateStats, error) {
+	if s.readOnly {
+		return MigrateStats{}, ErrReadOnly
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	var stats MigrateStats
+	total, err := s.pastes.CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return stats, err
+	}
+	stats.Scanned = int(total)
+	old := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "schema_version", Value: bson.D{{Key: "$exists", Value: false}}}},
+		bson.D{{Key: "schema_version", Value: bson.D{{Key: "$lt", Value: models.MetadataSchema}}}},
+	}}}
+	if dryRun {
+		n, err := s.pastes.CountDocuments(ctx, old)
+		stats.Upgraded = int(n)
+		return stats, err
+	}
+	res, err := s.pastes.UpdateMany(ctx, old, bson.D{{Key: "$set", Value: bson.D{{Key: "schema_version", Value: models.MetadataSchema}}}})
+	if err != nil {
+		log.Printf("[ERROR] Mongo MigrateMetadata: failed to upgrade metadata: %v", err)
+		return stats, err
+	}
+	stats.Upgraded = int(res.ModifiedCount)
+	return stats, nil
+}
+
+// ListObjects implements ObjectLister, listing each paste's metadata as
+// "<id>.json" and each content document as "<id>". Internal objects are
+// skipped.
+func (s *MongoStore) ListObjects(fn func(Object) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	var objects []Object
+	find := options.Find().SetProjection(bson.D{{Key: "size", Value: 1}, {Key: "modified_at", Value: 1}})
+	for _, coll := range []*mongo.Collection{s.pastes, s.contents} {
+		cur, err := coll.Find(ctx, bson.D{}, find)
+		if err != nil {
+			log.Printf("[ERROR] Mongo ListObjects: failed to list %s: %v", coll.Name(), err)
+			return err
+		}
+		var docs []mongoContent
+		if err := cur.All(ctx, &docs); err != nil {
+			log.Printf("[ERROR] Mongo ListObjects: failed to read %s: %v", coll.Name(), err)
+			return err
+		}
+		for _, d := range docs {
+			if strings.HasPrefix(d.ID, ".") {
+				continue
+			}
+			obj := Object{Name: d.ID, Size: d.Size, ModTime: d.ModifiedAt}
+			if coll == s.pastes {
+				// The size of a paste is that of its content.
+				obj.Name, obj.Size = d.ID+".json", 0
+			}
+			objects = append(objects, obj)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	for _, obj := range objects {
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StoreContent saves content inline or, above 1 MiB, as a new GridFS file
+// that replaces the previous one once the content document points to it.
+func (s *MongoStore) StoreContent(id string, content []byte) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	old, err := s.getContentDoc(ctx, id, false)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	doc := mongoContent{ID: id, Size: int64(len(content)), ModifiedAt: time.Now().UTC()}
+	if len(content) > mongoInlineLimit {
+		fileID, err := s.files.UploadFromStream(ctx, id, bytes.NewReader(content))
+		if err != nil {
+			log.Printf("[ERROR] Mongo StoreContent: failed to upload content for %s: %v", id, err)
+			return err
+		}
+		doc.FileID = &fileID
+	} else {
+		doc.Data = content
+	}
+	if _, err := s.contents.ReplaceOne(ctx, bson.D{{Key: "_id", Value: id}}, doc, options.Replace().SetUpsert(true)); err != nil {
+		log.Printf("[ERROR] Mongo StoreContent: failed to store content for %s: %v", id, err)
+		if doc.FileID != nil {
+			_ = s.files.Delete(ctx, *doc.FileID)
+		}
+		return err
+	}
+	if old != nil && old.FileID != nil {
+		if err := s.files.Delete(ctx, *old.FileID); err != nil {
+			log.Printf("[WARN] Mongo StoreContent: failed to delete replaced content of %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// CreateContent implements ContentCreator by inserting the content
+// document, which fails on the duplicate _id if one exists.
+func (s *MongoStore) CreateContent(id string, content []byte) (bool, error) {
+	if s.readOnly {
+		return false, ErrReadOnly
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	doc := mongoContent{ID: id, Size: int64(len(content)), ModifiedAt: time.Now().UTC()}
+	if len(content) > mongoInlineLimit {
+		fileID, err := s.files.UploadFromStream(ctx, id, bytes.NewReader(content))
+		if err != nil {
+			return false, err
+		}
+		doc.FileID = &fileID
+	} else {
+		doc.Data = content
+	}
+	_, err := s.contents.InsertOne(ctx, doc)
+	if err != nil && doc.FileID != nil {
+		_ = s.files.Delete(ctx, *doc.FileID)
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		log.Printf("[ERROR] Mongo CreateContent: failed to store content for %s: %v", id, err)
+		return false, err
+	}
+	return true, nil
+}
+
+// getContentDoc reads the content document of id, with the inline data
+// only if withData is set. A missing document is ErrNotFound.
+func (s *MongoStore) getContentDoc(ctx context.Context, id string, withData bool) (*mongoContent, error) {
+	find := options.FindOne()
+	if !withData {
+		find.SetProjection(bson.D{{Key: "data", Value: 0}})
+	}
+	var doc mongoContent
+	err := s.contents.FindOne(ctx, bson.D{{Key: "_id", Value: id}}, find).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("content %s: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		log.Printf("[ERROR] Mongo: failed to read content document %s: %v", id, err)
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// deleteContent removes the content of id and its GridFS file, if any.
+func (s *MongoStore) deleteContent(ctx context.Context, id string) error {
+	var doc mongoContent
+	find := options.FindOneAndDelete().SetProjection(bson.D{{Key: "data", Value: 0}})
+	err := s.contents.FindOneAndDelete(ctx, bson.D{{Key: "_id", Value: id}}, find).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if doc.FileID != nil {
+		if err := s.files.Delete(ctx, *doc.FileID); err != nil && !errors.Is(err, mongo.ErrFileNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MongoStore) GetContent(id string) ([]byte, error) {
+	r, err := s.OpenContent(id)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := r.Close(); cerr != nil {
+			log.Printf("[WARN] Mongo GetContent: failed to close content of %s: %v", id, cerr)
+		}
+	}()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		log.Printf("[ERROR] Mongo GetContent: failed to read content of %s: %v", id, err)
+		return nil, err
+	}
+	return data, nil
+}
+
+// OpenContent implements ContentOpener. Content in GridFS may be read for
+// up to five minutes.
+func (s *MongoStore) OpenContent(id string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	doc, err := s.getContentDoc(ctx, id, true)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if doc.FileID == nil {
+		cancel()
+		return io.NopCloser(bytes.NewReader(doc.Data)), nil
+	}
+	stream, err := s.files.OpenDownloadStream(ctx, *doc.FileID)
+	if err != nil {
+		cancel()
+		log.Printf("[ERROR] Mongo OpenContent: failed to open GridFS file of %s: %v", id, err)
+		return nil, err
+	}
+	return &cancelOnClose{ReadCloser: stream, cancel: cancel}, nil
+}
+
+// StatContent reads the size from the content document, without the data.
+func (s *MongoStore) StatContent(id string) (bool, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	doc, err := s.getContentDoc(ctx, id, false)
+	if errors.Is(err, ErrNotFound) {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	return true, doc.Size, nil
+}
+
+// AtRest implements AtRestReporter. Content larger than mongoInlineLimit
+// is kept in GridFS chunks.
+func (s *MongoStore) AtRest(id string) (AtRest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	doc, err := s.getContentDoc(ctx, id, false)
+	if err != nil {
+		return AtRest{}, err
+	}
+	return AtRest{Chunked: doc.FileID != nil}, nil
+}
+
+// GetContentPrefix retrieves up to n bytes of content. For content in
+// GridFS only the chunks covering the prefix are fetched.
+func (s *MongoStore) GetContentPrefix(id string, n int64) ([]byte, error) {
+	if n <= 0 {
+		return []byte{}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	doc, err := s.getContentDoc(ctx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	if n > doc.Size {
+		n = doc.Size
+	}
+	if doc.FileID == nil {
+		if n > int64(len(doc.Data)) {
+			n = int64(len(doc.Data))
+		}
+		return doc.Data[:n], nil
+	}
+	chunks := (n + mongoChunkSize - 1) / mongoChunkSize
+	filter := bson.D{{Key: "files_id", Value: *doc.FileID}, {Key: "n", Value: bson.D{{Key: "$lt", Value: chunks}}}}
+	cur, err := s.files.GetChunksCollection().Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "n", Value: 1}}))
+	if err != nil {
+		log.Printf("[ERROR] Mongo GetContentPrefix: failed to read chunks of %s: %v", id, err)
+		return nil, err
+	}
+	defer func() { _ = cur.Close(ctx) }()
+	data := make([]byte, 0, n)
+	for cur.Next(ctx) && int64(len(data)) < n {
+		var chunk struct {
+			Data []byte `bson:"data"`
+		}
+		if err := cur.Decode(&chunk); err != nil {
+			return nil, err
+		}
+		data = append(data, chunk.Data...)
+	}
+	if err := cur.Err(); err != nil {
+		log.Printf("[ERROR] Mongo GetContentPrefix: failed to read chunks of %s: %v", id, err)
+		return nil, err
+	}
+	if int64(len(data)) > n {
+		data = data[:n]
+	}
+	return data, nil
+}
+
+// mongoValue is the document of a share token, collection or certificate.
+// Slug is only set for share tokens, to list the tokens of a paste.
+type mongoValue[T any] struct {
+	ID    string `bson:"_id"`
+	Slug  string `bson:"slug,omitempty"`
+	Value T      `bson:"value"`
+}
+
+// putValue stores value under id in coll, replacing any previous value.
+func putValue[T any](s *MongoStore, coll *mongo.Collection, doc mongoValue[T]) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	_, err := coll.ReplaceOne(ctx, bson.D{{Key: "_id", Value: doc.ID}}, doc, options.Replace().SetUpsert(true))
+	if err != nil {
+		log.Printf("[ERROR] Mongo: failed to store %s in %s: %v", doc.ID, coll.Name(), err)
+	}
+	return err
+}
+
+// getValue reads the value stored under id in coll, or ErrNotFound.
+func getValue[T any](coll *mongo.Collection, id string) (T, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	var doc mongoValue[T]
+	err := coll.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return doc.Value, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("[ERROR] Mongo: failed to read %s from %s: %v", id, coll.Name(), err)
+	}
+	return doc.Value, err
+}
+
+// deleteValue removes the value stored under id in coll, if any.
+func (s *MongoStore) deleteValue(coll *mongo.Collection, id string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	if _, err := coll.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}}); err != nil {
+		log.Printf("[ERROR] Mongo: failed to delete %s from %s: %v", id, coll.Name(), err)
+		return err
+	}
+	return nil
+}
+
+// StoreToken implements TokenStore.
+func (s *MongoStore) StoreToken(t *models.ShareToken) error {
+	if !ValidToken(t.Token) || !utils.IsValidSlug(t.Slug) {
+		return errInvalidToken
+	}
+	return putValue(s, s.tokens, mongoValue[models.ShareToken]{ID: t.Token, Slug: t.Slug, Value: *t})
+}
+
+// GetToken implements TokenStore.
+func (s *MongoStore) GetToken(token string) (*models.ShareToken, error) {
+	if !ValidToken(token) {
+		return nil, errInvalidToken
+	}
+	t, err := getValue[models.ShareToken](s.tokens, token)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// DeleteToken implements TokenStore.
+func (s *MongoStore) DeleteToken(t *models.ShareToken) error {
+	if !ValidToken(t.Token) {
+		return errInvalidToken
+	}
+	return s.deleteValue(s.tokens, t.Token)
+}
+
+// ListTokens implements TokenStore.
+func (s *MongoStore) ListTokens(slug string) ([]*models.ShareToken, error) {
+	if !utils.IsValidSlug(slug) {
+		return nil, errInvalidToken
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	cur, err := s.tokens.Find(ctx, bson.D{{Key: "slug", Value: slug}}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		log.Printf("[ERROR] Mongo ListTokens: failed to list tokens of %s: %v", slug, err)
+		return nil, err
+	}
+	var docs []mongoValue[models.ShareToken]
+	if err := cur.All(ctx, &docs); err != nil {
+		log.Printf("[ERROR] Mongo ListTokens: failed to read tokens of %s: %v", slug, err)
+		return nil, err
+	}
+	tokens := make([]*models.ShareToken, len(docs))
+	for i := range docs {
+		tokens[i] = &docs[i].Value
+	}
+	return tokens, nil
+}
+
+// StoreCollection implements CollectionStore.
+func (s *MongoStore) StoreCollection(col *models.Collection) error {
+	if !validCollectionID(col.ID) {
+		return errInvalidCollectionID
+	}
+	return putValue(s, s.collections, mongoValue[models.Collection]{ID: col.ID, Value: *col})
+}
+
+// GetCollection implements CollectionStore.
+func (s *MongoStore) GetCollection(id string) (*models.Collection, error) {
+	if !validCollectionID(id) {
+		return nil, errInvalidCollectionID
+	}
+	col, err := getValue[models.Collection](s.collections, id)
+	if err != nil {
+		return nil, err
+	}
+	return &col, nil
+}
+
+// DeleteCollection implements CollectionStore.
+func (s *MongoStore) DeleteCollection(id string) error {
+	if !validCollectionID(id) {
+		return errInvalidCollectionID
+	}
+	return s.deleteValue(s.collections, id)
+}
+
+// GetCert implements CertStore.
+func (s *MongoStore) GetCert(name string) ([]byte, error) {
+	if !validCertName(name) {
+		return nil, errInvalidCertName
+	}
+	return getValue[[]byte](s.certs, name)
+}
+
+// PutCert implements CertStore.
+func (s *MongoStore) PutCert(name string, data []byte) error {
+	if !validCertName(name) {
+		return errInvalidCertName
+	}
+	return putValue(s, s.certs, mongoValue[[]byte]{ID: name, Value: data})
+}
+
+// DeleteCert implements CertStore.
+func (s *MongoStore) DeleteCert(name string) error {
+	if !validCertName(name) {
+		return errInvalidCertName
+	}
+	return s.deleteValue(s.certs, name)
+}
+
+// Close disconnects from MongoDB.
+func (s *MongoStore) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	return s.client.Disconnect(ctx)
+}
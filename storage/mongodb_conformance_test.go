@@ -0,0 +1,35 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/storage"
+	"github.com/johnwmail/nclip/storage/conformancetest"
+)
+
+// TestConformance_MongoDB runs the conformance suite against the MongoDB
+// deployment at NCLIP_TEST_MONGO_URI. Each subtest uses its own database;
+// databases it leaves behind are not dropped, so use a scratch deployment.
+//
+//	NCLIP_TEST_MONGO_URI=mongodb://localhost:27017 go test -tags integration ./storage/
+func TestConformance_MongoDB(t *testing.T) {
+	uri := os.Getenv("NCLIP_TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("NCLIP_TEST_MONGO_URI not set")
+	}
+	run := time.Now().UTC().Format("20060102T150405")
+	n := 0
+	conformancetest.Run(t, func(t *testing.T) storage.PasteStore {
+		n++
+		store, err := storage.NewMongoStore(uri, fmt.Sprintf("nclip_conformance_%s_%d", run, n))
+		if err != nil {
+			t.Fatalf("NewMongoStore: %v", err)
+		}
+		return store
+	})
+}
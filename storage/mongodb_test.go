@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestNewMongoStore_EmptyDatabase(t *testing.T) {
+	if _, err := NewMongoStore("mongodb://localhost:27017", ""); err == nil {
+		t.Error("expected error for empty database name")
+	}
+}
+
+// TestMongoStore_Interfaces checks at compile time that MongoStore offers
+// everything the filesystem and S3 backends do.
+func TestMongoStore_Interfaces(t *testing.T) {
+	var _ PasteStore = (*MongoStore)(nil)
+	var _ ReadOnlySetter = (*MongoStore)(nil)
+	var _ ReadCounter = (*MongoStore)(nil)
+	var _ ContentOpener = (*MongoStore)(nil)
+	var _ Lister = (*MongoStore)(nil)
+	var _ ObjectLister = (*MongoStore)(nil)
+	var _ TokenStore = (*MongoStore)(nil)
+	var _ CollectionStore = (*MongoStore)(nil)
+	var _ CertStore = (*MongoStore)(nil)
+}
+
+func TestPurgeAt(t *testing.T) {
+	expires := time.Now().UTC().Truncate(time.Second)
+	if got := purgeAt(&models.Paste{ExpiresAt: &expires}); got == nil || !got.Equal(expires.Add(mongoPurgeDelay)) {
+		t.Errorf("purgeAt = %v, want %v", got, expires.Add(mongoPurgeDelay))
+	}
+	for _, p := range []models.Paste{{}, {ExpiresAt: &expires, Pinned: true}, {ExpiresAt: &expires, LegalHold: true}} {
+		if got := purgeAt(&p); got != nil {
+			t.Errorf("purgeAt(%+v) = %v, want nil", p, got)
+		}
+	}
+}
+
+// Test that metadata survives the round trip through BSON with the fields
+// MongoStore adds.
+func TestMongoPaste_RoundTrip(t *testing.T) {
+	created := time.Now().UTC().Truncate(time.Millisecond)
+	expires := created.Add(time.Hour)
+	want := models.Paste{
+		ID:          "RNDTRP",
+		CreatedAt:   created,
+		ExpiresAt:   &expires,
+		Size:        11,
+		ContentType: "text/plain",
+		ReadCount:   2,
+		Views:       1,
+		Tags:        []string{"deploy"},
+		Visibility:  models.VisibilityPrivate,
+		Versions:    []models.PasteVersion{{Number: 1, Size: 4}},
+	}
+	data, err := bson.Marshal(mongoPaste{Paste: want, PurgeAt: purgeAt(&want), ModifiedAt: created})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	raw := bson.Raw(data)
+	if id, ok := raw.Lookup("_id").StringValueOK(); !ok || id != "RNDTRP" {
+		t.Errorf("_id = %v, want the slug", raw.Lookup("_id"))
+	}
+	if _, ok := raw.Lookup("purge_at").TimeOK(); !ok {
+		t.Error("purge_at not stored as a date, the TTL index would ignore it")
+	}
+	var got mongoPaste
+	if err := bson.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) || got.ExpiresAt == nil || !got.ExpiresAt.Equal(*want.ExpiresAt) {
+		t.Errorf("times = %v, %v; want %v, %v", got.CreatedAt, got.ExpiresAt, want.CreatedAt, want.ExpiresAt)
+	}
+	got.CreatedAt, got.ExpiresAt = want.CreatedAt, want.ExpiresAt
+	if !reflect.DeepEqual(got.Paste, want) {
+		t.Errorf("round trip = %+v, want %+v", got.Paste, want)
+	}
+}
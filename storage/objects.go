@@ -0,0 +1,23 @@
+package storage
+
+import "time"
+
+// Object is a raw object of a store, as listed by ObjectLister.
+type Object struct {
+	// Name is the object's name under the store root: "<id>" for content
+	// saved with StoreContent and "<id>.json" for metadata.
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// ObjectLister is implemented by stores that can enumerate their raw
+// objects, so objects without a counterpart (content without metadata or
+// the reverse) can be found and reclaimed.
+type ObjectLister interface {
+	// ListObjects calls fn for every object at the top level of the store
+	// in ascending name order. Internal objects, whose names start with a
+	// dot (such as the tag index), are skipped. An error returned by fn
+	// stops the listing and is returned.
+	ListObjects(fn func(Object) error) error
+}
@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"io"
+
+	"github.com/johnwmail/nclip/internal/cdnpurge"
+	"github.com/johnwmail/nclip/models"
+)
+
+// PurgingStore wraps a PasteStore and has the CDN drop its cached copies
+// of every paste deleted through it, and of those the backend removes on
+// read because they expired.
+type PurgingStore struct {
+	backend PasteStore
+	purger  *cdnpurge.Purger
+}
+
+// NewPurgingStore wraps backend, purging deleted pastes with purger. It
+// registers purger with the first store in backend's decorator chain that
+// implements ExpiryHook.
+func NewPurgingStore(backend PasteStore, purger *cdnpurge.Purger) *PurgingStore {
+	for store := backend; store != nil; {
+		if h, ok := store.(ExpiryHook); ok {
+			h.SetOnExpire(func(id string) { purger.Purge(id) })
+			break
+		}
+		d, ok := store.(interface{ Backend() PasteStore })
+		if !ok {
+			break
+		}
+		store = d.Backend()
+	}
+	return &PurgingStore{backend: backend, purger: purger}
+}
+
+// Backend returns the wrapped store.
+func (s *PurgingStore) Backend() PasteStore {
+	return s.backend
+}
+
+// Purger returns the CDN purger.
+func (s *PurgingStore) Purger() *cdnpurge.Purger {
+	return s.purger
+}
+
+// Store implements PasteStore.
+func (s *PurgingStore) Store(paste *models.Paste) error {
+	return s.backend.Store(paste)
+}
+
+// Get implements PasteStore.
+func (s *PurgingStore) Get(id string) (*models.Paste, error) {
+	return s.backend.Get(id)
+}
+
+// GetBatch implements BatchGetter.
+func (s *PurgingStore) GetBatch(ids []string) map[string]BatchResult {
+	return GetBatch(s.backend, ids)
+}
+
+// Exists implements PasteStore.
+func (s *PurgingStore) Exists(id string) (bool, error) {
+	return s.backend.Exists(id)
+}
+
+// Delete implements PasteStore, purging the paste.
+func (s *PurgingStore) Delete(id string) error {
+	if err := s.backend.Delete(id); err != nil {
+		return err
+	}
+	s.purger.Purge(id)
+	return nil
+}
+
+// DeleteBatch implements BatchDeleter, purging every paste the backend
+// deleted.
+func (s *PurgingStore) DeleteBatch(ids []string, progress DeleteProgress) map[string]error {
+	errs := DeleteBatch(s.backend, ids, progress)
+	deleted := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, failed := errs[id]; !failed {
+			deleted = append(deleted, id)
+		}
+	}
+	s.purger.Purge(deleted...)
+	return errs
+}
+
+// IncrementReadCount implements PasteStore.
+func (s *PurgingStore) IncrementReadCount(id string) error {
+	return s.backend.IncrementReadCount(id)
+}
+
+// IncrementReads implements ReadCounter.
+func (s *PurgingStore) IncrementReads(id string, kind models.ReadKind) error {
+	return IncrementReads(s.backend, id, kind)
+}
+
+// Close implements PasteStore.
+func (s *PurgingStore) Close() error {
+	return s.backend.Close()
+}
+
+// StoreContent implements PasteStore.
+func (s *PurgingStore) StoreContent(id string, content []byte) error {
+	return s.backend.StoreContent(id, content)
+}
+
+// CreateContent implements ContentCreator.
+func (s *PurgingStore) CreateContent(id string, content []byte) (bool, error) {
+	return CreateContent(s.backend, id, content)
+}
+
+// GetContent implements PasteStore.
+func (s *PurgingStore) GetContent(id string) ([]byte, error) {
+	return s.backend.GetContent(id)
+}
+
+// OpenContent implements ContentOpener.
+func (s *PurgingStore) OpenContent(id string) (io.ReadCloser, error) {
+	return OpenContent(s.backend, id)
+}
+
+// GetContentPrefix implements PasteStore.
+func (s *PurgingStore) GetContentPrefix(id string, n int64) ([]byte, error) {
+	return s.backend.GetContentPrefix(id, n)
+}
+
+// StatContent implements PasteStore.
+func (s *PurgingStore) StatContent(id string) (bool, int64, error) {
+	return s.backend.StatContent(id)
+}
+
+// List implements Lister by delegating to the backend.
+func (s *PurgingStore) List(opts ListOptions) (ListPage, error) {
+	l, ok := s.backend.(Lister)
+	if !ok {
+		return ListPage{}, errUnsupported
+	}
+	return l.List(opts)
+}
+
+// ListObjects implements ObjectLister by delegating to the backend.
+func (s *PurgingStore) ListObjects(fn func(Object) error) error {
+	l, ok := s.backend.(ObjectLister)
+	if !ok {
+		return errUnsupported
+	}
+	return l.ListObjects(fn)
+}
+
+// StoreCollection implements CollectionStore by delegating to the backend.
+func (s *PurgingStore) StoreCollection(col *models.Collection) error {
+	cs, ok := s.backend.(CollectionStore)
+	if !ok {
+		return errUnsupported
+	}
+	return cs.StoreCollection(col)
+}
+
+// GetCollection implements CollectionStore by delegating to the backend.
+func (s *PurgingStore) GetCollection(id string) (*models.Collection, error) {
+	cs, ok := s.backend.(CollectionStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return cs.GetCollection(id)
+}
+
+// DeleteCollection implements CollectionStore by delegating to the backend.
+func (s *PurgingStore) DeleteCollection(id string) error {
+	cs, ok := s.backend.(CollectionStore)
+	if !ok {
+		return errUnsupported
+	}
+	return cs.DeleteCollection(id)
+}
+
+// StoreToken implements TokenStore by delegating to the backend.
+func (s *PurgingStore) StoreToken(t *models.ShareToken) error {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return errUnsupported
+	}
+	return ts.StoreToken(t)
+}
+
+// GetToken implements TokenStore by delegating to the backend.
+func (s *PurgingStore) GetToken(token string) (*models.ShareToken, error) {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return ts.GetToken(token)
+}
+
+// DeleteToken implements TokenStore by delegating to the backend.
+func (s *PurgingStore) DeleteToken(t *models.ShareToken) error {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return errUnsupported
+	}
+	return ts.DeleteToken(t)
+}
+
+// ListTokens implements TokenStore by delegating to the backend.
+func (s *PurgingStore) ListTokens(slug string) ([]*models.ShareToken, error) {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return ts.ListTokens(slug)
+}
+
+// GetCert implements CertStore by delegating to the backend.
+func (s *PurgingStore) GetCert(name string) ([]byte, error) {
+	cs, ok := s.backend.(CertStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return cs.GetCert(name)
+}
+
+// PutCert implements CertStore by delegating to the backend.
+func (s *PurgingStore) PutCert(name string, data []byte) error {
+	cs, ok := s.backend.(CertStore)
+	if !ok {
+		return errUnsupported
+	}
+	return cs.PutCert(name, data)
+}
+
+// DeleteCert implements CertStore by delegating to the backend.
+func (s *PurgingStore) DeleteCert(name string) error {
+	cs, ok := s.backend.(CertStore)
+	if !ok {
+		return errUnsupported
+	}
+	return cs.DeleteCert(name)
+}
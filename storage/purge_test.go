@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/internal/cdnpurge"
+	"github.com/johnwmail/nclip/models"
+)
+
+// purgeRecorder is a CDN purge target that records the paths it is sent.
+type purgeRecorder struct {
+	paths []string
+}
+
+func (r *purgeRecorder) Name() string { return "recorder" }
+
+func (r *purgeRecorder) Invalidate(_ context.Context, paths []string) error {
+	r.paths = append(r.paths, paths...)
+	return nil
+}
+
+func TestPurgingStore(t *testing.T) {
+	backend := NewMemoryStore()
+	target := &purgeRecorder{}
+	// An unstarted purger sends right away.
+	store := NewPurgingStore(NewInstrumentedStore(backend, "memory", nil), cdnpurge.New([]string{"/raw/{slug}"}, time.Minute, target))
+
+	past := time.Now().Add(-time.Minute)
+	for _, p := range []*models.Paste{{ID: "DLTD"}, {ID: "BTCH"}, {ID: "XPRD", ExpiresAt: &past}} {
+		if err := store.StoreContent(p.ID, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Store(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete("DLTD"); err != nil {
+		t.Fatal(err)
+	}
+	if errs := store.DeleteBatch([]string{"BTCH", "BTCH.v1"}, nil); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	// The backend removes the expired paste when it is read.
+	if _, err := store.Get("XPRD"); err != ErrNotFound {
+		t.Fatalf("expected the expired paste to be gone, got %v", err)
+	}
+	if want := []string{"/raw/DLTD", "/raw/BTCH", "/raw/XPRD"}; !reflect.DeepEqual(target.paths, want) {
+		t.Errorf("got %v, want %v", target.paths, want)
+	}
+}
@@ -0,0 +1,20 @@
+package storage
+
+import "github.com/johnwmail/nclip/models"
+
+// ReadCounter is implemented by stores that keep per-kind read counters in
+// addition to the total read count.
+type ReadCounter interface {
+	// IncrementReads increments the total read count and the counter for
+	// kind in a single metadata update.
+	IncrementReads(id string, kind models.ReadKind) error
+}
+
+// IncrementReads records a read of kind using the store's ReadCounter
+// implementation when available, falling back to IncrementReadCount.
+func IncrementReads(store PasteStore, id string, kind models.ReadKind) error {
+	if rc, ok := store.(ReadCounter); ok {
+		return rc.IncrementReads(id, kind)
+	}
+	return store.IncrementReadCount(id)
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/johnwmail/nclip/models"
+)
+
+func TestIncrementReads(t *testing.T) {
+	fs, err := NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystemStore: %v", err)
+	}
+	if err := fs.Store(&models.Paste{ID: "RDS22"}); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	for _, kind := range []models.ReadKind{models.ReadView, models.ReadView, models.ReadRaw, models.ReadDownload} {
+		if err := IncrementReads(fs, "RDS22", kind); err != nil {
+			t.Fatalf("IncrementReads(%s): %v", kind, err)
+		}
+	}
+	if err := fs.IncrementReadCount("RDS22"); err != nil {
+		t.Fatalf("IncrementReadCount: %v", err)
+	}
+	p, err := fs.Get("RDS22")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if p.ReadCount != 5 || p.Views != 2 || p.RawReads != 1 || p.Downloads != 1 {
+		t.Errorf("unexpected counters: read_count=%d views=%d raw_reads=%d downloads=%d", p.ReadCount, p.Views, p.RawReads, p.Downloads)
+	}
+
+	// Stores without per-kind counters still count the read.
+	mock := NewMockPasteStore()
+	if err := mock.Store(&models.Paste{ID: "RDS22"}); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if err := IncrementReads(mock, "RDS22", models.ReadRaw); err != nil {
+		t.Fatalf("IncrementReads: %v", err)
+	}
+	if p, _ := mock.Get("RDS22"); p.ReadCount != 1 {
+		t.Errorf("expected fallback to IncrementReadCount, got read_count=%d", p.ReadCount)
+	}
+}
@@ -8,8 +8,10 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math/rand/v2"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -21,26 +23,130 @@ import (
 )
 
 type S3Store struct {
-	bucket string
-	prefix string
-	client *s3.Client
+	bucket       string
+	prefix       string
+	client       *s3.Client
+	readOnly     bool
+	onExpire     func(id string)
+	readCounting ReadCountMode
+	reads        *readBuffer
+	// slugs is the slug index; nil unless EnableSlugIndex was called.
+	slugs *s3SlugIndex
+	// put is applied to every object written; see SetPutOptions.
+	put S3PutOptions
+	// opts are the retry settings and timeouts the store was created with.
+	opts S3ClientOptions
+	// retries counts retried requests; nil for stores built in tests.
+	retries *retryCounters
+	// conns counts opened and reused connections; nil for stores built in
+	// tests.
+	conns *connCounters
+}
+
+// SetReadOnly implements ReadOnlySetter. It must be called before the store
+// is shared between goroutines.
+func (s *S3Store) SetReadOnly(readOnly bool) {
+	s.readOnly = readOnly
+}
+
+// SetOnExpire implements ExpiryHook. It must be called before the store
+// is shared between goroutines.
+func (s *S3Store) SetOnExpire(fn func(id string)) {
+	s.onExpire = fn
 }
 
 // NewS3Store creates a new S3Store instance
 func NewS3Store(bucket, prefix string) (*S3Store, error) {
+	return NewS3StoreInRegion(bucket, prefix, "")
+}
+
+// NewS3StoreInRegion is NewS3Store with an explicit bucket region, for
+// callers running outside it (such as edge functions). An empty region uses
+// the default AWS configuration.
+func NewS3StoreInRegion(bucket, prefix, region string) (*S3Store, error) {
+	return NewS3StoreWithOptions(bucket, prefix, region, S3ClientOptions{})
+}
+
+// NewS3StoreWithOptions is NewS3StoreInRegion with the retry settings and
+// timeouts of o.
+func NewS3StoreWithOptions(bucket, prefix, region string, o S3ClientOptions) (*S3Store, error) {
 	if bucket == "" {
 		return nil, fmt.Errorf("s3 bucket name must not be empty")
 	}
-	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	o = o.withDefaults()
+	counts, conns := &retryCounters{}, &connCounters{}
+	opts := []func(*config.LoadOptions) error{
+		config.WithRetryer(func() aws.Retryer { return newRetryer(o, counts) }),
+		config.WithHTTPClient(newHTTPClient(o)),
+	}
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(context.Background(), opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
-	client := s3.NewFromConfig(cfg)
-	return &S3Store{bucket: bucket, prefix: prefix, client: client}, nil
+	client := s3.NewFromConfig(cfg, countConns(conns))
+	return &S3Store{bucket: bucket, prefix: prefix, client: client, opts: o, retries: counts, conns: conns}, nil
 }
 
+// Store saves the paste metadata and indexes its tags.
 func (s *S3Store) Store(paste *models.Paste) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if err := s.putMetadata(paste); err != nil {
+		return err
+	}
+	if s.slugs != nil {
+		// The index holds slugs, which tenants share.
+		_, slug := splitTenantKey(paste.ID)
+		s.slugs.add(slug)
+	}
+	ctx, cancel := s.requestContext()
+	defer cancel()
+	for _, tag := range paste.Tags {
+		key, err := s.tagKey(tag, paste.ID)
+		if err != nil {
+			return err
+		}
+		if _, err := s.putObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(nil),
+		}); err != nil {
+			log.Printf("[ERROR] S3 Store: failed to index tag %q for %s: %v", tag, paste.ID, err)
+			return err
+		}
+	}
+	// The marker of content this paste had before stays behind; checksum
+	// lookups check the metadata and skip it.
+	if key, ok := s.checksumKey(paste); ok {
+		if _, err := s.putObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(nil),
+		}); err != nil {
+			log.Printf("[ERROR] S3 Store: failed to index checksum of %s: %v", paste.ID, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// putMetadata writes the metadata object for paste.
+func (s *S3Store) putMetadata(paste *models.Paste) error {
+	return s.putMetadataIf(paste, "")
+}
+
+// putMetadataIf writes the metadata object for paste. A non-empty etag
+// makes the write conditional on the object still having that ETag; a
+// lost race fails with an error errConditionFailed matches.
+func (s *S3Store) putMetadataIf(paste *models.Paste, etag string) error {
+	ctx, cancel := s.requestContext()
 	defer cancel()
 	// Store metadata
 	metaKey := applyS3Prefix(s.prefix, paste.ID+".json")
@@ -49,11 +155,18 @@ func (s *S3Store) Store(paste *models.Paste) error {
 		log.Printf("[ERROR] S3 Store: failed to marshal metadata for %s: %v", paste.ID, err)
 		return err
 	}
-	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+	in := &s3.PutObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(metaKey),
 		Body:   bytes.NewReader(metaData),
-	})
+	}
+	if etag != "" {
+		in.IfMatch = aws.String(etag)
+	}
+	_, err = s.putObject(ctx, in)
+	if err != nil && etag != "" && errConditionFailed(err) {
+		return err
+	}
 	if err != nil {
 		log.Printf("[ERROR] S3 Store: failed to put metadata for %s: %v", paste.ID, err)
 		if utils.IsDebugEnabled() {
@@ -72,9 +185,23 @@ func (s *S3Store) Store(paste *models.Paste) error {
 	return err
 }
 
-func (s *S3Store) Get(id string) (*models.Paste, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// getMetadata reads the metadata object of id and returns it with its
+// ETag, without checking expiry.
+func (s *S3Store) getMetadata(ctx context.Context, id string) (*models.Paste, string, error) {
+	metaData, etag, err := s.getMetadataRaw(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	var paste models.Paste
+	if err := json.Unmarshal(metaData, &paste); err != nil {
+		log.Printf("[ERROR] S3 Get: failed to unmarshal metadata for %s: %v", id, err)
+		return nil, "", err
+	}
+	return &paste, etag, nil
+}
+
+// getMetadataRaw reads the metadata object of id as stored, with its ETag.
+func (s *S3Store) getMetadataRaw(ctx context.Context, id string) ([]byte, string, error) {
 	metaKey := applyS3Prefix(s.prefix, id+".json")
 	obj, err := s.client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
@@ -86,14 +213,14 @@ func (s *S3Store) Get(id string) (*models.Paste, error) {
 		if errors.As(err, &apiErr) {
 			code := apiErr.ErrorCode()
 			if code == "NoSuchKey" || code == "NotFound" || code == "404" {
-				return nil, ErrNotFound
+				return nil, "", ErrNotFound
 			}
 		}
 		if strings.Contains(err.Error(), "StatusCode: 404") || strings.Contains(err.Error(), "NotFound") {
-			return nil, ErrNotFound
+			return nil, "", ErrNotFound
 		}
 		log.Printf("[ERROR] S3 Get: failed to get metadata for %s: %v", id, err)
-		return nil, err
+		return nil, "", err
 	}
 	defer func() {
 		if cerr := obj.Body.Close(); cerr != nil {
@@ -103,16 +230,26 @@ func (s *S3Store) Get(id string) (*models.Paste, error) {
 	metaData, err := io.ReadAll(obj.Body)
 	if err != nil {
 		log.Printf("[ERROR] S3 Get: failed to read metadata body for %s: %v", id, err)
-		return nil, err
+		return nil, "", err
 	}
-	var paste models.Paste
-	if err := json.Unmarshal(metaData, &paste); err != nil {
-		log.Printf("[ERROR] S3 Get: failed to unmarshal metadata for %s: %v", id, err)
+	return metaData, aws.ToString(obj.ETag), nil
+}
+
+func (s *S3Store) Get(id string) (*models.Paste, error) {
+	ctx, cancel := s.requestContext()
+	defer cancel()
+	paste, _, err := s.getMetadata(ctx, id)
+	if err != nil {
 		return nil, err
 	}
 	if paste.IsExpired() {
 		log.Printf("[INFO] S3 Get: paste %s is expired", id)
+		if s.readOnly {
+			return nil, ErrNotFound
+		}
 		// Attempt to delete expired objects (best-effort)
+		s.unindexTags(ctx, paste)
+		s.deleteVersions(ctx, paste)
 		if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
 			Bucket: aws.String(s.bucket),
 			Key:    aws.String(applyS3Prefix(s.prefix, id)),
@@ -124,14 +261,43 @@ func (s *S3Store) Get(id string) (*models.Paste, error) {
 			Key:    aws.String(applyS3Prefix(s.prefix, id+".json")),
 		}); err != nil {
 			log.Printf("[WARN] S3 Get: failed to delete expired metadata for %s: %v", id, err)
+		} else if s.onExpire != nil {
+			s.onExpire(id)
 		}
 		return nil, ErrNotFound
 	}
-	return &paste, nil
+	return paste, nil
+}
+
+// s3BatchConcurrency caps the number of parallel metadata requests issued
+// by GetBatch so a large batch cannot exhaust the HTTP connection pool.
+const s3BatchConcurrency = 10
+
+// GetBatch fetches metadata objects in parallel with a bounded number of
+// in-flight requests.
+func (s *S3Store) GetBatch(ids []string) map[string]BatchResult {
+	results := make(map[string]BatchResult, len(ids))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, s3BatchConcurrency)
+	for _, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(id string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			r := batchResult(s.Get(id))
+			mu.Lock()
+			results[id] = r
+			mu.Unlock()
+		}(id)
+	}
+	wg.Wait()
+	return results
 }
 
 func (s *S3Store) Exists(id string) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := s.headContext()
 	defer cancel()
 	metaKey := applyS3Prefix(s.prefix, id+".json")
 	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
@@ -160,39 +326,547 @@ func (s *S3Store) Exists(id string) (bool, error) {
 	return true, nil
 }
 
+// Delete removes the paste with its preview, versions and tag index
+// entries in one DeleteObjects call.
 func (s *S3Store) Delete(id string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	return s.DeleteBatch([]string{id}, nil)[id]
+}
+
+// deleteVersions deletes the kept earlier contents of paste (best-effort).
+func (s *S3Store) deleteVersions(ctx context.Context, paste *models.Paste) {
+	for _, v := range paste.Versions {
+		if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(applyS3Prefix(s.prefix, VersionID(paste.ID, v.Number))),
+		}); err != nil {
+			log.Printf("[WARN] S3: failed to delete version %d of %s: %v", v.Number, paste.ID, err)
+		}
+	}
+}
+
+func (s *S3Store) IncrementReadCount(id string) error {
+	return s.IncrementReads(id, "")
+}
+
+// IncrementReads implements ReadCounter, according to the store's
+// ReadCountMode.
+func (s *S3Store) IncrementReads(id string, kind models.ReadKind) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	switch s.readCounting {
+	case ReadCountConditional:
+		return s.addReads(id, readCounts{kind: 1})
+	case ReadCountBuffered:
+		return s.reads.add(id, kind, s.addReads)
+	}
+	paste, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	paste.RecordRead(kind)
+	return s.putMetadata(paste)
+}
+
+// tagKey returns the index marker key for id under tag.
+// MigrateMetadata implements MetadataMigrator. Each rewrite is
+// conditional on the object's ETag and retried like read counting, so
+// reads counted meanwhile by running instances are not lost.
+func (s *S3Store) MigrateMetadata(dryRun bool) (MigrateStats, error) {
+	return migrateListed(s, func(id string) (bool, error) {
+		return s.migrateMeta(id, dryRun)
+	})
+}
+
+// migrateMeta rewrites the metadata of id if it has an older schema and
+// reports whether it did (or, with dryRun, would have).
+func (s *S3Store) migrateMeta(id string, dryRun bool) (bool, error) {
+	if s.readOnly {
+		return false, ErrReadOnly
+	}
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := s.requestContext()
+		metaData, etag, err := s.getMetadataRaw(ctx, id)
+		cancel()
+		if errors.Is(err, ErrNotFound) {
+			// Deleted since it was listed.
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		schema, err := storedSchema(metaData)
+		if err != nil {
+			return false, err
+		}
+		if schema >= models.MetadataSchema || dryRun {
+			return schema < models.MetadataSchema, nil
+		}
+		var paste models.Paste
+		if err := json.Unmarshal(metaData, &paste); err != nil {
+			return false, err
+		}
+		err = s.putMetadataIf(&paste, etag)
+		if err == nil || !errConditionFailed(err) || attempt == conditionalAttempts-1 {
+			return err == nil, err
+		}
+		time.Sleep(rand.N(conditionalBackoff << attempt))
+	}
+}
+
+func (s *S3Store) tagKey(tag, id string) (string, error) {
+	if !utils.IsValidTag(tag) {
+		return "", errInvalidTag
+	}
+	return applyS3Prefix(s.prefix, tagIndexDir+"/"+tag+"/"+id), nil
+}
+
+// checksumKey returns the index marker key of paste under its checksum,
+// if it recorded one.
+func (s *S3Store) checksumKey(paste *models.Paste) (string, bool) {
+	if !utils.IsValidChecksum(paste.SHA256) {
+		return "", false
+	}
+	return applyS3Prefix(s.prefix, checksumIndexDir+"/"+paste.SHA256+"/"+paste.ID), true
+}
+
+// unindexTags removes paste from the index of each of its tags and of its
+// checksum (best-effort).
+func (s *S3Store) unindexTags(ctx context.Context, paste *models.Paste) {
+	for _, tag := range paste.Tags {
+		key, err := s.tagKey(tag, paste.ID)
+		if err != nil {
+			continue
+		}
+		if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+		}); err != nil {
+			log.Printf("[WARN] S3: failed to unindex tag %q for %s: %v", tag, paste.ID, err)
+		}
+	}
+	if key, ok := s.checksumKey(paste); ok {
+		if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+		}); err != nil {
+			log.Printf("[WARN] S3: failed to unindex checksum of %s: %v", paste.ID, err)
+		}
+	}
+}
+
+// collectionKey returns the key of the collection with id.
+func (s *S3Store) collectionKey(id string) (string, error) {
+	if !validCollectionID(id) {
+		return "", errInvalidCollectionID
+	}
+	return applyS3Prefix(s.prefix, collectionDir+"/"+id+".json"), nil
+}
+
+// StoreCollection implements CollectionStore.
+func (s *S3Store) StoreCollection(col *models.Collection) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	key, err := s.collectionKey(col.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(col, "", "  ")
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.requestContext()
 	defer cancel()
-	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+	if _, err := s.putObject(ctx, &s3.PutObjectInput{
 		Bucket: aws.String(s.bucket),
-		Key:    aws.String(applyS3Prefix(s.prefix, id)),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
 	}); err != nil {
-		log.Printf("[ERROR] S3 Delete: failed to delete content for %s: %v", id, err)
-		return fmt.Errorf("failed to delete content for %s: %w", id, err)
+		log.Printf("[ERROR] S3 StoreCollection: failed to put %s: %v", col.ID, err)
+		return err
 	}
+	return nil
+}
+
+// GetCollection implements CollectionStore.
+func (s *S3Store) GetCollection(id string) (*models.Collection, error) {
+	key, err := s.collectionKey(id)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.requestContext()
+	defer cancel()
+	obj, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			if code := apiErr.ErrorCode(); code == "NoSuchKey" || code == "NotFound" || code == "404" {
+				return nil, ErrNotFound
+			}
+		}
+		log.Printf("[ERROR] S3 GetCollection: failed to get %s: %v", id, err)
+		return nil, err
+	}
+	defer func() { _ = obj.Body.Close() }()
+	data, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return nil, err
+	}
+	var col models.Collection
+	if err := json.Unmarshal(data, &col); err != nil {
+		log.Printf("[ERROR] S3 GetCollection: failed to unmarshal %s: %v", id, err)
+		return nil, err
+	}
+	return &col, nil
+}
+
+// DeleteCollection implements CollectionStore.
+func (s *S3Store) DeleteCollection(id string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	key, err := s.collectionKey(id)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.requestContext()
+	defer cancel()
 	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s.bucket),
-		Key:    aws.String(applyS3Prefix(s.prefix, id+".json")),
+		Key:    aws.String(key),
 	}); err != nil {
-		log.Printf("[ERROR] S3 Delete: failed to delete metadata for %s: %v", id, err)
-		return fmt.Errorf("failed to delete metadata for %s: %w", id, err)
+		log.Printf("[ERROR] S3 DeleteCollection: failed to delete %s: %v", id, err)
+		return err
 	}
 	return nil
 }
 
-func (s *S3Store) IncrementReadCount(id string) error {
-	paste, err := s.Get(id)
+// tokenKeys returns the key of the share token and of its marker in the
+// index of its paste.
+func (s *S3Store) tokenKeys(token, slug string) (tokenKey, indexKey string, err error) {
+	if !ValidToken(token) || !utils.IsValidSlug(slug) {
+		return "", "", errInvalidToken
+	}
+	return applyS3Prefix(s.prefix, tokenDir+"/"+token+".json"),
+		applyS3Prefix(s.prefix, tokenDir+"/"+slug+"/"+token), nil
+}
+
+// StoreToken implements TokenStore.
+func (s *S3Store) StoreToken(t *models.ShareToken) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	key, idx, err := s.tokenKeys(t.Token, t.Slug)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.requestContext()
+	defer cancel()
+	if _, err := s.putObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	}); err != nil {
+		log.Printf("[ERROR] S3 StoreToken: failed to put token for %s: %v", t.Slug, err)
+		return err
+	}
+	if _, err := s.putObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(idx),
+		Body:   bytes.NewReader(nil),
+	}); err != nil {
+		log.Printf("[ERROR] S3 StoreToken: failed to index token for %s: %v", t.Slug, err)
+		return err
+	}
+	return nil
+}
+
+// GetToken implements TokenStore.
+func (s *S3Store) GetToken(token string) (*models.ShareToken, error) {
+	if !ValidToken(token) {
+		return nil, errInvalidToken
+	}
+	ctx, cancel := s.requestContext()
+	defer cancel()
+	obj, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(applyS3Prefix(s.prefix, tokenDir+"/"+token+".json")),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			if code := apiErr.ErrorCode(); code == "NoSuchKey" || code == "NotFound" || code == "404" {
+				return nil, ErrNotFound
+			}
+		}
+		log.Printf("[ERROR] S3 GetToken: failed to get token: %v", err)
+		return nil, err
+	}
+	defer func() { _ = obj.Body.Close() }()
+	data, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return nil, err
+	}
+	var t models.ShareToken
+	if err := json.Unmarshal(data, &t); err != nil {
+		log.Printf("[ERROR] S3 GetToken: failed to unmarshal token: %v", err)
+		return nil, err
+	}
+	return &t, nil
+}
+
+// DeleteToken implements TokenStore.
+func (s *S3Store) DeleteToken(t *models.ShareToken) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	key, idx, err := s.tokenKeys(t.Token, t.Slug)
 	if err != nil {
 		return err
 	}
-	paste.ReadCount++
-	return s.Store(paste)
+	ctx, cancel := s.requestContext()
+	defer cancel()
+	for _, k := range []string{key, idx} {
+		if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(k),
+		}); err != nil {
+			log.Printf("[ERROR] S3 DeleteToken: failed to delete token of %s: %v", t.Slug, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// ListTokens implements TokenStore. Index markers whose token is gone are
+// skipped.
+func (s *S3Store) ListTokens(slug string) ([]*models.ShareToken, error) {
+	if !utils.IsValidSlug(slug) {
+		return nil, errInvalidToken
+	}
+	listPrefix := applyS3Prefix(s.prefix, tokenDir+"/"+slug+"/")
+	in := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(listPrefix),
+	}
+	var names []string
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		out, err := s.client.ListObjectsV2(ctx, in)
+		cancel()
+		if err != nil {
+			log.Printf("[ERROR] S3 ListTokens: failed to list %s: %v", listPrefix, err)
+			return nil, err
+		}
+		for _, obj := range out.Contents {
+			names = append(names, strings.TrimPrefix(aws.ToString(obj.Key), listPrefix))
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			break
+		}
+		in.ContinuationToken = out.NextContinuationToken
+	}
+	var tokens []*models.ShareToken
+	for _, name := range names {
+		t, err := s.GetToken(name)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) || errors.Is(err, errInvalidToken) {
+				continue
+			}
+			return nil, err
+		}
+		if t.Slug == slug {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+
+// certKey returns the key of the certificate stored as name.
+func (s *S3Store) certKey(name string) (string, error) {
+	if !validCertName(name) {
+		return "", errInvalidCertName
+	}
+	return applyS3Prefix(s.prefix, certDir+"/"+name), nil
+}
+
+// GetCert implements CertStore.
+func (s *S3Store) GetCert(name string) ([]byte, error) {
+	key, err := s.certKey(name)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.requestContext()
+	defer cancel()
+	obj, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			if code := apiErr.ErrorCode(); code == "NoSuchKey" || code == "NotFound" || code == "404" {
+				return nil, ErrNotFound
+			}
+		}
+		log.Printf("[ERROR] S3 GetCert: failed to get %s: %v", name, err)
+		return nil, err
+	}
+	defer func() { _ = obj.Body.Close() }()
+	return io.ReadAll(obj.Body)
+}
+
+// PutCert implements CertStore.
+func (s *S3Store) PutCert(name string, data []byte) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	key, err := s.certKey(name)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.requestContext()
+	defer cancel()
+	if _, err := s.putObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	}); err != nil {
+		log.Printf("[ERROR] S3 PutCert: failed to put %s: %v", name, err)
+		return err
+	}
+	return nil
+}
+
+// DeleteCert implements CertStore.
+func (s *S3Store) DeleteCert(name string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	key, err := s.certKey(name)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.requestContext()
+	defer cancel()
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}); err != nil {
+		log.Printf("[ERROR] S3 DeleteCert: failed to delete %s: %v", name, err)
+		return err
+	}
+	return nil
+}
+
+// List returns a page of slugs in ascending key order. Tag and checksum
+// listings read the prefix of their index; other listings read metadata
+// keys at the top level of the prefix, skipping content objects and the
+// indexes.
+func (s *S3Store) List(opts ListOptions) (ListPage, error) {
+	if err := checkChecksumListing(opts); err != nil {
+		return ListPage{}, err
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	listPrefix, suffix := applyS3Prefix(s.prefix, ""), ".json"
+	if opts.Tag != "" {
+		if !utils.IsValidTag(opts.Tag) {
+			return ListPage{}, errInvalidTag
+		}
+		listPrefix, suffix = applyS3Prefix(s.prefix, tagIndexDir+"/"+opts.Tag+"/"), ""
+	} else if opts.Checksum != "" {
+		listPrefix, suffix = applyS3Prefix(s.prefix, checksumIndexDir+"/"+opts.Checksum+"/"), ""
+	}
+	in := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(listPrefix),
+		Delimiter: aws.String("/"),
+	}
+	if opts.Cursor != "" {
+		in.StartAfter = aws.String(listPrefix + opts.Cursor + suffix)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var ids []string
+	for {
+		out, err := s.client.ListObjectsV2(ctx, in)
+		if err != nil {
+			log.Printf("[ERROR] S3 List: failed to list %s: %v", listPrefix, err)
+			return ListPage{}, err
+		}
+		for _, obj := range out.Contents {
+			name := strings.TrimPrefix(aws.ToString(obj.Key), listPrefix)
+			if suffix != "" {
+				if !strings.HasSuffix(name, suffix) {
+					continue
+				}
+				name = strings.TrimSuffix(name, suffix)
+			}
+			if isPasteKey(name) {
+				ids = append(ids, name)
+			}
+		}
+		// Collect one extra id to know whether another page exists.
+		if len(ids) > limit || !aws.ToBool(out.IsTruncated) {
+			break
+		}
+		in.ContinuationToken = out.NextContinuationToken
+	}
+	return pageFromSorted(ids, opts.Cursor, limit), nil
+}
+
+// ListObjects implements ObjectLister. Objects below the top level of the
+// prefix, such as the tag index, are not listed.
+func (s *S3Store) ListObjects(fn func(Object) error) error {
+	listPrefix := applyS3Prefix(s.prefix, "")
+	in := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(listPrefix),
+		Delimiter: aws.String("/"),
+	}
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		out, err := s.client.ListObjectsV2(ctx, in)
+		cancel()
+		if err != nil {
+			log.Printf("[ERROR] S3 ListObjects: failed to list %s: %v", listPrefix, err)
+			return err
+		}
+		for _, obj := range out.Contents {
+			name := strings.TrimPrefix(aws.ToString(obj.Key), listPrefix)
+			if name == "" || strings.HasPrefix(name, ".") {
+				continue
+			}
+			if err := fn(Object{Name: name, Size: aws.ToInt64(obj.Size), ModTime: aws.ToTime(obj.LastModified)}); err != nil {
+				return err
+			}
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			return nil
+		}
+		in.ContinuationToken = out.NextContinuationToken
+	}
 }
 
 func (s *S3Store) StoreContent(id string, content []byte) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	ctx, cancel := s.contentContext()
 	defer cancel()
-	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+	_, err := s.putObject(ctx, &s3.PutObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(applyS3Prefix(s.prefix, id)),
 		Body:   bytes.NewReader(content),
@@ -215,8 +889,32 @@ func (s *S3Store) StoreContent(id string, content []byte) error {
 	return err
 }
 
+// CreateContent implements ContentCreator with a conditional put
+// (If-None-Match: *), which S3 rejects when the object exists.
+func (s *S3Store) CreateContent(id string, content []byte) (bool, error) {
+	if s.readOnly {
+		return false, ErrReadOnly
+	}
+	ctx, cancel := s.contentContext()
+	defer cancel()
+	_, err := s.putObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(applyS3Prefix(s.prefix, id)),
+		Body:        bytes.NewReader(content),
+		IfNoneMatch: aws.String("*"),
+	})
+	if errConditionFailed(err) {
+		return false, nil
+	}
+	if err != nil {
+		log.Printf("[ERROR] S3 CreateContent: failed to put content for %s: %v", id, err)
+		return false, err
+	}
+	return true, nil
+}
+
 func (s *S3Store) GetContent(id string) ([]byte, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := s.contentContext()
 	defer cancel()
 	obj, err := s.client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
@@ -239,9 +937,25 @@ func (s *S3Store) GetContent(id string) ([]byte, error) {
 	return data, nil
 }
 
+// OpenContent implements ContentOpener. The body may be read for up to
+// five minutes.
+func (s *S3Store) OpenContent(id string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	obj, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(applyS3Prefix(s.prefix, id)),
+	})
+	if err != nil {
+		cancel()
+		log.Printf("[ERROR] S3 OpenContent: failed to get content for %s: %v", id, err)
+		return nil, err
+	}
+	return &cancelOnClose{ReadCloser: obj.Body, cancel: cancel}, nil
+}
+
 // StatContent checks if the object exists in S3 and returns its size.
 func (s *S3Store) StatContent(id string) (bool, int64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := s.headContext()
 	defer cancel()
 	key := applyS3Prefix(s.prefix, id)
 	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
@@ -274,7 +988,7 @@ func (s *S3Store) GetContentPrefix(id string, n int64) ([]byte, error) {
 	if n <= 0 {
 		return []byte{}, nil
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := s.contentContext()
 	defer cancel()
 	key := applyS3Prefix(s.prefix, id)
 	// Range header is inclusive: bytes=0-(n-1)
@@ -301,6 +1015,13 @@ func (s *S3Store) GetContentPrefix(id string, n int64) ([]byte, error) {
 	return data, nil
 }
 
+// Close flushes buffered read counts and saves the slug index.
 func (s *S3Store) Close() error {
+	if s.reads != nil {
+		s.reads.flushAll(s.addReads)
+	}
+	if s.slugs != nil {
+		s.flushSlugIndex()
+	}
 	return nil
 }
@@ -0,0 +1,36 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/storage"
+	"github.com/johnwmail/nclip/storage/conformancetest"
+)
+
+// TestConformance_S3 runs the conformance suite against the bucket named by
+// NCLIP_TEST_S3_BUCKET, using the default AWS configuration (set
+// AWS_ENDPOINT_URL_S3 for MinIO or LocalStack). Each subtest uses its own
+// prefix; objects it leaves behind are not removed, so use a scratch bucket.
+//
+//	NCLIP_TEST_S3_BUCKET=nclip-test go test -tags integration ./storage/
+func TestConformance_S3(t *testing.T) {
+	bucket := os.Getenv("NCLIP_TEST_S3_BUCKET")
+	if bucket == "" {
+		t.Skip("NCLIP_TEST_S3_BUCKET not set")
+	}
+	run := time.Now().UTC().Format("20060102T150405")
+	n := 0
+	conformancetest.Run(t, func(t *testing.T) storage.PasteStore {
+		n++
+		store, err := storage.NewS3Store(bucket, fmt.Sprintf("conformance/%s/%d", run, n))
+		if err != nil {
+			t.Fatalf("NewS3Store: %v", err)
+		}
+		return store
+	})
+}
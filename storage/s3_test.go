@@ -1,7 +1,22 @@
 package storage
 
 import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johnwmail/nclip/models"
 )
 
 func TestNewS3Store_EmptyBucket(t *testing.T) {
@@ -25,4 +40,510 @@ func TestS3Store_Constructor(t *testing.T) {
 	}
 }
 
-// Do not call S3Store methods that require a real client
+// fakeS3 is an in-memory S3 bucket serving path-style GetObject,
+// PutObject, DeleteObject, DeleteObjects and single-page ListObjectsV2,
+// with ETags and If-Match.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	etags   map[string]string
+	version int
+	puts    int
+	// beforePut, when set, runs before each PutObject is applied.
+	beforePut func(key string)
+	// deleteCalls counts DeleteObjects calls; failDeletes[key] is the
+	// number of calls that still fail to delete key.
+	deleteCalls int
+	failDeletes map[string]int
+	// putHeader holds the headers of the last PutObject; rejectPuts, when
+	// set, is the error code every PutObject fails with.
+	putHeader  http.Header
+	rejectPuts string
+	// slowDowns is the number of requests still answered with a 503
+	// SlowDown.
+	slowDowns int
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	f.mu.Lock()
+	slowDown := f.slowDowns > 0
+	if slowDown {
+		f.slowDowns--
+	}
+	f.mu.Unlock()
+	if slowDown {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = io.WriteString(w, `<Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>`)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if r.URL.Query().Get("list-type") == "2" {
+			f.listObjects(w, r)
+			return
+		}
+		f.mu.Lock()
+		data, ok := f.objects[key]
+		etag := f.etags[key]
+		f.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write(data)
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		if f.beforePut != nil {
+			f.beforePut(key)
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.putHeader = r.Header.Clone()
+		if f.rejectPuts != "" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, `<Error><Code>%s</Code><Message>rejected</Message></Error>`, f.rejectPuts)
+			return
+		}
+		_, exists := f.objects[key]
+		if m := r.Header.Get("If-Match"); (m != "" && m != f.etags[key]) || (r.Header.Get("If-None-Match") == "*" && exists) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			_, _ = io.WriteString(w, `<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>`)
+			return
+		}
+		f.set(key, data)
+		f.puts++
+		w.Header().Set("ETag", f.etags[key])
+	case http.MethodPost:
+		if _, ok := r.URL.Query()["delete"]; !ok {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		f.deleteObjects(w, r)
+	case http.MethodDelete:
+		f.mu.Lock()
+		delete(f.objects, key)
+		delete(f.etags, key)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// deleteObjects serves DeleteObjects, failing the keys in failDeletes.
+func (f *fakeS3) deleteObjects(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Objects []struct {
+			Key string `xml:"Key"`
+		} `xml:"Object"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleteCalls++
+	var out strings.Builder
+	out.WriteString(`<DeleteResult>`)
+	for _, o := range req.Objects {
+		if f.failDeletes[o.Key] > 0 {
+			f.failDeletes[o.Key]--
+			fmt.Fprintf(&out, `<Error><Key>%s</Key><Code>InternalError</Code><Message>try again</Message></Error>`, o.Key)
+			continue
+		}
+		delete(f.objects, o.Key)
+		delete(f.etags, o.Key)
+	}
+	out.WriteString(`</DeleteResult>`)
+	_, _ = io.WriteString(w, out.String())
+}
+
+// listObjects serves ListObjectsV2 of the top level of the prefix.
+func (f *fakeS3) listObjects(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	f.mu.Lock()
+	var keys []string
+	for key := range f.objects {
+		if rest, ok := strings.CutPrefix(key, prefix); ok && !strings.Contains(rest, "/") {
+			keys = append(keys, key)
+		}
+	}
+	sizes := make(map[string]int, len(keys))
+	for _, key := range keys {
+		sizes[key] = len(f.objects[key])
+	}
+	f.mu.Unlock()
+	sort.Strings(keys)
+	var out strings.Builder
+	out.WriteString(`<ListBucketResult><IsTruncated>false</IsTruncated>`)
+	for _, key := range keys {
+		fmt.Fprintf(&out, `<Contents><Key>%s</Key><Size>%d</Size></Contents>`, key, sizes[key])
+	}
+	out.WriteString(`</ListBucketResult>`)
+	_, _ = io.WriteString(w, out.String())
+}
+
+// set stores data under key with a new ETag. Callers must hold f.mu.
+func (f *fakeS3) set(key string, data []byte) {
+	f.version++
+	f.objects[key] = data
+	f.etags[key] = fmt.Sprintf(`"v%d"`, f.version)
+}
+
+// newFakeS3Store returns an S3Store backed by a fakeS3 holding the
+// metadata of paste.
+func newFakeS3Store(t *testing.T, paste *models.Paste) (*S3Store, *fakeS3) {
+	t.Helper()
+	f := &fakeS3{objects: map[string][]byte{}, etags: map[string]string{}}
+	data, err := json.Marshal(paste)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.set(paste.ID+".json", data)
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	client := s3.New(s3.Options{
+		BaseEndpoint:               aws.String(srv.URL),
+		UsePathStyle:               true,
+		Region:                     "us-east-1",
+		Credentials:                aws.AnonymousCredentials{},
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+	})
+	return &S3Store{bucket: "bucket", client: client}, f
+}
+
+func TestS3Store_IncrementReads_Conditional(t *testing.T) {
+	store, f := newFakeS3Store(t, &models.Paste{ID: "RDS22", CreatedAt: time.Now()})
+	store.SetReadCounting(ReadCountConditional, time.Minute)
+	// Another instance records a read between our read and write, twice.
+	conflicts := 2
+	f.beforePut = func(key string) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if conflicts == 0 {
+			return
+		}
+		conflicts--
+		var p models.Paste
+		_ = json.Unmarshal(f.objects[key], &p)
+		p.RecordRead(models.ReadView)
+		data, _ := json.Marshal(&p)
+		f.set(key, data)
+	}
+	if err := store.IncrementReads("RDS22", models.ReadRaw); err != nil {
+		t.Fatalf("IncrementReads: %v", err)
+	}
+	p, err := store.Get("RDS22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ReadCount != 3 || p.Views != 2 || p.RawReads != 1 {
+		t.Errorf("counts after conflicting writes: read_count=%d views=%d raw_reads=%d; want 3, 2, 1", p.ReadCount, p.Views, p.RawReads)
+	}
+}
+
+func TestS3Store_IncrementReads_Buffered(t *testing.T) {
+	store, f := newFakeS3Store(t, &models.Paste{ID: "RDS22", CreatedAt: time.Now()})
+	store.SetReadCounting(ReadCountBuffered, time.Minute)
+	now := time.Now()
+	store.reads.now = func() time.Time { return now }
+	readCount := func() int {
+		t.Helper()
+		p, err := store.Get("RDS22")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.ReadCount
+	}
+
+	// The first read is written at once, the next ones wait for the
+	// interval.
+	for i := 0; i < 5; i++ {
+		if err := store.IncrementReads("RDS22", models.ReadRaw); err != nil {
+			t.Fatalf("IncrementReads: %v", err)
+		}
+	}
+	if got := readCount(); got != 1 || f.puts != 1 {
+		t.Fatalf("after 5 reads within the interval: read_count=%d puts=%d; want 1, 1", got, f.puts)
+	}
+	now = now.Add(time.Minute)
+	if err := store.IncrementReads("RDS22", models.ReadView); err != nil {
+		t.Fatalf("IncrementReads: %v", err)
+	}
+	if got := readCount(); got != 6 || f.puts != 2 {
+		t.Fatalf("after the interval: read_count=%d puts=%d; want 6, 2", got, f.puts)
+	}
+	if err := store.IncrementReads("RDS22", models.ReadView); err != nil {
+		t.Fatalf("IncrementReads: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := store.Get("RDS22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ReadCount != 7 || p.RawReads != 5 || p.Views != 2 {
+		t.Errorf("after Close: read_count=%d raw_reads=%d views=%d; want 7, 5, 2", p.ReadCount, p.RawReads, p.Views)
+	}
+}
+
+func TestS3Store_DeleteBatch(t *testing.T) {
+	backoff := s3DeleteBackoff
+	s3DeleteBackoff = 0
+	t.Cleanup(func() { s3DeleteBackoff = backoff })
+
+	store, f := newFakeS3Store(t, &models.Paste{ID: "DELAA", CreatedAt: time.Now(), Versions: []models.PasteVersion{{Number: 1}}})
+	data, _ := json.Marshal(&models.Paste{ID: "DELBB", CreatedAt: time.Now()})
+	f.mu.Lock()
+	f.set("DELBB.json", data)
+	for _, key := range []string{"DELAA", "DELAA.png", "DELAA.v1", "DELBB"} {
+		f.set(key, []byte("x"))
+	}
+	// DELAA's content fails once and is retried; DELBB's content and
+	// DELAA's preview fail on every attempt.
+	f.failDeletes = map[string]int{"DELAA": 1, "DELBB": 10, "DELAA.png": 10}
+	f.mu.Unlock()
+
+	var progress []int
+	errs := store.DeleteBatch([]string{"DELAA", "DELBB"}, func(done, total int) {
+		if total != 2 {
+			t.Errorf("progress total = %d, want 2", total)
+		}
+		progress = append(progress, done)
+	})
+	if len(errs) != 1 || errs["DELBB"] == nil {
+		t.Fatalf("DeleteBatch errors = %v, want only DELBB", errs)
+	}
+	if len(progress) != 2 || progress[1] != 2 {
+		t.Errorf("progress = %v, want [1 2]", progress)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.deleteCalls != s3DeleteAttempts {
+		t.Errorf("DeleteObjects calls = %d, want %d", f.deleteCalls, s3DeleteAttempts)
+	}
+	for _, key := range []string{"DELAA", "DELAA.json", "DELAA.v1", "DELBB.json"} {
+		if _, ok := f.objects[key]; ok {
+			t.Errorf("%s was not deleted", key)
+		}
+	}
+	for _, key := range []string{"DELBB", "DELAA.png"} {
+		if _, ok := f.objects[key]; !ok {
+			t.Errorf("%s was deleted despite failing", key)
+		}
+	}
+}
+
+func TestS3Store_MigrateMeta(t *testing.T) {
+	store, f := newFakeS3Store(t, &models.Paste{ID: "CRRNT", CreatedAt: time.Now()})
+	old := `{"id":"LEGACY","created_at":"2099-06-01T09:00:00+02:00","read_count":1}`
+	f.set("LEGACY.json", []byte(old))
+	// A read is counted by a running instance, in the old schema, between
+	// the migration's read and write.
+	f.beforePut = func(key string) {
+		f.beforePut = nil
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.set(key, []byte(strings.Replace(old, `"read_count":1`, `"read_count":2`, 1)))
+	}
+
+	if upgraded, err := store.migrateMeta("LEGACY", true); err != nil || !upgraded || f.puts != 0 {
+		t.Fatalf("dry run = %t, %v with %d puts", upgraded, err, f.puts)
+	}
+	if upgraded, err := store.migrateMeta("LEGACY", false); err != nil || !upgraded {
+		t.Fatalf("migrateMeta = %t, %v", upgraded, err)
+	}
+	if schema, _ := storedSchema(f.objects["LEGACY.json"]); schema != models.MetadataSchema {
+		t.Errorf("stored schema = %d: %s", schema, f.objects["LEGACY.json"])
+	}
+	p, err := store.Get("LEGACY")
+	if err != nil || p.ReadCount != 2 || !strings.Contains(string(f.objects["LEGACY.json"]), `"2099-06-01T07:00:00Z"`) {
+		t.Errorf("migrated paste = %+v, %v: %s", p, err, f.objects["LEGACY.json"])
+	}
+
+	for _, id := range []string{"CRRNT", "MSSNG"} {
+		if upgraded, err := store.migrateMeta(id, false); err != nil || upgraded {
+			t.Errorf("migrateMeta(%s) = %t, %v; want nothing to do", id, upgraded, err)
+		}
+	}
+}
+
+func TestS3Store_SlugIndex(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	store, f := newFakeS3Store(t, &models.Paste{ID: "TAKEN", CreatedAt: now})
+	store.slugs = &s3SlugIndex{now: clock}
+	other := &S3Store{bucket: "bucket", client: store.client, slugs: &s3SlugIndex{now: clock}}
+
+	if !store.MayExist("FREEE") {
+		t.Fatal("MayExist before the index is loaded must be true")
+	}
+	// No persisted index yet: the first sync lists the bucket.
+	store.syncSlugIndex()
+	if !store.MayExist("TAKEN") || store.MayExist("FREEE") {
+		t.Fatalf("rebuilt index: MayExist(TAKEN)=%t MayExist(FREEE)=%t", store.MayExist("TAKEN"), store.MayExist("FREEE"))
+	}
+	if _, ok := f.objects[slugIndexKey]; !ok {
+		t.Fatal("rebuilt index was not persisted")
+	}
+
+	// Another environment loads the persisted index, then learns the
+	// slugs stored elsewhere once they are saved.
+	other.syncSlugIndex()
+	if !other.MayExist("TAKEN") {
+		t.Error("loaded index is missing TAKEN")
+	}
+	if err := store.Store(&models.Paste{ID: "NEWWW", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if !store.MayExist("NEWWW") {
+		t.Error("stored slug missing from the local index")
+	}
+	if other.MayExist("NEWWW") {
+		t.Fatal("NEWWW known elsewhere before it was saved")
+	}
+	store.syncSlugIndex()
+	other.syncSlugIndex()
+	if !other.MayExist("NEWWW") {
+		t.Error("saved slug not picked up by the other environment")
+	}
+
+	// Deleted slugs are forgotten when the index is rebuilt after a day.
+	f.mu.Lock()
+	delete(f.objects, "TAKEN.json")
+	f.mu.Unlock()
+	now = now.Add(slugIndexMaxAge + time.Minute)
+	store.syncSlugIndex()
+	if store.MayExist("TAKEN") || !store.MayExist("NEWWW") {
+		t.Errorf("after rebuild: MayExist(TAKEN)=%t MayExist(NEWWW)=%t", store.MayExist("TAKEN"), store.MayExist("NEWWW"))
+	}
+}
+
+func TestS3Store_CreateContent(t *testing.T) {
+	store, f := newFakeS3Store(t, &models.Paste{ID: "CRT22", CreatedAt: time.Now()})
+	if created, err := store.CreateContent("0123abcd.link", []byte("first")); err != nil || !created {
+		t.Fatalf("CreateContent = %v, %v; want true, nil", created, err)
+	}
+	if got := f.putHeader.Get("If-None-Match"); got != "*" {
+		t.Errorf("expected a conditional put, got If-None-Match %q", got)
+	}
+	if created, err := store.CreateContent("0123abcd.link", []byte("second")); err != nil || created {
+		t.Fatalf("CreateContent(existing) = %v, %v; want false, nil", created, err)
+	}
+	if got := string(f.objects["0123abcd.link"]); got != "first" {
+		t.Errorf("expected the first content kept, got %q", got)
+	}
+}
+
+func TestS3Store_PutOptions(t *testing.T) {
+	store, f := newFakeS3Store(t, &models.Paste{ID: "PUT22", CreatedAt: time.Now()})
+	if err := store.SetPutOptions(S3PutOptions{StorageClass: "COLD"}); err == nil {
+		t.Error("expected an unknown storage class to be rejected")
+	}
+	if err := store.SetPutOptions(S3PutOptions{ACL: "everyone"}); err == nil {
+		t.Error("expected an unknown ACL to be rejected")
+	}
+	opts := S3PutOptions{KMSKeyID: "alias/nclip", StorageClass: "INTELLIGENT_TIERING"}
+	if err := store.SetPutOptions(opts); err != nil {
+		t.Fatalf("SetPutOptions: %v", err)
+	}
+
+	if err := store.StoreContent("PUT22", []byte("hello")); err != nil {
+		t.Fatalf("StoreContent: %v", err)
+	}
+	for header, want := range map[string]string{
+		"X-Amz-Server-Side-Encryption":                "aws:kms",
+		"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "alias/nclip",
+		"X-Amz-Storage-Class":                         "INTELLIGENT_TIERING",
+		"X-Amz-Acl":                                   "",
+	} {
+		if got := f.putHeader.Get(header); got != want {
+			t.Errorf("%s: expected %q, got %q", header, want, got)
+		}
+	}
+
+	if err := store.CheckWrite(); err != nil {
+		t.Fatalf("CheckWrite: %v", err)
+	}
+	if _, ok := f.objects[writeCheckKey]; ok {
+		t.Error("expected the write check object to be removed")
+	}
+
+	f.rejectPuts = "AccessDenied"
+	err := store.CheckWrite()
+	if err == nil || !strings.Contains(err.Error(), "kms:GenerateDataKey") {
+		t.Errorf("expected a rejected write to fail with a hint, got %v", err)
+	}
+}
+
+func TestS3Store_RetryStats(t *testing.T) {
+	if err := (S3ClientOptions{RetryMode: "eager"}).Validate(); err == nil {
+		t.Error("expected an unknown retry mode to be rejected")
+	}
+	if err := (S3ClientOptions{MaxAttempts: 11}).Validate(); err == nil {
+		t.Error("expected too many attempts to be rejected")
+	}
+	if r := newRetryer(S3ClientOptions{RetryMode: S3RetryAdaptive, MaxAttempts: 5}, &retryCounters{}); r.MaxAttempts() != 5 {
+		t.Errorf("expected 5 attempts, got %d", r.MaxAttempts())
+	}
+
+	store, f := newFakeS3Store(t, &models.Paste{ID: "THR22", CreatedAt: time.Now()})
+	store.retries = &retryCounters{}
+	// Retry without backoff so the test does not wait.
+	retryer := countingRetryer{
+		RetryerV2: retry.NewStandard(func(o *retry.StandardOptions) {
+			o.MaxAttempts = 3
+			o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) { return 0, nil })
+		}),
+		counts: store.retries,
+	}
+	store.client = s3.New(store.client.Options(), func(o *s3.Options) { o.Retryer = retryer })
+
+	f.slowDowns = 2
+	if _, err := store.Get("THR22"); err != nil {
+		t.Fatalf("expected the read to succeed on the third attempt, got %v", err)
+	}
+	if st := store.RetryStats(); st.Retries != 2 || st.Throttled != 2 || st.RetryMode != S3RetryStandard {
+		t.Errorf("unexpected stats after two throttled attempts: %+v", st)
+	}
+
+	f.slowDowns = 5
+	if _, err := store.Get("THR22"); err == nil {
+		t.Error("expected the read to fail once the attempts run out")
+	}
+	if st := store.RetryStats(); st.Retries != 4 || st.Throttled != 5 {
+		t.Errorf("unexpected stats after three more throttled attempts: %+v", st)
+	}
+}
+
+func TestS3Store_ConnStats(t *testing.T) {
+	if err := (S3ClientOptions{MaxIdleConns: 1001}).Validate(); err == nil {
+		t.Error("expected too many idle connections to be rejected")
+	}
+
+	store, _ := newFakeS3Store(t, &models.Paste{ID: "CNN22", CreatedAt: time.Now()})
+	store.conns = &connCounters{}
+	store.client = s3.New(store.client.Options(), func(o *s3.Options) {
+		o.HTTPClient = newHTTPClient(S3ClientOptions{}.withDefaults())
+	}, countConns(store.conns))
+
+	n := store.Prewarm(4)
+	if n < 1 || n > 4 {
+		t.Fatalf("expected 1 to 4 prewarmed connections, got %d", n)
+	}
+	for range 3 {
+		if _, err := store.Get("CNN22"); err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+	}
+	st := store.ConnStats()
+	if st.Prewarmed != int64(n) || st.Opened != int64(n) || st.Reused < 3 || st.MaxIdleConns != DefaultS3MaxIdleConns {
+		t.Errorf("expected the reads to reuse the %d prewarmed connections, got %+v", n, st)
+	}
+}
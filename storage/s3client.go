@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+// S3 retry modes.
+const (
+	// S3RetryStandard retries with exponential backoff; it is the SDK's
+	// default.
+	S3RetryStandard = "standard"
+	// S3RetryAdaptive also slows the client's own request rate while S3
+	// throttles it, so a burst of uploads backs off instead of retrying
+	// into the throttling.
+	S3RetryAdaptive = "adaptive"
+)
+
+// Defaults for S3ClientOptions.
+const (
+	DefaultS3MaxAttempts    = 3
+	DefaultS3HeadTimeout    = 3 * time.Second
+	DefaultS3RequestTimeout = 10 * time.Second
+	DefaultS3ContentTimeout = 30 * time.Second
+)
+
+// maxS3Attempts bounds MaxAttempts.
+const maxS3Attempts = 10
+
+// throttleLogInterval bounds how often throttling is logged.
+const throttleLogInterval = time.Minute
+
+// S3ClientOptions tune how an S3Store retries failed requests, how long
+// it waits for them and how many connections it keeps open. Each timeout
+// covers every attempt of a request, so a throttled request fails within
+// it rather than retrying for long. Zero fields keep the defaults.
+type S3ClientOptions struct {
+	// RetryMode is S3RetryStandard (the default) or S3RetryAdaptive.
+	RetryMode string
+	// MaxAttempts bounds the attempts of one request, the first included.
+	MaxAttempts int
+	// HeadTimeout bounds existence and size checks, which sit in front of
+	// most requests and are cheap to answer.
+	HeadTimeout time.Duration
+	// RequestTimeout bounds metadata reads and writes and the other small
+	// requests.
+	RequestTimeout time.Duration
+	// ContentTimeout bounds reads and writes of paste content.
+	ContentTimeout time.Duration
+	// MaxIdleConns bounds the idle connections kept to S3 for later
+	// requests, and IdleConnTimeout how long each is kept.
+	MaxIdleConns    int
+	IdleConnTimeout time.Duration
+}
+
+// Validate checks the retry mode and bounds.
+func (o S3ClientOptions) Validate() error {
+	if o.RetryMode != "" && o.RetryMode != S3RetryStandard && o.RetryMode != S3RetryAdaptive {
+		return fmt.Errorf("unknown S3 retry mode %q: want standard or adaptive", o.RetryMode)
+	}
+	if o.MaxAttempts < 0 || o.MaxAttempts > maxS3Attempts {
+		return fmt.Errorf("S3 max attempts must be between 1 and %d, got %d", maxS3Attempts, o.MaxAttempts)
+	}
+	if o.HeadTimeout < 0 || o.RequestTimeout < 0 || o.ContentTimeout < 0 || o.IdleConnTimeout < 0 {
+		return fmt.Errorf("S3 timeouts must not be negative")
+	}
+	if o.MaxIdleConns < 0 || o.MaxIdleConns > maxS3IdleConns {
+		return fmt.Errorf("S3 idle connections must be between 1 and %d, got %d", maxS3IdleConns, o.MaxIdleConns)
+	}
+	return nil
+}
+
+// withDefaults returns o with its zero fields set to the defaults.
+func (o S3ClientOptions) withDefaults() S3ClientOptions {
+	if o.RetryMode == "" {
+		o.RetryMode = S3RetryStandard
+	}
+	if o.MaxAttempts == 0 {
+		o.MaxAttempts = DefaultS3MaxAttempts
+	}
+	if o.HeadTimeout == 0 {
+		o.HeadTimeout = DefaultS3HeadTimeout
+	}
+	if o.RequestTimeout == 0 {
+		o.RequestTimeout = DefaultS3RequestTimeout
+	}
+	if o.ContentTimeout == 0 {
+		o.ContentTimeout = DefaultS3ContentTimeout
+	}
+	if o.MaxIdleConns == 0 {
+		o.MaxIdleConns = DefaultS3MaxIdleConns
+	}
+	if o.IdleConnTimeout == 0 {
+		o.IdleConnTimeout = DefaultS3IdleConnTimeout
+	}
+	return o
+}
+
+// S3RetryStats counts the retried requests of an S3Store since it was
+// created.
+type S3RetryStats struct {
+	RetryMode   string `json:"retry_mode"`
+	MaxAttempts int    `json:"max_attempts"`
+	// Retries counts attempts after the first.
+	Retries int64 `json:"retries"`
+	// Throttled counts attempts S3 answered with a throttling error, such
+	// as SlowDown or 503.
+	Throttled int64 `json:"throttled"`
+}
+
+// retryCounters are the counts behind S3RetryStats.
+type retryCounters struct {
+	retries   atomic.Int64
+	throttled atomic.Int64
+	// lastLog is when throttling was last logged (Unix seconds).
+	lastLog atomic.Int64
+}
+
+// countingRetryer counts the retries and throttling errors the retryer it
+// wraps sees.
+type countingRetryer struct {
+	aws.RetryerV2
+	counts *retryCounters
+}
+
+// throttles recognizes throttling errors like the adaptive retryer does.
+var throttles = retry.IsErrorThrottles(retry.DefaultThrottles)
+
+func (r countingRetryer) IsErrorRetryable(err error) bool {
+	if throttles.IsErrorThrottle(err) == aws.TrueTernary {
+		n := r.counts.throttled.Add(1)
+		now := time.Now().Unix()
+		last := r.counts.lastLog.Load()
+		if now-last >= int64(throttleLogInterval/time.Second) && r.counts.lastLog.CompareAndSwap(last, now) {
+			log.Printf("[WARN] S3: request throttled (%d throttled attempts so far): %v", n, err)
+		}
+	}
+	return r.RetryerV2.IsErrorRetryable(err)
+}
+
+func (r countingRetryer) RetryDelay(attempt int, err error) (time.Duration, error) {
+	r.counts.retries.Add(1)
+	return r.RetryerV2.RetryDelay(attempt, err)
+}
+
+// newRetryer returns the retryer for o, counting into counts.
+func newRetryer(o S3ClientOptions, counts *retryCounters) aws.Retryer {
+	standard := func(so *retry.StandardOptions) { so.MaxAttempts = o.MaxAttempts }
+	var base aws.RetryerV2
+	if o.RetryMode == S3RetryAdaptive {
+		base = retry.NewAdaptiveMode(func(ao *retry.AdaptiveModeOptions) {
+			ao.StandardOptions = append(ao.StandardOptions, standard)
+		})
+	} else {
+		base = retry.NewStandard(standard)
+	}
+	return countingRetryer{RetryerV2: base, counts: counts}
+}
+
+// RetryStats returns how many of the store's requests were retried or
+// throttled.
+func (s *S3Store) RetryStats() S3RetryStats {
+	o := s.opts.withDefaults()
+	st := S3RetryStats{RetryMode: o.RetryMode, MaxAttempts: o.MaxAttempts}
+	if s.retries != nil {
+		st.Retries = s.retries.retries.Load()
+		st.Throttled = s.retries.throttled.Load()
+	}
+	return st
+}
+
+// headContext bounds an existence or size check by the head timeout.
+func (s *S3Store) headContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.opts.withDefaults().HeadTimeout)
+}
+
+// requestContext bounds a small request by the request timeout.
+func (s *S3Store) requestContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.opts.withDefaults().RequestTimeout)
+}
+
+// contentContext bounds a content transfer by the content timeout.
+func (s *S3Store) contentContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.opts.withDefaults().ContentTimeout)
+}
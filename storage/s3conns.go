@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/johnwmail/nclip/internal/egress"
+)
+
+// Defaults for the connection settings of S3ClientOptions.
+const (
+	// DefaultS3MaxIdleConns keeps more idle connections to the bucket than
+	// the SDK's 10 per host, so a burst of concurrent requests does not
+	// close most of its connections and pay new TLS handshakes afterwards.
+	DefaultS3MaxIdleConns    = 64
+	DefaultS3IdleConnTimeout = 90 * time.Second
+	// s3KeepAlive is the TCP keep-alive period of connections to S3.
+	s3KeepAlive = 30 * time.Second
+)
+
+// maxS3IdleConns bounds MaxIdleConns.
+const maxS3IdleConns = 1000
+
+// prewarmKey is the object Prewarm asks for. It need not exist: any answer
+// from S3 leaves an open connection behind.
+const prewarmKey = ".prewarm"
+
+// S3ConnStats counts the connections an S3Store opened and reused since it
+// was created. A high Reused share means requests skip the TCP and TLS
+// handshakes.
+type S3ConnStats struct {
+	MaxIdleConns int `json:"max_idle_conns"`
+	// Opened counts requests that had to open a connection, Reused those
+	// sent on an idle one.
+	Opened int64 `json:"opened"`
+	Reused int64 `json:"reused"`
+	// Prewarmed counts connections opened by Prewarm.
+	Prewarmed int64 `json:"prewarmed"`
+	// TLSHandshakes and TLSHandshakeMillis are the number and total time of
+	// the TLS handshakes.
+	TLSHandshakes      int64 `json:"tls_handshakes"`
+	TLSHandshakeMillis int64 `json:"tls_handshake_ms"`
+}
+
+// connCounters are the counts behind S3ConnStats.
+type connCounters struct {
+	opened     atomic.Int64
+	reused     atomic.Int64
+	prewarmed  atomic.Int64
+	handshakes atomic.Int64
+	// handshakeTime is in microseconds.
+	handshakeTime atomic.Int64
+}
+
+// countingClient traces the connections of the requests it sends.
+type countingClient struct {
+	client aws.HTTPClient
+	counts *connCounters
+}
+
+func (c countingClient) Do(req *http.Request) (*http.Response, error) {
+	var start time.Time
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				c.counts.reused.Add(1)
+			} else {
+				c.counts.opened.Add(1)
+			}
+		},
+		TLSHandshakeStart: func() { start = time.Now() },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			c.counts.handshakes.Add(1)
+			c.counts.handshakeTime.Add(time.Since(start).Microseconds())
+		},
+	}
+	return c.client.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// newHTTPClient returns the HTTP client for o. It goes through the proxy
+// of the environment and trusts the roots of NCLIP_CA_BUNDLE. The AWS
+// configuration loader adds custom CA bundles to it, so it is wrapped in a
+// countingClient only once the S3 client is created (see countConns).
+func newHTTPClient(o S3ClientOptions) *awshttp.BuildableClient {
+	return awshttp.NewBuildableClient().
+		WithTransportOptions(func(t *http.Transport) {
+			t.MaxIdleConns = o.MaxIdleConns
+			t.MaxIdleConnsPerHost = o.MaxIdleConns
+			t.IdleConnTimeout = o.IdleConnTimeout
+			if roots := egress.RootCAs(); roots != nil {
+				t.TLSClientConfig.RootCAs = roots
+			}
+		}).
+		WithDialerOptions(func(d *net.Dialer) {
+			d.KeepAlive = s3KeepAlive
+		})
+}
+
+// countConns makes an S3 client count the connections of its requests
+// into counts.
+func countConns(counts *connCounters) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.HTTPClient = countingClient{client: o.HTTPClient, counts: counts}
+	}
+}
+
+// ConnStats returns how many connections the store's requests opened and
+// reused.
+func (s *S3Store) ConnStats() S3ConnStats {
+	st := S3ConnStats{MaxIdleConns: s.opts.withDefaults().MaxIdleConns}
+	if s.conns != nil {
+		st.Opened = s.conns.opened.Load()
+		st.Reused = s.conns.reused.Load()
+		st.Prewarmed = s.conns.prewarmed.Load()
+		st.TLSHandshakes = s.conns.handshakes.Load()
+		st.TLSHandshakeMillis = s.conns.handshakeTime.Load() / 1000
+	}
+	return st
+}
+
+// CheckConnection asks the bucket for an object, so a proxy or TLS
+// interception that keeps the store from reaching S3 is reported at
+// startup instead of on the first request. Any answer from S3, even a
+// denial, passes; so do network errors that may be transient, which are
+// only logged. The connection is kept for the requests that follow.
+func (s *S3Store) CheckConnection() error {
+	ctx, cancel := s.headContext()
+	defer cancel()
+	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(applyS3Prefix(s.prefix, prewarmKey)),
+	})
+	var apiErr smithy.APIError
+	if err == nil || errors.As(err, &apiErr) {
+		return nil
+	}
+	if egress.Hint(err) != "" {
+		return fmt.Errorf("cannot reach s3://%s: %w", s.bucket, egress.Explain(err))
+	}
+	log.Printf("[WARN] S3 connection check: %v", err)
+	return nil
+}
+
+// Prewarm opens up to n connections to the bucket at once and leaves them
+// idle for the requests that follow, so they skip the handshakes. Lambda
+// calls it during the init phase, before the first invocation. It returns
+// how many connections were opened; failures only mean fewer of them.
+func (s *S3Store) Prewarm(n int) int {
+	if s.conns == nil || n <= 0 {
+		return 0
+	}
+	before := s.conns.opened.Load()
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := s.headContext()
+			defer cancel()
+			_, _ = s.client.HeadObject(ctx, &s3.HeadObjectInput{
+				Bucket: aws.String(s.bucket),
+				Key:    aws.String(applyS3Prefix(s.prefix, prewarmKey)),
+			})
+		}()
+	}
+	wg.Wait()
+	opened := s.conns.opened.Load() - before
+	s.conns.prewarmed.Add(opened)
+	return int(opened)
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Batch deletes: DeleteObjects removes up to s3DeleteBatchSize keys per
+// call, s3DeleteConcurrency calls run at once, and keys S3 fails to delete
+// are retried up to s3DeleteAttempts times in all.
+const (
+	s3DeleteBatchSize   = 1000
+	s3DeleteConcurrency = 4
+	s3DeleteAttempts    = 3
+)
+
+// s3DeleteBackoff is the wait before the first retry of failed keys; it
+// grows with each attempt.
+var s3DeleteBackoff = 200 * time.Millisecond
+
+// s3DeleteKey is one object of a paste in a batch delete. Only the content
+// and metadata are required: like Delete, the batch treats the preview,
+// versions and tag index entries as best-effort.
+type s3DeleteKey struct {
+	id       string
+	key      string
+	required bool
+}
+
+// DeleteBatch implements BatchDeleter. It reads the metadata of ids in
+// parallel to find their versions and tag index entries, then deletes all
+// of their objects with DeleteObjects calls run by a small worker pool.
+func (s *S3Store) DeleteBatch(ids []string, progress DeleteProgress) map[string]error {
+	errs := map[string]error{}
+	if s.readOnly {
+		for _, id := range ids {
+			errs[id] = ErrReadOnly
+		}
+		return errs
+	}
+	metas := s.GetBatch(ids)
+	var keys []s3DeleteKey
+	remaining := map[string]int{}
+	add := func(id, key string, required bool) {
+		keys = append(keys, s3DeleteKey{id: id, key: key, required: required})
+		remaining[id]++
+	}
+	for _, id := range ids {
+		if _, dup := remaining[id]; dup {
+			continue
+		}
+		add(id, applyS3Prefix(s.prefix, id), true)
+		add(id, applyS3Prefix(s.prefix, id+".json"), true)
+		add(id, applyS3Prefix(s.prefix, id+PreviewSuffix), false)
+		paste := metas[id].Paste
+		if paste == nil {
+			continue
+		}
+		for _, v := range paste.Versions {
+			add(id, applyS3Prefix(s.prefix, VersionID(id, v.Number)), false)
+		}
+		for _, tag := range paste.Tags {
+			if key, err := s.tagKey(tag, id); err == nil {
+				add(id, key, false)
+			}
+		}
+		if key, ok := s.checksumKey(paste); ok {
+			add(id, key, false)
+		}
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		done int
+	)
+	total := len(remaining)
+	chunks := make(chan []s3DeleteKey)
+	for w := 0; w < s3DeleteConcurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range chunks {
+				failed := s.deleteKeys(chunk)
+				mu.Lock()
+				for _, k := range chunk {
+					if err, ok := failed[k.key]; ok {
+						if k.required {
+							if errs[k.id] == nil {
+								errs[k.id] = fmt.Errorf("failed to delete %s: %w", k.key, err)
+							}
+						} else {
+							log.Printf("[WARN] S3 DeleteBatch: failed to delete %s of %s: %v", k.key, k.id, err)
+						}
+					}
+					if remaining[k.id]--; remaining[k.id] == 0 {
+						done++
+						if progress != nil {
+							progress(done, total)
+						}
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for start := 0; start < len(keys); start += s3DeleteBatchSize {
+		chunks <- keys[start:min(start+s3DeleteBatchSize, len(keys))]
+	}
+	close(chunks)
+	wg.Wait()
+	for id, err := range errs {
+		log.Printf("[ERROR] S3 DeleteBatch: %s: %v", id, err)
+	}
+	return errs
+}
+
+// deleteKeys deletes keys with DeleteObjects, retrying the keys S3 reports
+// as failed, and returns the errors of those still not deleted.
+func (s *S3Store) deleteKeys(keys []s3DeleteKey) map[string]error {
+	pending := make([]string, len(keys))
+	for i, k := range keys {
+		pending[i] = k.key
+	}
+	for attempt := 1; ; attempt++ {
+		failed := s.deleteObjects(pending)
+		if len(failed) == 0 || attempt == s3DeleteAttempts {
+			return failed
+		}
+		pending = pending[:0]
+		for key := range failed {
+			pending = append(pending, key)
+		}
+		time.Sleep(s3DeleteBackoff * time.Duration(attempt))
+	}
+}
+
+// deleteObjects makes one DeleteObjects call and returns the errors of the
+// keys that were not deleted.
+func (s *S3Store) deleteObjects(keys []string) map[string]error {
+	objects := make([]types.ObjectIdentifier, len(keys))
+	for i, key := range keys {
+		objects[i] = types.ObjectIdentifier{Key: aws.String(key)}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	out, err := s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(s.bucket),
+		Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+	})
+	failed := map[string]error{}
+	if err != nil {
+		for _, key := range keys {
+			failed[key] = err
+		}
+		return failed
+	}
+	for _, e := range out.Errors {
+		failed[aws.ToString(e.Key)] = fmt.Errorf("%s: %s", aws.ToString(e.Code), aws.ToString(e.Message))
+	}
+	return failed
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ContentPresigner is implemented by stores that let clients store
+// content themselves through a presigned URL.
+type ContentPresigner interface {
+	// PresignContentPut returns a URL that accepts one PUT of exactly size
+	// bytes as the content of id until validity has passed, and the
+	// headers the PUT must send. An empty contentType is not enforced.
+	PresignContentPut(id string, size int64, contentType string, validity time.Duration) (string, http.Header, error)
+}
+
+// PresignContentPut implements ContentPresigner. The size and content type
+// are part of the signature, so S3 rejects any other upload.
+func (s *S3Store) PresignContentPut(id string, size int64, contentType string, validity time.Duration) (string, http.Header, error) {
+	if s.readOnly {
+		return "", nil, ErrReadOnly
+	}
+	if size <= 0 {
+		return "", nil, errors.New("presigned uploads need a positive size")
+	}
+	in := &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(applyS3Prefix(s.prefix, id)),
+		ContentLength: aws.Int64(size),
+	}
+	if contentType != "" {
+		in.ContentType = aws.String(contentType)
+	}
+	// The encryption, storage class and ACL headers are signed too, so
+	// the uploader must send them along with the returned header.
+	s.put.apply(in)
+	ctx, cancel := s.requestContext()
+	defer cancel()
+	req, err := s3.NewPresignClient(s.client).PresignPutObject(ctx, in, s3.WithPresignExpires(validity))
+	if err != nil {
+		return "", nil, err
+	}
+	header := req.SignedHeader.Clone()
+	// Browsers set these themselves and refuse to have them set.
+	header.Del("Host")
+	header.Del("Content-Length")
+	return req.URL, header, nil
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/johnwmail/nclip/internal/egress"
+)
+
+// writeCheckKey is the object CheckWrite writes and removes again. It is
+// not a paste, so listings and the janitor skip it.
+const writeCheckKey = ".write-check"
+
+// S3PutOptions are applied to every object an S3Store writes. Empty fields
+// leave the bucket's defaults in place.
+type S3PutOptions struct {
+	// KMSKeyID encrypts objects with SSE-KMS under this key (an ID, ARN or
+	// alias); empty uses the bucket's default encryption.
+	KMSKeyID string
+	// StorageClass is the S3 storage class, such as INTELLIGENT_TIERING.
+	StorageClass string
+	// ACL is a canned ACL. Leave it empty for buckets with Object
+	// Ownership set to "bucket owner enforced", which reject every ACL
+	// but bucket-owner-full-control.
+	ACL string
+}
+
+// IsZero reports whether o changes nothing.
+func (o S3PutOptions) IsZero() bool {
+	return o == S3PutOptions{}
+}
+
+// Validate checks the storage class and ACL against the values S3 knows.
+func (o S3PutOptions) Validate() error {
+	if o.StorageClass != "" && !slices.Contains(types.StorageClass("").Values(), types.StorageClass(o.StorageClass)) {
+		return fmt.Errorf("unknown S3 storage class %q", o.StorageClass)
+	}
+	if o.ACL != "" && !slices.Contains(types.ObjectCannedACL("").Values(), types.ObjectCannedACL(o.ACL)) {
+		return fmt.Errorf("unknown S3 canned ACL %q", o.ACL)
+	}
+	return nil
+}
+
+// apply sets o on in.
+func (o S3PutOptions) apply(in *s3.PutObjectInput) {
+	if o.KMSKeyID != "" {
+		in.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		in.SSEKMSKeyId = aws.String(o.KMSKeyID)
+	}
+	if o.StorageClass != "" {
+		in.StorageClass = types.StorageClass(o.StorageClass)
+	}
+	if o.ACL != "" {
+		in.ACL = types.ObjectCannedACL(o.ACL)
+	}
+}
+
+// SetPutOptions applies o to every object the store writes, presigned
+// uploads included. It must be called before the store is shared between
+// goroutines.
+func (s *S3Store) SetPutOptions(o S3PutOptions) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	s.put = o
+	return nil
+}
+
+// putObject writes an object with the store's put options.
+func (s *S3Store) putObject(ctx context.Context, in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	s.put.apply(in)
+	return s.client.PutObject(ctx, in)
+}
+
+// CheckWrite writes a small object with the store's put options, reads it
+// back and removes it, so a KMS key, key policy or bucket policy that
+// rejects the store's writes is reported at startup instead of on the
+// first upload.
+func (s *S3Store) CheckWrite() error {
+	if s.readOnly {
+		return nil
+	}
+	ctx, cancel := s.requestContext()
+	defer cancel()
+	key := aws.String(applyS3Prefix(s.prefix, writeCheckKey))
+	payload := []byte("nclip write check\n")
+	if _, err := s.putObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    key,
+		Body:   bytes.NewReader(payload),
+	}); err != nil {
+		return fmt.Errorf("s3://%s/%s: write rejected (%s): %w", s.bucket, *key, s.describePut(), explainS3Error(err))
+	}
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: key})
+	if err != nil {
+		return fmt.Errorf("s3://%s/%s: written but cannot be read back (%s): %w", s.bucket, *key, s.describePut(), explainS3Error(err))
+	}
+	data, err := io.ReadAll(out.Body)
+	_ = out.Body.Close()
+	if err != nil || !bytes.Equal(data, payload) {
+		return fmt.Errorf("s3://%s/%s: read back different content (%v)", s.bucket, *key, err)
+	}
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: key}); err != nil {
+		log.Printf("[WARN] S3 write check: failed to remove %s: %v", *key, err)
+	}
+	return nil
+}
+
+// describePut describes the put options for error messages.
+func (s *S3Store) describePut() string {
+	if s.put.IsZero() {
+		return "bucket defaults"
+	}
+	return fmt.Sprintf("KMS key %q, storage class %q, ACL %q", s.put.KMSKeyID, s.put.StorageClass, s.put.ACL)
+}
+
+// explainS3Error adds a hint to the S3 errors the put options commonly
+// cause, and to failures to reach the bucket over TLS or through a proxy.
+func explainS3Error(err error) error {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return egress.Explain(err)
+	}
+	switch apiErr.ErrorCode() {
+	case "AccessControlListNotSupported":
+		return fmt.Errorf("%w; the bucket enforces bucket owner ownership, so unset NCLIP_S3_ACL", err)
+	case "KMS.NotFoundException", "KMS.DisabledException", "KMS.KMSInvalidStateException":
+		return fmt.Errorf("%w; check that NCLIP_S3_KMS_KEY_ID names an enabled key in the bucket's region", err)
+	case "AccessDenied":
+		return fmt.Errorf("%w; check that the role may use the KMS key (kms:GenerateDataKey, kms:Decrypt) and that the bucket policy allows the encryption, storage class and ACL", err)
+	case "InvalidStorageClass":
+		return fmt.Errorf("%w; the bucket does not accept NCLIP_S3_STORAGE_CLASS", err)
+	}
+	return err
+}
@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go"
+	"github.com/johnwmail/nclip/models"
+)
+
+// ReadCountMode selects how S3Store records reads. Every read count lives
+// in the paste's metadata object, which S3 can only replace as a whole.
+type ReadCountMode string
+
+const (
+	// ReadCountRewrite reads and rewrites the metadata on every read.
+	// Concurrent reads overwrite each other's counts (last writer wins).
+	// For S3-compatible stores without conditional writes.
+	ReadCountRewrite ReadCountMode = "rewrite"
+	// ReadCountConditional rewrites the metadata with If-Match on its
+	// ETag and retries with jittered backoff when another write won, so
+	// no read is lost. It still costs a GET and a PUT per read.
+	ReadCountConditional ReadCountMode = "conditional"
+	// ReadCountBuffered records the first read of a paste at once, then
+	// sums its reads in memory and writes them conditionally at most once
+	// per flush interval. Counts lag by up to the interval, and reads not
+	// yet written are lost if the process dies without Close.
+	ReadCountBuffered ReadCountMode = "buffered"
+)
+
+// ParseReadCountMode parses a read counting mode.
+func ParseReadCountMode(s string) (ReadCountMode, error) {
+	switch m := ReadCountMode(s); m {
+	case ReadCountRewrite, ReadCountConditional, ReadCountBuffered:
+		return m, nil
+	}
+	return "", fmt.Errorf("must be %q, %q or %q, got %q", ReadCountRewrite, ReadCountConditional, ReadCountBuffered, s)
+}
+
+// Conditional metadata updates give up after this many lost races.
+const (
+	conditionalAttempts = 6
+	conditionalBackoff  = 25 * time.Millisecond
+)
+
+// SetReadCounting sets how reads are recorded; flush is the interval of
+// ReadCountBuffered. It must be called before the store is shared between
+// goroutines. The default is ReadCountRewrite.
+func (s *S3Store) SetReadCounting(mode ReadCountMode, flush time.Duration) {
+	s.readCounting = mode
+	s.reads = nil
+	if mode == ReadCountBuffered {
+		s.reads = newReadBuffer(flush)
+	}
+}
+
+// readCounts holds a number of reads per kind.
+type readCounts map[models.ReadKind]int
+
+// addReads adds counts to the metadata of id with conditional writes,
+// retrying with jittered exponential backoff when the metadata changed
+// between read and write.
+func (s *S3Store) addReads(id string, counts readCounts) error {
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := s.requestContext()
+		paste, etag, err := s.getMetadata(ctx, id)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if paste.IsExpired() {
+			return ErrNotFound
+		}
+		for kind, n := range counts {
+			for i := 0; i < n; i++ {
+				paste.RecordRead(kind)
+			}
+		}
+		err = s.putMetadataIf(paste, etag)
+		if err == nil || !errConditionFailed(err) {
+			return err
+		}
+		if attempt == conditionalAttempts-1 {
+			log.Printf("[WARN] S3 IncrementReads: gave up on %s after %d conflicting writes", id, conditionalAttempts)
+			return err
+		}
+		time.Sleep(rand.N(conditionalBackoff << attempt))
+	}
+}
+
+// errConditionFailed reports whether err is S3 rejecting a conditional
+// write because the object changed, or because a concurrent conditional
+// write to it was in progress.
+func errConditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return true
+	}
+	return false
+}
+
+// readBuffer sums reads per paste between writes. It flushes from within
+// add rather than from a timer, since Lambda freezes the process between
+// requests: a paste whose interval has passed is written on its next
+// read, and any other overdue paste along with it.
+type readBuffer struct {
+	interval time.Duration
+	now      func() time.Time
+	mu       sync.Mutex
+	pastes   map[string]*bufferedReads
+}
+
+// bufferedReads are the unwritten reads of one paste.
+type bufferedReads struct {
+	counts  readCounts
+	written time.Time
+}
+
+func newReadBuffer(interval time.Duration) *readBuffer {
+	return &readBuffer{interval: interval, now: time.Now, pastes: make(map[string]*bufferedReads)}
+}
+
+// add records a read of id and writes the reads of every paste whose
+// interval has passed using write.
+func (b *readBuffer) add(id string, kind models.ReadKind, write func(string, readCounts) error) error {
+	now := b.now()
+	b.mu.Lock()
+	p := b.pastes[id]
+	if p == nil {
+		p = &bufferedReads{counts: readCounts{}}
+		b.pastes[id] = p
+	}
+	p.counts[kind]++
+	due := b.takeDue(now)
+	b.mu.Unlock()
+
+	var err error
+	for pid, counts := range due {
+		if werr := b.write(pid, counts, write); pid == id {
+			err = werr
+		}
+	}
+	return err
+}
+
+// takeDue removes and returns the reads of pastes not written for an
+// interval. Pastes without reads since their last write are dropped once
+// their interval has passed. Callers must hold b.mu.
+func (b *readBuffer) takeDue(now time.Time) map[string]readCounts {
+	due := make(map[string]readCounts)
+	for id, p := range b.pastes {
+		if now.Sub(p.written) < b.interval {
+			continue
+		}
+		if len(p.counts) == 0 {
+			delete(b.pastes, id)
+			continue
+		}
+		due[id] = p.counts
+		p.counts = readCounts{}
+		p.written = now
+	}
+	return due
+}
+
+// write writes counts for id, putting them back to retry with the next
+// flush when the write fails for a reason other than the paste being gone.
+func (b *readBuffer) write(id string, counts readCounts, write func(string, readCounts) error) error {
+	err := write(id, counts)
+	if err == nil || errors.Is(err, ErrNotFound) {
+		return err
+	}
+	log.Printf("[WARN] S3 IncrementReads: failed to write buffered reads of %s: %v", id, err)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p := b.pastes[id]
+	if p == nil {
+		p = &bufferedReads{counts: readCounts{}}
+		b.pastes[id] = p
+	}
+	for kind, n := range counts {
+		p.counts[kind] += n
+	}
+	return err
+}
+
+// flushAll writes every buffered read.
+func (b *readBuffer) flushAll(write func(string, readCounts) error) {
+	b.mu.Lock()
+	pending := make(map[string]readCounts, len(b.pastes))
+	for id, p := range b.pastes {
+		if len(p.counts) > 0 {
+			pending[id] = p.counts
+		}
+	}
+	b.pastes = make(map[string]*bufferedReads)
+	b.mu.Unlock()
+	for id, counts := range pending {
+		if err := write(id, counts); err != nil && !errors.Is(err, ErrNotFound) {
+			log.Printf("[WARN] S3 Close: failed to write buffered reads of %s: %v", id, err)
+		}
+	}
+}
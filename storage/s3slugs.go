@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/johnwmail/nclip/internal/slugindex"
+)
+
+// slugIndexKey is the object the slug index is persisted in. Listings skip
+// names starting with a dot, so it is never mistaken for a paste.
+const slugIndexKey = ".slugindex"
+
+const (
+	// slugIndexSyncInterval is how often the index is saved and reloaded,
+	// picking up slugs other instances saved in the meantime.
+	slugIndexSyncInterval = 5 * time.Minute
+	// slugIndexMaxAge is how old the index may get before it is rebuilt
+	// from a listing of the bucket, dropping deleted slugs. A Bloom filter
+	// cannot forget them otherwise.
+	slugIndexMaxAge = 24 * time.Hour
+)
+
+// slugIndexSnapshot is the persisted form of the slug index.
+type slugIndexSnapshot struct {
+	BuiltAt time.Time `json:"built_at"`
+	Filter  []byte    `json:"filter"`
+}
+
+// s3SlugIndex is the in-memory slug index of an S3Store.
+type s3SlugIndex struct {
+	now func() time.Time
+
+	mu sync.Mutex
+	// filter is nil until the index is loaded.
+	filter  *slugindex.Filter
+	builtAt time.Time
+	// pending holds the slugs stored since the index was last saved.
+	pending []string
+	syncing bool
+}
+
+// add records id as taken.
+func (x *s3SlugIndex) add(id string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.filter != nil {
+		x.filter.Add(id)
+	}
+	x.pending = append(x.pending, id)
+}
+
+// mayContain reports whether id may be taken; true until the index is
+// loaded.
+func (x *s3SlugIndex) mayContain(id string) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.filter == nil || x.filter.MayContain(id)
+}
+
+// swap replaces the filter with f, built at builtAt and holding the first
+// saved pending slugs, and adds the slugs stored since.
+func (x *s3SlugIndex) swap(f *slugindex.Filter, builtAt time.Time, saved int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.pending = x.pending[saved:]
+	for _, id := range x.pending {
+		f.Add(id)
+	}
+	x.filter, x.builtAt = f, builtAt
+}
+
+// EnableSlugIndex keeps an index of taken slugs in memory, so MayExist can
+// rule out most random slug candidates without a HeadObject request. The
+// index is loaded from the bucket in the background, saved back and
+// reloaded every few minutes, and rebuilt from a listing once a day. It
+// must be called before the store is shared between goroutines.
+func (s *S3Store) EnableSlugIndex() {
+	s.slugs = &s3SlugIndex{now: time.Now}
+	go func() {
+		s.syncSlugIndex()
+		ticker := time.NewTicker(slugIndexSyncInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.syncSlugIndex()
+		}
+	}()
+}
+
+// MayExist implements SlugFilter. Without the slug index, or while it is
+// loading, every slug may exist.
+func (s *S3Store) MayExist(id string) bool {
+	return s.slugs == nil || s.slugs.mayContain(id)
+}
+
+// syncSlugIndex saves the slugs stored since the last sync to the
+// persisted index and adopts it, which also brings in the slugs other
+// instances saved. A missing or outdated index is rebuilt instead.
+func (s *S3Store) syncSlugIndex() {
+	x := s.slugs
+	x.mu.Lock()
+	if x.syncing {
+		x.mu.Unlock()
+		return
+	}
+	x.syncing = true
+	local, localBuilt, saved := x.filter, x.builtAt, len(x.pending)
+	x.mu.Unlock()
+	defer func() {
+		x.mu.Lock()
+		x.syncing = false
+		x.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	snap, etag, err := s.getSlugIndex(ctx)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("[WARN] S3 slug index: failed to load: %v", err)
+		return
+	}
+	if snap == nil || x.now().Sub(snap.BuiltAt) > slugIndexMaxAge {
+		if local == nil || x.now().Sub(localBuilt) > slugIndexMaxAge {
+			s.rebuildSlugIndex(ctx)
+			return
+		}
+	}
+
+	var base *slugindex.Filter
+	builtAt := localBuilt
+	if snap != nil && !snap.BuiltAt.Before(localBuilt) {
+		base = new(slugindex.Filter)
+		if err := base.UnmarshalBinary(snap.Filter); err != nil {
+			log.Printf("[WARN] S3 slug index: ignoring corrupt index, rebuilding: %v", err)
+			s.rebuildSlugIndex(ctx)
+			return
+		}
+		builtAt = snap.BuiltAt
+	} else {
+		// Our index is newer: a rebuild whose save failed.
+		base = local
+		etag = ""
+	}
+	if saved > 0 || base == local {
+		x.mu.Lock()
+		for _, id := range x.pending[:saved] {
+			base.Add(id)
+		}
+		x.mu.Unlock()
+		if s.readOnly {
+			saved = 0
+		} else if err := s.putSlugIndex(ctx, base, builtAt, etag); err != nil {
+			if !errConditionFailed(err) {
+				log.Printf("[WARN] S3 slug index: failed to save: %v", err)
+			}
+			// Try again at the next sync; the local index stays as it is.
+			return
+		}
+	}
+	x.swap(base, builtAt, saved)
+}
+
+// flushSlugIndex saves the slugs stored since the last sync, if the index
+// is loaded.
+func (s *S3Store) flushSlugIndex() {
+	x := s.slugs
+	x.mu.Lock()
+	unsaved := x.filter != nil && len(x.pending) > 0
+	x.mu.Unlock()
+	if unsaved {
+		s.syncSlugIndex()
+	}
+}
+
+// rebuildSlugIndex builds the index from a listing of the bucket and saves
+// it, replacing the persisted one.
+func (s *S3Store) rebuildSlugIndex(ctx context.Context) {
+	x := s.slugs
+	builtAt := x.now()
+	var ids []string
+	err := s.ListObjects(func(obj Object) error {
+		if key, ok := strings.CutSuffix(obj.Name, ".json"); ok && isPasteKey(key) {
+			_, id := splitTenantKey(key)
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARN] S3 slug index: failed to list the bucket: %v", err)
+		return
+	}
+	f := slugindex.New(2 * len(ids))
+	for _, id := range ids {
+		f.Add(id)
+	}
+	x.mu.Lock()
+	saved := len(x.pending)
+	for _, id := range x.pending {
+		f.Add(id)
+	}
+	x.mu.Unlock()
+	if !s.readOnly {
+		if err := s.putSlugIndex(ctx, f, builtAt, ""); err != nil {
+			log.Printf("[WARN] S3 slug index: failed to save: %v", err)
+			// Use the rebuilt index anyway; the next sync saves it.
+			saved = 0
+		}
+	}
+	x.swap(f, builtAt, saved)
+	log.Printf("[INFO] S3 slug index: rebuilt with %d slugs", len(ids))
+}
+
+// getSlugIndex reads the persisted index with its ETag. It returns
+// ErrNotFound when there is none.
+func (s *S3Store) getSlugIndex(ctx context.Context) (*slugIndexSnapshot, string, error) {
+	obj, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(applyS3Prefix(s.prefix, slugIndexKey)),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			if code := apiErr.ErrorCode(); code == "NoSuchKey" || code == "NotFound" || code == "404" {
+				return nil, "", ErrNotFound
+			}
+		}
+		return nil, "", err
+	}
+	defer func() { _ = obj.Body.Close() }()
+	data, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	var snap slugIndexSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		// Rebuilt like a missing index.
+		log.Printf("[WARN] S3 slug index: ignoring corrupt index: %v", err)
+		return nil, "", ErrNotFound
+	}
+	return &snap, aws.ToString(obj.ETag), nil
+}
+
+// putSlugIndex saves f as the persisted index. A non-empty etag makes the
+// write conditional on the index not having changed since it was read.
+func (s *S3Store) putSlugIndex(ctx context.Context, f *slugindex.Filter, builtAt time.Time, etag string) error {
+	bits, err := f.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(slugIndexSnapshot{BuiltAt: builtAt, Filter: bits})
+	if err != nil {
+		return err
+	}
+	in := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(applyS3Prefix(s.prefix, slugIndexKey)),
+		Body:   bytes.NewReader(data),
+	}
+	if etag != "" {
+		in.IfMatch = aws.String(etag)
+	}
+	_, err = s.putObject(ctx, in)
+	return err
+}
@@ -0,0 +1,29 @@
+package storage
+
+// SlugFilter is implemented by stores that can rule out a slug being taken
+// without a backend request, such as the S3 store with its slug index.
+type SlugFilter interface {
+	// MayExist reports whether id may be taken. False means it is free as
+	// far as the store knows; true means Exists has to be asked.
+	MayExist(id string) bool
+}
+
+// MayExist reports whether id may be taken, asking the first store in the
+// decorator chain that implements SlugFilter. Without one it returns true.
+//
+// A filter may miss slugs taken very recently by another instance, so
+// MayExist only suits random slug candidates, where that collision is as
+// unlikely as any other. Custom slugs must be checked with Exists.
+func MayExist(store PasteStore, id string) bool {
+	for store != nil {
+		if f, ok := store.(SlugFilter); ok {
+			return f.MayExist(id)
+		}
+		d, ok := store.(interface{ Backend() PasteStore })
+		if !ok {
+			break
+		}
+		store = d.Backend()
+	}
+	return true
+}
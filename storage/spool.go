@@ -0,0 +1,714 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johnwmail/nclip/models"
+)
+
+const (
+	// spoolMinBackoff is the retry delay after a failed flush; it doubles
+	// on every consecutive failure up to spoolMaxBackoff.
+	spoolMinBackoff = time.Second
+	spoolMaxBackoff = 5 * time.Minute
+	// spoolIdleInterval is how often the worker wakes when nothing failed.
+	spoolIdleInterval = 30 * time.Second
+)
+
+// SpoolStats describes the write-behind spool for health reporting.
+type SpoolStats struct {
+	Depth    int   `json:"depth"`
+	Bytes    int64 `json:"bytes"`
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+// SpoolReporter is implemented by stores that buffer writes locally.
+type SpoolReporter interface {
+	SpoolStats() SpoolStats
+}
+
+// spoolEntry tracks one spooled paste. gen is bumped on every local change
+// so the flush worker can tell whether an entry was modified while it was
+// being pushed to the backend.
+type spoolEntry struct {
+	gen     uint64
+	size    int64
+	hasMeta bool
+}
+
+// SpoolStore wraps a PasteStore with a write-behind queue on local disk.
+// Writes that fail on the backend are spooled and acknowledged; a worker
+// flushes them with exponential backoff. Reads check the spool first, so
+// spooled pastes are retrievable immediately. Spooled pastes do not appear
+// in listings until they are flushed.
+type SpoolStore struct {
+	backend  PasteStore
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries map[string]*spoolEntry
+	bytes   int64
+
+	kick    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	started bool
+}
+
+// NewSpoolStore creates a SpoolStore spooling to dir, bounded to maxBytes
+// of content and metadata. Entries left in dir by a previous run are
+// recovered and flushed. Call Start to run the flush worker.
+func NewSpoolStore(backend PasteStore, dir string, maxBytes int64) (*SpoolStore, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory %s: %w", dir, err)
+	}
+	s := &SpoolStore{
+		backend:  backend,
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*spoolEntry),
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+	if n := len(s.entries); n > 0 {
+		log.Printf("[INFO] Spool: recovered %d pending paste(s) from %s", n, dir)
+	}
+	return s, nil
+}
+
+// recover rebuilds the in-memory index from the spool directory.
+func (s *SpoolStore) recover() error {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read spool directory %s: %w", s.dir, err)
+	}
+	for _, f := range files {
+		name := f.Name()
+		id, isMeta := strings.TrimSuffix(name, ".json"), strings.HasSuffix(name, ".json")
+		if !isMeta {
+			id = strings.TrimSuffix(name, ".content")
+			if id == name {
+				continue
+			}
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		e := s.entries[id]
+		if e == nil {
+			e = &spoolEntry{}
+			s.entries[id] = e
+		}
+		e.size += info.Size()
+		e.hasMeta = e.hasMeta || isMeta
+		s.bytes += info.Size()
+	}
+	return nil
+}
+
+// Backend returns the wrapped store.
+func (s *SpoolStore) Backend() PasteStore {
+	return s.backend
+}
+
+// Start runs the flush worker until Close is called.
+func (s *SpoolStore) Start() {
+	s.mu.Lock()
+	s.started = true
+	s.mu.Unlock()
+	go s.run()
+}
+
+func (s *SpoolStore) run() {
+	defer close(s.done)
+	backoff := spoolMinBackoff
+	wait := time.Duration(0)
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-s.kick:
+			timer.Stop()
+		case <-timer.C:
+		}
+		if s.flush() {
+			backoff = spoolMinBackoff
+			wait = spoolIdleInterval
+			continue
+		}
+		wait = backoff
+		backoff *= 2
+		if backoff > spoolMaxBackoff {
+			backoff = spoolMaxBackoff
+		}
+		log.Printf("[WARN] Spool: %d paste(s) pending, retrying in %s", s.SpoolStats().Depth, wait)
+	}
+}
+
+// flush pushes every complete entry to the backend and reports whether all
+// of them succeeded. It stops at the first backend failure.
+func (s *SpoolStore) flush() bool {
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.entries))
+	for id, e := range s.entries {
+		if e.hasMeta {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.Unlock()
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		if err := s.flushOne(id); err != nil {
+			log.Printf("[WARN] Spool: failed to flush %s: %v", id, err)
+			return false
+		}
+	}
+	return true
+}
+
+// flushOne pushes a single entry. Backend calls are made without holding
+// s.mu so reads are not blocked by a slow backend; if the entry changed in
+// the meantime it stays spooled and is pushed again on the next round.
+func (s *SpoolStore) flushOne(id string) error {
+	s.mu.Lock()
+	e, ok := s.entries[id]
+	if !ok {
+		s.mu.Unlock()
+		return nil
+	}
+	gen := e.gen
+	paste, err := s.readMeta(id)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	content, hasContent, err := s.readContent(id)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Existence checks are skipped during an outage, so a custom slug may
+	// have been taken on the backend in the meantime. Never overwrite it.
+	existing, err := s.backend.Get(id)
+	switch {
+	case err == nil && existing != nil && !existing.IsExpired() && !existing.CreatedAt.Equal(paste.CreatedAt):
+		log.Printf("[ERROR] Spool: dropping %s, slug already taken on the backend", id)
+		s.mu.Lock()
+		s.removeLocked(id)
+		s.mu.Unlock()
+		return nil
+	case err != nil && !errors.Is(err, ErrNotFound):
+		return err
+	}
+
+	if hasContent {
+		if err := s.backend.StoreContent(id, content); err != nil {
+			return err
+		}
+	}
+	if err := s.backend.Store(paste); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.entries[id]
+	switch {
+	case !ok:
+		// Deleted while flushing: propagate the delete.
+		if err := s.backend.Delete(id); err != nil && !errors.Is(err, ErrNotFound) {
+			log.Printf("[WARN] Spool: failed to delete flushed paste %s: %v", id, err)
+		}
+	case cur.gen == gen:
+		s.removeLocked(id)
+		log.Printf("[INFO] Spool: flushed %s to backend", id)
+	}
+	return nil
+}
+
+// Close stops the flush worker, makes a final flush attempt and closes the
+// backend. Entries that could not be flushed stay on disk for the next run.
+func (s *SpoolStore) Close() error {
+	s.mu.Lock()
+	started := s.started
+	s.started = false
+	s.mu.Unlock()
+	if started {
+		close(s.stop)
+		<-s.done
+	}
+	s.flush()
+	return s.backend.Close()
+}
+
+// SpoolStats implements SpoolReporter.
+func (s *SpoolStore) SpoolStats() SpoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SpoolStats{Depth: len(s.entries), Bytes: s.bytes, MaxBytes: s.maxBytes}
+}
+
+func (s *SpoolStore) notify() {
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+// spoolable reports whether a backend error is an outage worth spooling
+// for, rather than a rejection that would fail again on retry.
+func spoolable(err error) bool {
+	return err != nil && !errors.Is(err, ErrReadOnly) && !errors.Is(err, errUnsafeID)
+}
+
+// writeLocked writes a spool file for id, enforcing the size bound.
+func (s *SpoolStore) writeLocked(id, suffix string, data []byte) error {
+	p, err := safePath(s.dir, id+suffix)
+	if err != nil {
+		return err
+	}
+	var old int64
+	if info, err := os.Stat(p); err == nil {
+		old = info.Size()
+	}
+	if s.maxBytes > 0 && s.bytes-old+int64(len(data)) > s.maxBytes {
+		return fmt.Errorf("spool full (%d bytes)", s.maxBytes)
+	}
+	if err := os.WriteFile(p, data, 0600); err != nil {
+		return err
+	}
+	e := s.entries[id]
+	if e == nil {
+		e = &spoolEntry{}
+		s.entries[id] = e
+	}
+	e.gen++
+	e.size += int64(len(data)) - old
+	s.bytes += int64(len(data)) - old
+	if suffix == ".json" {
+		e.hasMeta = true
+	}
+	return nil
+}
+
+func (s *SpoolStore) removeLocked(id string) {
+	e, ok := s.entries[id]
+	if !ok {
+		return
+	}
+	for _, suffix := range []string{".json", ".content"} {
+		if p, err := safePath(s.dir, id+suffix); err == nil {
+			_ = os.Remove(p)
+		}
+	}
+	s.bytes -= e.size
+	delete(s.entries, id)
+}
+
+func (s *SpoolStore) readMeta(id string) (*models.Paste, error) {
+	p, err := safePath(s.dir, id+".json")
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p) // #nosec G304 -- path sanitised by safePath
+	if err != nil {
+		return nil, err
+	}
+	var paste models.Paste
+	if err := json.Unmarshal(data, &paste); err != nil {
+		return nil, fmt.Errorf("malformed spooled metadata for %s: %w", id, err)
+	}
+	return &paste, nil
+}
+
+func (s *SpoolStore) readContent(id string) ([]byte, bool, error) {
+	p, err := safePath(s.dir, id+".content")
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := os.ReadFile(p) // #nosec G304 -- path sanitised by safePath
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// spooled reports whether id has pending local state. Callers hold s.mu.
+func (s *SpoolStore) spooledLocked(id string) bool {
+	_, ok := s.entries[id]
+	return ok
+}
+
+// Spooled reports whether id has writes that are not on the backend yet.
+func (s *SpoolStore) Spooled(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.spooledLocked(id)
+}
+
+// StoreContent implements PasteStore, spooling the content when the
+// backend fails.
+func (s *SpoolStore) StoreContent(id string, content []byte) error {
+	err := s.backend.StoreContent(id, content)
+	if !spoolable(err) {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if serr := s.writeLocked(id, ".content", content); serr != nil {
+		return fmt.Errorf("%w (not spooled: %v)", err, serr)
+	}
+	log.Printf("[WARN] Spool: backend unavailable, spooled content for %s: %v", id, err)
+	return nil
+}
+
+// CreateContent implements ContentCreator. It is never spooled: while the
+// backend is unavailable, whether the content exists there is unknown.
+func (s *SpoolStore) CreateContent(id string, content []byte) (bool, error) {
+	p, err := safePath(s.dir, id+".content")
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(p); err == nil {
+		return false, nil
+	}
+	return CreateContent(s.backend, id, content)
+}
+
+// Store implements PasteStore. Metadata is spooled when the backend fails
+// or when the content is already spooled, so a paste is never visible on
+// the backend before its content.
+func (s *SpoolStore) Store(paste *models.Paste) error {
+	s.mu.Lock()
+	spooled := s.spooledLocked(paste.ID)
+	s.mu.Unlock()
+
+	var err error
+	if !spooled {
+		err = s.backend.Store(paste)
+		if !spoolable(err) {
+			return err
+		}
+	}
+	data, merr := json.Marshal(paste)
+	if merr != nil {
+		return merr
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if serr := s.writeLocked(paste.ID, ".json", data); serr != nil {
+		s.removeLocked(paste.ID)
+		if err == nil {
+			return serr
+		}
+		return fmt.Errorf("%w (not spooled: %v)", err, serr)
+	}
+	if err != nil {
+		log.Printf("[WARN] Spool: backend unavailable, spooled metadata for %s: %v", paste.ID, err)
+	}
+	s.notify()
+	return nil
+}
+
+// Get implements PasteStore, preferring the spooled copy.
+func (s *SpoolStore) Get(id string) (*models.Paste, error) {
+	s.mu.Lock()
+	if e, ok := s.entries[id]; ok && e.hasMeta {
+		defer s.mu.Unlock()
+		return s.readMeta(id)
+	}
+	s.mu.Unlock()
+	return s.backend.Get(id)
+}
+
+// GetBatch implements BatchGetter.
+func (s *SpoolStore) GetBatch(ids []string) map[string]BatchResult {
+	results := make(map[string]BatchResult, len(ids))
+	var rest []string
+	s.mu.Lock()
+	for _, id := range ids {
+		if e, ok := s.entries[id]; ok && e.hasMeta {
+			results[id] = batchResult(s.readMeta(id))
+			continue
+		}
+		rest = append(rest, id)
+	}
+	s.mu.Unlock()
+	for id, r := range GetBatch(s.backend, rest) {
+		results[id] = r
+	}
+	return results
+}
+
+// Exists implements PasteStore. While the backend is unreachable it reports
+// false so random slugs can still be generated; the flush worker refuses to
+// overwrite a slug that turns out to be taken.
+func (s *SpoolStore) Exists(id string) (bool, error) {
+	s.mu.Lock()
+	spooled := s.spooledLocked(id)
+	s.mu.Unlock()
+	if spooled {
+		return true, nil
+	}
+	exists, err := s.backend.Exists(id)
+	if err != nil {
+		log.Printf("[WARN] Spool: existence check for %s failed, assuming free: %v", id, err)
+		return false, nil
+	}
+	return exists, nil
+}
+
+// MayExist implements SlugFilter, since spooled pastes are not in the
+// backend's index yet.
+func (s *SpoolStore) MayExist(id string) bool {
+	s.mu.Lock()
+	spooled := s.spooledLocked(id)
+	s.mu.Unlock()
+	return spooled || MayExist(s.backend, id)
+}
+
+// Delete implements PasteStore.
+func (s *SpoolStore) Delete(id string) error {
+	s.mu.Lock()
+	spooled := s.spooledLocked(id)
+	s.removeLocked(id)
+	s.mu.Unlock()
+	err := s.backend.Delete(id)
+	if spooled && err != nil {
+		// The paste only ever existed in the spool.
+		return nil
+	}
+	return err
+}
+
+// DeleteBatch implements BatchDeleter, dropping spooled copies first as
+// Delete does.
+func (s *SpoolStore) DeleteBatch(ids []string, progress DeleteProgress) map[string]error {
+	spooled := map[string]bool{}
+	s.mu.Lock()
+	for _, id := range ids {
+		if s.spooledLocked(id) {
+			spooled[id] = true
+		}
+		s.removeLocked(id)
+	}
+	s.mu.Unlock()
+	errs := DeleteBatch(s.backend, ids, progress)
+	for id := range errs {
+		if spooled[id] {
+			// The paste only ever existed in the spool.
+			delete(errs, id)
+		}
+	}
+	return errs
+}
+
+// IncrementReadCount implements PasteStore.
+func (s *SpoolStore) IncrementReadCount(id string) error {
+	return s.IncrementReads(id, "")
+}
+
+// IncrementReads implements ReadCounter, updating the spooled copy when
+// there is one.
+func (s *SpoolStore) IncrementReads(id string, kind models.ReadKind) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[id]; !ok || !e.hasMeta {
+		return IncrementReads(s.backend, id, kind)
+	}
+	paste, err := s.readMeta(id)
+	if err != nil {
+		return err
+	}
+	paste.RecordRead(kind)
+	data, err := json.Marshal(paste)
+	if err != nil {
+		return err
+	}
+	return s.writeLocked(id, ".json", data)
+}
+
+// GetContent implements PasteStore, preferring the spooled copy.
+func (s *SpoolStore) GetContent(id string) ([]byte, error) {
+	s.mu.Lock()
+	content, ok, err := s.readContent(id)
+	s.mu.Unlock()
+	if err != nil || ok {
+		return content, err
+	}
+	return s.backend.GetContent(id)
+}
+
+// OpenContent implements ContentOpener, preferring the spooled copy.
+func (s *SpoolStore) OpenContent(id string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	content, ok, err := s.readContent(id)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return io.NopCloser(bytes.NewReader(content)), nil
+	}
+	return OpenContent(s.backend, id)
+}
+
+// GetContentPrefix implements PasteStore, preferring the spooled copy.
+func (s *SpoolStore) GetContentPrefix(id string, n int64) ([]byte, error) {
+	s.mu.Lock()
+	content, ok, err := s.readContent(id)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return s.backend.GetContentPrefix(id, n)
+	}
+	if int64(len(content)) > n {
+		content = content[:n]
+	}
+	return content, nil
+}
+
+// StatContent implements PasteStore, preferring the spooled copy.
+func (s *SpoolStore) StatContent(id string) (bool, int64, error) {
+	p, err := safePath(s.dir, id+".content")
+	if err != nil {
+		return false, 0, err
+	}
+	if info, err := os.Stat(p); err == nil {
+		return true, info.Size(), nil
+	}
+	return s.backend.StatContent(id)
+}
+
+// List implements Lister by delegating to the backend.
+func (s *SpoolStore) List(opts ListOptions) (ListPage, error) {
+	l, ok := s.backend.(Lister)
+	if !ok {
+		return ListPage{}, errUnsupported
+	}
+	return l.List(opts)
+}
+
+// ListObjects implements ObjectLister by delegating to the backend.
+func (s *SpoolStore) ListObjects(fn func(Object) error) error {
+	l, ok := s.backend.(ObjectLister)
+	if !ok {
+		return errUnsupported
+	}
+	return l.ListObjects(fn)
+}
+
+// StoreCollection implements CollectionStore by delegating to the backend.
+func (s *SpoolStore) StoreCollection(col *models.Collection) error {
+	cs, ok := s.backend.(CollectionStore)
+	if !ok {
+		return errUnsupported
+	}
+	return cs.StoreCollection(col)
+}
+
+// GetCollection implements CollectionStore by delegating to the backend.
+func (s *SpoolStore) GetCollection(id string) (*models.Collection, error) {
+	cs, ok := s.backend.(CollectionStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return cs.GetCollection(id)
+}
+
+// DeleteCollection implements CollectionStore by delegating to the backend.
+func (s *SpoolStore) DeleteCollection(id string) error {
+	cs, ok := s.backend.(CollectionStore)
+	if !ok {
+		return errUnsupported
+	}
+	return cs.DeleteCollection(id)
+}
+
+// StoreToken implements TokenStore by delegating to the backend.
+func (s *SpoolStore) StoreToken(t *models.ShareToken) error {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return errUnsupported
+	}
+	return ts.StoreToken(t)
+}
+
+// GetToken implements TokenStore by delegating to the backend.
+func (s *SpoolStore) GetToken(token string) (*models.ShareToken, error) {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return ts.GetToken(token)
+}
+
+// DeleteToken implements TokenStore by delegating to the backend.
+func (s *SpoolStore) DeleteToken(t *models.ShareToken) error {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return errUnsupported
+	}
+	return ts.DeleteToken(t)
+}
+
+// ListTokens implements TokenStore by delegating to the backend.
+func (s *SpoolStore) ListTokens(slug string) ([]*models.ShareToken, error) {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return ts.ListTokens(slug)
+}
+
+// GetCert implements CertStore by delegating to the backend.
+func (s *SpoolStore) GetCert(name string) ([]byte, error) {
+	cs, ok := s.backend.(CertStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return cs.GetCert(name)
+}
+
+// PutCert implements CertStore by delegating to the backend.
+func (s *SpoolStore) PutCert(name string, data []byte) error {
+	cs, ok := s.backend.(CertStore)
+	if !ok {
+		return errUnsupported
+	}
+	return cs.PutCert(name, data)
+}
+
+// DeleteCert implements CertStore by delegating to the backend.
+func (s *SpoolStore) DeleteCert(name string) error {
+	cs, ok := s.backend.(CertStore)
+	if !ok {
+		return errUnsupported
+	}
+	return cs.DeleteCert(name)
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/models"
+)
+
+var errBackendDown = errors.New("backend unavailable")
+
+// flakyStore fails every operation while down is set.
+type flakyStore struct {
+	*FilesystemStore
+	down bool
+}
+
+func (f *flakyStore) Store(p *models.Paste) error {
+	if f.down {
+		return errBackendDown
+	}
+	return f.FilesystemStore.Store(p)
+}
+
+func (f *flakyStore) StoreContent(id string, content []byte) error {
+	if f.down {
+		return errBackendDown
+	}
+	return f.FilesystemStore.StoreContent(id, content)
+}
+
+func (f *flakyStore) Get(id string) (*models.Paste, error) {
+	if f.down {
+		return nil, errBackendDown
+	}
+	return f.FilesystemStore.Get(id)
+}
+
+func (f *flakyStore) Exists(id string) (bool, error) {
+	if f.down {
+		return false, errBackendDown
+	}
+	return f.FilesystemStore.Exists(id)
+}
+
+func newFlakySpool(t *testing.T, maxBytes int64) (*SpoolStore, *flakyStore) {
+	t.Helper()
+	fs, err := NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystemStore failed: %v", err)
+	}
+	backend := &flakyStore{FilesystemStore: fs, down: true}
+	spool, err := NewSpoolStore(backend, t.TempDir(), maxBytes)
+	if err != nil {
+		t.Fatalf("NewSpoolStore failed: %v", err)
+	}
+	return spool, backend
+}
+
+func spoolPaste(t *testing.T, s PasteStore, id, content string) {
+	t.Helper()
+	if err := s.StoreContent(id, []byte(content)); err != nil {
+		t.Fatalf("StoreContent(%s) failed: %v", id, err)
+	}
+	exp := time.Now().Add(time.Hour)
+	p := &models.Paste{ID: id, CreatedAt: time.Now(), ExpiresAt: &exp, Size: int64(len(content))}
+	if err := s.Store(p); err != nil {
+		t.Fatalf("Store(%s) failed: %v", id, err)
+	}
+}
+
+func TestSpoolStore_SpoolsAndFlushes(t *testing.T) {
+	spool, backend := newFlakySpool(t, 0)
+	spoolPaste(t, spool, "AAAAA", "hello")
+
+	if st := spool.SpoolStats(); st.Depth != 1 {
+		t.Fatalf("expected spool depth 1, got %+v", st)
+	}
+	if exists, err := spool.Exists("AAAAA"); err != nil || !exists {
+		t.Errorf("expected spooled paste to exist, got %v, %v", exists, err)
+	}
+	if exists, err := spool.Exists("BBBBB"); err != nil || exists {
+		t.Errorf("expected unknown slug to be free while the backend is down, got %v, %v", exists, err)
+	}
+	if err := spool.IncrementReadCount("AAAAA"); err != nil {
+		t.Fatalf("IncrementReadCount failed: %v", err)
+	}
+	p, err := spool.Get("AAAAA")
+	if err != nil || p.ReadCount != 1 {
+		t.Fatalf("expected spooled paste with read count 1, got %+v, %v", p, err)
+	}
+	content, err := spool.GetContent("AAAAA")
+	if err != nil || string(content) != "hello" {
+		t.Fatalf("expected spooled content, got %q, %v", content, err)
+	}
+
+	if !spool.Spooled("AAAAA") || spool.Spooled("BBBBB") {
+		t.Error("expected only AAAAA to be reported spooled")
+	}
+	if spool.flush() {
+		t.Error("expected flush to fail while the backend is down")
+	}
+
+	backend.down = false
+	if !spool.flush() {
+		t.Fatal("expected flush to succeed once the backend is back")
+	}
+	if st := spool.SpoolStats(); st.Depth != 0 || st.Bytes != 0 {
+		t.Errorf("expected empty spool after flush, got %+v", st)
+	}
+	if spool.Spooled("AAAAA") {
+		t.Error("expected the flushed paste not to be reported spooled")
+	}
+	p, err = backend.Get("AAAAA")
+	if err != nil || p.ReadCount != 1 {
+		t.Fatalf("expected flushed paste on backend, got %+v, %v", p, err)
+	}
+	content, err = backend.GetContent("AAAAA")
+	if err != nil || string(content) != "hello" {
+		t.Errorf("expected flushed content on backend, got %q, %v", content, err)
+	}
+}
+
+func TestSpoolStore_Bounded(t *testing.T) {
+	spool, _ := newFlakySpool(t, 16)
+	if err := spool.StoreContent("AAAAA", []byte("this is more than sixteen bytes")); !errors.Is(err, errBackendDown) {
+		t.Errorf("expected backend error when the spool is full, got %v", err)
+	}
+	if st := spool.SpoolStats(); st.Depth != 0 {
+		t.Errorf("expected nothing spooled, got %+v", st)
+	}
+}
+
+func TestSpoolStore_DeleteSpooled(t *testing.T) {
+	spool, _ := newFlakySpool(t, 0)
+	spoolPaste(t, spool, "AAAAA", "hello")
+	if err := spool.Delete("AAAAA"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := spool.Get("AAAAA"); err == nil {
+		t.Error("expected deleted paste to be gone")
+	}
+	if st := spool.SpoolStats(); st.Depth != 0 {
+		t.Errorf("expected empty spool, got %+v", st)
+	}
+}
+
+func TestSpoolStore_RecoverAndConflict(t *testing.T) {
+	spool, backend := newFlakySpool(t, 0)
+	spoolPaste(t, spool, "AAAAA", "spooled")
+
+	// A restart recovers pending entries from disk.
+	recovered, err := NewSpoolStore(backend, spool.dir, 0)
+	if err != nil {
+		t.Fatalf("NewSpoolStore failed: %v", err)
+	}
+	if st := recovered.SpoolStats(); st.Depth != 1 {
+		t.Fatalf("expected 1 recovered entry, got %+v", st)
+	}
+
+	// Meanwhile the slug was taken on the backend; it must not be overwritten.
+	backend.down = false
+	spoolPaste(t, backend, "AAAAA", "original")
+	if !recovered.flush() {
+		t.Fatal("expected flush to succeed")
+	}
+	content, err := backend.GetContent("AAAAA")
+	if err != nil || string(content) != "original" {
+		t.Errorf("expected backend paste to be kept, got %q, %v", content, err)
+	}
+	if st := recovered.SpoolStats(); st.Depth != 0 {
+		t.Errorf("expected conflicting entry to be dropped, got %+v", st)
+	}
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// ContentOpener is implemented by stores that can stream paste content
+// instead of reading it into memory. The caller closes the reader.
+type ContentOpener interface {
+	OpenContent(id string) (io.ReadCloser, error)
+}
+
+// OpenContent opens the content of id for streaming using the store's
+// ContentOpener implementation when available, falling back to GetContent.
+func OpenContent(store PasteStore, id string) (io.ReadCloser, error) {
+	if co, ok := store.(ContentOpener); ok {
+		return co.OpenContent(id)
+	}
+	content, err := store.GetContent(id)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// cancelOnClose releases a request context once its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
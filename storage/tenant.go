@@ -0,0 +1,720 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johnwmail/nclip/models"
+	"github.com/johnwmail/nclip/utils"
+)
+
+// tenantSeparator separates the tenant from the id in the keys of pastes
+// of tenants. Neither tenant names nor slugs contain it.
+const tenantSeparator = "~"
+
+// tenantPointerPrefix starts the id of the object that names the tenant
+// of a slug. The leading dot keeps pointers out of listings.
+const tenantPointerPrefix = ".tenant" + tenantSeparator
+
+// tenantCacheSize bounds the slugs a TenantStore remembers the tenant of.
+const tenantCacheSize = 1 << 16
+
+// tenantPattern is the form of tenant names, as API keys files accept them.
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// errInvalidTenant is returned for a tenant name or pointer that is not of
+// the form tenantPattern accepts.
+var errInvalidTenant = errors.New("invalid tenant")
+
+// tenantKey returns the key the object id of a paste of tenant is stored
+// under: "<tenant>~<id>", or id itself for the default tenant "".
+func tenantKey(tenant, id string) string {
+	if tenant == "" {
+		return id
+	}
+	return tenant + tenantSeparator + id
+}
+
+// splitTenantKey splits a key made by tenantKey into the tenant and id.
+// Keys without a valid tenant belong to the default tenant.
+func splitTenantKey(key string) (tenant, id string) {
+	tenant, id, ok := strings.Cut(key, tenantSeparator)
+	if !ok || !tenantPattern.MatchString(tenant) {
+		return "", key
+	}
+	return tenant, id
+}
+
+// isPasteKey reports whether key, as a backend lists it, is the id of a
+// paste: a slug, under the prefix of its tenant if it has one.
+func isPasteKey(key string) bool {
+	_, id := splitTenantKey(key)
+	return utils.IsValidSlug(id)
+}
+
+// tenantPointerID returns the id of the object that names the tenant of
+// slug.
+func tenantPointerID(slug string) string {
+	return tenantPointerPrefix + slug
+}
+
+// pasteSlug returns the slug the object id belongs to, reporting false for
+// ids that are not a paste's, such as internal objects.
+func pasteSlug(id string) (string, bool) {
+	slug, _, _ := strings.Cut(id, ".")
+	return slug, utils.IsValidSlug(slug)
+}
+
+// TenantAssigner is implemented by stores that keep the pastes of each
+// tenant under a prefix of their own.
+type TenantAssigner interface {
+	// AssignTenant makes the objects of slug go to the prefix of tenant,
+	// or to no prefix for the default tenant "". It must be called before
+	// anything of a new paste is stored.
+	AssignTenant(slug, tenant string) error
+}
+
+// AssignTenant assigns slug to tenant in the first store in the decorator
+// chain that implements TenantAssigner. Without one it does nothing.
+func AssignTenant(store PasteStore, slug, tenant string) error {
+	if a, ok := Find[interface {
+		PasteStore
+		TenantAssigner
+	}](store); ok {
+		return a.AssignTenant(slug, tenant)
+	}
+	return nil
+}
+
+// TenantStore wraps a PasteStore and stores the objects of the pastes of
+// each tenant under the key prefix "<tenant>~", while callers keep using
+// slugs. A pointer object ".tenant~<slug>" names the tenant of each such
+// paste; pastes of the default tenant, and those stored before their
+// tenant had a prefix, keep their bare keys and have no pointer.
+//
+// Metadata is looked up under the bare key first, so reading a paste of
+// the default tenant costs no more than without the wrapper. The tenants
+// of slugs read, listed or assigned are remembered, so content and later
+// reads of a tenant's paste go straight to its key.
+type TenantStore struct {
+	backend PasteStore
+
+	mu      sync.Mutex
+	tenants map[string]string
+}
+
+// NewTenantStore wraps backend.
+func NewTenantStore(backend PasteStore) *TenantStore {
+	return &TenantStore{backend: backend, tenants: map[string]string{}}
+}
+
+// Backend returns the wrapped store.
+func (s *TenantStore) Backend() PasteStore {
+	return s.backend
+}
+
+// cached returns the remembered tenant of slug.
+func (s *TenantStore) cached(slug string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tenant, ok := s.tenants[slug]
+	return tenant, ok
+}
+
+// remember records the tenant of slug, forgetting every other slug once
+// the cache is full.
+func (s *TenantStore) remember(slug, tenant string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tenants[slug]; !ok && len(s.tenants) >= tenantCacheSize {
+		clear(s.tenants)
+	}
+	s.tenants[slug] = tenant
+}
+
+// forget drops the remembered tenant of slug.
+func (s *TenantStore) forget(slug string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tenants, slug)
+}
+
+// pointer reads the tenant the pointer of slug names, or "" when slug has
+// no pointer. It checks for the pointer first, since most slugs have none
+// and backends log failed content reads.
+func (s *TenantStore) pointer(slug string) (string, error) {
+	id := tenantPointerID(slug)
+	exists, _, err := s.backend.StatContent(id)
+	if err != nil || !exists {
+		return "", err
+	}
+	data, err := s.backend.GetContent(id)
+	if err != nil {
+		return "", err
+	}
+	tenant := string(data)
+	if !tenantPattern.MatchString(tenant) {
+		return "", fmt.Errorf("%w in the pointer of %s: %q", errInvalidTenant, slug, tenant)
+	}
+	s.remember(slug, tenant)
+	return tenant, nil
+}
+
+// key returns the key id is stored under.
+func (s *TenantStore) key(id string) (string, error) {
+	slug, ok := pasteSlug(id)
+	if !ok {
+		return id, nil
+	}
+	if tenant, ok := s.cached(slug); ok {
+		return tenantKey(tenant, id), nil
+	}
+	tenant, err := s.pointer(slug)
+	return tenantKey(tenant, id), err
+}
+
+// find calls fn with the key id may be stored under until fn does not
+// return ErrNotFound: the remembered one, the bare key, and the key the
+// pointer of its slug names.
+func (s *TenantStore) find(id string, fn func(key string) error) error {
+	slug, ok := pasteSlug(id)
+	if !ok {
+		return fn(id)
+	}
+	bare := true
+	if tenant, ok := s.cached(slug); ok {
+		err := fn(tenantKey(tenant, id))
+		if !errors.Is(err, ErrNotFound) || id != slug {
+			return err
+		}
+		// The slug may have been deleted and taken again since.
+		s.forget(slug)
+		bare = tenant != ""
+	}
+	err := ErrNotFound
+	if bare {
+		err = fn(id)
+		if err == nil && id == slug {
+			s.remember(slug, "")
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	tenant, perr := s.pointer(slug)
+	if perr != nil {
+		return perr
+	}
+	if tenant == "" {
+		return err
+	}
+	return fn(tenantKey(tenant, id))
+}
+
+// AssignTenant implements TenantAssigner, writing or removing the pointer
+// of slug as needed. Callers only assign slugs that are free, so what an
+// expired paste of another tenant left under the slug is deleted.
+func (s *TenantStore) AssignTenant(slug, tenant string) error {
+	if !utils.IsValidSlug(slug) {
+		return errUnsafeID
+	}
+	if tenant != "" && !tenantPattern.MatchString(tenant) {
+		return fmt.Errorf("%w: %q", errInvalidTenant, tenant)
+	}
+	current, err := s.pointer(slug)
+	if err != nil {
+		return err
+	}
+	if current != tenant {
+		if current != "" {
+			if err := s.backend.Delete(tenantKey(current, slug)); err != nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+		}
+		if tenant == "" {
+			err = s.backend.Delete(tenantPointerID(slug))
+		} else {
+			err = s.backend.StoreContent(tenantPointerID(slug), []byte(tenant))
+		}
+		if err != nil {
+			s.forget(slug)
+			return err
+		}
+	}
+	s.remember(slug, tenant)
+	return nil
+}
+
+// Store implements PasteStore.
+func (s *TenantStore) Store(paste *models.Paste) error {
+	key, err := s.key(paste.ID)
+	if err != nil {
+		return err
+	}
+	id := paste.ID
+	paste.ID = key
+	defer func() { paste.ID = id }()
+	return s.backend.Store(paste)
+}
+
+// Get implements PasteStore.
+func (s *TenantStore) Get(id string) (*models.Paste, error) {
+	var paste *models.Paste
+	err := s.find(id, func(key string) error {
+		var err error
+		paste, err = s.backend.Get(key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	paste.ID = id
+	return paste, nil
+}
+
+// GetBatch implements BatchGetter. Slugs that are not found under the key
+// tried first are read one at a time.
+func (s *TenantStore) GetBatch(ids []string) map[string]BatchResult {
+	byKey := make(map[string]string, len(ids))
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		key := id
+		if slug, ok := pasteSlug(id); ok {
+			if tenant, ok := s.cached(slug); ok {
+				key = tenantKey(tenant, id)
+			}
+		}
+		if _, dup := byKey[key]; !dup {
+			byKey[key] = id
+			keys = append(keys, key)
+		}
+	}
+	results := make(map[string]BatchResult, len(ids))
+	for key, r := range GetBatch(s.backend, keys) {
+		id := byKey[key]
+		switch {
+		case errors.Is(r.Err, ErrNotFound):
+			r = batchResult(s.Get(id))
+		case r.Err == nil:
+			r.Paste.ID = id
+			if key == id {
+				if slug, ok := pasteSlug(id); ok && slug == id {
+					s.remember(slug, "")
+				}
+			}
+		}
+		results[id] = r
+	}
+	return results
+}
+
+// Exists implements PasteStore.
+func (s *TenantStore) Exists(id string) (bool, error) {
+	err := s.find(id, func(key string) error {
+		exists, err := s.backend.Exists(key)
+		if err == nil && !exists {
+			return ErrNotFound
+		}
+		return err
+	})
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Delete implements PasteStore, removing the pointer of a deleted paste.
+func (s *TenantStore) Delete(id string) error {
+	return s.DeleteBatch([]string{id}, nil)[id]
+}
+
+// DeleteBatch implements BatchDeleter, removing the pointers of the
+// deleted pastes.
+func (s *TenantStore) DeleteBatch(ids []string, progress DeleteProgress) map[string]error {
+	errs := map[string]error{}
+	byKey := make(map[string]string, len(ids))
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		key, err := s.key(id)
+		if err != nil {
+			errs[id] = err
+			continue
+		}
+		byKey[key] = id
+		keys = append(keys, key)
+	}
+	failed := DeleteBatch(s.backend, keys, progress)
+	var pointers []string
+	for _, key := range keys {
+		id := byKey[key]
+		if err, ok := failed[key]; ok {
+			errs[id] = err
+			continue
+		}
+		if slug, ok := pasteSlug(id); ok && slug == id {
+			s.forget(slug)
+			if key != id {
+				pointers = append(pointers, tenantPointerID(slug))
+			}
+		}
+	}
+	if len(pointers) > 0 {
+		for id, err := range DeleteBatch(s.backend, pointers, nil) {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			log.Printf("[WARN] Tenants: failed to remove %s: %v", id, err)
+		}
+	}
+	return errs
+}
+
+// IncrementReadCount implements PasteStore.
+func (s *TenantStore) IncrementReadCount(id string) error {
+	key, err := s.key(id)
+	if err != nil {
+		return err
+	}
+	return s.backend.IncrementReadCount(key)
+}
+
+// IncrementReads implements ReadCounter.
+func (s *TenantStore) IncrementReads(id string, kind models.ReadKind) error {
+	key, err := s.key(id)
+	if err != nil {
+		return err
+	}
+	return IncrementReads(s.backend, key, kind)
+}
+
+// Close implements PasteStore.
+func (s *TenantStore) Close() error {
+	return s.backend.Close()
+}
+
+// StoreContent implements PasteStore.
+func (s *TenantStore) StoreContent(id string, content []byte) error {
+	key, err := s.key(id)
+	if err != nil {
+		return err
+	}
+	return s.backend.StoreContent(key, content)
+}
+
+// CreateContent implements ContentCreator.
+func (s *TenantStore) CreateContent(id string, content []byte) (bool, error) {
+	key, err := s.key(id)
+	if err != nil {
+		return false, err
+	}
+	return CreateContent(s.backend, key, content)
+}
+
+// GetContent implements PasteStore.
+func (s *TenantStore) GetContent(id string) ([]byte, error) {
+	key, err := s.key(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.backend.GetContent(key)
+}
+
+// OpenContent implements ContentOpener.
+func (s *TenantStore) OpenContent(id string) (io.ReadCloser, error) {
+	key, err := s.key(id)
+	if err != nil {
+		return nil, err
+	}
+	return OpenContent(s.backend, key)
+}
+
+// GetContentPrefix implements PasteStore.
+func (s *TenantStore) GetContentPrefix(id string, n int64) ([]byte, error) {
+	key, err := s.key(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.backend.GetContentPrefix(key, n)
+}
+
+// StatContent implements PasteStore.
+func (s *TenantStore) StatContent(id string) (bool, int64, error) {
+	key, err := s.key(id)
+	if err != nil {
+		return false, 0, err
+	}
+	return s.backend.StatContent(key)
+}
+
+// PresignContentPut implements ContentPresigner by delegating to the
+// first store below that implements it, under the key of id. id must be
+// assigned its tenant first.
+func (s *TenantStore) PresignContentPut(id string, size int64, contentType string, validity time.Duration) (string, http.Header, error) {
+	p, ok := Find[interface {
+		PasteStore
+		ContentPresigner
+	}](s.backend)
+	if !ok {
+		return "", nil, errUnsupported
+	}
+	key, err := s.key(id)
+	if err != nil {
+		return "", nil, err
+	}
+	return p.PresignContentPut(key, size, contentType, validity)
+}
+
+// SetOnExpire implements ExpiryHook, registering fn with the first store
+// below that implements it and calling it with slugs.
+func (s *TenantStore) SetOnExpire(fn func(id string)) {
+	for store := s.backend; store != nil; {
+		if h, ok := store.(ExpiryHook); ok {
+			h.SetOnExpire(func(key string) {
+				_, id := splitTenantKey(key)
+				s.forget(id)
+				fn(id)
+			})
+			return
+		}
+		d, ok := store.(interface{ Backend() PasteStore })
+		if !ok {
+			return
+		}
+		store = d.Backend()
+	}
+}
+
+// List implements Lister by delegating to the backend, listing slugs.
+// The backend lists the pastes of each tenant after those of the default
+// tenant, so the pages are in ascending order within each tenant only.
+func (s *TenantStore) List(opts ListOptions) (ListPage, error) {
+	l, ok := s.backend.(Lister)
+	if !ok {
+		return ListPage{}, errUnsupported
+	}
+	if opts.Cursor != "" {
+		cursor, err := s.key(opts.Cursor)
+		if err != nil {
+			return ListPage{}, err
+		}
+		opts.Cursor = cursor
+	}
+	page, err := l.List(opts)
+	for i, key := range page.IDs {
+		tenant, id := splitTenantKey(key)
+		s.remember(id, tenant)
+		page.IDs[i] = id
+	}
+	_, page.NextCursor = splitTenantKey(page.NextCursor)
+	return page, err
+}
+
+// ListObjects implements ObjectLister by delegating to the backend, naming
+// the objects of tenants as those of the default tenant are named. The
+// pointers are not listed.
+func (s *TenantStore) ListObjects(fn func(Object) error) error {
+	l, ok := s.backend.(ObjectLister)
+	if !ok {
+		return errUnsupported
+	}
+	var objects []Object
+	err := l.ListObjects(func(obj Object) error {
+		if strings.HasPrefix(obj.Name, ".") {
+			return nil
+		}
+		tenant, name := splitTenantKey(obj.Name)
+		if slug, ok := pasteSlug(name); ok && tenant != "" {
+			s.remember(slug, tenant)
+		}
+		obj.Name = name
+		objects = append(objects, obj)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	for _, obj := range objects {
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateTenants moves the pastes of tenants that are stored under their
+// bare keys to the prefix of their tenant, writing their pointers. With
+// dryRun set it only counts them. Pastes that fail are logged, counted
+// and left where they were; moving them again is safe.
+func (s *TenantStore) MigrateTenants(dryRun bool) (MigrateStats, error) {
+	var stats MigrateStats
+	l, ok := s.backend.(Lister)
+	if !ok {
+		return stats, errUnsupported
+	}
+	cursor := ""
+	for {
+		page, err := l.List(ListOptions{Cursor: cursor, Limit: 1000})
+		if err != nil {
+			return stats, err
+		}
+		for _, key := range page.IDs {
+			if tenant, _ := splitTenantKey(key); tenant != "" {
+				continue
+			}
+			stats.Scanned++
+			paste, err := s.backend.Get(key)
+			if errors.Is(err, ErrNotFound) || err == nil && paste.Tenant == "" {
+				continue
+			}
+			if err == nil && !dryRun {
+				paste.ID = key
+				err = s.move(paste)
+			}
+			if err != nil {
+				log.Printf("[ERROR] Migrate: failed to move %s to its tenant: %v", key, err)
+				stats.Failed++
+				continue
+			}
+			stats.Upgraded++
+		}
+		if page.NextCursor == "" {
+			return stats, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// move copies paste, stored under its bare key, to the prefix of its
+// tenant and then deletes the bare copy. Cached previews are not copied;
+// they are made again when needed.
+func (s *TenantStore) move(paste *models.Paste) error {
+	slug, tenant := paste.ID, paste.Tenant
+	if err := s.AssignTenant(slug, tenant); err != nil {
+		return err
+	}
+	ids := []string{slug}
+	for _, v := range paste.Versions {
+		ids = append(ids, VersionID(slug, v.Number))
+	}
+	for _, id := range ids {
+		exists, _, err := s.backend.StatContent(id)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		content, err := s.backend.GetContent(id)
+		if err != nil {
+			return err
+		}
+		if err := s.backend.StoreContent(tenantKey(tenant, id), content); err != nil {
+			return err
+		}
+	}
+	moved := *paste
+	moved.ID = tenantKey(tenant, slug)
+	if err := s.backend.Store(&moved); err != nil {
+		return err
+	}
+	return s.backend.Delete(slug)
+}
+
+// StoreCollection implements CollectionStore by delegating to the backend.
+func (s *TenantStore) StoreCollection(col *models.Collection) error {
+	cs, ok := s.backend.(CollectionStore)
+	if !ok {
+		return errUnsupported
+	}
+	return cs.StoreCollection(col)
+}
+
+// GetCollection implements CollectionStore by delegating to the backend.
+func (s *TenantStore) GetCollection(id string) (*models.Collection, error) {
+	cs, ok := s.backend.(CollectionStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return cs.GetCollection(id)
+}
+
+// DeleteCollection implements CollectionStore by delegating to the backend.
+func (s *TenantStore) DeleteCollection(id string) error {
+	cs, ok := s.backend.(CollectionStore)
+	if !ok {
+		return errUnsupported
+	}
+	return cs.DeleteCollection(id)
+}
+
+// StoreToken implements TokenStore by delegating to the backend.
+func (s *TenantStore) StoreToken(t *models.ShareToken) error {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return errUnsupported
+	}
+	return ts.StoreToken(t)
+}
+
+// GetToken implements TokenStore by delegating to the backend.
+func (s *TenantStore) GetToken(token string) (*models.ShareToken, error) {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return ts.GetToken(token)
+}
+
+// DeleteToken implements TokenStore by delegating to the backend.
+func (s *TenantStore) DeleteToken(t *models.ShareToken) error {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return errUnsupported
+	}
+	return ts.DeleteToken(t)
+}
+
+// ListTokens implements TokenStore by delegating to the backend.
+func (s *TenantStore) ListTokens(slug string) ([]*models.ShareToken, error) {
+	ts, ok := s.backend.(TokenStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return ts.ListTokens(slug)
+}
+
+// GetCert implements CertStore by delegating to the backend.
+func (s *TenantStore) GetCert(name string) ([]byte, error) {
+	cs, ok := s.backend.(CertStore)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return cs.GetCert(name)
+}
+
+// PutCert implements CertStore by delegating to the backend.
+func (s *TenantStore) PutCert(name string, data []byte) error {
+	cs, ok := s.backend.(CertStore)
+	if !ok {
+		return errUnsupported
+	}
+	return cs.PutCert(name, data)
+}
+
+// DeleteCert implements CertStore by delegating to the backend.
+func (s *TenantStore) DeleteCert(name string) error {
+	cs, ok := s.backend.(CertStore)
+	if !ok {
+		return errUnsupported
+	}
+	return cs.DeleteCert(name)
+}
@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/johnwmail/nclip/models"
+)
+
+func tenantPaste(id, tenant string) *models.Paste {
+	expires := time.Now().Add(time.Hour)
+	return &models.Paste{ID: id, Tenant: tenant, CreatedAt: time.Now(), ExpiresAt: &expires, Size: 2}
+}
+
+func TestTenantStore(t *testing.T) {
+	backend := NewMemoryStore()
+	store := NewTenantStore(backend)
+	for _, p := range []*models.Paste{tenantPaste("TNNTA", "eng"), tenantPaste("DFLTA", "")} {
+		id := p.ID
+		if err := store.AssignTenant(p.ID, p.Tenant); err != nil {
+			t.Fatalf("AssignTenant(%s): %v", p.ID, err)
+		}
+		if err := store.StoreContent(p.ID, []byte("hi")); err != nil {
+			t.Fatalf("StoreContent(%s): %v", p.ID, err)
+		}
+		if err := store.Store(p); err != nil {
+			t.Fatalf("Store(%s): %v", p.ID, err)
+		}
+		if p.ID != id {
+			t.Fatalf("Store changed the caller's paste ID to %q", p.ID)
+		}
+	}
+
+	// The tenant's paste is stored under its prefix, the default tenant's
+	// under its slug.
+	for _, key := range []string{"eng~TNNTA", "DFLTA"} {
+		if _, err := backend.Get(key); err != nil {
+			t.Errorf("backend.Get(%s): %v", key, err)
+		}
+		if ok, _, _ := backend.StatContent(key); !ok {
+			t.Errorf("backend has no content under %s", key)
+		}
+	}
+	if _, err := backend.Get("TNNTA"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("backend.Get(TNNTA) = %v; want ErrNotFound", err)
+	}
+	if ok, _, _ := backend.StatContent(tenantPointerID("DFLTA")); ok {
+		t.Error("a paste of the default tenant got a pointer")
+	}
+
+	// Another instance finds the paste by slug alone.
+	other := NewTenantStore(backend)
+	paste, err := other.Get("TNNTA")
+	if err != nil || paste.ID != "TNNTA" || paste.Tenant != "eng" {
+		t.Fatalf("Get(TNNTA) = %+v, %v", paste, err)
+	}
+	if content, err := NewTenantStore(backend).GetContent("TNNTA"); err != nil || string(content) != "hi" {
+		t.Errorf("GetContent(TNNTA) from a cold cache = %q, %v", content, err)
+	}
+	if ok, err := NewTenantStore(backend).Exists("TNNTA"); err != nil || !ok {
+		t.Errorf("Exists(TNNTA) = %v, %v", ok, err)
+	}
+	results := NewTenantStore(backend).GetBatch([]string{"TNNTA", "DFLTA", "MSSNG"})
+	if r := results["TNNTA"]; r.Err != nil || r.Paste.ID != "TNNTA" {
+		t.Errorf("GetBatch TNNTA = %+v", r)
+	}
+	if r := results["MSSNG"]; !errors.Is(r.Err, ErrNotFound) {
+		t.Errorf("GetBatch MSSNG = %+v; want ErrNotFound", r)
+	}
+	page, err := NewTenantStore(backend).List(ListOptions{})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	slices.Sort(page.IDs)
+	if want := []string{"DFLTA", "TNNTA"}; !reflect.DeepEqual(page.IDs, want) {
+		t.Errorf("List = %v; want %v", page.IDs, want)
+	}
+	var names []string
+	if err := other.ListObjects(func(obj Object) error {
+		names = append(names, obj.Name)
+		return nil
+	}); err != nil {
+		t.Fatalf("ListObjects: %v", err)
+	}
+	if want := []string{"DFLTA", "DFLTA.json", "TNNTA", "TNNTA.json"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ListObjects = %v; want %v", names, want)
+	}
+
+	if err := other.Delete("TNNTA"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if ok, _, _ := backend.StatContent("eng~TNNTA"); ok {
+		t.Error("Delete left the content behind")
+	}
+	if ok, _, _ := backend.StatContent(tenantPointerID("TNNTA")); ok {
+		t.Error("Delete left the pointer behind")
+	}
+	if _, err := store.Get("TNNTA"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v; want ErrNotFound", err)
+	}
+}
+
+func TestTenantStore_Reassign(t *testing.T) {
+	backend := NewMemoryStore()
+	store := NewTenantStore(backend)
+	if err := store.AssignTenant("RSSGN", "eng"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.StoreContent("RSSGN", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Store(tenantPaste("RSSGN", "eng")); err != nil {
+		t.Fatal(err)
+	}
+	// The paste expired and another instance hands the slug to the
+	// default tenant.
+	other := NewTenantStore(backend)
+	if err := other.AssignTenant("RSSGN", ""); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, _ := backend.StatContent("eng~RSSGN"); ok {
+		t.Error("the expired paste of the previous tenant was left behind")
+	}
+	if ok, _, _ := backend.StatContent(tenantPointerID("RSSGN")); ok {
+		t.Error("the pointer to the previous tenant was left behind")
+	}
+	if err := other.StoreContent("RSSGN", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := backend.GetContent("RSSGN"); err != nil || string(content) != "new" {
+		t.Errorf("backend content under the bare key = %q, %v", content, err)
+	}
+	if err := store.AssignTenant("BDTNT", "Not A Tenant"); !errors.Is(err, errInvalidTenant) {
+		t.Errorf("AssignTenant with an invalid tenant = %v", err)
+	}
+}
+
+func TestTenantStore_OnExpire(t *testing.T) {
+	backend := NewMemoryStore()
+	store := NewTenantStore(backend)
+	var expired []string
+	store.SetOnExpire(func(id string) { expired = append(expired, id) })
+	p := tenantPaste("XPRD", "eng")
+	past := time.Now().Add(-time.Minute)
+	p.ExpiresAt = &past
+	if err := store.AssignTenant(p.ID, p.Tenant); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Store(p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("XPRD"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get(expired) = %v; want ErrNotFound", err)
+	}
+	if want := []string{"XPRD"}; !reflect.DeepEqual(expired, want) {
+		t.Errorf("expired = %v; want %v", expired, want)
+	}
+}
+
+func TestTenantStore_Migrate(t *testing.T) {
+	backend := NewMemoryStore()
+	// Pastes stored before tenants had prefixes.
+	old := tenantPaste("MGRTA", "eng")
+	old.Versions = []models.PasteVersion{{Number: 1, Size: 2}}
+	for _, p := range []*models.Paste{old, tenantPaste("MGRTB", "")} {
+		if err := backend.StoreContent(p.ID, []byte("hi")); err != nil {
+			t.Fatal(err)
+		}
+		if err := backend.Store(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := backend.StoreContent(VersionID("MGRTA", 1), []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	store := NewTenantStore(backend)
+	// They keep working before the migration.
+	if content, err := store.GetContent("MGRTA"); err != nil || string(content) != "hi" {
+		t.Fatalf("GetContent before the migration = %q, %v", content, err)
+	}
+
+	stats, err := store.MigrateTenants(true)
+	if err != nil || stats != (MigrateStats{Scanned: 2, Upgraded: 1}) {
+		t.Fatalf("dry run = %+v, %v", stats, err)
+	}
+	if _, err := backend.Get("MGRTA"); err != nil {
+		t.Fatalf("the dry run moved the paste: %v", err)
+	}
+	stats, err = store.MigrateTenants(false)
+	if err != nil || stats != (MigrateStats{Scanned: 2, Upgraded: 1}) {
+		t.Fatalf("MigrateTenants = %+v, %v", stats, err)
+	}
+	if _, err := backend.Get("MGRTA"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("the bare copy was kept: %v", err)
+	}
+	if content, err := backend.GetContent("eng~" + VersionID("MGRTA", 1)); err != nil || string(content) != "v1" {
+		t.Errorf("version under the prefix = %q, %v", content, err)
+	}
+	if _, err := backend.Get("MGRTB"); err != nil {
+		t.Errorf("the paste of the default tenant was moved: %v", err)
+	}
+	other := NewTenantStore(backend)
+	if paste, err := other.Get("MGRTA"); err != nil || paste.ID != "MGRTA" {
+		t.Errorf("Get after the migration = %+v, %v", paste, err)
+	}
+	if content, err := other.GetContent("MGRTA"); err != nil || string(content) != "hi" {
+		t.Errorf("GetContent after the migration = %q, %v", content, err)
+	}
+	stats, err = store.MigrateTenants(false)
+	if err != nil || stats != (MigrateStats{Scanned: 1}) {
+		t.Errorf("second run = %+v, %v", stats, err)
+	}
+}
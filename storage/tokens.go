@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/johnwmail/nclip/models"
+)
+
+// tokenDir is the directory (filesystem) or key prefix (S3) holding one
+// "<token>.json" object per share token, and the per-slug index: a
+// "<slug>/<token>" marker for each token of a paste. Tokens and slugs use
+// different alphabets, so the two never collide.
+const tokenDir = ".tokens"
+
+// errInvalidToken is returned when a share token is malformed.
+var errInvalidToken = errors.New("invalid share token")
+
+// tokenPattern matches share tokens: 160 random bits in lowercase base32.
+var tokenPattern = regexp.MustCompile(`^[a-z2-7]{32}$`)
+
+// TokenStore is implemented by stores that can save share tokens.
+// GetToken returns ErrNotFound for unknown tokens; ListTokens returns the
+// tokens of one paste.
+type TokenStore interface {
+	StoreToken(t *models.ShareToken) error
+	GetToken(token string) (*models.ShareToken, error)
+	DeleteToken(t *models.ShareToken) error
+	ListTokens(slug string) ([]*models.ShareToken, error)
+}
+
+// ValidToken reports whether token is well-formed.
+func ValidToken(token string) bool {
+	return tokenPattern.MatchString(token)
+}
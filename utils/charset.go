@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// Charsets that text uploads are transcoded from. UTF-16 is only
+// recognized by its byte order mark, which the original keeps.
+const (
+	CharsetUTF16LE     = "utf-16le"
+	CharsetUTF16BE     = "utf-16be"
+	CharsetWindows1252 = "windows-1252"
+	CharsetLatin1      = "iso-8859-1"
+)
+
+var charsets = map[string]encoding.Encoding{
+	CharsetUTF16LE:     unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM),
+	CharsetUTF16BE:     unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM),
+	CharsetWindows1252: charmap.Windows1252,
+	CharsetLatin1:      charmap.ISO8859_1,
+}
+
+// DetectCharset returns the charset of text content that is not UTF-8:
+// UTF-16 with a byte order mark, or else a single-byte Windows-1252 or
+// ISO-8859-1 text, as Windows terminals produce. It returns "" for UTF-8
+// and for content that does not look like text.
+func DetectCharset(content []byte) string {
+	switch {
+	case bytes.HasPrefix(content, []byte{0xFF, 0xFE}):
+		return CharsetUTF16LE
+	case bytes.HasPrefix(content, []byte{0xFE, 0xFF}):
+		return CharsetUTF16BE
+	case utf8.Valid(content):
+		return ""
+	}
+	c1 := false
+	for _, b := range content {
+		switch {
+		case b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != 0x1B, b == 0x7F:
+			// Control characters other than whitespace and ANSI escapes
+			// mean binary content.
+			return ""
+		case b >= 0x80 && b <= 0x9F:
+			c1 = true
+		}
+	}
+	// ISO-8859-1 uses 0x80-0x9F only for control characters, which
+	// Windows-1252 replaced with punctuation such as curly quotes.
+	if c1 {
+		return CharsetWindows1252
+	}
+	return CharsetLatin1
+}
+
+// ToUTF8 transcodes text content to UTF-8, returning it with its content
+// type's charset set to utf-8 and the detected original charset. Content
+// that is already UTF-8, is not text, or would not convert back to the
+// same bytes is returned unchanged with an empty charset.
+func ToUTF8(content []byte, contentType string) ([]byte, string, string) {
+	if !IsTextContent(contentType) {
+		return content, contentType, ""
+	}
+	charset := DetectCharset(content)
+	if charset == "" {
+		return content, contentType, ""
+	}
+	decoded, err := charsets[charset].NewDecoder().Bytes(content)
+	if err != nil {
+		return content, contentType, ""
+	}
+	if back, err := FromUTF8(decoded, charset); err != nil || !bytes.Equal(back, content) {
+		return content, contentType, ""
+	}
+	return decoded, WithCharset(contentType, "utf-8"), charset
+}
+
+// FromUTF8 converts content transcoded by ToUTF8 back to its original
+// charset.
+func FromUTF8(content []byte, charset string) ([]byte, error) {
+	enc, ok := charsets[charset]
+	if !ok {
+		return nil, fmt.Errorf("unknown charset %q", charset)
+	}
+	return enc.NewEncoder().Bytes(content)
+}
+
+// WithCharset returns contentType with its charset parameter set to
+// charset. Only text types and types that already name a charset get one.
+func WithCharset(contentType, charset string) string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	if _, ok := params["charset"]; !ok && !strings.HasPrefix(mediaType, "text/") {
+		return contentType
+	}
+	params["charset"] = charset
+	return mime.FormatMediaType(mediaType, params)
+}
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestToUTF8(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     []byte
+		contentType string
+		want        string
+		wantType    string
+		wantCharset string
+	}{
+		{
+			name:        "utf-8 unchanged",
+			content:     []byte("héllo"),
+			contentType: "text/plain; charset=utf-8",
+			want:        "héllo",
+			wantType:    "text/plain; charset=utf-8",
+		},
+		{
+			name:        "utf-16le with bom",
+			content:     []byte{0xFF, 0xFE, 'h', 0, 0xE9, 0, 'y', 0, '\r', 0, '\n', 0},
+			contentType: "text/plain; charset=utf-16le",
+			want:        "héy\r\n",
+			wantType:    "text/plain; charset=utf-8",
+			wantCharset: CharsetUTF16LE,
+		},
+		{
+			name:        "utf-16be with bom",
+			content:     []byte{0xFE, 0xFF, 0, 'o', 0, 'k'},
+			contentType: "text/plain; charset=utf-16be",
+			want:        "ok",
+			wantType:    "text/plain; charset=utf-8",
+			wantCharset: CharsetUTF16BE,
+		},
+		{
+			name:        "latin-1",
+			content:     []byte("caf\xe9 cr\xe8me"),
+			contentType: "text/plain; charset=utf-8",
+			want:        "café crème",
+			wantType:    "text/plain; charset=utf-8",
+			wantCharset: CharsetLatin1,
+		},
+		{
+			name:        "windows-1252 punctuation",
+			content:     []byte("\x93quoted\x94 \x80 5"),
+			contentType: "text/x-go",
+			want:        "“quoted” € 5",
+			wantType:    "text/x-go; charset=utf-8",
+			wantCharset: CharsetWindows1252,
+		},
+		{
+			name:        "json keeps its type",
+			content:     []byte("{\"name\":\"Jos\xe9\"}"),
+			contentType: "application/json",
+			want:        `{"name":"José"}`,
+			wantType:    "application/json",
+			wantCharset: CharsetLatin1,
+		},
+		{
+			name:        "binary content",
+			content:     []byte("\x00\x01\xff\xfe"),
+			contentType: "text/plain",
+			want:        "\x00\x01\xff\xfe",
+			wantType:    "text/plain",
+		},
+		{
+			name:        "not text",
+			content:     []byte("caf\xe9"),
+			contentType: "application/octet-stream",
+			want:        "caf\xe9",
+			wantType:    "application/octet-stream",
+		},
+		{
+			name:        "unpaired surrogate",
+			content:     []byte{0xFF, 0xFE, 0x00, 0xD8, 'a', 0},
+			contentType: "text/plain; charset=utf-16le",
+			want:        string([]byte{0xFF, 0xFE, 0x00, 0xD8, 'a', 0}),
+			wantType:    "text/plain; charset=utf-16le",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotType, charset := ToUTF8(tt.content, tt.contentType)
+			if string(got) != tt.want || gotType != tt.wantType || charset != tt.wantCharset {
+				t.Fatalf("ToUTF8() = %q, %q, %q; want %q, %q, %q", got, gotType, charset, tt.want, tt.wantType, tt.wantCharset)
+			}
+			if charset == "" {
+				return
+			}
+			original, err := FromUTF8(got, charset)
+			if err != nil || !bytes.Equal(original, tt.content) {
+				t.Errorf("FromUTF8() = %q, %v; want the original bytes %q", original, err, tt.content)
+			}
+		})
+	}
+}
+
+func TestWithCharset(t *testing.T) {
+	tests := []struct{ contentType, want string }{
+		{"text/plain", "text/plain; charset=utf-16le"},
+		{"text/plain; charset=utf-8", "text/plain; charset=utf-16le"},
+		{"application/json", "application/json"},
+		{"application/xml; charset=utf-8", "application/xml; charset=utf-16le"},
+		{"not a type", "not a type"},
+	}
+	for _, tt := range tests {
+		if got := WithCharset(tt.contentType, CharsetUTF16LE); got != tt.want {
+			t.Errorf("WithCharset(%q) = %q, want %q", tt.contentType, got, tt.want)
+		}
+	}
+	if _, err := FromUTF8([]byte("x"), "ebcdic"); err == nil {
+		t.Error("FromUTF8 accepted an unknown charset")
+	}
+}
@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Checksum returns the lowercase hex SHA-256 of content, the form pastes
+// record it in and /sha256/ URLs take.
+func Checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// IsValidChecksum reports whether sum is a lowercase hex SHA-256.
+func IsValidChecksum(sum string) bool {
+	if len(sum) != 2*sha256.Size {
+		return false
+	}
+	for i := 0; i < len(sum); i++ {
+		ch := sum[i]
+		if (ch < '0' || ch > '9') && (ch < 'a' || ch > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	sum := Checksum([]byte("hello"))
+	if sum != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("Checksum(hello) = %s", sum)
+	}
+	for _, tc := range []struct {
+		sum  string
+		want bool
+	}{
+		{sum, true},
+		{strings.ToUpper(sum), false},
+		{sum[:63], false},
+		{sum[:63] + "g", false},
+		{"", false},
+	} {
+		if got := IsValidChecksum(tc.sum); got != tc.want {
+			t.Errorf("IsValidChecksum(%q) = %v, want %v", tc.sum, got, tc.want)
+		}
+	}
+}
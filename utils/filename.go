@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxFilenameLength is the maximum length in bytes of a sanitized filename.
+const MaxFilenameLength = 255
+
+// maxExtensionLength is the longest extension kept when a filename is
+// shortened; a longer one is shortened with the rest of the name.
+const maxExtensionLength = 16
+
+// SanitizeFilename makes a client-supplied filename safe to store and to
+// send in a Content-Disposition header. Directory components, control and
+// formatting characters (such as right-to-left overrides that disguise an
+// extension), quotes and backslashes are removed, runs of whitespace become
+// one space, and leading/trailing dots and spaces are trimmed. Names longer
+// than MaxFilenameLength bytes are shortened on a rune boundary, keeping
+// the extension. It returns "" when nothing usable remains.
+func SanitizeFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == utf8.RuneError, r == '"', r == '/', unicode.IsControl(r), unicode.Is(unicode.Cf, r):
+			return -1
+		case unicode.IsSpace(r):
+			return ' '
+		}
+		return r
+	}, name)
+	name = strings.Join(strings.Fields(name), " ")
+	name = strings.Trim(name, " .")
+	if len(name) > MaxFilenameLength {
+		ext := path.Ext(name)
+		if len(ext) > maxExtensionLength || len(ext) == len(name) {
+			ext = ""
+		}
+		stem := name[:len(name)-len(ext)]
+		for len(stem)+len(ext) > MaxFilenameLength {
+			_, size := utf8.DecodeLastRuneInString(stem)
+			stem = stem[:len(stem)-size]
+		}
+		name = strings.TrimRight(stem, " .") + ext
+	}
+	return strings.Trim(name, " .")
+}
+
+// ContentDisposition formats an inline or attachment Content-Disposition
+// value (RFC 6266). The filename is sanitized first. The plain filename
+// parameter is an ASCII fallback for old clients, with other characters
+// replaced by '_'; filename* (RFC 5987) carries the exact UTF-8 name and is
+// only added when the two differ.
+func ContentDisposition(filename string, attachment bool) string {
+	disposition := "inline"
+	if attachment {
+		disposition = "attachment"
+	}
+	filename = SanitizeFilename(filename)
+	if filename == "" {
+		return disposition
+	}
+	fallback := asciiFilename(filename)
+	value := disposition + `; filename="` + fallback + `"`
+	if fallback != filename {
+		value += "; filename*=UTF-8''" + encodeRFC5987(filename)
+	}
+	return value
+}
+
+// asciiFilename replaces the characters of a sanitized filename that are
+// not printable ASCII, or need escaping in a quoted string, with '_'.
+func asciiFilename(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' || r == '%' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// encodeRFC5987 percent-encodes every byte of s outside the RFC 5987
+// attr-char set.
+func encodeRFC5987(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isAttrChar(c) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0x0f])
+	}
+	return b.String()
+}
+
+func isAttrChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	cases := map[string]string{
+		"report.pdf":            "report.pdf",
+		"../../etc/passwd":      "passwd",
+		`C:\Users\me\notes.txt`: "notes.txt",
+		"a\"b\r\nc.txt":         "abc.txt",
+		"  .hidden.  ":          "hidden",
+		"résumé.txt":            "résumé.txt",
+		"invoice\u202Efdp.exe":  "invoicefdp.exe",
+		"my \t  notes.txt":      "my notes.txt",
+		"..":                    "",
+		"/":                     "",
+		"":                      "",
+	}
+	for in, want := range cases {
+		if got := SanitizeFilename(in); got != want {
+			t.Errorf("SanitizeFilename(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	long := SanitizeFilename(strings.Repeat("é", 200))
+	if len(long) > MaxFilenameLength || !strings.HasPrefix(long, "é") {
+		t.Errorf("expected truncation to at most %d bytes on a rune boundary, got %d bytes", MaxFilenameLength, len(long))
+	}
+}
+
+func TestSanitizeFilename_KeepsExtension(t *testing.T) {
+	got := SanitizeFilename(strings.Repeat("a", 300) + ".tar.gz")
+	if len(got) != MaxFilenameLength || !strings.HasSuffix(got, "a.gz") {
+		t.Errorf("expected %d bytes ending in the extension, got %d bytes %q", MaxFilenameLength, len(got), got[len(got)-8:])
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	cases := []struct {
+		name       string
+		attachment bool
+		want       string
+	}{
+		{"report.pdf", true, `attachment; filename="report.pdf"`},
+		{"notes.txt", false, `inline; filename="notes.txt"`},
+		{"résumé.txt", true, `attachment; filename="r_sum_.txt"; filename*=UTF-8''r%C3%A9sum%C3%A9.txt`},
+		{"日本語.txt", true, `attachment; filename="___.txt"; filename*=UTF-8''%E6%97%A5%E6%9C%AC%E8%AA%9E.txt`},
+		{"a;b,c 100%.txt", true, `attachment; filename="a;b,c 100_.txt"; filename*=UTF-8''a%3Bb%2Cc%20100%25.txt`},
+		{"x\"\r\ny.txt", true, `attachment; filename="xy.txt"`},
+		{"..", true, `attachment`},
+	}
+	for _, tc := range cases {
+		if got := ContentDisposition(tc.name, tc.attachment); got != tc.want {
+			t.Errorf("ContentDisposition(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
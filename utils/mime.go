@@ -48,6 +48,9 @@ var extensionMap = map[string]string{
 	"text/x-sh":       ".sh",
 	"text/x-yaml":     ".yaml",
 	"text/x-toml":     ".toml",
+	"text/csv":        ".csv",
+
+	"text/tab-separated-values": ".tsv",
 
 	// Application types
 	"application/json":          ".json",
@@ -59,6 +62,7 @@ var extensionMap = map[string]string{
 	"application/javascript":    ".js",
 	"application/x-sh":          ".sh",
 	"application/x-python-code": ".py",
+	"application/geo+json":      ".geojson",
 
 	// Binary and generic
 	"application/octet-stream": ".bin",
@@ -66,6 +70,14 @@ var extensionMap = map[string]string{
 	"application/bin":          ".bin",
 }
 
+// typeByExtension holds extensions the web UI renders specially but which
+// Go's built-in table only knows if the system has a mime.types file.
+var typeByExtension = map[string]string{
+	".csv":     "text/csv",
+	".tsv":     "text/tab-separated-values",
+	".geojson": "application/geo+json",
+}
+
 func extensionByMimeMap(mimeType string) string {
 	ext, ok := extensionMap[strings.ToLower(mimeType)]
 	if ok {
@@ -80,6 +92,9 @@ func DetectContentType(filename string, content []byte) string {
 	// Try to detect from filename extension first
 	if filename != "" {
 		ext := strings.ToLower(filepath.Ext(filename))
+		if mimeType, ok := typeByExtension[ext]; ok {
+			return mimeType
+		}
 		if mimeType := mime.TypeByExtension(ext); mimeType != "" {
 			return mimeType
 		}
@@ -126,6 +141,7 @@ func IsTextContent(contentType string) bool {
 	textTypes := []string{
 		"text/",
 		"application/json",
+		"application/geo+json",
 		"application/xml",
 		"application/javascript",
 		"application/x-sh",
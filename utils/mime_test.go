@@ -29,6 +29,18 @@ func TestDetectContentType(t *testing.T) {
 			content:  []byte("PK\x03\x04"), // ZIP header
 			want:     "application/zip",
 		},
+		{
+			name:     "detect from filename - csv",
+			filename: "data.CSV",
+			content:  []byte("a,b\n1,2\n"),
+			want:     "text/csv",
+		},
+		{
+			name:     "detect from filename - geojson",
+			filename: "places.geojson",
+			content:  []byte(`{"type": "FeatureCollection"}`),
+			want:     "application/geo+json",
+		},
 		{
 			name:     "detect from content - html",
 			filename: "",
@@ -86,6 +98,11 @@ func TestIsTextContent(t *testing.T) {
 			contentType: "application/json",
 			want:        true,
 		},
+		{
+			name:        "geojson",
+			contentType: "application/geo+json",
+			want:        true,
+		},
 		{
 			name:        "xml",
 			contentType: "application/xml",
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"strings"
+	"sync"
+)
+
+// DefaultReservedSlugs are never accepted as custom slugs, in addition to
+// the path prefixes of registered routes and NCLIP_RESERVED_SLUGS.
+var DefaultReservedSlugs = []string{
+	"admin", "api", "base64", "burn", "download", "favicon", "health",
+	"json", "login", "logout", "metrics", "raw", "static", "www",
+}
+
+// ReservedSlugs is a case-insensitive set of words that cannot be used as
+// custom slugs. It is safe for concurrent use; a nil set reserves nothing.
+type ReservedSlugs struct {
+	mu  sync.RWMutex
+	set map[string]struct{}
+}
+
+// NewReservedSlugs creates a set containing words.
+func NewReservedSlugs(words ...string) *ReservedSlugs {
+	r := &ReservedSlugs{set: make(map[string]struct{})}
+	r.Add(words...)
+	return r
+}
+
+// Add reserves words. Surrounding whitespace is ignored, as are empty words.
+func (r *ReservedSlugs) Add(words ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, w := range words {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			r.set[w] = struct{}{}
+		}
+	}
+}
+
+// IsReserved reports whether slug matches a reserved word, ignoring case.
+func (r *ReservedSlugs) IsReserved(slug string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.set[strings.ToLower(slug)]
+	return ok
+}
+
+// Words returns the reserved words in no particular order.
+func (r *ReservedSlugs) Words() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	words := make([]string, 0, len(r.set))
+	for w := range r.set {
+		words = append(words, w)
+	}
+	return words
+}
@@ -0,0 +1,24 @@
+package utils
+
+import "testing"
+
+func TestReservedSlugs(t *testing.T) {
+	r := NewReservedSlugs(DefaultReservedSlugs...)
+	r.Add(" Team ", "", "PASTE")
+
+	for _, slug := range []string{"HEALTH", "health", "RAW", "team", "paste"} {
+		if !r.IsReserved(slug) {
+			t.Errorf("expected %q to be reserved", slug)
+		}
+	}
+	for _, slug := range []string{"ABCDE", "HEALTHY", ""} {
+		if r.IsReserved(slug) {
+			t.Errorf("expected %q not to be reserved", slug)
+		}
+	}
+
+	var none *ReservedSlugs
+	if none.IsReserved("HEALTH") || none.Words() != nil {
+		t.Error("expected nil set to reserve nothing")
+	}
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// MaxTags is the maximum number of tags a paste may carry.
+	MaxTags = 10
+	// MaxTagLength is the maximum length of a single tag.
+	MaxTagLength = 32
+)
+
+// IsValidTag reports whether tag is a normalized label: 1-32 characters of
+// lowercase letters, digits, '-' or '_', starting with a letter or digit.
+func IsValidTag(tag string) bool {
+	if len(tag) == 0 || len(tag) > MaxTagLength {
+		return false
+	}
+	for i := 0; i < len(tag); i++ {
+		ch := tag[i]
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9':
+		case (ch == '-' || ch == '_') && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ParseTags parses a comma-separated X-Tags header value. Tags are trimmed,
+// lowercased and de-duplicated while preserving order; empty entries are
+// ignored. An error is returned for invalid tags or too many tags.
+func ParseTags(header string) ([]string, error) {
+	var tags []string
+	seen := map[string]bool{}
+	for _, raw := range strings.Split(header, ",") {
+		tag := strings.ToLower(strings.TrimSpace(raw))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if !IsValidTag(tag) {
+			return nil, fmt.Errorf("invalid tag %q", tag)
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) > MaxTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", MaxTags)
+	}
+	return tags, nil
+}
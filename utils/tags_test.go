@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"work", []string{"work"}, false},
+		{" Work , logs,work,, ci_2 ", []string{"work", "logs", "ci_2"}, false},
+		{"bad tag", nil, true},
+		{"-lead", nil, true},
+		{"a/b", nil, true},
+		{strings.Repeat("a", MaxTagLength+1), nil, true},
+		{"a,b,c,d,e,f,g,h,i,j,k", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTags(tt.header)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTags(%q) error = %v, wantErr %v", tt.header, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseTags(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}